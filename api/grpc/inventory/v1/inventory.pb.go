@@ -0,0 +1,508 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.6
+// 	protoc        (unknown)
+// source: inventory/v1/inventory.proto
+
+package inventoryv1
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// EventType is the kind of change of an inventory event
+type EventType int32
+
+const (
+	EventType_EVENT_TYPE_UNSPECIFIED EventType = 0
+	// The function was discovered
+	EventType_EVENT_TYPE_ADDED EventType = 1
+	// The function changed (e.g., its VF was allocated)
+	EventType_EVENT_TYPE_UPDATED EventType = 2
+	// The function is gone, the event carries its last state
+	EventType_EVENT_TYPE_DELETED EventType = 3
+	// Every current function was sent, the mirror is complete
+	EventType_EVENT_TYPE_SYNCED EventType = 4
+)
+
+// Enum value maps for EventType.
+var (
+	EventType_name = map[int32]string{
+		0: "EVENT_TYPE_UNSPECIFIED",
+		1: "EVENT_TYPE_ADDED",
+		2: "EVENT_TYPE_UPDATED",
+		3: "EVENT_TYPE_DELETED",
+		4: "EVENT_TYPE_SYNCED",
+	}
+	EventType_value = map[string]int32{
+		"EVENT_TYPE_UNSPECIFIED": 0,
+		"EVENT_TYPE_ADDED":       1,
+		"EVENT_TYPE_UPDATED":     2,
+		"EVENT_TYPE_DELETED":     3,
+		"EVENT_TYPE_SYNCED":      4,
+	}
+)
+
+func (x EventType) Enum() *EventType {
+	p := new(EventType)
+	*p = x
+	return p
+}
+
+func (x EventType) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (EventType) Descriptor() protoreflect.EnumDescriptor {
+	return file_inventory_v1_inventory_proto_enumTypes[0].Descriptor()
+}
+
+func (EventType) Type() protoreflect.EnumType {
+	return &file_inventory_v1_inventory_proto_enumTypes[0]
+}
+
+func (x EventType) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use EventType.Descriptor instead.
+func (EventType) EnumDescriptor() ([]byte, []int) {
+	return file_inventory_v1_inventory_proto_rawDescGZIP(), []int{0}
+}
+
+// WatchInventoryRequest selects the functions to watch
+type WatchInventoryRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Name of the physical function (e.g., eth0), empty for all
+	PfName string `protobuf:"bytes,1,opt,name=pf_name,json=pfName,proto3" json:"pf_name,omitempty"`
+	// Whether to skip the current functions and only send the changes
+	SkipInitial   bool `protobuf:"varint,2,opt,name=skip_initial,json=skipInitial,proto3" json:"skip_initial,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WatchInventoryRequest) Reset() {
+	*x = WatchInventoryRequest{}
+	mi := &file_inventory_v1_inventory_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WatchInventoryRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WatchInventoryRequest) ProtoMessage() {}
+
+func (x *WatchInventoryRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_inventory_v1_inventory_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WatchInventoryRequest.ProtoReflect.Descriptor instead.
+func (*WatchInventoryRequest) Descriptor() ([]byte, []int) {
+	return file_inventory_v1_inventory_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *WatchInventoryRequest) GetPfName() string {
+	if x != nil {
+		return x.PfName
+	}
+	return ""
+}
+
+func (x *WatchInventoryRequest) GetSkipInitial() bool {
+	if x != nil {
+		return x.SkipInitial
+	}
+	return false
+}
+
+// InventoryEvent is a change of a physical or virtual function
+type InventoryEvent struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Kind of change
+	Type EventType `protobuf:"varint,1,opt,name=type,proto3,enum=nsm.inventory.v1.EventType" json:"type,omitempty"`
+	// Time the change was observed
+	Time *timestamppb.Timestamp `protobuf:"bytes,2,opt,name=time,proto3" json:"time,omitempty"`
+	// Function that changed, unset for synced events
+	//
+	// Types that are valid to be assigned to Function:
+	//
+	//	*InventoryEvent_Pf
+	//	*InventoryEvent_Vf
+	Function      isInventoryEvent_Function `protobuf_oneof:"function"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *InventoryEvent) Reset() {
+	*x = InventoryEvent{}
+	mi := &file_inventory_v1_inventory_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *InventoryEvent) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*InventoryEvent) ProtoMessage() {}
+
+func (x *InventoryEvent) ProtoReflect() protoreflect.Message {
+	mi := &file_inventory_v1_inventory_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use InventoryEvent.ProtoReflect.Descriptor instead.
+func (*InventoryEvent) Descriptor() ([]byte, []int) {
+	return file_inventory_v1_inventory_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *InventoryEvent) GetType() EventType {
+	if x != nil {
+		return x.Type
+	}
+	return EventType_EVENT_TYPE_UNSPECIFIED
+}
+
+func (x *InventoryEvent) GetTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Time
+	}
+	return nil
+}
+
+func (x *InventoryEvent) GetFunction() isInventoryEvent_Function {
+	if x != nil {
+		return x.Function
+	}
+	return nil
+}
+
+func (x *InventoryEvent) GetPf() *PhysicalFunction {
+	if x != nil {
+		if x, ok := x.Function.(*InventoryEvent_Pf); ok {
+			return x.Pf
+		}
+	}
+	return nil
+}
+
+func (x *InventoryEvent) GetVf() *VirtualFunction {
+	if x != nil {
+		if x, ok := x.Function.(*InventoryEvent_Vf); ok {
+			return x.Vf
+		}
+	}
+	return nil
+}
+
+type isInventoryEvent_Function interface {
+	isInventoryEvent_Function()
+}
+
+type InventoryEvent_Pf struct {
+	Pf *PhysicalFunction `protobuf:"bytes,3,opt,name=pf,proto3,oneof"`
+}
+
+type InventoryEvent_Vf struct {
+	Vf *VirtualFunction `protobuf:"bytes,4,opt,name=vf,proto3,oneof"`
+}
+
+func (*InventoryEvent_Pf) isInventoryEvent_Function() {}
+
+func (*InventoryEvent_Vf) isInventoryEvent_Function() {}
+
+// PhysicalFunction is an SR-IOV capable NIC
+type PhysicalFunction struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Interface name (e.g., eth0)
+	Name string `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	// PCI address
+	PciAddress string `protobuf:"bytes,2,opt,name=pci_address,json=pciAddress,proto3" json:"pci_address,omitempty"`
+	// Number of VFs configured
+	NumVfs        int32 `protobuf:"varint,3,opt,name=num_vfs,json=numVfs,proto3" json:"num_vfs,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PhysicalFunction) Reset() {
+	*x = PhysicalFunction{}
+	mi := &file_inventory_v1_inventory_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PhysicalFunction) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PhysicalFunction) ProtoMessage() {}
+
+func (x *PhysicalFunction) ProtoReflect() protoreflect.Message {
+	mi := &file_inventory_v1_inventory_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PhysicalFunction.ProtoReflect.Descriptor instead.
+func (*PhysicalFunction) Descriptor() ([]byte, []int) {
+	return file_inventory_v1_inventory_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *PhysicalFunction) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *PhysicalFunction) GetPciAddress() string {
+	if x != nil {
+		return x.PciAddress
+	}
+	return ""
+}
+
+func (x *PhysicalFunction) GetNumVfs() int32 {
+	if x != nil {
+		return x.NumVfs
+	}
+	return 0
+}
+
+// VirtualFunction is a VF of a physical function
+type VirtualFunction struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Name of the physical function
+	PfName string `protobuf:"bytes,1,opt,name=pf_name,json=pfName,proto3" json:"pf_name,omitempty"`
+	// VF ID on the physical function
+	VfId int32 `protobuf:"varint,2,opt,name=vf_id,json=vfId,proto3" json:"vf_id,omitempty"`
+	// PCI address
+	PciAddress string `protobuf:"bytes,3,opt,name=pci_address,json=pciAddress,proto3" json:"pci_address,omitempty"`
+	// Interface name, if bound to a network driver
+	InterfaceName string `protobuf:"bytes,4,opt,name=interface_name,json=interfaceName,proto3" json:"interface_name,omitempty"`
+	// Whether the VF is allocated to a pod
+	Allocated bool `protobuf:"varint,5,opt,name=allocated,proto3" json:"allocated,omitempty"`
+	// Namespace of the pod using the VF
+	Namespace string `protobuf:"bytes,6,opt,name=namespace,proto3" json:"namespace,omitempty"`
+	// Pod using the VF
+	Pod           string `protobuf:"bytes,7,opt,name=pod,proto3" json:"pod,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *VirtualFunction) Reset() {
+	*x = VirtualFunction{}
+	mi := &file_inventory_v1_inventory_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *VirtualFunction) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*VirtualFunction) ProtoMessage() {}
+
+func (x *VirtualFunction) ProtoReflect() protoreflect.Message {
+	mi := &file_inventory_v1_inventory_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use VirtualFunction.ProtoReflect.Descriptor instead.
+func (*VirtualFunction) Descriptor() ([]byte, []int) {
+	return file_inventory_v1_inventory_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *VirtualFunction) GetPfName() string {
+	if x != nil {
+		return x.PfName
+	}
+	return ""
+}
+
+func (x *VirtualFunction) GetVfId() int32 {
+	if x != nil {
+		return x.VfId
+	}
+	return 0
+}
+
+func (x *VirtualFunction) GetPciAddress() string {
+	if x != nil {
+		return x.PciAddress
+	}
+	return ""
+}
+
+func (x *VirtualFunction) GetInterfaceName() string {
+	if x != nil {
+		return x.InterfaceName
+	}
+	return ""
+}
+
+func (x *VirtualFunction) GetAllocated() bool {
+	if x != nil {
+		return x.Allocated
+	}
+	return false
+}
+
+func (x *VirtualFunction) GetNamespace() string {
+	if x != nil {
+		return x.Namespace
+	}
+	return ""
+}
+
+func (x *VirtualFunction) GetPod() string {
+	if x != nil {
+		return x.Pod
+	}
+	return ""
+}
+
+var File_inventory_v1_inventory_proto protoreflect.FileDescriptor
+
+const file_inventory_v1_inventory_proto_rawDesc = "" +
+	"\n" +
+	"\x1cinventory/v1/inventory.proto\x12\x10nsm.inventory.v1\x1a\x1fgoogle/protobuf/timestamp.proto\"S\n" +
+	"\x15WatchInventoryRequest\x12\x17\n" +
+	"\apf_name\x18\x01 \x01(\tR\x06pfName\x12!\n" +
+	"\fskip_initial\x18\x02 \x01(\bR\vskipInitial\"\xe8\x01\n" +
+	"\x0eInventoryEvent\x12/\n" +
+	"\x04type\x18\x01 \x01(\x0e2\x1b.nsm.inventory.v1.EventTypeR\x04type\x12.\n" +
+	"\x04time\x18\x02 \x01(\v2\x1a.google.protobuf.TimestampR\x04time\x124\n" +
+	"\x02pf\x18\x03 \x01(\v2\".nsm.inventory.v1.PhysicalFunctionH\x00R\x02pf\x123\n" +
+	"\x02vf\x18\x04 \x01(\v2!.nsm.inventory.v1.VirtualFunctionH\x00R\x02vfB\n" +
+	"\n" +
+	"\bfunction\"`\n" +
+	"\x10PhysicalFunction\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x1f\n" +
+	"\vpci_address\x18\x02 \x01(\tR\n" +
+	"pciAddress\x12\x17\n" +
+	"\anum_vfs\x18\x03 \x01(\x05R\x06numVfs\"\xd5\x01\n" +
+	"\x0fVirtualFunction\x12\x17\n" +
+	"\apf_name\x18\x01 \x01(\tR\x06pfName\x12\x13\n" +
+	"\x05vf_id\x18\x02 \x01(\x05R\x04vfId\x12\x1f\n" +
+	"\vpci_address\x18\x03 \x01(\tR\n" +
+	"pciAddress\x12%\n" +
+	"\x0einterface_name\x18\x04 \x01(\tR\rinterfaceName\x12\x1c\n" +
+	"\tallocated\x18\x05 \x01(\bR\tallocated\x12\x1c\n" +
+	"\tnamespace\x18\x06 \x01(\tR\tnamespace\x12\x10\n" +
+	"\x03pod\x18\a \x01(\tR\x03pod*\x84\x01\n" +
+	"\tEventType\x12\x1a\n" +
+	"\x16EVENT_TYPE_UNSPECIFIED\x10\x00\x12\x14\n" +
+	"\x10EVENT_TYPE_ADDED\x10\x01\x12\x16\n" +
+	"\x12EVENT_TYPE_UPDATED\x10\x02\x12\x16\n" +
+	"\x12EVENT_TYPE_DELETED\x10\x03\x12\x15\n" +
+	"\x11EVENT_TYPE_SYNCED\x10\x042j\n" +
+	"\tInventory\x12]\n" +
+	"\x0eWatchInventory\x12'.nsm.inventory.v1.WatchInventoryRequest\x1a .nsm.inventory.v1.InventoryEvent0\x01B=Z;github.com/akos011221/nsm/api/grpc/inventory/v1;inventoryv1b\x06proto3"
+
+var (
+	file_inventory_v1_inventory_proto_rawDescOnce sync.Once
+	file_inventory_v1_inventory_proto_rawDescData []byte
+)
+
+func file_inventory_v1_inventory_proto_rawDescGZIP() []byte {
+	file_inventory_v1_inventory_proto_rawDescOnce.Do(func() {
+		file_inventory_v1_inventory_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_inventory_v1_inventory_proto_rawDesc), len(file_inventory_v1_inventory_proto_rawDesc)))
+	})
+	return file_inventory_v1_inventory_proto_rawDescData
+}
+
+var file_inventory_v1_inventory_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_inventory_v1_inventory_proto_msgTypes = make([]protoimpl.MessageInfo, 4)
+var file_inventory_v1_inventory_proto_goTypes = []any{
+	(EventType)(0),                // 0: nsm.inventory.v1.EventType
+	(*WatchInventoryRequest)(nil), // 1: nsm.inventory.v1.WatchInventoryRequest
+	(*InventoryEvent)(nil),        // 2: nsm.inventory.v1.InventoryEvent
+	(*PhysicalFunction)(nil),      // 3: nsm.inventory.v1.PhysicalFunction
+	(*VirtualFunction)(nil),       // 4: nsm.inventory.v1.VirtualFunction
+	(*timestamppb.Timestamp)(nil), // 5: google.protobuf.Timestamp
+}
+var file_inventory_v1_inventory_proto_depIdxs = []int32{
+	0, // 0: nsm.inventory.v1.InventoryEvent.type:type_name -> nsm.inventory.v1.EventType
+	5, // 1: nsm.inventory.v1.InventoryEvent.time:type_name -> google.protobuf.Timestamp
+	3, // 2: nsm.inventory.v1.InventoryEvent.pf:type_name -> nsm.inventory.v1.PhysicalFunction
+	4, // 3: nsm.inventory.v1.InventoryEvent.vf:type_name -> nsm.inventory.v1.VirtualFunction
+	1, // 4: nsm.inventory.v1.Inventory.WatchInventory:input_type -> nsm.inventory.v1.WatchInventoryRequest
+	2, // 5: nsm.inventory.v1.Inventory.WatchInventory:output_type -> nsm.inventory.v1.InventoryEvent
+	5, // [5:6] is the sub-list for method output_type
+	4, // [4:5] is the sub-list for method input_type
+	4, // [4:4] is the sub-list for extension type_name
+	4, // [4:4] is the sub-list for extension extendee
+	0, // [0:4] is the sub-list for field type_name
+}
+
+func init() { file_inventory_v1_inventory_proto_init() }
+func file_inventory_v1_inventory_proto_init() {
+	if File_inventory_v1_inventory_proto != nil {
+		return
+	}
+	file_inventory_v1_inventory_proto_msgTypes[1].OneofWrappers = []any{
+		(*InventoryEvent_Pf)(nil),
+		(*InventoryEvent_Vf)(nil),
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_inventory_v1_inventory_proto_rawDesc), len(file_inventory_v1_inventory_proto_rawDesc)),
+			NumEnums:      1,
+			NumMessages:   4,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_inventory_v1_inventory_proto_goTypes,
+		DependencyIndexes: file_inventory_v1_inventory_proto_depIdxs,
+		EnumInfos:         file_inventory_v1_inventory_proto_enumTypes,
+		MessageInfos:      file_inventory_v1_inventory_proto_msgTypes,
+	}.Build()
+	File_inventory_v1_inventory_proto = out.File
+	file_inventory_v1_inventory_proto_goTypes = nil
+	file_inventory_v1_inventory_proto_depIdxs = nil
+}
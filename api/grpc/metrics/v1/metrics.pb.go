@@ -0,0 +1,272 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.6
+// 	protoc        (unknown)
+// source: metrics/v1/metrics.proto
+
+package metricsv1
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// WatchConnectionMetricsRequest selects the connections to watch
+type WatchConnectionMetricsRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Namespace of the connections, empty for all namespaces
+	Namespace string `protobuf:"bytes,1,opt,name=namespace,proto3" json:"namespace,omitempty"`
+	// Names of the connections, empty for all connections in the namespace
+	Names []string `protobuf:"bytes,2,rep,name=names,proto3" json:"names,omitempty"`
+	// Label selector of the connections (e.g., tier=video), empty for all
+	LabelSelector string `protobuf:"bytes,3,opt,name=label_selector,json=labelSelector,proto3" json:"label_selector,omitempty"`
+	// Minimum interval between two samples of a connection in milliseconds,
+	// defaults to 1000
+	MinIntervalMs uint32 `protobuf:"varint,4,opt,name=min_interval_ms,json=minIntervalMs,proto3" json:"min_interval_ms,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WatchConnectionMetricsRequest) Reset() {
+	*x = WatchConnectionMetricsRequest{}
+	mi := &file_metrics_v1_metrics_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WatchConnectionMetricsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WatchConnectionMetricsRequest) ProtoMessage() {}
+
+func (x *WatchConnectionMetricsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_metrics_v1_metrics_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WatchConnectionMetricsRequest.ProtoReflect.Descriptor instead.
+func (*WatchConnectionMetricsRequest) Descriptor() ([]byte, []int) {
+	return file_metrics_v1_metrics_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *WatchConnectionMetricsRequest) GetNamespace() string {
+	if x != nil {
+		return x.Namespace
+	}
+	return ""
+}
+
+func (x *WatchConnectionMetricsRequest) GetNames() []string {
+	if x != nil {
+		return x.Names
+	}
+	return nil
+}
+
+func (x *WatchConnectionMetricsRequest) GetLabelSelector() string {
+	if x != nil {
+		return x.LabelSelector
+	}
+	return ""
+}
+
+func (x *WatchConnectionMetricsRequest) GetMinIntervalMs() uint32 {
+	if x != nil {
+		return x.MinIntervalMs
+	}
+	return 0
+}
+
+// ConnectionMetricsSample is a metrics sample of a connection
+type ConnectionMetricsSample struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Namespace of the connection
+	Namespace string `protobuf:"bytes,1,opt,name=namespace,proto3" json:"namespace,omitempty"`
+	// Name of the connection
+	Name string `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	// Time the metrics were observed
+	Time *timestamppb.Timestamp `protobuf:"bytes,3,opt,name=time,proto3" json:"time,omitempty"`
+	// Observed latency in milliseconds
+	LatencyMs int64 `protobuf:"varint,4,opt,name=latency_ms,json=latencyMs,proto3" json:"latency_ms,omitempty"`
+	// Observed throughput in Mbps
+	ThroughputMbps int64 `protobuf:"varint,5,opt,name=throughput_mbps,json=throughputMbps,proto3" json:"throughput_mbps,omitempty"`
+	// Observed packet loss in parts per million
+	PacketLossPpm int64 `protobuf:"varint,6,opt,name=packet_loss_ppm,json=packetLossPpm,proto3" json:"packet_loss_ppm,omitempty"`
+	// State of the connection
+	State         string `protobuf:"bytes,7,opt,name=state,proto3" json:"state,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ConnectionMetricsSample) Reset() {
+	*x = ConnectionMetricsSample{}
+	mi := &file_metrics_v1_metrics_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ConnectionMetricsSample) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ConnectionMetricsSample) ProtoMessage() {}
+
+func (x *ConnectionMetricsSample) ProtoReflect() protoreflect.Message {
+	mi := &file_metrics_v1_metrics_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ConnectionMetricsSample.ProtoReflect.Descriptor instead.
+func (*ConnectionMetricsSample) Descriptor() ([]byte, []int) {
+	return file_metrics_v1_metrics_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *ConnectionMetricsSample) GetNamespace() string {
+	if x != nil {
+		return x.Namespace
+	}
+	return ""
+}
+
+func (x *ConnectionMetricsSample) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *ConnectionMetricsSample) GetTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Time
+	}
+	return nil
+}
+
+func (x *ConnectionMetricsSample) GetLatencyMs() int64 {
+	if x != nil {
+		return x.LatencyMs
+	}
+	return 0
+}
+
+func (x *ConnectionMetricsSample) GetThroughputMbps() int64 {
+	if x != nil {
+		return x.ThroughputMbps
+	}
+	return 0
+}
+
+func (x *ConnectionMetricsSample) GetPacketLossPpm() int64 {
+	if x != nil {
+		return x.PacketLossPpm
+	}
+	return 0
+}
+
+func (x *ConnectionMetricsSample) GetState() string {
+	if x != nil {
+		return x.State
+	}
+	return ""
+}
+
+var File_metrics_v1_metrics_proto protoreflect.FileDescriptor
+
+const file_metrics_v1_metrics_proto_rawDesc = "" +
+	"\n" +
+	"\x18metrics/v1/metrics.proto\x12\x0ensm.metrics.v1\x1a\x1fgoogle/protobuf/timestamp.proto\"\xa2\x01\n" +
+	"\x1dWatchConnectionMetricsRequest\x12\x1c\n" +
+	"\tnamespace\x18\x01 \x01(\tR\tnamespace\x12\x14\n" +
+	"\x05names\x18\x02 \x03(\tR\x05names\x12%\n" +
+	"\x0elabel_selector\x18\x03 \x01(\tR\rlabelSelector\x12&\n" +
+	"\x0fmin_interval_ms\x18\x04 \x01(\rR\rminIntervalMs\"\x81\x02\n" +
+	"\x17ConnectionMetricsSample\x12\x1c\n" +
+	"\tnamespace\x18\x01 \x01(\tR\tnamespace\x12\x12\n" +
+	"\x04name\x18\x02 \x01(\tR\x04name\x12.\n" +
+	"\x04time\x18\x03 \x01(\v2\x1a.google.protobuf.TimestampR\x04time\x12\x1d\n" +
+	"\n" +
+	"latency_ms\x18\x04 \x01(\x03R\tlatencyMs\x12'\n" +
+	"\x0fthroughput_mbps\x18\x05 \x01(\x03R\x0ethroughputMbps\x12&\n" +
+	"\x0fpacket_loss_ppm\x18\x06 \x01(\x03R\rpacketLossPpm\x12\x14\n" +
+	"\x05state\x18\a \x01(\tR\x05state2\x87\x01\n" +
+	"\x11ConnectionMetrics\x12r\n" +
+	"\x16WatchConnectionMetrics\x12-.nsm.metrics.v1.WatchConnectionMetricsRequest\x1a'.nsm.metrics.v1.ConnectionMetricsSample0\x01B9Z7github.com/akos011221/nsm/api/grpc/metrics/v1;metricsv1b\x06proto3"
+
+var (
+	file_metrics_v1_metrics_proto_rawDescOnce sync.Once
+	file_metrics_v1_metrics_proto_rawDescData []byte
+)
+
+func file_metrics_v1_metrics_proto_rawDescGZIP() []byte {
+	file_metrics_v1_metrics_proto_rawDescOnce.Do(func() {
+		file_metrics_v1_metrics_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_metrics_v1_metrics_proto_rawDesc), len(file_metrics_v1_metrics_proto_rawDesc)))
+	})
+	return file_metrics_v1_metrics_proto_rawDescData
+}
+
+var file_metrics_v1_metrics_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_metrics_v1_metrics_proto_goTypes = []any{
+	(*WatchConnectionMetricsRequest)(nil), // 0: nsm.metrics.v1.WatchConnectionMetricsRequest
+	(*ConnectionMetricsSample)(nil),       // 1: nsm.metrics.v1.ConnectionMetricsSample
+	(*timestamppb.Timestamp)(nil),         // 2: google.protobuf.Timestamp
+}
+var file_metrics_v1_metrics_proto_depIdxs = []int32{
+	2, // 0: nsm.metrics.v1.ConnectionMetricsSample.time:type_name -> google.protobuf.Timestamp
+	0, // 1: nsm.metrics.v1.ConnectionMetrics.WatchConnectionMetrics:input_type -> nsm.metrics.v1.WatchConnectionMetricsRequest
+	1, // 2: nsm.metrics.v1.ConnectionMetrics.WatchConnectionMetrics:output_type -> nsm.metrics.v1.ConnectionMetricsSample
+	2, // [2:3] is the sub-list for method output_type
+	1, // [1:2] is the sub-list for method input_type
+	1, // [1:1] is the sub-list for extension type_name
+	1, // [1:1] is the sub-list for extension extendee
+	0, // [0:1] is the sub-list for field type_name
+}
+
+func init() { file_metrics_v1_metrics_proto_init() }
+func file_metrics_v1_metrics_proto_init() {
+	if File_metrics_v1_metrics_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_metrics_v1_metrics_proto_rawDesc), len(file_metrics_v1_metrics_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   2,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_metrics_v1_metrics_proto_goTypes,
+		DependencyIndexes: file_metrics_v1_metrics_proto_depIdxs,
+		MessageInfos:      file_metrics_v1_metrics_proto_msgTypes,
+	}.Build()
+	File_metrics_v1_metrics_proto = out.File
+	file_metrics_v1_metrics_proto_goTypes = nil
+	file_metrics_v1_metrics_proto_depIdxs = nil
+}
@@ -0,0 +1,132 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: metrics/v1/metrics.proto
+
+package metricsv1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	ConnectionMetrics_WatchConnectionMetrics_FullMethodName = "/nsm.metrics.v1.ConnectionMetrics/WatchConnectionMetrics"
+)
+
+// ConnectionMetricsClient is the client API for ConnectionMetrics service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// ConnectionMetrics streams the observed metrics of NetworkConnections
+type ConnectionMetricsClient interface {
+	// WatchConnectionMetrics pushes a sample whenever the metrics of a
+	// selected connection are updated, until the client cancels
+	WatchConnectionMetrics(ctx context.Context, in *WatchConnectionMetricsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ConnectionMetricsSample], error)
+}
+
+type connectionMetricsClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewConnectionMetricsClient(cc grpc.ClientConnInterface) ConnectionMetricsClient {
+	return &connectionMetricsClient{cc}
+}
+
+func (c *connectionMetricsClient) WatchConnectionMetrics(ctx context.Context, in *WatchConnectionMetricsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ConnectionMetricsSample], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &ConnectionMetrics_ServiceDesc.Streams[0], ConnectionMetrics_WatchConnectionMetrics_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[WatchConnectionMetricsRequest, ConnectionMetricsSample]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type ConnectionMetrics_WatchConnectionMetricsClient = grpc.ServerStreamingClient[ConnectionMetricsSample]
+
+// ConnectionMetricsServer is the server API for ConnectionMetrics service.
+// All implementations must embed UnimplementedConnectionMetricsServer
+// for forward compatibility.
+//
+// ConnectionMetrics streams the observed metrics of NetworkConnections
+type ConnectionMetricsServer interface {
+	// WatchConnectionMetrics pushes a sample whenever the metrics of a
+	// selected connection are updated, until the client cancels
+	WatchConnectionMetrics(*WatchConnectionMetricsRequest, grpc.ServerStreamingServer[ConnectionMetricsSample]) error
+	mustEmbedUnimplementedConnectionMetricsServer()
+}
+
+// UnimplementedConnectionMetricsServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedConnectionMetricsServer struct{}
+
+func (UnimplementedConnectionMetricsServer) WatchConnectionMetrics(*WatchConnectionMetricsRequest, grpc.ServerStreamingServer[ConnectionMetricsSample]) error {
+	return status.Errorf(codes.Unimplemented, "method WatchConnectionMetrics not implemented")
+}
+func (UnimplementedConnectionMetricsServer) mustEmbedUnimplementedConnectionMetricsServer() {}
+func (UnimplementedConnectionMetricsServer) testEmbeddedByValue()                           {}
+
+// UnsafeConnectionMetricsServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ConnectionMetricsServer will
+// result in compilation errors.
+type UnsafeConnectionMetricsServer interface {
+	mustEmbedUnimplementedConnectionMetricsServer()
+}
+
+func RegisterConnectionMetricsServer(s grpc.ServiceRegistrar, srv ConnectionMetricsServer) {
+	// If the following call pancis, it indicates UnimplementedConnectionMetricsServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&ConnectionMetrics_ServiceDesc, srv)
+}
+
+func _ConnectionMetrics_WatchConnectionMetrics_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchConnectionMetricsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ConnectionMetricsServer).WatchConnectionMetrics(m, &grpc.GenericServerStream[WatchConnectionMetricsRequest, ConnectionMetricsSample]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type ConnectionMetrics_WatchConnectionMetricsServer = grpc.ServerStreamingServer[ConnectionMetricsSample]
+
+// ConnectionMetrics_ServiceDesc is the grpc.ServiceDesc for ConnectionMetrics service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var ConnectionMetrics_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "nsm.metrics.v1.ConnectionMetrics",
+	HandlerType: (*ConnectionMetricsServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchConnectionMetrics",
+			Handler:       _ConnectionMetrics_WatchConnectionMetrics_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "metrics/v1/metrics.proto",
+}
@@ -0,0 +1,464 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.6
+// 	protoc        (unknown)
+// source: vf/v1/vf.proto
+
+package vfv1
+
+import (
+	v1 "github.com/akos011221/nsm/api/grpc/inventory/v1"
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// RequestVFRequest requests a VF for a pod
+type RequestVFRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Namespace of the pod
+	Namespace string `protobuf:"bytes,1,opt,name=namespace,proto3" json:"namespace,omitempty"`
+	// Pod the VF is leased to, it needn't exist yet
+	Pod string `protobuf:"bytes,2,opt,name=pod,proto3" json:"pod,omitempty"`
+	// Seconds the lease lasts without being renewed, 0 for the longest the
+	// node allows
+	TtlSeconds    int32 `protobuf:"varint,3,opt,name=ttl_seconds,json=ttlSeconds,proto3" json:"ttl_seconds,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RequestVFRequest) Reset() {
+	*x = RequestVFRequest{}
+	mi := &file_vf_v1_vf_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RequestVFRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RequestVFRequest) ProtoMessage() {}
+
+func (x *RequestVFRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_vf_v1_vf_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RequestVFRequest.ProtoReflect.Descriptor instead.
+func (*RequestVFRequest) Descriptor() ([]byte, []int) {
+	return file_vf_v1_vf_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *RequestVFRequest) GetNamespace() string {
+	if x != nil {
+		return x.Namespace
+	}
+	return ""
+}
+
+func (x *RequestVFRequest) GetPod() string {
+	if x != nil {
+		return x.Pod
+	}
+	return ""
+}
+
+func (x *RequestVFRequest) GetTtlSeconds() int32 {
+	if x != nil {
+		return x.TtlSeconds
+	}
+	return 0
+}
+
+// VFLease is a VF leased to a pod
+type VFLease struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Namespace of the pod holding the lease
+	Namespace string `protobuf:"bytes,1,opt,name=namespace,proto3" json:"namespace,omitempty"`
+	// Pod holding the lease
+	Pod string `protobuf:"bytes,2,opt,name=pod,proto3" json:"pod,omitempty"`
+	// VF leased
+	Vf *v1.VirtualFunction `protobuf:"bytes,3,opt,name=vf,proto3" json:"vf,omitempty"`
+	// Seconds the lease lasts without being renewed
+	TtlSeconds int32 `protobuf:"varint,4,opt,name=ttl_seconds,json=ttlSeconds,proto3" json:"ttl_seconds,omitempty"`
+	// Time the lease expires unless renewed
+	Expires       *timestamppb.Timestamp `protobuf:"bytes,5,opt,name=expires,proto3" json:"expires,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *VFLease) Reset() {
+	*x = VFLease{}
+	mi := &file_vf_v1_vf_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *VFLease) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*VFLease) ProtoMessage() {}
+
+func (x *VFLease) ProtoReflect() protoreflect.Message {
+	mi := &file_vf_v1_vf_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use VFLease.ProtoReflect.Descriptor instead.
+func (*VFLease) Descriptor() ([]byte, []int) {
+	return file_vf_v1_vf_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *VFLease) GetNamespace() string {
+	if x != nil {
+		return x.Namespace
+	}
+	return ""
+}
+
+func (x *VFLease) GetPod() string {
+	if x != nil {
+		return x.Pod
+	}
+	return ""
+}
+
+func (x *VFLease) GetVf() *v1.VirtualFunction {
+	if x != nil {
+		return x.Vf
+	}
+	return nil
+}
+
+func (x *VFLease) GetTtlSeconds() int32 {
+	if x != nil {
+		return x.TtlSeconds
+	}
+	return 0
+}
+
+func (x *VFLease) GetExpires() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Expires
+	}
+	return nil
+}
+
+// ReleaseVFRequest releases the VF leased to a pod
+type ReleaseVFRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Namespace of the pod
+	Namespace string `protobuf:"bytes,1,opt,name=namespace,proto3" json:"namespace,omitempty"`
+	// Pod holding the lease
+	Pod           string `protobuf:"bytes,2,opt,name=pod,proto3" json:"pod,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ReleaseVFRequest) Reset() {
+	*x = ReleaseVFRequest{}
+	mi := &file_vf_v1_vf_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ReleaseVFRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReleaseVFRequest) ProtoMessage() {}
+
+func (x *ReleaseVFRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_vf_v1_vf_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReleaseVFRequest.ProtoReflect.Descriptor instead.
+func (*ReleaseVFRequest) Descriptor() ([]byte, []int) {
+	return file_vf_v1_vf_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *ReleaseVFRequest) GetNamespace() string {
+	if x != nil {
+		return x.Namespace
+	}
+	return ""
+}
+
+func (x *ReleaseVFRequest) GetPod() string {
+	if x != nil {
+		return x.Pod
+	}
+	return ""
+}
+
+// ReleaseVFResponse confirms the VF was freed
+type ReleaseVFResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ReleaseVFResponse) Reset() {
+	*x = ReleaseVFResponse{}
+	mi := &file_vf_v1_vf_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ReleaseVFResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReleaseVFResponse) ProtoMessage() {}
+
+func (x *ReleaseVFResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_vf_v1_vf_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReleaseVFResponse.ProtoReflect.Descriptor instead.
+func (*ReleaseVFResponse) Descriptor() ([]byte, []int) {
+	return file_vf_v1_vf_proto_rawDescGZIP(), []int{3}
+}
+
+// ListVFsRequest selects the VFs to list
+type ListVFsRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Name of the physical function (e.g., eth0), empty for all
+	PfName string `protobuf:"bytes,1,opt,name=pf_name,json=pfName,proto3" json:"pf_name,omitempty"`
+	// Whether to only list the VFs not allocated
+	FreeOnly      bool `protobuf:"varint,2,opt,name=free_only,json=freeOnly,proto3" json:"free_only,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListVFsRequest) Reset() {
+	*x = ListVFsRequest{}
+	mi := &file_vf_v1_vf_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListVFsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListVFsRequest) ProtoMessage() {}
+
+func (x *ListVFsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_vf_v1_vf_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListVFsRequest.ProtoReflect.Descriptor instead.
+func (*ListVFsRequest) Descriptor() ([]byte, []int) {
+	return file_vf_v1_vf_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *ListVFsRequest) GetPfName() string {
+	if x != nil {
+		return x.PfName
+	}
+	return ""
+}
+
+func (x *ListVFsRequest) GetFreeOnly() bool {
+	if x != nil {
+		return x.FreeOnly
+	}
+	return false
+}
+
+// ListVFsResponse lists VFs, ordered by PCI address
+type ListVFsResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// VFs
+	Vfs           []*v1.VirtualFunction `protobuf:"bytes,1,rep,name=vfs,proto3" json:"vfs,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListVFsResponse) Reset() {
+	*x = ListVFsResponse{}
+	mi := &file_vf_v1_vf_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListVFsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListVFsResponse) ProtoMessage() {}
+
+func (x *ListVFsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_vf_v1_vf_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListVFsResponse.ProtoReflect.Descriptor instead.
+func (*ListVFsResponse) Descriptor() ([]byte, []int) {
+	return file_vf_v1_vf_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *ListVFsResponse) GetVfs() []*v1.VirtualFunction {
+	if x != nil {
+		return x.Vfs
+	}
+	return nil
+}
+
+var File_vf_v1_vf_proto protoreflect.FileDescriptor
+
+const file_vf_v1_vf_proto_rawDesc = "" +
+	"\n" +
+	"\x0evf/v1/vf.proto\x12\tnsm.vf.v1\x1a\x1fgoogle/protobuf/timestamp.proto\x1a\x1cinventory/v1/inventory.proto\"c\n" +
+	"\x10RequestVFRequest\x12\x1c\n" +
+	"\tnamespace\x18\x01 \x01(\tR\tnamespace\x12\x10\n" +
+	"\x03pod\x18\x02 \x01(\tR\x03pod\x12\x1f\n" +
+	"\vttl_seconds\x18\x03 \x01(\x05R\n" +
+	"ttlSeconds\"\xc3\x01\n" +
+	"\aVFLease\x12\x1c\n" +
+	"\tnamespace\x18\x01 \x01(\tR\tnamespace\x12\x10\n" +
+	"\x03pod\x18\x02 \x01(\tR\x03pod\x121\n" +
+	"\x02vf\x18\x03 \x01(\v2!.nsm.inventory.v1.VirtualFunctionR\x02vf\x12\x1f\n" +
+	"\vttl_seconds\x18\x04 \x01(\x05R\n" +
+	"ttlSeconds\x124\n" +
+	"\aexpires\x18\x05 \x01(\v2\x1a.google.protobuf.TimestampR\aexpires\"B\n" +
+	"\x10ReleaseVFRequest\x12\x1c\n" +
+	"\tnamespace\x18\x01 \x01(\tR\tnamespace\x12\x10\n" +
+	"\x03pod\x18\x02 \x01(\tR\x03pod\"\x13\n" +
+	"\x11ReleaseVFResponse\"F\n" +
+	"\x0eListVFsRequest\x12\x17\n" +
+	"\apf_name\x18\x01 \x01(\tR\x06pfName\x12\x1b\n" +
+	"\tfree_only\x18\x02 \x01(\bR\bfreeOnly\"F\n" +
+	"\x0fListVFsResponse\x123\n" +
+	"\x03vfs\x18\x01 \x03(\v2!.nsm.inventory.v1.VirtualFunctionR\x03vfs2\xb9\x02\n" +
+	"\x10VirtualFunctions\x12<\n" +
+	"\tRequestVF\x12\x1b.nsm.vf.v1.RequestVFRequest\x1a\x12.nsm.vf.v1.VFLease\x12F\n" +
+	"\tReleaseVF\x12\x1b.nsm.vf.v1.ReleaseVFRequest\x1a\x1c.nsm.vf.v1.ReleaseVFResponse\x12@\n" +
+	"\aListVFs\x12\x19.nsm.vf.v1.ListVFsRequest\x1a\x1a.nsm.vf.v1.ListVFsResponse\x12]\n" +
+	"\x0eWatchInventory\x12'.nsm.inventory.v1.WatchInventoryRequest\x1a .nsm.inventory.v1.InventoryEvent0\x01B/Z-github.com/akos011221/nsm/api/grpc/vf/v1;vfv1b\x06proto3"
+
+var (
+	file_vf_v1_vf_proto_rawDescOnce sync.Once
+	file_vf_v1_vf_proto_rawDescData []byte
+)
+
+func file_vf_v1_vf_proto_rawDescGZIP() []byte {
+	file_vf_v1_vf_proto_rawDescOnce.Do(func() {
+		file_vf_v1_vf_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_vf_v1_vf_proto_rawDesc), len(file_vf_v1_vf_proto_rawDesc)))
+	})
+	return file_vf_v1_vf_proto_rawDescData
+}
+
+var file_vf_v1_vf_proto_msgTypes = make([]protoimpl.MessageInfo, 6)
+var file_vf_v1_vf_proto_goTypes = []any{
+	(*RequestVFRequest)(nil),         // 0: nsm.vf.v1.RequestVFRequest
+	(*VFLease)(nil),                  // 1: nsm.vf.v1.VFLease
+	(*ReleaseVFRequest)(nil),         // 2: nsm.vf.v1.ReleaseVFRequest
+	(*ReleaseVFResponse)(nil),        // 3: nsm.vf.v1.ReleaseVFResponse
+	(*ListVFsRequest)(nil),           // 4: nsm.vf.v1.ListVFsRequest
+	(*ListVFsResponse)(nil),          // 5: nsm.vf.v1.ListVFsResponse
+	(*v1.VirtualFunction)(nil),       // 6: nsm.inventory.v1.VirtualFunction
+	(*timestamppb.Timestamp)(nil),    // 7: google.protobuf.Timestamp
+	(*v1.WatchInventoryRequest)(nil), // 8: nsm.inventory.v1.WatchInventoryRequest
+	(*v1.InventoryEvent)(nil),        // 9: nsm.inventory.v1.InventoryEvent
+}
+var file_vf_v1_vf_proto_depIdxs = []int32{
+	6, // 0: nsm.vf.v1.VFLease.vf:type_name -> nsm.inventory.v1.VirtualFunction
+	7, // 1: nsm.vf.v1.VFLease.expires:type_name -> google.protobuf.Timestamp
+	6, // 2: nsm.vf.v1.ListVFsResponse.vfs:type_name -> nsm.inventory.v1.VirtualFunction
+	0, // 3: nsm.vf.v1.VirtualFunctions.RequestVF:input_type -> nsm.vf.v1.RequestVFRequest
+	2, // 4: nsm.vf.v1.VirtualFunctions.ReleaseVF:input_type -> nsm.vf.v1.ReleaseVFRequest
+	4, // 5: nsm.vf.v1.VirtualFunctions.ListVFs:input_type -> nsm.vf.v1.ListVFsRequest
+	8, // 6: nsm.vf.v1.VirtualFunctions.WatchInventory:input_type -> nsm.inventory.v1.WatchInventoryRequest
+	1, // 7: nsm.vf.v1.VirtualFunctions.RequestVF:output_type -> nsm.vf.v1.VFLease
+	3, // 8: nsm.vf.v1.VirtualFunctions.ReleaseVF:output_type -> nsm.vf.v1.ReleaseVFResponse
+	5, // 9: nsm.vf.v1.VirtualFunctions.ListVFs:output_type -> nsm.vf.v1.ListVFsResponse
+	9, // 10: nsm.vf.v1.VirtualFunctions.WatchInventory:output_type -> nsm.inventory.v1.InventoryEvent
+	7, // [7:11] is the sub-list for method output_type
+	3, // [3:7] is the sub-list for method input_type
+	3, // [3:3] is the sub-list for extension type_name
+	3, // [3:3] is the sub-list for extension extendee
+	0, // [0:3] is the sub-list for field type_name
+}
+
+func init() { file_vf_v1_vf_proto_init() }
+func file_vf_v1_vf_proto_init() {
+	if File_vf_v1_vf_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_vf_v1_vf_proto_rawDesc), len(file_vf_v1_vf_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   6,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_vf_v1_vf_proto_goTypes,
+		DependencyIndexes: file_vf_v1_vf_proto_depIdxs,
+		MessageInfos:      file_vf_v1_vf_proto_msgTypes,
+	}.Build()
+	File_vf_v1_vf_proto = out.File
+	file_vf_v1_vf_proto_goTypes = nil
+	file_vf_v1_vf_proto_depIdxs = nil
+}
@@ -0,0 +1,264 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: vf/v1/vf.proto
+
+package vfv1
+
+import (
+	context "context"
+	v1 "github.com/akos011221/nsm/api/grpc/inventory/v1"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	VirtualFunctions_RequestVF_FullMethodName      = "/nsm.vf.v1.VirtualFunctions/RequestVF"
+	VirtualFunctions_ReleaseVF_FullMethodName      = "/nsm.vf.v1.VirtualFunctions/ReleaseVF"
+	VirtualFunctions_ListVFs_FullMethodName        = "/nsm.vf.v1.VirtualFunctions/ListVFs"
+	VirtualFunctions_WatchInventory_FullMethodName = "/nsm.vf.v1.VirtualFunctions/WatchInventory"
+)
+
+// VirtualFunctionsClient is the client API for VirtualFunctions service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// VirtualFunctions lets local agents (CNI plugins, device plugins,
+// sidecars) request and release the SR-IOV VFs of the node, served over a
+// unix socket of the node
+type VirtualFunctionsClient interface {
+	// RequestVF leases a free VF to a pod, or renews the lease the pod holds.
+	// The lease is renewed while the pod exists, and the VF freed once it
+	// expires, so a crashed agent can't leak it.
+	RequestVF(ctx context.Context, in *RequestVFRequest, opts ...grpc.CallOption) (*VFLease, error)
+	// ReleaseVF frees the VF leased to a pod
+	ReleaseVF(ctx context.Context, in *ReleaseVFRequest, opts ...grpc.CallOption) (*ReleaseVFResponse, error)
+	// ListVFs lists the VFs of the node
+	ListVFs(ctx context.Context, in *ListVFsRequest, opts ...grpc.CallOption) (*ListVFsResponse, error)
+	// WatchInventory sends the current physical and virtual functions as
+	// added, a synced event, then an event whenever one is added, updated or
+	// deleted, until the client cancels
+	WatchInventory(ctx context.Context, in *v1.WatchInventoryRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[v1.InventoryEvent], error)
+}
+
+type virtualFunctionsClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewVirtualFunctionsClient(cc grpc.ClientConnInterface) VirtualFunctionsClient {
+	return &virtualFunctionsClient{cc}
+}
+
+func (c *virtualFunctionsClient) RequestVF(ctx context.Context, in *RequestVFRequest, opts ...grpc.CallOption) (*VFLease, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(VFLease)
+	err := c.cc.Invoke(ctx, VirtualFunctions_RequestVF_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *virtualFunctionsClient) ReleaseVF(ctx context.Context, in *ReleaseVFRequest, opts ...grpc.CallOption) (*ReleaseVFResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ReleaseVFResponse)
+	err := c.cc.Invoke(ctx, VirtualFunctions_ReleaseVF_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *virtualFunctionsClient) ListVFs(ctx context.Context, in *ListVFsRequest, opts ...grpc.CallOption) (*ListVFsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListVFsResponse)
+	err := c.cc.Invoke(ctx, VirtualFunctions_ListVFs_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *virtualFunctionsClient) WatchInventory(ctx context.Context, in *v1.WatchInventoryRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[v1.InventoryEvent], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &VirtualFunctions_ServiceDesc.Streams[0], VirtualFunctions_WatchInventory_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[v1.WatchInventoryRequest, v1.InventoryEvent]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type VirtualFunctions_WatchInventoryClient = grpc.ServerStreamingClient[v1.InventoryEvent]
+
+// VirtualFunctionsServer is the server API for VirtualFunctions service.
+// All implementations must embed UnimplementedVirtualFunctionsServer
+// for forward compatibility.
+//
+// VirtualFunctions lets local agents (CNI plugins, device plugins,
+// sidecars) request and release the SR-IOV VFs of the node, served over a
+// unix socket of the node
+type VirtualFunctionsServer interface {
+	// RequestVF leases a free VF to a pod, or renews the lease the pod holds.
+	// The lease is renewed while the pod exists, and the VF freed once it
+	// expires, so a crashed agent can't leak it.
+	RequestVF(context.Context, *RequestVFRequest) (*VFLease, error)
+	// ReleaseVF frees the VF leased to a pod
+	ReleaseVF(context.Context, *ReleaseVFRequest) (*ReleaseVFResponse, error)
+	// ListVFs lists the VFs of the node
+	ListVFs(context.Context, *ListVFsRequest) (*ListVFsResponse, error)
+	// WatchInventory sends the current physical and virtual functions as
+	// added, a synced event, then an event whenever one is added, updated or
+	// deleted, until the client cancels
+	WatchInventory(*v1.WatchInventoryRequest, grpc.ServerStreamingServer[v1.InventoryEvent]) error
+	mustEmbedUnimplementedVirtualFunctionsServer()
+}
+
+// UnimplementedVirtualFunctionsServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedVirtualFunctionsServer struct{}
+
+func (UnimplementedVirtualFunctionsServer) RequestVF(context.Context, *RequestVFRequest) (*VFLease, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RequestVF not implemented")
+}
+func (UnimplementedVirtualFunctionsServer) ReleaseVF(context.Context, *ReleaseVFRequest) (*ReleaseVFResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReleaseVF not implemented")
+}
+func (UnimplementedVirtualFunctionsServer) ListVFs(context.Context, *ListVFsRequest) (*ListVFsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListVFs not implemented")
+}
+func (UnimplementedVirtualFunctionsServer) WatchInventory(*v1.WatchInventoryRequest, grpc.ServerStreamingServer[v1.InventoryEvent]) error {
+	return status.Errorf(codes.Unimplemented, "method WatchInventory not implemented")
+}
+func (UnimplementedVirtualFunctionsServer) mustEmbedUnimplementedVirtualFunctionsServer() {}
+func (UnimplementedVirtualFunctionsServer) testEmbeddedByValue()                          {}
+
+// UnsafeVirtualFunctionsServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to VirtualFunctionsServer will
+// result in compilation errors.
+type UnsafeVirtualFunctionsServer interface {
+	mustEmbedUnimplementedVirtualFunctionsServer()
+}
+
+func RegisterVirtualFunctionsServer(s grpc.ServiceRegistrar, srv VirtualFunctionsServer) {
+	// If the following call pancis, it indicates UnimplementedVirtualFunctionsServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&VirtualFunctions_ServiceDesc, srv)
+}
+
+func _VirtualFunctions_RequestVF_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RequestVFRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(VirtualFunctionsServer).RequestVF(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: VirtualFunctions_RequestVF_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(VirtualFunctionsServer).RequestVF(ctx, req.(*RequestVFRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _VirtualFunctions_ReleaseVF_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReleaseVFRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(VirtualFunctionsServer).ReleaseVF(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: VirtualFunctions_ReleaseVF_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(VirtualFunctionsServer).ReleaseVF(ctx, req.(*ReleaseVFRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _VirtualFunctions_ListVFs_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListVFsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(VirtualFunctionsServer).ListVFs(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: VirtualFunctions_ListVFs_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(VirtualFunctionsServer).ListVFs(ctx, req.(*ListVFsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _VirtualFunctions_WatchInventory_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(v1.WatchInventoryRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(VirtualFunctionsServer).WatchInventory(m, &grpc.GenericServerStream[v1.WatchInventoryRequest, v1.InventoryEvent]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type VirtualFunctions_WatchInventoryServer = grpc.ServerStreamingServer[v1.InventoryEvent]
+
+// VirtualFunctions_ServiceDesc is the grpc.ServiceDesc for VirtualFunctions service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var VirtualFunctions_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "nsm.vf.v1.VirtualFunctions",
+	HandlerType: (*VirtualFunctionsServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "RequestVF",
+			Handler:    _VirtualFunctions_RequestVF_Handler,
+		},
+		{
+			MethodName: "ReleaseVF",
+			Handler:    _VirtualFunctions_ReleaseVF_Handler,
+		},
+		{
+			MethodName: "ListVFs",
+			Handler:    _VirtualFunctions_ListVFs_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchInventory",
+			Handler:       _VirtualFunctions_WatchInventory_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "vf/v1/vf.proto",
+}
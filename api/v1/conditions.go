@@ -0,0 +1,14 @@
+package v1
+
+// Condition types shared by the NSM resources
+const (
+	// ConditionReady indicates that the resource is fully reconciled
+	ConditionReady = "Ready"
+	// ConditionDegraded indicates that the resource works with reduced capabilities
+	ConditionDegraded = "Degraded"
+	// ConditionLatencyBudget indicates whether a connection meets its latency requirement
+	ConditionLatencyBudget = "LatencyBudget"
+	// ConditionCanaryGate indicates whether the canary of a connection let
+	// it migrate to its changed spec
+	ConditionCanaryGate = "CanaryGate"
+)
@@ -0,0 +1,4 @@
+// Package v1 contains the v1 API types of the nsm.akosrbn.io group
+// +k8s:deepcopy-gen=package
+// +groupName=nsm.akosrbn.io
+package v1
@@ -0,0 +1,78 @@
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// NetworkBlueprintSpec is a connection template stamped out once per
+// instance, e.g. a "camera-feed" blueprint expanded per device ID, so
+// fleets of similar devices don't need a hand-written connection each
+type NetworkBlueprintSpec struct {
+	// Parameters the templates may refer to as ${name}
+	Parameters []BlueprintParameter `json:"parameters,omitempty"`
+	// Name of the generated connections with ${name} placeholders, defaults
+	// to the blueprint name followed by the parameter values
+	NameTemplate string `json:"nameTemplate,omitempty"`
+	// Spec of the generated connections, ${name} placeholders are
+	// substituted in its string fields
+	Template NetworkConnectionSpec `json:"template"`
+	// Parameter values of the instances, each generating a connection
+	Instances []BlueprintInstance `json:"instances,omitempty"`
+}
+
+// BlueprintParameter declares a parameter of a blueprint
+type BlueprintParameter struct {
+	// Name of the parameter
+	// +kubebuilder:validation:Pattern=`^[A-Za-z_][A-Za-z0-9_]*$`
+	Name string `json:"name"`
+	// Value used when an instance doesn't set the parameter, the parameter
+	// is required without it
+	Default string `json:"default,omitempty"`
+}
+
+// BlueprintInstance is an expansion of a blueprint, e.g. a device
+type BlueprintInstance struct {
+	// Values of the parameters by name
+	Parameters map[string]string `json:"parameters,omitempty"`
+}
+
+// NetworkBlueprintStatus defines the observed state of a NetworkBlueprint
+type NetworkBlueprintStatus struct {
+	// Generation of the blueprint the status was computed from
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Number of NetworkConnections generated from this blueprint
+	ConnectionCount int `json:"connectionCount,omitempty"`
+	// Human-readable message about the current status
+	Message string `json:"message,omitempty"`
+	// Current conditions of the blueprint
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// +genclient
+// +kubebuilder:object:root=true
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+// +kubebuilder:subresource:status
+
+// NetworkBlueprint is a parameterized NetworkConnection template expanded
+// into a connection per instance
+type NetworkBlueprint struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   NetworkBlueprintSpec   `json:"spec,omitempty"`
+	Status NetworkBlueprintStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// NetworkBlueprintList contains a list of NetworkBlueprint
+type NetworkBlueprintList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []NetworkBlueprint `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&NetworkBlueprint{}, &NetworkBlueprintList{})
+}
@@ -0,0 +1,463 @@
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Connection types
+const (
+	// ConnectionTypeKernel uses the regular kernel datapath (veth/routes)
+	ConnectionTypeKernel = "kernel"
+	// ConnectionTypeSRIOV uses a dedicated SR-IOV Virtual Function
+	ConnectionTypeSRIOV = "sriov"
+	// ConnectionTypeDPDK uses a DPDK poll-mode interface
+	ConnectionTypeDPDK = "dpdk"
+	// ConnectionTypeVXLAN uses a VXLAN tunnel
+	ConnectionTypeVXLAN = "vxlan"
+	// ConnectionTypeWireGuard uses an encrypted WireGuard tunnel
+	ConnectionTypeWireGuard = "wireguard"
+	// ConnectionTypeVhostUser uses a vhost-user socket of a userspace
+	// dataplane (OVS-DPDK, VPP), for DPDK workloads (virtio-user) and VMs
+	ConnectionTypeVhostUser = "vhostuser"
+)
+
+// NetworkConnection states
+const (
+	ConnectionStatePending     = "Pending"
+	ConnectionStateEstablished = "Established"
+	ConnectionStateDegraded    = "Degraded"
+	ConnectionStateFailed      = "Failed"
+	ConnectionStateAdminDown   = "AdminDown"
+)
+
+// Fallback datapaths used when the requested acceleration is unavailable
+const (
+	// DatapathMacvlan is a macvlan sub-interface of the uplink
+	DatapathMacvlan = "macvlan"
+	// DatapathIPvlan is an ipvlan sub-interface of the uplink
+	DatapathIPvlan = "ipvlan"
+)
+
+// Encryption protocols of a connection
+const (
+	// EncryptionIPsec encrypts the connection with IPsec (ESP)
+	EncryptionIPsec = "ipsec"
+	// EncryptionTLS encrypts the connection with kernel TLS
+	EncryptionTLS = "tls"
+)
+
+// Encryption modes reported in the status
+const (
+	// EncryptionModeOffload encrypts inline on the NIC
+	EncryptionModeOffload = "offload"
+	// EncryptionModeSoftware encrypts on the CPU
+	EncryptionModeSoftware = "software"
+)
+
+// Administrative states
+const (
+	// AdminStateUp lets the connection carry traffic
+	AdminStateUp = "up"
+	// AdminStateDown tears down the datapath but keeps the allocations
+	AdminStateDown = "down"
+)
+
+// LabelConnectionNode labels the generated NetworkConnections with the
+// node of the pod they serve, the node their reconciliation is sharded by
+const LabelConnectionNode = LabelEndpointNode
+
+// NetworkConnectionSpec defines the desired state of a NetworkConnection
+type NetworkConnectionSpec struct {
+	// Source endpoint of the connection (e.g., namespace/pod), filled in
+	// with the address of the source NetworkEndpoint when one is referenced
+	Source string `json:"source,omitempty"`
+	// Destination endpoint of the connection (e.g., a NetworkService name, a
+	// namespace/service in another namespace accepting it, or an address),
+	// filled in with the address of the destination NetworkEndpoint when
+	// one is referenced
+	Destination string `json:"destination,omitempty"`
+	// NetworkEndpoint the connection starts at, instead of a free-form source
+	SourceEndpoint string `json:"sourceEndpoint,omitempty"`
+	// NetworkEndpoint the connection ends at, instead of a free-form destination
+	DestinationEndpoint string `json:"destinationEndpoint,omitempty"`
+	// Type of the connection datapath (kernel, sriov, dpdk, vxlan, wireguard,
+	// vhostuser)
+	ConnectionType string `json:"connectionType"`
+	// Priority of the connection, higher values are served first
+	// +kubebuilder:validation:Minimum=0
+	Priority int32 `json:"priority,omitempty"`
+	// Maximum allowed latency in milliseconds (0 means no requirement)
+	LatencyRequirement int `json:"latencyRequirement,omitempty"`
+	// Bandwidth limit in Mbps (0 means unlimited)
+	Bandwidth int `json:"bandwidth,omitempty"`
+	// Administrative state (up, down), defaults to up
+	// +kubebuilder:validation:Enum=up;down
+	AdminState string `json:"adminState,omitempty"`
+	// Encryption protocol (ipsec, tls), empty for none. IPsec encrypts
+	// between the source and destination addresses; kernel TLS is enabled
+	// by the workload on its own sockets, so the datapath refuses it.
+	// WireGuard connections are always encrypted.
+	// +kubebuilder:validation:Enum=ipsec;tls
+	Encryption string `json:"encryption,omitempty"`
+	// Seconds between rekeys of an encrypted connection, 0 for the node default
+	// +kubebuilder:validation:Minimum=0
+	RekeyIntervalSeconds int `json:"rekeyIntervalSeconds,omitempty"`
+	// Makes this an ephemeral canary connection validating a candidate path
+	Canary *CanarySpec `json:"canary,omitempty"`
+	// Canary connection in the namespace that must pass before a changed
+	// spec is set up, so the connection only migrates to a candidate path
+	// the canary validated and keeps its current datapath meanwhile
+	CanaryRef string `json:"canaryRef,omitempty"`
+	// Name resolution over the connection, for its source pod
+	DNS *ConnectionDNS `json:"dns,omitempty"`
+	// Remote endpoint addresses answered for with proxy ARP (IPv4) or NDP
+	// (IPv6) on bridged datapaths (macvlan, ipvlan), so legacy equipment on
+	// the segment reaches them without broadcasts flooding the connection
+	ProxyNeighbors []string `json:"proxyNeighbors,omitempty"`
+	// Multicast groups the connection carries, e.g. for video distribution
+	Multicast *MulticastSpec `json:"multicast,omitempty"`
+	// VLAN tags of the connection traffic on bridged datapaths, a single
+	// 802.1Q tag or stacked 802.1ad (QinQ) tags
+	VLAN *VLANSpec `json:"vlan,omitempty"`
+	// Address (host:port) probed over the connection to measure its latency
+	// and loss, empty to not probe it
+	ProbeTarget string `json:"probeTarget,omitempty"`
+	// Paths the traffic of the connection is shared over at once
+	LoadSharing *LoadSharingSpec `json:"loadSharing,omitempty"`
+	// Seconds the connection may stay pending before it is given up on and
+	// marked Failed, 0 to keep retrying indefinitely
+	// +kubebuilder:validation:Minimum=0
+	EstablishTimeoutSeconds int `json:"establishTimeoutSeconds,omitempty"`
+	// Paths the traffic of the connection fails over between, one at a time
+	Failover *FailoverSpec `json:"failover,omitempty"`
+}
+
+// Load sharing modes
+const (
+	// LoadSharingPerFlow keeps every flow on one path, hashed on its addresses and ports
+	LoadSharingPerFlow = "per-flow"
+	// LoadSharingPerPacket spreads the packets of every flow over the paths,
+	// for endpoints tolerating reordering
+	LoadSharingPerPacket = "per-packet"
+)
+
+// LoadSharingSpec spreads the traffic of a connection towards a
+// destination over several paths at the same time, aggregating the
+// bandwidth of the uplinks instead of keeping one of them idle
+type LoadSharingSpec struct {
+	// Destination prefix (CIDR) of the shared traffic
+	Destination string `json:"destination"`
+	// Paths the traffic is shared over
+	// +kubebuilder:validation:MinItems=2
+	Paths []LoadSharingPath `json:"paths"`
+	// Mode (per-flow, per-packet), defaults to per-flow
+	// +kubebuilder:validation:Enum=per-flow;per-packet
+	Mode string `json:"mode,omitempty"`
+}
+
+// LoadSharingPath is one of the paths of a load shared connection
+type LoadSharingPath struct {
+	// Name of the path, reported in the status
+	Name string `json:"name"`
+	// Outgoing device of the path
+	Device string `json:"device"`
+	// Next hop of the path, empty for directly connected destinations
+	Gateway string `json:"gateway,omitempty"`
+	// Relative share of the traffic, defaults to 1
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=256
+	Weight int `json:"weight,omitempty"`
+}
+
+// FailoverSpec routes the traffic of a connection towards a destination
+// over the first healthy of its paths, re-routing it to a backup path when
+// the active one loses its link, its probes or its latency requirement
+type FailoverSpec struct {
+	// Destination prefix (CIDR) of the traffic
+	Destination string `json:"destination"`
+	// Paths in order of preference, the first one is the primary path the
+	// traffic returns to once it recovered
+	// +kubebuilder:validation:MinItems=2
+	Paths []FailoverPath `json:"paths"`
+}
+
+// FailoverPath is one of the paths of a connection with failover
+type FailoverPath struct {
+	// Name of the path, reported in the status
+	Name string `json:"name"`
+	// Outgoing device of the path
+	Device string `json:"device"`
+	// Next hop of the path, empty for directly connected destinations
+	Gateway string `json:"gateway,omitempty"`
+	// Address (host:port) probed over the device of the path, empty to only
+	// check its link
+	ProbeTarget string `json:"probeTarget,omitempty"`
+}
+
+// PathShare is the share of the traffic of a connection a path carries
+type PathShare struct {
+	// Name of the path
+	Name string `json:"name"`
+	// Outgoing device of the path
+	Device string `json:"device"`
+	// Percentage of the traffic sent over the path
+	Percent int `json:"percent"`
+}
+
+// VLANSpec tags the traffic of a connection. With an inner VID the
+// connection is delivered over QinQ: the outer VID is the 802.1ad service
+// tag of the provider, the inner VID the 802.1Q customer tag.
+type VLANSpec struct {
+	// Outer VLAN ID, the only tag without an inner VID
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=4094
+	OuterVID int `json:"outerVID"`
+	// Inner VLAN ID, 0 for a single tag
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=4094
+	InnerVID int `json:"innerVID,omitempty"`
+}
+
+// MulticastSpec makes the node join multicast groups for a connection,
+// so the upstream routers and switches deliver the groups to it
+type MulticastSpec struct {
+	// Physical function or bridge the groups are joined on, defaults to the uplink
+	Interface string `json:"interface,omitempty"`
+	// VLAN the groups are joined on, 0 for untagged
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=4094
+	VLAN int `json:"vlan,omitempty"`
+	// Enables IGMP/MLD snooping on the interface, which must be a bridge,
+	// so group traffic only reaches the ports with members
+	Snooping bool `json:"snooping,omitempty"`
+	// Groups joined
+	// +kubebuilder:validation:MinItems=1
+	Groups []MulticastGroup `json:"groups"`
+}
+
+// MulticastGroup is a multicast group joined for a connection
+type MulticastGroup struct {
+	// Group address (e.g., 239.1.1.1 or ff3e::1)
+	Address string `json:"address"`
+	// Bandwidth limit of the group in Mbps (0 means unlimited), traffic
+	// beyond it is dropped
+	// +kubebuilder:validation:Minimum=0
+	Bandwidth int `json:"bandwidth,omitempty"`
+}
+
+// ConnectionDNS configures the resolvers of the remote network a
+// connection reaches, e.g. over an L2 or VPN connection. The source pod
+// gets a resolv.conf listing them ahead of the cluster resolver.
+type ConnectionDNS struct {
+	// Resolvers reachable over the connection
+	// +kubebuilder:validation:MaxItems=3
+	Nameservers []string `json:"nameservers,omitempty"`
+	// Search domains of the remote network
+	Searches []string `json:"searches,omitempty"`
+	// Resolver options (e.g., ndots:2, timeout:1)
+	Options []string `json:"options,omitempty"`
+}
+
+// Canary phases
+const (
+	CanaryPhaseRunning = "Running"
+	CanaryPhasePassed  = "Passed"
+	CanaryPhaseFailed  = "Failed"
+)
+
+// CanarySpec describes the synthetic traffic a canary connection sends
+// over a candidate path (new uplink, VF or tunnel configuration)
+type CanarySpec struct {
+	// Address (host:port) the synthetic traffic is sent to
+	Target string `json:"target"`
+	// Device of the candidate path
+	Device string `json:"device,omitempty"`
+	// Number of probes to send, defaults to 10
+	Probes int `json:"probes,omitempty"`
+	// Interval between probes in milliseconds, defaults to 100
+	IntervalMs int `json:"intervalMs,omitempty"`
+	// Maximum acceptable average latency in milliseconds (0 means no limit)
+	MaxLatencyMs int `json:"maxLatencyMs,omitempty"`
+	// Maximum acceptable probe loss in percent
+	MaxLossPercent int `json:"maxLossPercent,omitempty"`
+	// Seconds to keep the canary after it completed, defaults to 300
+	TTLSeconds int `json:"ttlSeconds,omitempty"`
+}
+
+// CanaryStatus reports the result of a canary connection
+type CanaryStatus struct {
+	// Phase of the canary (Running, Passed, Failed)
+	Phase string `json:"phase,omitempty"`
+	// Number of probes sent and answered
+	Sent     int `json:"sent,omitempty"`
+	Received int `json:"received,omitempty"`
+	// Average and maximum observed latency in milliseconds
+	AvgLatencyMs int `json:"avgLatencyMs,omitempty"`
+	MaxLatencyMs int `json:"maxLatencyMs,omitempty"`
+	// Human-readable verdict
+	Message string `json:"message,omitempty"`
+	// Time the canary completed
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+}
+
+// Types of diagnostics run against connections
+const (
+	// DiagnosticTrafficTest is a run of the test traffic generator
+	DiagnosticTrafficTest = "TrafficTest"
+)
+
+// DiagnosticResult is the outcome of a diagnostic run against a connection
+type DiagnosticResult struct {
+	// Type of the diagnostic (TrafficTest)
+	Type string `json:"type"`
+	// Time the diagnostic completed
+	CompletionTime metav1.Time `json:"completionTime"`
+	// Offered rate in Mbps, packet size in bytes and duration in seconds
+	RateMbps    int `json:"rateMbps,omitempty"`
+	PacketSize  int `json:"packetSize,omitempty"`
+	DurationSec int `json:"durationSec,omitempty"`
+	// Number of packets sent and echoed back
+	Sent     int `json:"sent,omitempty"`
+	Received int `json:"received,omitempty"`
+	// Throughput of the echoed traffic in kbps
+	ThroughputKbps int `json:"throughputKbps,omitempty"`
+	// Packet loss in parts per million
+	PacketLossPPM int `json:"packetLossPPM,omitempty"`
+	// Average and maximum round trip time, and the jitter, in microseconds
+	AvgLatencyUs int `json:"avgLatencyUs,omitempty"`
+	MaxLatencyUs int `json:"maxLatencyUs,omitempty"`
+	JitterUs     int `json:"jitterUs,omitempty"`
+	// Whether the connection carried the offered rate without exceeding
+	// its requirements
+	Passed bool `json:"passed"`
+	// Human-readable verdict
+	Message string `json:"message,omitempty"`
+}
+
+// ConnectionMetrics holds the observed metrics of a connection
+type ConnectionMetrics struct {
+	// Observed latency in milliseconds
+	LatencyMs int `json:"latencyMs,omitempty"`
+	// Observed throughput in Mbps
+	ThroughputMbps int `json:"throughputMbps,omitempty"`
+	// Observed packet loss in parts per million
+	PacketLossPPM int `json:"packetLossPPM,omitempty"`
+	// Observed queueing delay on the NIC in microseconds, 0 if not measured
+	NICQueueingUs int `json:"nicQueueingUs,omitempty"`
+	// Observed latency of the host network stack in microseconds, 0 if not measured
+	HostStackUs int `json:"hostStackUs,omitempty"`
+	// Last time the metrics were updated
+	LastUpdated *metav1.Time `json:"lastUpdated,omitempty"`
+}
+
+// Segments of the path of a connection its latency budget is split into
+const (
+	// LatencySegmentNICQueueing is the queueing delay on the NIC
+	LatencySegmentNICQueueing = "NICQueueing"
+	// LatencySegmentHostStack is the latency of the host network stack
+	LatencySegmentHostStack = "HostStack"
+	// LatencySegmentWAN is the latency of the network beyond the node
+	LatencySegmentWAN = "WAN"
+)
+
+// LatencyBudget splits the latency requirement of a connection into the
+// budgets of the segments of its path, in microseconds
+type LatencyBudget struct {
+	// Budget of the queueing delay on the NIC, the queues are sized for it
+	NICQueueingUs int `json:"nicQueueingUs"`
+	// Budget of the host network stack, by datapath
+	HostStackUs int `json:"hostStackUs"`
+	// Remaining budget of the network beyond the node
+	WANUs int `json:"wanUs"`
+	// Segment over its budget while the requirement is missed, empty if it is met
+	Violation string `json:"violation,omitempty"`
+}
+
+// VhostUserStatus is the vhost-user port serving a vhostuser connection
+type VhostUserStatus struct {
+	// Dataplane the port is added to (ovs, vpp)
+	Dataplane string `json:"dataplane"`
+	// Path of the socket on the node. The workload creates it as the
+	// vhost-user server and the dataplane connects as the client, so the
+	// port survives restarts of the dataplane.
+	SocketPath string `json:"socketPath"`
+	// Interface of the port in the dataplane
+	Interface string `json:"interface"`
+}
+
+// NetworkConnectionStatus defines the observed state of a NetworkConnection
+type NetworkConnectionStatus struct {
+	// Current state of the connection
+	State string `json:"state,omitempty"`
+	// Whether the datapath is established
+	Established bool `json:"established,omitempty"`
+	// Generation of the connection the datapath was set up from
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Human-readable message about the current status
+	Message string `json:"message,omitempty"`
+	// Time the connection started waiting for its datapath, nil while established
+	PendingSince *metav1.Time `json:"pendingSince,omitempty"`
+	// Observed connection metrics
+	Metrics ConnectionMetrics `json:"metrics,omitempty"`
+	// Node whose agent sets up the datapath, claimed by the first node
+	// reconciling the connection and moved when that node is lost
+	Node string `json:"node,omitempty"`
+	// Path currently carrying the traffic
+	ActivePath string `json:"activePath,omitempty"`
+	// Pre-established backup path (fast failover strategy only)
+	StandbyPath string `json:"standbyPath,omitempty"`
+	// Result of the canary, for canary connections only
+	Canary *CanaryStatus `json:"canary,omitempty"`
+	// Datapath serving the connection (the connection type, or a fallback)
+	Datapath string `json:"datapath,omitempty"`
+	// Whether a non-accelerated fallback serves an accelerated connection type
+	NonAccelerated bool `json:"nonAccelerated,omitempty"`
+	// Dataplane backend forwarding the connection (kernel, ovs, vpp),
+	// negotiated from the features the connection requires
+	Dataplane string `json:"dataplane,omitempty"`
+	// vhost-user port serving the connection, for vhostuser connections only
+	VhostUser *VhostUserStatus `json:"vhostUser,omitempty"`
+	// Whether the connection is encrypted inline on the NIC (offload) or on
+	// the CPU (software), empty for unencrypted connections
+	Encryption string `json:"encryption,omitempty"`
+	// Secret in the namespace of the connection holding its tunnel key
+	KeySecret string `json:"keySecret,omitempty"`
+	// Last time the keys of an encrypted connection were rotated
+	LastRekeyTime *metav1.Time `json:"lastRekeyTime,omitempty"`
+	// Latency requirement split into the budgets of the path segments
+	LatencyBudget *LatencyBudget `json:"latencyBudget,omitempty"`
+	// Share of the traffic of every path of a load shared connection
+	PathShares []PathShare `json:"pathShares,omitempty"`
+	// Latest diagnostics run against the connection, oldest first
+	Diagnostics []DiagnosticResult `json:"diagnostics,omitempty"`
+	// Current conditions of the connection
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// +genclient
+// +kubebuilder:object:root=true
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+// +kubebuilder:subresource:status
+
+// NetworkConnection is a connection between two endpoints managed by NSM
+type NetworkConnection struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   NetworkConnectionSpec   `json:"spec,omitempty"`
+	Status NetworkConnectionStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// NetworkConnectionList contains a list of NetworkConnection
+type NetworkConnectionList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []NetworkConnection `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&NetworkConnection{}, &NetworkConnectionList{})
+}
@@ -0,0 +1,69 @@
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Endpoint capabilities
+const (
+	// EndpointCapabilityKernel is served by the kernel datapath
+	EndpointCapabilityKernel = "kernel"
+	// EndpointCapabilitySRIOV can hand out SR-IOV Virtual Functions
+	EndpointCapabilitySRIOV = "sriov"
+	// EndpointCapabilityDPDK can be bound to a DPDK poll-mode driver
+	EndpointCapabilityDPDK = "dpdk"
+)
+
+// LabelEndpointNode labels the NetworkEndpoints with the node publishing them
+const LabelEndpointNode = "nsm.akosrbn.io/node"
+
+// NetworkEndpointSpec describes an interface of a node connections can
+// start or end at. The registry of the node publishes it, it isn't meant
+// to be edited.
+type NetworkEndpointSpec struct {
+	// Node of the interface
+	NodeName string `json:"nodeName"`
+	// Interface name on the node (e.g., eth0)
+	Interface string `json:"interface"`
+	// MAC address of the interface
+	MAC string `json:"mac,omitempty"`
+	// IP addresses of the interface, without their prefix length
+	IPs []string `json:"ips,omitempty"`
+	// Capabilities of the interface (kernel, sriov, dpdk)
+	Capabilities []string `json:"capabilities,omitempty"`
+	// Negotiated link speed in Mbps, 0 when unknown
+	SpeedMbps int `json:"speedMbps,omitempty"`
+	// Whether the interface is administratively and operationally up
+	Up bool `json:"up"`
+}
+
+// +genclient
+// +genclient:nonNamespaced
+// +genclient:noStatus
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// NetworkEndpoint is an interface of a node, published by the NSM
+// controller of the node. NetworkConnections reference it by name as
+// their source or destination endpoint.
+type NetworkEndpoint struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec NetworkEndpointSpec `json:"spec,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// NetworkEndpointList contains a list of NetworkEndpoint
+type NetworkEndpointList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []NetworkEndpoint `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&NetworkEndpoint{}, &NetworkEndpointList{})
+}
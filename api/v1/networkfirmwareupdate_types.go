@@ -0,0 +1,103 @@
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// NetworkFirmwareUpdate phases
+const (
+	// FirmwareUpdatePending is an update not started yet
+	FirmwareUpdatePending = "Pending"
+	// FirmwareUpdateWaitingForWindow is an update waiting for its maintenance window
+	FirmwareUpdateWaitingForWindow = "WaitingForWindow"
+	// FirmwareUpdateInProgress is an update draining, flashing and restoring the PF
+	FirmwareUpdateInProgress = "InProgress"
+	// FirmwareUpdateSucceeded is an update validated and with its allocations restored
+	FirmwareUpdateSucceeded = "Succeeded"
+	// FirmwareUpdateFailed is an update that failed, see its message
+	FirmwareUpdateFailed = "Failed"
+)
+
+// NetworkFirmwareUpdateSpec requests the firmware update of a PF. The VFs
+// of the PF are drained, the firmware flashed and activated with a reset
+// of the PF, then validated and the VFs restored to their pods.
+type NetworkFirmwareUpdateSpec struct {
+	// Node of the PF
+	NodeName string `json:"nodeName"`
+	// PF to update (e.g., eth0)
+	PF string `json:"pf"`
+	// Firmware image flashed, a file name in the firmware search path of
+	// the node (e.g., /lib/firmware)
+	// +kubebuilder:validation:Pattern=`^[A-Za-z0-9._-][A-Za-z0-9._/-]*$`
+	Image string `json:"image"`
+	// Firmware version the PF must report after the update, empty to
+	// accept any version
+	ExpectedVersion string `json:"expectedVersion,omitempty"`
+	// Maintenance window the update may start in, nil to start right away
+	MaintenanceWindow *MaintenanceWindow `json:"maintenanceWindow,omitempty"`
+}
+
+// MaintenanceWindow is a recurring time window for disruptive maintenance
+type MaintenanceWindow struct {
+	// Days of the week the window opens on (Mon, Tue, Wed, Thu, Fri, Sat,
+	// Sun), empty for every day
+	Days []string `json:"days,omitempty"`
+	// Time of the day the window opens, HH:MM in UTC
+	// +kubebuilder:validation:Pattern=`^([01][0-9]|2[0-3]):[0-5][0-9]$`
+	Start string `json:"start"`
+	// Length of the window in minutes
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=10080
+	DurationMinutes int `json:"durationMinutes"`
+}
+
+// NetworkFirmwareUpdateStatus defines the observed state of a NetworkFirmwareUpdate
+type NetworkFirmwareUpdateStatus struct {
+	// Generation of the update the status was computed from
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Current phase of the update
+	Phase string `json:"phase,omitempty"`
+	// Human-readable message about the current status
+	Message string `json:"message,omitempty"`
+	// Firmware version of the PF before the update
+	PreviousVersion string `json:"previousVersion,omitempty"`
+	// Firmware version of the PF after the update
+	Version string `json:"version,omitempty"`
+	// Time the update started
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+	// Time the update succeeded or failed
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+	// VFs restored to their pods after the update
+	RestoredVFs []string `json:"restoredVFs,omitempty"`
+	// Current conditions of the update
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// +genclient
+// +kubebuilder:object:root=true
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+// +kubebuilder:subresource:status
+
+// NetworkFirmwareUpdate is a firmware update of a PF, run by the NSM
+// controller of its node within the maintenance window
+type NetworkFirmwareUpdate struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   NetworkFirmwareUpdateSpec   `json:"spec,omitempty"`
+	Status NetworkFirmwareUpdateStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// NetworkFirmwareUpdateList contains a list of NetworkFirmwareUpdate
+type NetworkFirmwareUpdateList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []NetworkFirmwareUpdate `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&NetworkFirmwareUpdate{}, &NetworkFirmwareUpdateList{})
+}
@@ -0,0 +1,79 @@
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Intent directions
+const (
+	// IntentDirectionEgress means the selected pods reach the service
+	IntentDirectionEgress = "egress"
+	// IntentDirectionIngress means the service reaches the selected pods
+	IntentDirectionIngress = "ingress"
+)
+
+// NetworkIntentSpec describes what an application needs from the network,
+// e.g. "pods with label app=vision may reach service camera-feed at <10ms"
+type NetworkIntentSpec struct {
+	// Pods the intent applies to, in the namespace of the intent
+	PodSelector metav1.LabelSelector `json:"podSelector"`
+	// Name of the NetworkService (in the same namespace) to connect with
+	Service string `json:"service"`
+	// Direction of the traffic (egress, ingress), defaults to egress
+	// +kubebuilder:validation:Enum=egress;ingress
+	Direction string `json:"direction,omitempty"`
+	// Maximum allowed latency in milliseconds
+	MaxLatencyMs int `json:"maxLatencyMs,omitempty"`
+	// Priority level for the generated connections (high, medium, low)
+	// +kubebuilder:validation:Enum=high;medium;low
+	Priority string `json:"priority,omitempty"`
+	// Bandwidth limit in Mbps for each generated connection
+	Bandwidth int `json:"bandwidth,omitempty"`
+	// Connection type to use, derived from the service when empty
+	ConnectionType string `json:"connectionType,omitempty"`
+	// Seconds between rekeys of the generated encrypted connections, 0 for
+	// the node default
+	// +kubebuilder:validation:Minimum=0
+	RekeyIntervalSeconds int `json:"rekeyIntervalSeconds,omitempty"`
+}
+
+// NetworkIntentStatus defines the observed state of a NetworkIntent
+type NetworkIntentStatus struct {
+	// Generation of the intent the status was computed from
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Number of NetworkConnections generated from this intent
+	ConnectionCount int `json:"connectionCount,omitempty"`
+	// Human-readable message about the current status
+	Message string `json:"message,omitempty"`
+	// Current conditions of the intent
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// +genclient
+// +kubebuilder:object:root=true
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+// +kubebuilder:subresource:status
+
+// NetworkIntent is a high level connectivity requirement that is compiled
+// into NetworkConnections, NetworkPolicies and QoS settings
+type NetworkIntent struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   NetworkIntentSpec   `json:"spec,omitempty"`
+	Status NetworkIntentStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// NetworkIntentList contains a list of NetworkIntent
+type NetworkIntentList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []NetworkIntent `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&NetworkIntent{}, &NetworkIntentList{})
+}
@@ -0,0 +1,134 @@
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// NetworkService phases
+const (
+	ServicePhasePending  = "Pending"
+	ServicePhaseReady    = "Ready"
+	ServicePhaseDegraded = "Degraded"
+	ServicePhaseError    = "Error"
+)
+
+// NetworkServiceSpec defines the desired state of a NetworkService
+type NetworkServiceSpec struct {
+	// Type of the service (e.g., l2, l3, vpn)
+	ServiceType string `json:"serviceType"`
+	// Endpoint the service is reachable at (host:port, IP or DNS name),
+	// optional with external endpoints
+	Endpoint string `json:"endpoint,omitempty"`
+	// Priority level for this service (high, medium, low)
+	Priority string `json:"priority,omitempty"`
+	// Maximum allowed latency in milliseconds
+	LatencyRequirement int `json:"latencyRequirement,omitempty"`
+	// Bandwidth reserved for the service in Mbps (0 means best effort)
+	Bandwidth int `json:"bandwidth,omitempty"`
+	// Whether SR-IOV acceleration is required
+	RequireSRIOV bool `json:"requireSRIOV,omitempty"`
+	// Whether DPDK acceleration is required
+	RequireDPDK bool `json:"requireDPDK,omitempty"`
+	// Endpoints outside the cluster (e.g., an on-prem server or a cloud
+	// service) the service fails over between, the healthy one with the
+	// lowest priority value is active
+	ExternalEndpoints []ExternalEndpoint `json:"externalEndpoints,omitempty"`
+	// Health check of the external endpoints, TCP connects by default
+	HealthCheck *HealthCheck `json:"healthCheck,omitempty"`
+}
+
+// ExternalEndpoint is an endpoint of a service outside the cluster
+type ExternalEndpoint struct {
+	// Name of the endpoint, unique within the service
+	Name string `json:"name"`
+	// Address of the endpoint (host:port, IP or DNS name)
+	Address string `json:"address"`
+	// Preference of the endpoint, lower values are preferred
+	// +kubebuilder:validation:Minimum=0
+	Priority int `json:"priority,omitempty"`
+}
+
+// Health check types
+const (
+	// HealthCheckTCP connects to the endpoint
+	HealthCheckTCP = "tcp"
+	// HealthCheckHTTP expects a 2xx or 3xx answer to a GET of the path
+	HealthCheckHTTP = "http"
+)
+
+// HealthCheck probes the external endpoints of a service
+type HealthCheck struct {
+	// Type of the check (tcp, http), defaults to tcp
+	// +kubebuilder:validation:Enum=tcp;http
+	Type string `json:"type,omitempty"`
+	// Port checked on endpoints whose address has none
+	Port int `json:"port,omitempty"`
+	// Path of the http checks, defaults to /
+	Path string `json:"path,omitempty"`
+	// Seconds between checks, defaults to 10
+	IntervalSeconds int `json:"intervalSeconds,omitempty"`
+	// Seconds a check may take, defaults to 2
+	TimeoutSeconds int `json:"timeoutSeconds,omitempty"`
+	// Consecutive failed checks making an endpoint unhealthy, defaults to 3
+	FailureThreshold int `json:"failureThreshold,omitempty"`
+	// Consecutive passed checks making an endpoint healthy, defaults to 1
+	SuccessThreshold int `json:"successThreshold,omitempty"`
+}
+
+// EndpointHealth is the observed health of an external endpoint
+type EndpointHealth struct {
+	// Name of the endpoint
+	Name string `json:"name"`
+	// Address checked
+	Address string `json:"address"`
+	// Whether the endpoint is healthy
+	Healthy bool `json:"healthy"`
+	// Last time the endpoint became healthy or unhealthy
+	LastTransitionTime *metav1.Time `json:"lastTransitionTime,omitempty"`
+	// Why the last check failed, empty if it passed
+	Message string `json:"message,omitempty"`
+}
+
+// NetworkServiceStatus defines the observed state of a NetworkService
+type NetworkServiceStatus struct {
+	// Current phase of the network service
+	Phase string `json:"phase,omitempty"`
+	// Human-readable message about the current status
+	Message string `json:"message,omitempty"`
+	// Number of connections using this service
+	ConnectionCount int `json:"connectionCount,omitempty"`
+	// External endpoint currently serving the service
+	ActiveEndpoint string `json:"activeEndpoint,omitempty"`
+	// Health of the external endpoints
+	Endpoints []EndpointHealth `json:"endpoints,omitempty"`
+	// Current conditions of the network service
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// +genclient
+// +kubebuilder:object:root=true
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+// +kubebuilder:subresource:status
+
+// NetworkService is a network service offered at the edge
+type NetworkService struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   NetworkServiceSpec   `json:"spec,omitempty"`
+	Status NetworkServiceStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// NetworkServiceList contains a list of NetworkService
+type NetworkServiceList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []NetworkService `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&NetworkService{}, &NetworkServiceList{})
+}
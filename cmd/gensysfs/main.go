@@ -0,0 +1,61 @@
+// gensysfs captures the sysfs subtree of a node the NSM hardware discovery
+// reads into a sanitized fixture. Attach the fixture to a bug report about
+// the discovery of a NIC, it reproduces the discovery without the hardware:
+//
+//	gensysfs -o node.json
+//
+// MAC addresses and switch/port IDs are replaced by pseudonyms.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/akos011221/nsm/pkg/sysfsfixture"
+)
+
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// run captures the fixture and writes it out
+func run(args []string) error {
+	fs := flag.NewFlagSet("gensysfs", flag.ContinueOnError)
+	root := fs.String("root", "/", "root of the filesystem sysfs is mounted under")
+	output := fs.String("o", "-", "file to write the fixture to, - for stdout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments: %v", fs.Args())
+	}
+
+	fixture, err := sysfsfixture.Capture(*root)
+	if err != nil {
+		return err
+	}
+
+	out := os.Stdout
+	if *output != "-" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return fmt.Errorf("failed to create %s: %w", *output, err)
+		}
+		defer f.Close()
+		out = f
+	}
+	if err := fixture.Write(out); err != nil {
+		return fmt.Errorf("failed to write the fixture: %w", err)
+	}
+	if *output != "-" {
+		if err := out.Close(); err != nil {
+			return fmt.Errorf("failed to write %s: %w", *output, err)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Captured %d sysfs entries\n", len(fixture.Entries))
+	return nil
+}
@@ -0,0 +1,413 @@
+// nsm-cni moves the VF allocated to a pod by the NSM controller into the
+// network namespace of the pod
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/akos011221/nsm/pkg/cni"
+	"github.com/akos011221/nsm/pkg/netutil"
+	"github.com/containernetworking/cni/pkg/invoke"
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/types"
+	current "github.com/containernetworking/cni/pkg/types/100"
+	"github.com/containernetworking/cni/pkg/version"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+)
+
+// defaultSocket is the default CNI socket of the controller
+const defaultSocket = "/var/run/nsm/cni.sock"
+
+// requestTimeout bounds the wait for the controller to allocate the VF
+const requestTimeout = 30 * time.Second
+
+// cacheDir keeps what ADD changed on the host, for DEL to undo it
+const cacheDir = "/var/lib/cni/nsm"
+
+// vfCache is the MAC a VF had on its PF before ADD replaced it
+type vfCache struct {
+	// PF the VF belongs to
+	PFName string `json:"pfName"`
+	// VF ID on the PF
+	VFID int `json:"vfId"`
+	// MAC of the VF on the PF before ADD, empty if it had none
+	MAC string `json:"mac,omitempty"`
+}
+
+// NetConf is the network configuration of the plugin
+type NetConf struct {
+	types.NetConf
+
+	// CNI socket of the controller
+	Socket string `json:"socket,omitempty"`
+	// MTU of the VF in the pod, 0 to keep the MTU of the PF
+	MTU int `json:"mtu,omitempty"`
+	// MAC address of the VF, overridden by the runtime
+	MAC string `json:"mac,omitempty"`
+	// Capabilities passed by the runtime
+	RuntimeConfig struct {
+		MAC string `json:"mac,omitempty"`
+	} `json:"runtimeConfig,omitempty"`
+}
+
+// podArgs are the CNI_ARGS the kubelet passes
+type podArgs struct {
+	types.CommonArgs
+	K8S_POD_NAMESPACE types.UnmarshallableString
+	K8S_POD_NAME      types.UnmarshallableString
+}
+
+func main() {
+	skel.PluginMainFuncs(skel.CNIFuncs{
+		Add:   cmdAdd,
+		Del:   cmdDel,
+		Check: cmdCheck,
+	}, version.All, "nsm-cni: moves NSM allocated SR-IOV VFs into pods")
+}
+
+// loadNetConf parses the network configuration
+func loadNetConf(data []byte) (*NetConf, error) {
+	conf := &NetConf{Socket: defaultSocket}
+	if err := json.Unmarshal(data, conf); err != nil {
+		return nil, fmt.Errorf("failed to parse the network configuration: %w", err)
+	}
+	if conf.RuntimeConfig.MAC != "" {
+		conf.MAC = conf.RuntimeConfig.MAC
+	}
+	if conf.MAC != "" {
+		if _, err := net.ParseMAC(conf.MAC); err != nil {
+			return nil, fmt.Errorf("invalid MAC address %q: %w", conf.MAC, err)
+		}
+	}
+	if conf.MTU < 0 {
+		return nil, fmt.Errorf("invalid MTU %d", conf.MTU)
+	}
+	return conf, nil
+}
+
+// cmdAdd moves the VF of the pod into its network namespace, named as the
+// runtime asks, with the configured MAC, MTU and the addresses of the IPAM
+// plugin. The host name of the VF is kept in its alias to restore it on
+// deletion.
+func cmdAdd(args *skel.CmdArgs) error {
+	conf, err := loadNetConf(args.StdinData)
+	if err != nil {
+		return err
+	}
+	pod := podArgs{}
+	if err := types.LoadArgs(args.Args, &pod); err != nil {
+		return fmt.Errorf("failed to parse CNI_ARGS: %w", err)
+	}
+	if pod.K8S_POD_NAMESPACE == "" || pod.K8S_POD_NAME == "" {
+		return fmt.Errorf("K8S_POD_NAMESPACE and K8S_POD_NAME are required in CNI_ARGS")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	vf, err := cni.NewClient(conf.Socket).RequestVF(ctx, string(pod.K8S_POD_NAMESPACE), string(pod.K8S_POD_NAME), args.Netns, args.IfName)
+	if err != nil {
+		return err
+	}
+	if vf.InterfaceName == "" {
+		return fmt.Errorf("VF %s of pod %s/%s isn't bound to a network driver", vf.PCIAddress, pod.K8S_POD_NAMESPACE, pod.K8S_POD_NAME)
+	}
+
+	link, err := netlink.LinkByName(vf.InterfaceName)
+	if err != nil {
+		return fmt.Errorf("failed to find the interface %s of VF %s: %w", vf.InterfaceName, vf.PCIAddress, err)
+	}
+	if err := netlink.LinkSetDown(link); err != nil {
+		return fmt.Errorf("failed to set %s down: %w", vf.InterfaceName, err)
+	}
+	if err := netlink.LinkSetAlias(link, vf.InterfaceName); err != nil {
+		return fmt.Errorf("failed to record the host name of %s: %w", vf.InterfaceName, err)
+	}
+	if conf.MAC != "" {
+		if err := setMAC(netutil.NewNetlink(), args, vf, conf.MAC); err != nil {
+			return err
+		}
+	}
+
+	podNS, err := netns.GetFromPath(args.Netns)
+	if err != nil {
+		return fmt.Errorf("failed to open the network namespace %s: %w", args.Netns, err)
+	}
+	defer podNS.Close()
+	if err := netlink.LinkSetNsFd(link, int(podNS)); err != nil {
+		return fmt.Errorf("failed to move %s into the network namespace of the pod: %w", vf.InterfaceName, err)
+	}
+
+	result, err := configurePod(args, conf, vf, podNS)
+	if err != nil {
+		// hand the VF back to the host for the next attempt
+		if restoreErr := restoreVF(podNS, args.IfName, vf.InterfaceName); restoreErr != nil {
+			return fmt.Errorf("%w, and failed to restore VF %s to the host: %v", err, vf.PCIAddress, restoreErr)
+		}
+		return err
+	}
+	return types.PrintResult(result, conf.CNIVersion)
+}
+
+// setMAC sets the MAC address of a VF on its PF, so it survives driver
+// reloads in the pod, and on the interface. The MAC the VF had before is
+// cached for DEL to restore, so the next pod doesn't inherit this one's.
+func setMAC(nl netutil.Interface, args *skel.CmdArgs, vf *cni.VFResponse, mac string) error {
+	vfs, err := nl.VFList(vf.PFName)
+	if err != nil {
+		return fmt.Errorf("failed to list the VFs of %s: %w", vf.PFName, err)
+	}
+	cache := vfCache{PFName: vf.PFName, VFID: vf.VFID}
+	for _, info := range vfs {
+		if info.ID == vf.VFID {
+			cache.MAC = info.MAC
+		}
+	}
+	if err := saveCache(args, cache); err != nil {
+		return err
+	}
+
+	if err := nl.VFSetMAC(vf.PFName, vf.VFID, mac); err != nil {
+		return fmt.Errorf("failed to set the MAC of VF %d on %s: %w", vf.VFID, vf.PFName, err)
+	}
+	if err := nl.LinkSetHardwareAddr(vf.InterfaceName, mac); err != nil {
+		return fmt.Errorf("failed to set the MAC of %s: %w", vf.InterfaceName, err)
+	}
+	return nil
+}
+
+// restoreMAC gives a VF the MAC it had on its PF before ADD, if ADD set one
+func restoreMAC(nl netutil.Interface, args *skel.CmdArgs) error {
+	path := cachePath(args)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	var cache vfCache
+	if err := json.Unmarshal(data, &cache); err != nil {
+		return fmt.Errorf("failed to decode %s: %w", path, err)
+	}
+
+	// the zero address clears the MAC
+	mac := cache.MAC
+	if mac == "" {
+		mac = "00:00:00:00:00:00"
+	}
+	if err := nl.VFSetMAC(cache.PFName, cache.VFID, mac); err != nil && !errors.Is(err, netutil.ErrNotFound) {
+		return fmt.Errorf("failed to restore the MAC of VF %d on %s: %w", cache.VFID, cache.PFName, err)
+	}
+	return os.Remove(path)
+}
+
+// cachePath is where the changes of ADD for a container interface are kept
+func cachePath(args *skel.CmdArgs) string {
+	return filepath.Join(cacheDir, args.ContainerID+"-"+args.IfName)
+}
+
+// saveCache keeps what ADD changed for a container interface
+func saveCache(args *skel.CmdArgs, cache vfCache) error {
+	data, err := json.Marshal(cache)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(cacheDir, 0o700); err != nil {
+		return fmt.Errorf("failed to create %s: %w", cacheDir, err)
+	}
+	if err := os.WriteFile(cachePath(args), data, 0o600); err != nil {
+		return fmt.Errorf("failed to cache the MAC of the VF: %w", err)
+	}
+	return nil
+}
+
+// configurePod names, addresses and sets up the VF moved into the pod
+func configurePod(args *skel.CmdArgs, conf *NetConf, vf *cni.VFResponse, podNS netns.NsHandle) (*current.Result, error) {
+	h, err := netlink.NewHandleAt(podNS)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open netlink in the network namespace of the pod: %w", err)
+	}
+	defer h.Close()
+
+	link, err := h.LinkByName(vf.InterfaceName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find %s in the network namespace of the pod: %w", vf.InterfaceName, err)
+	}
+	if err := h.LinkSetName(link, args.IfName); err != nil {
+		return nil, fmt.Errorf("failed to rename %s to %s: %w", vf.InterfaceName, args.IfName, err)
+	}
+	if conf.MTU > 0 {
+		if err := h.LinkSetMTU(link, conf.MTU); err != nil {
+			return nil, fmt.Errorf("failed to set the MTU of %s to %d: %w", args.IfName, conf.MTU, err)
+		}
+	}
+
+	result := &current.Result{
+		CNIVersion: current.ImplementedSpecVersion,
+		Interfaces: []*current.Interface{{
+			Name:    args.IfName,
+			Mac:     link.Attrs().HardwareAddr.String(),
+			Mtu:     conf.MTU,
+			Sandbox: args.Netns,
+			PciID:   vf.PCIAddress,
+		}},
+	}
+	if conf.IPAM.Type != "" {
+		ipam, err := invoke.DelegateAdd(context.Background(), conf.IPAM.Type, args.StdinData, nil)
+		if err != nil {
+			return nil, fmt.Errorf("IPAM plugin %s failed: %w", conf.IPAM.Type, err)
+		}
+		ipamResult, err := current.NewResultFromResult(ipam)
+		if err != nil {
+			invoke.DelegateDel(context.Background(), conf.IPAM.Type, args.StdinData, nil)
+			return nil, fmt.Errorf("invalid result of IPAM plugin %s: %w", conf.IPAM.Type, err)
+		}
+		if err := addAddresses(h, link, ipamResult); err != nil {
+			invoke.DelegateDel(context.Background(), conf.IPAM.Type, args.StdinData, nil)
+			return nil, err
+		}
+		zero := 0
+		for _, ip := range ipamResult.IPs {
+			ip.Interface = &zero
+		}
+		result.IPs = ipamResult.IPs
+		result.Routes = ipamResult.Routes
+		result.DNS = ipamResult.DNS
+	}
+
+	if err := h.LinkSetUp(link); err != nil {
+		return nil, fmt.Errorf("failed to set %s up: %w", args.IfName, err)
+	}
+	return result, nil
+}
+
+// addAddresses assigns the addresses and routes of an IPAM result to the VF
+func addAddresses(h *netlink.Handle, link netlink.Link, ipam *current.Result) error {
+	for _, ip := range ipam.IPs {
+		addr := &netlink.Addr{IPNet: &net.IPNet{IP: ip.Address.IP, Mask: ip.Address.Mask}}
+		if err := h.AddrAdd(link, addr); err != nil {
+			return fmt.Errorf("failed to add address %s: %w", ip.Address.String(), err)
+		}
+	}
+	for _, route := range ipam.Routes {
+		gw := route.GW
+		if gw == nil {
+			for _, ip := range ipam.IPs {
+				if (ip.Address.IP.To4() == nil) == (route.Dst.IP.To4() == nil) {
+					gw = ip.Gateway
+					break
+				}
+			}
+		}
+		dst := route.Dst
+		if err := h.RouteAdd(&netlink.Route{LinkIndex: link.Attrs().Index, Dst: &dst, Gw: gw}); err != nil {
+			return fmt.Errorf("failed to add route to %s: %w", dst.String(), err)
+		}
+	}
+	return nil
+}
+
+// cmdDel moves the VF of the pod back to the host under its host name and
+// restores the MAC it had on its PF. The runtime may call it repeatedly and
+// after the namespace is gone.
+func cmdDel(args *skel.CmdArgs) error {
+	conf, err := loadNetConf(args.StdinData)
+	if err != nil {
+		return err
+	}
+	if conf.IPAM.Type != "" {
+		if err := invoke.DelegateDel(context.Background(), conf.IPAM.Type, args.StdinData, nil); err != nil {
+			return fmt.Errorf("IPAM plugin %s failed: %w", conf.IPAM.Type, err)
+		}
+	}
+	if err := restoreMAC(netutil.NewNetlink(), args); err != nil {
+		return err
+	}
+	if args.Netns == "" {
+		return nil
+	}
+
+	podNS, err := netns.GetFromPath(args.Netns)
+	if err != nil {
+		// the kernel returns the VFs of deleted namespaces to the host
+		return nil
+	}
+	defer podNS.Close()
+	return restoreVF(podNS, args.IfName, "")
+}
+
+// restoreVF moves the VF named ifName in the pod namespace back to the
+// host, renamed to hostName or to its alias if empty
+func restoreVF(podNS netns.NsHandle, ifName, hostName string) error {
+	h, err := netlink.NewHandleAt(podNS)
+	if err != nil {
+		return fmt.Errorf("failed to open netlink in the network namespace of the pod: %w", err)
+	}
+	defer h.Close()
+
+	link, err := h.LinkByName(ifName)
+	if err != nil {
+		var notFound netlink.LinkNotFoundError
+		if errors.As(err, &notFound) {
+			return nil
+		}
+		return fmt.Errorf("failed to find %s: %w", ifName, err)
+	}
+	if hostName == "" {
+		hostName = link.Attrs().Alias
+	}
+	if err := h.LinkSetDown(link); err != nil {
+		return fmt.Errorf("failed to set %s down: %w", ifName, err)
+	}
+	if hostName != "" && hostName != ifName {
+		if err := h.LinkSetName(link, hostName); err != nil {
+			return fmt.Errorf("failed to rename %s to %s: %w", ifName, hostName, err)
+		}
+	}
+
+	hostNS, err := netns.Get()
+	if err != nil {
+		return fmt.Errorf("failed to open the host network namespace: %w", err)
+	}
+	defer hostNS.Close()
+	if err := h.LinkSetNsFd(link, int(hostNS)); err != nil {
+		return fmt.Errorf("failed to move %s back to the host: %w", ifName, err)
+	}
+	return nil
+}
+
+// cmdCheck verifies the VF is still in the pod, named as the runtime asked
+func cmdCheck(args *skel.CmdArgs) error {
+	if _, err := loadNetConf(args.StdinData); err != nil {
+		return err
+	}
+	podNS, err := netns.GetFromPath(args.Netns)
+	if err != nil {
+		return fmt.Errorf("failed to open the network namespace %s: %w", args.Netns, err)
+	}
+	defer podNS.Close()
+
+	h, err := netlink.NewHandleAt(podNS)
+	if err != nil {
+		return fmt.Errorf("failed to open netlink in the network namespace of the pod: %w", err)
+	}
+	defer h.Close()
+
+	link, err := h.LinkByName(args.IfName)
+	if err != nil {
+		return fmt.Errorf("interface %s of the VF is missing: %w", args.IfName, err)
+	}
+	if link.Attrs().OperState == netlink.OperDown {
+		return fmt.Errorf("interface %s of the VF is down", args.IfName)
+	}
+	return nil
+}
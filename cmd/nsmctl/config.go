@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/akos011221/nsm/pkg/config"
+	"sigs.k8s.io/yaml"
+)
+
+// validateConfig loads a config file as the controller would, merged with
+// the defaults and the NSM_* environment, and prints the effective config
+// without starting anything
+func validateConfig(args []string) error {
+	fs := flag.NewFlagSet("--validate-config", flag.ContinueOnError)
+	format := fs.String("format", "", "output format: json, yaml (default the format of the file)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: nsmctl --validate-config [--format json|yaml] <file>")
+	}
+	path := fs.Arg(0)
+
+	cfg, err := config.LoadConfig(path)
+	if err != nil {
+		return fmt.Errorf("invalid config %s: %w", path, err)
+	}
+
+	if *format == "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		*format = "json"
+		if config.IsYAML(path, data) {
+			*format = "yaml"
+		}
+	}
+	switch *format {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(cfg)
+	case "yaml":
+		data, err := yaml.Marshal(cfg)
+		if err != nil {
+			return fmt.Errorf("failed to encode config: %w", err)
+		}
+		_, err = os.Stdout.Write(data)
+		return err
+	default:
+		return fmt.Errorf("invalid format %q, must be json or yaml", *format)
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/akos011221/nsm/pkg/adopt"
+	"github.com/akos011221/nsm/pkg/bulk"
+	"github.com/akos011221/nsm/pkg/connection"
+	"github.com/akos011221/nsm/pkg/traffic"
+)
+
+// connectionList prints the connections with their state, node and
+// dataplane, and why the ones not established aren't
+func connectionList(c *apiClient, args []string) error {
+	fs := flag.NewFlagSet("connection list", flag.ContinueOnError)
+	namespace := fs.String("namespace", "", "namespace of the connections (default all namespaces)")
+	node := fs.String("node", "", "only the connections set up on this node")
+	format := fs.String("format", "table", "output format: table, json")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *format != "table" && *format != "json" {
+		return fmt.Errorf("invalid format %q, must be table or json", *format)
+	}
+
+	if err := c.supports("GET /v1/connections"); err != nil {
+		return err
+	}
+	query := url.Values{}
+	if *namespace != "" {
+		query.Set("namespace", *namespace)
+	}
+	if *node != "" {
+		query.Set("node", *node)
+	}
+	path := "/v1/connections"
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+	var conns []connection.Summary
+	if err := c.get(path, &conns); err != nil {
+		return err
+	}
+	if *format == "json" {
+		return printJSON(conns)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CONNECTION	TYPE	STATE	NODE	DATAPATH	DATAPLANE	PATH	LATENCY	MESSAGE")
+	for _, conn := range conns {
+		latency := "-"
+		if conn.LatencyMs > 0 {
+			latency = fmt.Sprintf("%dms", conn.LatencyMs)
+		}
+		message := "-"
+		if !conn.Established && conn.Message != "" {
+			message = conn.Message
+		}
+		fmt.Fprintf(w, "%s/%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", conn.Namespace, conn.Name, conn.ConnectionType,
+			orDash(conn.State), orDash(conn.Node), orDash(conn.Datapath), orDash(conn.Dataplane), orDash(conn.ActivePath), latency, message)
+	}
+	return w.Flush()
+}
+
+// connectionBulk applies a bulk operation to the connections matching a selector
+func connectionBulk(c *apiClient, args []string) error {
+	fs := flag.NewFlagSet("connection bulk", flag.ContinueOnError)
+	namespace := fs.String("namespace", "", "namespace of the connections (default all namespaces)")
+	selector := fs.String("selector", "", "label selector of the connections (e.g., tier=bulk)")
+	op := fs.String("op", "", "operation: set-priority, reroute, label, unlabel, pause, resume")
+	value := fs.String("value", "", "operation argument")
+	dryRun := fs.Bool("dry-run", false, "only show what would change")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	req := bulk.Request{
+		Namespace: *namespace,
+		Selector:  *selector,
+		Operation: *op,
+		Value:     *value,
+		DryRun:    *dryRun,
+	}
+
+	if err := c.supports("POST /v1/connections/bulk"); err != nil {
+		return err
+	}
+	var result bulk.Result
+	if err := c.post("/v1/connections/bulk", req, &result); err != nil {
+		return err
+	}
+
+	verb := "updated"
+	if *dryRun {
+		verb = "would update"
+	}
+	fmt.Printf("%d connections matched, %s %d\n", result.Matched, verb, len(result.Updated))
+	for _, name := range result.Updated {
+		fmt.Printf("  %s\n", name)
+	}
+
+	if len(result.Failed) > 0 {
+		failed := make([]string, 0, len(result.Failed))
+		for name := range result.Failed {
+			failed = append(failed, name)
+		}
+		sort.Strings(failed)
+
+		fmt.Printf("%d connections failed:\n", len(failed))
+		for _, name := range failed {
+			fmt.Printf("  %s: %s\n", name, result.Failed[name])
+		}
+		return fmt.Errorf("bulk operation partially failed")
+	}
+
+	return nil
+}
+
+// connectionImport adopts the tunnels, VLANs and static routes configured
+// on the node of the controller outside NSM as connections. It only shows
+// the connections and their diff unless --apply is given.
+func connectionImport(c *apiClient, args []string) error {
+	fs := flag.NewFlagSet("connection import", flag.ContinueOnError)
+	namespace := fs.String("namespace", "default", "namespace the connections are created in")
+	fs.StringVar(namespace, "n", "default", "namespace the connections are created in (shorthand)")
+	apply := fs.Bool("apply", false, "create and update the connections instead of showing the diff")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if err := c.supports("POST /v1/connections/import"); err != nil {
+		return err
+	}
+	var result adopt.Result
+	if err := c.post("/v1/connections/import", adopt.Request{Namespace: *namespace, DryRun: !*apply}, &result); err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, candidate := range result.Candidates {
+		conn := candidate.Connection
+		if candidate.Error != "" {
+			failed++
+			fmt.Printf("%s/%s from %s: %s\n", conn.Namespace, conn.Name, candidate.Object.Key(), candidate.Error)
+			continue
+		}
+		fmt.Printf("%s/%s from %s: %s\n", conn.Namespace, conn.Name, candidate.Object.Key(), candidate.Action)
+		if result.DryRun && candidate.Diff != "" {
+			for _, line := range strings.Split(strings.TrimSuffix(candidate.Diff, "\n"), "\n") {
+				fmt.Printf("    %s\n", line)
+			}
+		}
+	}
+	for _, skipped := range result.Skipped {
+		fmt.Printf("skipped %s: %s\n", skipped.Object.Key(), skipped.Reason)
+	}
+	if len(result.Candidates) == 0 && len(result.Skipped) == 0 {
+		fmt.Printf("Nothing configured outside NSM on node %s\n", result.Node)
+	}
+	if result.DryRun && len(result.Candidates) > 0 {
+		fmt.Println("Dry run, rerun with --apply to import the connections")
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d connections can't be imported", failed)
+	}
+	return nil
+}
+
+// connectionTrafficTest sends test traffic over a connection and prints
+// what it sustained
+func connectionTrafficTest(c *apiClient, args []string) error {
+	fs := flag.NewFlagSet("connection traffic-test", flag.ContinueOnError)
+	namespace := fs.String("namespace", "default", "namespace of the connection")
+	fs.StringVar(namespace, "n", "default", "namespace of the connection (shorthand)")
+	rate := fs.Int("rate", 0, "offered rate in Mbps")
+	size := fs.Int("size", 0, "UDP payload size in bytes (default 1000)")
+	duration := fs.Int("duration", 0, "duration in seconds (default 10)")
+	target := fs.String("target", "", "reflector (host:port), defaults to the probe target host of the connection")
+
+	// the connection name may come before or after the flags
+	var name string
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if name == "" {
+		name = fs.Arg(0)
+	}
+	if name == "" || *rate <= 0 {
+		return fmt.Errorf("usage: nsmctl connection traffic-test <name> --rate MBPS [--namespace NAMESPACE] [--size BYTES] [--duration SECONDS] [--target HOST:PORT]")
+	}
+
+	req := traffic.Request{
+		RateMbps:    *rate,
+		PacketSize:  *size,
+		DurationSec: *duration,
+		Target:      *target,
+	}
+
+	if err := c.supports("POST /v1/connections/{namespace}/{name}/traffic-test"); err != nil {
+		return err
+	}
+	// the response only comes once the test is over
+	c.http.Timeout = time.Duration(max(*duration, 10))*time.Second + 30*time.Second
+	var report traffic.Report
+	if err := c.post("/v1/connections/"+url.PathEscape(*namespace)+"/"+url.PathEscape(name)+"/traffic-test", req, &report); err != nil {
+		return err
+	}
+
+	res := report.Result
+	fmt.Printf("Traffic test on %s to %s: %d Mbps of %d byte packets for %ds\n",
+		report.Connection, report.Target, res.RateMbps, res.PacketSize, res.DurationSec)
+	fmt.Printf("  sent %d, received %d, loss %d ppm\n", res.Sent, res.Received, res.PacketLossPPM)
+	fmt.Printf("  throughput %d kbps\n", res.ThroughputKbps)
+	fmt.Printf("  latency avg %dus, max %dus, jitter %dus\n", res.AvgLatencyUs, res.MaxLatencyUs, res.JitterUs)
+	if report.SaveError != "" {
+		fmt.Printf("warning: result not saved in the diagnostics history: %s\n", report.SaveError)
+	}
+	if !res.Passed {
+		return fmt.Errorf("traffic test failed: %s", res.Message)
+	}
+	fmt.Printf("Passed: %s\n", res.Message)
+	return nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/akos011221/nsm/pkg/hardware"
+)
+
+// explainPod prints the allocation decisions on a pod
+func explainPod(c *apiClient, args []string) error {
+	fs := flag.NewFlagSet("explain pod", flag.ContinueOnError)
+	namespace := fs.String("namespace", "default", "namespace of the pod")
+	fs.StringVar(namespace, "n", "default", "namespace of the pod (shorthand)")
+
+	// the pod name may come before or after the flags
+	var name string
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if name == "" {
+		name = fs.Arg(0)
+	}
+	if name == "" {
+		return fmt.Errorf("usage: nsmctl explain pod <name> [--namespace NAMESPACE]")
+	}
+
+	if err := c.supports("GET /v1/explain/pods/{namespace}/{name}"); err != nil {
+		return err
+	}
+	var exp hardware.Explanation
+	if err := c.get("/v1/explain/pods/"+url.PathEscape(*namespace)+"/"+url.PathEscape(name), &exp); err != nil {
+		return err
+	}
+
+	if exp.Allocated {
+		fmt.Printf("Pod %s/%s holds a VF\n", exp.Namespace, exp.Pod)
+	} else {
+		fmt.Printf("Pod %s/%s holds no VF\n", exp.Namespace, exp.Pod)
+	}
+	for _, d := range exp.Decisions {
+		fmt.Printf("  %s  %-16s %s", d.LastSeen.Local().Format(time.RFC3339), d.Reason, d.Message)
+		if d.PCIAddress != "" {
+			fmt.Printf(" [%s]", d.PCIAddress)
+		}
+		if !d.Since.Equal(d.LastSeen) {
+			fmt.Printf(" (since %s)", d.Since.Local().Format(time.RFC3339))
+		}
+		fmt.Println()
+	}
+	return nil
+}
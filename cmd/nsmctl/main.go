@@ -0,0 +1,281 @@
+// nsmctl inspects and manages NSM state through the controller's management API
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/akos011221/nsm/pkg/api"
+)
+
+// defaultServer is the default address of the management API
+const defaultServer = "http://127.0.0.1:9090"
+
+// usage is printed for unknown or missing commands
+const usage = `Usage: nsmctl [--server URL] [--token TOKEN] <command> [flags]
+       nsmctl --validate-config [--format json|yaml] <file>
+
+Commands:
+  connection list   List the connections with their state, node and dataplane
+  connection bulk   Apply an operation to all connections matching a selector
+  connection import Adopt the tunnels, VLANs and static routes configured outside NSM
+                    as connections, showing the diff unless --apply is given
+  connection traffic-test
+                    Send test traffic over a connection and measure it
+  explain pod       Explain why a pod did or didn't get a VF
+  security posture  Report the encryption and policy compliance of the connections by site
+  service status    Show the phase and connections of the NetworkServices
+  vf list           List the VFs of the node and the pods they are allocated to
+  vf release        Free the VFs allocated to a pod (<namespace>/<pod>)
+
+--validate-config checks a JSON or YAML controller config file, merged with
+the NSM_* environment, and prints the effective config.
+
+The server defaults to $NSM_SERVER or ` + defaultServer + `, the token
+the commands changing state need defaults to $NSM_API_TOKEN.
+`
+
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// run parses the global flags and dispatches to the command
+func run(args []string) error {
+	// a config file is validated without the controller
+	if len(args) > 0 && args[0] == "--validate-config" {
+		return validateConfig(args[1:])
+	}
+
+	server := os.Getenv("NSM_SERVER")
+	if server == "" {
+		server = defaultServer
+	}
+
+	token := os.Getenv("NSM_API_TOKEN")
+
+	for len(args) >= 2 && (args[0] == "--server" || args[0] == "--token") {
+		if args[0] == "--server" {
+			server = args[1]
+		} else {
+			token = args[1]
+		}
+		args = args[2:]
+	}
+
+	c := &apiClient{
+		server: server,
+		token:  token,
+		http:   &http.Client{Timeout: 30 * time.Second},
+	}
+
+	if len(args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		return fmt.Errorf("missing command")
+	}
+
+	switch args[0] + " " + args[1] {
+	case "connection list":
+		return connectionList(c, args[2:])
+	case "connection bulk":
+		return connectionBulk(c, args[2:])
+	case "connection import":
+		return connectionImport(c, args[2:])
+	case "connection traffic-test":
+		return connectionTrafficTest(c, args[2:])
+	case "explain pod":
+		return explainPod(c, args[2:])
+	case "security posture":
+		return securityPosture(c, args[2:])
+	case "service status":
+		return serviceStatus(c, args[2:])
+	case "vf list":
+		return vfList(c, args[2:])
+	case "vf release":
+		return vfRelease(c, args[2:])
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		return fmt.Errorf("unknown command: %s %s", args[0], args[1])
+	}
+}
+
+// apiClient talks to the management API
+type apiClient struct {
+	// Base URL of the management API
+	server string
+	// Bearer token of the operations changing state, empty for none
+	token string
+	// HTTP client
+	http *http.Client
+	// Versions and operations served by the controller, nil until negotiated
+	versions *api.VersionInfo
+}
+
+// negotiate fetches the versions and operations the controller serves.
+// Controllers predating the negotiation serve v1 without listing their
+// operations, every operation is attempted on them.
+func (c *apiClient) negotiate() (*api.VersionInfo, error) {
+	if c.versions != nil {
+		return c.versions, nil
+	}
+
+	resp, err := c.http.Get(c.server + api.VersionsPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach the NSM controller: %w", err)
+	}
+	defer resp.Body.Close()
+
+	info := &api.VersionInfo{Versions: []string{api.V1}, Preferred: api.V1}
+	if resp.StatusCode != http.StatusNotFound {
+		if err := decodeResponse(resp, info); err != nil {
+			return nil, err
+		}
+	}
+	c.versions = info
+	return info, nil
+}
+
+// supports checks that the controller serves an operation of the current
+// version (e.g., "POST /v1/drills"), in that version or an older one
+func (c *apiClient) supports(operation string) error {
+	info, err := c.negotiate()
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(info.Versions, api.V1) && !slices.Contains(info.Versions, api.V1Alpha1) {
+		return fmt.Errorf("the NSM controller serves API versions %v, nsmctl needs %s or %s", info.Versions, api.V1, api.V1Alpha1)
+	}
+	if len(info.Operations) > 0 && !slices.Contains(info.Operations, operation) {
+		return fmt.Errorf("the NSM controller doesn't serve %s, it is older than nsmctl or the feature is disabled", operation)
+	}
+	return nil
+}
+
+// url returns the URL of a path of the current version (e.g., "/v1/drills")
+// in the newest version the controller serves
+func (c *apiClient) url(path string) (string, error) {
+	info, err := c.negotiate()
+	if err != nil {
+		return "", err
+	}
+	if !slices.Contains(info.Versions, api.V1) && slices.Contains(info.Versions, api.V1Alpha1) {
+		path = "/" + api.V1Alpha1 + strings.TrimPrefix(path, "/"+api.V1)
+	}
+	return c.server + path, nil
+}
+
+// post sends a JSON request and decodes the JSON response into out
+func (c *apiClient) post(path string, in, out interface{}) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return fmt.Errorf("failed to encode request: %w", err)
+	}
+
+	target, err := c.url(path)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach the NSM controller: %w", err)
+	}
+	defer resp.Body.Close()
+
+	return decodeResponse(resp, out)
+}
+
+// get sends a request and decodes the JSON response into out
+func (c *apiClient) get(path string, out interface{}) error {
+	target, err := c.url(path)
+	if err != nil {
+		return err
+	}
+	resp, err := c.http.Get(target)
+	if err != nil {
+		return fmt.Errorf("failed to reach the NSM controller: %w", err)
+	}
+	defer resp.Body.Close()
+
+	return decodeResponse(resp, out)
+}
+
+// delete sends a DELETE request, for operations without a response body
+func (c *apiClient) delete(path string) error {
+	target, err := c.url(path)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodDelete, target, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach the NSM controller: %w", err)
+	}
+	defer resp.Body.Close()
+
+	return decodeResponse(resp, nil)
+}
+
+// do sends a request changing state with the bearer token
+func (c *apiClient) do(req *http.Request) (*http.Response, error) {
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	return c.http.Do(req)
+}
+
+// printJSON prints a value as indented JSON
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// decodeResponse decodes a successful response or returns the API error.
+// The warnings of deprecated operations are shown on stderr.
+func decodeResponse(resp *http.Response, out interface{}) error {
+	for _, warning := range resp.Header.Values("Warning") {
+		if text, ok := strings.CutPrefix(warning, "299 - "); ok {
+			fmt.Fprintf(os.Stderr, "warning: %s\n", strings.Trim(text, `"`))
+		}
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if resp.StatusCode >= 300 {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(data, &apiErr) == nil && apiErr.Error != "" {
+			return fmt.Errorf("%s", apiErr.Error)
+		}
+		return fmt.Errorf("unexpected response: %s", resp.Status)
+	}
+	if out == nil {
+		return nil
+	}
+
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
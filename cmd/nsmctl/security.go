@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/akos011221/nsm/pkg/posture"
+)
+
+// securityPosture prints the security posture of the connections by site
+func securityPosture(c *apiClient, args []string) error {
+	fs := flag.NewFlagSet("security posture", flag.ContinueOnError)
+	sites := fs.String("site", "", "comma-separated sites (nodes) to report (default all sites)")
+	format := fs.String("format", "table", "output format: table, json, csv")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *format != "table" && *format != "json" && *format != "csv" {
+		return fmt.Errorf("invalid format %q, must be table, json or csv", *format)
+	}
+
+	if err := c.supports("GET /v1/security/posture"); err != nil {
+		return err
+	}
+	query := url.Values{}
+	for _, site := range strings.Split(*sites, ",") {
+		if site = strings.TrimSpace(site); site != "" {
+			query.Add("site", site)
+		}
+	}
+	path := "/v1/security/posture"
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+	var report posture.Report
+	if err := c.get(path, &report); err != nil {
+		return err
+	}
+
+	switch *format {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	case "csv":
+		return posture.WriteCSV(os.Stdout, &report)
+	}
+
+	nonCompliant := 0
+	for _, site := range report.Sites {
+		name := site.Site
+		if name == "" {
+			name = "(not established)"
+		}
+		s := site.Summary
+		fmt.Printf("Site %s: %d connections, %d encrypted (%d offloaded), %d checked on the datapath, %d identity verified, %d compliant\n",
+			name, s.Connections, s.Encrypted, s.Offloaded, s.Verified, s.IdentityVerified, s.Compliant)
+		ciphers := make([]string, 0, len(s.Ciphers))
+		for cipher, count := range s.Ciphers {
+			ciphers = append(ciphers, fmt.Sprintf("%s %d", cipher, count))
+		}
+		sort.Strings(ciphers)
+		if len(ciphers) > 0 {
+			fmt.Printf("  ciphers: %s\n", strings.Join(ciphers, ", "))
+		}
+		for _, conn := range site.Connections {
+			if conn.Compliant {
+				continue
+			}
+			nonCompliant++
+			fmt.Printf("  %s/%s: %s\n", conn.Namespace, conn.Name, strings.Join(conn.Violations, "; "))
+		}
+	}
+	if nonCompliant > 0 {
+		return fmt.Errorf("%d connections don't comply with the security policy", nonCompliant)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/akos011221/nsm/pkg/catalog"
+)
+
+// serviceStatus prints the phase and connections of the NetworkServices,
+// of a single one if given as <namespace>/<name>
+func serviceStatus(c *apiClient, args []string) error {
+	fs := flag.NewFlagSet("service status", flag.ContinueOnError)
+	serviceType := fs.String("type", "", "only the services of this type (e.g., l2, l3, vpn)")
+	format := fs.String("format", "table", "output format: table, json")
+
+	// the service may come before or after the flags
+	var name string
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if name == "" {
+		name = fs.Arg(0)
+	}
+	if *format != "table" && *format != "json" {
+		return fmt.Errorf("invalid format %q, must be table or json", *format)
+	}
+
+	if err := c.supports("GET /v1/services"); err != nil {
+		return err
+	}
+	path := "/v1/services"
+	if *serviceType != "" {
+		path += "?" + url.Values{"serviceType": {*serviceType}}.Encode()
+	}
+	var all []catalog.Entry
+	if err := c.get(path, &all); err != nil {
+		return err
+	}
+	entries := make([]catalog.Entry, 0, len(all))
+	for _, e := range all {
+		if name == "" || e.Namespace+"/"+e.Name == name {
+			entries = append(entries, e)
+		}
+	}
+	if name != "" && len(entries) == 0 {
+		return fmt.Errorf("service %s not found, it may be invalid or not reconciled yet", name)
+	}
+	if *format == "json" {
+		return printJSON(entries)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SERVICE\tTYPE\tPHASE\tENDPOINT\tCONNECTIONS\tESTABLISHED")
+	for _, e := range entries {
+		fmt.Fprintf(w, "%s/%s\t%s\t%s\t%s\t%d\t%d\n", e.Namespace, e.Name, e.ServiceType, e.Phase,
+			orDash(e.Endpoint), e.ConnectionCount, e.Established)
+	}
+	return w.Flush()
+}
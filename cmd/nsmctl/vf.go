@@ -0,0 +1,87 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/akos011221/nsm/pkg/hardware"
+)
+
+// vfList prints the VFs of the node and the pods they are allocated to
+func vfList(c *apiClient, args []string) error {
+	fs := flag.NewFlagSet("vf list", flag.ContinueOnError)
+	pf := fs.String("pf", "", "only the VFs of this PF (e.g., eth0)")
+	allocated := fs.Bool("allocated", false, "only the allocated VFs")
+	format := fs.String("format", "table", "output format: table, json")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *format != "table" && *format != "json" {
+		return fmt.Errorf("invalid format %q, must be table or json", *format)
+	}
+
+	if err := c.supports("GET /v1/hardware/sriov/vfs"); err != nil {
+		return err
+	}
+	var all []hardware.VirtualFunction
+	if err := c.get("/v1/hardware/sriov/vfs", &all); err != nil {
+		return err
+	}
+	vfs := make([]hardware.VirtualFunction, 0, len(all))
+	for _, vf := range all {
+		if (*pf == "" || vf.PFName == *pf) && (!*allocated || vf.Allocated) {
+			vfs = append(vfs, vf)
+		}
+	}
+	if *format == "json" {
+		return printJSON(vfs)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PCI ADDRESS\tPF\tVF\tINTERFACE\tNUMA\tDRIVER\tPOD\tINTERFACE IN POD")
+	for _, vf := range vfs {
+		pod := "-"
+		if vf.Allocated {
+			pod = vf.Namespace + "/" + vf.AllocatedTo
+			if !vf.LeaseExpires.IsZero() {
+				pod += " (leased)"
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%d\t%s\t%s\t%s\n", vf.PCIAddress, vf.PFName, vf.VFID,
+			orDash(vf.InterfaceName), vf.NUMANode, orDash(vf.Driver), pod, orDash(vf.PodInterface))
+	}
+	return w.Flush()
+}
+
+// vfRelease frees the VFs allocated to a pod, e.g., ones left behind by a
+// pod deleted while the controller was down
+func vfRelease(c *apiClient, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: nsmctl vf release <namespace>/<pod>")
+	}
+	namespace, pod, ok := strings.Cut(args[0], "/")
+	if !ok || namespace == "" || pod == "" {
+		return fmt.Errorf("invalid pod %q, must be <namespace>/<pod>", args[0])
+	}
+
+	if err := c.supports("DELETE /v1/hardware/sriov/vfs/{namespace}/{name}"); err != nil {
+		return err
+	}
+	if err := c.delete("/v1/hardware/sriov/vfs/" + url.PathEscape(namespace) + "/" + url.PathEscape(pod)); err != nil {
+		return err
+	}
+	fmt.Printf("Released the VFs of pod %s/%s\n", namespace, pod)
+	return nil
+}
+
+// orDash returns a value, or a dash for an empty one in a table
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
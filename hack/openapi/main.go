@@ -0,0 +1,19 @@
+// openapi writes the OpenAPI document of the management API
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/akos011221/nsm/pkg/controller"
+)
+
+func main() {
+	out, err := json.MarshalIndent(controller.OpenAPI(), "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+	os.Stdout.Write(append(out, '\n'))
+}
@@ -0,0 +1,123 @@
+package admission
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/external"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// serviceType matches the service types, lowercase tokens like l2 or vpn
+var serviceType = regexp.MustCompile(`^[a-z][a-z0-9-]*$`)
+
+// connectionTypes are the datapaths a connection can request
+var connectionTypes = map[string]bool{
+	nsmv1.ConnectionTypeKernel:    true,
+	nsmv1.ConnectionTypeSRIOV:     true,
+	nsmv1.ConnectionTypeDPDK:      true,
+	nsmv1.ConnectionTypeVXLAN:     true,
+	nsmv1.ConnectionTypeWireGuard: true,
+	nsmv1.ConnectionTypeVhostUser: true,
+}
+
+// ValidateConnection checks the spec of a connection. Bandwidths beyond
+// maxBandwidthMbps, the fastest link of the node, can never be served and
+// are rejected; 0 doesn't limit them.
+func ValidateConnection(spec *nsmv1.NetworkConnectionSpec, maxBandwidthMbps int) error {
+	if spec.Source == "" && spec.SourceEndpoint == "" {
+		return fmt.Errorf("source or source endpoint is required")
+	}
+	if spec.Destination == "" && spec.DestinationEndpoint == "" {
+		return fmt.Errorf("destination or destination endpoint is required")
+	}
+	for _, name := range []string{spec.SourceEndpoint, spec.DestinationEndpoint} {
+		if name != "" && len(validation.IsDNS1123Subdomain(name)) > 0 {
+			return fmt.Errorf("invalid endpoint reference %q, must be the name of a NetworkEndpoint", name)
+		}
+	}
+	if !connectionTypes[spec.ConnectionType] {
+		return fmt.Errorf("unknown connection type %q, must be one of: kernel, sriov, dpdk, vxlan, wireguard, vhostuser", spec.ConnectionType)
+	}
+	if spec.Priority < 0 {
+		return fmt.Errorf("priority must not be negative, got %d", spec.Priority)
+	}
+	if spec.LatencyRequirement < 0 {
+		return fmt.Errorf("latency requirement must not be negative, got %d", spec.LatencyRequirement)
+	}
+	if err := validateBandwidth(spec.Bandwidth, maxBandwidthMbps); err != nil {
+		return err
+	}
+	switch spec.AdminState {
+	case "", nsmv1.AdminStateUp, nsmv1.AdminStateDown:
+	default:
+		return fmt.Errorf("invalid admin state %q, must be up or down", spec.AdminState)
+	}
+	switch spec.Encryption {
+	case "", nsmv1.EncryptionIPsec, nsmv1.EncryptionTLS:
+	default:
+		return fmt.Errorf("invalid encryption %q, must be ipsec or tls", spec.Encryption)
+	}
+	if spec.RekeyIntervalSeconds < 0 || spec.EstablishTimeoutSeconds < 0 {
+		return fmt.Errorf("rekey interval and establish timeout must not be negative")
+	}
+	return nil
+}
+
+// ValidateService checks the type, endpoints and reservation of a
+// service, with bandwidths limited like ValidateConnection does
+func ValidateService(spec *nsmv1.NetworkServiceSpec, maxBandwidthMbps int) error {
+	if !serviceType.MatchString(spec.ServiceType) {
+		return fmt.Errorf("invalid service type %q, must be a lowercase token like l2, l3 or vpn", spec.ServiceType)
+	}
+	if spec.Endpoint == "" && len(spec.ExternalEndpoints) == 0 {
+		return fmt.Errorf("endpoint or external endpoints are required")
+	}
+	if spec.Endpoint != "" {
+		if err := validateEndpoint(spec.Endpoint); err != nil {
+			return err
+		}
+	}
+	for _, ep := range spec.ExternalEndpoints {
+		if err := validateEndpoint(ep.Address); err != nil {
+			return fmt.Errorf("external endpoint %s: %w", ep.Name, err)
+		}
+	}
+	if spec.LatencyRequirement < 0 {
+		return fmt.Errorf("latency requirement must not be negative, got %d", spec.LatencyRequirement)
+	}
+	if err := validateBandwidth(spec.Bandwidth, maxBandwidthMbps); err != nil {
+		return err
+	}
+	return external.Validate(spec)
+}
+
+// validateEndpoint checks an endpoint is an IP address or a DNS name,
+// with an optional port
+func validateEndpoint(endpoint string) error {
+	host := endpoint
+	if h, port, err := net.SplitHostPort(endpoint); err == nil {
+		if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+			return fmt.Errorf("invalid endpoint %q: port must be between 1 and 65535", endpoint)
+		}
+		host = h
+	}
+	if net.ParseIP(host) == nil && len(validation.IsDNS1123Subdomain(host)) > 0 {
+		return fmt.Errorf("invalid endpoint %q, must be host:port, an IP address or a DNS name", endpoint)
+	}
+	return nil
+}
+
+// validateBandwidth checks a bandwidth in Mbps fits the fastest link
+func validateBandwidth(bandwidth, maxBandwidthMbps int) error {
+	if bandwidth < 0 {
+		return fmt.Errorf("bandwidth must not be negative, got %d", bandwidth)
+	}
+	if maxBandwidthMbps > 0 && bandwidth > maxBandwidthMbps {
+		return fmt.Errorf("bandwidth of %d Mbps exceeds the %d Mbps of the fastest link of the node", bandwidth, maxBandwidthMbps)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package admission
+
+import (
+	"strings"
+	"testing"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+)
+
+func TestValidateConnection(t *testing.T) {
+	valid := nsmv1.NetworkConnectionSpec{
+		Source:         "edge/camera",
+		Destination:    "vision",
+		ConnectionType: nsmv1.ConnectionTypeSRIOV,
+		Priority:       10,
+		Bandwidth:      10000,
+		Encryption:     nsmv1.EncryptionIPsec,
+	}
+	if err := ValidateConnection(&valid, 25000); err != nil {
+		t.Errorf("ValidateConnection() error = %v", err)
+	}
+	// without a known link speed any bandwidth is admitted
+	huge := valid
+	huge.Bandwidth = 400000
+	if err := ValidateConnection(&huge, 0); err != nil {
+		t.Errorf("ValidateConnection() without a limit error = %v", err)
+	}
+	// NetworkEndpoints stand in for the free-form endpoints
+	referenced := valid
+	referenced.Source, referenced.SourceEndpoint = "", "edge-1.eth1"
+	referenced.Destination, referenced.DestinationEndpoint = "", "edge-2.eth1"
+	if err := ValidateConnection(&referenced, 25000); err != nil {
+		t.Errorf("ValidateConnection() with endpoint references error = %v", err)
+	}
+
+	for name, tt := range map[string]struct {
+		mutate func(*nsmv1.NetworkConnectionSpec)
+		want   string
+	}{
+		"missing source":      {func(s *nsmv1.NetworkConnectionSpec) { s.Source = "" }, "source"},
+		"invalid endpoint":    {func(s *nsmv1.NetworkConnectionSpec) { s.DestinationEndpoint = "edge-2/eth1" }, "endpoint reference"},
+		"unknown type":        {func(s *nsmv1.NetworkConnectionSpec) { s.ConnectionType = "infiniband" }, "unknown connection type"},
+		"negative priority":   {func(s *nsmv1.NetworkConnectionSpec) { s.Priority = -1 }, "priority"},
+		"beyond the link":     {func(s *nsmv1.NetworkConnectionSpec) { s.Bandwidth = 40000 }, "exceeds"},
+		"negative bandwidth":  {func(s *nsmv1.NetworkConnectionSpec) { s.Bandwidth = -5 }, "bandwidth"},
+		"unknown admin state": {func(s *nsmv1.NetworkConnectionSpec) { s.AdminState = "paused" }, "admin state"},
+		"unknown encryption":  {func(s *nsmv1.NetworkConnectionSpec) { s.Encryption = "macsec" }, "encryption"},
+	} {
+		spec := valid
+		tt.mutate(&spec)
+		err := ValidateConnection(&spec, 25000)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: ValidateConnection() error = %v, want it to mention %q", name, err, tt.want)
+		}
+	}
+}
+
+func TestValidateService(t *testing.T) {
+	for name, spec := range map[string]nsmv1.NetworkServiceSpec{
+		"endpoint": {ServiceType: "l3", Endpoint: "10.0.0.5:8080", Bandwidth: 1000},
+		"dns name": {ServiceType: "vpn", Endpoint: "upf.edge.svc"},
+		"external": {ServiceType: "l3", ExternalEndpoints: []nsmv1.ExternalEndpoint{
+			{Name: "onprem", Address: "10.20.0.5:443"},
+		}},
+	} {
+		if err := ValidateService(&spec, 10000); err != nil {
+			t.Errorf("%s: ValidateService() error = %v", name, err)
+		}
+	}
+
+	for name, spec := range map[string]nsmv1.NetworkServiceSpec{
+		"missing endpoint":     {ServiceType: "l3"},
+		"invalid external":     {ServiceType: "l3", ExternalEndpoints: []nsmv1.ExternalEndpoint{{Name: "onprem", Address: "not a host:443"}}},
+		"negative latency":     {ServiceType: "l3", Endpoint: "10.0.0.5", LatencyRequirement: -1},
+		"reservation too high": {ServiceType: "l3", Endpoint: "10.0.0.5", Bandwidth: 40000},
+	} {
+		if err := ValidateService(&spec, 10000); err == nil {
+			t.Errorf("%s: ValidateService() accepted %+v", name, spec)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package admission
+
+import (
+	"context"
+	"fmt"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	cradmission "sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// Webhook paths of the validated types, referenced by the
+// ValidatingWebhookConfiguration
+const (
+	ConnectionPath = "/validate-nsm-akosrbn-io-v1-networkconnection"
+	ServicePath    = "/validate-nsm-akosrbn-io-v1-networkservice"
+)
+
+// Validator rejects invalid NetworkConnections and NetworkServices when
+// they are created or updated, so a bad spec fails kubectl apply instead
+// of sitting unreconciled with a status nobody reads
+type Validator struct {
+	// Logger
+	logger *logrus.Logger
+	// Highest bandwidth in Mbps a connection or service may ask for, 0
+	// for no limit
+	maxBandwidthMbps int
+}
+
+// NewValidator creates a new validator limiting the bandwidths to
+// maxBandwidthMbps, 0 for no limit
+func NewValidator(logger *logrus.Logger, maxBandwidthMbps int) *Validator {
+	return &Validator{logger: logger, maxBandwidthMbps: maxBandwidthMbps}
+}
+
+// SetupWithManager registers the webhooks with the webhook server of the
+// manager
+func (v *Validator) SetupWithManager(mgr ctrl.Manager) error {
+	if err := ctrl.NewWebhookManagedBy(mgr).
+		For(&nsmv1.NetworkConnection{}).
+		WithValidator(connectionValidator{v}).
+		WithCustomPath(ConnectionPath).
+		Complete(); err != nil {
+		return fmt.Errorf("failed to register the NetworkConnection webhook: %w", err)
+	}
+	if err := ctrl.NewWebhookManagedBy(mgr).
+		For(&nsmv1.NetworkService{}).
+		WithValidator(serviceValidator{v}).
+		WithCustomPath(ServicePath).
+		Complete(); err != nil {
+		return fmt.Errorf("failed to register the NetworkService webhook: %w", err)
+	}
+	return nil
+}
+
+// reject logs a rejected object and returns the error denying it
+func (v *Validator) reject(kind, namespace, name string, err error) error {
+	v.logger.Infof("Rejected %s %s/%s: %v", kind, namespace, name, err)
+	return err
+}
+
+// connectionValidator validates NetworkConnections
+type connectionValidator struct {
+	*Validator
+}
+
+// ValidateCreate implements admission.CustomValidator
+func (v connectionValidator) ValidateCreate(ctx context.Context, obj runtime.Object) (cradmission.Warnings, error) {
+	conn, ok := obj.(*nsmv1.NetworkConnection)
+	if !ok {
+		return nil, fmt.Errorf("expected a NetworkConnection, got %T", obj)
+	}
+	if err := ValidateConnection(&conn.Spec, v.maxBandwidthMbps); err != nil {
+		return nil, v.reject("NetworkConnection", conn.Namespace, conn.Name, err)
+	}
+	return nil, nil
+}
+
+// ValidateUpdate implements admission.CustomValidator. Objects whose spec
+// didn't change are admitted, so finalizers and labels can still be
+// updated on objects created before a check existed.
+func (v connectionValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) (cradmission.Warnings, error) {
+	old, ok := oldObj.(*nsmv1.NetworkConnection)
+	if !ok {
+		return nil, fmt.Errorf("expected a NetworkConnection, got %T", oldObj)
+	}
+	conn, ok := newObj.(*nsmv1.NetworkConnection)
+	if !ok {
+		return nil, fmt.Errorf("expected a NetworkConnection, got %T", newObj)
+	}
+	if equality.Semantic.DeepEqual(old.Spec, conn.Spec) {
+		return nil, nil
+	}
+	return v.ValidateCreate(ctx, conn)
+}
+
+// ValidateDelete implements admission.CustomValidator
+func (v connectionValidator) ValidateDelete(ctx context.Context, obj runtime.Object) (cradmission.Warnings, error) {
+	return nil, nil
+}
+
+// serviceValidator validates NetworkServices
+type serviceValidator struct {
+	*Validator
+}
+
+// ValidateCreate implements admission.CustomValidator
+func (v serviceValidator) ValidateCreate(ctx context.Context, obj runtime.Object) (cradmission.Warnings, error) {
+	svc, ok := obj.(*nsmv1.NetworkService)
+	if !ok {
+		return nil, fmt.Errorf("expected a NetworkService, got %T", obj)
+	}
+	if err := ValidateService(&svc.Spec, v.maxBandwidthMbps); err != nil {
+		return nil, v.reject("NetworkService", svc.Namespace, svc.Name, err)
+	}
+	return nil, nil
+}
+
+// ValidateUpdate implements admission.CustomValidator, admitting objects
+// whose spec didn't change
+func (v serviceValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) (cradmission.Warnings, error) {
+	old, ok := oldObj.(*nsmv1.NetworkService)
+	if !ok {
+		return nil, fmt.Errorf("expected a NetworkService, got %T", oldObj)
+	}
+	svc, ok := newObj.(*nsmv1.NetworkService)
+	if !ok {
+		return nil, fmt.Errorf("expected a NetworkService, got %T", newObj)
+	}
+	if equality.Semantic.DeepEqual(old.Spec, svc.Spec) {
+		return nil, nil
+	}
+	return v.ValidateCreate(ctx, svc)
+}
+
+// ValidateDelete implements admission.CustomValidator
+func (v serviceValidator) ValidateDelete(ctx context.Context, obj runtime.Object) (cradmission.Warnings, error) {
+	return nil, nil
+}
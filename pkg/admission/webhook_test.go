@@ -0,0 +1,91 @@
+package admission
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/sirupsen/logrus"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	cradmission "sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func TestConnectionWebhook(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := nsmv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	hook := cradmission.WithCustomValidator(scheme, &nsmv1.NetworkConnection{}, connectionValidator{NewValidator(logger, 10000)})
+
+	review := func(op admissionv1.Operation, conn, old *nsmv1.NetworkConnection) cradmission.Response {
+		t.Helper()
+		req := cradmission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+			Operation: op,
+			Kind:      metav1.GroupVersionKind{Group: nsmv1.GroupName, Version: nsmv1.Version, Kind: "NetworkConnection"},
+		}}
+		var err error
+		if req.Object.Raw, err = json.Marshal(conn); err != nil {
+			t.Fatal(err)
+		}
+		if old != nil {
+			if req.OldObject.Raw, err = json.Marshal(old); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return hook.Handle(context.Background(), req)
+	}
+
+	conn := &nsmv1.NetworkConnection{
+		TypeMeta:   metav1.TypeMeta{APIVersion: nsmv1.SchemeGroupVersion.String(), Kind: "NetworkConnection"},
+		ObjectMeta: metav1.ObjectMeta{Name: "camera-vision", Namespace: "edge"},
+		Spec:       nsmv1.NetworkConnectionSpec{Source: "edge/camera", Destination: "vision", ConnectionType: nsmv1.ConnectionTypeKernel},
+	}
+	if resp := review(admissionv1.Create, conn, nil); !resp.Allowed {
+		t.Fatalf("valid connection denied: %+v", resp.Result)
+	}
+
+	bad := conn.DeepCopy()
+	bad.Spec.ConnectionType = "infiniband"
+	resp := review(admissionv1.Create, bad, nil)
+	if resp.Allowed || resp.Result.Code != http.StatusForbidden {
+		t.Fatalf("unknown connection type admitted: %+v", resp.Result)
+	}
+
+	// an invalid spec is rejected on update too, unless it didn't change
+	if resp := review(admissionv1.Update, bad, conn); resp.Allowed {
+		t.Error("update to an invalid spec admitted")
+	}
+	labeled := bad.DeepCopy()
+	labeled.Labels = map[string]string{"tier": "gold"}
+	if resp := review(admissionv1.Update, labeled, bad); !resp.Allowed {
+		t.Errorf("metadata update of an unchanged spec denied: %+v", resp.Result)
+	}
+}
+
+func TestServiceWebhook(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	v := serviceValidator{NewValidator(logger, 1000)}
+
+	svc := &nsmv1.NetworkService{
+		ObjectMeta: metav1.ObjectMeta{Name: "vision", Namespace: "edge"},
+		Spec:       nsmv1.NetworkServiceSpec{ServiceType: "l3", Endpoint: "10.0.0.5:8080", Bandwidth: 500},
+	}
+	if _, err := v.ValidateCreate(context.Background(), svc); err != nil {
+		t.Errorf("ValidateCreate() error = %v", err)
+	}
+	svc.Spec.Bandwidth = 2000
+	if _, err := v.ValidateCreate(context.Background(), svc); err == nil {
+		t.Error("ValidateCreate() admitted a reservation beyond the link")
+	}
+	if _, err := v.ValidateCreate(context.Background(), &nsmv1.NetworkConnection{}); err == nil {
+		t.Error("ValidateCreate() admitted a NetworkConnection")
+	}
+}
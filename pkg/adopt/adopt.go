@@ -0,0 +1,303 @@
+// Package adopt imports the tunnels, VLANs and static routes configured on
+// a brownfield node before NSM as NetworkConnections, so the node can be
+// migrated without recreating its connectivity by hand. The host objects
+// are left in place, to be removed once the connections are up.
+package adopt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/intent"
+	"github.com/akos011221/nsm/pkg/netendpoint"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// AnnotationAdoptedFrom records the host object (e.g., link/vx100) a
+// connection was imported from
+const AnnotationAdoptedFrom = "nsm.akosrbn.io/adopted-from"
+
+// Candidate actions
+const (
+	// ActionCreate creates the connection
+	ActionCreate = "create"
+	// ActionUpdate updates the connection imported earlier
+	ActionUpdate = "update"
+	// ActionUnchanged leaves the connection imported earlier as it is
+	ActionUnchanged = "unchanged"
+)
+
+// Request imports the host objects of the node as connections
+type Request struct {
+	// Namespace the connections are created in
+	Namespace string `json:"namespace"`
+	// Only report the connections and their diff, without creating them
+	DryRun bool `json:"dryRun,omitempty"`
+}
+
+// Candidate is a connection proposed for a host object
+type Candidate struct {
+	// Host object adopted
+	Object HostObject `json:"object"`
+	// Connection the object is adopted as
+	Connection *nsmv1.NetworkConnection `json:"connection"`
+	// Action (create, update, unchanged)
+	Action string `json:"action"`
+	// Line diff of the connection spec against the one imported earlier,
+	// - for removed and + for added lines
+	Diff string `json:"diff,omitempty"`
+	// Error creating or updating the connection
+	Error string `json:"error,omitempty"`
+}
+
+// Skipped is a host object not adopted, with the reason
+type Skipped struct {
+	// Host object
+	Object HostObject `json:"object"`
+	// Why it isn't adopted
+	Reason string `json:"reason"`
+}
+
+// Result lists the connections proposed, and created unless dry-run
+type Result struct {
+	// Node the host objects are on
+	Node string `json:"node"`
+	// Whether nothing was created
+	DryRun bool `json:"dryRun"`
+	// Connections proposed
+	Candidates []Candidate `json:"candidates"`
+	// Host objects not adopted
+	Skipped []Skipped `json:"skipped,omitempty"`
+}
+
+// Propose returns the candidates adopting the host objects of a node, in
+// the order of the objects and without an action.
+// Tunnels become connections to their remote endpoint, VLANs connections
+// tagged with their ID to the prefix routed through them, and static
+// routes over a physical interface connections to their prefix. The source
+// is the NetworkEndpoint of the physical interface.
+func Propose(node, namespace string, objects []HostObject) ([]Candidate, []Skipped) {
+	links := make(map[string]HostObject)
+	routesVia := make(map[string][]HostObject)
+	for _, obj := range objects {
+		if obj.Kind == KindRoute {
+			routesVia[obj.Device] = append(routesVia[obj.Device], obj)
+		} else {
+			links[obj.Name] = obj
+		}
+	}
+	// the outer tag of stacked VLANs is adopted with the inner one
+	carriers := make(map[string]bool)
+	for _, obj := range links {
+		if parent, ok := links[obj.Device]; ok && obj.Kind == KindVLAN && parent.Kind == KindVLAN {
+			carriers[parent.Name] = true
+		}
+	}
+
+	var candidates []Candidate
+	var skipped []Skipped
+	for _, obj := range objects {
+		conn := newConnection(node, namespace, obj)
+		switch {
+		case obj.Kind == KindTunnel:
+			conn.Spec.ConnectionType = nsmv1.ConnectionTypeKernel
+			if obj.LinkKind == "vxlan" {
+				conn.Spec.ConnectionType = nsmv1.ConnectionTypeVXLAN
+			}
+			conn.Spec.Destination = obj.Remote
+			setSourceEndpoint(conn, node, physical(links, obj.Device))
+			if conn.Spec.SourceEndpoint == "" {
+				conn.Spec.Source = obj.Local
+			}
+
+		case obj.Kind == KindVLAN && carriers[obj.Name]:
+			continue
+
+		case obj.Kind == KindVLAN:
+			routes := routesVia[obj.Name]
+			if len(routes) == 0 {
+				skipped = append(skipped, Skipped{Object: obj, Reason: "no static route through the VLAN, nothing to connect to"})
+				continue
+			}
+			conn.Spec.ConnectionType = nsmv1.ConnectionTypeKernel
+			conn.Spec.Destination = routes[0].Name
+			conn.Spec.VLAN = &nsmv1.VLANSpec{OuterVID: obj.ID}
+			if parent, ok := links[obj.Device]; ok && parent.Kind == KindVLAN {
+				conn.Spec.VLAN = &nsmv1.VLANSpec{OuterVID: parent.ID, InnerVID: obj.ID}
+			}
+			setSourceEndpoint(conn, node, physical(links, obj.Device))
+
+		case obj.Kind == KindRoute:
+			if via, ok := links[obj.Device]; ok {
+				skipped = append(skipped, Skipped{Object: obj, Reason: fmt.Sprintf("carried by the adopted %s %s", via.Kind, via.Name)})
+				continue
+			}
+			conn.Spec.ConnectionType = nsmv1.ConnectionTypeKernel
+			conn.Spec.Destination = obj.Name
+			setSourceEndpoint(conn, node, obj.Device)
+		}
+		candidates = append(candidates, Candidate{Object: obj, Connection: conn})
+	}
+	return candidates, skipped
+}
+
+// newConnection returns the connection of a host object without its spec
+func newConnection(node, namespace string, obj HostObject) *nsmv1.NetworkConnection {
+	return &nsmv1.NetworkConnection{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        intent.ObjectName("adopted", sanitize(node), sanitize(obj.Name)),
+			Namespace:   namespace,
+			Annotations: map[string]string{AnnotationAdoptedFrom: obj.Key()},
+		},
+	}
+}
+
+// physical returns the physical interface under a stack of links
+func physical(links map[string]HostObject, device string) string {
+	for depth := 0; depth < len(links); depth++ {
+		link, ok := links[device]
+		if !ok {
+			break
+		}
+		device = link.Device
+	}
+	return device
+}
+
+// setSourceEndpoint references the NetworkEndpoint of the physical
+// interface as the source of the connection
+func setSourceEndpoint(conn *nsmv1.NetworkConnection, node, device string) {
+	if device != "" {
+		conn.Spec.SourceEndpoint = netendpoint.Name(node, device)
+	}
+}
+
+// sanitize makes a link name or prefix fit an object name
+func sanitize(s string) string {
+	return strings.Trim(strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r - 'A' + 'a'
+		}
+		return '-'
+	}, s), "-")
+}
+
+// Import proposes the connections adopting the host objects of a node,
+// creating them or updating those imported earlier unless dry-run
+func Import(ctx context.Context, c client.Client, node string, objects []HostObject, req Request) (*Result, error) {
+	if req.Namespace == "" {
+		return nil, fmt.Errorf("a namespace is required to import connections")
+	}
+	candidates, skipped := Propose(node, req.Namespace, objects)
+	result := &Result{Node: node, DryRun: req.DryRun, Candidates: []Candidate{}, Skipped: skipped}
+
+	for _, candidate := range candidates {
+		conn := candidate.Connection
+		candidate.Action = ActionCreate
+
+		var existing nsmv1.NetworkConnection
+		err := c.Get(ctx, client.ObjectKeyFromObject(conn), &existing)
+		switch {
+		case apierrors.IsNotFound(err):
+			candidate.Diff = Diff(nil, &conn.Spec)
+		case err != nil:
+			return nil, fmt.Errorf("failed to get connection %s/%s: %w", conn.Namespace, conn.Name, err)
+		case existing.Annotations[AnnotationAdoptedFrom] != conn.Annotations[AnnotationAdoptedFrom]:
+			candidate.Action = ""
+			candidate.Error = fmt.Sprintf("connection %s/%s exists and wasn't imported from %s", conn.Namespace, conn.Name, candidate.Object.Key())
+		case equality.Semantic.DeepEqual(adoptedSpec(existing.Spec, conn.Spec), conn.Spec):
+			candidate.Action = ActionUnchanged
+		default:
+			candidate.Action = ActionUpdate
+			candidate.Diff = Diff(&existing.Spec, &conn.Spec)
+		}
+
+		if !req.DryRun && candidate.Error == "" {
+			if err := apply(ctx, c, conn, &existing, candidate.Action); err != nil {
+				candidate.Error = err.Error()
+			}
+		}
+		result.Candidates = append(result.Candidates, candidate)
+	}
+	sort.Slice(result.Candidates, func(i, j int) bool {
+		return result.Candidates[i].Connection.Name < result.Candidates[j].Connection.Name
+	})
+	return result, nil
+}
+
+// adoptedSpec returns the fields of an existing spec the import sets, the
+// registry may have filled in the address of the source endpoint since
+func adoptedSpec(existing, proposed nsmv1.NetworkConnectionSpec) nsmv1.NetworkConnectionSpec {
+	if proposed.SourceEndpoint != "" && existing.SourceEndpoint == proposed.SourceEndpoint {
+		existing.Source = proposed.Source
+	}
+	return existing
+}
+
+// apply creates or updates an imported connection
+func apply(ctx context.Context, c client.Client, conn, existing *nsmv1.NetworkConnection, action string) error {
+	switch action {
+	case ActionCreate:
+		return c.Create(ctx, conn)
+	case ActionUpdate:
+		// keeping the address the registry resolved
+		existing.Spec = adoptedSpec(conn.Spec, existing.Spec)
+		return c.Update(ctx, existing)
+	}
+	return nil
+}
+
+// Diff returns a line diff of two connection specs rendered as JSON, nil
+// for a connection not created yet
+func Diff(from, to *nsmv1.NetworkConnectionSpec) string {
+	render := func(spec *nsmv1.NetworkConnectionSpec) []string {
+		if spec == nil {
+			return nil
+		}
+		data, _ := json.MarshalIndent(spec, "", "  ")
+		return strings.Split(string(data), "\n")
+	}
+	a, b := render(from), render(to)
+
+	// longest common subsequence of the lines
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var diff strings.Builder
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			diff.WriteString("  " + a[i] + "\n")
+			i, j = i+1, j+1
+		case j < len(b) && (i == len(a) || lcs[i][j+1] >= lcs[i+1][j]):
+			diff.WriteString("+ " + b[j] + "\n")
+			j++
+		default:
+			diff.WriteString("- " + a[i] + "\n")
+			i++
+		}
+	}
+	return diff.String()
+}
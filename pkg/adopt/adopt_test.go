@@ -0,0 +1,140 @@
+package adopt
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newClient(t *testing.T, objs ...client.Object) client.Client {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := nsmv1.AddToScheme(scheme); err != nil {
+		t.Fatalf("failed to build scheme: %v", err)
+	}
+	return fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build()
+}
+
+func TestPropose(t *testing.T) {
+	objects := []HostObject{
+		{Kind: KindVLAN, Name: "eth1.10", Device: "eth1", ID: 10, Protocol: "802.1ad"},
+		{Kind: KindVLAN, Name: "eth1.10.20", Device: "eth1.10", ID: 20, Protocol: "802.1Q"},
+		{Kind: KindVLAN, Name: "eth1.30", Device: "eth1", ID: 30, Protocol: "802.1Q"},
+		{Kind: KindTunnel, Name: "gre1", LinkKind: "gre", Local: "192.0.2.10", Remote: "192.0.2.30"},
+		{Kind: KindRoute, Name: "10.20.0.0/16", Device: "eth1.10.20"},
+		{Kind: KindRoute, Name: "10.50.0.0/16", Device: "gre1"},
+		{Kind: KindRoute, Name: "10.60.0.0/16", Device: "eth2", Gateway: "192.0.2.1"},
+	}
+	candidates, skipped := Propose("edge-1", "default", objects)
+
+	if len(candidates) != 3 {
+		t.Fatalf("Propose() = %d candidates, want 3: %+v", len(candidates), candidates)
+	}
+
+	qinq := candidates[0].Connection
+	if qinq.Name != "adopted-edge-1-eth1-10-20" || qinq.Annotations[AnnotationAdoptedFrom] != "link/eth1.10.20" {
+		t.Errorf("unexpected QinQ connection metadata: %+v", qinq.ObjectMeta)
+	}
+	if qinq.Spec.VLAN == nil || qinq.Spec.VLAN.OuterVID != 10 || qinq.Spec.VLAN.InnerVID != 20 {
+		t.Errorf("QinQ tags = %+v, want 10/20", qinq.Spec.VLAN)
+	}
+	if qinq.Spec.Destination != "10.20.0.0/16" || qinq.Spec.SourceEndpoint != "edge-1.eth1" {
+		t.Errorf("unexpected QinQ endpoints: %+v", qinq.Spec)
+	}
+
+	gre := candidates[1].Connection
+	if gre.Spec.ConnectionType != nsmv1.ConnectionTypeKernel || gre.Spec.Source != "192.0.2.10" || gre.Spec.Destination != "192.0.2.30" {
+		t.Errorf("unexpected tunnel connection: %+v", gre.Spec)
+	}
+
+	route := candidates[2].Connection
+	if route.Spec.Destination != "10.60.0.0/16" || route.Spec.SourceEndpoint != "edge-1.eth2" {
+		t.Errorf("unexpected route connection: %+v", route.Spec)
+	}
+
+	// the route through the QinQ VLAN is its destination
+	if len(skipped) != 3 || skipped[0].Object.Name != "eth1.30" || skipped[1].Object.Name != "10.20.0.0/16" || skipped[2].Object.Name != "10.50.0.0/16" {
+		t.Errorf("unexpected skipped objects: %+v", skipped)
+	}
+}
+
+func TestImport(t *testing.T) {
+	objects := []HostObject{
+		{Kind: KindTunnel, Name: "vx42", LinkKind: "vxlan", Device: "eth0", Local: "192.0.2.10", Remote: "192.0.2.20", ID: 42},
+	}
+	c := newClient(t)
+	ctx := context.Background()
+
+	result, err := Import(ctx, c, "edge-1", objects, Request{Namespace: "default", DryRun: true})
+	if err != nil {
+		t.Fatalf("Import() error = %v", err)
+	}
+	if len(result.Candidates) != 1 || result.Candidates[0].Action != ActionCreate {
+		t.Fatalf("unexpected dry-run result: %+v", result)
+	}
+	if !strings.Contains(result.Candidates[0].Diff, `+   "destination": "192.0.2.20"`) {
+		t.Errorf("dry-run diff doesn't add the destination:\n%s", result.Candidates[0].Diff)
+	}
+	var list nsmv1.NetworkConnectionList
+	if err := c.List(ctx, &list); err != nil || len(list.Items) != 0 {
+		t.Fatalf("dry-run created connections: %v, %v", list.Items, err)
+	}
+
+	if _, err := Import(ctx, c, "edge-1", objects, Request{Namespace: "default"}); err != nil {
+		t.Fatalf("Import() error = %v", err)
+	}
+	var conn nsmv1.NetworkConnection
+	if err := c.Get(ctx, client.ObjectKey{Namespace: "default", Name: "adopted-edge-1-vx42"}, &conn); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if conn.Spec.ConnectionType != nsmv1.ConnectionTypeVXLAN || conn.Spec.SourceEndpoint != "edge-1.eth0" {
+		t.Errorf("unexpected imported connection: %+v", conn.Spec)
+	}
+
+	// the registry resolves the source endpoint, the import leaves it
+	conn.Spec.Source = "192.0.2.10"
+	if err := c.Update(ctx, &conn); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	result, err = Import(ctx, c, "edge-1", objects, Request{Namespace: "default", DryRun: true})
+	if err != nil || result.Candidates[0].Action != ActionUnchanged {
+		t.Fatalf("re-import = %+v, %v, want unchanged", result, err)
+	}
+
+	objects[0].Remote = "192.0.2.21"
+	result, err = Import(ctx, c, "edge-1", objects, Request{Namespace: "default"})
+	if err != nil || result.Candidates[0].Action != ActionUpdate {
+		t.Fatalf("re-import = %+v, %v, want update", result, err)
+	}
+	diff := result.Candidates[0].Diff
+	if !strings.Contains(diff, `-   "destination": "192.0.2.20"`) || !strings.Contains(diff, `+   "destination": "192.0.2.21"`) {
+		t.Errorf("update diff doesn't change the destination:\n%s", diff)
+	}
+	if err := c.Get(ctx, client.ObjectKeyFromObject(&conn), &conn); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if conn.Spec.Destination != "192.0.2.21" || conn.Spec.Source != "192.0.2.10" {
+		t.Errorf("unexpected updated connection: %+v", conn.Spec)
+	}
+}
+
+func TestImportKeepsConnectionsNotImported(t *testing.T) {
+	existing := &nsmv1.NetworkConnection{}
+	existing.Name, existing.Namespace = "adopted-edge-1-vx42", "default"
+	existing.Spec = nsmv1.NetworkConnectionSpec{Source: "a", Destination: "b", ConnectionType: nsmv1.ConnectionTypeKernel}
+	c := newClient(t, existing)
+
+	objects := []HostObject{{Kind: KindTunnel, Name: "vx42", LinkKind: "vxlan", Remote: "192.0.2.20"}}
+	result, err := Import(context.Background(), c, "edge-1", objects, Request{Namespace: "default"})
+	if err != nil {
+		t.Fatalf("Import() error = %v", err)
+	}
+	if result.Candidates[0].Error == "" {
+		t.Errorf("import overwrote a connection not imported")
+	}
+}
@@ -0,0 +1,40 @@
+package adopt
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/akos011221/nsm/pkg/api"
+	"github.com/sirupsen/logrus"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Handler serves the import of the host objects of the node over the
+// management API
+func Handler(c client.Client, logger *logrus.Logger, scanner *Scanner, node string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req Request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			api.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid import request: %w", err))
+			return
+		}
+
+		objects, err := scanner.Scan(r.Context())
+		if err != nil {
+			api.WriteError(w, http.StatusInternalServerError, fmt.Errorf("failed to scan the host: %w", err))
+			return
+		}
+
+		result, err := Import(r.Context(), c, node, objects, req)
+		if err != nil {
+			api.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		logger.Infof("Import of the host objects of node %s into %s: %d candidates, %d skipped, dry-run=%t",
+			node, req.Namespace, len(result.Candidates), len(result.Skipped), req.DryRun)
+
+		api.WriteJSON(w, http.StatusOK, result)
+	})
+}
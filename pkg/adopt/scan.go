@@ -0,0 +1,181 @@
+package adopt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/akos011221/nsm/pkg/datapath"
+)
+
+// Kinds of host objects
+const (
+	// KindTunnel is a VXLAN, GRE or IP-in-IP tunnel link
+	KindTunnel = "tunnel"
+	// KindVLAN is a VLAN sub-interface
+	KindVLAN = "vlan"
+	// KindRoute is a static route
+	KindRoute = "route"
+)
+
+// managedPrefix prefixes the names of the links NSM creates
+const managedPrefix = "nsm"
+
+// tunnelKinds are the link kinds adopted as tunnels, with a remote endpoint
+var tunnelKinds = map[string]bool{
+	"vxlan":  true,
+	"geneve": true,
+	"gre":    true,
+	"gretap": true,
+	"ip6gre": true,
+	"ipip":   true,
+	"ip6tnl": true,
+	"sit":    true,
+}
+
+// routeProtocols are the origins of the routes configured by hand or by
+// scripts, the others are added by the kernel or routing daemons
+var routeProtocols = map[string]bool{
+	"static": true,
+	"boot":   true,
+}
+
+// HostObject is a tunnel, VLAN or route configured on the host outside NSM
+type HostObject struct {
+	// Kind (tunnel, vlan, route)
+	Kind string `json:"kind"`
+	// Link name, or the destination prefix of a route
+	Name string `json:"name"`
+	// Link kind of a tunnel (e.g., vxlan, gre)
+	LinkKind string `json:"linkKind,omitempty"`
+	// Parent interface of a VLAN or tunnel, outgoing interface of a route
+	Device string `json:"device,omitempty"`
+	// Local tunnel endpoint
+	Local string `json:"local,omitempty"`
+	// Remote tunnel endpoint
+	Remote string `json:"remote,omitempty"`
+	// VXLAN network identifier or VLAN ID
+	ID int `json:"id,omitempty"`
+	// VLAN protocol (802.1Q, 802.1ad)
+	Protocol string `json:"protocol,omitempty"`
+	// Next hop of a route
+	Gateway string `json:"gateway,omitempty"`
+}
+
+// Key identifies the object on the host (e.g., link/vx100, route/10.20.0.0/16)
+func (o HostObject) Key() string {
+	if o.Kind == KindRoute {
+		return "route/" + o.Name
+	}
+	return "link/" + o.Name
+}
+
+// Scanner lists the tunnels, VLANs and static routes of the host with
+// iproute2, leaving out those NSM created
+type Scanner struct {
+	// Runs the commands, replaceable for tests
+	run datapath.CommandRunner
+}
+
+// NewScanner creates a scanner of the host
+func NewScanner() *Scanner {
+	return &Scanner{run: runCommand}
+}
+
+// runCommand runs a command on the host
+func runCommand(ctx context.Context, name string, args ...string) ([]byte, error) {
+	out, err := exec.CommandContext(ctx, name, args...).CombinedOutput()
+	if err != nil {
+		return out, fmt.Errorf("%s %s failed: %w: %s", name, strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return out, nil
+}
+
+// Scan returns the links and then the routes configured outside NSM
+func (s *Scanner) Scan(ctx context.Context) ([]HostObject, error) {
+	links, err := s.scanLinks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	routes, err := s.scanRoutes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return append(links, routes...), nil
+}
+
+// scanLinks lists the tunnels and VLANs with `ip -j -d link show`
+func (s *Scanner) scanLinks(ctx context.Context) ([]HostObject, error) {
+	out, err := s.run(ctx, "ip", "-j", "-d", "link", "show")
+	if err != nil {
+		return nil, err
+	}
+	var links []struct {
+		Name     string `json:"ifname"`
+		Link     string `json:"link"`
+		LinkInfo struct {
+			Kind string `json:"info_kind"`
+			Data struct {
+				ID       int    `json:"id"`
+				Protocol string `json:"protocol"`
+				Local    string `json:"local"`
+				Remote   string `json:"remote"`
+				Link     string `json:"link"`
+			} `json:"info_data"`
+		} `json:"linkinfo"`
+	}
+	if err := json.Unmarshal(out, &links); err != nil {
+		return nil, fmt.Errorf("failed to parse links: %w", err)
+	}
+
+	var objs []HostObject
+	for _, link := range links {
+		if strings.HasPrefix(link.Name, managedPrefix) {
+			continue
+		}
+		info := link.LinkInfo
+		parent := link.Link
+		if parent == "" {
+			parent = info.Data.Link
+		}
+		switch {
+		case info.Kind == "vlan":
+			objs = append(objs, HostObject{Kind: KindVLAN, Name: link.Name, Device: parent, ID: info.Data.ID, Protocol: info.Data.Protocol})
+		case tunnelKinds[info.Kind] && info.Data.Remote != "":
+			objs = append(objs, HostObject{Kind: KindTunnel, Name: link.Name, LinkKind: info.Kind, Device: parent,
+				Local: info.Data.Local, Remote: info.Data.Remote, ID: info.Data.ID})
+		}
+	}
+	return objs, nil
+}
+
+// scanRoutes lists the static routes of the main table with `ip -j route
+// show`, for IPv4 and IPv6
+func (s *Scanner) scanRoutes(ctx context.Context) ([]HostObject, error) {
+	var objs []HostObject
+	for _, family := range []string{"-4", "-6"} {
+		out, err := s.run(ctx, "ip", family, "-j", "route", "show", "table", "main")
+		if err != nil {
+			return nil, err
+		}
+		var routes []struct {
+			Dst      string `json:"dst"`
+			Gateway  string `json:"gateway"`
+			Dev      string `json:"dev"`
+			Protocol string `json:"protocol"`
+		}
+		if err := json.Unmarshal(out, &routes); err != nil {
+			return nil, fmt.Errorf("failed to parse routes: %w", err)
+		}
+		for _, route := range routes {
+			// the default route is the uplink of the node, not a connection
+			if !routeProtocols[route.Protocol] || route.Dst == "default" || strings.HasPrefix(route.Dev, managedPrefix) {
+				continue
+			}
+			objs = append(objs, HostObject{Kind: KindRoute, Name: route.Dst, Device: route.Dev, Gateway: route.Gateway})
+		}
+	}
+	return objs, nil
+}
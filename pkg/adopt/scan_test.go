@@ -0,0 +1,52 @@
+package adopt
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const (
+	linksJSON = `[
+{"ifname":"lo","linkinfo":null},
+{"ifname":"eth0"},
+{"ifname":"eth0.100","link":"eth0","linkinfo":{"info_kind":"vlan","info_data":{"protocol":"802.1Q","id":100}}},
+{"ifname":"vx42","linkinfo":{"info_kind":"vxlan","info_data":{"id":42,"remote":"192.0.2.20","local":"192.0.2.10","link":"eth0"}}},
+{"ifname":"vx-flood","linkinfo":{"info_kind":"vxlan","info_data":{"id":43,"local":"192.0.2.10"}}},
+{"ifname":"nsmvl1234","link":"eth0","linkinfo":{"info_kind":"vlan","info_data":{"protocol":"802.1Q","id":200}}}
+]`
+	routes4JSON = `[
+{"dst":"default","gateway":"192.0.2.1","dev":"eth0","protocol":"static"},
+{"dst":"192.0.2.0/24","dev":"eth0","protocol":"kernel"},
+{"dst":"10.20.0.0/16","gateway":"10.100.0.1","dev":"eth0.100","protocol":"static"},
+{"dst":"10.30.0.0/16","dev":"nsmvl1234","protocol":"static"},
+{"dst":"10.40.0.0/16","gateway":"192.0.2.2","dev":"eth0","protocol":"bgp"}
+]`
+)
+
+func fakeHost(ctx context.Context, name string, args ...string) ([]byte, error) {
+	switch strings.Join(args, " ") {
+	case "-j -d link show":
+		return []byte(linksJSON), nil
+	case "-4 -j route show table main":
+		return []byte(routes4JSON), nil
+	}
+	return []byte("[]"), nil
+}
+
+func TestScan(t *testing.T) {
+	s := &Scanner{run: fakeHost}
+	objs, err := s.Scan(context.Background())
+	if err != nil {
+		t.Fatalf("Scan() error = %v", err)
+	}
+	want := []HostObject{
+		{Kind: KindVLAN, Name: "eth0.100", Device: "eth0", ID: 100, Protocol: "802.1Q"},
+		{Kind: KindTunnel, Name: "vx42", LinkKind: "vxlan", Device: "eth0", Local: "192.0.2.10", Remote: "192.0.2.20", ID: 42},
+		{Kind: KindRoute, Name: "10.20.0.0/16", Device: "eth0.100", Gateway: "10.100.0.1"},
+	}
+	if !reflect.DeepEqual(objs, want) {
+		t.Errorf("Scan() = %+v, want %+v", objs, want)
+	}
+}
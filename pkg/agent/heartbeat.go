@@ -0,0 +1,159 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/akos011221/nsm/pkg/api"
+	"github.com/akos011221/nsm/pkg/hardware"
+	"github.com/akos011221/nsm/pkg/watchdog"
+	"github.com/sirupsen/logrus"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// Labels and annotations of the agent Leases
+const (
+	// LabelAgent marks the Leases the node agents register with
+	LabelAgent = "nsm.akosrbn.io/agent"
+	// AnnotationAPIVersion is the management API version the agent speaks
+	AnnotationAPIVersion = "nsm.akosrbn.io/api-version"
+	// AnnotationVFs lists the VFs allocated on the node (PF/VF id), comma separated
+	AnnotationVFs = "nsm.akosrbn.io/vfs"
+)
+
+// VFLister lists the SR-IOV virtual functions of the node
+type VFLister interface {
+	VirtualFunctions() []hardware.VirtualFunction
+}
+
+// LeaseName is the name of the Lease of the agent on a node
+func LeaseName(node string) string {
+	return "nsm-agent-" + strings.ToLower(node)
+}
+
+// Heartbeat registers the agent of a node with the controller by creating
+// a Lease, and keeps it alive by renewing the Lease every third of its
+// duration. The Lease carries the API version of the agent and the VFs
+// allocated on the node, which the controller marks stale once the Lease
+// expires.
+type Heartbeat struct {
+	// Context for cancellation
+	ctx context.Context
+	// Kubernetes client (controller-runtime)
+	client client.Client
+	// Logger
+	logger *logrus.Logger
+	// Namespace of the Leases
+	namespace string
+	// Node of the agent
+	node string
+	// Time the Lease is valid for without being renewed
+	leaseDuration time.Duration
+	// Interval between renewals
+	interval time.Duration
+	// VFs of the node, nil without SR-IOV
+	vfs VFLister
+	// Progress signal for the watchdog
+	heartbeat *watchdog.Heartbeat
+}
+
+// NewHeartbeat creates the heartbeat of the agent on a node
+func NewHeartbeat(ctx context.Context, c client.Client, logger *logrus.Logger, namespace, node string, leaseDuration time.Duration) *Heartbeat {
+	return &Heartbeat{
+		ctx:           ctx,
+		client:        c,
+		logger:        logger,
+		namespace:     namespace,
+		node:          node,
+		leaseDuration: leaseDuration,
+		interval:      leaseDuration / 3,
+	}
+}
+
+// SetVFLister makes the heartbeat announce the VFs allocated on the node
+func (h *Heartbeat) SetVFLister(vfs VFLister) {
+	h.vfs = vfs
+}
+
+// SetHeartbeat makes the heartbeat report its progress to the watchdog
+func (h *Heartbeat) SetHeartbeat(hb *watchdog.Heartbeat) {
+	h.heartbeat = hb
+	hb.Expect(h.interval)
+}
+
+// Start registers the agent and renews its Lease until the context is
+// cancelled. The Lease is kept on shutdown, so an agent restarting within
+// the lease duration doesn't fail over the connections of its node.
+func (h *Heartbeat) Start() error {
+	h.logger.Infof("Registering agent of node %s", h.node)
+	if err := h.Renew(time.Now()); err != nil {
+		h.logger.WithError(err).Warn("Failed to register the agent")
+	}
+
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			h.heartbeat.Beat()
+			if err := h.Renew(now); err != nil {
+				h.logger.WithError(err).Warn("Failed to renew the agent lease")
+			}
+
+		case <-h.ctx.Done():
+			h.logger.Info("Stopping agent heartbeat")
+			return nil
+		}
+	}
+}
+
+// Renew creates or renews the Lease of the agent
+func (h *Heartbeat) Renew(now time.Time) error {
+	lease := &coordinationv1.Lease{ObjectMeta: metav1.ObjectMeta{Namespace: h.namespace, Name: LeaseName(h.node)}}
+	_, err := controllerutil.CreateOrUpdate(h.ctx, h.client, lease, func() error {
+		if lease.Labels == nil {
+			lease.Labels = make(map[string]string)
+		}
+		if lease.Annotations == nil {
+			lease.Annotations = make(map[string]string)
+		}
+		lease.Labels[LabelAgent] = "true"
+		lease.Annotations[AnnotationAPIVersion] = api.V1
+		lease.Annotations[AnnotationVFs] = strings.Join(h.allocatedVFs(), ",")
+		seconds := int32(h.leaseDuration / time.Second)
+		renewTime := metav1.NewMicroTime(now)
+		lease.Spec.HolderIdentity = &h.node
+		lease.Spec.LeaseDurationSeconds = &seconds
+		lease.Spec.RenewTime = &renewTime
+		if lease.Spec.AcquireTime == nil {
+			lease.Spec.AcquireTime = &renewTime
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to renew lease %s/%s: %w", h.namespace, LeaseName(h.node), err)
+	}
+	return nil
+}
+
+// allocatedVFs returns the VFs allocated on the node, sorted
+func (h *Heartbeat) allocatedVFs() []string {
+	if h.vfs == nil {
+		return nil
+	}
+	var vfs []string
+	for _, vf := range h.vfs.VirtualFunctions() {
+		if vf.Allocated {
+			vfs = append(vfs, fmt.Sprintf("%s/%d", vf.PFName, vf.VFID))
+		}
+	}
+	sort.Strings(vfs)
+	return vfs
+}
@@ -0,0 +1,42 @@
+package agent
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/akos011221/nsm/pkg/api"
+	"github.com/akos011221/nsm/pkg/hardware"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+type staticVFs []hardware.VirtualFunction
+
+func (v staticVFs) VirtualFunctions() []hardware.VirtualFunction {
+	return v
+}
+
+func TestHeartbeatRegisters(t *testing.T) {
+	c := newTestClient(t)
+	h := NewHeartbeat(context.Background(), c, quietLogger(), "nsm-system", "Edge-A", 30*time.Second)
+	h.SetVFLister(staticVFs{
+		{PFName: "eth1", VFID: 1, Allocated: true},
+		{PFName: "eth1", VFID: 0, Allocated: true},
+		{PFName: "eth1", VFID: 2},
+	})
+	if err := h.Renew(time.Now()); err != nil {
+		t.Fatalf("Renew() error = %v", err)
+	}
+
+	var lease coordinationv1.Lease
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "nsm-system", Name: "nsm-agent-edge-a"}, &lease); err != nil {
+		t.Fatalf("lease not created: %v", err)
+	}
+	if lease.Labels[LabelAgent] != "true" || *lease.Spec.HolderIdentity != "Edge-A" || *lease.Spec.LeaseDurationSeconds != 30 {
+		t.Errorf("unexpected lease %+v", lease)
+	}
+	if lease.Annotations[AnnotationAPIVersion] != api.V1 || lease.Annotations[AnnotationVFs] != "eth1/0,eth1/1" {
+		t.Errorf("unexpected annotations %v", lease.Annotations)
+	}
+}
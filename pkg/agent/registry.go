@@ -0,0 +1,317 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/watchdog"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	// agentNodes is the number of registered nodes by liveness
+	agentNodes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "nsm_agent_nodes",
+		Help: "Number of nodes with a registered agent, by state (alive, lost)",
+	}, []string{"state"})
+
+	// nodeFailoversTotal counts the connections failed over from lost nodes
+	nodeFailoversTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "nsm_node_failovers_total",
+		Help: "Number of connections failed over because the agent of their node was lost",
+	})
+)
+
+func init() {
+	crmetrics.Registry.MustRegister(agentNodes, nodeFailoversTotal)
+}
+
+// Shard tells which nodes this controller replica fails over, implemented
+// by shard.Membership
+type Shard interface {
+	// Owns tells whether this replica reconciles the objects of the node
+	Owns(node string) bool
+}
+
+// NodeStatus is the liveness of the agent of a node
+type NodeStatus struct {
+	// Node of the agent
+	Node string `json:"node"`
+	// Whether the agent renewed its Lease in time
+	Alive bool `json:"alive"`
+	// Management API version the agent speaks
+	APIVersion string `json:"apiVersion,omitempty"`
+	// Time the agent registered
+	Registered time.Time `json:"registered"`
+	// Time the agent last renewed its Lease
+	LastRenewed time.Time `json:"lastRenewed"`
+	// VFs allocated on the node (PF/VF id), stale while the node is lost
+	VFs []string `json:"vfs,omitempty"`
+	// Connections failed over since the node was lost
+	FailedOver int `json:"failedOver,omitempty"`
+}
+
+// Registry tracks the agents registered with the controller through their
+// Leases. An agent whose Lease expired is lost: the VFs it announced are
+// stale, and its connections are moved to a surviving node, which sets
+// them up again. The established ones are marked pending with the reason
+// NodeLost meanwhile, switched to their standby path when they have one.
+type Registry struct {
+	// Context for cancellation
+	ctx context.Context
+	// Kubernetes client (controller-runtime)
+	client client.Client
+	// Logger
+	logger *logrus.Logger
+	// Namespace of the Leases
+	namespace string
+	// Interval between syncs
+	interval time.Duration
+	// Nodes by name
+	nodes map[string]*NodeStatus
+	// Mutex for protecting the nodes
+	mu sync.RWMutex
+	// Replica shard, nil if this replica fails over every node
+	shard Shard
+	// Progress signal for the watchdog
+	heartbeat *watchdog.Heartbeat
+}
+
+// NewRegistry creates a registry of the agents with Leases in the namespace
+func NewRegistry(ctx context.Context, c client.Client, logger *logrus.Logger, namespace string, interval time.Duration) *Registry {
+	return &Registry{
+		ctx:       ctx,
+		client:    c,
+		logger:    logger,
+		namespace: namespace,
+		interval:  interval,
+		nodes:     make(map[string]*NodeStatus),
+	}
+}
+
+// SetHeartbeat makes the registry report its progress to the watchdog
+func (r *Registry) SetHeartbeat(hb *watchdog.Heartbeat) {
+	r.heartbeat = hb
+	hb.Expect(r.interval)
+}
+
+// SetShard makes the registry fail over only the lost nodes of this
+// replica's shard, the other replicas fail over theirs
+func (r *Registry) SetShard(shard Shard) {
+	r.shard = shard
+}
+
+// Start syncs the registry until the context is cancelled
+func (r *Registry) Start() error {
+	r.logger.Info("Starting agent registry")
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			r.heartbeat.Beat()
+			if err := r.Sync(now); err != nil {
+				r.logger.WithError(err).Warn("Failed to sync the agent registry")
+			}
+
+		case <-r.ctx.Done():
+			r.logger.Info("Stopping agent registry")
+			return nil
+		}
+	}
+}
+
+// Nodes returns the registered nodes, sorted by name
+func (r *Registry) Nodes() []NodeStatus {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	nodes := make([]NodeStatus, 0, len(r.nodes))
+	for _, node := range r.nodes {
+		nodes = append(nodes, *node)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Node < nodes[j].Node })
+	return nodes
+}
+
+// Alive tells whether the agent of a node renewed its Lease in time
+func (r *Registry) Alive(node string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	status, ok := r.nodes[node]
+	return ok && status.Alive
+}
+
+// Sync reads the agent Leases and fails over the connections of the nodes
+// lost since the last sync. Nodes already lost when first seen are failed
+// over too, the controller may have restarted after they were lost.
+func (r *Registry) Sync(now time.Time) error {
+	var leases coordinationv1.LeaseList
+	if err := r.client.List(r.ctx, &leases, client.InNamespace(r.namespace), client.MatchingLabels{LabelAgent: "true"}); err != nil {
+		return fmt.Errorf("failed to list agent leases: %w", err)
+	}
+
+	var lost []string
+	seen := make(map[string]bool)
+	r.mu.Lock()
+	for _, lease := range leases.Items {
+		spec := lease.Spec
+		if spec.HolderIdentity == nil || spec.RenewTime == nil || spec.LeaseDurationSeconds == nil {
+			continue
+		}
+		node := *spec.HolderIdentity
+		seen[node] = true
+		alive := now.Before(spec.RenewTime.Add(time.Duration(*spec.LeaseDurationSeconds) * time.Second))
+
+		status, known := r.nodes[node]
+		if !known {
+			status = &NodeStatus{Node: node}
+			r.nodes[node] = status
+		}
+		switch {
+		case alive && known && !status.Alive:
+			r.logger.Infof("Agent of node %s is back", node)
+			status.FailedOver = 0
+		case alive && !known:
+			r.logger.Infof("Agent of node %s registered", node)
+		case !alive && (!known || status.Alive):
+			lost = append(lost, node)
+		}
+		status.Alive = alive
+		status.APIVersion = lease.Annotations[AnnotationAPIVersion]
+		status.LastRenewed = spec.RenewTime.Time
+		if spec.AcquireTime != nil {
+			status.Registered = spec.AcquireTime.Time
+		}
+		status.VFs = nil
+		if vfs := lease.Annotations[AnnotationVFs]; vfs != "" {
+			status.VFs = strings.Split(vfs, ",")
+		}
+	}
+	// deleted Leases deregister their agents
+	for node := range r.nodes {
+		if !seen[node] {
+			r.logger.Infof("Agent of node %s deregistered", node)
+			delete(r.nodes, node)
+		}
+	}
+	alive := 0
+	for _, status := range r.nodes {
+		if status.Alive {
+			alive++
+		}
+	}
+	agentNodes.WithLabelValues("alive").Set(float64(alive))
+	agentNodes.WithLabelValues("lost").Set(float64(len(r.nodes) - alive))
+	r.mu.Unlock()
+
+	for _, node := range lost {
+		if r.shard != nil && !r.shard.Owns(node) {
+			continue
+		}
+		r.logger.Warnf("Agent of node %s lost, its lease expired", node)
+		count, err := r.failover(node, now)
+		if err != nil {
+			return err
+		}
+		r.mu.Lock()
+		if status, ok := r.nodes[node]; ok {
+			status.FailedOver += count
+		}
+		r.mu.Unlock()
+	}
+	return nil
+}
+
+// failover moves the connections of a lost node to the surviving node
+// with the fewest connections, marking the established ones pending and
+// switching them to their standby path when they have one, and returns
+// how many were moved. Without a surviving node they are left to the
+// first node claiming them.
+func (r *Registry) failover(node string, now time.Time) (int, error) {
+	var conns nsmv1.NetworkConnectionList
+	if err := r.client.List(r.ctx, &conns); err != nil {
+		return 0, fmt.Errorf("failed to list connections of node %s: %w", node, err)
+	}
+
+	load := make(map[string]int)
+	r.mu.RLock()
+	for name, status := range r.nodes {
+		if status.Alive && name != node {
+			load[name] = 0
+		}
+	}
+	r.mu.RUnlock()
+	for _, conn := range conns.Items {
+		if _, ok := load[conn.Status.Node]; ok {
+			load[conn.Status.Node]++
+		}
+	}
+
+	count := 0
+	for i := range conns.Items {
+		conn := &conns.Items[i]
+		if conn.Status.Node != node || conn.Spec.Canary != nil {
+			continue
+		}
+		target := leastLoaded(load)
+		if target != "" {
+			load[target]++
+		}
+		conn.Status.Node = target
+		to := "to the first node claiming it"
+		if target != "" {
+			to = "to node " + target
+		}
+
+		msg := fmt.Sprintf("node %s lost, moving %s", node, to)
+		if conn.Status.Established {
+			if conn.Status.StandbyPath != "" {
+				msg = fmt.Sprintf("node %s lost, failing over to standby path %s, moving %s", node, conn.Status.StandbyPath, to)
+				conn.Status.ActivePath, conn.Status.StandbyPath = conn.Status.StandbyPath, ""
+			}
+			pending := metav1.NewTime(now)
+			conn.Status.State = nsmv1.ConnectionStatePending
+			conn.Status.Established = false
+			conn.Status.PendingSince = &pending
+			conn.Status.Message = msg
+			meta.SetStatusCondition(&conn.Status.Conditions, metav1.Condition{
+				Type:               nsmv1.ConditionReady,
+				Status:             metav1.ConditionFalse,
+				Reason:             "NodeLost",
+				Message:            msg,
+				ObservedGeneration: conn.Generation,
+			})
+		}
+		if err := r.client.Status().Update(r.ctx, conn); err != nil {
+			return count, fmt.Errorf("failed to fail over connection %s/%s: %w", conn.Namespace, conn.Name, err)
+		}
+		r.logger.Warnf("Connection %s/%s: %s", conn.Namespace, conn.Name, msg)
+		nodeFailoversTotal.Inc()
+		count++
+	}
+	return count, nil
+}
+
+// leastLoaded returns the node with the fewest connections, the first by
+// name of those with as few, empty if there is none
+func leastLoaded(load map[string]int) string {
+	best := ""
+	for node, n := range load {
+		if best == "" || n < load[best] || (n == load[best] && node < best) {
+			best = node
+		}
+	}
+	return best
+}
@@ -0,0 +1,218 @@
+package agent
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/sirupsen/logrus"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func quietLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return logger
+}
+
+func newTestClient(t *testing.T, objs ...client.Object) client.Client {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatalf("failed to build scheme: %v", err)
+	}
+	if err := nsmv1.AddToScheme(scheme); err != nil {
+		t.Fatalf("failed to build scheme: %v", err)
+	}
+	return fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(objs...).
+		WithStatusSubresource(&nsmv1.NetworkConnection{}).
+		Build()
+}
+
+func establishedOn(name, node, standby string) *nsmv1.NetworkConnection {
+	return &nsmv1.NetworkConnection{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "edge"},
+		Spec:       nsmv1.NetworkConnectionSpec{ConnectionType: nsmv1.ConnectionTypeKernel},
+		Status: nsmv1.NetworkConnectionStatus{
+			State:       nsmv1.ConnectionStateEstablished,
+			Established: true,
+			Node:        node,
+			ActivePath:  "primary",
+			StandbyPath: standby,
+		},
+	}
+}
+
+func TestRegistryFailsOverLostNodes(t *testing.T) {
+	c := newTestClient(t,
+		establishedOn("a-1", "edge-a", "backup"),
+		establishedOn("a-2", "edge-a", ""),
+		establishedOn("b-1", "edge-b", ""),
+	)
+	ctx := context.Background()
+	now := time.Now()
+	for _, node := range []string{"edge-a", "edge-b"} {
+		if err := NewHeartbeat(ctx, c, quietLogger(), "nsm-system", node, 30*time.Second).Renew(now); err != nil {
+			t.Fatalf("Renew() error = %v", err)
+		}
+	}
+
+	r := NewRegistry(ctx, c, quietLogger(), "nsm-system", 10*time.Second)
+	if err := r.Sync(now.Add(10 * time.Second)); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if nodes := r.Nodes(); len(nodes) != 2 || !nodes[0].Alive || !nodes[1].Alive {
+		t.Fatalf("unexpected nodes %+v", nodes)
+	}
+
+	// edge-b keeps renewing, edge-a's lease expires
+	if err := NewHeartbeat(ctx, c, quietLogger(), "nsm-system", "edge-b", 30*time.Second).Renew(now.Add(30 * time.Second)); err != nil {
+		t.Fatalf("Renew() error = %v", err)
+	}
+	if err := r.Sync(now.Add(40 * time.Second)); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if r.Alive("edge-a") || !r.Alive("edge-b") {
+		t.Fatalf("unexpected liveness %+v", r.Nodes())
+	}
+	if nodes := r.Nodes(); nodes[0].FailedOver != 2 {
+		t.Errorf("failed over %d connections, want 2", nodes[0].FailedOver)
+	}
+
+	var conn nsmv1.NetworkConnection
+	if err := c.Get(ctx, client.ObjectKey{Namespace: "edge", Name: "a-1"}, &conn); err != nil {
+		t.Fatal(err)
+	}
+	cond := meta.FindStatusCondition(conn.Status.Conditions, nsmv1.ConditionReady)
+	if conn.Status.Established || conn.Status.ActivePath != "backup" || conn.Status.StandbyPath != "" || cond == nil || cond.Reason != "NodeLost" {
+		t.Errorf("connection not failed over to its standby path: %+v", conn.Status)
+	}
+	if conn.Status.Node != "edge-b" {
+		t.Errorf("connection moved to node %q, want the surviving edge-b", conn.Status.Node)
+	}
+	if err := c.Get(ctx, client.ObjectKey{Namespace: "edge", Name: "b-1"}, &conn); err != nil {
+		t.Fatal(err)
+	}
+	if !conn.Status.Established {
+		t.Errorf("connection of a live node failed over")
+	}
+
+	// a lost node is failed over once, not on every sync
+	if err := r.Sync(now.Add(50 * time.Second)); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if nodes := r.Nodes(); nodes[0].FailedOver != 2 {
+		t.Errorf("failed over again: %d", nodes[0].FailedOver)
+	}
+
+	// deleted leases deregister their agents
+	if err := c.Delete(ctx, &coordinationv1.Lease{ObjectMeta: metav1.ObjectMeta{Namespace: "nsm-system", Name: LeaseName("edge-a")}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Sync(now.Add(60 * time.Second)); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if nodes := r.Nodes(); len(nodes) != 1 || nodes[0].Node != "edge-b" {
+		t.Errorf("unexpected nodes after deregistration %+v", nodes)
+	}
+}
+
+func TestRegistryMovesConnectionsToLeastLoadedNode(t *testing.T) {
+	pending := establishedOn("a-3", "edge-a", "")
+	pending.Status = nsmv1.NetworkConnectionStatus{State: nsmv1.ConnectionStatePending, Node: "edge-a"}
+	c := newTestClient(t,
+		establishedOn("a-1", "edge-a", ""),
+		establishedOn("a-2", "edge-a", ""),
+		pending,
+		establishedOn("b-1", "edge-b", ""),
+		establishedOn("b-2", "edge-b", ""),
+	)
+	ctx := context.Background()
+	now := time.Now()
+	for _, node := range []string{"edge-a", "edge-b", "edge-c"} {
+		if err := NewHeartbeat(ctx, c, quietLogger(), "nsm-system", node, 30*time.Second).Renew(now); err != nil {
+			t.Fatalf("Renew() error = %v", err)
+		}
+	}
+	r := NewRegistry(ctx, c, quietLogger(), "nsm-system", 10*time.Second)
+	if err := r.Sync(now); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	for _, node := range []string{"edge-b", "edge-c"} {
+		if err := NewHeartbeat(ctx, c, quietLogger(), "nsm-system", node, 30*time.Second).Renew(now.Add(30 * time.Second)); err != nil {
+			t.Fatalf("Renew() error = %v", err)
+		}
+	}
+	if err := r.Sync(now.Add(40 * time.Second)); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+
+	// edge-c takes the connections until it has as many as edge-b, the
+	// pending one is moved too
+	nodes := make(map[string]string)
+	var conns nsmv1.NetworkConnectionList
+	if err := c.List(ctx, &conns); err != nil {
+		t.Fatal(err)
+	}
+	for _, conn := range conns.Items {
+		nodes[conn.Name] = conn.Status.Node
+	}
+	want := map[string]string{"a-1": "edge-c", "a-2": "edge-c", "a-3": "edge-b", "b-1": "edge-b", "b-2": "edge-b"}
+	for name, node := range want {
+		if nodes[name] != node {
+			t.Errorf("connection %s on node %q, want %q", name, nodes[name], node)
+		}
+	}
+}
+
+// nodeShard owns a fixed set of nodes
+type nodeShard map[string]bool
+
+func (s nodeShard) Owns(node string) bool { return s[node] }
+
+func TestRegistryFailsOverNodesOfItsShard(t *testing.T) {
+	c := newTestClient(t,
+		establishedOn("a-1", "edge-a", ""),
+		establishedOn("b-1", "edge-b", ""),
+		establishedOn("c-1", "edge-c", ""),
+	)
+	ctx := context.Background()
+	now := time.Now()
+	for _, node := range []string{"edge-a", "edge-b", "edge-c"} {
+		if err := NewHeartbeat(ctx, c, quietLogger(), "nsm-system", node, 30*time.Second).Renew(now); err != nil {
+			t.Fatalf("Renew() error = %v", err)
+		}
+	}
+	r := NewRegistry(ctx, c, quietLogger(), "nsm-system", 10*time.Second)
+	r.SetShard(nodeShard{"edge-a": true})
+	if err := r.Sync(now); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if err := NewHeartbeat(ctx, c, quietLogger(), "nsm-system", "edge-c", 30*time.Second).Renew(now.Add(30 * time.Second)); err != nil {
+		t.Fatalf("Renew() error = %v", err)
+	}
+	if err := r.Sync(now.Add(40 * time.Second)); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+
+	// both edge-a and edge-b are lost, the replica of edge-b fails it over
+	for name, want := range map[string]string{"a-1": "edge-c", "b-1": "edge-b"} {
+		var conn nsmv1.NetworkConnection
+		if err := c.Get(ctx, client.ObjectKey{Namespace: "edge", Name: name}, &conn); err != nil {
+			t.Fatal(err)
+		}
+		if conn.Status.Node != want {
+			t.Errorf("connection %s on node %q, want %q", name, conn.Status.Node, want)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package anomaly
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Kinds of anomalies, also the reasons of their events
+const (
+	// KindSpike is a throughput far above the recent baseline of the
+	// connection
+	KindSpike = "TrafficSpike"
+	// KindBeaconing is traffic bursts at regular intervals on an otherwise
+	// idle connection, the pattern of malware calling home
+	KindBeaconing = "TrafficBeaconing"
+)
+
+// Tuning of the heuristics
+const (
+	// baselineWeight is the weight of a sample in the moving average the
+	// spikes are measured against
+	baselineWeight = 0.2
+	// warmupSamples is the number of samples the baseline needs before
+	// spikes are flagged
+	warmupSamples = 5
+	// minSpikeMbps keeps tiny absolute changes (1 to 5 Mbps) from being
+	// flagged as spikes
+	minSpikeMbps = 10
+	// idleMbps is the throughput below which a connection is idle, a
+	// burst starts when it goes beyond
+	idleMbps = 1
+	// beaconBursts is the number of bursts the intervals between are
+	// checked for regularity
+	beaconBursts = 6
+	// beaconMaxEntropy is the highest normalized entropy of the burst
+	// intervals still considered regular
+	beaconMaxEntropy = 0.5
+	// beaconBucket is the width of the buckets the burst intervals are
+	// counted in, relative to their mean, absorbing the sampling jitter
+	beaconBucket = 0.2
+)
+
+// Anomaly is an unusual traffic pattern of a connection
+type Anomaly struct {
+	// Namespace and name of the connection
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	// Node the connection is established on
+	Node string `json:"node,omitempty"`
+	// Kind of the anomaly (TrafficSpike, TrafficBeaconing)
+	Kind string `json:"kind"`
+	// Human-readable description
+	Message string `json:"message"`
+	// Throughput in Mbps for spikes, interval in seconds for beaconing
+	Value float64 `json:"value"`
+	// Baseline throughput in Mbps the spike was measured against, or
+	// normalized entropy of the burst intervals for beaconing
+	Baseline float64 `json:"baseline"`
+	// Time the anomaly was detected
+	DetectedAt time.Time `json:"detectedAt"`
+}
+
+// series is the recent traffic of a connection
+type series struct {
+	// Samples observed
+	samples int
+	// Moving average of the throughput in Mbps
+	baseline float64
+	// Whether the last sample was beyond the idle throughput
+	active bool
+	// Start times of the last bursts, oldest first
+	bursts []time.Time
+}
+
+// observe adds a throughput sample taken at a time and returns the
+// anomalies it reveals, spikes being samples beyond factor times the
+// baseline
+func (s *series) observe(mbps int, at time.Time, factor float64) []Anomaly {
+	var found []Anomaly
+	tput := float64(mbps)
+
+	// rate of change: a sample far beyond the moving average
+	if s.samples >= warmupSamples && mbps >= minSpikeMbps && tput >= factor*math.Max(s.baseline, idleMbps) {
+		found = append(found, Anomaly{
+			Kind:     KindSpike,
+			Message:  fmt.Sprintf("throughput jumped to %d Mbps, %.0fx the baseline of %.1f Mbps", mbps, tput/math.Max(s.baseline, idleMbps), s.baseline),
+			Value:    tput,
+			Baseline: s.baseline,
+		})
+	}
+	if s.samples == 0 {
+		s.baseline = tput
+	} else {
+		s.baseline += baselineWeight * (tput - s.baseline)
+	}
+	s.samples++
+
+	// beaconing: bursts out of idle at regular intervals
+	active := mbps >= idleMbps
+	if active && !s.active && s.samples > 1 {
+		s.bursts = append(s.bursts, at)
+		if len(s.bursts) > beaconBursts {
+			s.bursts = s.bursts[len(s.bursts)-beaconBursts:]
+		}
+		if len(s.bursts) == beaconBursts {
+			if interval, entropy := regularity(s.bursts); entropy <= beaconMaxEntropy {
+				found = append(found, Anomaly{
+					Kind: KindBeaconing,
+					Message: fmt.Sprintf("%d traffic bursts about every %s on an otherwise idle connection (interval entropy %.2f)",
+						len(s.bursts), interval.Round(time.Second), entropy),
+					Value:    interval.Seconds(),
+					Baseline: entropy,
+				})
+			}
+		}
+	}
+	s.active = active
+	return found
+}
+
+// regularity returns the mean interval between bursts and the normalized
+// Shannon entropy of the intervals: 0 when they are all alike, 1 when
+// every interval is different
+func regularity(bursts []time.Time) (time.Duration, float64) {
+	intervals := make([]float64, 0, len(bursts)-1)
+	var sum float64
+	for i := 1; i < len(bursts); i++ {
+		d := bursts[i].Sub(bursts[i-1]).Seconds()
+		intervals = append(intervals, d)
+		sum += d
+	}
+	mean := sum / float64(len(intervals))
+	width := math.Max(mean*beaconBucket, 1)
+
+	counts := make(map[int]int)
+	for _, d := range intervals {
+		counts[int(math.Round(d/width))]++
+	}
+	var entropy float64
+	n := float64(len(intervals))
+	for _, count := range counts {
+		p := float64(count) / n
+		entropy -= p * math.Log2(p)
+	}
+	return time.Duration(mean * float64(time.Second)), entropy / math.Log2(n)
+}
@@ -0,0 +1,90 @@
+package anomaly
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSeriesSpike(t *testing.T) {
+	s := &series{}
+	start := time.Now()
+	for i, mbps := range []int{20, 22, 18, 21, 19} {
+		if found := s.observe(mbps, start.Add(time.Duration(i)*time.Minute), 5); len(found) != 0 {
+			t.Fatalf("steady traffic flagged: %+v", found)
+		}
+	}
+	// within the factor of the baseline
+	if found := s.observe(60, start.Add(5*time.Minute), 5); len(found) != 0 {
+		t.Errorf("moderate increase flagged: %+v", found)
+	}
+	found := s.observe(500, start.Add(6*time.Minute), 5)
+	if len(found) != 1 || found[0].Kind != KindSpike || found[0].Value != 500 {
+		t.Fatalf("observe(500) = %+v, want a spike", found)
+	}
+
+	// small absolute jumps on idle connections aren't spikes
+	idle := &series{}
+	for i, mbps := range []int{0, 0, 1, 0, 0, 8} {
+		if found := idle.observe(mbps, start.Add(time.Duration(i)*time.Minute), 5); len(found) != 0 {
+			t.Errorf("small jump flagged: %+v", found)
+		}
+	}
+}
+
+func TestSeriesBeaconing(t *testing.T) {
+	s := &series{}
+	start := time.Now()
+	var found []Anomaly
+	// a burst every 5 minutes, sampled every 30 seconds with jitter
+	for i := range 80 {
+		at := start.Add(time.Duration(i) * 30 * time.Second)
+		mbps := 0
+		if i%10 == 1 {
+			mbps = 3
+		}
+		if i%20 == 11 {
+			at = at.Add(4 * time.Second)
+		}
+		found = append(found, s.observe(mbps, at, 5)...)
+	}
+	if len(found) == 0 || found[0].Kind != KindBeaconing {
+		t.Fatalf("found = %+v, want beaconing", found)
+	}
+	if found[0].Value < 290 || found[0].Value > 310 {
+		t.Errorf("interval = %.0fs, want about 300s", found[0].Value)
+	}
+
+	// bursts at irregular intervals are ordinary traffic
+	s = &series{}
+	gaps := []int{3, 11, 5, 17, 2, 8, 13, 4}
+	i := 0
+	for _, gap := range gaps {
+		for range gap {
+			if found := s.observe(0, start.Add(time.Duration(i)*time.Minute), 5); len(found) != 0 {
+				t.Fatalf("irregular bursts flagged: %+v", found)
+			}
+			i++
+		}
+		if found := s.observe(5, start.Add(time.Duration(i)*time.Minute), 5); len(found) != 0 {
+			t.Fatalf("irregular bursts flagged: %+v", found)
+		}
+		i++
+	}
+}
+
+func TestRegularity(t *testing.T) {
+	start := time.Now()
+	bursts := func(gaps ...int) []time.Time {
+		times := []time.Time{start}
+		for _, gap := range gaps {
+			times = append(times, times[len(times)-1].Add(time.Duration(gap)*time.Second))
+		}
+		return times
+	}
+	if interval, entropy := regularity(bursts(60, 60, 60, 60)); interval != time.Minute || entropy != 0 {
+		t.Errorf("regularity(periodic) = %s, %.2f, want 1m0s, 0", interval, entropy)
+	}
+	if _, entropy := regularity(bursts(10, 70, 200, 35)); entropy != 1 {
+		t.Errorf("regularity(irregular) entropy = %.2f, want 1", entropy)
+	}
+}
@@ -0,0 +1,233 @@
+package anomaly
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/watchdog"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var anomaliesTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "nsm_traffic_anomalies_total",
+		Help: "Traffic anomalies detected on the connections by kind (TrafficSpike, TrafficBeaconing)",
+	},
+	[]string{"kind"},
+)
+
+func init() {
+	crmetrics.Registry.MustRegister(anomaliesTotal)
+}
+
+// alertCooldown is the time an anomaly of a connection isn't reported
+// again for, so an ongoing spike doesn't flood the events and the webhook
+const alertCooldown = 15 * time.Minute
+
+// alertTimeout bounds the delivery of an alert to the webhook
+const alertTimeout = 10 * time.Second
+
+// Alerter delivers the anomalies outside the cluster
+type Alerter interface {
+	Alert(ctx context.Context, a Anomaly) error
+}
+
+// WebhookAlerter posts the anomalies as JSON to a URL
+type WebhookAlerter struct {
+	// URL the anomalies are posted to
+	url string
+	// HTTP client
+	client *http.Client
+}
+
+// NewWebhookAlerter creates a new alerter posting to url
+func NewWebhookAlerter(url string) *WebhookAlerter {
+	return &WebhookAlerter{url: url, client: &http.Client{Timeout: alertTimeout}}
+}
+
+// Alert implements Alerter
+func (w *WebhookAlerter) Alert(ctx context.Context, a Anomaly) error {
+	body, err := json.Marshal(a)
+	if err != nil {
+		return fmt.Errorf("failed to encode the alert: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create the alert request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to post the alert: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("alert webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// Detector watches the throughput of the connections for spikes and
+// beaconing, and reports them as events on the connections and, when an
+// alerter is set, to a webhook. Unattended edge sites have nobody looking
+// at the dashboards.
+type Detector struct {
+	// Context for cancellation
+	ctx context.Context
+	// Kubernetes client (controller-runtime)
+	client client.Client
+	// Logger
+	logger *logrus.Logger
+	// Interval between detections
+	interval time.Duration
+	// Multiple of the baseline throughput a spike reaches
+	spikeFactor float64
+	// Emits the events on the connections, nil for none
+	recorder record.EventRecorder
+	// Delivers the anomalies to a webhook, nil for none
+	alerter Alerter
+	// Progress signal for the watchdog
+	heartbeat *watchdog.Heartbeat
+	// Traffic of the connections, by connection
+	series map[types.NamespacedName]*series
+	// Time of the last sample of the connections, by connection
+	observed map[types.NamespacedName]time.Time
+	// Time anomalies were last reported, by connection and kind
+	reported map[string]time.Time
+}
+
+// NewDetector creates a new detector flagging throughputs beyond
+// spikeFactor times the baseline of their connection
+func NewDetector(ctx context.Context, c client.Client, logger *logrus.Logger, interval time.Duration, spikeFactor int) *Detector {
+	return &Detector{
+		ctx:         ctx,
+		client:      c,
+		logger:      logger,
+		interval:    interval,
+		spikeFactor: float64(spikeFactor),
+		series:      make(map[types.NamespacedName]*series),
+		observed:    make(map[types.NamespacedName]time.Time),
+		reported:    make(map[string]time.Time),
+	}
+}
+
+// SetRecorder makes the detector emit the anomalies as events on the
+// connections
+func (d *Detector) SetRecorder(recorder record.EventRecorder) {
+	d.recorder = recorder
+}
+
+// SetAlerter makes the detector deliver the anomalies to an alerter
+func (d *Detector) SetAlerter(alerter Alerter) {
+	d.alerter = alerter
+}
+
+// SetHeartbeat makes the detector report its progress to the watchdog
+func (d *Detector) SetHeartbeat(hb *watchdog.Heartbeat) {
+	d.heartbeat = hb
+	hb.Expect(d.interval)
+}
+
+// Start runs the detection periodically
+func (d *Detector) Start() error {
+	d.logger.Infof("Detecting traffic anomalies every %s", d.interval)
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			d.heartbeat.Beat()
+			if _, err := d.Detect(time.Now()); err != nil {
+				d.logger.WithError(err).Error("Traffic anomaly detection failed")
+			}
+		case <-d.ctx.Done():
+			return nil
+		}
+	}
+}
+
+// Detect feeds the new metrics of the established connections into their
+// series and reports the anomalies found, returning them
+func (d *Detector) Detect(now time.Time) ([]Anomaly, error) {
+	var conns nsmv1.NetworkConnectionList
+	if err := d.client.List(d.ctx, &conns); err != nil {
+		return nil, fmt.Errorf("failed to list connections: %w", err)
+	}
+
+	var found []Anomaly
+	seen := make(map[types.NamespacedName]bool, len(conns.Items))
+	for i := range conns.Items {
+		conn := &conns.Items[i]
+		key := types.NamespacedName{Namespace: conn.Namespace, Name: conn.Name}
+		updated := conn.Status.Metrics.LastUpdated
+		if !conn.Status.Established || updated == nil {
+			continue
+		}
+		seen[key] = true
+		// a sample is only counted once, however often it is listed
+		if !updated.Time.After(d.observed[key]) {
+			continue
+		}
+		d.observed[key] = updated.Time
+
+		s := d.series[key]
+		if s == nil {
+			s = &series{}
+			d.series[key] = s
+		}
+		for _, a := range s.observe(conn.Status.Metrics.ThroughputMbps, updated.Time, d.spikeFactor) {
+			a.Namespace, a.Name, a.Node, a.DetectedAt = conn.Namespace, conn.Name, conn.Status.Node, now
+			if d.report(conn, a) {
+				found = append(found, a)
+			}
+		}
+	}
+
+	// connections gone or torn down start over
+	for key := range d.series {
+		if !seen[key] {
+			delete(d.series, key)
+			delete(d.observed, key)
+			for _, kind := range []string{KindSpike, KindBeaconing} {
+				delete(d.reported, key.String()+"/"+kind)
+			}
+		}
+	}
+	return found, nil
+}
+
+// report emits an anomaly unless one of its kind was reported for the
+// connection within the cooldown, and reports whether it was emitted
+func (d *Detector) report(conn *nsmv1.NetworkConnection, a Anomaly) bool {
+	key := conn.Namespace + "/" + conn.Name + "/" + a.Kind
+	if last, ok := d.reported[key]; ok && a.DetectedAt.Sub(last) < alertCooldown {
+		return false
+	}
+	d.reported[key] = a.DetectedAt
+
+	anomaliesTotal.WithLabelValues(a.Kind).Inc()
+	d.logger.Warnf("Traffic anomaly on connection %s/%s: %s", conn.Namespace, conn.Name, a.Message)
+	if d.recorder != nil {
+		d.recorder.Event(conn, corev1.EventTypeWarning, a.Kind, a.Message)
+	}
+	if d.alerter != nil {
+		ctx, cancel := context.WithTimeout(d.ctx, alertTimeout)
+		defer cancel()
+		if err := d.alerter.Alert(ctx, a); err != nil {
+			d.logger.WithError(err).Warnf("Failed to deliver the traffic anomaly alert of connection %s/%s", conn.Namespace, conn.Name)
+		}
+	}
+	return true
+}
@@ -0,0 +1,98 @@
+package anomaly
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestDetectorReportsSpikes(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := nsmv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	conn := &nsmv1.NetworkConnection{
+		ObjectMeta: metav1.ObjectMeta{Name: "plc", Namespace: "edge"},
+		Status:     nsmv1.NetworkConnectionStatus{Established: true, Node: "edge-1"},
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(conn).WithStatusSubresource(conn).Build()
+
+	var alerts []Anomaly
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var a Anomaly
+		if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+			t.Errorf("invalid alert: %v", err)
+		}
+		alerts = append(alerts, a)
+	}))
+	defer server.Close()
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	ctx := context.Background()
+	d := NewDetector(ctx, c, logger, time.Minute, 5)
+	recorder := record.NewFakeRecorder(10)
+	d.SetRecorder(recorder)
+	d.SetAlerter(NewWebhookAlerter(server.URL))
+
+	now := time.Now()
+	detect := func(mbps int) []Anomaly {
+		t.Helper()
+		now = now.Add(time.Minute)
+		var got nsmv1.NetworkConnection
+		if err := c.Get(ctx, client.ObjectKeyFromObject(conn), &got); err != nil {
+			t.Fatal(err)
+		}
+		got.Status.Metrics.ThroughputMbps = mbps
+		got.Status.Metrics.LastUpdated = &metav1.Time{Time: now}
+		if err := c.Status().Update(ctx, &got); err != nil {
+			t.Fatal(err)
+		}
+		found, err := d.Detect(now)
+		if err != nil {
+			t.Fatalf("Detect() error = %v", err)
+		}
+		return found
+	}
+
+	for _, mbps := range []int{50, 55, 45, 50, 52} {
+		if found := detect(mbps); len(found) != 0 {
+			t.Fatalf("steady traffic flagged: %+v", found)
+		}
+	}
+	found := detect(900)
+	if len(found) != 1 || found[0].Kind != KindSpike || found[0].Node != "edge-1" {
+		t.Fatalf("Detect() = %+v, want a spike", found)
+	}
+	if event := <-recorder.Events; !strings.HasPrefix(event, "Warning TrafficSpike") {
+		t.Errorf("event = %q, want a TrafficSpike warning", event)
+	}
+	if len(alerts) != 1 || alerts[0].Name != "plc" || alerts[0].Kind != KindSpike {
+		t.Errorf("alerts = %+v, want the spike", alerts)
+	}
+
+	// the sample isn't counted again, nor the ongoing spike reported again
+	// within the cooldown
+	if found, _ := d.Detect(now); len(found) != 0 {
+		t.Errorf("same sample reported again: %+v", found)
+	}
+	if found := detect(5000); len(found) != 0 {
+		t.Errorf("spike reported within the cooldown: %+v", found)
+	}
+	if len(alerts) != 1 {
+		t.Errorf("alerts = %d, want 1", len(alerts))
+	}
+}
@@ -0,0 +1,171 @@
+package anycast
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/external"
+	"github.com/akos011221/nsm/pkg/watchdog"
+	"github.com/sirupsen/logrus"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// AnnotationAnycast opts a NetworkService into route health injection
+const AnnotationAnycast = "nsm.akosrbn.io/anycast"
+
+// Injector announces the IPs of healthy anycast services from this edge
+// node and withdraws them when the service is no longer healthy, so
+// upstream routers steer traffic to healthy sites
+type Injector struct {
+	// Context for cancellation
+	ctx context.Context
+	// Kubernetes client (controller-runtime)
+	client client.Client
+	// Logger
+	logger *logrus.Logger
+	// BGP speaker
+	speaker Speaker
+	// Currently announced prefixes
+	announced map[string]bool
+	// Mutex for protecting the announced prefixes
+	mu sync.Mutex
+	// Poll interval for service health
+	pollInterval time.Duration
+	// Progress signal for the watchdog
+	heartbeat *watchdog.Heartbeat
+}
+
+// NewInjector creates a new route health injector
+func NewInjector(ctx context.Context, c client.Client, logger *logrus.Logger, speaker Speaker) *Injector {
+	return &Injector{
+		ctx:          ctx,
+		client:       c,
+		logger:       logger,
+		speaker:      speaker,
+		announced:    make(map[string]bool),
+		pollInterval: 5 * time.Second,
+	}
+}
+
+// SetHeartbeat makes the injector report its progress to the watchdog
+func (i *Injector) SetHeartbeat(hb *watchdog.Heartbeat) {
+	i.heartbeat = hb
+	hb.Expect(i.pollInterval)
+}
+
+// Start periodically syncs the announcements with the service health
+func (i *Injector) Start() error {
+	i.logger.Info("Starting route health injector")
+
+	ticker := time.NewTicker(i.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			i.heartbeat.Beat()
+
+			var services nsmv1.NetworkServiceList
+			if err := i.client.List(i.ctx, &services); err != nil {
+				i.logger.WithError(err).Error("Failed to list network services")
+				continue
+			}
+			if err := i.Sync(services.Items); err != nil {
+				i.logger.WithError(err).Error("Route health injection sync failed")
+			}
+
+		case <-i.ctx.Done():
+			// withdraw everything so traffic isn't sent to a stopped node
+			i.Sync(nil)
+			i.logger.Info("Stopping route health injector")
+			return nil
+		}
+	}
+}
+
+// Sync announces the prefixes of healthy services and withdraws the rest
+func (i *Injector) Sync(services []nsmv1.NetworkService) error {
+	desired := DesiredPrefixes(services)
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	var errs []error
+
+	// withdraw first, a withdrawn unhealthy service is more important than
+	// announcing a new healthy one
+	for _, prefix := range sortedKeys(i.announced) {
+		if desired[prefix] {
+			continue
+		}
+		if err := i.speaker.Withdraw(prefix); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		delete(i.announced, prefix)
+		i.logger.Infof("Withdrew route for %s", prefix)
+	}
+
+	for _, prefix := range sortedKeys(desired) {
+		if i.announced[prefix] {
+			continue
+		}
+		if err := i.speaker.Announce(prefix); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		i.announced[prefix] = true
+		i.logger.Infof("Announced route for %s", prefix)
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to update %d announcements: %v", len(errs), errs)
+	}
+	return nil
+}
+
+// DesiredPrefixes returns the host prefixes of the anycast services that
+// are healthy. Only Ready services are announced, Degraded ones are
+// withdrawn so that a fully healthy site is preferred.
+func DesiredPrefixes(services []nsmv1.NetworkService) map[string]bool {
+	desired := make(map[string]bool)
+	for _, svc := range services {
+		if svc.Annotations[AnnotationAnycast] != "true" {
+			continue
+		}
+		if svc.Status.Phase != nsmv1.ServicePhaseReady || svc.DeletionTimestamp != nil {
+			continue
+		}
+
+		host := external.Endpoint(&svc)
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		ip := net.ParseIP(host)
+		if ip == nil {
+			continue
+		}
+
+		if ip.To4() != nil {
+			desired[ip.String()+"/32"] = true
+		} else {
+			desired[ip.String()+"/128"] = true
+		}
+	}
+	return desired
+}
+
+// sortedKeys returns the keys of the set in a stable order
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,96 @@
+package anycast
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type fakeSpeaker struct {
+	announced map[string]bool
+	fail      bool
+}
+
+func (f *fakeSpeaker) Announce(prefix string) error {
+	if f.fail {
+		return errors.New("speaker down")
+	}
+	f.announced[prefix] = true
+	return nil
+}
+
+func (f *fakeSpeaker) Withdraw(prefix string) error {
+	delete(f.announced, prefix)
+	return nil
+}
+
+func service(name, endpoint, phase string, anycast bool) nsmv1.NetworkService {
+	svc := nsmv1.NetworkService{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Annotations: map[string]string{}},
+		Spec:       nsmv1.NetworkServiceSpec{Endpoint: endpoint},
+		Status:     nsmv1.NetworkServiceStatus{Phase: phase},
+	}
+	if anycast {
+		svc.Annotations[AnnotationAnycast] = "true"
+	}
+	return svc
+}
+
+func TestDesiredPrefixes(t *testing.T) {
+	got := DesiredPrefixes([]nsmv1.NetworkService{
+		service("ready", "198.51.100.10:443", nsmv1.ServicePhaseReady, true),
+		service("v6", "2001:db8::1", nsmv1.ServicePhaseReady, true),
+		service("degraded", "198.51.100.11", nsmv1.ServicePhaseDegraded, true),
+		service("not-anycast", "198.51.100.12", nsmv1.ServicePhaseReady, false),
+		service("dns", "svc.example.com", nsmv1.ServicePhaseReady, true),
+	})
+
+	if len(got) != 2 || !got["198.51.100.10/32"] || !got["2001:db8::1/128"] {
+		t.Errorf("DesiredPrefixes() = %v", got)
+	}
+}
+
+func TestInjectorSync(t *testing.T) {
+	speaker := &fakeSpeaker{announced: make(map[string]bool)}
+	inj := NewInjector(context.Background(), nil, logrus.New(), speaker)
+
+	healthy := service("a", "198.51.100.10", nsmv1.ServicePhaseReady, true)
+	if err := inj.Sync([]nsmv1.NetworkService{healthy}); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if !speaker.announced["198.51.100.10/32"] {
+		t.Fatalf("healthy service was not announced")
+	}
+
+	// the service becomes unhealthy, so the route must be withdrawn
+	unhealthy := healthy
+	unhealthy.Status.Phase = nsmv1.ServicePhaseDegraded
+	if err := inj.Sync([]nsmv1.NetworkService{unhealthy}); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if len(speaker.announced) != 0 {
+		t.Errorf("unhealthy service still announced: %v", speaker.announced)
+	}
+}
+
+func TestInjectorSyncRetriesFailedAnnouncements(t *testing.T) {
+	speaker := &fakeSpeaker{announced: make(map[string]bool), fail: true}
+	inj := NewInjector(context.Background(), nil, logrus.New(), speaker)
+	services := []nsmv1.NetworkService{service("a", "198.51.100.10", nsmv1.ServicePhaseReady, true)}
+
+	if err := inj.Sync(services); err == nil {
+		t.Fatalf("expected error from failing speaker")
+	}
+
+	speaker.fail = false
+	if err := inj.Sync(services); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if !speaker.announced["198.51.100.10/32"] {
+		t.Errorf("failed announcement was not retried")
+	}
+}
@@ -0,0 +1,67 @@
+package anycast
+
+import (
+	"fmt"
+	"net"
+	"os/exec"
+	"strings"
+)
+
+// Speaker announces and withdraws prefixes to the upstream routers
+type Speaker interface {
+	// Announce advertises the prefix
+	Announce(prefix string) error
+	// Withdraw stops advertising the prefix
+	Withdraw(prefix string) error
+}
+
+// GoBGPSpeaker drives a local gobgpd through the gobgp CLI
+type GoBGPSpeaker struct {
+	// Path of the gobgp binary
+	binary string
+	// Next hop to announce the prefixes with (empty for self)
+	nextHop string
+}
+
+// NewGoBGPSpeaker creates a speaker using the gobgp binary
+func NewGoBGPSpeaker(binary, nextHop string) *GoBGPSpeaker {
+	if binary == "" {
+		binary = "gobgp"
+	}
+	return &GoBGPSpeaker{
+		binary:  binary,
+		nextHop: nextHop,
+	}
+}
+
+// Announce adds the prefix to the global RIB of gobgpd
+func (s *GoBGPSpeaker) Announce(prefix string) error {
+	args := append([]string{"global", "rib", "add"}, s.prefixArgs(prefix)...)
+	if s.nextHop != "" {
+		args = append(args, "nexthop", s.nextHop)
+	}
+	return s.run(args...)
+}
+
+// Withdraw removes the prefix from the global RIB of gobgpd
+func (s *GoBGPSpeaker) Withdraw(prefix string) error {
+	return s.run(append([]string{"global", "rib", "del"}, s.prefixArgs(prefix)...)...)
+}
+
+// prefixArgs returns the prefix and its address family arguments
+func (s *GoBGPSpeaker) prefixArgs(prefix string) []string {
+	family := "ipv4"
+	if ip, _, err := net.ParseCIDR(prefix); err == nil && ip.To4() == nil {
+		family = "ipv6"
+	}
+	return []string{prefix, "-a", family}
+}
+
+// run runs the gobgp CLI with the given arguments
+func (s *GoBGPSpeaker) run(args ...string) error {
+	out, err := exec.Command(s.binary, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s %s failed: %w: %s", s.binary, strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package api
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// errUnauthorized is returned to requests changing state without the token
+var errUnauthorized = errors.New("unauthorized, the operation needs the bearer token of the management API")
+
+// Authorized checks the bearer token of the request in constant time,
+// no request is authorized without a token
+func Authorized(r *http.Request, token string) bool {
+	if token == "" {
+		return false
+	}
+	got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
+
+// readOnly reports whether the request can't change state
+func readOnly(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+// requireToken serves read-only requests to anyone, and the requests that
+// change state (bulk operations, VF releases, leases...) only with the
+// bearer token
+func requireToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !readOnly(r) && !Authorized(r, token) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="nsm"`)
+			WriteError(w, http.StatusUnauthorized, errUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestServerRequiresTokenToChangeState(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	serve := func(s *Server, method, path, auth string) int {
+		req := httptest.NewRequest(method, path, nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	s := NewServer(context.Background(), logrus.New(), "")
+	s.SetToken("s3cret")
+	s.Handle("GET /v1/hardware/sriov/vfs", ok)
+	s.Handle("POST /v1/connections/bulk", ok)
+	s.Handle("DELETE /v1/hardware/sriov/vfs/{namespace}/{name}", ok)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		auth   string
+		want   int
+	}{
+		{"read without token", http.MethodGet, "/v1/hardware/sriov/vfs", "", http.StatusOK},
+		{"bulk without token", http.MethodPost, "/v1/connections/bulk", "", http.StatusUnauthorized},
+		{"bulk with wrong token", http.MethodPost, "/v1/connections/bulk", "Bearer nope", http.StatusUnauthorized},
+		{"bulk with token", http.MethodPost, "/v1/connections/bulk", "Bearer s3cret", http.StatusOK},
+		{"release without token", http.MethodDelete, "/v1/hardware/sriov/vfs/edge/cam", "", http.StatusUnauthorized},
+		{"release with token", http.MethodDelete, "/v1/hardware/sriov/vfs/edge/cam", "Bearer s3cret", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := serve(s, tt.method, tt.path, tt.auth); got != tt.want {
+				t.Errorf("%s %s = %d, want %d", tt.method, tt.path, got, tt.want)
+			}
+		})
+	}
+
+	// without a token nothing changing state is served
+	open := NewServer(context.Background(), logrus.New(), "")
+	open.Handle("POST /v1/connections/bulk", ok)
+	if got := serve(open, http.MethodPost, "/v1/connections/bulk", "Bearer "); got != http.StatusUnauthorized {
+		t.Errorf("POST without a configured token = %d, want 401", got)
+	}
+}
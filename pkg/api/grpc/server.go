@@ -0,0 +1,205 @@
+// Package grpc serves the SR-IOV VFs of the node to local agents (CNI
+// plugins, device plugins, sidecars) over gRPC on a unix socket, so they
+// can request, release and watch VFs without importing NSM.
+package grpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	inventoryv1 "github.com/akos011221/nsm/api/grpc/inventory/v1"
+	vfv1 "github.com/akos011221/nsm/api/grpc/vf/v1"
+	"github.com/akos011221/nsm/pkg/hardware"
+	"github.com/akos011221/nsm/pkg/inventorystream"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// VFs leases the SR-IOV VFs of the node
+type VFs interface {
+	inventorystream.Inventory
+	LeaseVF(namespace, podName string, ttl time.Duration) (hardware.Lease, error)
+	ReleaseLease(namespace, podName string) bool
+}
+
+// Server serves the VF API over a unix socket only root on the node can
+// reach. VFs are handed out as leases, renewed while the pod exists, so an
+// agent crashing between a request and its release can't leak them.
+type Server struct {
+	vfv1.UnimplementedVirtualFunctionsServer
+
+	// Context for cancellation
+	ctx context.Context
+	// Logger
+	logger *logrus.Logger
+	// Path of the unix socket
+	socketPath string
+	// SR-IOV inventory of the node
+	vfs VFs
+	// Longest lease, given to requests without a TTL
+	maxTTL time.Duration
+	// Inventory streaming
+	inventory *inventorystream.Server
+}
+
+// NewServer creates a new VF API server
+func NewServer(ctx context.Context, logger *logrus.Logger, socketPath string, vfs VFs, maxTTL time.Duration) *Server {
+	return &Server{
+		ctx:        ctx,
+		logger:     logger,
+		socketPath: socketPath,
+		vfs:        vfs,
+		maxTTL:     maxTTL,
+		inventory:  inventorystream.NewServer(ctx, logger, vfs),
+	}
+}
+
+// Start serves the API on the socket until the context is done
+func (s *Server) Start() error {
+	s.logger.Infof("Starting VF API on %s", s.socketPath)
+
+	if err := os.MkdirAll(filepath.Dir(s.socketPath), 0o755); err != nil {
+		return fmt.Errorf("failed to create the directory of the VF socket: %w", err)
+	}
+	// the socket of a previous run is left behind on crashes
+	if err := os.Remove(s.socketPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove the stale VF socket: %w", err)
+	}
+	lis, err := listenPrivate(s.socketPath)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(s.socketPath)
+	return s.Serve(lis)
+}
+
+// listenPrivate listens on a unix socket only its owner can connect to.
+// The socket is created in a directory of its own with mode 0700 and
+// restricted there, then moved into place, so it never exists at the path
+// with the permissions of the umask.
+func listenPrivate(path string) (net.Listener, error) {
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".vf-socket-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the directory of the VF socket: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	tmp := filepath.Join(dir, filepath.Base(path))
+	lis, err := net.Listen("unix", tmp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on the VF socket: %w", err)
+	}
+	// the socket is moved, so the listener can't remove it on close
+	lis.(*net.UnixListener).SetUnlinkOnClose(false)
+	if err := os.Chmod(tmp, 0o600); err != nil {
+		lis.Close()
+		return nil, fmt.Errorf("failed to restrict the VF socket: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		lis.Close()
+		return nil, fmt.Errorf("failed to move the VF socket into place: %w", err)
+	}
+	return lis, nil
+}
+
+// Serve serves the API on the listener until the context is done
+func (s *Server) Serve(lis net.Listener) error {
+	srv := grpc.NewServer()
+	vfv1.RegisterVirtualFunctionsServer(srv, s)
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.Serve(lis)
+	}()
+
+	select {
+	case err := <-errCh:
+		return fmt.Errorf("VF API failed: %w", err)
+	case <-s.ctx.Done():
+		// the watches end with the context, so this doesn't block
+		s.logger.Info("Stopping VF API")
+		srv.GracefulStop()
+		return nil
+	}
+}
+
+// RequestVF leases a free VF to a pod, or renews the lease it holds
+func (s *Server) RequestVF(ctx context.Context, req *vfv1.RequestVFRequest) (*vfv1.VFLease, error) {
+	if req.GetNamespace() == "" || req.GetPod() == "" {
+		return nil, status.Error(codes.InvalidArgument, "namespace and pod are required")
+	}
+	ttl := time.Duration(req.GetTtlSeconds()) * time.Second
+	if ttl == 0 {
+		ttl = s.maxTTL
+	}
+	if ttl < 0 || ttl > s.maxTTL {
+		return nil, status.Errorf(codes.InvalidArgument, "TTL must be between 1 and %d seconds", int(s.maxTTL.Seconds()))
+	}
+
+	lease, err := s.vfs.LeaseVF(req.GetNamespace(), req.GetPod(), ttl)
+	switch {
+	case errors.Is(err, hardware.ErrNoFreeVF):
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
+	case err != nil:
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+
+	var vf *inventoryv1.VirtualFunction
+	for _, candidate := range s.vfs.VirtualFunctions() {
+		if candidate.PCIAddress == lease.PCIAddress {
+			vf = inventorystream.VirtualFunction(candidate)
+		}
+	}
+	s.logger.Infof("Leased VF %s (%s) to pod %s/%s for a local agent", lease.VF, lease.PCIAddress, lease.Namespace, lease.Pod)
+	return &vfv1.VFLease{
+		Namespace:  lease.Namespace,
+		Pod:        lease.Pod,
+		Vf:         vf,
+		TtlSeconds: int32(lease.TTLSeconds),
+		Expires:    timestamppb.New(lease.Expires),
+	}, nil
+}
+
+// ReleaseVF frees the VF leased to a pod
+func (s *Server) ReleaseVF(ctx context.Context, req *vfv1.ReleaseVFRequest) (*vfv1.ReleaseVFResponse, error) {
+	if req.GetNamespace() == "" || req.GetPod() == "" {
+		return nil, status.Error(codes.InvalidArgument, "namespace and pod are required")
+	}
+	if !s.vfs.ReleaseLease(req.GetNamespace(), req.GetPod()) {
+		return nil, status.Errorf(codes.NotFound, "pod %s/%s holds no VF lease", req.GetNamespace(), req.GetPod())
+	}
+	s.logger.Infof("Released the VF of pod %s/%s for a local agent", req.GetNamespace(), req.GetPod())
+	return &vfv1.ReleaseVFResponse{}, nil
+}
+
+// ListVFs lists the VFs of a PF (all if empty), ordered by PCI address
+func (s *Server) ListVFs(ctx context.Context, req *vfv1.ListVFsRequest) (*vfv1.ListVFsResponse, error) {
+	vfs := s.vfs.VirtualFunctions()
+	sort.Slice(vfs, func(i, j int) bool { return vfs[i].PCIAddress < vfs[j].PCIAddress })
+
+	resp := &vfv1.ListVFsResponse{}
+	for _, vf := range vfs {
+		if req.GetPfName() != "" && vf.PFName != req.GetPfName() {
+			continue
+		}
+		if req.GetFreeOnly() && vf.Allocated {
+			continue
+		}
+		resp.Vfs = append(resp.Vfs, inventorystream.VirtualFunction(vf))
+	}
+	return resp, nil
+}
+
+// WatchInventory streams the changes of the SR-IOV inventory
+func (s *Server) WatchInventory(req *inventoryv1.WatchInventoryRequest, stream vfv1.VirtualFunctions_WatchInventoryServer) error {
+	return s.inventory.WatchInventory(req, stream)
+}
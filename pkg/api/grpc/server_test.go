@@ -0,0 +1,203 @@
+package grpc
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	inventoryv1 "github.com/akos011221/nsm/api/grpc/inventory/v1"
+	vfv1 "github.com/akos011221/nsm/api/grpc/vf/v1"
+	"github.com/akos011221/nsm/pkg/hardware"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// fakeVFs leases the VFs of a PF in memory
+type fakeVFs struct {
+	mu  sync.Mutex
+	vfs []hardware.VirtualFunction
+}
+
+func (f *fakeVFs) PhysicalFunctions() []hardware.PhysicalFunction {
+	return []hardware.PhysicalFunction{{Name: "eth0", PCIAddress: "0000:03:00.0", NumVFs: len(f.vfs)}}
+}
+
+func (f *fakeVFs) VirtualFunctions() []hardware.VirtualFunction {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]hardware.VirtualFunction(nil), f.vfs...)
+}
+
+func (f *fakeVFs) LeaseVF(namespace, podName string, ttl time.Duration) (hardware.Lease, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := range f.vfs {
+		if !f.vfs[i].Allocated {
+			f.vfs[i].Allocated, f.vfs[i].Namespace, f.vfs[i].AllocatedTo = true, namespace, podName
+			return hardware.Lease{Namespace: namespace, Pod: podName, PCIAddress: f.vfs[i].PCIAddress,
+				TTLSeconds: int(ttl.Seconds()), Expires: time.Now().Add(ttl)}, nil
+		}
+	}
+	return hardware.Lease{}, hardware.ErrNoFreeVF
+}
+
+func (f *fakeVFs) ReleaseLease(namespace, podName string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := range f.vfs {
+		if f.vfs[i].Allocated && f.vfs[i].Namespace == namespace && f.vfs[i].AllocatedTo == podName {
+			f.vfs[i].Allocated, f.vfs[i].Namespace, f.vfs[i].AllocatedTo = false, "", ""
+			return true
+		}
+	}
+	return false
+}
+
+// startServer serves the API over an in-memory listener and returns a client
+func startServer(t *testing.T, vfs VFs) vfv1.VirtualFunctionsClient {
+	t.Helper()
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	ctx, cancel := context.WithCancel(context.Background())
+	s := NewServer(ctx, logger, "", vfs, time.Hour)
+
+	lis := bufconn.Listen(1 << 20)
+	go s.Serve(lis)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		cancel()
+	})
+	return vfv1.NewVirtualFunctionsClient(conn)
+}
+
+func TestRequestAndReleaseVF(t *testing.T) {
+	vfs := &fakeVFs{vfs: []hardware.VirtualFunction{
+		{PFName: "eth0", VFID: 1, PCIAddress: "0000:03:00.2", InterfaceName: "eth0v1"},
+		{PFName: "eth0", VFID: 0, PCIAddress: "0000:03:00.1", InterfaceName: "eth0v0"},
+	}}
+	client := startServer(t, vfs)
+	ctx := context.Background()
+
+	lease, err := client.RequestVF(ctx, &vfv1.RequestVFRequest{Namespace: "default", Pod: "dpdk-app"})
+	if err != nil {
+		t.Fatalf("RequestVF() error = %v", err)
+	}
+	if lease.GetVf().GetInterfaceName() != "eth0v1" || lease.GetTtlSeconds() != 3600 || !lease.GetVf().GetAllocated() {
+		t.Errorf("unexpected lease: %v", lease)
+	}
+
+	list, err := client.ListVFs(ctx, &vfv1.ListVFsRequest{FreeOnly: true})
+	if err != nil {
+		t.Fatalf("ListVFs() error = %v", err)
+	}
+	if len(list.GetVfs()) != 1 || list.GetVfs()[0].GetPciAddress() != "0000:03:00.1" {
+		t.Errorf("unexpected free VFs: %v", list.GetVfs())
+	}
+	list, err = client.ListVFs(ctx, &vfv1.ListVFsRequest{PfName: "eth0"})
+	if err != nil || len(list.GetVfs()) != 2 || list.GetVfs()[0].GetPciAddress() != "0000:03:00.1" {
+		t.Errorf("ListVFs() = %v, %v, want both VFs ordered by PCI address", list.GetVfs(), err)
+	}
+
+	if _, err := client.ReleaseVF(ctx, &vfv1.ReleaseVFRequest{Namespace: "default", Pod: "dpdk-app"}); err != nil {
+		t.Fatalf("ReleaseVF() error = %v", err)
+	}
+	_, err = client.ReleaseVF(ctx, &vfv1.ReleaseVFRequest{Namespace: "default", Pod: "dpdk-app"})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("second ReleaseVF() error = %v, want NotFound", err)
+	}
+}
+
+func TestRequestVFErrors(t *testing.T) {
+	vfs := &fakeVFs{vfs: []hardware.VirtualFunction{{PFName: "eth0", PCIAddress: "0000:03:00.1", Allocated: true}}}
+	client := startServer(t, vfs)
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		req  *vfv1.RequestVFRequest
+		code codes.Code
+	}{
+		{"missing pod", &vfv1.RequestVFRequest{Namespace: "default"}, codes.InvalidArgument},
+		{"TTL over the maximum", &vfv1.RequestVFRequest{Namespace: "default", Pod: "a", TtlSeconds: 7200}, codes.InvalidArgument},
+		{"no free VF", &vfv1.RequestVFRequest{Namespace: "default", Pod: "a", TtlSeconds: 60}, codes.ResourceExhausted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := client.RequestVF(ctx, tt.req); status.Code(err) != tt.code {
+				t.Errorf("RequestVF() error = %v, want %s", err, tt.code)
+			}
+		})
+	}
+}
+
+func TestWatchInventory(t *testing.T) {
+	vfs := &fakeVFs{vfs: []hardware.VirtualFunction{{PFName: "eth0", VFID: 0, PCIAddress: "0000:03:00.1"}}}
+	client := startServer(t, vfs)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stream, err := client.WatchInventory(ctx, &inventoryv1.WatchInventoryRequest{})
+	if err != nil {
+		t.Fatalf("WatchInventory() error = %v", err)
+	}
+	var types []inventoryv1.EventType
+	for len(types) < 3 {
+		event, err := stream.Recv()
+		if err != nil {
+			t.Fatalf("Recv() error = %v", err)
+		}
+		types = append(types, event.GetType())
+	}
+	if types[0] != inventoryv1.EventType_EVENT_TYPE_ADDED || types[2] != inventoryv1.EventType_EVENT_TYPE_SYNCED {
+		t.Errorf("event types = %v, want the PF and VF added then synced", types)
+	}
+}
+
+func TestListenPrivate(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "vf.sock")
+	lis, err := listenPrivate(path)
+	if err != nil {
+		t.Fatalf("listenPrivate() error = %v", err)
+	}
+	defer lis.Close()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("socket not in place: %v", err)
+	}
+	if info.Mode()&os.ModeSocket == 0 || info.Mode().Perm() != 0o600 {
+		t.Errorf("socket mode = %v, want a socket with 0600", info.Mode())
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) != 1 {
+		t.Errorf("directory entries = %v, %v, want the socket only", entries, err)
+	}
+
+	go func() {
+		if conn, err := lis.Accept(); err == nil {
+			conn.Close()
+		}
+	}()
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("failed to connect to the moved socket: %v", err)
+	}
+	conn.Close()
+}
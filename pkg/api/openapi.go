@@ -0,0 +1,252 @@
+package api
+
+import (
+	"net/http"
+	"path"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// OpenAPIPath is where the OpenAPI document of the management API is served
+const OpenAPIPath = "/v1/openapi.json"
+
+// Operation documents an endpoint of the management API
+type Operation struct {
+	// Identifier of the operation, used as method name by SDK generators
+	ID string
+	// One-line summary
+	Summary string
+	// Optional string query parameters
+	Query []string
+	// Request body, nil if the endpoint takes none
+	Request interface{}
+	// Response body on success, nil if the endpoint answers 204 No Content
+	Response interface{}
+}
+
+// Document is an OpenAPI 3.0 document
+type Document struct {
+	OpenAPI    string                               `json:"openapi"`
+	Info       Info                                 `json:"info"`
+	Paths      map[string]map[string]*PathOperation `json:"paths"`
+	Components Components                           `json:"components"`
+}
+
+// Info describes the API
+type Info struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+// PathOperation is an operation on a path
+type PathOperation struct {
+	OperationID string               `json:"operationId"`
+	Summary     string               `json:"summary,omitempty"`
+	Parameters  []*Parameter         `json:"parameters,omitempty"`
+	RequestBody *Body                `json:"requestBody,omitempty"`
+	Responses   map[string]*Response `json:"responses"`
+}
+
+// Parameter is a path or query parameter
+type Parameter struct {
+	Name     string  `json:"name"`
+	In       string  `json:"in"`
+	Required bool    `json:"required"`
+	Schema   *Schema `json:"schema"`
+}
+
+// Body is a JSON request body
+type Body struct {
+	Required bool                  `json:"required"`
+	Content  map[string]*MediaType `json:"content"`
+}
+
+// Response is a JSON response
+type Response struct {
+	Description string                `json:"description"`
+	Content     map[string]*MediaType `json:"content,omitempty"`
+}
+
+// MediaType holds the schema of a body
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// Components holds the named schemas
+type Components struct {
+	Schemas map[string]*Schema `json:"schemas"`
+}
+
+// Schema is a JSON schema
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+}
+
+// ErrorResponse is the body of every error response
+type ErrorResponse struct {
+	// Error message
+	Error string `json:"error"`
+}
+
+// NewDocument builds the OpenAPI document of the operations, keyed by
+// their pattern (e.g., "POST /v1/connections/bulk"). The schemas are
+// derived from the Go types, so the document can't drift from the code.
+func NewDocument(title, version string, ops map[string]Operation) *Document {
+	doc := &Document{
+		OpenAPI:    "3.0.3",
+		Info:       Info{Title: title, Version: version},
+		Paths:      make(map[string]map[string]*PathOperation),
+		Components: Components{Schemas: make(map[string]*Schema)},
+	}
+	errSchema := doc.schema(reflect.TypeOf(ErrorResponse{}))
+
+	for pattern, op := range ops {
+		method, route, _ := strings.Cut(pattern, " ")
+		pathOp := &PathOperation{
+			OperationID: op.ID,
+			Summary:     op.Summary,
+			Responses: map[string]*Response{
+				"default": {Description: "Error", Content: jsonContent(errSchema)},
+			},
+		}
+		if op.Response != nil {
+			pathOp.Responses["200"] = &Response{Description: "Success", Content: jsonContent(doc.schema(reflect.TypeOf(op.Response)))}
+		} else {
+			pathOp.Responses["204"] = &Response{Description: "No content"}
+		}
+		// wildcards of the route (e.g., {name}) are path parameters
+		for _, segment := range strings.Split(route, "/") {
+			if name, ok := strings.CutPrefix(segment, "{"); ok {
+				name = strings.TrimSuffix(strings.TrimSuffix(name, "}"), "...")
+				pathOp.Parameters = append(pathOp.Parameters, &Parameter{Name: name, In: "path", Required: true, Schema: &Schema{Type: "string"}})
+			}
+		}
+		for _, name := range op.Query {
+			pathOp.Parameters = append(pathOp.Parameters, &Parameter{Name: name, In: "query", Schema: &Schema{Type: "string"}})
+		}
+		if op.Request != nil {
+			pathOp.RequestBody = &Body{Required: true, Content: jsonContent(doc.schema(reflect.TypeOf(op.Request)))}
+		}
+
+		if doc.Paths[route] == nil {
+			doc.Paths[route] = make(map[string]*PathOperation)
+		}
+		doc.Paths[route][strings.ToLower(method)] = pathOp
+	}
+
+	return doc
+}
+
+// Handler serves the document
+func (d *Document) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		WriteJSON(w, http.StatusOK, d)
+	})
+}
+
+// schema returns the schema of a Go type, named structs are added to the
+// components and referenced
+func (d *Document) schema(t reflect.Type) *Schema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	// metav1.Time marshals like time.Time
+	if t == reflect.TypeOf(time.Time{}) || (t.Name() == "Time" && strings.HasSuffix(t.PkgPath(), "apis/meta/v1")) {
+		return &Schema{Type: "string", Format: "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Int64, reflect.Uint, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: d.schema(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: d.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return d.object(t)
+		}
+		name := schemaName(t)
+		if _, ok := d.Components.Schemas[name]; !ok {
+			// reserve the name first, so recursive types terminate
+			d.Components.Schemas[name] = &Schema{}
+			*d.Components.Schemas[name] = *d.object(t)
+		}
+		return &Schema{Ref: "#/components/schemas/" + name}
+	default:
+		return &Schema{}
+	}
+}
+
+// object returns the object schema of a struct, following the encoding/json
+// rules: fields without omitempty are required, embedded structs are inlined
+func (d *Document) object(t reflect.Type) *Schema {
+	s := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, opts, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+
+		// the exported fields of embedded structs are promoted, even if the
+		// struct type itself is unexported
+		embeddedType := field.Type
+		if embeddedType.Kind() == reflect.Ptr {
+			embeddedType = embeddedType.Elem()
+		}
+		if field.Anonymous && name == "" && embeddedType.Kind() == reflect.Struct {
+			embedded := d.object(embeddedType)
+			for prop, schema := range embedded.Properties {
+				s.Properties[prop] = schema
+			}
+			s.Required = append(s.Required, embedded.Required...)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+		s.Properties[name] = d.schema(field.Type)
+		if !strings.Contains(opts, "omitempty") {
+			s.Required = append(s.Required, name)
+		}
+	}
+	return s
+}
+
+// schemaName qualifies the name of a type with its package, so generic
+// names (bulk.Request) stay unique and meaningful in generated SDKs
+func schemaName(t reflect.Type) string {
+	pkg := path.Base(t.PkgPath())
+	if t.PkgPath() == reflect.TypeOf(Document{}).PkgPath() || pkg == "." {
+		return t.Name()
+	}
+	return strings.ToUpper(pkg[:1]) + pkg[1:] + t.Name()
+}
+
+// jsonContent wraps a schema as JSON content
+func jsonContent(schema *Schema) map[string]*MediaType {
+	return map[string]*MediaType{"application/json": {Schema: schema}}
+}
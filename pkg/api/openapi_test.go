@@ -0,0 +1,130 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type testBase struct {
+	ID string `json:"id"`
+}
+
+type testItem struct {
+	testBase
+	Name     string            `json:"name"`
+	Count    int               `json:"count,omitempty"`
+	Tags     map[string]string `json:"tags,omitempty"`
+	Children []testItem        `json:"children,omitempty"`
+	Created  metav1.Time       `json:"created"`
+	Seen     *time.Time        `json:"seen,omitempty"`
+	internal bool
+	Ignored  string `json:"-"`
+}
+
+func TestNewDocument(t *testing.T) {
+	doc := NewDocument("test", "v1", map[string]Operation{
+		"POST /v1/items": {ID: "createItem", Request: testItem{}, Response: testItem{}},
+		"GET /v1/items":  {ID: "listItems", Response: []testItem{}},
+	})
+
+	ops := doc.Paths["/v1/items"]
+	if ops["post"] == nil || ops["get"] == nil {
+		t.Fatalf("missing operations: %+v", ops)
+	}
+	if ops["get"].RequestBody != nil || ops["post"].RequestBody == nil {
+		t.Errorf("request bodies not derived from the operations")
+	}
+	list := ops["get"].Responses["200"].Content["application/json"].Schema
+	if list.Type != "array" || list.Items.Ref != "#/components/schemas/testItem" {
+		t.Errorf("unexpected list schema %+v", list)
+	}
+	if ops["post"].Responses["default"].Content["application/json"].Schema.Ref != "#/components/schemas/ErrorResponse" {
+		t.Errorf("errors not documented")
+	}
+
+	item := doc.Components.Schemas["testItem"]
+	if item == nil {
+		t.Fatalf("item schema not in components: %v", doc.Components.Schemas)
+	}
+	var props []string
+	for name := range item.Properties {
+		props = append(props, name)
+	}
+	if len(props) != 7 {
+		t.Errorf("properties = %v, want id (embedded), name, count, tags, children, created, seen", props)
+	}
+	if !reflect.DeepEqual(item.Required, []string{"id", "name", "created"}) {
+		t.Errorf("required = %v", item.Required)
+	}
+	if s := item.Properties["created"]; s.Type != "string" || s.Format != "date-time" {
+		t.Errorf("metav1.Time schema = %+v", s)
+	}
+	if s := item.Properties["tags"]; s.Type != "object" || s.AdditionalProperties.Type != "string" {
+		t.Errorf("map schema = %+v", s)
+	}
+	if s := item.Properties["children"]; s.Items.Ref != "#/components/schemas/testItem" {
+		t.Errorf("recursive schema = %+v", s)
+	}
+}
+
+func TestNewDocumentPathParameters(t *testing.T) {
+	doc := NewDocument("test", "v1", map[string]Operation{
+		"GET /v1/items/{namespace}/{name}": {ID: "getItem", Response: testItem{}},
+	})
+
+	op := doc.Paths["/v1/items/{namespace}/{name}"]["get"]
+	if op == nil || len(op.Parameters) != 2 {
+		t.Fatalf("unexpected operation %+v", op)
+	}
+	for i, want := range []string{"namespace", "name"} {
+		if p := op.Parameters[i]; p.Name != want || p.In != "path" || !p.Required || p.Schema.Type != "string" {
+			t.Errorf("parameter %d = %+v, want required path parameter %s", i, p, want)
+		}
+	}
+}
+
+func TestNewDocumentQueryParameters(t *testing.T) {
+	doc := NewDocument("test", "v1", map[string]Operation{
+		"GET /v1/items/{namespace}": {ID: "listItems", Query: []string{"type"}, Response: []testItem{}},
+	})
+
+	op := doc.Paths["/v1/items/{namespace}"]["get"]
+	if op == nil || len(op.Parameters) != 2 {
+		t.Fatalf("unexpected operation %+v", op)
+	}
+	if p := op.Parameters[1]; p.Name != "type" || p.In != "query" || p.Required {
+		t.Errorf("parameter = %+v, want optional query parameter type", p)
+	}
+}
+
+func TestNewDocumentNoContent(t *testing.T) {
+	doc := NewDocument("test", "v1", map[string]Operation{
+		"DELETE /v1/items/{name}": {ID: "deleteItem"},
+	})
+
+	op := doc.Paths["/v1/items/{name}"]["delete"]
+	if op == nil || op.Responses["204"] == nil || op.Responses["200"] != nil {
+		t.Fatalf("unexpected operation %+v, want a 204 response", op)
+	}
+}
+
+func TestDocumentHandler(t *testing.T) {
+	doc := NewDocument("test", "v1", map[string]Operation{"GET /v1/items": {ID: "listItems", Response: []string{}}})
+
+	rec := httptest.NewRecorder()
+	doc.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, OpenAPIPath, nil))
+
+	var served map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &served); err != nil {
+		t.Fatalf("invalid document: %v", err)
+	}
+	if served["openapi"] != "3.0.3" || rec.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("unexpected document %v", served)
+	}
+}
@@ -0,0 +1,116 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Server is the management HTTP API of the controller, components
+// register their endpoints on it before it is started
+type Server struct {
+	// Context for cancellation
+	ctx context.Context
+	// Logger
+	logger *logrus.Logger
+	// Address to listen on
+	listenAddr string
+	// Request multiplexer
+	mux *http.ServeMux
+	// Operations of the current version, in registration order
+	operations []string
+	// Handlers of the operations of the current version
+	handlers map[string]http.Handler
+	// Older versions served as deprecated aliases
+	legacy []legacyVersion
+	// Deprecated operations
+	deprecations []Deprecation
+	// gRPC services of the controller
+	services []string
+	// Bearer token required by the requests that change state, they are
+	// refused while it is empty
+	token string
+}
+
+// NewServer creates a new management API server
+func NewServer(ctx context.Context, logger *logrus.Logger, listenAddr string) *Server {
+	s := &Server{
+		ctx:        ctx,
+		logger:     logger,
+		listenAddr: listenAddr,
+		mux:        http.NewServeMux(),
+		handlers:   make(map[string]http.Handler),
+	}
+	s.mux.HandleFunc("GET "+VersionsPath, s.handleVersions)
+	return s
+}
+
+// Handle registers a handler for the pattern (e.g., "POST /v1/connections/bulk"),
+// and its aliases in the legacy versions served
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+	s.operations = append(s.operations, pattern)
+	s.handlers[pattern] = handler
+	for _, legacy := range s.legacy {
+		s.alias(legacy, pattern)
+	}
+}
+
+// SetToken sets the bearer token required by the requests that change
+// state, read-only requests are served without it
+func (s *Server) SetToken(token string) {
+	s.token = token
+}
+
+// Handler returns the HTTP handler of the server
+func (s *Server) Handler() http.Handler {
+	return requireToken(s.token, s.mux)
+}
+
+// Start serves the API until the context is done
+func (s *Server) Start() error {
+	s.logger.Infof("Starting management API on %s", s.listenAddr)
+	if s.token == "" {
+		s.logger.Warn("Management API token not set, the operations changing state are refused")
+	}
+
+	srv := &http.Server{
+		Addr:              s.listenAddr,
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			errCh <- err
+		}
+	}()
+
+	select {
+	case err := <-errCh:
+		return fmt.Errorf("management API failed: %w", err)
+	case <-s.ctx.Done():
+		s.logger.Info("Stopping management API")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return srv.Shutdown(shutdownCtx)
+	}
+}
+
+// WriteJSON writes v as a JSON response with the given status code
+func WriteJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// WriteError writes an error as a JSON response with the given status code
+func WriteError(w http.ResponseWriter, status int, err error) {
+	WriteJSON(w, status, ErrorResponse{Error: err.Error()})
+}
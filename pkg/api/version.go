@@ -0,0 +1,143 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Versions of the management API
+const (
+	// V1Alpha1 is the version of the first controllers, still served for
+	// older nsmctl and agents during fleet upgrades
+	V1Alpha1 = "v1alpha1"
+	// V1 is the current version
+	V1 = "v1"
+)
+
+// VersionsPath is where the versions and capabilities of the management
+// API are served. It is unversioned, so clients of any version find it.
+const VersionsPath = "/versions"
+
+// VersionInfo lets clients negotiate the version and the operations of
+// the management API before calling it
+type VersionInfo struct {
+	// Versions served, oldest first
+	Versions []string `json:"versions"`
+	// Version new clients should use
+	Preferred string `json:"preferred"`
+	// Operations served in the preferred version (e.g., "POST /v1/drills"),
+	// depending on the features enabled on the controller
+	Operations []string `json:"operations"`
+	// gRPC services served (e.g., "nsm.metrics.v1.ConnectionMetrics")
+	Services []string `json:"services,omitempty"`
+	// Deprecated operations still served
+	Deprecations []Deprecation `json:"deprecations,omitempty"`
+}
+
+// Deprecation describes a deprecated operation of the management API
+type Deprecation struct {
+	// Deprecated operation (e.g., "GET /v1alpha1/hardware")
+	Operation string `json:"operation"`
+	// Operation replacing it, empty if it goes away without a replacement
+	Successor string `json:"successor,omitempty"`
+	// Time after which the operation may be removed, nil if not scheduled
+	Sunset *time.Time `json:"sunset,omitempty"`
+	// Warning returned with every call of the operation
+	Message string `json:"message"`
+}
+
+// Deprecated wraps the handler of a deprecated operation, signaling the
+// deprecation on every response: a Deprecation header, the Sunset and the
+// successor Link when known, and a Warning header (code 299, as the
+// Kubernetes API server sends them) clients show to their users.
+func Deprecated(dep Deprecation, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		h.Set("Deprecation", "true")
+		if dep.Sunset != nil {
+			h.Set("Sunset", dep.Sunset.UTC().Format(http.TimeFormat))
+		}
+		if _, route, ok := strings.Cut(dep.Successor, " "); ok && !strings.Contains(route, "{") {
+			h.Set("Link", fmt.Sprintf("<%s>; rel=\"successor-version\"", route))
+		}
+		h.Add("Warning", fmt.Sprintf("299 - %q", dep.Message))
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// legacyVersion is an older version served as deprecated aliases of the
+// current operations
+type legacyVersion struct {
+	// Version (e.g., v1alpha1)
+	version string
+	// Time after which the version may be removed, nil if not scheduled
+	sunset *time.Time
+}
+
+// ServeLegacyVersion serves every operation of the current version under
+// an older one too, deprecated in favour of the current operation, so
+// clients of the older version keep working until they are upgraded.
+// Operations registered before and after the call are aliased alike.
+func (s *Server) ServeLegacyVersion(version string, sunset *time.Time) {
+	legacy := legacyVersion{version: version, sunset: sunset}
+	s.legacy = append(s.legacy, legacy)
+	for _, pattern := range s.operations {
+		s.alias(legacy, pattern)
+	}
+}
+
+// AddService lists a gRPC service among the capabilities of the controller
+func (s *Server) AddService(name string) {
+	s.services = append(s.services, name)
+}
+
+// alias registers the handler of an operation of the current version
+// under a legacy version
+func (s *Server) alias(legacy legacyVersion, pattern string) {
+	method, route, _ := strings.Cut(pattern, " ")
+	rest, ok := strings.CutPrefix(route, "/"+V1+"/")
+	if !ok {
+		return
+	}
+	old := method + " /" + legacy.version + "/" + rest
+	dep := Deprecation{
+		Operation: old,
+		Successor: pattern,
+		Sunset:    legacy.sunset,
+		Message:   fmt.Sprintf("%s is deprecated, use %s", old, pattern),
+	}
+	if legacy.sunset != nil {
+		dep.Message += fmt.Sprintf(" (removal after %s)", legacy.sunset.Format(time.DateOnly))
+	}
+	s.HandleDeprecated(old, dep, s.handlers[pattern])
+}
+
+// HandleDeprecated registers the handler of a deprecated operation
+func (s *Server) HandleDeprecated(pattern string, dep Deprecation, handler http.Handler) {
+	s.mux.Handle(pattern, Deprecated(dep, handler))
+	s.deprecations = append(s.deprecations, dep)
+}
+
+// Versions returns the versions and capabilities of the API
+func (s *Server) Versions() VersionInfo {
+	info := VersionInfo{
+		Preferred:    V1,
+		Operations:   append([]string{}, s.operations...),
+		Services:     s.services,
+		Deprecations: s.deprecations,
+	}
+	for _, legacy := range s.legacy {
+		info.Versions = append(info.Versions, legacy.version)
+	}
+	info.Versions = append(info.Versions, V1)
+	sort.Strings(info.Operations)
+	return info
+}
+
+// handleVersions serves the versions and capabilities of the API
+func (s *Server) handleVersions(w http.ResponseWriter, r *http.Request) {
+	WriteJSON(w, http.StatusOK, s.Versions())
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestServerLegacyVersion(t *testing.T) {
+	s := NewServer(context.Background(), logrus.New(), "")
+	s.SetToken("s3cret")
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		WriteJSON(w, http.StatusOK, map[string]string{"path": r.URL.Path})
+	})
+	s.Handle("GET /v1/hardware", ok)
+	sunset := time.Date(2027, 6, 30, 0, 0, 0, 0, time.UTC)
+	s.ServeLegacyVersion(V1Alpha1, &sunset)
+	// aliased whether registered before or after
+	s.Handle("POST /v1/drills", ok)
+	s.AddService("nsm.metrics.v1.ConnectionMetrics")
+
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/hardware", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Deprecation") != "" {
+		t.Errorf("current version: code %d, headers %v", rec.Code, rec.Header())
+	}
+
+	rec = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/v1alpha1/drills", nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	s.Handler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("legacy version not served: %d", rec.Code)
+	}
+	h := rec.Header()
+	if h.Get("Deprecation") != "true" || h.Get("Sunset") != "Wed, 30 Jun 2027 00:00:00 GMT" || h.Get("Link") != `</v1/drills>; rel="successor-version"` {
+		t.Errorf("deprecation not signaled: %v", h)
+	}
+	if warning := h.Get("Warning"); !strings.HasPrefix(warning, "299 - ") || !strings.Contains(warning, "use POST /v1/drills (removal after 2027-06-30)") {
+		t.Errorf("unexpected warning %q", warning)
+	}
+
+	rec = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, VersionsPath, nil))
+	var info VersionInfo
+	if err := json.Unmarshal(rec.Body.Bytes(), &info); err != nil {
+		t.Fatalf("invalid response %s: %v", rec.Body.String(), err)
+	}
+	if !reflect.DeepEqual(info.Versions, []string{V1Alpha1, V1}) || info.Preferred != V1 {
+		t.Errorf("unexpected versions %v, preferred %s", info.Versions, info.Preferred)
+	}
+	if !reflect.DeepEqual(info.Operations, []string{"GET /v1/hardware", "POST /v1/drills"}) {
+		t.Errorf("unexpected operations %v", info.Operations)
+	}
+	if len(info.Services) != 1 || len(info.Deprecations) != 2 || info.Deprecations[1].Successor != "POST /v1/drills" {
+		t.Errorf("unexpected services %v or deprecations %+v", info.Services, info.Deprecations)
+	}
+}
@@ -0,0 +1,119 @@
+package bfd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestControlPacketRoundTrip(t *testing.T) {
+	in := ControlPacket{
+		Diag:              DiagControlExpired,
+		State:             StateUp,
+		DetectMult:        3,
+		MyDiscriminator:   42,
+		YourDiscriminator: 7,
+		DesiredMinTx:      10 * time.Millisecond,
+		RequiredMinRx:     20 * time.Millisecond,
+	}
+
+	var out ControlPacket
+	if err := out.Unmarshal(in.Marshal()); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if out != in {
+		t.Errorf("round trip mismatch: got %+v, want %+v", out, in)
+	}
+}
+
+func TestControlPacketValidation(t *testing.T) {
+	valid := (&ControlPacket{State: StateDown, DetectMult: 3, MyDiscriminator: 1}).Marshal()
+
+	var p ControlPacket
+	if err := p.Unmarshal(valid[:10]); err == nil {
+		t.Errorf("expected error for short packet")
+	}
+
+	badVersion := append([]byte(nil), valid...)
+	badVersion[0] = 2 << 5
+	if err := p.Unmarshal(badVersion); err == nil {
+		t.Errorf("expected error for unsupported version")
+	}
+
+	up := (&ControlPacket{State: StateUp, DetectMult: 3, MyDiscriminator: 1}).Marshal()
+	if err := p.Unmarshal(up); err == nil {
+		t.Errorf("expected error for zero your discriminator in Up state")
+	}
+}
+
+// exchange delivers a packet from one session to the other
+func exchange(from, to *Session, now time.Time) {
+	var p ControlPacket
+	if err := p.Unmarshal(from.packet().Marshal()); err != nil {
+		panic(err)
+	}
+	to.handle(&p, now)
+}
+
+func TestSessionHandshakeAndTimeout(t *testing.T) {
+	var changes []State
+	timers := TimersForPriority(100)
+	a := newSession("a", 1, timers, func(_ string, s State) { changes = append(changes, s) })
+	b := newSession("b", 2, timers, nil)
+
+	now := time.Now()
+	exchange(a, b, now) // b: Down -> Init
+	exchange(b, a, now) // a: Down -> Up (peer is Init)
+	exchange(a, b, now) // b: Init -> Up
+
+	if a.State() != StateUp || b.State() != StateUp {
+		t.Fatalf("sessions not up: a=%s b=%s", a.State(), b.State())
+	}
+
+	// once up, b advertises its fast transmit interval
+	exchange(b, a, now)
+
+	// packets within the detection time keep the session up
+	a.checkTimeout(now.Add(2 * timers.RxInterval))
+	if a.State() != StateUp {
+		t.Fatalf("session went down before the detection time")
+	}
+
+	a.checkTimeout(now.Add(time.Duration(timers.DetectMult+1) * timers.RxInterval))
+	if a.State() != StateDown {
+		t.Fatalf("session did not detect the failure")
+	}
+	if p := a.packet(); p.Diag != DiagControlExpired || p.YourDiscriminator != 0 {
+		t.Errorf("unexpected packet after timeout: %+v", p)
+	}
+
+	if len(changes) != 2 || changes[0] != StateUp || changes[1] != StateDown {
+		t.Errorf("unexpected state changes: %v", changes)
+	}
+}
+
+func TestSessionNeighborDown(t *testing.T) {
+	timers := TimersForPriority(0)
+	a := newSession("a", 1, timers, nil)
+	b := newSession("b", 2, timers, nil)
+
+	now := time.Now()
+	exchange(a, b, now)
+	exchange(b, a, now)
+	exchange(a, b, now)
+
+	b.setState(StateAdminDown, DiagAdminDown)
+	exchange(b, a, now)
+	if a.State() != StateDown {
+		t.Errorf("session did not follow the peer's AdminDown, state %s", a.State())
+	}
+}
+
+func TestTimersForPriority(t *testing.T) {
+	high, low := TimersForPriority(100), TimersForPriority(10)
+	if high.TxInterval >= low.TxInterval {
+		t.Errorf("high priority should probe faster: high=%s low=%s", high.TxInterval, low.TxInterval)
+	}
+	if detect := time.Duration(high.DetectMult) * high.RxInterval; detect > 100*time.Millisecond {
+		t.Errorf("high priority detection time %s exceeds 100ms", detect)
+	}
+}
@@ -0,0 +1,204 @@
+package bfd
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ControlPort is the UDP port of single-hop BFD control packets
+const ControlPort = 3784
+
+// Manager runs the BFD sessions of the node over a single UDP socket
+type Manager struct {
+	// Context for cancellation
+	ctx context.Context
+	// Logger
+	logger *logrus.Logger
+	// Address to listen on
+	listenAddr string
+	// Socket used for sending and receiving control packets
+	conn net.PacketConn
+	// Sessions by local discriminator
+	sessions map[uint32]*Session
+	// Cancel functions of the session transmit loops
+	cancels map[uint32]context.CancelFunc
+	// Mutex for protecting the sessions
+	mu sync.RWMutex
+}
+
+// NewManager creates a new BFD manager listening on listenAddr (e.g., ":3784")
+func NewManager(ctx context.Context, logger *logrus.Logger, listenAddr string) *Manager {
+	return &Manager{
+		ctx:        ctx,
+		logger:     logger,
+		listenAddr: listenAddr,
+		sessions:   make(map[uint32]*Session),
+		cancels:    make(map[uint32]context.CancelFunc),
+	}
+}
+
+// Start opens the socket and processes received packets until the context is done
+func (m *Manager) Start() error {
+	conn, err := net.ListenPacket("udp", m.listenAddr)
+	if err != nil {
+		return fmt.Errorf("failed to listen for BFD packets: %w", err)
+	}
+
+	m.mu.Lock()
+	m.conn = conn
+	m.mu.Unlock()
+
+	m.logger.Infof("Starting BFD manager on %s", m.listenAddr)
+
+	go func() {
+		<-m.ctx.Done()
+		conn.Close()
+	}()
+
+	buf := make([]byte, 512)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if m.ctx.Err() != nil {
+				m.logger.Info("Stopping BFD manager")
+				return nil
+			}
+			m.logger.WithError(err).Warn("Failed to read BFD packet")
+			continue
+		}
+
+		var p ControlPacket
+		if err := p.Unmarshal(buf[:n]); err != nil {
+			m.logger.WithError(err).Debugf("Dropping invalid BFD packet from %s", addr)
+			continue
+		}
+
+		if s := m.lookup(&p, addr); s != nil {
+			s.handle(&p, time.Now())
+		}
+	}
+}
+
+// AddSession starts a session towards the peer with timers derived from the priority
+func (m *Manager) AddSession(peer string, priority int32, onChange func(peer string, state State)) (*Session, error) {
+	if _, _, err := net.SplitHostPort(peer); err != nil {
+		peer = net.JoinHostPort(peer, fmt.Sprint(ControlPort))
+	}
+	if _, err := net.ResolveUDPAddr("udp", peer); err != nil {
+		return nil, fmt.Errorf("invalid BFD peer %s: %w", peer, err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, s := range m.sessions {
+		if s.peer == peer {
+			return s, nil
+		}
+	}
+
+	disc := m.newDiscriminator()
+	s := newSession(peer, disc, TimersForPriority(priority), onChange)
+	ctx, cancel := context.WithCancel(m.ctx)
+	m.sessions[disc] = s
+	m.cancels[disc] = cancel
+
+	go m.run(ctx, s)
+
+	m.logger.Infof("Added BFD session to %s (tx interval %s)", peer, s.timers.TxInterval)
+	return s, nil
+}
+
+// RemoveSession stops the session towards the peer
+func (m *Manager) RemoveSession(peer string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for disc, s := range m.sessions {
+		if s.peer == peer || s.peer == net.JoinHostPort(peer, fmt.Sprint(ControlPort)) {
+			m.cancels[disc]()
+			delete(m.sessions, disc)
+			delete(m.cancels, disc)
+			m.logger.Infof("Removed BFD session to %s", s.peer)
+			return
+		}
+	}
+}
+
+// run transmits control packets and checks the detection timer of a session
+func (m *Manager) run(ctx context.Context, s *Session) {
+	timer := time.NewTimer(s.txInterval())
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-timer.C:
+			s.checkTimeout(time.Now())
+			m.send(s)
+			timer.Reset(s.txInterval())
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// send transmits the next control packet of a session
+func (m *Manager) send(s *Session) {
+	m.mu.RLock()
+	conn := m.conn
+	m.mu.RUnlock()
+
+	// not listening yet
+	if conn == nil {
+		return
+	}
+
+	addr, err := net.ResolveUDPAddr("udp", s.peer)
+	if err != nil {
+		m.logger.WithError(err).Warnf("Failed to resolve BFD peer %s", s.peer)
+		return
+	}
+	if _, err := conn.WriteTo(s.packet().Marshal(), addr); err != nil {
+		m.logger.WithError(err).Debugf("Failed to send BFD packet to %s", s.peer)
+	}
+}
+
+// lookup finds the session a received packet belongs to
+func (m *Manager) lookup(p *ControlPacket, addr net.Addr) *Session {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if p.YourDiscriminator != 0 {
+		return m.sessions[p.YourDiscriminator]
+	}
+
+	// the peer doesn't know our discriminator yet, match by address
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return nil
+	}
+	for _, s := range m.sessions {
+		if peerHost, _, err := net.SplitHostPort(s.peer); err == nil && peerHost == host {
+			return s
+		}
+	}
+	return nil
+}
+
+// newDiscriminator returns an unused non-zero discriminator.
+// Must be called with the lock held.
+func (m *Manager) newDiscriminator() uint32 {
+	for {
+		disc := rand.Uint32()
+		if _, exists := m.sessions[disc]; disc != 0 && !exists {
+			return disc
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package bfd
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// State is the BFD session state (RFC 5880 section 4.1)
+type State uint8
+
+// BFD session states
+const (
+	StateAdminDown State = 0
+	StateDown      State = 1
+	StateInit      State = 2
+	StateUp        State = 3
+)
+
+func (s State) String() string {
+	switch s {
+	case StateAdminDown:
+		return "AdminDown"
+	case StateDown:
+		return "Down"
+	case StateInit:
+		return "Init"
+	case StateUp:
+		return "Up"
+	default:
+		return fmt.Sprintf("State(%d)", uint8(s))
+	}
+}
+
+// Diagnostic codes used by NSM
+const (
+	DiagNone           uint8 = 0
+	DiagControlExpired uint8 = 1
+	DiagNeighborDown   uint8 = 3
+	DiagAdminDown      uint8 = 7
+)
+
+// bfd protocol version and the length of a packet without authentication
+const (
+	version       = 1
+	controlLength = 24
+)
+
+// ControlPacket is a BFD control packet without authentication
+type ControlPacket struct {
+	// Diagnostic code of the last state change
+	Diag uint8
+	// State of the sender
+	State State
+	// Detection time multiplier
+	DetectMult uint8
+	// Discriminator of the sender's session
+	MyDiscriminator uint32
+	// Discriminator of the receiver's session as known by the sender
+	YourDiscriminator uint32
+	// Interval the sender wants to transmit at
+	DesiredMinTx time.Duration
+	// Minimum interval the sender supports receiving at
+	RequiredMinRx time.Duration
+}
+
+// Marshal encodes the packet in the RFC 5880 wire format
+func (p *ControlPacket) Marshal() []byte {
+	b := make([]byte, controlLength)
+	b[0] = version<<5 | p.Diag&0x1f
+	b[1] = uint8(p.State) << 6
+	b[2] = p.DetectMult
+	b[3] = controlLength
+	binary.BigEndian.PutUint32(b[4:], p.MyDiscriminator)
+	binary.BigEndian.PutUint32(b[8:], p.YourDiscriminator)
+	binary.BigEndian.PutUint32(b[12:], uint32(p.DesiredMinTx/time.Microsecond))
+	binary.BigEndian.PutUint32(b[16:], uint32(p.RequiredMinRx/time.Microsecond))
+	// required min echo rx interval stays 0, echo mode is not supported
+	return b
+}
+
+// Unmarshal decodes and validates a packet (RFC 5880 section 6.8.6)
+func (p *ControlPacket) Unmarshal(b []byte) error {
+	if len(b) < controlLength {
+		return fmt.Errorf("packet too short: %d bytes", len(b))
+	}
+	if v := b[0] >> 5; v != version {
+		return fmt.Errorf("unsupported BFD version: %d", v)
+	}
+	if length := int(b[3]); length < controlLength || length > len(b) {
+		return fmt.Errorf("invalid packet length: %d", length)
+	}
+	if b[2] == 0 {
+		return fmt.Errorf("detect multiplier is zero")
+	}
+
+	p.Diag = b[0] & 0x1f
+	p.State = State(b[1] >> 6)
+	p.DetectMult = b[2]
+	p.MyDiscriminator = binary.BigEndian.Uint32(b[4:])
+	p.YourDiscriminator = binary.BigEndian.Uint32(b[8:])
+	p.DesiredMinTx = time.Duration(binary.BigEndian.Uint32(b[12:])) * time.Microsecond
+	p.RequiredMinRx = time.Duration(binary.BigEndian.Uint32(b[16:])) * time.Microsecond
+
+	if p.MyDiscriminator == 0 {
+		return fmt.Errorf("my discriminator is zero")
+	}
+	if p.YourDiscriminator == 0 && p.State != StateDown && p.State != StateAdminDown {
+		return fmt.Errorf("your discriminator is zero in state %s", p.State)
+	}
+
+	return nil
+}
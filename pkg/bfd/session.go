@@ -0,0 +1,198 @@
+package bfd
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Timers configures how fast a session detects failures
+type Timers struct {
+	// Interval between transmitted control packets
+	TxInterval time.Duration
+	// Minimum interval between received control packets
+	RxInterval time.Duration
+	// Number of missed packets before the session goes down
+	DetectMult uint8
+}
+
+// slowTxInterval is used while the session is not up (RFC 5880 section 6.8.3)
+const slowTxInterval = time.Second
+
+// TimersForPriority returns the timers of a connection with the given
+// priority: high priority connections detect failures in milliseconds,
+// low priority ones trade detection speed for less probe traffic
+func TimersForPriority(priority int32) Timers {
+	switch {
+	case priority >= 100:
+		return Timers{TxInterval: 10 * time.Millisecond, RxInterval: 10 * time.Millisecond, DetectMult: 3}
+	case priority >= 50:
+		return Timers{TxInterval: 50 * time.Millisecond, RxInterval: 50 * time.Millisecond, DetectMult: 3}
+	default:
+		return Timers{TxInterval: 300 * time.Millisecond, RxInterval: 300 * time.Millisecond, DetectMult: 3}
+	}
+}
+
+// Session is a BFD session with a single peer
+type Session struct {
+	// Peer address (host:port)
+	peer string
+	// Timers of the session
+	timers Timers
+	// Local and remote discriminators
+	localDisc  uint32
+	remoteDisc uint32
+	// Local state and diagnostic
+	state State
+	diag  uint8
+	// Parameters advertised by the remote side
+	remoteDetectMult uint8
+	remoteMinTx      time.Duration
+	// Time the last valid packet was received
+	lastRx time.Time
+	// Called on every state change
+	onChange func(peer string, state State)
+	// Mutex for protecting the session state
+	mu sync.Mutex
+}
+
+// newSession creates a session in the Down state
+func newSession(peer string, localDisc uint32, timers Timers, onChange func(string, State)) *Session {
+	return &Session{
+		peer:      peer,
+		timers:    timers,
+		localDisc: localDisc,
+		state:     StateDown,
+		onChange:  onChange,
+	}
+}
+
+// State returns the current state of the session
+func (s *Session) State() State {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.state
+}
+
+// Peer returns the peer address of the session
+func (s *Session) Peer() string {
+	return s.peer
+}
+
+// handle runs the reception state machine (RFC 5880 section 6.8.6)
+func (s *Session) handle(p *ControlPacket, now time.Time) {
+	s.mu.Lock()
+
+	s.remoteDisc = p.MyDiscriminator
+	s.remoteDetectMult = p.DetectMult
+	s.remoteMinTx = p.DesiredMinTx
+	s.lastRx = now
+
+	old := s.state
+	switch {
+	case p.State == StateAdminDown:
+		if s.state != StateDown {
+			s.setState(StateDown, DiagNeighborDown)
+		}
+	case s.state == StateDown:
+		if p.State == StateDown {
+			s.setState(StateInit, DiagNone)
+		} else if p.State == StateInit {
+			s.setState(StateUp, DiagNone)
+		}
+	case s.state == StateInit:
+		if p.State == StateInit || p.State == StateUp {
+			s.setState(StateUp, DiagNone)
+		}
+	case s.state == StateUp:
+		if p.State == StateDown {
+			s.setState(StateDown, DiagNeighborDown)
+		}
+	}
+	changed := old != s.state
+	state := s.state
+
+	s.mu.Unlock()
+
+	if changed && s.onChange != nil {
+		s.onChange(s.peer, state)
+	}
+}
+
+// checkTimeout brings the session down when the detection time expired
+func (s *Session) checkTimeout(now time.Time) {
+	s.mu.Lock()
+
+	if s.state != StateInit && s.state != StateUp {
+		s.mu.Unlock()
+		return
+	}
+	if now.Sub(s.lastRx) <= s.detectionTime() {
+		s.mu.Unlock()
+		return
+	}
+
+	s.setState(StateDown, DiagControlExpired)
+	s.remoteDisc = 0
+
+	s.mu.Unlock()
+
+	if s.onChange != nil {
+		s.onChange(s.peer, StateDown)
+	}
+}
+
+// packet builds the next control packet to transmit
+func (s *Session) packet() *ControlPacket {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx := s.timers.TxInterval
+	if s.state != StateUp {
+		tx = slowTxInterval
+	}
+
+	return &ControlPacket{
+		Diag:              s.diag,
+		State:             s.state,
+		DetectMult:        s.timers.DetectMult,
+		MyDiscriminator:   s.localDisc,
+		YourDiscriminator: s.remoteDisc,
+		DesiredMinTx:      tx,
+		RequiredMinRx:     s.timers.RxInterval,
+	}
+}
+
+// txInterval returns the jittered interval until the next transmission
+func (s *Session) txInterval() time.Duration {
+	s.mu.Lock()
+	interval := s.timers.TxInterval
+	if s.state != StateUp {
+		interval = slowTxInterval
+	}
+	s.mu.Unlock()
+
+	// reduce by 0-25% to avoid self-synchronization (RFC 5880 section 6.8.7)
+	return interval - time.Duration(rand.Int63n(int64(interval)/4+1))
+}
+
+// detectionTime is the time without packets after which the peer is down.
+// Must be called with the lock held.
+func (s *Session) detectionTime() time.Duration {
+	interval := s.timers.RxInterval
+	if s.remoteMinTx > interval {
+		interval = s.remoteMinTx
+	}
+	mult := s.remoteDetectMult
+	if mult == 0 {
+		mult = s.timers.DetectMult
+	}
+	return time.Duration(mult) * interval
+}
+
+// setState changes the local state. Must be called with the lock held.
+func (s *Session) setState(state State, diag uint8) {
+	s.state = state
+	s.diag = diag
+}
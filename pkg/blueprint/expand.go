@@ -0,0 +1,128 @@
+package blueprint
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/intent"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// LabelBlueprint is set on every connection generated from a blueprint
+const LabelBlueprint = "nsm.akosrbn.io/blueprint"
+
+// placeholder matches a ${name} reference to a parameter
+var placeholder = regexp.MustCompile(`\$\{([^}]*)\}`)
+
+// Expand stamps out a NetworkConnection per instance of the blueprint,
+// substituting the parameters in the name template and the string fields
+// of the connection template. A single invalid instance fails the whole
+// expansion, so a typo never removes the connections of a fleet.
+func Expand(bp *nsmv1.NetworkBlueprint) ([]nsmv1.NetworkConnection, error) {
+	declared := make(map[string]bool)
+	for _, p := range bp.Spec.Parameters {
+		if p.Name == "" {
+			return nil, fmt.Errorf("blueprint %s/%s has a parameter without a name", bp.Namespace, bp.Name)
+		}
+		if declared[p.Name] {
+			return nil, fmt.Errorf("parameter %s is declared twice", p.Name)
+		}
+		declared[p.Name] = true
+	}
+
+	conns := make([]nsmv1.NetworkConnection, 0, len(bp.Spec.Instances))
+	names := make(map[string]int)
+	for i, instance := range bp.Spec.Instances {
+		values, err := resolve(bp.Spec.Parameters, instance)
+		if err != nil {
+			return nil, fmt.Errorf("instance %d: %w", i, err)
+		}
+
+		conn, err := expandInstance(bp, values)
+		if err != nil {
+			return nil, fmt.Errorf("instance %d: %w", i, err)
+		}
+		if j, ok := names[conn.Name]; ok {
+			return nil, fmt.Errorf("instances %d and %d both generate connection %s", j, i, conn.Name)
+		}
+		names[conn.Name] = i
+		conns = append(conns, *conn)
+	}
+	return conns, nil
+}
+
+// resolve returns the value of every parameter for an instance
+func resolve(params []nsmv1.BlueprintParameter, instance nsmv1.BlueprintInstance) (map[string]string, error) {
+	values := make(map[string]string, len(params))
+	for _, p := range params {
+		value, ok := instance.Parameters[p.Name]
+		if !ok || value == "" {
+			if p.Default == "" {
+				return nil, fmt.Errorf("parameter %s is required", p.Name)
+			}
+			value = p.Default
+		}
+		values[p.Name] = value
+	}
+	for name := range instance.Parameters {
+		if _, ok := values[name]; !ok {
+			return nil, fmt.Errorf("unknown parameter %s", name)
+		}
+	}
+	return values, nil
+}
+
+// expandInstance builds the connection of an instance
+func expandInstance(bp *nsmv1.NetworkBlueprint, values map[string]string) (*nsmv1.NetworkConnection, error) {
+	var err error
+	subst := func(s string) string {
+		return placeholder.ReplaceAllStringFunc(s, func(ref string) string {
+			name := ref[2 : len(ref)-1]
+			value, ok := values[name]
+			if !ok && err == nil {
+				err = fmt.Errorf("undeclared parameter %s referenced", name)
+			}
+			return value
+		})
+	}
+
+	var name string
+	if bp.Spec.NameTemplate != "" {
+		name = strings.ToLower(subst(bp.Spec.NameTemplate))
+	} else {
+		parts := []string{bp.Name}
+		for _, p := range bp.Spec.Parameters {
+			parts = append(parts, values[p.Name])
+		}
+		name = intent.ObjectName(parts...)
+	}
+
+	spec := *bp.Spec.Template.DeepCopy()
+	spec.Source = subst(spec.Source)
+	spec.Destination = subst(spec.Destination)
+	spec.ConnectionType = subst(spec.ConnectionType)
+	spec.AdminState = subst(spec.AdminState)
+	spec.Encryption = subst(spec.Encryption)
+	if spec.Canary != nil {
+		spec.Canary.Target = subst(spec.Canary.Target)
+		spec.Canary.Device = subst(spec.Canary.Device)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if errs := validation.IsDNS1123Subdomain(name); len(errs) > 0 {
+		return nil, fmt.Errorf("invalid connection name %q: %s", name, strings.Join(errs, ", "))
+	}
+
+	return &nsmv1.NetworkConnection{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: bp.Namespace,
+			Labels:    map[string]string{LabelBlueprint: bp.Name},
+		},
+		Spec: spec,
+	}, nil
+}
@@ -0,0 +1,109 @@
+package blueprint
+
+import (
+	"strings"
+	"testing"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func cameraFeed(instances ...map[string]string) *nsmv1.NetworkBlueprint {
+	bp := &nsmv1.NetworkBlueprint{
+		ObjectMeta: metav1.ObjectMeta{Name: "camera-feed", Namespace: "edge"},
+		Spec: nsmv1.NetworkBlueprintSpec{
+			Parameters: []nsmv1.BlueprintParameter{
+				{Name: "device"},
+				{Name: "sink", Default: "vision"},
+			},
+			Template: nsmv1.NetworkConnectionSpec{
+				Source:         "edge/camera-${device}",
+				Destination:    "${sink}",
+				ConnectionType: nsmv1.ConnectionTypeSRIOV,
+				Priority:       80,
+			},
+		},
+	}
+	for _, params := range instances {
+		bp.Spec.Instances = append(bp.Spec.Instances, nsmv1.BlueprintInstance{Parameters: params})
+	}
+	return bp
+}
+
+func TestExpand(t *testing.T) {
+	conns, err := Expand(cameraFeed(
+		map[string]string{"device": "0017"},
+		map[string]string{"device": "0018", "sink": "archive"},
+	))
+	if err != nil {
+		t.Fatalf("Expand() error = %v", err)
+	}
+	if len(conns) != 2 {
+		t.Fatalf("expanded %d connections, want 2", len(conns))
+	}
+
+	first, second := conns[0], conns[1]
+	if first.Name != "camera-feed-0017-vision" || first.Namespace != "edge" || first.Labels[LabelBlueprint] != "camera-feed" {
+		t.Errorf("unexpected metadata %+v", first.ObjectMeta)
+	}
+	if first.Spec.Source != "edge/camera-0017" || first.Spec.Destination != "vision" || first.Spec.Priority != 80 {
+		t.Errorf("unexpected spec %+v", first.Spec)
+	}
+	if second.Spec.Destination != "archive" {
+		t.Errorf("default not overridden: %+v", second.Spec)
+	}
+}
+
+func TestExpandNameTemplate(t *testing.T) {
+	bp := cameraFeed(map[string]string{"device": "Cam17"})
+	bp.Spec.NameTemplate = "feed-${device}"
+	bp.Spec.Template.Canary = &nsmv1.CanarySpec{Target: "cam-${device}:9000"}
+
+	conns, err := Expand(bp)
+	if err != nil {
+		t.Fatalf("Expand() error = %v", err)
+	}
+	if conns[0].Name != "feed-cam17" || conns[0].Spec.Canary.Target != "cam-Cam17:9000" {
+		t.Errorf("unexpected connection %+v", conns[0])
+	}
+	if bp.Spec.Template.Canary.Target != "cam-${device}:9000" {
+		t.Errorf("expansion changed the template")
+	}
+}
+
+func TestExpandErrors(t *testing.T) {
+	tests := map[string]struct {
+		bp   *nsmv1.NetworkBlueprint
+		want string
+	}{
+		"missing required parameter": {
+			bp:   cameraFeed(map[string]string{"sink": "archive"}),
+			want: "parameter device is required",
+		},
+		"unknown parameter": {
+			bp:   cameraFeed(map[string]string{"device": "1", "zone": "a"}),
+			want: "unknown parameter zone",
+		},
+		"duplicate names": {
+			bp:   cameraFeed(map[string]string{"device": "1"}, map[string]string{"device": "1"}),
+			want: "instances 0 and 1 both generate connection camera-feed-1-vision",
+		},
+		"invalid name": {
+			bp:   cameraFeed(map[string]string{"device": "cam:1"}),
+			want: "invalid connection name",
+		},
+	}
+	undeclared := cameraFeed(map[string]string{"device": "1"})
+	undeclared.Spec.Template.Destination = "${target}"
+	tests["undeclared reference"] = struct {
+		bp   *nsmv1.NetworkBlueprint
+		want string
+	}{undeclared, "undeclared parameter target referenced"}
+
+	for name, tt := range tests {
+		_, err := Expand(tt.bp)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: error = %v, want %q", name, err, tt.want)
+		}
+	}
+}
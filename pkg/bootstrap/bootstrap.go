@@ -0,0 +1,208 @@
+package bootstrap
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// LabelBootstrap marks the objects created from the bootstrap file, only
+// those are ever updated or pruned
+const LabelBootstrap = "nsm.akosrbn.io/bootstrap"
+
+// FieldManager owns the fields set from the bootstrap file
+const FieldManager = "nsm-bootstrap"
+
+// Kinds the bootstrap file may contain, in the order they are applied
+var kinds = []schema.GroupVersionKind{
+	nsmv1.SchemeGroupVersion.WithKind("NetworkService"),
+	nsmv1.SchemeGroupVersion.WithKind("NetworkConnection"),
+	nsmv1.SchemeGroupVersion.WithKind("NetworkIntent"),
+	networkingv1.SchemeGroupVersion.WithKind("NetworkPolicy"),
+}
+
+// Result reports what applying the bootstrap file changed
+type Result struct {
+	// Objects created, as kind/namespace/name
+	Created []string
+	// Objects whose spec was updated
+	Updated []string
+	// Objects already matching the file
+	Unchanged []string
+	// Objects in the file existing without the bootstrap label, created by
+	// someone else and left alone
+	Unowned []string
+	// Objects removed from the file and deleted (prune only)
+	Pruned []string
+	// Objects removed from the file but kept because pruning is off
+	Stale []string
+}
+
+// Load reads the bootstrap file, a multi-document YAML (or JSON) file of
+// NetworkServices, NetworkConnections, NetworkIntents and NetworkPolicies
+func Load(path string) ([]*unstructured.Unstructured, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open bootstrap file: %w", err)
+	}
+	defer f.Close()
+
+	allowed := make(map[schema.GroupVersionKind]bool, len(kinds))
+	for _, gvk := range kinds {
+		allowed[gvk] = true
+	}
+
+	var objs []*unstructured.Unstructured
+	decoder := yaml.NewYAMLOrJSONDecoder(f, 4096)
+	for {
+		var raw runtime.RawExtension
+		if err := decoder.Decode(&raw); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, fmt.Errorf("failed to parse bootstrap file: %w", err)
+		}
+		// empty documents (e.g., a trailing ---)
+		if len(bytes.TrimSpace(raw.Raw)) == 0 || string(raw.Raw) == "null" {
+			continue
+		}
+
+		// numbers are decoded as int64 like objects read from the API server,
+		// so unchanged specs compare equal on re-apply
+		obj := &unstructured.Unstructured{}
+		if err := obj.UnmarshalJSON(raw.Raw); err != nil {
+			return nil, fmt.Errorf("failed to parse bootstrap file: %w", err)
+		}
+
+		if !allowed[obj.GroupVersionKind()] {
+			return nil, fmt.Errorf("unsupported kind %s in bootstrap file", obj.GroupVersionKind())
+		}
+		if obj.GetName() == "" {
+			return nil, fmt.Errorf("%s without name in bootstrap file", obj.GetKind())
+		}
+		if obj.GetNamespace() == "" {
+			obj.SetNamespace("default")
+		}
+		objs = append(objs, obj)
+	}
+
+	return objs, nil
+}
+
+// Apply makes the cluster match the bootstrap objects. It is idempotent,
+// so it runs on every start: missing objects are created, drifted fields
+// are reset and matching objects are left alone, as are objects of the
+// same name not created from the file. Objects created from an
+// earlier version of the file that are no longer in it are only deleted
+// with prune, a truncated file must not wipe a site.
+func Apply(ctx context.Context, c client.Client, objs []*unstructured.Unstructured, prune bool) (*Result, error) {
+	result := &Result{}
+	wanted := make(map[string]bool, len(objs))
+
+	for _, gvk := range kinds {
+		for _, obj := range objs {
+			if obj.GroupVersionKind() != gvk {
+				continue
+			}
+			id := objectID(obj)
+			wanted[id] = true
+
+			changed, created, unowned, err := applyObject(ctx, c, obj)
+			if err != nil {
+				return result, err
+			}
+			switch {
+			case unowned:
+				result.Unowned = append(result.Unowned, id)
+			case created:
+				result.Created = append(result.Created, id)
+			case changed:
+				result.Updated = append(result.Updated, id)
+			default:
+				result.Unchanged = append(result.Unchanged, id)
+			}
+		}
+	}
+
+	// delete dependents first, the reverse of the apply order
+	for i := len(kinds) - 1; i >= 0; i-- {
+		var list unstructured.UnstructuredList
+		list.SetGroupVersionKind(kinds[i].GroupVersion().WithKind(kinds[i].Kind + "List"))
+		if err := c.List(ctx, &list, client.MatchingLabels{LabelBootstrap: "true"}); err != nil {
+			return result, fmt.Errorf("failed to list bootstrapped %s: %w", kinds[i].Kind, err)
+		}
+
+		for j := range list.Items {
+			obj := &list.Items[j]
+			obj.SetGroupVersionKind(kinds[i])
+			id := objectID(obj)
+			if wanted[id] {
+				continue
+			}
+			if !prune {
+				result.Stale = append(result.Stale, id)
+				continue
+			}
+			if err := c.Delete(ctx, obj); err != nil && !apierrors.IsNotFound(err) {
+				return result, fmt.Errorf("failed to prune %s: %w", id, err)
+			}
+			result.Pruned = append(result.Pruned, id)
+		}
+	}
+
+	sort.Strings(result.Stale)
+	sort.Strings(result.Pruned)
+	return result, nil
+}
+
+// applyObject applies the object from the file with server-side apply, so
+// only the fields the file sets are compared and reset, not those the API
+// server defaulted. Objects existing without the bootstrap label weren't
+// created from the file and are left alone instead of being adopted.
+func applyObject(ctx context.Context, c client.Client, obj *unstructured.Unstructured) (changed, created, unowned bool, err error) {
+	existing := &unstructured.Unstructured{}
+	existing.SetGroupVersionKind(obj.GroupVersionKind())
+	err = c.Get(ctx, client.ObjectKeyFromObject(obj), existing)
+	switch {
+	case apierrors.IsNotFound(err):
+		created = true
+	case err != nil:
+		return false, false, false, fmt.Errorf("failed to get %s: %w", objectID(obj), err)
+	case existing.GetLabels()[LabelBootstrap] != "true":
+		return false, false, true, nil
+	}
+
+	desired := obj.DeepCopy()
+	labels := desired.GetLabels()
+	if labels == nil {
+		labels = make(map[string]string)
+	}
+	labels[LabelBootstrap] = "true"
+	desired.SetLabels(labels)
+	desired.SetResourceVersion("")
+	desired.SetManagedFields(nil)
+	// the file wins over the changes made by hand to the fields it sets
+	if err := c.Patch(ctx, desired, client.Apply, client.FieldOwner(FieldManager), client.ForceOwnership); err != nil {
+		return false, false, false, fmt.Errorf("failed to apply %s: %w", objectID(obj), err)
+	}
+	// an apply changing nothing leaves the resource version alone
+	return created || desired.GetResourceVersion() != existing.GetResourceVersion(), created, false, nil
+}
+
+// objectID identifies an object in the result
+func objectID(obj *unstructured.Unstructured) string {
+	return fmt.Sprintf("%s/%s/%s", obj.GetKind(), obj.GetNamespace(), obj.GetName())
+}
@@ -0,0 +1,294 @@
+package bootstrap
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+const siteFile = `
+apiVersion: nsm.akosrbn.io/v1
+kind: NetworkService
+metadata:
+  name: camera-feed
+  namespace: edge
+spec:
+  serviceType: l3
+  endpoint: 10.0.0.5:554
+  latencyRequirement: 10
+---
+apiVersion: nsm.akosrbn.io/v1
+kind: NetworkConnection
+metadata:
+  name: uplink
+  namespace: edge
+spec:
+  source: edge/gateway
+  destination: camera-feed
+  connectionType: kernel
+  priority: 5
+---
+apiVersion: networking.k8s.io/v1
+kind: NetworkPolicy
+metadata:
+  name: camera
+  namespace: edge
+spec:
+  podSelector: {}
+---
+`
+
+func writeFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "bootstrap.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func newTestClient(t *testing.T, objs ...client.Object) client.Client {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatalf("failed to build scheme: %v", err)
+	}
+	if err := nsmv1.AddToScheme(scheme); err != nil {
+		t.Fatalf("failed to build scheme: %v", err)
+	}
+	return fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(objs...).
+		WithInterceptorFuncs(interceptor.Funcs{Patch: serverSideApply}).
+		Build()
+}
+
+// serverSideApply emulates server-side apply, which the fake client lacks:
+// the fields of the applied object are merged into the existing one, which
+// is only updated when they change it
+func serverSideApply(ctx context.Context, c client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	if patch.Type() != types.ApplyPatchType {
+		return c.Patch(ctx, obj, patch, opts...)
+	}
+	applied := obj.(*unstructured.Unstructured)
+	existing := &unstructured.Unstructured{}
+	existing.SetGroupVersionKind(applied.GroupVersionKind())
+	err := c.Get(ctx, client.ObjectKeyFromObject(applied), existing)
+	if apierrors.IsNotFound(err) {
+		return c.Create(ctx, applied)
+	}
+	if err != nil {
+		return err
+	}
+	merged := existing.DeepCopy()
+	mergeFields(merged.Object, applied.Object)
+	if !equality.Semantic.DeepEqual(merged.Object, existing.Object) {
+		if err := c.Update(ctx, merged); err != nil {
+			return err
+		}
+		existing = merged
+	}
+	existing.DeepCopyInto(applied)
+	return nil
+}
+
+// mergeFields sets the fields of src in dst, keeping the others
+func mergeFields(dst, src map[string]interface{}) {
+	for k, v := range src {
+		if fields, ok := v.(map[string]interface{}); ok {
+			if into, ok := dst[k].(map[string]interface{}); ok {
+				mergeFields(into, fields)
+				continue
+			}
+		}
+		dst[k] = v
+	}
+}
+
+func mustLoad(t *testing.T, content string) []*unstructured.Unstructured {
+	t.Helper()
+	objs, err := Load(writeFile(t, content))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	return objs
+}
+
+func TestLoad(t *testing.T) {
+	objs := mustLoad(t, siteFile+`apiVersion: nsm.akosrbn.io/v1
+kind: NetworkIntent
+metadata:
+  name: vision
+spec:
+  service: camera-feed
+  podSelector: {}
+`)
+	if len(objs) != 4 {
+		t.Fatalf("loaded %d objects, want 4", len(objs))
+	}
+	if objs[3].GetNamespace() != "default" {
+		t.Errorf("namespace = %q, want default", objs[3].GetNamespace())
+	}
+
+	for name, content := range map[string]string{
+		"unsupported kind": "apiVersion: v1\nkind: Secret\nmetadata:\n  name: keys\n",
+		"missing name":     "apiVersion: nsm.akosrbn.io/v1\nkind: NetworkService\nmetadata: {}\n",
+		"invalid yaml":     "apiVersion: [",
+	} {
+		if _, err := Load(writeFile(t, content)); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestApplyIsIdempotent(t *testing.T) {
+	c := newTestClient(t)
+	ctx := context.Background()
+
+	result, err := Apply(ctx, c, mustLoad(t, siteFile), false)
+	if err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	want := []string{"NetworkService/edge/camera-feed", "NetworkConnection/edge/uplink", "NetworkPolicy/edge/camera"}
+	if !reflect.DeepEqual(result.Created, want) {
+		t.Errorf("created %v, want %v", result.Created, want)
+	}
+
+	var conn nsmv1.NetworkConnection
+	if err := c.Get(ctx, client.ObjectKey{Namespace: "edge", Name: "uplink"}, &conn); err != nil {
+		t.Fatal(err)
+	}
+	if conn.Spec.Priority != 5 || conn.Labels[LabelBootstrap] != "true" {
+		t.Errorf("unexpected connection %+v", conn)
+	}
+
+	// restart: nothing changes
+	result, err = Apply(ctx, c, mustLoad(t, siteFile), false)
+	if err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if len(result.Created) != 0 || len(result.Updated) != 0 || len(result.Unchanged) != 3 {
+		t.Errorf("re-apply changed objects: %+v", result)
+	}
+
+	// fields defaulted by the API server aren't drift
+	var policy networkingv1.NetworkPolicy
+	if err := c.Get(ctx, client.ObjectKey{Namespace: "edge", Name: "camera"}, &policy); err != nil {
+		t.Fatal(err)
+	}
+	policy.Spec.PolicyTypes = []networkingv1.PolicyType{networkingv1.PolicyTypeIngress}
+	if err := c.Update(ctx, &policy); err != nil {
+		t.Fatal(err)
+	}
+	result, err = Apply(ctx, c, mustLoad(t, siteFile), false)
+	if err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if len(result.Updated) != 0 || len(result.Unchanged) != 3 {
+		t.Errorf("defaulted fields reported as changed: %+v", result)
+	}
+
+	// drift is reset
+	conn.Spec.Priority = 1
+	if err := c.Update(ctx, &conn); err != nil {
+		t.Fatal(err)
+	}
+	result, err = Apply(ctx, c, mustLoad(t, siteFile), false)
+	if err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if !reflect.DeepEqual(result.Updated, []string{"NetworkConnection/edge/uplink"}) {
+		t.Errorf("updated %v, want the drifted connection", result.Updated)
+	}
+	if err := c.Get(ctx, client.ObjectKey{Namespace: "edge", Name: "uplink"}, &conn); err != nil || conn.Spec.Priority != 5 {
+		t.Errorf("drifted priority not reset: %d (%v)", conn.Spec.Priority, err)
+	}
+}
+
+func TestApplyPrunesOnlyWithFlag(t *testing.T) {
+	// created by hand, never pruned
+	manual := &nsmv1.NetworkService{ObjectMeta: metav1.ObjectMeta{Name: "manual", Namespace: "edge"}}
+	c := newTestClient(t, manual)
+	ctx := context.Background()
+
+	if _, err := Apply(ctx, c, mustLoad(t, siteFile), false); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+
+	// the connection was removed from the file
+	reduced := mustLoad(t, siteFile)
+	reduced = append(reduced[:1], reduced[2:]...)
+
+	result, err := Apply(ctx, c, reduced, false)
+	if err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if !reflect.DeepEqual(result.Stale, []string{"NetworkConnection/edge/uplink"}) || len(result.Pruned) != 0 {
+		t.Errorf("without prune: stale %v, pruned %v", result.Stale, result.Pruned)
+	}
+	if err := c.Get(ctx, client.ObjectKey{Namespace: "edge", Name: "uplink"}, &nsmv1.NetworkConnection{}); err != nil {
+		t.Errorf("connection deleted without prune: %v", err)
+	}
+
+	result, err = Apply(ctx, c, reduced, true)
+	if err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if !reflect.DeepEqual(result.Pruned, []string{"NetworkConnection/edge/uplink"}) {
+		t.Errorf("pruned %v, want the removed connection", result.Pruned)
+	}
+	if err := c.Get(ctx, client.ObjectKey{Namespace: "edge", Name: "uplink"}, &nsmv1.NetworkConnection{}); err == nil {
+		t.Errorf("connection not pruned")
+	}
+	if err := c.Get(ctx, client.ObjectKey{Namespace: "edge", Name: "manual"}, &nsmv1.NetworkService{}); err != nil {
+		t.Errorf("object not created from the file was pruned: %v", err)
+	}
+}
+
+func TestApplyLeavesUnlabeledObjectsAlone(t *testing.T) {
+	// a service of the same name created by hand before the bootstrap
+	manual := &nsmv1.NetworkService{
+		ObjectMeta: metav1.ObjectMeta{Name: "camera-feed", Namespace: "edge"},
+		Spec:       nsmv1.NetworkServiceSpec{ServiceType: "l3", Endpoint: "10.0.0.9:554"},
+	}
+	c := newTestClient(t, manual)
+	ctx := context.Background()
+
+	result, err := Apply(ctx, c, mustLoad(t, siteFile), true)
+	if err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if !reflect.DeepEqual(result.Unowned, []string{"NetworkService/edge/camera-feed"}) || len(result.Created) != 2 {
+		t.Errorf("unowned %v, created %v, want the service left alone", result.Unowned, result.Created)
+	}
+	var svc nsmv1.NetworkService
+	if err := c.Get(ctx, client.ObjectKeyFromObject(manual), &svc); err != nil {
+		t.Fatal(err)
+	}
+	if svc.Spec.Endpoint != "10.0.0.9:554" || svc.Labels[LabelBootstrap] != "" {
+		t.Errorf("service created by hand was adopted: %+v", svc)
+	}
+
+	// so pruning never deletes it
+	if _, err := Apply(ctx, c, mustLoad(t, siteFile)[1:], true); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if err := c.Get(ctx, client.ObjectKeyFromObject(manual), &svc); err != nil {
+		t.Errorf("service created by hand was pruned: %v", err)
+	}
+}
@@ -0,0 +1,239 @@
+package budget
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/akos011221/nsm/pkg/watchdog"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// Highest degradation level, a component samples at most 2^MaxLevel times
+// less often than configured
+const MaxLevel = 3
+
+var (
+	// cpuPercent exposes the CPU usage of the budgeted components
+	cpuPercent = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "nsm_component_cpu_percent",
+		Help: "CPU time used by a component in the last budget window, in percent of one CPU",
+	}, []string{"component"})
+
+	// memoryBytes exposes the memory held by the budgeted components
+	memoryBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "nsm_component_memory_bytes",
+		Help: "Memory held by a component as estimated by the component",
+	}, []string{"component"})
+
+	// degradationLevel exposes how far the components slowed down
+	degradationLevel = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "nsm_component_degradation_level",
+		Help: "Degradation level of a component, its sampling interval is stretched by 2^level",
+	}, []string{"component"})
+)
+
+func init() {
+	crmetrics.Registry.MustRegister(cpuPercent, memoryBytes, degradationLevel)
+}
+
+// Budget limits the resources of a component
+type Budget struct {
+	// CPU time in percent of one CPU, 0 for no limit
+	CPUPercent float64
+	// Memory in bytes, 0 for no limit
+	MemoryBytes int64
+}
+
+// Tracker accounts the resources used by a component and tells it how
+// far to degrade. A nil tracker accounts nothing and never degrades.
+type Tracker struct {
+	// Name of the component
+	name string
+	// Limits of the component
+	budget Budget
+	// CPU time used in the current window
+	cpu time.Duration
+	// Memory held as last reported
+	memory int64
+	// Degradation level
+	level int
+	// Mutex for protecting the usage
+	mu sync.Mutex
+}
+
+// Run runs a unit of work of the component and accounts the CPU time of
+// the calling thread. Work handed to other goroutines is not accounted.
+func (t *Tracker) Run(fn func()) {
+	if t == nil {
+		fn()
+		return
+	}
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	start := threadCPU()
+	fn()
+	used := threadCPU() - start
+
+	t.mu.Lock()
+	t.cpu += used
+	t.mu.Unlock()
+}
+
+// SetMemory reports the memory currently held by the component
+func (t *Tracker) SetMemory(bytes int64) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.memory = bytes
+}
+
+// Level returns the degradation level, 0 while within budget
+func (t *Tracker) Level() int {
+	if t == nil {
+		return 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.level
+}
+
+// Divisor returns the share of the samples the component should take:
+// one in Divisor
+func (t *Tracker) Divisor() int {
+	return 1 << t.Level()
+}
+
+// Stretch returns the sampling interval to use at the degradation level
+func (t *Tracker) Stretch(interval time.Duration) time.Duration {
+	return interval * time.Duration(t.Divisor())
+}
+
+// evaluate compares the usage of the window with the budget and adjusts
+// the degradation level: one level up per window over budget, one level
+// down per window under half of it, so the level doesn't flap
+func (t *Tracker) evaluate(logger *logrus.Logger, elapsed time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	cpu := float64(t.cpu) / float64(elapsed) * 100
+	t.cpu = 0
+
+	over := (t.budget.CPUPercent > 0 && cpu > t.budget.CPUPercent) ||
+		(t.budget.MemoryBytes > 0 && t.memory > t.budget.MemoryBytes)
+	under := (t.budget.CPUPercent == 0 || cpu < t.budget.CPUPercent/2) &&
+		(t.budget.MemoryBytes == 0 || t.memory < t.budget.MemoryBytes/2)
+
+	switch {
+	case over && t.level < MaxLevel:
+		t.level++
+		logger.Warnf("%s over budget (%.1f%% CPU, %d bytes), sampling 1 in %d", t.name, cpu, t.memory, 1<<t.level)
+	case under && t.level > 0:
+		t.level--
+		logger.Infof("%s back within budget (%.1f%% CPU, %d bytes), sampling 1 in %d", t.name, cpu, t.memory, 1<<t.level)
+	}
+
+	cpuPercent.WithLabelValues(t.name).Set(cpu)
+	memoryBytes.WithLabelValues(t.name).Set(float64(t.memory))
+	degradationLevel.WithLabelValues(t.name).Set(float64(t.level))
+}
+
+// Manager checks the heavy subsystems against their budgets, keeping the
+// footprint of the agent predictable on small edge boxes
+type Manager struct {
+	// Context for cancellation
+	ctx context.Context
+	// Logger
+	logger *logrus.Logger
+	// Length of the windows the usage is measured over
+	window time.Duration
+	// Trackers by component
+	trackers map[string]*Tracker
+	// End of the last window
+	last time.Time
+	// Mutex for protecting the trackers
+	mu sync.Mutex
+	// Progress signal for the watchdog
+	heartbeat *watchdog.Heartbeat
+}
+
+// NewManager creates a new budget manager
+func NewManager(ctx context.Context, logger *logrus.Logger, window time.Duration) *Manager {
+	return &Manager{
+		ctx:      ctx,
+		logger:   logger,
+		window:   window,
+		trackers: make(map[string]*Tracker),
+		last:     time.Now(),
+	}
+}
+
+// Track returns the tracker of a component, creating it with the budget
+func (m *Manager) Track(name string, budget Budget) *Tracker {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if t, ok := m.trackers[name]; ok {
+		return t
+	}
+	t := &Tracker{name: name, budget: budget}
+	m.trackers[name] = t
+	return t
+}
+
+// SetHeartbeat makes the manager report its progress to the watchdog
+func (m *Manager) SetHeartbeat(hb *watchdog.Heartbeat) {
+	m.heartbeat = hb
+	hb.Expect(m.window)
+}
+
+// Start checks the budgets at the end of every window
+func (m *Manager) Start() error {
+	m.logger.Infof("Starting resource budget manager (window %s)", m.window)
+
+	ticker := time.NewTicker(m.window)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			m.heartbeat.Beat()
+			if err := m.Check(now); err != nil {
+				m.logger.WithError(err).Warn("Failed to check resource budgets")
+			}
+
+		case <-m.ctx.Done():
+			m.logger.Info("Stopping resource budget manager")
+			return nil
+		}
+	}
+}
+
+// Check ends the current window and adjusts the degradation levels
+func (m *Manager) Check(now time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	elapsed := now.Sub(m.last)
+	if elapsed <= 0 {
+		return fmt.Errorf("budget window ended at %s before it started at %s", now, m.last)
+	}
+	m.last = now
+
+	names := make([]string, 0, len(m.trackers))
+	for name := range m.trackers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		m.trackers[name].evaluate(m.logger, elapsed)
+	}
+	return nil
+}
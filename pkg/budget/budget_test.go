@@ -0,0 +1,93 @@
+package budget
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+)
+
+func quietLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return logger
+}
+
+func TestTrackerRunAccountsCPU(t *testing.T) {
+	m := NewManager(context.Background(), quietLogger(), time.Second)
+	tracker := m.Track("busy", Budget{CPUPercent: 10})
+
+	tracker.Run(func() {
+		for start := time.Now(); time.Since(start) < 50*time.Millisecond; {
+		}
+	})
+	tracker.mu.Lock()
+	used := tracker.cpu
+	tracker.mu.Unlock()
+	if used < 10*time.Millisecond {
+		t.Errorf("busy loop accounted %s of CPU", used)
+	}
+}
+
+func TestTrackerDegradesAndRecovers(t *testing.T) {
+	m := NewManager(context.Background(), quietLogger(), 10*time.Second)
+	tracker := m.Track("telemetry", Budget{CPUPercent: 5, MemoryBytes: 1000})
+	now := m.last
+
+	use := func(cpu time.Duration, memory int64) {
+		t.Helper()
+		tracker.mu.Lock()
+		tracker.cpu = cpu
+		tracker.mu.Unlock()
+		tracker.SetMemory(memory)
+		now = now.Add(10 * time.Second)
+		if err := m.Check(now); err != nil {
+			t.Fatalf("Check() error = %v", err)
+		}
+	}
+
+	// 10% CPU degrades one level per window up to the maximum
+	for level := 1; level <= MaxLevel+1; level++ {
+		use(time.Second, 0)
+		if want := min(level, MaxLevel); tracker.Level() != want {
+			t.Fatalf("level = %d after %d windows over budget, want %d", tracker.Level(), level, want)
+		}
+	}
+	if tracker.Divisor() != 8 || tracker.Stretch(250*time.Millisecond) != 2*time.Second {
+		t.Errorf("divisor = %d, stretch = %s at the maximum level", tracker.Divisor(), tracker.Stretch(250*time.Millisecond))
+	}
+	if testutil.ToFloat64(cpuPercent.WithLabelValues("telemetry")) != 10 || testutil.ToFloat64(degradationLevel.WithLabelValues("telemetry")) != MaxLevel {
+		t.Errorf("metrics not updated")
+	}
+
+	// between half and the full budget the level holds
+	use(400*time.Millisecond, 0)
+	if tracker.Level() != MaxLevel {
+		t.Errorf("level = %d within budget but above half of it, want %d", tracker.Level(), MaxLevel)
+	}
+
+	// under half of the budget recovers one level per window
+	use(100*time.Millisecond, 0)
+	if tracker.Level() != MaxLevel-1 {
+		t.Errorf("level = %d after a quiet window, want %d", tracker.Level(), MaxLevel-1)
+	}
+
+	// memory over budget degrades even with idle CPU
+	use(0, 2000)
+	if tracker.Level() != MaxLevel {
+		t.Errorf("level = %d with memory over budget, want %d", tracker.Level(), MaxLevel)
+	}
+}
+
+func TestNilTracker(t *testing.T) {
+	var tracker *Tracker
+	ran := false
+	tracker.Run(func() { ran = true })
+	tracker.SetMemory(1 << 30)
+	if !ran || tracker.Level() != 0 || tracker.Stretch(time.Second) != time.Second {
+		t.Errorf("nil tracker must run the work and never degrade")
+	}
+}
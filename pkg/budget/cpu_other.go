@@ -0,0 +1,14 @@
+//go:build !linux
+
+package budget
+
+import "time"
+
+// Start of the monotonic clock threadCPU measures from
+var clockStart = time.Now()
+
+// threadCPU approximates the CPU time with the wall time where the
+// thread CPU time isn't available, overestimating blocking work
+func threadCPU() time.Duration {
+	return time.Since(clockStart)
+}
@@ -0,0 +1,173 @@
+package bulk
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Supported bulk operations
+const (
+	// OpSetPriority changes the priority of the connections
+	OpSetPriority = "set-priority"
+	// OpReroute changes the destination of the connections
+	OpReroute = "reroute"
+	// OpLabel sets a label (key=value) on the connections
+	OpLabel = "label"
+	// OpUnlabel removes a label (key) from the connections
+	OpUnlabel = "unlabel"
+	// OpPause sets the administrative state of the connections to down
+	OpPause = "pause"
+	// OpResume sets the administrative state of the connections to up
+	OpResume = "resume"
+)
+
+// Request describes a bulk operation on the connections matching a selector
+type Request struct {
+	// Namespace of the connections, empty for all namespaces
+	Namespace string `json:"namespace,omitempty"`
+	// Label selector of the connections (e.g., tier=bulk)
+	Selector string `json:"selector"`
+	// Operation to apply
+	Operation string `json:"operation"`
+	// Operation argument (priority, destination, key=value or key)
+	Value string `json:"value,omitempty"`
+	// Only report what would change
+	DryRun bool `json:"dryRun,omitempty"`
+}
+
+// Result reports the outcome of a bulk operation
+type Result struct {
+	// Number of connections matching the selector
+	Matched int `json:"matched"`
+	// Connections that were (or would be) changed
+	Updated []string `json:"updated"`
+	// Connections that failed to update, with the reason
+	Failed map[string]string `json:"failed,omitempty"`
+}
+
+// Apply runs the bulk operation against the matching connections. An
+// empty selector is rejected, so a typo can't change the whole fleet.
+func Apply(ctx context.Context, c client.Client, req Request) (*Result, error) {
+	if strings.TrimSpace(req.Selector) == "" {
+		return nil, fmt.Errorf("a label selector is required for bulk operations")
+	}
+	selector, err := labels.Parse(req.Selector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid label selector: %w", err)
+	}
+
+	// validate the operation before touching anything
+	if _, err := Mutate(&nsmv1.NetworkConnection{}, req.Operation, req.Value); err != nil {
+		return nil, err
+	}
+
+	opts := []client.ListOption{client.MatchingLabelsSelector{Selector: selector}}
+	if req.Namespace != "" {
+		opts = append(opts, client.InNamespace(req.Namespace))
+	}
+
+	var conns nsmv1.NetworkConnectionList
+	if err := c.List(ctx, &conns, opts...); err != nil {
+		return nil, fmt.Errorf("failed to list connections: %w", err)
+	}
+
+	result := &Result{
+		Matched: len(conns.Items),
+		Updated: []string{},
+		Failed:  make(map[string]string),
+	}
+
+	for i := range conns.Items {
+		conn := &conns.Items[i]
+		key := conn.Namespace + "/" + conn.Name
+
+		changed, _ := Mutate(conn, req.Operation, req.Value)
+		if !changed {
+			continue
+		}
+		if !req.DryRun {
+			if err := c.Update(ctx, conn); err != nil {
+				result.Failed[key] = err.Error()
+				continue
+			}
+		}
+		result.Updated = append(result.Updated, key)
+	}
+
+	sort.Strings(result.Updated)
+	return result, nil
+}
+
+// Mutate applies the operation to a single connection and reports whether it changed
+func Mutate(conn *nsmv1.NetworkConnection, op, value string) (bool, error) {
+	switch op {
+	case OpSetPriority:
+		priority, err := strconv.ParseInt(value, 10, 32)
+		if err != nil || priority < 0 {
+			return false, fmt.Errorf("invalid priority: %s, must be a non-negative integer", value)
+		}
+		if conn.Spec.Priority == int32(priority) {
+			return false, nil
+		}
+		conn.Spec.Priority = int32(priority)
+		return true, nil
+
+	case OpReroute:
+		if value == "" {
+			return false, fmt.Errorf("reroute requires a destination")
+		}
+		if conn.Spec.Destination == value {
+			return false, nil
+		}
+		conn.Spec.Destination = value
+		return true, nil
+
+	case OpLabel:
+		key, val, ok := strings.Cut(value, "=")
+		if !ok || key == "" {
+			return false, fmt.Errorf("invalid label: %s, must be key=value", value)
+		}
+		if current, exists := conn.Labels[key]; exists && current == val {
+			return false, nil
+		}
+		if conn.Labels == nil {
+			conn.Labels = make(map[string]string)
+		}
+		conn.Labels[key] = val
+		return true, nil
+
+	case OpUnlabel:
+		if value == "" {
+			return false, fmt.Errorf("unlabel requires a label key")
+		}
+		if _, exists := conn.Labels[value]; !exists {
+			return false, nil
+		}
+		delete(conn.Labels, value)
+		return true, nil
+
+	case OpPause:
+		if conn.Spec.AdminState == nsmv1.AdminStateDown {
+			return false, nil
+		}
+		conn.Spec.AdminState = nsmv1.AdminStateDown
+		return true, nil
+
+	case OpResume:
+		if conn.Spec.AdminState != nsmv1.AdminStateDown {
+			return false, nil
+		}
+		conn.Spec.AdminState = nsmv1.AdminStateUp
+		return true, nil
+
+	default:
+		return false, fmt.Errorf("unknown bulk operation: %s", op)
+	}
+}
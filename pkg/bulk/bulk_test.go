@@ -0,0 +1,130 @@
+package bulk
+
+import (
+	"context"
+	"testing"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newConn(namespace, name string, labels map[string]string, priority int32) *nsmv1.NetworkConnection {
+	return &nsmv1.NetworkConnection{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: labels},
+		Spec: nsmv1.NetworkConnectionSpec{
+			Source:         namespace + "/pod",
+			Destination:    "svc-a",
+			ConnectionType: nsmv1.ConnectionTypeKernel,
+			Priority:       priority,
+		},
+	}
+}
+
+func newClient(t *testing.T, objs ...client.Object) client.Client {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := nsmv1.AddToScheme(scheme); err != nil {
+		t.Fatalf("failed to build scheme: %v", err)
+	}
+	return fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build()
+}
+
+func TestApplySetPriority(t *testing.T) {
+	c := newClient(t,
+		newConn("a", "bulk-1", map[string]string{"tier": "bulk"}, 50),
+		newConn("b", "bulk-2", map[string]string{"tier": "bulk"}, 10),
+		newConn("a", "gold", map[string]string{"tier": "gold"}, 50),
+	)
+
+	result, err := Apply(context.Background(), c, Request{Selector: "tier=bulk", Operation: OpSetPriority, Value: "10"})
+	if err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if result.Matched != 2 || len(result.Updated) != 1 || result.Updated[0] != "a/bulk-1" {
+		t.Errorf("unexpected result: %+v", result)
+	}
+
+	var conn nsmv1.NetworkConnection
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "a", Name: "bulk-1"}, &conn); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if conn.Spec.Priority != 10 {
+		t.Errorf("priority = %d, want 10", conn.Spec.Priority)
+	}
+
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "a", Name: "gold"}, &conn); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if conn.Spec.Priority != 50 {
+		t.Errorf("non-matching connection was changed")
+	}
+}
+
+func TestApplyDryRunAndNamespace(t *testing.T) {
+	c := newClient(t,
+		newConn("a", "bulk-1", map[string]string{"tier": "bulk"}, 50),
+		newConn("b", "bulk-2", map[string]string{"tier": "bulk"}, 50),
+	)
+
+	result, err := Apply(context.Background(), c, Request{Namespace: "b", Selector: "tier=bulk", Operation: OpReroute, Value: "svc-b", DryRun: true})
+	if err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if result.Matched != 1 || len(result.Updated) != 1 || result.Updated[0] != "b/bulk-2" {
+		t.Errorf("unexpected result: %+v", result)
+	}
+
+	var conn nsmv1.NetworkConnection
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "b", Name: "bulk-2"}, &conn); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if conn.Spec.Destination != "svc-a" {
+		t.Errorf("dry run changed the connection")
+	}
+}
+
+func TestApplyRejectsInvalidRequests(t *testing.T) {
+	c := newClient(t)
+	for _, req := range []Request{
+		{Selector: "", Operation: OpSetPriority, Value: "1"},
+		{Selector: "tier in (", Operation: OpSetPriority, Value: "1"},
+		{Selector: "tier=bulk", Operation: "explode"},
+		{Selector: "tier=bulk", Operation: OpSetPriority, Value: "-1"},
+	} {
+		if _, err := Apply(context.Background(), c, req); err == nil {
+			t.Errorf("expected error for %+v", req)
+		}
+	}
+}
+
+func TestMutateLabels(t *testing.T) {
+	conn := newConn("a", "c", nil, 0)
+	if changed, err := Mutate(conn, OpLabel, "incident=42"); err != nil || !changed {
+		t.Fatalf("Mutate(label) = %t, %v", changed, err)
+	}
+	if changed, _ := Mutate(conn, OpLabel, "incident=42"); changed {
+		t.Errorf("setting the same label should be a no-op")
+	}
+	if changed, err := Mutate(conn, OpUnlabel, "incident"); err != nil || !changed {
+		t.Fatalf("Mutate(unlabel) = %t, %v", changed, err)
+	}
+	if _, exists := conn.Labels["incident"]; exists {
+		t.Errorf("label was not removed")
+	}
+}
+
+func TestMutatePauseResume(t *testing.T) {
+	conn := newConn("a", "c", nil, 0)
+	if changed, err := Mutate(conn, OpResume, ""); err != nil || changed {
+		t.Errorf("resuming an up connection should be a no-op")
+	}
+	if changed, err := Mutate(conn, OpPause, ""); err != nil || !changed || conn.Spec.AdminState != nsmv1.AdminStateDown {
+		t.Fatalf("Mutate(pause) = %t, %v, adminState %q", changed, err, conn.Spec.AdminState)
+	}
+	if changed, err := Mutate(conn, OpResume, ""); err != nil || !changed || conn.Spec.AdminState != nsmv1.AdminStateUp {
+		t.Fatalf("Mutate(resume) = %t, %v, adminState %q", changed, err, conn.Spec.AdminState)
+	}
+}
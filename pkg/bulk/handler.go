@@ -0,0 +1,33 @@
+package bulk
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/akos011221/nsm/pkg/api"
+	"github.com/sirupsen/logrus"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Handler serves bulk operations over the management API
+func Handler(c client.Client, logger *logrus.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req Request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			api.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid bulk request: %w", err))
+			return
+		}
+
+		result, err := Apply(r.Context(), c, req)
+		if err != nil {
+			api.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		logger.Infof("Bulk %s (%s) on selector %q: %d matched, %d updated, %d failed, dry-run=%t",
+			req.Operation, req.Value, req.Selector, result.Matched, len(result.Updated), len(result.Failed), req.DryRun)
+
+		api.WriteJSON(w, http.StatusOK, result)
+	})
+}
@@ -0,0 +1,105 @@
+package canary
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/probe"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Defaults for unset canary parameters
+const (
+	DefaultProbes     = 10
+	DefaultIntervalMs = 100
+	DefaultTTLSeconds = 300
+)
+
+// maximum time a single canary run may take
+const maxRunTime = 2 * time.Minute
+
+// Run sends the synthetic traffic of the canary and evaluates it
+func Run(ctx context.Context, prober probe.Prober, spec *nsmv1.CanarySpec) (*nsmv1.CanaryStatus, error) {
+	probes := spec.Probes
+	if probes <= 0 {
+		probes = DefaultProbes
+	}
+	interval := time.Duration(spec.IntervalMs) * time.Millisecond
+	if interval <= 0 {
+		interval = DefaultIntervalMs * time.Millisecond
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, maxRunTime)
+	defer cancel()
+
+	samples, err := prober.Probe(ctx, spec.Target, probes, interval)
+	if err != nil {
+		return nil, fmt.Errorf("canary probes to %s failed: %w", spec.Target, err)
+	}
+
+	return Evaluate(spec, samples), nil
+}
+
+// Evaluate turns the probe samples into a canary verdict
+func Evaluate(spec *nsmv1.CanarySpec, samples []probe.Sample) *nsmv1.CanaryStatus {
+	status := &nsmv1.CanaryStatus{
+		Sent: len(samples),
+	}
+
+	var total time.Duration
+	for _, s := range samples {
+		if !s.OK {
+			continue
+		}
+		status.Received++
+		total += s.RTT
+		if ms := int(s.RTT.Milliseconds()); ms > status.MaxLatencyMs {
+			status.MaxLatencyMs = ms
+		}
+	}
+	if status.Received > 0 {
+		status.AvgLatencyMs = int((total / time.Duration(status.Received)).Milliseconds())
+	}
+
+	now := metav1.Now()
+	status.CompletionTime = &now
+
+	lossPercent := 100
+	if status.Sent > 0 {
+		lossPercent = (status.Sent - status.Received) * 100 / status.Sent
+	}
+
+	switch {
+	case status.Received == 0:
+		status.Phase = nsmv1.CanaryPhaseFailed
+		status.Message = fmt.Sprintf("no answer from %s over the candidate path", spec.Target)
+	case lossPercent > spec.MaxLossPercent:
+		status.Phase = nsmv1.CanaryPhaseFailed
+		status.Message = fmt.Sprintf("probe loss %d%% exceeds %d%%", lossPercent, spec.MaxLossPercent)
+	case spec.MaxLatencyMs > 0 && status.AvgLatencyMs > spec.MaxLatencyMs:
+		status.Phase = nsmv1.CanaryPhaseFailed
+		status.Message = fmt.Sprintf("average latency %dms exceeds %dms", status.AvgLatencyMs, spec.MaxLatencyMs)
+	default:
+		status.Phase = nsmv1.CanaryPhasePassed
+		status.Message = fmt.Sprintf("%d/%d probes answered, average latency %dms", status.Received, status.Sent, status.AvgLatencyMs)
+	}
+
+	return status
+}
+
+// Expired reports whether a completed canary outlived its TTL
+func Expired(spec *nsmv1.CanarySpec, status *nsmv1.CanaryStatus, now time.Time) (bool, time.Duration) {
+	if status == nil || status.CompletionTime == nil {
+		return false, 0
+	}
+
+	ttl := time.Duration(spec.TTLSeconds) * time.Second
+	if spec.TTLSeconds <= 0 {
+		ttl = DefaultTTLSeconds * time.Second
+	}
+
+	remaining := status.CompletionTime.Add(ttl).Sub(now)
+	return remaining <= 0, remaining
+}
@@ -0,0 +1,90 @@
+package canary
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/probe"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// fakeProber returns the configured samples
+type fakeProber struct {
+	samples []probe.Sample
+	count   int
+}
+
+func (f *fakeProber) Probe(ctx context.Context, target string, count int, interval time.Duration) ([]probe.Sample, error) {
+	f.count = count
+	return f.samples, nil
+}
+
+func samples(ok int, lost int, rtt time.Duration) []probe.Sample {
+	var s []probe.Sample
+	for i := 0; i < ok; i++ {
+		s = append(s, probe.Sample{RTT: rtt, OK: true})
+	}
+	for i := 0; i < lost; i++ {
+		s = append(s, probe.Sample{})
+	}
+	return s
+}
+
+func TestEvaluate(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    nsmv1.CanarySpec
+		samples []probe.Sample
+		want    string
+	}{
+		{"healthy path", nsmv1.CanarySpec{MaxLatencyMs: 10}, samples(10, 0, 2*time.Millisecond), nsmv1.CanaryPhasePassed},
+		{"too slow", nsmv1.CanarySpec{MaxLatencyMs: 10}, samples(10, 0, 20*time.Millisecond), nsmv1.CanaryPhaseFailed},
+		{"lossy", nsmv1.CanarySpec{MaxLossPercent: 10}, samples(8, 2, time.Millisecond), nsmv1.CanaryPhaseFailed},
+		{"tolerated loss", nsmv1.CanarySpec{MaxLossPercent: 20}, samples(8, 2, time.Millisecond), nsmv1.CanaryPhasePassed},
+		{"unreachable", nsmv1.CanarySpec{MaxLossPercent: 100}, samples(0, 5, 0), nsmv1.CanaryPhaseFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Evaluate(&tt.spec, tt.samples)
+			if got.Phase != tt.want {
+				t.Errorf("phase = %s (%s), want %s", got.Phase, got.Message, tt.want)
+			}
+			if got.CompletionTime == nil {
+				t.Errorf("completion time not set")
+			}
+		})
+	}
+}
+
+func TestRunDefaults(t *testing.T) {
+	p := &fakeProber{samples: samples(10, 0, time.Millisecond)}
+	status, err := Run(context.Background(), p, &nsmv1.CanarySpec{Target: "10.0.0.1:80"})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if p.count != DefaultProbes {
+		t.Errorf("probes = %d, want %d", p.count, DefaultProbes)
+	}
+	if status.Phase != nsmv1.CanaryPhasePassed || status.AvgLatencyMs != 1 {
+		t.Errorf("unexpected status: %+v", status)
+	}
+}
+
+func TestExpired(t *testing.T) {
+	spec := &nsmv1.CanarySpec{TTLSeconds: 60}
+	done := metav1.NewTime(time.Now().Add(-30 * time.Second))
+	status := &nsmv1.CanaryStatus{CompletionTime: &done}
+
+	if expired, remaining := Expired(spec, status, time.Now()); expired || remaining <= 0 {
+		t.Errorf("canary expired too early")
+	}
+	if expired, _ := Expired(spec, status, time.Now().Add(time.Minute)); !expired {
+		t.Errorf("canary should be expired")
+	}
+	if expired, _ := Expired(spec, &nsmv1.CanaryStatus{}, time.Now()); expired {
+		t.Errorf("running canary can't be expired")
+	}
+}
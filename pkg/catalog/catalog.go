@@ -0,0 +1,80 @@
+package catalog
+
+import (
+	"sort"
+	"sync"
+)
+
+// Entry is a NetworkService registered in the catalog
+type Entry struct {
+	// Namespace of the service
+	Namespace string `json:"namespace"`
+	// Name of the service
+	Name string `json:"name"`
+	// Type of the service (e.g., l2, l3, vpn)
+	ServiceType string `json:"serviceType"`
+	// Endpoint the service is reachable at
+	Endpoint string `json:"endpoint"`
+	// Phase of the service (Pending, Ready, Degraded)
+	Phase string `json:"phase"`
+	// Number of connections to the service
+	ConnectionCount int `json:"connectionCount"`
+	// Number of connections to the service that are established
+	Established int `json:"established"`
+}
+
+// Catalog is the registry of the valid NetworkServices the connections
+// can be set up to, kept by the service reconciler
+type Catalog struct {
+	// Entries by namespace/name
+	entries map[string]Entry
+	// Mutex for protecting the entries
+	mu sync.RWMutex
+}
+
+// NewCatalog creates an empty catalog
+func NewCatalog() *Catalog {
+	return &Catalog{entries: make(map[string]Entry)}
+}
+
+// Register adds a service to the catalog or updates its entry
+func (c *Catalog) Register(entry Entry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[entry.Namespace+"/"+entry.Name] = entry
+}
+
+// Deregister removes a service from the catalog
+func (c *Catalog) Deregister(namespace, name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, namespace+"/"+name)
+}
+
+// Lookup returns the entry of a service, false if it isn't registered
+func (c *Catalog) Lookup(namespace, name string) (Entry, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	entry, ok := c.entries[namespace+"/"+name]
+	return entry, ok
+}
+
+// List returns the services of a type (all if empty), sorted by namespace and name
+func (c *Catalog) List(serviceType string) []Entry {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	entries := []Entry{}
+	for _, entry := range c.entries {
+		if serviceType == "" || entry.ServiceType == serviceType {
+			entries = append(entries, entry)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Namespace != entries[j].Namespace {
+			return entries[i].Namespace < entries[j].Namespace
+		}
+		return entries[i].Name < entries[j].Name
+	})
+	return entries
+}
@@ -0,0 +1,29 @@
+package catalog
+
+import "testing"
+
+func TestCatalog(t *testing.T) {
+	c := NewCatalog()
+	c.Register(Entry{Namespace: "edge", Name: "vpn", ServiceType: "vpn"})
+	c.Register(Entry{Namespace: "edge", Name: "cameras", ServiceType: "l2"})
+	c.Register(Entry{Namespace: "core", Name: "routing", ServiceType: "l3"})
+
+	// re-registering updates the entry
+	c.Register(Entry{Namespace: "edge", Name: "cameras", ServiceType: "l2", ConnectionCount: 3})
+	if entry, ok := c.Lookup("edge", "cameras"); !ok || entry.ConnectionCount != 3 {
+		t.Errorf("Lookup() = %+v, %t", entry, ok)
+	}
+
+	entries := c.List("")
+	if len(entries) != 3 || entries[0].Name != "routing" || entries[1].Name != "cameras" || entries[2].Name != "vpn" {
+		t.Errorf("List() = %+v, want sorted by namespace and name", entries)
+	}
+	if entries := c.List("l2"); len(entries) != 1 || entries[0].Name != "cameras" {
+		t.Errorf("List(l2) = %+v", entries)
+	}
+
+	c.Deregister("edge", "vpn")
+	if _, ok := c.Lookup("edge", "vpn"); ok {
+		t.Error("deregistered service still registered")
+	}
+}
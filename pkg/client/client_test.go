@@ -0,0 +1,58 @@
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/client/clientset/versioned/fake"
+	"github.com/akos011221/nsm/pkg/client/informers/externalversions"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestClientsetInformersAndListers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cs := fake.NewSimpleClientset()
+	factory := externalversions.NewSharedInformerFactory(cs, time.Minute)
+	informer := factory.Nsm().V1().NetworkConnections()
+
+	added := make(chan string, 1)
+	informer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) { added <- obj.(*nsmv1.NetworkConnection).Name },
+	})
+	factory.Start(ctx.Done())
+	factory.WaitForCacheSync(ctx.Done())
+
+	conn := &nsmv1.NetworkConnection{
+		ObjectMeta: metav1.ObjectMeta{Name: "conn", Namespace: "edge"},
+		Spec:       nsmv1.NetworkConnectionSpec{Source: "edge/pod", Destination: "svc", ConnectionType: nsmv1.ConnectionTypeKernel},
+	}
+	if _, err := cs.NsmV1().NetworkConnections("edge").Create(ctx, conn, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	select {
+	case name := <-added:
+		if name != "conn" {
+			t.Errorf("informer saw %s, want conn", name)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("informer didn't see the created connection")
+	}
+
+	listed, err := informer.Lister().NetworkConnections("edge").List(labels.Everything())
+	if err != nil || len(listed) != 1 || listed[0].Spec.Destination != "svc" {
+		t.Errorf("lister returned %v (err %v)", listed, err)
+	}
+
+	conn.Status.State = nsmv1.ConnectionStateEstablished
+	updated, err := cs.NsmV1().NetworkConnections("edge").UpdateStatus(ctx, conn, metav1.UpdateOptions{})
+	if err != nil || updated.Status.State != nsmv1.ConnectionStateEstablished {
+		t.Errorf("UpdateStatus() = %+v, error %v", updated, err)
+	}
+}
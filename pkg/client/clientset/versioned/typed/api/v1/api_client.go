@@ -0,0 +1,131 @@
+/*
+Copyright NSM Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+// Code generated by client-gen. DO NOT EDIT.
+
+package v1
+
+import (
+	http "net/http"
+
+	apiv1 "github.com/akos011221/nsm/api/v1"
+	scheme "github.com/akos011221/nsm/pkg/client/clientset/versioned/scheme"
+	rest "k8s.io/client-go/rest"
+)
+
+type NsmV1Interface interface {
+	RESTClient() rest.Interface
+	NetworkBlueprintsGetter
+	NetworkConnectionsGetter
+	NetworkEndpointsGetter
+	NetworkFirmwareUpdatesGetter
+	NetworkIntentsGetter
+	NetworkServicesGetter
+}
+
+// NsmV1Client is used to interact with features provided by the nsm.akosrbn.io group.
+type NsmV1Client struct {
+	restClient rest.Interface
+}
+
+func (c *NsmV1Client) NetworkBlueprints(namespace string) NetworkBlueprintInterface {
+	return newNetworkBlueprints(c, namespace)
+}
+
+func (c *NsmV1Client) NetworkConnections(namespace string) NetworkConnectionInterface {
+	return newNetworkConnections(c, namespace)
+}
+
+func (c *NsmV1Client) NetworkEndpoints() NetworkEndpointInterface {
+	return newNetworkEndpoints(c)
+}
+
+func (c *NsmV1Client) NetworkFirmwareUpdates(namespace string) NetworkFirmwareUpdateInterface {
+	return newNetworkFirmwareUpdates(c, namespace)
+}
+
+func (c *NsmV1Client) NetworkIntents(namespace string) NetworkIntentInterface {
+	return newNetworkIntents(c, namespace)
+}
+
+func (c *NsmV1Client) NetworkServices(namespace string) NetworkServiceInterface {
+	return newNetworkServices(c, namespace)
+}
+
+// NewForConfig creates a new NsmV1Client for the given config.
+// NewForConfig is equivalent to NewForConfigAndClient(c, httpClient),
+// where httpClient was generated with rest.HTTPClientFor(c).
+func NewForConfig(c *rest.Config) (*NsmV1Client, error) {
+	config := *c
+	if err := setConfigDefaults(&config); err != nil {
+		return nil, err
+	}
+	httpClient, err := rest.HTTPClientFor(&config)
+	if err != nil {
+		return nil, err
+	}
+	return NewForConfigAndClient(&config, httpClient)
+}
+
+// NewForConfigAndClient creates a new NsmV1Client for the given config and http client.
+// Note the http client provided takes precedence over the configured transport values.
+func NewForConfigAndClient(c *rest.Config, h *http.Client) (*NsmV1Client, error) {
+	config := *c
+	if err := setConfigDefaults(&config); err != nil {
+		return nil, err
+	}
+	client, err := rest.RESTClientForConfigAndClient(&config, h)
+	if err != nil {
+		return nil, err
+	}
+	return &NsmV1Client{client}, nil
+}
+
+// NewForConfigOrDie creates a new NsmV1Client for the given config and
+// panics if there is an error in the config.
+func NewForConfigOrDie(c *rest.Config) *NsmV1Client {
+	client, err := NewForConfig(c)
+	if err != nil {
+		panic(err)
+	}
+	return client
+}
+
+// New creates a new NsmV1Client for the given RESTClient.
+func New(c rest.Interface) *NsmV1Client {
+	return &NsmV1Client{c}
+}
+
+func setConfigDefaults(config *rest.Config) error {
+	gv := apiv1.SchemeGroupVersion
+	config.GroupVersion = &gv
+	config.APIPath = "/apis"
+	config.NegotiatedSerializer = rest.CodecFactoryForGeneratedClient(scheme.Scheme, scheme.Codecs).WithoutConversion()
+
+	if config.UserAgent == "" {
+		config.UserAgent = rest.DefaultKubernetesUserAgent()
+	}
+
+	return nil
+}
+
+// RESTClient returns a RESTClient that is used to communicate
+// with API server by this client implementation.
+func (c *NsmV1Client) RESTClient() rest.Interface {
+	if c == nil {
+		return nil
+	}
+	return c.restClient
+}
@@ -0,0 +1,59 @@
+/*
+Copyright NSM Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+// Code generated by client-gen. DO NOT EDIT.
+
+package fake
+
+import (
+	v1 "github.com/akos011221/nsm/pkg/client/clientset/versioned/typed/api/v1"
+	rest "k8s.io/client-go/rest"
+	testing "k8s.io/client-go/testing"
+)
+
+type FakeNsmV1 struct {
+	*testing.Fake
+}
+
+func (c *FakeNsmV1) NetworkBlueprints(namespace string) v1.NetworkBlueprintInterface {
+	return newFakeNetworkBlueprints(c, namespace)
+}
+
+func (c *FakeNsmV1) NetworkConnections(namespace string) v1.NetworkConnectionInterface {
+	return newFakeNetworkConnections(c, namespace)
+}
+
+func (c *FakeNsmV1) NetworkEndpoints() v1.NetworkEndpointInterface {
+	return newFakeNetworkEndpoints(c)
+}
+
+func (c *FakeNsmV1) NetworkFirmwareUpdates(namespace string) v1.NetworkFirmwareUpdateInterface {
+	return newFakeNetworkFirmwareUpdates(c, namespace)
+}
+
+func (c *FakeNsmV1) NetworkIntents(namespace string) v1.NetworkIntentInterface {
+	return newFakeNetworkIntents(c, namespace)
+}
+
+func (c *FakeNsmV1) NetworkServices(namespace string) v1.NetworkServiceInterface {
+	return newFakeNetworkServices(c, namespace)
+}
+
+// RESTClient returns a RESTClient that is used to communicate
+// with API server by this client implementation.
+func (c *FakeNsmV1) RESTClient() rest.Interface {
+	var ret *rest.RESTClient
+	return ret
+}
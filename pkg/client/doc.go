@@ -0,0 +1,9 @@
+// Package client holds the generated typed clientset, informers and listers
+// of the nsm.akosrbn.io API group, for external Go programs watching and
+// creating NetworkServices, NetworkConnections and NetworkIntents:
+//
+//	cs, err := versioned.NewForConfig(restConfig)
+//	conns, err := cs.NsmV1().NetworkConnections("edge").List(ctx, metav1.ListOptions{})
+//
+// Regenerate with "make -C hack generate-client" after changing api/v1.
+package client
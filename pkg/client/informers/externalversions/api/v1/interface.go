@@ -0,0 +1,79 @@
+/*
+Copyright NSM Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+// Code generated by informer-gen. DO NOT EDIT.
+
+package v1
+
+import (
+	internalinterfaces "github.com/akos011221/nsm/pkg/client/informers/externalversions/internalinterfaces"
+)
+
+// Interface provides access to all the informers in this group version.
+type Interface interface {
+	// NetworkBlueprints returns a NetworkBlueprintInformer.
+	NetworkBlueprints() NetworkBlueprintInformer
+	// NetworkConnections returns a NetworkConnectionInformer.
+	NetworkConnections() NetworkConnectionInformer
+	// NetworkEndpoints returns a NetworkEndpointInformer.
+	NetworkEndpoints() NetworkEndpointInformer
+	// NetworkFirmwareUpdates returns a NetworkFirmwareUpdateInformer.
+	NetworkFirmwareUpdates() NetworkFirmwareUpdateInformer
+	// NetworkIntents returns a NetworkIntentInformer.
+	NetworkIntents() NetworkIntentInformer
+	// NetworkServices returns a NetworkServiceInformer.
+	NetworkServices() NetworkServiceInformer
+}
+
+type version struct {
+	factory          internalinterfaces.SharedInformerFactory
+	namespace        string
+	tweakListOptions internalinterfaces.TweakListOptionsFunc
+}
+
+// New returns a new Interface.
+func New(f internalinterfaces.SharedInformerFactory, namespace string, tweakListOptions internalinterfaces.TweakListOptionsFunc) Interface {
+	return &version{factory: f, namespace: namespace, tweakListOptions: tweakListOptions}
+}
+
+// NetworkBlueprints returns a NetworkBlueprintInformer.
+func (v *version) NetworkBlueprints() NetworkBlueprintInformer {
+	return &networkBlueprintInformer{factory: v.factory, namespace: v.namespace, tweakListOptions: v.tweakListOptions}
+}
+
+// NetworkConnections returns a NetworkConnectionInformer.
+func (v *version) NetworkConnections() NetworkConnectionInformer {
+	return &networkConnectionInformer{factory: v.factory, namespace: v.namespace, tweakListOptions: v.tweakListOptions}
+}
+
+// NetworkEndpoints returns a NetworkEndpointInformer.
+func (v *version) NetworkEndpoints() NetworkEndpointInformer {
+	return &networkEndpointInformer{factory: v.factory, tweakListOptions: v.tweakListOptions}
+}
+
+// NetworkFirmwareUpdates returns a NetworkFirmwareUpdateInformer.
+func (v *version) NetworkFirmwareUpdates() NetworkFirmwareUpdateInformer {
+	return &networkFirmwareUpdateInformer{factory: v.factory, namespace: v.namespace, tweakListOptions: v.tweakListOptions}
+}
+
+// NetworkIntents returns a NetworkIntentInformer.
+func (v *version) NetworkIntents() NetworkIntentInformer {
+	return &networkIntentInformer{factory: v.factory, namespace: v.namespace, tweakListOptions: v.tweakListOptions}
+}
+
+// NetworkServices returns a NetworkServiceInformer.
+func (v *version) NetworkServices() NetworkServiceInformer {
+	return &networkServiceInformer{factory: v.factory, namespace: v.namespace, tweakListOptions: v.tweakListOptions}
+}
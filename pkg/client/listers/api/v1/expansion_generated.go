@@ -0,0 +1,62 @@
+/*
+Copyright NSM Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+// Code generated by lister-gen. DO NOT EDIT.
+
+package v1
+
+// NetworkBlueprintListerExpansion allows custom methods to be added to
+// NetworkBlueprintLister.
+type NetworkBlueprintListerExpansion interface{}
+
+// NetworkBlueprintNamespaceListerExpansion allows custom methods to be added to
+// NetworkBlueprintNamespaceLister.
+type NetworkBlueprintNamespaceListerExpansion interface{}
+
+// NetworkConnectionListerExpansion allows custom methods to be added to
+// NetworkConnectionLister.
+type NetworkConnectionListerExpansion interface{}
+
+// NetworkConnectionNamespaceListerExpansion allows custom methods to be added to
+// NetworkConnectionNamespaceLister.
+type NetworkConnectionNamespaceListerExpansion interface{}
+
+// NetworkEndpointListerExpansion allows custom methods to be added to
+// NetworkEndpointLister.
+type NetworkEndpointListerExpansion interface{}
+
+// NetworkFirmwareUpdateListerExpansion allows custom methods to be added to
+// NetworkFirmwareUpdateLister.
+type NetworkFirmwareUpdateListerExpansion interface{}
+
+// NetworkFirmwareUpdateNamespaceListerExpansion allows custom methods to be added to
+// NetworkFirmwareUpdateNamespaceLister.
+type NetworkFirmwareUpdateNamespaceListerExpansion interface{}
+
+// NetworkIntentListerExpansion allows custom methods to be added to
+// NetworkIntentLister.
+type NetworkIntentListerExpansion interface{}
+
+// NetworkIntentNamespaceListerExpansion allows custom methods to be added to
+// NetworkIntentNamespaceLister.
+type NetworkIntentNamespaceListerExpansion interface{}
+
+// NetworkServiceListerExpansion allows custom methods to be added to
+// NetworkServiceLister.
+type NetworkServiceListerExpansion interface{}
+
+// NetworkServiceNamespaceListerExpansion allows custom methods to be added to
+// NetworkServiceNamespaceLister.
+type NetworkServiceNamespaceListerExpansion interface{}
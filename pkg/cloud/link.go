@@ -0,0 +1,173 @@
+package cloud
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// Modes of the node
+const (
+	// ModeConnected is a node whose heartbeats reach the cloud
+	ModeConnected = "connected"
+	// ModeAutonomous is a node cut off from the cloud, deciding on its
+	// local state until the heartbeats get through again
+	ModeAutonomous = "autonomous"
+)
+
+var (
+	// autonomousMode is 1 while the node runs autonomously
+	autonomousMode = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "nsm_cloud_autonomous",
+		Help: "Whether the node is disconnected from the cloud and runs autonomously",
+	})
+
+	// disconnectsTotal counts the switches to the autonomous mode
+	disconnectsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "nsm_cloud_disconnects_total",
+		Help: "Number of times the node lost the cloud and switched to the autonomous mode",
+	})
+)
+
+func init() {
+	crmetrics.Registry.MustRegister(autonomousMode, disconnectsTotal)
+}
+
+// Signal tells whether the node is cut off from the cloud
+type Signal interface {
+	Autonomous() bool
+}
+
+// Status is the connectivity of the node to the cloud
+type Status struct {
+	// Mode (connected, autonomous)
+	Mode string `json:"mode"`
+	// Last time a heartbeat reached the cloud, nil if none did yet
+	LastContact *time.Time `json:"lastContact,omitempty"`
+	// Time the node switched to the current mode
+	Since time.Time `json:"since"`
+	// Number of heartbeats failed in a row
+	Failures int `json:"failures"`
+	// Error of the last failed heartbeat, empty after a success
+	LastError string `json:"lastError,omitempty"`
+}
+
+// Link tracks the connectivity of the node to the cloud from the outcome
+// of its heartbeats. Once no heartbeat got through for the disconnect
+// period the node switches to the autonomous mode, in which the
+// components depending on the cloud keep deciding on their local state,
+// and back with the first heartbeat acknowledged.
+type Link struct {
+	// Logger
+	logger *logrus.Logger
+	// Time without contact after which the node runs autonomously
+	disconnectAfter time.Duration
+	// Whether the node runs autonomously
+	autonomous bool
+	// Time the node switched to the current mode, or the link was created
+	since time.Time
+	// Last time a heartbeat got through, zero if none did
+	lastContact time.Time
+	// Heartbeats failed in a row and the error of the last one
+	failures  int
+	lastError string
+	// Functions called when the mode changes
+	listeners []func(autonomous bool)
+	// Mutex for protecting the state
+	mu sync.RWMutex
+}
+
+// NewLink creates a link to the cloud, counting the disconnect period
+// from now
+func NewLink(logger *logrus.Logger, disconnectAfter time.Duration) *Link {
+	return &Link{
+		logger:          logger,
+		disconnectAfter: disconnectAfter,
+		since:           time.Now(),
+	}
+}
+
+// Report records the outcome of a heartbeat sent at now, nil for one the
+// cloud acknowledged
+func (l *Link) Report(err error, now time.Time) {
+	l.mu.Lock()
+	changed := false
+	if err == nil {
+		if l.autonomous {
+			l.logger.Infof("Reconnected to the cloud after %s, leaving autonomous mode", now.Sub(l.since).Round(time.Second))
+			l.autonomous, l.since, changed = false, now, true
+		}
+		l.lastContact, l.failures, l.lastError = now, 0, ""
+	} else {
+		l.failures++
+		l.lastError = err.Error()
+		contact := l.lastContact
+		if contact.IsZero() {
+			contact = l.since
+		}
+		if !l.autonomous && now.Sub(contact) >= l.disconnectAfter {
+			l.logger.Warnf("No heartbeat reached the cloud for %s (%s), switching to autonomous mode",
+				now.Sub(contact).Round(time.Second), l.lastError)
+			l.autonomous, l.since, changed = true, now, true
+			disconnectsTotal.Inc()
+		}
+	}
+	autonomous := l.autonomous
+	listeners := append([]func(bool){}, l.listeners...)
+	l.mu.Unlock()
+
+	if autonomous {
+		autonomousMode.Set(1)
+	} else {
+		autonomousMode.Set(0)
+	}
+	if changed {
+		for _, fn := range listeners {
+			fn(autonomous)
+		}
+	}
+}
+
+// Autonomous reports whether the node is cut off from the cloud
+func (l *Link) Autonomous() bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.autonomous
+}
+
+// AutonomousFor returns how long the node has been running autonomously
+// at now, 0 while connected
+func (l *Link) AutonomousFor(now time.Time) time.Duration {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if !l.autonomous {
+		return 0
+	}
+	return now.Sub(l.since)
+}
+
+// OnChange registers a function called when the node switches between
+// the connected and autonomous modes
+func (l *Link) OnChange(fn func(autonomous bool)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.listeners = append(l.listeners, fn)
+}
+
+// Status returns the connectivity of the node
+func (l *Link) Status() Status {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	s := Status{Mode: ModeConnected, Since: l.since, Failures: l.failures, LastError: l.lastError}
+	if l.autonomous {
+		s.Mode = ModeAutonomous
+	}
+	if !l.lastContact.IsZero() {
+		contact := l.lastContact
+		s.LastContact = &contact
+	}
+	return s
+}
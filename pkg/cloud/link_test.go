@@ -0,0 +1,78 @@
+package cloud
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+)
+
+func testLink(disconnectAfter time.Duration) *Link {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return NewLink(logger, disconnectAfter)
+}
+
+func TestLinkSwitchesToAutonomousMode(t *testing.T) {
+	l := testLink(90 * time.Second)
+	start := l.since
+	var changes []bool
+	l.OnChange(func(autonomous bool) { changes = append(changes, autonomous) })
+	down := errors.New("connection refused")
+
+	l.Report(nil, start.Add(30*time.Second))
+	l.Report(down, start.Add(60*time.Second))
+	l.Report(down, start.Add(90*time.Second))
+	if l.Autonomous() {
+		t.Fatal("autonomous before the disconnect period")
+	}
+
+	before := testutil.ToFloat64(disconnectsTotal)
+	l.Report(down, start.Add(120*time.Second))
+	if !l.Autonomous() {
+		t.Fatal("not autonomous after the disconnect period without contact")
+	}
+	if got := testutil.ToFloat64(disconnectsTotal) - before; got != 1 {
+		t.Errorf("disconnects = %v, want 1", got)
+	}
+	s := l.Status()
+	if s.Mode != ModeAutonomous || s.Failures != 3 || s.LastError != "connection refused" || !s.LastContact.Equal(start.Add(30*time.Second)) {
+		t.Errorf("Status() = %+v", s)
+	}
+	if got := l.AutonomousFor(start.Add(150 * time.Second)); got != 30*time.Second {
+		t.Errorf("AutonomousFor() = %s, want 30s", got)
+	}
+
+	// further failures don't switch again
+	l.Report(down, start.Add(150*time.Second))
+	l.Report(nil, start.Add(180*time.Second))
+	if l.Autonomous() {
+		t.Fatal("still autonomous after a heartbeat got through")
+	}
+	if s := l.Status(); s.Mode != ModeConnected || s.Failures != 0 || s.LastError != "" || !s.Since.Equal(start.Add(180*time.Second)) {
+		t.Errorf("Status() after reconnecting = %+v", s)
+	}
+	if len(changes) != 2 || !changes[0] || changes[1] {
+		t.Errorf("changes = %v, want [true false]", changes)
+	}
+}
+
+func TestLinkWithoutContactSinceStart(t *testing.T) {
+	l := testLink(time.Minute)
+	start := l.since
+
+	l.Report(errors.New("timeout"), start.Add(30*time.Second))
+	if l.Autonomous() {
+		t.Fatal("autonomous before the disconnect period")
+	}
+	l.Report(errors.New("timeout"), start.Add(time.Minute))
+	if !l.Autonomous() {
+		t.Error("not autonomous without any contact for the disconnect period")
+	}
+	if s := l.Status(); s.LastContact != nil {
+		t.Errorf("LastContact = %v, want none", s.LastContact)
+	}
+}
@@ -0,0 +1,67 @@
+package cni
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/akos011221/nsm/pkg/api"
+)
+
+// Client asks the controller for the VFs of pods over its CNI socket
+type Client struct {
+	// HTTP client dialing the socket
+	http *http.Client
+}
+
+// NewClient creates a new client of the CNI socket
+func NewClient(socketPath string) *Client {
+	return &Client{
+		http: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", socketPath)
+				},
+			},
+		},
+	}
+}
+
+// RequestVF returns the VF allocated to a pod, to be moved into the
+// network namespace netns as ifName
+func (c *Client) RequestVF(ctx context.Context, namespace, pod, netns, ifName string) (*VFResponse, error) {
+	body, err := json.Marshal(VFRequest{Namespace: namespace, Pod: pod, Netns: netns, Interface: ifName})
+	if err != nil {
+		return nil, err
+	}
+	// the host is ignored, the transport always dials the socket
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://nsm"+VFPath, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach the NSM controller: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var apiErr api.ErrorResponse
+		if err := json.NewDecoder(resp.Body).Decode(&apiErr); err != nil || apiErr.Error == "" {
+			return nil, fmt.Errorf("NSM controller answered %s", resp.Status)
+		}
+		return nil, fmt.Errorf("NSM controller: %s", apiErr.Error)
+	}
+
+	vf := &VFResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(vf); err != nil {
+		return nil, fmt.Errorf("invalid response from the NSM controller: %w", err)
+	}
+	return vf, nil
+}
@@ -0,0 +1,188 @@
+package cni
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/akos011221/nsm/pkg/api"
+	"github.com/akos011221/nsm/pkg/hardware"
+	"github.com/sirupsen/logrus"
+)
+
+// VFPath is where the CNI plugin asks for the VF of a pod
+const VFPath = "/v1/vf"
+
+// VFRequest asks for the VF allocated to a pod
+type VFRequest struct {
+	// Namespace of the pod
+	Namespace string `json:"namespace"`
+	// Name of the pod
+	Pod string `json:"pod"`
+	// Network namespace the VF is moved into, recorded to restore the VF
+	// after PF resets
+	Netns string `json:"netns,omitempty"`
+	// Interface name of the VF in the pod
+	Interface string `json:"interface,omitempty"`
+}
+
+// VFResponse is the VF allocated to a pod
+type VFResponse struct {
+	// PF the VF belongs to (e.g., eth0)
+	PFName string `json:"pfName"`
+	// VF ID on the PF
+	VFID int `json:"vfId"`
+	// VF PCI address
+	PCIAddress string `json:"pciAddress"`
+	// Interface of the VF in the host network namespace
+	InterfaceName string `json:"interfaceName"`
+}
+
+// VFSource looks up the VFs allocated to pods
+type VFSource interface {
+	GetVFsForPod(namespace, podName string) []hardware.VirtualFunction
+	SetAttachment(namespace, podName, netns, ifName string) bool
+	Resync()
+}
+
+// Server answers the CNI plugin with the VFs allocated to pods, over a
+// unix socket only root on the node can reach. The plugin runs as soon as
+// the sandbox of a pod is created, often before the allocator has seen the
+// pod, so the lookup triggers a resync and waits for the allocation.
+type Server struct {
+	// Context for cancellation
+	ctx context.Context
+	// Logger
+	logger *logrus.Logger
+	// Path of the unix socket
+	socketPath string
+	// VF allocations
+	vfs VFSource
+	// Longest time a lookup waits for the pod to get a VF
+	timeout time.Duration
+	// Interval the allocation is checked for while waiting
+	pollInterval time.Duration
+}
+
+// NewServer creates a new CNI plugin server
+func NewServer(ctx context.Context, logger *logrus.Logger, socketPath string, vfs VFSource) *Server {
+	return &Server{
+		ctx:          ctx,
+		logger:       logger,
+		socketPath:   socketPath,
+		vfs:          vfs,
+		timeout:      10 * time.Second,
+		pollInterval: 200 * time.Millisecond,
+	}
+}
+
+// Handler returns the HTTP handler of the server
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST "+VFPath, s.handleVF)
+	return mux
+}
+
+// Start serves the plugin on the socket until the context is done
+func (s *Server) Start() error {
+	s.logger.Infof("Starting CNI plugin server on %s", s.socketPath)
+
+	if err := os.MkdirAll(filepath.Dir(s.socketPath), 0o755); err != nil {
+		return fmt.Errorf("failed to create the directory of the CNI socket: %w", err)
+	}
+	// the socket of a previous run is left behind on crashes
+	if err := os.Remove(s.socketPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove the stale CNI socket: %w", err)
+	}
+	lis, err := net.Listen("unix", s.socketPath)
+	if err != nil {
+		return fmt.Errorf("failed to listen on the CNI socket: %w", err)
+	}
+	if err := os.Chmod(s.socketPath, 0o600); err != nil {
+		lis.Close()
+		return fmt.Errorf("failed to restrict the CNI socket: %w", err)
+	}
+
+	srv := &http.Server{
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		if err := srv.Serve(lis); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			errCh <- err
+		}
+	}()
+
+	select {
+	case err := <-errCh:
+		return fmt.Errorf("CNI plugin server failed: %w", err)
+	case <-s.ctx.Done():
+		s.logger.Info("Stopping CNI plugin server")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return srv.Shutdown(shutdownCtx)
+	}
+}
+
+// handleVF answers with the VF of a pod, waiting for its allocation
+func (s *Server) handleVF(w http.ResponseWriter, r *http.Request) {
+	var req VFRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		api.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+	if req.Namespace == "" || req.Pod == "" {
+		api.WriteError(w, http.StatusBadRequest, fmt.Errorf("namespace and pod are required"))
+		return
+	}
+
+	vf, ok := s.waitForVF(r.Context(), req.Namespace, req.Pod, req.Interface)
+	if !ok {
+		api.WriteError(w, http.StatusNotFound, fmt.Errorf("no VF allocated to pod %s/%s", req.Namespace, req.Pod))
+		return
+	}
+	if req.Netns != "" {
+		s.vfs.SetAttachment(req.Namespace, req.Pod, req.Netns, req.Interface)
+	}
+	s.logger.Infof("Handing VF %s-vf%d (%s) to the CNI plugin for pod %s/%s",
+		vf.PFName, vf.VFID, vf.PCIAddress, req.Namespace, req.Pod)
+	api.WriteJSON(w, http.StatusOK, VFResponse{
+		PFName:        vf.PFName,
+		VFID:          vf.VFID,
+		PCIAddress:    vf.PCIAddress,
+		InterfaceName: vf.InterfaceName,
+	})
+}
+
+// waitForVF looks up the VF of a pod to hand out as an interface,
+// resyncing the allocations once if the pod has none yet and waiting up to
+// the timeout for it. Pods holding several VFs get one per interface.
+func (s *Server) waitForVF(ctx context.Context, namespace, pod, ifName string) (hardware.VirtualFunction, bool) {
+	if vf, ok := hardware.PickVF(s.vfs.GetVFsForPod(namespace, pod), ifName); ok {
+		return vf, true
+	}
+	s.vfs.Resync()
+
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	ticker := time.NewTicker(s.pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if vf, ok := hardware.PickVF(s.vfs.GetVFsForPod(namespace, pod), ifName); ok {
+				return vf, true
+			}
+		case <-ctx.Done():
+			return hardware.VirtualFunction{}, false
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package cni
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/akos011221/nsm/pkg/hardware"
+	"github.com/sirupsen/logrus"
+)
+
+// fakeVFs allocates the pending VFs on resync, as the allocator would on
+// seeing the pods
+type fakeVFs struct {
+	mu        sync.Mutex
+	allocated map[string]hardware.VirtualFunction
+	pending   map[string]hardware.VirtualFunction
+	resyncs   int
+	attached  map[string]string
+}
+
+func (f *fakeVFs) GetVFsForPod(namespace, podName string) []hardware.VirtualFunction {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if vf, ok := f.allocated[namespace+"/"+podName]; ok {
+		return []hardware.VirtualFunction{vf}
+	}
+	return nil
+}
+
+func (f *fakeVFs) SetAttachment(namespace, podName, netns, ifName string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.allocated[namespace+"/"+podName]; !ok {
+		return false
+	}
+	f.attached[namespace+"/"+podName] = netns + " " + ifName
+	return true
+}
+
+func (f *fakeVFs) Resync() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.resyncs++
+	for key, vf := range f.pending {
+		f.allocated[key] = vf
+	}
+}
+
+// startServer serves the VFs on a socket in a short temporary directory,
+// unix socket paths are limited to about 100 bytes
+func startServer(t *testing.T, vfs VFSource) *Client {
+	t.Helper()
+	dir, err := os.MkdirTemp("", "nsm-cni")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	socket := filepath.Join(dir, "run", "cni.sock")
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	ctx, cancel := context.WithCancel(context.Background())
+	s := NewServer(ctx, logger, socket, vfs)
+	s.timeout = 200 * time.Millisecond
+	s.pollInterval = 10 * time.Millisecond
+
+	done := make(chan error, 1)
+	go func() { done <- s.Start() }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Start() error = %v", err)
+		}
+	})
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, err := os.Stat(socket); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("CNI socket never created")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	info, err := os.Stat(socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Errorf("socket permissions = %o, want 600", perm)
+	}
+	return NewClient(socket)
+}
+
+func TestRequestVF(t *testing.T) {
+	vfs := &fakeVFs{
+		allocated: map[string]hardware.VirtualFunction{
+			"edge/camera": {PFName: "eth0", VFID: 1, PCIAddress: "0000:3b:02.1", InterfaceName: "eth0v1", Allocated: true},
+		},
+		pending: map[string]hardware.VirtualFunction{
+			"edge/router": {PFName: "eth0", VFID: 2, PCIAddress: "0000:3b:02.2", InterfaceName: "eth0v2", Allocated: true},
+		},
+		attached: make(map[string]string),
+	}
+	c := startServer(t, vfs)
+
+	vf, err := c.RequestVF(context.Background(), "edge", "camera", "/var/run/netns/camera", "net1")
+	if err != nil {
+		t.Fatalf("RequestVF() error = %v", err)
+	}
+	want := VFResponse{PFName: "eth0", VFID: 1, PCIAddress: "0000:3b:02.1", InterfaceName: "eth0v1"}
+	if *vf != want {
+		t.Errorf("RequestVF() = %+v, want %+v", *vf, want)
+	}
+	if got := vfs.attached["edge/camera"]; got != "/var/run/netns/camera net1" {
+		t.Errorf("attachment = %q, want the namespace and interface of the plugin", got)
+	}
+	if vfs.resyncs != 0 {
+		t.Errorf("resynced %d times for an allocated VF", vfs.resyncs)
+	}
+
+	// pods the allocator hasn't seen yet get their VF after a resync
+	vf, err = c.RequestVF(context.Background(), "edge", "router", "", "")
+	if err != nil || vf.VFID != 2 || vfs.resyncs != 1 {
+		t.Errorf("RequestVF() = %+v, %v after %d resyncs, want VF 2 after one", vf, err, vfs.resyncs)
+	}
+
+	if _, err := c.RequestVF(context.Background(), "edge", "batch", "", ""); err == nil || !strings.Contains(err.Error(), "no VF allocated") {
+		t.Errorf("RequestVF() error = %v for a pod without a VF", err)
+	}
+	if _, err := c.RequestVF(context.Background(), "", "camera", "", ""); err == nil {
+		t.Error("RequestVF() accepted a request without a namespace")
+	}
+}
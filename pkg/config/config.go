@@ -1,13 +1,25 @@
 package config
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"net"
+	"net/url"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
+
+	"sigs.k8s.io/yaml"
 )
 
 type Config struct {
+	// File the config was loaded from, empty without one. It isn't a
+	// setting, the controller reloads the file when it changes.
+	File string `json:"-"`
 	// QoS class for prioritization (high, medium, low)
 	QoSPriority string `json:"qosPriority"`
 	// Edge node identifier
@@ -16,26 +28,502 @@ type Config struct {
 	EnableSRIOV bool `json:"enableSRIOV"`
 	// Whether to enable DPDK support
 	EnableDPDK bool `json:"enableDPDK"`
-	// Maximum latency treshold in milliseconds
+	// Userspace driver the DPDK devices are bound to (vfio-pci, igb_uio)
+	DPDKDriver string `json:"dpdkDriver"`
+	// PCI addresses of the NICs bound to the DPDK driver on startup
+	DPDKDevices []string `json:"dpdkDevices"`
+	// Maximum latency treshold in milliseconds. It is only validated, no
+	// component reads it, the latency requirement of a connection is set
+	// in its spec.
 	LatencyTreshold int `json:"latencyTreshold"`
 	// Heartbeat interval for cloud connectivity in seconds
 	CloudHeartbeatSec int `json:"cloudHeartbeatSec"`
+	// URL the heartbeats with the connection telemetry are posted to (empty to disable)
+	CloudEndpoint string `json:"cloudEndpoint"`
+	// Length of the windows the telemetry is rolled up into, in seconds
+	TelemetryRollupSec int `json:"telemetryRollupSec"`
+	// Number of busiest connections reported per heartbeat, 0 for all
+	TelemetryTopN int `json:"telemetryTopN"`
+	// Maximum size of a heartbeat in bytes, 0 for no limit
+	TelemetryMaxBytes int `json:"telemetryMaxBytes"`
 	// Failover strategy (fast, balanced, reliable)
 	FailoverStrategy string `json:"failoverStrategy"`
 	// Kubeconfig file path (empty for in-cluster config)
 	Kubeconfig string `json:"kubeconfig"`
+	// Whether to run BFD sessions towards the gateways of the failover
+	// paths for fast failure detection
+	EnableBFD bool `json:"enableBFD"`
+	// Whether to announce healthy anycast services over BGP
+	EnableRouteInjection bool `json:"enableRouteInjection"`
+	// Path of the gobgp CLI used to drive the local BGP speaker
+	GoBGPBinary string `json:"gobgpBinary"`
+	// Next hop to announce anycast prefixes with (empty for self)
+	BGPNextHop string `json:"bgpNextHop"`
+	// Address of the xDS server for Envoy gateways (empty to disable)
+	XDSListenAddr string `json:"xdsListenAddr"`
+	// Name of the cluster Envoy gateways use to reach the xDS server
+	XDSClusterName string `json:"xdsClusterName"`
+	// Address of the management API (empty to disable)
+	APIListenAddr string `json:"apiListenAddr"`
+	// Bearer token required by the management API operations that change
+	// state (empty refuses them, read-only operations are always served)
+	APIToken string `json:"apiToken"`
+	// Whether to keep serving the v1alpha1 management API, deprecated, for
+	// older nsmctl and agents during fleet upgrades
+	ServeLegacyAPI bool `json:"serveLegacyAPI"`
+	// Date (YYYY-MM-DD) after which the v1alpha1 API may be removed,
+	// announced to its clients (empty if not scheduled)
+	LegacyAPISunset string `json:"legacyAPISunset"`
+	// Whether to serve pprof endpoints on the management API and take
+	// periodic heap/goroutine snapshots
+	EnableProfiling bool `json:"enableProfiling"`
+	// Bearer token required by the pprof endpoints
+	ProfilingToken string `json:"profilingToken"`
+	// Directory the snapshots are written to (empty to disable snapshots)
+	ProfileDir string `json:"profileDir"`
+	// Interval between snapshots in seconds
+	ProfileIntervalSec int `json:"profileIntervalSec"`
+	// Number of snapshots of each kind to keep
+	ProfileMaxSnapshots int `json:"profileMaxSnapshots"`
+	// Whether to watch the component loops for stalls
+	EnableWatchdog bool `json:"enableWatchdog"`
+	// Number of missed loop intervals after which a component is stalled
+	WatchdogMissedIntervals int `json:"watchdogMissedIntervals"`
+	// Whether stalled components are restarted
+	WatchdogRestart bool `json:"watchdogRestart"`
+	// Datapath for sriov connections on nodes without SR-IOV (macvlan, ipvlan, none)
+	FallbackDatapath string `json:"fallbackDatapath"`
+	// Uplink the fallback interfaces are created on (empty to detect)
+	FallbackUplink string `json:"fallbackUplink"`
+	// Whether encrypted connections use the inline crypto offload of the uplink NIC
+	EnableCryptoOffload bool `json:"enableCryptoOffload"`
+	// Seconds between rekeys of encrypted connections, 0 to disable key rotation
+	RekeyIntervalSec int `json:"rekeyIntervalSec"`
+	// Minimum seconds between two rekeys on the node
+	RekeyGapSec int `json:"rekeyGapSec"`
+	// Controller-global Secret (namespace/name) older versions stored the
+	// tunnel keys in, migrated to per-namespace Secrets on startup
+	LegacyKeySecret string `json:"legacyKeySecret"`
+	// Declarative file of services, connections and policies applied on
+	// startup, for air-gapped sites without GitOps
+	BootstrapFile string `json:"bootstrapFile"`
+	// Whether objects removed from the bootstrap file are deleted
+	BootstrapPrune bool `json:"bootstrapPrune"`
+	// Listen address of the gRPC streaming APIs, connection metrics and the
+	// SR-IOV inventory (empty to disable)
+	MetricsStreamListenAddr string `json:"metricsStreamListenAddr"`
+	// Whether non-critical allocations and telemetry pause under node pressure
+	EnablePressureThrottling bool `json:"enablePressureThrottling"`
+	// Share of time tasks stall on CPU, memory or IO (PSI avg10) in percent
+	// above which the node is under pressure
+	PressureStallThresholdPercent int `json:"pressureStallThresholdPercent"`
+	// Available memory in percent below which the node is under pressure
+	PressureMinMemAvailablePercent int `json:"pressureMinMemAvailablePercent"`
+	// Priority from which connections are set up even under node pressure
+	PressureCriticalPriority int `json:"pressureCriticalPriority"`
+	// Whether the hwmon temperature and power sensors are read and exposed
+	EnableThermalMonitor bool `json:"enableThermalMonitor"`
+	// Degrees Celsius below the limit of a sensor the node counts as hot at
+	ThermalMarginCelsius int `json:"thermalMarginCelsius"`
+	// Priority below which connections are shed while the node is hot, 0 to never shed
+	ThermalShedPriority int `json:"thermalShedPriority"`
+	// Whether telemetry is sampled less often while the node is hot
+	ThermalReducePolling bool `json:"thermalReducePolling"`
+	// Seconds without pods requesting NSM resources after which the node
+	// enters the low-power idle mode, 0 to disable
+	IdleAfterSec int `json:"idleAfterSec"`
+	// Whether heavy subsystems sample less often once they exceed their budgets
+	EnableResourceBudgets bool `json:"enableResourceBudgets"`
+	// CPU budget of the canary probing in percent of one CPU, 0 for no limit
+	ProbeCPUBudgetPercent int `json:"probeCPUBudgetPercent"`
+	// CPU budget of the telemetry sampling in percent of one CPU, 0 for no limit
+	TelemetryCPUBudgetPercent int `json:"telemetryCPUBudgetPercent"`
+	// Memory budget of the telemetry rollups in MiB, 0 for no limit
+	TelemetryMemoryBudgetMB int `json:"telemetryMemoryBudgetMB"`
+	// CPU budget of the metrics streams in percent of one CPU, 0 for no limit
+	MetricsStreamCPUBudgetPercent int `json:"metricsStreamCPUBudgetPercent"`
+	// External device inventory NetworkServices are synced from (csv,
+	// netbox), empty to disable
+	RegistrySource string `json:"registrySource"`
+	// File path or http(s) URL of the CSV, or the base URL of NetBox
+	RegistryURL string `json:"registryURL"`
+	// NetBox API token
+	RegistryToken string `json:"registryToken"`
+	// NetBox query parameters filtering the devices (e.g., site=edge-01)
+	RegistryFilter string `json:"registryFilter"`
+	// Namespace of the services of devices without one
+	RegistryNamespace string `json:"registryNamespace"`
+	// Service type of devices without one
+	RegistryServiceType string `json:"registryServiceType"`
+	// Seconds between syncs of the inventory
+	RegistrySyncIntervalSec int `json:"registrySyncIntervalSec"`
+	// Whether the endpoints of the services are scored and ranked by reachability
+	EnableReachabilityScoring bool `json:"enableReachabilityScoring"`
+	// File the flap history of the endpoints is persisted in (empty to keep it in memory)
+	ReachabilityStateFile string `json:"reachabilityStateFile"`
+	// Whether connections going up and down in rapid succession are held down
+	EnableFlapDamping bool `json:"enableFlapDamping"`
+	// Penalty above which the setup of a flapping connection is suppressed,
+	// each flap adds 1000
+	FlapSuppressThreshold int `json:"flapSuppressThreshold"`
+	// Penalty below which a suppressed connection is set up again
+	FlapReuseThreshold int `json:"flapReuseThreshold"`
+	// Seconds for the penalty of a connection to decay to half
+	FlapHalfLifeSec int `json:"flapHalfLifeSec"`
+	// Maximum seconds a connection stays suppressed after its last flap
+	FlapMaxSuppressSec int `json:"flapMaxSuppressSec"`
+	// Whether the objects of the nodes (generated connections, lost nodes)
+	// are sharded across the controller replicas by consistent hashing of
+	// the node names instead of reconciled by the leader
+	EnableSharding bool `json:"enableSharding"`
+	// Namespace of the Leases the replicas announce themselves with
+	ShardNamespace string `json:"shardNamespace"`
+	// Seconds a replica stays a member without renewing its Lease
+	ShardLeaseDurationSec int `json:"shardLeaseDurationSec"`
+	// Whether the agent of the node registers with a Lease it renews, and
+	// the controller fails over the connections of nodes whose Lease expired
+	EnableAgentLeases bool `json:"enableAgentLeases"`
+	// Namespace of the agent Leases
+	AgentLeaseNamespace string `json:"agentLeaseNamespace"`
+	// Seconds an agent stays alive without renewing its Lease
+	AgentLeaseDurationSec int `json:"agentLeaseDurationSec"`
+	// Whether connections without an owner are deleted once their endpoints are gone
+	EnableConnectionGC bool `json:"enableConnectionGC"`
+	// Seconds the endpoints of a connection must be gone before it is deleted
+	ConnectionGCGraceSec int `json:"connectionGCGraceSec"`
+	// Whether stale connections are only logged and counted, not deleted
+	ConnectionGCDryRun bool `json:"connectionGCDryRun"`
+	// Whether Gateway API routes with NetworkService backends generate connections
+	EnableGatewayAPI bool `json:"enableGatewayAPI"`
+	// Whether NetworkService endpoints are published as Services and EndpointSlices
+	EnableEndpointSlices bool `json:"enableEndpointSlices"`
+	// Whether source pods get a resolv.conf with the resolvers of their connections
+	EnableConnectionDNS bool `json:"enableConnectionDNS"`
+	// Cluster resolver listed after those of the connections, empty for none
+	ClusterDNS string `json:"clusterDNS"`
+	// Cluster domain the search domains of pods derive from
+	ClusterDomain string `json:"clusterDomain"`
+	// Broadcast packets per second a pod may send through its VF, 0 for no limit
+	SRIOVBroadcastPPS int `json:"sriovBroadcastPPS"`
+	// Multicast packets per second a pod may send through its VF, 0 for no limit
+	SRIOVMulticastPPS int `json:"sriovMulticastPPS"`
+	// Whether the MAC learning tables of bridges are watched for churn and anomalies
+	EnableFDBMonitor bool `json:"enableFDBMonitor"`
+	// Moves of a MAC between bridge ports within a minute that make it flapping
+	FDBFlapThreshold int `json:"fdbFlapThreshold"`
+	// Seconds between probe rounds of stable priority 0 connections with a
+	// probe target, 0 to disable probing
+	ProbeIntervalSec int `json:"probeIntervalSec"`
+	// Shortest interval in seconds between probe rounds of critical or unstable connections
+	ProbeMinIntervalSec int `json:"probeMinIntervalSec"`
+	// Probes per second the node may send to the probe targets of its connections
+	ProbeBudgetPerSec int `json:"probeBudgetPerSec"`
+	// Whether operators may trigger failover drills over the management API
+	EnableFailoverDrills bool `json:"enableFailoverDrills"`
+	// Longest time in seconds a drill keeps a device down
+	FailoverDrillMaxSec int `json:"failoverDrillMaxSec"`
+	// Longest TTL in seconds of the VF leases requested over the management API
+	VFLeaseMaxTTLSec int `json:"vfLeaseMaxTTLSec"`
+	// Whether the usage of the namespaces is metered into chargeback reports
+	EnableUsageReports bool `json:"enableUsageReports"`
+	// Length of the period of a usage report in seconds
+	UsageReportSec int `json:"usageReportSec"`
+	// Whether the usage reports are pushed to the cloud with the heartbeats
+	PushUsageReports bool `json:"pushUsageReports"`
+	// What to do with disruptive datapath changes (PF resets, driver rebinds,
+	// VF count changes) the disruption budgets of the affected pods don't
+	// allow yet: defer them until allowed, or evict the pods as allowed
+	DisruptionPolicy string `json:"disruptionPolicy"`
+	// Longest time in seconds the evictions for a disruptive change are waited for
+	DisruptionTimeoutSec int `json:"disruptionTimeoutSec"`
+	// Unix socket the CNI plugin asks for the VFs of pods on, empty to not
+	// serve the plugin
+	CNISocket string `json:"cniSocket"`
+	// Whether PF resets keep the pods, quiescing their connections and
+	// re-attaching their VFs after the reset, instead of evicting them
+	EnableVFReparenting bool `json:"enableVFReparenting"`
+	// Whether NetworkFirmwareUpdates of the node's PFs are run
+	EnableFirmwareUpdates bool `json:"enableFirmwareUpdates"`
+	// File the VF allocations are persisted in across restarts (empty to
+	// keep them in memory)
+	SRIOVStateFile string `json:"sriovStateFile"`
+	// Command flashing the firmware, with {pf}, {pci} and {image}
+	// placeholders, empty for devlink dev flash
+	FirmwareUpdateCommand string `json:"firmwareUpdateCommand"`
+	// Longest time the firmware update command may run
+	FirmwareUpdateTimeoutSec int `json:"firmwareUpdateTimeoutSec"`
+	// Whether the NICs are managed through devlink: eswitch modes and
+	// health reporters
+	EnableDevlink bool `json:"enableDevlink"`
+	// Interval between devlink health polls
+	DevlinkPollIntervalSec int `json:"devlinkPollIntervalSec"`
+	// Whether PCI hotplug events update the VF inventory right away
+	EnableHotplug bool `json:"enableHotplug"`
+	// Whether the paths of connections with failover are checked and their
+	// traffic re-routed with the failover strategy
+	EnableFailover bool `json:"enableFailover"`
+	// Seconds between the VF discovery scans checking that no hotplug event
+	// was missed, replacing the regular polls while the events drive the
+	// discovery
+	HotplugConsistencyCheckSec int `json:"hotplugConsistencyCheckSec"`
+	// Seconds without a heartbeat reaching the cloud after which the node
+	// runs autonomously on its local state
+	CloudDisconnectAfterSec int `json:"cloudDisconnectAfterSec"`
+	// Seconds between the checks of the VF inventory against the links,
+	// sysfs and the pods, 0 to only check on demand through the API
+	InventoryCheckIntervalSec int `json:"inventoryCheckIntervalSec"`
+	// Whether the inconsistencies found by the periodic checks are
+	// repaired, otherwise they are only reported with the suggested repair
+	EnableInventoryRepair bool `json:"enableInventoryRepair"`
+	// Whether test traffic can be sent over connections through the
+	// management API
+	EnableTrafficTests bool `json:"enableTrafficTests"`
+	// Longest traffic test in seconds
+	TrafficTestMaxSec int `json:"trafficTestMaxSec"`
+	// Highest rate a traffic test offers in Mbps
+	TrafficTestMaxRateMbps int `json:"trafficTestMaxRateMbps"`
+	// UDP address echoing the test traffic of other nodes (e.g., :9107),
+	// empty to not reflect it
+	TrafficReflectorListenAddr string `json:"trafficReflectorListenAddr"`
+	// Whether connection priorities and bandwidths are enforced with tc
+	// on the QoS interfaces
+	EnableQoS bool `json:"enableQoS"`
+	// PF or VF interfaces whose egress traffic is shaped
+	QoSInterfaces []string `json:"qosInterfaces"`
+	// Link rate in Mbps of the QoS interfaces whose speed the kernel
+	// doesn't report (e.g., VFs)
+	QoSLinkRateMbps int `json:"qosLinkRateMbps"`
+	// Seconds between the updates of the traffic shaping
+	QoSIntervalSec int `json:"qosIntervalSec"`
+	// Whether the latencies the node achieves to the services are published
+	// as labels of its Node for the scheduler
+	EnableLatencyHints bool `json:"enableLatencyHints"`
+	// Seconds between the publications of the latency hints
+	LatencyHintIntervalSec int `json:"latencyHintIntervalSec"`
+	// Kubernetes Node the latency hints are published on, defaults to the
+	// edge node ID
+	LatencyHintNodeName string `json:"latencyHintNodeName"`
+	// Address serving the Prometheus metrics on /metrics, and those of a
+	// single component on /metrics/{component} (empty to disable)
+	MetricsListenAddr string `json:"metricsListenAddr"`
+	// Whether invalid NetworkConnections and NetworkServices are rejected
+	// by a validating admission webhook when they are created or updated
+	EnableAdmissionWebhook bool `json:"enableAdmissionWebhook"`
+	// Port the admission webhook is served on over TLS
+	AdmissionWebhookPort int `json:"admissionWebhookPort"`
+	// Directory holding the serving certificate of the admission webhook
+	// (tls.crt, tls.key)
+	AdmissionWebhookCertDir string `json:"admissionWebhookCertDir"`
+	// Whether the security posture report requires every connection to be
+	// encrypted
+	PostureRequireEncryption bool `json:"postureRequireEncryption"`
+	// Seconds a key may stay in use before the security posture report
+	// flags the connection (0 for no limit)
+	PostureMaxKeyAgeSec int `json:"postureMaxKeyAgeSec"`
+	// Whether the throughput of the connections is watched for spikes and
+	// beaconing, reported as events on the connections
+	EnableAnomalyDetection bool `json:"enableAnomalyDetection"`
+	// Seconds between anomaly detections
+	AnomalyIntervalSec int `json:"anomalyIntervalSec"`
+	// Multiple of its baseline throughput a connection must reach to be
+	// reported as a spike
+	AnomalySpikeFactor int `json:"anomalySpikeFactor"`
+	// URL the anomalies are posted to as JSON (empty for events only)
+	AnomalyWebhookURL string `json:"anomalyWebhookURL"`
+	// Whether the interfaces of the node are published as NetworkEndpoints
+	// for connections to reference
+	EnableEndpointRegistry bool `json:"enableEndpointRegistry"`
+	// Seconds between publications of the NetworkEndpoints
+	EndpointRegistryIntervalSec int `json:"endpointRegistryIntervalSec"`
+	// Unix socket local agents request and release VFs on over gRPC, empty
+	// to not serve them
+	VFSocket string `json:"vfSocket"`
+	// Whether the VFs of the PFs the SR-IOV network operator provisions are
+	// left to it when it manages the node, NSM only allocating them
+	EnableSRIOVOperatorCoexistence bool `json:"enableSRIOVOperatorCoexistence"`
+	// Kubernetes Node the operator's node state is named after, defaults to
+	// the edge node ID
+	SRIOVOperatorNodeName string `json:"sriovOperatorNodeName"`
+	// Kubernetes Node the hugepage condition of DPDK is set on, defaults to
+	// the edge node ID
+	DPDKNodeName string `json:"dpdkNodeName"`
+	// Whether the KubeVirt VMIs labeled for SR-IOV are allocated VFs, in
+	// place of the pods running them
+	EnableKubeVirt bool `json:"enableKubeVirt"`
+	// Kubernetes Node the VMIs allocated VFs run on, defaults to the edge
+	// node ID
+	KubeVirtNodeName string `json:"kubeVirtNodeName"`
+	// Whether the controller replicas elect a leader with a Lease, only the
+	// leader reconciling the cluster-scope objects (intents, blueprints,
+	// routes, published services) while the node-scope managers and
+	// reconcilers run on every replica
+	EnableLeaderElection bool `json:"enableLeaderElection"`
+	// Namespace of the leader election Lease
+	LeaderElectionNamespace string `json:"leaderElectionNamespace"`
+	// Seconds the leader keeps leading without renewing its Lease, the
+	// longest the cluster-scope reconciliation pauses on a failover
+	LeaderElectionLeaseDurationSec int `json:"leaderElectionLeaseDurationSec"`
+	// Userspace dataplane the vhost-user ports of the vhostuser connections
+	// are added to (ovs, vpp), empty to reject those connections
+	VhostUserDataplane string `json:"vhostUserDataplane"`
+	// Host directory holding the vhost-user sockets, one sub-directory per
+	// connection, mounted into the workloads
+	VhostUserSocketDir string `json:"vhostUserSocketDir"`
+	// Bridge the vhost-user ports join: an OVS bridge, or a VPP bridge
+	// domain ID, empty to leave the VPP interfaces unbridged
+	VhostUserBridge string `json:"vhostUserBridge"`
+	// Address serving the liveness on /healthz and the readiness on
+	// /readyz of the components, from their watchdog heartbeats (empty to
+	// disable)
+	HealthListenAddr string `json:"healthListenAddr"`
+	// Dataplane forwarding the kernel connections: kernel, or vpp for
+	// userspace forwarding with FD.io VPP
+	Dataplane string `json:"dataplane"`
+	// VPP interface of the uplink the connections are forwarded over with
+	// the vpp dataplane (e.g., TenGigabitEthernet3b/0/0)
+	VPPUplink string `json:"vppUplink"`
+	// Cost of the uplinks the failover paths use, the cheapest healthy
+	// path within its monthly budget is preferred (empty to prefer the
+	// paths in the order of the connection spec)
+	UplinkCosts []UplinkCost `json:"uplinkCosts"`
+	// File the month-to-date traffic of the uplinks is persisted in (empty
+	// to keep it in memory)
+	UplinkCostStateFile string `json:"uplinkCostStateFile"`
+}
+
+// UplinkCost is the cost metadata of an uplink, e.g., a metered LTE link
+// or a flat-rate fiber one
+type UplinkCost struct {
+	// Network interface of the uplink
+	Device string `json:"device"`
+	// Price of a gigabyte sent or received, 0 for a flat-rate link
+	CostPerGB float64 `json:"costPerGB"`
+	// Spend per month after which the uplink is avoided, 0 for no budget
+	MonthlyBudget float64 `json:"monthlyBudget,omitempty"`
 }
 
 func DefaultConfig() *Config {
 	return &Config{
-		QoSPriority:       "high",
-		EdgeNodeID:        getDefaultEdgeNodeID(),
-		EnableSRIOV:       false,
-		EnableDPDK:        false,
-		LatencyTreshold:   10,
-		CloudHeartbeatSec: 30,
-		FailoverStrategy:  "balanced",
-		Kubeconfig:        "", // so it will use the pod's identity
+		QoSPriority:                    "high",
+		EdgeNodeID:                     getDefaultEdgeNodeID(),
+		EnableSRIOV:                    false,
+		EnableDPDK:                     false,
+		DPDKDriver:                     "vfio-pci",
+		LatencyTreshold:                10,
+		CloudHeartbeatSec:              30,
+		TelemetryRollupSec:             60,
+		FailoverStrategy:               "balanced",
+		Kubeconfig:                     "", // so it will use the pod's identity
+		EnableBFD:                      false,
+		GoBGPBinary:                    "gobgp",
+		XDSClusterName:                 "nsm-xds",
+		APIListenAddr:                  "127.0.0.1:9090",
+		ServeLegacyAPI:                 true,
+		EnableProfiling:                false,
+		ProfileDir:                     "/var/lib/nsm/profiles",
+		ProfileIntervalSec:             3600,
+		ProfileMaxSnapshots:            24,
+		EnableWatchdog:                 true,
+		WatchdogMissedIntervals:        3,
+		WatchdogRestart:                false,
+		FallbackDatapath:               "macvlan",
+		EnableCryptoOffload:            true,
+		RekeyIntervalSec:               86400,
+		RekeyGapSec:                    10,
+		MetricsStreamListenAddr:        "127.0.0.1:9091",
+		EnablePressureThrottling:       true,
+		PressureStallThresholdPercent:  40,
+		PressureMinMemAvailablePercent: 10,
+		PressureCriticalPriority:       100,
+		EnableThermalMonitor:           true,
+		ThermalMarginCelsius:           5,
+		EnableResourceBudgets:          true,
+		ProbeCPUBudgetPercent:          5,
+		TelemetryCPUBudgetPercent:      5,
+		TelemetryMemoryBudgetMB:        16,
+		MetricsStreamCPUBudgetPercent:  10,
+		RegistryNamespace:              "default",
+		RegistryServiceType:            "l3",
+		RegistrySyncIntervalSec:        300,
+		EnableReachabilityScoring:      true,
+		ReachabilityStateFile:          "/var/lib/nsm/reachability.json",
+		EnableFlapDamping:              true,
+		FlapSuppressThreshold:          2000,
+		FlapReuseThreshold:             750,
+		FlapHalfLifeSec:                900,
+		FlapMaxSuppressSec:             3600,
+		ShardNamespace:                 "nsm-system",
+		ShardLeaseDurationSec:          15,
+		EnableAgentLeases:              false,
+		AgentLeaseNamespace:            "nsm-system",
+		AgentLeaseDurationSec:          40,
+		ConnectionGCGraceSec:           600,
+		ClusterDNS:                     "10.96.0.10",
+		ClusterDomain:                  "cluster.local",
+		FDBFlapThreshold:               3,
+		ProbeIntervalSec:               30,
+		ProbeMinIntervalSec:            2,
+		ProbeBudgetPerSec:              10,
+		EnableFailoverDrills:           false,
+		FailoverDrillMaxSec:            30,
+		VFLeaseMaxTTLSec:               86400,
+		EnableUsageReports:             false,
+		UsageReportSec:                 3600,
+		PushUsageReports:               false,
+		DisruptionPolicy:               "defer",
+		DisruptionTimeoutSec:           300,
+		CNISocket:                      "/var/run/nsm/cni.sock",
+		EnableVFReparenting:            true,
+		SRIOVStateFile:                 "/var/lib/nsm/sriov-allocations.json",
+		EnableFirmwareUpdates:          false,
+		FirmwareUpdateCommand:          "",
+		FirmwareUpdateTimeoutSec:       1800,
+		EnableDevlink:                  false,
+		DevlinkPollIntervalSec:         30,
+		EnableHotplug:                  true,
+		EnableFailover:                 true,
+		HotplugConsistencyCheckSec:     300,
+		CloudDisconnectAfterSec:        90,
+		InventoryCheckIntervalSec:      600,
+		EnableInventoryRepair:          false,
+		EnableTrafficTests:             false,
+		TrafficTestMaxSec:              60,
+		TrafficTestMaxRateMbps:         1000,
+		TrafficReflectorListenAddr:     "",
+		EnableQoS:                      false,
+		QoSLinkRateMbps:                1000,
+		QoSIntervalSec:                 30,
+		EnableLatencyHints:             false,
+		LatencyHintIntervalSec:         60,
+		LatencyHintNodeName:            "",
+		MetricsListenAddr:              ":9092",
+		EnableAdmissionWebhook:         false,
+		AdmissionWebhookPort:           9443,
+		AdmissionWebhookCertDir:        "/etc/nsm/webhook-certs",
+		PostureRequireEncryption:       true,
+		PostureMaxKeyAgeSec:            172800, // twice the default rekey interval
+		EnableAnomalyDetection:         false,
+		AnomalyIntervalSec:             30,
+		AnomalySpikeFactor:             5,
+		AnomalyWebhookURL:              "",
+		EnableEndpointRegistry:         false,
+		EndpointRegistryIntervalSec:    60,
+		VFSocket:                       "/var/run/nsm/vf.sock",
+		EnableSRIOVOperatorCoexistence: true,
+		SRIOVOperatorNodeName:          "",
+		DPDKNodeName:                   "",
+		EnableKubeVirt:                 false,
+		KubeVirtNodeName:               "",
+		EnableLeaderElection:           false,
+		LeaderElectionNamespace:        "nsm-system",
+		LeaderElectionLeaseDurationSec: 15,
+		VhostUserDataplane:             "",
+		VhostUserSocketDir:             "/var/run/nsm/vhost-user",
+		VhostUserBridge:                "",
+		HealthListenAddr:               ":8081",
+		Dataplane:                      "kernel",
+		VPPUplink:                      "",
+		UplinkCostStateFile:            "/var/lib/nsm/uplink-costs.json",
 	}
 }
 
@@ -44,16 +532,14 @@ func LoadConfig(configPath string) (*Config, error) {
 
 	// if a file is provided, overwrite the default config
 	if configPath != "" {
-		file, err := os.Open(configPath)
+		data, err := os.ReadFile(configPath)
 		if err != nil {
 			return nil, fmt.Errorf("failed to open config file: %w", err)
 		}
-		defer file.Close()
-
-		decoder := json.NewDecoder(file)
-		if err := decoder.Decode(cfg); err != nil {
+		if err := decodeConfig(configPath, data, cfg); err != nil {
 			return nil, fmt.Errorf("failed to decode config file: %w", err)
 		}
+		cfg.File = configPath
 	}
 
 	// override with environment variables
@@ -66,6 +552,30 @@ func LoadConfig(configPath string) (*Config, error) {
 	return cfg, nil
 }
 
+// IsYAML reports whether a config file is YAML, by its extension or, for
+// other extensions, by its content not being a JSON object
+func IsYAML(path string, data []byte) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return true
+	case ".json":
+		return false
+	}
+	return !bytes.HasPrefix(bytes.TrimSpace(data), []byte("{"))
+}
+
+// decodeConfig decodes a JSON or YAML config file over a config, by the
+// names of the settings in the JSON tags, rejecting the unknown settings so
+// a typo doesn't silently leave the default in effect
+func decodeConfig(path string, data []byte, cfg *Config) error {
+	if IsYAML(path, data) {
+		return yaml.UnmarshalStrict(data, cfg)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(cfg)
+}
+
 func overrideFromEnv(cfg *Config) {
 	// QoS priority
 	if val := os.Getenv("NSM_QOS_PRIORITY"); val != "" {
@@ -86,6 +596,12 @@ func overrideFromEnv(cfg *Config) {
 	if val := os.Getenv("NSM_ENABLE_DPDK"); val != "" {
 		cfg.EnableDPDK = strings.ToLower(val) == "true"
 	}
+	if val := os.Getenv("NSM_DPDK_DRIVER"); val != "" {
+		cfg.DPDKDriver = val
+	}
+	if val := os.Getenv("NSM_DPDK_DEVICES"); val != "" {
+		cfg.DPDKDevices = strings.Split(val, ",")
+	}
 
 	// Latency Treshold
 	if val := os.Getenv("NSM_LATENCY_TRESHOLD"); val != "" {
@@ -103,6 +619,43 @@ func overrideFromEnv(cfg *Config) {
 		}
 	}
 
+	// Cloud endpoint
+	if val := os.Getenv("NSM_CLOUD_ENDPOINT"); val != "" {
+		cfg.CloudEndpoint = val
+	}
+
+	// Disconnected edge
+	if val := os.Getenv("NSM_CLOUD_DISCONNECT_AFTER_SEC"); val != "" {
+		var seconds int
+		if _, err := fmt.Sscanf(val, "%d", &seconds); err == nil {
+			cfg.CloudDisconnectAfterSec = seconds
+		}
+	}
+
+	// Telemetry rollup window
+	if val := os.Getenv("NSM_TELEMETRY_ROLLUP_SEC"); val != "" {
+		var rollup int
+		if _, err := fmt.Sscanf(val, "%d", &rollup); err == nil {
+			cfg.TelemetryRollupSec = rollup
+		}
+	}
+
+	// Telemetry top-N connections
+	if val := os.Getenv("NSM_TELEMETRY_TOP_N"); val != "" {
+		var topN int
+		if _, err := fmt.Sscanf(val, "%d", &topN); err == nil {
+			cfg.TelemetryTopN = topN
+		}
+	}
+
+	// Telemetry byte budget
+	if val := os.Getenv("NSM_TELEMETRY_MAX_BYTES"); val != "" {
+		var maxBytes int
+		if _, err := fmt.Sscanf(val, "%d", &maxBytes); err == nil {
+			cfg.TelemetryMaxBytes = maxBytes
+		}
+	}
+
 	// Failover Strategy
 	if val := os.Getenv("NSM_FAILOVER_STRATEGY"); val != "" {
 		cfg.FailoverStrategy = val
@@ -112,8 +665,735 @@ func overrideFromEnv(cfg *Config) {
 	if val := os.Getenv("NSM_KUBECONFIG"); val != "" {
 		cfg.Kubeconfig = val
 	}
+
+	// Enable BFD
+	if val := os.Getenv("NSM_ENABLE_BFD"); val != "" {
+		cfg.EnableBFD = strings.ToLower(val) == "true"
+	}
+
+	// Enable route health injection
+	if val := os.Getenv("NSM_ENABLE_ROUTE_INJECTION"); val != "" {
+		cfg.EnableRouteInjection = strings.ToLower(val) == "true"
+	}
+
+	// BGP next hop
+	if val := os.Getenv("NSM_BGP_NEXT_HOP"); val != "" {
+		cfg.BGPNextHop = val
+	}
+
+	// xDS listen address
+	if val := os.Getenv("NSM_XDS_LISTEN_ADDR"); val != "" {
+		cfg.XDSListenAddr = val
+	}
+
+	// Management API listen address
+	if val, ok := os.LookupEnv("NSM_API_LISTEN_ADDR"); ok {
+		cfg.APIListenAddr = val
+	}
+	if val := os.Getenv("NSM_API_TOKEN"); val != "" {
+		cfg.APIToken = val
+	}
+
+	// Legacy management API
+	if val := os.Getenv("NSM_SERVE_LEGACY_API"); val != "" {
+		cfg.ServeLegacyAPI = strings.ToLower(val) == "true"
+	}
+	if val := os.Getenv("NSM_LEGACY_API_SUNSET"); val != "" {
+		cfg.LegacyAPISunset = val
+	}
+
+	// Enable profiling
+	if val := os.Getenv("NSM_ENABLE_PROFILING"); val != "" {
+		cfg.EnableProfiling = strings.ToLower(val) == "true"
+	}
+
+	// Profiling token
+	if val := os.Getenv("NSM_PROFILING_TOKEN"); val != "" {
+		cfg.ProfilingToken = val
+	}
+
+	// Profile snapshot directory
+	if val, ok := os.LookupEnv("NSM_PROFILE_DIR"); ok {
+		cfg.ProfileDir = val
+	}
+
+	// Profile snapshot interval
+	if val := os.Getenv("NSM_PROFILE_INTERVAL_SEC"); val != "" {
+		var interval int
+		if _, err := fmt.Sscanf(val, "%d", &interval); err == nil {
+			cfg.ProfileIntervalSec = interval
+		}
+	}
+
+	// Enable watchdog
+	if val := os.Getenv("NSM_ENABLE_WATCHDOG"); val != "" {
+		cfg.EnableWatchdog = strings.ToLower(val) == "true"
+	}
+
+	// Watchdog missed intervals
+	if val := os.Getenv("NSM_WATCHDOG_MISSED_INTERVALS"); val != "" {
+		var misses int
+		if _, err := fmt.Sscanf(val, "%d", &misses); err == nil {
+			cfg.WatchdogMissedIntervals = misses
+		}
+	}
+
+	// Watchdog restarts
+	if val := os.Getenv("NSM_WATCHDOG_RESTART"); val != "" {
+		cfg.WatchdogRestart = strings.ToLower(val) == "true"
+	}
+
+	// Fallback datapath
+	if val := os.Getenv("NSM_FALLBACK_DATAPATH"); val != "" {
+		cfg.FallbackDatapath = val
+	}
+
+	// Fallback uplink
+	if val := os.Getenv("NSM_FALLBACK_UPLINK"); val != "" {
+		cfg.FallbackUplink = val
+	}
+
+	// Enable crypto offload
+	if val := os.Getenv("NSM_ENABLE_CRYPTO_OFFLOAD"); val != "" {
+		cfg.EnableCryptoOffload = strings.ToLower(val) == "true"
+	}
+
+	// Rekey interval
+	if val := os.Getenv("NSM_REKEY_INTERVAL_SEC"); val != "" {
+		var interval int
+		if _, err := fmt.Sscanf(val, "%d", &interval); err == nil {
+			cfg.RekeyIntervalSec = interval
+		}
+	}
+
+	// Rekey gap
+	if val := os.Getenv("NSM_REKEY_GAP_SEC"); val != "" {
+		var gap int
+		if _, err := fmt.Sscanf(val, "%d", &gap); err == nil {
+			cfg.RekeyGapSec = gap
+		}
+	}
+
+	// Legacy key Secret
+	if val := os.Getenv("NSM_LEGACY_KEY_SECRET"); val != "" {
+		cfg.LegacyKeySecret = val
+	}
+
+	// Bootstrap file
+	if val := os.Getenv("NSM_BOOTSTRAP_FILE"); val != "" {
+		cfg.BootstrapFile = val
+	}
+
+	// Bootstrap prune
+	if val := os.Getenv("NSM_BOOTSTRAP_PRUNE"); val != "" {
+		cfg.BootstrapPrune = strings.ToLower(val) == "true"
+	}
+
+	// Metrics streaming API listen address, set to empty to disable
+	if val, ok := os.LookupEnv("NSM_METRICS_STREAM_LISTEN_ADDR"); ok {
+		cfg.MetricsStreamListenAddr = val
+	}
+
+	// Enable pressure throttling
+	if val := os.Getenv("NSM_ENABLE_PRESSURE_THROTTLING"); val != "" {
+		cfg.EnablePressureThrottling = strings.ToLower(val) == "true"
+	}
+
+	// Pressure stall threshold
+	if val := os.Getenv("NSM_PRESSURE_STALL_THRESHOLD_PERCENT"); val != "" {
+		var threshold int
+		if _, err := fmt.Sscanf(val, "%d", &threshold); err == nil {
+			cfg.PressureStallThresholdPercent = threshold
+		}
+	}
+
+	// Pressure minimum available memory
+	if val := os.Getenv("NSM_PRESSURE_MIN_MEM_AVAILABLE_PERCENT"); val != "" {
+		var available int
+		if _, err := fmt.Sscanf(val, "%d", &available); err == nil {
+			cfg.PressureMinMemAvailablePercent = available
+		}
+	}
+
+	// Pressure critical priority
+	if val := os.Getenv("NSM_PRESSURE_CRITICAL_PRIORITY"); val != "" {
+		var priority int
+		if _, err := fmt.Sscanf(val, "%d", &priority); err == nil {
+			cfg.PressureCriticalPriority = priority
+		}
+	}
+
+	// Enable thermal monitor
+	if val := os.Getenv("NSM_ENABLE_THERMAL_MONITOR"); val != "" {
+		cfg.EnableThermalMonitor = strings.ToLower(val) == "true"
+	}
+
+	// Thermal margin
+	if val := os.Getenv("NSM_THERMAL_MARGIN_CELSIUS"); val != "" {
+		var margin int
+		if _, err := fmt.Sscanf(val, "%d", &margin); err == nil {
+			cfg.ThermalMarginCelsius = margin
+		}
+	}
+
+	// Thermal shed priority
+	if val := os.Getenv("NSM_THERMAL_SHED_PRIORITY"); val != "" {
+		var priority int
+		if _, err := fmt.Sscanf(val, "%d", &priority); err == nil {
+			cfg.ThermalShedPriority = priority
+		}
+	}
+
+	// Thermal polling reduction
+	if val := os.Getenv("NSM_THERMAL_REDUCE_POLLING"); val != "" {
+		cfg.ThermalReducePolling = strings.ToLower(val) == "true"
+	}
+
+	// Idle mode
+	if val := os.Getenv("NSM_IDLE_AFTER_SEC"); val != "" {
+		var idle int
+		if _, err := fmt.Sscanf(val, "%d", &idle); err == nil {
+			cfg.IdleAfterSec = idle
+		}
+	}
+
+	// Resource budgets
+	if val := os.Getenv("NSM_ENABLE_RESOURCE_BUDGETS"); val != "" {
+		cfg.EnableResourceBudgets = strings.ToLower(val) == "true"
+	}
+
+	// Probing CPU budget
+	if val := os.Getenv("NSM_PROBE_CPU_BUDGET_PERCENT"); val != "" {
+		var percent int
+		if _, err := fmt.Sscanf(val, "%d", &percent); err == nil {
+			cfg.ProbeCPUBudgetPercent = percent
+		}
+	}
+
+	// Telemetry CPU budget
+	if val := os.Getenv("NSM_TELEMETRY_CPU_BUDGET_PERCENT"); val != "" {
+		var percent int
+		if _, err := fmt.Sscanf(val, "%d", &percent); err == nil {
+			cfg.TelemetryCPUBudgetPercent = percent
+		}
+	}
+
+	// Telemetry memory budget
+	if val := os.Getenv("NSM_TELEMETRY_MEMORY_BUDGET_MB"); val != "" {
+		var mb int
+		if _, err := fmt.Sscanf(val, "%d", &mb); err == nil {
+			cfg.TelemetryMemoryBudgetMB = mb
+		}
+	}
+
+	// Metrics stream CPU budget
+	if val := os.Getenv("NSM_METRICS_STREAM_CPU_BUDGET_PERCENT"); val != "" {
+		var percent int
+		if _, err := fmt.Sscanf(val, "%d", &percent); err == nil {
+			cfg.MetricsStreamCPUBudgetPercent = percent
+		}
+	}
+
+	// Device registry sync
+	if val := os.Getenv("NSM_REGISTRY_SOURCE"); val != "" {
+		cfg.RegistrySource = val
+	}
+	if val := os.Getenv("NSM_REGISTRY_URL"); val != "" {
+		cfg.RegistryURL = val
+	}
+	if val := os.Getenv("NSM_REGISTRY_TOKEN"); val != "" {
+		cfg.RegistryToken = val
+	}
+	if val := os.Getenv("NSM_REGISTRY_FILTER"); val != "" {
+		cfg.RegistryFilter = val
+	}
+	if val := os.Getenv("NSM_REGISTRY_NAMESPACE"); val != "" {
+		cfg.RegistryNamespace = val
+	}
+	if val := os.Getenv("NSM_REGISTRY_SERVICE_TYPE"); val != "" {
+		cfg.RegistryServiceType = val
+	}
+	if val := os.Getenv("NSM_REGISTRY_SYNC_INTERVAL_SEC"); val != "" {
+		var interval int
+		if _, err := fmt.Sscanf(val, "%d", &interval); err == nil {
+			cfg.RegistrySyncIntervalSec = interval
+		}
+	}
+
+	// Endpoint reachability scoring
+	if val := os.Getenv("NSM_ENABLE_REACHABILITY_SCORING"); val != "" {
+		cfg.EnableReachabilityScoring = strings.ToLower(val) == "true"
+	}
+	if val, ok := os.LookupEnv("NSM_REACHABILITY_STATE_FILE"); ok {
+		cfg.ReachabilityStateFile = val
+	}
+
+	// Connection flap damping
+	if val := os.Getenv("NSM_ENABLE_FLAP_DAMPING"); val != "" {
+		cfg.EnableFlapDamping = strings.ToLower(val) == "true"
+	}
+	if val := os.Getenv("NSM_FLAP_SUPPRESS_THRESHOLD"); val != "" {
+		var threshold int
+		if _, err := fmt.Sscanf(val, "%d", &threshold); err == nil {
+			cfg.FlapSuppressThreshold = threshold
+		}
+	}
+	if val := os.Getenv("NSM_FLAP_REUSE_THRESHOLD"); val != "" {
+		var threshold int
+		if _, err := fmt.Sscanf(val, "%d", &threshold); err == nil {
+			cfg.FlapReuseThreshold = threshold
+		}
+	}
+	if val := os.Getenv("NSM_FLAP_HALF_LIFE_SEC"); val != "" {
+		var halfLife int
+		if _, err := fmt.Sscanf(val, "%d", &halfLife); err == nil {
+			cfg.FlapHalfLifeSec = halfLife
+		}
+	}
+	if val := os.Getenv("NSM_FLAP_MAX_SUPPRESS_SEC"); val != "" {
+		var maxSuppress int
+		if _, err := fmt.Sscanf(val, "%d", &maxSuppress); err == nil {
+			cfg.FlapMaxSuppressSec = maxSuppress
+		}
+	}
+
+	// Sharding across replicas
+	if val := os.Getenv("NSM_ENABLE_SHARDING"); val != "" {
+		cfg.EnableSharding = strings.ToLower(val) == "true"
+	}
+	if val := os.Getenv("NSM_SHARD_NAMESPACE"); val != "" {
+		cfg.ShardNamespace = val
+	}
+	if val := os.Getenv("NSM_SHARD_LEASE_DURATION_SEC"); val != "" {
+		var duration int
+		if _, err := fmt.Sscanf(val, "%d", &duration); err == nil {
+			cfg.ShardLeaseDurationSec = duration
+		}
+	}
+
+	// Agent leases
+	if val := os.Getenv("NSM_ENABLE_AGENT_LEASES"); val != "" {
+		cfg.EnableAgentLeases = strings.ToLower(val) == "true"
+	}
+	if val := os.Getenv("NSM_AGENT_LEASE_NAMESPACE"); val != "" {
+		cfg.AgentLeaseNamespace = val
+	}
+	if val := os.Getenv("NSM_AGENT_LEASE_DURATION_SEC"); val != "" {
+		var duration int
+		if _, err := fmt.Sscanf(val, "%d", &duration); err == nil {
+			cfg.AgentLeaseDurationSec = duration
+		}
+	}
+
+	// Stale connection collection
+	if val := os.Getenv("NSM_ENABLE_CONNECTION_GC"); val != "" {
+		cfg.EnableConnectionGC = strings.ToLower(val) == "true"
+	}
+	if val := os.Getenv("NSM_CONNECTION_GC_GRACE_SEC"); val != "" {
+		var grace int
+		if _, err := fmt.Sscanf(val, "%d", &grace); err == nil {
+			cfg.ConnectionGCGraceSec = grace
+		}
+	}
+	if val := os.Getenv("NSM_CONNECTION_GC_DRY_RUN"); val != "" {
+		cfg.ConnectionGCDryRun = strings.ToLower(val) == "true"
+	}
+
+	// Gateway API routes
+	if val := os.Getenv("NSM_ENABLE_GATEWAY_API"); val != "" {
+		cfg.EnableGatewayAPI = strings.ToLower(val) == "true"
+	}
+
+	// EndpointSlice publication
+	if val := os.Getenv("NSM_ENABLE_ENDPOINT_SLICES"); val != "" {
+		cfg.EnableEndpointSlices = strings.ToLower(val) == "true"
+	}
+
+	// Connection-scoped DNS
+	if val := os.Getenv("NSM_ENABLE_CONNECTION_DNS"); val != "" {
+		cfg.EnableConnectionDNS = strings.ToLower(val) == "true"
+	}
+	if val, ok := os.LookupEnv("NSM_CLUSTER_DNS"); ok {
+		cfg.ClusterDNS = val
+	}
+	if val := os.Getenv("NSM_CLUSTER_DOMAIN"); val != "" {
+		cfg.ClusterDomain = val
+	}
+
+	// VF storm control
+	if val := os.Getenv("NSM_SRIOV_BROADCAST_PPS"); val != "" {
+		var pps int
+		if _, err := fmt.Sscanf(val, "%d", &pps); err == nil {
+			cfg.SRIOVBroadcastPPS = pps
+		}
+	}
+	if val := os.Getenv("NSM_SRIOV_MULTICAST_PPS"); val != "" {
+		var pps int
+		if _, err := fmt.Sscanf(val, "%d", &pps); err == nil {
+			cfg.SRIOVMulticastPPS = pps
+		}
+	}
+
+	// MAC learning table monitor
+	if val := os.Getenv("NSM_ENABLE_FDB_MONITOR"); val != "" {
+		cfg.EnableFDBMonitor = strings.ToLower(val) == "true"
+	}
+	if val := os.Getenv("NSM_FDB_FLAP_THRESHOLD"); val != "" {
+		var threshold int
+		if _, err := fmt.Sscanf(val, "%d", &threshold); err == nil {
+			cfg.FDBFlapThreshold = threshold
+		}
+	}
+
+	// Connection probing
+	if val := os.Getenv("NSM_PROBE_INTERVAL_SEC"); val != "" {
+		var interval int
+		if _, err := fmt.Sscanf(val, "%d", &interval); err == nil {
+			cfg.ProbeIntervalSec = interval
+		}
+	}
+	if val := os.Getenv("NSM_PROBE_MIN_INTERVAL_SEC"); val != "" {
+		var interval int
+		if _, err := fmt.Sscanf(val, "%d", &interval); err == nil {
+			cfg.ProbeMinIntervalSec = interval
+		}
+	}
+	if val := os.Getenv("NSM_PROBE_BUDGET_PER_SEC"); val != "" {
+		var budget int
+		if _, err := fmt.Sscanf(val, "%d", &budget); err == nil {
+			cfg.ProbeBudgetPerSec = budget
+		}
+	}
+
+	// Failover drills
+	if val := os.Getenv("NSM_ENABLE_FAILOVER_DRILLS"); val != "" {
+		cfg.EnableFailoverDrills = strings.ToLower(val) == "true"
+	}
+	if val := os.Getenv("NSM_FAILOVER_DRILL_MAX_SEC"); val != "" {
+		var seconds int
+		if _, err := fmt.Sscanf(val, "%d", &seconds); err == nil {
+			cfg.FailoverDrillMaxSec = seconds
+		}
+	}
+
+	// VF leases
+	if val := os.Getenv("NSM_VF_LEASE_MAX_TTL_SEC"); val != "" {
+		var seconds int
+		if _, err := fmt.Sscanf(val, "%d", &seconds); err == nil {
+			cfg.VFLeaseMaxTTLSec = seconds
+		}
+	}
+
+	// Usage reports
+	if val := os.Getenv("NSM_ENABLE_USAGE_REPORTS"); val != "" {
+		cfg.EnableUsageReports = strings.ToLower(val) == "true"
+	}
+	if val := os.Getenv("NSM_USAGE_REPORT_SEC"); val != "" {
+		var seconds int
+		if _, err := fmt.Sscanf(val, "%d", &seconds); err == nil {
+			cfg.UsageReportSec = seconds
+		}
+	}
+	if val := os.Getenv("NSM_PUSH_USAGE_REPORTS"); val != "" {
+		cfg.PushUsageReports = strings.ToLower(val) == "true"
+	}
+
+	// Disruption coordination
+	if val := os.Getenv("NSM_DISRUPTION_POLICY"); val != "" {
+		cfg.DisruptionPolicy = val
+	}
+	if val := os.Getenv("NSM_DISRUPTION_TIMEOUT_SEC"); val != "" {
+		var seconds int
+		if _, err := fmt.Sscanf(val, "%d", &seconds); err == nil {
+			cfg.DisruptionTimeoutSec = seconds
+		}
+	}
+
+	// CNI plugin socket
+	if val, ok := os.LookupEnv("NSM_CNI_SOCKET"); ok {
+		cfg.CNISocket = val
+	}
+
+	// VF reparenting
+	if val := os.Getenv("NSM_ENABLE_VF_REPARENTING"); val != "" {
+		cfg.EnableVFReparenting = strings.ToLower(val) == "true"
+	}
+
+	// VF allocation checkpoint
+	if val, ok := os.LookupEnv("NSM_SRIOV_STATE_FILE"); ok {
+		cfg.SRIOVStateFile = val
+	}
+
+	// Firmware updates
+	if val := os.Getenv("NSM_ENABLE_FIRMWARE_UPDATES"); val != "" {
+		cfg.EnableFirmwareUpdates = strings.ToLower(val) == "true"
+	}
+	if val, ok := os.LookupEnv("NSM_FIRMWARE_UPDATE_COMMAND"); ok {
+		cfg.FirmwareUpdateCommand = val
+	}
+	if val := os.Getenv("NSM_FIRMWARE_UPDATE_TIMEOUT_SEC"); val != "" {
+		var seconds int
+		if _, err := fmt.Sscanf(val, "%d", &seconds); err == nil {
+			cfg.FirmwareUpdateTimeoutSec = seconds
+		}
+	}
+
+	// Devlink
+	if val := os.Getenv("NSM_ENABLE_DEVLINK"); val != "" {
+		cfg.EnableDevlink = strings.ToLower(val) == "true"
+	}
+	if val := os.Getenv("NSM_DEVLINK_POLL_INTERVAL_SEC"); val != "" {
+		var seconds int
+		if _, err := fmt.Sscanf(val, "%d", &seconds); err == nil {
+			cfg.DevlinkPollIntervalSec = seconds
+		}
+	}
+
+	// PCI hotplug
+	if val := os.Getenv("NSM_ENABLE_HOTPLUG"); val != "" {
+		cfg.EnableHotplug = strings.ToLower(val) == "true"
+	}
+	if val := os.Getenv("NSM_HOTPLUG_CONSISTENCY_CHECK_SEC"); val != "" {
+		var seconds int
+		if _, err := fmt.Sscanf(val, "%d", &seconds); err == nil {
+			cfg.HotplugConsistencyCheckSec = seconds
+		}
+	}
+
+	// Path failover
+	if val := os.Getenv("NSM_ENABLE_FAILOVER"); val != "" {
+		cfg.EnableFailover = strings.ToLower(val) == "true"
+	}
+
+	// VF inventory consistency checks
+	if val := os.Getenv("NSM_INVENTORY_CHECK_INTERVAL_SEC"); val != "" {
+		var seconds int
+		if _, err := fmt.Sscanf(val, "%d", &seconds); err == nil {
+			cfg.InventoryCheckIntervalSec = seconds
+		}
+	}
+	if val := os.Getenv("NSM_ENABLE_INVENTORY_REPAIR"); val != "" {
+		cfg.EnableInventoryRepair = strings.ToLower(val) == "true"
+	}
+
+	// Traffic tests
+	if val := os.Getenv("NSM_ENABLE_TRAFFIC_TESTS"); val != "" {
+		cfg.EnableTrafficTests = strings.ToLower(val) == "true"
+	}
+	if val := os.Getenv("NSM_TRAFFIC_TEST_MAX_SEC"); val != "" {
+		var seconds int
+		if _, err := fmt.Sscanf(val, "%d", &seconds); err == nil {
+			cfg.TrafficTestMaxSec = seconds
+		}
+	}
+	if val := os.Getenv("NSM_TRAFFIC_TEST_MAX_RATE_MBPS"); val != "" {
+		var rate int
+		if _, err := fmt.Sscanf(val, "%d", &rate); err == nil {
+			cfg.TrafficTestMaxRateMbps = rate
+		}
+	}
+	if val := os.Getenv("NSM_TRAFFIC_REFLECTOR_LISTEN_ADDR"); val != "" {
+		cfg.TrafficReflectorListenAddr = val
+	}
+
+	// QoS enforcement
+	if val := os.Getenv("NSM_ENABLE_QOS"); val != "" {
+		cfg.EnableQoS = strings.ToLower(val) == "true"
+	}
+	if val := os.Getenv("NSM_QOS_INTERFACES"); val != "" {
+		cfg.QoSInterfaces = strings.Split(val, ",")
+	}
+	if val := os.Getenv("NSM_QOS_LINK_RATE_MBPS"); val != "" {
+		var rate int
+		if _, err := fmt.Sscanf(val, "%d", &rate); err == nil {
+			cfg.QoSLinkRateMbps = rate
+		}
+	}
+	if val := os.Getenv("NSM_QOS_INTERVAL_SEC"); val != "" {
+		var seconds int
+		if _, err := fmt.Sscanf(val, "%d", &seconds); err == nil {
+			cfg.QoSIntervalSec = seconds
+		}
+	}
+
+	// Latency hints
+	if val := os.Getenv("NSM_ENABLE_LATENCY_HINTS"); val != "" {
+		cfg.EnableLatencyHints = strings.ToLower(val) == "true"
+	}
+	if val := os.Getenv("NSM_LATENCY_HINT_INTERVAL_SEC"); val != "" {
+		var seconds int
+		if _, err := fmt.Sscanf(val, "%d", &seconds); err == nil {
+			cfg.LatencyHintIntervalSec = seconds
+		}
+	}
+	if val := os.Getenv("NSM_LATENCY_HINT_NODE_NAME"); val != "" {
+		cfg.LatencyHintNodeName = val
+	}
+
+	// Prometheus metrics listen address, set to empty to disable
+	if val, ok := os.LookupEnv("NSM_METRICS_LISTEN_ADDR"); ok {
+		cfg.MetricsListenAddr = val
+	}
+
+	// Admission webhook
+	if val := os.Getenv("NSM_ENABLE_ADMISSION_WEBHOOK"); val != "" {
+		cfg.EnableAdmissionWebhook = strings.ToLower(val) == "true"
+	}
+	if val := os.Getenv("NSM_ADMISSION_WEBHOOK_PORT"); val != "" {
+		var port int
+		if _, err := fmt.Sscanf(val, "%d", &port); err == nil {
+			cfg.AdmissionWebhookPort = port
+		}
+	}
+	if val := os.Getenv("NSM_ADMISSION_WEBHOOK_CERT_DIR"); val != "" {
+		cfg.AdmissionWebhookCertDir = val
+	}
+
+	// Security posture policy
+	if val := os.Getenv("NSM_POSTURE_REQUIRE_ENCRYPTION"); val != "" {
+		cfg.PostureRequireEncryption = strings.ToLower(val) == "true"
+	}
+	if val := os.Getenv("NSM_POSTURE_MAX_KEY_AGE_SEC"); val != "" {
+		var seconds int
+		if _, err := fmt.Sscanf(val, "%d", &seconds); err == nil {
+			cfg.PostureMaxKeyAgeSec = seconds
+		}
+	}
+
+	// Traffic anomaly detection
+	if val := os.Getenv("NSM_ENABLE_ANOMALY_DETECTION"); val != "" {
+		cfg.EnableAnomalyDetection = strings.ToLower(val) == "true"
+	}
+	if val := os.Getenv("NSM_ANOMALY_INTERVAL_SEC"); val != "" {
+		var seconds int
+		if _, err := fmt.Sscanf(val, "%d", &seconds); err == nil {
+			cfg.AnomalyIntervalSec = seconds
+		}
+	}
+	if val := os.Getenv("NSM_ANOMALY_SPIKE_FACTOR"); val != "" {
+		var factor int
+		if _, err := fmt.Sscanf(val, "%d", &factor); err == nil {
+			cfg.AnomalySpikeFactor = factor
+		}
+	}
+	if val := os.Getenv("NSM_ANOMALY_WEBHOOK_URL"); val != "" {
+		cfg.AnomalyWebhookURL = val
+	}
+
+	// NetworkEndpoint registry
+	if val := os.Getenv("NSM_ENABLE_ENDPOINT_REGISTRY"); val != "" {
+		cfg.EnableEndpointRegistry = strings.ToLower(val) == "true"
+	}
+	if val := os.Getenv("NSM_ENDPOINT_REGISTRY_INTERVAL_SEC"); val != "" {
+		var seconds int
+		if _, err := fmt.Sscanf(val, "%d", &seconds); err == nil {
+			cfg.EndpointRegistryIntervalSec = seconds
+		}
+	}
+
+	// VF gRPC socket
+	if val, ok := os.LookupEnv("NSM_VF_SOCKET"); ok {
+		cfg.VFSocket = val
+	}
+
+	// SR-IOV network operator coexistence
+	if val := os.Getenv("NSM_ENABLE_SRIOV_OPERATOR_COEXISTENCE"); val != "" {
+		cfg.EnableSRIOVOperatorCoexistence = strings.ToLower(val) == "true"
+	}
+	if val := os.Getenv("NSM_SRIOV_OPERATOR_NODE_NAME"); val != "" {
+		cfg.SRIOVOperatorNodeName = val
+	}
+
+	// DPDK hugepage condition
+	if val := os.Getenv("NSM_DPDK_NODE_NAME"); val != "" {
+		cfg.DPDKNodeName = val
+	}
+
+	// KubeVirt VMIs
+	if val := os.Getenv("NSM_ENABLE_KUBEVIRT"); val != "" {
+		cfg.EnableKubeVirt = strings.ToLower(val) == "true"
+	}
+	if val := os.Getenv("NSM_KUBEVIRT_NODE_NAME"); val != "" {
+		cfg.KubeVirtNodeName = val
+	}
+
+	// Leader election
+	if val := os.Getenv("NSM_ENABLE_LEADER_ELECTION"); val != "" {
+		cfg.EnableLeaderElection = strings.ToLower(val) == "true"
+	}
+	if val := os.Getenv("NSM_LEADER_ELECTION_NAMESPACE"); val != "" {
+		cfg.LeaderElectionNamespace = val
+	}
+	if val := os.Getenv("NSM_LEADER_ELECTION_LEASE_DURATION_SEC"); val != "" {
+		var duration int
+		if _, err := fmt.Sscanf(val, "%d", &duration); err == nil {
+			cfg.LeaderElectionLeaseDurationSec = duration
+		}
+	}
+
+	// vhost-user ports
+	if val := os.Getenv("NSM_VHOST_USER_DATAPLANE"); val != "" {
+		cfg.VhostUserDataplane = strings.ToLower(val)
+	}
+	if val := os.Getenv("NSM_VHOST_USER_SOCKET_DIR"); val != "" {
+		cfg.VhostUserSocketDir = val
+	}
+	if val := os.Getenv("NSM_VHOST_USER_BRIDGE"); val != "" {
+		cfg.VhostUserBridge = val
+	}
+
+	// Health probes listen address, set to empty to disable
+	if val, ok := os.LookupEnv("NSM_HEALTH_LISTEN_ADDR"); ok {
+		cfg.HealthListenAddr = val
+	}
+
+	// Dataplane
+	if val := os.Getenv("NSM_DATAPLANE"); val != "" {
+		cfg.Dataplane = strings.ToLower(val)
+	}
+	if val := os.Getenv("NSM_VPP_UPLINK"); val != "" {
+		cfg.VPPUplink = val
+	}
+
+	// Uplink costs
+	if val := os.Getenv("NSM_UPLINK_COSTS"); val != "" {
+		if costs, err := parseUplinkCosts(val); err == nil {
+			cfg.UplinkCosts = costs
+		}
+	}
+	if val := os.Getenv("NSM_UPLINK_COST_STATE_FILE"); val != "" {
+		cfg.UplinkCostStateFile = val
+	}
+}
+
+// parseUplinkCosts parses uplink costs in device=costPerGB[:monthlyBudget]
+// form, separated by commas (e.g., "wwan0=0.5:100,eth0=0")
+func parseUplinkCosts(val string) ([]UplinkCost, error) {
+	var costs []UplinkCost
+	for _, entry := range strings.Split(val, ",") {
+		device, price, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid uplink cost %q, must be device=costPerGB[:monthlyBudget]", entry)
+		}
+		perGB, budget, hasBudget := strings.Cut(price, ":")
+		cost := UplinkCost{Device: device}
+		var err error
+		if cost.CostPerGB, err = strconv.ParseFloat(perGB, 64); err != nil {
+			return nil, fmt.Errorf("invalid cost per GB of uplink %s: %w", device, err)
+		}
+		if hasBudget {
+			if cost.MonthlyBudget, err = strconv.ParseFloat(budget, 64); err != nil {
+				return nil, fmt.Errorf("invalid monthly budget of uplink %s: %w", device, err)
+			}
+		}
+		costs = append(costs, cost)
+	}
+	return costs, nil
 }
 
+// pciAddress matches a PCI address in domain:bus:device.function form
+var pciAddress = regexp.MustCompile(`^[0-9a-f]{4}:[0-9a-f]{2}:[0-9a-f]{2}\.[0-7]$`)
+
 func validateConfig(cfg *Config) error {
 	// Validate QoS
 	validQoS := map[string]bool{"high": true, "medium": true, "low": true}
@@ -126,6 +1406,18 @@ func validateConfig(cfg *Config) error {
 		return fmt.Errorf("edge node ID cannot be empty")
 	}
 
+	// Validate DPDK
+	if cfg.EnableDPDK {
+		if cfg.DPDKDriver != "vfio-pci" && cfg.DPDKDriver != "igb_uio" {
+			return fmt.Errorf("invalid DPDK driver: %s, must be one of: vfio-pci, igb_uio", cfg.DPDKDriver)
+		}
+		for _, addr := range cfg.DPDKDevices {
+			if !pciAddress.MatchString(addr) {
+				return fmt.Errorf("invalid DPDK device: %s, must be a PCI address (e.g., 0000:3b:00.0)", addr)
+			}
+		}
+	}
+
 	// Validate Latency Treshold
 	if cfg.LatencyTreshold <= 0 {
 		return fmt.Errorf("latency treshold must be greater than 0")
@@ -136,12 +1428,427 @@ func validateConfig(cfg *Config) error {
 		return fmt.Errorf("cloud heartbeat interval must be greater than 0")
 	}
 
+	// Validate telemetry downsampling
+	if cfg.CloudEndpoint != "" {
+		if cfg.TelemetryRollupSec <= 0 {
+			return fmt.Errorf("telemetry rollup must be greater than 0")
+		}
+		if cfg.TelemetryTopN < 0 || cfg.TelemetryMaxBytes < 0 {
+			return fmt.Errorf("telemetry top-N and max bytes must not be negative")
+		}
+		// a single lost heartbeat must not cut the node off
+		if cfg.CloudDisconnectAfterSec < cfg.CloudHeartbeatSec {
+			return fmt.Errorf("cloud disconnect period must be at least the heartbeat interval")
+		}
+	}
+
 	// Validate failover strategy
 	validFailover := map[string]bool{"fast": true, "balanced": true, "reliable": true}
 	if !validFailover[strings.ToLower(cfg.FailoverStrategy)] {
 		return fmt.Errorf("invalid failover strategy: %s, must be one of: fast, balanced, reliable", cfg.FailoverStrategy)
 	}
 
+	// Validate BGP next hop
+	if cfg.BGPNextHop != "" && net.ParseIP(cfg.BGPNextHop) == nil {
+		return fmt.Errorf("invalid BGP next hop: %s, must be an IP address", cfg.BGPNextHop)
+	}
+
+	// Validate legacy API sunset
+	if cfg.LegacyAPISunset != "" {
+		if _, err := time.Parse(time.DateOnly, cfg.LegacyAPISunset); err != nil {
+			return fmt.Errorf("invalid legacy API sunset: %s, must be a date (YYYY-MM-DD)", cfg.LegacyAPISunset)
+		}
+	}
+
+	// Validate profiling, the endpoints expose memory contents so they
+	// are never served without a token
+	if cfg.EnableProfiling {
+		if cfg.APIListenAddr != "" && cfg.ProfilingToken == "" {
+			return fmt.Errorf("profiling token must be set when profiling is enabled")
+		}
+		if cfg.ProfileDir != "" && (cfg.ProfileIntervalSec <= 0 || cfg.ProfileMaxSnapshots <= 0) {
+			return fmt.Errorf("profile interval and max snapshots must be greater than 0")
+		}
+	}
+
+	// Validate fallback datapath
+	validFallback := map[string]bool{"macvlan": true, "ipvlan": true, "none": true}
+	if !validFallback[cfg.FallbackDatapath] {
+		return fmt.Errorf("invalid fallback datapath: %s, must be one of: macvlan, ipvlan, none", cfg.FallbackDatapath)
+	}
+
+	// Validate key rotation
+	if cfg.RekeyIntervalSec < 0 {
+		return fmt.Errorf("rekey interval must not be negative")
+	}
+	if cfg.RekeyIntervalSec > 0 && cfg.RekeyGapSec <= 0 {
+		return fmt.Errorf("rekey gap must be greater than 0")
+	}
+
+	// Validate legacy key Secret
+	if cfg.LegacyKeySecret != "" {
+		namespace, name, ok := strings.Cut(cfg.LegacyKeySecret, "/")
+		if !ok || namespace == "" || name == "" {
+			return fmt.Errorf("invalid legacy key secret: %s, must be namespace/name", cfg.LegacyKeySecret)
+		}
+	}
+
+	// Validate pressure throttling
+	if cfg.EnablePressureThrottling {
+		if cfg.PressureStallThresholdPercent <= 0 || cfg.PressureStallThresholdPercent > 100 {
+			return fmt.Errorf("pressure stall threshold must be between 1 and 100 percent")
+		}
+		if cfg.PressureMinMemAvailablePercent < 0 || cfg.PressureMinMemAvailablePercent >= 100 {
+			return fmt.Errorf("pressure minimum available memory must be between 0 and 99 percent")
+		}
+	}
+
+	// Validate thermal monitor
+	if cfg.EnableThermalMonitor {
+		if cfg.ThermalMarginCelsius <= 0 {
+			return fmt.Errorf("thermal margin must be greater than 0")
+		}
+		if cfg.ThermalShedPriority < 0 {
+			return fmt.Errorf("thermal shed priority must not be negative")
+		}
+	}
+
+	// Validate idle mode
+	if cfg.IdleAfterSec < 0 {
+		return fmt.Errorf("idle period must not be negative")
+	}
+
+	// Validate resource budgets
+	if cfg.EnableResourceBudgets {
+		for _, percent := range []int{cfg.ProbeCPUBudgetPercent, cfg.TelemetryCPUBudgetPercent, cfg.MetricsStreamCPUBudgetPercent} {
+			if percent < 0 || percent > 100 {
+				return fmt.Errorf("CPU budgets must be between 0 and 100 percent")
+			}
+		}
+		if cfg.TelemetryMemoryBudgetMB < 0 {
+			return fmt.Errorf("telemetry memory budget must not be negative")
+		}
+	}
+
+	// Validate device registry sync
+	if cfg.RegistrySource != "" {
+		validSource := map[string]bool{"csv": true, "netbox": true}
+		if !validSource[cfg.RegistrySource] {
+			return fmt.Errorf("invalid registry source: %s, must be one of: csv, netbox", cfg.RegistrySource)
+		}
+		if cfg.RegistryURL == "" {
+			return fmt.Errorf("registry URL must be set when a registry source is configured")
+		}
+		if cfg.RegistryNamespace == "" {
+			return fmt.Errorf("registry namespace must be set when a registry source is configured")
+		}
+		if cfg.RegistrySyncIntervalSec <= 0 {
+			return fmt.Errorf("registry sync interval must be greater than 0")
+		}
+		if _, err := url.ParseQuery(cfg.RegistryFilter); err != nil {
+			return fmt.Errorf("invalid registry filter: %w", err)
+		}
+	}
+
+	// Validate flap damping
+	if cfg.EnableFlapDamping {
+		if cfg.FlapReuseThreshold <= 0 {
+			return fmt.Errorf("flap reuse threshold must be greater than 0")
+		}
+		if cfg.FlapSuppressThreshold <= cfg.FlapReuseThreshold {
+			return fmt.Errorf("flap suppress threshold must be greater than the reuse threshold")
+		}
+		if cfg.FlapHalfLifeSec <= 0 || cfg.FlapMaxSuppressSec <= 0 {
+			return fmt.Errorf("flap half-life and maximum suppression must be greater than 0")
+		}
+	}
+
+	// Validate sharding
+	if cfg.EnableSharding {
+		if cfg.ShardNamespace == "" {
+			return fmt.Errorf("shard namespace is required when sharding is enabled")
+		}
+		// renewals every third of the lease duration need whole seconds
+		if cfg.ShardLeaseDurationSec < 3 {
+			return fmt.Errorf("shard lease duration must be at least 3 seconds")
+		}
+	}
+
+	// Validate agent leases
+	if cfg.EnableAgentLeases {
+		if cfg.AgentLeaseNamespace == "" {
+			return fmt.Errorf("agent lease namespace is required when agent leases are enabled")
+		}
+		// renewals every third of the lease duration need whole seconds
+		if cfg.AgentLeaseDurationSec < 3 {
+			return fmt.Errorf("agent lease duration must be at least 3 seconds")
+		}
+	}
+
+	// Validate stale connection collection
+	if cfg.EnableConnectionGC && cfg.ConnectionGCGraceSec < 60 {
+		return fmt.Errorf("connection GC grace period must be at least 60 seconds")
+	}
+
+	// Validate connection-scoped DNS
+	if cfg.ClusterDNS != "" && net.ParseIP(cfg.ClusterDNS) == nil {
+		return fmt.Errorf("invalid cluster DNS: %s, must be an IP address", cfg.ClusterDNS)
+	}
+	if cfg.EnableConnectionDNS && cfg.ClusterDomain == "" {
+		return fmt.Errorf("cluster domain is required when connection DNS is enabled")
+	}
+
+	// Validate VF storm control
+	if cfg.SRIOVBroadcastPPS < 0 || cfg.SRIOVMulticastPPS < 0 {
+		return fmt.Errorf("VF broadcast and multicast limits must not be negative")
+	}
+
+	// Validate MAC learning table monitor
+	if cfg.EnableFDBMonitor && cfg.FDBFlapThreshold < 2 {
+		return fmt.Errorf("FDB flap threshold must be at least 2 moves")
+	}
+
+	// Validate connection probing
+	if cfg.ProbeIntervalSec < 0 {
+		return fmt.Errorf("probe interval must not be negative")
+	}
+	if cfg.ProbeIntervalSec > 0 {
+		if cfg.ProbeMinIntervalSec <= 0 || cfg.ProbeMinIntervalSec > cfg.ProbeIntervalSec {
+			return fmt.Errorf("minimum probe interval must be between 1 and %d seconds", cfg.ProbeIntervalSec)
+		}
+		if cfg.ProbeBudgetPerSec <= 0 {
+			return fmt.Errorf("probe budget must be greater than 0")
+		}
+	}
+
+	// Validate failover drills
+	if cfg.EnableFailoverDrills && (cfg.FailoverDrillMaxSec <= 0 || cfg.FailoverDrillMaxSec > 600) {
+		return fmt.Errorf("failover drill duration must be between 1 and 600 seconds")
+	}
+
+	// Validate VF leases
+	if cfg.VFLeaseMaxTTLSec <= 0 {
+		return fmt.Errorf("VF lease max TTL must be greater than 0")
+	}
+
+	// Validate usage reports, sampled every minute
+	if cfg.EnableUsageReports && cfg.UsageReportSec < 60 {
+		return fmt.Errorf("usage report period must be at least 60 seconds")
+	}
+	if cfg.PushUsageReports && (!cfg.EnableUsageReports || cfg.CloudEndpoint == "") {
+		return fmt.Errorf("pushing usage reports requires usage reports and a cloud endpoint")
+	}
+
+	// Validate disruption coordination
+	if cfg.DisruptionPolicy != "defer" && cfg.DisruptionPolicy != "evict" {
+		return fmt.Errorf("invalid disruption policy: %s, must be one of: defer, evict", cfg.DisruptionPolicy)
+	}
+	if cfg.DisruptionTimeoutSec <= 0 {
+		return fmt.Errorf("disruption timeout must be greater than 0")
+	}
+
+	// Validate CNI plugin socket
+	if cfg.CNISocket != "" && !filepath.IsAbs(cfg.CNISocket) {
+		return fmt.Errorf("invalid CNI socket: %s, must be an absolute path", cfg.CNISocket)
+	}
+
+	// Validate VF allocation checkpoint
+	if cfg.SRIOVStateFile != "" && !filepath.IsAbs(cfg.SRIOVStateFile) {
+		return fmt.Errorf("invalid SR-IOV state file: %s, must be an absolute path", cfg.SRIOVStateFile)
+	}
+
+	// Validate firmware updates, the command runs without a shell
+	if cfg.EnableFirmwareUpdates && cfg.FirmwareUpdateTimeoutSec <= 0 {
+		return fmt.Errorf("firmware update timeout must be greater than 0")
+	}
+	if cfg.FirmwareUpdateCommand != "" && !strings.Contains(cfg.FirmwareUpdateCommand, "{image}") {
+		return fmt.Errorf("invalid firmware update command: %s, must pass the {image}", cfg.FirmwareUpdateCommand)
+	}
+
+	// Validate devlink
+	if cfg.EnableDevlink && cfg.DevlinkPollIntervalSec <= 0 {
+		return fmt.Errorf("devlink poll interval must be greater than 0")
+	}
+
+	if cfg.EnableHotplug && cfg.HotplugConsistencyCheckSec <= 0 {
+		return fmt.Errorf("hotplug consistency check interval must be greater than 0")
+	}
+
+	// Validate VF inventory consistency checks
+	if cfg.InventoryCheckIntervalSec < 0 {
+		return fmt.Errorf("inventory check interval cannot be negative")
+	}
+	if cfg.EnableInventoryRepair && cfg.InventoryCheckIntervalSec == 0 {
+		return fmt.Errorf("inventory repair requires periodic inventory checks")
+	}
+
+	// Validate traffic tests
+	if cfg.EnableTrafficTests && (cfg.TrafficTestMaxSec <= 0 || cfg.TrafficTestMaxSec > 600) {
+		return fmt.Errorf("invalid traffic test max duration: %d, must be between 1 and 600 seconds", cfg.TrafficTestMaxSec)
+	}
+	if cfg.EnableTrafficTests && cfg.TrafficTestMaxRateMbps <= 0 {
+		return fmt.Errorf("traffic test max rate must be greater than 0")
+	}
+	if cfg.TrafficReflectorListenAddr != "" {
+		if _, _, err := net.SplitHostPort(cfg.TrafficReflectorListenAddr); err != nil {
+			return fmt.Errorf("invalid traffic reflector listen address: %w", err)
+		}
+	}
+
+	// Validate QoS enforcement
+	if cfg.EnableQoS {
+		if len(cfg.QoSInterfaces) == 0 {
+			return fmt.Errorf("QoS enforcement requires at least one QoS interface")
+		}
+		for _, name := range cfg.QoSInterfaces {
+			if name == "" {
+				return fmt.Errorf("invalid QoS interfaces: empty interface name")
+			}
+		}
+		if cfg.QoSLinkRateMbps <= 0 {
+			return fmt.Errorf("QoS link rate must be greater than 0")
+		}
+		if cfg.QoSIntervalSec <= 0 {
+			return fmt.Errorf("QoS interval must be greater than 0")
+		}
+	}
+
+	// Validate latency hints
+	if cfg.EnableLatencyHints && cfg.LatencyHintIntervalSec <= 0 {
+		return fmt.Errorf("latency hint interval must be greater than 0")
+	}
+
+	// Validate metrics
+	if cfg.MetricsListenAddr != "" {
+		if _, _, err := net.SplitHostPort(cfg.MetricsListenAddr); err != nil {
+			return fmt.Errorf("invalid metrics listen address: %w", err)
+		}
+	}
+
+	// Validate admission webhook
+	if cfg.EnableAdmissionWebhook {
+		if cfg.AdmissionWebhookPort < 1 || cfg.AdmissionWebhookPort > 65535 {
+			return fmt.Errorf("admission webhook port must be between 1 and 65535, got %d", cfg.AdmissionWebhookPort)
+		}
+		if cfg.AdmissionWebhookCertDir == "" {
+			return fmt.Errorf("admission webhook cert dir is required")
+		}
+	}
+
+	// Validate security posture policy
+	if cfg.PostureMaxKeyAgeSec < 0 {
+		return fmt.Errorf("posture max key age must not be negative, got %d", cfg.PostureMaxKeyAgeSec)
+	}
+
+	// Validate traffic anomaly detection
+	if cfg.EnableAnomalyDetection {
+		if cfg.AnomalyIntervalSec <= 0 {
+			return fmt.Errorf("anomaly detection interval must be greater than 0")
+		}
+		if cfg.AnomalySpikeFactor < 2 {
+			return fmt.Errorf("anomaly spike factor must be at least 2, got %d", cfg.AnomalySpikeFactor)
+		}
+		if cfg.AnomalyWebhookURL != "" {
+			u, err := url.Parse(cfg.AnomalyWebhookURL)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Errorf("invalid anomaly webhook URL %q, must be an http or https URL", cfg.AnomalyWebhookURL)
+			}
+		}
+	}
+
+	// Validate NetworkEndpoint registry
+	if cfg.EnableEndpointRegistry && cfg.EndpointRegistryIntervalSec <= 0 {
+		return fmt.Errorf("endpoint registry interval must be greater than 0")
+	}
+
+	// Validate VF gRPC socket
+	if cfg.VFSocket != "" && !filepath.IsAbs(cfg.VFSocket) {
+		return fmt.Errorf("invalid VF socket: %s, must be an absolute path", cfg.VFSocket)
+	}
+
+	// Validate KubeVirt support
+	if cfg.EnableKubeVirt && !cfg.EnableSRIOV {
+		return fmt.Errorf("KubeVirt support requires SR-IOV")
+	}
+
+	// Validate leader election
+	if cfg.EnableLeaderElection {
+		if cfg.LeaderElectionNamespace == "" {
+			return fmt.Errorf("leader election namespace is required when leader election is enabled")
+		}
+		// the leader renews every two thirds, candidates retry every fifth
+		// of the lease duration
+		if cfg.LeaderElectionLeaseDurationSec < 5 {
+			return fmt.Errorf("leader election lease duration must be at least 5 seconds")
+		}
+		// a leader reconciles every object, the shards would sit idle
+		if cfg.EnableSharding {
+			return fmt.Errorf("leader election and sharding are mutually exclusive")
+		}
+	}
+
+	// Validate vhost-user ports
+	switch cfg.VhostUserDataplane {
+	case "":
+	case "ovs":
+		if cfg.VhostUserBridge == "" {
+			return fmt.Errorf("vhost-user bridge is required with the ovs dataplane")
+		}
+	case "vpp":
+		// VPP bridge domains are numbered, 0 being the default domain
+		if cfg.VhostUserBridge != "" {
+			if id, err := strconv.Atoi(cfg.VhostUserBridge); err != nil || id < 1 {
+				return fmt.Errorf("invalid vhost-user bridge: %s, must be a VPP bridge domain ID", cfg.VhostUserBridge)
+			}
+		}
+	default:
+		return fmt.Errorf("invalid vhost-user dataplane: %s, must be one of: ovs, vpp", cfg.VhostUserDataplane)
+	}
+	if cfg.VhostUserDataplane != "" && !filepath.IsAbs(cfg.VhostUserSocketDir) {
+		return fmt.Errorf("vhost-user socket directory must be an absolute path")
+	}
+
+	// Validate health probes
+	if cfg.HealthListenAddr != "" {
+		if _, _, err := net.SplitHostPort(cfg.HealthListenAddr); err != nil {
+			return fmt.Errorf("invalid health listen address: %w", err)
+		}
+	}
+
+	// Validate dataplane
+	switch cfg.Dataplane {
+	case "kernel":
+	case "vpp":
+		if cfg.VPPUplink == "" {
+			return fmt.Errorf("VPP uplink is required with the vpp dataplane")
+		}
+	default:
+		return fmt.Errorf("invalid dataplane: %s, must be one of: kernel, vpp", cfg.Dataplane)
+	}
+
+	// Validate uplink costs
+	uplinks := make(map[string]bool)
+	for _, cost := range cfg.UplinkCosts {
+		if cost.Device == "" {
+			return fmt.Errorf("uplink costs need a device")
+		}
+		if uplinks[cost.Device] {
+			return fmt.Errorf("duplicate uplink cost of %s", cost.Device)
+		}
+		uplinks[cost.Device] = true
+		if cost.CostPerGB < 0 || cost.MonthlyBudget < 0 {
+			return fmt.Errorf("invalid cost of uplink %s: the cost per GB and monthly budget must not be negative", cost.Device)
+		}
+	}
+	if cfg.UplinkCostStateFile != "" && !filepath.IsAbs(cfg.UplinkCostStateFile) {
+		return fmt.Errorf("invalid uplink cost state file: %s, must be an absolute path", cfg.UplinkCostStateFile)
+	}
+
+	// Validate watchdog
+	if cfg.EnableWatchdog && cfg.WatchdogMissedIntervals <= 0 {
+		return fmt.Errorf("watchdog missed intervals must be greater than 0")
+	}
+
 	return nil
 }
 
@@ -0,0 +1,1269 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateProfiling(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(*Config)
+		wantErr bool
+	}{
+		{"disabled", func(c *Config) {}, false},
+		{"enabled without token", func(c *Config) { c.EnableProfiling = true }, true},
+		{"enabled with token", func(c *Config) { c.EnableProfiling = true; c.ProfilingToken = "t" }, false},
+		{"snapshots only", func(c *Config) { c.EnableProfiling = true; c.APIListenAddr = "" }, false},
+		{"invalid interval", func(c *Config) {
+			c.EnableProfiling = true
+			c.ProfilingToken = "t"
+			c.ProfileIntervalSec = 0
+		}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			tt.modify(cfg)
+			if err := validateConfig(cfg); (err != nil) != tt.wantErr {
+				t.Errorf("validateConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestProfilingFromEnv(t *testing.T) {
+	t.Setenv("NSM_ENABLE_PROFILING", "true")
+	t.Setenv("NSM_PROFILING_TOKEN", "s3cret")
+	t.Setenv("NSM_PROFILE_DIR", "")
+	t.Setenv("NSM_PROFILE_INTERVAL_SEC", "60")
+
+	cfg, err := LoadConfig("")
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if !cfg.EnableProfiling || cfg.ProfilingToken != "s3cret" || cfg.ProfileDir != "" || cfg.ProfileIntervalSec != 60 {
+		t.Errorf("unexpected config: %+v", cfg)
+	}
+}
+
+func TestValidateWatchdog(t *testing.T) {
+	cfg := DefaultConfig()
+	if !cfg.EnableWatchdog || cfg.WatchdogRestart {
+		t.Errorf("watchdog should be enabled without restarts by default")
+	}
+
+	cfg.WatchdogMissedIntervals = 0
+	if err := validateConfig(cfg); err == nil {
+		t.Errorf("expected error for zero missed intervals")
+	}
+
+	cfg.EnableWatchdog = false
+	if err := validateConfig(cfg); err != nil {
+		t.Errorf("disabled watchdog must not be validated: %v", err)
+	}
+}
+
+func TestValidateFallbackDatapath(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.FallbackDatapath = "bridge"
+	if err := validateConfig(cfg); err == nil {
+		t.Errorf("expected error for invalid fallback datapath")
+	}
+	cfg.FallbackDatapath = "ipvlan"
+	if err := validateConfig(cfg); err != nil {
+		t.Errorf("validateConfig() error = %v", err)
+	}
+}
+
+func TestCryptoOffloadFromEnv(t *testing.T) {
+	if !DefaultConfig().EnableCryptoOffload {
+		t.Errorf("crypto offload should be enabled by default")
+	}
+
+	t.Setenv("NSM_ENABLE_CRYPTO_OFFLOAD", "false")
+	cfg, err := LoadConfig("")
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if cfg.EnableCryptoOffload {
+		t.Errorf("crypto offload not disabled from the environment")
+	}
+}
+
+func TestValidateRekey(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.RekeyGapSec = 0
+	if err := validateConfig(cfg); err == nil {
+		t.Errorf("expected error for zero rekey gap")
+	}
+
+	cfg.RekeyIntervalSec = 0
+	if err := validateConfig(cfg); err != nil {
+		t.Errorf("disabled key rotation must not be validated: %v", err)
+	}
+
+	cfg.RekeyIntervalSec = -1
+	if err := validateConfig(cfg); err == nil {
+		t.Errorf("expected error for negative rekey interval")
+	}
+}
+
+func TestValidateLegacyKeySecret(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.LegacyKeySecret = "nsm-keys"
+	if err := validateConfig(cfg); err == nil {
+		t.Errorf("expected error for legacy key secret without namespace")
+	}
+	cfg.LegacyKeySecret = "nsm-system/nsm-keys"
+	if err := validateConfig(cfg); err != nil {
+		t.Errorf("validateConfig() error = %v", err)
+	}
+}
+
+func TestBootstrapFromEnv(t *testing.T) {
+	t.Setenv("NSM_BOOTSTRAP_FILE", "/etc/nsm/bootstrap.yaml")
+	t.Setenv("NSM_BOOTSTRAP_PRUNE", "true")
+
+	cfg, err := LoadConfig("")
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if cfg.BootstrapFile != "/etc/nsm/bootstrap.yaml" || !cfg.BootstrapPrune {
+		t.Errorf("unexpected bootstrap config: %q prune=%t", cfg.BootstrapFile, cfg.BootstrapPrune)
+	}
+}
+
+func TestMetricsStreamFromEnv(t *testing.T) {
+	if DefaultConfig().MetricsStreamListenAddr == "" {
+		t.Errorf("metrics streaming API should be enabled by default")
+	}
+
+	// an empty address disables the API
+	t.Setenv("NSM_METRICS_STREAM_LISTEN_ADDR", "")
+	cfg, err := LoadConfig("")
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if cfg.MetricsStreamListenAddr != "" {
+		t.Errorf("metrics streaming API not disabled from the environment: %q", cfg.MetricsStreamListenAddr)
+	}
+}
+
+func TestValidateTelemetry(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.TelemetryRollupSec = 0
+	if err := validateConfig(cfg); err != nil {
+		t.Errorf("telemetry must not be validated without cloud endpoint: %v", err)
+	}
+
+	cfg.CloudEndpoint = "https://fleet.example.com/v1/heartbeats"
+	if err := validateConfig(cfg); err == nil {
+		t.Errorf("expected error for zero rollup")
+	}
+
+	cfg.TelemetryRollupSec = 60
+	cfg.TelemetryMaxBytes = -1
+	if err := validateConfig(cfg); err == nil {
+		t.Errorf("expected error for negative byte budget")
+	}
+}
+
+func TestValidatePressureThrottling(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.PressureStallThresholdPercent = 0
+	if err := validateConfig(cfg); err == nil {
+		t.Errorf("expected error for zero stall threshold")
+	}
+
+	cfg.EnablePressureThrottling = false
+	if err := validateConfig(cfg); err != nil {
+		t.Errorf("disabled throttling must not be validated: %v", err)
+	}
+
+	cfg.EnablePressureThrottling = true
+	cfg.PressureStallThresholdPercent = 40
+	cfg.PressureMinMemAvailablePercent = 100
+	if err := validateConfig(cfg); err == nil {
+		t.Errorf("expected error for 100%% minimum available memory")
+	}
+}
+
+func TestValidateThermalMonitor(t *testing.T) {
+	cfg := DefaultConfig()
+	if cfg.ThermalShedPriority != 0 || cfg.ThermalReducePolling {
+		t.Errorf("thermal shedding and polling reduction must be opt-in")
+	}
+
+	cfg.ThermalMarginCelsius = 0
+	if err := validateConfig(cfg); err == nil {
+		t.Errorf("expected error for zero thermal margin")
+	}
+
+	cfg.EnableThermalMonitor = false
+	if err := validateConfig(cfg); err != nil {
+		t.Errorf("disabled thermal monitor must not be validated: %v", err)
+	}
+}
+
+func TestIdleModeFromEnv(t *testing.T) {
+	if DefaultConfig().IdleAfterSec != 0 {
+		t.Errorf("idle mode must be opt-in")
+	}
+
+	t.Setenv("NSM_IDLE_AFTER_SEC", "900")
+	cfg, err := LoadConfig("")
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if cfg.IdleAfterSec != 900 {
+		t.Errorf("idle period = %d, want 900", cfg.IdleAfterSec)
+	}
+
+	t.Setenv("NSM_IDLE_AFTER_SEC", "-1")
+	if _, err := LoadConfig(""); err == nil {
+		t.Errorf("expected error for negative idle period")
+	}
+}
+
+func TestResourceBudgetsFromEnv(t *testing.T) {
+	t.Setenv("NSM_TELEMETRY_CPU_BUDGET_PERCENT", "2")
+	t.Setenv("NSM_TELEMETRY_MEMORY_BUDGET_MB", "4")
+	cfg, err := LoadConfig("")
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if !cfg.EnableResourceBudgets || cfg.TelemetryCPUBudgetPercent != 2 || cfg.TelemetryMemoryBudgetMB != 4 || cfg.ProbeCPUBudgetPercent != 5 {
+		t.Errorf("unexpected budgets %+v", cfg)
+	}
+
+	t.Setenv("NSM_PROBE_CPU_BUDGET_PERCENT", "150")
+	if _, err := LoadConfig(""); err == nil {
+		t.Errorf("expected error for a CPU budget over 100%%")
+	}
+
+	t.Setenv("NSM_ENABLE_RESOURCE_BUDGETS", "false")
+	if _, err := LoadConfig(""); err != nil {
+		t.Errorf("budgets validated while disabled: %v", err)
+	}
+}
+
+func TestRegistryFromEnv(t *testing.T) {
+	t.Setenv("NSM_REGISTRY_SOURCE", "netbox")
+	t.Setenv("NSM_REGISTRY_URL", "https://netbox.example.com")
+	t.Setenv("NSM_REGISTRY_FILTER", "site=edge-01")
+	cfg, err := LoadConfig("")
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if cfg.RegistrySource != "netbox" || cfg.RegistryFilter != "site=edge-01" || cfg.RegistryNamespace != "default" || cfg.RegistrySyncIntervalSec != 300 {
+		t.Errorf("unexpected registry config %+v", cfg)
+	}
+
+	t.Setenv("NSM_REGISTRY_SOURCE", "ldap")
+	if _, err := LoadConfig(""); err == nil {
+		t.Errorf("expected error for an unknown registry source")
+	}
+
+	cfg = DefaultConfig()
+	cfg.RegistrySource = "csv"
+	if err := validateConfig(cfg); err == nil {
+		t.Errorf("expected error without a registry URL")
+	}
+}
+
+func TestReachabilityFromEnv(t *testing.T) {
+	cfg, err := LoadConfig("")
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if !cfg.EnableReachabilityScoring || cfg.ReachabilityStateFile == "" {
+		t.Errorf("unexpected defaults %+v", cfg)
+	}
+
+	// an empty state file keeps the flap history in memory
+	t.Setenv("NSM_REACHABILITY_STATE_FILE", "")
+	if cfg, _ := LoadConfig(""); cfg.ReachabilityStateFile != "" {
+		t.Errorf("state file = %q, want none", cfg.ReachabilityStateFile)
+	}
+}
+
+func TestFlapDampingFromEnv(t *testing.T) {
+	t.Setenv("NSM_FLAP_SUPPRESS_THRESHOLD", "3000")
+	t.Setenv("NSM_FLAP_HALF_LIFE_SEC", "60")
+	cfg, err := LoadConfig("")
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if !cfg.EnableFlapDamping || cfg.FlapSuppressThreshold != 3000 || cfg.FlapHalfLifeSec != 60 || cfg.FlapReuseThreshold != 750 {
+		t.Errorf("unexpected flap damping config %+v", cfg)
+	}
+
+	// the reuse threshold must be below the suppress threshold
+	t.Setenv("NSM_FLAP_REUSE_THRESHOLD", "3000")
+	if _, err := LoadConfig(""); err == nil {
+		t.Errorf("expected an error for a reuse threshold above the suppress threshold")
+	}
+}
+
+func TestShardingFromEnv(t *testing.T) {
+	t.Setenv("NSM_ENABLE_SHARDING", "true")
+	t.Setenv("NSM_SHARD_LEASE_DURATION_SEC", "30")
+	cfg, err := LoadConfig("")
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if !cfg.EnableSharding || cfg.ShardNamespace != "nsm-system" || cfg.ShardLeaseDurationSec != 30 {
+		t.Errorf("unexpected sharding config %+v", cfg)
+	}
+
+	t.Setenv("NSM_SHARD_LEASE_DURATION_SEC", "1")
+	if _, err := LoadConfig(""); err == nil {
+		t.Errorf("expected an error for a lease shorter than 3 seconds")
+	}
+}
+
+func TestConnectionGCFromEnv(t *testing.T) {
+	t.Setenv("NSM_ENABLE_CONNECTION_GC", "true")
+	t.Setenv("NSM_CONNECTION_GC_DRY_RUN", "true")
+	cfg, err := LoadConfig("")
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if !cfg.EnableConnectionGC || !cfg.ConnectionGCDryRun || cfg.ConnectionGCGraceSec != 600 {
+		t.Errorf("unexpected connection GC config %+v", cfg)
+	}
+
+	t.Setenv("NSM_CONNECTION_GC_GRACE_SEC", "5")
+	if _, err := LoadConfig(""); err == nil {
+		t.Errorf("expected an error for a grace period under a minute")
+	}
+}
+
+func TestGatewayAPIFromEnv(t *testing.T) {
+	if cfg, _ := LoadConfig(""); cfg.EnableGatewayAPI {
+		t.Errorf("Gateway API routes reconciled by default")
+	}
+	t.Setenv("NSM_ENABLE_GATEWAY_API", "true")
+	if cfg, _ := LoadConfig(""); !cfg.EnableGatewayAPI {
+		t.Errorf("NSM_ENABLE_GATEWAY_API not applied")
+	}
+}
+
+func TestEndpointSlicesFromEnv(t *testing.T) {
+	if cfg, _ := LoadConfig(""); cfg.EnableEndpointSlices {
+		t.Errorf("EndpointSlices published by default")
+	}
+	t.Setenv("NSM_ENABLE_ENDPOINT_SLICES", "true")
+	if cfg, _ := LoadConfig(""); !cfg.EnableEndpointSlices {
+		t.Errorf("NSM_ENABLE_ENDPOINT_SLICES not applied")
+	}
+}
+
+func TestConnectionDNSFromEnv(t *testing.T) {
+	t.Setenv("NSM_ENABLE_CONNECTION_DNS", "true")
+	t.Setenv("NSM_CLUSTER_DOMAIN", "edge.local")
+	cfg, err := LoadConfig("")
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if !cfg.EnableConnectionDNS || cfg.ClusterDNS != "10.96.0.10" || cfg.ClusterDomain != "edge.local" {
+		t.Errorf("unexpected connection DNS config %+v", cfg)
+	}
+
+	// an empty cluster resolver leaves only those of the connections
+	t.Setenv("NSM_CLUSTER_DNS", "")
+	if cfg, err := LoadConfig(""); err != nil || cfg.ClusterDNS != "" {
+		t.Errorf("NSM_CLUSTER_DNS not cleared: %v", err)
+	}
+
+	t.Setenv("NSM_CLUSTER_DNS", "kube-dns")
+	if _, err := LoadConfig(""); err == nil {
+		t.Errorf("expected an error for a cluster DNS that isn't an IP")
+	}
+}
+
+func TestSRIOVStormControlFromEnv(t *testing.T) {
+	t.Setenv("NSM_SRIOV_BROADCAST_PPS", "200")
+	t.Setenv("NSM_SRIOV_MULTICAST_PPS", "5000")
+	cfg, err := LoadConfig("")
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if cfg.SRIOVBroadcastPPS != 200 || cfg.SRIOVMulticastPPS != 5000 {
+		t.Errorf("unexpected VF storm control limits %d/%d", cfg.SRIOVBroadcastPPS, cfg.SRIOVMulticastPPS)
+	}
+
+	t.Setenv("NSM_SRIOV_BROADCAST_PPS", "-1")
+	if _, err := LoadConfig(""); err == nil {
+		t.Errorf("expected an error for a negative broadcast limit")
+	}
+}
+
+func TestFDBMonitorFromEnv(t *testing.T) {
+	t.Setenv("NSM_ENABLE_FDB_MONITOR", "true")
+	cfg, err := LoadConfig("")
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if !cfg.EnableFDBMonitor || cfg.FDBFlapThreshold != 3 {
+		t.Errorf("unexpected FDB monitor config %+v", cfg)
+	}
+
+	t.Setenv("NSM_FDB_FLAP_THRESHOLD", "1")
+	if _, err := LoadConfig(""); err == nil {
+		t.Errorf("expected an error for a flap threshold of a single move")
+	}
+}
+
+func TestProbingFromEnv(t *testing.T) {
+	t.Setenv("NSM_PROBE_INTERVAL_SEC", "60")
+	t.Setenv("NSM_PROBE_BUDGET_PER_SEC", "5")
+	cfg, err := LoadConfig("")
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if cfg.ProbeIntervalSec != 60 || cfg.ProbeMinIntervalSec != 2 || cfg.ProbeBudgetPerSec != 5 {
+		t.Errorf("unexpected probing config %+v", cfg)
+	}
+
+	t.Setenv("NSM_PROBE_MIN_INTERVAL_SEC", "120")
+	if _, err := LoadConfig(""); err == nil {
+		t.Errorf("expected an error for a minimum interval above the base interval")
+	}
+	t.Setenv("NSM_PROBE_MIN_INTERVAL_SEC", "2")
+	t.Setenv("NSM_PROBE_BUDGET_PER_SEC", "0")
+	if _, err := LoadConfig(""); err == nil {
+		t.Errorf("expected an error for a zero probe budget")
+	}
+
+	// a zero interval disables probing, whatever the budget
+	t.Setenv("NSM_PROBE_INTERVAL_SEC", "0")
+	if _, err := LoadConfig(""); err != nil {
+		t.Errorf("LoadConfig() error = %v with probing disabled", err)
+	}
+}
+
+func TestFailoverDrillsFromEnv(t *testing.T) {
+	cfg := DefaultConfig()
+	if cfg.EnableFailoverDrills {
+		t.Errorf("failover drills should be disabled by default")
+	}
+
+	t.Setenv("NSM_ENABLE_FAILOVER_DRILLS", "true")
+	t.Setenv("NSM_FAILOVER_DRILL_MAX_SEC", "10")
+	cfg, err := LoadConfig("")
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if !cfg.EnableFailoverDrills || cfg.FailoverDrillMaxSec != 10 {
+		t.Errorf("unexpected failover drill config %+v", cfg)
+	}
+
+	t.Setenv("NSM_FAILOVER_DRILL_MAX_SEC", "0")
+	if _, err := LoadConfig(""); err == nil {
+		t.Errorf("expected an error for a zero drill duration")
+	}
+}
+
+func TestLegacyAPIFromEnv(t *testing.T) {
+	if !DefaultConfig().ServeLegacyAPI {
+		t.Errorf("legacy API should be served by default")
+	}
+
+	t.Setenv("NSM_SERVE_LEGACY_API", "false")
+	t.Setenv("NSM_LEGACY_API_SUNSET", "2027-06-30")
+	cfg, err := LoadConfig("")
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if cfg.ServeLegacyAPI || cfg.LegacyAPISunset != "2027-06-30" {
+		t.Errorf("unexpected legacy API config %+v", cfg)
+	}
+
+	t.Setenv("NSM_LEGACY_API_SUNSET", "next summer")
+	if _, err := LoadConfig(""); err == nil {
+		t.Errorf("expected an error for an invalid sunset date")
+	}
+}
+
+func TestAgentLeasesFromEnv(t *testing.T) {
+	t.Setenv("NSM_ENABLE_AGENT_LEASES", "true")
+	t.Setenv("NSM_AGENT_LEASE_DURATION_SEC", "20")
+	cfg, err := LoadConfig("")
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if !cfg.EnableAgentLeases || cfg.AgentLeaseNamespace != "nsm-system" || cfg.AgentLeaseDurationSec != 20 {
+		t.Errorf("unexpected agent lease config %+v", cfg)
+	}
+
+	t.Setenv("NSM_AGENT_LEASE_DURATION_SEC", "2")
+	if _, err := LoadConfig(""); err == nil {
+		t.Errorf("expected an error for a lease shorter than 3 seconds")
+	}
+}
+
+func TestDPDKFromEnv(t *testing.T) {
+	t.Setenv("NSM_ENABLE_DPDK", "true")
+	t.Setenv("NSM_DPDK_DEVICES", "0000:3b:00.0,0000:3b:00.1")
+	cfg, err := LoadConfig("")
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if !cfg.EnableDPDK || cfg.DPDKDriver != "vfio-pci" || len(cfg.DPDKDevices) != 2 || cfg.DPDKDevices[1] != "0000:3b:00.1" {
+		t.Errorf("unexpected DPDK config %+v", cfg)
+	}
+
+	t.Setenv("NSM_DPDK_DRIVER", "uio_pci_generic")
+	if _, err := LoadConfig(""); err == nil {
+		t.Errorf("expected an error for an unsupported DPDK driver")
+	}
+
+	t.Setenv("NSM_DPDK_DRIVER", "igb_uio")
+	t.Setenv("NSM_DPDK_DEVICES", "eth0")
+	if _, err := LoadConfig(""); err == nil {
+		t.Errorf("expected an error for a device that is not a PCI address")
+	}
+}
+
+func TestVFLeaseMaxTTLFromEnv(t *testing.T) {
+	t.Setenv("NSM_VF_LEASE_MAX_TTL_SEC", "3600")
+	cfg, err := LoadConfig("")
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if cfg.VFLeaseMaxTTLSec != 3600 {
+		t.Errorf("VFLeaseMaxTTLSec = %d, want 3600", cfg.VFLeaseMaxTTLSec)
+	}
+
+	t.Setenv("NSM_VF_LEASE_MAX_TTL_SEC", "0")
+	if _, err := LoadConfig(""); err == nil {
+		t.Errorf("expected an error for a max TTL of 0")
+	}
+}
+
+func TestUsageReportsFromEnv(t *testing.T) {
+	t.Setenv("NSM_ENABLE_USAGE_REPORTS", "true")
+	t.Setenv("NSM_USAGE_REPORT_SEC", "86400")
+	t.Setenv("NSM_PUSH_USAGE_REPORTS", "true")
+	t.Setenv("NSM_CLOUD_ENDPOINT", "https://fleet.example.com/v1/heartbeats")
+	cfg, err := LoadConfig("")
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if !cfg.EnableUsageReports || cfg.UsageReportSec != 86400 || !cfg.PushUsageReports {
+		t.Errorf("usage reports = %t every %ds, push %t", cfg.EnableUsageReports, cfg.UsageReportSec, cfg.PushUsageReports)
+	}
+
+	t.Setenv("NSM_CLOUD_ENDPOINT", "")
+	if _, err := LoadConfig(""); err == nil {
+		t.Errorf("expected an error for pushing usage reports without a cloud endpoint")
+	}
+
+	t.Setenv("NSM_PUSH_USAGE_REPORTS", "false")
+	t.Setenv("NSM_USAGE_REPORT_SEC", "30")
+	if _, err := LoadConfig(""); err == nil {
+		t.Errorf("expected an error for a report period under a minute")
+	}
+}
+
+func TestDisruptionPolicyFromEnv(t *testing.T) {
+	t.Setenv("NSM_DISRUPTION_POLICY", "evict")
+	t.Setenv("NSM_DISRUPTION_TIMEOUT_SEC", "600")
+	cfg, err := LoadConfig("")
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if cfg.DisruptionPolicy != "evict" || cfg.DisruptionTimeoutSec != 600 {
+		t.Errorf("disruption policy = %s with timeout %ds", cfg.DisruptionPolicy, cfg.DisruptionTimeoutSec)
+	}
+
+	t.Setenv("NSM_DISRUPTION_POLICY", "yank")
+	if _, err := LoadConfig(""); err == nil {
+		t.Errorf("expected an error for an invalid disruption policy")
+	}
+}
+
+func TestCNISocketFromEnv(t *testing.T) {
+	t.Setenv("NSM_CNI_SOCKET", "/run/nsm/plugin.sock")
+	cfg, err := LoadConfig("")
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if cfg.CNISocket != "/run/nsm/plugin.sock" {
+		t.Errorf("CNI socket = %q", cfg.CNISocket)
+	}
+
+	t.Setenv("NSM_CNI_SOCKET", "")
+	cfg, err = LoadConfig("")
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if cfg.CNISocket != "" {
+		t.Errorf("CNI socket = %q, want it disabled", cfg.CNISocket)
+	}
+
+	t.Setenv("NSM_CNI_SOCKET", "cni.sock")
+	if _, err := LoadConfig(""); err == nil {
+		t.Errorf("expected an error for a relative CNI socket")
+	}
+}
+
+func TestVFReparentingFromEnv(t *testing.T) {
+	cfg, err := LoadConfig("")
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if !cfg.EnableVFReparenting {
+		t.Error("VF reparenting disabled by default")
+	}
+
+	t.Setenv("NSM_ENABLE_VF_REPARENTING", "false")
+	cfg, err = LoadConfig("")
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if cfg.EnableVFReparenting {
+		t.Error("VF reparenting enabled with NSM_ENABLE_VF_REPARENTING=false")
+	}
+}
+
+func TestSRIOVStateFileFromEnv(t *testing.T) {
+	t.Setenv("NSM_SRIOV_STATE_FILE", "/data/nsm/vfs.json")
+	cfg, err := LoadConfig("")
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if cfg.SRIOVStateFile != "/data/nsm/vfs.json" {
+		t.Errorf("SR-IOV state file = %q", cfg.SRIOVStateFile)
+	}
+
+	t.Setenv("NSM_SRIOV_STATE_FILE", "")
+	cfg, err = LoadConfig("")
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if cfg.SRIOVStateFile != "" {
+		t.Errorf("SR-IOV state file = %q, want the allocations kept in memory", cfg.SRIOVStateFile)
+	}
+
+	t.Setenv("NSM_SRIOV_STATE_FILE", "vfs.json")
+	if _, err := LoadConfig(""); err == nil {
+		t.Errorf("expected an error for a relative SR-IOV state file")
+	}
+}
+
+func TestFirmwareUpdatesFromEnv(t *testing.T) {
+	cfg, err := LoadConfig("")
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if cfg.EnableFirmwareUpdates {
+		t.Error("firmware updates enabled by default")
+	}
+
+	t.Setenv("NSM_ENABLE_FIRMWARE_UPDATES", "true")
+	t.Setenv("NSM_FIRMWARE_UPDATE_COMMAND", "/opt/vendor/fwupdate --dev {pci} {image}")
+	t.Setenv("NSM_FIRMWARE_UPDATE_TIMEOUT_SEC", "600")
+	cfg, err = LoadConfig("")
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if !cfg.EnableFirmwareUpdates || cfg.FirmwareUpdateCommand != "/opt/vendor/fwupdate --dev {pci} {image}" || cfg.FirmwareUpdateTimeoutSec != 600 {
+		t.Errorf("firmware updates = %t with command %q and timeout %ds", cfg.EnableFirmwareUpdates, cfg.FirmwareUpdateCommand, cfg.FirmwareUpdateTimeoutSec)
+	}
+
+	t.Setenv("NSM_FIRMWARE_UPDATE_COMMAND", "/opt/vendor/fwupdate --latest")
+	if _, err := LoadConfig(""); err == nil {
+		t.Errorf("expected an error for a command without the image")
+	}
+}
+
+func TestDevlinkFromEnv(t *testing.T) {
+	t.Setenv("NSM_ENABLE_DEVLINK", "true")
+	t.Setenv("NSM_DEVLINK_POLL_INTERVAL_SEC", "10")
+	cfg, err := LoadConfig("")
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if !cfg.EnableDevlink || cfg.DevlinkPollIntervalSec != 10 {
+		t.Errorf("devlink = %t polling every %ds", cfg.EnableDevlink, cfg.DevlinkPollIntervalSec)
+	}
+
+	t.Setenv("NSM_DEVLINK_POLL_INTERVAL_SEC", "0")
+	if _, err := LoadConfig(""); err == nil {
+		t.Errorf("expected an error for a zero poll interval")
+	}
+}
+
+func TestHotplugFromEnv(t *testing.T) {
+	cfg, err := LoadConfig("")
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if !cfg.EnableHotplug {
+		t.Error("hotplug disabled by default")
+	}
+
+	t.Setenv("NSM_ENABLE_HOTPLUG", "false")
+	cfg, err = LoadConfig("")
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if cfg.EnableHotplug {
+		t.Error("hotplug enabled with NSM_ENABLE_HOTPLUG=false")
+	}
+}
+
+func TestHotplugConsistencyCheckFromEnv(t *testing.T) {
+	t.Setenv("NSM_HOTPLUG_CONSISTENCY_CHECK_SEC", "600")
+	cfg, err := LoadConfig("")
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if cfg.HotplugConsistencyCheckSec != 600 {
+		t.Errorf("HotplugConsistencyCheckSec = %d, want 600", cfg.HotplugConsistencyCheckSec)
+	}
+
+	t.Setenv("NSM_HOTPLUG_CONSISTENCY_CHECK_SEC", "0")
+	if _, err := LoadConfig(""); err == nil {
+		t.Error("LoadConfig() accepted a consistency check interval of 0 with hotplug enabled")
+	}
+	t.Setenv("NSM_ENABLE_HOTPLUG", "false")
+	if _, err := LoadConfig(""); err != nil {
+		t.Errorf("LoadConfig() error = %v, the interval is unused without hotplug", err)
+	}
+}
+
+func TestFailoverFromEnv(t *testing.T) {
+	cfg, err := LoadConfig("")
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if !cfg.EnableFailover {
+		t.Error("failover disabled by default")
+	}
+
+	t.Setenv("NSM_ENABLE_FAILOVER", "false")
+	cfg, err = LoadConfig("")
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if cfg.EnableFailover {
+		t.Error("failover enabled with NSM_ENABLE_FAILOVER=false")
+	}
+}
+
+func TestCloudDisconnectFromEnv(t *testing.T) {
+	t.Setenv("NSM_CLOUD_ENDPOINT", "https://fleet.example.com/v1/heartbeats")
+	t.Setenv("NSM_CLOUD_DISCONNECT_AFTER_SEC", "300")
+	cfg, err := LoadConfig("")
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if cfg.CloudDisconnectAfterSec != 300 {
+		t.Errorf("CloudDisconnectAfterSec = %d, want 300", cfg.CloudDisconnectAfterSec)
+	}
+
+	t.Setenv("NSM_CLOUD_DISCONNECT_AFTER_SEC", "10")
+	if _, err := LoadConfig(""); err == nil {
+		t.Error("LoadConfig() accepted a disconnect period shorter than the heartbeat interval")
+	}
+}
+
+func TestInventoryCheckFromEnv(t *testing.T) {
+	t.Setenv("NSM_INVENTORY_CHECK_INTERVAL_SEC", "120")
+	t.Setenv("NSM_ENABLE_INVENTORY_REPAIR", "true")
+	cfg, err := LoadConfig("")
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if cfg.InventoryCheckIntervalSec != 120 || !cfg.EnableInventoryRepair {
+		t.Errorf("inventory checks = %ds repair=%t, want 120s with repairs", cfg.InventoryCheckIntervalSec, cfg.EnableInventoryRepair)
+	}
+
+	t.Setenv("NSM_INVENTORY_CHECK_INTERVAL_SEC", "0")
+	if _, err := LoadConfig(""); err == nil {
+		t.Error("LoadConfig() accepted repairs without periodic checks")
+	}
+}
+
+func TestTrafficTestsFromEnv(t *testing.T) {
+	t.Setenv("NSM_ENABLE_TRAFFIC_TESTS", "true")
+	t.Setenv("NSM_TRAFFIC_TEST_MAX_SEC", "120")
+	t.Setenv("NSM_TRAFFIC_TEST_MAX_RATE_MBPS", "5000")
+	t.Setenv("NSM_TRAFFIC_REFLECTOR_LISTEN_ADDR", ":9107")
+	cfg, err := LoadConfig("")
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if !cfg.EnableTrafficTests || cfg.TrafficTestMaxSec != 120 || cfg.TrafficTestMaxRateMbps != 5000 || cfg.TrafficReflectorListenAddr != ":9107" {
+		t.Errorf("unexpected traffic test config: %+v", cfg)
+	}
+
+	t.Setenv("NSM_TRAFFIC_TEST_MAX_SEC", "3600")
+	if _, err := LoadConfig(""); err == nil {
+		t.Error("LoadConfig() accepted a traffic test max duration over 600 seconds")
+	}
+
+	t.Setenv("NSM_TRAFFIC_TEST_MAX_SEC", "60")
+	t.Setenv("NSM_TRAFFIC_REFLECTOR_LISTEN_ADDR", "9107")
+	if _, err := LoadConfig(""); err == nil {
+		t.Error("LoadConfig() accepted a reflector address without a port")
+	}
+}
+
+func TestQoSFromEnv(t *testing.T) {
+	t.Setenv("NSM_ENABLE_QOS", "true")
+	t.Setenv("NSM_QOS_INTERFACES", "eth0,eth1")
+	t.Setenv("NSM_QOS_LINK_RATE_MBPS", "10000")
+	t.Setenv("NSM_QOS_INTERVAL_SEC", "10")
+	cfg, err := LoadConfig("")
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if !cfg.EnableQoS || len(cfg.QoSInterfaces) != 2 || cfg.QoSInterfaces[1] != "eth1" || cfg.QoSLinkRateMbps != 10000 || cfg.QoSIntervalSec != 10 {
+		t.Errorf("unexpected QoS config: %+v", cfg)
+	}
+
+	t.Setenv("NSM_QOS_INTERFACES", "eth0,")
+	if _, err := LoadConfig(""); err == nil {
+		t.Error("LoadConfig() accepted an empty QoS interface name")
+	}
+
+	t.Setenv("NSM_QOS_INTERFACES", "eth0")
+	t.Setenv("NSM_QOS_LINK_RATE_MBPS", "0")
+	if _, err := LoadConfig(""); err == nil {
+		t.Error("LoadConfig() accepted a zero QoS link rate")
+	}
+}
+
+func TestLatencyHintsFromEnv(t *testing.T) {
+	t.Setenv("NSM_ENABLE_LATENCY_HINTS", "true")
+	t.Setenv("NSM_LATENCY_HINT_INTERVAL_SEC", "30")
+	t.Setenv("NSM_LATENCY_HINT_NODE_NAME", "worker-1")
+	cfg, err := LoadConfig("")
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if !cfg.EnableLatencyHints || cfg.LatencyHintIntervalSec != 30 || cfg.LatencyHintNodeName != "worker-1" {
+		t.Errorf("unexpected latency hint config: %+v", cfg)
+	}
+
+	t.Setenv("NSM_LATENCY_HINT_INTERVAL_SEC", "0")
+	if _, err := LoadConfig(""); err == nil {
+		t.Error("LoadConfig() accepted a zero latency hint interval")
+	}
+}
+
+func TestMetricsListenAddrFromEnv(t *testing.T) {
+	t.Setenv("NSM_METRICS_LISTEN_ADDR", "")
+	cfg, err := LoadConfig("")
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if cfg.MetricsListenAddr != "" {
+		t.Errorf("metrics listen address = %q, want it disabled", cfg.MetricsListenAddr)
+	}
+
+	t.Setenv("NSM_METRICS_LISTEN_ADDR", "9092")
+	if _, err := LoadConfig(""); err == nil {
+		t.Error("LoadConfig() accepted a metrics address without a port")
+	}
+}
+
+func TestAdmissionWebhookFromEnv(t *testing.T) {
+	t.Setenv("NSM_ENABLE_ADMISSION_WEBHOOK", "true")
+	t.Setenv("NSM_ADMISSION_WEBHOOK_PORT", "8443")
+	t.Setenv("NSM_ADMISSION_WEBHOOK_CERT_DIR", "/run/nsm/certs")
+	cfg, err := LoadConfig("")
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if !cfg.EnableAdmissionWebhook || cfg.AdmissionWebhookPort != 8443 || cfg.AdmissionWebhookCertDir != "/run/nsm/certs" {
+		t.Errorf("unexpected admission webhook config: %t %d %q", cfg.EnableAdmissionWebhook, cfg.AdmissionWebhookPort, cfg.AdmissionWebhookCertDir)
+	}
+
+	t.Setenv("NSM_ADMISSION_WEBHOOK_PORT", "70000")
+	if _, err := LoadConfig(""); err == nil {
+		t.Error("LoadConfig() accepted an out of range webhook port")
+	}
+}
+
+func TestPosturePolicyFromEnv(t *testing.T) {
+	t.Setenv("NSM_POSTURE_REQUIRE_ENCRYPTION", "false")
+	t.Setenv("NSM_POSTURE_MAX_KEY_AGE_SEC", "3600")
+	cfg, err := LoadConfig("")
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if cfg.PostureRequireEncryption || cfg.PostureMaxKeyAgeSec != 3600 {
+		t.Errorf("unexpected posture policy: %t %d", cfg.PostureRequireEncryption, cfg.PostureMaxKeyAgeSec)
+	}
+
+	t.Setenv("NSM_POSTURE_MAX_KEY_AGE_SEC", "-1")
+	if _, err := LoadConfig(""); err == nil {
+		t.Error("LoadConfig() accepted a negative max key age")
+	}
+}
+
+func TestAnomalyDetectionFromEnv(t *testing.T) {
+	t.Setenv("NSM_ENABLE_ANOMALY_DETECTION", "true")
+	t.Setenv("NSM_ANOMALY_INTERVAL_SEC", "10")
+	t.Setenv("NSM_ANOMALY_SPIKE_FACTOR", "8")
+	t.Setenv("NSM_ANOMALY_WEBHOOK_URL", "https://alerts.example.com/nsm")
+	cfg, err := LoadConfig("")
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if !cfg.EnableAnomalyDetection || cfg.AnomalyIntervalSec != 10 || cfg.AnomalySpikeFactor != 8 || cfg.AnomalyWebhookURL != "https://alerts.example.com/nsm" {
+		t.Errorf("unexpected anomaly detection config: %t %d %d %q",
+			cfg.EnableAnomalyDetection, cfg.AnomalyIntervalSec, cfg.AnomalySpikeFactor, cfg.AnomalyWebhookURL)
+	}
+
+	t.Setenv("NSM_ANOMALY_WEBHOOK_URL", "alerts.example.com")
+	if _, err := LoadConfig(""); err == nil {
+		t.Error("LoadConfig() accepted a webhook URL without a scheme")
+	}
+	t.Setenv("NSM_ANOMALY_WEBHOOK_URL", "")
+	t.Setenv("NSM_ANOMALY_SPIKE_FACTOR", "1")
+	if _, err := LoadConfig(""); err == nil {
+		t.Error("LoadConfig() accepted a spike factor below 2")
+	}
+}
+
+func TestEndpointRegistryFromEnv(t *testing.T) {
+	t.Setenv("NSM_ENABLE_ENDPOINT_REGISTRY", "true")
+	t.Setenv("NSM_ENDPOINT_REGISTRY_INTERVAL_SEC", "15")
+	cfg, err := LoadConfig("")
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if !cfg.EnableEndpointRegistry || cfg.EndpointRegistryIntervalSec != 15 {
+		t.Errorf("unexpected endpoint registry config: %t %d", cfg.EnableEndpointRegistry, cfg.EndpointRegistryIntervalSec)
+	}
+
+	t.Setenv("NSM_ENDPOINT_REGISTRY_INTERVAL_SEC", "0")
+	if _, err := LoadConfig(""); err == nil {
+		t.Error("LoadConfig() accepted a registry interval of 0")
+	}
+}
+
+func TestVFSocketFromEnv(t *testing.T) {
+	cfg, err := LoadConfig("")
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if cfg.VFSocket != "/var/run/nsm/vf.sock" {
+		t.Errorf("VF socket = %q, want the default", cfg.VFSocket)
+	}
+
+	t.Setenv("NSM_VF_SOCKET", "")
+	cfg, err = LoadConfig("")
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if cfg.VFSocket != "" {
+		t.Errorf("VF socket = %q, want it disabled", cfg.VFSocket)
+	}
+
+	t.Setenv("NSM_VF_SOCKET", "vf.sock")
+	if _, err := LoadConfig(""); err == nil {
+		t.Errorf("expected an error for a relative VF socket")
+	}
+}
+
+func TestSRIOVOperatorCoexistenceFromEnv(t *testing.T) {
+	cfg, err := LoadConfig("")
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if !cfg.EnableSRIOVOperatorCoexistence || cfg.SRIOVOperatorNodeName != "" {
+		t.Errorf("unexpected SR-IOV operator defaults: %v, %q", cfg.EnableSRIOVOperatorCoexistence, cfg.SRIOVOperatorNodeName)
+	}
+
+	t.Setenv("NSM_ENABLE_SRIOV_OPERATOR_COEXISTENCE", "false")
+	t.Setenv("NSM_SRIOV_OPERATOR_NODE_NAME", "worker-1")
+	cfg, err = LoadConfig("")
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if cfg.EnableSRIOVOperatorCoexistence {
+		t.Error("SR-IOV operator coexistence not disabled")
+	}
+	if cfg.SRIOVOperatorNodeName != "worker-1" {
+		t.Errorf("SR-IOV operator node name = %q, want worker-1", cfg.SRIOVOperatorNodeName)
+	}
+}
+
+func TestDPDKNodeNameFromEnv(t *testing.T) {
+	cfg, err := LoadConfig("")
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if cfg.DPDKNodeName != "" {
+		t.Errorf("unexpected DPDK node name default: %q", cfg.DPDKNodeName)
+	}
+
+	t.Setenv("NSM_DPDK_NODE_NAME", "worker-1")
+	cfg, err = LoadConfig("")
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if cfg.DPDKNodeName != "worker-1" {
+		t.Errorf("DPDK node name = %q, want worker-1", cfg.DPDKNodeName)
+	}
+}
+
+func TestKubeVirtFromEnv(t *testing.T) {
+	cfg, err := LoadConfig("")
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if cfg.EnableKubeVirt || cfg.KubeVirtNodeName != "" {
+		t.Errorf("unexpected KubeVirt defaults: %v, %q", cfg.EnableKubeVirt, cfg.KubeVirtNodeName)
+	}
+
+	// the VMs are allocated VFs
+	t.Setenv("NSM_ENABLE_KUBEVIRT", "true")
+	if _, err := LoadConfig(""); err == nil {
+		t.Error("LoadConfig() accepted KubeVirt support without SR-IOV")
+	}
+
+	t.Setenv("NSM_ENABLE_SRIOV", "true")
+	t.Setenv("NSM_KUBEVIRT_NODE_NAME", "worker-1")
+	cfg, err = LoadConfig("")
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if !cfg.EnableKubeVirt {
+		t.Error("KubeVirt support not enabled")
+	}
+	if cfg.KubeVirtNodeName != "worker-1" {
+		t.Errorf("KubeVirt node name = %q, want worker-1", cfg.KubeVirtNodeName)
+	}
+}
+
+func TestLeaderElectionFromEnv(t *testing.T) {
+	cfg, err := LoadConfig("")
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if cfg.EnableLeaderElection || cfg.LeaderElectionNamespace != "nsm-system" || cfg.LeaderElectionLeaseDurationSec != 15 {
+		t.Errorf("unexpected leader election defaults: %v, %q, %d",
+			cfg.EnableLeaderElection, cfg.LeaderElectionNamespace, cfg.LeaderElectionLeaseDurationSec)
+	}
+
+	t.Setenv("NSM_ENABLE_LEADER_ELECTION", "true")
+	t.Setenv("NSM_LEADER_ELECTION_NAMESPACE", "edge-system")
+	t.Setenv("NSM_LEADER_ELECTION_LEASE_DURATION_SEC", "30")
+	cfg, err = LoadConfig("")
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if !cfg.EnableLeaderElection || cfg.LeaderElectionNamespace != "edge-system" || cfg.LeaderElectionLeaseDurationSec != 30 {
+		t.Errorf("leader election = %v, %q, %d, want enabled in edge-system for 30s",
+			cfg.EnableLeaderElection, cfg.LeaderElectionNamespace, cfg.LeaderElectionLeaseDurationSec)
+	}
+
+	t.Setenv("NSM_LEADER_ELECTION_LEASE_DURATION_SEC", "2")
+	if _, err := LoadConfig(""); err == nil {
+		t.Error("LoadConfig() accepted a 2s leader election lease")
+	}
+
+	t.Setenv("NSM_LEADER_ELECTION_LEASE_DURATION_SEC", "15")
+	t.Setenv("NSM_ENABLE_SHARDING", "true")
+	if _, err := LoadConfig(""); err == nil {
+		t.Error("LoadConfig() accepted leader election with sharding")
+	}
+}
+
+func TestVhostUserFromEnv(t *testing.T) {
+	cfg, err := LoadConfig("")
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if cfg.VhostUserDataplane != "" || cfg.VhostUserSocketDir != "/var/run/nsm/vhost-user" {
+		t.Errorf("unexpected vhost-user defaults: %q, %q", cfg.VhostUserDataplane, cfg.VhostUserSocketDir)
+	}
+
+	t.Setenv("NSM_VHOST_USER_DATAPLANE", "OVS")
+	t.Setenv("NSM_VHOST_USER_SOCKET_DIR", "/run/vhost")
+	t.Setenv("NSM_VHOST_USER_BRIDGE", "br-edge")
+	cfg, err = LoadConfig("")
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if cfg.VhostUserDataplane != "ovs" || cfg.VhostUserSocketDir != "/run/vhost" || cfg.VhostUserBridge != "br-edge" {
+		t.Errorf("vhost-user = %q, %q, %q, want ovs, /run/vhost, br-edge",
+			cfg.VhostUserDataplane, cfg.VhostUserSocketDir, cfg.VhostUserBridge)
+	}
+
+	// VPP bridges are bridge domain IDs
+	t.Setenv("NSM_VHOST_USER_DATAPLANE", "vpp")
+	if _, err := LoadConfig(""); err == nil {
+		t.Error("LoadConfig() accepted a named VPP bridge domain")
+	}
+	t.Setenv("NSM_VHOST_USER_BRIDGE", "10")
+	if _, err := LoadConfig(""); err != nil {
+		t.Errorf("LoadConfig() error = %v", err)
+	}
+
+	t.Setenv("NSM_VHOST_USER_DATAPLANE", "snabb")
+	if _, err := LoadConfig(""); err == nil {
+		t.Error("LoadConfig() accepted an unknown dataplane")
+	}
+
+	t.Setenv("NSM_VHOST_USER_DATAPLANE", "ovs")
+	t.Setenv("NSM_VHOST_USER_SOCKET_DIR", "vhost")
+	if _, err := LoadConfig(""); err == nil {
+		t.Error("LoadConfig() accepted a relative socket directory")
+	}
+}
+
+func TestHealthListenAddrFromEnv(t *testing.T) {
+	cfg, err := LoadConfig("")
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if cfg.HealthListenAddr != ":8081" {
+		t.Errorf("health listen address = %q, want :8081", cfg.HealthListenAddr)
+	}
+
+	t.Setenv("NSM_HEALTH_LISTEN_ADDR", "")
+	cfg, err = LoadConfig("")
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if cfg.HealthListenAddr != "" {
+		t.Errorf("health listen address = %q, want it disabled", cfg.HealthListenAddr)
+	}
+
+	t.Setenv("NSM_HEALTH_LISTEN_ADDR", "8081")
+	if _, err := LoadConfig(""); err == nil {
+		t.Error("LoadConfig() accepted a health address without a port")
+	}
+}
+
+func TestDataplaneFromEnv(t *testing.T) {
+	cfg, err := LoadConfig("")
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if cfg.Dataplane != "kernel" {
+		t.Errorf("dataplane = %q, want kernel", cfg.Dataplane)
+	}
+
+	t.Setenv("NSM_DATAPLANE", "VPP")
+	if _, err := LoadConfig(""); err == nil {
+		t.Error("LoadConfig() accepted the vpp dataplane without an uplink")
+	}
+
+	t.Setenv("NSM_VPP_UPLINK", "TenGigabitEthernet3b/0/0")
+	cfg, err = LoadConfig("")
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if cfg.Dataplane != "vpp" || cfg.VPPUplink != "TenGigabitEthernet3b/0/0" {
+		t.Errorf("dataplane = %q over %q, want vpp over TenGigabitEthernet3b/0/0", cfg.Dataplane, cfg.VPPUplink)
+	}
+
+	t.Setenv("NSM_DATAPLANE", "dpdk")
+	if _, err := LoadConfig(""); err == nil {
+		t.Error("LoadConfig() accepted an unknown dataplane")
+	}
+}
+
+func TestUplinkCostsFromEnv(t *testing.T) {
+	cfg, err := LoadConfig("")
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if len(cfg.UplinkCosts) != 0 {
+		t.Errorf("uplink costs = %+v, want none", cfg.UplinkCosts)
+	}
+
+	t.Setenv("NSM_UPLINK_COSTS", "wwan0=0.5:100, eth0=0")
+	cfg, err = LoadConfig("")
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	want := []UplinkCost{{Device: "wwan0", CostPerGB: 0.5, MonthlyBudget: 100}, {Device: "eth0"}}
+	if len(cfg.UplinkCosts) != 2 || cfg.UplinkCosts[0] != want[0] || cfg.UplinkCosts[1] != want[1] {
+		t.Errorf("uplink costs = %+v, want %+v", cfg.UplinkCosts, want)
+	}
+
+	t.Setenv("NSM_UPLINK_COSTS", "wwan0=0.5,wwan0=1")
+	if _, err := LoadConfig(""); err == nil {
+		t.Error("LoadConfig() accepted duplicate uplink costs")
+	}
+
+	t.Setenv("NSM_UPLINK_COSTS", "wwan0=-1")
+	if _, err := LoadConfig(""); err == nil {
+		t.Error("LoadConfig() accepted a negative uplink cost")
+	}
+}
+
+func TestLoadConfigYAML(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	data := `qosPriority: low
+uplinkCosts:
+  - device: wwan0
+    costPerGB: 0.5
+    monthlyBudget: 100
+`
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if cfg.QoSPriority != "low" || len(cfg.UplinkCosts) != 1 || cfg.UplinkCosts[0].MonthlyBudget != 100 {
+		t.Errorf("config = %s, %+v, want the settings of the file", cfg.QoSPriority, cfg.UplinkCosts)
+	}
+	if cfg.FailoverStrategy != "balanced" {
+		t.Errorf("failover strategy = %s, want the default kept", cfg.FailoverStrategy)
+	}
+	// the controller reloads the file the config was loaded from
+	if cfg.File != path {
+		t.Errorf("file = %q, want %q", cfg.File, path)
+	}
+
+	// the environment overrides the file
+	t.Setenv("NSM_QOS_PRIORITY", "medium")
+	if cfg, err := LoadConfig(path); err != nil || cfg.QoSPriority != "medium" {
+		t.Errorf("QoS priority = %v (%v), want medium from the environment", cfg, err)
+	}
+
+	// without a known extension the content tells the format
+	path = filepath.Join(dir, "config")
+	if err := os.WriteFile(path, []byte("edgeNodeId: edge-7\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if cfg, err := LoadConfig(path); err != nil || cfg.EdgeNodeID != "edge-7" {
+		t.Errorf("LoadConfig() of YAML without extension = %v, %v", cfg, err)
+	}
+}
+
+func TestLoadConfigRejectsUnknownSettings(t *testing.T) {
+	dir := t.TempDir()
+	for name, data := range map[string]string{
+		"config.json": `{"qosPriorty": "low"}`,
+		"config.yaml": "qosPriorty: low\n",
+		"config":      `{"qosPriority": "low", "extra": true}`,
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadConfig(path); err == nil || !strings.Contains(err.Error(), "unknown field") {
+			t.Errorf("LoadConfig(%s) error = %v, want an unknown field", name, err)
+		}
+	}
+}
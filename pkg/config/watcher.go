@@ -0,0 +1,133 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/sirupsen/logrus"
+)
+
+// reloadDelay lets the writes of a config file settle before it is
+// reloaded, editors and ConfigMap updates touch it several times
+const reloadDelay = 500 * time.Millisecond
+
+// Watcher reloads the config file when it changes or the process receives
+// SIGHUP, and hands every valid config to its reload function. An invalid
+// config is logged and dropped, the current one stays in effect.
+type Watcher struct {
+	// Context for cancellation
+	ctx context.Context
+	// Logger
+	logger *logrus.Logger
+	// Config file watched
+	path string
+	// Applies a new valid config
+	reload func(cfg *Config)
+	// Signals requesting a reload
+	signals chan os.Signal
+}
+
+// NewWatcher creates a new config watcher
+func NewWatcher(ctx context.Context, logger *logrus.Logger, path string, reload func(cfg *Config)) *Watcher {
+	return &Watcher{
+		ctx:     ctx,
+		logger:  logger,
+		path:    filepath.Clean(path),
+		reload:  reload,
+		signals: make(chan os.Signal, 1),
+	}
+}
+
+// Start watches the config file and SIGHUP until the context is done. The
+// directory of the file is watched, so the file being replaced (e.g., the
+// symlink swap of a ConfigMap volume) is seen as well.
+func (w *Watcher) Start() error {
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create config file watcher: %w", err)
+	}
+	defer fsw.Close()
+	if err := fsw.Add(filepath.Dir(w.path)); err != nil {
+		return fmt.Errorf("failed to watch %s: %w", filepath.Dir(w.path), err)
+	}
+
+	signal.Notify(w.signals, syscall.SIGHUP)
+	defer signal.Stop(w.signals)
+	w.logger.Infof("Watching config file %s, reloading it on changes and SIGHUP", w.path)
+
+	var settle <-chan time.Time
+	for {
+		select {
+		case event, ok := <-fsw.Events:
+			if !ok {
+				return nil
+			}
+			if w.affects(event) {
+				settle = time.After(reloadDelay)
+			}
+
+		case err, ok := <-fsw.Errors:
+			if !ok {
+				return nil
+			}
+			w.logger.WithError(err).Warn("Config file watch error")
+
+		case <-w.signals:
+			w.logger.Info("Received SIGHUP, reloading config")
+			_ = w.Reload()
+
+		case <-settle:
+			settle = nil
+			w.logger.Infof("Config file %s changed, reloading it", w.path)
+			_ = w.Reload()
+
+		case <-w.ctx.Done():
+			return nil
+		}
+	}
+}
+
+// Reload loads the config file and applies it if it is valid
+func (w *Watcher) Reload() error {
+	cfg, err := LoadConfig(w.path)
+	if err != nil {
+		w.logger.WithError(err).Errorf("Rejected config %s, keeping the current one", w.path)
+		return err
+	}
+	w.reload(cfg)
+	return nil
+}
+
+// affects reports whether a change in the directory of the config file
+// may have changed it
+func (w *Watcher) affects(event fsnotify.Event) bool {
+	if !event.Has(fsnotify.Write) && !event.Has(fsnotify.Create) && !event.Has(fsnotify.Rename) {
+		return false
+	}
+	name := filepath.Clean(event.Name)
+	// ConfigMap volumes swap the ..data symlink the file points through
+	return name == w.path || filepath.Base(name) == "..data"
+}
+
+// Diff returns the settings that differ between two configs, by their
+// name in the config file
+func Diff(old, new *Config) []string {
+	var changed []string
+	ov, nv := reflect.ValueOf(old).Elem(), reflect.ValueOf(new).Elem()
+	for i := 0; i < ov.NumField(); i++ {
+		if reflect.DeepEqual(ov.Field(i).Interface(), nv.Field(i).Interface()) {
+			continue
+		}
+		name, _, _ := strings.Cut(ov.Type().Field(i).Tag.Get("json"), ",")
+		changed = append(changed, name)
+	}
+	return changed
+}
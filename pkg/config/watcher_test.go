@@ -0,0 +1,108 @@
+package config
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func quietLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return logger
+}
+
+func TestDiff(t *testing.T) {
+	old := DefaultConfig()
+	cfg := DefaultConfig()
+	if changed := Diff(old, cfg); len(changed) != 0 {
+		t.Errorf("Diff() of equal configs = %v", changed)
+	}
+
+	cfg.QoSPriority = "low"
+	cfg.QoSInterfaces = []string{"eth0"}
+	changed := Diff(old, cfg)
+	if len(changed) != 2 || changed[0] != "qosPriority" || changed[1] != "qosInterfaces" {
+		t.Errorf("Diff() = %v, want qosPriority and qosInterfaces", changed)
+	}
+}
+
+func TestWatcherReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{"qosPriority": "low"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded := make(chan *Config, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w := NewWatcher(ctx, quietLogger(), path, func(cfg *Config) { reloaded <- cfg })
+
+	if err := w.Reload(); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	if cfg := <-reloaded; cfg.QoSPriority != "low" {
+		t.Errorf("reloaded QoS priority %s, want low", cfg.QoSPriority)
+	}
+
+	// an invalid config isn't handed over
+	if err := os.WriteFile(path, []byte(`{"qosPriority": "urgent"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Reload(); err == nil {
+		t.Errorf("Reload() accepted an invalid QoS priority")
+	}
+	select {
+	case cfg := <-reloaded:
+		t.Errorf("invalid config reloaded: %+v", cfg)
+	default:
+	}
+}
+
+func TestWatcherFileChange(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded := make(chan *Config, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	w := NewWatcher(ctx, quietLogger(), path, func(cfg *Config) {
+		select {
+		case reloaded <- cfg:
+		default:
+		}
+	})
+	done := make(chan error, 1)
+	go func() { done <- w.Start() }()
+	defer func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Start() error = %v", err)
+		}
+	}()
+
+	// the watch may not be set up yet, so the file is rewritten until the
+	// change is seen
+	deadline := time.After(5 * time.Second)
+	for {
+		if err := os.WriteFile(path, []byte(`{"qosPriority": "medium"}`), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case cfg := <-reloaded:
+			if cfg.QoSPriority != "medium" {
+				t.Errorf("reloaded QoS priority %s, want medium", cfg.QoSPriority)
+			}
+			return
+		case <-time.After(2 * reloadDelay):
+		case <-deadline:
+			t.Fatalf("config file change not reloaded")
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package connection
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// AnnotationAcceptFrom lists the namespaces allowed to connect into a
+// namespace or NetworkService, comma-separated or * for any. On a
+// Namespace it accepts connections to all its pods and services, on a
+// NetworkService only to that service.
+const AnnotationAcceptFrom = "nsm.akosrbn.io/accept-connections-from"
+
+// ReasonNotAccepted is reported while a connection into another namespace
+// isn't accepted there
+const ReasonNotAccepted = "NotAccepted"
+
+// AuthorizationError tells which namespace didn't accept a connection
+type AuthorizationError struct {
+	// Namespace the connection reaches into
+	Namespace string
+	// Human-readable message telling how to accept the connection
+	Message string
+}
+
+func (e *AuthorizationError) Error() string {
+	return e.Message
+}
+
+// Destination returns the namespace and name of the destination service
+// of a connection. A destination of the form namespace/service targets a
+// service in another namespace, a plain name one in the connection's.
+func Destination(conn *nsmv1.NetworkConnection) (string, string) {
+	if namespace, name, ok := strings.Cut(conn.Spec.Destination, "/"); ok {
+		return namespace, name
+	}
+	return conn.Namespace, conn.Spec.Destination
+}
+
+// Authorize returns an *AuthorizationError unless every namespace a
+// connection reaches into besides its own accepted it: the namespace of
+// the source pod, and that of the destination service. Tenants thereby
+// can't connect into each other unilaterally.
+func Authorize(ctx context.Context, c client.Reader, conn *nsmv1.NetworkConnection) error {
+	if namespace, _, ok := strings.Cut(conn.Spec.Source, "/"); ok && namespace != conn.Namespace {
+		accepted, err := namespaceAccepts(ctx, c, namespace, conn.Namespace)
+		if err != nil {
+			return err
+		}
+		if !accepted {
+			return &AuthorizationError{
+				Namespace: namespace,
+				Message: fmt.Sprintf("source pod %s is in namespace %s, which doesn't accept connections from %s: annotate the namespace with %s",
+					conn.Spec.Source, namespace, conn.Namespace, AnnotationAcceptFrom),
+			}
+		}
+	}
+
+	namespace, name := Destination(conn)
+	if namespace == conn.Namespace {
+		return nil
+	}
+	var svc nsmv1.NetworkService
+	err := c.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, &svc)
+	if client.IgnoreNotFound(err) != nil {
+		return fmt.Errorf("failed to get service %s/%s: %w", namespace, name, err)
+	}
+	if err == nil && accepts(svc.Annotations, conn.Namespace) {
+		return nil
+	}
+	accepted, err := namespaceAccepts(ctx, c, namespace, conn.Namespace)
+	if err != nil {
+		return err
+	}
+	if !accepted {
+		return &AuthorizationError{
+			Namespace: namespace,
+			Message: fmt.Sprintf("service %s/%s doesn't accept connections from namespace %s: annotate the service or its namespace with %s",
+				namespace, name, conn.Namespace, AnnotationAcceptFrom),
+		}
+	}
+	return nil
+}
+
+// namespaceAccepts tells whether a namespace accepts connections from another
+func namespaceAccepts(ctx context.Context, c client.Reader, namespace, from string) (bool, error) {
+	var ns corev1.Namespace
+	if err := c.Get(ctx, client.ObjectKey{Name: namespace}, &ns); err != nil {
+		if client.IgnoreNotFound(err) != nil {
+			return false, fmt.Errorf("failed to get namespace %s: %w", namespace, err)
+		}
+		return false, nil
+	}
+	return accepts(ns.Annotations, from), nil
+}
+
+// accepts tells whether the accept annotation lists a namespace
+func accepts(annotations map[string]string, from string) bool {
+	for _, namespace := range strings.Split(annotations[AnnotationAcceptFrom], ",") {
+		if namespace = strings.TrimSpace(namespace); namespace == "*" || namespace == from {
+			return true
+		}
+	}
+	return false
+}
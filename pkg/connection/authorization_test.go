@@ -0,0 +1,76 @@
+package connection
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestAuthorize(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatalf("failed to build scheme: %v", err)
+	}
+	if err := nsmv1.AddToScheme(scheme); err != nil {
+		t.Fatalf("failed to build scheme: %v", err)
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "tenant-b"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "shared", Annotations: map[string]string{AnnotationAcceptFrom: "*"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "pods", Annotations: map[string]string{AnnotationAcceptFrom: "tenant-c, tenant-a"}}},
+		&nsmv1.NetworkService{ObjectMeta: metav1.ObjectMeta{Namespace: "tenant-b", Name: "public", Annotations: map[string]string{AnnotationAcceptFrom: "tenant-a"}}},
+		&nsmv1.NetworkService{ObjectMeta: metav1.ObjectMeta{Namespace: "tenant-b", Name: "private"}},
+	).Build()
+
+	tests := []struct {
+		name          string
+		source        string
+		destination   string
+		wantNamespace string
+	}{
+		{"same namespace", "tenant-a/pod", "vision", ""},
+		{"accepted by the service", "tenant-a/pod", "tenant-b/public", ""},
+		{"not accepted by the service", "tenant-a/pod", "tenant-b/private", "tenant-b"},
+		{"accepted by the namespace", "tenant-a/pod", "shared/anything", ""},
+		{"unknown namespace", "tenant-a/pod", "nowhere/svc", "nowhere"},
+		{"source pod accepted", "pods/camera", "vision", ""},
+		{"source pod not accepted", "tenant-b/camera", "vision", "tenant-b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := &nsmv1.NetworkConnection{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "tenant-a", Name: "conn"},
+				Spec:       nsmv1.NetworkConnectionSpec{Source: tt.source, Destination: tt.destination},
+			}
+			err := Authorize(context.Background(), c, conn)
+			if tt.wantNamespace == "" {
+				if err != nil {
+					t.Errorf("Authorize() error = %v", err)
+				}
+				return
+			}
+			var authErr *AuthorizationError
+			if !errors.As(err, &authErr) || authErr.Namespace != tt.wantNamespace {
+				t.Errorf("Authorize() error = %v, want rejection by %s", err, tt.wantNamespace)
+			}
+		})
+	}
+}
+
+func TestDestination(t *testing.T) {
+	conn := &nsmv1.NetworkConnection{ObjectMeta: metav1.ObjectMeta{Namespace: "edge"}, Spec: nsmv1.NetworkConnectionSpec{Destination: "vision"}}
+	if ns, name := Destination(conn); ns != "edge" || name != "vision" {
+		t.Errorf("Destination() = %s, %s", ns, name)
+	}
+	conn.Spec.Destination = "shared/vision"
+	if ns, name := Destination(conn); ns != "shared" || name != "vision" {
+		t.Errorf("Destination() = %s, %s", ns, name)
+	}
+}
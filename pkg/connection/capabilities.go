@@ -0,0 +1,149 @@
+package connection
+
+import (
+	"fmt"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/config"
+)
+
+// Reasons reported when a connection type can't be served
+const (
+	ReasonUnknownConnectionType = "UnknownConnectionType"
+	ReasonSRIOVDisabled         = "SRIOVDisabled"
+	ReasonDPDKDisabled          = "DPDKDisabled"
+	ReasonVhostUserDisabled     = "VhostUserDisabled"
+)
+
+// Capabilities describes the datapath subsystems enabled on this node
+type Capabilities struct {
+	// SR-IOV Virtual Functions can be allocated
+	SRIOV bool
+	// DPDK interfaces can be allocated
+	DPDK bool
+	// Fallback datapath (macvlan, ipvlan) for sriov connections when
+	// SR-IOV is unavailable, empty to reject them instead
+	Fallback string
+	// Userspace dataplane vhost-user ports are added to, empty if none
+	VhostUser string
+	// Inline crypto offloads (ipsec, tls) of the uplink NIC
+	CryptoOffload map[string]bool
+	// Userspace dataplane backends in order of preference, the kernel
+	// serves the datapaths none of them does
+	Dataplanes []Dataplane
+}
+
+// Selection is the datapath chosen for a connection
+type Selection struct {
+	// Datapath serving the connection
+	Datapath string
+	// Whether it is a non-accelerated fallback
+	Fallback bool
+	// Dataplane backend forwarding the connection
+	Dataplane string
+}
+
+// CapabilityError explains why a connection type is not supported
+type CapabilityError struct {
+	// Machine-readable reason, used as condition reason
+	Reason string
+	// Human-readable message telling what to enable
+	Message string
+}
+
+func (e *CapabilityError) Error() string {
+	return e.Message
+}
+
+// CapabilitiesFromConfig derives the capabilities from the NSM configuration
+func CapabilitiesFromConfig(cfg *config.Config) Capabilities {
+	caps := Capabilities{
+		SRIOV:      cfg.EnableSRIOV,
+		DPDK:       cfg.EnableDPDK,
+		VhostUser:  cfg.VhostUserDataplane,
+		Dataplanes: DataplanesFromConfig(cfg),
+	}
+	if cfg.FallbackDatapath != "none" {
+		caps.Fallback = cfg.FallbackDatapath
+	}
+	return caps
+}
+
+// Resolve selects the datapath for the connection type. SR-IOV connections
+// fall back to the non-accelerated datapath when SR-IOV is unavailable, so
+// small edge gateways without SR-IOV NICs can still serve them.
+func (c Capabilities) Resolve(connectionType string) (Selection, error) {
+	if connectionType == nsmv1.ConnectionTypeSRIOV && !c.SRIOV && c.Fallback != "" {
+		return Selection{Datapath: c.Fallback, Fallback: true}, nil
+	}
+	if err := c.Check(connectionType); err != nil {
+		return Selection{}, err
+	}
+	return Selection{Datapath: connectionType}, nil
+}
+
+// Select selects the datapath of a connection and negotiates the
+// dataplane backend forwarding it
+func (c Capabilities) Select(spec nsmv1.NetworkConnectionSpec) (Selection, error) {
+	selection, err := c.Resolve(spec.ConnectionType)
+	if err != nil {
+		return Selection{}, err
+	}
+	if selection.Dataplane, err = c.Negotiate(selection.Datapath, spec); err != nil {
+		return Selection{}, err
+	}
+	return selection, nil
+}
+
+// EncryptionMode tells how a connection is encrypted: inline on the NIC when
+// it offloads the requested protocol, on the CPU otherwise. It returns an
+// empty string for unencrypted connections.
+func (c Capabilities) EncryptionMode(spec nsmv1.NetworkConnectionSpec) string {
+	switch {
+	case spec.Encryption != "" && c.CryptoOffload[spec.Encryption]:
+		return nsmv1.EncryptionModeOffload
+	case spec.Encryption != "", spec.ConnectionType == nsmv1.ConnectionTypeWireGuard:
+		// no NIC offloads the WireGuard ChaCha20-Poly1305 construction
+		return nsmv1.EncryptionModeSoftware
+	default:
+		return ""
+	}
+}
+
+// Check returns a *CapabilityError if the connection type requires a
+// subsystem that is disabled, or if the type is unknown
+func (c Capabilities) Check(connectionType string) error {
+	switch connectionType {
+	case nsmv1.ConnectionTypeKernel, nsmv1.ConnectionTypeVXLAN, nsmv1.ConnectionTypeWireGuard:
+		return nil
+	case nsmv1.ConnectionTypeSRIOV:
+		if !c.SRIOV {
+			return &CapabilityError{
+				Reason:  ReasonSRIOVDisabled,
+				Message: "connection type sriov requires SR-IOV support, set enableSRIOV (NSM_ENABLE_SRIOV=true) on the node",
+			}
+		}
+		return nil
+	case nsmv1.ConnectionTypeDPDK:
+		if !c.DPDK {
+			return &CapabilityError{
+				Reason:  ReasonDPDKDisabled,
+				Message: "connection type dpdk requires DPDK support, set enableDPDK (NSM_ENABLE_DPDK=true) on the node",
+			}
+		}
+		return nil
+	case nsmv1.ConnectionTypeVhostUser:
+		if c.VhostUser == "" {
+			return &CapabilityError{
+				Reason:  ReasonVhostUserDisabled,
+				Message: "connection type vhostuser requires a userspace dataplane, set vhostUserDataplane (NSM_VHOST_USER_DATAPLANE=ovs or vpp) on the node",
+			}
+		}
+		return nil
+	default:
+		return &CapabilityError{
+			Reason:  ReasonUnknownConnectionType,
+			Message: fmt.Sprintf("unknown connection type: %s, must be one of: kernel, sriov, dpdk, vxlan, wireguard, vhostuser", connectionType),
+		}
+	}
+}
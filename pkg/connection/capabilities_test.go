@@ -0,0 +1,123 @@
+package connection
+
+import (
+	"errors"
+	"testing"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/config"
+)
+
+func TestCapabilitiesCheck(t *testing.T) {
+	tests := []struct {
+		name       string
+		caps       Capabilities
+		connType   string
+		wantReason string
+	}{
+		{"kernel always works", Capabilities{}, nsmv1.ConnectionTypeKernel, ""},
+		{"wireguard always works", Capabilities{}, nsmv1.ConnectionTypeWireGuard, ""},
+		{"sriov disabled", Capabilities{DPDK: true}, nsmv1.ConnectionTypeSRIOV, ReasonSRIOVDisabled},
+		{"sriov enabled", Capabilities{SRIOV: true}, nsmv1.ConnectionTypeSRIOV, ""},
+		{"dpdk disabled", Capabilities{SRIOV: true}, nsmv1.ConnectionTypeDPDK, ReasonDPDKDisabled},
+		{"dpdk enabled", Capabilities{DPDK: true}, nsmv1.ConnectionTypeDPDK, ""},
+		{"vhostuser without dataplane", Capabilities{DPDK: true}, nsmv1.ConnectionTypeVhostUser, ReasonVhostUserDisabled},
+		{"vhostuser with dataplane", Capabilities{VhostUser: "ovs"}, nsmv1.ConnectionTypeVhostUser, ""},
+		{"unknown type", Capabilities{SRIOV: true, DPDK: true}, "carrier-pigeon", ReasonUnknownConnectionType},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.caps.Check(tt.connType)
+			if tt.wantReason == "" {
+				if err != nil {
+					t.Fatalf("Check() error = %v, want nil", err)
+				}
+				return
+			}
+
+			var capErr *CapabilityError
+			if !errors.As(err, &capErr) {
+				t.Fatalf("Check() error = %v, want *CapabilityError", err)
+			}
+			if capErr.Reason != tt.wantReason {
+				t.Errorf("reason = %s, want %s", capErr.Reason, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestCapabilitiesFromConfig(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.EnableSRIOV = true
+
+	caps := CapabilitiesFromConfig(cfg)
+	if !caps.SRIOV || caps.DPDK {
+		t.Errorf("unexpected capabilities: %+v", caps)
+	}
+}
+
+func TestCapabilitiesResolve(t *testing.T) {
+	tests := []struct {
+		name         string
+		caps         Capabilities
+		connType     string
+		wantDatapath string
+		wantFallback bool
+		wantErr      bool
+	}{
+		{"sriov available", Capabilities{SRIOV: true, Fallback: "macvlan"}, nsmv1.ConnectionTypeSRIOV, "sriov", false, false},
+		{"sriov falls back", Capabilities{Fallback: "macvlan"}, nsmv1.ConnectionTypeSRIOV, "macvlan", true, false},
+		{"sriov without fallback", Capabilities{}, nsmv1.ConnectionTypeSRIOV, "", false, true},
+		{"dpdk never falls back", Capabilities{Fallback: "ipvlan"}, nsmv1.ConnectionTypeDPDK, "", false, true},
+		{"kernel", Capabilities{Fallback: "ipvlan"}, nsmv1.ConnectionTypeKernel, "kernel", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.caps.Resolve(tt.connType)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Resolve() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got.Datapath != tt.wantDatapath || got.Fallback != tt.wantFallback {
+				t.Errorf("Resolve() = %+v, want %s (fallback %t)", got, tt.wantDatapath, tt.wantFallback)
+			}
+		})
+	}
+}
+
+func TestCapabilitiesFallbackFromConfig(t *testing.T) {
+	cfg := config.DefaultConfig()
+	if caps := CapabilitiesFromConfig(cfg); caps.Fallback != "macvlan" {
+		t.Errorf("fallback = %q, want macvlan by default", caps.Fallback)
+	}
+
+	cfg.FallbackDatapath = "none"
+	if caps := CapabilitiesFromConfig(cfg); caps.Fallback != "" {
+		t.Errorf("fallback = %q, want disabled", caps.Fallback)
+	}
+}
+
+func TestCapabilitiesEncryptionMode(t *testing.T) {
+	offload := Capabilities{CryptoOffload: map[string]bool{nsmv1.EncryptionIPsec: true}}
+	tests := []struct {
+		name string
+		caps Capabilities
+		spec nsmv1.NetworkConnectionSpec
+		want string
+	}{
+		{"unencrypted", offload, nsmv1.NetworkConnectionSpec{ConnectionType: nsmv1.ConnectionTypeKernel}, ""},
+		{"ipsec offloaded", offload, nsmv1.NetworkConnectionSpec{ConnectionType: nsmv1.ConnectionTypeVXLAN, Encryption: nsmv1.EncryptionIPsec}, nsmv1.EncryptionModeOffload},
+		{"tls not offloaded", offload, nsmv1.NetworkConnectionSpec{ConnectionType: nsmv1.ConnectionTypeKernel, Encryption: nsmv1.EncryptionTLS}, nsmv1.EncryptionModeSoftware},
+		{"ipsec without offload", Capabilities{}, nsmv1.NetworkConnectionSpec{ConnectionType: nsmv1.ConnectionTypeVXLAN, Encryption: nsmv1.EncryptionIPsec}, nsmv1.EncryptionModeSoftware},
+		{"wireguard", offload, nsmv1.NetworkConnectionSpec{ConnectionType: nsmv1.ConnectionTypeWireGuard}, nsmv1.EncryptionModeSoftware},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.caps.EncryptionMode(tt.spec); got != tt.want {
+				t.Errorf("EncryptionMode() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package connection
+
+import (
+	"context"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+)
+
+// Datapath programs the host networking of connections
+type Datapath interface {
+	// Setup establishes the datapath of the connection
+	Setup(ctx context.Context, conn *nsmv1.NetworkConnection) error
+	// Teardown removes the datapath of the connection. With keepAllocations
+	// the resources backing it (VFs, addresses) stay reserved for the
+	// connection, so it can be brought up again without reallocating.
+	Teardown(ctx context.Context, conn *nsmv1.NetworkConnection, keepAllocations bool) error
+}
+
+// Rekeyer rotates the keys of encrypted connections
+type Rekeyer interface {
+	// Rekey installs fresh keys for the connection without interrupting it
+	Rekey(ctx context.Context, conn *nsmv1.NetworkConnection) error
+}
+
+// NopDatapath is a Datapath that doesn't program anything
+type NopDatapath struct{}
+
+// Setup does nothing
+func (NopDatapath) Setup(ctx context.Context, conn *nsmv1.NetworkConnection) error {
+	return nil
+}
+
+// Teardown does nothing
+func (NopDatapath) Teardown(ctx context.Context, conn *nsmv1.NetworkConnection, keepAllocations bool) error {
+	return nil
+}
+
+// Rekey does nothing
+func (NopDatapath) Rekey(ctx context.Context, conn *nsmv1.NetworkConnection) error {
+	return nil
+}
@@ -0,0 +1,161 @@
+package connection
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/config"
+)
+
+// Dataplane backends forwarding the connections
+const (
+	// DataplaneKernel is the Linux networking stack, with the NIC for the
+	// accelerated datapaths
+	DataplaneKernel = "kernel"
+	// DataplaneOVS is Open vSwitch with its DPDK datapath
+	DataplaneOVS = "ovs"
+	// DataplaneVPP is the FD.io Vector Packet Processor
+	DataplaneVPP = "vpp"
+)
+
+// Features a connection may require of its dataplane backend
+const (
+	// FeatureEncryption encrypts the traffic (IPsec, TLS, WireGuard)
+	FeatureEncryption = "encryption"
+	// FeatureEncryptionOffload encrypts the traffic inline on the NIC
+	FeatureEncryptionOffload = "encryption-offload"
+	// FeatureL2 forwards the frames of a VLAN tagged connection
+	FeatureL2 = "l2"
+	// FeatureQinQ forwards the frames of a connection with stacked 802.1ad
+	// tags
+	FeatureQinQ = "qinq"
+)
+
+// ReasonNoCapableDataplane is reported when no dataplane backend serving
+// the datapath of a connection offers the features it requires
+const ReasonNoCapableDataplane = "NoCapableDataplane"
+
+// Dataplane is a backend forwarding connections, with the datapaths it
+// serves and the features it offers
+type Dataplane struct {
+	// Name of the backend (kernel, ovs, vpp)
+	Name string
+	// Datapaths served (connection types and fallbacks)
+	Datapaths []string
+	// Features offered
+	Features []string
+}
+
+// Has reports whether the backend offers a feature
+func (d Dataplane) Has(feature string) bool {
+	return slices.Contains(d.Features, feature)
+}
+
+// Serves reports whether the backend serves a datapath
+func (d Dataplane) Serves(datapath string) bool {
+	return slices.Contains(d.Datapaths, datapath)
+}
+
+// DataplanesFromConfig derives the userspace dataplane backends from the
+// NSM configuration, VPP serving the kernel connections when it is the
+// dataplane and the vhost-user ports of either
+func DataplanesFromConfig(cfg *config.Config) []Dataplane {
+	var dataplanes []Dataplane
+	vpp := Dataplane{Name: DataplaneVPP, Features: []string{FeatureL2, FeatureQinQ}}
+	if cfg.Dataplane == DataplaneVPP {
+		vpp.Datapaths = append(vpp.Datapaths, nsmv1.ConnectionTypeKernel)
+	}
+	switch cfg.VhostUserDataplane {
+	case DataplaneVPP:
+		vpp.Datapaths = append(vpp.Datapaths, nsmv1.ConnectionTypeVhostUser)
+	case DataplaneOVS:
+		dataplanes = append(dataplanes, Dataplane{
+			Name:      DataplaneOVS,
+			Datapaths: []string{nsmv1.ConnectionTypeVhostUser},
+			Features:  []string{FeatureL2, FeatureQinQ},
+		})
+	}
+	if len(vpp.Datapaths) > 0 {
+		dataplanes = append(dataplanes, vpp)
+	}
+	return dataplanes
+}
+
+// kernelDataplane returns the kernel backend, which serves every datapath
+// but vhost-user and offloads the encryption when the uplink NIC does
+func (c Capabilities) kernelDataplane() Dataplane {
+	d := Dataplane{
+		Name: DataplaneKernel,
+		Datapaths: []string{
+			nsmv1.ConnectionTypeKernel, nsmv1.ConnectionTypeSRIOV, nsmv1.ConnectionTypeDPDK,
+			nsmv1.ConnectionTypeVXLAN, nsmv1.ConnectionTypeWireGuard, nsmv1.DatapathMacvlan, nsmv1.DatapathIPvlan,
+		},
+		Features: []string{FeatureEncryption, FeatureL2, FeatureQinQ},
+	}
+	if len(c.CryptoOffload) > 0 {
+		d.Features = append(d.Features, FeatureEncryptionOffload)
+	}
+	return d
+}
+
+// RequiredFeatures returns the features a connection requires of its
+// dataplane backend
+func RequiredFeatures(spec nsmv1.NetworkConnectionSpec) []string {
+	var features []string
+	if spec.Encryption != "" || spec.ConnectionType == nsmv1.ConnectionTypeWireGuard {
+		features = append(features, FeatureEncryption)
+	}
+	if spec.VLAN != nil {
+		features = append(features, FeatureL2)
+		if spec.VLAN.InnerVID > 0 {
+			features = append(features, FeatureQinQ)
+		}
+	}
+	return features
+}
+
+// Negotiate picks the dataplane backend of a connection on a datapath:
+// the first userspace backend serving the datapath with every feature the
+// connection requires, then the kernel. A backend offloading the
+// encryption is preferred for the connections encrypted with a protocol
+// the NIC offloads.
+func (c Capabilities) Negotiate(datapath string, spec nsmv1.NetworkConnectionSpec) (string, error) {
+	required := RequiredFeatures(spec)
+	candidates := append(slices.Clone(c.Dataplanes), c.kernelDataplane())
+	if c.EncryptionMode(spec) == nsmv1.EncryptionModeOffload {
+		// stable, so the order of preference holds among the others
+		slices.SortStableFunc(candidates, func(a, b Dataplane) int {
+			switch {
+			case a.Has(FeatureEncryptionOffload) && !b.Has(FeatureEncryptionOffload):
+				return -1
+			case b.Has(FeatureEncryptionOffload) && !a.Has(FeatureEncryptionOffload):
+				return 1
+			}
+			return 0
+		})
+	}
+
+	var missing []string
+	for _, d := range candidates {
+		if !d.Serves(datapath) {
+			continue
+		}
+		lacking := slices.DeleteFunc(slices.Clone(required), d.Has)
+		if len(lacking) == 0 {
+			return d.Name, nil
+		}
+		missing = append(missing, fmt.Sprintf("%s lacks %s", d.Name, strings.Join(lacking, ", ")))
+	}
+	if len(missing) == 0 {
+		return "", &CapabilityError{
+			Reason:  ReasonNoCapableDataplane,
+			Message: fmt.Sprintf("no dataplane backend serves datapath %s", datapath),
+		}
+	}
+	return "", &CapabilityError{
+		Reason:  ReasonNoCapableDataplane,
+		Message: fmt.Sprintf("no dataplane backend serving datapath %s offers %s: %s", datapath, strings.Join(required, ", "), strings.Join(missing, "; ")),
+	}
+}
@@ -0,0 +1,87 @@
+package connection
+
+import (
+	"errors"
+	"testing"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/config"
+)
+
+func TestDataplanesFromConfig(t *testing.T) {
+	cfg := config.DefaultConfig()
+	if dataplanes := DataplanesFromConfig(cfg); len(dataplanes) != 0 {
+		t.Errorf("DataplanesFromConfig() = %+v, want the kernel only", dataplanes)
+	}
+
+	cfg.Dataplane = "vpp"
+	cfg.VhostUserDataplane = "vpp"
+	dataplanes := DataplanesFromConfig(cfg)
+	if len(dataplanes) != 1 || !dataplanes[0].Serves(nsmv1.ConnectionTypeKernel) || !dataplanes[0].Serves(nsmv1.ConnectionTypeVhostUser) {
+		t.Errorf("DataplanesFromConfig() = %+v, want VPP serving kernel and vhostuser connections", dataplanes)
+	}
+
+	cfg.VhostUserDataplane = "ovs"
+	dataplanes = DataplanesFromConfig(cfg)
+	if len(dataplanes) != 2 || dataplanes[0].Name != DataplaneOVS || dataplanes[1].Serves(nsmv1.ConnectionTypeVhostUser) {
+		t.Errorf("DataplanesFromConfig() = %+v, want OVS serving the vhostuser connections", dataplanes)
+	}
+}
+
+func TestCapabilitiesNegotiate(t *testing.T) {
+	vpp := Dataplane{Name: DataplaneVPP, Datapaths: []string{nsmv1.ConnectionTypeKernel}, Features: []string{FeatureL2}}
+	ovs := Dataplane{Name: DataplaneOVS, Datapaths: []string{nsmv1.ConnectionTypeVhostUser}, Features: []string{FeatureL2}}
+	tests := []struct {
+		name       string
+		caps       Capabilities
+		datapath   string
+		spec       nsmv1.NetworkConnectionSpec
+		want       string
+		wantReason string
+	}{
+		{"kernel alone", Capabilities{}, nsmv1.ConnectionTypeKernel, nsmv1.NetworkConnectionSpec{}, DataplaneKernel, ""},
+		{"userspace preferred", Capabilities{Dataplanes: []Dataplane{vpp}}, nsmv1.ConnectionTypeKernel,
+			nsmv1.NetworkConnectionSpec{VLAN: &nsmv1.VLANSpec{OuterVID: 100}}, DataplaneVPP, ""},
+		{"QinQ on the kernel", Capabilities{Dataplanes: []Dataplane{vpp}}, nsmv1.ConnectionTypeKernel,
+			nsmv1.NetworkConnectionSpec{VLAN: &nsmv1.VLANSpec{OuterVID: 100, InnerVID: 20}}, DataplaneKernel, ""},
+		{"encryption on the kernel", Capabilities{Dataplanes: []Dataplane{vpp}}, nsmv1.ConnectionTypeKernel,
+			nsmv1.NetworkConnectionSpec{Encryption: nsmv1.EncryptionIPsec}, DataplaneKernel, ""},
+		{"sriov on the kernel", Capabilities{Dataplanes: []Dataplane{vpp}}, nsmv1.ConnectionTypeSRIOV, nsmv1.NetworkConnectionSpec{}, DataplaneKernel, ""},
+		{"vhostuser on OVS", Capabilities{Dataplanes: []Dataplane{ovs}}, nsmv1.ConnectionTypeVhostUser, nsmv1.NetworkConnectionSpec{}, DataplaneOVS, ""},
+		{"vhostuser QinQ", Capabilities{Dataplanes: []Dataplane{ovs}}, nsmv1.ConnectionTypeVhostUser,
+			nsmv1.NetworkConnectionSpec{VLAN: &nsmv1.VLANSpec{OuterVID: 100, InnerVID: 20}}, "", ReasonNoCapableDataplane},
+		{"vhostuser without dataplane", Capabilities{}, nsmv1.ConnectionTypeVhostUser, nsmv1.NetworkConnectionSpec{}, "", ReasonNoCapableDataplane},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.caps.Negotiate(tt.datapath, tt.spec)
+			if tt.wantReason == "" {
+				if err != nil || got != tt.want {
+					t.Errorf("Negotiate() = %q, %v, want %s", got, err, tt.want)
+				}
+				return
+			}
+			var capErr *CapabilityError
+			if !errors.As(err, &capErr) || capErr.Reason != tt.wantReason {
+				t.Errorf("Negotiate() error = %v, want %s", err, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestCapabilitiesNegotiateOffload(t *testing.T) {
+	// a userspace backend offloading the encryption is preferred for the
+	// offloaded protocols only
+	dpu := Dataplane{Name: "dpu", Datapaths: []string{nsmv1.ConnectionTypeVXLAN}, Features: []string{FeatureEncryption, FeatureEncryptionOffload}}
+	caps := Capabilities{
+		CryptoOffload: map[string]bool{nsmv1.EncryptionIPsec: true},
+		Dataplanes:    []Dataplane{{Name: DataplaneVPP, Datapaths: []string{nsmv1.ConnectionTypeVXLAN}, Features: []string{FeatureEncryption}}, dpu},
+	}
+	if got, _ := caps.Negotiate(nsmv1.ConnectionTypeVXLAN, nsmv1.NetworkConnectionSpec{Encryption: nsmv1.EncryptionIPsec}); got != "dpu" {
+		t.Errorf("Negotiate(ipsec) = %s, want the offloading backend", got)
+	}
+	if got, _ := caps.Negotiate(nsmv1.ConnectionTypeVXLAN, nsmv1.NetworkConnectionSpec{Encryption: nsmv1.EncryptionTLS}); got != DataplaneVPP {
+		t.Errorf("Negotiate(tls) = %s, want the preferred backend", got)
+	}
+}
@@ -0,0 +1,117 @@
+package connection
+
+import (
+	"net"
+	"slices"
+	"sort"
+	"strings"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+)
+
+// MaxNameservers is the number of nameservers the resolver of the C
+// library uses, further ones are ignored
+const MaxNameservers = 3
+
+// ClusterDNS is the cluster resolver pods fall back to
+type ClusterDNS struct {
+	// Address of the cluster resolver, empty for none
+	Nameserver string
+	// Cluster domain (e.g., cluster.local)
+	Domain string
+}
+
+// DNSView is the name resolution of a pod over its connections
+type DNSView struct {
+	// Resolvers, those of the connections first
+	Nameservers []string
+	// Search domains, the cluster ones first
+	Searches []string
+	// Resolver options
+	Options []string
+	// Nameservers left out, invalid or beyond MaxNameservers
+	Dropped []string
+}
+
+// BuildDNSView merges the DNS of the established connections of a pod
+// with the cluster resolver. The resolvers of the connections come first
+// so names on the remote networks resolve, the cluster resolver last
+// while there is room for it. The cluster search domains stay first, so
+// short names keep resolving to services of the cluster. Connections are
+// merged by name, making the view stable between reconciles.
+func BuildDNSView(namespace string, cluster ClusterDNS, conns []nsmv1.NetworkConnection) *DNSView {
+	conns = slices.Clone(conns)
+	sort.Slice(conns, func(i, j int) bool {
+		return conns[i].Namespace+"/"+conns[i].Name < conns[j].Namespace+"/"+conns[j].Name
+	})
+
+	view := &DNSView{}
+	if cluster.Domain != "" {
+		view.Searches = []string{namespace + ".svc." + cluster.Domain, "svc." + cluster.Domain, cluster.Domain}
+		// the cluster default, so service names aren't tried as FQDNs first
+		view.Options = []string{"ndots:5"}
+	}
+	for _, conn := range conns {
+		dns := conn.Spec.DNS
+		if dns == nil {
+			continue
+		}
+		for _, ns := range dns.Nameservers {
+			ip := net.ParseIP(ns)
+			switch {
+			case ip == nil || len(view.Nameservers) == MaxNameservers:
+				view.Dropped = append(view.Dropped, ns)
+			case !slices.Contains(view.Nameservers, ip.String()):
+				view.Nameservers = append(view.Nameservers, ip.String())
+			}
+		}
+		for _, search := range dns.Searches {
+			search = strings.TrimSuffix(search, ".")
+			if search != "" && !slices.Contains(view.Searches, search) {
+				view.Searches = append(view.Searches, search)
+			}
+		}
+		for _, option := range dns.Options {
+			if option = strings.TrimSpace(option); option != "" {
+				view.Options = setOption(view.Options, option)
+			}
+		}
+	}
+
+	if cluster.Nameserver != "" && !slices.Contains(view.Nameservers, cluster.Nameserver) {
+		if len(view.Nameservers) < MaxNameservers {
+			view.Nameservers = append(view.Nameservers, cluster.Nameserver)
+		} else {
+			view.Dropped = append(view.Dropped, cluster.Nameserver)
+		}
+	}
+	return view
+}
+
+// ResolvConf renders the view in resolv.conf(5) format
+func (v *DNSView) ResolvConf() string {
+	var b strings.Builder
+	b.WriteString("# Generated by NSM from the DNS of the pod's connections\n")
+	for _, ns := range v.Nameservers {
+		b.WriteString("nameserver " + ns + "\n")
+	}
+	if len(v.Searches) > 0 {
+		b.WriteString("search " + strings.Join(v.Searches, " ") + "\n")
+	}
+	if len(v.Options) > 0 {
+		b.WriteString("options " + strings.Join(v.Options, " ") + "\n")
+	}
+	return b.String()
+}
+
+// setOption sets a resolver option, replacing an earlier value of it
+func setOption(options []string, option string) []string {
+	name, _, _ := strings.Cut(option, ":")
+	for i, o := range options {
+		if n, _, _ := strings.Cut(o, ":"); n == name {
+			options[i] = option
+			return options
+		}
+	}
+	return append(options, option)
+}
@@ -0,0 +1,70 @@
+package connection
+
+import (
+	"reflect"
+	"testing"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func dnsConnection(name string, dns *nsmv1.ConnectionDNS) nsmv1.NetworkConnection {
+	return nsmv1.NetworkConnection{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "edge", Name: name},
+		Spec:       nsmv1.NetworkConnectionSpec{Source: "edge/pod", DNS: dns},
+	}
+}
+
+func TestBuildDNSView(t *testing.T) {
+	cluster := ClusterDNS{Nameserver: "10.96.0.10", Domain: "cluster.local"}
+	conns := []nsmv1.NetworkConnection{
+		dnsConnection("vpn", &nsmv1.ConnectionDNS{
+			Nameservers: []string{"172.16.0.53"},
+			Searches:    []string{"corp.example.com."},
+			Options:     []string{"ndots:2", "timeout:1"},
+		}),
+		dnsConnection("factory", &nsmv1.ConnectionDNS{
+			Nameservers: []string{"192.168.10.53", "172.16.0.53", "not-an-ip"},
+			Searches:    []string{"plant.local"},
+		}),
+		dnsConnection("plain", nil),
+	}
+
+	view := BuildDNSView("edge", cluster, conns)
+	// connections are merged by name: factory before vpn
+	if want := []string{"192.168.10.53", "172.16.0.53", "10.96.0.10"}; !reflect.DeepEqual(view.Nameservers, want) {
+		t.Errorf("nameservers = %v, want %v", view.Nameservers, want)
+	}
+	if want := []string{"edge.svc.cluster.local", "svc.cluster.local", "cluster.local", "plant.local", "corp.example.com"}; !reflect.DeepEqual(view.Searches, want) {
+		t.Errorf("searches = %v, want %v", view.Searches, want)
+	}
+	if want := []string{"ndots:2", "timeout:1"}; !reflect.DeepEqual(view.Options, want) {
+		t.Errorf("options = %v, want %v", view.Options, want)
+	}
+	if want := []string{"not-an-ip"}; !reflect.DeepEqual(view.Dropped, want) {
+		t.Errorf("dropped = %v, want %v", view.Dropped, want)
+	}
+
+	want := "# Generated by NSM from the DNS of the pod's connections\n" +
+		"nameserver 192.168.10.53\nnameserver 172.16.0.53\nnameserver 10.96.0.10\n" +
+		"search edge.svc.cluster.local svc.cluster.local cluster.local plant.local corp.example.com\n" +
+		"options ndots:2 timeout:1\n"
+	if got := view.ResolvConf(); got != want {
+		t.Errorf("ResolvConf() = %q, want %q", got, want)
+	}
+}
+
+func TestBuildDNSViewNameserverLimit(t *testing.T) {
+	conns := []nsmv1.NetworkConnection{
+		dnsConnection("a", &nsmv1.ConnectionDNS{Nameservers: []string{"10.1.0.53", "10.2.0.53"}}),
+		dnsConnection("b", &nsmv1.ConnectionDNS{Nameservers: []string{"10.3.0.53", "10.4.0.53"}}),
+	}
+	view := BuildDNSView("edge", ClusterDNS{Nameserver: "10.96.0.10", Domain: "cluster.local"}, conns)
+	if len(view.Nameservers) != MaxNameservers {
+		t.Errorf("%d nameservers, want %d", len(view.Nameservers), MaxNameservers)
+	}
+	// the resolvers of the connections take precedence over the cluster one
+	if want := []string{"10.4.0.53", "10.96.0.10"}; !reflect.DeepEqual(view.Dropped, want) {
+		t.Errorf("dropped = %v, want %v", view.Dropped, want)
+	}
+}
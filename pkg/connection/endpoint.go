@@ -0,0 +1,20 @@
+package connection
+
+import nsmv1 "github.com/akos011221/nsm/api/v1"
+
+// ReasonEndpointUnresolved is reported while a connection references a
+// NetworkEndpoint whose address isn't filled in yet
+const ReasonEndpointUnresolved = "EndpointUnresolved"
+
+// UnresolvedEndpoint returns the NetworkEndpoint a connection references
+// whose address the registry of its node hasn't filled in yet, empty when
+// the connection has its source and destination
+func UnresolvedEndpoint(spec *nsmv1.NetworkConnectionSpec) string {
+	if spec.Source == "" && spec.SourceEndpoint != "" {
+		return spec.SourceEndpoint
+	}
+	if spec.Destination == "" && spec.DestinationEndpoint != "" {
+		return spec.DestinationEndpoint
+	}
+	return ""
+}
@@ -0,0 +1,23 @@
+package connection
+
+import (
+	"testing"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+)
+
+func TestUnresolvedEndpoint(t *testing.T) {
+	for name, tt := range map[string]struct {
+		spec nsmv1.NetworkConnectionSpec
+		want string
+	}{
+		"free-form":              {nsmv1.NetworkConnectionSpec{Source: "edge/plc", Destination: "scada"}, ""},
+		"resolved":               {nsmv1.NetworkConnectionSpec{Source: "192.0.2.10", SourceEndpoint: "edge-1.eth1", Destination: "scada"}, ""},
+		"source unresolved":      {nsmv1.NetworkConnectionSpec{SourceEndpoint: "edge-1.eth1", Destination: "scada"}, "edge-1.eth1"},
+		"destination unresolved": {nsmv1.NetworkConnectionSpec{Source: "edge/plc", DestinationEndpoint: "edge-2.eth0"}, "edge-2.eth0"},
+	} {
+		if got := UnresolvedEndpoint(&tt.spec); got != tt.want {
+			t.Errorf("%s: UnresolvedEndpoint() = %q, want %q", name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package connection
+
+import (
+	"fmt"
+	"time"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+)
+
+// Share of the latency requirement the NIC queues may take, beyond which
+// queues hold more than an industrial flow tolerates
+const (
+	nicQueueingShare = 10
+	maxNICQueueing   = 5 * time.Millisecond
+)
+
+// Typical latency of the host network stack per datapath
+var hostStackLatency = map[string]time.Duration{
+	nsmv1.ConnectionTypeDPDK:      5 * time.Microsecond,
+	nsmv1.ConnectionTypeSRIOV:     10 * time.Microsecond,
+	nsmv1.ConnectionTypeVhostUser: 10 * time.Microsecond,
+	nsmv1.DatapathMacvlan:         30 * time.Microsecond,
+	nsmv1.DatapathIPvlan:          30 * time.Microsecond,
+	nsmv1.ConnectionTypeKernel:    50 * time.Microsecond,
+	nsmv1.ConnectionTypeVXLAN:     80 * time.Microsecond,
+	nsmv1.ConnectionTypeWireGuard: 150 * time.Microsecond,
+}
+
+// Default host stack latency of datapaths not listed
+const defaultHostStackLatency = 50 * time.Microsecond
+
+// DecomposeLatency splits the latency requirement of a connection served
+// by a datapath into the budgets of the NIC queues, the host stack and the
+// WAN. The NIC gets a share of the requirement, but at least the time to
+// send two full-sized frames at the bandwidth limit; the WAN gets the rest.
+// Connections without a requirement have no budget.
+func DecomposeLatency(spec nsmv1.NetworkConnectionSpec, datapath string) (*nsmv1.LatencyBudget, error) {
+	if spec.LatencyRequirement <= 0 {
+		return nil, nil
+	}
+	requirement := time.Duration(spec.LatencyRequirement) * time.Millisecond
+
+	host, ok := hostStackLatency[datapath]
+	if !ok {
+		host = defaultHostStackLatency
+	}
+	nic := min(requirement/nicQueueingShare, maxNICQueueing)
+	if spec.Bandwidth > 0 {
+		// two 1500 byte frames, 24000 bits at the limit in Mbps
+		nic = max(nic, time.Duration(24000/spec.Bandwidth)*time.Microsecond)
+	}
+
+	wan := requirement - host - nic
+	if wan <= 0 {
+		return nil, fmt.Errorf("latency requirement of %dms leaves no WAN budget after %s of NIC queueing and %s of %s host stack",
+			spec.LatencyRequirement, nic, host, datapath)
+	}
+	return &nsmv1.LatencyBudget{
+		NICQueueingUs: int(nic.Microseconds()),
+		HostStackUs:   int(host.Microseconds()),
+		WANUs:         int(wan.Microseconds()),
+	}, nil
+}
+
+// LatencyViolation returns the segment furthest over its budget when the
+// observed latency of a connection misses its requirement, or an empty
+// string if it is met. The WAN latency is what the measured segments leave
+// of the observed latency, so segments without measurements count as WAN.
+func LatencyViolation(budget *nsmv1.LatencyBudget, requirementMs int, metrics nsmv1.ConnectionMetrics) string {
+	if budget == nil || metrics.LatencyMs <= requirementMs {
+		return ""
+	}
+	wan := metrics.LatencyMs*1000 - metrics.NICQueueingUs - metrics.HostStackUs
+	overruns := []struct {
+		segment string
+		excess  int
+	}{
+		{nsmv1.LatencySegmentNICQueueing, metrics.NICQueueingUs - budget.NICQueueingUs},
+		{nsmv1.LatencySegmentHostStack, metrics.HostStackUs - budget.HostStackUs},
+		{nsmv1.LatencySegmentWAN, wan - budget.WANUs},
+	}
+	worst := overruns[0]
+	for _, o := range overruns[1:] {
+		if o.excess > worst.excess {
+			worst = o
+		}
+	}
+	return worst.segment
+}
@@ -0,0 +1,76 @@
+package connection
+
+import (
+	"testing"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+)
+
+func TestDecomposeLatency(t *testing.T) {
+	tests := []struct {
+		name     string
+		spec     nsmv1.NetworkConnectionSpec
+		datapath string
+		want     *nsmv1.LatencyBudget
+		wantErr  bool
+	}{
+		{name: "no requirement", datapath: nsmv1.ConnectionTypeKernel},
+		{
+			name:     "share of the requirement",
+			spec:     nsmv1.NetworkConnectionSpec{LatencyRequirement: 20},
+			datapath: nsmv1.ConnectionTypeSRIOV,
+			want:     &nsmv1.LatencyBudget{NICQueueingUs: 2000, HostStackUs: 10, WANUs: 17990},
+		},
+		{
+			name:     "queueing capped",
+			spec:     nsmv1.NetworkConnectionSpec{LatencyRequirement: 100},
+			datapath: nsmv1.ConnectionTypeWireGuard,
+			want:     &nsmv1.LatencyBudget{NICQueueingUs: 5000, HostStackUs: 150, WANUs: 94850},
+		},
+		{
+			name:     "two frames at a low bandwidth",
+			spec:     nsmv1.NetworkConnectionSpec{LatencyRequirement: 5, Bandwidth: 10},
+			datapath: nsmv1.DatapathMacvlan,
+			want:     &nsmv1.LatencyBudget{NICQueueingUs: 2400, HostStackUs: 30, WANUs: 2570},
+		},
+		{
+			name:     "infeasible",
+			spec:     nsmv1.NetworkConnectionSpec{LatencyRequirement: 1, Bandwidth: 1},
+			datapath: nsmv1.ConnectionTypeKernel,
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DecomposeLatency(tt.spec, tt.datapath)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DecomposeLatency() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if (got == nil) != (tt.want == nil) || got != nil && *got != *tt.want {
+				t.Errorf("DecomposeLatency() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLatencyViolation(t *testing.T) {
+	budget := &nsmv1.LatencyBudget{NICQueueingUs: 1000, HostStackUs: 50, WANUs: 8950}
+	tests := []struct {
+		name    string
+		metrics nsmv1.ConnectionMetrics
+		want    string
+	}{
+		{name: "met", metrics: nsmv1.ConnectionMetrics{LatencyMs: 10, NICQueueingUs: 3000}},
+		{name: "host stack", metrics: nsmv1.ConnectionMetrics{LatencyMs: 12, NICQueueingUs: 800, HostStackUs: 3000}, want: nsmv1.LatencySegmentHostStack},
+		{name: "WAN", metrics: nsmv1.ConnectionMetrics{LatencyMs: 15, NICQueueingUs: 1500, HostStackUs: 60}, want: nsmv1.LatencySegmentWAN},
+		{name: "unmeasured segments count as WAN", metrics: nsmv1.ConnectionMetrics{LatencyMs: 11}, want: nsmv1.LatencySegmentWAN},
+	}
+	for _, tt := range tests {
+		if got := LatencyViolation(budget, 10, tt.metrics); got != tt.want {
+			t.Errorf("%s: LatencyViolation() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+	if got := LatencyViolation(nil, 10, nsmv1.ConnectionMetrics{LatencyMs: 50}); got != "" {
+		t.Errorf("violation reported without a budget: %q", got)
+	}
+}
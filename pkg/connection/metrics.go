@@ -0,0 +1,68 @@
+package connection
+
+import (
+	"context"
+	"time"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// states are the connection states always reported, so alerts on them
+// see zeros rather than absent series
+var states = []string{
+	nsmv1.ConnectionStatePending,
+	nsmv1.ConnectionStateEstablished,
+	nsmv1.ConnectionStateDegraded,
+	nsmv1.ConnectionStateFailed,
+	nsmv1.ConnectionStateAdminDown,
+}
+
+var connectionsDesc = prometheus.NewDesc("nsm_connections", "Network connections, by state",
+	[]string{"state"}, nil)
+
+// listTimeout bounds the listing of the connections on a scrape
+const listTimeout = 5 * time.Second
+
+// StateCollector counts the network connections by state when scraped
+type StateCollector struct {
+	// Reader of the connections (the cache of the manager)
+	client client.Reader
+}
+
+// NewStateCollector creates a collector of the connection states
+func NewStateCollector(c client.Reader) *StateCollector {
+	return &StateCollector{client: c}
+}
+
+// Describe implements prometheus.Collector
+func (c *StateCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- connectionsDesc
+}
+
+// Collect implements prometheus.Collector
+func (c *StateCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), listTimeout)
+	defer cancel()
+
+	var conns nsmv1.NetworkConnectionList
+	if err := c.client.List(ctx, &conns); err != nil {
+		ch <- prometheus.NewInvalidMetric(connectionsDesc, err)
+		return
+	}
+	counts := make(map[string]int, len(states))
+	for _, state := range states {
+		counts[state] = 0
+	}
+	for _, conn := range conns.Items {
+		state := conn.Status.State
+		if state == "" {
+			state = nsmv1.ConnectionStatePending
+		}
+		counts[state]++
+	}
+	for state, n := range counts {
+		ch <- prometheus.MustNewConstMetric(connectionsDesc, prometheus.GaugeValue, float64(n), state)
+	}
+}
@@ -0,0 +1,44 @@
+package connection
+
+import (
+	"strings"
+	"testing"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestStateCollector(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := nsmv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	conn := func(name, state string) *nsmv1.NetworkConnection {
+		return &nsmv1.NetworkConnection{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "edge"},
+			Status:     nsmv1.NetworkConnectionStatus{State: state},
+		}
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		conn("plc", nsmv1.ConnectionStateEstablished),
+		conn("camera", nsmv1.ConnectionStateEstablished),
+		conn("lidar", nsmv1.ConnectionStateDegraded),
+		conn("new", ""),
+	).Build()
+
+	want := `
+# HELP nsm_connections Network connections, by state
+# TYPE nsm_connections gauge
+nsm_connections{state="AdminDown"} 0
+nsm_connections{state="Degraded"} 1
+nsm_connections{state="Established"} 2
+nsm_connections{state="Failed"} 0
+nsm_connections{state="Pending"} 1
+`
+	if err := testutil.CollectAndCompare(NewStateCollector(c), strings.NewReader(want)); err != nil {
+		t.Error(err)
+	}
+}
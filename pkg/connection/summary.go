@@ -0,0 +1,92 @@
+package connection
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/api"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Summary is the state of a connection at a glance, for operators
+// debugging its setup without reading the whole object
+type Summary struct {
+	// Namespace of the connection
+	Namespace string `json:"namespace"`
+	// Name of the connection
+	Name string `json:"name"`
+	// Type of the connection (e.g., kernel, sriov, wireguard)
+	ConnectionType string `json:"connectionType"`
+	// Source and destination of the connection
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	// State of the connection (e.g., Pending, Established, Failed)
+	State string `json:"state"`
+	// Whether the connection is established
+	Established bool `json:"established"`
+	// Node the connection is set up on
+	Node string `json:"node,omitempty"`
+	// Datapath and dataplane backend forwarding the connection
+	Datapath  string `json:"datapath,omitempty"`
+	Dataplane string `json:"dataplane,omitempty"`
+	// Failover path carrying the traffic
+	ActivePath string `json:"activePath,omitempty"`
+	// Observed latency in milliseconds
+	LatencyMs int `json:"latencyMs,omitempty"`
+	// Last status message, e.g., why the setup failed
+	Message string `json:"message,omitempty"`
+}
+
+// Summarize returns the summary of a connection
+func Summarize(conn *nsmv1.NetworkConnection) Summary {
+	return Summary{
+		Namespace:      conn.Namespace,
+		Name:           conn.Name,
+		ConnectionType: conn.Spec.ConnectionType,
+		Source:         conn.Spec.Source,
+		Destination:    conn.Spec.Destination,
+		State:          conn.Status.State,
+		Established:    conn.Status.Established,
+		Node:           conn.Status.Node,
+		Datapath:       conn.Status.Datapath,
+		Dataplane:      conn.Status.Dataplane,
+		ActivePath:     conn.Status.ActivePath,
+		LatencyMs:      conn.Status.Metrics.LatencyMs,
+		Message:        conn.Status.Message,
+	}
+}
+
+// Handler serves the summaries of the connections, ordered by namespace
+// and name, of a namespace and node if given with namespace and node
+func Handler(c client.Reader) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		var opts []client.ListOption
+		if namespace := query.Get("namespace"); namespace != "" {
+			opts = append(opts, client.InNamespace(namespace))
+		}
+
+		var conns nsmv1.NetworkConnectionList
+		if err := c.List(r.Context(), &conns, opts...); err != nil {
+			api.WriteError(w, http.StatusInternalServerError, fmt.Errorf("failed to list connections: %w", err))
+			return
+		}
+		node := query.Get("node")
+		summaries := make([]Summary, 0, len(conns.Items))
+		for i := range conns.Items {
+			if node != "" && conns.Items[i].Status.Node != node {
+				continue
+			}
+			summaries = append(summaries, Summarize(&conns.Items[i]))
+		}
+		sort.Slice(summaries, func(i, j int) bool {
+			if summaries[i].Namespace != summaries[j].Namespace {
+				return summaries[i].Namespace < summaries[j].Namespace
+			}
+			return summaries[i].Name < summaries[j].Name
+		})
+		api.WriteJSON(w, http.StatusOK, summaries)
+	})
+}
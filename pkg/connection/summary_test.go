@@ -0,0 +1,55 @@
+package connection
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestHandler(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := nsmv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	summarized := func(namespace, name, node string) *nsmv1.NetworkConnection {
+		return &nsmv1.NetworkConnection{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+			Spec:       nsmv1.NetworkConnectionSpec{ConnectionType: nsmv1.ConnectionTypeKernel, Source: namespace + "/pod", Destination: "svc"},
+			Status:     nsmv1.NetworkConnectionStatus{State: nsmv1.ConnectionStateEstablished, Established: true, Node: node, Dataplane: DataplaneKernel},
+		}
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		summarized("edge", "telemetry", "edge-1"),
+		summarized("edge", "plc", "edge-2"),
+		summarized("factory", "camera", "edge-1"),
+	).Build()
+	h := Handler(c)
+
+	list := func(target string) []Summary {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		var summaries []Summary
+		if err := json.Unmarshal(rec.Body.Bytes(), &summaries); err != nil {
+			t.Fatalf("decoding %s: %v", rec.Body, err)
+		}
+		return summaries
+	}
+
+	all := list("/v1/connections")
+	if len(all) != 3 || all[0].Name != "plc" || all[1].Name != "telemetry" || all[2].Name != "camera" {
+		t.Fatalf("summaries = %+v, want ordered by namespace and name", all)
+	}
+	if all[0].Source != "edge/pod" || !all[0].Established || all[0].Dataplane != DataplaneKernel {
+		t.Errorf("summary = %+v", all[0])
+	}
+	if got := list("/v1/connections?namespace=edge&node=edge-1"); len(got) != 1 || got[0].Name != "telemetry" {
+		t.Errorf("summaries of edge on edge-1 = %+v", got)
+	}
+}
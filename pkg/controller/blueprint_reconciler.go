@@ -0,0 +1,141 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/blueprint"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// BlueprintReconciler stamps out the NetworkConnections of NetworkBlueprints
+type BlueprintReconciler struct {
+	// Kubernetes client (controller-runtime)
+	client client.Client
+	// Logger
+	logger *logrus.Logger
+	// Replica shard, nil if this replica reconciles every blueprint
+	shard Shard
+	// Triggers the reconcile of the blueprints after a rebalance
+	shardEvents chan event.GenericEvent
+}
+
+// NewBlueprintReconciler creates a new blueprint reconciler
+func NewBlueprintReconciler(c client.Client, logger *logrus.Logger) *BlueprintReconciler {
+	return &BlueprintReconciler{
+		client: c,
+		logger: logger,
+	}
+}
+
+// SetShard makes the reconciler apply only the connections of this
+// replica's shard: those whose source is a pod by the node of the pod, the
+// others and the status of a blueprint by its key
+func (r *BlueprintReconciler) SetShard(shard Shard) {
+	r.shard = shard
+	r.shardEvents = shardEvents(shard, &nsmv1.NetworkBlueprint{})
+}
+
+// rebalanceRequests returns the requests of every blueprint after a rebalance
+func (r *BlueprintReconciler) rebalanceRequests(ctx context.Context, _ client.Object) []reconcile.Request {
+	return rebalanceRequests(ctx, r.client, r.logger, &nsmv1.NetworkBlueprintList{})
+}
+
+// SetupWithManager registers the reconciler and its watches with the manager
+func (r *BlueprintReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	b := ctrl.NewControllerManagedBy(mgr).
+		Named("networkblueprint").
+		For(&nsmv1.NetworkBlueprint{}).
+		Owns(&nsmv1.NetworkConnection{})
+	if r.shardEvents != nil {
+		b = b.WithOptions(sharded()).
+			WatchesRawSource(source.Channel(r.shardEvents, handler.EnqueueRequestsFromMapFunc(r.rebalanceRequests)))
+	}
+	return b.Complete(r)
+}
+
+// Reconcile brings the generated connections of a blueprint in line with
+// its template and instances
+func (r *BlueprintReconciler) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	var bp nsmv1.NetworkBlueprint
+	if err := r.client.Get(ctx, req.NamespacedName, &bp); err != nil {
+		// generated connections are garbage collected through owner references
+		return reconcile.Result{}, client.IgnoreNotFound(err)
+	}
+
+	// an invalid blueprint keeps the connections generated before
+	conns, err := blueprint.Expand(&bp)
+	if err != nil {
+		return reconcile.Result{}, r.setStatus(ctx, &bp, metav1.ConditionFalse, "ExpandFailed", err.Error(), bp.Status.ConnectionCount)
+	}
+
+	if r.shard != nil {
+		if err := r.labelNodes(ctx, conns); err != nil {
+			return reconcile.Result{}, err
+		}
+	}
+	if err := applyGeneratedConnections(ctx, r.client, r.logger, r.shard, &bp, "blueprint", blueprint.LabelBlueprint, conns); err != nil {
+		return reconcile.Result{}, err
+	}
+
+	msg := fmt.Sprintf("%d connections generated", len(conns))
+	return reconcile.Result{}, r.setStatus(ctx, &bp, metav1.ConditionTrue, "Expanded", msg, len(conns))
+}
+
+// setStatus writes the Ready condition and connection count of a blueprint
+func (r *BlueprintReconciler) setStatus(ctx context.Context, bp *nsmv1.NetworkBlueprint, status metav1.ConditionStatus, reason, msg string, count int) error {
+	if !ownsObject(r.shard, bp) {
+		return nil
+	}
+	bp.Status.ObservedGeneration = bp.Generation
+	bp.Status.ConnectionCount = count
+	bp.Status.Message = msg
+	meta.SetStatusCondition(&bp.Status.Conditions, metav1.Condition{
+		Type:               nsmv1.ConditionReady,
+		Status:             status,
+		Reason:             reason,
+		Message:            msg,
+		ObservedGeneration: bp.Generation,
+	})
+
+	if err := r.client.Status().Update(ctx, bp); err != nil {
+		return fmt.Errorf("failed to update blueprint status: %w", err)
+	}
+	return nil
+}
+
+// labelNodes labels the connections whose source is a scheduled pod with
+// its node, which shards them
+func (r *BlueprintReconciler) labelNodes(ctx context.Context, conns []nsmv1.NetworkConnection) error {
+	for i := range conns {
+		namespace, name, ok := strings.Cut(conns[i].Spec.Source, "/")
+		if !ok {
+			continue
+		}
+		var pod corev1.Pod
+		err := r.client.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, &pod)
+		if apierrors.IsNotFound(err) || pod.Spec.NodeName == "" {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get source pod of connection %s: %w", conns[i].Name, err)
+		}
+		if conns[i].Labels == nil {
+			conns[i].Labels = make(map[string]string)
+		}
+		conns[i].Labels[nsmv1.LabelConnectionNode] = pod.Spec.NodeName
+	}
+	return nil
+}
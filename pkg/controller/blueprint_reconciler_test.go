@@ -0,0 +1,86 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/blueprint"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestBlueprintReconcilerStampsOutConnections(t *testing.T) {
+	bp := &nsmv1.NetworkBlueprint{
+		ObjectMeta: metav1.ObjectMeta{Name: "camera-feed", Namespace: "edge", UID: "bp-uid"},
+		Spec: nsmv1.NetworkBlueprintSpec{
+			Parameters: []nsmv1.BlueprintParameter{{Name: "device"}},
+			Template: nsmv1.NetworkConnectionSpec{
+				Source:         "edge/camera-${device}",
+				Destination:    "vision",
+				ConnectionType: nsmv1.ConnectionTypeKernel,
+			},
+			Instances: []nsmv1.BlueprintInstance{
+				{Parameters: map[string]string{"device": "1"}},
+				{Parameters: map[string]string{"device": "2"}},
+			},
+		},
+	}
+	c := newTestClient(t, bp)
+	r := NewBlueprintReconciler(c, logrus.New())
+	ctx := context.Background()
+	req := reconcile.Request{NamespacedName: client.ObjectKeyFromObject(bp)}
+
+	reconcileAndCount := func(want int) {
+		t.Helper()
+		if _, err := r.Reconcile(ctx, req); err != nil {
+			t.Fatalf("Reconcile() error = %v", err)
+		}
+		var conns nsmv1.NetworkConnectionList
+		if err := c.List(ctx, &conns, client.MatchingLabels{blueprint.LabelBlueprint: "camera-feed"}); err != nil {
+			t.Fatal(err)
+		}
+		if len(conns.Items) != want {
+			t.Fatalf("%d connections generated, want %d", len(conns.Items), want)
+		}
+	}
+
+	reconcileAndCount(2)
+	var conn nsmv1.NetworkConnection
+	if err := c.Get(ctx, client.ObjectKey{Namespace: "edge", Name: "camera-feed-2"}, &conn); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if conn.Spec.Source != "edge/camera-2" || !metav1.IsControlledBy(&conn, bp) {
+		t.Errorf("unexpected connection %+v", conn)
+	}
+
+	// removing an instance removes its connection
+	if err := c.Get(ctx, req.NamespacedName, bp); err != nil {
+		t.Fatal(err)
+	}
+	bp.Spec.Instances = bp.Spec.Instances[:1]
+	if err := c.Update(ctx, bp); err != nil {
+		t.Fatal(err)
+	}
+	reconcileAndCount(1)
+
+	// an invalid blueprint keeps the connections and reports the error
+	if err := c.Get(ctx, req.NamespacedName, bp); err != nil {
+		t.Fatal(err)
+	}
+	bp.Spec.Instances = append(bp.Spec.Instances, nsmv1.BlueprintInstance{})
+	if err := c.Update(ctx, bp); err != nil {
+		t.Fatal(err)
+	}
+	reconcileAndCount(1)
+	if err := c.Get(ctx, req.NamespacedName, bp); err != nil {
+		t.Fatal(err)
+	}
+	cond := meta.FindStatusCondition(bp.Status.Conditions, nsmv1.ConditionReady)
+	if cond == nil || cond.Status != metav1.ConditionFalse || cond.Reason != "ExpandFailed" || bp.Status.ConnectionCount != 1 {
+		t.Errorf("unexpected status %+v", bp.Status)
+	}
+}
@@ -0,0 +1,233 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/budget"
+	"github.com/akos011221/nsm/pkg/canary"
+	"github.com/akos011221/nsm/pkg/idle"
+	"github.com/akos011221/nsm/pkg/probe"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// Delay before a canary suspended in the idle mode is probed again
+const idleProbeDelay = time.Minute
+
+// ProberFactory creates a prober sending its traffic through a device
+type ProberFactory func(device string) probe.Prober
+
+// CanaryReconciler runs canary connections and removes them once their
+// TTL expired. The probes of a canary run in the background rather than
+// on a reconcile worker, reporting the verdict once they are done.
+type CanaryReconciler struct {
+	// Kubernetes client (controller-runtime)
+	client client.Client
+	// Logger
+	logger *logrus.Logger
+	// Creates the prober for the candidate path
+	newProber ProberFactory
+	// Idle mode of the node, probes are suspended while idle
+	idle idle.Signal
+	// Resource budget of the probing, probes are spaced out once it is exceeded
+	budget *budget.Tracker
+	// Canaries being probed
+	running map[types.NamespacedName]bool
+	// Mutex protecting the running canaries
+	mu sync.Mutex
+	// Probes in flight
+	probes sync.WaitGroup
+	// Canaries whose probes are done, reconciled again, nil without a manager
+	done chan event.GenericEvent
+}
+
+// NewCanaryReconciler creates a new canary reconciler
+func NewCanaryReconciler(c client.Client, logger *logrus.Logger, newProber ProberFactory) *CanaryReconciler {
+	return &CanaryReconciler{
+		client:    c,
+		logger:    logger,
+		newProber: newProber,
+		running:   make(map[types.NamespacedName]bool),
+	}
+}
+
+// SetIdleSignal makes the reconciler suspend the probes while the node is
+// in the low-power idle mode
+func (r *CanaryReconciler) SetIdleSignal(signal idle.Signal) {
+	r.idle = signal
+}
+
+// SetBudget makes the reconciler account the resources of the probing
+// and space the probes out while over budget
+func (r *CanaryReconciler) SetBudget(tracker *budget.Tracker) {
+	r.budget = tracker
+}
+
+// SetupWithManager registers the reconciler with the manager
+func (r *CanaryReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	isCanary := predicate.NewPredicateFuncs(func(obj client.Object) bool {
+		conn, ok := obj.(*nsmv1.NetworkConnection)
+		return ok && conn.Spec.Canary != nil
+	})
+
+	r.done = make(chan event.GenericEvent, 1)
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("canary").
+		// the candidate paths are probed once, by the leader
+		For(&nsmv1.NetworkConnection{}, builder.WithPredicates(isCanary)).
+		WatchesRawSource(source.Channel(r.done, &handler.EnqueueRequestForObject{})).
+		Complete(r)
+}
+
+// Reconcile starts the probes of a canary connection once, in the
+// background, and removes the canary once its TTL expired
+func (r *CanaryReconciler) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	var conn nsmv1.NetworkConnection
+	if err := r.client.Get(ctx, req.NamespacedName, &conn); err != nil {
+		return reconcile.Result{}, client.IgnoreNotFound(err)
+	}
+	if conn.Spec.Canary == nil {
+		return reconcile.Result{}, nil
+	}
+
+	// completed canaries only live until their TTL expires
+	if expired, remaining := canary.Expired(conn.Spec.Canary, conn.Status.Canary, time.Now()); expired {
+		r.logger.Infof("Removing expired canary %s/%s", conn.Namespace, conn.Name)
+		if err := r.client.Delete(ctx, &conn); err != nil {
+			return reconcile.Result{}, client.IgnoreNotFound(err)
+		}
+		return reconcile.Result{}, nil
+	} else if remaining > 0 {
+		return reconcile.Result{RequeueAfter: remaining}, nil
+	}
+
+	if r.isRunning(req.NamespacedName) {
+		return reconcile.Result{}, nil
+	}
+	if r.idle != nil && r.idle.Idle() {
+		return reconcile.Result{RequeueAfter: idleProbeDelay}, nil
+	}
+
+	spec := *conn.Spec.Canary
+	if divisor := r.budget.Divisor(); divisor > 1 {
+		if spec.IntervalMs <= 0 {
+			spec.IntervalMs = canary.DefaultIntervalMs
+		}
+		spec.IntervalMs *= divisor
+	}
+	r.logger.Infof("Running canary %s/%s against %s via %q", conn.Namespace, conn.Name, spec.Target, spec.Device)
+
+	// a canary left running by a previous leader is probed again
+	r.setRunning(req.NamespacedName, true)
+	conn.Status.Canary = &nsmv1.CanaryStatus{Phase: nsmv1.CanaryPhaseRunning}
+	conn.Status.State = nsmv1.ConnectionStatePending
+	conn.Status.Message = fmt.Sprintf("probing %s over the candidate path", spec.Target)
+	if err := r.client.Status().Update(ctx, &conn); err != nil {
+		r.setRunning(req.NamespacedName, false)
+		return reconcile.Result{}, fmt.Errorf("failed to update canary status: %w", err)
+	}
+
+	r.probes.Add(1)
+	go func() {
+		defer r.probes.Done()
+		conn := r.probe(ctx, req.NamespacedName, spec)
+		r.setRunning(req.NamespacedName, false)
+		if conn != nil {
+			r.reconcileAgain(ctx, conn)
+		}
+	}()
+	return reconcile.Result{}, nil
+}
+
+// probe sends the synthetic traffic of a canary and reports the verdict
+// in its status, returning the canary unless it was deleted meanwhile
+func (r *CanaryReconciler) probe(ctx context.Context, key types.NamespacedName, spec nsmv1.CanarySpec) *nsmv1.NetworkConnection {
+	var result *nsmv1.CanaryStatus
+	var err error
+	r.budget.Run(func() { result, err = canary.Run(ctx, r.newProber(spec.Device), &spec) })
+	if err != nil {
+		now := metav1.Now()
+		result = &nsmv1.CanaryStatus{
+			Phase:          nsmv1.CanaryPhaseFailed,
+			Message:        err.Error(),
+			CompletionTime: &now,
+		}
+	}
+
+	var conn nsmv1.NetworkConnection
+	if err := r.client.Get(ctx, key, &conn); err != nil {
+		if client.IgnoreNotFound(err) != nil {
+			r.logger.WithError(err).Errorf("Failed to get canary %s", key)
+		}
+		return nil
+	}
+
+	conn.Status.Canary = result
+	conn.Status.Message = result.Message
+	ready := metav1.Condition{
+		Type:               nsmv1.ConditionReady,
+		Status:             metav1.ConditionTrue,
+		Reason:             "CanaryPassed",
+		Message:            result.Message,
+		ObservedGeneration: conn.Generation,
+	}
+	if result.Phase == nsmv1.CanaryPhasePassed {
+		conn.Status.State = nsmv1.ConnectionStateEstablished
+	} else {
+		conn.Status.State = nsmv1.ConnectionStateFailed
+		ready.Status = metav1.ConditionFalse
+		ready.Reason = "CanaryFailed"
+	}
+	meta.SetStatusCondition(&conn.Status.Conditions, ready)
+
+	if err := r.client.Status().Update(ctx, &conn); err != nil {
+		r.logger.WithError(err).Errorf("Failed to update the status of canary %s", key)
+		return &conn
+	}
+	r.logger.Infof("Canary %s %s: %s", key, result.Phase, result.Message)
+	return &conn
+}
+
+// reconcileAgain queues a canary whose probes are done, so it is removed
+// after its TTL or probed again if its verdict couldn't be reported
+func (r *CanaryReconciler) reconcileAgain(ctx context.Context, conn *nsmv1.NetworkConnection) {
+	if r.done == nil {
+		return
+	}
+	select {
+	case r.done <- event.GenericEvent{Object: conn}:
+	case <-ctx.Done():
+	}
+}
+
+// isRunning reports whether the probes of a canary are in flight
+func (r *CanaryReconciler) isRunning(key types.NamespacedName) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.running[key]
+}
+
+// setRunning records whether the probes of a canary are in flight
+func (r *CanaryReconciler) setRunning(key types.NamespacedName, running bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if running {
+		r.running[key] = true
+	} else {
+		delete(r.running, key)
+	}
+}
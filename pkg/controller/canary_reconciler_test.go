@@ -0,0 +1,223 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/budget"
+	"github.com/akos011221/nsm/pkg/probe"
+	"github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// staticProber answers every probe with the same result
+type staticProber struct {
+	ok bool
+}
+
+func (p staticProber) Probe(ctx context.Context, target string, count int, interval time.Duration) ([]probe.Sample, error) {
+	samples := make([]probe.Sample, count)
+	for i := range samples {
+		samples[i] = probe.Sample{RTT: time.Millisecond, OK: p.ok}
+	}
+	return samples, nil
+}
+
+func testCanary() *nsmv1.NetworkConnection {
+	conn := testConnection(nsmv1.ConnectionTypeKernel)
+	conn.Spec.Canary = &nsmv1.CanarySpec{Target: "10.0.0.5:80", Device: "eth1", Probes: 3, IntervalMs: 1, TTLSeconds: 60}
+	return conn
+}
+
+func TestCanaryReconcilerReportsResult(t *testing.T) {
+	for _, ok := range []bool{true, false} {
+		c := newTestClient(t, testCanary())
+		var device string
+		r := NewCanaryReconciler(c, logrus.New(), func(d string) probe.Prober {
+			device = d
+			return staticProber{ok: ok}
+		})
+
+		key := client.ObjectKey{Namespace: "edge", Name: "conn"}
+		if _, err := r.Reconcile(context.Background(), reconcile.Request{NamespacedName: key}); err != nil {
+			t.Fatalf("Reconcile() error = %v", err)
+		}
+		r.probes.Wait()
+		// reconciled again once the probes are done, until the TTL expires
+		res, err := r.Reconcile(context.Background(), reconcile.Request{NamespacedName: key})
+		if err != nil {
+			t.Fatalf("Reconcile() error = %v", err)
+		}
+		if device != "eth1" {
+			t.Errorf("prober bound to %q, want eth1", device)
+		}
+		if res.RequeueAfter <= 0 || res.RequeueAfter > time.Minute {
+			t.Errorf("requeue after %v, want the TTL", res.RequeueAfter)
+		}
+
+		var conn nsmv1.NetworkConnection
+		if err := c.Get(context.Background(), key, &conn); err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+		want, wantState := nsmv1.CanaryPhasePassed, nsmv1.ConnectionStateEstablished
+		if !ok {
+			want, wantState = nsmv1.CanaryPhaseFailed, nsmv1.ConnectionStateFailed
+		}
+		if conn.Status.Canary == nil || conn.Status.Canary.Phase != want || conn.Status.State != wantState {
+			t.Errorf("unexpected status: %+v", conn.Status)
+		}
+		if conn.Status.Canary.Sent != 3 {
+			t.Errorf("sent = %d, want 3", conn.Status.Canary.Sent)
+		}
+	}
+}
+
+func TestCanaryReconcilerRemovesExpired(t *testing.T) {
+	conn := testCanary()
+	done := metav1.NewTime(time.Now().Add(-2 * time.Minute))
+	conn.Status.Canary = &nsmv1.CanaryStatus{Phase: nsmv1.CanaryPhasePassed, CompletionTime: &done}
+	c := newTestClient(t, conn)
+
+	r := NewCanaryReconciler(c, logrus.New(), func(string) probe.Prober {
+		t.Fatalf("expired canary must not be probed again")
+		return nil
+	})
+
+	key := client.ObjectKey{Namespace: "edge", Name: "conn"}
+	if _, err := r.Reconcile(context.Background(), reconcile.Request{NamespacedName: key}); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if err := c.Get(context.Background(), key, &nsmv1.NetworkConnection{}); !apierrors.IsNotFound(err) {
+		t.Errorf("expired canary was not removed: %v", err)
+	}
+}
+
+// idleFlag is a settable idle signal
+type idleFlag bool
+
+func (f *idleFlag) Idle() bool {
+	return bool(*f)
+}
+
+func TestCanaryReconcilerSuspendsProbesWhenIdle(t *testing.T) {
+	c := newTestClient(t, testCanary())
+	probes := 0
+	r := NewCanaryReconciler(c, logrus.New(), func(string) probe.Prober {
+		probes++
+		return staticProber{ok: true}
+	})
+	idle := idleFlag(true)
+	r.SetIdleSignal(&idle)
+
+	key := client.ObjectKey{Namespace: "edge", Name: "conn"}
+	res, err := r.Reconcile(context.Background(), reconcile.Request{NamespacedName: key})
+	if err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if probes != 0 || res.RequeueAfter != idleProbeDelay {
+		t.Errorf("probed %d times while idle, requeue after %v", probes, res.RequeueAfter)
+	}
+
+	idle = false
+	if _, err := r.Reconcile(context.Background(), reconcile.Request{NamespacedName: key}); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	r.probes.Wait()
+	if probes != 1 {
+		t.Errorf("canary not probed after waking up")
+	}
+}
+
+// intervalProber records the interval it was asked to probe at
+type intervalProber struct {
+	interval *time.Duration
+}
+
+func (p intervalProber) Probe(ctx context.Context, target string, count int, interval time.Duration) ([]probe.Sample, error) {
+	*p.interval = interval
+	return staticProber{ok: true}.Probe(ctx, target, count, interval)
+}
+
+func TestCanaryReconcilerSpacesProbesOverBudget(t *testing.T) {
+	c := newTestClient(t, testCanary())
+	var interval time.Duration
+	r := NewCanaryReconciler(c, logrus.New(), func(string) probe.Prober {
+		return intervalProber{interval: &interval}
+	})
+	budgets := budget.NewManager(context.Background(), logrus.New(), time.Second)
+	tracker := budgets.Track("probing", budget.Budget{MemoryBytes: 1})
+	tracker.SetMemory(2)
+	if err := budgets.Check(time.Now().Add(time.Second)); err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	r.SetBudget(tracker)
+
+	key := client.ObjectKey{Namespace: "edge", Name: "conn"}
+	if _, err := r.Reconcile(context.Background(), reconcile.Request{NamespacedName: key}); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	r.probes.Wait()
+	if interval != 2*time.Millisecond {
+		t.Errorf("probed every %s over budget, want 2ms", interval)
+	}
+
+	var conn nsmv1.NetworkConnection
+	if err := c.Get(context.Background(), key, &conn); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if conn.Spec.Canary.IntervalMs != 1 {
+		t.Errorf("spec interval changed to %d", conn.Spec.Canary.IntervalMs)
+	}
+}
+
+// blockingProber answers once released
+type blockingProber struct {
+	release chan struct{}
+}
+
+func (p blockingProber) Probe(ctx context.Context, target string, count int, interval time.Duration) ([]probe.Sample, error) {
+	<-p.release
+	return staticProber{ok: true}.Probe(ctx, target, count, interval)
+}
+
+func TestCanaryReconcilerProbesInBackground(t *testing.T) {
+	c := newTestClient(t, testCanary())
+	release := make(chan struct{})
+	probes := 0
+	r := NewCanaryReconciler(c, logrus.New(), func(string) probe.Prober {
+		probes++
+		return blockingProber{release: release}
+	})
+
+	// the reconcile returns while the probes are in flight
+	key := client.ObjectKey{Namespace: "edge", Name: "conn"}
+	for i := 0; i < 2; i++ {
+		if _, err := r.Reconcile(context.Background(), reconcile.Request{NamespacedName: key}); err != nil {
+			t.Fatalf("Reconcile() error = %v", err)
+		}
+	}
+	var conn nsmv1.NetworkConnection
+	if err := c.Get(context.Background(), key, &conn); err != nil {
+		t.Fatal(err)
+	}
+	if conn.Status.Canary == nil || conn.Status.Canary.Phase != nsmv1.CanaryPhaseRunning {
+		t.Errorf("status while probing = %+v, want Running", conn.Status.Canary)
+	}
+
+	close(release)
+	r.probes.Wait()
+	if probes != 1 {
+		t.Errorf("canary probed %d times, want once", probes)
+	}
+	if err := c.Get(context.Background(), key, &conn); err != nil {
+		t.Fatal(err)
+	}
+	if conn.Status.Canary == nil || conn.Status.Canary.Phase != nsmv1.CanaryPhasePassed {
+		t.Errorf("status after probing = %+v, want Passed", conn.Status.Canary)
+	}
+}
@@ -0,0 +1,1026 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/connection"
+	"github.com/akos011221/nsm/pkg/damping"
+	"github.com/akos011221/nsm/pkg/datapath"
+	"github.com/akos011221/nsm/pkg/keys"
+	"github.com/akos011221/nsm/pkg/pressure"
+	"github.com/akos011221/nsm/pkg/thermal"
+	"github.com/akos011221/nsm/pkg/watchdog"
+	"github.com/akos011221/nsm/pkg/whatif"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// Interval between setup attempts of a failed connection
+const setupRetryInterval = 30 * time.Second
+
+// connectionFinalizer holds a deleted connection until its datapath is torn
+// down, releasing its allocations
+const connectionFinalizer = "nsm.akosrbn.io/teardown"
+
+// encryptedConnectionsTotal counts the encrypted connections established per
+// encryption mode, telling how much traffic the NIC offload takes off the CPU
+var encryptedConnectionsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "nsm_encrypted_connections_total",
+	Help: "Number of encrypted connections established, by encryption mode (offload, software)",
+}, []string{"mode"})
+
+func init() {
+	crmetrics.Registry.MustRegister(encryptedConnectionsTotal)
+}
+
+// ConnectionReconciler reconciles NetworkConnection resources
+type ConnectionReconciler struct {
+	// Kubernetes client (controller-runtime)
+	client client.Client
+	// Logger
+	logger *logrus.Logger
+	// Datapath subsystems enabled on this node
+	caps connection.Capabilities
+	// Datapath programming the connections
+	datapath connection.Datapath
+	// Tunnel keys of encrypted connections, nil if keys aren't managed
+	keys *keys.Store
+	// Node pressure, nil if setups are never throttled
+	pressure pressure.Signal
+	// Priority from which connections are set up even under node pressure
+	criticalPriority int32
+	// Thermal state of the node, nil if connections are never shed
+	thermal thermal.Signal
+	// Priority below which connections are shed near the thermal limits
+	shedPriority int32
+	// Triggers the reconcile of the sheddable connections on thermal changes
+	thermalEvents chan event.GenericEvent
+	// Source pods (namespace/name) whose connections are quiesced, e.g.,
+	// while the PF of their VF is reset
+	quiesced map[string]bool
+	// Mutex protecting the quiesced pods
+	quiesceMu sync.RWMutex
+	// Triggers the reconcile of the connections of quiesced and resumed pods
+	quiesceEvents chan event.GenericEvent
+	// Interval Quiesce checks the connections for being down at
+	quiescePollInterval time.Duration
+	// Flap damping of unstable connections, nil if connections are never damped
+	damper *damping.Damper
+	// Records events on the connections, nil if no events are emitted
+	recorder record.EventRecorder
+	// Node the connections are established on, recorded in their status
+	node string
+	// Progress signal for the watchdog, beating on every successful
+	// reconcile
+	heartbeat *watchdog.Heartbeat
+	// Generation each connection was set up at since the reconciler
+	// started. Established connections missing are set up again, so a
+	// restarted controller applies their datapath on the host again.
+	applied map[string]int64
+	// Mutex protecting the applied generations
+	appliedMu sync.Mutex
+}
+
+// NewConnectionReconciler creates a new connection reconciler
+func NewConnectionReconciler(c client.Client, logger *logrus.Logger, caps connection.Capabilities, datapath connection.Datapath) *ConnectionReconciler {
+	return &ConnectionReconciler{
+		client:   c,
+		logger:   logger,
+		caps:     caps,
+		datapath: datapath,
+
+		applied:             make(map[string]int64),
+		quiesced:            make(map[string]bool),
+		quiesceEvents:       make(chan event.GenericEvent, 1),
+		quiescePollInterval: 200 * time.Millisecond,
+	}
+}
+
+// SetKeyStore makes the reconciler provision the tunnel keys of encrypted
+// connections before setting up their datapath
+func (r *ConnectionReconciler) SetKeyStore(store *keys.Store) {
+	r.keys = store
+}
+
+// SetPressureSignal makes the reconciler defer the setup of connections
+// below the critical priority while the node is under pressure, so the
+// established high-priority connections keep their CPU and memory
+func (r *ConnectionReconciler) SetPressureSignal(signal pressure.Signal, criticalPriority int32) {
+	r.pressure = signal
+	r.criticalPriority = criticalPriority
+}
+
+// SetThermalSignal makes the reconciler tear down the connections below
+// the shed priority while the node is near its thermal limits, and set
+// them up again once it cooled down
+func (r *ConnectionReconciler) SetThermalSignal(signal thermal.Signal, shedPriority int32) {
+	r.thermal = signal
+	r.shedPriority = shedPriority
+	r.thermalEvents = make(chan event.GenericEvent, 1)
+	signal.OnChange(func(bool) {
+		// a pending trigger already reconciles all sheddable connections
+		select {
+		case r.thermalEvents <- event.GenericEvent{Object: &nsmv1.NetworkConnection{}}:
+		default:
+		}
+	})
+}
+
+// SetDamper makes the reconciler hold down connections that keep going
+// up and down, marking them Degraded instead of setting them up again
+func (r *ConnectionReconciler) SetDamper(damper *damping.Damper) {
+	r.damper = damper
+}
+
+// SetRecorder makes the reconciler emit events on the connections, such
+// as the ones given up on after their establish timeout
+func (r *ConnectionReconciler) SetRecorder(recorder record.EventRecorder) {
+	r.recorder = recorder
+}
+
+// SetNode makes the reconciler record the node it establishes the
+// connections on, so they are failed over once the node is lost
+func (r *ConnectionReconciler) SetNode(node string) {
+	r.node = node
+}
+
+// SetHeartbeat makes the reconciler report its successful reconciles, it
+// reconciles on events only so it never stalls
+func (r *ConnectionReconciler) SetHeartbeat(hb *watchdog.Heartbeat) {
+	r.heartbeat = hb
+}
+
+// SetupWithManager registers the reconciler with the manager
+func (r *ConnectionReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	b := ctrl.NewControllerManagedBy(mgr).
+		Named("networkconnection").
+		// the datapath of the node is programmed on every replica
+		WithOptions(nodeScoped()).
+		For(&nsmv1.NetworkConnection{}, builder.WithPredicates(predicate.Funcs{
+			CreateFunc: func(e event.CreateEvent) bool { return r.onNode(e.Object) },
+			// a connection moved to another node is released by this one
+			UpdateFunc:  func(e event.UpdateEvent) bool { return r.onNode(e.ObjectOld) || r.onNode(e.ObjectNew) },
+			DeleteFunc:  func(e event.DeleteEvent) bool { return r.onNode(e.Object) },
+			GenericFunc: func(e event.GenericEvent) bool { return r.onNode(e.Object) },
+		})).
+		// connections migrate once their canary passed
+		Watches(&nsmv1.NetworkConnection{}, handler.EnqueueRequestsFromMapFunc(r.gatedByCanary)).
+		WatchesRawSource(source.Channel(r.quiesceEvents, handler.EnqueueRequestsFromMapFunc(r.quiescible)))
+	if r.thermalEvents != nil {
+		b = b.WatchesRawSource(source.Channel(r.thermalEvents, handler.EnqueueRequestsFromMapFunc(r.sheddable)))
+	}
+	return b.Complete(r)
+}
+
+// onNode reports whether a connection is set up by the node, or not
+// claimed by any node yet
+func (r *ConnectionReconciler) onNode(obj client.Object) bool {
+	conn, ok := obj.(*nsmv1.NetworkConnection)
+	return !ok || r.node == "" || conn.Status.Node == "" || conn.Status.Node == r.node
+}
+
+// sheddable returns the requests of the connections shed near the thermal limits
+func (r *ConnectionReconciler) sheddable(ctx context.Context, _ client.Object) []reconcile.Request {
+	var conns nsmv1.NetworkConnectionList
+	if err := r.client.List(ctx, &conns); err != nil {
+		r.logger.WithError(err).Error("Failed to list connections for thermal shedding")
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, conn := range conns.Items {
+		if conn.Spec.Priority < r.shedPriority {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&conn)})
+		}
+	}
+	return requests
+}
+
+// Reconcile validates a connection against the enabled capabilities
+func (r *ConnectionReconciler) Reconcile(ctx context.Context, req reconcile.Request) (result reconcile.Result, err error) {
+	defer func() {
+		if err == nil {
+			r.heartbeat.Beat()
+		}
+	}()
+	var conn nsmv1.NetworkConnection
+	if err := r.client.Get(ctx, req.NamespacedName, &conn); err != nil {
+		if apierrors.IsNotFound(err) {
+			r.forgetApplied(req.String())
+			if r.damper != nil {
+				r.damper.Forget(req.String())
+			}
+		}
+		return reconcile.Result{}, client.IgnoreNotFound(err)
+	}
+
+	// canaries are run by the canary reconciler
+	if conn.Spec.Canary != nil {
+		return reconcile.Result{}, nil
+	}
+
+	// connections are set up by the node that claimed them
+	if !r.onNode(&conn) {
+		return reconcile.Result{}, r.release(ctx, &conn)
+	}
+
+	if !conn.DeletionTimestamp.IsZero() {
+		if r.damper != nil {
+			r.damper.Forget(req.String())
+		}
+		return reconcile.Result{}, r.teardown(ctx, &conn)
+	}
+
+	if claimed, err := r.claim(ctx, &conn); err != nil || !claimed {
+		return reconcile.Result{}, err
+	}
+
+	// administratively disabled connections keep their allocations, taking
+	// a connection down on purpose isn't a flap
+	if conn.Spec.AdminState == nsmv1.AdminStateDown {
+		if r.damper != nil {
+			r.damper.Forget(req.String())
+		}
+		return reconcile.Result{}, r.adminDown(ctx, &conn)
+	}
+
+	// connections pending past their establish timeout are given up on
+	// until their spec changes
+	if ready := meta.FindStatusCondition(conn.Status.Conditions, nsmv1.ConditionReady); ready != nil && ready.Reason == "EstablishTimeout" {
+		if ready.ObservedGeneration == conn.Generation {
+			return reconcile.Result{}, nil
+		}
+		// a changed spec gets a fresh deadline
+		conn.Status.PendingSince = nil
+	}
+	if deadline, ok := establishDeadline(&conn); ok && !time.Now().Before(deadline) {
+		return reconcile.Result{}, r.establishTimeout(ctx, &conn)
+	}
+
+	result, err = r.reconcile(ctx, &conn)
+	if deadline, ok := establishDeadline(&conn); ok && err == nil {
+		// check the deadline again whatever keeps the connection pending
+		if wait := time.Until(deadline); result.RequeueAfter == 0 || wait < result.RequeueAfter {
+			result.RequeueAfter = max(wait, time.Second)
+		}
+	}
+	return result, err
+}
+
+// reconcile sets up a connection that is neither a canary nor administratively down
+func (r *ConnectionReconciler) reconcile(ctx context.Context, conn *nsmv1.NetworkConnection) (reconcile.Result, error) {
+	req := reconcile.Request{NamespacedName: client.ObjectKeyFromObject(conn)}
+
+	// connections into other namespaces wait for their acceptance there
+	if err := connection.Authorize(ctx, r.client, conn); err != nil {
+		var authErr *connection.AuthorizationError
+		if !errors.As(err, &authErr) {
+			return reconcile.Result{}, err
+		}
+		if r.damper != nil {
+			r.damper.Forget(req.String())
+		}
+		return r.notAccepted(ctx, conn, authErr)
+	}
+	r.observe(conn)
+
+	// connections requiring a disabled subsystem can't be served, say so
+	// instead of leaving them without any feedback
+	var capErr *connection.CapabilityError
+	selection, err := r.caps.Select(conn.Spec)
+	if errors.As(err, &capErr) {
+		r.logger.Warnf("Connection %s/%s is degraded: %s", conn.Namespace, conn.Name, capErr.Message)
+		return reconcile.Result{}, r.markDegraded(ctx, conn, capErr.Reason, capErr.Message)
+	}
+	if r.damper != nil && !conn.Status.Established {
+		if suppressed, reuse := r.damper.Suppressed(req.String(), time.Now()); suppressed {
+			return r.damp(ctx, conn, reuse)
+		}
+	}
+
+	if err := r.clearDegraded(ctx, conn); err != nil {
+		return reconcile.Result{}, err
+	}
+	if name := connection.UnresolvedEndpoint(&conn.Spec); name != "" && !conn.Status.Established {
+		return r.unresolved(ctx, conn, name)
+	}
+	if r.isQuiesced(conn.Spec.Source) {
+		return r.quiesce(ctx, conn)
+	}
+	if r.thermal != nil && conn.Spec.Priority < r.shedPriority && r.thermal.Hot() {
+		return r.shed(ctx, conn)
+	}
+	// a changed spec is set up again, the datapath replaces what it applied
+	if conn.Status.Established && r.upToDate(conn) {
+		return reconcile.Result{}, r.checkLatency(ctx, conn)
+	}
+	if !conn.Status.Established && r.pressure != nil && conn.Spec.Priority < r.criticalPriority && r.pressure.UnderPressure() {
+		return r.deferSetup(ctx, conn)
+	}
+	// a changed spec migrates the connection only once its canary passed
+	if migrating(conn) {
+		passed, reason, msg, err := r.canaryGate(ctx, conn)
+		if err != nil {
+			return reconcile.Result{}, err
+		}
+		if !passed {
+			return r.awaitCanary(ctx, conn, reason, msg)
+		}
+		meta.SetStatusCondition(&conn.Status.Conditions, metav1.Condition{
+			Type:               nsmv1.ConditionCanaryGate,
+			Status:             metav1.ConditionTrue,
+			Reason:             reason,
+			Message:            msg,
+			ObservedGeneration: conn.Generation,
+		})
+	}
+
+	return r.establish(ctx, conn, selection)
+}
+
+// Plan previews how the connection would be reconciled in the current
+// state of the node, without changing the connection or the host
+func (r *ConnectionReconciler) Plan(ctx context.Context, conn *nsmv1.NetworkConnection) (*whatif.ConnectionPlan, error) {
+	conn = conn.DeepCopy()
+	plan := &whatif.ConnectionPlan{
+		Namespace: conn.Namespace,
+		Name:      conn.Name,
+		QoS: whatif.QoS{
+			Priority:      conn.Spec.Priority,
+			BandwidthMbps: conn.Spec.Bandwidth,
+			LatencyMs:     conn.Spec.LatencyRequirement,
+		},
+		Actions: []whatif.Action{},
+	}
+
+	if conn.Spec.Canary != nil {
+		plan.State, plan.Reason = nsmv1.ConnectionStatePending, "Canary"
+		plan.Message = "canaries are probed by the canary reconciler"
+		return plan, nil
+	}
+	if conn.Spec.AdminState == nsmv1.AdminStateDown {
+		plan.State, plan.Reason = nsmv1.ConnectionStateAdminDown, "AdminDown"
+		plan.Message = "connection is administratively down, its allocations are kept"
+		return plan, nil
+	}
+	if ready := meta.FindStatusCondition(conn.Status.Conditions, nsmv1.ConditionReady); ready != nil && ready.Reason == "EstablishTimeout" && ready.ObservedGeneration == conn.Generation {
+		plan.State, plan.Reason, plan.Message = nsmv1.ConnectionStateFailed, "EstablishTimeout", ready.Message
+		return plan, nil
+	}
+	if err := connection.Authorize(ctx, r.client, conn); err != nil {
+		var authErr *connection.AuthorizationError
+		if !errors.As(err, &authErr) {
+			return nil, err
+		}
+		plan.State, plan.Reason, plan.Message = nsmv1.ConnectionStatePending, connection.ReasonNotAccepted, authErr.Message
+		return plan, nil
+	}
+
+	var capErr *connection.CapabilityError
+	selection, err := r.caps.Select(conn.Spec)
+	if errors.As(err, &capErr) {
+		plan.State, plan.Reason, plan.Message = nsmv1.ConnectionStateDegraded, capErr.Reason, capErr.Message
+		return plan, nil
+	}
+	plan.Datapath = selection.Datapath
+	plan.Fallback = selection.Fallback
+	plan.Dataplane = selection.Dataplane
+	plan.Encryption = r.caps.EncryptionMode(conn.Spec)
+	if plan.Encryption != "" && r.keys != nil {
+		plan.KeySecret = keys.SecretName(conn)
+	}
+
+	if r.damper != nil && !conn.Status.Established {
+		if suppressed, reuse := r.damper.Suppressed(client.ObjectKeyFromObject(conn).String(), time.Now()); suppressed {
+			plan.State, plan.Reason = nsmv1.ConnectionStateDegraded, "FlapDamped"
+			plan.Message = fmt.Sprintf("flapping, setup suppressed for %s", reuse)
+			return plan, nil
+		}
+	}
+
+	switch {
+	case connection.UnresolvedEndpoint(&conn.Spec) != "" && !conn.Status.Established:
+		plan.State, plan.Reason = nsmv1.ConnectionStatePending, connection.ReasonEndpointUnresolved
+		plan.Message = unresolvedMessage(connection.UnresolvedEndpoint(&conn.Spec))
+		return plan, nil
+	case r.isQuiesced(conn.Spec.Source):
+		plan.State, plan.Reason, plan.Message = nsmv1.ConnectionStatePending, "Quiesced", quiescedMessage
+		return plan, nil
+	case r.thermal != nil && conn.Spec.Priority < r.shedPriority && r.thermal.Hot():
+		plan.State, plan.Reason, plan.Message = nsmv1.ConnectionStatePending, "ThermalShed", "shed, node near thermal limit"
+		return plan, nil
+	case conn.Status.Established && r.upToDate(conn):
+		plan.State, plan.Reason = nsmv1.ConnectionStateEstablished, "Established"
+		plan.Message = "already established, the datapath is kept"
+		return plan, nil
+	case !conn.Status.Established && r.pressure != nil && conn.Spec.Priority < r.criticalPriority && r.pressure.UnderPressure():
+		plan.State, plan.Reason, plan.Message = nsmv1.ConnectionStatePending, "NodePressure", "setup deferred, node under pressure"
+		return plan, nil
+	}
+	if migrating(conn) {
+		passed, reason, msg, err := r.canaryGate(ctx, conn)
+		if err != nil {
+			return nil, err
+		}
+		if !passed {
+			plan.State, plan.Reason, plan.Message = conn.Status.State, reason, msg
+			return plan, nil
+		}
+	}
+
+	// the datapath reads the selection from the status, as in establish
+	conn.Status.Datapath = selection.Datapath
+	conn.Status.NonAccelerated = selection.Fallback
+	conn.Status.Dataplane = selection.Dataplane
+	conn.Status.Encryption = plan.Encryption
+	conn.Status.KeySecret = plan.KeySecret
+	r.setLatencyBudget(conn)
+	if planner, ok := r.datapath.(datapath.Planner); ok {
+		changes, err := planner.Plan(ctx, conn)
+		if err != nil {
+			plan.State, plan.Reason, plan.Message = nsmv1.ConnectionStateFailed, "SetupFailed", err.Error()
+			return plan, nil
+		}
+		plan.Actions = whatif.Actions(changes)
+	}
+
+	plan.State, plan.Reason = nsmv1.ConnectionStateEstablished, "Established"
+	if selection.Fallback {
+		plan.Message = fmt.Sprintf("non-accelerated: no SR-IOV on the node, using %s fallback", selection.Datapath)
+	}
+	return plan, nil
+}
+
+// establish sets up the datapath of a connection. The datapath rolls back
+// the steps it already applied when one fails, so a failed connection
+// leaves no half-configured interfaces behind and is retried later.
+func (r *ConnectionReconciler) establish(ctx context.Context, conn *nsmv1.NetworkConnection, selection connection.Selection) (reconcile.Result, error) {
+	// the datapath would outlive the connection without the finalizer
+	if controllerutil.AddFinalizer(conn, connectionFinalizer) {
+		if err := r.client.Update(ctx, conn); err != nil {
+			return reconcile.Result{}, fmt.Errorf("failed to add finalizer to connection %s/%s: %w", conn.Namespace, conn.Name, err)
+		}
+	}
+
+	// the datapath implementation reads the selection and whether to
+	// program the NIC crypto offload from the status
+	conn.Status.Datapath = selection.Datapath
+	conn.Status.NonAccelerated = selection.Fallback
+	conn.Status.Dataplane = selection.Dataplane
+	conn.Status.Encryption = r.caps.EncryptionMode(conn.Spec)
+
+	// the datapath reads the key from the Secret in the connection's namespace
+	conn.Status.KeySecret = ""
+	if conn.Status.Encryption != "" && r.keys != nil {
+		if _, err := r.keys.Ensure(ctx, conn); err != nil {
+			return r.setupFailed(ctx, conn, "KeyUnavailable", err)
+		}
+		conn.Status.KeySecret = keys.SecretName(conn)
+	}
+
+	// the datapath sizes the queues for the NIC queueing budget
+	r.setLatencyBudget(conn)
+	conn.Status.PathShares = datapath.PathShares(conn.Spec.LoadSharing)
+
+	if err := r.datapath.Setup(ctx, conn); err != nil {
+		reason := "SetupFailed"
+		var stepErr *datapath.StepError
+		switch {
+		case errors.As(err, &stepErr) && len(stepErr.RollbackErrs) > 0:
+			reason = "RollbackFailed"
+		case errors.Is(err, datapath.ErrUnsupported):
+			// retrying won't help before the spec or the node changes
+			_, err := r.setupFailed(ctx, conn, "DatapathUnsupported", err)
+			return reconcile.Result{}, err
+		}
+		return r.setupFailed(ctx, conn, reason, err)
+	}
+	if conn.Status.Established {
+		r.logger.Infof("Connection %s/%s set up again at generation %d over %s", conn.Namespace, conn.Name, conn.Generation, selection.Datapath)
+	} else {
+		r.logger.Infof("Connection %s/%s established over %s", conn.Namespace, conn.Name, selection.Datapath)
+		if conn.Status.Encryption != "" {
+			encryptedConnectionsTotal.WithLabelValues(conn.Status.Encryption).Inc()
+		}
+	}
+
+	conn.Status.State = nsmv1.ConnectionStateEstablished
+	conn.Status.Established = true
+	conn.Status.ObservedGeneration = conn.Generation
+	r.appliedMu.Lock()
+	r.applied[client.ObjectKeyFromObject(conn).String()] = conn.Generation
+	r.appliedMu.Unlock()
+	conn.Status.Node = r.node
+	conn.Status.Message = ""
+	if selection.Fallback {
+		conn.Status.Message = fmt.Sprintf("non-accelerated: no SR-IOV on the node, using %s fallback", selection.Datapath)
+	}
+	meta.SetStatusCondition(&conn.Status.Conditions, metav1.Condition{
+		Type:               nsmv1.ConditionReady,
+		Status:             metav1.ConditionTrue,
+		Reason:             "Established",
+		ObservedGeneration: conn.Generation,
+	})
+	if err := r.updateStatus(ctx, conn); err != nil {
+		return reconcile.Result{}, err
+	}
+	r.observe(conn)
+	return reconcile.Result{}, nil
+}
+
+// claim records the node as the one setting up a connection no node
+// claimed yet. Of the nodes claiming a connection at the same time, the
+// first status update wins and the others get a conflict, leaving the
+// connection to the winner.
+func (r *ConnectionReconciler) claim(ctx context.Context, conn *nsmv1.NetworkConnection) (bool, error) {
+	if r.node == "" || conn.Status.Node == r.node {
+		return true, nil
+	}
+	conn.Status.Node = r.node
+	if err := r.client.Status().Update(ctx, conn); err != nil {
+		if apierrors.IsConflict(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to claim connection %s/%s: %w", conn.Namespace, conn.Name, err)
+	}
+	r.logger.Infof("Connection %s/%s claimed by node %s", conn.Namespace, conn.Name, r.node)
+	return true, nil
+}
+
+// release tears down the datapath the node set up for a connection that
+// moved to another node, e.g. after this node was lost for a while. The
+// finalizer and status belong to the new node.
+func (r *ConnectionReconciler) release(ctx context.Context, conn *nsmv1.NetworkConnection) error {
+	key := client.ObjectKeyFromObject(conn).String()
+	r.appliedMu.Lock()
+	_, applied := r.applied[key]
+	r.appliedMu.Unlock()
+	if !applied {
+		return nil
+	}
+	r.logger.Warnf("Connection %s/%s moved to node %s, tearing down its datapath", conn.Namespace, conn.Name, conn.Status.Node)
+	if err := r.datapath.Teardown(ctx, conn, false); err != nil {
+		return fmt.Errorf("failed to release connection %s/%s: %w", conn.Namespace, conn.Name, err)
+	}
+	r.forgetApplied(key)
+	return nil
+}
+
+// upToDate reports whether the datapath of an established connection was
+// set up from its current spec since the reconciler started
+func (r *ConnectionReconciler) upToDate(conn *nsmv1.NetworkConnection) bool {
+	r.appliedMu.Lock()
+	defer r.appliedMu.Unlock()
+	generation, ok := r.applied[client.ObjectKeyFromObject(conn).String()]
+	return ok && generation == conn.Generation && conn.Status.ObservedGeneration == conn.Generation
+}
+
+// forgetApplied drops the applied generation of a removed connection
+func (r *ConnectionReconciler) forgetApplied(key string) {
+	r.appliedMu.Lock()
+	defer r.appliedMu.Unlock()
+	delete(r.applied, key)
+}
+
+// checkLatency reports which segment of its path makes an established
+// connection miss its latency requirement, as its metrics come in
+func (r *ConnectionReconciler) checkLatency(ctx context.Context, conn *nsmv1.NetworkConnection) error {
+	violation := ""
+	if conn.Status.LatencyBudget != nil {
+		violation = conn.Status.LatencyBudget.Violation
+	}
+	if !r.setLatencyBudget(conn) {
+		return nil
+	}
+	if budget := conn.Status.LatencyBudget; budget != nil && budget.Violation != "" && budget.Violation != violation {
+		r.logger.Warnf("Connection %s/%s misses its %dms latency requirement with %dms, %s over budget",
+			conn.Namespace, conn.Name, conn.Spec.LatencyRequirement, conn.Status.Metrics.LatencyMs, budget.Violation)
+	}
+	return r.updateStatus(ctx, conn)
+}
+
+// setLatencyBudget splits the latency requirement of a connection into the
+// budgets of its path segments and sets the LatencyBudget condition,
+// reporting whether the status changed
+func (r *ConnectionReconciler) setLatencyBudget(conn *nsmv1.NetworkConnection) bool {
+	budget, err := connection.DecomposeLatency(conn.Spec, conn.Status.Datapath)
+	if err == nil && budget == nil {
+		changed := conn.Status.LatencyBudget != nil
+		conn.Status.LatencyBudget = nil
+		return meta.RemoveStatusCondition(&conn.Status.Conditions, nsmv1.ConditionLatencyBudget) || changed
+	}
+
+	cond := metav1.Condition{
+		Type:               nsmv1.ConditionLatencyBudget,
+		Status:             metav1.ConditionTrue,
+		Reason:             "WithinBudget",
+		ObservedGeneration: conn.Generation,
+	}
+	switch {
+	case err != nil:
+		cond.Status, cond.Reason, cond.Message = metav1.ConditionFalse, "BudgetInfeasible", err.Error()
+	default:
+		budget.Violation = connection.LatencyViolation(budget, conn.Spec.LatencyRequirement, conn.Status.Metrics)
+		if budget.Violation != "" {
+			cond.Status, cond.Reason = metav1.ConditionFalse, budget.Violation+"OverBudget"
+			cond.Message = fmt.Sprintf("observed %dms misses the %dms requirement, %s is over its budget",
+				conn.Status.Metrics.LatencyMs, conn.Spec.LatencyRequirement, budget.Violation)
+		}
+	}
+	changed := !equality.Semantic.DeepEqual(conn.Status.LatencyBudget, budget)
+	conn.Status.LatencyBudget = budget
+	return meta.SetStatusCondition(&conn.Status.Conditions, cond) || changed
+}
+
+// setupFailed marks a connection as failed and retries it later
+func (r *ConnectionReconciler) setupFailed(ctx context.Context, conn *nsmv1.NetworkConnection, reason string, err error) (reconcile.Result, error) {
+	r.logger.Errorf("Failed to set up connection %s/%s: %v", conn.Namespace, conn.Name, err)
+
+	conn.Status.State = nsmv1.ConnectionStateFailed
+	conn.Status.Established = false
+	// nothing encrypts the traffic of a connection without a datapath
+	conn.Status.Encryption = ""
+	conn.Status.Message = err.Error()
+	meta.SetStatusCondition(&conn.Status.Conditions, metav1.Condition{
+		Type:               nsmv1.ConditionReady,
+		Status:             metav1.ConditionFalse,
+		Reason:             reason,
+		Message:            err.Error(),
+		ObservedGeneration: conn.Generation,
+	})
+	return reconcile.Result{RequeueAfter: setupRetryInterval}, r.updateStatus(ctx, conn)
+}
+
+// establishDeadline returns when a pending connection has to be
+// established by, if it has an establish timeout. Connections shed near
+// the thermal limits were taken down on purpose and have no deadline.
+func establishDeadline(conn *nsmv1.NetworkConnection) (time.Time, bool) {
+	if conn.Spec.EstablishTimeoutSeconds <= 0 || conn.Status.Established || conn.Status.PendingSince == nil {
+		return time.Time{}, false
+	}
+	if ready := meta.FindStatusCondition(conn.Status.Conditions, nsmv1.ConditionReady); ready != nil && ready.Reason == "ThermalShed" {
+		return time.Time{}, false
+	}
+	return conn.Status.PendingSince.Add(time.Duration(conn.Spec.EstablishTimeoutSeconds) * time.Second), true
+}
+
+// establishTimeout marks a connection still pending at its deadline as
+// failed, keeping what it was waiting for in the message, and emits an
+// event on it. It isn't retried until its spec changes.
+func (r *ConnectionReconciler) establishTimeout(ctx context.Context, conn *nsmv1.NetworkConnection) error {
+	waiting := conn.Status.Message
+	if ready := meta.FindStatusCondition(conn.Status.Conditions, nsmv1.ConditionReady); waiting == "" && ready != nil {
+		waiting = ready.Reason
+	}
+	msg := fmt.Sprintf("not established within %ds", conn.Spec.EstablishTimeoutSeconds)
+	if waiting != "" {
+		msg += ": " + waiting
+	}
+	r.logger.Warnf("Giving up on connection %s/%s, %s", conn.Namespace, conn.Name, msg)
+	if r.recorder != nil {
+		r.recorder.Event(conn, corev1.EventTypeWarning, "EstablishTimeout", msg)
+	}
+
+	conn.Status.State = nsmv1.ConnectionStateFailed
+	conn.Status.Message = msg
+	meta.SetStatusCondition(&conn.Status.Conditions, metav1.Condition{
+		Type:               nsmv1.ConditionReady,
+		Status:             metav1.ConditionFalse,
+		Reason:             "EstablishTimeout",
+		Message:            msg,
+		ObservedGeneration: conn.Generation,
+	})
+	return r.updateStatus(ctx, conn)
+}
+
+// deferSetup postpones the setup of a non-critical connection until the
+// node pressure is released
+func (r *ConnectionReconciler) deferSetup(ctx context.Context, conn *nsmv1.NetworkConnection) (reconcile.Result, error) {
+	result := reconcile.Result{RequeueAfter: setupRetryInterval}
+	ready := meta.FindStatusCondition(conn.Status.Conditions, nsmv1.ConditionReady)
+	if ready != nil && ready.Reason == "NodePressure" {
+		return result, nil
+	}
+	r.logger.Infof("Deferring setup of connection %s/%s, node under pressure", conn.Namespace, conn.Name)
+
+	msg := "setup deferred, node under pressure"
+	conn.Status.State = nsmv1.ConnectionStatePending
+	conn.Status.Message = msg
+	meta.SetStatusCondition(&conn.Status.Conditions, metav1.Condition{
+		Type:               nsmv1.ConditionReady,
+		Status:             metav1.ConditionFalse,
+		Reason:             "NodePressure",
+		Message:            msg,
+		ObservedGeneration: conn.Generation,
+	})
+	return result, r.updateStatus(ctx, conn)
+}
+
+// migrating reports whether a connection set up from an older spec waits
+// for a canary before it is set up from the current one
+func migrating(conn *nsmv1.NetworkConnection) bool {
+	return conn.Spec.CanaryRef != "" && conn.Status.ObservedGeneration != 0 && conn.Status.ObservedGeneration != conn.Generation
+}
+
+// canaryGate reports whether the canary of a connection passed, with the
+// reason and message of its verdict
+func (r *ConnectionReconciler) canaryGate(ctx context.Context, conn *nsmv1.NetworkConnection) (bool, string, string, error) {
+	var canary nsmv1.NetworkConnection
+	err := r.client.Get(ctx, client.ObjectKey{Namespace: conn.Namespace, Name: conn.Spec.CanaryRef}, &canary)
+	switch {
+	case apierrors.IsNotFound(err):
+		return false, "CanaryMissing", fmt.Sprintf("waiting for canary %s to be created", conn.Spec.CanaryRef), nil
+	case err != nil:
+		return false, "", "", fmt.Errorf("failed to get canary %s/%s: %w", conn.Namespace, conn.Spec.CanaryRef, err)
+	case canary.Spec.Canary == nil:
+		return false, "CanaryMissing", fmt.Sprintf("connection %s is not a canary", conn.Spec.CanaryRef), nil
+	case canary.Status.Canary == nil || canary.Status.Canary.Phase == nsmv1.CanaryPhaseRunning:
+		return false, "CanaryRunning", fmt.Sprintf("waiting for canary %s to complete", conn.Spec.CanaryRef), nil
+	case canary.Status.Canary.Phase != nsmv1.CanaryPhasePassed:
+		return false, "CanaryFailed", fmt.Sprintf("canary %s failed: %s", conn.Spec.CanaryRef, canary.Status.Canary.Message), nil
+	}
+	return true, "CanaryPassed", fmt.Sprintf("canary %s passed: %s", conn.Spec.CanaryRef, canary.Status.Canary.Message), nil
+}
+
+// awaitCanary holds back the changed spec of a connection until its
+// canary passed, the connection keeps its current datapath meanwhile
+func (r *ConnectionReconciler) awaitCanary(ctx context.Context, conn *nsmv1.NetworkConnection, reason, msg string) (reconcile.Result, error) {
+	result := reconcile.Result{RequeueAfter: setupRetryInterval}
+	gate := meta.FindStatusCondition(conn.Status.Conditions, nsmv1.ConditionCanaryGate)
+	if gate != nil && gate.Reason == reason && gate.Message == msg && gate.ObservedGeneration == conn.Generation {
+		return result, nil
+	}
+	r.logger.Infof("Connection %s/%s not migrated to its changed spec: %s", conn.Namespace, conn.Name, msg)
+
+	conn.Status.Message = msg
+	meta.SetStatusCondition(&conn.Status.Conditions, metav1.Condition{
+		Type:               nsmv1.ConditionCanaryGate,
+		Status:             metav1.ConditionFalse,
+		Reason:             reason,
+		Message:            msg,
+		ObservedGeneration: conn.Generation,
+	})
+	return result, r.updateStatus(ctx, conn)
+}
+
+// gatedByCanary returns the requests of the connections waiting for a
+// canary to migrate
+func (r *ConnectionReconciler) gatedByCanary(ctx context.Context, obj client.Object) []reconcile.Request {
+	canary, ok := obj.(*nsmv1.NetworkConnection)
+	if !ok || canary.Spec.Canary == nil {
+		return nil
+	}
+	var conns nsmv1.NetworkConnectionList
+	if err := r.client.List(ctx, &conns, client.InNamespace(canary.Namespace)); err != nil {
+		r.logger.WithError(err).Error("Failed to list connections gated by a canary")
+		return nil
+	}
+	var requests []reconcile.Request
+	for _, conn := range conns.Items {
+		if conn.Spec.CanaryRef == canary.Name && r.onNode(&conn) {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&conn)})
+		}
+	}
+	return requests
+}
+
+// unresolvedMessage tells which NetworkEndpoint a connection waits for
+func unresolvedMessage(name string) string {
+	return fmt.Sprintf("waiting for the address of network endpoint %s", name)
+}
+
+// unresolved holds back a connection until the registry of its node filled
+// in the address of the NetworkEndpoint it references
+func (r *ConnectionReconciler) unresolved(ctx context.Context, conn *nsmv1.NetworkConnection, name string) (reconcile.Result, error) {
+	result := reconcile.Result{RequeueAfter: setupRetryInterval}
+	msg := unresolvedMessage(name)
+	ready := meta.FindStatusCondition(conn.Status.Conditions, nsmv1.ConditionReady)
+	if ready != nil && ready.Reason == connection.ReasonEndpointUnresolved && ready.Message == msg {
+		return result, nil
+	}
+	r.logger.Infof("Connection %s/%s waits for the address of network endpoint %s", conn.Namespace, conn.Name, name)
+
+	conn.Status.State = nsmv1.ConnectionStatePending
+	conn.Status.Message = msg
+	meta.SetStatusCondition(&conn.Status.Conditions, metav1.Condition{
+		Type:               nsmv1.ConditionReady,
+		Status:             metav1.ConditionFalse,
+		Reason:             connection.ReasonEndpointUnresolved,
+		Message:            msg,
+		ObservedGeneration: conn.Generation,
+	})
+	return result, r.updateStatus(ctx, conn)
+}
+
+// shed tears down a low-priority connection while the node is near its
+// thermal limits, keeping its allocations so it comes back quickly
+func (r *ConnectionReconciler) shed(ctx context.Context, conn *nsmv1.NetworkConnection) (reconcile.Result, error) {
+	result := reconcile.Result{RequeueAfter: setupRetryInterval}
+	ready := meta.FindStatusCondition(conn.Status.Conditions, nsmv1.ConditionReady)
+	if !conn.Status.Established && ready != nil && ready.Reason == "ThermalShed" {
+		return result, nil
+	}
+
+	if conn.Status.Established {
+		if err := r.datapath.Teardown(ctx, conn, true); err != nil {
+			return reconcile.Result{}, fmt.Errorf("failed to shed connection %s/%s: %w", conn.Namespace, conn.Name, err)
+		}
+		r.logger.Warnf("Shed connection %s/%s, node near thermal limit", conn.Namespace, conn.Name)
+	}
+	conn.Status.Established = false
+	r.observe(conn)
+
+	msg := "shed, node near thermal limit"
+	conn.Status.State = nsmv1.ConnectionStatePending
+	conn.Status.Message = msg
+	meta.SetStatusCondition(&conn.Status.Conditions, metav1.Condition{
+		Type:               nsmv1.ConditionReady,
+		Status:             metav1.ConditionFalse,
+		Reason:             "ThermalShed",
+		Message:            msg,
+		ObservedGeneration: conn.Generation,
+	})
+	return result, r.updateStatus(ctx, conn)
+}
+
+// observe records whether a connection is established with the damper,
+// catching it going down whatever took it down
+func (r *ConnectionReconciler) observe(conn *nsmv1.NetworkConnection) {
+	if r.damper == nil {
+		return
+	}
+	key := client.ObjectKeyFromObject(conn).String()
+	if r.damper.Observe(key, conn.Status.Established, time.Now()) {
+		r.logger.Warnf("Connection %s flapped, damping penalty %.0f", key, r.damper.Penalty(key, time.Now()))
+	}
+}
+
+// damp holds down a flapping connection until its penalty decayed,
+// marking it Degraded once instead of setting it up again
+func (r *ConnectionReconciler) damp(ctx context.Context, conn *nsmv1.NetworkConnection, reuse time.Duration) (reconcile.Result, error) {
+	result := reconcile.Result{RequeueAfter: reuse}
+	degraded := meta.FindStatusCondition(conn.Status.Conditions, nsmv1.ConditionDegraded)
+	if degraded != nil && degraded.Status == metav1.ConditionTrue && degraded.Reason == "FlapDamped" {
+		return result, nil
+	}
+	r.logger.Warnf("Suppressing setup of flapping connection %s/%s for %s", conn.Namespace, conn.Name, reuse)
+
+	msg := fmt.Sprintf("flapping, setup suppressed for %s", reuse)
+	return result, r.markDegraded(ctx, conn, "FlapDamped", msg)
+}
+
+// notAccepted holds back a connection into a namespace that didn't accept
+// it, tearing it down when the acceptance was revoked
+func (r *ConnectionReconciler) notAccepted(ctx context.Context, conn *nsmv1.NetworkConnection, authErr *connection.AuthorizationError) (reconcile.Result, error) {
+	result := reconcile.Result{RequeueAfter: setupRetryInterval}
+	ready := meta.FindStatusCondition(conn.Status.Conditions, nsmv1.ConditionReady)
+	if !conn.Status.Established && ready != nil && ready.Reason == connection.ReasonNotAccepted && ready.Message == authErr.Message {
+		return result, nil
+	}
+
+	if conn.Status.Established {
+		if err := r.datapath.Teardown(ctx, conn, false); err != nil {
+			return reconcile.Result{}, fmt.Errorf("failed to tear down connection %s/%s: %w", conn.Namespace, conn.Name, err)
+		}
+		r.logger.Warnf("Tore down connection %s/%s, namespace %s no longer accepts it", conn.Namespace, conn.Name, authErr.Namespace)
+	} else {
+		r.logger.Infof("Connection %s/%s waits for namespace %s to accept it", conn.Namespace, conn.Name, authErr.Namespace)
+	}
+
+	conn.Status.State = nsmv1.ConnectionStatePending
+	conn.Status.Established = false
+	conn.Status.Message = authErr.Message
+	meta.SetStatusCondition(&conn.Status.Conditions, metav1.Condition{
+		Type:               nsmv1.ConditionReady,
+		Status:             metav1.ConditionFalse,
+		Reason:             connection.ReasonNotAccepted,
+		Message:            authErr.Message,
+		ObservedGeneration: conn.Generation,
+	})
+	return result, r.updateStatus(ctx, conn)
+}
+
+// teardown removes the datapath of a deleted connection with its
+// allocations, then lets the deletion complete
+func (r *ConnectionReconciler) teardown(ctx context.Context, conn *nsmv1.NetworkConnection) error {
+	if !controllerutil.ContainsFinalizer(conn, connectionFinalizer) {
+		return nil
+	}
+
+	// connections never set up have no datapath to remove
+	if conn.Status.Datapath != "" {
+		if err := r.datapath.Teardown(ctx, conn, false); err != nil {
+			return fmt.Errorf("failed to tear down deleted connection %s/%s: %w", conn.Namespace, conn.Name, err)
+		}
+		r.logger.Infof("Tore down deleted connection %s/%s", conn.Namespace, conn.Name)
+	}
+
+	r.forgetApplied(client.ObjectKeyFromObject(conn).String())
+	controllerutil.RemoveFinalizer(conn, connectionFinalizer)
+	return client.IgnoreNotFound(r.client.Update(ctx, conn))
+}
+
+// adminDown tears down the datapath of a connection but keeps its allocations
+func (r *ConnectionReconciler) adminDown(ctx context.Context, conn *nsmv1.NetworkConnection) error {
+	if conn.Status.State == nsmv1.ConnectionStateAdminDown {
+		return nil
+	}
+
+	if err := r.datapath.Teardown(ctx, conn, true); err != nil {
+		return fmt.Errorf("failed to tear down connection %s/%s: %w", conn.Namespace, conn.Name, err)
+	}
+	r.logger.Infof("Connection %s/%s is administratively down", conn.Namespace, conn.Name)
+
+	msg := "connection is administratively down"
+	conn.Status.State = nsmv1.ConnectionStateAdminDown
+	conn.Status.Established = false
+	conn.Status.Message = msg
+	meta.SetStatusCondition(&conn.Status.Conditions, metav1.Condition{
+		Type:               nsmv1.ConditionReady,
+		Status:             metav1.ConditionFalse,
+		Reason:             "AdminDown",
+		Message:            msg,
+		ObservedGeneration: conn.Generation,
+	})
+
+	return r.updateStatus(ctx, conn)
+}
+
+// markDegraded sets the Degraded condition and state on a connection
+func (r *ConnectionReconciler) markDegraded(ctx context.Context, conn *nsmv1.NetworkConnection, reason, msg string) error {
+	conn.Status.State = nsmv1.ConnectionStateDegraded
+	conn.Status.Established = false
+	conn.Status.Message = msg
+	meta.SetStatusCondition(&conn.Status.Conditions, metav1.Condition{
+		Type:               nsmv1.ConditionDegraded,
+		Status:             metav1.ConditionTrue,
+		Reason:             reason,
+		Message:            msg,
+		ObservedGeneration: conn.Generation,
+	})
+	meta.SetStatusCondition(&conn.Status.Conditions, metav1.Condition{
+		Type:               nsmv1.ConditionReady,
+		Status:             metav1.ConditionFalse,
+		Reason:             reason,
+		Message:            msg,
+		ObservedGeneration: conn.Generation,
+	})
+
+	return r.updateStatus(ctx, conn)
+}
+
+// clearDegraded removes a previously set Degraded condition
+func (r *ConnectionReconciler) clearDegraded(ctx context.Context, conn *nsmv1.NetworkConnection) error {
+	state := conn.Status.State
+	if !meta.IsStatusConditionTrue(conn.Status.Conditions, nsmv1.ConditionDegraded) && state != "" && state != nsmv1.ConnectionStateAdminDown {
+		return nil
+	}
+
+	meta.SetStatusCondition(&conn.Status.Conditions, metav1.Condition{
+		Type:               nsmv1.ConditionDegraded,
+		Status:             metav1.ConditionFalse,
+		Reason:             "CapabilitiesAvailable",
+		ObservedGeneration: conn.Generation,
+	})
+	conn.Status.State = nsmv1.ConnectionStatePending
+	conn.Status.Message = ""
+
+	return r.updateStatus(ctx, conn)
+}
+
+// updateStatus writes the status subresource of a connection. The
+// establish timeout runs from the first status written while pending.
+func (r *ConnectionReconciler) updateStatus(ctx context.Context, conn *nsmv1.NetworkConnection) error {
+	switch {
+	case conn.Status.Established:
+		conn.Status.PendingSince = nil
+	case conn.Status.PendingSince == nil:
+		now := metav1.Now()
+		conn.Status.PendingSince = &now
+	}
+	if err := r.client.Status().Update(ctx, conn); err != nil {
+		return fmt.Errorf("failed to update connection status: %w", err)
+	}
+	return nil
+}
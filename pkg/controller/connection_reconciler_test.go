@@ -0,0 +1,898 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/connection"
+	"github.com/akos011221/nsm/pkg/damping"
+	"github.com/akos011221/nsm/pkg/datapath"
+	"github.com/akos011221/nsm/pkg/keys"
+	"github.com/akos011221/nsm/pkg/netutil"
+	"github.com/akos011221/nsm/pkg/watchdog"
+	"github.com/akos011221/nsm/pkg/whatif"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// recordingDatapath records the calls made by the reconciler
+type recordingDatapath struct {
+	setups    int
+	teardowns int
+	kept      bool
+	setupErr  error
+	// Encryption mode the last connection was set up with
+	encryption string
+}
+
+func (d *recordingDatapath) Setup(ctx context.Context, conn *nsmv1.NetworkConnection) error {
+	d.setups++
+	d.encryption = conn.Status.Encryption
+	return d.setupErr
+}
+
+func (d *recordingDatapath) Teardown(ctx context.Context, conn *nsmv1.NetworkConnection, keepAllocations bool) error {
+	d.teardowns++
+	d.kept = keepAllocations
+	return nil
+}
+
+func newTestClient(t *testing.T, objs ...client.Object) client.Client {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatalf("failed to build scheme: %v", err)
+	}
+	if err := nsmv1.AddToScheme(scheme); err != nil {
+		t.Fatalf("failed to build scheme: %v", err)
+	}
+	return fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(objs...).
+		WithStatusSubresource(&nsmv1.NetworkConnection{}, &nsmv1.NetworkService{}, &nsmv1.NetworkIntent{}, &nsmv1.NetworkBlueprint{}, &nsmv1.NetworkFirmwareUpdate{}).
+		Build()
+}
+
+func testConnection(connType string) *nsmv1.NetworkConnection {
+	return &nsmv1.NetworkConnection{
+		ObjectMeta: metav1.ObjectMeta{Name: "conn", Namespace: "edge"},
+		Spec: nsmv1.NetworkConnectionSpec{
+			Source:         "edge/pod",
+			Destination:    "svc",
+			ConnectionType: connType,
+		},
+	}
+}
+
+func reconcileConnection(t *testing.T, r *ConnectionReconciler, c client.Client) *nsmv1.NetworkConnection {
+	t.Helper()
+	key := client.ObjectKey{Namespace: "edge", Name: "conn"}
+	if _, err := r.Reconcile(context.Background(), reconcile.Request{NamespacedName: key}); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+
+	var conn nsmv1.NetworkConnection
+	if err := c.Get(context.Background(), key, &conn); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	return &conn
+}
+
+func TestConnectionReconcilerDegradedOnDisabledSubsystem(t *testing.T) {
+	c := newTestClient(t, testConnection(nsmv1.ConnectionTypeSRIOV))
+	r := NewConnectionReconciler(c, logrus.New(), connection.Capabilities{SRIOV: false}, &recordingDatapath{})
+
+	conn := reconcileConnection(t, r, c)
+	if conn.Status.State != nsmv1.ConnectionStateDegraded {
+		t.Errorf("state = %s, want Degraded", conn.Status.State)
+	}
+	cond := meta.FindStatusCondition(conn.Status.Conditions, nsmv1.ConditionDegraded)
+	if cond == nil || cond.Status != metav1.ConditionTrue || cond.Reason != connection.ReasonSRIOVDisabled {
+		t.Fatalf("unexpected Degraded condition: %+v", cond)
+	}
+
+	// enabling the subsystem clears the condition
+	r.caps.SRIOV = true
+	conn = reconcileConnection(t, r, c)
+	if meta.IsStatusConditionTrue(conn.Status.Conditions, nsmv1.ConditionDegraded) {
+		t.Errorf("Degraded condition was not cleared")
+	}
+}
+
+func TestConnectionReconcilerHeartbeat(t *testing.T) {
+	c := newTestClient(t, testConnection(nsmv1.ConnectionTypeKernel))
+	r := NewConnectionReconciler(c, logrus.New(), connection.Capabilities{}, &recordingDatapath{})
+	wd := watchdog.NewWatchdog(context.Background(), logrus.New(), 3, false)
+	r.SetHeartbeat(wd.Register("connection reconciler", nil))
+
+	registered := wd.Heartbeats(time.Now())[0].LastBeat
+	reconcileConnection(t, r, c)
+	if hb := wd.Heartbeats(time.Now())[0]; !hb.LastBeat.After(registered) || hb.Stalled {
+		t.Errorf("successful reconcile not reported: %+v", hb)
+	}
+}
+
+func TestConnectionReconcilerAdminDown(t *testing.T) {
+	conn := testConnection(nsmv1.ConnectionTypeKernel)
+	conn.Spec.AdminState = nsmv1.AdminStateDown
+	c := newTestClient(t, conn)
+	dp := &recordingDatapath{}
+	r := NewConnectionReconciler(c, logrus.New(), connection.Capabilities{}, dp)
+
+	got := reconcileConnection(t, r, c)
+	if got.Status.State != nsmv1.ConnectionStateAdminDown || got.Status.Established {
+		t.Errorf("unexpected status: %+v", got.Status)
+	}
+	if dp.teardowns != 1 || !dp.kept {
+		t.Errorf("datapath teardown = %d (keep allocations %t), want 1 keeping allocations", dp.teardowns, dp.kept)
+	}
+
+	// a second reconcile must not tear down again
+	reconcileConnection(t, r, c)
+	if dp.teardowns != 1 {
+		t.Errorf("teardown repeated: %d", dp.teardowns)
+	}
+
+	// resuming moves the connection out of AdminDown
+	got.Spec.AdminState = nsmv1.AdminStateUp
+	if err := c.Update(context.Background(), got); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	got = reconcileConnection(t, r, c)
+	if got.Status.State == nsmv1.ConnectionStateAdminDown {
+		t.Errorf("connection still AdminDown after resume")
+	}
+}
+
+func TestConnectionReconcilerTearsDownDeletedConnections(t *testing.T) {
+	c := newTestClient(t, testConnection(nsmv1.ConnectionTypeKernel))
+	dp := &recordingDatapath{}
+	r := NewConnectionReconciler(c, logrus.New(), connection.Capabilities{}, dp)
+
+	got := reconcileConnection(t, r, c)
+	if !got.Status.Established || !controllerutil.ContainsFinalizer(got, connectionFinalizer) {
+		t.Fatalf("established connection without finalizer: %+v", got)
+	}
+
+	// the finalizer holds the deletion until the datapath is gone
+	if err := c.Delete(context.Background(), got); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	key := client.ObjectKey{Namespace: "edge", Name: "conn"}
+	if _, err := r.Reconcile(context.Background(), reconcile.Request{NamespacedName: key}); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if dp.teardowns != 1 || dp.kept {
+		t.Errorf("datapath teardown = %d (keep allocations %t), want 1 releasing allocations", dp.teardowns, dp.kept)
+	}
+	if err := c.Get(context.Background(), key, &nsmv1.NetworkConnection{}); !apierrors.IsNotFound(err) {
+		t.Errorf("deleted connection still present: %v", err)
+	}
+}
+
+func TestConnectionReconcilerSetupFailure(t *testing.T) {
+	c := newTestClient(t, testConnection(nsmv1.ConnectionTypeKernel))
+	dp := &recordingDatapath{setupErr: &datapath.StepError{Step: "routes", Err: errors.New("no such device"), RolledBack: []string{"vf"}}}
+	r := NewConnectionReconciler(c, logrus.New(), connection.Capabilities{}, dp)
+
+	key := client.ObjectKey{Namespace: "edge", Name: "conn"}
+	res, err := r.Reconcile(context.Background(), reconcile.Request{NamespacedName: key})
+	if err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if res.RequeueAfter != setupRetryInterval {
+		t.Errorf("requeue after %v, want %v", res.RequeueAfter, setupRetryInterval)
+	}
+
+	var conn nsmv1.NetworkConnection
+	if err := c.Get(context.Background(), key, &conn); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if conn.Status.State != nsmv1.ConnectionStateFailed || conn.Status.Established {
+		t.Errorf("unexpected status: %+v", conn.Status)
+	}
+	cond := meta.FindStatusCondition(conn.Status.Conditions, nsmv1.ConditionReady)
+	if cond == nil || cond.Reason != "SetupFailed" || !strings.Contains(cond.Message, "step routes failed") {
+		t.Errorf("failure reason not recorded: %+v", cond)
+	}
+
+	// the retry succeeds once the datapath recovers
+	dp.setupErr = nil
+	got := reconcileConnection(t, r, c)
+	if got.Status.State != nsmv1.ConnectionStateEstablished || !got.Status.Established || dp.setups != 2 {
+		t.Errorf("connection not established on retry: %+v", got.Status)
+	}
+
+	// established connections are not set up again
+	reconcileConnection(t, r, c)
+	if dp.setups != 2 {
+		t.Errorf("established connection set up again")
+	}
+}
+
+func TestConnectionReconcilerSetsUpChangedSpec(t *testing.T) {
+	c := newTestClient(t, testConnection(nsmv1.ConnectionTypeKernel))
+	dp := &recordingDatapath{}
+	r := NewConnectionReconciler(c, logrus.New(), connection.Capabilities{}, dp)
+
+	conn := reconcileConnection(t, r, c)
+	if !conn.Status.Established || conn.Status.ObservedGeneration != conn.Generation || dp.setups != 1 {
+		t.Fatalf("connection not established: %+v", conn.Status)
+	}
+
+	// a spec change bumps the generation, the datapath is set up again; the
+	// fake client leaves the generation to the test
+	conn.Spec.Bandwidth = 100
+	conn.Generation++
+	if err := c.Update(context.Background(), conn); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	got := reconcileConnection(t, r, c)
+	if got.Generation == conn.Status.ObservedGeneration {
+		t.Fatalf("generation not bumped by the spec change")
+	}
+	if dp.setups != 2 || got.Status.ObservedGeneration != got.Generation || !got.Status.Established {
+		t.Errorf("changed connection not set up again: setups = %d, status = %+v", dp.setups, got.Status)
+	}
+
+	reconcileConnection(t, r, c)
+	if dp.setups != 2 {
+		t.Errorf("unchanged connection set up again")
+	}
+}
+
+func TestConnectionReconcilerWaitsForCanaryToMigrate(t *testing.T) {
+	conn := testConnection(nsmv1.ConnectionTypeKernel)
+	conn.Spec.CanaryRef = "canary"
+	conn.Generation = 1
+	c := newTestClient(t, conn)
+	dp := &recordingDatapath{}
+	r := NewConnectionReconciler(c, logrus.New(), connection.Capabilities{}, dp)
+
+	// the first setup doesn't wait for the canary
+	conn = reconcileConnection(t, r, c)
+	if !conn.Status.Established || dp.setups != 1 {
+		t.Fatalf("connection not established: %+v", conn.Status)
+	}
+
+	conn.Spec.Bandwidth = 100
+	conn.Generation++
+	if err := c.Update(context.Background(), conn); err != nil {
+		t.Fatal(err)
+	}
+	got := reconcileConnection(t, r, c)
+	gate := meta.FindStatusCondition(got.Status.Conditions, nsmv1.ConditionCanaryGate)
+	if dp.setups != 1 || !got.Status.Established || gate == nil || gate.Reason != "CanaryMissing" {
+		t.Fatalf("changed spec set up without its canary: setups = %d, gate = %+v", dp.setups, gate)
+	}
+
+	canary := &nsmv1.NetworkConnection{
+		ObjectMeta: metav1.ObjectMeta{Name: "canary", Namespace: "edge"},
+		Spec: nsmv1.NetworkConnectionSpec{
+			Source:         "edge/pod",
+			Destination:    "svc",
+			ConnectionType: nsmv1.ConnectionTypeKernel,
+			Canary:         &nsmv1.CanarySpec{},
+		},
+	}
+	if err := c.Create(context.Background(), canary); err != nil {
+		t.Fatal(err)
+	}
+	canary.Status.Canary = &nsmv1.CanaryStatus{Phase: nsmv1.CanaryPhaseFailed, Message: "loss 20%"}
+	if err := c.Status().Update(context.Background(), canary); err != nil {
+		t.Fatal(err)
+	}
+	got = reconcileConnection(t, r, c)
+	if gate := meta.FindStatusCondition(got.Status.Conditions, nsmv1.ConditionCanaryGate); dp.setups != 1 || gate.Reason != "CanaryFailed" {
+		t.Fatalf("migrated after a failed canary: setups = %d, gate = %+v", dp.setups, gate)
+	}
+
+	// the connection waiting for the canary is enqueued by its verdict
+	if requests := r.gatedByCanary(context.Background(), canary); len(requests) != 1 || requests[0].Name != "conn" {
+		t.Errorf("gatedByCanary() = %v, want the connection", requests)
+	}
+
+	canary.Status.Canary = &nsmv1.CanaryStatus{Phase: nsmv1.CanaryPhasePassed, Message: "all checks passed"}
+	if err := c.Status().Update(context.Background(), canary); err != nil {
+		t.Fatal(err)
+	}
+	got = reconcileConnection(t, r, c)
+	gate = meta.FindStatusCondition(got.Status.Conditions, nsmv1.ConditionCanaryGate)
+	if dp.setups != 2 || got.Status.ObservedGeneration != got.Generation || gate.Status != metav1.ConditionTrue {
+		t.Errorf("not migrated after the canary passed: setups = %d, gate = %+v", dp.setups, gate)
+	}
+}
+
+func TestConnectionReconcilerReappliesAfterRestart(t *testing.T) {
+	c := newTestClient(t, testConnection(nsmv1.ConnectionTypeKernel))
+	if conn := reconcileConnection(t, NewConnectionReconciler(c, logrus.New(), connection.Capabilities{}, &recordingDatapath{}), c); !conn.Status.Established {
+		t.Fatalf("connection not established: %+v", conn.Status)
+	}
+
+	// a restarted controller applies the datapath of established
+	// connections again, once
+	dp := &recordingDatapath{}
+	r := NewConnectionReconciler(c, logrus.New(), connection.Capabilities{}, dp)
+	if conn := reconcileConnection(t, r, c); dp.setups != 1 || !conn.Status.Established {
+		t.Errorf("established connection not applied again after a restart: setups = %d", dp.setups)
+	}
+	reconcileConnection(t, r, c)
+	if dp.setups != 1 {
+		t.Errorf("established connection applied again without a change")
+	}
+}
+
+func TestConnectionReconcilerRefusesUnsupportedDatapath(t *testing.T) {
+	c := newTestClient(t, testConnection(nsmv1.ConnectionTypeVXLAN))
+	r := NewConnectionReconciler(c, logrus.New(), connection.Capabilities{}, datapath.UnsupportedDatapath{})
+
+	key := client.ObjectKey{Namespace: "edge", Name: "conn"}
+	res, err := r.Reconcile(context.Background(), reconcile.Request{NamespacedName: key})
+	if err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if res.RequeueAfter != 0 {
+		t.Errorf("unsupported connection retried after %v", res.RequeueAfter)
+	}
+
+	var conn nsmv1.NetworkConnection
+	if err := c.Get(context.Background(), key, &conn); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if conn.Status.State != nsmv1.ConnectionStateFailed || conn.Status.Established {
+		t.Errorf("unsupported connection not failed: %+v", conn.Status)
+	}
+	if cond := meta.FindStatusCondition(conn.Status.Conditions, nsmv1.ConditionReady); cond == nil || cond.Reason != "DatapathUnsupported" {
+		t.Errorf("Ready = %+v, want DatapathUnsupported", cond)
+	}
+}
+
+func TestConnectionReconcilerFallbackDatapath(t *testing.T) {
+	c := newTestClient(t, testConnection(nsmv1.ConnectionTypeSRIOV))
+	dp := &recordingDatapath{}
+	r := NewConnectionReconciler(c, logrus.New(), connection.Capabilities{Fallback: nsmv1.DatapathMacvlan}, dp)
+
+	conn := reconcileConnection(t, r, c)
+	if conn.Status.State != nsmv1.ConnectionStateEstablished || dp.setups != 1 {
+		t.Fatalf("sriov connection not established over the fallback: %+v", conn.Status)
+	}
+	if conn.Status.Datapath != nsmv1.DatapathMacvlan || !conn.Status.NonAccelerated {
+		t.Errorf("connection not flagged as non-accelerated: %+v", conn.Status)
+	}
+	if meta.IsStatusConditionTrue(conn.Status.Conditions, nsmv1.ConditionDegraded) {
+		t.Errorf("fallback connection must not be degraded")
+	}
+}
+
+func TestConnectionReconcilerNegotiatesDataplane(t *testing.T) {
+	// VPP forwards the kernel connections, without QinQ
+	caps := connection.Capabilities{Dataplanes: []connection.Dataplane{{
+		Name:      connection.DataplaneVPP,
+		Datapaths: []string{nsmv1.ConnectionTypeKernel},
+		Features:  []string{connection.FeatureL2},
+	}}}
+	tests := []struct {
+		name string
+		vlan *nsmv1.VLANSpec
+		want string
+	}{
+		{"untagged", nil, connection.DataplaneVPP},
+		{"single tag", &nsmv1.VLANSpec{OuterVID: 100}, connection.DataplaneVPP},
+		{"QinQ", &nsmv1.VLANSpec{OuterVID: 100, InnerVID: 20}, connection.DataplaneKernel},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obj := testConnection(nsmv1.ConnectionTypeKernel)
+			obj.Spec.VLAN = tt.vlan
+			c := newTestClient(t, obj)
+			r := NewConnectionReconciler(c, logrus.New(), caps, &recordingDatapath{})
+
+			conn := reconcileConnection(t, r, c)
+			if conn.Status.State != nsmv1.ConnectionStateEstablished || conn.Status.Dataplane != tt.want {
+				t.Errorf("connection established = %s on %q, want it on %s", conn.Status.State, conn.Status.Dataplane, tt.want)
+			}
+		})
+	}
+}
+
+func TestConnectionReconcilerEncryptionOffload(t *testing.T) {
+	tests := []struct {
+		name    string
+		offload map[string]bool
+		want    string
+	}{
+		{"offloaded", map[string]bool{nsmv1.EncryptionIPsec: true}, nsmv1.EncryptionModeOffload},
+		{"software", map[string]bool{nsmv1.EncryptionTLS: true}, nsmv1.EncryptionModeSoftware},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obj := testConnection(nsmv1.ConnectionTypeVXLAN)
+			obj.Spec.Encryption = nsmv1.EncryptionIPsec
+			c := newTestClient(t, obj)
+			dp := &recordingDatapath{}
+			r := NewConnectionReconciler(c, logrus.New(), connection.Capabilities{CryptoOffload: tt.offload}, dp)
+			before := testutil.ToFloat64(encryptedConnectionsTotal.WithLabelValues(tt.want))
+
+			conn := reconcileConnection(t, r, c)
+			if conn.Status.State != nsmv1.ConnectionStateEstablished {
+				t.Fatalf("connection not established: %+v", conn.Status)
+			}
+			if dp.encryption != tt.want || conn.Status.Encryption != tt.want {
+				t.Errorf("encryption = %q (datapath %q), want %q", conn.Status.Encryption, dp.encryption, tt.want)
+			}
+			if diff := testutil.ToFloat64(encryptedConnectionsTotal.WithLabelValues(tt.want)) - before; diff != 1 {
+				t.Errorf("%s encrypted connections counter increased by %v, want 1", tt.want, diff)
+			}
+		})
+	}
+}
+
+func TestConnectionReconcilerProvisionsTenantKey(t *testing.T) {
+	obj := testConnection(nsmv1.ConnectionTypeWireGuard)
+	c := newTestClient(t, obj)
+	r := NewConnectionReconciler(c, logrus.New(), connection.Capabilities{}, &recordingDatapath{})
+	r.SetKeyStore(keys.NewStore(c, logrus.New(), types.NamespacedName{}))
+
+	conn := reconcileConnection(t, r, c)
+	if conn.Status.State != nsmv1.ConnectionStateEstablished || conn.Status.KeySecret != "nsm-key-conn" {
+		t.Fatalf("unexpected status %+v", conn.Status)
+	}
+
+	var secret corev1.Secret
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "edge", Name: "nsm-key-conn"}, &secret); err != nil {
+		t.Fatalf("key secret not created in the connection namespace: %v", err)
+	}
+	if len(secret.Data[keys.DataKey]) == 0 {
+		t.Errorf("key secret holds no key")
+	}
+}
+
+// pressureFlag is a settable node pressure signal
+type pressureFlag bool
+
+func (p *pressureFlag) UnderPressure() bool {
+	return bool(*p)
+}
+
+func TestConnectionReconcilerDefersSetupUnderPressure(t *testing.T) {
+	critical := testConnection(nsmv1.ConnectionTypeKernel)
+	critical.Name = "critical"
+	critical.Spec.Priority = 100
+	c := newTestClient(t, testConnection(nsmv1.ConnectionTypeKernel), critical)
+	dp := &recordingDatapath{}
+	r := NewConnectionReconciler(c, logrus.New(), connection.Capabilities{}, dp)
+	pressured := pressureFlag(true)
+	r.SetPressureSignal(&pressured, 100)
+
+	conn := reconcileConnection(t, r, c)
+	if conn.Status.State != nsmv1.ConnectionStatePending || dp.setups != 0 {
+		t.Fatalf("non-critical connection set up under pressure: %+v", conn.Status)
+	}
+	if cond := meta.FindStatusCondition(conn.Status.Conditions, nsmv1.ConditionReady); cond == nil || cond.Reason != "NodePressure" {
+		t.Errorf("unexpected Ready condition: %+v", cond)
+	}
+
+	// critical connections are still set up
+	key := types.NamespacedName{Namespace: "edge", Name: "critical"}
+	if _, err := r.Reconcile(context.Background(), reconcile.Request{NamespacedName: key}); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if dp.setups != 1 {
+		t.Errorf("critical connection not set up under pressure")
+	}
+
+	// the deferred connection is set up once the pressure is released
+	pressured = false
+	conn = reconcileConnection(t, r, c)
+	if conn.Status.State != nsmv1.ConnectionStateEstablished || dp.setups != 2 {
+		t.Errorf("deferred connection not set up after the pressure: %+v", conn.Status)
+	}
+}
+
+// thermalFlag is a settable thermal signal
+type thermalFlag struct {
+	hot      bool
+	onChange func(bool)
+}
+
+func (f *thermalFlag) Hot() bool {
+	return f.hot
+}
+
+func (f *thermalFlag) OnChange(fn func(bool)) {
+	f.onChange = fn
+}
+
+func TestConnectionReconcilerShedsWhenHot(t *testing.T) {
+	critical := testConnection(nsmv1.ConnectionTypeKernel)
+	critical.Name = "critical"
+	critical.Spec.Priority = 100
+	c := newTestClient(t, testConnection(nsmv1.ConnectionTypeKernel), critical)
+	dp := &recordingDatapath{}
+	r := NewConnectionReconciler(c, logrus.New(), connection.Capabilities{}, dp)
+	signal := &thermalFlag{}
+	r.SetThermalSignal(signal, 50)
+
+	conn := reconcileConnection(t, r, c)
+	if !conn.Status.Established {
+		t.Fatalf("connection not established while cool: %+v", conn.Status)
+	}
+
+	// getting hot triggers the reconcile of the low-priority connections only
+	signal.hot = true
+	signal.onChange(true)
+	select {
+	case <-r.thermalEvents:
+	default:
+		t.Fatalf("thermal change did not trigger a reconcile")
+	}
+	requests := r.sheddable(context.Background(), nil)
+	if len(requests) != 1 || requests[0].Name != "conn" {
+		t.Errorf("sheddable = %v, want the low-priority connection", requests)
+	}
+
+	conn = reconcileConnection(t, r, c)
+	if conn.Status.Established || dp.teardowns != 1 || !dp.kept {
+		t.Fatalf("connection not shed keeping its allocations: %+v", conn.Status)
+	}
+	if cond := meta.FindStatusCondition(conn.Status.Conditions, nsmv1.ConditionReady); cond == nil || cond.Reason != "ThermalShed" {
+		t.Errorf("unexpected Ready condition: %+v", cond)
+	}
+
+	// shed connections aren't torn down again
+	reconcileConnection(t, r, c)
+	if dp.teardowns != 1 {
+		t.Errorf("shed connection torn down %d times", dp.teardowns)
+	}
+
+	// cooling down sets it up again
+	signal.hot = false
+	conn = reconcileConnection(t, r, c)
+	if !conn.Status.Established || dp.setups != 2 {
+		t.Errorf("connection not set up after cooling down: %+v", conn.Status)
+	}
+}
+
+func TestConnectionReconcilerPlan(t *testing.T) {
+	nl := netutil.NewFake()
+	nl.Links["usb0"] = netutil.Link{Name: "usb0", Type: "device", Up: true}
+	applier := datapath.NewApplier(datapath.NewNetlinkBackend(nl, datapath.NewHostBackend()), logrus.New())
+	dp := datapath.NewFallbackDatapath(applier, "usb0", connection.NopDatapath{})
+	r := NewConnectionReconciler(newTestClient(t), logrus.New(), connection.Capabilities{Fallback: nsmv1.DatapathMacvlan}, dp)
+
+	// an sriov connection on a node without SR-IOV plans a macvlan link
+	conn := testConnection(nsmv1.ConnectionTypeSRIOV)
+	conn.Spec.Priority = 10
+	conn.Spec.Bandwidth = 100
+	plan, err := r.Plan(context.Background(), conn)
+	if err != nil {
+		t.Fatalf("Plan() error = %v", err)
+	}
+	if plan.State != nsmv1.ConnectionStateEstablished || plan.Datapath != nsmv1.DatapathMacvlan || !plan.Fallback {
+		t.Errorf("unexpected plan %+v", plan)
+	}
+	link := datapath.FallbackLinkName(conn)
+	if len(plan.Actions) != 1 || plan.Actions[0] != (whatif.Action{Op: "create", Kind: "link", Object: "link/" + link}) {
+		t.Errorf("actions = %+v, want the fallback link created", plan.Actions)
+	}
+	if plan.QoS.Priority != 10 || plan.QoS.BandwidthMbps != 100 {
+		t.Errorf("qos = %+v", plan.QoS)
+	}
+	if _, ok := nl.Links[link]; ok || conn.Status.Datapath != "" {
+		t.Errorf("planning changed the host or the connection")
+	}
+
+	// without a fallback the connection would be degraded
+	r.caps.Fallback = ""
+	if plan, _ := r.Plan(context.Background(), conn); plan.State != nsmv1.ConnectionStateDegraded || plan.Reason != connection.ReasonSRIOVDisabled {
+		t.Errorf("unexpected plan without fallback %+v", plan)
+	}
+
+	// non-critical setups are deferred under pressure
+	pressured := pressureFlag(true)
+	r.SetPressureSignal(&pressured, 100)
+	kernel := testConnection(nsmv1.ConnectionTypeKernel)
+	if plan, _ := r.Plan(context.Background(), kernel); plan.State != nsmv1.ConnectionStatePending || plan.Reason != "NodePressure" || len(plan.Actions) != 0 {
+		t.Errorf("unexpected plan under pressure %+v", plan)
+	}
+
+	// established connections keep their datapath
+	kernel.Status.Established = true
+	if plan, _ := r.Plan(context.Background(), kernel); plan.State != nsmv1.ConnectionStateEstablished || len(plan.Actions) != 0 {
+		t.Errorf("unexpected plan of an established connection %+v", plan)
+	}
+}
+
+func TestConnectionReconcilerDampsFlaps(t *testing.T) {
+	c := newTestClient(t, testConnection(nsmv1.ConnectionTypeKernel))
+	dp := &recordingDatapath{}
+	r := NewConnectionReconciler(c, logrus.New(), connection.Capabilities{}, dp)
+	signal := &thermalFlag{}
+	r.SetThermalSignal(signal, 50)
+	r.SetDamper(damping.NewDamper(damping.DefaultConfig()))
+
+	// the node keeps heating up and cooling down, three sheds in a row
+	// cross the suppress threshold
+	for i := 0; i < 3; i++ {
+		signal.hot = false
+		if conn := reconcileConnection(t, r, c); !conn.Status.Established {
+			t.Fatalf("connection not established in cycle %d: %+v", i, conn.Status)
+		}
+		signal.hot = true
+		reconcileConnection(t, r, c)
+	}
+
+	// cooled down, the flapping connection is held down instead of set up again
+	signal.hot = false
+	conn := reconcileConnection(t, r, c)
+	if conn.Status.Established || dp.setups != 3 || conn.Status.State != nsmv1.ConnectionStateDegraded {
+		t.Fatalf("flapping connection set up again: %+v", conn.Status)
+	}
+	if cond := meta.FindStatusCondition(conn.Status.Conditions, nsmv1.ConditionDegraded); cond == nil || cond.Reason != "FlapDamped" {
+		t.Errorf("unexpected Degraded condition: %+v", cond)
+	}
+	plan, err := r.Plan(context.Background(), conn)
+	if err != nil || plan.Reason != "FlapDamped" {
+		t.Errorf("Plan() = %+v, %v, want FlapDamped", plan, err)
+	}
+
+	// while suppressed the status isn't written again
+	result, err := r.Reconcile(context.Background(), reconcile.Request{NamespacedName: client.ObjectKeyFromObject(conn)})
+	if err != nil || result.RequeueAfter <= 0 {
+		t.Fatalf("Reconcile() = %+v, %v, want a requeue once the penalty decayed", result, err)
+	}
+	var after nsmv1.NetworkConnection
+	if err := c.Get(context.Background(), client.ObjectKeyFromObject(conn), &after); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if after.ResourceVersion != conn.ResourceVersion || dp.setups != 3 {
+		t.Errorf("suppressed connection was updated or set up")
+	}
+
+	// taking it down on purpose lifts the damping
+	after.Spec.AdminState = nsmv1.AdminStateDown
+	if err := c.Update(context.Background(), &after); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	reconcileConnection(t, r, c)
+	if suppressed, _ := r.damper.Suppressed("edge/conn", time.Now()); suppressed {
+		t.Errorf("administratively down connection still suppressed")
+	}
+}
+
+func TestConnectionReconcilerRequiresAcceptance(t *testing.T) {
+	conn := testConnection(nsmv1.ConnectionTypeKernel)
+	conn.Spec.Destination = "shared/vision"
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "shared"}}
+	c := newTestClient(t, conn, ns)
+	dp := &recordingDatapath{}
+	r := NewConnectionReconciler(c, logrus.New(), connection.Capabilities{}, dp)
+	ctx := context.Background()
+
+	got := reconcileConnection(t, r, c)
+	if got.Status.Established || dp.setups != 0 {
+		t.Fatalf("connection set up without acceptance: %+v", got.Status)
+	}
+	if cond := meta.FindStatusCondition(got.Status.Conditions, nsmv1.ConditionReady); cond == nil || cond.Reason != connection.ReasonNotAccepted {
+		t.Fatalf("unexpected Ready condition: %+v", cond)
+	}
+
+	// the destination namespace accepts the connection
+	ns.Annotations = map[string]string{connection.AnnotationAcceptFrom: "edge"}
+	if err := c.Update(ctx, ns); err != nil {
+		t.Fatal(err)
+	}
+	if got = reconcileConnection(t, r, c); !got.Status.Established {
+		t.Fatalf("accepted connection not established: %+v", got.Status)
+	}
+
+	// revoking the acceptance tears it down
+	ns.Annotations = nil
+	if err := c.Update(ctx, ns); err != nil {
+		t.Fatal(err)
+	}
+	if got = reconcileConnection(t, r, c); got.Status.Established || dp.teardowns != 1 || dp.kept {
+		t.Errorf("connection not torn down after the acceptance was revoked: %+v", got.Status)
+	}
+}
+
+func TestConnectionReconcilerLatencyBudget(t *testing.T) {
+	conn := testConnection(nsmv1.ConnectionTypeKernel)
+	conn.Spec.LatencyRequirement = 10
+	c := newTestClient(t, conn)
+	r := NewConnectionReconciler(c, logrus.New(), connection.Capabilities{}, &recordingDatapath{})
+
+	conn = reconcileConnection(t, r, c)
+	budget := conn.Status.LatencyBudget
+	if budget == nil || budget.NICQueueingUs != 1000 || budget.HostStackUs != 50 || budget.WANUs != 8950 {
+		t.Fatalf("unexpected latency budget %+v", budget)
+	}
+	if !meta.IsStatusConditionTrue(conn.Status.Conditions, nsmv1.ConditionLatencyBudget) {
+		t.Errorf("connection without metrics not within budget: %+v", conn.Status.Conditions)
+	}
+
+	// the metrics show the NIC queues holding the traffic too long
+	conn.Status.Metrics = nsmv1.ConnectionMetrics{LatencyMs: 14, NICQueueingUs: 5000, HostStackUs: 40}
+	if err := c.Status().Update(context.Background(), conn); err != nil {
+		t.Fatal(err)
+	}
+	conn = reconcileConnection(t, r, c)
+	cond := meta.FindStatusCondition(conn.Status.Conditions, nsmv1.ConditionLatencyBudget)
+	if conn.Status.LatencyBudget.Violation != nsmv1.LatencySegmentNICQueueing || cond.Status != metav1.ConditionFalse || cond.Reason != "NICQueueingOverBudget" {
+		t.Errorf("NIC queueing not reported over budget: %+v, %+v", conn.Status.LatencyBudget, cond)
+	}
+
+	// without a requirement there is no budget
+	conn.Spec.LatencyRequirement = 0
+	if err := c.Update(context.Background(), conn); err != nil {
+		t.Fatal(err)
+	}
+	conn = reconcileConnection(t, r, c)
+	if conn.Status.LatencyBudget != nil || meta.FindStatusCondition(conn.Status.Conditions, nsmv1.ConditionLatencyBudget) != nil {
+		t.Errorf("latency budget left without a requirement: %+v", conn.Status)
+	}
+}
+
+func TestConnectionReconcilerPathShares(t *testing.T) {
+	conn := testConnection(nsmv1.ConnectionTypeKernel)
+	conn.Spec.LoadSharing = &nsmv1.LoadSharingSpec{
+		Destination: "10.20.0.0/16",
+		Paths: []nsmv1.LoadSharingPath{
+			{Name: "fiber", Device: "eth0", Weight: 4},
+			{Name: "lte", Device: "wwan0"},
+		},
+	}
+	c := newTestClient(t, conn)
+	r := NewConnectionReconciler(c, logrus.New(), connection.Capabilities{}, &recordingDatapath{})
+
+	conn = reconcileConnection(t, r, c)
+	want := []nsmv1.PathShare{
+		{Name: "fiber", Device: "eth0", Percent: 80},
+		{Name: "lte", Device: "wwan0", Percent: 20},
+	}
+	if !conn.Status.Established || !reflect.DeepEqual(conn.Status.PathShares, want) {
+		t.Errorf("PathShares = %+v, want %+v", conn.Status.PathShares, want)
+	}
+}
+
+func TestConnectionReconcilerEstablishTimeout(t *testing.T) {
+	conn := testConnection(nsmv1.ConnectionTypeKernel)
+	conn.Spec.EstablishTimeoutSeconds = 60
+	c := newTestClient(t, conn)
+	dp := &recordingDatapath{setupErr: errors.New("no such device")}
+	r := NewConnectionReconciler(c, logrus.New(), connection.Capabilities{}, dp)
+	recorder := record.NewFakeRecorder(10)
+	r.SetRecorder(recorder)
+
+	got := reconcileConnection(t, r, c)
+	if got.Status.State != nsmv1.ConnectionStateFailed || got.Status.PendingSince == nil {
+		t.Fatalf("unexpected status before the deadline: %+v", got.Status)
+	}
+
+	// past the deadline the connection is given up on
+	past := metav1.NewTime(time.Now().Add(-2 * time.Minute))
+	got.Status.PendingSince = &past
+	if err := c.Status().Update(context.Background(), got); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	got = reconcileConnection(t, r, c)
+	cond := meta.FindStatusCondition(got.Status.Conditions, nsmv1.ConditionReady)
+	if cond == nil || cond.Reason != "EstablishTimeout" || !strings.Contains(cond.Message, "not established within 60s: no such device") {
+		t.Fatalf("unexpected Ready condition: %+v", cond)
+	}
+	select {
+	case event := <-recorder.Events:
+		if !strings.Contains(event, "Warning EstablishTimeout") {
+			t.Errorf("unexpected event %q", event)
+		}
+	default:
+		t.Errorf("no event emitted")
+	}
+
+	// and not retried until its spec changes
+	setups := dp.setups
+	reconcileConnection(t, r, c)
+	if dp.setups != setups {
+		t.Errorf("timed out connection set up again")
+	}
+
+	dp.setupErr = nil
+	got.Spec.Bandwidth = 100
+	got.Generation++
+	if err := c.Update(context.Background(), got); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	got = reconcileConnection(t, r, c)
+	if !got.Status.Established || got.Status.PendingSince != nil {
+		t.Errorf("connection not established after its spec changed: %+v", got.Status)
+	}
+}
+
+func TestConnectionReconcilerWaitsForEndpoints(t *testing.T) {
+	conn := testConnection(nsmv1.ConnectionTypeKernel)
+	conn.Spec.Destination, conn.Spec.DestinationEndpoint = "", "edge-2.eth0"
+	c := newTestClient(t, conn)
+	dp := &recordingDatapath{}
+	r := NewConnectionReconciler(c, logrus.New(), connection.Capabilities{}, dp)
+
+	got := reconcileConnection(t, r, c)
+	if got.Status.State != nsmv1.ConnectionStatePending || dp.setups != 0 {
+		t.Fatalf("connection set up without its destination: %+v", got.Status)
+	}
+	if cond := meta.FindStatusCondition(got.Status.Conditions, nsmv1.ConditionReady); cond == nil || cond.Reason != connection.ReasonEndpointUnresolved {
+		t.Errorf("unexpected Ready condition: %+v", cond)
+	}
+
+	// the registry of the endpoint's node fills in its address
+	got.Spec.Destination = "192.0.2.20"
+	if err := c.Update(context.Background(), got); err != nil {
+		t.Fatal(err)
+	}
+	got = reconcileConnection(t, r, c)
+	if got.Status.State != nsmv1.ConnectionStateEstablished || dp.setups != 1 {
+		t.Errorf("resolved connection not set up: %+v", got.Status)
+	}
+}
+
+func TestConnectionReconcilerClaimsConnectionForOneNode(t *testing.T) {
+	c := newTestClient(t, testConnection(nsmv1.ConnectionTypeKernel))
+	dp1, dp2 := &recordingDatapath{}, &recordingDatapath{}
+	r1 := NewConnectionReconciler(c, logrus.New(), connection.Capabilities{}, dp1)
+	r1.SetNode("edge-1")
+	r2 := NewConnectionReconciler(c, logrus.New(), connection.Capabilities{}, dp2)
+	r2.SetNode("edge-2")
+
+	// the node reconciling a connection first claims it
+	conn := reconcileConnection(t, r1, c)
+	if conn.Status.Node != "edge-1" || !conn.Status.Established || dp1.setups != 1 {
+		t.Fatalf("status = %+v, want established on edge-1", conn.Status)
+	}
+	if r2.onNode(conn) {
+		t.Errorf("connection of edge-1 passed to the reconciler of edge-2")
+	}
+	reconcileConnection(t, r2, c)
+	if dp2.setups != 0 {
+		t.Errorf("connection set up on both nodes")
+	}
+
+	// the connection moves to edge-2, a node still claiming it from an
+	// older version loses on the conflict
+	stale := conn.DeepCopy()
+	stale.Status.Node = ""
+	conn.Status.Node = "edge-2"
+	if err := c.Status().Update(context.Background(), conn); err != nil {
+		t.Fatal(err)
+	}
+	if claimed, err := r1.claim(context.Background(), stale); claimed || err != nil {
+		t.Errorf("claim() = %t, %v, want lost on the conflict", claimed, err)
+	}
+
+	// the node the connection moved away from releases its datapath
+	reconcileConnection(t, r1, c)
+	if dp1.teardowns != 1 || dp1.kept {
+		t.Errorf("teardowns = %d (keep allocations %t), want the datapath released", dp1.teardowns, dp1.kept)
+	}
+	reconcileConnection(t, r1, c)
+	if dp1.teardowns != 1 {
+		t.Errorf("datapath released again")
+	}
+}
@@ -2,16 +2,93 @@ package controller
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	inventoryv1 "github.com/akos011221/nsm/api/grpc/inventory/v1"
+	metricsv1 "github.com/akos011221/nsm/api/grpc/metrics/v1"
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/admission"
+	"github.com/akos011221/nsm/pkg/adopt"
+	"github.com/akos011221/nsm/pkg/agent"
+	"github.com/akos011221/nsm/pkg/anomaly"
+	"github.com/akos011221/nsm/pkg/anycast"
+	"github.com/akos011221/nsm/pkg/api"
+	vfgrpc "github.com/akos011221/nsm/pkg/api/grpc"
+	"github.com/akos011221/nsm/pkg/bfd"
+	"github.com/akos011221/nsm/pkg/bootstrap"
+	"github.com/akos011221/nsm/pkg/budget"
+	"github.com/akos011221/nsm/pkg/bulk"
+	"github.com/akos011221/nsm/pkg/catalog"
+	"github.com/akos011221/nsm/pkg/cloud"
+	"github.com/akos011221/nsm/pkg/cni"
 	"github.com/akos011221/nsm/pkg/config"
+	"github.com/akos011221/nsm/pkg/connection"
+	"github.com/akos011221/nsm/pkg/cost"
+	"github.com/akos011221/nsm/pkg/damping"
+	"github.com/akos011221/nsm/pkg/datapath"
+	"github.com/akos011221/nsm/pkg/devlink"
+	"github.com/akos011221/nsm/pkg/disruption"
+	"github.com/akos011221/nsm/pkg/drill"
+	"github.com/akos011221/nsm/pkg/external"
+	"github.com/akos011221/nsm/pkg/failover"
+	"github.com/akos011221/nsm/pkg/fdb"
+	"github.com/akos011221/nsm/pkg/firmware"
+	"github.com/akos011221/nsm/pkg/gateway"
+	"github.com/akos011221/nsm/pkg/gc"
 	"github.com/akos011221/nsm/pkg/hardware"
+	"github.com/akos011221/nsm/pkg/health"
+	"github.com/akos011221/nsm/pkg/idle"
+	"github.com/akos011221/nsm/pkg/inventorystream"
+	"github.com/akos011221/nsm/pkg/keys"
+	"github.com/akos011221/nsm/pkg/kubecompat"
+	"github.com/akos011221/nsm/pkg/kubevirt"
+	"github.com/akos011221/nsm/pkg/metrics"
+	"github.com/akos011221/nsm/pkg/metricsstream"
+	"github.com/akos011221/nsm/pkg/netendpoint"
+	"github.com/akos011221/nsm/pkg/netutil"
+	"github.com/akos011221/nsm/pkg/placement"
+	"github.com/akos011221/nsm/pkg/posture"
+	"github.com/akos011221/nsm/pkg/pressure"
+	"github.com/akos011221/nsm/pkg/probe"
+	"github.com/akos011221/nsm/pkg/profiling"
+	"github.com/akos011221/nsm/pkg/qos"
+	"github.com/akos011221/nsm/pkg/reachability"
+	"github.com/akos011221/nsm/pkg/registry"
+	"github.com/akos011221/nsm/pkg/rekey"
+	"github.com/akos011221/nsm/pkg/shard"
+	"github.com/akos011221/nsm/pkg/sriovoperator"
+	"github.com/akos011221/nsm/pkg/telemetry"
+	"github.com/akos011221/nsm/pkg/thermal"
+	"github.com/akos011221/nsm/pkg/traffic"
+	"github.com/akos011221/nsm/pkg/uevent"
+	"github.com/akos011221/nsm/pkg/usage"
+	"github.com/akos011221/nsm/pkg/watchdog"
+	"github.com/akos011221/nsm/pkg/whatif"
+	"github.com/akos011221/nsm/pkg/xds"
+	"github.com/go-logr/logr/funcr"
 	"github.com/sirupsen/logrus"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	crlog "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
 )
 
 // Controller manages the NSM components
@@ -29,8 +106,89 @@ type Controller struct {
 	// Wait group for goroutines
 	wg sync.WaitGroup
 
+	// Manager running the CRD reconcilers
+	mgr manager.Manager
+	// Whether this replica leads the reconciliation of the cluster-scope objects
+	leader leaderTracker
+
 	// Component managers
 	sriovManager *hardware.SRIOVManager
+	dpdkManager  *hardware.DPDKManager
+	bfdManager   *bfd.Manager
+	// xDS server for Envoy gateways
+	xdsServer *xds.Server
+	// Management API server
+	apiServer *api.Server
+	// Periodic profile snapshots
+	snapshotter *profiling.Snapshotter
+	// Watchdog for stalled component loops
+	watchdog *watchdog.Watchdog
+	// Detected hardware platform
+	platform *hardware.Platform
+	// Per-namespace tunnel keys of encrypted connections
+	keyStore *keys.Store
+	// Datapath encrypting the connections, rotating their associations
+	ipsec *datapath.IPsecDatapath
+	// gRPC metrics streaming API
+	metricsStream *metricsstream.Server
+	// Prometheus metrics server, with a registry per component
+	metricsServer *metrics.Server
+	// Health probes, nil if disabled
+	healthServer *health.Server
+	// Node pressure monitor throttling non-critical work
+	pressureMonitor *pressure.Monitor
+	// Temperature and power sensors of the node
+	thermalMonitor *thermal.Monitor
+	// Kernel uevents of the node's devices, nil if hotplug isn't handled
+	uevents *uevent.Listener
+	// Devlink devices of the node: eswitch modes and health reporters
+	devlinkMonitor *devlink.Monitor
+	// Host state applier shared by the datapaths and the failover engine
+	applier *datapath.Applier
+	// Connectivity to the cloud, nil without a cloud endpoint
+	cloudLink *cloud.Link
+	// Low-power idle mode without demand for NSM resources
+	idleDetector *idle.Detector
+	// Resource budgets of the heavy subsystems
+	budgetManager *budget.Manager
+	// Reachability scores of the service endpoints
+	scorer *reachability.Scorer
+	// Shard of this replica, nil if it reconciles the objects of every node
+	membership *shard.Membership
+	// Agents registered with their Leases, nil without agent leases
+	agents *agent.Registry
+	// NetworkServices registered by the service reconciler
+	catalog *catalog.Catalog
+	// Usage of the namespaces for chargeback, nil without usage reports
+	usageMeter *usage.Meter
+	// Runs the disruptive datapath changes past the disruption budgets
+	disruptions *disruption.Coordinator
+	// Hands the allocated VFs to the CNI plugin, nil without SR-IOV
+	cniServer *cni.Server
+	// Serves the VFs to local agents over gRPC, nil without SR-IOV or a socket
+	vfServer *vfgrpc.Server
+	// API versions the cluster serves
+	capabilities *kubecompat.Capabilities
+	// State of the SR-IOV network operator on the node, nil without SR-IOV
+	// or coexistence
+	sriovOperator *sriovoperator.Watcher
+	kubevirt      *kubevirt.Watcher
+	// Flap damper of the connections, nil without flap damping
+	damper *damping.Damper
+	// QoS enforcer running, nil without QoS enforcement
+	qosEnforcer *qos.Enforcer
+	// Prices the uplinks of the failover paths, nil without uplink costs
+	costModel *cost.Model
+
+	// Config file reloaded on changes and SIGHUP, empty to not reload
+	configPath string
+	// Config last reloaded, nil before the first reload
+	live atomic.Pointer[config.Config]
+	// Mutex serializing the reloads and the restarts of the components
+	// taking reloaded settings
+	reloadMu sync.Mutex
+	// Probes the connections, nil without probing
+	probeMonitor *probe.Monitor
 }
 
 // NewController creates a new controller instance
@@ -43,20 +201,32 @@ func NewController(cfg *config.Config, logger *logrus.Logger) (*Controller, erro
 		ctx:    ctx,
 		cancel: cancel,
 	}
+	ctrl.SetConfigPath(cfg.File)
 
 	/* k8s client */
 
 	k8sConfig, err := getKubernetesConfig(cfg.Kubeconfig)
 	if err != nil {
+		cancel()
 		return nil, fmt.Errorf("failed to create kubernetes config: %w", err)
 	}
 
 	clientset, err := kubernetes.NewForConfig(k8sConfig)
 	if err != nil {
+		cancel()
 		return nil, fmt.Errorf("failed to create kubernetes client: %w", err)
 	}
 	ctrl.clientset = clientset
 
+	/* controller-runtime manager for the CRD reconcilers */
+
+	mgr, err := newManager(k8sConfig, cfg, logger)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to create controller manager: %w", err)
+	}
+	ctrl.mgr = mgr
+
 	// initialize components
 	if err := ctrl.initComponents(); err != nil {
 		cancel() // clean up the context
@@ -82,10 +252,462 @@ func getKubernetesConfig(kubeconfigPath string) (*rest.Config, error) {
 	return nil, fmt.Errorf("could not create kubernetes config: %v", err)
 }
 
+// newManager creates the controller-runtime manager with the NSM types
+// registered, serving the admission webhook if enabled
+func newManager(k8sConfig *rest.Config, cfg *config.Config, logger *logrus.Logger) (manager.Manager, error) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		return nil, fmt.Errorf("failed to register kubernetes types: %w", err)
+	}
+	if err := nsmv1.AddToScheme(scheme); err != nil {
+		return nil, fmt.Errorf("failed to register NSM types: %w", err)
+	}
+
+	// route controller-runtime logs through logrus
+	crlog.SetLogger(funcr.New(func(prefix, args string) {
+		logger.WithField("component", prefix).Debug(args)
+	}, funcr.Options{}))
+
+	var webhookServer webhook.Server
+	if cfg.EnableAdmissionWebhook {
+		webhookServer = webhook.NewServer(webhook.Options{Port: cfg.AdmissionWebhookPort, CertDir: cfg.AdmissionWebhookCertDir})
+	}
+
+	opts := manager.Options{
+		Scheme: scheme,
+		// tunnel keys are read on demand instead of caching every Secret of the cluster,
+		// shard Leases instead of caching the node heartbeats with them
+		Client: client.Options{Cache: &client.CacheOptions{DisableFor: []client.Object{&corev1.Secret{}, &coordinationv1.Lease{}}}},
+		// metrics and health probes are served by NSM itself
+		Metrics:                metricsserver.Options{BindAddress: "0"},
+		HealthProbeBindAddress: "0",
+		WebhookServer:          webhookServer,
+	}
+	// only the leader reconciles the cluster-scope objects when enabled
+	leaderElection(&opts, cfg)
+	return manager.New(k8sConfig, opts)
+}
+
 // initComponents initializes all controller components
 func (c *Controller) initComponents() error {
+	// the datapath selection depends on the hardware
+	platform, err := hardware.NewPlatformDetector().Detect()
+	if err != nil {
+		c.logger.WithError(err).Warn("Hardware detection failed")
+	} else {
+		c.platform = platform
+		c.logger.Infof("Detected %s platform, IOMMU %s, %d NICs", platform.Arch, platform.IOMMU, len(platform.NICs))
+	}
+
+	if c.config.EnableWatchdog {
+		c.watchdog = watchdog.NewWatchdog(c.ctx, c.logger, c.config.WatchdogMissedIntervals, c.config.WatchdogRestart)
+	}
+
+	if c.config.EnablePressureThrottling {
+		c.pressureMonitor = pressure.NewMonitor(c.ctx, c.logger, "/proc",
+			float64(c.config.PressureStallThresholdPercent), float64(c.config.PressureMinMemAvailablePercent))
+	}
+
+	if c.config.EnableThermalMonitor {
+		c.thermalMonitor = thermal.NewMonitor(c.ctx, c.logger, "/", float64(c.config.ThermalMarginCelsius))
+	}
+
+	if c.config.CloudEndpoint != "" {
+		c.cloudLink = cloud.NewLink(c.logger, time.Duration(c.config.CloudDisconnectAfterSec)*time.Second)
+	}
+
+	if c.config.EnableDevlink {
+		c.devlinkMonitor = devlink.NewMonitor(c.ctx, c.logger, devlink.NewNetlinkBackend(c.ctx), "/",
+			time.Duration(c.config.DevlinkPollIntervalSec)*time.Second)
+	}
+
+	if c.config.EnableResourceBudgets {
+		c.budgetManager = budget.NewManager(c.ctx, c.logger, 10*time.Second)
+	}
+
+	// the APIs differ across the Kubernetes versions of the fleet
+	c.capabilities, err = kubecompat.Detect(c.clientset.Discovery())
+	if err != nil {
+		c.logger.WithError(err).Warn("Kubernetes API detection failed, assuming the current API versions")
+		c.capabilities = kubecompat.Latest()
+	} else {
+		c.logger.Infof("Detected Kubernetes %s, EndpointSlice %q, PodDisruptionBudget %s, Eviction %s", c.capabilities.ServerVersion,
+			c.capabilities.EndpointSlice, c.capabilities.PodDisruptionBudget, c.capabilities.Eviction)
+	}
+
+	c.disruptions = disruption.NewCoordinator(c.ctx, c.clientset, c.logger, c.config.DisruptionPolicy,
+		time.Duration(c.config.DisruptionTimeoutSec)*time.Second)
+	c.disruptions.SetCapabilities(c.capabilities)
+
 	if c.config.EnableSRIOV {
 		c.sriovManager = hardware.NewSRIOVManager(c.ctx, c.clientset, c.logger)
+		c.sriovManager.SetDisrupter(c.disruptions)
+		c.sriovManager.SetStateFile(c.config.SRIOVStateFile)
+		// only the pods of the node get its VFs
+		c.sriovManager.SetNodeName(c.config.EdgeNodeID)
+		// the operator provisions the VFs of its PFs, NSM only allocates them
+		if c.config.EnableSRIOVOperatorCoexistence {
+			nodeName := c.config.SRIOVOperatorNodeName
+			if nodeName == "" {
+				nodeName = c.config.EdgeNodeID
+			}
+			c.sriovOperator = sriovoperator.NewWatcher(c.ctx, c.mgr.GetAPIReader(), c.logger, nodeName, 10*time.Second)
+			c.sriovManager.SetProvisioner(c.sriovOperator)
+		}
+		// the VMs hold their VFs across the pods running them
+		if c.config.EnableKubeVirt {
+			nodeName := c.config.KubeVirtNodeName
+			if nodeName == "" {
+				nodeName = c.config.EdgeNodeID
+			}
+			c.kubevirt = kubevirt.NewWatcher(c.ctx, c.mgr.GetAPIReader(), c.logger, nodeName, 10*time.Second)
+			c.kubevirt.SetOnChange(c.sriovManager.Resync)
+			c.sriovManager.SetVirtualMachines(c.kubevirt)
+		}
+		if c.config.CNISocket != "" {
+			c.cniServer = cni.NewServer(c.ctx, c.logger, c.config.CNISocket, c.sriovManager)
+		}
+		if c.config.VFSocket != "" {
+			c.vfServer = vfgrpc.NewServer(c.ctx, c.logger, c.config.VFSocket, c.sriovManager,
+				time.Duration(c.config.VFLeaseMaxTTLSec)*time.Second)
+		}
+		// the events drive the discovery, the polls only catch missed ones
+		if c.config.EnableHotplug {
+			c.uevents = uevent.NewListener(c.ctx, c.logger)
+			c.uevents.Subscribe("pci", c.sriovManager.HandlePCIEvent)
+			c.uevents.Subscribe("net", c.sriovManager.HandleNetEvent)
+			c.sriovManager.SetPollInterval(time.Duration(c.config.HotplugConsistencyCheckSec) * time.Second)
+		}
+	}
+
+	if c.config.EnableDPDK {
+		c.dpdkManager = hardware.NewDPDKManager(c.ctx, c.clientset, c.logger, c.config.DPDKDriver, c.config.DPDKDevices)
+		nodeName := c.config.DPDKNodeName
+		if nodeName == "" {
+			nodeName = c.config.EdgeNodeID
+		}
+		c.dpdkManager.SetNodeName(nodeName)
+	}
+
+	if c.config.EnableUsageReports {
+		c.usageMeter = usage.NewMeter(c.ctx, c.mgr.GetClient(), c.logger, c.config.EdgeNodeID, time.Duration(c.config.UsageReportSec)*time.Second)
+		if c.sriovManager != nil {
+			c.usageMeter.SetVFLister(c.sriovManager)
+		}
+	}
+
+	if c.config.IdleAfterSec > 0 {
+		c.idleDetector = idle.NewDetector(c.ctx, c.mgr.GetClient(), c.logger, time.Duration(c.config.IdleAfterSec)*time.Second)
+		if c.sriovManager != nil {
+			c.idleDetector.OnChange(c.sriovManager.SetIdle)
+		}
+	}
+
+	if c.config.EnableBFD {
+		c.bfdManager = bfd.NewManager(c.ctx, c.logger, fmt.Sprintf(":%d", bfd.ControlPort))
+	}
+
+	if c.config.EnableReachabilityScoring {
+		c.scorer = reachability.NewScorer(c.ctx, c.mgr.GetClient(), c.logger, c.config.ReachabilityStateFile)
+	}
+
+	if len(c.config.UplinkCosts) > 0 {
+		c.costModel = cost.NewModel(c.ctx, c.logger, uplinks(c.config), c.config.UplinkCostStateFile)
+	}
+
+	if c.config.XDSListenAddr != "" {
+		c.xdsServer = xds.NewServer(c.ctx, c.mgr.GetClient(), c.logger, c.config.XDSListenAddr, c.config.XDSClusterName)
+		if c.scorer != nil {
+			c.xdsServer.SetScores(c.scorer)
+		}
+	}
+
+	// the objects of the nodes are sharded across the replicas when enabled
+	if c.config.EnableSharding {
+		c.membership = shard.NewMembership(c.ctx, c.mgr.GetClient(), c.logger, c.config.ShardNamespace, "nsm-controller",
+			c.config.EdgeNodeID, time.Duration(c.config.ShardLeaseDurationSec)*time.Second)
+	}
+
+	// the agents of the nodes register with Leases the controller tracks
+	if c.config.EnableAgentLeases {
+		c.agents = agent.NewRegistry(c.ctx, c.mgr.GetClient(), c.logger, c.config.AgentLeaseNamespace,
+			time.Duration(c.config.AgentLeaseDurationSec)*time.Second/3)
+		if c.membership != nil {
+			c.agents.SetShard(c.membership)
+		}
+	}
+
+	// CRD reconcilers
+	intentReconciler := NewIntentReconciler(c.mgr.GetClient(), c.logger)
+	blueprintReconciler := NewBlueprintReconciler(c.mgr.GetClient(), c.logger)
+	if c.membership != nil {
+		intentReconciler.SetShard(c.membership)
+		blueprintReconciler.SetShard(c.membership)
+	}
+	if err := intentReconciler.SetupWithManager(c.mgr); err != nil {
+		return fmt.Errorf("failed to set up intent reconciler: %w", err)
+	}
+	if err := blueprintReconciler.SetupWithManager(c.mgr); err != nil {
+		return fmt.Errorf("failed to set up blueprint reconciler: %w", err)
+	}
+	if c.config.EnableGatewayAPI {
+		for _, gvk := range []schema.GroupVersionKind{gateway.HTTPRoute, gateway.GRPCRoute} {
+			// watching a kind whose CRD isn't installed would stall the manager
+			if _, err := c.mgr.GetRESTMapper().RESTMapping(gvk.GroupKind(), gvk.Version); err != nil {
+				c.logger.Warnf("Gateway API %s not installed, not reconciling it", gvk.Kind)
+				continue
+			}
+			if err := NewRouteReconciler(c.mgr.GetClient(), c.logger, gvk).SetupWithManager(c.mgr); err != nil {
+				return fmt.Errorf("failed to set up %s reconciler: %w", gvk.Kind, err)
+			}
+		}
+	}
+	if c.config.EnableEndpointSlices {
+		if c.capabilities.EndpointSlice == "" {
+			c.logger.Warnf("Kubernetes %s doesn't serve EndpointSlices, not publishing NetworkServices", c.capabilities.ServerVersion)
+		} else {
+			endpointSliceReconciler := NewEndpointSliceReconciler(c.mgr.GetClient(), c.logger)
+			endpointSliceReconciler.SetCapabilities(c.capabilities)
+			if err := endpointSliceReconciler.SetupWithManager(c.mgr); err != nil {
+				return fmt.Errorf("failed to set up endpoint slice reconciler: %w", err)
+			}
+		}
+	}
+	if c.config.EnableConnectionDNS {
+		cluster := connection.ClusterDNS{Nameserver: c.config.ClusterDNS, Domain: c.config.ClusterDomain}
+		if err := NewDNSReconciler(c.mgr.GetClient(), c.logger, cluster).SetupWithManager(c.mgr); err != nil {
+			return fmt.Errorf("failed to set up connection DNS reconciler: %w", err)
+		}
+	}
+	caps := connection.CapabilitiesFromConfig(c.config)
+	if c.platform != nil && !c.platform.SRIOV() {
+		// fall back automatically instead of failing VF allocations
+		caps.SRIOV = false
+	}
+	uplink := c.config.FallbackUplink
+	if uplink == "" && c.platform != nil {
+		uplink = c.platform.DefaultUplink()
+	}
+	if c.config.EnableCryptoOffload && c.platform != nil {
+		caps.CryptoOffload = make(map[string]bool)
+		for _, crypto := range c.platform.CryptoOffload(uplink) {
+			caps.CryptoOffload[crypto] = true
+		}
+		c.logger.Infof("Uplink %s offloads encryption: %v", uplink, c.platform.CryptoOffload(uplink))
+	}
+	nl := netutil.NewNetlink()
+	applier := datapath.NewApplier(datapath.NewNetlinkBackend(nl, datapath.NewHostBackend()), c.logger)
+	c.applier = applier
+	if c.sriovManager != nil {
+		c.sriovManager.SetStormControl(datapath.NewVFStormControl(applier), hardware.StormPolicy{
+			BroadcastPPS: c.config.SRIOVBroadcastPPS,
+			MulticastPPS: c.config.SRIOVMulticastPPS,
+		})
+		c.sriovManager.SetVFConfigurer(datapath.NewVFConfigurer(nl))
+	}
+	// connections none of the datapaths serve are refused, not reported
+	// established with nothing programmed
+	var accelerated connection.Datapath = datapath.UnsupportedDatapath{}
+	switch c.config.VhostUserDataplane {
+	case datapath.VhostUserOVS:
+		accelerated = datapath.NewVhostUserDatapath(datapath.NewOVSDataplane(c.config.VhostUserBridge), c.config.VhostUserSocketDir, accelerated)
+	case datapath.VhostUserVPP:
+		accelerated = datapath.NewVhostUserDatapath(datapath.NewVPPDataplane(c.config.VhostUserBridge), c.config.VhostUserSocketDir, accelerated)
+	}
+	if c.config.Dataplane == "vpp" {
+		// VPP attaches to veth pairs, which the host applier programs
+		vpp := datapath.NewApplier(datapath.NewVPPBackend(datapath.NewNetlinkBackend(nl, datapath.NewHostBackend())), c.logger)
+		accelerated = datapath.NewVPPDatapath(vpp, c.config.VPPUplink, accelerated)
+		c.logger.Infof("Forwarding connections with VPP over %s", c.config.VPPUplink)
+	}
+	c.keyStore = keys.NewStore(c.mgr.GetClient(), c.logger, legacyKeySecret(c.config.LegacyKeySecret))
+	c.ipsec = datapath.NewIPsecDatapath(applier, c.keyStore, uplink, datapath.NewLoadSharingDatapath(applier,
+		datapath.NewMulticastDatapath(applier, uplink, datapath.NewFallbackDatapath(applier, uplink, accelerated))))
+	connReconciler := NewConnectionReconciler(c.mgr.GetClient(), c.logger, caps, c.ipsec)
+	connReconciler.SetKeyStore(c.keyStore)
+	connReconciler.SetRecorder(c.mgr.GetEventRecorderFor("nsm-controller"))
+	connReconciler.SetNode(c.config.EdgeNodeID)
+	if c.watchdog != nil {
+		connReconciler.SetHeartbeat(c.watchdog.Register("connection reconciler", nil))
+	}
+	if c.pressureMonitor != nil {
+		connReconciler.SetPressureSignal(c.pressureMonitor, int32(c.config.PressureCriticalPriority))
+	}
+	if c.thermalMonitor != nil && c.config.ThermalShedPriority > 0 {
+		connReconciler.SetThermalSignal(c.thermalMonitor, int32(c.config.ThermalShedPriority))
+	}
+	if c.config.EnableFlapDamping {
+		c.damper = damping.NewDamper(dampingConfig(c.config))
+		connReconciler.SetDamper(c.damper)
+	}
+	if err := connReconciler.SetupWithManager(c.mgr); err != nil {
+		return fmt.Errorf("failed to set up connection reconciler: %w", err)
+	}
+	// PF resets keep the pods, their VFs are re-attached once it's over
+	if c.sriovManager != nil && c.config.EnableVFReparenting {
+		c.sriovManager.SetReparenting(datapath.NewVFReparenter(nl), connReconciler)
+	}
+	// NetworkFirmwareUpdates drain, flash and restore the PFs of the node
+	if c.config.EnableFirmwareUpdates {
+		if c.sriovManager == nil {
+			c.logger.Warn("Firmware updates need SR-IOV, not running NetworkFirmwareUpdates")
+		} else {
+			updater := firmware.NewUpdater(c.config.FirmwareUpdateCommand, time.Duration(c.config.FirmwareUpdateTimeoutSec)*time.Second)
+			fwReconciler := NewFirmwareReconciler(c.mgr.GetClient(), c.logger, c.config.EdgeNodeID, c.sriovManager, updater)
+			if err := fwReconciler.SetupWithManager(c.mgr); err != nil {
+				return fmt.Errorf("failed to set up firmware update reconciler: %w", err)
+			}
+		}
+	}
+	c.catalog = catalog.NewCatalog()
+	if err := NewServiceReconciler(c.mgr.GetClient(), c.logger, caps, c.catalog).SetupWithManager(c.mgr); err != nil {
+		return fmt.Errorf("failed to set up service reconciler: %w", err)
+	}
+	// invalid specs are rejected at admission, bandwidths beyond the
+	// fastest link of the node among them
+	if c.config.EnableAdmissionWebhook {
+		maxBandwidth := 0
+		if c.platform != nil {
+			maxBandwidth = c.platform.MaxSpeedMbps()
+		}
+		if err := admission.NewValidator(c.logger, maxBandwidth).SetupWithManager(c.mgr); err != nil {
+			return fmt.Errorf("failed to set up admission webhook: %w", err)
+		}
+	}
+	newProber := func(device string) probe.Prober { return probe.NewTCPProber(device, time.Second) }
+	canaryReconciler := NewCanaryReconciler(c.mgr.GetClient(), c.logger, newProber)
+	if c.idleDetector != nil {
+		canaryReconciler.SetIdleSignal(c.idleDetector)
+	}
+	canaryReconciler.SetBudget(c.track("probing", c.config.ProbeCPUBudgetPercent, 0))
+	if err := canaryReconciler.SetupWithManager(c.mgr); err != nil {
+		return fmt.Errorf("failed to set up canary reconciler: %w", err)
+	}
+
+	// management API
+	if c.config.APIListenAddr != "" {
+		c.apiServer = api.NewServer(c.ctx, c.logger, c.config.APIListenAddr)
+		c.apiServer.SetToken(c.config.APIToken)
+		if c.config.ServeLegacyAPI {
+			var sunset *time.Time
+			if c.config.LegacyAPISunset != "" {
+				// validated with the config
+				date, _ := time.Parse(time.DateOnly, c.config.LegacyAPISunset)
+				sunset = &date
+			}
+			c.apiServer.ServeLegacyVersion(api.V1Alpha1, sunset)
+		}
+		c.apiServer.Handle("GET /v1/connections", connection.Handler(c.mgr.GetClient()))
+		c.apiServer.Handle("POST /v1/connections/bulk", bulk.Handler(c.mgr.GetClient(), c.logger))
+		c.apiServer.Handle("POST /v1/connections/import", adopt.Handler(c.mgr.GetClient(), c.logger, adopt.NewScanner(), c.config.EdgeNodeID))
+		c.apiServer.Handle("GET /v1/hardware", http.HandlerFunc(c.handlePlatform))
+		c.apiServer.Handle("GET /v1/hardware/sensors", http.HandlerFunc(c.handleSensors))
+		c.apiServer.Handle("GET /v1/hardware/dpdk", http.HandlerFunc(c.handleDPDK))
+		c.apiServer.Handle("GET /v1/cloud", http.HandlerFunc(c.handleCloud))
+		c.apiServer.Handle("GET /v1/hardware/devlink", http.HandlerFunc(c.handleDevlink))
+		c.apiServer.Handle("PUT /v1/hardware/devlink/{bus}/{device}/eswitch", http.HandlerFunc(c.handleSetEswitchMode))
+		c.apiServer.Handle("PUT /v1/hardware/sriov/{pf}/numvfs", http.HandlerFunc(c.handleSetNumVFs))
+		c.apiServer.Handle("POST /v1/hardware/sriov/{pf}/reset", http.HandlerFunc(c.handleResetPF))
+		c.apiServer.Handle("GET /v1/hardware/sriov/consistency", http.HandlerFunc(c.handleCheckConsistency))
+		c.apiServer.Handle("POST /v1/hardware/sriov/consistency/repair", http.HandlerFunc(c.handleRepairConsistency))
+		c.apiServer.Handle("GET /v1/hardware/sriov/operator", http.HandlerFunc(c.handleSRIOVOperator))
+		c.apiServer.Handle("GET /v1/hardware/sriov/vms", http.HandlerFunc(c.handleVirtualMachines))
+		c.apiServer.Handle("GET /v1/hardware/sriov/vfs", http.HandlerFunc(c.handleListVFs))
+		c.apiServer.Handle("DELETE /v1/hardware/sriov/vfs/{namespace}/{name}", http.HandlerFunc(c.handleReleaseVF))
+		c.apiServer.Handle("GET /v1/disruptions", http.HandlerFunc(c.handleDisruptions))
+		c.apiServer.Handle("GET /v1/explain/pods/{namespace}/{name}", http.HandlerFunc(c.handleExplainPod))
+		c.apiServer.Handle("GET /v1/leases", http.HandlerFunc(c.handleListLeases))
+		c.apiServer.Handle("POST /v1/leases", http.HandlerFunc(c.handleLeaseVF))
+		c.apiServer.Handle("POST /v1/leases/{namespace}/{name}/renew", http.HandlerFunc(c.handleRenewLease))
+		c.apiServer.Handle("DELETE /v1/leases/{namespace}/{name}", http.HandlerFunc(c.handleReleaseLease))
+		c.apiServer.Handle("GET /v1/services", http.HandlerFunc(c.handleServices))
+		c.apiServer.Handle("GET /v1/endpoints", http.HandlerFunc(c.handleEndpoints))
+		c.apiServer.Handle("GET /v1/uplinks", http.HandlerFunc(c.handleUplinks))
+		c.apiServer.Handle("GET /v1/nodes", http.HandlerFunc(c.handleNodes))
+		c.apiServer.Handle("GET /v1/leader", http.HandlerFunc(c.handleLeader))
+		if c.usageMeter != nil {
+			c.apiServer.Handle("GET /v1/usage", usage.Handler(c.usageMeter))
+		}
+		policy := posture.Policy{
+			RequireEncryption: c.config.PostureRequireEncryption,
+			MaxKeyAge:         time.Duration(c.config.PostureMaxKeyAgeSec) * time.Second,
+		}
+		c.apiServer.Handle("GET /v1/security/posture", posture.Handler(c.mgr.GetClient(), policy, c.config.EdgeNodeID, c.ipsec))
+		var vfs whatif.VFInventory
+		if c.sriovManager != nil {
+			vfs = c.sriovManager
+		}
+		c.apiServer.Handle("POST /v1/whatif", whatif.Handler(c.mgr.GetClient(), c.logger, connReconciler, vfs))
+		if c.config.EnableFailoverDrills {
+			runner := drill.NewRunner(c.mgr.GetClient(), c.logger, drill.NewLinkFault(netutil.NewNetlink()),
+				probe.NewTCPProber("", 200*time.Millisecond), c.config.FailoverStrategy,
+				time.Duration(c.config.FailoverDrillMaxSec)*time.Second)
+			c.apiServer.Handle("POST /v1/drills", drill.Handler(runner))
+			c.apiServer.Handle("GET /v1/drills", drill.ReportsHandler(runner))
+		}
+		if c.config.EnableTrafficTests {
+			generator := traffic.NewGenerator(c.mgr.GetClient(), c.logger,
+				time.Duration(c.config.TrafficTestMaxSec)*time.Second, c.config.TrafficTestMaxRateMbps)
+			c.apiServer.Handle("POST /v1/connections/{namespace}/{name}/traffic-test", traffic.Handler(generator))
+		}
+		c.apiServer.Handle("GET "+api.OpenAPIPath, OpenAPI().Handler())
+		if c.config.EnableProfiling {
+			c.apiServer.Handle(profiling.PathPrefix, profiling.Handler(c.config.ProfilingToken))
+		}
+	}
+
+	// Prometheus metrics of the managers
+	if c.config.MetricsListenAddr != "" {
+		c.metricsServer = metrics.NewServer(c.ctx, c.logger, c.config.MetricsListenAddr)
+		if err := c.metricsServer.Register("connections", connection.NewStateCollector(c.mgr.GetClient())); err != nil {
+			return err
+		}
+		if c.sriovManager != nil {
+			if err := c.metricsServer.Register("sriov", c.sriovManager.Collectors()...); err != nil {
+				return err
+			}
+		}
+		if c.dpdkManager != nil {
+			if err := c.metricsServer.Register("dpdk", c.dpdkManager.Collectors()...); err != nil {
+				return err
+			}
+		}
+	}
+
+	// liveness and readiness probes
+	if c.config.HealthListenAddr != "" {
+		c.healthServer = health.NewServer(c.ctx, c.logger, c.config.HealthListenAddr)
+		if c.watchdog != nil {
+			c.healthServer.SetWatchdog(c.watchdog)
+		}
+		c.healthServer.AddReadinessCheck("controller manager", func(ctx context.Context) error {
+			if !c.mgr.GetCache().WaitForCacheSync(ctx) {
+				return fmt.Errorf("caches not synced")
+			}
+			return nil
+		})
+	}
+
+	// live connection metrics for dashboards
+	if c.config.MetricsStreamListenAddr != "" {
+		c.metricsStream = metricsstream.NewServer(c.ctx, c.mgr.GetClient(), c.logger, c.config.MetricsStreamListenAddr)
+		c.metricsStream.SetBudget(c.track("metrics stream", c.config.MetricsStreamCPUBudgetPercent, 0))
+		if c.apiServer != nil {
+			c.apiServer.AddService(metricsv1.ConnectionMetrics_ServiceDesc.ServiceName)
+		}
+		// the SR-IOV inventory changes are streamed on the same listener
+		if c.sriovManager != nil {
+			c.metricsStream.RegisterService(&inventoryv1.Inventory_ServiceDesc, inventorystream.NewServer(c.ctx, c.logger, c.sriovManager))
+			if c.apiServer != nil {
+				c.apiServer.AddService(inventoryv1.Inventory_ServiceDesc.ServiceName)
+			}
+		}
+	}
+
+	// profiling snapshots
+	if c.config.EnableProfiling && c.config.ProfileDir != "" {
+		interval := time.Duration(c.config.ProfileIntervalSec) * time.Second
+		c.snapshotter = profiling.NewSnapshotter(c.ctx, c.logger, c.config.ProfileDir, interval, c.config.ProfileMaxSnapshots)
 	}
 
 	// others will come
@@ -102,22 +724,537 @@ func (c *Controller) Start() error {
 		c.logger.Warn("Hardware validation failed, continuing with reduced capabilities")
 	}
 
+	// Follow the SR-IOV network operator, the SR-IOV manager asks it
+	// whether it provisions the PFs
+	if c.sriovOperator != nil {
+		c.watchOnly("SR-IOV operator watcher", c.sriovOperator, c.sriovOperator.Start)
+	}
+
+	// Follow the KubeVirt VMIs, the SR-IOV manager allocates their VFs
+	// from the VMIs listed
+	if c.kubevirt != nil {
+		c.watchOnly("KubeVirt VMI watcher", c.kubevirt, c.kubevirt.Start)
+	}
+
 	// Start SR-IOV manager if enabled
 	if c.sriovManager != nil {
-		c.wg.Add(1)
-		go func() {
-			defer c.wg.Done()
-			if err := c.sriovManager.Start(); err != nil {
-				c.logger.WithError(err).Error(("SR-IOV manager failed"))
+		c.watchOnly("SR-IOV manager", c.sriovManager, c.sriovManager.Start)
+	}
+
+	// Check the VF inventory against the node periodically if enabled
+	if c.sriovManager != nil && c.config.InventoryCheckIntervalSec > 0 {
+		interval := time.Duration(c.config.InventoryCheckIntervalSec) * time.Second
+		c.runWatched("VF inventory checker", func(ctx context.Context, hb *watchdog.Heartbeat) func() error {
+			checker := hardware.NewConsistencyChecker(ctx, c.sriovManager, interval, c.config.EnableInventoryRepair)
+			checker.SetHeartbeat(hb)
+			return checker.Start
+		})
+	}
+
+	// Start uevent listener if hotplug is handled, the polls catch up
+	// at the regular interval without it
+	if c.uevents != nil {
+		c.runComponent("uevent listener", func() error {
+			err := c.uevents.Start()
+			if err != nil {
+				c.sriovManager.SetPollInterval(hardware.DefaultPollInterval)
+			}
+			return err
+		})
+	}
+
+	// Start DPDK manager if enabled
+	if c.dpdkManager != nil {
+		c.watchOnly("DPDK manager", c.dpdkManager, c.dpdkManager.Start)
+	}
+
+	// Start disruption coordinator
+	c.watchOnly("disruption coordinator", c.disruptions, c.disruptions.Start)
+
+	// Start usage meter if enabled
+	if c.usageMeter != nil {
+		c.watchOnly("usage meter", c.usageMeter, c.usageMeter.Start)
+	}
+
+	// Start node pressure monitor if enabled
+	if c.pressureMonitor != nil {
+		c.watchOnly("node pressure monitor", c.pressureMonitor, c.pressureMonitor.Start)
+	}
+
+	// Start thermal monitor if enabled
+	if c.thermalMonitor != nil {
+		c.watchOnly("thermal monitor", c.thermalMonitor, c.thermalMonitor.Start)
+	}
+
+	// Start devlink monitor if enabled
+	if c.devlinkMonitor != nil {
+		// health events are recorded on the node
+		recorder := c.mgr.GetEventRecorderFor("nsm-controller")
+		node := &corev1.ObjectReference{Kind: "Node", Name: c.config.EdgeNodeID, UID: types.UID(c.config.EdgeNodeID)}
+		c.devlinkMonitor.OnEvent(func(e devlink.Event) {
+			eventType := corev1.EventTypeWarning
+			if e.Healthy() && e.NewErrors == 0 {
+				eventType = corev1.EventTypeNormal
+			}
+			recorder.Event(node, eventType, "DevlinkHealth", e.Message())
+		})
+		c.watchOnly("devlink monitor", c.devlinkMonitor, c.devlinkMonitor.Start)
+	}
+
+	// Start idle detector if enabled
+	if c.idleDetector != nil {
+		c.watchOnly("idle detector", c.idleDetector, func() error {
+			if !c.mgr.GetCache().WaitForCacheSync(c.ctx) {
+				return fmt.Errorf("cache not synced")
+			}
+			if err := c.idleDetector.WakeOn(c.ctx, c.mgr.GetCache()); err != nil {
+				return err
+			}
+			return c.idleDetector.Start()
+		})
+	}
+
+	// Start resource budget manager if enabled
+	if c.budgetManager != nil {
+		c.watchOnly("resource budget manager", c.budgetManager, c.budgetManager.Start)
+	}
+
+	// Start BFD manager if enabled
+	if c.bfdManager != nil {
+		c.runComponent("BFD manager", c.bfdManager.Start)
+	}
+
+	// Echo the test traffic of other nodes if enabled
+	if c.config.TrafficReflectorListenAddr != "" {
+		reflector := traffic.NewReflector(c.ctx, c.logger, c.config.TrafficReflectorListenAddr)
+		c.runComponent("traffic reflector", reflector.Start)
+	}
+
+	// Start route health injector if enabled
+	if c.config.EnableRouteInjection {
+		speaker := anycast.NewGoBGPSpeaker(c.config.GoBGPBinary, c.config.BGPNextHop)
+		c.runWatched("route health injector", func(ctx context.Context, hb *watchdog.Heartbeat) func() error {
+			injector := anycast.NewInjector(ctx, c.mgr.GetClient(), c.logger, speaker)
+			injector.SetHeartbeat(hb)
+			return injector.Start
+		})
+	}
+
+	// Start the failover engine re-routing connections off failed paths
+	if c.config.EnableFailover {
+		policy := failover.PolicyFor(c.config.FailoverStrategy)
+		c.runWatched("failover engine", func(ctx context.Context, hb *watchdog.Heartbeat) func() error {
+			checker := failover.NewProbeChecker(netutil.NewNetlink(), 3, policy.Interval)
+			engine := failover.NewEngine(ctx, c.mgr.GetClient(), c.logger, c.applier, checker,
+				c.config.FailoverStrategy, c.config.EdgeNodeID)
+			if c.costModel != nil {
+				engine.SetCostModel(c.costModel)
+			}
+			if c.bfdManager != nil {
+				engine.SetBFD(c.bfdManager)
+			}
+			engine.SetHeartbeat(hb)
+			return engine.Start
+		})
+	}
+
+	// Start shaping the QoS interfaces by connection priority if enabled
+	if c.config.EnableQoS {
+		interval := time.Duration(c.config.QoSIntervalSec) * time.Second
+		c.runWatched("QoS enforcer", func(ctx context.Context, hb *watchdog.Heartbeat) func() error {
+			c.reloadMu.Lock()
+			defer c.reloadMu.Unlock()
+			live := c.liveConfig()
+			enforcer := qos.NewEnforcer(ctx, c.mgr.GetClient(), c.logger, c.applier, c.config.QoSInterfaces,
+				live.QoSLinkRateMbps, live.QoSPriority, interval)
+			enforcer.SetHeartbeat(hb)
+			c.qosEnforcer = enforcer
+			return enforcer.Start
+		})
+	}
+
+	// Publish the latencies achieved to the services for the scheduler
+	if c.config.EnableLatencyHints {
+		interval := time.Duration(c.config.LatencyHintIntervalSec) * time.Second
+		nodeName := c.config.LatencyHintNodeName
+		if nodeName == "" {
+			nodeName = c.config.EdgeNodeID
+		}
+		c.runWatched("latency hint publisher", func(ctx context.Context, hb *watchdog.Heartbeat) func() error {
+			publisher := placement.NewPublisher(ctx, c.mgr.GetClient(), c.logger, c.config.EdgeNodeID, nodeName, interval)
+			publisher.SetHeartbeat(hb)
+			return publisher.Start
+		})
+	}
+
+	// Start external endpoint health checks, services without external
+	// endpoints aren't checked
+	c.runWatched("external endpoint checker", func(ctx context.Context, hb *watchdog.Heartbeat) func() error {
+		checker := external.NewChecker(ctx, c.mgr.GetClient(), c.logger)
+		checker.SetHeartbeat(hb)
+		return checker.Start
+	})
+
+	// Start watching the traffic of the connections for anomalies if enabled
+	if c.config.EnableAnomalyDetection {
+		interval := time.Duration(c.config.AnomalyIntervalSec) * time.Second
+		c.runWatched("traffic anomaly detector", func(ctx context.Context, hb *watchdog.Heartbeat) func() error {
+			detector := anomaly.NewDetector(ctx, c.mgr.GetClient(), c.logger, interval, c.config.AnomalySpikeFactor)
+			detector.SetRecorder(c.mgr.GetEventRecorderFor("nsm-controller"))
+			if c.config.AnomalyWebhookURL != "" {
+				detector.SetAlerter(anomaly.NewWebhookAlerter(c.config.AnomalyWebhookURL))
+			}
+			detector.SetHeartbeat(hb)
+			return detector.Start
+		})
+	}
+
+	// Publish the interfaces of the node as NetworkEndpoints if enabled
+	if c.config.EnableEndpointRegistry {
+		interval := time.Duration(c.config.EndpointRegistryIntervalSec) * time.Second
+		var nics []hardware.NIC
+		if c.platform != nil {
+			nics = c.platform.NICs
+		}
+		interfaces := netendpoint.HostInterfaces(nics, c.config.EnableSRIOV, c.config.EnableDPDK)
+		c.runWatched("network endpoint registry", func(ctx context.Context, hb *watchdog.Heartbeat) func() error {
+			registry := netendpoint.NewRegistry(ctx, c.mgr.GetClient(), c.logger, c.config.EdgeNodeID, interfaces, interval)
+			registry.SetHeartbeat(hb)
+			return registry.Start
+		})
+	}
+
+	// Start MAC learning table monitor if enabled
+	if c.config.EnableFDBMonitor {
+		threshold := c.config.FDBFlapThreshold
+		c.runWatched("FDB monitor", func(ctx context.Context, hb *watchdog.Heartbeat) func() error {
+			monitor := fdb.NewMonitor(ctx, c.logger, netutil.NewNetlink(), threshold)
+			monitor.SetHeartbeat(hb)
+			return monitor.Start
+		})
+	}
+
+	// Start connection probe monitor if enabled
+	if c.config.ProbeIntervalSec > 0 {
+		c.runWatched("connection probe monitor", func(ctx context.Context, hb *watchdog.Heartbeat) func() error {
+			c.reloadMu.Lock()
+			defer c.reloadMu.Unlock()
+			live := c.liveConfig()
+			// disabling the probes takes effect on the next restart
+			if live.ProbeIntervalSec <= 0 {
+				live = c.config
+			}
+			monitor := probe.NewMonitor(ctx, c.mgr.GetClient(), c.logger, time.Duration(live.ProbeIntervalSec)*time.Second,
+				time.Duration(live.ProbeMinIntervalSec)*time.Second, float64(live.ProbeBudgetPerSec))
+			monitor.SetHeartbeat(hb)
+			c.probeMonitor = monitor
+			return monitor.Start
+		})
+	}
+
+	// Start key rotation scheduler if enabled
+	if c.config.RekeyIntervalSec > 0 {
+		interval := time.Duration(c.config.RekeyIntervalSec) * time.Second
+		gap := time.Duration(c.config.RekeyGapSec) * time.Second
+		c.runWatched("key rotation scheduler", func(ctx context.Context, hb *watchdog.Heartbeat) func() error {
+			scheduler := rekey.NewScheduler(ctx, c.mgr.GetClient(), c.logger, c.ipsec, interval, gap)
+			scheduler.SetNode(c.config.EdgeNodeID)
+			scheduler.SetHeartbeat(hb)
+			return scheduler.Start
+		})
+	}
+
+	// Start cloud reporter if enabled, its heartbeats tell whether the
+	// node runs autonomously
+	if c.config.CloudEndpoint != "" {
+		recorder := c.mgr.GetEventRecorderFor("nsm-controller")
+		node := &corev1.ObjectReference{Kind: "Node", Name: c.config.EdgeNodeID, UID: types.UID(c.config.EdgeNodeID)}
+		c.cloudLink.OnChange(func(autonomous bool) {
+			if autonomous {
+				recorder.Event(node, corev1.EventTypeWarning, "CloudDisconnected", "lost the cloud, running autonomously on the local state")
+			} else {
+				recorder.Event(node, corev1.EventTypeNormal, "CloudReconnected", "reconnected to the cloud")
+			}
+		})
+		interval := time.Duration(c.config.CloudHeartbeatSec) * time.Second
+		downsampling := telemetry.Downsampling{
+			Rollup:   time.Duration(c.config.TelemetryRollupSec) * time.Second,
+			TopN:     c.config.TelemetryTopN,
+			MaxBytes: c.config.TelemetryMaxBytes,
+		}
+		c.runWatched("cloud reporter", func(ctx context.Context, hb *watchdog.Heartbeat) func() error {
+			reporter := telemetry.NewReporter(ctx, c.mgr.GetClient(), c.logger, c.config.CloudEndpoint, c.config.EdgeNodeID, interval, downsampling)
+			if c.sriovManager != nil {
+				reporter.SetVFLister(c.sriovManager)
+			}
+			if c.pressureMonitor != nil {
+				reporter.SetPressureSignal(c.pressureMonitor)
+			}
+			if c.idleDetector != nil {
+				reporter.SetIdleSignal(c.idleDetector)
+			}
+			if c.thermalMonitor != nil && c.config.ThermalReducePolling {
+				reporter.SetThermalSignal(c.thermalMonitor)
+			}
+			if c.config.PushUsageReports {
+				reporter.SetUsageSource(c.usageMeter)
+			}
+			reporter.SetLink(c.cloudLink)
+			reporter.SetBudget(c.track("telemetry", c.config.TelemetryCPUBudgetPercent, c.config.TelemetryMemoryBudgetMB))
+			reporter.SetHeartbeat(hb)
+			return reporter.Start
+		})
+	}
+
+	// Sync the NetworkServices of an external device inventory
+	if c.config.RegistrySource != "" {
+		c.runWatched("registry sync", func(ctx context.Context, hb *watchdog.Heartbeat) func() error {
+			syncer := registry.NewSyncer(ctx, c.mgr.GetClient(), c.logger, c.registrySource(),
+				c.config.RegistryNamespace, c.config.RegistryServiceType, time.Duration(c.config.RegistrySyncIntervalSec)*time.Second)
+			if c.cloudLink != nil {
+				syncer.SetCloudSignal(c.cloudLink)
+			}
+			syncer.SetHeartbeat(hb)
+			return func() error {
+				if !c.mgr.GetCache().WaitForCacheSync(ctx) {
+					return fmt.Errorf("cache not synced")
+				}
+				return syncer.Start()
+			}
+		})
+	}
+
+	// Move the keys out of the controller-global Secret of older versions
+	if c.config.LegacyKeySecret != "" {
+		c.runComponent("tunnel key migration", func() error {
+			if !c.mgr.GetCache().WaitForCacheSync(c.ctx) {
+				return fmt.Errorf("cache not synced")
 			}
-		}()
-		c.logger.Info("Started SR-IOV manager")
+			_, err := c.keyStore.Migrate(c.ctx)
+			return err
+		})
+	}
+
+	// Apply the bootstrap file of air-gapped sites
+	if c.config.BootstrapFile != "" {
+		c.runComponent("bootstrap", c.bootstrap)
+	}
+
+	// Collect the connections left behind by crashed clients
+	if c.config.EnableConnectionGC {
+		grace := time.Duration(c.config.ConnectionGCGraceSec) * time.Second
+		c.runWatched("connection GC", func(ctx context.Context, hb *watchdog.Heartbeat) func() error {
+			collector := gc.NewCollector(ctx, c.mgr.GetClient(), c.logger, grace, c.config.ConnectionGCDryRun)
+			collector.SetHeartbeat(hb)
+			return func() error {
+				if !c.mgr.GetCache().WaitForCacheSync(ctx) {
+					return fmt.Errorf("cache not synced")
+				}
+				return collector.Start()
+			}
+		})
+	}
+
+	// Register the agent of this node and track the others if enabled
+	if c.agents != nil {
+		duration := time.Duration(c.config.AgentLeaseDurationSec) * time.Second
+		c.runWatched("agent heartbeat", func(ctx context.Context, hb *watchdog.Heartbeat) func() error {
+			heartbeat := agent.NewHeartbeat(ctx, c.mgr.GetClient(), c.logger, c.config.AgentLeaseNamespace, c.config.EdgeNodeID, duration)
+			if c.sriovManager != nil {
+				heartbeat.SetVFLister(c.sriovManager)
+			}
+			heartbeat.SetHeartbeat(hb)
+			return heartbeat.Start
+		})
+		c.watchOnly("agent registry", c.agents, func() error {
+			if !c.mgr.GetCache().WaitForCacheSync(c.ctx) {
+				return fmt.Errorf("cache not synced")
+			}
+			return c.agents.Start()
+		})
+	}
+
+	// Join the shard group if enabled
+	if c.membership != nil {
+		c.watchOnly("shard membership", c.membership, c.membership.Start)
+	}
+
+	// Start the reachability scorer if enabled
+	if c.scorer != nil {
+		c.watchOnly("reachability scorer", c.scorer, c.scorer.Start)
+	}
+
+	// Start pricing the uplinks if they have costs
+	if c.costModel != nil {
+		c.watchOnly("uplink cost model", c.costModel, c.costModel.Start)
+	}
+
+	// Start xDS server if enabled
+	if c.xdsServer != nil {
+		// a restart would race the stalled instance for the listen address
+		c.watchOnly("xDS server", c.xdsServer, c.xdsServer.Start)
+	}
+
+	// Start management API if enabled
+	if c.apiServer != nil {
+		c.runComponent("management API", c.apiServer.Start)
+	}
+
+	// Start CNI plugin server if enabled
+	if c.cniServer != nil {
+		c.runComponent("CNI plugin server", c.cniServer.Start)
+	}
+
+	// Start VF API for local agents if enabled
+	if c.vfServer != nil {
+		c.runComponent("VF API", c.vfServer.Start)
+	}
+
+	// Start Prometheus metrics server if enabled
+	if c.metricsServer != nil {
+		c.runComponent("metrics server", c.metricsServer.Start)
+	}
+
+	// Start health probe server if enabled
+	if c.healthServer != nil {
+		c.runComponent("health probe server", c.healthServer.Start)
+	}
+
+	// Start metrics streaming API if enabled
+	if c.metricsStream != nil {
+		c.runComponent("metrics streaming API", c.metricsStream.Start)
+	}
+
+	// Start profile snapshotter if enabled
+	if c.snapshotter != nil {
+		c.runComponent("profile snapshotter", c.snapshotter.Start)
+	}
+
+	// Start watchdog if enabled
+	if c.watchdog != nil {
+		c.runComponent("watchdog", c.watchdog.Start)
+	}
+
+	// Reload the config file on changes and SIGHUP if one is set
+	if c.configPath != "" {
+		c.runComponent("config watcher", config.NewWatcher(c.ctx, c.logger, c.configPath, c.Reload).Start)
 	}
 
+	// Start the CRD reconcilers
+	c.watchLeadership()
+	c.runComponent("controller manager", c.runManager)
+
 	c.logger.Info("All components started successfully")
 	return nil
 }
 
+// registrySource creates the configured external device inventory
+func (c *Controller) registrySource() registry.Source {
+	httpClient := &http.Client{Timeout: 30 * time.Second}
+	if c.config.RegistrySource == "netbox" {
+		// validated with the config
+		filter, _ := url.ParseQuery(c.config.RegistryFilter)
+		return registry.NewNetBoxSource(c.config.RegistryURL, c.config.RegistryToken, filter, httpClient)
+	}
+	return registry.NewCSVSource(c.config.RegistryURL, httpClient)
+}
+
+// bootstrap applies the bootstrap file once the cache is synced
+func (c *Controller) bootstrap() error {
+	objs, err := bootstrap.Load(c.config.BootstrapFile)
+	if err != nil {
+		return err
+	}
+	if !c.mgr.GetCache().WaitForCacheSync(c.ctx) {
+		return fmt.Errorf("cache not synced")
+	}
+
+	result, err := bootstrap.Apply(c.ctx, c.mgr.GetClient(), objs, c.config.BootstrapPrune)
+	if err != nil {
+		return err
+	}
+	c.logger.Infof("Applied bootstrap file %s: %d created, %d updated, %d unchanged, %d pruned",
+		c.config.BootstrapFile, len(result.Created), len(result.Updated), len(result.Unchanged), len(result.Pruned))
+	if len(result.Stale) > 0 {
+		c.logger.Warnf("Objects removed from the bootstrap file kept, enable bootstrapPrune to delete them: %v", result.Stale)
+	}
+	if len(result.Unowned) > 0 {
+		c.logger.Warnf("Objects of the bootstrap file exist without the %s label and were left alone: %v", bootstrap.LabelBootstrap, result.Unowned)
+	}
+	return nil
+}
+
+// legacyKeySecret parses the "namespace/name" of the legacy key Secret
+func legacyKeySecret(ref string) types.NamespacedName {
+	namespace, name, _ := strings.Cut(ref, "/")
+	return types.NamespacedName{Namespace: namespace, Name: name}
+}
+
+// runComponent runs a component's blocking start function in a goroutine
+func (c *Controller) runComponent(name string, start func() error) {
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		if err := start(); err != nil {
+			c.logger.WithError(err).Errorf("%s failed", name)
+		}
+	}()
+	c.logger.Infof("Started %s", name)
+}
+
+// watchOnly runs a component the watchdog reports when it stalls but never
+// restarts: other components hold it, or its state lives in memory, so a
+// fresh instance would be cut off from them
+func (c *Controller) watchOnly(name string, component interface{ SetHeartbeat(*watchdog.Heartbeat) }, start func() error) {
+	if c.watchdog != nil {
+		component.SetHeartbeat(c.watchdog.Register(name, nil))
+	}
+	c.runComponent(name, start)
+}
+
+// runWatched runs a loop component built by build. When the watchdog
+// restarts it, the context of the stalled instance is cancelled and a
+// fresh instance is built; the stuck goroutine itself can't be stopped.
+func (c *Controller) runWatched(name string, build func(ctx context.Context, hb *watchdog.Heartbeat) func() error) {
+	if c.watchdog == nil {
+		c.runComponent(name, build(c.ctx, nil))
+		return
+	}
+
+	restart := make(chan struct{}, 1)
+	hb := c.watchdog.Register(name, func() {
+		select {
+		case restart <- struct{}{}:
+		default:
+		}
+	})
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		for {
+			ctx, cancel := context.WithCancel(c.ctx)
+			done := make(chan error, 1)
+			start := build(ctx, hb)
+			hb.Beat()
+			go func() { done <- start() }()
+
+			select {
+			case err := <-done:
+				cancel()
+				if err != nil {
+					c.logger.WithError(err).Errorf("%s failed", name)
+				}
+				return
+			case <-restart:
+				cancel()
+				c.logger.Warnf("Restarted stalled %s", name)
+			}
+		}
+	}()
+	c.logger.Infof("Started %s", name)
+}
+
 // Stop gracefully shuts down all controller components
 func (c *Controller) Stop() error {
 	c.logger.Info("Stopping NSM Controller")
@@ -146,14 +1283,25 @@ func (c *Controller) Stop() error {
 func (c *Controller) validateHardware() error {
 	var errors []error
 
+	platform := c.platform
+	if platform == nil {
+		return fmt.Errorf("hardware detection did not complete")
+	}
+	for _, quirk := range platform.Quirks {
+		c.logger.Warnf("Hardware quirk: %s", quirk)
+	}
+
 	// validate SR-IOV if enabled
 	if c.config.EnableSRIOV {
-		if err := hardware.ValidateSRIOVCapabilities(); err != nil {
+		if err := hardware.ValidateSRIOVCapabilities(platform); err != nil {
 			errors = append(errors, fmt.Errorf("SR-IOV validation failed: %w", err))
 		}
 	}
 
-	// TODO for DPDK
+	// DPDK binds devices to vfio-pci, which needs a working IOMMU
+	if c.config.EnableDPDK && c.config.DPDKDriver == hardware.DriverVFIOPCI && !platform.VFIO() {
+		errors = append(errors, fmt.Errorf("DPDK validation failed: VFIO unavailable (IOMMU %s)", platform.IOMMU))
+	}
 
 	if len(errors) > 0 {
 		return fmt.Errorf("hardware validation failed: %v", errors)
@@ -161,3 +1309,344 @@ func (c *Controller) validateHardware() error {
 
 	return nil
 }
+
+// handleExplainPod serves the allocation decisions on a pod
+func (c *Controller) handleExplainPod(w http.ResponseWriter, r *http.Request) {
+	if c.sriovManager == nil {
+		api.WriteError(w, http.StatusServiceUnavailable, fmt.Errorf("SR-IOV is disabled"))
+		return
+	}
+	namespace, name := r.PathValue("namespace"), r.PathValue("name")
+	exp, ok := c.sriovManager.Explain(namespace, name)
+	if !ok {
+		api.WriteError(w, http.StatusNotFound, fmt.Errorf("no allocation decisions on pod %s/%s, pods request a VF with the label %s=true", namespace, name, idle.LabelSRIOV))
+		return
+	}
+	api.WriteJSON(w, http.StatusOK, exp)
+}
+
+// handleListLeases serves the VF leases
+func (c *Controller) handleListLeases(w http.ResponseWriter, r *http.Request) {
+	if c.sriovManager == nil {
+		api.WriteError(w, http.StatusServiceUnavailable, fmt.Errorf("SR-IOV is disabled"))
+		return
+	}
+	api.WriteJSON(w, http.StatusOK, c.sriovManager.Leases())
+}
+
+// handleLeaseVF leases a free VF to a pod, or renews its lease
+func (c *Controller) handleLeaseVF(w http.ResponseWriter, r *http.Request) {
+	if c.sriovManager == nil {
+		api.WriteError(w, http.StatusServiceUnavailable, fmt.Errorf("SR-IOV is disabled"))
+		return
+	}
+	var req hardware.LeaseRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		api.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid lease request: %w", err))
+		return
+	}
+	if req.Namespace == "" || req.Pod == "" {
+		api.WriteError(w, http.StatusBadRequest, fmt.Errorf("namespace and pod are required"))
+		return
+	}
+	if req.TTLSeconds <= 0 || req.TTLSeconds > c.config.VFLeaseMaxTTLSec {
+		api.WriteError(w, http.StatusBadRequest, fmt.Errorf("TTL must be between 1 and %d seconds", c.config.VFLeaseMaxTTLSec))
+		return
+	}
+
+	lease, err := c.sriovManager.LeaseVF(req.Namespace, req.Pod, time.Duration(req.TTLSeconds)*time.Second)
+	if err != nil {
+		api.WriteError(w, http.StatusConflict, err)
+		return
+	}
+	api.WriteJSON(w, http.StatusOK, lease)
+}
+
+// handleRenewLease extends the VF lease of a pod by its TTL
+func (c *Controller) handleRenewLease(w http.ResponseWriter, r *http.Request) {
+	if c.sriovManager == nil {
+		api.WriteError(w, http.StatusServiceUnavailable, fmt.Errorf("SR-IOV is disabled"))
+		return
+	}
+	namespace, name := r.PathValue("namespace"), r.PathValue("name")
+	lease, ok := c.sriovManager.RenewLease(namespace, name)
+	if !ok {
+		api.WriteError(w, http.StatusNotFound, fmt.Errorf("pod %s/%s holds no VF lease, it may have expired", namespace, name))
+		return
+	}
+	api.WriteJSON(w, http.StatusOK, lease)
+}
+
+// handleReleaseLease frees the VF leased to a pod
+func (c *Controller) handleReleaseLease(w http.ResponseWriter, r *http.Request) {
+	if c.sriovManager == nil {
+		api.WriteError(w, http.StatusServiceUnavailable, fmt.Errorf("SR-IOV is disabled"))
+		return
+	}
+	namespace, name := r.PathValue("namespace"), r.PathValue("name")
+	if !c.sriovManager.ReleaseLease(namespace, name) {
+		api.WriteError(w, http.StatusNotFound, fmt.Errorf("pod %s/%s holds no VF lease", namespace, name))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleListVFs serves the VF inventory with the pods the VFs are
+// allocated to
+func (c *Controller) handleListVFs(w http.ResponseWriter, r *http.Request) {
+	if c.sriovManager == nil {
+		api.WriteError(w, http.StatusServiceUnavailable, fmt.Errorf("SR-IOV is disabled"))
+		return
+	}
+	api.WriteJSON(w, http.StatusOK, c.sriovManager.VirtualFunctions())
+}
+
+// handleReleaseVF frees the VFs allocated to a pod, e.g., one stuck after
+// the pod is gone
+func (c *Controller) handleReleaseVF(w http.ResponseWriter, r *http.Request) {
+	if c.sriovManager == nil {
+		api.WriteError(w, http.StatusServiceUnavailable, fmt.Errorf("SR-IOV is disabled"))
+		return
+	}
+	namespace, name := r.PathValue("namespace"), r.PathValue("name")
+	if !c.sriovManager.ReleaseVF(namespace, name) {
+		api.WriteError(w, http.StatusNotFound, fmt.Errorf("pod %s/%s holds no VF", namespace, name))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// track returns the resource tracker of a subsystem, nil without budgets
+func (c *Controller) track(name string, cpuPercent, memoryMB int) *budget.Tracker {
+	if c.budgetManager == nil {
+		return nil
+	}
+	return c.budgetManager.Track(name, budget.Budget{CPUPercent: float64(cpuPercent), MemoryBytes: int64(memoryMB) << 20})
+}
+
+// handlePlatform serves the detected hardware platform
+func (c *Controller) handlePlatform(w http.ResponseWriter, r *http.Request) {
+	if c.platform == nil {
+		api.WriteError(w, http.StatusServiceUnavailable, fmt.Errorf("hardware detection did not complete"))
+		return
+	}
+	api.WriteJSON(w, http.StatusOK, c.platform)
+}
+
+// handleServices serves the NetworkServices registered in the catalog
+func (c *Controller) handleServices(w http.ResponseWriter, r *http.Request) {
+	api.WriteJSON(w, http.StatusOK, c.catalog.List(r.URL.Query().Get("serviceType")))
+}
+
+// handleEndpoints serves the service endpoints ranked by reachability
+func (c *Controller) handleEndpoints(w http.ResponseWriter, r *http.Request) {
+	if c.scorer == nil {
+		api.WriteError(w, http.StatusServiceUnavailable, fmt.Errorf("reachability scoring is disabled"))
+		return
+	}
+	query := r.URL.Query()
+	api.WriteJSON(w, http.StatusOK, c.scorer.Endpoints(query.Get("namespace"), query.Get("serviceType")))
+}
+
+// handleUplinks serves the month-to-date spend of the uplinks with costs
+func (c *Controller) handleUplinks(w http.ResponseWriter, r *http.Request) {
+	if c.costModel == nil {
+		api.WriteError(w, http.StatusServiceUnavailable, fmt.Errorf("no uplink costs are configured"))
+		return
+	}
+	api.WriteJSON(w, http.StatusOK, c.costModel.Spends())
+}
+
+// handleNodes serves the liveness of the agents registered with the controller
+func (c *Controller) handleNodes(w http.ResponseWriter, r *http.Request) {
+	if c.agents == nil {
+		api.WriteError(w, http.StatusServiceUnavailable, fmt.Errorf("agent leases are disabled"))
+		return
+	}
+	api.WriteJSON(w, http.StatusOK, c.agents.Nodes())
+}
+
+// handleDPDK serves the hugepages and the DPDK devices of the node
+func (c *Controller) handleDPDK(w http.ResponseWriter, r *http.Request) {
+	if c.dpdkManager == nil {
+		api.WriteError(w, http.StatusServiceUnavailable, fmt.Errorf("DPDK is disabled"))
+		return
+	}
+	api.WriteJSON(w, http.StatusOK, c.dpdkManager.Inventory())
+}
+
+// handleSetNumVFs changes the number of VFs of a PF, once the pods using
+// them are evicted as their disruption budgets allow
+func (c *Controller) handleSetNumVFs(w http.ResponseWriter, r *http.Request) {
+	if c.sriovManager == nil {
+		api.WriteError(w, http.StatusServiceUnavailable, fmt.Errorf("SR-IOV is disabled"))
+		return
+	}
+	var req hardware.NumVFsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		api.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid VF count request: %w", err))
+		return
+	}
+
+	err := c.sriovManager.SetNumVFs(r.PathValue("pf"), req.NumVFs)
+	var blocked *disruption.BlockedError
+	switch {
+	case err == nil:
+		w.WriteHeader(http.StatusNoContent)
+	case errors.Is(err, hardware.ErrProvisionedExternally):
+		api.WriteError(w, http.StatusConflict, err)
+	case errors.As(err, &blocked) && blocked.Deferred:
+		// applied once the budgets allow it
+		api.WriteError(w, http.StatusAccepted, err)
+	case errors.As(err, &blocked):
+		api.WriteError(w, http.StatusConflict, err)
+	default:
+		api.WriteError(w, http.StatusBadRequest, err)
+	}
+}
+
+// handleResetPF resets a PF, re-attaching the VFs to their pods after it
+// or, without reparenting, once the pods are evicted as their disruption
+// budgets allow
+func (c *Controller) handleResetPF(w http.ResponseWriter, r *http.Request) {
+	if c.sriovManager == nil {
+		api.WriteError(w, http.StatusServiceUnavailable, fmt.Errorf("SR-IOV is disabled"))
+		return
+	}
+
+	report, err := c.sriovManager.ResetPF(r.PathValue("pf"))
+	var blocked *disruption.BlockedError
+	switch {
+	case err == nil:
+		api.WriteJSON(w, http.StatusOK, report)
+	case errors.Is(err, hardware.ErrProvisionedExternally):
+		api.WriteError(w, http.StatusConflict, err)
+	case errors.As(err, &blocked) && blocked.Deferred:
+		// applied once the budgets allow it
+		api.WriteError(w, http.StatusAccepted, err)
+	case errors.As(err, &blocked):
+		api.WriteError(w, http.StatusConflict, err)
+	default:
+		api.WriteError(w, http.StatusBadRequest, err)
+	}
+}
+
+// handleCheckConsistency serves the differences between the VF inventory
+// and the node, with the repairs they suggest
+func (c *Controller) handleCheckConsistency(w http.ResponseWriter, r *http.Request) {
+	c.serveConsistency(w, false)
+}
+
+// handleRepairConsistency repairs the differences between the VF inventory
+// and the node, and serves them
+func (c *Controller) handleRepairConsistency(w http.ResponseWriter, r *http.Request) {
+	c.serveConsistency(w, true)
+}
+
+// serveConsistency checks the consistency of the VF inventory, repairing
+// it if asked
+func (c *Controller) serveConsistency(w http.ResponseWriter, repair bool) {
+	if c.sriovManager == nil {
+		api.WriteError(w, http.StatusServiceUnavailable, fmt.Errorf("SR-IOV is disabled"))
+		return
+	}
+	report, err := c.sriovManager.CheckConsistency(repair)
+	if err != nil {
+		api.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+	api.WriteJSON(w, http.StatusOK, report)
+}
+
+// handleSRIOVOperator serves the state of the SR-IOV network operator on
+// the node and the PFs whose VFs are left to it
+func (c *Controller) handleSRIOVOperator(w http.ResponseWriter, r *http.Request) {
+	if c.sriovOperator == nil {
+		api.WriteError(w, http.StatusServiceUnavailable, fmt.Errorf("SR-IOV operator coexistence is disabled"))
+		return
+	}
+	api.WriteJSON(w, http.StatusOK, c.sriovOperator.State())
+}
+
+// handleVirtualMachines serves the KubeVirt VMIs requesting VFs and the
+// VFs allocated to them
+func (c *Controller) handleVirtualMachines(w http.ResponseWriter, r *http.Request) {
+	if c.kubevirt == nil {
+		api.WriteError(w, http.StatusServiceUnavailable, fmt.Errorf("KubeVirt support is disabled"))
+		return
+	}
+	vms := c.kubevirt.VirtualMachines()
+	for i, vm := range vms {
+		for _, vf := range c.sriovManager.GetVFsForPod(vm.Namespace, vm.Name) {
+			vms[i].VFs = append(vms[i].VFs, vf.PCIAddress)
+		}
+	}
+	api.WriteJSON(w, http.StatusOK, vms)
+}
+
+// handleDisruptions serves the disruptive changes deferred until the
+// disruption budgets allow them
+func (c *Controller) handleDisruptions(w http.ResponseWriter, r *http.Request) {
+	api.WriteJSON(w, http.StatusOK, c.disruptions.Deferred())
+}
+
+// handleCloud serves the connectivity of the node to the cloud
+func (c *Controller) handleCloud(w http.ResponseWriter, r *http.Request) {
+	if c.cloudLink == nil {
+		api.WriteError(w, http.StatusServiceUnavailable, fmt.Errorf("no cloud endpoint configured"))
+		return
+	}
+	api.WriteJSON(w, http.StatusOK, c.cloudLink.Status())
+}
+
+// handleDevlink serves the devlink devices of the node with their eswitch
+// and health reporters
+func (c *Controller) handleDevlink(w http.ResponseWriter, r *http.Request) {
+	if c.devlinkMonitor == nil {
+		api.WriteError(w, http.StatusServiceUnavailable, fmt.Errorf("devlink is disabled"))
+		return
+	}
+	api.WriteJSON(w, http.StatusOK, c.devlinkMonitor.Devices())
+}
+
+// handleSetEswitchMode changes the eswitch mode of a device. The mode
+// change takes the VFs down, so it is refused while pods use them.
+func (c *Controller) handleSetEswitchMode(w http.ResponseWriter, r *http.Request) {
+	if c.devlinkMonitor == nil {
+		api.WriteError(w, http.StatusServiceUnavailable, fmt.Errorf("devlink is disabled"))
+		return
+	}
+	var req devlink.EswitchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		api.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid eswitch request: %w", err))
+		return
+	}
+	bus, device := r.PathValue("bus"), r.PathValue("device")
+	if _, ok := c.devlinkMonitor.Device(bus, device); !ok {
+		api.WriteError(w, http.StatusNotFound, fmt.Errorf("no devlink device %s/%s", bus, device))
+		return
+	}
+	if c.sriovManager != nil && bus == "pci" {
+		for _, pf := range c.sriovManager.PhysicalFunctions() {
+			if pods := c.sriovManager.PodsOnPF(pf.Name); pf.PCIAddress == device && len(pods) > 0 {
+				api.WriteError(w, http.StatusConflict, fmt.Errorf("%d VFs of %s are allocated to pods", len(pods), pf.Name))
+				return
+			}
+		}
+	}
+
+	if err := c.devlinkMonitor.SetEswitchMode(bus, device, req.Mode); err != nil {
+		api.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleSensors serves the thermal state and sensor readings of the node
+func (c *Controller) handleSensors(w http.ResponseWriter, r *http.Request) {
+	if c.thermalMonitor == nil {
+		api.WriteError(w, http.StatusServiceUnavailable, fmt.Errorf("thermal monitor is disabled"))
+		return
+	}
+	api.WriteJSON(w, http.StatusOK, c.thermalMonitor.Status())
+}
@@ -0,0 +1,221 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/config"
+	"github.com/akos011221/nsm/pkg/cost"
+	"github.com/akos011221/nsm/pkg/hardware"
+	"github.com/akos011221/nsm/pkg/reachability"
+	"github.com/akos011221/nsm/pkg/watchdog"
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRunWatchedRestartsStalledComponent(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	c := &Controller{
+		ctx:      ctx,
+		cancel:   cancel,
+		logger:   logger,
+		watchdog: watchdog.NewWatchdog(ctx, logger, 1, true),
+	}
+
+	var builds atomic.Int32
+	stuck := make(chan struct{})
+	defer close(stuck)
+	c.runWatched("loop", func(ctx context.Context, hb *watchdog.Heartbeat) func() error {
+		n := builds.Add(1)
+		hb.Expect(time.Millisecond)
+		return func() error {
+			if n == 1 {
+				// the first instance hangs and ignores its context
+				<-stuck
+				return nil
+			}
+			<-ctx.Done()
+			return nil
+		}
+	})
+
+	waitFor(t, func() bool { return builds.Load() == 1 })
+	if got := c.watchdog.Check(time.Now().Add(time.Second)); len(got) != 1 {
+		t.Fatalf("stall not detected: %v", got)
+	}
+
+	waitFor(t, func() bool { return builds.Load() == 2 })
+
+	// the restarted instance stops with the controller
+	cancel()
+	done := make(chan struct{})
+	go func() {
+		c.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("restarted component did not stop")
+	}
+}
+
+// waitFor polls the condition until it holds or the test times out
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("condition not met in time")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestHandlePlatform(t *testing.T) {
+	c := &Controller{}
+	rec := httptest.NewRecorder()
+	c.handlePlatform(rec, httptest.NewRequest(http.MethodGet, "/v1/hardware", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d before detection, want 503", rec.Code)
+	}
+
+	c.platform = &hardware.Platform{Arch: "arm64", IOMMU: "arm-smmu-v3", Quirks: []string{"end0 is a platform device, SR-IOV unavailable"}}
+	rec = httptest.NewRecorder()
+	c.handlePlatform(rec, httptest.NewRequest(http.MethodGet, "/v1/hardware", nil))
+	var got hardware.Platform
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if got.Arch != "arm64" || len(got.Quirks) != 1 {
+		t.Errorf("unexpected platform %+v", got)
+	}
+}
+
+func TestHandleExplainPod(t *testing.T) {
+	c := &Controller{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/explain/pods/{namespace}/{name}", c.handleExplainPod)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/explain/pods/edge/camera", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d without SR-IOV, want 503", rec.Code)
+	}
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	c.sriovManager = hardware.NewSRIOVManager(context.Background(), nil, logger)
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/explain/pods/edge/camera", nil))
+	if rec.Code != http.StatusNotFound || !strings.Contains(rec.Body.String(), "edge/camera") {
+		t.Errorf("status = %d (%s) for a pod without decisions, want 404", rec.Code, rec.Body.String())
+	}
+}
+
+func TestHandleEndpoints(t *testing.T) {
+	c := &Controller{}
+	rec := httptest.NewRecorder()
+	c.handleEndpoints(rec, httptest.NewRequest(http.MethodGet, "/v1/endpoints", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d with scoring disabled, want 503", rec.Code)
+	}
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	c.scorer = reachability.NewScorer(context.Background(), nil, logger, "")
+	services := []nsmv1.NetworkService{
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "edge", Name: "down"}, Status: nsmv1.NetworkServiceStatus{Phase: nsmv1.ServicePhaseError}},
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "edge", Name: "up"}, Status: nsmv1.NetworkServiceStatus{Phase: nsmv1.ServicePhaseReady}},
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "other", Name: "up"}, Status: nsmv1.NetworkServiceStatus{Phase: nsmv1.ServicePhaseReady}},
+	}
+	if err := c.scorer.Observe(services, nil, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	rec = httptest.NewRecorder()
+	c.handleEndpoints(rec, httptest.NewRequest(http.MethodGet, "/v1/endpoints?namespace=edge", nil))
+	var endpoints []reachability.Endpoint
+	if err := json.Unmarshal(rec.Body.Bytes(), &endpoints); err != nil {
+		t.Fatalf("invalid response %s: %v", rec.Body.String(), err)
+	}
+	if len(endpoints) != 2 || endpoints[0].Name != "up" || endpoints[1].Name != "down" {
+		t.Errorf("unexpected ranking %+v", endpoints)
+	}
+}
+
+func TestHandleVFs(t *testing.T) {
+	c := &Controller{}
+	rec := httptest.NewRecorder()
+	c.handleListVFs(rec, httptest.NewRequest(http.MethodGet, "/v1/hardware/sriov/vfs", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d without SR-IOV, want 503", rec.Code)
+	}
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	c.sriovManager = hardware.NewSRIOVManager(context.Background(), nil, logger)
+	rec = httptest.NewRecorder()
+	c.handleListVFs(rec, httptest.NewRequest(http.MethodGet, "/v1/hardware/sriov/vfs", nil))
+	if rec.Code != http.StatusOK || strings.TrimSpace(rec.Body.String()) != "[]" {
+		t.Errorf("status = %d (%s), want an empty inventory", rec.Code, rec.Body.String())
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("DELETE /v1/hardware/sriov/vfs/{namespace}/{name}", c.handleReleaseVF)
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/v1/hardware/sriov/vfs/edge/camera", nil))
+	if rec.Code != http.StatusNotFound || !strings.Contains(rec.Body.String(), "edge/camera") {
+		t.Errorf("status = %d (%s) for a pod without VFs, want 404", rec.Code, rec.Body.String())
+	}
+}
+
+func TestHandleUplinks(t *testing.T) {
+	c := &Controller{}
+	rec := httptest.NewRecorder()
+	c.handleUplinks(rec, httptest.NewRequest(http.MethodGet, "/v1/uplinks", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d without uplink costs, want 503", rec.Code)
+	}
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	cfg := config.DefaultConfig()
+	cfg.UplinkCosts = []config.UplinkCost{{Device: "wwan0", CostPerGB: 0.5, MonthlyBudget: 100}, {Device: "eth0"}}
+	c.costModel = cost.NewModel(context.Background(), logger, uplinks(cfg), "")
+
+	rec = httptest.NewRecorder()
+	c.handleUplinks(rec, httptest.NewRequest(http.MethodGet, "/v1/uplinks", nil))
+	var spends []cost.Spend
+	if err := json.Unmarshal(rec.Body.Bytes(), &spends); err != nil {
+		t.Fatalf("invalid response %s: %v", rec.Body.String(), err)
+	}
+	if len(spends) != 2 || spends[1].Device != "wwan0" || spends[1].MonthlyBudget != 100 {
+		t.Errorf("unexpected spends %+v", spends)
+	}
+}
+
+func TestOpenAPIDocumentUpToDate(t *testing.T) {
+	want, err := json.MarshalIndent(OpenAPI(), "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile("../../api/openapi.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(want)+"\n" {
+		t.Errorf("api/openapi.json is out of date, run make -C hack openapi")
+	}
+}
@@ -0,0 +1,138 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/connection"
+	"github.com/akos011221/nsm/pkg/intent"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// DNSConfigMapKey is the key of the resolv.conf in the DNS ConfigMap of a pod
+const DNSConfigMapKey = "resolv.conf"
+
+// LabelDNSPod marks the DNS ConfigMaps with the pod they belong to
+const LabelDNSPod = "nsm.akosrbn.io/dns-pod"
+
+// DNSConfigMapName returns the name of the ConfigMap holding the
+// resolv.conf of a pod, which the pod mounts to resolve over its connections
+func DNSConfigMapName(pod string) string {
+	return intent.ObjectName("nsm-dns", pod)
+}
+
+// DNSReconciler gives pods a resolv.conf listing the resolvers and search
+// domains of their established connections, so apps using L2 or VPN
+// connections resolve names on the remote networks. The resolv.conf is
+// kept in a ConfigMap owned by the pod, updated as connections come and
+// go, and removed once no connection of the pod configures DNS.
+type DNSReconciler struct {
+	// Kubernetes client (controller-runtime)
+	client client.Client
+	// Logger
+	logger *logrus.Logger
+	// Cluster resolver the pods fall back to
+	cluster connection.ClusterDNS
+}
+
+// NewDNSReconciler creates a new connection DNS reconciler
+func NewDNSReconciler(c client.Client, logger *logrus.Logger, cluster connection.ClusterDNS) *DNSReconciler {
+	return &DNSReconciler{
+		client:  c,
+		logger:  logger,
+		cluster: cluster,
+	}
+}
+
+// SetupWithManager registers the reconciler and its watches with the manager
+func (r *DNSReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("connectiondns").
+		For(&corev1.Pod{}).
+		Owns(&corev1.ConfigMap{}).
+		Watches(&nsmv1.NetworkConnection{}, handler.EnqueueRequestsFromMapFunc(r.podForConnection)).
+		Complete(r)
+}
+
+// Reconcile brings the resolv.conf of a pod in line with its connections
+func (r *DNSReconciler) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	var pod corev1.Pod
+	if err := r.client.Get(ctx, req.NamespacedName, &pod); err != nil {
+		// the ConfigMap is garbage collected through its owner reference
+		return reconcile.Result{}, client.IgnoreNotFound(err)
+	}
+
+	var list nsmv1.NetworkConnectionList
+	if err := r.client.List(ctx, &list); err != nil {
+		return reconcile.Result{}, fmt.Errorf("failed to list connections: %w", err)
+	}
+	// the resolvers are only reachable once the connection is up
+	var conns []nsmv1.NetworkConnection
+	for _, conn := range list.Items {
+		if conn.Spec.Source == req.String() && conn.Spec.DNS != nil && conn.Status.Established {
+			conns = append(conns, conn)
+		}
+	}
+
+	cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: pod.Namespace, Name: DNSConfigMapName(pod.Name)}}
+	if len(conns) == 0 {
+		return reconcile.Result{}, r.remove(ctx, &pod, cm)
+	}
+
+	view := connection.BuildDNSView(pod.Namespace, r.cluster, conns)
+	if len(view.Dropped) > 0 {
+		r.logger.Warnf("Leaving nameservers %v out of the resolv.conf of pod %s, invalid or beyond %d", view.Dropped, req, connection.MaxNameservers)
+	}
+	op, err := controllerutil.CreateOrUpdate(ctx, r.client, cm, func() error {
+		if cm.Labels == nil {
+			cm.Labels = make(map[string]string)
+		}
+		cm.Labels[LabelDNSPod] = pod.Name
+		cm.Data = map[string]string{DNSConfigMapKey: view.ResolvConf()}
+		return controllerutil.SetControllerReference(&pod, cm, r.client.Scheme())
+	})
+	if err != nil {
+		return reconcile.Result{}, fmt.Errorf("failed to write DNS config map %s/%s: %w", cm.Namespace, cm.Name, err)
+	}
+	if op != controllerutil.OperationResultNone {
+		r.logger.Infof("Pod %s resolves over %d connections with nameservers %v", req, len(conns), view.Nameservers)
+	}
+	return reconcile.Result{}, nil
+}
+
+// remove deletes the DNS ConfigMap of a pod, if NSM manages it
+func (r *DNSReconciler) remove(ctx context.Context, pod *corev1.Pod, cm *corev1.ConfigMap) error {
+	if err := r.client.Get(ctx, client.ObjectKeyFromObject(cm), cm); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if !metav1.IsControlledBy(cm, pod) {
+		return nil
+	}
+	if err := r.client.Delete(ctx, cm); client.IgnoreNotFound(err) != nil {
+		return fmt.Errorf("failed to delete DNS config map %s/%s: %w", cm.Namespace, cm.Name, err)
+	}
+	r.logger.Infof("Pod %s/%s no longer resolves over its connections", pod.Namespace, pod.Name)
+	return nil
+}
+
+// podForConnection maps a connection to its source pod
+func (r *DNSReconciler) podForConnection(ctx context.Context, obj client.Object) []reconcile.Request {
+	conn, ok := obj.(*nsmv1.NetworkConnection)
+	if !ok {
+		return nil
+	}
+	namespace, name, ok := strings.Cut(conn.Spec.Source, "/")
+	if !ok {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: client.ObjectKey{Namespace: namespace, Name: name}}}
+}
@@ -0,0 +1,69 @@
+package controller
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/connection"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestDNSReconcilerWritesResolvConf(t *testing.T) {
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "edge", Name: "pod", UID: "pod-uid"}}
+	conn := testConnection(nsmv1.ConnectionTypeKernel)
+	conn.Spec.DNS = &nsmv1.ConnectionDNS{Nameservers: []string{"172.16.0.53"}, Searches: []string{"corp.example.com"}}
+	c := newTestClient(t, pod, conn)
+	r := NewDNSReconciler(c, logrus.New(), connection.ClusterDNS{Nameserver: "10.96.0.10", Domain: "cluster.local"})
+	ctx := context.Background()
+	req := reconcile.Request{NamespacedName: client.ObjectKeyFromObject(pod)}
+	key := client.ObjectKey{Namespace: "edge", Name: DNSConfigMapName("pod")}
+
+	if reqs := r.podForConnection(ctx, conn); len(reqs) != 1 || reqs[0] != req {
+		t.Errorf("podForConnection() = %v", reqs)
+	}
+
+	// the resolvers aren't reachable before the connection is established
+	if _, err := r.Reconcile(ctx, req); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if err := c.Get(ctx, key, &corev1.ConfigMap{}); !apierrors.IsNotFound(err) {
+		t.Errorf("resolv.conf written for a connection not established: %v", err)
+	}
+
+	conn.Status.Established = true
+	if err := c.Status().Update(ctx, conn); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Reconcile(ctx, req); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	var cm corev1.ConfigMap
+	if err := c.Get(ctx, key, &cm); err != nil {
+		t.Fatalf("resolv.conf not written: %v", err)
+	}
+	resolv := cm.Data[DNSConfigMapKey]
+	if !strings.HasPrefix(resolv, "# Generated by NSM") || !strings.Contains(resolv, "nameserver 172.16.0.53\nnameserver 10.96.0.10\n") || !strings.Contains(resolv, "corp.example.com") {
+		t.Errorf("unexpected resolv.conf %q", resolv)
+	}
+	if !metav1.IsControlledBy(&cm, pod) || cm.Labels[LabelDNSPod] != "pod" {
+		t.Errorf("config map not owned by the pod: %+v", cm.ObjectMeta)
+	}
+
+	// the resolv.conf is removed once no connection configures DNS
+	if err := c.Delete(ctx, conn); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Reconcile(ctx, req); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if err := c.Get(ctx, key, &corev1.ConfigMap{}); !apierrors.IsNotFound(err) {
+		t.Errorf("resolv.conf kept without connections: %v", err)
+	}
+}
@@ -0,0 +1,162 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/endpoints"
+	"github.com/akos011221/nsm/pkg/external"
+	"github.com/akos011221/nsm/pkg/kubecompat"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// EndpointSliceReconciler publishes the endpoints of NetworkServices as
+// selectorless Services with an EndpointSlice, so kube-proxy and cluster
+// DNS consumers reach them over the NSM fast path without bespoke
+// discovery. The published objects are owned by the NetworkService and
+// garbage collected with it.
+type EndpointSliceReconciler struct {
+	// Kubernetes client (controller-runtime)
+	client client.Client
+	// Logger
+	logger *logrus.Logger
+	// API versions the cluster serves
+	capabilities *kubecompat.Capabilities
+}
+
+// NewEndpointSliceReconciler creates a new EndpointSlice reconciler
+func NewEndpointSliceReconciler(c client.Client, logger *logrus.Logger) *EndpointSliceReconciler {
+	return &EndpointSliceReconciler{
+		client:       c,
+		logger:       logger,
+		capabilities: kubecompat.Latest(),
+	}
+}
+
+// SetCapabilities sets the API versions the cluster serves, for publishing
+// v1beta1 EndpointSlices on clusters without the v1 API
+func (r *EndpointSliceReconciler) SetCapabilities(caps *kubecompat.Capabilities) {
+	r.capabilities = caps
+}
+
+// SetupWithManager registers the reconciler and its watches with the manager
+func (r *EndpointSliceReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("endpointslice").
+		For(&nsmv1.NetworkService{}).
+		Owns(&corev1.Service{}).
+		Owns(r.capabilities.NewEndpointSlice()).
+		Complete(r)
+}
+
+// Reconcile brings the published Service and EndpointSlice of a
+// NetworkService in line with its endpoint
+func (r *EndpointSliceReconciler) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	var svc nsmv1.NetworkService
+	if err := r.client.Get(ctx, req.NamespacedName, &svc); err != nil {
+		// published objects are garbage collected through owner references
+		return reconcile.Result{}, client.IgnoreNotFound(err)
+	}
+	if svc.DeletionTimestamp != nil {
+		return reconcile.Result{}, nil
+	}
+
+	pub, ok := endpoints.Build(&svc)
+	if !ok {
+		// e.g. a DNS name, which an EndpointSlice can't hold
+		return reconcile.Result{}, r.unpublish(ctx, &svc)
+	}
+
+	var existing corev1.Service
+	err := r.client.Get(ctx, client.ObjectKeyFromObject(pub.Service), &existing)
+	if client.IgnoreNotFound(err) != nil {
+		return reconcile.Result{}, fmt.Errorf("failed to get service %s: %w", client.ObjectKeyFromObject(pub.Service), err)
+	}
+	if err == nil {
+		if !metav1.IsControlledBy(&existing, &svc) {
+			r.logger.Warnf("Not publishing NetworkService %s, Service %s/%s isn't managed by NSM", req, existing.Namespace, existing.Name)
+			return reconcile.Result{}, nil
+		}
+		// the cluster IP can't be added to or removed from a Service in place
+		if (existing.Spec.ClusterIP == corev1.ClusterIPNone) != (pub.Service.Spec.ClusterIP == corev1.ClusterIPNone) {
+			if err := r.client.Delete(ctx, &existing, client.Preconditions{UID: &existing.UID}); client.IgnoreNotFound(err) != nil {
+				return reconcile.Result{}, fmt.Errorf("failed to delete service %s/%s: %w", existing.Namespace, existing.Name, err)
+			}
+		}
+	}
+
+	service := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: pub.Service.Namespace, Name: pub.Service.Name}}
+	if _, err := controllerutil.CreateOrUpdate(ctx, r.client, service, func() error {
+		if service.CreationTimestamp.IsZero() {
+			service.Spec.ClusterIP = pub.Service.Spec.ClusterIP
+		}
+		service.Spec.Type = pub.Service.Spec.Type
+		service.Spec.Ports = pub.Service.Spec.Ports
+		service.Spec.Selector = nil
+		return controllerutil.SetControllerReference(&svc, service, r.client.Scheme())
+	}); err != nil {
+		return reconcile.Result{}, fmt.Errorf("failed to publish service %s/%s: %w", service.Namespace, service.Name, err)
+	}
+
+	existingSlice := r.capabilities.NewEndpointSlice()
+	err = r.client.Get(ctx, client.ObjectKeyFromObject(pub.Slice), existingSlice)
+	if client.IgnoreNotFound(err) != nil {
+		return reconcile.Result{}, fmt.Errorf("failed to get endpoint slice %s: %w", client.ObjectKeyFromObject(pub.Slice), err)
+	}
+	// the address type is immutable, so an IPv4 slice is replaced by an IPv6 one
+	if err == nil && kubecompat.AddressType(existingSlice) != string(pub.Slice.AddressType) {
+		uid := existingSlice.GetUID()
+		if err := r.client.Delete(ctx, existingSlice, client.Preconditions{UID: &uid}); client.IgnoreNotFound(err) != nil {
+			return reconcile.Result{}, fmt.Errorf("failed to delete endpoint slice %s/%s: %w", existingSlice.GetNamespace(), existingSlice.GetName(), err)
+		}
+	}
+
+	// the slice is written in the version the cluster serves
+	slice := r.capabilities.NewEndpointSlice()
+	slice.SetNamespace(pub.Slice.Namespace)
+	slice.SetName(pub.Slice.Name)
+	if _, err := controllerutil.CreateOrUpdate(ctx, r.client, slice, func() error {
+		labels := slice.GetLabels()
+		if labels == nil {
+			labels = make(map[string]string)
+		}
+		for k, v := range pub.Slice.Labels {
+			labels[k] = v
+		}
+		slice.SetLabels(labels)
+		kubecompat.SetEndpointSlice(slice, pub.Slice)
+		return controllerutil.SetControllerReference(&svc, slice, r.client.Scheme())
+	}); err != nil {
+		return reconcile.Result{}, fmt.Errorf("failed to publish endpoint slice %s/%s: %w", slice.GetNamespace(), slice.GetName(), err)
+	}
+	return reconcile.Result{}, nil
+}
+
+// unpublish deletes the published Service and EndpointSlice of a
+// NetworkService, if NSM manages them
+func (r *EndpointSliceReconciler) unpublish(ctx context.Context, svc *nsmv1.NetworkService) error {
+	key := client.ObjectKey{Namespace: svc.Namespace, Name: endpoints.ServiceName(svc)}
+	for _, obj := range []client.Object{r.capabilities.NewEndpointSlice(), &corev1.Service{}} {
+		if err := r.client.Get(ctx, key, obj); err != nil {
+			if client.IgnoreNotFound(err) != nil {
+				return fmt.Errorf("failed to get %s: %w", key, err)
+			}
+			continue
+		}
+		if !metav1.IsControlledBy(obj, svc) {
+			continue
+		}
+		if err := r.client.Delete(ctx, obj); client.IgnoreNotFound(err) != nil {
+			return fmt.Errorf("failed to delete %s: %w", key, err)
+		}
+		r.logger.Infof("Unpublished %s, endpoint %q of NetworkService %s/%s isn't an IP", key, external.Endpoint(svc), svc.Namespace, svc.Name)
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/endpoints"
+	"github.com/akos011221/nsm/pkg/kubecompat"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	discoveryv1beta1 "k8s.io/api/discovery/v1beta1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestEndpointSliceReconcilerPublishesEndpoint(t *testing.T) {
+	svc := &nsmv1.NetworkService{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "edge", Name: "vision", UID: "svc-uid"},
+		Spec:       nsmv1.NetworkServiceSpec{Endpoint: "10.60.0.7:8443"},
+	}
+	c := newTestClient(t, svc)
+	r := NewEndpointSliceReconciler(c, logrus.New())
+	ctx := context.Background()
+	req := reconcile.Request{NamespacedName: client.ObjectKeyFromObject(svc)}
+	key := client.ObjectKey{Namespace: "edge", Name: endpoints.ServiceName(svc)}
+
+	if _, err := r.Reconcile(ctx, req); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	var service corev1.Service
+	if err := c.Get(ctx, key, &service); err != nil {
+		t.Fatalf("service not published: %v", err)
+	}
+	if !metav1.IsControlledBy(&service, svc) || len(service.Spec.Ports) != 1 {
+		t.Errorf("unexpected service %+v", service)
+	}
+	var slice discoveryv1.EndpointSlice
+	if err := c.Get(ctx, key, &slice); err != nil {
+		t.Fatalf("endpoint slice not published: %v", err)
+	}
+	if !metav1.IsControlledBy(&slice, svc) || slice.Endpoints[0].Addresses[0] != "10.60.0.7" {
+		t.Errorf("unexpected slice %+v", slice)
+	}
+
+	// a moved endpoint is republished
+	svc.Spec.Endpoint = "10.60.0.9:8443"
+	if err := c.Update(ctx, svc); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Reconcile(ctx, req); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if err := c.Get(ctx, key, &slice); err != nil || slice.Endpoints[0].Addresses[0] != "10.60.0.9" {
+		t.Errorf("endpoint not updated: %+v, %v", slice.Endpoints, err)
+	}
+
+	// a DNS name can't be published, the objects are removed
+	svc.Spec.Endpoint = "vision.example.com:8443"
+	if err := c.Update(ctx, svc); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Reconcile(ctx, req); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if err := c.Get(ctx, key, &corev1.Service{}); !apierrors.IsNotFound(err) {
+		t.Errorf("service of a DNS endpoint still published: %v", err)
+	}
+	if err := c.Get(ctx, key, &discoveryv1.EndpointSlice{}); !apierrors.IsNotFound(err) {
+		t.Errorf("slice of a DNS endpoint still published: %v", err)
+	}
+}
+
+func TestEndpointSliceReconcilerKeepsForeignService(t *testing.T) {
+	svc := &nsmv1.NetworkService{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "edge", Name: "vision", UID: "svc-uid"},
+		Spec:       nsmv1.NetworkServiceSpec{Endpoint: "10.60.0.7:8443"},
+	}
+	foreign := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "edge", Name: endpoints.ServiceName(svc)}}
+	c := newTestClient(t, svc, foreign)
+	r := NewEndpointSliceReconciler(c, logrus.New())
+	ctx := context.Background()
+
+	if _, err := r.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(svc)}); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if err := c.Get(ctx, client.ObjectKeyFromObject(foreign), &discoveryv1.EndpointSlice{}); !apierrors.IsNotFound(err) {
+		t.Errorf("slice published for a service NSM doesn't manage: %v", err)
+	}
+	var service corev1.Service
+	if err := c.Get(ctx, client.ObjectKeyFromObject(foreign), &service); err != nil || len(service.OwnerReferences) != 0 {
+		t.Errorf("foreign service taken over: %+v, %v", service.OwnerReferences, err)
+	}
+}
+
+func TestEndpointSliceReconcilerPublishesV1Beta1(t *testing.T) {
+	svc := &nsmv1.NetworkService{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "edge", Name: "vision", UID: "svc-uid"},
+		Spec:       nsmv1.NetworkServiceSpec{Endpoint: "10.60.0.7:8443"},
+	}
+	c := newTestClient(t, svc)
+	r := NewEndpointSliceReconciler(c, logrus.New())
+	// Kubernetes 1.19 and 1.20 only serve v1beta1 EndpointSlices
+	r.SetCapabilities(&kubecompat.Capabilities{EndpointSlice: kubecompat.V1Beta1, Eviction: kubecompat.V1Beta1})
+	ctx := context.Background()
+	key := client.ObjectKey{Namespace: "edge", Name: endpoints.ServiceName(svc)}
+
+	if _, err := r.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(svc)}); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	var slice discoveryv1beta1.EndpointSlice
+	if err := c.Get(ctx, key, &slice); err != nil {
+		t.Fatalf("v1beta1 endpoint slice not published: %v", err)
+	}
+	if !metav1.IsControlledBy(&slice, svc) || slice.AddressType != discoveryv1beta1.AddressTypeIPv4 ||
+		slice.Endpoints[0].Addresses[0] != "10.60.0.7" || *slice.Ports[0].Port != 8443 {
+		t.Errorf("unexpected slice %+v", slice)
+	}
+}
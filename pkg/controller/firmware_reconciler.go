@@ -0,0 +1,214 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/disruption"
+	"github.com/akos011221/nsm/pkg/firmware"
+	"github.com/akos011221/nsm/pkg/hardware"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// Delay before an update blocked by the disruption budgets is retried
+const firmwareRetryInterval = time.Minute
+
+// PFMaintainer runs disruptive maintenance of the PFs of the node
+type PFMaintainer interface {
+	MaintainPF(pfName, kind string, work func() error) (*hardware.ResetReport, error)
+	PhysicalFunctions() []hardware.PhysicalFunction
+}
+
+// FirmwareFlasher flashes the firmware of PFs and reads their version
+type FirmwareFlasher interface {
+	Flash(ctx context.Context, pf, pci, image string) error
+	Version(ctx context.Context, pf string) (string, error)
+}
+
+// FirmwareReconciler runs the NetworkFirmwareUpdates of the node's PFs
+// within their maintenance windows: the VFs of the PF are drained, the
+// firmware flashed and activated with a PF reset, the version validated
+// and the VFs restored. The reconciles run one at a time, so a node never
+// updates two PFs at once.
+type FirmwareReconciler struct {
+	// Kubernetes client (controller-runtime)
+	client client.Client
+	// Logger
+	logger *logrus.Logger
+	// Node the updates are run for
+	node string
+	// Drains and restores the PFs
+	pfs PFMaintainer
+	// Flashes the firmware
+	flasher FirmwareFlasher
+	// Current time, replaceable for tests
+	now func() time.Time
+}
+
+// NewFirmwareReconciler creates a new firmware update reconciler for node
+func NewFirmwareReconciler(c client.Client, logger *logrus.Logger, node string, pfs PFMaintainer, flasher FirmwareFlasher) *FirmwareReconciler {
+	return &FirmwareReconciler{
+		client:  c,
+		logger:  logger,
+		node:    node,
+		pfs:     pfs,
+		flasher: flasher,
+		now:     time.Now,
+	}
+}
+
+// SetupWithManager registers the reconciler with the manager
+func (r *FirmwareReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("networkfirmwareupdate").
+		// the PFs of the node are updated on every replica
+		WithOptions(nodeScoped()).
+		For(&nsmv1.NetworkFirmwareUpdate{}).
+		Complete(r)
+}
+
+// Reconcile runs a firmware update once its maintenance window is open
+func (r *FirmwareReconciler) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	var fw nsmv1.NetworkFirmwareUpdate
+	if err := r.client.Get(ctx, req.NamespacedName, &fw); err != nil {
+		return reconcile.Result{}, client.IgnoreNotFound(err)
+	}
+	// the updates of other nodes are run by their controller
+	if fw.Spec.NodeName != r.node {
+		return reconcile.Result{}, nil
+	}
+	if fw.Status.ObservedGeneration == fw.Generation {
+		switch fw.Status.Phase {
+		case nsmv1.FirmwareUpdateSucceeded, nsmv1.FirmwareUpdateFailed:
+			return reconcile.Result{}, nil
+		case nsmv1.FirmwareUpdateInProgress:
+			// the controller restarted in the middle of the update, the
+			// state of the PF is unknown
+			return reconcile.Result{}, r.finish(ctx, &fw, nsmv1.FirmwareUpdateFailed, "Interrupted",
+				"the update was interrupted, check the PF before retrying", nil)
+		}
+	}
+
+	if err := firmware.ValidateImage(fw.Spec.Image); err != nil {
+		return reconcile.Result{}, r.finish(ctx, &fw, nsmv1.FirmwareUpdateFailed, "InvalidSpec", err.Error(), nil)
+	}
+	open, next, err := firmware.InWindow(fw.Spec.MaintenanceWindow, r.now())
+	if err != nil {
+		return reconcile.Result{}, r.finish(ctx, &fw, nsmv1.FirmwareUpdateFailed, "InvalidSpec", err.Error(), nil)
+	}
+	if !open {
+		msg := fmt.Sprintf("maintenance window opens at %s", next.Format(time.RFC3339))
+		if err := r.setPhase(ctx, &fw, nsmv1.FirmwareUpdateWaitingForWindow, "WaitingForWindow", msg); err != nil {
+			return reconcile.Result{}, err
+		}
+		return reconcile.Result{RequeueAfter: next.Sub(r.now())}, nil
+	}
+
+	var pf *hardware.PhysicalFunction
+	for _, p := range r.pfs.PhysicalFunctions() {
+		if p.Name == fw.Spec.PF {
+			pf = &p
+			break
+		}
+	}
+	if pf == nil {
+		return reconcile.Result{}, r.finish(ctx, &fw, nsmv1.FirmwareUpdateFailed, "PFNotFound",
+			fmt.Sprintf("no SR-IOV PF %s on node %s", fw.Spec.PF, r.node), nil)
+	}
+
+	previous, err := r.flasher.Version(ctx, pf.Name)
+	if err != nil {
+		return reconcile.Result{}, r.finish(ctx, &fw, nsmv1.FirmwareUpdateFailed, "VersionUnknown", err.Error(), nil)
+	}
+	fw.Status.PreviousVersion = previous
+	// an update applied already, e.g., by a deferred disruption, isn't
+	// flashed again
+	if fw.Spec.ExpectedVersion != "" && previous == fw.Spec.ExpectedVersion {
+		fw.Status.Version = previous
+		return reconcile.Result{}, r.finish(ctx, &fw, nsmv1.FirmwareUpdateSucceeded, "UpToDate",
+			fmt.Sprintf("%s already runs firmware %s", pf.Name, previous), nil)
+	}
+
+	now := metav1.NewTime(r.now())
+	fw.Status.StartTime = &now
+	if err := r.setPhase(ctx, &fw, nsmv1.FirmwareUpdateInProgress, "Updating",
+		fmt.Sprintf("flashing %s on %s", fw.Spec.Image, pf.Name)); err != nil {
+		return reconcile.Result{}, err
+	}
+	r.logger.Infof("Updating the firmware of %s from %s with %s", pf.Name, previous, fw.Spec.Image)
+
+	report, err := r.pfs.MaintainPF(pf.Name, disruption.KindFirmwareUpdate, func() error {
+		return r.flasher.Flash(ctx, pf.Name, pf.PCIAddress, fw.Spec.Image)
+	})
+	var blocked *disruption.BlockedError
+	if errors.As(err, &blocked) {
+		// nothing was flashed, the update waits for the budgets
+		fw.Status.StartTime = nil
+		if err := r.setPhase(ctx, &fw, nsmv1.FirmwareUpdatePending, "Blocked", err.Error()); err != nil {
+			return reconcile.Result{}, err
+		}
+		return reconcile.Result{RequeueAfter: firmwareRetryInterval}, nil
+	}
+	if err != nil {
+		r.logger.WithError(err).Errorf("Firmware update of %s failed", pf.Name)
+		return reconcile.Result{}, r.finish(ctx, &fw, nsmv1.FirmwareUpdateFailed, "UpdateFailed", err.Error(), report)
+	}
+
+	version, err := r.flasher.Version(ctx, pf.Name)
+	if err != nil {
+		return reconcile.Result{}, r.finish(ctx, &fw, nsmv1.FirmwareUpdateFailed, "VersionUnknown", err.Error(), report)
+	}
+	fw.Status.Version = version
+	if fw.Spec.ExpectedVersion != "" && version != fw.Spec.ExpectedVersion {
+		return reconcile.Result{}, r.finish(ctx, &fw, nsmv1.FirmwareUpdateFailed, "VersionMismatch",
+			fmt.Sprintf("%s runs firmware %s after the update, want %s", pf.Name, version, fw.Spec.ExpectedVersion), report)
+	}
+	if report != nil && len(report.Failed) > 0 {
+		return reconcile.Result{}, r.finish(ctx, &fw, nsmv1.FirmwareUpdateFailed, "RestoreFailed",
+			fmt.Sprintf("firmware %s active, %d VFs couldn't be restored to their pods", version, len(report.Failed)), report)
+	}
+	r.logger.Infof("Updated the firmware of %s to %s", pf.Name, version)
+	return reconcile.Result{}, r.finish(ctx, &fw, nsmv1.FirmwareUpdateSucceeded, "Updated",
+		fmt.Sprintf("%s runs firmware %s", pf.Name, version), report)
+}
+
+// finish records the outcome of an update
+func (r *FirmwareReconciler) finish(ctx context.Context, fw *nsmv1.NetworkFirmwareUpdate, phase, reason, msg string, report *hardware.ResetReport) error {
+	now := metav1.NewTime(r.now())
+	fw.Status.CompletionTime = &now
+	if report != nil {
+		fw.Status.RestoredVFs = report.Reparented
+	}
+	return r.setPhase(ctx, fw, phase, reason, msg)
+}
+
+// setPhase writes the phase and Ready condition of an update
+func (r *FirmwareReconciler) setPhase(ctx context.Context, fw *nsmv1.NetworkFirmwareUpdate, phase, reason, msg string) error {
+	fw.Status.ObservedGeneration = fw.Generation
+	fw.Status.Phase = phase
+	fw.Status.Message = msg
+	status := metav1.ConditionFalse
+	if phase == nsmv1.FirmwareUpdateSucceeded {
+		status = metav1.ConditionTrue
+	}
+	meta.SetStatusCondition(&fw.Status.Conditions, metav1.Condition{
+		Type:               nsmv1.ConditionReady,
+		Status:             status,
+		Reason:             reason,
+		Message:            msg,
+		ObservedGeneration: fw.Generation,
+	})
+
+	if err := r.client.Status().Update(ctx, fw); err != nil {
+		return fmt.Errorf("failed to update firmware update status: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/disruption"
+	"github.com/akos011221/nsm/pkg/hardware"
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// fakeMaintainer runs the maintenance work of a PF with two VFs in pods
+type fakeMaintainer struct {
+	kinds []string
+	err   error
+}
+
+func (m *fakeMaintainer) MaintainPF(pfName, kind string, work func() error) (*hardware.ResetReport, error) {
+	m.kinds = append(m.kinds, kind)
+	if m.err != nil {
+		return nil, m.err
+	}
+	report := &hardware.ResetReport{PF: pfName, NumVFs: 2, Reparented: []string{pfName + "-vf0", pfName + "-vf1"}}
+	return report, work()
+}
+
+func (m *fakeMaintainer) PhysicalFunctions() []hardware.PhysicalFunction {
+	return []hardware.PhysicalFunction{{Name: "eth0", PCIAddress: "0000:3b:00.0", NumVFs: 2}}
+}
+
+// fakeFlasher moves the PF to the version of the image flashed
+type fakeFlasher struct {
+	version string
+	flashed []string
+}
+
+func (f *fakeFlasher) Flash(_ context.Context, pf, pci, image string) error {
+	f.flashed = append(f.flashed, pci+" "+image)
+	f.version = "22.40"
+	return nil
+}
+
+func (f *fakeFlasher) Version(context.Context, string) (string, error) {
+	return f.version, nil
+}
+
+func newFirmwareUpdate(name string, window *nsmv1.MaintenanceWindow) *nsmv1.NetworkFirmwareUpdate {
+	return &nsmv1.NetworkFirmwareUpdate{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "edge", Generation: 1},
+		Spec: nsmv1.NetworkFirmwareUpdateSpec{
+			NodeName:          "edge-1",
+			PF:                "eth0",
+			Image:             "mlx/fw-22.40.bin",
+			ExpectedVersion:   "22.40",
+			MaintenanceWindow: window,
+		},
+	}
+}
+
+func reconcileFirmwareUpdate(t *testing.T, r *FirmwareReconciler, c client.Client, name string) (reconcile.Result, *nsmv1.NetworkFirmwareUpdate) {
+	t.Helper()
+	key := client.ObjectKey{Namespace: "edge", Name: name}
+	res, err := r.Reconcile(context.Background(), reconcile.Request{NamespacedName: key})
+	if err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	var fw nsmv1.NetworkFirmwareUpdate
+	if err := c.Get(context.Background(), key, &fw); err != nil {
+		t.Fatal(err)
+	}
+	return res, &fw
+}
+
+func TestFirmwareReconcilerUpdatesWithinWindow(t *testing.T) {
+	// Wednesday 01:30
+	now := time.Date(2026, 10, 14, 1, 30, 0, 0, time.UTC)
+	later := newFirmwareUpdate("later", &nsmv1.MaintenanceWindow{Start: "02:00", DurationMinutes: 60})
+	c := newTestClient(t, newFirmwareUpdate("now", nil), later)
+	pfs := &fakeMaintainer{}
+	flasher := &fakeFlasher{version: "22.39"}
+	r := NewFirmwareReconciler(c, logrus.New(), "edge-1", pfs, flasher)
+	r.now = func() time.Time { return now }
+
+	res, fw := reconcileFirmwareUpdate(t, r, c, "later")
+	if fw.Status.Phase != nsmv1.FirmwareUpdateWaitingForWindow || res.RequeueAfter != 30*time.Minute || len(flasher.flashed) != 0 {
+		t.Errorf("phase %s, requeued after %s, flashed %v before the window", fw.Status.Phase, res.RequeueAfter, flasher.flashed)
+	}
+
+	_, fw = reconcileFirmwareUpdate(t, r, c, "now")
+	if fw.Status.Phase != nsmv1.FirmwareUpdateSucceeded || fw.Status.PreviousVersion != "22.39" || fw.Status.Version != "22.40" {
+		t.Errorf("status = %+v, want the update validated", fw.Status)
+	}
+	if len(flasher.flashed) != 1 || flasher.flashed[0] != "0000:3b:00.0 mlx/fw-22.40.bin" {
+		t.Errorf("flashed %v", flasher.flashed)
+	}
+	if len(pfs.kinds) != 1 || pfs.kinds[0] != disruption.KindFirmwareUpdate || len(fw.Status.RestoredVFs) != 2 {
+		t.Errorf("maintenance %v restored %v, want the VFs drained and restored", pfs.kinds, fw.Status.RestoredVFs)
+	}
+	if fw.Status.StartTime == nil || fw.Status.CompletionTime == nil {
+		t.Error("start or completion time not recorded")
+	}
+
+	// the window opened, the PF runs the expected version already
+	now = now.Add(time.Hour)
+	_, fw = reconcileFirmwareUpdate(t, r, c, "later")
+	if fw.Status.Phase != nsmv1.FirmwareUpdateSucceeded || len(flasher.flashed) != 1 {
+		t.Errorf("phase %s after flashing %v, want the update skipped", fw.Status.Phase, flasher.flashed)
+	}
+}
+
+func TestFirmwareReconcilerFailures(t *testing.T) {
+	mismatch := newFirmwareUpdate("mismatch", nil)
+	mismatch.Spec.ExpectedVersion = "23.01"
+	other := newFirmwareUpdate("other-node", nil)
+	other.Spec.NodeName = "edge-2"
+	escape := newFirmwareUpdate("escape", nil)
+	escape.Spec.Image = "../../etc/shadow"
+	blocked := newFirmwareUpdate("blocked", nil)
+	blocked.Spec.ExpectedVersion = ""
+	c := newTestClient(t, mismatch, other, escape, blocked)
+	pfs := &fakeMaintainer{}
+	flasher := &fakeFlasher{version: "22.39"}
+	r := NewFirmwareReconciler(c, logrus.New(), "edge-1", pfs, flasher)
+
+	if _, fw := reconcileFirmwareUpdate(t, r, c, "other-node"); fw.Status.Phase != "" {
+		t.Errorf("phase %s for an update of another node", fw.Status.Phase)
+	}
+	if _, fw := reconcileFirmwareUpdate(t, r, c, "escape"); fw.Status.Phase != nsmv1.FirmwareUpdateFailed || len(pfs.kinds) != 0 {
+		t.Errorf("phase %s after %v for an image outside the firmware path", fw.Status.Phase, pfs.kinds)
+	}
+	_, fw := reconcileFirmwareUpdate(t, r, c, "mismatch")
+	if fw.Status.Phase != nsmv1.FirmwareUpdateFailed || fw.Status.Version != "22.40" {
+		t.Errorf("status = %+v, want the version mismatch reported", fw.Status)
+	}
+	// failed updates aren't retried
+	reconcileFirmwareUpdate(t, r, c, "mismatch")
+	if len(flasher.flashed) != 1 {
+		t.Errorf("flashed %d times", len(flasher.flashed))
+	}
+
+	pfs.err = &disruption.BlockedError{Change: disruption.Change{Kind: disruption.KindFirmwareUpdate, Device: "eth0"}}
+	res, fw := reconcileFirmwareUpdate(t, r, c, "blocked")
+	if fw.Status.Phase != nsmv1.FirmwareUpdatePending || res.RequeueAfter != firmwareRetryInterval || fw.Status.StartTime != nil {
+		t.Errorf("phase %s, requeued after %s when blocked by the budgets", fw.Status.Phase, res.RequeueAfter)
+	}
+	pfs.err = errors.New("flash failed")
+	if _, fw = reconcileFirmwareUpdate(t, r, c, "blocked"); fw.Status.Phase != nsmv1.FirmwareUpdateFailed {
+		t.Errorf("phase %s after a failed update", fw.Status.Phase)
+	}
+}
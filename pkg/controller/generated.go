@@ -0,0 +1,65 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// applyGeneratedConnections creates or updates the connections generated
+// from an owner (intent, blueprint) and removes the stale ones, found by
+// the label carrying the owner name. With a shard only the connections
+// this replica owns are applied and removed.
+func applyGeneratedConnections(ctx context.Context, c client.Client, logger *logrus.Logger, shard Shard, owner client.Object, kind, label string, desired []nsmv1.NetworkConnection) error {
+	keep := make(map[string]bool)
+	for i := range desired {
+		want := desired[i]
+		keep[want.Name] = true
+		if !ownsConnection(shard, owner, &want) {
+			continue
+		}
+
+		conn := &nsmv1.NetworkConnection{ObjectMeta: metav1.ObjectMeta{Name: want.Name, Namespace: want.Namespace}}
+		op, err := controllerutil.CreateOrUpdate(ctx, c, conn, func() error {
+			if conn.Labels == nil {
+				conn.Labels = make(map[string]string)
+			}
+			for k, v := range want.Labels {
+				conn.Labels[k] = v
+			}
+			if _, ok := want.Labels[nsmv1.LabelConnectionNode]; !ok {
+				delete(conn.Labels, nsmv1.LabelConnectionNode)
+			}
+			conn.Spec = want.Spec
+			return controllerutil.SetControllerReference(owner, conn, c.Scheme())
+		})
+		if err != nil {
+			return fmt.Errorf("failed to apply connection %s: %w", want.Name, err)
+		}
+		if op != controllerutil.OperationResultNone {
+			logger.Infof("Connection %s/%s %s from %s %s", conn.Namespace, conn.Name, op, kind, owner.GetName())
+		}
+	}
+
+	var existing nsmv1.NetworkConnectionList
+	if err := c.List(ctx, &existing, client.InNamespace(owner.GetNamespace()), client.MatchingLabels{label: owner.GetName()}); err != nil {
+		return fmt.Errorf("failed to list connections of %s: %w", kind, err)
+	}
+	for i := range existing.Items {
+		conn := &existing.Items[i]
+		if keep[conn.Name] || !metav1.IsControlledBy(conn, owner) || !ownsConnection(shard, owner, conn) {
+			continue
+		}
+		if err := c.Delete(ctx, conn); client.IgnoreNotFound(err) != nil {
+			return fmt.Errorf("failed to delete stale connection %s: %w", conn.Name, err)
+		}
+		logger.Infof("Deleted stale connection %s/%s of %s %s", conn.Namespace, conn.Name, kind, owner.GetName())
+	}
+
+	return nil
+}
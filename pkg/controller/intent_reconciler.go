@@ -0,0 +1,216 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/intent"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// how often an intent is re-evaluated when its service is missing
+const intentRetryInterval = 30 * time.Second
+
+// IntentReconciler compiles NetworkIntents into NetworkConnections and policies
+type IntentReconciler struct {
+	// Kubernetes client (controller-runtime)
+	client client.Client
+	// Logger
+	logger *logrus.Logger
+	// Replica shard, nil if this replica reconciles every intent
+	shard Shard
+	// Triggers the reconcile of the intents after a rebalance
+	shardEvents chan event.GenericEvent
+}
+
+// NewIntentReconciler creates a new intent reconciler
+func NewIntentReconciler(c client.Client, logger *logrus.Logger) *IntentReconciler {
+	return &IntentReconciler{
+		client: c,
+		logger: logger,
+	}
+}
+
+// SetShard makes the reconciler apply only the connections of the pods on
+// the nodes of this replica's shard. The policies and status of an intent
+// are written by the replica owning its key.
+func (r *IntentReconciler) SetShard(shard Shard) {
+	r.shard = shard
+	r.shardEvents = shardEvents(shard, &nsmv1.NetworkIntent{})
+}
+
+// rebalanceRequests returns the requests of every intent after a rebalance
+func (r *IntentReconciler) rebalanceRequests(ctx context.Context, _ client.Object) []reconcile.Request {
+	return rebalanceRequests(ctx, r.client, r.logger, &nsmv1.NetworkIntentList{})
+}
+
+// SetupWithManager registers the reconciler and its watches with the manager
+func (r *IntentReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	b := ctrl.NewControllerManagedBy(mgr).
+		Named("networkintent").
+		For(&nsmv1.NetworkIntent{}).
+		Owns(&nsmv1.NetworkConnection{}).
+		Owns(&networkingv1.NetworkPolicy{}).
+		Watches(&corev1.Pod{}, handler.EnqueueRequestsFromMapFunc(r.intentsForPod)).
+		Watches(&nsmv1.NetworkService{}, handler.EnqueueRequestsFromMapFunc(r.intentsForService))
+	if r.shardEvents != nil {
+		b = b.WithOptions(sharded()).
+			WatchesRawSource(source.Channel(r.shardEvents, handler.EnqueueRequestsFromMapFunc(r.rebalanceRequests)))
+	}
+	return b.Complete(r)
+}
+
+// Reconcile brings the generated objects of an intent in line with its spec
+func (r *IntentReconciler) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	var in nsmv1.NetworkIntent
+	if err := r.client.Get(ctx, req.NamespacedName, &in); err != nil {
+		// generated objects are garbage collected through owner references
+		return reconcile.Result{}, client.IgnoreNotFound(err)
+	}
+
+	var svc nsmv1.NetworkService
+	err := r.client.Get(ctx, types.NamespacedName{Namespace: in.Namespace, Name: in.Spec.Service}, &svc)
+	if apierrors.IsNotFound(err) {
+		msg := fmt.Sprintf("NetworkService %s not found", in.Spec.Service)
+		return reconcile.Result{RequeueAfter: intentRetryInterval}, r.setStatus(ctx, &in, metav1.ConditionFalse, "ServiceNotFound", msg, 0)
+	}
+	if err != nil {
+		return reconcile.Result{}, fmt.Errorf("failed to get network service: %w", err)
+	}
+
+	selector, err := metav1.LabelSelectorAsSelector(&in.Spec.PodSelector)
+	if err != nil {
+		return reconcile.Result{}, r.setStatus(ctx, &in, metav1.ConditionFalse, "InvalidSelector", err.Error(), 0)
+	}
+
+	var pods corev1.PodList
+	if err := r.client.List(ctx, &pods, client.InNamespace(in.Namespace), client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return reconcile.Result{}, fmt.Errorf("failed to list pods: %w", err)
+	}
+
+	result, err := intent.Compile(&in, &svc, pods.Items)
+	if err != nil {
+		return reconcile.Result{}, r.setStatus(ctx, &in, metav1.ConditionFalse, "CompileFailed", err.Error(), 0)
+	}
+
+	if err := r.applyConnections(ctx, &in, result.Connections); err != nil {
+		return reconcile.Result{}, err
+	}
+	if ownsObject(r.shard, &in) {
+		if err := r.applyPolicies(ctx, &in, result.Policies); err != nil {
+			return reconcile.Result{}, err
+		}
+	}
+
+	msg := fmt.Sprintf("%d connections generated", len(result.Connections))
+	return reconcile.Result{}, r.setStatus(ctx, &in, metav1.ConditionTrue, "Compiled", msg, len(result.Connections))
+}
+
+// applyConnections creates or updates the desired connections and removes stale ones
+func (r *IntentReconciler) applyConnections(ctx context.Context, in *nsmv1.NetworkIntent, desired []nsmv1.NetworkConnection) error {
+	return applyGeneratedConnections(ctx, r.client, r.logger, r.shard, in, "intent", intent.LabelIntent, desired)
+}
+
+// applyPolicies creates or updates the desired policies and removes stale ones
+func (r *IntentReconciler) applyPolicies(ctx context.Context, in *nsmv1.NetworkIntent, desired []networkingv1.NetworkPolicy) error {
+	keep := make(map[string]bool)
+	for i := range desired {
+		want := desired[i]
+		keep[want.Name] = true
+
+		policy := &networkingv1.NetworkPolicy{ObjectMeta: metav1.ObjectMeta{Name: want.Name, Namespace: want.Namespace}}
+		if _, err := controllerutil.CreateOrUpdate(ctx, r.client, policy, func() error {
+			policy.Labels = want.Labels
+			policy.Spec = want.Spec
+			return controllerutil.SetControllerReference(in, policy, r.client.Scheme())
+		}); err != nil {
+			return fmt.Errorf("failed to apply network policy %s: %w", want.Name, err)
+		}
+	}
+
+	var existing networkingv1.NetworkPolicyList
+	if err := r.client.List(ctx, &existing, client.InNamespace(in.Namespace), client.MatchingLabels{intent.LabelIntent: in.Name}); err != nil {
+		return fmt.Errorf("failed to list network policies of intent: %w", err)
+	}
+	for i := range existing.Items {
+		policy := &existing.Items[i]
+		if keep[policy.Name] || !metav1.IsControlledBy(policy, in) {
+			continue
+		}
+		if err := r.client.Delete(ctx, policy); client.IgnoreNotFound(err) != nil {
+			return fmt.Errorf("failed to delete stale network policy %s: %w", policy.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// setStatus writes the Ready condition and connection count of an intent
+func (r *IntentReconciler) setStatus(ctx context.Context, in *nsmv1.NetworkIntent, status metav1.ConditionStatus, reason, msg string, count int) error {
+	if !ownsObject(r.shard, in) {
+		return nil
+	}
+	in.Status.ObservedGeneration = in.Generation
+	in.Status.ConnectionCount = count
+	in.Status.Message = msg
+	meta.SetStatusCondition(&in.Status.Conditions, metav1.Condition{
+		Type:               nsmv1.ConditionReady,
+		Status:             status,
+		Reason:             reason,
+		Message:            msg,
+		ObservedGeneration: in.Generation,
+	})
+
+	if err := r.client.Status().Update(ctx, in); err != nil {
+		return fmt.Errorf("failed to update intent status: %w", err)
+	}
+	return nil
+}
+
+// intentsForPod maps a pod event to the intents in the pod's namespace
+func (r *IntentReconciler) intentsForPod(ctx context.Context, obj client.Object) []reconcile.Request {
+	return r.intentsInNamespace(ctx, obj.GetNamespace(), func(in *nsmv1.NetworkIntent) bool {
+		selector, err := metav1.LabelSelectorAsSelector(&in.Spec.PodSelector)
+		return err == nil && selector.Matches(labels.Set(obj.GetLabels()))
+	})
+}
+
+// intentsForService maps a service event to the intents referencing it
+func (r *IntentReconciler) intentsForService(ctx context.Context, obj client.Object) []reconcile.Request {
+	return r.intentsInNamespace(ctx, obj.GetNamespace(), func(in *nsmv1.NetworkIntent) bool {
+		return in.Spec.Service == obj.GetName()
+	})
+}
+
+// intentsInNamespace returns requests for the intents matching the filter
+func (r *IntentReconciler) intentsInNamespace(ctx context.Context, namespace string, match func(*nsmv1.NetworkIntent) bool) []reconcile.Request {
+	var intents nsmv1.NetworkIntentList
+	if err := r.client.List(ctx, &intents, client.InNamespace(namespace)); err != nil {
+		r.logger.WithError(err).Warn("Failed to list network intents")
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for i := range intents.Items {
+		if match(&intents.Items[i]) {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&intents.Items[i])})
+		}
+	}
+	return requests
+}
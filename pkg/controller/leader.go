@@ -0,0 +1,115 @@
+package controller
+
+import (
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/akos011221/nsm/pkg/api"
+	"github.com/akos011221/nsm/pkg/config"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// leaderElectionID names the Lease the controller replicas compete for
+const leaderElectionID = "nsm-controller-leader"
+
+// Leadership is the leader election state of this replica
+type Leadership struct {
+	// Whether the replicas elect a leader, every replica reconciles
+	// everything otherwise
+	Enabled bool `json:"enabled"`
+	// Whether this replica reconciles the cluster-scope objects
+	Leading bool `json:"leading"`
+	// Time this replica became the leader
+	Since *time.Time `json:"since,omitempty"`
+}
+
+// leaderElection sets the leader election options of the manager. The
+// leader renews the Lease every two thirds of its duration and candidates
+// retry every fifth, as the controller-runtime defaults do. The Lease is
+// released on shutdown, so a rollout fails over without waiting for it to
+// expire.
+func leaderElection(opts *manager.Options, cfg *config.Config) {
+	if !cfg.EnableLeaderElection {
+		return
+	}
+	lease := time.Duration(cfg.LeaderElectionLeaseDurationSec) * time.Second
+	renew := lease * 2 / 3
+	retry := lease / 5
+	opts.LeaderElection = true
+	opts.LeaderElectionID = leaderElectionID
+	opts.LeaderElectionNamespace = cfg.LeaderElectionNamespace
+	opts.LeaderElectionReleaseOnCancel = true
+	opts.LeaseDuration = &lease
+	opts.RenewDeadline = &renew
+	opts.RetryPeriod = &retry
+}
+
+// nodeScoped returns the options of the reconcilers acting on this node,
+// its datapath, probes or PFs, which run on every replica whether it leads
+// or not. They only act on the objects of their node: the connections it
+// claimed, the firmware updates of its PFs.
+func nodeScoped() controller.Options {
+	needLeaderElection := false
+	return controller.Options{NeedLeaderElection: &needLeaderElection}
+}
+
+// leaderTracker follows whether this replica leads
+type leaderTracker struct {
+	// Mutex for protecting the state
+	mu sync.RWMutex
+	// Leadership of this replica
+	state Leadership
+}
+
+// elected records that this replica became the leader
+func (t *leaderTracker) elected(now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.state.Leading = true
+	t.state.Since = &now
+}
+
+// Leadership returns the leader election state of this replica
+func (t *leaderTracker) Leadership() Leadership {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.state
+}
+
+// watchLeadership records when this replica is elected. A replica losing
+// the Lease exits, as controller-runtime stops it, so it never steps down.
+func (c *Controller) watchLeadership() {
+	c.leader.state.Enabled = c.config.EnableLeaderElection
+	c.runComponent("leader election", func() error {
+		select {
+		case <-c.mgr.Elected():
+			c.leader.elected(time.Now())
+			if c.config.EnableLeaderElection {
+				c.logger.Info("Elected leader, reconciling the cluster-scope objects")
+			}
+		case <-c.ctx.Done():
+		}
+		return nil
+	})
+}
+
+// runManager runs the manager of the reconcilers. The manager stops when
+// its leader loses the Lease, as it can't be sure no other replica leads,
+// and can't be started again, so the process exits to rejoin the election
+// from scratch once restarted.
+func (c *Controller) runManager() error {
+	err := c.mgr.Start(c.ctx)
+	if err != nil && c.config.EnableLeaderElection && c.ctx.Err() == nil {
+		c.logger.WithError(err).Error("Controller manager stopped, exiting to rejoin the leader election")
+		os.Exit(1)
+	}
+	return err
+}
+
+// handleLeader serves the leader election state of this replica
+func (c *Controller) handleLeader(w http.ResponseWriter, r *http.Request) {
+	api.WriteJSON(w, http.StatusOK, c.leader.Leadership())
+}
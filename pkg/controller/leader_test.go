@@ -0,0 +1,51 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/akos011221/nsm/pkg/config"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+func TestLeaderElectionOptions(t *testing.T) {
+	cfg := config.DefaultConfig()
+	var opts manager.Options
+	leaderElection(&opts, cfg)
+	if opts.LeaderElection {
+		t.Fatal("leader election enabled by default")
+	}
+
+	cfg.EnableLeaderElection = true
+	cfg.LeaderElectionNamespace = "edge-system"
+	cfg.LeaderElectionLeaseDurationSec = 30
+	leaderElection(&opts, cfg)
+	if !opts.LeaderElection || opts.LeaderElectionID != leaderElectionID || opts.LeaderElectionNamespace != "edge-system" {
+		t.Errorf("leader election = %v, %q in %q", opts.LeaderElection, opts.LeaderElectionID, opts.LeaderElectionNamespace)
+	}
+	if !opts.LeaderElectionReleaseOnCancel {
+		t.Error("Lease not released on shutdown")
+	}
+	if *opts.LeaseDuration != 30*time.Second || *opts.RenewDeadline != 20*time.Second || *opts.RetryPeriod != 6*time.Second {
+		t.Errorf("lease %s, renew deadline %s, retry period %s, want 30s, 20s and 6s",
+			*opts.LeaseDuration, *opts.RenewDeadline, *opts.RetryPeriod)
+	}
+
+	// the node-scope reconcilers run on every replica
+	if opts := nodeScoped(); opts.NeedLeaderElection == nil || *opts.NeedLeaderElection {
+		t.Error("node-scope reconcilers wait for the leadership")
+	}
+}
+
+func TestLeaderTracker(t *testing.T) {
+	var tracker leaderTracker
+	tracker.state.Enabled = true
+	if l := tracker.Leadership(); l.Leading || l.Since != nil {
+		t.Errorf("Leadership() = %+v before the election", l)
+	}
+	now := time.Now()
+	tracker.elected(now)
+	if l := tracker.Leadership(); !l.Enabled || !l.Leading || l.Since == nil || !l.Since.Equal(now) {
+		t.Errorf("Leadership() = %+v, want leading since %s", l, now)
+	}
+}
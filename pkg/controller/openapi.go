@@ -0,0 +1,218 @@
+package controller
+
+import (
+	"github.com/akos011221/nsm/pkg/adopt"
+	"github.com/akos011221/nsm/pkg/agent"
+	"github.com/akos011221/nsm/pkg/api"
+	"github.com/akos011221/nsm/pkg/bulk"
+	"github.com/akos011221/nsm/pkg/catalog"
+	"github.com/akos011221/nsm/pkg/cloud"
+	"github.com/akos011221/nsm/pkg/connection"
+	"github.com/akos011221/nsm/pkg/cost"
+	"github.com/akos011221/nsm/pkg/devlink"
+	"github.com/akos011221/nsm/pkg/disruption"
+	"github.com/akos011221/nsm/pkg/drill"
+	"github.com/akos011221/nsm/pkg/hardware"
+	"github.com/akos011221/nsm/pkg/kubevirt"
+	"github.com/akos011221/nsm/pkg/posture"
+	"github.com/akos011221/nsm/pkg/reachability"
+	"github.com/akos011221/nsm/pkg/sriovoperator"
+	"github.com/akos011221/nsm/pkg/thermal"
+	"github.com/akos011221/nsm/pkg/traffic"
+	"github.com/akos011221/nsm/pkg/usage"
+	"github.com/akos011221/nsm/pkg/whatif"
+)
+
+// apiOperations documents the endpoints of the management API, SDKs for
+// tools written outside Go are generated from it
+var apiOperations = map[string]api.Operation{
+	"GET /v1/connections": {
+		ID:       "listConnections",
+		Summary:  "List the state of the connections at a glance, ordered by namespace and name",
+		Query:    []string{"namespace", "node"},
+		Response: []connection.Summary{},
+	},
+	"POST /v1/connections/bulk": {
+		ID:       "bulkUpdateConnections",
+		Summary:  "Apply an operation to all connections matching a label selector",
+		Request:  bulk.Request{},
+		Response: bulk.Result{},
+	},
+	"POST /v1/connections/import": {
+		ID:       "importConnections",
+		Summary:  "Adopt the tunnels, VLANs and static routes configured on the node outside NSM as connections",
+		Request:  adopt.Request{},
+		Response: adopt.Result{},
+	},
+	"POST /v1/drills": {
+		ID:       "runFailoverDrill",
+		Summary:  "Fail a device under the selected connections and measure how fast they fail over",
+		Request:  drill.Request{},
+		Response: drill.Report{},
+	},
+	"POST /v1/connections/{namespace}/{name}/traffic-test": {
+		ID:       "runTrafficTest",
+		Summary:  "Send test traffic at a rate over a connection to a reflector, saving the throughput, loss and latency in its diagnostics history",
+		Request:  traffic.Request{},
+		Response: traffic.Report{},
+	},
+	"GET /v1/drills": {
+		ID:       "listFailoverDrills",
+		Summary:  "List the reports of the latest failover drills",
+		Response: []drill.Report{},
+	},
+	"GET " + api.VersionsPath: {
+		ID:       "getVersions",
+		Summary:  "Get the API versions, operations and deprecations served by the controller",
+		Response: api.VersionInfo{},
+	},
+	"GET /v1/hardware": {
+		ID:       "getHardware",
+		Summary:  "Get the detected hardware platform of the node",
+		Response: hardware.Platform{},
+	},
+	"GET /v1/hardware/sensors": {
+		ID:       "getSensors",
+		Summary:  "Get the temperature and power sensor readings of the node",
+		Response: thermal.Status{},
+	},
+	"GET /v1/hardware/dpdk": {
+		ID:       "getDPDK",
+		Summary:  "Get the hugepages and the DPDK devices of the node and the pods they are allocated to",
+		Response: hardware.DPDKInventory{},
+	},
+	"GET /v1/cloud": {
+		ID:       "getCloudStatus",
+		Summary:  "Get the connectivity of the node to the cloud and whether it runs autonomously",
+		Response: cloud.Status{},
+	},
+	"GET /v1/hardware/devlink": {
+		ID:       "listDevlinkDevices",
+		Summary:  "List the devlink devices of the node with their eswitch mode, versions, VF counts and health reporters",
+		Response: []devlink.Device{},
+	},
+	"PUT /v1/hardware/devlink/{bus}/{device}/eswitch": {
+		ID:      "setEswitchMode",
+		Summary: "Change the eswitch mode (legacy, switchdev) of a devlink device whose VFs aren't allocated to pods",
+		Request: devlink.EswitchRequest{},
+	},
+	"GET /v1/explain/pods/{namespace}/{name}": {
+		ID:       "explainPod",
+		Summary:  "Explain why a pod did or didn't get a VF",
+		Response: hardware.Explanation{},
+	},
+	"GET /v1/leases": {
+		ID:       "listVFLeases",
+		Summary:  "List the VFs leased to pods for a limited time",
+		Response: []hardware.Lease{},
+	},
+	"POST /v1/leases": {
+		ID:       "leaseVF",
+		Summary:  "Lease a free VF to a pod until the TTL passes without a renewal, renewing the lease the pod holds",
+		Request:  hardware.LeaseRequest{},
+		Response: hardware.Lease{},
+	},
+	"POST /v1/leases/{namespace}/{name}/renew": {
+		ID:       "renewVFLease",
+		Summary:  "Extend the VF lease of a pod by its TTL",
+		Response: hardware.Lease{},
+	},
+	"DELETE /v1/leases/{namespace}/{name}": {
+		ID:      "releaseVFLease",
+		Summary: "Free the VF leased to a pod",
+	},
+	"GET /v1/endpoints": {
+		ID:       "listEndpoints",
+		Summary:  "List the service endpoints ranked by reachability score, best first",
+		Query:    []string{"namespace", "serviceType"},
+		Response: []reachability.Endpoint{},
+	},
+	"GET /v1/uplinks": {
+		ID:       "listUplinks",
+		Summary:  "List the month-to-date traffic and spend of the uplinks with costs, and whether they spent their budget",
+		Response: []cost.Spend{},
+	},
+	"GET /v1/services": {
+		ID:       "listServices",
+		Summary:  "List the valid NetworkServices with their phase and connection count",
+		Query:    []string{"serviceType"},
+		Response: []catalog.Entry{},
+	},
+	"GET /v1/nodes": {
+		ID:       "listNodes",
+		Summary:  "List the nodes whose agents registered with the controller and whether they are alive",
+		Response: []agent.NodeStatus{},
+	},
+	"GET /v1/leader": {
+		ID:       "getLeadership",
+		Summary:  "Get whether this controller replica leads the reconciliation of the cluster-scope objects",
+		Response: Leadership{},
+	},
+	"PUT /v1/hardware/sriov/{pf}/numvfs": {
+		ID:      "setNumVFs",
+		Summary: "Change the number of VFs of a PF after evicting the pods using them as their disruption budgets allow (202 if deferred, 409 if the SR-IOV network operator provisions it)",
+		Request: hardware.NumVFsRequest{},
+	},
+	"POST /v1/hardware/sriov/{pf}/reset": {
+		ID:       "resetPF",
+		Summary:  "Reset a PF, e.g. to activate new firmware, re-attaching the VFs to their pods with their configuration after it",
+		Response: hardware.ResetReport{},
+	},
+	"GET /v1/hardware/sriov/consistency": {
+		ID:       "checkInventoryConsistency",
+		Summary:  "Check the VF inventory against sysfs, the host links and the pods, listing the inconsistencies with suggested repairs",
+		Response: hardware.ConsistencyReport{},
+	},
+	"POST /v1/hardware/sriov/consistency/repair": {
+		ID:       "repairInventoryConsistency",
+		Summary:  "Repair the inconsistencies of the VF inventory with sysfs, the host links and the pods, listing them with the repairs applied",
+		Response: hardware.ConsistencyReport{},
+	},
+	"GET /v1/hardware/sriov/operator": {
+		ID:       "getSRIOVOperator",
+		Summary:  "Get the state of the SR-IOV network operator on the node and the PFs whose VF count is left to it",
+		Response: sriovoperator.NodeState{},
+	},
+	"GET /v1/hardware/sriov/vms": {
+		ID:       "listSRIOVVirtualMachines",
+		Summary:  "List the KubeVirt VMIs requesting VFs, their interfaces and the VFs allocated to them",
+		Response: []kubevirt.VirtualMachine{},
+	},
+	"GET /v1/hardware/sriov/vfs": {
+		ID:       "listVFs",
+		Summary:  "List the VFs of the node, ordered by PCI address, with the pods they are allocated to",
+		Response: []hardware.VirtualFunction{},
+	},
+	"DELETE /v1/hardware/sriov/vfs/{namespace}/{name}": {
+		ID:      "releaseVF",
+		Summary: "Free the VFs allocated to a pod",
+	},
+	"GET /v1/disruptions": {
+		ID:       "listDeferredDisruptions",
+		Summary:  "List the disruptive datapath changes deferred until the disruption budgets of the affected pods allow them",
+		Response: []disruption.Pending{},
+	},
+	"GET /v1/usage": {
+		ID:       "listUsageReports",
+		Summary:  "List the usage reports of the namespaces for chargeback, as JSON or with format=csv as CSV",
+		Query:    []string{"since", "format"},
+		Response: []usage.Report{},
+	},
+	"GET /v1/security/posture": {
+		ID:       "getSecurityPosture",
+		Summary:  "Report the connections of every site by encryption, cipher, identity verification and policy compliance, as JSON or with format=csv as CSV",
+		Query:    []string{"site", "format"},
+		Response: posture.Report{},
+	},
+	"POST /v1/whatif": {
+		ID:       "previewChanges",
+		Summary:  "Preview the datapath actions of a proposed connection or service spec without applying them",
+		Request:  whatif.Request{},
+		Response: whatif.Result{},
+	},
+}
+
+// OpenAPI returns the OpenAPI document of the management API
+func OpenAPI() *api.Document {
+	return api.NewDocument("NSM management API", "v1", apiOperations)
+}
@@ -0,0 +1,140 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// Status message of the quiesced connections
+const quiescedMessage = "quiesced while the VF of its source pod is re-parented"
+
+// Quiesce tears down the connections from the pods, keeping their
+// allocations, until they are resumed, and waits until none of them is
+// established. It implements hardware.Quiescer.
+func (r *ConnectionReconciler) Quiesce(ctx context.Context, pods []types.NamespacedName) error {
+	r.quiesceMu.Lock()
+	for _, pod := range pods {
+		r.quiesced[pod.String()] = true
+	}
+	r.quiesceMu.Unlock()
+	r.triggerQuiesce()
+
+	ticker := time.NewTicker(r.quiescePollInterval)
+	defer ticker.Stop()
+	for {
+		established, err := r.establishedQuiesced(ctx)
+		if err != nil {
+			return err
+		}
+		if established == 0 {
+			return nil
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return fmt.Errorf("%d connections still established: %w", established, ctx.Err())
+		}
+	}
+}
+
+// Resume lets the connections from the pods be set up again. It
+// implements hardware.Quiescer.
+func (r *ConnectionReconciler) Resume(pods []types.NamespacedName) {
+	r.quiesceMu.Lock()
+	for _, pod := range pods {
+		delete(r.quiesced, pod.String())
+	}
+	r.quiesceMu.Unlock()
+	r.triggerQuiesce()
+}
+
+// triggerQuiesce reconciles the connections of the quiesced and resumed pods
+func (r *ConnectionReconciler) triggerQuiesce() {
+	// a pending trigger already reconciles all of them
+	select {
+	case r.quiesceEvents <- event.GenericEvent{Object: &nsmv1.NetworkConnection{}}:
+	default:
+	}
+}
+
+// isQuiesced reports whether the connections from a source are quiesced
+func (r *ConnectionReconciler) isQuiesced(source string) bool {
+	r.quiesceMu.RLock()
+	defer r.quiesceMu.RUnlock()
+	return r.quiesced[source]
+}
+
+// establishedQuiesced counts the connections of quiesced pods that are
+// still established
+func (r *ConnectionReconciler) establishedQuiesced(ctx context.Context) (int, error) {
+	var conns nsmv1.NetworkConnectionList
+	if err := r.client.List(ctx, &conns); err != nil {
+		return 0, fmt.Errorf("failed to list connections: %w", err)
+	}
+	established := 0
+	for _, conn := range conns.Items {
+		if conn.Status.Established && r.isQuiesced(conn.Spec.Source) {
+			established++
+		}
+	}
+	return established, nil
+}
+
+// quiescible returns the requests of the connections to quiesce or resume
+func (r *ConnectionReconciler) quiescible(ctx context.Context, _ client.Object) []reconcile.Request {
+	var conns nsmv1.NetworkConnectionList
+	if err := r.client.List(ctx, &conns); err != nil {
+		r.logger.WithError(err).Error("Failed to list connections for quiescing")
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, conn := range conns.Items {
+		ready := meta.FindStatusCondition(conn.Status.Conditions, nsmv1.ConditionReady)
+		if r.isQuiesced(conn.Spec.Source) || (ready != nil && ready.Reason == "Quiesced") {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&conn)})
+		}
+	}
+	return requests
+}
+
+// quiesce tears down a connection of a quiesced pod, keeping its allocations
+func (r *ConnectionReconciler) quiesce(ctx context.Context, conn *nsmv1.NetworkConnection) (reconcile.Result, error) {
+	result := reconcile.Result{RequeueAfter: setupRetryInterval}
+	ready := meta.FindStatusCondition(conn.Status.Conditions, nsmv1.ConditionReady)
+	if !conn.Status.Established && ready != nil && ready.Reason == "Quiesced" {
+		return result, nil
+	}
+
+	if conn.Status.Established {
+		if err := r.datapath.Teardown(ctx, conn, true); err != nil {
+			return reconcile.Result{}, fmt.Errorf("failed to quiesce connection %s/%s: %w", conn.Namespace, conn.Name, err)
+		}
+		r.logger.Infof("Quiesced connection %s/%s", conn.Namespace, conn.Name)
+	}
+	// quiescing on purpose isn't a flap
+	if r.damper != nil {
+		r.damper.Forget(client.ObjectKeyFromObject(conn).String())
+	}
+	conn.Status.Established = false
+
+	conn.Status.State = nsmv1.ConnectionStatePending
+	conn.Status.Message = quiescedMessage
+	meta.SetStatusCondition(&conn.Status.Conditions, metav1.Condition{
+		Type:               nsmv1.ConditionReady,
+		Status:             metav1.ConditionFalse,
+		Reason:             "Quiesced",
+		Message:            quiescedMessage,
+		ObservedGeneration: conn.Generation,
+	})
+	return result, r.updateStatus(ctx, conn)
+}
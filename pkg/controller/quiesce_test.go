@@ -0,0 +1,79 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/connection"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestConnectionReconcilerQuiesce(t *testing.T) {
+	other := testConnection(nsmv1.ConnectionTypeKernel)
+	other.Name = "other"
+	other.Spec.Source = "edge/other"
+	c := newTestClient(t, testConnection(nsmv1.ConnectionTypeSRIOV), other)
+	dp := &recordingDatapath{}
+	r := NewConnectionReconciler(c, logrus.New(), connection.Capabilities{SRIOV: true}, dp)
+	r.quiescePollInterval = time.Millisecond
+
+	if conn := reconcileConnection(t, r, c); !conn.Status.Established {
+		t.Fatalf("connection not established: %+v", conn.Status)
+	}
+
+	pods := []types.NamespacedName{{Namespace: "edge", Name: "pod"}}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- r.Quiesce(ctx, pods) }()
+
+	// quiescing triggers the reconcile of the connections of the pod only
+	select {
+	case <-r.quiesceEvents:
+	case <-time.After(5 * time.Second):
+		t.Fatal("quiescing did not trigger a reconcile")
+	}
+	if requests := r.quiescible(context.Background(), nil); len(requests) != 1 || requests[0].Name != "conn" {
+		t.Errorf("quiescible = %v, want the connection of the pod", requests)
+	}
+
+	conn := reconcileConnection(t, r, c)
+	if conn.Status.Established || dp.teardowns != 1 || !dp.kept {
+		t.Fatalf("connection not quiesced keeping its allocations: %+v", conn.Status)
+	}
+	if cond := meta.FindStatusCondition(conn.Status.Conditions, nsmv1.ConditionReady); cond == nil || cond.Reason != "Quiesced" {
+		t.Errorf("unexpected Ready condition: %+v", cond)
+	}
+	// Quiesce returns once the connections are down
+	if err := <-done; err != nil {
+		t.Fatalf("Quiesce() error = %v", err)
+	}
+
+	// resuming sets the connection up again
+	r.Resume(pods)
+	if requests := r.quiescible(context.Background(), nil); len(requests) != 1 {
+		t.Errorf("quiescible = %v after resuming, want the quiesced connection", requests)
+	}
+	conn = reconcileConnection(t, r, c)
+	if !conn.Status.Established || dp.setups != 2 {
+		t.Errorf("connection not set up after resuming: %+v", conn.Status)
+	}
+}
+
+func TestConnectionReconcilerQuiesceTimesOut(t *testing.T) {
+	c := newTestClient(t, testConnection(nsmv1.ConnectionTypeSRIOV))
+	r := NewConnectionReconciler(c, logrus.New(), connection.Capabilities{SRIOV: true}, &recordingDatapath{})
+	r.quiescePollInterval = time.Millisecond
+	reconcileConnection(t, r, c)
+
+	// nothing reconciles the connection
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := r.Quiesce(ctx, []types.NamespacedName{{Namespace: "edge", Name: "pod"}}); err == nil {
+		t.Error("Quiesce() returned with the connection still established")
+	}
+}
@@ -0,0 +1,183 @@
+package controller
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/akos011221/nsm/pkg/config"
+	"github.com/akos011221/nsm/pkg/cost"
+	"github.com/akos011221/nsm/pkg/damping"
+)
+
+// liveSetting is a group of settings the running components take on a
+// reload, by their name in the config file
+type liveSetting struct {
+	// Settings of the group
+	fields []string
+	// Applies the settings of a new config to the running components,
+	// false if they take effect on the next restart only
+	apply func(c *Controller, cfg *config.Config) bool
+}
+
+// liveSettings are the settings reloaded without a restart, the others take
+// effect on the next restart. A setting of a component that isn't running
+// takes effect once it is started, on the next restart.
+var liveSettings = []liveSetting{
+	{
+		fields: []string{"hotplugConsistencyCheckSec"},
+		apply: func(c *Controller, cfg *config.Config) bool {
+			// the interval of the polls catching missed hotplug events,
+			// without hotplug the discovery polls at the default interval
+			if c.sriovManager == nil || c.uevents == nil {
+				return false
+			}
+			c.sriovManager.SetPollInterval(time.Duration(cfg.HotplugConsistencyCheckSec) * time.Second)
+			return true
+		},
+	},
+	{
+		fields: []string{"pressureStallThresholdPercent", "pressureMinMemAvailablePercent"},
+		apply: func(c *Controller, cfg *config.Config) bool {
+			if c.pressureMonitor == nil {
+				return false
+			}
+			c.pressureMonitor.SetThresholds(float64(cfg.PressureStallThresholdPercent), float64(cfg.PressureMinMemAvailablePercent))
+			return true
+		},
+	},
+	{
+		fields: []string{"flapSuppressThreshold", "flapReuseThreshold", "flapHalfLifeSec", "flapMaxSuppressSec"},
+		apply: func(c *Controller, cfg *config.Config) bool {
+			if c.damper == nil {
+				return false
+			}
+			c.damper.SetConfig(dampingConfig(cfg))
+			return true
+		},
+	},
+	{
+		fields: []string{"qosPriority", "qosLinkRateMbps"},
+		apply: func(c *Controller, cfg *config.Config) bool {
+			if c.qosEnforcer == nil {
+				return false
+			}
+			c.qosEnforcer.SetPolicy(cfg.QoSLinkRateMbps, cfg.QoSPriority)
+			return true
+		},
+	},
+	{
+		fields: []string{"uplinkCosts"},
+		apply: func(c *Controller, cfg *config.Config) bool {
+			// the model starts with the first uplink costs on a restart
+			if c.costModel == nil {
+				return false
+			}
+			c.costModel.SetUplinks(uplinks(cfg))
+			return true
+		},
+	},
+	{
+		fields: []string{"probeIntervalSec", "probeMinIntervalSec", "probeBudgetPerSec"},
+		apply: func(c *Controller, cfg *config.Config) bool {
+			// enabling or disabling the probes starts or stops the monitor
+			if c.probeMonitor == nil || cfg.ProbeIntervalSec <= 0 {
+				return false
+			}
+			c.probeMonitor.SetIntervals(time.Duration(cfg.ProbeIntervalSec)*time.Second,
+				time.Duration(cfg.ProbeMinIntervalSec)*time.Second, float64(cfg.ProbeBudgetPerSec))
+			return true
+		},
+	},
+	{
+		fields: []string{"latencyTreshold"},
+		apply: func(c *Controller, cfg *config.Config) bool {
+			// only validated, no component reads it
+			return true
+		},
+	},
+}
+
+// SetConfigPath makes the controller reload the config file when it
+// changes or on SIGHUP, empty to not reload it. It must be set before
+// Start, NewController sets the file the config was loaded from.
+func (c *Controller) SetConfigPath(path string) {
+	c.configPath = path
+}
+
+// liveConfig returns the config last reloaded, the one the controller
+// started with before the first reload. The components restarted after a
+// reload read it, c.config stays the config of the start.
+func (c *Controller) liveConfig() *config.Config {
+	if cfg := c.live.Load(); cfg != nil {
+		return cfg
+	}
+	return c.config
+}
+
+// Reload applies a new config, validated by config.LoadConfig, to the
+// running components. The settings they can take live are applied at
+// once, the changes to the others are logged and take effect on the next
+// restart.
+func (c *Controller) Reload(cfg *config.Config) {
+	c.reloadMu.Lock()
+	defer c.reloadMu.Unlock()
+
+	changed := make(map[string]bool)
+	for _, field := range config.Diff(c.liveConfig(), cfg) {
+		changed[field] = true
+	}
+	if len(changed) == 0 {
+		c.logger.Info("Config reloaded without changes")
+		return
+	}
+
+	var applied []string
+	for _, setting := range liveSettings {
+		var fields []string
+		for _, field := range setting.fields {
+			if changed[field] {
+				fields = append(fields, field)
+			}
+		}
+		if len(fields) > 0 && setting.apply(c, cfg) {
+			applied = append(applied, fields...)
+			for _, field := range fields {
+				delete(changed, field)
+			}
+		}
+	}
+	c.live.Store(cfg)
+
+	if len(applied) > 0 {
+		c.logger.Infof("Config reloaded, applied %s", strings.Join(applied, ", "))
+	}
+	if len(changed) > 0 {
+		pending := make([]string, 0, len(changed))
+		for field := range changed {
+			pending = append(pending, field)
+		}
+		sort.Strings(pending)
+		c.logger.Warnf("Config changes to %s take effect on the next restart", strings.Join(pending, ", "))
+	}
+}
+
+// uplinks returns the cost metadata of the uplinks of a config
+func uplinks(cfg *config.Config) []cost.Uplink {
+	costs := make([]cost.Uplink, 0, len(cfg.UplinkCosts))
+	for _, u := range cfg.UplinkCosts {
+		costs = append(costs, cost.Uplink{Device: u.Device, CostPerGB: u.CostPerGB, MonthlyBudget: u.MonthlyBudget})
+	}
+	return costs
+}
+
+// dampingConfig returns the flap damping parameters of a config
+func dampingConfig(cfg *config.Config) damping.Config {
+	return damping.Config{
+		Penalty:           damping.DefaultConfig().Penalty,
+		SuppressThreshold: float64(cfg.FlapSuppressThreshold),
+		ReuseThreshold:    float64(cfg.FlapReuseThreshold),
+		HalfLife:          time.Duration(cfg.FlapHalfLifeSec) * time.Second,
+		MaxSuppress:       time.Duration(cfg.FlapMaxSuppressSec) * time.Second,
+	}
+}
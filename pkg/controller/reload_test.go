@@ -0,0 +1,50 @@
+package controller
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/akos011221/nsm/pkg/config"
+	"github.com/akos011221/nsm/pkg/damping"
+	"github.com/sirupsen/logrus"
+)
+
+func TestReload(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	cfg := config.DefaultConfig()
+	damper := damping.NewDamper(dampingConfig(cfg))
+	c := &Controller{config: cfg, logger: logger, damper: damper}
+
+	// a single flap is below the default suppress threshold
+	now := time.Now()
+	damper.Observe("edge/conn", true, now)
+	damper.Observe("edge/conn", false, now)
+	if suppressed, _ := damper.Suppressed("edge/conn", now); suppressed {
+		t.Fatalf("suppressed after a single flap")
+	}
+
+	next := config.DefaultConfig()
+	next.FlapSuppressThreshold = 900
+	next.FlapReuseThreshold = 500
+	next.EdgeNodeID = "edge-2"
+	c.Reload(next)
+	if c.liveConfig() != next || c.config != cfg {
+		t.Errorf("reloaded config not kept apart from the config of the start")
+	}
+	damper.Observe("edge/conn", true, now)
+	damper.Observe("edge/conn", false, now)
+	if suppressed, _ := damper.Suppressed("edge/conn", now); !suppressed {
+		t.Errorf("flap damping thresholds not applied")
+	}
+
+	// components that aren't running are skipped
+	again := config.DefaultConfig()
+	again.QoSPriority = "low"
+	again.PressureStallThresholdPercent = 60
+	c.Reload(again)
+	if c.liveConfig().QoSPriority != "low" {
+		t.Errorf("QoS priority = %s, want low", c.liveConfig().QoSPriority)
+	}
+}
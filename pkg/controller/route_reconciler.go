@@ -0,0 +1,151 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/gateway"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// RouteReconciler accepts Gateway API routes (HTTPRoute, GRPCRoute) as
+// intent: it connects the pods of the Gateways of a route to its
+// NetworkService backends, so north-south traffic into the site is
+// expressed with the standard APIs while NSM programs the datapath.
+// Route status is left to the Gateway implementation.
+type RouteReconciler struct {
+	// Kubernetes client (controller-runtime)
+	client client.Client
+	// Logger
+	logger *logrus.Logger
+	// Kind of the routes reconciled
+	gvk schema.GroupVersionKind
+}
+
+// NewRouteReconciler creates a new reconciler of a Gateway API route kind
+func NewRouteReconciler(c client.Client, logger *logrus.Logger, gvk schema.GroupVersionKind) *RouteReconciler {
+	return &RouteReconciler{
+		client: c,
+		logger: logger,
+		gvk:    gvk,
+	}
+}
+
+// newRoute returns an empty route of the reconciled kind
+func (r *RouteReconciler) newRoute() *unstructured.Unstructured {
+	route := &unstructured.Unstructured{}
+	route.SetGroupVersionKind(r.gvk)
+	return route
+}
+
+// SetupWithManager registers the reconciler and its watches with the manager
+func (r *RouteReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named(strings.ToLower(r.gvk.Kind)).
+		For(r.newRoute()).
+		Owns(&nsmv1.NetworkConnection{}).
+		Watches(&corev1.Pod{}, handler.EnqueueRequestsFromMapFunc(r.routesForPod)).
+		Watches(&nsmv1.NetworkService{}, handler.EnqueueRequestsFromMapFunc(r.routesForService)).
+		Complete(r)
+}
+
+// Reconcile brings the generated connections of a route in line with its
+// Gateways and backends
+func (r *RouteReconciler) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	route := r.newRoute()
+	if err := r.client.Get(ctx, req.NamespacedName, route); err != nil {
+		// generated connections are garbage collected through owner references
+		return reconcile.Result{}, client.IgnoreNotFound(err)
+	}
+
+	spec, err := gateway.ParseSpec(route)
+	if err != nil {
+		r.logger.WithError(err).Warnf("Ignoring %s %s", r.gvk.Kind, req)
+		return reconcile.Result{}, nil
+	}
+
+	var pods []corev1.Pod
+	for _, gw := range spec.Gateways(route.GetNamespace()) {
+		var list corev1.PodList
+		if err := r.client.List(ctx, &list, client.InNamespace(gw.Namespace), client.MatchingLabels{gateway.LabelGatewayName: gw.Name}); err != nil {
+			return reconcile.Result{}, fmt.Errorf("failed to list pods of gateway %s: %w", gw, err)
+		}
+		pods = append(pods, list.Items...)
+	}
+
+	services := make(map[client.ObjectKey]*nsmv1.NetworkService)
+	for _, key := range spec.Services(route.GetNamespace()) {
+		var svc nsmv1.NetworkService
+		if err := r.client.Get(ctx, key, &svc); err != nil {
+			if client.IgnoreNotFound(err) != nil {
+				return reconcile.Result{}, fmt.Errorf("failed to get network service %s: %w", key, err)
+			}
+			continue
+		}
+		services[key] = &svc
+	}
+
+	result, err := gateway.Compile(route, pods, services)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	for _, key := range result.Unresolved {
+		r.logger.Warnf("%s %s references NetworkService %s, which doesn't exist", r.gvk.Kind, req, key)
+	}
+
+	return reconcile.Result{}, applyGeneratedConnections(ctx, r.client, r.logger, nil, route, strings.ToLower(r.gvk.Kind), gateway.LabelRoute, result.Connections)
+}
+
+// routesForPod maps a Gateway pod to the routes attached to its Gateway
+func (r *RouteReconciler) routesForPod(ctx context.Context, obj client.Object) []reconcile.Request {
+	name, ok := obj.GetLabels()[gateway.LabelGatewayName]
+	if !ok {
+		return nil
+	}
+	gw := client.ObjectKey{Namespace: obj.GetNamespace(), Name: name}
+	return r.routesMatching(ctx, func(spec *gateway.RouteSpec, namespace string) []client.ObjectKey {
+		return spec.Gateways(namespace)
+	}, gw)
+}
+
+// routesForService maps a NetworkService to the routes using it as backend
+func (r *RouteReconciler) routesForService(ctx context.Context, obj client.Object) []reconcile.Request {
+	return r.routesMatching(ctx, func(spec *gateway.RouteSpec, namespace string) []client.ObjectKey {
+		return spec.Services(namespace)
+	}, client.ObjectKeyFromObject(obj))
+}
+
+// routesMatching returns the requests of the routes referencing an object
+func (r *RouteReconciler) routesMatching(ctx context.Context, refs func(*gateway.RouteSpec, string) []client.ObjectKey, key client.ObjectKey) []reconcile.Request {
+	list := &unstructured.UnstructuredList{}
+	list.SetGroupVersionKind(r.gvk.GroupVersion().WithKind(r.gvk.Kind + "List"))
+	if err := r.client.List(ctx, list); err != nil {
+		r.logger.WithError(err).Errorf("Failed to list %ss", r.gvk.Kind)
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for i := range list.Items {
+		route := &list.Items[i]
+		spec, err := gateway.ParseSpec(route)
+		if err != nil {
+			continue
+		}
+		for _, ref := range refs(spec, route.GetNamespace()) {
+			if ref == key {
+				requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(route)})
+				break
+			}
+		}
+	}
+	return requests
+}
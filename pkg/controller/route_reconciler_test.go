@@ -0,0 +1,62 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/gateway"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestRouteReconcilerConnectsGatewayPods(t *testing.T) {
+	route := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{
+			"parentRefs": []interface{}{map[string]interface{}{"name": "edge-gw"}},
+			"rules": []interface{}{map[string]interface{}{"backendRefs": []interface{}{
+				map[string]interface{}{"group": nsmv1.GroupName, "kind": "NetworkService", "name": "vision"},
+			}}},
+		},
+	}}
+	route.SetGroupVersionKind(gateway.HTTPRoute)
+	route.SetNamespace("edge")
+	route.SetName("cameras")
+	route.SetUID("route-uid")
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "edge", Name: "edge-gw-0", Labels: map[string]string{gateway.LabelGatewayName: "edge-gw"}}}
+	svc := &nsmv1.NetworkService{ObjectMeta: metav1.ObjectMeta{Namespace: "edge", Name: "vision"}}
+	c := newTestClient(t, route, pod, svc)
+	r := NewRouteReconciler(c, logrus.New(), gateway.HTTPRoute)
+	ctx := context.Background()
+
+	if _, err := r.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(route)}); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	var conns nsmv1.NetworkConnectionList
+	if err := c.List(ctx, &conns, client.MatchingLabels{gateway.LabelRoute: "cameras"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(conns.Items) != 1 {
+		t.Fatalf("%d connections generated, want 1", len(conns.Items))
+	}
+	conn := conns.Items[0]
+	if conn.Spec.Source != "edge/edge-gw-0" || conn.Spec.Destination != "vision" || !metav1.IsControlledBy(&conn, route) {
+		t.Errorf("unexpected connection %+v", conn)
+	}
+
+	// a new gateway pod and the backend reconcile the route
+	if reqs := r.routesForPod(ctx, pod); len(reqs) != 1 || reqs[0].Name != "cameras" {
+		t.Errorf("routesForPod() = %v", reqs)
+	}
+	if reqs := r.routesForService(ctx, svc); len(reqs) != 1 {
+		t.Errorf("routesForService() = %v", reqs)
+	}
+	other := &nsmv1.NetworkService{ObjectMeta: metav1.ObjectMeta{Namespace: "edge", Name: "other"}}
+	if reqs := r.routesForService(ctx, other); len(reqs) != 0 {
+		t.Errorf("routesForService() of an unused service = %v", reqs)
+	}
+}
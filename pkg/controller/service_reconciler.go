@@ -0,0 +1,190 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/admission"
+	"github.com/akos011221/nsm/pkg/catalog"
+	"github.com/akos011221/nsm/pkg/connection"
+	"github.com/akos011221/nsm/pkg/external"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// ServiceReconciler validates NetworkServices, registers the valid ones in
+// the service catalog and reports their phase from the connections to them
+type ServiceReconciler struct {
+	// Kubernetes client (controller-runtime)
+	client client.Client
+	// Logger
+	logger *logrus.Logger
+	// Acceleration capabilities of the node
+	caps connection.Capabilities
+	// Catalog the services are registered in
+	catalog *catalog.Catalog
+}
+
+// NewServiceReconciler creates a new service reconciler
+func NewServiceReconciler(c client.Client, logger *logrus.Logger, caps connection.Capabilities, cat *catalog.Catalog) *ServiceReconciler {
+	return &ServiceReconciler{
+		client:  c,
+		logger:  logger,
+		caps:    caps,
+		catalog: cat,
+	}
+}
+
+// SetupWithManager registers the reconciler and its watches with the manager
+func (r *ServiceReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("networkservice").
+		// the status of the services is written by the leader only, so the
+		// replicas don't race on it
+		For(&nsmv1.NetworkService{}).
+		Watches(&nsmv1.NetworkConnection{}, handler.EnqueueRequestsFromMapFunc(r.serviceForConnection)).
+		Complete(r)
+}
+
+// serviceForConnection maps a connection event to its destination service
+func (r *ServiceReconciler) serviceForConnection(_ context.Context, obj client.Object) []reconcile.Request {
+	conn, ok := obj.(*nsmv1.NetworkConnection)
+	if !ok {
+		return nil
+	}
+	namespace, name := connection.Destination(conn)
+	if len(validation.IsDNS1123Subdomain(name)) > 0 {
+		// an address, not a service
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{Namespace: namespace, Name: name}}}
+}
+
+// Reconcile validates a service, registers it in the catalog and moves its
+// phase between Pending, Ready and Degraded
+func (r *ServiceReconciler) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	var svc nsmv1.NetworkService
+	if err := r.client.Get(ctx, req.NamespacedName, &svc); err != nil {
+		r.catalog.Deregister(req.Namespace, req.Name)
+		return reconcile.Result{}, client.IgnoreNotFound(err)
+	}
+	if svc.DeletionTimestamp != nil {
+		r.catalog.Deregister(req.Namespace, req.Name)
+		return reconcile.Result{}, nil
+	}
+
+	// invalid services aren't offered until fixed
+	if err := admission.ValidateService(&svc.Spec, 0); err != nil {
+		r.catalog.Deregister(req.Namespace, req.Name)
+		status := nsmv1.NetworkServiceStatus{Phase: nsmv1.ServicePhaseError, Message: err.Error()}
+		return reconcile.Result{}, r.setStatus(ctx, &svc, status, "InvalidSpec", "")
+	}
+
+	var conns nsmv1.NetworkConnectionList
+	if err := r.client.List(ctx, &conns); err != nil {
+		return reconcile.Result{}, fmt.Errorf("failed to list connections: %w", err)
+	}
+	count, established, failing := 0, 0, 0
+	for i := range conns.Items {
+		conn := &conns.Items[i]
+		if namespace, name := connection.Destination(conn); namespace != svc.Namespace || name != svc.Name {
+			continue
+		}
+		count++
+		switch {
+		case conn.Status.State == nsmv1.ConnectionStateFailed || conn.Status.State == nsmv1.ConnectionStateDegraded:
+			failing++
+		case conn.Status.Established:
+			established++
+		}
+	}
+
+	status := nsmv1.NetworkServiceStatus{ConnectionCount: count}
+	reason, degraded := "", ""
+	switch {
+	case svc.Spec.RequireSRIOV && !r.caps.SRIOV:
+		status.Phase, status.Message = nsmv1.ServicePhaseDegraded, "SR-IOV is required but unavailable on the node"
+		reason, degraded = "AccelerationUnavailable", "AccelerationUnavailable"
+	case svc.Spec.RequireDPDK && !r.caps.DPDK:
+		status.Phase, status.Message = nsmv1.ServicePhaseDegraded, "DPDK is required but unavailable on the node"
+		reason, degraded = "AccelerationUnavailable", "AccelerationUnavailable"
+	case len(svc.Spec.ExternalEndpoints) > 0 && svc.Status.ActiveEndpoint == "":
+		status.Phase, status.Message = nsmv1.ServicePhaseDegraded, "none of the external endpoints is healthy"
+		reason, degraded = "NoHealthyEndpoint", "NoHealthyEndpoint"
+	case failing > 0:
+		status.Phase = nsmv1.ServicePhaseDegraded
+		status.Message = fmt.Sprintf("%d of %d connections failed or degraded", failing, count)
+		reason, degraded = "ConnectionsFailing", "ConnectionsFailing"
+	case count > 0 && established == 0:
+		status.Phase = nsmv1.ServicePhasePending
+		status.Message = fmt.Sprintf("waiting for %d connections to be established", count)
+		reason = "ConnectionsPending"
+	default:
+		status.Phase = nsmv1.ServicePhaseReady
+		status.Message = fmt.Sprintf("%d of %d connections established", established, count)
+		reason = "Registered"
+	}
+
+	r.catalog.Register(catalog.Entry{
+		Namespace:       svc.Namespace,
+		Name:            svc.Name,
+		ServiceType:     svc.Spec.ServiceType,
+		Endpoint:        external.Endpoint(&svc),
+		Phase:           status.Phase,
+		ConnectionCount: count,
+		Established:     established,
+	})
+	return reconcile.Result{}, r.setStatus(ctx, &svc, status, reason, degraded)
+}
+
+// setStatus writes the phase and conditions of a service, skipping the
+// update when nothing changed so the connection watch doesn't loop. The
+// service is Degraded with the reason when degraded isn't empty. The
+// health of the external endpoints is kept, the checker records it.
+func (r *ServiceReconciler) setStatus(ctx context.Context, svc *nsmv1.NetworkService, status nsmv1.NetworkServiceStatus, reason, degraded string) error {
+	old := svc.Status.DeepCopy()
+	status.Conditions = old.Conditions
+	status.ActiveEndpoint, status.Endpoints = old.ActiveEndpoint, old.Endpoints
+	ready := metav1.ConditionFalse
+	if status.Phase == nsmv1.ServicePhaseReady {
+		ready = metav1.ConditionTrue
+	}
+	meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+		Type:               nsmv1.ConditionReady,
+		Status:             ready,
+		Reason:             reason,
+		Message:            status.Message,
+		ObservedGeneration: svc.Generation,
+	})
+	cond := metav1.Condition{
+		Type:               nsmv1.ConditionDegraded,
+		Status:             metav1.ConditionFalse,
+		Reason:             "Healthy",
+		ObservedGeneration: svc.Generation,
+	}
+	if degraded != "" {
+		cond.Status, cond.Reason, cond.Message = metav1.ConditionTrue, degraded, status.Message
+	}
+	meta.SetStatusCondition(&status.Conditions, cond)
+
+	if equality.Semantic.DeepEqual(*old, status) {
+		return nil
+	}
+	if old.Phase != status.Phase {
+		r.logger.Infof("NetworkService %s/%s is %s: %s", svc.Namespace, svc.Name, status.Phase, status.Message)
+	}
+	svc.Status = status
+	if err := r.client.Status().Update(ctx, svc); err != nil {
+		return fmt.Errorf("failed to update service status: %w", err)
+	}
+	return nil
+}
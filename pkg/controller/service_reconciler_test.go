@@ -0,0 +1,203 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/catalog"
+	"github.com/akos011221/nsm/pkg/connection"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestServiceReconcilerPhases(t *testing.T) {
+	svc := &nsmv1.NetworkService{
+		ObjectMeta: metav1.ObjectMeta{Name: "vision", Namespace: "edge"},
+		Spec:       nsmv1.NetworkServiceSpec{ServiceType: "l3", Endpoint: "10.0.0.5:8080"},
+	}
+	conn := &nsmv1.NetworkConnection{
+		ObjectMeta: metav1.ObjectMeta{Name: "camera-vision", Namespace: "edge"},
+		Spec:       nsmv1.NetworkConnectionSpec{Source: "edge/camera", Destination: "vision", ConnectionType: nsmv1.ConnectionTypeKernel},
+		Status:     nsmv1.NetworkConnectionStatus{State: nsmv1.ConnectionStatePending},
+	}
+	c := newTestClient(t, svc, conn)
+	cat := catalog.NewCatalog()
+	r := NewServiceReconciler(c, logrus.New(), connection.Capabilities{}, cat)
+	ctx := context.Background()
+	req := reconcile.Request{NamespacedName: client.ObjectKeyFromObject(svc)}
+
+	reconcilePhase := func(want string) {
+		t.Helper()
+		if _, err := r.Reconcile(ctx, req); err != nil {
+			t.Fatalf("Reconcile() error = %v", err)
+		}
+		if err := c.Get(ctx, req.NamespacedName, svc); err != nil {
+			t.Fatal(err)
+		}
+		if svc.Status.Phase != want {
+			t.Fatalf("phase = %s (%s), want %s", svc.Status.Phase, svc.Status.Message, want)
+		}
+	}
+	setState := func(state string, established bool) {
+		t.Helper()
+		if err := c.Get(ctx, client.ObjectKeyFromObject(conn), conn); err != nil {
+			t.Fatal(err)
+		}
+		conn.Status.State, conn.Status.Established = state, established
+		if err := c.Status().Update(ctx, conn); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reconcilePhase(nsmv1.ServicePhasePending)
+	if svc.Status.ConnectionCount != 1 {
+		t.Errorf("ConnectionCount = %d, want 1", svc.Status.ConnectionCount)
+	}
+
+	setState(nsmv1.ConnectionStateEstablished, true)
+	reconcilePhase(nsmv1.ServicePhaseReady)
+	if !meta.IsStatusConditionTrue(svc.Status.Conditions, nsmv1.ConditionReady) {
+		t.Errorf("Ready condition not true: %+v", svc.Status.Conditions)
+	}
+	if entry, ok := cat.Lookup("edge", "vision"); !ok || entry.Phase != nsmv1.ServicePhaseReady || entry.Established != 1 {
+		t.Errorf("catalog entry = %+v, %t", entry, ok)
+	}
+
+	setState(nsmv1.ConnectionStateFailed, false)
+	reconcilePhase(nsmv1.ServicePhaseDegraded)
+	if !meta.IsStatusConditionTrue(svc.Status.Conditions, nsmv1.ConditionDegraded) {
+		t.Errorf("Degraded condition not true: %+v", svc.Status.Conditions)
+	}
+
+	// an unchanged status isn't written again
+	version := svc.ResourceVersion
+	reconcilePhase(nsmv1.ServicePhaseDegraded)
+	if svc.ResourceVersion != version {
+		t.Error("unchanged status was updated")
+	}
+
+	// a deleted service is deregistered
+	if err := c.Delete(ctx, svc); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Reconcile(ctx, req); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if _, ok := cat.Lookup("edge", "vision"); ok {
+		t.Error("deleted service still registered")
+	}
+}
+
+func TestServiceReconcilerRejectsInvalidServices(t *testing.T) {
+	for name, spec := range map[string]nsmv1.NetworkServiceSpec{
+		"missing type":     {Endpoint: "10.0.0.5"},
+		"uppercase type":   {ServiceType: "L3", Endpoint: "10.0.0.5"},
+		"missing endpoint": {ServiceType: "l3"},
+		"invalid port":     {ServiceType: "l3", Endpoint: "10.0.0.5:99999"},
+		"invalid host":     {ServiceType: "l3", Endpoint: "not a host"},
+		"external without port": {ServiceType: "l3", ExternalEndpoints: []nsmv1.ExternalEndpoint{
+			{Name: "onprem", Address: "10.20.0.5"},
+		}},
+		"duplicate external": {ServiceType: "l3", ExternalEndpoints: []nsmv1.ExternalEndpoint{
+			{Name: "onprem", Address: "10.20.0.5:443"}, {Name: "onprem", Address: "10.20.0.6:443"},
+		}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			svc := &nsmv1.NetworkService{ObjectMeta: metav1.ObjectMeta{Name: "vision", Namespace: "edge"}, Spec: spec}
+			c := newTestClient(t, svc)
+			cat := catalog.NewCatalog()
+			r := NewServiceReconciler(c, logrus.New(), connection.Capabilities{}, cat)
+			req := reconcile.Request{NamespacedName: client.ObjectKeyFromObject(svc)}
+
+			if _, err := r.Reconcile(context.Background(), req); err != nil {
+				t.Fatalf("Reconcile() error = %v", err)
+			}
+			if err := c.Get(context.Background(), req.NamespacedName, svc); err != nil {
+				t.Fatal(err)
+			}
+			cond := meta.FindStatusCondition(svc.Status.Conditions, nsmv1.ConditionReady)
+			if svc.Status.Phase != nsmv1.ServicePhaseError || cond == nil || cond.Reason != "InvalidSpec" {
+				t.Errorf("status = %+v, want Error with InvalidSpec", svc.Status)
+			}
+			if _, ok := cat.Lookup("edge", "vision"); ok {
+				t.Error("invalid service registered")
+			}
+		})
+	}
+}
+
+func TestServiceReconcilerRequiresAcceleration(t *testing.T) {
+	svc := &nsmv1.NetworkService{
+		ObjectMeta: metav1.ObjectMeta{Name: "packet-core", Namespace: "edge"},
+		Spec:       nsmv1.NetworkServiceSpec{ServiceType: "l2", Endpoint: "upf.edge.svc", RequireSRIOV: true},
+	}
+	c := newTestClient(t, svc)
+	r := NewServiceReconciler(c, logrus.New(), connection.Capabilities{DPDK: true}, catalog.NewCatalog())
+	req := reconcile.Request{NamespacedName: client.ObjectKeyFromObject(svc)}
+
+	if _, err := r.Reconcile(context.Background(), req); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if err := c.Get(context.Background(), req.NamespacedName, svc); err != nil {
+		t.Fatal(err)
+	}
+	if svc.Status.Phase != nsmv1.ServicePhaseDegraded {
+		t.Errorf("phase = %s, want Degraded without SR-IOV", svc.Status.Phase)
+	}
+}
+
+func TestServiceReconcilerExternalEndpoints(t *testing.T) {
+	svc := &nsmv1.NetworkService{
+		ObjectMeta: metav1.ObjectMeta{Name: "historian", Namespace: "edge"},
+		Spec: nsmv1.NetworkServiceSpec{ServiceType: "l3", ExternalEndpoints: []nsmv1.ExternalEndpoint{
+			{Name: "onprem", Address: "10.20.0.5:443"},
+			{Name: "cloud", Address: "historian.example.com:443", Priority: 1},
+		}},
+	}
+	c := newTestClient(t, svc)
+	cat := catalog.NewCatalog()
+	r := NewServiceReconciler(c, logrus.New(), connection.Capabilities{}, cat)
+	ctx := context.Background()
+	req := reconcile.Request{NamespacedName: client.ObjectKeyFromObject(svc)}
+
+	// no healthy external endpoint yet
+	if _, err := r.Reconcile(ctx, req); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if err := c.Get(ctx, req.NamespacedName, svc); err != nil {
+		t.Fatal(err)
+	}
+	cond := meta.FindStatusCondition(svc.Status.Conditions, nsmv1.ConditionDegraded)
+	if svc.Status.Phase != nsmv1.ServicePhaseDegraded || cond == nil || cond.Reason != "NoHealthyEndpoint" {
+		t.Fatalf("status = %+v, want Degraded with NoHealthyEndpoint", svc.Status)
+	}
+
+	// the checker fails the service over to the cloud endpoint
+	svc.Status.ActiveEndpoint = "cloud"
+	svc.Status.Endpoints = []nsmv1.EndpointHealth{
+		{Name: "onprem", Address: "10.20.0.5:443", Message: "connection refused"},
+		{Name: "cloud", Address: "historian.example.com:443", Healthy: true},
+	}
+	if err := c.Status().Update(ctx, svc); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Reconcile(ctx, req); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if err := c.Get(ctx, req.NamespacedName, svc); err != nil {
+		t.Fatal(err)
+	}
+	if svc.Status.Phase != nsmv1.ServicePhaseReady {
+		t.Errorf("phase = %s (%s), want Ready", svc.Status.Phase, svc.Status.Message)
+	}
+	if svc.Status.ActiveEndpoint != "cloud" || len(svc.Status.Endpoints) != 2 {
+		t.Errorf("endpoint health not kept: %+v", svc.Status)
+	}
+	if entry, ok := cat.Lookup("edge", "historian"); !ok || entry.Endpoint != "historian.example.com:443" {
+		t.Errorf("catalog entry = %+v, %t, want the cloud endpoint", entry, ok)
+	}
+}
@@ -0,0 +1,77 @@
+package controller
+
+import (
+	"context"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// Shard tells which nodes this replica reconciles the objects of when the
+// reconciliation is sharded across the controller replicas
+type Shard interface {
+	// Owns tells whether this replica reconciles the objects of the node,
+	// or the objects with the key that aren't tied to a node
+	Owns(node string) bool
+	// OnChange registers a function called after every rebalance
+	OnChange(fn func())
+}
+
+// sharded returns the options of the sharded reconcilers, which run on
+// every replica whether it leads or not, each reconciling its shard
+func sharded() controller.Options {
+	needLeaderElection := false
+	return controller.Options{NeedLeaderElection: &needLeaderElection}
+}
+
+// shardEvents returns a channel triggering the reconcile of the objects
+// of a kind after every rebalance
+func shardEvents(shard Shard, obj client.Object) chan event.GenericEvent {
+	events := make(chan event.GenericEvent, 1)
+	shard.OnChange(func() {
+		// a pending trigger already reconciles all objects
+		select {
+		case events <- event.GenericEvent{Object: obj}:
+		default:
+		}
+	})
+	return events
+}
+
+// rebalanceRequests returns the requests of all objects of the list, any
+// of which may generate connections on the nodes taken over on a rebalance
+func rebalanceRequests(ctx context.Context, c client.Client, logger *logrus.Logger, list client.ObjectList) []reconcile.Request {
+	if err := c.List(ctx, list); err != nil {
+		logger.WithError(err).Error("Failed to list objects after a shard rebalance")
+		return nil
+	}
+
+	var requests []reconcile.Request
+	_ = meta.EachListItem(list, func(o runtime.Object) error {
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(o.(client.Object))})
+		return nil
+	})
+	return requests
+}
+
+// ownsObject tells whether this replica reconciles what of an object isn't
+// tied to a node, its status and the objects generated for no node
+func ownsObject(shard Shard, obj client.Object) bool {
+	return shard == nil || shard.Owns(client.ObjectKeyFromObject(obj).String())
+}
+
+// ownsConnection tells whether this replica applies a generated
+// connection: the connections serving a pod are sharded by its node, the
+// others by the object generating them
+func ownsConnection(shard Shard, owner client.Object, conn *nsmv1.NetworkConnection) bool {
+	if node := conn.Labels[nsmv1.LabelConnectionNode]; node != "" && shard != nil {
+		return shard.Owns(node)
+	}
+	return ownsObject(shard, owner)
+}
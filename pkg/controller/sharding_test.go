@@ -0,0 +1,123 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/blueprint"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// staticShard owns a fixed set of keys
+type staticShard struct {
+	owned    map[string]bool
+	onChange func()
+}
+
+func (s *staticShard) Owns(key string) bool { return s.owned[key] }
+
+func (s *staticShard) OnChange(fn func()) { s.onChange = fn }
+
+func TestBlueprintReconcilerSharded(t *testing.T) {
+	newBlueprint := func(name string) *nsmv1.NetworkBlueprint {
+		return &nsmv1.NetworkBlueprint{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "edge", UID: types.UID("uid-" + name)},
+			Spec: nsmv1.NetworkBlueprintSpec{
+				Template:  nsmv1.NetworkConnectionSpec{Source: "edge/camera", Destination: "vision", ConnectionType: nsmv1.ConnectionTypeKernel},
+				Instances: []nsmv1.BlueprintInstance{{}},
+			},
+		}
+	}
+	c := newTestClient(t, newBlueprint("mine"), newBlueprint("theirs"))
+	shard := &staticShard{owned: map[string]bool{"edge/mine": true}}
+	r := NewBlueprintReconciler(c, logrus.New())
+	r.SetShard(shard)
+	ctx := context.Background()
+
+	// blueprints of other shards are left to their replica
+	for _, name := range []string{"mine", "theirs"} {
+		if _, err := r.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKey{Namespace: "edge", Name: name}}); err != nil {
+			t.Fatalf("Reconcile() error = %v", err)
+		}
+	}
+	for name, want := range map[string]int{"mine": 1, "theirs": 0} {
+		var conns nsmv1.NetworkConnectionList
+		if err := c.List(ctx, &conns, client.MatchingLabels{blueprint.LabelBlueprint: name}); err != nil {
+			t.Fatal(err)
+		}
+		if len(conns.Items) != want {
+			t.Errorf("blueprint %s generated %d connections, want %d", name, len(conns.Items), want)
+		}
+	}
+
+	// a rebalance reconciles every blueprint, any may have connections
+	// on the nodes taken over
+	shard.owned["edge/theirs"] = true
+	shard.onChange()
+	select {
+	case <-r.shardEvents:
+	default:
+		t.Fatalf("rebalance did not trigger a reconcile")
+	}
+	requests := r.rebalanceRequests(ctx, nil)
+	if len(requests) != 2 {
+		t.Errorf("rebalance requests = %v, want both blueprints", requests)
+	}
+}
+
+func TestBlueprintReconcilerShardedByNode(t *testing.T) {
+	pod := func(name, node string) *corev1.Pod {
+		return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "edge"}, Spec: corev1.PodSpec{NodeName: node}}
+	}
+	bp := &nsmv1.NetworkBlueprint{
+		ObjectMeta: metav1.ObjectMeta{Name: "cameras", Namespace: "edge", UID: "uid-cameras"},
+		Spec: nsmv1.NetworkBlueprintSpec{
+			Parameters: []nsmv1.BlueprintParameter{{Name: "id"}},
+			Template:   nsmv1.NetworkConnectionSpec{Source: "edge/camera-${id}", Destination: "vision", ConnectionType: nsmv1.ConnectionTypeKernel},
+			Instances: []nsmv1.BlueprintInstance{
+				{Parameters: map[string]string{"id": "a"}},
+				{Parameters: map[string]string{"id": "b"}},
+			},
+		},
+	}
+	c := newTestClient(t, bp, pod("camera-a", "edge-1"), pod("camera-b", "edge-2"))
+	ctx := context.Background()
+
+	// each replica applies the connections of the pods on its nodes, the
+	// replica owning the blueprint's key its status
+	for _, owned := range []string{"edge-1", "edge-2"} {
+		r := NewBlueprintReconciler(c, logrus.New())
+		r.SetShard(&staticShard{owned: map[string]bool{owned: true}})
+		if _, err := r.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(bp)}); err != nil {
+			t.Fatalf("Reconcile() error = %v", err)
+		}
+
+		var conns nsmv1.NetworkConnectionList
+		if err := c.List(ctx, &conns, client.MatchingLabels{nsmv1.LabelConnectionNode: owned}); err != nil {
+			t.Fatal(err)
+		}
+		if len(conns.Items) != 1 {
+			t.Errorf("replica of %s applied %d connections of its node, want 1", owned, len(conns.Items))
+		}
+	}
+	var conns nsmv1.NetworkConnectionList
+	if err := c.List(ctx, &conns); err != nil {
+		t.Fatal(err)
+	}
+	if len(conns.Items) != 2 {
+		t.Errorf("got %d connections, want one per pod", len(conns.Items))
+	}
+	var got nsmv1.NetworkBlueprint
+	if err := c.Get(ctx, client.ObjectKeyFromObject(bp), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Status.ConnectionCount != 0 {
+		t.Errorf("status written by a replica not owning the blueprint")
+	}
+}
@@ -0,0 +1,337 @@
+package cost
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/akos011221/nsm/pkg/watchdog"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// Interval the counters of the uplinks are sampled at
+const sampleInterval = time.Minute
+
+// Layout of the billing month of the usage
+const monthLayout = "2006-01"
+
+var (
+	// transferredBytes exposes the month-to-date traffic of the uplinks
+	transferredBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "nsm_uplink_transferred_bytes",
+		Help: "Bytes sent and received over an uplink in the current billing month",
+	}, []string{"device"})
+
+	// spendTotal exposes the month-to-date cost of the uplinks
+	spendTotal = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "nsm_uplink_spend",
+		Help: "Cost of the traffic over an uplink in the current billing month",
+	}, []string{"device"})
+
+	// overBudget exposes the uplinks that exhausted their monthly budget
+	overBudget = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "nsm_uplink_over_budget",
+		Help: "Whether an uplink spent its monthly budget (1) or not (0)",
+	}, []string{"device"})
+)
+
+func init() {
+	crmetrics.Registry.MustRegister(transferredBytes, spendTotal, overBudget)
+}
+
+// Uplink is the cost metadata of an uplink
+type Uplink struct {
+	// Network interface of the uplink
+	Device string
+	// Price of a gigabyte sent or received, 0 for a flat-rate link
+	CostPerGB float64
+	// Spend per month after which the uplink is avoided, 0 for no budget
+	MonthlyBudget float64
+}
+
+// Spend is the month-to-date usage of an uplink
+type Spend struct {
+	// Network interface of the uplink
+	Device string `json:"device"`
+	// Price of a gigabyte sent or received
+	CostPerGB float64 `json:"costPerGB"`
+	// Spend per month after which the uplink is avoided, 0 for no budget
+	MonthlyBudget float64 `json:"monthlyBudget,omitempty"`
+	// Billing month (e.g., 2026-10)
+	Month string `json:"month"`
+	// Gigabytes sent and received in the month
+	GBTransferred float64 `json:"gbTransferred"`
+	// Cost of the traffic in the month
+	Spend float64 `json:"spend"`
+	// Whether the monthly budget is spent
+	OverBudget bool `json:"overBudget"`
+}
+
+// usage is the persisted traffic of an uplink in a billing month
+type usage struct {
+	// Billing month
+	Month string `json:"month"`
+	// Bytes sent and received in the month
+	Bytes uint64 `json:"bytes"`
+	// Interface counters at the last sample
+	Counter uint64 `json:"counter"`
+}
+
+// Model prices the uplinks of the node: it samples the byte counters of
+// the uplinks with cost metadata, charges their traffic to the billing
+// month and reports which exhausted their budget, so path selection
+// prefers the cheap links and avoids the ones over budget. The usage is
+// persisted, so a restart doesn't reset the spend of the month.
+type Model struct {
+	// Context for cancellation
+	ctx context.Context
+	// Logger
+	logger *logrus.Logger
+	// File the usage is persisted in, empty to keep it in memory
+	stateFile string
+	// Root of sysfs, overridden in tests
+	sysRoot string
+	// Cost metadata by device
+	uplinks map[string]Uplink
+	// Usage of the current month by device
+	usage map[string]*usage
+	// Mutex for protecting the uplinks and usage
+	mu sync.RWMutex
+	// Progress signal for the watchdog
+	heartbeat *watchdog.Heartbeat
+}
+
+// NewModel creates a new cost model of the uplinks
+func NewModel(ctx context.Context, logger *logrus.Logger, uplinks []Uplink, stateFile string) *Model {
+	m := &Model{
+		ctx:       ctx,
+		logger:    logger,
+		stateFile: stateFile,
+		sysRoot:   "/sys",
+		usage:     make(map[string]*usage),
+	}
+	m.SetUplinks(uplinks)
+	return m
+}
+
+// SetUplinks replaces the cost metadata of the uplinks, the usage of the
+// month is kept
+func (m *Model) SetUplinks(uplinks []Uplink) {
+	byDevice := make(map[string]Uplink, len(uplinks))
+	for _, u := range uplinks {
+		byDevice[u.Device] = u
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.uplinks = byDevice
+}
+
+// SetHeartbeat makes the model report its progress to the watchdog
+func (m *Model) SetHeartbeat(hb *watchdog.Heartbeat) {
+	m.heartbeat = hb
+	hb.Expect(sampleInterval)
+}
+
+// Start loads the usage and samples the uplinks periodically
+func (m *Model) Start() error {
+	m.logger.Info("Starting uplink cost model")
+	if err := m.Load(); err != nil {
+		m.logger.WithError(err).Warn("Failed to load the uplink usage, starting the month over")
+	}
+	if err := m.Sample(time.Now()); err != nil {
+		m.logger.WithError(err).Warn("Failed to sample the uplinks")
+	}
+
+	ticker := time.NewTicker(sampleInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			m.heartbeat.Beat()
+			if err := m.Sample(now); err != nil {
+				m.logger.WithError(err).Warn("Failed to sample the uplinks")
+			}
+			if err := m.save(); err != nil {
+				m.logger.WithError(err).Warn("Failed to persist the uplink usage")
+			}
+
+		case <-m.ctx.Done():
+			m.logger.Info("Stopping uplink cost model")
+			if err := m.save(); err != nil {
+				m.logger.WithError(err).Warn("Failed to persist the uplink usage")
+			}
+			return nil
+		}
+	}
+}
+
+// Sample charges the traffic of the uplinks since the last sample to the
+// month, starting the usage over when the month changed
+func (m *Model) Sample(now time.Time) error {
+	month := now.UTC().Format(monthLayout)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var errs []error
+	for device, uplink := range m.uplinks {
+		counter, err := m.counter(device)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		u := m.usage[device]
+		switch {
+		case u == nil:
+			// the traffic before the first sample isn't known
+			u = &usage{Month: month}
+			m.usage[device] = u
+		case counter >= u.Counter:
+			u.Bytes += counter - u.Counter
+		default:
+			// the counters were reset, e.g., the interface was recreated
+			u.Bytes += counter
+		}
+		u.Counter = counter
+		if u.Month != month {
+			u.Month, u.Bytes = month, 0
+		}
+
+		spend := spendOf(uplink, u)
+		transferredBytes.WithLabelValues(device).Set(float64(u.Bytes))
+		spendTotal.WithLabelValues(device).Set(spend)
+		if exhausted(uplink, spend) {
+			overBudget.WithLabelValues(device).Set(1)
+		} else {
+			overBudget.WithLabelValues(device).Set(0)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Cost returns the price per gigabyte of an uplink and whether it spent
+// its monthly budget. Devices without cost metadata are free.
+func (m *Model) Cost(device string) (perGB float64, over bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	uplink, ok := m.uplinks[device]
+	if !ok {
+		return 0, false
+	}
+	return uplink.CostPerGB, exhausted(uplink, spendOf(uplink, m.usage[device]))
+}
+
+// Spends returns the month-to-date usage of the uplinks, ordered by device
+func (m *Model) Spends() []Spend {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	spends := make([]Spend, 0, len(m.uplinks))
+	for device, uplink := range m.uplinks {
+		s := Spend{Device: device, CostPerGB: uplink.CostPerGB, MonthlyBudget: uplink.MonthlyBudget}
+		if u := m.usage[device]; u != nil {
+			s.Month = u.Month
+			s.GBTransferred = float64(u.Bytes) / 1e9
+		}
+		s.Spend = spendOf(uplink, m.usage[device])
+		s.OverBudget = exhausted(uplink, s.Spend)
+		spends = append(spends, s)
+	}
+	sort.Slice(spends, func(i, j int) bool { return spends[i].Device < spends[j].Device })
+	return spends
+}
+
+// counter reads the bytes sent and received over a device
+func (m *Model) counter(device string) (uint64, error) {
+	var total uint64
+	for _, stat := range []string{"rx_bytes", "tx_bytes"} {
+		data, err := os.ReadFile(filepath.Join(m.sysRoot, "class/net", device, "statistics", stat))
+		if err != nil {
+			return 0, fmt.Errorf("failed to read the counters of uplink %s: %w", device, err)
+		}
+		n, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s of uplink %s: %w", stat, device, err)
+		}
+		total += n
+	}
+	return total, nil
+}
+
+// Load reads the persisted usage
+func (m *Model) Load() error {
+	if m.stateFile == "" {
+		return nil
+	}
+	data, err := os.ReadFile(m.stateFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read uplink usage: %w", err)
+	}
+
+	loaded := make(map[string]*usage)
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		return fmt.Errorf("failed to decode uplink usage: %w", err)
+	}
+	if loaded == nil {
+		loaded = make(map[string]*usage)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.usage = loaded
+	return nil
+}
+
+// save persists the usage, replacing the file atomically so a crash never
+// leaves a truncated usage behind
+func (m *Model) save() error {
+	if m.stateFile == "" {
+		return nil
+	}
+
+	m.mu.RLock()
+	data, err := json.Marshal(m.usage)
+	m.mu.RUnlock()
+	if err != nil {
+		return fmt.Errorf("failed to encode uplink usage: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(m.stateFile), 0o755); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+	tmp := m.stateFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write uplink usage: %w", err)
+	}
+	if err := os.Rename(tmp, m.stateFile); err != nil {
+		return fmt.Errorf("failed to replace uplink usage: %w", err)
+	}
+	return nil
+}
+
+// spendOf returns the cost of the usage of an uplink, in decimal gigabytes
+func spendOf(uplink Uplink, u *usage) float64 {
+	if u == nil {
+		return 0
+	}
+	return float64(u.Bytes) / 1e9 * uplink.CostPerGB
+}
+
+// exhausted reports whether an uplink spent its monthly budget
+func exhausted(uplink Uplink, spend float64) bool {
+	return uplink.MonthlyBudget > 0 && spend >= uplink.MonthlyBudget
+}
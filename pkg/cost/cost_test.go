@@ -0,0 +1,123 @@
+package cost
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// setCounters writes the byte counters of a device under a sysfs root
+func setCounters(t *testing.T, sys, device string, rx, tx uint64) {
+	t.Helper()
+	dir := filepath.Join(sys, "class/net", device, "statistics")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for stat, n := range map[string]uint64{"rx_bytes": rx, "tx_bytes": tx} {
+		if err := os.WriteFile(filepath.Join(dir, stat), []byte(fmt.Sprintf("%d\n", n)), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func testModel(t *testing.T, stateFile string) (*Model, string) {
+	t.Helper()
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	m := NewModel(context.Background(), logger, []Uplink{
+		{Device: "wwan0", CostPerGB: 2, MonthlyBudget: 10},
+		{Device: "eth0"},
+	}, stateFile)
+	m.sysRoot = t.TempDir()
+	return m, m.sysRoot
+}
+
+func TestModelChargesTrafficToBudget(t *testing.T) {
+	m, sys := testModel(t, "")
+	setCounters(t, sys, "wwan0", 1e9, 1e9)
+	setCounters(t, sys, "eth0", 0, 0)
+	now := time.Date(2026, 10, 30, 12, 0, 0, 0, time.UTC)
+
+	// the traffic before the first sample isn't charged
+	if err := m.Sample(now); err != nil {
+		t.Fatalf("Sample() error = %v", err)
+	}
+	if perGB, over := m.Cost("wwan0"); perGB != 2 || over {
+		t.Fatalf("Cost(wwan0) = %v, %v, want 2 within budget", perGB, over)
+	}
+
+	setCounters(t, sys, "wwan0", 3e9, 2e9)
+	if err := m.Sample(now.Add(time.Minute)); err != nil {
+		t.Fatalf("Sample() error = %v", err)
+	}
+	spends := m.Spends()
+	if len(spends) != 2 || spends[1].Device != "wwan0" || spends[1].GBTransferred != 3 || spends[1].Spend != 6 {
+		t.Fatalf("Spends() = %+v, want 3 GB for 6 over wwan0", spends)
+	}
+
+	setCounters(t, sys, "wwan0", 4e9, 3e9)
+	if err := m.Sample(now.Add(2 * time.Minute)); err != nil {
+		t.Fatalf("Sample() error = %v", err)
+	}
+	if _, over := m.Cost("wwan0"); !over {
+		t.Errorf("wwan0 within budget after spending 10")
+	}
+
+	// a reset counter is charged from zero
+	setCounters(t, sys, "wwan0", 0, 1e8)
+	if err := m.Sample(now.Add(3 * time.Minute)); err != nil {
+		t.Fatalf("Sample() error = %v", err)
+	}
+	if spend := m.Spends()[1]; spend.GBTransferred != 5.1 {
+		t.Errorf("transferred %v GB after a counter reset, want 5.1", spend.GBTransferred)
+	}
+
+	// the budget is renewed with the month
+	setCounters(t, sys, "wwan0", 0, 2e8)
+	if err := m.Sample(time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatalf("Sample() error = %v", err)
+	}
+	if _, over := m.Cost("wwan0"); over {
+		t.Errorf("wwan0 over budget in a new month")
+	}
+	if spend := m.Spends()[1]; spend.Month != "2026-11" || spend.Spend != 0 {
+		t.Errorf("spend = %+v, want none in 2026-11", spend)
+	}
+
+	// devices without cost metadata are free
+	if perGB, over := m.Cost("eth1"); perGB != 0 || over {
+		t.Errorf("Cost(eth1) = %v, %v, want free", perGB, over)
+	}
+}
+
+func TestModelPersistsUsage(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "uplink-costs.json")
+	m, sys := testModel(t, stateFile)
+	setCounters(t, sys, "wwan0", 0, 0)
+	setCounters(t, sys, "eth0", 0, 0)
+	now := time.Now()
+	if err := m.Sample(now); err != nil {
+		t.Fatal(err)
+	}
+	setCounters(t, sys, "wwan0", 6e9, 0)
+	if err := m.Sample(now); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.save(); err != nil {
+		t.Fatalf("save() error = %v", err)
+	}
+
+	restarted, _ := testModel(t, stateFile)
+	if err := restarted.Load(); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if _, over := restarted.Cost("wwan0"); !over {
+		t.Errorf("spend of the month lost on restart")
+	}
+}
@@ -0,0 +1,194 @@
+package damping
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	// flapsTotal counts the flaps of the connections
+	flapsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "nsm_connection_flaps_total",
+		Help: "Number of times an established connection went down",
+	})
+
+	// suppressedConnections is the number of connections whose setup is suppressed
+	suppressedConnections = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "nsm_connection_flap_suppressed",
+		Help: "Number of connections whose setup is suppressed by flap damping",
+	})
+)
+
+func init() {
+	crmetrics.Registry.MustRegister(flapsTotal, suppressedConnections)
+}
+
+// Config holds the damping parameters, with the semantics of BGP route
+// flap damping (RFC 2439)
+type Config struct {
+	// Penalty added each time an established connection goes down
+	Penalty float64
+	// Penalty above which the setup of the connection is suppressed
+	SuppressThreshold float64
+	// Penalty below which a suppressed connection is set up again
+	ReuseThreshold float64
+	// Time for the penalty to decay to half
+	HalfLife time.Duration
+	// Maximum time a connection stays suppressed after its last flap
+	MaxSuppress time.Duration
+}
+
+// DefaultConfig returns the damping parameters of common BGP implementations
+func DefaultConfig() Config {
+	return Config{
+		Penalty:           1000,
+		SuppressThreshold: 2000,
+		ReuseThreshold:    750,
+		HalfLife:          15 * time.Minute,
+		MaxSuppress:       time.Hour,
+	}
+}
+
+// entry is the damping state of a connection
+type entry struct {
+	// Penalty at the time it was last updated
+	penalty float64
+	// Time the penalty was last updated
+	updated time.Time
+	// Whether the connection was established when last observed
+	up bool
+	// Whether the setup of the connection is suppressed
+	suppressed bool
+}
+
+// Damper suppresses the setup of connections going up and down in rapid
+// succession. Every time an established connection goes down its penalty
+// grows, and decays exponentially over time. Once it crosses the suppress
+// threshold the connection is held down until the penalty decayed below
+// the reuse threshold, instead of thrashing the datapath and the API
+// server.
+type Damper struct {
+	// Damping parameters
+	cfg Config
+	// Penalty ceiling, bounding the suppression to MaxSuppress
+	ceiling float64
+	// Damping state by connection
+	entries map[string]*entry
+	// Mutex for protecting the state
+	mu sync.Mutex
+}
+
+// NewDamper creates a new flap damper
+func NewDamper(cfg Config) *Damper {
+	return &Damper{
+		cfg:     cfg,
+		ceiling: cfg.ReuseThreshold * math.Exp2(float64(cfg.MaxSuppress)/float64(cfg.HalfLife)),
+		entries: make(map[string]*entry),
+	}
+}
+
+// SetConfig changes the damping parameters. The penalties accumulated
+// are kept, and the suppressed connections are released once they decay
+// below the new reuse threshold.
+func (d *Damper) SetConfig(cfg Config) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.cfg = cfg
+	d.ceiling = cfg.ReuseThreshold * math.Exp2(float64(cfg.MaxSuppress)/float64(cfg.HalfLife))
+}
+
+// Observe records whether a connection is established, adding a penalty
+// when it went down since the last observation. It returns whether the
+// connection flapped.
+func (d *Damper) Observe(key string, up bool, now time.Time) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	e, ok := d.entries[key]
+	if !ok {
+		d.entries[key] = &entry{up: up, updated: now}
+		return false
+	}
+	flapped := e.up && !up
+	e.up = up
+	if !flapped {
+		return false
+	}
+
+	flapsTotal.Inc()
+	e.penalty = math.Min(d.decayed(e, now)+d.cfg.Penalty, d.ceiling)
+	e.updated = now
+	if e.penalty >= d.cfg.SuppressThreshold {
+		d.setSuppressed(e, true)
+	}
+	return true
+}
+
+// Suppressed tells whether the setup of a connection is suppressed, and
+// if so how long until it may be set up again
+func (d *Damper) Suppressed(key string, now time.Time) (bool, time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	e, ok := d.entries[key]
+	if !ok || !e.suppressed {
+		return false, 0
+	}
+	penalty := d.decayed(e, now)
+	if penalty < d.cfg.ReuseThreshold {
+		d.setSuppressed(e, false)
+		return false, 0
+	}
+	reuse := time.Duration(float64(d.cfg.HalfLife) * math.Log2(penalty/d.cfg.ReuseThreshold))
+	// round up so the connection is retried once the penalty decayed
+	return true, reuse.Truncate(time.Second) + time.Second
+}
+
+// Penalty returns the current penalty of a connection
+func (d *Damper) Penalty(key string, now time.Time) float64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	e, ok := d.entries[key]
+	if !ok {
+		return 0
+	}
+	return d.decayed(e, now)
+}
+
+// Forget drops the state of a connection, e.g. once it was deleted
+func (d *Damper) Forget(key string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if e, ok := d.entries[key]; ok {
+		d.setSuppressed(e, false)
+		delete(d.entries, key)
+	}
+}
+
+// decayed returns the penalty of an entry decayed to now
+func (d *Damper) decayed(e *entry, now time.Time) float64 {
+	elapsed := now.Sub(e.updated)
+	if elapsed <= 0 {
+		return e.penalty
+	}
+	return e.penalty * math.Exp2(-float64(elapsed)/float64(d.cfg.HalfLife))
+}
+
+// setSuppressed updates the suppression of an entry and the gauge
+func (d *Damper) setSuppressed(e *entry, suppressed bool) {
+	if e.suppressed == suppressed {
+		return
+	}
+	e.suppressed = suppressed
+	if suppressed {
+		suppressedConnections.Inc()
+	} else {
+		suppressedConnections.Dec()
+	}
+}
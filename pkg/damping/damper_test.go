@@ -0,0 +1,87 @@
+package damping
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDamperSuppressesFlaps(t *testing.T) {
+	d := NewDamper(DefaultConfig())
+	now := time.Now()
+
+	// going up for the first time or staying up is no flap
+	if d.Observe("edge/conn", true, now) || d.Observe("edge/conn", true, now) {
+		t.Fatalf("no flap expected")
+	}
+	if !d.Observe("edge/conn", false, now) {
+		t.Fatalf("going down is a flap")
+	}
+	if suppressed, _ := d.Suppressed("edge/conn", now); suppressed {
+		t.Fatalf("suppressed after a single flap")
+	}
+
+	// the third flap within a half-life crosses the suppress threshold
+	for i := 1; i <= 2; i++ {
+		d.Observe("edge/conn", true, now.Add(time.Duration(i)*time.Minute))
+		d.Observe("edge/conn", false, now.Add(time.Duration(i)*time.Minute))
+		if suppressed, _ := d.Suppressed("edge/conn", now.Add(time.Duration(i)*time.Minute)); suppressed != (i == 2) {
+			t.Fatalf("suppressed = %v after %d flaps with penalty %.0f", suppressed, i+1, d.Penalty("edge/conn", now.Add(time.Duration(i)*time.Minute)))
+		}
+	}
+	last := now.Add(2 * time.Minute)
+	// ~2870 decays to 750 in log2(2870/750) half-lives
+	_, reuse := d.Suppressed("edge/conn", last)
+	if reuse < 28*time.Minute || reuse > 30*time.Minute {
+		t.Errorf("reuse after %s, want ~29m", reuse)
+	}
+
+	// still suppressed below the suppress threshold, until the reuse threshold
+	if suppressed, _ := d.Suppressed("edge/conn", last.Add(20*time.Minute)); !suppressed {
+		t.Errorf("suppression lifted above the reuse threshold")
+	}
+	if suppressed, _ := d.Suppressed("edge/conn", last.Add(reuse)); suppressed {
+		t.Errorf("still suppressed after the reuse time")
+	}
+}
+
+func TestDamperMaxSuppress(t *testing.T) {
+	d := NewDamper(DefaultConfig())
+	now := time.Now()
+
+	// a connection flapping forever is retried at most an hour after its last flap
+	for i := 0; i < 50; i++ {
+		d.Observe("edge/conn", true, now)
+		d.Observe("edge/conn", false, now)
+	}
+	if suppressed, reuse := d.Suppressed("edge/conn", now); !suppressed || reuse > time.Hour+time.Second {
+		t.Errorf("Suppressed() = %v, %s, want at most an hour", suppressed, reuse)
+	}
+
+	d.Forget("edge/conn")
+	if suppressed, _ := d.Suppressed("edge/conn", now); suppressed || d.Penalty("edge/conn", now) != 0 {
+		t.Errorf("forgotten connection still damped")
+	}
+}
+
+func TestDamperSetConfig(t *testing.T) {
+	d := NewDamper(DefaultConfig())
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		d.Observe("edge/conn", true, now)
+		d.Observe("edge/conn", false, now)
+	}
+	if suppressed, _ := d.Suppressed("edge/conn", now); !suppressed {
+		t.Fatalf("not suppressed after 3 flaps")
+	}
+
+	// the penalty is kept, a higher reuse threshold releases it sooner
+	cfg := DefaultConfig()
+	cfg.ReuseThreshold = 3500
+	d.SetConfig(cfg)
+	if d.Penalty("edge/conn", now) != 3000 {
+		t.Errorf("penalty = %.0f, want 3000 kept", d.Penalty("edge/conn", now))
+	}
+	if suppressed, _ := d.Suppressed("edge/conn", now); suppressed {
+		t.Errorf("still suppressed below the new reuse threshold")
+	}
+}
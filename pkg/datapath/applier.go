@@ -0,0 +1,217 @@
+package datapath
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ErrNotFound is returned by backends for objects missing on the host
+var ErrNotFound = errors.New("object not found")
+
+// Backend observes and programs host networking objects
+type Backend interface {
+	// Get returns the observed state of the object, or ErrNotFound
+	Get(ctx context.Context, obj Object) (Object, error)
+	// Create creates the object
+	Create(ctx context.Context, obj Object) error
+	// Update brings an existing object to the desired state
+	Update(ctx context.Context, obj Object) error
+	// Delete removes the object, returning ErrNotFound if it doesn't exist
+	Delete(ctx context.Context, obj Object) error
+}
+
+// Plan lists the changes an apply made
+type Plan struct {
+	// Objects that were created
+	Create []Object
+	// Objects that were updated
+	Update []Object
+	// Objects that were deleted
+	Delete []Object
+}
+
+// Empty reports whether the plan has no changes
+func (p *Plan) Empty() bool {
+	return len(p.Create) == 0 && len(p.Update) == 0 && len(p.Delete) == 0
+}
+
+// Applier converges host networking objects to a desired state. Every
+// apply observes the host first, so it fixes whatever partial state an
+// earlier failure or an operator left behind.
+type Applier struct {
+	// Backend programming the host
+	backend Backend
+	// Logger
+	logger *logrus.Logger
+	// Objects applied per owner, to remove the ones no longer desired
+	owned map[string]map[string]Object
+	// Mutex for protecting the owned objects
+	mu sync.Mutex
+}
+
+// NewApplier creates a new applier on top of the backend
+func NewApplier(backend Backend, logger *logrus.Logger) *Applier {
+	return &Applier{
+		backend: backend,
+		logger:  logger,
+		owned:   make(map[string]map[string]Object),
+	}
+}
+
+// Apply converges the objects of the owner (e.g., a connection) to the
+// desired ones. Objects the owner had before but no longer desires are
+// deleted, unless another owner still has them. Applying the same state
+// again is a no-op.
+func (a *Applier) Apply(ctx context.Context, owner string, desired []Object) (*Plan, error) {
+	return a.converge(ctx, owner, desired, true)
+}
+
+// Plan returns the changes applying the desired objects of the owner
+// would make, without making them
+func (a *Applier) Plan(ctx context.Context, owner string, desired []Object) (*Plan, error) {
+	return a.converge(ctx, owner, desired, false)
+}
+
+// converge observes the objects of the owner and plans the changes,
+// making them on the host only with apply
+func (a *Applier) converge(ctx context.Context, owner string, desired []Object, apply bool) (*Plan, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	plan := &Plan{}
+	previous := a.owned[owner]
+	current := make(map[string]Object, len(desired))
+
+	// keep tracking the previous objects until they are really removed
+	defer func() {
+		if !apply {
+			return
+		}
+		if len(current) == 0 {
+			delete(a.owned, owner)
+			return
+		}
+		a.owned[owner] = current
+	}()
+
+	for _, obj := range sortObjects(desired, false) {
+		current[obj.Key()] = obj
+
+		observed, err := a.backend.Get(ctx, obj)
+		switch {
+		case errors.Is(err, ErrNotFound):
+			if apply {
+				if err := a.backend.Create(ctx, obj); err != nil {
+					a.keep(previous, current)
+					return plan, fmt.Errorf("failed to create %s: %w", obj.Key(), err)
+				}
+			}
+			plan.Create = append(plan.Create, obj)
+		case err != nil:
+			a.keep(previous, current)
+			return plan, fmt.Errorf("failed to observe %s: %w", obj.Key(), err)
+		case !obj.InSync(observed):
+			if apply {
+				if err := a.backend.Update(ctx, obj); err != nil {
+					a.keep(previous, current)
+					return plan, fmt.Errorf("failed to update %s: %w", obj.Key(), err)
+				}
+			}
+			plan.Update = append(plan.Update, obj)
+		}
+	}
+
+	var stale []Object
+	for key, obj := range previous {
+		// objects shared with other owners (e.g., a VLAN interface) stay
+		// until the last one no longer desires them
+		if _, ok := current[key]; !ok && !a.sharedWith(owner, key) {
+			stale = append(stale, obj)
+		}
+	}
+	// within a kind in reverse key order, so the children of a class
+	// (e.g., "1:10" of "1:1") go before it
+	sort.Slice(stale, func(i, j int) bool { return stale[i].Key() > stale[j].Key() })
+	for _, obj := range sortObjects(stale, true) {
+		if apply {
+			if err := a.backend.Delete(ctx, obj); err != nil && !errors.Is(err, ErrNotFound) {
+				a.keep(previous, current)
+				return plan, fmt.Errorf("failed to delete %s: %w", obj.Key(), err)
+			}
+		}
+		plan.Delete = append(plan.Delete, obj)
+	}
+
+	if apply && !plan.Empty() {
+		a.logger.Debugf("Applied %s: %d created, %d updated, %d deleted", owner, len(plan.Create), len(plan.Update), len(plan.Delete))
+	}
+	return plan, nil
+}
+
+// Remove deletes all objects of the owner
+func (a *Applier) Remove(ctx context.Context, owner string) error {
+	_, err := a.Apply(ctx, owner, nil)
+	return err
+}
+
+// Release deletes all objects of the owner like Remove. The applier only
+// remembers what it applied since it started, so for an owner it doesn't
+// know (e.g., a connection deleted while the controller restarted) the
+// objects the owner desires, rebuilt by the caller from its spec, are
+// deleted instead. Shared objects of owners not applied again yet may go
+// with them; applying those owners again recreates them.
+func (a *Applier) Release(ctx context.Context, owner string, desired []Object) error {
+	a.adopt(owner, desired)
+	return a.Remove(ctx, owner)
+}
+
+// adopt makes the objects those of an owner the applier doesn't know
+func (a *Applier) adopt(owner string, objs []Object) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, ok := a.owned[owner]; ok || len(objs) == 0 {
+		return
+	}
+	owned := make(map[string]Object, len(objs))
+	for _, obj := range objs {
+		owned[obj.Key()] = obj
+	}
+	a.owned[owner] = owned
+}
+
+// sharedWith reports whether another owner has an object with the key
+func (a *Applier) sharedWith(owner, key string) bool {
+	for other, objs := range a.owned {
+		if _, ok := objs[key]; ok && other != owner {
+			return true
+		}
+	}
+	return false
+}
+
+// keep adds the previous objects to the current ones, so a failed apply
+// doesn't forget about objects it didn't get to delete
+func (a *Applier) keep(previous, current map[string]Object) {
+	for key, obj := range previous {
+		if _, ok := current[key]; !ok {
+			current[key] = obj
+		}
+	}
+}
+
+// sortObjects orders the objects by kind, reversed for deletion
+func sortObjects(objs []Object, reverse bool) []Object {
+	sorted := append([]Object(nil), objs...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if reverse {
+			return sorted[i].Kind() > sorted[j].Kind()
+		}
+		return sorted[i].Kind() < sorted[j].Kind()
+	})
+	return sorted
+}
@@ -0,0 +1,237 @@
+package datapath
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+// memBackend keeps the host objects in memory
+type memBackend struct {
+	objects map[string]Object
+	calls   []string
+	failKey string
+}
+
+func newMemBackend() *memBackend {
+	return &memBackend{objects: make(map[string]Object)}
+}
+
+func (m *memBackend) Get(ctx context.Context, obj Object) (Object, error) {
+	observed, ok := m.objects[obj.Key()]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return observed, nil
+}
+
+func (m *memBackend) Create(ctx context.Context, obj Object) error {
+	if obj.Key() == m.failKey {
+		return errors.New("boom")
+	}
+	m.calls = append(m.calls, "create "+obj.Key())
+	m.objects[obj.Key()] = obj
+	return nil
+}
+
+func (m *memBackend) Update(ctx context.Context, obj Object) error {
+	m.calls = append(m.calls, "update "+obj.Key())
+	m.objects[obj.Key()] = obj
+	return nil
+}
+
+func (m *memBackend) Delete(ctx context.Context, obj Object) error {
+	if _, ok := m.objects[obj.Key()]; !ok {
+		return ErrNotFound
+	}
+	m.calls = append(m.calls, "delete "+obj.Key())
+	delete(m.objects, obj.Key())
+	return nil
+}
+
+func desiredState(mtu int) []Object {
+	return []Object{
+		Route{Destination: "10.0.0.0/24", Device: "nsm0", Metric: 100},
+		Address{Device: "nsm0", CIDR: "10.1.0.1/30"},
+		Link{Name: "nsm0", Type: "dummy", MTU: mtu, Up: true},
+		Qdisc{Device: "nsm0", Type: "fq_codel"},
+		NftRule{Family: "inet", Table: "nsm", Chain: "forward", Name: "conn", Rule: "ip daddr 10.0.0.0/24 accept"},
+	}
+}
+
+func TestApplierConverges(t *testing.T) {
+	backend := newMemBackend()
+	a := NewApplier(backend, logrus.New())
+
+	plan, err := a.Apply(context.Background(), "conn", desiredState(1500))
+	if err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if len(plan.Create) != 5 {
+		t.Fatalf("created %d objects, want 5", len(plan.Create))
+	}
+	// dependencies first: the link before its address and route
+	if backend.calls[0] != "create link/nsm0" || backend.calls[1] != "create address/nsm0/10.1.0.1/30" {
+		t.Errorf("objects not created in dependency order: %v", backend.calls)
+	}
+
+	// applying the same state again changes nothing
+	backend.calls = nil
+	plan, err = a.Apply(context.Background(), "conn", desiredState(1500))
+	if err != nil || !plan.Empty() || len(backend.calls) != 0 {
+		t.Errorf("second apply not a no-op: %+v %v (%v)", plan, backend.calls, err)
+	}
+
+	// drift is corrected
+	backend.objects["route/10.0.0.0/24/100"] = Route{Destination: "10.0.0.0/24", Device: "eth0", Metric: 100}
+	backend.calls = nil
+	if _, err := a.Apply(context.Background(), "conn", desiredState(9000)); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if len(backend.calls) != 2 || backend.calls[0] != "update link/nsm0" || backend.calls[1] != "update route/10.0.0.0/24/100" {
+		t.Errorf("unexpected calls for drift: %v", backend.calls)
+	}
+}
+
+func TestApplierRecoversPartialState(t *testing.T) {
+	backend := newMemBackend()
+	backend.failKey = "route/10.0.0.0/24/100"
+	a := NewApplier(backend, logrus.New())
+
+	if _, err := a.Apply(context.Background(), "conn", desiredState(1500)); err == nil {
+		t.Fatalf("expected apply to fail")
+	}
+
+	// the retry only creates what is missing
+	backend.failKey = ""
+	backend.calls = nil
+	plan, err := a.Apply(context.Background(), "conn", desiredState(1500))
+	if err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if len(plan.Create) != 3 || plan.Create[0].Kind() != KindRoute {
+		t.Errorf("unexpected plan after partial state: %v", backend.calls)
+	}
+}
+
+func TestApplierDeletesStaleObjects(t *testing.T) {
+	backend := newMemBackend()
+	a := NewApplier(backend, logrus.New())
+	other := []Object{Link{Name: "other0", Type: "dummy", Up: true}}
+
+	if _, err := a.Apply(context.Background(), "conn", desiredState(1500)); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if _, err := a.Apply(context.Background(), "other", other); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+
+	// objects removed from the desired state are deleted, dependents first
+	backend.calls = nil
+	if _, err := a.Apply(context.Background(), "conn", desiredState(1500)[2:3]); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	want := []string{"delete nft/inet/nsm/forward/conn", "delete qdisc/nsm0/root", "delete route/10.0.0.0/24/100", "delete address/nsm0/10.1.0.1/30"}
+	if len(backend.calls) != len(want) {
+		t.Fatalf("calls = %v, want %v", backend.calls, want)
+	}
+	for i := range want {
+		if backend.calls[i] != want[i] {
+			t.Errorf("calls = %v, want %v", backend.calls, want)
+			break
+		}
+	}
+
+	// removing an owner leaves the objects of others alone
+	if err := a.Remove(context.Background(), "conn"); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	if len(backend.objects) != 1 || backend.objects["link/other0"] == nil {
+		t.Errorf("unexpected objects after remove: %v", backend.objects)
+	}
+}
+
+func TestApplierPlanChangesNothing(t *testing.T) {
+	backend := newMemBackend()
+	a := NewApplier(backend, logrus.New())
+	if _, err := a.Apply(context.Background(), "conn", desiredState(1500)[2:4]); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+
+	// the MTU changes, a route is added and the qdisc dropped
+	backend.calls = nil
+	plan, err := a.Plan(context.Background(), "conn", []Object{desiredState(9000)[0], desiredState(9000)[2]})
+	if err != nil {
+		t.Fatalf("Plan() error = %v", err)
+	}
+	if len(plan.Create) != 1 || plan.Create[0].Key() != "route/10.0.0.0/24/100" ||
+		len(plan.Update) != 1 || plan.Update[0].Key() != "link/nsm0" ||
+		len(plan.Delete) != 1 || plan.Delete[0].Key() != "qdisc/nsm0/root" {
+		t.Errorf("unexpected plan %+v", plan)
+	}
+	if len(backend.calls) != 0 {
+		t.Errorf("planning changed the host: %v", backend.calls)
+	}
+
+	// the owned objects are untouched, so planning again gives the same plan
+	again, err := a.Plan(context.Background(), "conn", []Object{desiredState(9000)[0], desiredState(9000)[2]})
+	if err != nil || len(again.Delete) != 1 {
+		t.Errorf("second plan = %+v (%v), want the qdisc still deleted", again, err)
+	}
+}
+
+func TestApplierKeepsSharedObjects(t *testing.T) {
+	backend := newMemBackend()
+	a := NewApplier(backend, logrus.New())
+	ctx := context.Background()
+	shared := Link{Name: "nsm0.100", Type: "vlan", Up: true}
+
+	if _, err := a.Apply(ctx, "a", []Object{shared, Address{Device: "nsm0.100", CIDR: "10.0.0.1/24"}}); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if _, err := a.Apply(ctx, "b", []Object{shared}); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+
+	if err := a.Remove(ctx, "a"); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	if _, ok := backend.objects[shared.Key()]; !ok {
+		t.Errorf("shared link removed with the first owner")
+	}
+	if _, ok := backend.objects["address/nsm0.100/10.0.0.1/24"]; ok {
+		t.Errorf("address of the removed owner kept")
+	}
+
+	if err := a.Remove(ctx, "b"); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	if _, ok := backend.objects[shared.Key()]; ok {
+		t.Errorf("shared link kept after the last owner")
+	}
+}
+
+func TestApplierReleasesUnknownOwner(t *testing.T) {
+	backend := newMemBackend()
+	ctx := context.Background()
+	if _, err := NewApplier(backend, logrus.New()).Apply(ctx, "a", desiredState(1500)); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+
+	// a restarted applier doesn't know what the owner applied
+	restarted := NewApplier(backend, logrus.New())
+	if err := restarted.Remove(ctx, "a"); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	if len(backend.objects) != len(desiredState(1500)) {
+		t.Fatalf("unknown owner removed objects")
+	}
+	if err := restarted.Release(ctx, "a", desiredState(1500)); err != nil {
+		t.Fatalf("Release() error = %v", err)
+	}
+	if len(backend.objects) != 0 {
+		t.Errorf("objects of the released owner kept: %v", backend.objects)
+	}
+}
@@ -0,0 +1,239 @@
+package datapath
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/connection"
+)
+
+// Planner previews the host changes setting up a connection would make
+type Planner interface {
+	// Plan returns the changes Setup would make, without making them
+	Plan(ctx context.Context, conn *nsmv1.NetworkConnection) (*Plan, error)
+}
+
+// FallbackDatapath serves connections assigned a macvlan or ipvlan
+// fallback (nodes without SR-IOV, e.g. USB or onboard NICs) with a
+// sub-interface of the uplink, and passes all others to the next datapath
+type FallbackDatapath struct {
+	// Applier programming the sub-interfaces
+	applier *Applier
+	// Uplink the sub-interfaces are created on
+	uplink string
+	// Datapath for all other connections
+	next connection.Datapath
+}
+
+// NewFallbackDatapath creates a new fallback datapath
+func NewFallbackDatapath(applier *Applier, uplink string, next connection.Datapath) *FallbackDatapath {
+	return &FallbackDatapath{
+		applier: applier,
+		uplink:  uplink,
+		next:    next,
+	}
+}
+
+// Setup implements connection.Datapath
+func (d *FallbackDatapath) Setup(ctx context.Context, conn *nsmv1.NetworkConnection) error {
+	if !isFallback(conn) {
+		return d.next.Setup(ctx, conn)
+	}
+	if d.uplink == "" {
+		return fmt.Errorf("no uplink for the %s fallback datapath, set fallbackUplink (NSM_FALLBACK_UPLINK)", conn.Status.Datapath)
+	}
+
+	objs, err := fallbackObjects(conn, d.uplink)
+	if err != nil {
+		return err
+	}
+	if _, err := d.applier.Apply(ctx, fallbackOwner(conn), objs); err != nil {
+		return fmt.Errorf("failed to set up %s fallback: %w", conn.Status.Datapath, err)
+	}
+	return nil
+}
+
+// Plan implements Planner. Connections passed to a next datapath that
+// can't plan get an empty plan.
+func (d *FallbackDatapath) Plan(ctx context.Context, conn *nsmv1.NetworkConnection) (*Plan, error) {
+	if !isFallback(conn) {
+		if next, ok := d.next.(Planner); ok {
+			return next.Plan(ctx, conn)
+		}
+		return &Plan{}, nil
+	}
+	if d.uplink == "" {
+		return nil, fmt.Errorf("no uplink for the %s fallback datapath, set fallbackUplink (NSM_FALLBACK_UPLINK)", conn.Status.Datapath)
+	}
+	objs, err := fallbackObjects(conn, d.uplink)
+	if err != nil {
+		return nil, err
+	}
+	return d.applier.Plan(ctx, fallbackOwner(conn), objs)
+}
+
+// Teardown implements connection.Datapath. The sub-interface holds no
+// scarce resources, so it is removed even when allocations are kept.
+func (d *FallbackDatapath) Teardown(ctx context.Context, conn *nsmv1.NetworkConnection, keepAllocations bool) error {
+	if !isFallback(conn) {
+		return d.next.Teardown(ctx, conn, keepAllocations)
+	}
+	// a spec the objects can't be built from was never applied
+	objs, _ := fallbackObjects(conn, d.uplink)
+	return d.applier.Release(ctx, fallbackOwner(conn), objs)
+}
+
+// FallbackLink returns the sub-interface serving a fallback connection,
+// created on the uplink or the VLAN sub-interface of the connection
+func FallbackLink(conn *nsmv1.NetworkConnection, parent string) Link {
+	link := Link{
+		Name:   FallbackLinkName(conn),
+		Type:   conn.Status.Datapath,
+		Parent: parent,
+		Up:     true,
+	}
+	if link.Type == nsmv1.DatapathIPvlan {
+		link.Mode = "l2"
+	} else {
+		link.Mode = "bridge"
+	}
+	return link
+}
+
+// ProxyNeighbors returns the proxy entries answering for the remote
+// endpoints of a connection on its sub-interface. The kernel only answers
+// for proxy entries on forwarding interfaces, and for IPv6 ones with NDP
+// proxying enabled; unlike proxy_arp, only the listed addresses are
+// answered for, so the segment doesn't learn the whole remote network.
+func ProxyNeighbors(conn *nsmv1.NetworkConnection) ([]Object, error) {
+	if len(conn.Spec.ProxyNeighbors) == 0 {
+		return nil, nil
+	}
+	dev := FallbackLinkName(conn)
+	var objs []Object
+	var v4, v6 bool
+	for _, addr := range conn.Spec.ProxyNeighbors {
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid proxy neighbor %q, must be an IP address", addr)
+		}
+		if ip.To4() != nil {
+			v4 = true
+		} else {
+			v6 = true
+		}
+		objs = append(objs, NeighProxy{Device: dev, Address: ip.String()})
+	}
+	if v4 {
+		objs = append(objs, Sysctl{Name: "net/ipv4/conf/" + dev + "/forwarding", Value: "1", Reset: "0"})
+	}
+	if v6 {
+		objs = append(objs,
+			Sysctl{Name: "net/ipv6/conf/" + dev + "/forwarding", Value: "1", Reset: "0"},
+			Sysctl{Name: "net/ipv6/conf/" + dev + "/proxy_ndp", Value: "1", Reset: "0"},
+		)
+	}
+	return objs, nil
+}
+
+// VLANLinks returns the VLAN sub-interfaces tagging the traffic of a
+// connection, outermost first, and the interface its sub-interface is
+// created on. With an inner VID the outer tag is an 802.1ad service tag
+// (QinQ). The links are named after their tags, so connections of the
+// same service share them.
+func VLANLinks(conn *nsmv1.NetworkConnection, uplink string) ([]Object, string, error) {
+	vlan := conn.Spec.VLAN
+	if vlan == nil {
+		return nil, uplink, nil
+	}
+	if vlan.OuterVID < 1 || vlan.OuterVID > 4094 {
+		return nil, "", fmt.Errorf("invalid outer VLAN ID %d, must be between 1 and 4094", vlan.OuterVID)
+	}
+	if vlan.InnerVID < 0 || vlan.InnerVID > 4094 {
+		return nil, "", fmt.Errorf("invalid inner VLAN ID %d, must be between 1 and 4094 or 0 for a single tag", vlan.InnerVID)
+	}
+
+	outer := Link{Type: "vlan", Parent: uplink, VLAN: vlan.OuterVID, Up: true}
+	if vlan.InnerVID == 0 {
+		outer.Name = VLANLinkName(uplink, "", vlan.OuterVID)
+		return []Object{outer}, outer.Name, nil
+	}
+	outer.VLANProtocol = "802.1ad"
+	outer.Name = VLANLinkName(uplink, outer.VLANProtocol, vlan.OuterVID)
+	inner := Link{
+		Name:   VLANLinkName(outer.Name, "", vlan.InnerVID),
+		Type:   "vlan",
+		Parent: outer.Name,
+		VLAN:   vlan.InnerVID,
+		Up:     true,
+	}
+	return []Object{outer, inner}, inner.Name, nil
+}
+
+// VLANLinkName returns a stable name for a VLAN sub-interface, within the
+// 15 character limit of the kernel
+func VLANLinkName(parent, protocol string, vid int) string {
+	sum := sha256.Sum256([]byte(parent + "." + protocol + "." + strconv.Itoa(vid)))
+	return "nsmvl" + hex.EncodeToString(sum[:4])
+}
+
+// fallbackObjects returns the host objects serving a fallback connection
+func fallbackObjects(conn *nsmv1.NetworkConnection, uplink string) ([]Object, error) {
+	objs, parent, err := VLANLinks(conn, uplink)
+	if err != nil {
+		return nil, err
+	}
+	proxies, err := ProxyNeighbors(conn)
+	if err != nil {
+		return nil, err
+	}
+	link := FallbackLink(conn, parent)
+	objs = append(objs, link)
+	if budget := conn.Status.LatencyBudget; budget != nil {
+		objs = append(objs, LatencyQdisc(link.Name, conn.Spec, budget))
+	}
+	return append(objs, proxies...), nil
+}
+
+// LatencyQdisc returns the root qdisc of a link holding its queue within
+// the NIC queueing budget of a connection: bandwidth limited connections
+// are paced with tbf, dropping what would wait longer than the budget,
+// others get fq_codel targeting the budget as queueing delay
+func LatencyQdisc(device string, spec nsmv1.NetworkConnectionSpec, budget *nsmv1.LatencyBudget) Qdisc {
+	if spec.Bandwidth > 0 {
+		// a burst of 1ms of traffic at the limit, at least a full frame
+		burst := max(spec.Bandwidth*125, 1600)
+		return Qdisc{Device: device, Type: "tbf", Params: []string{
+			"rate", strconv.Itoa(spec.Bandwidth) + "mbit",
+			"burst", strconv.Itoa(burst),
+			"latency", strconv.Itoa(budget.NICQueueingUs) + "us",
+		}}
+	}
+	// the interval covers a round trip at the requirement
+	return Qdisc{Device: device, Type: "fq_codel", Params: []string{
+		"target", strconv.Itoa(budget.NICQueueingUs) + "us",
+		"interval", strconv.Itoa(2*spec.LatencyRequirement) + "ms",
+	}}
+}
+
+// FallbackLinkName returns a stable interface name for the connection,
+// within the 15 character limit of the kernel
+func FallbackLinkName(conn *nsmv1.NetworkConnection) string {
+	sum := sha256.Sum256([]byte(conn.Namespace + "/" + conn.Name))
+	return "nsmfb" + hex.EncodeToString(sum[:4])
+}
+
+// isFallback reports whether the connection is served by a fallback datapath
+func isFallback(conn *nsmv1.NetworkConnection) bool {
+	return conn.Status.Datapath == nsmv1.DatapathMacvlan || conn.Status.Datapath == nsmv1.DatapathIPvlan
+}
+
+// fallbackOwner returns the applier owner of a connection
+func fallbackOwner(conn *nsmv1.NetworkConnection) string {
+	return "connection/" + conn.Namespace + "/" + conn.Name
+}
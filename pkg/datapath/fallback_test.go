@@ -0,0 +1,256 @@
+package datapath
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/connection"
+	"github.com/akos011221/nsm/pkg/netutil"
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// countingDatapath counts the calls passed to it
+type countingDatapath struct {
+	connection.NopDatapath
+	setups int
+}
+
+func (c *countingDatapath) Setup(ctx context.Context, conn *nsmv1.NetworkConnection) error {
+	c.setups++
+	return nil
+}
+
+func fallbackConnection(datapath string) *nsmv1.NetworkConnection {
+	return &nsmv1.NetworkConnection{
+		ObjectMeta: metav1.ObjectMeta{Name: "conn", Namespace: "edge"},
+		Spec:       nsmv1.NetworkConnectionSpec{ConnectionType: nsmv1.ConnectionTypeSRIOV},
+		Status:     nsmv1.NetworkConnectionStatus{Datapath: datapath, NonAccelerated: true},
+	}
+}
+
+func TestFallbackDatapath(t *testing.T) {
+	nl := netutil.NewFake()
+	nl.Links["usb0"] = netutil.Link{Name: "usb0", Type: "device", Up: true}
+	next := &countingDatapath{}
+	d := NewFallbackDatapath(NewApplier(NewNetlinkBackend(nl, newMemBackend()), logrus.New()), "usb0", next)
+
+	conn := fallbackConnection(nsmv1.DatapathIPvlan)
+	if err := d.Setup(context.Background(), conn); err != nil {
+		t.Fatalf("Setup() error = %v", err)
+	}
+
+	name := FallbackLinkName(conn)
+	link, ok := nl.Links[name]
+	if !ok || link.Type != "ipvlan" || link.Parent != "usb0" || link.Mode != "l2" || !link.Up {
+		t.Errorf("unexpected fallback link %+v", link)
+	}
+	if len(name) > 15 {
+		t.Errorf("link name %s exceeds the kernel limit", name)
+	}
+
+	// setting up again converges without errors
+	if err := d.Setup(context.Background(), conn); err != nil {
+		t.Fatalf("second Setup() error = %v", err)
+	}
+
+	if err := d.Teardown(context.Background(), conn, true); err != nil {
+		t.Fatalf("Teardown() error = %v", err)
+	}
+	if _, ok := nl.Links[name]; ok {
+		t.Errorf("fallback link not removed")
+	}
+
+	// accelerated connections go to the next datapath
+	accelerated := fallbackConnection(nsmv1.ConnectionTypeSRIOV)
+	if err := d.Setup(context.Background(), accelerated); err != nil || next.setups != 1 {
+		t.Errorf("accelerated connection not delegated: %v", err)
+	}
+}
+
+func TestFallbackDatapathTeardownAfterRestart(t *testing.T) {
+	nl := netutil.NewFake()
+	nl.Links["usb0"] = netutil.Link{Name: "usb0", Type: "device", Up: true}
+	conn := fallbackConnection(nsmv1.DatapathMacvlan)
+	if err := NewFallbackDatapath(NewApplier(NewNetlinkBackend(nl, newMemBackend()), logrus.New()), "usb0", &countingDatapath{}).Setup(context.Background(), conn); err != nil {
+		t.Fatalf("Setup() error = %v", err)
+	}
+
+	// the sub-interface is rebuilt from the spec by a restarted controller
+	restarted := NewFallbackDatapath(NewApplier(NewNetlinkBackend(nl, newMemBackend()), logrus.New()), "usb0", &countingDatapath{})
+	if err := restarted.Teardown(context.Background(), conn, false); err != nil {
+		t.Fatalf("Teardown() error = %v", err)
+	}
+	if _, ok := nl.Links[FallbackLinkName(conn)]; ok {
+		t.Errorf("fallback link leaked after a restart")
+	}
+}
+
+func TestFallbackDatapathWithoutUplink(t *testing.T) {
+	d := NewFallbackDatapath(NewApplier(newMemBackend(), logrus.New()), "", connection.NopDatapath{})
+	if err := d.Setup(context.Background(), fallbackConnection(nsmv1.DatapathMacvlan)); err == nil {
+		t.Errorf("expected error without an uplink")
+	}
+}
+
+func TestFallbackDatapathPlan(t *testing.T) {
+	nl := netutil.NewFake()
+	nl.Links["usb0"] = netutil.Link{Name: "usb0", Type: "device", Up: true}
+	d := NewFallbackDatapath(NewApplier(NewNetlinkBackend(nl, newMemBackend()), logrus.New()), "usb0", &countingDatapath{})
+
+	conn := fallbackConnection(nsmv1.DatapathMacvlan)
+	plan, err := d.Plan(context.Background(), conn)
+	if err != nil {
+		t.Fatalf("Plan() error = %v", err)
+	}
+	if len(plan.Create) != 1 || plan.Create[0].Key() != "link/"+FallbackLinkName(conn) {
+		t.Errorf("unexpected plan %+v", plan)
+	}
+	if _, ok := nl.Links[FallbackLinkName(conn)]; ok {
+		t.Errorf("planning created the fallback link")
+	}
+
+	// next datapaths that can't plan have nothing to show
+	plan, err = d.Plan(context.Background(), fallbackConnection(nsmv1.ConnectionTypeSRIOV))
+	if err != nil || !plan.Empty() {
+		t.Errorf("plan of an accelerated connection = %+v (%v), want empty", plan, err)
+	}
+}
+
+func TestFallbackDatapathProxyNeighbors(t *testing.T) {
+	nl := netutil.NewFake()
+	nl.Links["usb0"] = netutil.Link{Name: "usb0", Type: "device", Up: true}
+	host := newMemBackend()
+	d := NewFallbackDatapath(NewApplier(NewNetlinkBackend(nl, host), logrus.New()), "usb0", &countingDatapath{})
+	ctx := context.Background()
+
+	conn := fallbackConnection(nsmv1.DatapathMacvlan)
+	conn.Spec.ProxyNeighbors = []string{"192.168.50.10", "fd00::10"}
+	if err := d.Setup(ctx, conn); err != nil {
+		t.Fatalf("Setup() error = %v", err)
+	}
+	dev := FallbackLinkName(conn)
+	for _, key := range []string{
+		"neigh-proxy/" + dev + "/192.168.50.10",
+		"neigh-proxy/" + dev + "/fd00::10",
+		"sysctl/net/ipv4/conf/" + dev + "/forwarding",
+		"sysctl/net/ipv6/conf/" + dev + "/forwarding",
+		"sysctl/net/ipv6/conf/" + dev + "/proxy_ndp",
+	} {
+		if _, ok := host.objects[key]; !ok {
+			t.Errorf("%s not programmed, have %v", key, host.calls)
+		}
+	}
+	// the proxy entries need their interface
+	if nl.Links[dev].Name == "" {
+		t.Errorf("fallback link not created")
+	}
+
+	// dropping the IPv6 neighbor resets NDP proxying, IPv4 stays
+	conn.Spec.ProxyNeighbors = []string{"192.168.50.10"}
+	if err := d.Setup(ctx, conn); err != nil {
+		t.Fatalf("Setup() error = %v", err)
+	}
+	if _, ok := host.objects["sysctl/net/ipv6/conf/"+dev+"/proxy_ndp"]; ok {
+		t.Errorf("NDP proxying kept without IPv6 neighbors")
+	}
+	if _, ok := host.objects["neigh-proxy/"+dev+"/192.168.50.10"]; !ok {
+		t.Errorf("IPv4 proxy entry removed")
+	}
+
+	conn.Spec.ProxyNeighbors = []string{"printer.local"}
+	if err := d.Setup(ctx, conn); err == nil {
+		t.Errorf("expected an error for a proxy neighbor that isn't an address")
+	}
+
+	if err := d.Teardown(ctx, conn, false); err != nil {
+		t.Fatalf("Teardown() error = %v", err)
+	}
+	if len(host.objects) != 0 {
+		t.Errorf("objects left after teardown: %v", host.objects)
+	}
+}
+
+func TestFallbackDatapathQinQ(t *testing.T) {
+	nl := netutil.NewFake()
+	nl.Links["usb0"] = netutil.Link{Name: "usb0", Type: "device", Up: true}
+	d := NewFallbackDatapath(NewApplier(NewNetlinkBackend(nl, newMemBackend()), logrus.New()), "usb0", &countingDatapath{})
+	ctx := context.Background()
+
+	qinq := func(name string, inner int) *nsmv1.NetworkConnection {
+		conn := fallbackConnection(nsmv1.DatapathMacvlan)
+		conn.Name = name
+		conn.Spec.VLAN = &nsmv1.VLANSpec{OuterVID: 100, InnerVID: inner}
+		return conn
+	}
+	a, b := qinq("plc-a", 10), qinq("plc-b", 20)
+	for _, conn := range []*nsmv1.NetworkConnection{a, b} {
+		if err := d.Setup(ctx, conn); err != nil {
+			t.Fatalf("Setup() error = %v", err)
+		}
+	}
+
+	outer := VLANLinkName("usb0", "802.1ad", 100)
+	if link := nl.Links[outer]; link.Parent != "usb0" || link.VLAN != 100 || link.VLANProtocol != "802.1ad" {
+		t.Fatalf("unexpected service VLAN link %+v", link)
+	}
+	inner := VLANLinkName(outer, "", 10)
+	if link := nl.Links[inner]; link.Parent != outer || link.VLAN != 10 || link.VLANProtocol != "" {
+		t.Errorf("unexpected customer VLAN link %+v", link)
+	}
+	if link := nl.Links[FallbackLinkName(a)]; link.Parent != inner {
+		t.Errorf("sub-interface created on %s, want the customer VLAN %s", link.Parent, inner)
+	}
+
+	// the service VLAN stays while the other connection uses it
+	if err := d.Teardown(ctx, a, false); err != nil {
+		t.Fatalf("Teardown() error = %v", err)
+	}
+	if _, ok := nl.Links[inner]; ok {
+		t.Errorf("customer VLAN link left after its connection")
+	}
+	if _, ok := nl.Links[outer]; !ok {
+		t.Errorf("service VLAN link removed while still used")
+	}
+
+	// a single tag is a plain 802.1Q VLAN, distinct from the service VLAN
+	single := fallbackConnection(nsmv1.DatapathMacvlan)
+	single.Spec.VLAN = &nsmv1.VLANSpec{OuterVID: 100}
+	objs, parent, err := VLANLinks(single, "usb0")
+	if err != nil || len(objs) != 1 || parent == outer || objs[0].(Link).VLANProtocol != "" {
+		t.Errorf("unexpected single tag links %+v on %s (%v)", objs, parent, err)
+	}
+
+	single.Spec.VLAN.InnerVID = 4095
+	if err := d.Setup(ctx, single); err == nil {
+		t.Errorf("expected an error for an inner VLAN ID out of range")
+	}
+}
+
+func TestFallbackDatapathLatencyQueue(t *testing.T) {
+	nl := netutil.NewFake()
+	nl.Links["usb0"] = netutil.Link{Name: "usb0", Type: "device", Up: true}
+	host := newMemBackend()
+	d := NewFallbackDatapath(NewApplier(NewNetlinkBackend(nl, host), logrus.New()), "usb0", &countingDatapath{})
+
+	conn := fallbackConnection(nsmv1.DatapathMacvlan)
+	conn.Spec.LatencyRequirement = 10
+	conn.Spec.Bandwidth = 50
+	conn.Status.LatencyBudget = &nsmv1.LatencyBudget{NICQueueingUs: 1000, HostStackUs: 30, WANUs: 8970}
+	if err := d.Setup(context.Background(), conn); err != nil {
+		t.Fatalf("Setup() error = %v", err)
+	}
+	qdisc, ok := host.objects["qdisc/"+FallbackLinkName(conn)+"/root"].(Qdisc)
+	want := []string{"rate", "50mbit", "burst", "6250", "latency", "1000us"}
+	if !ok || qdisc.Type != "tbf" || !reflect.DeepEqual(qdisc.Params, want) {
+		t.Errorf("unexpected queue %+v, want tbf %v", qdisc, want)
+	}
+
+	// without a bandwidth limit fq_codel targets the budget
+	conn.Spec.Bandwidth = 0
+	if q := LatencyQdisc("nsm0", conn.Spec, conn.Status.LatencyBudget); q.Type != "fq_codel" || q.Params[1] != "1000us" || q.Params[3] != "20ms" {
+		t.Errorf("unexpected queue %+v", q)
+	}
+}
@@ -0,0 +1,722 @@
+package datapath
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// CommandRunner runs a command and returns its combined output
+type CommandRunner func(ctx context.Context, name string, args ...string) ([]byte, error)
+
+// InputRunner runs a command with its standard input and returns its
+// combined output
+type InputRunner func(ctx context.Context, input []byte, name string, args ...string) ([]byte, error)
+
+// HostBackend is a Backend driving iproute2, tc and nft
+type HostBackend struct {
+	// Runs the commands, replaceable for tests
+	run CommandRunner
+	// Runs the commands taking secrets on their standard input, replaceable
+	// for tests
+	runInput InputRunner
+}
+
+// NewHostBackend creates a new backend running the host tools
+func NewHostBackend() *HostBackend {
+	return &HostBackend{run: runCommand, runInput: runCommandInput}
+}
+
+// runCommand runs a command on the host
+func runCommand(ctx context.Context, name string, args ...string) ([]byte, error) {
+	out, err := exec.CommandContext(ctx, name, args...).CombinedOutput()
+	if err != nil {
+		return out, fmt.Errorf("%s %s failed: %w: %s", name, strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return out, nil
+}
+
+// runCommandInput runs a command on the host with its standard input, the
+// input is left out of the error
+func runCommandInput(ctx context.Context, input []byte, name string, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdin = bytes.NewReader(input)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return out, fmt.Errorf("%s %s failed: %w: %s", name, strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return out, nil
+}
+
+// redactKey removes a key from the text of an error, which ends up in the
+// status of the connection and the logs
+func redactKey(err error, key string) error {
+	if err == nil || key == "" || !strings.Contains(err.Error(), key) {
+		return err
+	}
+	return errors.New(strings.ReplaceAll(err.Error(), key, "<redacted>"))
+}
+
+// Get implements Backend
+func (b *HostBackend) Get(ctx context.Context, obj Object) (Object, error) {
+	switch o := obj.(type) {
+	case Link:
+		return b.getLink(ctx, o)
+	case Address:
+		return b.getAddress(ctx, o)
+	case Route:
+		return b.getRoute(ctx, o)
+	case RouteRule:
+		return b.getRouteRule(ctx, o)
+	case Qdisc:
+		return b.getQdisc(ctx, o)
+	case Class:
+		return b.getClass(ctx, o)
+	case Filter:
+		return b.getFilter(ctx, o)
+	case BridgeSnooping:
+		return b.getBridgeSnooping(ctx, o)
+	case MulticastGroup:
+		return b.getMulticastGroup(ctx, o)
+	case NftChain:
+		return b.getNftChain(ctx, o)
+	case Sysctl:
+		return b.getSysctl(ctx, o)
+	case NeighProxy:
+		return b.getNeighProxy(ctx, o)
+	case XfrmState:
+		return b.getXfrmState(ctx, o)
+	case XfrmPolicy:
+		return b.getXfrmPolicy(ctx, o)
+	case NftRule:
+		rule, _, err := b.getNftRule(ctx, o)
+		return rule, err
+	default:
+		return nil, fmt.Errorf("unsupported object %T", obj)
+	}
+}
+
+// Create implements Backend
+func (b *HostBackend) Create(ctx context.Context, obj Object) error {
+	switch o := obj.(type) {
+	case Link:
+		args := []string{"link", "add", o.Name}
+		if o.Parent != "" {
+			args = append(args, "link", o.Parent)
+		}
+		args = append(append(args, "type", o.Type), linkTypeArgs(o)...)
+		if _, err := b.run(ctx, "ip", args...); err != nil {
+			return err
+		}
+		return b.setLink(ctx, o)
+	case Address:
+		_, err := b.run(ctx, "ip", "addr", "add", o.CIDR, "dev", o.Device)
+		return err
+	case NeighProxy:
+		_, err := b.run(ctx, "ip", "neigh", "add", "proxy", o.Address, "dev", o.Device)
+		return err
+	case MulticastGroup:
+		// autojoin makes the kernel send the membership reports
+		_, err := b.run(ctx, "ip", "addr", "add", o.cidr(), "dev", o.Device, "autojoin")
+		return err
+	case NftChain:
+		if _, err := b.run(ctx, "nft", "add", "table", o.Family, o.Table); err != nil {
+			return err
+		}
+		_, err := b.run(ctx, "nft", "add", "chain", o.Family, o.Table, o.Name,
+			"{", "type", "filter", "hook", o.Hook, "priority", strconv.Itoa(o.Priority), ";", "}")
+		return err
+	case RouteRule:
+		args := append(ruleFamily(o), "rule", "add", "fwmark", fmt.Sprintf("%#x", o.Mark), "lookup", strconv.Itoa(o.Table))
+		_, err := b.run(ctx, "ip", append(args, "pref", strconv.Itoa(o.Priority))...)
+		return err
+	case NftRule:
+		args := append([]string{"add", "rule", o.Family, o.Table, o.Chain}, strings.Fields(o.Rule)...)
+		_, err := b.run(ctx, "nft", append(args, "comment", strconv.Quote(o.comment()))...)
+		return err
+	case XfrmState:
+		// the arguments of a process are readable by every local user, so
+		// the command carrying the key is passed on the standard input
+		args := append([]string{"xfrm", "state", "add"}, xfrmStateID(o)...)
+		args = append(args, "reqid", strconv.Itoa(o.Reqid), "mode", "transport", "aead", "rfc4106(gcm(aes))", "0x"+o.AEADKey, "128")
+		if o.OffloadDevice != "" {
+			args = append(args, "offload", "dev", o.OffloadDevice, "dir", o.OffloadDir)
+		}
+		_, err := b.runInput(ctx, []byte(strings.Join(args, " ")+"\n"), "ip", "-batch", "-")
+		return redactKey(err, o.AEADKey)
+	default:
+		// routes, qdiscs, classes, filters, sysctls and policies are
+		// created with replace
+		return b.Update(ctx, obj)
+	}
+}
+
+// Update implements Backend
+func (b *HostBackend) Update(ctx context.Context, obj Object) error {
+	switch o := obj.(type) {
+	case Link:
+		return b.setLink(ctx, o)
+	case Address, NeighProxy, MulticastGroup, NftChain:
+		// an existing address, proxy entry, group or chain is always in sync
+		return nil
+	case BridgeSnooping:
+		return b.setBridgeSnooping(ctx, o.Bridge, o.Enabled)
+	case Sysctl:
+		_, err := b.run(ctx, "sysctl", "-w", o.Name+"="+o.Value)
+		return err
+	case Route:
+		_, err := b.run(ctx, "ip", append([]string{"route", "replace"}, routeArgs(o)...)...)
+		return err
+	case Qdisc:
+		args := append([]string{"qdisc", "replace", "dev", o.Device}, qdiscParent(o)...)
+		if o.Handle != "" {
+			args = append(args, "handle", o.Handle)
+		}
+		if o.Parent != "ingress" {
+			// the ingress qdisc is selected by its parent
+			args = append(args, o.Type)
+		}
+		_, err := b.run(ctx, "tc", append(args, o.Params...)...)
+		return err
+	case Class:
+		args := []string{"class", "replace", "dev", o.Device, "parent", o.Parent, "classid", o.ID, o.Type}
+		if _, err := b.run(ctx, "tc", append(args, o.Params...)...); err != nil {
+			return err
+		}
+		if o.Leaf == "" {
+			return nil
+		}
+		_, err := b.run(ctx, "tc", "qdisc", "replace", "dev", o.Device, "parent", o.ID, "handle", o.leafHandle(), o.Leaf)
+		return err
+	case Filter:
+		// flower filters are only replaced with a handle
+		args := append([]string{"filter", "replace", "dev", o.Device}, filterParent(o)...)
+		args = append(args, "pref", strconv.Itoa(o.Pref), "protocol", o.protocol(), "handle", "1")
+		_, err := b.run(ctx, "tc", append(append(args, o.Match...), o.Actions...)...)
+		return err
+	case XfrmPolicy:
+		args := []string{"xfrm", "policy", "update", "src", o.Src, "dst", o.Dst, "dir", o.Dir,
+			"tmpl", "src", o.TmplSrc, "dst", o.TmplDst, "proto", "esp"}
+		if o.SPI != 0 {
+			args = append(args, "spi", fmt.Sprintf("%#x", o.SPI))
+		}
+		_, err := b.run(ctx, "ip", append(args, "reqid", strconv.Itoa(o.Reqid), "mode", "transport")...)
+		return err
+	case NftRule, RouteRule, XfrmState:
+		// rules and the offload of associations can't be replaced in place
+		if err := b.Delete(ctx, o); err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		return b.Create(ctx, o)
+	default:
+		return fmt.Errorf("unsupported object %T", obj)
+	}
+}
+
+// Delete implements Backend
+func (b *HostBackend) Delete(ctx context.Context, obj Object) error {
+	if _, err := b.Get(ctx, obj); err != nil {
+		return err
+	}
+
+	var err error
+	switch o := obj.(type) {
+	case Link:
+		_, err = b.run(ctx, "ip", "link", "del", o.Name)
+	case Address:
+		_, err = b.run(ctx, "ip", "addr", "del", o.CIDR, "dev", o.Device)
+	case Route:
+		_, err = b.run(ctx, "ip", append([]string{"route", "del"}, routeArgs(o)...)...)
+	case RouteRule:
+		_, err = b.run(ctx, "ip", append(ruleFamily(o), "rule", "del", "fwmark", fmt.Sprintf("%#x", o.Mark))...)
+	case Qdisc:
+		_, err = b.run(ctx, "tc", append([]string{"qdisc", "del", "dev", o.Device}, qdiscParent(o)...)...)
+	case Class:
+		// the leaf qdisc goes with the class
+		_, err = b.run(ctx, "tc", "class", "del", "dev", o.Device, "classid", o.ID)
+	case Filter:
+		_, err = b.run(ctx, "tc", append(append([]string{"filter", "del", "dev", o.Device}, filterParent(o)...), "pref", strconv.Itoa(o.Pref))...)
+	case Sysctl:
+		// kernel parameters can't be removed, only reset
+		if o.Reset != "" {
+			_, err = b.run(ctx, "sysctl", "-w", o.Name+"="+o.Reset)
+		}
+	case NeighProxy:
+		_, err = b.run(ctx, "ip", "neigh", "del", "proxy", o.Address, "dev", o.Device)
+	case XfrmState:
+		_, err = b.run(ctx, "ip", append([]string{"xfrm", "state", "delete"}, xfrmStateID(o)...)...)
+	case XfrmPolicy:
+		_, err = b.run(ctx, "ip", "xfrm", "policy", "delete", "src", o.Src, "dst", o.Dst, "dir", o.Dir)
+	case BridgeSnooping:
+		// back to the kernel default
+		err = b.setBridgeSnooping(ctx, o.Bridge, true)
+	case MulticastGroup:
+		_, err = b.run(ctx, "ip", "addr", "del", o.cidr(), "dev", o.Device)
+	case NftChain:
+		_, err = b.run(ctx, "nft", "delete", "chain", o.Family, o.Table, o.Name)
+	case NftRule:
+		_, handle, getErr := b.getNftRule(ctx, o)
+		if getErr != nil {
+			return getErr
+		}
+		_, err = b.run(ctx, "nft", "delete", "rule", o.Family, o.Table, o.Chain, "handle", strconv.Itoa(handle))
+	}
+	return err
+}
+
+// linkTypeArgs returns the type specific arguments of `ip link add`
+func linkTypeArgs(l Link) []string {
+	var args []string
+	switch l.Type {
+	case "veth":
+		if l.PeerName != "" {
+			args = append(args, "peer", "name", l.PeerName)
+		}
+	case "vxlan":
+		args = append(args, "id", strconv.Itoa(l.VNI))
+		if l.Remote != "" {
+			args = append(args, "remote", l.Remote)
+		}
+		if l.Port > 0 {
+			args = append(args, "dstport", strconv.Itoa(l.Port))
+		}
+	case "vlan":
+		if l.VLANProtocol != "" {
+			args = append(args, "protocol", l.VLANProtocol)
+		}
+		args = append(args, "id", strconv.Itoa(l.VLAN))
+	case "macvlan", "ipvlan":
+		if l.Mode != "" {
+			args = append(args, "mode", l.Mode)
+		}
+	}
+	return args
+}
+
+// qdiscParent returns the tc arguments selecting the parent of a qdisc
+func qdiscParent(q Qdisc) []string {
+	if q.parent() == "root" || q.Parent == "ingress" {
+		return []string{q.parent()}
+	}
+	return []string{"parent", q.Parent}
+}
+
+// filterParent returns the tc arguments selecting the parent of a filter
+func filterParent(f Filter) []string {
+	if f.Parent == "ingress" {
+		return []string{"ingress"}
+	}
+	return []string{"parent", f.Parent}
+}
+
+// setLink applies the MTU and administrative state of a link
+func (b *HostBackend) setLink(ctx context.Context, l Link) error {
+	args := []string{"link", "set", l.Name}
+	if l.MTU > 0 {
+		args = append(args, "mtu", strconv.Itoa(l.MTU))
+	}
+	if l.Up {
+		args = append(args, "up")
+	} else {
+		args = append(args, "down")
+	}
+	_, err := b.run(ctx, "ip", args...)
+	return err
+}
+
+// getLink observes a link with `ip -j -d link show`
+func (b *HostBackend) getLink(ctx context.Context, l Link) (Object, error) {
+	out, err := b.run(ctx, "ip", "-j", "-d", "link", "show", "dev", l.Name)
+	if err != nil {
+		if strings.Contains(string(out), "does not exist") {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	var links []struct {
+		Name     string   `json:"ifname"`
+		MTU      int      `json:"mtu"`
+		Flags    []string `json:"flags"`
+		LinkInfo struct {
+			Kind string `json:"info_kind"`
+		} `json:"linkinfo"`
+	}
+	if err := json.Unmarshal(out, &links); err != nil {
+		return nil, fmt.Errorf("failed to parse link %s: %w", l.Name, err)
+	}
+	if len(links) == 0 {
+		return nil, ErrNotFound
+	}
+
+	observed := Link{Name: links[0].Name, Type: links[0].LinkInfo.Kind, MTU: links[0].MTU}
+	for _, flag := range links[0].Flags {
+		if flag == "UP" {
+			observed.Up = true
+		}
+	}
+	return observed, nil
+}
+
+// getAddress observes an address with `ip -j addr show`
+func (b *HostBackend) getAddress(ctx context.Context, a Address) (Object, error) {
+	out, err := b.run(ctx, "ip", "-j", "addr", "show", "dev", a.Device)
+	if err != nil {
+		if strings.Contains(string(out), "does not exist") {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	var links []struct {
+		AddrInfo []struct {
+			Local     string `json:"local"`
+			PrefixLen int    `json:"prefixlen"`
+		} `json:"addr_info"`
+	}
+	if err := json.Unmarshal(out, &links); err != nil {
+		return nil, fmt.Errorf("failed to parse addresses of %s: %w", a.Device, err)
+	}
+	for _, link := range links {
+		for _, addr := range link.AddrInfo {
+			if fmt.Sprintf("%s/%d", addr.Local, addr.PrefixLen) == a.CIDR {
+				return a, nil
+			}
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// getRoute observes a route with `ip -j route show`
+func (b *HostBackend) getRoute(ctx context.Context, r Route) (Object, error) {
+	args := []string{"-j", "route", "show", "exact", r.Destination}
+	if r.Table != 0 {
+		args = append(args, "table", strconv.Itoa(r.Table))
+	}
+	out, err := b.run(ctx, "ip", args...)
+	if err != nil {
+		return nil, err
+	}
+
+	var routes []struct {
+		Dst      string `json:"dst"`
+		Gateway  string `json:"gateway"`
+		Dev      string `json:"dev"`
+		Metric   int    `json:"metric"`
+		Nexthops []struct {
+			Gateway string `json:"gateway"`
+			Dev     string `json:"dev"`
+			Weight  int    `json:"weight"`
+		} `json:"nexthops"`
+	}
+	if err := json.Unmarshal(out, &routes); err != nil {
+		return nil, fmt.Errorf("failed to parse routes to %s: %w", r.Destination, err)
+	}
+	for _, route := range routes {
+		if route.Metric != r.Metric {
+			continue
+		}
+		observed := Route{Destination: r.Destination, Device: route.Dev, Gateway: route.Gateway, Metric: route.Metric, Table: r.Table}
+		for _, hop := range route.Nexthops {
+			observed.Nexthops = append(observed.Nexthops, Nexthop{Device: hop.Dev, Gateway: hop.Gateway, Weight: hop.Weight})
+		}
+		return observed, nil
+	}
+	return nil, ErrNotFound
+}
+
+// getRouteRule observes a policy routing rule with `ip -j rule show`
+func (b *HostBackend) getRouteRule(ctx context.Context, r RouteRule) (Object, error) {
+	out, err := b.run(ctx, "ip", append(ruleFamily(r), "-j", "rule", "show", "fwmark", fmt.Sprintf("%#x", r.Mark))...)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []struct {
+		Priority int    `json:"priority"`
+		Table    string `json:"table"`
+	}
+	if err := json.Unmarshal(out, &rules); err != nil {
+		return nil, fmt.Errorf("failed to parse rules of mark %#x: %w", r.Mark, err)
+	}
+	if len(rules) == 0 {
+		return nil, ErrNotFound
+	}
+	// tables without a name in rt_tables are shown by their ID
+	table, _ := strconv.Atoi(rules[0].Table)
+	return RouteRule{Mark: r.Mark, Table: table, Priority: rules[0].Priority, IPv6: r.IPv6}, nil
+}
+
+// ruleFamily returns the address family argument of `ip rule`
+func ruleFamily(r RouteRule) []string {
+	if r.IPv6 {
+		return []string{"-6"}
+	}
+	return []string{"-4"}
+}
+
+// getQdisc observes a qdisc with `tc -j qdisc show`
+func (b *HostBackend) getQdisc(ctx context.Context, q Qdisc) (Object, error) {
+	out, err := b.run(ctx, "tc", "-j", "qdisc", "show", "dev", q.Device)
+	if err != nil {
+		return nil, err
+	}
+
+	var qdiscs []struct {
+		Kind   string `json:"kind"`
+		Root   bool   `json:"root"`
+		Parent string `json:"parent"`
+	}
+	if err := json.Unmarshal(out, &qdiscs); err != nil {
+		return nil, fmt.Errorf("failed to parse qdiscs of %s: %w", q.Device, err)
+	}
+	for _, qdisc := range qdiscs {
+		if (q.parent() == "root" && qdisc.Root) || (q.Parent != "" && qdisc.Parent == q.Parent) ||
+			(q.Parent == "ingress" && qdisc.Kind == "ingress") {
+			return Qdisc{Device: q.Device, Parent: q.Parent, Type: qdisc.Kind}, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// getClass observes a class with `tc -j class show`
+func (b *HostBackend) getClass(ctx context.Context, c Class) (Object, error) {
+	out, err := b.run(ctx, "tc", "-j", "class", "show", "dev", c.Device, "classid", c.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	var classes []struct {
+		Class  string `json:"class"`
+		Handle string `json:"handle"`
+		Parent string `json:"parent"`
+	}
+	if err := json.Unmarshal(out, &classes); err != nil {
+		return nil, fmt.Errorf("failed to parse classes of %s: %w", c.Device, err)
+	}
+	for _, class := range classes {
+		if class.Handle == c.ID {
+			return Class{Device: c.Device, Parent: class.Parent, ID: class.Handle, Type: class.Class}, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// getFilter observes a filter with `tc -j filter show`
+func (b *HostBackend) getFilter(ctx context.Context, f Filter) (Object, error) {
+	args := append(append([]string{"-j", "filter", "show", "dev", f.Device}, filterParent(f)...), "pref", strconv.Itoa(f.Pref))
+	out, err := b.run(ctx, "tc", args...)
+	if err != nil {
+		if strings.Contains(string(out), "Cannot find") {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	var filters []struct {
+		Pref int `json:"pref"`
+	}
+	if err := json.Unmarshal(out, &filters); err != nil {
+		return nil, fmt.Errorf("failed to parse filters of %s: %w", f.Device, err)
+	}
+	for _, filter := range filters {
+		if filter.Pref == f.Pref {
+			return Filter{Device: f.Device, Parent: f.Parent, Pref: f.Pref, Protocol: f.Protocol}, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// setBridgeSnooping enables or disables IGMP/MLD snooping on a bridge
+func (b *HostBackend) setBridgeSnooping(ctx context.Context, bridge string, enabled bool) error {
+	value := "0"
+	if enabled {
+		value = "1"
+	}
+	_, err := b.run(ctx, "ip", "link", "set", "dev", bridge, "type", "bridge", "mcast_snooping", value)
+	return err
+}
+
+// getBridgeSnooping observes the snooping setting of a bridge with
+// `ip -j -d link show`
+func (b *HostBackend) getBridgeSnooping(ctx context.Context, s BridgeSnooping) (Object, error) {
+	out, err := b.run(ctx, "ip", "-j", "-d", "link", "show", "dev", s.Bridge)
+	if err != nil {
+		if strings.Contains(string(out), "does not exist") {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	var links []struct {
+		LinkInfo struct {
+			Kind string `json:"info_kind"`
+			Data struct {
+				Snooping int `json:"mcast_snooping"`
+			} `json:"info_data"`
+		} `json:"linkinfo"`
+	}
+	if err := json.Unmarshal(out, &links); err != nil {
+		return nil, fmt.Errorf("failed to parse link %s: %w", s.Bridge, err)
+	}
+	if len(links) == 0 {
+		return nil, ErrNotFound
+	}
+	if links[0].LinkInfo.Kind != "bridge" {
+		return nil, fmt.Errorf("%s is a %s link, not a bridge", s.Bridge, links[0].LinkInfo.Kind)
+	}
+	return BridgeSnooping{Bridge: s.Bridge, Enabled: links[0].LinkInfo.Data.Snooping == 1}, nil
+}
+
+// getMulticastGroup observes a joined group with `ip -j addr show`
+func (b *HostBackend) getMulticastGroup(ctx context.Context, m MulticastGroup) (Object, error) {
+	if _, err := b.getAddress(ctx, Address{Device: m.Device, CIDR: m.cidr()}); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// getNftChain observes a chain with `nft -j list chain`
+func (b *HostBackend) getNftChain(ctx context.Context, n NftChain) (Object, error) {
+	out, err := b.run(ctx, "nft", "-j", "list", "chain", n.Family, n.Table, n.Name)
+	if err != nil {
+		if strings.Contains(string(out), "No such file or directory") {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return n, nil
+}
+
+// getSysctl reads a kernel parameter with `sysctl -n`
+func (b *HostBackend) getSysctl(ctx context.Context, s Sysctl) (Object, error) {
+	out, err := b.run(ctx, "sysctl", "-n", s.Name)
+	if err != nil {
+		// parameters of an interface that doesn't exist
+		if strings.Contains(string(out), "cannot stat") || strings.Contains(string(out), "No such file") {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return Sysctl{Name: s.Name, Value: strings.TrimSpace(string(out)), Reset: s.Reset}, nil
+}
+
+// getNeighProxy observes a proxy entry with `ip -j neigh show proxy`
+func (b *HostBackend) getNeighProxy(ctx context.Context, n NeighProxy) (Object, error) {
+	out, err := b.run(ctx, "ip", "-j", "neigh", "show", "proxy", "dev", n.Device)
+	if err != nil {
+		if strings.Contains(string(out), "Cannot find device") {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	var entries []struct {
+		Dst string `json:"dst"`
+	}
+	if err := json.Unmarshal(out, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse proxy entries of %s: %w", n.Device, err)
+	}
+	for _, entry := range entries {
+		if entry.Dst == n.Address {
+			return n, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// xfrmStateID returns the iproute2 arguments identifying an association
+func xfrmStateID(x XfrmState) []string {
+	return []string{"src", x.Src, "dst", x.Dst, "proto", "esp", "spi", fmt.Sprintf("%#x", x.SPI)}
+}
+
+var (
+	// xfrmReqid matches the request ID in `ip xfrm` output
+	xfrmReqid = regexp.MustCompile(`reqid (\d+)`)
+	// xfrmOffload matches the offload device of an association
+	xfrmOffload = regexp.MustCompile(`offload parameters: dev (\S+)`)
+	// xfrmTmpl matches the template of a policy
+	xfrmTmpl = regexp.MustCompile(`tmpl src (\S+) dst (\S+)\s+proto esp spi (0x[0-9a-f]+)`)
+)
+
+// getXfrmState observes an association with `ip xfrm state get`, which
+// has no JSON output
+func (b *HostBackend) getXfrmState(ctx context.Context, x XfrmState) (Object, error) {
+	out, err := b.run(ctx, "ip", append([]string{"xfrm", "state", "get"}, xfrmStateID(x)...)...)
+	if err != nil {
+		if strings.Contains(string(out), "No such process") {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	observed := XfrmState{Src: x.Src, Dst: x.Dst, SPI: x.SPI}
+	if m := xfrmReqid.FindSubmatch(out); m != nil {
+		observed.Reqid, _ = strconv.Atoi(string(m[1]))
+	}
+	if m := xfrmOffload.FindSubmatch(out); m != nil {
+		observed.OffloadDevice = string(m[1])
+	}
+	return observed, nil
+}
+
+// getXfrmPolicy observes a policy with `ip xfrm policy get`
+func (b *HostBackend) getXfrmPolicy(ctx context.Context, x XfrmPolicy) (Object, error) {
+	out, err := b.run(ctx, "ip", "xfrm", "policy", "get", "src", x.Src, "dst", x.Dst, "dir", x.Dir)
+	if err != nil {
+		if strings.Contains(string(out), "No such file or directory") {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	observed := XfrmPolicy{Src: x.Src, Dst: x.Dst, Dir: x.Dir}
+	if m := xfrmTmpl.FindSubmatch(out); m != nil {
+		observed.TmplSrc, observed.TmplDst = string(m[1]), string(m[2])
+		spi, _ := strconv.ParseUint(string(m[3]), 0, 32)
+		observed.SPI = uint32(spi)
+	}
+	if m := xfrmReqid.FindSubmatch(out); m != nil {
+		observed.Reqid, _ = strconv.Atoi(string(m[1]))
+	}
+	return observed, nil
+}
+
+// getNftRule finds a rule by its comment with `nft -j -a list chain`,
+// returning the observed rule and its handle
+func (b *HostBackend) getNftRule(ctx context.Context, n NftRule) (Object, int, error) {
+	out, err := b.run(ctx, "nft", "-j", "-a", "list", "chain", n.Family, n.Table, n.Chain)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var ruleset struct {
+		Nftables []struct {
+			Rule *struct {
+				Handle  int    `json:"handle"`
+				Comment string `json:"comment"`
+			} `json:"rule"`
+		} `json:"nftables"`
+	}
+	if err := json.Unmarshal(out, &ruleset); err != nil {
+		return nil, 0, fmt.Errorf("failed to parse chain %s: %w", n.Chain, err)
+	}
+
+	prefix := "nsm:" + n.Name + ":"
+	for _, entry := range ruleset.Nftables {
+		if entry.Rule == nil || !strings.HasPrefix(entry.Rule.Comment, prefix) {
+			continue
+		}
+		observed := n
+		observed.Rule = ""
+		observed.hash = strings.TrimPrefix(entry.Rule.Comment, prefix)
+		return observed, entry.Rule.Handle, nil
+	}
+	return nil, 0, ErrNotFound
+}
@@ -0,0 +1,357 @@
+package datapath
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/akos011221/nsm/pkg/hardware"
+)
+
+// scriptedRunner answers commands with canned outputs
+type scriptedRunner struct {
+	outputs map[string]string
+	calls   []string
+}
+
+func (s *scriptedRunner) run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	cmd := name + " " + strings.Join(args, " ")
+	s.calls = append(s.calls, cmd)
+	for prefix, out := range s.outputs {
+		if strings.HasPrefix(cmd, prefix) {
+			if strings.HasPrefix(out, "error:") {
+				return []byte(strings.TrimPrefix(out, "error:")), errors.New("exit status 1")
+			}
+			return []byte(out), nil
+		}
+	}
+	return nil, nil
+}
+
+// runInput records a command with its input as "<command> < <input>",
+// its errors carry the output like those of runCommandInput
+func (s *scriptedRunner) runInput(ctx context.Context, input []byte, name string, args ...string) ([]byte, error) {
+	out, err := s.run(ctx, name, append(args, "<", strings.TrimSpace(string(input)))...)
+	if err != nil {
+		return out, fmt.Errorf("%s %s failed: %w: %s", name, strings.Join(args, " "), err, out)
+	}
+	return out, nil
+}
+
+func TestHostBackendGet(t *testing.T) {
+	runner := &scriptedRunner{outputs: map[string]string{
+		"ip -j -d link show dev nsm0":              `[{"ifname":"nsm0","mtu":1500,"flags":["BROADCAST","UP"],"linkinfo":{"info_kind":"dummy"}}]`,
+		"ip -j -d link show dev nsm1":              `error:Device "nsm1" does not exist.`,
+		"ip -j addr show dev nsm0":                 `[{"addr_info":[{"local":"10.1.0.1","prefixlen":30}]}]`,
+		"ip -j route show exact 10.0.0.0/24":       `[{"dst":"10.0.0.0/24","dev":"nsm0","gateway":"10.1.0.2","metric":100}]`,
+		"tc -j qdisc show dev nsm0":                `[{"kind":"fq_codel","root":true}]`,
+		"nft -j -a list chain inet nsm forward":    `{"nftables":[{"chain":{}},{"rule":{"handle":7,"comment":"nsm:conn:` + NftRule{Rule: "accept"}.ruleHash() + `"}}]}`,
+		"sysctl -n net/ipv6/conf/nsm0/proxy_ndp":   "1\n",
+		"sysctl -n net/ipv6/conf/nsm1/proxy_ndp":   "error:sysctl: cannot stat /proc/sys/net/ipv6/conf/nsm1/proxy_ndp: No such file or directory",
+		"ip -j neigh show proxy dev nsm0":          `[{"dst":"fd00::10","dev":"nsm0","flags":["proxy"]}]`,
+		"ip -j -d link show dev br0":               `[{"ifname":"br0","linkinfo":{"info_kind":"bridge","info_data":{"mcast_snooping":0}}}]`,
+		"ip -j addr show dev br0":                  `[{"addr_info":[{"local":"239.1.1.1","prefixlen":32}]}]`,
+		"nft -j list chain inet nsm mc-0":          `error:Error: No such file or directory`,
+		"tc -j qdisc show dev vf0":                 `[{"kind":"ingress","handle":"ffff:","parent":"ffff:fff1"}]`,
+		"tc -j filter show dev vf0 ingress pref 1": `[{"protocol":"all","pref":1,"kind":"flower","chain":0}]`,
+		"tc -j filter show dev vf0 ingress pref 2": `[]`,
+		"ip -j route show exact 10.20.0.0/16":      `[{"dst":"10.20.0.0/16","metric":50,"nexthops":[{"gateway":"192.0.2.1","dev":"eth0","weight":3},{"dev":"wwan0","weight":1}]}]`,
+		"ip -j route show exact 10.30.0.0/16":      `[{"dst":"10.30.0.0/16","dev":"eth0","metric":50}]`,
+		"ip -4 -j rule show fwmark 0x10010000":     `[{"priority":1000,"src":"all","fwmark":"0x10010000","table":"268500992"}]`,
+		"ip -4 -j rule show fwmark 0x10020000":     `[]`,
+		"tc -j class show dev eth0 classid 1:10":   `[{"class":"htb","handle":"1:10","parent":"1:1","leaf":"10:"}]`,
+		"tc -j class show dev eth0 classid 1:20":   `[]`,
+	}}
+	b := &HostBackend{run: runner.run}
+	ctx := context.Background()
+
+	link, err := b.Get(ctx, Link{Name: "nsm0"})
+	if err != nil || !(Link{Name: "nsm0", Type: "dummy", MTU: 1500, Up: true}).InSync(link) {
+		t.Errorf("unexpected link %+v (%v)", link, err)
+	}
+	if _, err := b.Get(ctx, Link{Name: "nsm1"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing link error = %v, want ErrNotFound", err)
+	}
+	if _, err := b.Get(ctx, Address{Device: "nsm0", CIDR: "10.1.0.1/30"}); err != nil {
+		t.Errorf("address not found: %v", err)
+	}
+	if _, err := b.Get(ctx, Address{Device: "nsm0", CIDR: "10.1.0.5/30"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("unexpected address found")
+	}
+
+	want := Route{Destination: "10.0.0.0/24", Device: "nsm0", Gateway: "10.1.0.2", Metric: 100}
+	if route, err := b.Get(ctx, want); err != nil || !want.InSync(route) {
+		t.Errorf("unexpected route %+v (%v)", route, err)
+	}
+	if _, err := b.Get(ctx, Route{Destination: "10.0.0.0/24", Metric: 200}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("route with other metric found")
+	}
+	if qdisc, err := b.Get(ctx, Qdisc{Device: "nsm0", Type: "fq_codel"}); err != nil || !(Qdisc{Device: "nsm0", Type: "fq_codel"}).InSync(qdisc) {
+		t.Errorf("unexpected qdisc %+v (%v)", qdisc, err)
+	}
+
+	ndp := Sysctl{Name: "net/ipv6/conf/nsm0/proxy_ndp", Value: "1"}
+	if observed, err := b.Get(ctx, ndp); err != nil || !ndp.InSync(observed) {
+		t.Errorf("unexpected sysctl %+v (%v)", observed, err)
+	}
+	if _, err := b.Get(ctx, Sysctl{Name: "net/ipv6/conf/nsm1/proxy_ndp"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("sysctl of a missing link error = %v, want ErrNotFound", err)
+	}
+	if _, err := b.Get(ctx, NeighProxy{Device: "nsm0", Address: "fd00::10"}); err != nil {
+		t.Errorf("proxy entry not found: %v", err)
+	}
+	if _, err := b.Get(ctx, NeighProxy{Device: "nsm0", Address: "fd00::11"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("unexpected proxy entry found")
+	}
+
+	snooping := BridgeSnooping{Bridge: "br0", Enabled: true}
+	if observed, err := b.Get(ctx, snooping); err != nil || snooping.InSync(observed) {
+		t.Errorf("disabled snooping reported in sync: %+v (%v)", observed, err)
+	}
+	if _, err := b.Get(ctx, BridgeSnooping{Bridge: "nsm0"}); err == nil {
+		t.Errorf("expected an error for snooping on a link that isn't a bridge")
+	}
+	if _, err := b.Get(ctx, MulticastGroup{Device: "br0", Group: "239.1.1.1"}); err != nil {
+		t.Errorf("joined group not found: %v", err)
+	}
+	if _, err := b.Get(ctx, NftChain{Family: "inet", Table: "nsm", Name: "mc-0"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing chain error = %v, want ErrNotFound", err)
+	}
+
+	ingress := Qdisc{Device: "vf0", Parent: "ingress", Type: "ingress"}
+	if observed, err := b.Get(ctx, ingress); err != nil || !ingress.InSync(observed) {
+		t.Errorf("unexpected ingress qdisc %+v (%v)", observed, err)
+	}
+	if _, err := b.Get(ctx, Filter{Device: "vf0", Parent: "ingress", Pref: 1}); err != nil {
+		t.Errorf("filter not found: %v", err)
+	}
+	if _, err := b.Get(ctx, Filter{Device: "vf0", Parent: "ingress", Pref: 2}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing filter error = %v, want ErrNotFound", err)
+	}
+
+	multipath := Route{Destination: "10.20.0.0/16", Metric: 50, Nexthops: []Nexthop{{Device: "eth0", Gateway: "192.0.2.1", Weight: 3}, {Device: "wwan0"}}}
+	if route, err := b.Get(ctx, multipath); err != nil || !multipath.InSync(route) {
+		t.Errorf("unexpected multipath route %+v (%v)", route, err)
+	}
+	multipath.Nexthops[1].Weight = 2
+	if route, _ := b.Get(ctx, multipath); multipath.InSync(route) {
+		t.Errorf("multipath route with other weights reported in sync")
+	}
+	tableRoute := Route{Destination: "10.30.0.0/16", Device: "eth0", Metric: 50, Table: 4096}
+	if route, err := b.Get(ctx, tableRoute); err != nil || !tableRoute.InSync(route) {
+		t.Errorf("unexpected route in table %+v (%v)", route, err)
+	}
+	steering := RouteRule{Mark: 0x10010000, Table: 0x10010000, Priority: 1000}
+	if observed, err := b.Get(ctx, steering); err != nil || !steering.InSync(observed) {
+		t.Errorf("unexpected routing rule %+v (%v)", observed, err)
+	}
+	if _, err := b.Get(ctx, RouteRule{Mark: 0x10020000}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing routing rule error = %v, want ErrNotFound", err)
+	}
+
+	class := Class{Device: "eth0", Parent: "1:1", ID: "1:10", Type: "htb"}
+	if observed, err := b.Get(ctx, class); err != nil || !class.InSync(observed) {
+		t.Errorf("unexpected class %+v (%v)", observed, err)
+	}
+	if _, err := b.Get(ctx, Class{Device: "eth0", ID: "1:20"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing class error = %v, want ErrNotFound", err)
+	}
+
+	rule := NftRule{Family: "inet", Table: "nsm", Chain: "forward", Name: "conn", Rule: "accept"}
+	observed, err := b.Get(ctx, rule)
+	if err != nil || !rule.InSync(observed) {
+		t.Errorf("unexpected rule %+v (%v)", observed, err)
+	}
+	rule.Rule = "drop"
+	if rule.InSync(observed) {
+		t.Errorf("changed rule reported in sync")
+	}
+}
+
+func TestHostBackendCommands(t *testing.T) {
+	runner := &scriptedRunner{outputs: map[string]string{
+		"nft -j -a list chain":           `{"nftables":[{"rule":{"handle":7,"comment":"nsm:conn:0"}}]}`,
+		"sysctl -n":                      "1",
+		"ip -j neigh show proxy dev vx0": `[{"dst":"10.0.0.5"}]`,
+		"ip -4 -j rule show fwmark":      `[{"priority":1000,"table":"4096"}]`,
+		"tc -j class show dev eth0":      `[{"class":"htb","handle":"1:100","parent":"1:1"}]`,
+	}}
+	b := &HostBackend{run: runner.run}
+	ctx := context.Background()
+
+	if err := b.Create(ctx, Link{Name: "vx0", Type: "vxlan", Parent: "eth0", VNI: 42, MTU: 1450, Up: true}); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if err := b.Create(ctx, Link{Name: "vx1", Type: "vlan", Parent: "eth0", VLAN: 100, Up: true}); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if err := b.Create(ctx, Link{Name: "vx2", Type: "vlan", Parent: "eth0", VLAN: 200, VLANProtocol: "802.1ad", Up: true}); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if err := b.Update(ctx, Qdisc{Device: "vx0", Type: "tbf", Params: []string{"rate", "100mbit"}}); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if err := b.Delete(ctx, NftRule{Family: "inet", Table: "nsm", Chain: "forward", Name: "conn"}); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+
+	if err := b.Create(ctx, NeighProxy{Device: "vx0", Address: "10.0.0.6"}); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if err := b.Create(ctx, Sysctl{Name: "net/ipv4/conf/vx0/forwarding", Value: "1", Reset: "0"}); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if err := b.Delete(ctx, Sysctl{Name: "net/ipv4/conf/vx0/forwarding", Value: "1", Reset: "0"}); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if err := b.Delete(ctx, NeighProxy{Device: "vx0", Address: "10.0.0.5"}); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+
+	if err := b.Create(ctx, MulticastGroup{Device: "vx0", Group: "ff3e::1"}); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if err := b.Create(ctx, NftChain{Family: "inet", Table: "nsm", Name: "mc-1", Hook: "prerouting"}); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if err := b.Update(ctx, BridgeSnooping{Bridge: "br0", Enabled: true}); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+
+	for _, obj := range StormControlObjects("vf1", hardware.StormPolicy{BroadcastPPS: 50}) {
+		if err := b.Create(ctx, obj); err != nil {
+			t.Fatalf("Create() error = %v", err)
+		}
+	}
+
+	if err := b.Create(ctx, Route{Destination: "10.20.0.0/16", Metric: 50, Nexthops: []Nexthop{{Device: "eth0", Gateway: "192.0.2.1", Weight: 3}, {Device: "wwan0"}}}); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if err := b.Create(ctx, Route{Destination: "10.20.0.0/16", Device: "wwan0", Metric: 50, Table: 4096}); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if err := b.Create(ctx, RouteRule{Mark: 4096, Table: 4096, Priority: 1000, IPv6: true}); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if err := b.Delete(ctx, RouteRule{Mark: 4096, Table: 4096, Priority: 1000}); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+
+	if err := b.Create(ctx, Qdisc{Device: "eth0", Handle: "1:", Type: "htb", Params: []string{"default", "30"}}); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if err := b.Create(ctx, Class{Device: "eth0", Parent: "1:1", ID: "1:100", Type: "htb", Params: []string{"rate", "20mbit"}, Leaf: "fq_codel"}); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if err := b.Delete(ctx, Class{Device: "eth0", Parent: "1:1", ID: "1:100", Type: "htb"}); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+
+	want := []string{
+		"tc qdisc replace dev eth0 root handle 1: htb default 30",
+		"tc class replace dev eth0 parent 1:1 classid 1:100 htb rate 20mbit",
+		"tc qdisc replace dev eth0 parent 1:100 handle 100: fq_codel",
+		"tc class del dev eth0 classid 1:100",
+		"ip route replace 10.20.0.0/16 metric 50 nexthop via 192.0.2.1 dev eth0 weight 3 nexthop dev wwan0 weight 1",
+		"ip route replace 10.20.0.0/16 dev wwan0 metric 50 table 4096",
+		"ip -6 rule add fwmark 0x1000 lookup 4096 pref 1000",
+		"ip -4 rule del fwmark 0x1000",
+		"tc qdisc replace dev vf1 ingress",
+		"tc filter replace dev vf1 ingress pref 1 protocol all handle 1 flower dst_mac ff:ff:ff:ff:ff:ff action police pkts_rate 50 pkts_burst 50 conform-exceed drop/ok",
+		"ip addr add ff3e::1/128 dev vx0 autojoin",
+		"nft add table inet nsm",
+		"nft add chain inet nsm mc-1 { type filter hook prerouting priority 0 ; }",
+		"ip link set dev br0 type bridge mcast_snooping 1",
+		"ip link add vx1 link eth0 type vlan id 100",
+		"ip link add vx2 link eth0 type vlan protocol 802.1ad id 200",
+		"ip neigh add proxy 10.0.0.6 dev vx0",
+		"sysctl -w net/ipv4/conf/vx0/forwarding=1",
+		"sysctl -w net/ipv4/conf/vx0/forwarding=0",
+		"ip neigh del proxy 10.0.0.5 dev vx0",
+		"ip link add vx0 link eth0 type vxlan id 42",
+		"ip link set vx0 mtu 1450 up",
+		"tc qdisc replace dev vx0 root tbf rate 100mbit",
+		"nft delete rule inet nsm forward handle 7",
+	}
+	for _, cmd := range want {
+		found := false
+		for _, call := range runner.calls {
+			found = found || call == cmd
+		}
+		if !found {
+			t.Errorf("command %q not run, calls: %v", cmd, runner.calls)
+		}
+	}
+}
+
+func TestHostBackendXfrm(t *testing.T) {
+	runner := &scriptedRunner{outputs: map[string]string{
+		"ip xfrm state get src 10.0.0.1 dst 10.0.0.2 proto esp spi 0x1234": "src 10.0.0.1 dst 10.0.0.2\n\tproto esp spi 0x00001234 reqid 7 mode transport\n" +
+			"\treplay-window 0\n\taead rfc4106(gcm(aes)) 0x00 128\n\tcrypto offload parameters: dev eth0 dir out mode crypto\n",
+		"ip xfrm state get src 10.0.0.2": "error:RTNETLINK answers: No such process",
+		"ip xfrm policy get src 10.0.0.1/32 dst 10.0.0.2/32 dir out": "src 10.0.0.1/32 dst 10.0.0.2/32\n\tdir out priority 0\n" +
+			"\ttmpl src 10.0.0.1 dst 10.0.0.2\n\t\tproto esp spi 0x00001234 reqid 7 mode transport\n",
+		"ip xfrm policy get src 10.0.0.2/32": "error:RTNETLINK answers: No such file or directory",
+	}}
+	b := &HostBackend{run: runner.run, runInput: runner.runInput}
+	ctx := context.Background()
+
+	state := XfrmState{Src: "10.0.0.1", Dst: "10.0.0.2", SPI: 0x1234, Reqid: 7, AEADKey: "00", OffloadDevice: "eth0", OffloadDir: "out"}
+	observed, err := b.Get(ctx, state)
+	if err != nil || !state.InSync(observed) || observed.(XfrmState).Reqid != 7 {
+		t.Errorf("Get(state) = %+v, %v", observed, err)
+	}
+	if _, err := b.Get(ctx, XfrmState{Src: "10.0.0.2", Dst: "10.0.0.1", SPI: 0x5678}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get(missing state) error = %v, want ErrNotFound", err)
+	}
+	policy := XfrmPolicy{Src: "10.0.0.1/32", Dst: "10.0.0.2/32", Dir: "out", TmplSrc: "10.0.0.1", TmplDst: "10.0.0.2", SPI: 0x1234, Reqid: 7}
+	if observed, err := b.Get(ctx, policy); err != nil || !policy.InSync(observed) {
+		t.Errorf("Get(policy) = %+v, %v", observed, err)
+	}
+	if _, err := b.Get(ctx, XfrmPolicy{Src: "10.0.0.2/32", Dst: "10.0.0.1/32", Dir: "in"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get(missing policy) error = %v, want ErrNotFound", err)
+	}
+
+	runner.calls = nil
+	if err := b.Create(ctx, state); err != nil {
+		t.Fatalf("Create(state) error = %v", err)
+	}
+	if err := b.Create(ctx, policy); err != nil {
+		t.Fatalf("Create(policy) error = %v", err)
+	}
+	if err := b.Delete(ctx, state); err != nil {
+		t.Fatalf("Delete(state) error = %v", err)
+	}
+	want := []string{
+		"ip -batch - < xfrm state add src 10.0.0.1 dst 10.0.0.2 proto esp spi 0x1234 reqid 7 mode transport aead rfc4106(gcm(aes)) 0x00 128 offload dev eth0 dir out",
+		"ip xfrm policy update src 10.0.0.1/32 dst 10.0.0.2/32 dir out tmpl src 10.0.0.1 dst 10.0.0.2 proto esp spi 0x1234 reqid 7 mode transport",
+		"ip xfrm state get src 10.0.0.1 dst 10.0.0.2 proto esp spi 0x1234",
+		"ip xfrm state delete src 10.0.0.1 dst 10.0.0.2 proto esp spi 0x1234",
+	}
+	if strings.Join(runner.calls, "\n") != strings.Join(want, "\n") {
+		t.Errorf("commands = %q, want %q", runner.calls, want)
+	}
+}
+
+func TestHostBackendXfrmKeepsKeyOutOfErrors(t *testing.T) {
+	key := "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef01234567"
+	runner := &scriptedRunner{outputs: map[string]string{
+		"ip -batch -": "error:Error: argument \"0x" + key + "\" is wrong: too long\nCommand failed -:1",
+	}}
+	b := &HostBackend{run: runner.run, runInput: runner.runInput}
+
+	err := b.Create(context.Background(), XfrmState{Src: "10.0.0.1", Dst: "10.0.0.2", SPI: 0x1234, Reqid: 7, AEADKey: key})
+	if err == nil {
+		t.Fatalf("Create() succeeded")
+	}
+	if strings.Contains(err.Error(), key) {
+		t.Errorf("key in the error %q", err)
+	}
+	for _, call := range runner.calls {
+		if cmd, _, _ := strings.Cut(call, " < "); strings.Contains(cmd, key) {
+			t.Errorf("key in the arguments of %q", cmd)
+		}
+	}
+}
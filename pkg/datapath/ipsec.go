@@ -0,0 +1,242 @@
+package datapath
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/connection"
+	"github.com/akos011221/nsm/pkg/keys"
+)
+
+// KeySource reads and rotates the tunnel key of an encrypted connection,
+// implemented by keys.Store
+type KeySource interface {
+	// Key returns the key of the connection from its key Secret, failing
+	// with keys.ErrNoKey when there is none
+	Key(ctx context.Context, conn *nsmv1.NetworkConnection) ([]byte, error)
+	// Rekey replaces the key of the connection with a fresh one
+	Rekey(ctx context.Context, conn *nsmv1.NetworkConnection) error
+}
+
+// IPsecDatapath encrypts the traffic of connections with IPsec encryption
+// between their source and destination addresses with ESP in transport
+// mode, inline on the uplink when it offloads IPsec. The associations are
+// derived from the tunnel key in the key Secret of the connection, which
+// the reconciler ensures before the setup, so the other end derives the
+// same ones from the same key. Connections asking for another
+// encryption are refused, and all others are passed to the next datapath.
+type IPsecDatapath struct {
+	// Applier programming the associations and policies
+	applier *Applier
+	// Tunnel keys of the connections
+	keys KeySource
+	// Uplink offloading the encryption
+	uplink string
+	// Datapath setting up the connections before they are encrypted
+	next connection.Datapath
+}
+
+// NewIPsecDatapath creates a new IPsec datapath
+func NewIPsecDatapath(applier *Applier, keys KeySource, uplink string, next connection.Datapath) *IPsecDatapath {
+	return &IPsecDatapath{
+		applier: applier,
+		keys:    keys,
+		uplink:  uplink,
+		next:    next,
+	}
+}
+
+// Setup implements connection.Datapath
+func (d *IPsecDatapath) Setup(ctx context.Context, conn *nsmv1.NetworkConnection) error {
+	if conn.Spec.Encryption == "" {
+		return d.next.Setup(ctx, conn)
+	}
+	// nothing is set up for a connection that can't be encrypted
+	objs, err := d.objects(ctx, conn)
+	if err != nil {
+		return err
+	}
+	if err := d.next.Setup(ctx, conn); err != nil {
+		return err
+	}
+	if _, err := d.applier.Apply(ctx, ipsecOwner(conn), objs); err != nil {
+		return fmt.Errorf("failed to install the IPsec associations: %w", err)
+	}
+	return nil
+}
+
+// Plan implements Planner, adding the IPsec changes to those of the next
+// datapath
+func (d *IPsecDatapath) Plan(ctx context.Context, conn *nsmv1.NetworkConnection) (*Plan, error) {
+	plan := &Plan{}
+	if next, ok := d.next.(Planner); ok {
+		p, err := next.Plan(ctx, conn)
+		if err != nil {
+			return nil, err
+		}
+		plan = p
+	}
+	if conn.Spec.Encryption == "" {
+		return plan, nil
+	}
+	objs, err := d.objects(ctx, conn)
+	if errors.Is(err, keys.ErrNoKey) {
+		// the key is only created at setup, the associations it derives
+		// are planned with a placeholder
+		objs, err = d.objectsWithKey(conn, make([]byte, 32))
+	}
+	if err != nil {
+		return nil, err
+	}
+	p, err := d.applier.Plan(ctx, ipsecOwner(conn), objs)
+	if err != nil {
+		return nil, err
+	}
+	plan.Create = append(plan.Create, p.Create...)
+	plan.Update = append(plan.Update, p.Update...)
+	plan.Delete = append(plan.Delete, p.Delete...)
+	return plan, nil
+}
+
+// Teardown implements connection.Datapath. The associations hold no
+// scarce resources, so they are removed even when allocations are kept,
+// before the datapath they encrypt.
+func (d *IPsecDatapath) Teardown(ctx context.Context, conn *nsmv1.NetworkConnection, keepAllocations bool) error {
+	var objs []Object
+	if conn.Spec.Encryption != "" {
+		// a spec the objects can't be built from was never applied
+		objs, _ = d.objects(ctx, conn)
+	}
+	if err := d.applier.Release(ctx, ipsecOwner(conn), objs); err != nil {
+		return fmt.Errorf("failed to remove the IPsec associations: %w", err)
+	}
+	return d.next.Teardown(ctx, conn, keepAllocations)
+}
+
+// Verify implements posture.Verifier, checking that the associations and
+// policies derived from the key of the connection are installed on the
+// host as they were applied
+func (d *IPsecDatapath) Verify(ctx context.Context, conn *nsmv1.NetworkConnection) error {
+	objs, err := d.objects(ctx, conn)
+	if err != nil {
+		return err
+	}
+	plan, err := d.applier.Plan(ctx, ipsecOwner(conn), objs)
+	if err != nil {
+		return err
+	}
+	if missing := append(plan.Create, plan.Update...); len(missing) > 0 {
+		return fmt.Errorf("%s not installed as applied", missing[0].Key())
+	}
+	return nil
+}
+
+// Rekey implements connection.Rekeyer. The key Secret is replaced first,
+// then the associations derived from the new key are installed and the
+// outbound policy switched over to them before the old associations are
+// retired, so the traffic keeps flowing during the switch.
+func (d *IPsecDatapath) Rekey(ctx context.Context, conn *nsmv1.NetworkConnection) error {
+	if conn.Spec.Encryption != nsmv1.EncryptionIPsec {
+		return fmt.Errorf("%w: %s encryption is not programmed by the datapath", ErrUnsupported, conn.Spec.Encryption)
+	}
+	// the associations of the old key are retired even when they were
+	// installed before the controller restarted
+	if old, err := d.objects(ctx, conn); err == nil {
+		d.applier.adopt(ipsecOwner(conn), old)
+	}
+	if err := d.keys.Rekey(ctx, conn); err != nil {
+		return err
+	}
+	objs, err := d.objects(ctx, conn)
+	if err != nil {
+		return err
+	}
+	if _, err := d.applier.Apply(ctx, ipsecOwner(conn), objs); err != nil {
+		return fmt.Errorf("failed to install the IPsec associations of the new key: %w", err)
+	}
+	return nil
+}
+
+// objects returns the associations and policies encrypting a connection
+func (d *IPsecDatapath) objects(ctx context.Context, conn *nsmv1.NetworkConnection) ([]Object, error) {
+	if conn.Spec.Encryption != nsmv1.EncryptionIPsec {
+		// kernel TLS is enabled by the workload on its own sockets
+		return nil, fmt.Errorf("%w: %s encryption is not programmed by the datapath, use ipsec", ErrUnsupported, conn.Spec.Encryption)
+	}
+	key, err := d.keys.Key(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	return d.objectsWithKey(conn, key)
+}
+
+// objectsWithKey returns the associations and policies derived from a key
+func (d *IPsecDatapath) objectsWithKey(conn *nsmv1.NetworkConnection, key []byte) ([]Object, error) {
+	offload := ""
+	if conn.Status.Encryption == nsmv1.EncryptionModeOffload {
+		offload = d.uplink
+	}
+	return IPsecObjects(conn, key, offload)
+}
+
+// IPsecObjects returns the associations of both directions of a connection
+// and the policies requiring them, derived from its tunnel key. The
+// outbound policy pins the association of the key, so a new key takes
+// over the traffic as soon as its associations are applied.
+func IPsecObjects(conn *nsmv1.NetworkConnection, key []byte, offloadDevice string) ([]Object, error) {
+	src, dst := hostPrefix(conn.Spec.Source), hostPrefix(conn.Spec.Destination)
+	if src == nil || dst == nil || (src.IP.To4() == nil) != (dst.IP.To4() == nil) {
+		return nil, fmt.Errorf("%w: ipsec needs source and destination addresses of the same family, got %q and %q",
+			ErrUnsupported, conn.Spec.Source, conn.Spec.Destination)
+	}
+	local, remote := src.IP.String(), dst.IP.String()
+	reqid := ipsecReqid(conn)
+
+	out := ipsecState(key, local, remote, reqid)
+	in := ipsecState(key, remote, local, reqid)
+	if offloadDevice != "" {
+		out.OffloadDevice, out.OffloadDir = offloadDevice, "out"
+		in.OffloadDevice, in.OffloadDir = offloadDevice, "in"
+	}
+	return []Object{
+		out,
+		in,
+		XfrmPolicy{Src: src.String(), Dst: dst.String(), Dir: "out", TmplSrc: local, TmplDst: remote, SPI: out.SPI, Reqid: reqid},
+		XfrmPolicy{Src: dst.String(), Dst: src.String(), Dir: "in", TmplSrc: remote, TmplDst: local, Reqid: reqid},
+	}, nil
+}
+
+// ipsecState derives the association of the traffic from src to dst from
+// the tunnel key: an AES-256-GCM key with its salt, and an SPI above the
+// range reserved by RFC 4303
+func ipsecState(key []byte, src, dst string, reqid int) XfrmState {
+	label := src + ">" + dst
+	aead := append(ipsecDerive(key, "key "+label), ipsecDerive(key, "salt "+label)[:4]...)
+	spi := 256 + binary.BigEndian.Uint32(ipsecDerive(key, "spi "+label))%(1<<32-256)
+	return XfrmState{Src: src, Dst: dst, SPI: spi, Reqid: reqid, AEADKey: hex.EncodeToString(aead)}
+}
+
+// ipsecDerive derives 32 bytes of key material for a purpose from the
+// tunnel key
+func ipsecDerive(key []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("nsm-esp " + purpose))
+	return mac.Sum(nil)
+}
+
+// ipsecReqid returns a stable request ID for a connection
+func ipsecReqid(conn *nsmv1.NetworkConnection) int {
+	sum := sha256.Sum256([]byte(conn.Namespace + "/" + conn.Name))
+	return int(binary.BigEndian.Uint32(sum[:4])&0x3fffffff) + 1
+}
+
+// ipsecOwner returns the applier owner of a connection
+func ipsecOwner(conn *nsmv1.NetworkConnection) string {
+	return "ipsec/" + conn.Namespace + "/" + conn.Name
+}
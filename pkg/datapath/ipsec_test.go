@@ -0,0 +1,227 @@
+package datapath
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/keys"
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// staticKeys hands out the same key to every connection, none if empty
+type staticKeys []byte
+
+func (k staticKeys) Key(ctx context.Context, conn *nsmv1.NetworkConnection) ([]byte, error) {
+	if len(k) == 0 {
+		return nil, keys.ErrNoKey
+	}
+	return k, nil
+}
+
+func (k staticKeys) Rekey(ctx context.Context, conn *nsmv1.NetworkConnection) error {
+	return errors.New("static keys can't be rotated")
+}
+
+// rotatingKeys hands out a key that changes on every rekey
+type rotatingKeys struct {
+	key byte
+}
+
+func (k *rotatingKeys) Key(ctx context.Context, conn *nsmv1.NetworkConnection) ([]byte, error) {
+	return bytes.Repeat([]byte{k.key}, 32), nil
+}
+
+func (k *rotatingKeys) Rekey(ctx context.Context, conn *nsmv1.NetworkConnection) error {
+	k.key++
+	return nil
+}
+
+func ipsecConnection(source, destination string) *nsmv1.NetworkConnection {
+	return &nsmv1.NetworkConnection{
+		ObjectMeta: metav1.ObjectMeta{Name: "conn", Namespace: "edge"},
+		Spec: nsmv1.NetworkConnectionSpec{
+			Source:         source,
+			Destination:    destination,
+			ConnectionType: nsmv1.ConnectionTypeSRIOV,
+			Encryption:     nsmv1.EncryptionIPsec,
+		},
+		Status: nsmv1.NetworkConnectionStatus{Encryption: nsmv1.EncryptionModeSoftware},
+	}
+}
+
+func TestIPsecDatapath(t *testing.T) {
+	backend := newMemBackend()
+	next := &countingDatapath{}
+	key := bytes.Repeat([]byte{7}, 32)
+	d := NewIPsecDatapath(NewApplier(backend, logrus.New()), staticKeys(key), "eth0", next)
+
+	conn := ipsecConnection("10.0.0.1", "10.0.0.2")
+	if err := d.Setup(context.Background(), conn); err != nil {
+		t.Fatalf("Setup() error = %v", err)
+	}
+	if next.setups != 1 || len(backend.objects) != 4 {
+		t.Fatalf("setups = %d, objects = %v, want the next datapath and 4 objects", next.setups, backend.objects)
+	}
+
+	objs, err := IPsecObjects(conn, key, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, in := objs[0].(XfrmState), objs[1].(XfrmState)
+	policy := objs[2].(XfrmPolicy)
+	if out.Src != "10.0.0.1" || out.Dst != "10.0.0.2" || policy.SPI != out.SPI || out.SPI < 256 || len(out.AEADKey) != 72 {
+		t.Errorf("unexpected outbound association %+v with policy %+v", out, policy)
+	}
+	if out.SPI == in.SPI || out.AEADKey == in.AEADKey {
+		t.Errorf("both directions share their association")
+	}
+
+	// the other end derives the same associations from the same key
+	peer, err := IPsecObjects(ipsecConnection("10.0.0.2", "10.0.0.1"), key, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if peerOut := peer[0].(XfrmState); peerOut.SPI != in.SPI || peerOut.AEADKey != in.AEADKey {
+		t.Errorf("peer outbound %+v doesn't match inbound %+v", peerOut, in)
+	}
+
+	if err := d.Teardown(context.Background(), conn, true); err != nil {
+		t.Fatalf("Teardown() error = %v", err)
+	}
+	if len(backend.objects) != 0 {
+		t.Errorf("associations kept after teardown: %v", backend.objects)
+	}
+}
+
+func TestIPsecDatapathOffload(t *testing.T) {
+	conn := ipsecConnection("fd00::1", "fd00::2")
+	conn.Status.Encryption = nsmv1.EncryptionModeOffload
+	backend := newMemBackend()
+	d := NewIPsecDatapath(NewApplier(backend, logrus.New()), staticKeys(bytes.Repeat([]byte{1}, 32)), "eth0", &countingDatapath{})
+	if err := d.Setup(context.Background(), conn); err != nil {
+		t.Fatalf("Setup() error = %v", err)
+	}
+	for _, obj := range backend.objects {
+		if state, ok := obj.(XfrmState); ok && (state.OffloadDevice != "eth0" || state.OffloadDir == "") {
+			t.Errorf("association %+v not offloaded to eth0", state)
+		}
+	}
+}
+
+func TestIPsecDatapathRefusesUnprogrammable(t *testing.T) {
+	tls := ipsecConnection("10.0.0.1", "10.0.0.2")
+	tls.Spec.Encryption = nsmv1.EncryptionTLS
+	pod := ipsecConnection("edge/pod", "10.0.0.2")
+	mixed := ipsecConnection("10.0.0.1", "fd00::2")
+
+	for name, conn := range map[string]*nsmv1.NetworkConnection{"tls": tls, "pod source": pod, "mixed families": mixed} {
+		next := &countingDatapath{}
+		d := NewIPsecDatapath(NewApplier(newMemBackend(), logrus.New()), staticKeys(make([]byte, 32)), "eth0", next)
+		if err := d.Setup(context.Background(), conn); !errors.Is(err, ErrUnsupported) {
+			t.Errorf("%s: Setup() error = %v, want ErrUnsupported", name, err)
+		}
+		if next.setups != 0 {
+			t.Errorf("%s: next datapath set up for a refused connection", name)
+		}
+	}
+
+	// unencrypted connections go to the next datapath
+	next := &countingDatapath{}
+	plain := ipsecConnection("edge/pod", "svc")
+	plain.Spec.Encryption = ""
+	d := NewIPsecDatapath(NewApplier(newMemBackend(), logrus.New()), staticKeys(nil), "eth0", next)
+	if err := d.Setup(context.Background(), plain); err != nil || next.setups != 1 {
+		t.Errorf("unencrypted connection not delegated: %v", err)
+	}
+}
+
+func TestIPsecDatapathNeedsKeySecret(t *testing.T) {
+	backend := newMemBackend()
+	next := &countingDatapath{}
+	d := NewIPsecDatapath(NewApplier(backend, logrus.New()), staticKeys(nil), "eth0", next)
+	conn := ipsecConnection("10.0.0.1", "10.0.0.2")
+
+	// the associations are planned before the key exists
+	plan, err := d.Plan(context.Background(), conn)
+	if err != nil || len(plan.Create) != 4 {
+		t.Fatalf("Plan() = %+v, %v, want 4 objects created", plan, err)
+	}
+
+	// but nothing is set up without it
+	if err := d.Setup(context.Background(), conn); !errors.Is(err, keys.ErrNoKey) {
+		t.Fatalf("Setup() error = %v, want ErrNoKey", err)
+	}
+	if next.setups != 0 || len(backend.objects) != 0 {
+		t.Errorf("connection set up without its key")
+	}
+}
+
+func TestIPsecDatapathRekey(t *testing.T) {
+	backend := newMemBackend()
+	rotating := &rotatingKeys{key: 1}
+	conn := ipsecConnection("10.0.0.1", "10.0.0.2")
+	old, err := IPsecObjects(conn, bytes.Repeat([]byte{1}, 32), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// associations installed before the controller restarted
+	for _, obj := range old {
+		if err := backend.Create(context.Background(), obj); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d := NewIPsecDatapath(NewApplier(backend, logrus.New()), rotating, "eth0", &countingDatapath{})
+	if err := d.Rekey(context.Background(), conn); err != nil {
+		t.Fatalf("Rekey() error = %v", err)
+	}
+
+	// the associations of the new key replace the old ones
+	rekeyed, err := IPsecObjects(conn, bytes.Repeat([]byte{2}, 32), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backend.objects) != 4 {
+		t.Fatalf("objects = %v, want the 4 of the new key", backend.objects)
+	}
+	for _, obj := range rekeyed {
+		if observed, ok := backend.objects[obj.Key()]; !ok || !obj.InSync(observed) {
+			t.Errorf("%s not installed for the new key", obj.Key())
+		}
+	}
+	for _, obj := range old[:2] {
+		if _, ok := backend.objects[obj.Key()]; ok {
+			t.Errorf("association %s of the old key kept", obj.Key())
+		}
+	}
+}
+
+func TestIPsecDatapathVerify(t *testing.T) {
+	backend := newMemBackend()
+	d := NewIPsecDatapath(NewApplier(backend, logrus.New()), staticKeys(bytes.Repeat([]byte{3}, 32)), "eth0", &countingDatapath{})
+	conn := ipsecConnection("10.0.0.1", "10.0.0.2")
+	if err := d.Verify(context.Background(), conn); err == nil {
+		t.Fatalf("Verify() passed before the associations were installed")
+	}
+	if err := d.Setup(context.Background(), conn); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Verify(context.Background(), conn); err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+
+	// an association removed behind the datapath's back is found missing
+	for key, obj := range backend.objects {
+		if _, ok := obj.(XfrmState); ok {
+			delete(backend.objects, key)
+			break
+		}
+	}
+	if err := d.Verify(context.Background(), conn); err == nil {
+		t.Errorf("Verify() passed with an association missing")
+	}
+}
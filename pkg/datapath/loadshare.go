@@ -0,0 +1,228 @@
+package datapath
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/connection"
+)
+
+// LoadSharingMetric is the metric of the multipath route of a load shared
+// connection, preferred over the routes of a warm standby pair
+const LoadSharingMetric = ActiveMetric / 2
+
+// Priority of the rules steering the packets of per-packet load sharing
+// into the table of their path
+const loadSharingRulePriority = 1000
+
+// LoadSharingDatapath shares the traffic of connections over several
+// paths at once. A multipath route spreads the flows over the paths by
+// their weights, hashing on addresses and ports so every flow stays on
+// one path. In per-packet mode the packets forwarded towards the
+// destination are marked round robin instead, and every mark is routed
+// over its path, which aggregates the bandwidth even of a single flow at
+// the cost of reordering. The connection itself is set up by the next
+// datapath.
+type LoadSharingDatapath struct {
+	// Applier programming the routes
+	applier *Applier
+	// Datapath setting up the connections
+	next connection.Datapath
+}
+
+// NewLoadSharingDatapath creates a new load sharing datapath
+func NewLoadSharingDatapath(applier *Applier, next connection.Datapath) *LoadSharingDatapath {
+	return &LoadSharingDatapath{
+		applier: applier,
+		next:    next,
+	}
+}
+
+// Setup implements connection.Datapath
+func (d *LoadSharingDatapath) Setup(ctx context.Context, conn *nsmv1.NetworkConnection) error {
+	objs, err := LoadSharingObjects(conn)
+	if err != nil {
+		return err
+	}
+	if err := d.next.Setup(ctx, conn); err != nil {
+		return err
+	}
+	// a connection that stopped sharing its load drops the routes
+	if _, err := d.applier.Apply(ctx, loadSharingOwner(conn), objs); err != nil {
+		return fmt.Errorf("failed to share the load over the paths: %w", err)
+	}
+	return nil
+}
+
+// Plan implements Planner, adding the route changes to those of the next
+// datapath
+func (d *LoadSharingDatapath) Plan(ctx context.Context, conn *nsmv1.NetworkConnection) (*Plan, error) {
+	plan := &Plan{}
+	if next, ok := d.next.(Planner); ok {
+		p, err := next.Plan(ctx, conn)
+		if err != nil {
+			return nil, err
+		}
+		plan = p
+	}
+	objs, err := LoadSharingObjects(conn)
+	if err != nil {
+		return nil, err
+	}
+	p, err := d.applier.Plan(ctx, loadSharingOwner(conn), objs)
+	if err != nil {
+		return nil, err
+	}
+	plan.Create = append(plan.Create, p.Create...)
+	plan.Update = append(plan.Update, p.Update...)
+	plan.Delete = append(plan.Delete, p.Delete...)
+	return plan, nil
+}
+
+// Teardown implements connection.Datapath
+func (d *LoadSharingDatapath) Teardown(ctx context.Context, conn *nsmv1.NetworkConnection, keepAllocations bool) error {
+	// a spec the objects can't be built from was never applied
+	objs, _ := LoadSharingObjects(conn)
+	if err := d.applier.Release(ctx, loadSharingOwner(conn), objs); err != nil {
+		return fmt.Errorf("failed to remove the load sharing routes: %w", err)
+	}
+	return d.next.Teardown(ctx, conn, keepAllocations)
+}
+
+// LoadSharingObjects returns the host objects sharing the traffic of a
+// connection over its paths: the multipath route with flow hashing on
+// the ports, and in per-packet mode a route table and rule per path and
+// the chain marking the packets round robin. Connections without load
+// sharing have none.
+func LoadSharingObjects(conn *nsmv1.NetworkConnection) ([]Object, error) {
+	ls := conn.Spec.LoadSharing
+	if ls == nil {
+		return nil, nil
+	}
+	ip, dst, err := net.ParseCIDR(ls.Destination)
+	if err != nil {
+		return nil, fmt.Errorf("invalid load sharing destination %q: %w", ls.Destination, err)
+	}
+	ipv6 := ip.To4() == nil
+	if len(ls.Paths) < 2 {
+		return nil, fmt.Errorf("load sharing needs at least 2 paths, got %d", len(ls.Paths))
+	}
+
+	route := Route{Destination: dst.String(), Metric: LoadSharingMetric}
+	names := make(map[string]bool)
+	for _, path := range ls.Paths {
+		if path.Name == "" || path.Device == "" {
+			return nil, fmt.Errorf("load sharing paths need a name and a device")
+		}
+		if names[path.Name] {
+			return nil, fmt.Errorf("duplicate load sharing path %s", path.Name)
+		}
+		names[path.Name] = true
+		if path.Weight < 0 || path.Weight > 256 {
+			return nil, fmt.Errorf("weight %d of path %s is not within 0-256", path.Weight, path.Name)
+		}
+		if path.Gateway != "" {
+			gw := net.ParseIP(path.Gateway)
+			if gw == nil || (gw.To4() == nil) != ipv6 {
+				return nil, fmt.Errorf("invalid gateway %q of path %s towards %s", path.Gateway, path.Name, ls.Destination)
+			}
+		}
+		route.Nexthops = append(route.Nexthops, Nexthop{Device: path.Device, Gateway: path.Gateway, Weight: pathWeight(path)})
+	}
+
+	// hash flows on their ports too, so the flows between two hosts spread
+	family := "ipv4"
+	if ipv6 {
+		family = "ipv6"
+	}
+	objs := []Object{
+		Sysctl{Name: "net/" + family + "/fib_multipath_hash_policy", Value: "1", Reset: "0"},
+		route,
+	}
+
+	switch ls.Mode {
+	case "", nsmv1.LoadSharingPerFlow:
+		return objs, nil
+	case nsmv1.LoadSharingPerPacket:
+	default:
+		return nil, fmt.Errorf("unknown load sharing mode %q", ls.Mode)
+	}
+
+	// marked before the routing decision, in the table of the node
+	chain := NftChain{Family: "inet", Table: MulticastTable, Name: loadSharingChain(conn), Hook: "prerouting", Priority: -150}
+	var slots []string
+	var total int
+	for _, path := range ls.Paths {
+		id := loadSharingID(conn, path.Name)
+		objs = append(objs,
+			Route{Destination: route.Destination, Device: path.Device, Gateway: path.Gateway, Metric: LoadSharingMetric, Table: id},
+			RouteRule{Mark: id, Table: id, Priority: loadSharingRulePriority, IPv6: ipv6},
+		)
+		weight := pathWeight(path)
+		slots = append(slots, fmt.Sprintf("%d-%d : %#x", total, total+weight-1, id))
+		total += weight
+	}
+	match := "ip daddr"
+	if ipv6 {
+		match = "ip6 daddr"
+	}
+	objs = append(objs, chain, NftRule{
+		Family: chain.Family,
+		Table:  chain.Table,
+		Chain:  chain.Name,
+		Name:   "spread",
+		Rule:   match + " " + route.Destination + " meta mark set numgen inc mod " + strconv.Itoa(total) + " map { " + strings.Join(slots, ", ") + " }",
+	})
+	return objs, nil
+}
+
+// PathShares returns the share of the traffic every path of a load
+// shared connection carries, nil without load sharing
+func PathShares(spec *nsmv1.LoadSharingSpec) []nsmv1.PathShare {
+	if spec == nil {
+		return nil
+	}
+	var total int
+	for _, path := range spec.Paths {
+		total += pathWeight(path)
+	}
+	shares := make([]nsmv1.PathShare, 0, len(spec.Paths))
+	for _, path := range spec.Paths {
+		shares = append(shares, nsmv1.PathShare{
+			Name:    path.Name,
+			Device:  path.Device,
+			Percent: pathWeight(path) * 100 / total,
+		})
+	}
+	return shares
+}
+
+// pathWeight returns the weight of a path, defaulting to 1
+func pathWeight(path nsmv1.LoadSharingPath) int {
+	return max(path.Weight, 1)
+}
+
+// loadSharingID returns the firewall mark and routing table of a path of
+// a connection. It only uses bits 16-27 below a fixed top bit, clear of
+// the low bits kube-proxy marks packets with.
+func loadSharingID(conn *nsmv1.NetworkConnection, path string) int {
+	sum := sha256.Sum256([]byte(conn.Namespace + "/" + conn.Name + "/" + path))
+	return 0x10000000 | (int(sum[0])<<4|int(sum[1])>>4)<<16
+}
+
+// loadSharingChain returns the chain marking the packets of a connection
+func loadSharingChain(conn *nsmv1.NetworkConnection) string {
+	sum := sha256.Sum256([]byte(conn.Namespace + "/" + conn.Name))
+	return "ls-" + hex.EncodeToString(sum[:4])
+}
+
+// loadSharingOwner returns the applier owner of the routes of a connection
+func loadSharingOwner(conn *nsmv1.NetworkConnection) string {
+	return "loadsharing/" + conn.Namespace + "/" + conn.Name
+}
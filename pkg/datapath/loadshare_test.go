@@ -0,0 +1,168 @@
+package datapath
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/netutil"
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func loadSharingConnection(name, mode string, paths ...nsmv1.LoadSharingPath) *nsmv1.NetworkConnection {
+	return &nsmv1.NetworkConnection{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "edge"},
+		Spec: nsmv1.NetworkConnectionSpec{
+			ConnectionType: nsmv1.ConnectionTypeKernel,
+			LoadSharing:    &nsmv1.LoadSharingSpec{Destination: "10.20.0.0/16", Paths: paths, Mode: mode},
+		},
+	}
+}
+
+var (
+	fiberPath = nsmv1.LoadSharingPath{Name: "fiber", Device: "eth0", Gateway: "192.0.2.1", Weight: 3}
+	ltePath   = nsmv1.LoadSharingPath{Name: "lte", Device: "wwan0"}
+)
+
+func TestLoadSharingObjectsPerFlow(t *testing.T) {
+	objs, err := LoadSharingObjects(loadSharingConnection("historian", "", fiberPath, ltePath))
+	if err != nil {
+		t.Fatalf("LoadSharingObjects() error = %v", err)
+	}
+	want := []Object{
+		Sysctl{Name: "net/ipv4/fib_multipath_hash_policy", Value: "1", Reset: "0"},
+		Route{Destination: "10.20.0.0/16", Metric: LoadSharingMetric, Nexthops: []Nexthop{
+			{Device: "eth0", Gateway: "192.0.2.1", Weight: 3},
+			{Device: "wwan0", Weight: 1},
+		}},
+	}
+	if !reflect.DeepEqual(objs, want) {
+		t.Errorf("LoadSharingObjects() = %+v, want %+v", objs, want)
+	}
+
+	if objs, err := LoadSharingObjects(multicastConnection("plain", nil)); err != nil || objs != nil {
+		t.Errorf("unexpected objects %v (%v) without load sharing", objs, err)
+	}
+}
+
+func TestLoadSharingObjectsPerPacket(t *testing.T) {
+	conn := loadSharingConnection("historian", nsmv1.LoadSharingPerPacket, fiberPath, ltePath)
+	objs, err := LoadSharingObjects(conn)
+	if err != nil {
+		t.Fatalf("LoadSharingObjects() error = %v", err)
+	}
+
+	fiber, lte := loadSharingID(conn, "fiber"), loadSharingID(conn, "lte")
+	if fiber == lte || fiber&0xffff != 0 {
+		t.Fatalf("unexpected marks %#x and %#x", fiber, lte)
+	}
+	keys := make(map[string]Object)
+	for _, obj := range objs {
+		keys[obj.Key()] = obj
+	}
+	route, ok := keys[fmt.Sprintf("route/%d/10.20.0.0/16/%d", fiber, LoadSharingMetric)].(Route)
+	if !ok || route.Device != "eth0" || route.Gateway != "192.0.2.1" {
+		t.Errorf("unexpected route of the fiber path %+v", keys)
+	}
+	if rule, ok := keys[fmt.Sprintf("rule/inet/%#x", lte)].(RouteRule); !ok || rule.Table != lte {
+		t.Errorf("no rule steering the lte mark into its table: %+v", keys)
+	}
+	// the multipath route still carries unmarked traffic
+	if _, ok := keys[fmt.Sprintf("route/10.20.0.0/16/%d", LoadSharingMetric)]; !ok {
+		t.Errorf("multipath route missing")
+	}
+
+	var rules []NftRule
+	for _, obj := range objs {
+		if rule, ok := obj.(NftRule); ok {
+			rules = append(rules, rule)
+		}
+	}
+	// three of every four packets go over the fiber
+	want := fmt.Sprintf("ip daddr 10.20.0.0/16 meta mark set numgen inc mod 4 map { 0-2 : %#x, 3-3 : %#x }", fiber, lte)
+	if len(rules) != 1 || rules[0].Rule != want {
+		t.Errorf("unexpected marking rules %+v, want %s", rules, want)
+	}
+}
+
+func TestLoadSharingObjectsInvalid(t *testing.T) {
+	tests := map[string]*nsmv1.NetworkConnection{
+		"single path":    loadSharingConnection("a", "", fiberPath),
+		"duplicate path": loadSharingConnection("b", "", fiberPath, fiberPath),
+		"no device":      loadSharingConnection("c", "", fiberPath, nsmv1.LoadSharingPath{Name: "lte"}),
+		"weight":         loadSharingConnection("d", "", fiberPath, nsmv1.LoadSharingPath{Name: "lte", Device: "wwan0", Weight: 300}),
+		"gateway family": loadSharingConnection("e", "", fiberPath, nsmv1.LoadSharingPath{Name: "lte", Device: "wwan0", Gateway: "2001:db8::1"}),
+		"mode":           loadSharingConnection("f", "per-byte", fiberPath, ltePath),
+	}
+	for name, conn := range tests {
+		if _, err := LoadSharingObjects(conn); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	bad := loadSharingConnection("g", "", fiberPath, ltePath)
+	bad.Spec.LoadSharing.Destination = "10.20.0.0"
+	if _, err := LoadSharingObjects(bad); err == nil {
+		t.Errorf("expected an error for a destination without prefix length")
+	}
+}
+
+func TestLoadSharingDatapath(t *testing.T) {
+	nl := netutil.NewFake()
+	nl.Links["eth0"] = netutil.Link{Name: "eth0", Type: "device", Up: true}
+	nl.Links["wwan0"] = netutil.Link{Name: "wwan0", Type: "device", Up: true}
+	host := newMemBackend()
+	next := &countingDatapath{}
+	d := NewLoadSharingDatapath(NewApplier(NewNetlinkBackend(nl, host), logrus.New()), next)
+	ctx := context.Background()
+
+	conn := loadSharingConnection("historian", "", fiberPath, ltePath)
+	if err := d.Setup(ctx, conn); err != nil {
+		t.Fatalf("Setup() error = %v", err)
+	}
+	if next.setups != 1 {
+		t.Errorf("connection not set up by the next datapath")
+	}
+	routes, _ := nl.RouteList("10.20.0.0/16")
+	if len(routes) != 1 || len(routes[0].Nexthops) != 2 || routes[0].Nexthops[0].Weight != 3 {
+		t.Fatalf("unexpected routes %+v", routes)
+	}
+	if _, ok := host.objects["sysctl/net/ipv4/fib_multipath_hash_policy"]; !ok {
+		t.Errorf("flows not hashed on their ports")
+	}
+
+	// a setup in sync changes nothing
+	host.calls = nil
+	if err := d.Setup(ctx, conn); err != nil || len(host.calls) != 0 {
+		t.Errorf("in sync setup changed %v (%v)", host.calls, err)
+	}
+
+	// invalid specs fail before the connection is set up
+	if err := d.Setup(ctx, loadSharingConnection("bad", "", fiberPath)); err == nil || next.setups != 2 {
+		t.Errorf("Setup() error = %v after %d setups", err, next.setups)
+	}
+
+	if err := d.Teardown(ctx, conn, false); err != nil {
+		t.Fatalf("Teardown() error = %v", err)
+	}
+	if routes, _ := nl.RouteList("10.20.0.0/16"); len(routes) != 0 {
+		t.Errorf("routes left after teardown: %+v", routes)
+	}
+}
+
+func TestPathShares(t *testing.T) {
+	shares := PathShares(loadSharingConnection("historian", "", fiberPath, ltePath).Spec.LoadSharing)
+	want := []nsmv1.PathShare{
+		{Name: "fiber", Device: "eth0", Percent: 75},
+		{Name: "lte", Device: "wwan0", Percent: 25},
+	}
+	if !reflect.DeepEqual(shares, want) {
+		t.Errorf("PathShares() = %+v, want %+v", shares, want)
+	}
+	if PathShares(nil) != nil {
+		t.Errorf("shares reported without load sharing")
+	}
+}
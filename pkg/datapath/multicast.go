@@ -0,0 +1,167 @@
+package datapath
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/connection"
+)
+
+// MulticastTable is the nftables table holding the group bandwidth limits
+const MulticastTable = "nsm"
+
+// MulticastDatapath joins the multicast groups of connections on their
+// physical function or VLAN, enables snooping on their bridge and limits
+// the bandwidth of each group. The connection itself is set up by the
+// next datapath.
+type MulticastDatapath struct {
+	// Applier programming the memberships
+	applier *Applier
+	// Interface groups are joined on by default
+	uplink string
+	// Datapath setting up the connections
+	next connection.Datapath
+}
+
+// NewMulticastDatapath creates a new multicast datapath
+func NewMulticastDatapath(applier *Applier, uplink string, next connection.Datapath) *MulticastDatapath {
+	return &MulticastDatapath{
+		applier: applier,
+		uplink:  uplink,
+		next:    next,
+	}
+}
+
+// Setup implements connection.Datapath
+func (d *MulticastDatapath) Setup(ctx context.Context, conn *nsmv1.NetworkConnection) error {
+	if err := d.next.Setup(ctx, conn); err != nil {
+		return err
+	}
+	objs, err := MulticastObjects(conn, d.uplink)
+	if err != nil {
+		return err
+	}
+	// a connection that dropped its groups leaves them
+	if _, err := d.applier.Apply(ctx, multicastOwner(conn), objs); err != nil {
+		return fmt.Errorf("failed to join multicast groups: %w", err)
+	}
+	return nil
+}
+
+// Plan implements Planner, adding the membership changes to those of the
+// next datapath
+func (d *MulticastDatapath) Plan(ctx context.Context, conn *nsmv1.NetworkConnection) (*Plan, error) {
+	plan := &Plan{}
+	if next, ok := d.next.(Planner); ok {
+		p, err := next.Plan(ctx, conn)
+		if err != nil {
+			return nil, err
+		}
+		plan = p
+	}
+	objs, err := MulticastObjects(conn, d.uplink)
+	if err != nil {
+		return nil, err
+	}
+	p, err := d.applier.Plan(ctx, multicastOwner(conn), objs)
+	if err != nil {
+		return nil, err
+	}
+	plan.Create = append(plan.Create, p.Create...)
+	plan.Update = append(plan.Update, p.Update...)
+	plan.Delete = append(plan.Delete, p.Delete...)
+	return plan, nil
+}
+
+// Teardown implements connection.Datapath. Memberships hold no scarce
+// resources, so they are left even when allocations are kept.
+func (d *MulticastDatapath) Teardown(ctx context.Context, conn *nsmv1.NetworkConnection, keepAllocations bool) error {
+	// a spec the objects can't be built from was never applied
+	objs, _ := MulticastObjects(conn, d.uplink)
+	if err := d.applier.Release(ctx, multicastOwner(conn), objs); err != nil {
+		return fmt.Errorf("failed to leave multicast groups: %w", err)
+	}
+	return d.next.Teardown(ctx, conn, keepAllocations)
+}
+
+// MulticastObjects returns the host objects joining the groups of a
+// connection: a VLAN sub-interface if the groups are tagged, the group
+// memberships, snooping on the bridge, and a chain dropping the traffic
+// of each group beyond its bandwidth. Connections without multicast have
+// none.
+func MulticastObjects(conn *nsmv1.NetworkConnection, uplink string) ([]Object, error) {
+	mc := conn.Spec.Multicast
+	if mc == nil {
+		return nil, nil
+	}
+	iface := mc.Interface
+	if iface == "" {
+		iface = uplink
+	}
+	if iface == "" {
+		return nil, fmt.Errorf("no interface to join multicast groups on, set spec.multicast.interface or fallbackUplink (NSM_FALLBACK_UPLINK)")
+	}
+
+	var objs []Object
+	if mc.Snooping {
+		objs = append(objs, BridgeSnooping{Bridge: iface, Enabled: true})
+	}
+	dev := iface
+	if mc.VLAN > 0 {
+		dev = MulticastLinkName(iface, mc.VLAN)
+		objs = append(objs, Link{Name: dev, Type: "vlan", Parent: iface, VLAN: mc.VLAN, Up: true})
+	}
+
+	chain := NftChain{Family: "inet", Table: MulticastTable, Name: multicastChain(conn), Hook: "prerouting"}
+	var limits []Object
+	for _, group := range mc.Groups {
+		ip := net.ParseIP(group.Address)
+		if ip == nil || !ip.IsMulticast() {
+			return nil, fmt.Errorf("invalid multicast group %q", group.Address)
+		}
+		objs = append(objs, MulticastGroup{Device: dev, Group: ip.String()})
+		if group.Bandwidth <= 0 {
+			continue
+		}
+		family := "ip"
+		if ip.To4() == nil {
+			family = "ip6"
+		}
+		// nft limits bytes, 1 Mbps is 125 kbytes/s
+		limits = append(limits, NftRule{
+			Family: chain.Family,
+			Table:  chain.Table,
+			Chain:  chain.Name,
+			Name:   ip.String(),
+			Rule:   family + " daddr " + ip.String() + " limit rate over " + strconv.Itoa(group.Bandwidth*125) + " kbytes/second drop",
+		})
+	}
+	if len(limits) > 0 {
+		objs = append(append(objs, chain), limits...)
+	}
+	return objs, nil
+}
+
+// MulticastLinkName returns a stable name for the VLAN sub-interface the
+// groups are joined on, within the 15 character limit of the kernel
+func MulticastLinkName(iface string, vlan int) string {
+	sum := sha256.Sum256([]byte(iface + "." + strconv.Itoa(vlan)))
+	return "nsmmc" + hex.EncodeToString(sum[:4])
+}
+
+// multicastChain returns the chain holding the limits of a connection,
+// one per connection so removing it never touches those of others
+func multicastChain(conn *nsmv1.NetworkConnection) string {
+	sum := sha256.Sum256([]byte(conn.Namespace + "/" + conn.Name))
+	return "mc-" + hex.EncodeToString(sum[:4])
+}
+
+// multicastOwner returns the applier owner of the memberships of a connection
+func multicastOwner(conn *nsmv1.NetworkConnection) string {
+	return "multicast/" + conn.Namespace + "/" + conn.Name
+}
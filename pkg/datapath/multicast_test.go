@@ -0,0 +1,123 @@
+package datapath
+
+import (
+	"context"
+	"testing"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/netutil"
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func multicastConnection(name string, mc *nsmv1.MulticastSpec) *nsmv1.NetworkConnection {
+	return &nsmv1.NetworkConnection{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "edge"},
+		Spec:       nsmv1.NetworkConnectionSpec{ConnectionType: nsmv1.ConnectionTypeKernel, Multicast: mc},
+	}
+}
+
+func TestMulticastObjects(t *testing.T) {
+	conn := multicastConnection("video", &nsmv1.MulticastSpec{
+		Interface: "br0",
+		VLAN:      100,
+		Snooping:  true,
+		Groups: []nsmv1.MulticastGroup{
+			{Address: "239.1.1.1", Bandwidth: 20},
+			{Address: "ff3e::1"},
+		},
+	})
+	objs, err := MulticastObjects(conn, "eth0")
+	if err != nil {
+		t.Fatalf("MulticastObjects() error = %v", err)
+	}
+
+	vlan := MulticastLinkName("br0", 100)
+	keys := make(map[string]Object)
+	for _, obj := range objs {
+		keys[obj.Key()] = obj
+	}
+	if link, ok := keys["link/"+vlan].(Link); !ok || link.Type != "vlan" || link.Parent != "br0" || link.VLAN != 100 {
+		t.Errorf("unexpected VLAN link %+v", keys["link/"+vlan])
+	}
+	if _, ok := keys["bridge-snooping/br0"]; !ok {
+		t.Errorf("snooping not enabled on the bridge")
+	}
+	for _, key := range []string{"multicast-group/" + vlan + "/239.1.1.1", "multicast-group/" + vlan + "/ff3e::1"} {
+		if _, ok := keys[key]; !ok {
+			t.Errorf("%s not joined", key)
+		}
+	}
+
+	// only the limited group gets a rule, 20 Mbps in bytes
+	var rules []NftRule
+	for _, obj := range objs {
+		if rule, ok := obj.(NftRule); ok {
+			rules = append(rules, rule)
+		}
+	}
+	if len(rules) != 1 || rules[0].Rule != "ip daddr 239.1.1.1 limit rate over 2500 kbytes/second drop" {
+		t.Errorf("unexpected limits %+v", rules)
+	}
+	if len(vlan) > 15 {
+		t.Errorf("link name %s exceeds the kernel limit", vlan)
+	}
+
+	// untagged groups are joined on the uplink
+	objs, err = MulticastObjects(multicastConnection("plain", &nsmv1.MulticastSpec{Groups: []nsmv1.MulticastGroup{{Address: "239.1.1.2"}}}), "eth0")
+	if err != nil || len(objs) != 1 || objs[0].Key() != "multicast-group/eth0/239.1.1.2" {
+		t.Errorf("unexpected untagged objects %v (%v)", objs, err)
+	}
+
+	if _, err := MulticastObjects(multicastConnection("bad", &nsmv1.MulticastSpec{Groups: []nsmv1.MulticastGroup{{Address: "10.0.0.1"}}}), "eth0"); err == nil {
+		t.Errorf("expected an error for a unicast group")
+	}
+	if _, err := MulticastObjects(multicastConnection("none", &nsmv1.MulticastSpec{Groups: []nsmv1.MulticastGroup{{Address: "239.1.1.2"}}}), ""); err == nil {
+		t.Errorf("expected an error without an interface")
+	}
+}
+
+func TestMulticastDatapathSharesVLAN(t *testing.T) {
+	nl := netutil.NewFake()
+	nl.Links["eth0"] = netutil.Link{Name: "eth0", Type: "device", Up: true}
+	host := newMemBackend()
+	next := &countingDatapath{}
+	d := NewMulticastDatapath(NewApplier(NewNetlinkBackend(nl, host), logrus.New()), "eth0", next)
+	ctx := context.Background()
+
+	spec := func(group string) *nsmv1.MulticastSpec {
+		return &nsmv1.MulticastSpec{VLAN: 200, Groups: []nsmv1.MulticastGroup{{Address: group}}}
+	}
+	a := multicastConnection("cam-a", spec("239.2.0.1"))
+	b := multicastConnection("cam-b", spec("239.2.0.2"))
+	for _, conn := range []*nsmv1.NetworkConnection{a, b} {
+		if err := d.Setup(ctx, conn); err != nil {
+			t.Fatalf("Setup() error = %v", err)
+		}
+	}
+	if next.setups != 2 {
+		t.Errorf("connections not set up by the next datapath")
+	}
+	vlan := MulticastLinkName("eth0", 200)
+	if nl.Links[vlan].VLAN != 200 {
+		t.Fatalf("VLAN link not created: %+v", nl.Links)
+	}
+
+	// the VLAN stays while the other connection uses it
+	if err := d.Teardown(ctx, a, false); err != nil {
+		t.Fatalf("Teardown() error = %v", err)
+	}
+	if _, ok := nl.Links[vlan]; !ok {
+		t.Errorf("VLAN link removed while still used")
+	}
+	if _, ok := host.objects["multicast-group/"+vlan+"/239.2.0.1"]; ok {
+		t.Errorf("group of the torn down connection still joined")
+	}
+
+	if err := d.Teardown(ctx, b, false); err != nil {
+		t.Fatalf("Teardown() error = %v", err)
+	}
+	if _, ok := nl.Links[vlan]; ok {
+		t.Errorf("VLAN link left after the last connection")
+	}
+}
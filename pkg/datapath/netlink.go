@@ -0,0 +1,234 @@
+package datapath
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/akos011221/nsm/pkg/netutil"
+)
+
+// NetlinkBackend is a Backend programming links, addresses, routes and
+// the IPsec associations encrypted on the CPU through netlink. The other
+// kinds (e.g., qdiscs, nftables), which netutil doesn't cover, are
+// delegated to the host tools.
+type NetlinkBackend struct {
+	// Netlink operations
+	nl netutil.Interface
+	// Backend for the remaining object kinds
+	host Backend
+}
+
+// NewNetlinkBackend creates a new netlink backed Backend
+func NewNetlinkBackend(nl netutil.Interface, host Backend) *NetlinkBackend {
+	return &NetlinkBackend{
+		nl:   nl,
+		host: host,
+	}
+}
+
+// Get implements Backend
+func (b *NetlinkBackend) Get(ctx context.Context, obj Object) (Object, error) {
+	switch o := obj.(type) {
+	case Link:
+		link, err := b.nl.LinkByName(o.Name)
+		if err != nil {
+			return nil, notFound(err)
+		}
+		return fromNetutilLink(link), nil
+	case Address:
+		addrs, err := b.nl.AddrList(o.Device)
+		if err != nil {
+			return nil, notFound(err)
+		}
+		for _, addr := range addrs {
+			if addr == o.CIDR {
+				return o, nil
+			}
+		}
+		return nil, ErrNotFound
+	case Route:
+		routes, err := b.nl.RouteList(o.Destination)
+		if err != nil {
+			return nil, notFound(err)
+		}
+		for _, r := range routes {
+			if r.Metric == o.Metric && r.Table == o.Table {
+				return fromNetutilRoute(r), nil
+			}
+		}
+		return nil, ErrNotFound
+	default:
+		return b.host.Get(ctx, obj)
+	}
+}
+
+// Create implements Backend
+func (b *NetlinkBackend) Create(ctx context.Context, obj Object) error {
+	switch o := obj.(type) {
+	case Link:
+		if err := b.nl.LinkAdd(toNetutilLink(o)); err != nil {
+			return err
+		}
+		return b.setLink(o)
+	case Address:
+		return b.nl.AddrAdd(o.Device, o.CIDR)
+	case Route:
+		return b.nl.RouteReplace(toNetutilRoute(o))
+	case XfrmState:
+		if o.OffloadDevice != "" {
+			// netlink doesn't offload associations, the host tools do
+			return b.host.Create(ctx, obj)
+		}
+		key, err := hex.DecodeString(o.AEADKey)
+		if err != nil {
+			return fmt.Errorf("invalid key of the xfrm state %s", o.Key())
+		}
+		return b.nl.XfrmStateAdd(netutil.XfrmState{Src: o.Src, Dst: o.Dst, SPI: o.SPI, Reqid: o.Reqid, AEADKey: key})
+	default:
+		return b.host.Create(ctx, obj)
+	}
+}
+
+// Update implements Backend
+func (b *NetlinkBackend) Update(ctx context.Context, obj Object) error {
+	switch o := obj.(type) {
+	case Link:
+		return b.setLink(o)
+	case Address:
+		return nil
+	case Route:
+		return b.nl.RouteReplace(toNetutilRoute(o))
+	case XfrmState:
+		// the offload of an association can't be changed in place
+		if err := b.host.Delete(ctx, o); err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		return b.Create(ctx, o)
+	default:
+		return b.host.Update(ctx, obj)
+	}
+}
+
+// Delete implements Backend
+func (b *NetlinkBackend) Delete(ctx context.Context, obj Object) error {
+	switch o := obj.(type) {
+	case Link:
+		return notFound(b.nl.LinkDel(o.Name))
+	case Address:
+		return notFound(b.nl.AddrDel(o.Device, o.CIDR))
+	case Route:
+		return notFound(b.nl.RouteDel(toNetutilRoute(o)))
+	default:
+		return b.host.Delete(ctx, obj)
+	}
+}
+
+// setLink applies the MTU and administrative state of a link
+func (b *NetlinkBackend) setLink(l Link) error {
+	if l.MTU > 0 {
+		if err := b.nl.LinkSetMTU(l.Name, l.MTU); err != nil {
+			return err
+		}
+	}
+	if l.Up {
+		return b.nl.LinkSetUp(l.Name)
+	}
+	return b.nl.LinkSetDown(l.Name)
+}
+
+// NetlinkRouter is a Router backed by netlink
+type NetlinkRouter struct {
+	// Netlink operations
+	nl netutil.Interface
+}
+
+// NewNetlinkRouter creates a new netlink based router
+func NewNetlinkRouter(nl netutil.Interface) *NetlinkRouter {
+	return &NetlinkRouter{nl: nl}
+}
+
+// ReplaceRoute implements Router
+func (r *NetlinkRouter) ReplaceRoute(route Route) error {
+	return r.nl.RouteReplace(toNetutilRoute(route))
+}
+
+// DeleteRoute implements Router
+func (r *NetlinkRouter) DeleteRoute(route Route) error {
+	return r.nl.RouteDel(toNetutilRoute(route))
+}
+
+// notFound maps netutil.ErrNotFound to ErrNotFound
+func notFound(err error) error {
+	if errors.Is(err, netutil.ErrNotFound) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// toNetutilLink converts a desired link to its netutil representation
+func toNetutilLink(l Link) netutil.Link {
+	return netutil.Link{
+		Name:         l.Name,
+		Type:         l.Type,
+		Parent:       l.Parent,
+		PeerName:     l.PeerName,
+		VNI:          l.VNI,
+		Remote:       l.Remote,
+		Port:         l.Port,
+		Mode:         l.Mode,
+		VLAN:         l.VLAN,
+		VLANProtocol: l.VLANProtocol,
+		MTU:          l.MTU,
+		Up:           l.Up,
+	}
+}
+
+// fromNetutilLink converts an observed netutil link
+func fromNetutilLink(l netutil.Link) Link {
+	return Link{
+		Name:         l.Name,
+		Type:         l.Type,
+		Parent:       l.Parent,
+		PeerName:     l.PeerName,
+		VNI:          l.VNI,
+		Remote:       l.Remote,
+		Port:         l.Port,
+		Mode:         l.Mode,
+		VLAN:         l.VLAN,
+		VLANProtocol: l.VLANProtocol,
+		MTU:          l.MTU,
+		Up:           l.Up,
+	}
+}
+
+// toNetutilRoute converts a route to its netutil representation
+func toNetutilRoute(r Route) netutil.Route {
+	route := netutil.Route{
+		Destination: r.Destination,
+		Device:      r.Device,
+		Gateway:     r.Gateway,
+		Metric:      r.Metric,
+		Table:       r.Table,
+	}
+	for _, hop := range r.Nexthops {
+		route.Nexthops = append(route.Nexthops, netutil.Nexthop(hop))
+	}
+	return route
+}
+
+// fromNetutilRoute converts a netutil route to a route
+func fromNetutilRoute(r netutil.Route) Route {
+	route := Route{
+		Destination: r.Destination,
+		Device:      r.Device,
+		Gateway:     r.Gateway,
+		Metric:      r.Metric,
+		Table:       r.Table,
+	}
+	for _, hop := range r.Nexthops {
+		route.Nexthops = append(route.Nexthops, Nexthop(hop))
+	}
+	return route
+}
@@ -0,0 +1,110 @@
+package datapath
+
+import (
+	"context"
+	"testing"
+
+	"github.com/akos011221/nsm/pkg/netutil"
+	"github.com/sirupsen/logrus"
+)
+
+func TestNetlinkBackendApply(t *testing.T) {
+	nl := netutil.NewFake()
+	nl.Links["eth0"] = netutil.Link{Name: "eth0", Type: "device", MTU: 1500, Up: true}
+	host := newMemBackend()
+	a := NewApplier(NewNetlinkBackend(nl, host), logrus.New())
+
+	desired := []Object{
+		Link{Name: "vx42", Type: "vxlan", Parent: "eth0", VNI: 42, Remote: "192.0.2.1", Port: 4789, MTU: 1450, Up: true},
+		Address{Device: "vx42", CIDR: "10.42.0.1/24"},
+		Route{Destination: "10.43.0.0/24", Device: "vx42", Gateway: "10.42.0.2", Metric: 100},
+		Qdisc{Device: "vx42", Type: "fq_codel"},
+	}
+	if _, err := a.Apply(context.Background(), "conn", desired); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+
+	link := nl.Links["vx42"]
+	if link.VNI != 42 || link.Parent != "eth0" || link.MTU != 1450 || !link.Up {
+		t.Errorf("unexpected link %+v", link)
+	}
+	if addrs := nl.Addrs["vx42"]; len(addrs) != 1 || addrs[0] != "10.42.0.1/24" {
+		t.Errorf("unexpected addresses %v", addrs)
+	}
+	if len(nl.Routes) != 1 || nl.Routes[0].Gateway != "10.42.0.2" {
+		t.Errorf("unexpected routes %+v", nl.Routes)
+	}
+	if _, ok := host.objects["qdisc/vx42/root"]; !ok {
+		t.Errorf("qdisc was not delegated to the host backend")
+	}
+
+	// a second apply observes everything in sync
+	plan, err := a.Apply(context.Background(), "conn", desired)
+	if err != nil || !plan.Empty() {
+		t.Errorf("second apply not a no-op: %+v (%v)", plan, err)
+	}
+
+	// deleting the link out of band is repaired
+	if err := nl.LinkDel("vx42"); err != nil {
+		t.Fatalf("LinkDel() error = %v", err)
+	}
+	plan, err = a.Apply(context.Background(), "conn", desired)
+	if err != nil || len(plan.Create) != 3 {
+		t.Errorf("link removal not repaired: %+v (%v)", plan, err)
+	}
+
+	if err := a.Remove(context.Background(), "conn"); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	if _, ok := nl.Links["vx42"]; ok || len(nl.Routes) != 0 {
+		t.Errorf("objects left after remove: %+v %+v", nl.Links, nl.Routes)
+	}
+}
+
+func TestNetlinkRouterWarmStandby(t *testing.T) {
+	nl := netutil.NewFake()
+	nl.Links["eth0"] = netutil.Link{Name: "eth0", Up: true}
+	nl.Links["wwan0"] = netutil.Link{Name: "wwan0", Up: true}
+
+	w := NewWarmStandby(NewNetlinkRouter(nl), "10.10.0.0/24", Path{Name: "fiber", Device: "eth0"}, Path{Name: "lte", Device: "wwan0"})
+	if err := w.Establish(); err != nil {
+		t.Fatalf("Establish() error = %v", err)
+	}
+	if err := w.Failover(); err != nil {
+		t.Fatalf("Failover() error = %v", err)
+	}
+
+	routes, _ := nl.RouteList("10.10.0.0/24")
+	metrics := make(map[string]int)
+	for _, r := range routes {
+		metrics[r.Device] = r.Metric
+	}
+	if metrics["wwan0"] != ActiveMetric || metrics["eth0"] != StandbyMetric {
+		t.Errorf("unexpected routes after failover: %+v", routes)
+	}
+}
+
+func TestNetlinkBackendXfrmState(t *testing.T) {
+	nl := netutil.NewFake()
+	host := newMemBackend()
+	b := NewNetlinkBackend(nl, host)
+
+	// associations encrypted on the CPU are handed to the kernel over netlink
+	state := XfrmState{Src: "10.0.0.1", Dst: "10.0.0.2", SPI: 0x1234, Reqid: 7, AEADKey: "00ff"}
+	if err := b.Create(context.Background(), state); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	got, ok := nl.XfrmStates["10.0.0.2/0x1234"]
+	if !ok || got.Reqid != 7 || string(got.AEADKey) != "\x00\xff" {
+		t.Errorf("xfrm states = %+v, want the association with its decoded key", nl.XfrmStates)
+	}
+
+	// netlink doesn't offload, so offloaded associations go to the host tools
+	offloaded := XfrmState{Src: "10.0.0.1", Dst: "10.0.0.3", SPI: 0x5678, AEADKey: "00", OffloadDevice: "eth0", OffloadDir: "out"}
+	if err := b.Create(context.Background(), offloaded); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if _, ok := host.objects[offloaded.Key()]; !ok || len(nl.XfrmStates) != 1 {
+		t.Errorf("offloaded association not delegated to the host backend")
+	}
+}
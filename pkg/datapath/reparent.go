@@ -0,0 +1,173 @@
+package datapath
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/akos011221/nsm/pkg/hardware"
+	"github.com/akos011221/nsm/pkg/netutil"
+)
+
+// VFReparenter is a hardware.VFPlumber moving the VFs between the pods and
+// the host through netlink, as the CNI plugin does
+type VFReparenter struct {
+	// Netlink operations of the host network namespace
+	nl netutil.Interface
+	// Longest time a recreated VF is waited for to show up
+	timeout time.Duration
+	// Interval the recreated VF is looked up at
+	pollInterval time.Duration
+}
+
+// NewVFReparenter creates a new netlink backed VF plumber
+func NewVFReparenter(nl netutil.Interface) *VFReparenter {
+	return &VFReparenter{nl: nl, timeout: 10 * time.Second, pollInterval: 100 * time.Millisecond}
+}
+
+// Detach implements hardware.VFPlumber
+func (r *VFReparenter) Detach(vf hardware.VirtualFunction) (*hardware.VFConfig, error) {
+	vfs, err := r.nl.VFList(vf.PFName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the VFs of %s: %w", vf.PFName, err)
+	}
+	cfg := &hardware.VFConfig{}
+	for _, info := range vfs {
+		if info.ID != vf.VFID {
+			continue
+		}
+		cfg.MAC = info.MAC
+		cfg.VLAN = info.VLAN
+		cfg.QoS = info.QoS
+		cfg.SpoofCheck = info.SpoofCheck
+		cfg.Trust = info.Trust
+		cfg.MaxTxRateMbps = info.MaxTxRateMbps
+		cfg.MinTxRateMbps = info.MinTxRateMbps
+	}
+	if vf.Netns == "" || vf.PodInterface == "" {
+		return cfg, nil
+	}
+
+	pod, err := r.nl.Netns(vf.Netns)
+	if err != nil {
+		return nil, err
+	}
+	defer pod.Close()
+
+	link, err := pod.LinkByName(vf.PodInterface)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find %s in %s: %w", vf.PodInterface, vf.Netns, err)
+	}
+	cfg.MTU = link.MTU
+	addrs, err := pod.AddrList(vf.PodInterface)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the addresses of %s: %w", vf.PodInterface, err)
+	}
+	for _, cidr := range addrs {
+		// link-local addresses come back with the interface
+		if ip, _, err := net.ParseCIDR(cidr); err == nil && ip.IsLinkLocalUnicast() {
+			continue
+		}
+		cfg.Addresses = append(cfg.Addresses, cidr)
+	}
+	routes, err := pod.LinkRouteList(vf.PodInterface)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the routes over %s: %w", vf.PodInterface, err)
+	}
+	for _, route := range routes {
+		cfg.Routes = append(cfg.Routes, hardware.VFRoute{Destination: route.Destination, Gateway: route.Gateway})
+	}
+
+	// the VF leaves the pod under its host name
+	if err := pod.LinkSetDown(vf.PodInterface); err != nil {
+		return nil, fmt.Errorf("failed to set %s down: %w", vf.PodInterface, err)
+	}
+	if err := pod.LinkSetName(vf.PodInterface, vf.InterfaceName); err != nil {
+		return nil, fmt.Errorf("failed to rename %s to %s: %w", vf.PodInterface, vf.InterfaceName, err)
+	}
+	if err := pod.LinkSetNetns(vf.InterfaceName, ""); err != nil {
+		return nil, fmt.Errorf("failed to move %s to the host: %w", vf.InterfaceName, err)
+	}
+	return cfg, nil
+}
+
+// Attach implements hardware.VFPlumber
+func (r *VFReparenter) Attach(vf hardware.VirtualFunction, cfg *hardware.VFConfig) error {
+	if cfg.MAC != "" {
+		if err := r.nl.VFSetMAC(vf.PFName, vf.VFID, cfg.MAC); err != nil {
+			return fmt.Errorf("failed to restore the MAC of VF %d: %w", vf.VFID, err)
+		}
+	}
+	if cfg.VLAN > 0 {
+		if err := r.nl.VFSetVLAN(vf.PFName, vf.VFID, cfg.VLAN, cfg.QoS); err != nil {
+			return fmt.Errorf("failed to restore the VLAN of VF %d: %w", vf.VFID, err)
+		}
+	}
+	if err := r.nl.VFSetSpoofCheck(vf.PFName, vf.VFID, cfg.SpoofCheck); err != nil {
+		return fmt.Errorf("failed to restore the spoof check of VF %d: %w", vf.VFID, err)
+	}
+	if err := r.nl.VFSetTrust(vf.PFName, vf.VFID, cfg.Trust); err != nil {
+		return fmt.Errorf("failed to restore the trust of VF %d: %w", vf.VFID, err)
+	}
+	if cfg.MaxTxRateMbps > 0 || cfg.MinTxRateMbps > 0 {
+		if err := r.nl.VFSetRate(vf.PFName, vf.VFID, cfg.MinTxRateMbps, cfg.MaxTxRateMbps); err != nil {
+			return fmt.Errorf("failed to restore the rate limit of VF %d: %w", vf.VFID, err)
+		}
+	}
+	if vf.Netns == "" || vf.PodInterface == "" {
+		return nil
+	}
+
+	if err := r.waitForLink(vf.InterfaceName); err != nil {
+		return err
+	}
+	if err := r.nl.LinkSetNetns(vf.InterfaceName, vf.Netns); err != nil {
+		return fmt.Errorf("failed to move %s into %s: %w", vf.InterfaceName, vf.Netns, err)
+	}
+
+	pod, err := r.nl.Netns(vf.Netns)
+	if err != nil {
+		return err
+	}
+	defer pod.Close()
+	if err := pod.LinkSetName(vf.InterfaceName, vf.PodInterface); err != nil {
+		return fmt.Errorf("failed to rename %s to %s: %w", vf.InterfaceName, vf.PodInterface, err)
+	}
+	if cfg.MTU > 0 {
+		if err := pod.LinkSetMTU(vf.PodInterface, cfg.MTU); err != nil {
+			return fmt.Errorf("failed to restore the MTU of %s: %w", vf.PodInterface, err)
+		}
+	}
+	for _, cidr := range cfg.Addresses {
+		if err := pod.AddrAdd(vf.PodInterface, cidr); err != nil {
+			return fmt.Errorf("failed to restore address %s on %s: %w", cidr, vf.PodInterface, err)
+		}
+	}
+	// the routes need the link up to be accepted
+	if err := pod.LinkSetUp(vf.PodInterface); err != nil {
+		return fmt.Errorf("failed to set %s up: %w", vf.PodInterface, err)
+	}
+	for _, rt := range cfg.Routes {
+		route := netutil.Route{Destination: rt.Destination, Device: vf.PodInterface, Gateway: rt.Gateway}
+		if err := pod.RouteReplace(route); err != nil {
+			return fmt.Errorf("failed to restore the route to %s over %s: %w", rt.Destination, vf.PodInterface, err)
+		}
+	}
+	return nil
+}
+
+// waitForLink waits for the interface of a recreated VF to show up
+func (r *VFReparenter) waitForLink(name string) error {
+	deadline := time.Now().Add(r.timeout)
+	for {
+		_, err := r.nl.LinkByName(name)
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, netutil.ErrNotFound) || time.Now().After(deadline) {
+			return fmt.Errorf("interface %s of the recreated VF didn't show up: %w", name, err)
+		}
+		time.Sleep(r.pollInterval)
+	}
+}
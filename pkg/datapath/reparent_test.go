@@ -0,0 +1,66 @@
+package datapath
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/akos011221/nsm/pkg/hardware"
+	"github.com/akos011221/nsm/pkg/netutil"
+)
+
+func TestVFReparenterRoundTrip(t *testing.T) {
+	nl := netutil.NewFake()
+	nl.Links["eth0"] = netutil.Link{Name: "eth0", Type: "device", Up: true}
+	nl.VFs["eth0"] = []netutil.VF{{ID: 1, MAC: "02:00:00:00:01:01", VLAN: 100, SpoofCheck: true, MaxTxRateMbps: 500}}
+	pod := nl.AddNetns("/var/run/netns/camera")
+	pod.Links["net1"] = netutil.Link{Name: "net1", Type: "device", MTU: 9000, Up: true}
+	pod.Addrs["net1"] = []string{"10.0.0.5/24", "fe80::1/64"}
+	pod.Routes = []netutil.Route{{Destination: "default", Device: "net1", Gateway: "10.0.0.1"}}
+
+	r := NewVFReparenter(nl)
+	r.timeout, r.pollInterval = 50*time.Millisecond, time.Millisecond
+	vf := hardware.VirtualFunction{PFName: "eth0", VFID: 1, InterfaceName: "eth0_vf1", Netns: "/var/run/netns/camera", PodInterface: "net1"}
+
+	cfg, err := r.Detach(vf)
+	if err != nil {
+		t.Fatalf("Detach() error = %v", err)
+	}
+	want := &hardware.VFConfig{
+		MAC: "02:00:00:00:01:01", VLAN: 100, SpoofCheck: true, MaxTxRateMbps: 500, MTU: 9000,
+		Addresses: []string{"10.0.0.5/24"},
+		Routes:    []hardware.VFRoute{{Destination: "default", Gateway: "10.0.0.1"}},
+	}
+	if !reflect.DeepEqual(cfg, want) {
+		t.Errorf("Detach() = %+v, want %+v", cfg, want)
+	}
+	if _, ok := nl.Links["eth0_vf1"]; !ok {
+		t.Fatalf("VF not moved to the host under its host name")
+	}
+
+	// the reset recreates the VF without its configuration
+	nl.VFs["eth0"] = []netutil.VF{{ID: 1}}
+	if err := nl.LinkDel("eth0_vf1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Attach(vf, cfg); err == nil {
+		t.Fatal("Attach() of a VF that didn't show up succeeded")
+	}
+	nl.Links["eth0_vf1"] = netutil.Link{Name: "eth0_vf1", Type: "device"}
+	if err := r.Attach(vf, cfg); err != nil {
+		t.Fatalf("Attach() error = %v", err)
+	}
+	if got := nl.VFs["eth0"][0]; got != (netutil.VF{ID: 1, MAC: "02:00:00:00:01:01", VLAN: 100, SpoofCheck: true, MaxTxRateMbps: 500}) {
+		t.Errorf("VF = %+v, want its configuration restored", got)
+	}
+	link, ok := pod.Links["net1"]
+	if !ok || link.MTU != 9000 || !link.Up {
+		t.Fatalf("link in the pod = %+v, %t, want net1 up with MTU 9000", link, ok)
+	}
+	if addrs := pod.Addrs["net1"]; !reflect.DeepEqual(addrs, []string{"10.0.0.5/24"}) {
+		t.Errorf("addresses = %v, want 10.0.0.5/24", addrs)
+	}
+	if len(pod.Routes) != 1 || pod.Routes[0].Gateway != "10.0.0.1" || pod.Routes[0].Device != "net1" {
+		t.Errorf("routes = %+v, want the default route via 10.0.0.1", pod.Routes)
+	}
+}
@@ -0,0 +1,60 @@
+package datapath
+
+import "strconv"
+
+// Route is a host route towards a connection destination
+type Route struct {
+	// Destination prefix in CIDR notation
+	Destination string
+	// Outgoing device (e.g., eth0, eth0_vf1, wg0)
+	Device string
+	// Next hop, empty for directly connected destinations
+	Gateway string
+	// Route metric, lower values are preferred
+	Metric int
+	// Routing table, 0 for the main table
+	Table int
+	// Paths of a multipath route, used instead of Device and Gateway
+	Nexthops []Nexthop
+}
+
+// Nexthop is a path of a multipath route
+type Nexthop struct {
+	// Outgoing device
+	Device string
+	// Next hop, empty for directly connected destinations
+	Gateway string
+	// Relative share of the traffic, 0 for 1
+	Weight int
+}
+
+// Router programs routes on the host
+type Router interface {
+	// ReplaceRoute installs or updates a route
+	ReplaceRoute(route Route) error
+	// DeleteRoute removes a route
+	DeleteRoute(route Route) error
+}
+
+// routeArgs builds the iproute2 arguments describing a route
+func routeArgs(route Route) []string {
+	args := []string{route.Destination}
+	if route.Gateway != "" {
+		args = append(args, "via", route.Gateway)
+	}
+	if route.Device != "" {
+		args = append(args, "dev", route.Device)
+	}
+	args = append(args, "metric", strconv.Itoa(route.Metric))
+	if route.Table != 0 {
+		args = append(args, "table", strconv.Itoa(route.Table))
+	}
+	for _, hop := range route.Nexthops {
+		args = append(args, "nexthop")
+		if hop.Gateway != "" {
+			args = append(args, "via", hop.Gateway)
+		}
+		args = append(args, "dev", hop.Device, "weight", strconv.Itoa(max(hop.Weight, 1)))
+	}
+	return args
+}
@@ -0,0 +1,149 @@
+package datapath
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Route metrics used by warm standby pairs
+const (
+	// ActiveMetric is the metric of the route carrying the traffic
+	ActiveMetric = 100
+	// StandbyMetric is the metric of the pre-established backup route
+	StandbyMetric = 200
+)
+
+// Path is one of the datapaths a connection can use
+type Path struct {
+	// Name of the path, reported in the connection status
+	Name string
+	// Outgoing device of the path
+	Device string
+	// Next hop of the path, if any
+	Gateway string
+}
+
+// WarmStandby keeps a backup path pre-established next to the active one,
+// so failover is a route metric flip instead of a full datapath setup
+type WarmStandby struct {
+	// Router used to program the routes
+	router Router
+	// Destination prefix of the connection
+	destination string
+	// Active and standby paths
+	active  Path
+	standby Path
+	// Whether the routes are installed
+	established bool
+	// Mutex for protecting the paths
+	mu sync.Mutex
+}
+
+// NewWarmStandby creates a warm standby pair for the destination
+func NewWarmStandby(router Router, destination string, primary, backup Path) *WarmStandby {
+	return &WarmStandby{
+		router:      router,
+		destination: destination,
+		active:      primary,
+		standby:     backup,
+	}
+}
+
+// Establish installs the routes of both paths, the standby one with a worse metric
+func (w *WarmStandby) Establish() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	// don't leave the active route behind if the standby can't be installed
+	tx := NewTransaction(w.routeStep("active path "+w.active.Name, w.route(w.active, ActiveMetric)),
+		w.routeStep("standby path "+w.standby.Name, w.route(w.standby, StandbyMetric)))
+	if err := tx.Commit(context.Background()); err != nil {
+		return fmt.Errorf("failed to establish warm standby for %s: %w", w.destination, err)
+	}
+
+	w.established = true
+	return nil
+}
+
+// Failover makes the standby path active by flipping the route metrics.
+// The standby route is promoted before the old active one is demoted, so
+// there is always a route towards the destination.
+func (w *WarmStandby) Failover() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.established {
+		return fmt.Errorf("warm standby for %s is not established", w.destination)
+	}
+
+	// promote the standby route with a metric between the two, so it
+	// wins immediately without conflicting with the current active route
+	if err := w.router.ReplaceRoute(w.route(w.standby, ActiveMetric-1)); err != nil {
+		return fmt.Errorf("failed to promote standby path %s: %w", w.standby.Name, err)
+	}
+	if err := w.router.ReplaceRoute(w.route(w.active, StandbyMetric)); err != nil {
+		return fmt.Errorf("failed to demote active path %s: %w", w.active.Name, err)
+	}
+	if err := w.router.ReplaceRoute(w.route(w.standby, ActiveMetric)); err != nil {
+		return fmt.Errorf("failed to settle active path %s: %w", w.standby.Name, err)
+	}
+	// the promoted route is a separate route, remove it once settled
+	if err := w.router.DeleteRoute(w.route(w.standby, ActiveMetric-1)); err != nil {
+		return fmt.Errorf("failed to remove promoted route of %s: %w", w.standby.Name, err)
+	}
+
+	w.active, w.standby = w.standby, w.active
+	return nil
+}
+
+// Teardown removes the routes of both paths
+func (w *WarmStandby) Teardown() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var errs []error
+	if err := w.router.DeleteRoute(w.route(w.active, ActiveMetric)); err != nil {
+		errs = append(errs, err)
+	}
+	if err := w.router.DeleteRoute(w.route(w.standby, StandbyMetric)); err != nil {
+		errs = append(errs, err)
+	}
+	w.established = false
+
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to tear down warm standby for %s: %v", w.destination, errs)
+	}
+	return nil
+}
+
+// Paths returns the currently active and standby paths
+func (w *WarmStandby) Paths() (Path, Path) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.active, w.standby
+}
+
+// route builds the route of a path with the given metric
+func (w *WarmStandby) route(p Path, metric int) Route {
+	return Route{
+		Destination: w.destination,
+		Device:      p.Device,
+		Gateway:     p.Gateway,
+		Metric:      metric,
+	}
+}
+
+// routeStep builds the transaction step installing a route
+func (w *WarmStandby) routeStep(name string, route Route) Step {
+	return Step{
+		Name: name,
+		Apply: func(ctx context.Context) error {
+			return w.router.ReplaceRoute(route)
+		},
+		Rollback: func(ctx context.Context) error {
+			return w.router.DeleteRoute(route)
+		},
+	}
+}
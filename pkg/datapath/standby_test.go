@@ -0,0 +1,98 @@
+package datapath
+
+import (
+	"errors"
+	"testing"
+)
+
+// fakeRouter records the routes programmed through it
+type fakeRouter struct {
+	routes map[string]int
+	calls  []Route
+	fail   bool
+}
+
+func newFakeRouter() *fakeRouter {
+	return &fakeRouter{routes: make(map[string]int)}
+}
+
+func (f *fakeRouter) ReplaceRoute(route Route) error {
+	if f.fail {
+		return errors.New("boom")
+	}
+	f.calls = append(f.calls, route)
+	f.routes[route.Device] = route.Metric
+	return nil
+}
+
+func (f *fakeRouter) DeleteRoute(route Route) error {
+	if f.routes[route.Device] == route.Metric {
+		delete(f.routes, route.Device)
+	}
+	return nil
+}
+
+func TestWarmStandbyFailover(t *testing.T) {
+	router := newFakeRouter()
+	primary := Path{Name: "fiber", Device: "eth0"}
+	backup := Path{Name: "lte", Device: "wwan0"}
+	w := NewWarmStandby(router, "10.10.0.0/24", primary, backup)
+
+	if err := w.Failover(); err == nil {
+		t.Fatalf("expected failover to fail before establish")
+	}
+
+	if err := w.Establish(); err != nil {
+		t.Fatalf("Establish() error = %v", err)
+	}
+	if router.routes["eth0"] != ActiveMetric || router.routes["wwan0"] != StandbyMetric {
+		t.Fatalf("unexpected metrics after establish: %v", router.routes)
+	}
+
+	router.calls = nil
+	if err := w.Failover(); err != nil {
+		t.Fatalf("Failover() error = %v", err)
+	}
+
+	// make-before-break: the backup must be preferred before the primary is demoted
+	if router.calls[0].Device != "wwan0" || router.calls[0].Metric >= ActiveMetric {
+		t.Errorf("standby was not promoted first: %+v", router.calls)
+	}
+	if router.routes["wwan0"] != ActiveMetric || router.routes["eth0"] != StandbyMetric {
+		t.Errorf("unexpected metrics after failover: %v", router.routes)
+	}
+
+	active, standby := w.Paths()
+	if active.Name != "lte" || standby.Name != "fiber" {
+		t.Errorf("paths not swapped: active=%s standby=%s", active.Name, standby.Name)
+	}
+
+	if err := w.Teardown(); err != nil {
+		t.Fatalf("Teardown() error = %v", err)
+	}
+	if len(router.routes) != 0 {
+		t.Errorf("routes left after teardown: %v", router.routes)
+	}
+}
+
+func TestWarmStandbyEstablishError(t *testing.T) {
+	router := newFakeRouter()
+	router.fail = true
+	w := NewWarmStandby(router, "10.10.0.0/24", Path{Device: "eth0"}, Path{Device: "eth1"})
+	if err := w.Establish(); err == nil {
+		t.Fatalf("expected establish error")
+	}
+}
+
+func TestRouteArgs(t *testing.T) {
+	got := routeArgs(Route{Destination: "10.0.0.0/8", Device: "eth0", Gateway: "192.168.1.1", Metric: 100})
+	want := []string{"10.0.0.0/8", "via", "192.168.1.1", "dev", "eth0", "metric", "100"}
+	if len(got) != len(want) {
+		t.Fatalf("routeArgs() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("routeArgs() = %v, want %v", got, want)
+		}
+	}
+}
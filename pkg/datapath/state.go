@@ -0,0 +1,558 @@
+package datapath
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Kind of a host networking object. Kinds are applied in ascending order
+// and removed in descending order, so dependencies (a route needs its link)
+// are always satisfied.
+type Kind int
+
+// Object kinds in dependency order
+const (
+	KindLink Kind = iota
+	KindBridgeSnooping
+	KindAddress
+	KindMulticastGroup
+	KindSysctl
+	KindNeighProxy
+	KindRoute
+	KindRouteRule
+	KindQdisc
+	KindClass
+	KindFilter
+	KindNftChain
+	KindNftRule
+	KindXfrmState
+	KindXfrmPolicy
+	KindVPPInterface
+	KindVPPUnnumbered
+	KindVPPXConnect
+	KindVPPRoute
+	KindVPPACL
+)
+
+// String returns the name of the kind
+func (k Kind) String() string {
+	switch k {
+	case KindLink:
+		return "link"
+	case KindBridgeSnooping:
+		return "bridge-snooping"
+	case KindAddress:
+		return "address"
+	case KindMulticastGroup:
+		return "multicast-group"
+	case KindSysctl:
+		return "sysctl"
+	case KindNeighProxy:
+		return "neigh-proxy"
+	case KindRoute:
+		return "route"
+	case KindRouteRule:
+		return "route-rule"
+	case KindQdisc:
+		return "qdisc"
+	case KindClass:
+		return "class"
+	case KindFilter:
+		return "filter"
+	case KindNftChain:
+		return "nft-chain"
+	case KindNftRule:
+		return "nft-rule"
+	case KindXfrmState:
+		return "xfrm-state"
+	case KindXfrmPolicy:
+		return "xfrm-policy"
+	case KindVPPInterface:
+		return "vpp-interface"
+	case KindVPPUnnumbered:
+		return "vpp-unnumbered"
+	case KindVPPXConnect:
+		return "vpp-xconnect"
+	case KindVPPRoute:
+		return "vpp-route"
+	case KindVPPACL:
+		return "vpp-acl"
+	default:
+		return fmt.Sprintf("kind(%d)", int(k))
+	}
+}
+
+// Object is the desired (or observed) state of a host networking object
+type Object interface {
+	// Kind of the object
+	Kind() Kind
+	// Key identifying the object on the host
+	Key() string
+	// InSync reports whether the observed object matches this desired one
+	InSync(observed Object) bool
+}
+
+// Link is a network interface
+type Link struct {
+	// Name of the interface
+	Name string
+	// Link type (e.g., dummy, veth, vxlan, vlan, macvlan)
+	Type string
+	// Parent interface, for stacked link types
+	Parent string
+	// Peer interface name (veth only)
+	PeerName string
+	// VXLAN network identifier (vxlan only)
+	VNI int
+	// Remote tunnel endpoint (vxlan only)
+	Remote string
+	// UDP destination port (vxlan only)
+	Port int
+	// Mode of macvlan or ipvlan links
+	Mode string
+	// VLAN ID (vlan only)
+	VLAN int
+	// VLAN protocol, 802.1q (default) or 802.1ad (vlan only)
+	VLANProtocol string
+	// MTU, 0 keeps the default
+	MTU int
+	// Whether the interface is up
+	Up bool
+}
+
+// Kind implements Object
+func (l Link) Kind() Kind { return KindLink }
+
+// Key implements Object
+func (l Link) Key() string { return "link/" + l.Name }
+
+// InSync implements Object
+func (l Link) InSync(observed Object) bool {
+	o, ok := observed.(Link)
+	if !ok {
+		return false
+	}
+	if l.Type != "" && o.Type != "" && l.Type != o.Type {
+		return false
+	}
+	return (l.MTU == 0 || l.MTU == o.MTU) && l.Up == o.Up
+}
+
+// Address is an IP address assigned to an interface
+type Address struct {
+	// Interface the address is assigned to
+	Device string
+	// Address with prefix length in CIDR notation
+	CIDR string
+}
+
+// Kind implements Object
+func (a Address) Kind() Kind { return KindAddress }
+
+// Key implements Object
+func (a Address) Key() string { return "address/" + a.Device + "/" + a.CIDR }
+
+// InSync implements Object
+func (a Address) InSync(observed Object) bool {
+	_, ok := observed.(Address)
+	return ok
+}
+
+// BridgeSnooping is the IGMP/MLD snooping setting of a bridge. With
+// snooping the bridge forwards multicast only to the ports with members.
+type BridgeSnooping struct {
+	// Bridge interface
+	Bridge string
+	// Whether snooping is enabled
+	Enabled bool
+}
+
+// Kind implements Object
+func (b BridgeSnooping) Kind() Kind { return KindBridgeSnooping }
+
+// Key implements Object
+func (b BridgeSnooping) Key() string { return "bridge-snooping/" + b.Bridge }
+
+// InSync implements Object
+func (b BridgeSnooping) InSync(observed Object) bool {
+	o, ok := observed.(BridgeSnooping)
+	return ok && b.Enabled == o.Enabled
+}
+
+// MulticastGroup is a multicast group the host joins on an interface, so
+// IGMP/MLD reports draw the group's traffic to it
+type MulticastGroup struct {
+	// Interface the group is joined on
+	Device string
+	// Group address
+	Group string
+}
+
+// Kind implements Object
+func (m MulticastGroup) Kind() Kind { return KindMulticastGroup }
+
+// Key implements Object
+func (m MulticastGroup) Key() string { return "multicast-group/" + m.Device + "/" + m.Group }
+
+// InSync implements Object
+func (m MulticastGroup) InSync(observed Object) bool {
+	_, ok := observed.(MulticastGroup)
+	return ok
+}
+
+// cidr returns the group as a host prefix
+func (m MulticastGroup) cidr() string {
+	if strings.Contains(m.Group, ":") {
+		return m.Group + "/128"
+	}
+	return m.Group + "/32"
+}
+
+// Sysctl is a kernel parameter, typically one of an interface
+type Sysctl struct {
+	// Name in slash notation (e.g., net/ipv4/conf/eth0/forwarding), which
+	// keeps interface names with dots intact
+	Name string
+	// Desired value
+	Value string
+	// Value restored when the parameter is no longer desired
+	Reset string
+}
+
+// Kind implements Object
+func (s Sysctl) Kind() Kind { return KindSysctl }
+
+// Key implements Object
+func (s Sysctl) Key() string { return "sysctl/" + s.Name }
+
+// InSync implements Object
+func (s Sysctl) InSync(observed Object) bool {
+	o, ok := observed.(Sysctl)
+	return ok && s.Value == o.Value
+}
+
+// NeighProxy is a proxy ARP (IPv4) or NDP (IPv6) entry: the host answers
+// neighbor solicitations for the address on the interface
+type NeighProxy struct {
+	// Interface the solicitations are answered on
+	Device string
+	// Address answered for
+	Address string
+}
+
+// Kind implements Object
+func (n NeighProxy) Kind() Kind { return KindNeighProxy }
+
+// Key implements Object
+func (n NeighProxy) Key() string { return "neigh-proxy/" + n.Device + "/" + n.Address }
+
+// InSync implements Object
+func (n NeighProxy) InSync(observed Object) bool {
+	_, ok := observed.(NeighProxy)
+	return ok
+}
+
+// Kind implements Object
+func (r Route) Kind() Kind { return KindRoute }
+
+// Key implements Object
+func (r Route) Key() string {
+	if r.Table != 0 {
+		return fmt.Sprintf("route/%d/%s/%d", r.Table, r.Destination, r.Metric)
+	}
+	return fmt.Sprintf("route/%s/%d", r.Destination, r.Metric)
+}
+
+// InSync implements Object
+func (r Route) InSync(observed Object) bool {
+	o, ok := observed.(Route)
+	if !ok || r.Device != o.Device || r.Gateway != o.Gateway || len(r.Nexthops) != len(o.Nexthops) {
+		return false
+	}
+	for i, hop := range r.Nexthops {
+		observed := o.Nexthops[i]
+		if hop.Device != observed.Device || hop.Gateway != observed.Gateway || max(hop.Weight, 1) != max(observed.Weight, 1) {
+			return false
+		}
+	}
+	return true
+}
+
+// RouteRule is a policy routing rule looking up the routes of packets
+// with a firewall mark in a routing table
+type RouteRule struct {
+	// Firewall mark of the packets
+	Mark int
+	// Routing table looked up
+	Table int
+	// Priority of the rule, lower values are looked up first
+	Priority int
+	// Whether the rule applies to IPv6 instead of IPv4
+	IPv6 bool
+}
+
+// Kind implements Object
+func (r RouteRule) Kind() Kind { return KindRouteRule }
+
+// Key implements Object
+func (r RouteRule) Key() string {
+	family := "inet"
+	if r.IPv6 {
+		family = "inet6"
+	}
+	return fmt.Sprintf("rule/%s/%#x", family, r.Mark)
+}
+
+// InSync implements Object
+func (r RouteRule) InSync(observed Object) bool {
+	o, ok := observed.(RouteRule)
+	return ok && r.Table == o.Table && r.Priority == o.Priority
+}
+
+// Qdisc is a traffic control queueing discipline
+type Qdisc struct {
+	// Interface the qdisc is attached to
+	Device string
+	// Parent of the qdisc ("root", "ingress" or a class id)
+	Parent string
+	// Handle of the qdisc (e.g., "1:"), empty for the kernel's choice
+	Handle string
+	// Qdisc type (e.g., tbf, fq_codel, htb)
+	Type string
+	// Type specific parameters (e.g., "rate", "100mbit")
+	Params []string
+}
+
+// Kind implements Object
+func (q Qdisc) Kind() Kind { return KindQdisc }
+
+// Key implements Object
+func (q Qdisc) Key() string { return "qdisc/" + q.Device + "/" + q.parent() }
+
+// InSync implements Object. The kernel reports qdisc parameters in a
+// normalized form that can't be compared reliably, so qdiscs with
+// parameters are always replaced, which is idempotent.
+func (q Qdisc) InSync(observed Object) bool {
+	o, ok := observed.(Qdisc)
+	return ok && q.Type == o.Type && len(q.Params) == 0
+}
+
+// parent returns the parent of the qdisc, defaulting to root
+func (q Qdisc) parent() string {
+	if q.Parent == "" {
+		return "root"
+	}
+	return q.Parent
+}
+
+// Class is a traffic control class of a classful qdisc, with the qdisc
+// queueing its traffic if it is a leaf
+type Class struct {
+	// Interface the class is attached to
+	Device string
+	// Parent qdisc handle or class id
+	Parent string
+	// Class id (e.g., "1:10")
+	ID string
+	// Class type, the type of the parent qdisc (e.g., htb)
+	Type string
+	// Type specific parameters (e.g., "rate", "100mbit")
+	Params []string
+	// Type of the qdisc of a leaf class (e.g., fq_codel), empty for the
+	// kernel default
+	Leaf string
+}
+
+// Kind implements Object
+func (c Class) Kind() Kind { return KindClass }
+
+// Key implements Object
+func (c Class) Key() string { return "class/" + c.Device + "/" + c.ID }
+
+// InSync implements Object. Like those of qdiscs, the kernel reports class
+// parameters in a normalized form, so classes with parameters are always
+// replaced.
+func (c Class) InSync(observed Object) bool {
+	o, ok := observed.(Class)
+	return ok && c.Type == o.Type && len(c.Params) == 0 && c.Leaf == ""
+}
+
+// leafHandle returns the handle of the leaf qdisc of the class, its minor
+// number as major (e.g., "10:" for class "1:10")
+func (c Class) leafHandle() string {
+	_, minor, _ := strings.Cut(c.ID, ":")
+	return minor + ":"
+}
+
+// Filter is a tc filter with its actions, identified by its preference
+type Filter struct {
+	// Interface the filter is attached to
+	Device string
+	// Parent qdisc of the filter ("ingress" or a qdisc handle)
+	Parent string
+	// Preference of the filter, lower ones match first
+	Pref int
+	// Protocol matched, all by default
+	Protocol string
+	// Classifier and its match (e.g., "flower", "dst_mac", "ff:ff:ff:ff:ff:ff")
+	Match []string
+	// Actions on matching packets (e.g., "action", "drop")
+	Actions []string
+}
+
+// Kind implements Object
+func (f Filter) Kind() Kind { return KindFilter }
+
+// Key implements Object
+func (f Filter) Key() string { return fmt.Sprintf("filter/%s/%s/%d", f.Device, f.Parent, f.Pref) }
+
+// InSync implements Object. Like those of qdiscs, the kernel reports
+// filters in a normalized form, so they are always replaced.
+func (f Filter) InSync(observed Object) bool {
+	_, ok := observed.(Filter)
+	return ok && len(f.Match) == 0 && len(f.Actions) == 0
+}
+
+// protocol returns the protocol matched by the filter, defaulting to all
+func (f Filter) protocol() string {
+	if f.Protocol == "" {
+		return "all"
+	}
+	return f.Protocol
+}
+
+// NftChain is an nftables base chain, created with its table
+type NftChain struct {
+	// Address family of the table (e.g., inet, ip, bridge)
+	Family string
+	// Table the chain lives in, shared and never removed
+	Table string
+	// Name of the chain
+	Name string
+	// Hook the chain is attached to (e.g., prerouting, forward)
+	Hook string
+	// Priority of the chain on the hook
+	Priority int
+}
+
+// Kind implements Object
+func (n NftChain) Kind() Kind { return KindNftChain }
+
+// Key implements Object
+func (n NftChain) Key() string {
+	return strings.Join([]string{"nft-chain", n.Family, n.Table, n.Name}, "/")
+}
+
+// InSync implements Object. Hook and priority of a chain can't change,
+// so an existing chain is in sync.
+func (n NftChain) InSync(observed Object) bool {
+	_, ok := observed.(NftChain)
+	return ok
+}
+
+// NftRule is an nftables rule, identified by the comment it carries
+type NftRule struct {
+	// Address family of the table (e.g., inet, ip, bridge)
+	Family string
+	// Table and chain the rule lives in
+	Table string
+	Chain string
+	// Unique name of the rule
+	Name string
+	// Rule expression (e.g., "ip daddr 10.0.0.5 accept")
+	Rule string
+	// Hash of the rule as observed on the host, set by backends only
+	hash string
+}
+
+// Kind implements Object
+func (n NftRule) Kind() Kind { return KindNftRule }
+
+// Key implements Object
+func (n NftRule) Key() string {
+	return strings.Join([]string{"nft", n.Family, n.Table, n.Chain, n.Name}, "/")
+}
+
+// InSync implements Object. nft normalizes the rule text, so rules are
+// compared by the hash stored in their comment instead.
+func (n NftRule) InSync(observed Object) bool {
+	o, ok := observed.(NftRule)
+	return ok && o.ruleHash() == n.ruleHash()
+}
+
+// comment returns the comment identifying the rule on the host
+func (n NftRule) comment() string {
+	return "nsm:" + n.Name + ":" + n.ruleHash()
+}
+
+// ruleHash returns a short hash of the rule expression
+func (n NftRule) ruleHash() string {
+	if n.hash != "" {
+		return n.hash
+	}
+	sum := sha256.Sum256([]byte(n.Rule))
+	return hex.EncodeToString(sum[:4])
+}
+
+// XfrmState is an IPsec security association encrypting the traffic from
+// one address to another with ESP in transport mode
+type XfrmState struct {
+	// Addresses the association protects the traffic between
+	Src string
+	Dst string
+	// Security parameter index identifying the association at the receiver
+	SPI uint32
+	// Request ID binding the association to the templates of its policies
+	Reqid int
+	// AES-GCM key with the 4 byte salt appended (rfc4106), hex encoded
+	AEADKey string
+	// Device encrypting the traffic inline, empty to encrypt on the CPU
+	OffloadDevice string
+	// Direction of the traffic on the offload device, in or out
+	OffloadDir string
+}
+
+// Kind implements Object
+func (x XfrmState) Kind() Kind { return KindXfrmState }
+
+// Key implements Object
+func (x XfrmState) Key() string { return fmt.Sprintf("xfrm-state/%s/esp/%#x", x.Dst, x.SPI) }
+
+// InSync implements Object. The SPI is derived from the key, so an
+// association with the same SPI has the same key; only the offload can
+// differ.
+func (x XfrmState) InSync(observed Object) bool {
+	o, ok := observed.(XfrmState)
+	return ok && x.OffloadDevice == o.OffloadDevice
+}
+
+// XfrmPolicy is an IPsec policy requiring ESP for the traffic between two
+// prefixes in one direction
+type XfrmPolicy struct {
+	// Prefixes of the traffic the policy selects
+	Src string
+	Dst string
+	// Direction of the traffic, in or out
+	Dir string
+	// Addresses of the association the traffic is protected with
+	TmplSrc string
+	TmplDst string
+	// SPI of the association the traffic is sent with, 0 for any
+	SPI uint32
+	// Request ID of the associations
+	Reqid int
+}
+
+// Kind implements Object
+func (x XfrmPolicy) Kind() Kind { return KindXfrmPolicy }
+
+// Key implements Object
+func (x XfrmPolicy) Key() string { return "xfrm-policy/" + x.Dir + "/" + x.Src + "/" + x.Dst }
+
+// InSync implements Object
+func (x XfrmPolicy) InSync(observed Object) bool {
+	o, ok := observed.(XfrmPolicy)
+	return ok && x.SPI == o.SPI && x.Reqid == o.Reqid && x.TmplSrc == o.TmplSrc && x.TmplDst == o.TmplDst
+}
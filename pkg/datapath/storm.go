@@ -0,0 +1,83 @@
+package datapath
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/akos011221/nsm/pkg/hardware"
+)
+
+// Preferences of the storm control filters. Broadcast matches first, as
+// broadcast frames also carry the multicast bit.
+const (
+	stormBroadcastPref = 1
+	stormMulticastPref = 2
+)
+
+// VFStormControl is a hardware.StormController policing the broadcast and
+// multicast packets a VF sends with tc on its host interface (the
+// representor in switchdev mode), before they reach the physical function
+type VFStormControl struct {
+	// Applier programming the filters
+	applier *Applier
+}
+
+// NewVFStormControl creates a new tc based VF storm control
+func NewVFStormControl(applier *Applier) *VFStormControl {
+	return &VFStormControl{applier: applier}
+}
+
+// Apply implements hardware.StormController
+func (s *VFStormControl) Apply(ctx context.Context, vf hardware.VirtualFunction, policy hardware.StormPolicy) error {
+	if vf.InterfaceName == "" {
+		return fmt.Errorf("VF %d of %s has no network interface to police", vf.VFID, vf.PFName)
+	}
+	if _, err := s.applier.Apply(ctx, stormOwner(vf), StormControlObjects(vf.InterfaceName, policy)); err != nil {
+		return fmt.Errorf("failed to program storm control on %s: %w", vf.InterfaceName, err)
+	}
+	return nil
+}
+
+// Remove implements hardware.StormController
+func (s *VFStormControl) Remove(ctx context.Context, vf hardware.VirtualFunction) error {
+	if err := s.applier.Remove(ctx, stormOwner(vf)); err != nil {
+		return fmt.Errorf("failed to lift storm control on %s: %w", vf.InterfaceName, err)
+	}
+	return nil
+}
+
+// StormControlObjects returns the ingress qdisc and police filters
+// dropping the broadcast and multicast packets of an interface beyond the
+// limits of a policy. Without limits there are none.
+func StormControlObjects(device string, policy hardware.StormPolicy) []Object {
+	if !policy.Enabled() {
+		return nil
+	}
+	objs := []Object{Qdisc{Device: device, Parent: "ingress", Type: "ingress"}}
+	if policy.BroadcastPPS > 0 {
+		objs = append(objs, stormFilter(device, stormBroadcastPref, "ff:ff:ff:ff:ff:ff", policy.BroadcastPPS))
+	}
+	if policy.MulticastPPS > 0 {
+		objs = append(objs, stormFilter(device, stormMulticastPref, "01:00:00:00:00:00/01:00:00:00:00:00", policy.MulticastPPS))
+	}
+	return objs
+}
+
+// stormFilter returns a filter policing the packets to a destination MAC
+// to a rate, allowing a burst of one second
+func stormFilter(device string, pref int, dstMAC string, pps int) Filter {
+	rate := strconv.Itoa(pps)
+	return Filter{
+		Device:  device,
+		Parent:  "ingress",
+		Pref:    pref,
+		Match:   []string{"flower", "dst_mac", dstMAC},
+		Actions: []string{"action", "police", "pkts_rate", rate, "pkts_burst", rate, "conform-exceed", "drop/ok"},
+	}
+}
+
+// stormOwner returns the applier owner of the storm control of a VF
+func stormOwner(vf hardware.VirtualFunction) string {
+	return "storm/" + vf.PCIAddress
+}
@@ -0,0 +1,59 @@
+package datapath
+
+import (
+	"context"
+	"testing"
+
+	"github.com/akos011221/nsm/pkg/hardware"
+	"github.com/sirupsen/logrus"
+)
+
+func TestStormControlObjects(t *testing.T) {
+	if objs := StormControlObjects("eth0_vf0", hardware.StormPolicy{}); len(objs) != 0 {
+		t.Errorf("unexpected objects without limits: %v", objs)
+	}
+
+	objs := StormControlObjects("eth0_vf0", hardware.StormPolicy{MulticastPPS: 500})
+	if len(objs) != 2 || objs[0].Key() != "qdisc/eth0_vf0/ingress" {
+		t.Fatalf("unexpected objects %v", objs)
+	}
+	filter, ok := objs[1].(Filter)
+	if !ok || filter.Pref != stormMulticastPref || filter.Match[2] != "01:00:00:00:00:00/01:00:00:00:00:00" || filter.Actions[3] != "500" {
+		t.Errorf("unexpected multicast filter %+v", objs[1])
+	}
+}
+
+func TestVFStormControl(t *testing.T) {
+	host := newMemBackend()
+	sc := NewVFStormControl(NewApplier(host, logrus.New()))
+	vf := hardware.VirtualFunction{PFName: "eth0", VFID: 0, PCIAddress: "0000:3b:02.0", InterfaceName: "eth0_vf0"}
+	ctx := context.Background()
+
+	if err := sc.Apply(ctx, vf, hardware.StormPolicy{BroadcastPPS: 100, MulticastPPS: 1000}); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	for _, key := range []string{"qdisc/eth0_vf0/ingress", "filter/eth0_vf0/ingress/1", "filter/eth0_vf0/ingress/2"} {
+		if _, ok := host.objects[key]; !ok {
+			t.Errorf("%s not programmed", key)
+		}
+	}
+
+	// lifting the multicast limit removes its filter only
+	if err := sc.Apply(ctx, vf, hardware.StormPolicy{BroadcastPPS: 100}); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if _, ok := host.objects["filter/eth0_vf0/ingress/2"]; ok {
+		t.Errorf("multicast filter left after its limit was lifted")
+	}
+
+	if err := sc.Remove(ctx, vf); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	if len(host.objects) != 0 {
+		t.Errorf("objects left after removal: %v", host.objects)
+	}
+
+	if err := sc.Apply(ctx, hardware.VirtualFunction{PFName: "eth0", VFID: 1}, hardware.StormPolicy{BroadcastPPS: 1}); err == nil {
+		t.Errorf("expected an error for a VF without an interface")
+	}
+}
@@ -0,0 +1,91 @@
+package datapath
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Step is a single change of a datapath setup (VF config, routes, tunnel, QoS)
+type Step struct {
+	// Name of the step, reported when it fails
+	Name string
+	// Apply performs the change
+	Apply func(ctx context.Context) error
+	// Rollback reverts the change, nil if there is nothing to revert
+	Rollback func(ctx context.Context) error
+}
+
+// StepError is returned when a step of a transaction failed
+type StepError struct {
+	// Name of the step that failed
+	Step string
+	// Error of the failed step
+	Err error
+	// Names of the previously applied steps that were rolled back
+	RolledBack []string
+	// Errors of the rollbacks that failed, the host may be half-configured
+	RollbackErrs []error
+}
+
+// Error implements the error interface
+func (e *StepError) Error() string {
+	msg := fmt.Sprintf("step %s failed: %v", e.Step, e.Err)
+	if len(e.RollbackErrs) > 0 {
+		msg += fmt.Sprintf(" (rollback failed: %v)", errors.Join(e.RollbackErrs...))
+	}
+	return msg
+}
+
+// Unwrap returns the error of the failed step
+func (e *StepError) Unwrap() error {
+	return e.Err
+}
+
+// Transaction applies a sequence of steps all-or-nothing
+type Transaction struct {
+	// Steps in the order they are applied
+	steps []Step
+}
+
+// NewTransaction creates a transaction of the steps
+func NewTransaction(steps ...Step) *Transaction {
+	return &Transaction{steps: steps}
+}
+
+// Add appends a step to the transaction
+func (t *Transaction) Add(step Step) {
+	t.steps = append(t.steps, step)
+}
+
+// Commit applies the steps in order. If a step fails, the already applied
+// steps are rolled back in reverse order and a *StepError is returned.
+func (t *Transaction) Commit(ctx context.Context) error {
+	for i, step := range t.steps {
+		if err := step.Apply(ctx); err != nil {
+			stepErr := &StepError{Step: step.Name, Err: err}
+			t.rollback(ctx, t.steps[:i], stepErr)
+			return stepErr
+		}
+	}
+	return nil
+}
+
+// rollback reverts the applied steps in reverse order, continuing past
+// failures so as little as possible is left behind
+func (t *Transaction) rollback(ctx context.Context, applied []Step, stepErr *StepError) {
+	// rollbacks must run even if the setup was cancelled
+	ctx = context.WithoutCancel(ctx)
+
+	for i := len(applied) - 1; i >= 0; i-- {
+		step := applied[i]
+		if step.Rollback == nil {
+			continue
+		}
+		if err := step.Rollback(ctx); err != nil {
+			stepErr.RollbackErrs = append(stepErr.RollbackErrs, fmt.Errorf("%s: %w", step.Name, err))
+			continue
+		}
+		stepErr.RolledBack = append(stepErr.RolledBack, step.Name)
+	}
+}
@@ -0,0 +1,113 @@
+package datapath
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// recordingStep builds a step that logs its apply and rollback calls
+func recordingStep(name string, log *[]string, applyErr, rollbackErr error) Step {
+	return Step{
+		Name: name,
+		Apply: func(ctx context.Context) error {
+			if applyErr != nil {
+				return applyErr
+			}
+			*log = append(*log, "apply "+name)
+			return nil
+		},
+		Rollback: func(ctx context.Context) error {
+			*log = append(*log, "rollback "+name)
+			return rollbackErr
+		},
+	}
+}
+
+func TestTransactionCommit(t *testing.T) {
+	var log []string
+	tx := NewTransaction(recordingStep("vf", &log, nil, nil), recordingStep("routes", &log, nil, nil))
+	tx.Add(recordingStep("qos", &log, nil, nil))
+
+	if err := tx.Commit(context.Background()); err != nil {
+		t.Fatalf("Commit() error = %v", err)
+	}
+	want := []string{"apply vf", "apply routes", "apply qos"}
+	if !reflect.DeepEqual(log, want) {
+		t.Errorf("calls = %v, want %v", log, want)
+	}
+}
+
+func TestTransactionRollsBackOnFailure(t *testing.T) {
+	var log []string
+	boom := errors.New("boom")
+	tx := NewTransaction(
+		recordingStep("vf", &log, nil, nil),
+		recordingStep("tunnel", &log, nil, nil),
+		recordingStep("routes", &log, boom, nil),
+		recordingStep("qos", &log, nil, nil),
+	)
+
+	err := tx.Commit(context.Background())
+	var stepErr *StepError
+	if !errors.As(err, &stepErr) || !errors.Is(err, boom) {
+		t.Fatalf("Commit() error = %v, want StepError wrapping boom", err)
+	}
+	if stepErr.Step != "routes" {
+		t.Errorf("failed step = %s, want routes", stepErr.Step)
+	}
+
+	want := []string{"apply vf", "apply tunnel", "rollback tunnel", "rollback vf"}
+	if !reflect.DeepEqual(log, want) {
+		t.Errorf("calls = %v, want %v", log, want)
+	}
+	if !reflect.DeepEqual(stepErr.RolledBack, []string{"tunnel", "vf"}) {
+		t.Errorf("rolled back = %v", stepErr.RolledBack)
+	}
+}
+
+func TestTransactionContinuesPastRollbackFailure(t *testing.T) {
+	var log []string
+	tx := NewTransaction(
+		recordingStep("vf", &log, nil, nil),
+		recordingStep("tunnel", &log, nil, errors.New("busy")),
+		recordingStep("routes", &log, errors.New("boom"), nil),
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var stepErr *StepError
+	if err := tx.Commit(ctx); !errors.As(err, &stepErr) {
+		t.Fatalf("Commit() error = %v, want StepError", err)
+	}
+	if len(stepErr.RollbackErrs) != 1 || !reflect.DeepEqual(stepErr.RolledBack, []string{"vf"}) {
+		t.Errorf("unexpected rollback result: %+v", stepErr)
+	}
+}
+
+func TestWarmStandbyEstablishRollsBack(t *testing.T) {
+	router := &failingRouter{fakeRouter: newFakeRouter(), failDevice: "wwan0"}
+	w := NewWarmStandby(router, "10.10.0.0/24", Path{Name: "fiber", Device: "eth0"}, Path{Name: "lte", Device: "wwan0"})
+
+	if err := w.Establish(); err == nil {
+		t.Fatalf("expected establish to fail")
+	}
+	if len(router.routes) != 0 {
+		t.Errorf("active route left behind: %v", router.routes)
+	}
+}
+
+// failingRouter fails to install routes through one device
+type failingRouter struct {
+	*fakeRouter
+	failDevice string
+}
+
+func (f *failingRouter) ReplaceRoute(route Route) error {
+	if route.Device == f.failDevice {
+		return errors.New("no such device")
+	}
+	return f.fakeRouter.ReplaceRoute(route)
+}
@@ -0,0 +1,43 @@
+package datapath
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+)
+
+// ErrUnsupported is returned for connections no datapath of the node serves
+var ErrUnsupported = errors.New("no datapath of the node serves the connection")
+
+// UnsupportedDatapath ends the datapath chain of a node: it refuses the
+// connections none of the datapaths before it served, instead of letting
+// them be reported established with nothing programmed for them
+type UnsupportedDatapath struct{}
+
+// Setup implements connection.Datapath
+func (UnsupportedDatapath) Setup(ctx context.Context, conn *nsmv1.NetworkConnection) error {
+	return unsupported(conn)
+}
+
+// Plan implements Planner
+func (UnsupportedDatapath) Plan(ctx context.Context, conn *nsmv1.NetworkConnection) (*Plan, error) {
+	return nil, unsupported(conn)
+}
+
+// Teardown implements connection.Datapath. Nothing was set up for the
+// refused connections, so there is nothing to remove.
+func (UnsupportedDatapath) Teardown(ctx context.Context, conn *nsmv1.NetworkConnection, keepAllocations bool) error {
+	return nil
+}
+
+// unsupported explains which datapaths a refused connection could use
+func unsupported(conn *nsmv1.NetworkConnection) error {
+	datapath := conn.Status.Datapath
+	if datapath == "" {
+		datapath = conn.Spec.ConnectionType
+	}
+	return fmt.Errorf("%w: %s connections are forwarded by the macvlan or ipvlan fallback, VPP (dataplane vpp) or a vhost-user dataplane, none of which serves it",
+		ErrUnsupported, datapath)
+}
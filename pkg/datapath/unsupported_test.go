@@ -0,0 +1,40 @@
+package datapath
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/netutil"
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestUnsupportedDatapathEndsTheChain(t *testing.T) {
+	nl := netutil.NewFake()
+	nl.Links["eth0"] = netutil.Link{Name: "eth0", Type: "device", Up: true}
+	applier := NewApplier(NewNetlinkBackend(nl, newMemBackend()), logrus.New())
+	chain := NewFallbackDatapath(applier, "eth0", UnsupportedDatapath{})
+
+	conn := &nsmv1.NetworkConnection{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "edge", Name: "telemetry"},
+		Spec:       nsmv1.NetworkConnectionSpec{ConnectionType: nsmv1.ConnectionTypeVXLAN},
+		Status:     nsmv1.NetworkConnectionStatus{Datapath: nsmv1.ConnectionTypeVXLAN},
+	}
+	if err := chain.Setup(context.Background(), conn); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("Setup() of a vxlan connection error = %v, want ErrUnsupported", err)
+	}
+	if _, err := chain.Plan(context.Background(), conn); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("Plan() of a vxlan connection error = %v, want ErrUnsupported", err)
+	}
+	if err := chain.Teardown(context.Background(), conn, false); err != nil {
+		t.Errorf("Teardown() of a refused connection error = %v", err)
+	}
+
+	// the datapaths before it still serve their connections
+	conn.Status.Datapath = nsmv1.DatapathMacvlan
+	if err := chain.Setup(context.Background(), conn); err != nil {
+		t.Errorf("Setup() of a fallback connection error = %v", err)
+	}
+}
@@ -0,0 +1,50 @@
+package datapath
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/akos011221/nsm/pkg/hardware"
+	"github.com/akos011221/nsm/pkg/netutil"
+)
+
+// zeroMAC clears the administrative MAC of a VF
+const zeroMAC = "00:00:00:00:00:00"
+
+// VFConfigurer is a hardware.VFConfigurer programming the VFs on their PF
+// through netlink, as "ip link set <pf> vf <n> mac/vlan/spoofchk/trust/
+// max_tx_rate" does
+type VFConfigurer struct {
+	// Netlink operations
+	nl netutil.Interface
+}
+
+// NewVFConfigurer creates a new netlink backed VF configurer
+func NewVFConfigurer(nl netutil.Interface) *VFConfigurer {
+	return &VFConfigurer{nl: nl}
+}
+
+// Configure implements hardware.VFConfigurer. The MAC is cleared with the
+// zero address, the VLAN with VLAN 0 and the rate limits with 0.
+func (c *VFConfigurer) Configure(ctx context.Context, vf hardware.VirtualFunction, cfg hardware.VFConfig) error {
+	mac := zeroMAC
+	if cfg.MAC != "" {
+		mac = cfg.MAC
+	}
+	if err := c.nl.VFSetMAC(vf.PFName, vf.VFID, mac); err != nil {
+		return fmt.Errorf("failed to set the MAC of VF %d of %s: %w", vf.VFID, vf.PFName, err)
+	}
+	if err := c.nl.VFSetVLAN(vf.PFName, vf.VFID, cfg.VLAN, cfg.QoS); err != nil {
+		return fmt.Errorf("failed to set the VLAN of VF %d of %s: %w", vf.VFID, vf.PFName, err)
+	}
+	if err := c.nl.VFSetSpoofCheck(vf.PFName, vf.VFID, cfg.SpoofCheck); err != nil {
+		return fmt.Errorf("failed to set the spoof check of VF %d of %s: %w", vf.VFID, vf.PFName, err)
+	}
+	if err := c.nl.VFSetTrust(vf.PFName, vf.VFID, cfg.Trust); err != nil {
+		return fmt.Errorf("failed to set the trust of VF %d of %s: %w", vf.VFID, vf.PFName, err)
+	}
+	if err := c.nl.VFSetRate(vf.PFName, vf.VFID, cfg.MinTxRateMbps, cfg.MaxTxRateMbps); err != nil {
+		return fmt.Errorf("failed to set the rate limits of VF %d of %s: %w", vf.VFID, vf.PFName, err)
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package datapath
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/akos011221/nsm/pkg/hardware"
+	"github.com/akos011221/nsm/pkg/netutil"
+)
+
+func TestVFConfigurer(t *testing.T) {
+	nl := netutil.NewFake()
+	nl.Links["eth0"] = netutil.Link{Name: "eth0", Type: "device"}
+	nl.VFs["eth0"] = []netutil.VF{{ID: 3, SpoofCheck: true}}
+	c := NewVFConfigurer(nl)
+	vf := hardware.VirtualFunction{PFName: "eth0", VFID: 3}
+
+	cfg := hardware.VFConfig{MAC: "02:00:00:00:01:01", VLAN: 100, QoS: 3, Trust: true, MaxTxRateMbps: 1000}
+	if err := c.Configure(context.Background(), vf, cfg); err != nil {
+		t.Fatalf("Configure() error = %v", err)
+	}
+	want := netutil.VF{ID: 3, MAC: "02:00:00:00:01:01", VLAN: 100, QoS: 3, Trust: true, MaxTxRateMbps: 1000}
+	if got := nl.VFs["eth0"][0]; got != want {
+		t.Errorf("VF = %+v, want %+v", got, want)
+	}
+
+	// the defaults clear what the previous pod set
+	if err := c.Configure(context.Background(), vf, hardware.DefaultVFConfig); err != nil {
+		t.Fatalf("Configure() error = %v", err)
+	}
+	if got := nl.VFs["eth0"][0]; got != (netutil.VF{ID: 3, SpoofCheck: true}) {
+		t.Errorf("VF after reset = %+v, want the defaults", got)
+	}
+
+	nl.Errors["VFSetTrust"] = errors.New("EOPNOTSUPP")
+	if err := c.Configure(context.Background(), vf, cfg); err == nil {
+		t.Error("expected an error when the NIC refuses a setting")
+	}
+}
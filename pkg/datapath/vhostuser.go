@@ -0,0 +1,251 @@
+package datapath
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/connection"
+)
+
+// Userspace dataplanes serving vhost-user ports
+const (
+	// VhostUserOVS is Open vSwitch with its DPDK datapath
+	VhostUserOVS = "ovs"
+	// VhostUserVPP is the FD.io Vector Packet Processor
+	VhostUserVPP = "vpp"
+)
+
+// VhostUserSocketName is the name of the socket in the directory of a
+// connection
+const VhostUserSocketName = "vhost-user.sock"
+
+// VhostUserDataplane adds vhost-user ports to a userspace dataplane. The
+// ports are clients of the sockets the workloads serve.
+type VhostUserDataplane interface {
+	// Name of the dataplane (ovs, vpp)
+	Name() string
+	// AddPort adds a port connecting to a socket and returns its interface,
+	// adding an existing port returns it
+	AddPort(ctx context.Context, name, socket string) (string, error)
+	// DeletePort deletes the port of a socket, deleting a missing port is
+	// not an error
+	DeletePort(ctx context.Context, iface, socket string) error
+}
+
+// OVSDataplane adds the vhost-user ports to a bridge of OVS-DPDK with
+// ovs-vsctl
+type OVSDataplane struct {
+	// Bridge the ports are added to
+	bridge string
+	// Runs the commands, replaceable for tests
+	run CommandRunner
+}
+
+// NewOVSDataplane creates a dataplane adding the ports to an OVS bridge
+func NewOVSDataplane(bridge string) *OVSDataplane {
+	return &OVSDataplane{bridge: bridge, run: runCommand}
+}
+
+// Name implements VhostUserDataplane
+func (o *OVSDataplane) Name() string {
+	return VhostUserOVS
+}
+
+// AddPort implements VhostUserDataplane. A dpdkvhostuserclient port
+// reconnects to the socket when the workload restarts, and the workload
+// keeps serving it when OVS restarts.
+func (o *OVSDataplane) AddPort(ctx context.Context, name, socket string) (string, error) {
+	if _, err := o.run(ctx, "ovs-vsctl", "--may-exist", "add-port", o.bridge, name,
+		"--", "set", "Interface", name, "type=dpdkvhostuserclient", "options:vhost-server-path="+socket); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// DeletePort implements VhostUserDataplane
+func (o *OVSDataplane) DeletePort(ctx context.Context, iface, socket string) error {
+	_, err := o.run(ctx, "ovs-vsctl", "--if-exists", "del-port", o.bridge, iface)
+	return err
+}
+
+// VPPDataplane adds the vhost-user ports to VPP with vppctl. VPP names the
+// interfaces itself, they are found by their socket.
+type VPPDataplane struct {
+	// Bridge domain the interfaces join, empty to leave them unbridged
+	bridgeDomain string
+	// Runs the commands, replaceable for tests
+	run CommandRunner
+}
+
+// NewVPPDataplane creates a dataplane adding the ports to VPP
+func NewVPPDataplane(bridgeDomain string) *VPPDataplane {
+	return &VPPDataplane{bridgeDomain: bridgeDomain, run: runCommand}
+}
+
+// Name implements VhostUserDataplane
+func (v *VPPDataplane) Name() string {
+	return VhostUserVPP
+}
+
+// AddPort implements VhostUserDataplane. The interface is created in
+// client mode, brought up and added to the bridge domain, which VPP
+// creates if it doesn't exist.
+func (v *VPPDataplane) AddPort(ctx context.Context, name, socket string) (string, error) {
+	iface, err := v.lookup(ctx, socket)
+	if err != nil {
+		return "", err
+	}
+	if iface == "" {
+		out, err := v.run(ctx, "vppctl", "create", "vhost-user", "socket", socket)
+		if err != nil {
+			return "", err
+		}
+		// vppctl exits successfully on CLI errors, the output tells
+		iface = strings.TrimSpace(string(out))
+		if !strings.HasPrefix(iface, "VirtualEthernet") {
+			return "", fmt.Errorf("failed to create vhost-user interface for %s: %s", socket, iface)
+		}
+	}
+	if _, err := v.run(ctx, "vppctl", "set", "interface", "state", iface, "up"); err != nil {
+		return "", err
+	}
+	if v.bridgeDomain != "" {
+		if _, err := v.run(ctx, "vppctl", "set", "interface", "l2", "bridge", iface, v.bridgeDomain); err != nil {
+			return "", err
+		}
+	}
+	return iface, nil
+}
+
+// DeletePort implements VhostUserDataplane. The interface is looked up by
+// its socket, the one recorded may have been renumbered by a restart of
+// VPP.
+func (v *VPPDataplane) DeletePort(ctx context.Context, iface, socket string) error {
+	iface, err := v.lookup(ctx, socket)
+	if err != nil || iface == "" {
+		return err
+	}
+	_, err = v.run(ctx, "vppctl", "delete", "vhost-user", iface)
+	return err
+}
+
+// lookup returns the vhost-user interface connected to a socket, empty if
+// there is none
+func (v *VPPDataplane) lookup(ctx context.Context, socket string) (string, error) {
+	out, err := v.run(ctx, "vppctl", "show", "vhost-user")
+	if err != nil {
+		return "", err
+	}
+	// e.g. "Interface: VirtualEthernet0/0/0 (ifindex 1)" followed by
+	// "  socket filename /var/run/... type client errno "Success""
+	var iface string
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		switch {
+		case len(fields) >= 2 && fields[0] == "Interface:":
+			iface = fields[1]
+		case len(fields) >= 3 && fields[0] == "socket" && fields[1] == "filename" && fields[2] == socket:
+			return iface, nil
+		}
+	}
+	return "", nil
+}
+
+// VhostUserDatapath serves vhostuser connections with a port of a
+// userspace dataplane connecting to a socket in a directory of the
+// connection, and passes all others to the next datapath. The workload,
+// a DPDK application with a virtio-user device or the QEMU of a VM,
+// serves the socket, so either side can restart without the other.
+type VhostUserDatapath struct {
+	// Dataplane the ports are added to
+	dataplane VhostUserDataplane
+	// Directory holding the directories of the connections
+	dir string
+	// Datapath for all other connections
+	next connection.Datapath
+}
+
+// NewVhostUserDatapath creates a new vhost-user datapath
+func NewVhostUserDatapath(dataplane VhostUserDataplane, dir string, next connection.Datapath) *VhostUserDatapath {
+	return &VhostUserDatapath{
+		dataplane: dataplane,
+		dir:       dir,
+		next:      next,
+	}
+}
+
+// Setup implements connection.Datapath, recording the port in the status
+func (d *VhostUserDatapath) Setup(ctx context.Context, conn *nsmv1.NetworkConnection) error {
+	if conn.Status.Datapath != nsmv1.ConnectionTypeVhostUser {
+		return d.next.Setup(ctx, conn)
+	}
+
+	// the workload, often not root (QEMU runs as qemu), creates the socket
+	dir := VhostUserSocketDir(d.dir, conn)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create vhost-user socket directory: %w", err)
+	}
+	if err := os.Chmod(dir, 0o777); err != nil {
+		return fmt.Errorf("failed to open vhost-user socket directory: %w", err)
+	}
+	socket := filepath.Join(dir, VhostUserSocketName)
+	iface, err := d.dataplane.AddPort(ctx, VhostUserPortName(conn), socket)
+	if err != nil {
+		return fmt.Errorf("failed to add vhost-user port to %s: %w", d.dataplane.Name(), err)
+	}
+	conn.Status.VhostUser = &nsmv1.VhostUserStatus{
+		Dataplane:  d.dataplane.Name(),
+		SocketPath: socket,
+		Interface:  iface,
+	}
+	return nil
+}
+
+// Teardown implements connection.Datapath. The port is deleted even when
+// allocations are kept, the directory is kept along with them so the
+// workload's socket stays where it expects it.
+func (d *VhostUserDatapath) Teardown(ctx context.Context, conn *nsmv1.NetworkConnection, keepAllocations bool) error {
+	if conn.Status.Datapath != nsmv1.ConnectionTypeVhostUser {
+		return d.next.Teardown(ctx, conn, keepAllocations)
+	}
+
+	dir := VhostUserSocketDir(d.dir, conn)
+	iface := VhostUserPortName(conn)
+	if status := conn.Status.VhostUser; status != nil && status.Interface != "" {
+		iface = status.Interface
+	}
+	if err := d.dataplane.DeletePort(ctx, iface, filepath.Join(dir, VhostUserSocketName)); err != nil {
+		return fmt.Errorf("failed to delete vhost-user port from %s: %w", d.dataplane.Name(), err)
+	}
+	if keepAllocations {
+		return nil
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("failed to remove vhost-user socket directory: %w", err)
+	}
+	// the namespace directory goes with its last connection
+	_ = os.Remove(filepath.Dir(dir))
+	return nil
+}
+
+// VhostUserSocketDir returns the directory holding the socket of a
+// connection, the workload mounts it
+func VhostUserSocketDir(dir string, conn *nsmv1.NetworkConnection) string {
+	return filepath.Join(dir, conn.Namespace, conn.Name)
+}
+
+// VhostUserPortName returns a stable port name for the connection, within
+// the 15 character limit of the kernel OVS also holds its ports to
+func VhostUserPortName(conn *nsmv1.NetworkConnection) string {
+	sum := sha256.Sum256([]byte(conn.Namespace + "/" + conn.Name))
+	return "nsmvu" + hex.EncodeToString(sum[:4])
+}
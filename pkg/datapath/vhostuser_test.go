@@ -0,0 +1,131 @@
+package datapath
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func vhostUserConnection() *nsmv1.NetworkConnection {
+	return &nsmv1.NetworkConnection{
+		ObjectMeta: metav1.ObjectMeta{Name: "conn", Namespace: "edge"},
+		Spec:       nsmv1.NetworkConnectionSpec{ConnectionType: nsmv1.ConnectionTypeVhostUser},
+		Status:     nsmv1.NetworkConnectionStatus{Datapath: nsmv1.ConnectionTypeVhostUser},
+	}
+}
+
+func TestVhostUserDatapathOVS(t *testing.T) {
+	runner := &scriptedRunner{}
+	dir := t.TempDir()
+	next := &countingDatapath{}
+	d := NewVhostUserDatapath(&OVSDataplane{bridge: "br-nsm", run: runner.run}, dir, next)
+
+	conn := vhostUserConnection()
+	if err := d.Setup(context.Background(), conn); err != nil {
+		t.Fatalf("Setup() error = %v", err)
+	}
+	port := VhostUserPortName(conn)
+	socket := filepath.Join(dir, "edge", "conn", VhostUserSocketName)
+	want := "ovs-vsctl --may-exist add-port br-nsm " + port + " -- set Interface " + port +
+		" type=dpdkvhostuserclient options:vhost-server-path=" + socket
+	if len(runner.calls) != 1 || runner.calls[0] != want {
+		t.Errorf("calls = %v, want %s", runner.calls, want)
+	}
+	if len(port) > 15 {
+		t.Errorf("port name %s exceeds the kernel limit", port)
+	}
+	status := conn.Status.VhostUser
+	if status == nil || status.Dataplane != VhostUserOVS || status.SocketPath != socket || status.Interface != port {
+		t.Errorf("unexpected vhost-user status %+v", status)
+	}
+	if info, err := os.Stat(filepath.Dir(socket)); err != nil || info.Mode().Perm() != 0o777 {
+		t.Errorf("socket directory not writable by the workload: %v", err)
+	}
+
+	// an administratively down connection keeps its directory
+	runner.calls = nil
+	if err := d.Teardown(context.Background(), conn, true); err != nil {
+		t.Fatalf("Teardown() error = %v", err)
+	}
+	if len(runner.calls) != 1 || runner.calls[0] != "ovs-vsctl --if-exists del-port br-nsm "+port {
+		t.Errorf("calls = %v, want the port deleted", runner.calls)
+	}
+	if _, err := os.Stat(filepath.Dir(socket)); err != nil {
+		t.Errorf("socket directory removed with allocations kept")
+	}
+
+	if err := d.Teardown(context.Background(), conn, false); err != nil {
+		t.Fatalf("Teardown() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "edge")); !os.IsNotExist(err) {
+		t.Errorf("socket directory not removed")
+	}
+
+	// other connections go to the next datapath
+	if err := d.Setup(context.Background(), fallbackConnection(nsmv1.ConnectionTypeSRIOV)); err != nil || next.setups != 1 {
+		t.Errorf("sriov connection not delegated: %v", err)
+	}
+}
+
+func TestVhostUserDatapathVPP(t *testing.T) {
+	dir := t.TempDir()
+	conn := vhostUserConnection()
+	socket := filepath.Join(VhostUserSocketDir(dir, conn), VhostUserSocketName)
+	runner := &scriptedRunner{outputs: map[string]string{
+		"vppctl show vhost-user":          "Virtio vhost-user interfaces\nGlobal:\n  coalesce frames 32 time 1e-3\n",
+		"vppctl create vhost-user socket": "VirtualEthernet0/0/3\n",
+	}}
+	d := NewVhostUserDatapath(&VPPDataplane{bridgeDomain: "10", run: runner.run}, dir, &countingDatapath{})
+
+	if err := d.Setup(context.Background(), conn); err != nil {
+		t.Fatalf("Setup() error = %v", err)
+	}
+	want := []string{
+		"vppctl show vhost-user",
+		"vppctl create vhost-user socket " + socket,
+		"vppctl set interface state VirtualEthernet0/0/3 up",
+		"vppctl set interface l2 bridge VirtualEthernet0/0/3 10",
+	}
+	if len(runner.calls) != len(want) {
+		t.Fatalf("calls = %v, want %v", runner.calls, want)
+	}
+	for i := range want {
+		if runner.calls[i] != want[i] {
+			t.Errorf("call %d = %s, want %s", i, runner.calls[i], want[i])
+		}
+	}
+	if status := conn.Status.VhostUser; status == nil || status.Dataplane != VhostUserVPP || status.Interface != "VirtualEthernet0/0/3" {
+		t.Errorf("unexpected vhost-user status %+v", status)
+	}
+
+	// VPP restarted and renumbered the interface, it is found by its socket
+	runner.outputs["vppctl show vhost-user"] = "Interface: VirtualEthernet0/0/0 (ifindex 1)\n" +
+		"  socket filename " + socket + " type client errno \"Success\"\n"
+	runner.calls = nil
+	if err := d.Teardown(context.Background(), conn, false); err != nil {
+		t.Fatalf("Teardown() error = %v", err)
+	}
+	if len(runner.calls) != 2 || runner.calls[1] != "vppctl delete vhost-user VirtualEthernet0/0/0" {
+		t.Errorf("calls = %v, want the renumbered interface deleted", runner.calls)
+	}
+
+	// a port already gone is not an error
+	runner.outputs["vppctl show vhost-user"] = ""
+	if err := d.Teardown(context.Background(), conn, false); err != nil {
+		t.Errorf("Teardown() of a missing port error = %v", err)
+	}
+}
+
+func TestVPPDataplaneCLIError(t *testing.T) {
+	runner := &scriptedRunner{outputs: map[string]string{
+		"vppctl create vhost-user socket": "create vhost-user: vhost-user socket file already in use\n",
+	}}
+	v := &VPPDataplane{run: runner.run}
+	if _, err := v.AddPort(context.Background(), "nsmvu0", "/run/nsm/vhost.sock"); err == nil {
+		t.Errorf("expected error on a CLI error")
+	}
+}
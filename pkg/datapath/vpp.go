@@ -0,0 +1,202 @@
+package datapath
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/connection"
+)
+
+// VPPDatapath forwards the connections negotiated onto VPP, kernel ones
+// and vhostuser ones whose ports are on VPP, with VPP instead of the kernel. Every connection
+// gets an interface in VPP, a veth pair attached over AF_PACKET or its
+// vhost-user port: connections tagged with VLANs are cross-connected at L2
+// to a sub-interface of the uplink matching their tags, others are routed
+// at L3 over the uplink. An ACL limits the traffic of the workload to the
+// destination of its connection when both ends are addresses.
+type VPPDatapath struct {
+	// Applier programming VPP and the veth pairs
+	applier *Applier
+	// VPP interface of the uplink (e.g., TenGigabitEthernet3b/0/0)
+	uplink string
+	// Datapath setting up the vhost-user ports, and the other connections
+	next connection.Datapath
+}
+
+// NewVPPDatapath creates a new VPP datapath
+func NewVPPDatapath(applier *Applier, uplink string, next connection.Datapath) *VPPDatapath {
+	return &VPPDatapath{
+		applier: applier,
+		uplink:  uplink,
+		next:    next,
+	}
+}
+
+// Setup implements connection.Datapath. The vhost-user port of a
+// connection is set up by the next datapath before it is cross-connected.
+func (d *VPPDatapath) Setup(ctx context.Context, conn *nsmv1.NetworkConnection) error {
+	if err := d.next.Setup(ctx, conn); err != nil {
+		return err
+	}
+	if !isVPP(conn) {
+		return nil
+	}
+	objs, err := VPPObjects(conn, d.uplink)
+	if err != nil {
+		return err
+	}
+	if _, err := d.applier.Apply(ctx, vppOwner(conn), objs); err != nil {
+		return fmt.Errorf("failed to set up VPP forwarding: %w", err)
+	}
+	return nil
+}
+
+// Plan implements Planner, adding the VPP changes to those of the next
+// datapath. The vhost-user port a connection doesn't have yet can't be
+// planned for.
+func (d *VPPDatapath) Plan(ctx context.Context, conn *nsmv1.NetworkConnection) (*Plan, error) {
+	plan := &Plan{}
+	if next, ok := d.next.(Planner); ok {
+		p, err := next.Plan(ctx, conn)
+		if err != nil {
+			return nil, err
+		}
+		plan = p
+	}
+	if !isVPP(conn) {
+		return plan, nil
+	}
+	objs, err := VPPObjects(conn, d.uplink)
+	if err != nil {
+		return nil, err
+	}
+	p, err := d.applier.Plan(ctx, vppOwner(conn), objs)
+	if err != nil {
+		return nil, err
+	}
+	plan.Create = append(plan.Create, p.Create...)
+	plan.Update = append(plan.Update, p.Update...)
+	plan.Delete = append(plan.Delete, p.Delete...)
+	return plan, nil
+}
+
+// Teardown implements connection.Datapath. The forwarding holds no scarce
+// resources, so it is removed even when allocations are kept, before the
+// vhost-user port it uses.
+func (d *VPPDatapath) Teardown(ctx context.Context, conn *nsmv1.NetworkConnection, keepAllocations bool) error {
+	var objs []Object
+	if isVPP(conn) {
+		// a spec the objects can't be built from was never applied
+		objs, _ = VPPObjects(conn, d.uplink)
+	}
+	if err := d.applier.Release(ctx, vppOwner(conn), objs); err != nil {
+		return fmt.Errorf("failed to remove VPP forwarding: %w", err)
+	}
+	return d.next.Teardown(ctx, conn, keepAllocations)
+}
+
+// VPPObjects returns the objects forwarding a connection with VPP
+func VPPObjects(conn *nsmv1.NetworkConnection, uplink string) ([]Object, error) {
+	if uplink == "" {
+		return nil, fmt.Errorf("no uplink for the VPP dataplane, set vppUplink (NSM_VPP_UPLINK)")
+	}
+
+	var objs []Object
+	var iface string
+	if conn.Status.Datapath == nsmv1.ConnectionTypeVhostUser {
+		iface = conn.Status.VhostUser.Interface
+	} else {
+		host, peer := VPPLinkNames(conn)
+		vif := VPPHostInterface(peer)
+		objs = append(objs,
+			Link{Name: host, Type: "veth", PeerName: peer, Up: true},
+			Link{Name: peer, Type: "veth", Up: true},
+			vif,
+		)
+		iface = vif.Name
+	}
+
+	if vlan := conn.Spec.VLAN; vlan != nil {
+		if vlan.OuterVID < 1 || vlan.OuterVID > 4094 {
+			return nil, fmt.Errorf("invalid outer VLAN ID %d, must be between 1 and 4094", vlan.OuterVID)
+		}
+		if vlan.InnerVID < 0 || vlan.InnerVID > 4094 {
+			return nil, fmt.Errorf("invalid inner VLAN ID %d, must be between 1 and 4094 or 0 for a single tag", vlan.InnerVID)
+		}
+		sub := VPPSubInterface(uplink, vlan.OuterVID, vlan.InnerVID)
+		objs = append(objs, sub, VPPXConnect{RX: iface, TX: sub.Name}, VPPXConnect{RX: sub.Name, TX: iface})
+	} else {
+		objs = append(objs, VPPUnnumbered{Interface: iface, Via: uplink})
+		// the replies to the workload are routed to its interface
+		if source := hostPrefix(conn.Spec.Source); source != nil {
+			objs = append(objs, VPPRoute{Prefix: source.String(), Interface: iface})
+		}
+	}
+
+	if acl := vppACL(conn, iface); acl != nil {
+		objs = append(objs, *acl)
+	}
+	return objs, nil
+}
+
+// vppACL returns the ACL permitting the workload to reach the destination
+// of its connection only, nil unless both ends are addresses
+func vppACL(conn *nsmv1.NetworkConnection, iface string) *VPPACL {
+	source := hostPrefix(conn.Spec.Source)
+	destination := hostPrefix(conn.Spec.Destination)
+	if source == nil || destination == nil || (source.IP.To4() == nil) != (destination.IP.To4() == nil) {
+		return nil
+	}
+	host, _ := VPPLinkNames(conn)
+	rules := []string{"permit src " + source.String() + " dst " + destination.String()}
+	if source.IP.To4() == nil {
+		// IPv6 resolves neighbors with ICMPv6 from link-local addresses
+		rules = append(rules, "permit src fe80::/10 dst ::/0 proto 58")
+	}
+	return &VPPACL{Name: host, Interface: iface, Rules: rules}
+}
+
+// hostPrefix parses an address or a prefix, nil if it is neither (e.g.,
+// a pod or a service name)
+func hostPrefix(addr string) *net.IPNet {
+	if _, prefix, err := net.ParseCIDR(addr); err == nil {
+		return prefix
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return nil
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}
+}
+
+// VPPLinkNames returns stable names for the veth pair attaching a
+// connection to VPP, the workload side and the VPP side, within the 15
+// character limit of the kernel
+func VPPLinkNames(conn *nsmv1.NetworkConnection) (string, string) {
+	sum := sha256.Sum256([]byte(conn.Namespace + "/" + conn.Name))
+	suffix := hex.EncodeToString(sum[:4])
+	return "nsmvp" + suffix, "nsmvq" + suffix
+}
+
+// isVPP reports whether VPP forwards the connection, as negotiated by the
+// connection reconciler
+func isVPP(conn *nsmv1.NetworkConnection) bool {
+	if conn.Status.Dataplane != connection.DataplaneVPP {
+		return false
+	}
+	// the vhost-user port may be on another dataplane after a config change
+	return conn.Status.Datapath != nsmv1.ConnectionTypeVhostUser ||
+		(conn.Status.VhostUser != nil && conn.Status.VhostUser.Dataplane == VhostUserVPP)
+}
+
+// vppOwner returns the applier owner of a connection
+func vppOwner(conn *nsmv1.NetworkConnection) string {
+	return "vpp/" + conn.Namespace + "/" + conn.Name
+}
@@ -0,0 +1,164 @@
+package datapath
+
+import (
+	"context"
+	"testing"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/connection"
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func vppConnection() *nsmv1.NetworkConnection {
+	return &nsmv1.NetworkConnection{
+		ObjectMeta: metav1.ObjectMeta{Name: "conn", Namespace: "edge"},
+		Spec: nsmv1.NetworkConnectionSpec{
+			ConnectionType: nsmv1.ConnectionTypeKernel,
+			Source:         "10.0.0.5",
+			Destination:    "10.2.0.0/16",
+		},
+		Status: nsmv1.NetworkConnectionStatus{Datapath: nsmv1.ConnectionTypeKernel, Dataplane: connection.DataplaneVPP},
+	}
+}
+
+func TestVPPDatapathRouted(t *testing.T) {
+	backend := newMemBackend()
+	next := &countingDatapath{}
+	d := NewVPPDatapath(NewApplier(backend, logrus.New()), "TenGigabitEthernet3b/0/0", next)
+
+	conn := vppConnection()
+	if err := d.Setup(context.Background(), conn); err != nil {
+		t.Fatalf("Setup() error = %v", err)
+	}
+	if next.setups != 1 {
+		t.Errorf("next datapath not set up first")
+	}
+	host, peer := VPPLinkNames(conn)
+	vif := "host-" + peer
+	for _, key := range []string{
+		"link/" + host,
+		"link/" + peer,
+		"vpp-interface/" + vif,
+		"vpp-unnumbered/" + vif,
+		"vpp-route/10.0.0.5/32",
+		"vpp-acl/" + host,
+	} {
+		if _, ok := backend.objects[key]; !ok {
+			t.Errorf("%s not created", key)
+		}
+	}
+	if u := backend.objects["vpp-unnumbered/"+vif].(VPPUnnumbered); u.Via != "TenGigabitEthernet3b/0/0" {
+		t.Errorf("interface borrows the address of %s, want the uplink", u.Via)
+	}
+	acl := backend.objects["vpp-acl/"+host].(VPPACL)
+	if acl.Interface != vif || len(acl.Rules) != 1 || acl.Rules[0] != "permit src 10.0.0.5/32 dst 10.2.0.0/16" {
+		t.Errorf("unexpected ACL %+v", acl)
+	}
+
+	// nothing left to do once set up
+	plan, err := d.Plan(context.Background(), conn)
+	if err != nil {
+		t.Fatalf("Plan() error = %v", err)
+	}
+	if len(plan.Create)+len(plan.Update)+len(plan.Delete) != 0 {
+		t.Errorf("Plan() = %+v, want no changes", plan)
+	}
+
+	if err := d.Teardown(context.Background(), conn, true); err != nil {
+		t.Fatalf("Teardown() error = %v", err)
+	}
+	if len(backend.objects) != 0 {
+		t.Errorf("objects left after teardown: %v", backend.objects)
+	}
+}
+
+func TestVPPDatapathVLAN(t *testing.T) {
+	conn := vppConnection()
+	conn.Spec.Source = "edge/app"
+	conn.Spec.VLAN = &nsmv1.VLANSpec{OuterVID: 100, InnerVID: 20}
+
+	objs, err := VPPObjects(conn, "TenGigabitEthernet3b/0/0")
+	if err != nil {
+		t.Fatalf("VPPObjects() error = %v", err)
+	}
+	_, peer := VPPLinkNames(conn)
+	sub := "TenGigabitEthernet3b/0/0.409620"
+	want := map[string]bool{
+		"vpp-interface/" + sub:      false,
+		"vpp-xconnect/host-" + peer: false,
+		"vpp-xconnect/" + sub:       false,
+	}
+	for _, obj := range objs {
+		switch o := obj.(type) {
+		case VPPInterface:
+			if o.Parent != "" && (o.OuterVID != 100 || o.InnerVID != 20) {
+				t.Errorf("sub-interface %+v doesn't match the tags", o)
+			}
+		case VPPUnnumbered, VPPRoute:
+			t.Errorf("cross-connected connection routed with %+v", o)
+		case VPPACL:
+			t.Errorf("ACL %+v for a connection from a pod", o)
+		}
+		if _, ok := want[obj.Key()]; ok {
+			want[obj.Key()] = true
+		}
+	}
+	for key, found := range want {
+		if !found {
+			t.Errorf("%s missing", key)
+		}
+	}
+
+	conn.Spec.VLAN.OuterVID = 4095
+	if _, err := VPPObjects(conn, "TenGigabitEthernet3b/0/0"); err == nil {
+		t.Errorf("expected error on an invalid VLAN ID")
+	}
+	if _, err := VPPObjects(vppConnection(), ""); err == nil {
+		t.Errorf("expected error without an uplink")
+	}
+}
+
+func TestVPPDatapathVhostUser(t *testing.T) {
+	conn := vhostUserConnection()
+	conn.Spec.Source = "2001:db8::5"
+	conn.Spec.Destination = "2001:db8:1::/48"
+	conn.Status.Dataplane = connection.DataplaneVPP
+	conn.Status.VhostUser = &nsmv1.VhostUserStatus{Dataplane: VhostUserVPP, Interface: "VirtualEthernet0/0/3"}
+
+	objs, err := VPPObjects(conn, "TenGigabitEthernet3b/0/0")
+	if err != nil {
+		t.Fatalf("VPPObjects() error = %v", err)
+	}
+	for _, obj := range objs {
+		switch o := obj.(type) {
+		case Link, VPPInterface:
+			t.Errorf("vhost-user port attached with %+v", o)
+		case VPPRoute:
+			if o.Prefix != "2001:db8::5/128" || o.Interface != "VirtualEthernet0/0/3" {
+				t.Errorf("unexpected route %+v", o)
+			}
+		case VPPACL:
+			if len(o.Rules) != 2 {
+				t.Errorf("ACL rules = %v, want the neighbor discovery permitted", o.Rules)
+			}
+		}
+	}
+
+	// vhost-user ports on OVS, connections negotiated onto the kernel and
+	// other connections aren't forwarded
+	backend := newMemBackend()
+	d := NewVPPDatapath(NewApplier(backend, logrus.New()), "TenGigabitEthernet3b/0/0", &countingDatapath{})
+	conn.Status.VhostUser.Dataplane = VhostUserOVS
+	if err := d.Setup(context.Background(), conn); err != nil || len(backend.objects) != 0 {
+		t.Errorf("OVS vhost-user connection forwarded with VPP: %v", err)
+	}
+	kernel := vppConnection()
+	kernel.Status.Dataplane = connection.DataplaneKernel
+	if err := d.Setup(context.Background(), kernel); err != nil || len(backend.objects) != 0 {
+		t.Errorf("connection negotiated onto the kernel forwarded with VPP: %v", err)
+	}
+	if err := d.Setup(context.Background(), fallbackConnection(nsmv1.ConnectionTypeSRIOV)); err != nil || len(backend.objects) != 0 {
+		t.Errorf("sriov connection forwarded with VPP: %v", err)
+	}
+}
@@ -0,0 +1,483 @@
+package datapath
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// VPPInterface is an interface of VPP: a host interface attached to a
+// kernel link over AF_PACKET, or a VLAN sub-interface of another interface
+type VPPInterface struct {
+	// Name of the interface in VPP
+	Name string
+	// Kernel link the interface is attached to (host interfaces only)
+	HostIf string
+	// Interface the sub-interface is created on (sub-interfaces only)
+	Parent string
+	// ID of the sub-interface, unique on its parent
+	SubID int
+	// VLAN ID of a single tagged sub-interface, the 802.1ad service tag
+	// of a double tagged one
+	OuterVID int
+	// 802.1Q customer tag of a double tagged sub-interface, 0 for none
+	InnerVID int
+	// Whether the interface is up
+	Up bool
+}
+
+// VPPHostInterface returns the host interface VPP attaches to a kernel
+// link, which VPP names after the link
+func VPPHostInterface(hostIf string) VPPInterface {
+	return VPPInterface{Name: "host-" + hostIf, HostIf: hostIf, Up: true}
+}
+
+// VPPSubInterface returns the sub-interface of a VPP interface matching
+// exactly the VLAN tags of a connection, with an inner VID the outer tag is
+// an 802.1ad service tag (QinQ)
+func VPPSubInterface(parent string, outer, inner int) VPPInterface {
+	id := outer
+	if inner > 0 {
+		id = outer*4096 + inner
+	}
+	return VPPInterface{
+		Name:     parent + "." + strconv.Itoa(id),
+		Parent:   parent,
+		SubID:    id,
+		OuterVID: outer,
+		InnerVID: inner,
+		Up:       true,
+	}
+}
+
+// Kind implements Object
+func (i VPPInterface) Kind() Kind { return KindVPPInterface }
+
+// Key implements Object
+func (i VPPInterface) Key() string { return "vpp-interface/" + i.Name }
+
+// InSync implements Object
+func (i VPPInterface) InSync(observed Object) bool {
+	o, ok := observed.(VPPInterface)
+	return ok && i.Up == o.Up
+}
+
+// VPPUnnumbered borrows the address of an interface for another, enabling
+// IP on it without an address of its own
+type VPPUnnumbered struct {
+	// Interface enabled
+	Interface string
+	// Interface whose address is borrowed
+	Via string
+}
+
+// Kind implements Object
+func (u VPPUnnumbered) Kind() Kind { return KindVPPUnnumbered }
+
+// Key implements Object
+func (u VPPUnnumbered) Key() string { return "vpp-unnumbered/" + u.Interface }
+
+// InSync implements Object
+func (u VPPUnnumbered) InSync(observed Object) bool {
+	o, ok := observed.(VPPUnnumbered)
+	return ok && u.Via == o.Via
+}
+
+// VPPXConnect sends every frame received on an interface out of another,
+// one direction of an L2 cross-connect
+type VPPXConnect struct {
+	// Interface the frames are received on
+	RX string
+	// Interface the frames are sent out of
+	TX string
+}
+
+// Kind implements Object
+func (x VPPXConnect) Kind() Kind { return KindVPPXConnect }
+
+// Key implements Object
+func (x VPPXConnect) Key() string { return "vpp-xconnect/" + x.RX }
+
+// InSync implements Object
+func (x VPPXConnect) InSync(observed Object) bool {
+	o, ok := observed.(VPPXConnect)
+	return ok && x.TX == o.TX
+}
+
+// VPPRoute is a route of the default VRF of VPP
+type VPPRoute struct {
+	// Destination prefix
+	Prefix string
+	// Outgoing interface
+	Interface string
+	// Next hop, empty for destinations attached to the interface
+	NextHop string
+}
+
+// Kind implements Object
+func (r VPPRoute) Kind() Kind { return KindVPPRoute }
+
+// Key implements Object
+func (r VPPRoute) Key() string { return "vpp-route/" + r.Prefix }
+
+// InSync implements Object
+func (r VPPRoute) InSync(observed Object) bool {
+	o, ok := observed.(VPPRoute)
+	return ok && r.Interface == o.Interface && (r.NextHop == "" || r.NextHop == o.NextHop)
+}
+
+// VPPACL is an ACL of the VPP ACL plugin filtering the packets received on
+// an interface. The packets matching no rule are dropped.
+type VPPACL struct {
+	// Unique name of the ACL
+	Name string
+	// Interface the ACL filters the input of
+	Interface string
+	// Rules in the syntax of the plugin CLI (e.g., "permit src
+	// 10.0.0.5/32 dst 10.2.0.0/16")
+	Rules []string
+	// Tag of the ACL as observed in VPP, set by backends only
+	tag string
+}
+
+// Kind implements Object
+func (a VPPACL) Kind() Kind { return KindVPPACL }
+
+// Key implements Object
+func (a VPPACL) Key() string { return "vpp-acl/" + a.Name }
+
+// InSync implements Object. VPP reformats the rules, so ACLs are compared
+// by the hash carried in their tag instead.
+func (a VPPACL) InSync(observed Object) bool {
+	o, ok := observed.(VPPACL)
+	return ok && o.aclTag() == a.aclTag()
+}
+
+// aclTag returns the tag identifying the ACL in VPP, within the 64 bytes
+// the plugin keeps
+func (a VPPACL) aclTag() string {
+	if a.tag != "" {
+		return a.tag
+	}
+	sum := sha256.Sum256([]byte(a.Interface + "\n" + strings.Join(a.Rules, "\n")))
+	return a.Name + "-" + hex.EncodeToString(sum[:4])
+}
+
+// aclTagName returns the name of the ACL a tag identifies, the tag without
+// its hash
+func aclTagName(tag string) string {
+	i := strings.LastIndexByte(tag, '-')
+	if i < 0 {
+		return ""
+	}
+	return tag[:i]
+}
+
+// VPPBackend is a Backend programming VPP with vppctl, passing the host
+// objects (e.g., the veth pairs VPP attaches to) to the host backend
+type VPPBackend struct {
+	// Backend of the host objects
+	host Backend
+	// Runs the commands, replaceable for tests
+	run CommandRunner
+}
+
+// NewVPPBackend creates a new backend running vppctl
+func NewVPPBackend(host Backend) *VPPBackend {
+	return &VPPBackend{host: host, run: runCommand}
+}
+
+// show runs a vppctl show command
+func (b *VPPBackend) show(ctx context.Context, args ...string) (string, error) {
+	out, err := b.run(ctx, "vppctl", append([]string{"show"}, args...)...)
+	return string(out), err
+}
+
+// exec runs a vppctl command printing nothing on success. vppctl exits
+// successfully on CLI errors, whatever it prints is the error.
+func (b *VPPBackend) exec(ctx context.Context, args ...string) error {
+	out, err := b.run(ctx, "vppctl", args...)
+	if err != nil {
+		return err
+	}
+	if msg := strings.TrimSpace(string(out)); msg != "" {
+		return fmt.Errorf("vppctl %s failed: %s", strings.Join(args, " "), msg)
+	}
+	return nil
+}
+
+// Get implements Backend
+func (b *VPPBackend) Get(ctx context.Context, obj Object) (Object, error) {
+	switch o := obj.(type) {
+	case VPPInterface:
+		return b.getInterface(ctx, o)
+	case VPPUnnumbered:
+		return b.getUnnumbered(ctx, o)
+	case VPPXConnect:
+		return b.getXConnect(ctx, o)
+	case VPPRoute:
+		return b.getRoute(ctx, o)
+	case VPPACL:
+		acl, _, err := b.getACL(ctx, o)
+		return acl, err
+	default:
+		return b.host.Get(ctx, obj)
+	}
+}
+
+// Create implements Backend
+func (b *VPPBackend) Create(ctx context.Context, obj Object) error {
+	switch o := obj.(type) {
+	case VPPInterface:
+		args := []string{"create", "host-interface", "name", o.HostIf}
+		if o.Parent != "" {
+			args = []string{"create", "sub-interfaces", o.Parent, strconv.Itoa(o.SubID)}
+			if o.InnerVID > 0 {
+				args = append(args, "dot1ad", strconv.Itoa(o.OuterVID), "inner-dot1q", strconv.Itoa(o.InnerVID))
+			} else {
+				args = append(args, "dot1q", strconv.Itoa(o.OuterVID))
+			}
+			args = append(args, "exact-match")
+		}
+		// the interface created is printed
+		out, err := b.run(ctx, "vppctl", args...)
+		if err != nil {
+			return err
+		}
+		if name := strings.TrimSpace(string(out)); name != o.Name {
+			return fmt.Errorf("vppctl %s failed: %s", strings.Join(args, " "), name)
+		}
+		return b.Update(ctx, o)
+	case VPPUnnumbered:
+		return b.exec(ctx, "set", "interface", "unnumbered", o.Interface, "use", o.Via)
+	case VPPXConnect:
+		return b.exec(ctx, "set", "interface", "l2", "xconnect", o.RX, o.TX)
+	case VPPRoute:
+		return b.exec(ctx, append([]string{"ip", "route", "add"}, vppRouteArgs(o)...)...)
+	case VPPACL:
+		return b.createACL(ctx, o)
+	default:
+		return b.host.Create(ctx, obj)
+	}
+}
+
+// Update implements Backend
+func (b *VPPBackend) Update(ctx context.Context, obj Object) error {
+	switch o := obj.(type) {
+	case VPPInterface:
+		state := "down"
+		if o.Up {
+			state = "up"
+		}
+		return b.exec(ctx, "set", "interface", "state", o.Name, state)
+	case VPPUnnumbered:
+		return b.Create(ctx, o)
+	case VPPXConnect:
+		return b.Create(ctx, o)
+	case VPPRoute:
+		// deleting the prefix drops all its paths
+		if err := b.exec(ctx, "ip", "route", "del", o.Prefix); err != nil {
+			return err
+		}
+		return b.Create(ctx, o)
+	case VPPACL:
+		if err := b.Delete(ctx, o); err != nil {
+			return err
+		}
+		return b.createACL(ctx, o)
+	default:
+		return b.host.Update(ctx, obj)
+	}
+}
+
+// Delete implements Backend
+func (b *VPPBackend) Delete(ctx context.Context, obj Object) error {
+	switch o := obj.(type) {
+	case VPPInterface:
+		if _, err := b.getInterface(ctx, o); err != nil {
+			return err
+		}
+		if o.Parent != "" {
+			return b.exec(ctx, "delete", "sub-interface", o.Name)
+		}
+		return b.exec(ctx, "delete", "host-interface", "name", o.HostIf)
+	case VPPUnnumbered:
+		if _, err := b.getUnnumbered(ctx, o); err != nil {
+			return err
+		}
+		return b.exec(ctx, "set", "interface", "unnumbered", "del", o.Interface)
+	case VPPXConnect:
+		if _, err := b.getXConnect(ctx, o); err != nil {
+			return err
+		}
+		// the interface goes back to routing
+		return b.exec(ctx, "set", "interface", "l3", o.RX)
+	case VPPRoute:
+		if _, err := b.getRoute(ctx, o); err != nil {
+			return err
+		}
+		return b.exec(ctx, "ip", "route", "del", o.Prefix)
+	case VPPACL:
+		_, index, err := b.getACL(ctx, o)
+		if err != nil {
+			return err
+		}
+		// an ACL applied to an interface can't be deleted
+		if err := b.exec(ctx, "set", "acl-plugin", "interface", o.Interface, "input", "acl", index, "del"); err != nil {
+			return err
+		}
+		return b.exec(ctx, "delete", "acl-plugin", "acl", "index", index)
+	default:
+		return b.host.Delete(ctx, obj)
+	}
+}
+
+// getInterface reads an interface from the interface table, e.g.
+// "host-nsm0    3    up    9000/0/0/0"
+func (b *VPPBackend) getInterface(ctx context.Context, i VPPInterface) (Object, error) {
+	out, err := b.show(ctx, "interface", i.Name)
+	if err != nil {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 3 && fields[0] == i.Name {
+			observed := i
+			observed.Up = fields[2] == "up"
+			return observed, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// getUnnumbered reads the address borrowed by an interface, e.g.
+// "  unnumbered, use host-eth0"
+func (b *VPPBackend) getUnnumbered(ctx context.Context, u VPPUnnumbered) (Object, error) {
+	out, err := b.show(ctx, "interface", "addr", u.Interface)
+	if err != nil {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 3 && fields[0] == "unnumbered," && fields[1] == "use" {
+			return VPPUnnumbered{Interface: u.Interface, Via: fields[2]}, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// getXConnect reads the forwarding mode of an interface, e.g.
+// "l2 xconnect host-nsm0 GigabitEthernet3/0/0.100"
+func (b *VPPBackend) getXConnect(ctx context.Context, x VPPXConnect) (Object, error) {
+	out, err := b.show(ctx, "mode", x.RX)
+	if err != nil {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 4 && fields[0] == "l2" && fields[1] == "xconnect" && fields[2] == x.RX {
+			return VPPXConnect{RX: x.RX, TX: fields[3]}, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// getRoute reads a prefix from the FIB. A missing prefix shows the route
+// covering it, so the prefix line is looked for, then its forwarding
+// path, e.g. "[0] [@5]: ipv4 via 10.1.0.2 host-nsm0: mtu:9000 next:3".
+func (b *VPPBackend) getRoute(ctx context.Context, r VPPRoute) (Object, error) {
+	table := "ip"
+	if ip, _, err := net.ParseCIDR(r.Prefix); err == nil && ip.To4() == nil {
+		table = "ip6"
+	}
+	out, err := b.show(ctx, table, "fib", r.Prefix)
+	if err != nil {
+		return nil, err
+	}
+	var observed *VPPRoute
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		switch {
+		case len(fields) >= 2 && fields[0] == r.Prefix && strings.HasPrefix(fields[1], "fib:"):
+			observed = &VPPRoute{Prefix: r.Prefix}
+		case observed != nil && strings.Contains(scanner.Text(), "[@"):
+			for i := 0; i+1 < len(fields); i++ {
+				if fields[i] != "via" {
+					continue
+				}
+				next := strings.TrimSuffix(fields[i+1], ":")
+				if net.ParseIP(next) != nil && i+2 < len(fields) {
+					observed.NextHop = next
+					next = strings.TrimSuffix(fields[i+2], ":")
+				}
+				observed.Interface = next
+				return *observed, nil
+			}
+		}
+	}
+	if observed == nil {
+		return nil, ErrNotFound
+	}
+	return *observed, nil
+}
+
+// getACL finds an ACL by the name in its tag and returns it with its
+// index, e.g. "acl-index 0 count 2 tag {nsmvp1a2b3c4d-5e6f7a8b}"
+func (b *VPPBackend) getACL(ctx context.Context, a VPPACL) (Object, string, error) {
+	out, err := b.show(ctx, "acl-plugin", "acl")
+	if err != nil {
+		return nil, "", err
+	}
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 6 || fields[0] != "acl-index" || fields[4] != "tag" {
+			continue
+		}
+		tag := strings.Trim(fields[5], "{}")
+		if aclTagName(tag) == a.Name {
+			return VPPACL{Name: a.Name, Interface: a.Interface, tag: tag}, fields[1], nil
+		}
+	}
+	return nil, "", ErrNotFound
+}
+
+// createACL creates an ACL and applies it to the input of its interface.
+// The index of the ACL created is printed, e.g. "ACL index:3".
+func (b *VPPBackend) createACL(ctx context.Context, a VPPACL) error {
+	args := []string{"set", "acl-plugin", "acl"}
+	for i, rule := range a.Rules {
+		if i > 0 {
+			args[len(args)-1] += ","
+		}
+		args = append(args, strings.Fields(rule)...)
+	}
+	args = append(args, "tag", a.aclTag())
+	out, err := b.run(ctx, "vppctl", args...)
+	if err != nil {
+		return err
+	}
+	_, index, ok := strings.Cut(strings.TrimSpace(string(out)), "ACL index:")
+	if !ok {
+		return fmt.Errorf("vppctl %s failed: %s", strings.Join(args, " "), strings.TrimSpace(string(out)))
+	}
+	return b.exec(ctx, "set", "acl-plugin", "interface", a.Interface, "input", "acl", strings.TrimSpace(index))
+}
+
+// vppRouteArgs returns the arguments of a route after add or del
+func vppRouteArgs(r VPPRoute) []string {
+	args := []string{r.Prefix, "via"}
+	if r.NextHop != "" {
+		args = append(args, r.NextHop)
+	}
+	return append(args, r.Interface)
+}
@@ -0,0 +1,152 @@
+package datapath
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestVPPBackendGet(t *testing.T) {
+	runner := &scriptedRunner{outputs: map[string]string{
+		"vppctl show interface host-nsm0": "              Name               Idx    State  MTU (L3/IP4/IP6/MPLS)     Counter          Count\n" +
+			"host-nsm0                         3      up          9000/0/0/0\n",
+		"vppctl show interface host-nsm1":      "show interface: unknown input `host-nsm1'\n",
+		"vppctl show interface addr host-nsm0": "host-nsm0 (up):\n  unnumbered, use TenGigabitEthernet3b/0/0\n",
+		"vppctl show mode host-nsm0":           "l2 xconnect host-nsm0 TenGigabitEthernet3b/0/0.100\n",
+		"vppctl show ip fib 10.0.0.5/32": "ipv4-VRF:0, fib_index:0, flow hash:[src dst sport dport proto flowlabel ] epoch:0 flags:none locks:[default-route:1, ]\n" +
+			"10.0.0.5/32 fib:0 index:18 locks:2\n" +
+			"  CLI refs:1 src-flags:added,contributing,active,\n" +
+			"    path-list:[21] locks:2 flags:shared, uPRF-list:19 len:1 itfs:[3, ]\n" +
+			"  forwarding:   unicast-ip4-chain\n" +
+			"  [@0]: dpo-load-balance: [proto:ip4 index:20 buckets:1 uRPF:19 to:[0:0]]\n" +
+			"    [0] [@5]: ipv4 via 10.0.0.5 host-nsm0: mtu:9000 next:3 flags:[] 02fe0a0a0a0a02fe0b0b0b0b0800\n",
+		"vppctl show ip fib 10.0.0.6/32": "ipv4-VRF:0, fib_index:0, flow hash:[src dst sport dport proto flowlabel ] epoch:0 flags:none locks:[default-route:1, ]\n" +
+			"0.0.0.0/0 fib:0 index:0 locks:2\n" +
+			"  [@0]: dpo-drop ip4\n",
+		"vppctl show acl-plugin acl": "acl-index 0 count 1 tag {nsmvp1a2b3c4d-peer-0a1b2c3d}\n" +
+			"          0: ipv4 permit src 10.0.0.6/32 dst 10.2.0.0/16 proto 0 sport 0-65535 dport 0-65535\n" +
+			"acl-index 1 count 1 tag {nsmvp1a2b3c4d-5e6f7a8b}\n" +
+			"          0: ipv4 permit src 10.0.0.5/32 dst 10.2.0.0/16 proto 0 sport 0-65535 dport 0-65535\n",
+	}}
+	b := &VPPBackend{host: newMemBackend(), run: runner.run}
+	ctx := context.Background()
+
+	got, err := b.Get(ctx, VPPHostInterface("nsm0"))
+	if err != nil || !got.(VPPInterface).Up {
+		t.Errorf("Get(host-nsm0) = %+v, %v, want it up", got, err)
+	}
+	if _, err := b.Get(ctx, VPPHostInterface("nsm1")); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get(host-nsm1) error = %v, want ErrNotFound", err)
+	}
+	got, err = b.Get(ctx, VPPUnnumbered{Interface: "host-nsm0"})
+	if err != nil || got.(VPPUnnumbered).Via != "TenGigabitEthernet3b/0/0" {
+		t.Errorf("Get(unnumbered) = %+v, %v", got, err)
+	}
+	got, err = b.Get(ctx, VPPXConnect{RX: "host-nsm0"})
+	if err != nil || got.(VPPXConnect).TX != "TenGigabitEthernet3b/0/0.100" {
+		t.Errorf("Get(xconnect) = %+v, %v", got, err)
+	}
+	got, err = b.Get(ctx, VPPRoute{Prefix: "10.0.0.5/32"})
+	if err != nil || got.(VPPRoute).Interface != "host-nsm0" || got.(VPPRoute).NextHop != "10.0.0.5" {
+		t.Errorf("Get(route) = %+v, %v", got, err)
+	}
+	// the covering default route isn't the route looked for
+	if _, err := b.Get(ctx, VPPRoute{Prefix: "10.0.0.6/32"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get(10.0.0.6/32) error = %v, want ErrNotFound", err)
+	}
+	got, err = b.Get(ctx, VPPACL{Name: "nsmvp1a2b3c4d"})
+	if err != nil || got.(VPPACL).aclTag() != "nsmvp1a2b3c4d-5e6f7a8b" {
+		t.Errorf("Get(acl) = %+v, %v", got, err)
+	}
+	// names are matched exactly, not as a prefix of the tag
+	got, err = b.Get(ctx, VPPACL{Name: "nsmvp1a2b3c4d-peer"})
+	if err != nil || got.(VPPACL).aclTag() != "nsmvp1a2b3c4d-peer-0a1b2c3d" {
+		t.Errorf("Get(acl-peer) = %+v, %v", got, err)
+	}
+	if _, err := b.Get(ctx, VPPACL{Name: "nsmvp00000000"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get(missing acl) error = %v, want ErrNotFound", err)
+	}
+	// host objects go to the host backend
+	if _, err := b.Get(ctx, Link{Name: "nsm0"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get(link) error = %v, want ErrNotFound from the host backend", err)
+	}
+}
+
+func TestVPPBackendCreate(t *testing.T) {
+	runner := &scriptedRunner{outputs: map[string]string{
+		"vppctl create host-interface name nsm0":                       "host-nsm0\n",
+		"vppctl create sub-interfaces TenGigabitEthernet3b/0/0 409620": "TenGigabitEthernet3b/0/0.409620\n",
+		"vppctl set acl-plugin acl":                                    "ACL index:4\n",
+	}}
+	host := newMemBackend()
+	b := &VPPBackend{host: host, run: runner.run}
+	ctx := context.Background()
+
+	acl := VPPACL{
+		Name:      "nsmvp1a2b3c4d",
+		Interface: "host-nsm0",
+		Rules:     []string{"permit src 2001:db8::5/128 dst 2001:db8:1::/48", "permit src fe80::/10 dst ::/0 proto 58"},
+	}
+	for _, obj := range []Object{
+		Link{Name: "nsm0", Type: "veth", PeerName: "nsm1", Up: true},
+		VPPHostInterface("nsm0"),
+		VPPSubInterface("TenGigabitEthernet3b/0/0", 100, 20),
+		VPPUnnumbered{Interface: "host-nsm0", Via: "TenGigabitEthernet3b/0/0"},
+		VPPXConnect{RX: "host-nsm0", TX: "TenGigabitEthernet3b/0/0.409620"},
+		VPPRoute{Prefix: "10.0.0.5/32", Interface: "host-nsm0"},
+		acl,
+	} {
+		if err := b.Create(ctx, obj); err != nil {
+			t.Fatalf("Create(%s) error = %v", obj.Key(), err)
+		}
+	}
+	want := []string{
+		"vppctl create host-interface name nsm0",
+		"vppctl set interface state host-nsm0 up",
+		"vppctl create sub-interfaces TenGigabitEthernet3b/0/0 409620 dot1ad 100 inner-dot1q 20 exact-match",
+		"vppctl set interface state TenGigabitEthernet3b/0/0.409620 up",
+		"vppctl set interface unnumbered host-nsm0 use TenGigabitEthernet3b/0/0",
+		"vppctl set interface l2 xconnect host-nsm0 TenGigabitEthernet3b/0/0.409620",
+		"vppctl ip route add 10.0.0.5/32 via host-nsm0",
+		"vppctl set acl-plugin acl permit src 2001:db8::5/128 dst 2001:db8:1::/48, permit src fe80::/10 dst ::/0 proto 58 tag " + acl.aclTag(),
+		"vppctl set acl-plugin interface host-nsm0 input acl 4",
+	}
+	if len(runner.calls) != len(want) {
+		t.Fatalf("calls = %v, want %v", runner.calls, want)
+	}
+	for i := range want {
+		if runner.calls[i] != want[i] {
+			t.Errorf("call %d = %s, want %s", i, runner.calls[i], want[i])
+		}
+	}
+	if _, ok := host.objects["link/nsm0"]; !ok {
+		t.Errorf("veth not created by the host backend")
+	}
+}
+
+func TestVPPBackendCLIError(t *testing.T) {
+	runner := &scriptedRunner{outputs: map[string]string{
+		"vppctl create host-interface name nsm0": "create host-interface: Unable to create host-interface: No such device\n",
+		"vppctl set interface unnumbered":        "set interface unnumbered: unknown interface `host-nsm0'\n",
+	}}
+	b := &VPPBackend{host: newMemBackend(), run: runner.run}
+	if err := b.Create(context.Background(), VPPHostInterface("nsm0")); err == nil {
+		t.Errorf("expected error creating a host interface on a missing link")
+	}
+	if err := b.Create(context.Background(), VPPUnnumbered{Interface: "host-nsm0", Via: "TenGigabitEthernet3b/0/0"}); err == nil {
+		t.Errorf("expected error on a CLI error")
+	}
+}
+
+func TestVPPACLInSync(t *testing.T) {
+	acl := VPPACL{Name: "nsmvp1a2b3c4d", Interface: "host-nsm0", Rules: []string{"permit src 10.0.0.5/32 dst 10.2.0.0/16"}}
+	observed := VPPACL{Name: acl.Name, tag: acl.aclTag()}
+	if !acl.InSync(observed) {
+		t.Errorf("ACL not in sync with its own tag")
+	}
+	changed := acl
+	changed.Rules = []string{"permit src 10.0.0.5/32 dst 10.3.0.0/16"}
+	if changed.InSync(observed) {
+		t.Errorf("ACL with other rules in sync")
+	}
+}
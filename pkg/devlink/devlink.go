@@ -0,0 +1,281 @@
+package devlink
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+)
+
+// Eswitch modes of a device
+const (
+	// EswitchLegacy switches the VF traffic in the NIC without representors
+	EswitchLegacy = "legacy"
+	// EswitchSwitchdev exposes a representor per VF for offloaded switching
+	EswitchSwitchdev = "switchdev"
+)
+
+// Reporter states
+const (
+	StateHealthy = "healthy"
+	StateError   = "error"
+)
+
+// Device is a devlink device, usually a NIC function on the PCI bus
+type Device struct {
+	// Bus of the device (e.g., pci)
+	Bus string `json:"bus"`
+	// Name of the device on its bus (e.g., 0000:3b:00.0)
+	Name string `json:"name"`
+	// Driver of the device
+	Driver string `json:"driver,omitempty"`
+	// Serial number of the board
+	SerialNumber string `json:"serialNumber,omitempty"`
+	// Firmware and board versions, by name (e.g., fw.mgmt)
+	Versions map[string]string `json:"versions,omitempty"`
+	// Eswitch mode (legacy, switchdev), empty without an eswitch
+	EswitchMode string `json:"eswitchMode,omitempty"`
+	// Eswitch inline and encapsulation modes
+	InlineMode string `json:"inlineMode,omitempty"`
+	EncapMode  string `json:"encapMode,omitempty"`
+	// Netdevs of the physical ports of the device
+	Netdevs []string `json:"netdevs,omitempty"`
+	// Number of VFs configured and supported, read from sysfs
+	NumVFs   int `json:"numVFs"`
+	TotalVFs int `json:"totalVFs"`
+	// Health reporters of the device and its ports
+	Reporters []Reporter `json:"healthReporters,omitempty"`
+}
+
+// Handle returns the devlink handle of the device (e.g., pci/0000:3b:00.0)
+func (d Device) Handle() string {
+	return d.Bus + "/" + d.Name
+}
+
+// Reporter is a devlink health reporter, tracking a class of device errors
+// (e.g., fw, tx) and their recoveries
+type Reporter struct {
+	// Name of the reporter
+	Name string `json:"name"`
+	// Port index for the reporters of a port, empty for the device's
+	Port string `json:"port,omitempty"`
+	// State (healthy, error)
+	State string `json:"state"`
+	// Number of errors and recoveries reported since the driver loaded
+	Errors   uint64 `json:"errors"`
+	Recovers uint64 `json:"recovers"`
+	// Whether the driver recovers from the errors on its own
+	AutoRecover bool `json:"autoRecover"`
+}
+
+// key identifies a reporter of a device
+func (r Reporter) key() string {
+	if r.Port == "" {
+		return r.Name
+	}
+	return r.Port + "/" + r.Name
+}
+
+// Backend queries and configures the devlink devices of the node
+type Backend interface {
+	// Devices returns the devlink devices with their eswitch, info and ports
+	Devices() ([]Device, error)
+	// SetEswitchMode changes the eswitch mode of a device
+	SetEswitchMode(bus, device, mode string) error
+	// HealthReporters returns the health reporters by device handle
+	HealthReporters() (map[string][]Reporter, error)
+}
+
+// Netlink is the part of the devlink netlink family the backend uses,
+// replaceable for tests
+type Netlink interface {
+	// DevLinkGetDeviceList returns the devlink devices with their eswitch
+	DevLinkGetDeviceList() ([]*netlink.DevlinkDevice, error)
+	// DevLinkGetAllPortList returns the ports of all devices
+	DevLinkGetAllPortList() ([]*netlink.DevlinkPort, error)
+	// DevlinkGetDeviceInfoByNameAsMap returns the driver, serial number and
+	// versions of a device
+	DevlinkGetDeviceInfoByNameAsMap(bus, device string) (map[string]string, error)
+	// DevLinkGetDeviceByName returns a device
+	DevLinkGetDeviceByName(bus, device string) (*netlink.DevlinkDevice, error)
+	// DevLinkSetEswitchMode changes the eswitch mode of a device
+	DevLinkSetEswitchMode(dev *netlink.DevlinkDevice, mode string) error
+}
+
+// kernelNetlink is the Netlink of the kernel
+type kernelNetlink struct{}
+
+// DevLinkGetDeviceList implements Netlink
+func (kernelNetlink) DevLinkGetDeviceList() ([]*netlink.DevlinkDevice, error) {
+	return netlink.DevLinkGetDeviceList()
+}
+
+// DevLinkGetAllPortList implements Netlink
+func (kernelNetlink) DevLinkGetAllPortList() ([]*netlink.DevlinkPort, error) {
+	return netlink.DevLinkGetAllPortList()
+}
+
+// DevlinkGetDeviceInfoByNameAsMap implements Netlink
+func (kernelNetlink) DevlinkGetDeviceInfoByNameAsMap(bus, device string) (map[string]string, error) {
+	return netlink.DevlinkGetDeviceInfoByNameAsMap(bus, device)
+}
+
+// DevLinkGetDeviceByName implements Netlink
+func (kernelNetlink) DevLinkGetDeviceByName(bus, device string) (*netlink.DevlinkDevice, error) {
+	return netlink.DevLinkGetDeviceByName(bus, device)
+}
+
+// DevLinkSetEswitchMode implements Netlink
+func (kernelNetlink) DevLinkSetEswitchMode(dev *netlink.DevlinkDevice, mode string) error {
+	return netlink.DevLinkSetEswitchMode(dev, mode)
+}
+
+// CommandRunner runs a command and returns its output
+type CommandRunner func(ctx context.Context, name string, args ...string) ([]byte, error)
+
+// NetlinkBackend manages the devices over the devlink netlink family. The
+// netlink library doesn't cover the health reporters, they are read with
+// the devlink tool of iproute2.
+type NetlinkBackend struct {
+	// Context for cancellation
+	ctx context.Context
+	// Devlink netlink family, replaceable for tests
+	nl Netlink
+	// Runs the devlink tool, replaceable for tests
+	run CommandRunner
+}
+
+// NewNetlinkBackend creates a new devlink backend
+func NewNetlinkBackend(ctx context.Context) *NetlinkBackend {
+	return &NetlinkBackend{ctx: ctx, nl: kernelNetlink{}, run: runCommand}
+}
+
+// runCommand runs a command on the host
+func runCommand(ctx context.Context, name string, args ...string) ([]byte, error) {
+	out, err := exec.CommandContext(ctx, name, args...).Output()
+	if err != nil {
+		return out, fmt.Errorf("%s %s failed: %w", name, strings.Join(args, " "), err)
+	}
+	return out, nil
+}
+
+// Devices returns the devlink devices of the node
+func (b *NetlinkBackend) Devices() ([]Device, error) {
+	devs, err := b.nl.DevLinkGetDeviceList()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list devlink devices: %w", err)
+	}
+	netdevs := make(map[string][]string)
+	// drivers without ports only fail the port listing
+	if ports, err := b.nl.DevLinkGetAllPortList(); err == nil {
+		for _, port := range ports {
+			if port.PortFlavour == nl.DEVLINK_PORT_FLAVOUR_PHYSICAL && port.NetdeviceName != "" {
+				handle := port.BusName + "/" + port.DeviceName
+				netdevs[handle] = append(netdevs[handle], port.NetdeviceName)
+			}
+		}
+	}
+
+	devices := make([]Device, 0, len(devs))
+	for _, dev := range devs {
+		d := Device{Bus: dev.BusName, Name: dev.DeviceName}
+		// devices without an eswitch report no mode
+		if dev.Attrs.Eswitch.Mode != "" && dev.Attrs.Eswitch.Mode != "unknown" {
+			d.EswitchMode = dev.Attrs.Eswitch.Mode
+			d.InlineMode = dev.Attrs.Eswitch.InlineMode
+			d.EncapMode = dev.Attrs.Eswitch.EncapMode
+		}
+		// the info is optional for drivers
+		if info, err := b.nl.DevlinkGetDeviceInfoByNameAsMap(dev.BusName, dev.DeviceName); err == nil {
+			d.Driver = info["driver"]
+			d.SerialNumber = info["serialNumber"]
+			delete(info, "driver")
+			delete(info, "serialNumber")
+			if len(info) > 0 {
+				d.Versions = info
+			}
+		}
+		d.Netdevs = netdevs[d.Handle()]
+		sort.Strings(d.Netdevs)
+		devices = append(devices, d)
+	}
+	return devices, nil
+}
+
+// SetEswitchMode changes the eswitch mode of a device
+func (b *NetlinkBackend) SetEswitchMode(bus, device, mode string) error {
+	dev, err := b.nl.DevLinkGetDeviceByName(bus, device)
+	if err != nil {
+		return fmt.Errorf("failed to get devlink device %s/%s: %w", bus, device, err)
+	}
+	if err := b.nl.DevLinkSetEswitchMode(dev, mode); err != nil {
+		return fmt.Errorf("failed to set the eswitch of %s/%s to %s: %w", bus, device, mode, err)
+	}
+	return nil
+}
+
+// HealthReporters returns the health reporters of the devices
+func (b *NetlinkBackend) HealthReporters() (map[string][]Reporter, error) {
+	out, err := b.run(b.ctx, "devlink", "-j", "health", "show")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read devlink health reporters: %w", err)
+	}
+	return parseHealth(out)
+}
+
+// healthReporter is a reporter in the JSON output of devlink health show
+type healthReporter struct {
+	Reporter    string `json:"reporter"`
+	State       string `json:"state"`
+	Error       uint64 `json:"error"`
+	Recover     uint64 `json:"recover"`
+	AutoRecover bool   `json:"auto_recover"`
+}
+
+// parseHealth parses the JSON output of devlink health show. The reporters
+// are keyed by device handle, or by port handle (e.g.,
+// pci/0000:3b:00.0/65535) for the reporters of a port.
+func parseHealth(out []byte) (map[string][]Reporter, error) {
+	var doc struct {
+		Health map[string][]healthReporter `json:"health"`
+	}
+	if err := json.Unmarshal(out, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse devlink health reporters: %w", err)
+	}
+
+	reporters := make(map[string][]Reporter)
+	for handle, list := range doc.Health {
+		device, port := handle, ""
+		// bus/device/port
+		if parts := strings.SplitN(handle, "/", 3); len(parts) == 3 {
+			device, port = parts[0]+"/"+parts[1], parts[2]
+		}
+		for _, hr := range list {
+			reporters[device] = append(reporters[device], Reporter{
+				Name:        hr.Reporter,
+				Port:        port,
+				State:       hr.State,
+				Errors:      hr.Error,
+				Recovers:    hr.Recover,
+				AutoRecover: hr.AutoRecover,
+			})
+		}
+	}
+	for device := range reporters {
+		sort.Slice(reporters[device], func(i, j int) bool {
+			return reporters[device][i].key() < reporters[device][j].key()
+		})
+	}
+	return reporters, nil
+}
+
+// EswitchRequest changes the eswitch mode of a device
+type EswitchRequest struct {
+	// Eswitch mode (legacy, switchdev)
+	Mode string `json:"mode"`
+}
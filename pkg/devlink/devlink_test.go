@@ -0,0 +1,147 @@
+package devlink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+)
+
+// fakeNetlink is an in-memory devlink netlink family
+type fakeNetlink struct {
+	devices []*netlink.DevlinkDevice
+	ports   []*netlink.DevlinkPort
+	// info by device handle, devices without info fail the query
+	info map[string]map[string]string
+	// eswitch mode changes, as handle=mode
+	changes []string
+}
+
+func (f *fakeNetlink) DevLinkGetDeviceList() ([]*netlink.DevlinkDevice, error) {
+	return f.devices, nil
+}
+
+func (f *fakeNetlink) DevLinkGetAllPortList() ([]*netlink.DevlinkPort, error) {
+	if f.ports == nil {
+		return nil, errors.New("EOPNOTSUPP")
+	}
+	return f.ports, nil
+}
+
+func (f *fakeNetlink) DevlinkGetDeviceInfoByNameAsMap(bus, device string) (map[string]string, error) {
+	info, ok := f.info[bus+"/"+device]
+	if !ok {
+		return nil, errors.New("EOPNOTSUPP")
+	}
+	// the backend takes the map apart
+	copied := make(map[string]string, len(info))
+	for k, v := range info {
+		copied[k] = v
+	}
+	return copied, nil
+}
+
+func (f *fakeNetlink) DevLinkGetDeviceByName(bus, device string) (*netlink.DevlinkDevice, error) {
+	for _, dev := range f.devices {
+		if dev.BusName == bus && dev.DeviceName == device {
+			return dev, nil
+		}
+	}
+	return nil, fmt.Errorf("devlink device %s/%s not found", bus, device)
+}
+
+func (f *fakeNetlink) DevLinkSetEswitchMode(dev *netlink.DevlinkDevice, mode string) error {
+	f.changes = append(f.changes, dev.BusName+"/"+dev.DeviceName+"="+mode)
+	dev.Attrs.Eswitch.Mode = mode
+	return nil
+}
+
+func TestNetlinkBackendDevices(t *testing.T) {
+	fake := &fakeNetlink{
+		devices: []*netlink.DevlinkDevice{
+			{BusName: "pci", DeviceName: "0000:3b:00.0", Attrs: netlink.DevlinkDevAttrs{
+				Eswitch: netlink.DevlinkDevEswitchAttr{Mode: EswitchSwitchdev, InlineMode: "none", EncapMode: "basic"}}},
+			{BusName: "pci", DeviceName: "0000:5e:00.0", Attrs: netlink.DevlinkDevAttrs{
+				Eswitch: netlink.DevlinkDevEswitchAttr{Mode: "unknown"}}},
+		},
+		ports: []*netlink.DevlinkPort{
+			{BusName: "pci", DeviceName: "0000:3b:00.0", PortFlavour: nl.DEVLINK_PORT_FLAVOUR_PHYSICAL, NetdeviceName: "ens1f1"},
+			{BusName: "pci", DeviceName: "0000:3b:00.0", PortFlavour: nl.DEVLINK_PORT_FLAVOUR_PHYSICAL, NetdeviceName: "ens1f0"},
+			{BusName: "pci", DeviceName: "0000:3b:00.0", PortFlavour: nl.DEVLINK_PORT_FLAVOUR_PCI_VF, NetdeviceName: "ens1f0_0"},
+		},
+		info: map[string]map[string]string{
+			"pci/0000:3b:00.0": {"driver": "mlx5_core", "serialNumber": "MT2116X09299", "fw.version": "22.31.1014"},
+		},
+	}
+	b := &NetlinkBackend{ctx: context.Background(), nl: fake}
+
+	devices, err := b.Devices()
+	if err != nil {
+		t.Fatalf("Devices() error = %v", err)
+	}
+	want := []Device{
+		{
+			Bus: "pci", Name: "0000:3b:00.0", Driver: "mlx5_core", SerialNumber: "MT2116X09299",
+			Versions:    map[string]string{"fw.version": "22.31.1014"},
+			EswitchMode: EswitchSwitchdev, InlineMode: "none", EncapMode: "basic",
+			Netdevs: []string{"ens1f0", "ens1f1"},
+		},
+		// devices without an eswitch, info or ports report none
+		{Bus: "pci", Name: "0000:5e:00.0"},
+	}
+	if !reflect.DeepEqual(devices, want) {
+		t.Errorf("Devices() = %+v, want %+v", devices, want)
+	}
+
+	// drivers without ports only lose the netdevs
+	fake.ports = nil
+	if devices, err := b.Devices(); err != nil || len(devices) != 2 || devices[0].Netdevs != nil {
+		t.Errorf("Devices() without ports = %+v, %v", devices, err)
+	}
+
+	if err := b.SetEswitchMode("pci", "0000:3b:00.0", EswitchLegacy); err != nil {
+		t.Fatalf("SetEswitchMode() error = %v", err)
+	}
+	if want := []string{"pci/0000:3b:00.0=legacy"}; !reflect.DeepEqual(fake.changes, want) {
+		t.Errorf("changes = %v, want %v", fake.changes, want)
+	}
+	if err := b.SetEswitchMode("pci", "0000:af:00.0", EswitchLegacy); err == nil {
+		t.Error("SetEswitchMode() of a missing device succeeded")
+	}
+}
+
+func TestNetlinkBackendHealthReporters(t *testing.T) {
+	b := &NetlinkBackend{ctx: context.Background(), run: func(_ context.Context, name string, args ...string) ([]byte, error) {
+		return []byte(`{"health":{
+			"pci/0000:3b:00.0":[
+				{"reporter":"fw_fatal","state":"healthy","error":0,"recover":0,"grace_period":1200000,"auto_recover":true},
+				{"reporter":"fw","state":"error","error":2,"recover":1,"auto_recover":false}],
+			"pci/0000:3b:00.0/65535":[
+				{"reporter":"tx","state":"healthy","error":5,"recover":5,"auto_recover":true}],
+			"pci/0000:3b:00.1":[]}}`), nil
+	}}
+
+	reporters, err := b.HealthReporters()
+	if err != nil {
+		t.Fatalf("HealthReporters() error = %v", err)
+	}
+	want := []Reporter{
+		{Name: "tx", Port: "65535", State: StateHealthy, Errors: 5, Recovers: 5, AutoRecover: true},
+		{Name: "fw", State: StateError, Errors: 2, Recovers: 1},
+		{Name: "fw_fatal", State: StateHealthy, AutoRecover: true},
+	}
+	if got := reporters["pci/0000:3b:00.0"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("reporters = %+v, want %+v", got, want)
+	}
+	if got := reporters["pci/0000:3b:00.1"]; len(got) != 0 {
+		t.Errorf("reporters of a device without any = %+v", got)
+	}
+
+	if _, err := parseHealth([]byte("devlink: unknown command")); err == nil {
+		t.Error("expected an error for output that isn't JSON")
+	}
+}
@@ -0,0 +1,298 @@
+package devlink
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/akos011221/nsm/pkg/watchdog"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	// reporterErrors exposes the error counts of the health reporters
+	reporterErrors = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "nsm_devlink_health_reporter_errors",
+		Help: "Errors a devlink health reporter counted since the driver loaded",
+	}, []string{"device", "reporter"})
+
+	// reporterRecovers exposes the recovery counts of the health reporters
+	reporterRecovers = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "nsm_devlink_health_reporter_recovers",
+		Help: "Recoveries a devlink health reporter counted since the driver loaded",
+	}, []string{"device", "reporter"})
+
+	// reporterHealthy is 1 while a reporter is healthy
+	reporterHealthy = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "nsm_devlink_health_reporter_healthy",
+		Help: "Whether a devlink health reporter is in the healthy state",
+	}, []string{"device", "reporter"})
+
+	// healthEventsTotal counts the health events raised
+	healthEventsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "nsm_devlink_health_events_total",
+		Help: "Number of devlink health events (new errors, state changes) by device and reporter",
+	}, []string{"device", "reporter"})
+)
+
+func init() {
+	crmetrics.Registry.MustRegister(reporterErrors, reporterRecovers, reporterHealthy, healthEventsTotal)
+}
+
+// Event is a change of a health reporter: new errors or a state change
+type Event struct {
+	// Handle of the device (e.g., pci/0000:3b:00.0)
+	Device string `json:"device"`
+	// Reporter, prefixed with its port for port reporters
+	Reporter string `json:"reporter"`
+	// State of the reporter
+	State string `json:"state"`
+	// Errors reported since the previous poll
+	NewErrors uint64 `json:"newErrors"`
+	// Total errors and recoveries
+	Errors   uint64 `json:"errors"`
+	Recovers uint64 `json:"recovers"`
+}
+
+// Healthy reports whether the reporter is healthy after the event
+func (e Event) Healthy() bool {
+	return e.State == StateHealthy
+}
+
+// Message describes the event
+func (e Event) Message() string {
+	switch {
+	case e.NewErrors > 0:
+		return fmt.Sprintf("devlink health reporter %s of %s reported %d errors (%d total, %d recovered), state %s",
+			e.Reporter, e.Device, e.NewErrors, e.Errors, e.Recovers, e.State)
+	case e.Healthy():
+		return fmt.Sprintf("devlink health reporter %s of %s recovered", e.Reporter, e.Device)
+	default:
+		return fmt.Sprintf("devlink health reporter %s of %s is in state %s (%d errors, %d recovered)",
+			e.Reporter, e.Device, e.State, e.Errors, e.Recovers)
+	}
+}
+
+// Monitor polls the devlink devices of the node: their eswitch, versions
+// and health reporters. New reporter errors and state changes are raised
+// as events, the first poll only raises the reporters already unhealthy.
+type Monitor struct {
+	// Context for cancellation
+	ctx context.Context
+	// Logger
+	logger *logrus.Logger
+	// Queries and configures the devices
+	backend Backend
+	// Root of the filesystem sysfs is mounted under
+	root string
+	// Interval between polls
+	interval time.Duration
+	// Devices of the last poll
+	devices []Device
+	// Reporters of the last poll, by device handle and reporter
+	reporters map[string]Reporter
+	// Whether a poll succeeded
+	polled bool
+	// Functions called on health events
+	listeners []func(Event)
+	// Mutex for protecting the state
+	mu sync.RWMutex
+	// Progress signal for the watchdog
+	heartbeat *watchdog.Heartbeat
+}
+
+// NewMonitor creates a new devlink monitor
+func NewMonitor(ctx context.Context, logger *logrus.Logger, backend Backend, root string, interval time.Duration) *Monitor {
+	return &Monitor{
+		ctx:       ctx,
+		logger:    logger,
+		backend:   backend,
+		root:      root,
+		interval:  interval,
+		reporters: make(map[string]Reporter),
+	}
+}
+
+// SetHeartbeat makes the monitor report its progress to the watchdog
+func (m *Monitor) SetHeartbeat(hb *watchdog.Heartbeat) {
+	m.heartbeat = hb
+	hb.Expect(m.interval)
+}
+
+// Start polls the devices periodically
+func (m *Monitor) Start() error {
+	m.logger.Infof("Starting devlink monitor (every %s)", m.interval)
+	if err := m.Check(); err != nil {
+		m.logger.WithError(err).Warn("Failed to poll devlink devices")
+	}
+
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			m.heartbeat.Beat()
+			if err := m.Check(); err != nil {
+				m.logger.WithError(err).Warn("Failed to poll devlink devices")
+			}
+
+		case <-m.ctx.Done():
+			m.logger.Info("Stopping devlink monitor")
+			return nil
+		}
+	}
+}
+
+// OnEvent registers a function called on health events
+func (m *Monitor) OnEvent(fn func(Event)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.listeners = append(m.listeners, fn)
+}
+
+// Devices returns the devices of the last poll
+func (m *Monitor) Devices() []Device {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]Device{}, m.devices...)
+}
+
+// Device returns a device of the last poll
+func (m *Monitor) Device(bus, name string) (Device, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, d := range m.devices {
+		if d.Bus == bus && d.Name == name {
+			return d, true
+		}
+	}
+	return Device{}, false
+}
+
+// SetEswitchMode changes the eswitch mode of a device and polls the
+// devices again
+func (m *Monitor) SetEswitchMode(bus, name, mode string) error {
+	if mode != EswitchLegacy && mode != EswitchSwitchdev {
+		return fmt.Errorf("invalid eswitch mode %q, must be %s or %s", mode, EswitchLegacy, EswitchSwitchdev)
+	}
+	dev, ok := m.Device(bus, name)
+	if !ok {
+		return fmt.Errorf("no devlink device %s/%s", bus, name)
+	}
+	if dev.EswitchMode == "" {
+		return fmt.Errorf("devlink device %s has no eswitch", dev.Handle())
+	}
+	if dev.EswitchMode == mode {
+		return nil
+	}
+
+	if err := m.backend.SetEswitchMode(bus, name, mode); err != nil {
+		return err
+	}
+	m.logger.Infof("Changed the eswitch of %s from %s to %s", dev.Handle(), dev.EswitchMode, mode)
+	return m.Check()
+}
+
+// Check polls the devices and their health reporters
+func (m *Monitor) Check() error {
+	devices, err := m.backend.Devices()
+	if err != nil {
+		return err
+	}
+	health, err := m.backend.HealthReporters()
+	if err != nil {
+		// devices without health reporters are still listed
+		m.logger.WithError(err).Debug("Failed to read devlink health reporters")
+		health = nil
+	}
+
+	reporters := make(map[string]Reporter)
+	for i := range devices {
+		d := &devices[i]
+		if d.Bus == "pci" {
+			dir := filepath.Join(m.root, "sys/bus/pci/devices", d.Name)
+			d.NumVFs = readInt(filepath.Join(dir, "sriov_numvfs"))
+			d.TotalVFs = readInt(filepath.Join(dir, "sriov_totalvfs"))
+		}
+		d.Reporters = health[d.Handle()]
+		for _, r := range d.Reporters {
+			reporters[d.Handle()+"|"+r.key()] = r
+		}
+	}
+
+	m.mu.Lock()
+	var events []Event
+	for id, r := range reporters {
+		device, _, _ := strings.Cut(id, "|")
+		prev, seen := m.reporters[id]
+		e := Event{Device: device, Reporter: r.key(), State: r.State, Errors: r.Errors, Recovers: r.Recovers}
+		switch {
+		case !seen && !m.polled:
+			// the errors counted before the first poll aren't new
+			if r.State != StateHealthy {
+				events = append(events, e)
+			}
+		case !seen:
+			// a reporter appearing later, e.g., after a driver reload
+			if r.Errors > 0 || r.State != StateHealthy {
+				e.NewErrors = r.Errors
+				events = append(events, e)
+			}
+		case r.Errors > prev.Errors:
+			e.NewErrors = r.Errors - prev.Errors
+			events = append(events, e)
+		case r.State != prev.State:
+			events = append(events, e)
+		}
+	}
+	m.devices = devices
+	m.reporters = reporters
+	m.polled = true
+	listeners := append([]func(Event){}, m.listeners...)
+	m.mu.Unlock()
+
+	for id, r := range reporters {
+		device, _, _ := strings.Cut(id, "|")
+		labels := prometheus.Labels{"device": device, "reporter": r.key()}
+		reporterErrors.With(labels).Set(float64(r.Errors))
+		reporterRecovers.With(labels).Set(float64(r.Recovers))
+		if r.State == StateHealthy {
+			reporterHealthy.With(labels).Set(1)
+		} else {
+			reporterHealthy.With(labels).Set(0)
+		}
+	}
+	for _, e := range events {
+		healthEventsTotal.WithLabelValues(e.Device, e.Reporter).Inc()
+		if e.Healthy() && e.NewErrors == 0 {
+			m.logger.Info(e.Message())
+		} else {
+			m.logger.Warn(e.Message())
+		}
+		for _, fn := range listeners {
+			fn(e)
+		}
+	}
+	return nil
+}
+
+// readInt reads an integer sysfs attribute, 0 if missing
+func readInt(path string) int {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0
+	}
+	return n
+}
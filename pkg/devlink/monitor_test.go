@@ -0,0 +1,172 @@
+package devlink
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+)
+
+func quietLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return logger
+}
+
+// fakeBackend is a NIC with an eswitch and health reporters
+type fakeBackend struct {
+	devices   []Device
+	reporters map[string][]Reporter
+	modes     []string
+}
+
+func (b *fakeBackend) Devices() ([]Device, error) {
+	return append([]Device{}, b.devices...), nil
+}
+
+func (b *fakeBackend) SetEswitchMode(bus, device, mode string) error {
+	for i := range b.devices {
+		if b.devices[i].Bus == bus && b.devices[i].Name == device {
+			b.devices[i].EswitchMode = mode
+			b.modes = append(b.modes, mode)
+			return nil
+		}
+	}
+	return fmt.Errorf("no device %s/%s", bus, device)
+}
+
+func (b *fakeBackend) HealthReporters() (map[string][]Reporter, error) {
+	return b.reporters, nil
+}
+
+func newFakeBackend() *fakeBackend {
+	return &fakeBackend{
+		devices: []Device{{Bus: "pci", Name: "0000:3b:00.0", Driver: "mlx5_core", EswitchMode: EswitchLegacy}},
+		reporters: map[string][]Reporter{"pci/0000:3b:00.0": {
+			{Name: "fw", State: StateHealthy, Errors: 3, Recovers: 3},
+			{Name: "tx", Port: "1", State: StateHealthy},
+		}},
+	}
+}
+
+func TestMonitorHealthEvents(t *testing.T) {
+	b := newFakeBackend()
+	m := NewMonitor(context.Background(), quietLogger(), b, t.TempDir(), 0)
+	var events []Event
+	m.OnEvent(func(e Event) { events = append(events, e) })
+
+	// the errors counted before the first poll aren't new
+	if err := m.Check(); err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	if len(events) != 0 {
+		t.Fatalf("events on the first poll = %+v", events)
+	}
+	if got := testutil.ToFloat64(reporterErrors.WithLabelValues("pci/0000:3b:00.0", "fw")); got != 3 {
+		t.Errorf("fw errors metric = %v, want 3", got)
+	}
+
+	b.reporters["pci/0000:3b:00.0"][0] = Reporter{Name: "fw", State: StateError, Errors: 5, Recovers: 3}
+	if err := m.Check(); err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	if len(events) != 1 || events[0].Reporter != "fw" || events[0].NewErrors != 2 || events[0].Healthy() {
+		t.Fatalf("events after new errors = %+v", events)
+	}
+	if got := testutil.ToFloat64(reporterHealthy.WithLabelValues("pci/0000:3b:00.0", "fw")); got != 0 {
+		t.Errorf("fw healthy metric = %v, want 0", got)
+	}
+
+	// unchanged reporters raise nothing
+	if err := m.Check(); err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	if len(events) != 1 {
+		t.Fatalf("events without changes = %+v", events[1:])
+	}
+
+	b.reporters["pci/0000:3b:00.0"][0] = Reporter{Name: "fw", State: StateHealthy, Errors: 5, Recovers: 5}
+	b.reporters["pci/0000:3b:00.0"][1] = Reporter{Name: "tx", Port: "1", State: StateHealthy, Errors: 1, Recovers: 1}
+	if err := m.Check(); err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	if len(events) != 3 {
+		t.Fatalf("events after the recovery = %+v", events)
+	}
+	for _, e := range events[1:] {
+		switch e.Reporter {
+		case "fw":
+			if !e.Healthy() || e.NewErrors != 0 {
+				t.Errorf("fw recovery event = %+v", e)
+			}
+		case "1/tx":
+			if e.NewErrors != 1 {
+				t.Errorf("tx error event = %+v", e)
+			}
+		default:
+			t.Errorf("unexpected event %+v", e)
+		}
+	}
+}
+
+func TestMonitorUnhealthyOnFirstPoll(t *testing.T) {
+	b := newFakeBackend()
+	b.reporters["pci/0000:3b:00.0"][0].State = StateError
+	m := NewMonitor(context.Background(), quietLogger(), b, t.TempDir(), 0)
+	var events []Event
+	m.OnEvent(func(e Event) { events = append(events, e) })
+
+	if err := m.Check(); err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	if len(events) != 1 || events[0].Reporter != "fw" || events[0].NewErrors != 0 {
+		t.Errorf("events = %+v, want the unhealthy fw reporter", events)
+	}
+}
+
+func TestMonitorSetEswitchMode(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "sys/bus/pci/devices/0000:3b:00.0")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for attr, value := range map[string]string{"sriov_numvfs": "4", "sriov_totalvfs": "64"} {
+		if err := os.WriteFile(filepath.Join(dir, attr), []byte(value+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b := newFakeBackend()
+	m := NewMonitor(context.Background(), quietLogger(), b, root, 0)
+	if err := m.Check(); err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	dev, ok := m.Device("pci", "0000:3b:00.0")
+	if !ok || dev.NumVFs != 4 || dev.TotalVFs != 64 {
+		t.Fatalf("device = %+v, want 4 of 64 VFs", dev)
+	}
+
+	if err := m.SetEswitchMode("pci", "0000:3b:00.0", "offload"); err == nil {
+		t.Error("expected an error for an invalid mode")
+	}
+	if err := m.SetEswitchMode("pci", "0000:af:00.0", EswitchSwitchdev); err == nil {
+		t.Error("expected an error for an unknown device")
+	}
+	if err := m.SetEswitchMode("pci", "0000:3b:00.0", EswitchSwitchdev); err != nil {
+		t.Fatalf("SetEswitchMode() error = %v", err)
+	}
+	if dev, _ := m.Device("pci", "0000:3b:00.0"); dev.EswitchMode != EswitchSwitchdev {
+		t.Errorf("eswitch mode = %q after the change, want switchdev", dev.EswitchMode)
+	}
+	// the mode the device already has isn't set again
+	if err := m.SetEswitchMode("pci", "0000:3b:00.0", EswitchSwitchdev); err != nil {
+		t.Fatalf("SetEswitchMode() error = %v", err)
+	}
+	if len(b.modes) != 1 {
+		t.Errorf("modes set = %v, want a single change", b.modes)
+	}
+}
@@ -0,0 +1,416 @@
+package disruption
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/akos011221/nsm/pkg/kubecompat"
+	"github.com/akos011221/nsm/pkg/watchdog"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// Kinds of disruptive datapath changes
+const (
+	// KindPFReset resets a PF, taking down every VF on it
+	KindPFReset = "pf-reset"
+	// KindDriverRebind binds a device to another driver
+	KindDriverRebind = "driver-rebind"
+	// KindVFCountChange changes the number of VFs of a PF, which the
+	// kernel only allows through 0, removing every VF
+	KindVFCountChange = "vf-count-change"
+	// KindFirmwareUpdate flashes new firmware on a PF and resets it
+	KindFirmwareUpdate = "firmware-update"
+)
+
+// Policies for changes the disruption budgets don't allow yet
+const (
+	// PolicyDefer reschedules the change until the budgets allow evicting
+	// every affected pod, nothing is evicted before
+	PolicyDefer = "defer"
+	// PolicyEvict evicts the affected pods one by one as the budgets allow,
+	// giving up on the change after the timeout
+	PolicyEvict = "evict"
+)
+
+// changesTotal counts the disruptive changes by kind and outcome
+var changesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "nsm_disruptive_changes_total",
+	Help: "Number of disruptive datapath changes, by kind and outcome (applied, deferred, blocked, failed)",
+}, []string{"kind", "outcome"})
+
+func init() {
+	crmetrics.Registry.MustRegister(changesTotal)
+}
+
+// Change is a disruptive datapath change of a device
+type Change struct {
+	// Kind of the change (pf-reset, driver-rebind, vf-count-change,
+	// firmware-update)
+	Kind string `json:"kind"`
+	// Device changed, a PF name or PCI address
+	Device string `json:"device"`
+}
+
+// String describes the change
+func (c Change) String() string {
+	return c.Kind + " of " + c.Device
+}
+
+// Blocker is an affected pod whose disruption budget doesn't allow evicting it
+type Blocker struct {
+	// Pod as namespace/name
+	Pod string `json:"pod"`
+	// PodDisruptionBudget protecting the pod, as namespace/name
+	Budget string `json:"budget"`
+	// Why the pod can't be evicted
+	Message string `json:"message"`
+}
+
+// BlockedError is returned when the disruption budgets of the affected
+// pods don't allow a change
+type BlockedError struct {
+	// Change blocked
+	Change Change
+	// Pods that can't be evicted
+	Blockers []Blocker
+	// Whether the change was rescheduled, to be applied once allowed
+	Deferred bool
+}
+
+func (e *BlockedError) Error() string {
+	pods := make([]string, 0, len(e.Blockers))
+	for _, b := range e.Blockers {
+		pods = append(pods, b.Pod+" ("+b.Budget+")")
+	}
+	msg := fmt.Sprintf("%s blocked by the disruption budgets of %s", e.Change, strings.Join(pods, ", "))
+	if e.Deferred {
+		msg += ", rescheduled until they allow it"
+	}
+	return msg
+}
+
+// Pending is a change rescheduled until the disruption budgets allow it
+type Pending struct {
+	Change
+	// Time the change was requested
+	Since time.Time `json:"since"`
+	// Pods blocking the change at the last attempt
+	Blockers []Blocker `json:"blockers"`
+}
+
+// pending is a rescheduled change with the functions to apply it
+type pending struct {
+	Pending
+	// Returns the pods affected by the change
+	affected func() []types.NamespacedName
+	// Applies the change once the affected pods are gone
+	apply func() error
+}
+
+// Coordinator runs the disruptive datapath changes (PF resets, driver
+// rebinds, VF count changes) past the PodDisruptionBudgets of the pods
+// using the device. The affected pods are evicted through the eviction
+// API, so the budgets are honored and the workloads get rescheduled,
+// instead of the devices being yanked from under running pods.
+type Coordinator struct {
+	// Context for cancellation
+	ctx context.Context
+	// Kubernetes client
+	clientset kubernetes.Interface
+	// Logger
+	logger *logrus.Logger
+	// Policy for changes the budgets don't allow yet (defer, evict)
+	policy string
+	// Longest time the evictions and pod terminations are waited for
+	timeout time.Duration
+	// Interval the evictions and deferred changes are retried at
+	retryInterval time.Duration
+	// Progress signal for the watchdog
+	heartbeat *watchdog.Heartbeat
+	// API versions the cluster serves
+	capabilities *kubecompat.Capabilities
+
+	// Mutex for protecting the deferred changes
+	mu sync.Mutex
+	// Deferred changes, by kind and device
+	deferred map[Change]*pending
+}
+
+// NewCoordinator creates a new disruption coordinator
+func NewCoordinator(ctx context.Context, clientset kubernetes.Interface, logger *logrus.Logger, policy string, timeout time.Duration) *Coordinator {
+	return &Coordinator{
+		ctx:           ctx,
+		clientset:     clientset,
+		logger:        logger,
+		policy:        policy,
+		timeout:       timeout,
+		retryInterval: 10 * time.Second,
+		capabilities:  kubecompat.Latest(),
+		deferred:      make(map[Change]*pending),
+	}
+}
+
+// SetCapabilities sets the API versions the cluster serves, for evicting
+// pods on clusters without the v1 eviction API
+func (c *Coordinator) SetCapabilities(caps *kubecompat.Capabilities) {
+	c.capabilities = caps
+}
+
+// SetHeartbeat makes the coordinator report its progress to the watchdog
+func (c *Coordinator) SetHeartbeat(hb *watchdog.Heartbeat) {
+	c.heartbeat = hb
+	hb.Expect(c.retryInterval)
+}
+
+// Start retries the deferred changes until the budgets allow them
+func (c *Coordinator) Start() error {
+	c.logger.Infof("Starting disruption coordinator (policy %s)", c.policy)
+
+	ticker := time.NewTicker(c.retryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.heartbeat.Beat()
+			c.retryDeferred()
+
+		case <-c.ctx.Done():
+			c.logger.Info("Stopping disruption coordinator")
+			return nil
+		}
+	}
+}
+
+// Disrupt evicts the pods affected by a change and applies it once they
+// are gone. When the budgets don't allow evicting them, the change is
+// deferred with the defer policy, or given up on after the timeout with
+// the evict policy; a *BlockedError is returned either way.
+func (c *Coordinator) Disrupt(change Change, affected func() []types.NamespacedName, apply func() error) error {
+	p := &pending{Pending: Pending{Change: change, Since: time.Now()}, affected: affected, apply: apply}
+	err := c.run(p)
+	var blocked *BlockedError
+	if errors.As(err, &blocked) && blocked.Deferred {
+		p.Blockers = blocked.Blockers
+		c.mu.Lock()
+		c.deferred[change] = p
+		c.mu.Unlock()
+	}
+	return err
+}
+
+// Deferred returns the changes waiting for the budgets, oldest first
+func (c *Coordinator) Deferred() []Pending {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	deferred := make([]Pending, 0, len(c.deferred))
+	for _, p := range c.deferred {
+		deferred = append(deferred, p.Pending)
+	}
+	sort.Slice(deferred, func(i, j int) bool { return deferred[i].Since.Before(deferred[j].Since) })
+	return deferred
+}
+
+// retryDeferred retries the deferred changes, dropping the ones applied or failed
+func (c *Coordinator) retryDeferred() {
+	c.mu.Lock()
+	deferred := make([]*pending, 0, len(c.deferred))
+	for _, p := range c.deferred {
+		deferred = append(deferred, p)
+	}
+	c.mu.Unlock()
+
+	for _, p := range deferred {
+		err := c.run(p)
+		var blocked *BlockedError
+		if errors.As(err, &blocked) && blocked.Deferred {
+			c.mu.Lock()
+			p.Blockers = blocked.Blockers
+			c.mu.Unlock()
+			continue
+		}
+		if err != nil {
+			c.logger.WithError(err).Warnf("Deferred %s failed", p.Change)
+		}
+		c.mu.Lock()
+		// unless a newer request for the device replaced it meanwhile
+		if c.deferred[p.Change] == p {
+			delete(c.deferred, p.Change)
+		}
+		c.mu.Unlock()
+	}
+}
+
+// run evicts the affected pods and applies the change
+func (c *Coordinator) run(p *pending) error {
+	pods := p.affected()
+	if len(pods) > 0 {
+		blockers, err := c.Check(c.ctx, pods)
+		if err != nil {
+			changesTotal.WithLabelValues(p.Kind, "failed").Inc()
+			return fmt.Errorf("failed to check the disruption budgets for %s: %w", p.Change, err)
+		}
+		if len(blockers) > 0 && c.policy == PolicyDefer {
+			changesTotal.WithLabelValues(p.Kind, "deferred").Inc()
+			return &BlockedError{Change: p.Change, Blockers: blockers, Deferred: true}
+		}
+		if err := c.evict(p.Change, pods); err != nil {
+			var blocked *BlockedError
+			if errors.As(err, &blocked) {
+				changesTotal.WithLabelValues(p.Kind, "blocked").Inc()
+			} else {
+				changesTotal.WithLabelValues(p.Kind, "failed").Inc()
+			}
+			return err
+		}
+	}
+
+	if err := p.apply(); err != nil {
+		changesTotal.WithLabelValues(p.Kind, "failed").Inc()
+		return fmt.Errorf("failed to apply %s: %w", p.Change, err)
+	}
+	c.logger.Infof("Applied %s after evicting %d pods", p.Change, len(pods))
+	changesTotal.WithLabelValues(p.Kind, "applied").Inc()
+	return nil
+}
+
+// Check returns the pods whose disruption budgets don't allow evicting
+// them all at once, pods without a budget never block
+func (c *Coordinator) Check(ctx context.Context, pods []types.NamespacedName) ([]Blocker, error) {
+	// the evictions each budget has to allow
+	type budget struct {
+		pdb  *policyv1.PodDisruptionBudget
+		pods []string
+	}
+	budgets := make(map[string]*budget)
+	listed := make(map[string][]policyv1.PodDisruptionBudget)
+
+	for _, key := range pods {
+		pod, err := c.clientset.CoreV1().Pods(key.Namespace).Get(ctx, key.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get pod %s: %w", key, err)
+		}
+		if pod.DeletionTimestamp != nil || pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+
+		pdbs, ok := listed[key.Namespace]
+		if !ok {
+			list, err := c.capabilities.ListPodDisruptionBudgets(ctx, c.clientset, key.Namespace)
+			if err != nil {
+				return nil, fmt.Errorf("failed to list the disruption budgets of namespace %s: %w", key.Namespace, err)
+			}
+			pdbs = list
+			listed[key.Namespace] = pdbs
+		}
+		for i := range pdbs {
+			pdb := &pdbs[i]
+			// a nil selector selects nothing, an empty one every pod
+			if pdb.Spec.Selector == nil {
+				continue
+			}
+			selector, err := metav1.LabelSelectorAsSelector(pdb.Spec.Selector)
+			if err != nil || !selector.Matches(labels.Set(pod.Labels)) {
+				continue
+			}
+			name := pdb.Namespace + "/" + pdb.Name
+			if budgets[name] == nil {
+				budgets[name] = &budget{pdb: pdb}
+			}
+			budgets[name].pods = append(budgets[name].pods, key.String())
+		}
+	}
+
+	var blockers []Blocker
+	for name, b := range budgets {
+		allowed := int(b.pdb.Status.DisruptionsAllowed)
+		if len(b.pods) <= allowed {
+			continue
+		}
+		for _, pod := range b.pods {
+			blockers = append(blockers, Blocker{
+				Pod:     pod,
+				Budget:  name,
+				Message: fmt.Sprintf("budget allows %d disruptions, the change evicts %d of its pods", allowed, len(b.pods)),
+			})
+		}
+	}
+	sort.Slice(blockers, func(i, j int) bool {
+		if blockers[i].Pod != blockers[j].Pod {
+			return blockers[i].Pod < blockers[j].Pod
+		}
+		return blockers[i].Budget < blockers[j].Budget
+	})
+	return blockers, nil
+}
+
+// evict evicts the pods through the eviction API, retrying the evictions
+// the budgets refuse, and waits for the pods to terminate
+func (c *Coordinator) evict(change Change, pods []types.NamespacedName) error {
+	ctx, cancel := context.WithTimeout(c.ctx, c.timeout)
+	defer cancel()
+
+	remaining := append([]types.NamespacedName(nil), pods...)
+	for {
+		var refused []types.NamespacedName
+		var blockers []Blocker
+		for _, key := range remaining {
+			err := c.capabilities.Evict(ctx, c.clientset, key.Namespace, key.Name)
+			switch {
+			case err == nil:
+				c.logger.Infof("Evicted pod %s for %s", key, change)
+			case apierrors.IsNotFound(err):
+			case apierrors.IsTooManyRequests(err):
+				// the budget doesn't allow it yet
+				refused = append(refused, key)
+				blockers = append(blockers, Blocker{Pod: key.String(), Message: err.Error()})
+			default:
+				return fmt.Errorf("failed to evict pod %s for %s: %w", key, change, err)
+			}
+		}
+		if len(refused) == 0 {
+			break
+		}
+		remaining = refused
+
+		select {
+		case <-time.After(c.retryInterval):
+		case <-ctx.Done():
+			return &BlockedError{Change: change, Blockers: blockers}
+		}
+	}
+
+	// the devices are only changed once no pod uses them
+	for _, key := range pods {
+		for {
+			_, err := c.clientset.CoreV1().Pods(key.Namespace).Get(ctx, key.Name, metav1.GetOptions{})
+			if apierrors.IsNotFound(err) {
+				break
+			}
+			select {
+			case <-time.After(time.Second):
+			case <-ctx.Done():
+				return fmt.Errorf("pod %s not terminated in %s for %s", key, c.timeout, change)
+			}
+		}
+	}
+	return nil
+}
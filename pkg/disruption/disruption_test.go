@@ -0,0 +1,170 @@
+package disruption
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+var pdbResource = policyv1.SchemeGroupVersion.WithResource("poddisruptionbudgets")
+
+func pod(name, app string) *corev1.Pod {
+	return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "edge", Labels: map[string]string{"app": app}}}
+}
+
+func budget(name, app string, allowed int32) *policyv1.PodDisruptionBudget {
+	return &policyv1.PodDisruptionBudget{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "edge"},
+		Spec:       policyv1.PodDisruptionBudgetSpec{Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": app}}},
+		Status:     policyv1.PodDisruptionBudgetStatus{DisruptionsAllowed: allowed},
+	}
+}
+
+// newTestCoordinator returns a coordinator whose evictions delete the pods
+// while their budgets have disruptions left
+func newTestCoordinator(t *testing.T, policy string, objs ...runtime.Object) (*Coordinator, *fake.Clientset) {
+	t.Helper()
+	clientset := fake.NewSimpleClientset(objs...)
+	clientset.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "eviction" {
+			return false, nil, nil
+		}
+		eviction := action.(k8stesting.CreateAction).GetObject().(*policyv1.Eviction)
+		p, err := clientset.Tracker().Get(corev1.SchemeGroupVersion.WithResource("pods"), eviction.Namespace, eviction.Name)
+		if err != nil {
+			return true, nil, err
+		}
+		// the reactors run under the clientset lock, so the budgets are read from the tracker
+		pdbs, err := clientset.Tracker().List(pdbResource, policyv1.SchemeGroupVersion.WithKind("PodDisruptionBudget"), eviction.Namespace)
+		if err != nil {
+			return true, nil, err
+		}
+		for _, pdb := range pdbs.(*policyv1.PodDisruptionBudgetList).Items {
+			if pdb.Spec.Selector.MatchLabels["app"] != p.(*corev1.Pod).Labels["app"] {
+				continue
+			}
+			if pdb.Status.DisruptionsAllowed == 0 {
+				return true, nil, apierrors.NewTooManyRequests("Cannot evict pod as it would violate the pod's disruption budget.", 0)
+			}
+			pdb.Status.DisruptionsAllowed--
+			if err := clientset.Tracker().Update(pdbResource, &pdb, pdb.Namespace); err != nil {
+				return true, nil, err
+			}
+		}
+		return true, nil, clientset.Tracker().Delete(corev1.SchemeGroupVersion.WithResource("pods"), eviction.Namespace, eviction.Name)
+	})
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	c := NewCoordinator(context.Background(), clientset, logger, policy, time.Second)
+	c.retryInterval = 10 * time.Millisecond
+	return c, clientset
+}
+
+func keys(names ...string) func() []types.NamespacedName {
+	return func() []types.NamespacedName {
+		var pods []types.NamespacedName
+		for _, name := range names {
+			pods = append(pods, types.NamespacedName{Namespace: "edge", Name: name})
+		}
+		return pods
+	}
+}
+
+func TestCheck(t *testing.T) {
+	c, _ := newTestCoordinator(t, PolicyDefer,
+		pod("router-0", "router"), pod("router-1", "router"), pod("camera", "camera"), pod("batch", "batch"),
+		budget("router", "router", 1), budget("camera", "camera", 1))
+
+	blockers, err := c.Check(context.Background(), keys("router-0", "camera", "batch", "gone")())
+	if err != nil || len(blockers) != 0 {
+		t.Fatalf("Check() = %+v, %v, want no blockers", blockers, err)
+	}
+
+	// the router budget allows one disruption, not two
+	blockers, err = c.Check(context.Background(), keys("router-0", "router-1", "camera")())
+	if err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	if len(blockers) != 2 || blockers[0].Pod != "edge/router-0" || blockers[1].Budget != "edge/router" {
+		t.Errorf("Check() = %+v, want both routers blocked by their budget", blockers)
+	}
+}
+
+func TestDisruptEvictsBeforeApplying(t *testing.T) {
+	c, clientset := newTestCoordinator(t, PolicyDefer, pod("camera", "camera"), pod("batch", "batch"), budget("camera", "camera", 1))
+
+	applied := false
+	err := c.Disrupt(Change{Kind: KindVFCountChange, Device: "eth0"}, keys("camera", "batch"), func() error {
+		// the pods are gone before the devices change
+		pods, _ := clientset.CoreV1().Pods("edge").List(context.Background(), metav1.ListOptions{})
+		if len(pods.Items) != 0 {
+			t.Errorf("change applied with %d pods running", len(pods.Items))
+		}
+		applied = true
+		return nil
+	})
+	if err != nil || !applied {
+		t.Fatalf("Disrupt() error = %v, applied = %t", err, applied)
+	}
+}
+
+func TestDisruptDefersBlockedChanges(t *testing.T) {
+	c, clientset := newTestCoordinator(t, PolicyDefer, pod("router-0", "router"), pod("router-1", "router"), budget("router", "router", 1))
+
+	applied := false
+	change := Change{Kind: KindPFReset, Device: "eth0"}
+	err := c.Disrupt(change, keys("router-0", "router-1"), func() error { applied = true; return nil })
+	var blocked *BlockedError
+	if !errors.As(err, &blocked) || !blocked.Deferred || applied {
+		t.Fatalf("Disrupt() error = %v, applied = %t, want a deferred change", err, applied)
+	}
+	// nothing is evicted while deferred
+	if pods, _ := clientset.CoreV1().Pods("edge").List(context.Background(), metav1.ListOptions{}); len(pods.Items) != 2 {
+		t.Errorf("%d pods left, want none evicted", len(pods.Items))
+	}
+	if deferred := c.Deferred(); len(deferred) != 1 || deferred[0].Change != change || len(deferred[0].Blockers) != 2 {
+		t.Fatalf("Deferred() = %+v", deferred)
+	}
+
+	// the change is applied once the budget allows it
+	c.retryDeferred()
+	if applied {
+		t.Fatal("deferred change applied while still blocked")
+	}
+	pdb := budget("router", "router", 2)
+	if _, err := clientset.PolicyV1().PodDisruptionBudgets("edge").UpdateStatus(context.Background(), pdb, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	c.retryDeferred()
+	if !applied || len(c.Deferred()) != 0 {
+		t.Errorf("applied = %t, deferred = %+v, want the change applied", applied, c.Deferred())
+	}
+}
+
+func TestDisruptEvictGivesUpAfterTimeout(t *testing.T) {
+	c, _ := newTestCoordinator(t, PolicyEvict, pod("router-0", "router"), pod("router-1", "router"), budget("router", "router", 1))
+	c.timeout = 100 * time.Millisecond
+
+	applied := false
+	err := c.Disrupt(Change{Kind: KindDriverRebind, Device: "0000:3b:00.0"}, keys("router-0", "router-1"), func() error { applied = true; return nil })
+	var blocked *BlockedError
+	if !errors.As(err, &blocked) || blocked.Deferred || applied {
+		t.Fatalf("Disrupt() error = %v, applied = %t, want a blocked change", err, applied)
+	}
+	if len(c.Deferred()) != 0 {
+		t.Error("change deferred with the evict policy")
+	}
+}
@@ -0,0 +1,410 @@
+package drill
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/netutil"
+	"github.com/akos011221/nsm/pkg/probe"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// Budgets is the failover time every failover strategy has to meet
+var Budgets = map[string]time.Duration{
+	"fast":     100 * time.Millisecond,
+	"balanced": time.Second,
+	"reliable": 5 * time.Second,
+}
+
+// Number of answered probes in a row after which a connection counts as
+// recovered
+const recoveryProbes = 3
+
+// Number of reports kept for the management API
+const maxReports = 20
+
+// ErrBusy is returned while another drill is running
+var ErrBusy = errors.New("a failover drill is already running")
+
+// drillsTotal counts the drills by outcome
+var drillsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "nsm_failover_drills_total",
+	Help: "Number of failover drills by result (passed, failed)",
+}, []string{"result"})
+
+func init() {
+	crmetrics.Registry.MustRegister(drillsTotal)
+}
+
+// Fault simulates the failure of a device
+type Fault interface {
+	// Fail takes the device out of service
+	Fail(device string) error
+	// Restore puts the device back into service
+	Restore(device string) error
+}
+
+// LinkFault fails a path, PF or uplink by setting its link down
+type LinkFault struct {
+	// Netlink operations
+	nl netutil.Interface
+}
+
+// NewLinkFault creates a new link fault
+func NewLinkFault(nl netutil.Interface) *LinkFault {
+	return &LinkFault{nl: nl}
+}
+
+// Fail implements Fault. Devices that are already down are refused, the
+// drill would neither measure anything nor know whether to bring them up.
+func (f *LinkFault) Fail(device string) error {
+	link, err := f.nl.LinkByName(device)
+	if err != nil {
+		return fmt.Errorf("failed to get device %s: %w", device, err)
+	}
+	if !link.Up {
+		return fmt.Errorf("device %s is already down", device)
+	}
+	if err := f.nl.LinkSetDown(device); err != nil {
+		return fmt.Errorf("failed to set device %s down: %w", device, err)
+	}
+	return nil
+}
+
+// Restore implements Fault
+func (f *LinkFault) Restore(device string) error {
+	if err := f.nl.LinkSetUp(device); err != nil {
+		return fmt.Errorf("failed to set device %s up: %w", device, err)
+	}
+	return nil
+}
+
+// Request describes a drill failing a device under the connections
+// matching a selector
+type Request struct {
+	// Path, PF or uplink device to fail
+	Device string `json:"device"`
+	// Namespace of the connections, empty for all namespaces
+	Namespace string `json:"namespace,omitempty"`
+	// Label selector of the connections to measure (e.g., site=plant-1)
+	Selector string `json:"selector"`
+	// Longest time in seconds the device stays down, 0 for the configured maximum
+	DurationSec int `json:"durationSec,omitempty"`
+}
+
+// ConnectionResult is the failover measured for one connection
+type ConnectionResult struct {
+	// Connection (namespace/name)
+	Connection string `json:"connection"`
+	// Why the connection was not measured, empty if it was
+	Skipped string `json:"skipped,omitempty"`
+	// Whether the connection lost probes while the device was down
+	Affected bool `json:"affected"`
+	// Whether the connection answered again while the device was down
+	Recovered bool `json:"recovered"`
+	// Time from the failure to the first answer of the recovery, in milliseconds
+	FailoverMs int64 `json:"failoverMs"`
+	// Number of probes lost
+	LostProbes int `json:"lostProbes"`
+	// Whether the connection failed over within the budget
+	Passed bool `json:"passed"`
+}
+
+// Report is the outcome of a drill
+type Report struct {
+	// Sequence number of the drill
+	ID int `json:"id"`
+	// Failed device
+	Device string `json:"device"`
+	// Configured failover strategy
+	Strategy string `json:"strategy"`
+	// Failover time the strategy has to meet, in milliseconds
+	BudgetMs int64 `json:"budgetMs"`
+	// Time the device was failed
+	Started time.Time `json:"started"`
+	// Time the device was down, in milliseconds
+	DurationMs int64 `json:"durationMs"`
+	// Results by connection
+	Connections []ConnectionResult `json:"connections"`
+	// Error restoring the device, empty if it is back in service
+	RestoreError string `json:"restoreError,omitempty"`
+	// Whether every measured connection passed
+	Passed bool `json:"passed"`
+}
+
+// Runner runs failover drills: it fails a device, probes the probe target
+// of every selected connection until traffic flows again, restores the
+// device and reports the failover time of every connection against the
+// budget of the configured failover strategy. Connections unaffected by
+// the failure pass, as do those recovering within the budget.
+type Runner struct {
+	// Kubernetes client (controller-runtime)
+	client client.Client
+	// Logger
+	logger *logrus.Logger
+	// Fails and restores the devices
+	fault Fault
+	// Sends the probes
+	prober probe.Prober
+	// Configured failover strategy
+	strategy string
+	// Failover time the strategy has to meet
+	budget time.Duration
+	// Longest time a device stays down
+	maxDuration time.Duration
+	// Time between probe rounds
+	interval time.Duration
+	// Clock, replaceable for tests
+	now func() time.Time
+	// Guards running, reports and drills
+	mu sync.Mutex
+	// Whether a drill is running
+	running bool
+	// Latest reports, oldest first
+	reports []Report
+	// Number of drills run
+	drills int
+}
+
+// NewRunner creates a new drill runner for the given failover strategy.
+// No drill keeps a device down for longer than maxDuration.
+func NewRunner(c client.Client, logger *logrus.Logger, fault Fault, prober probe.Prober, strategy string, maxDuration time.Duration) *Runner {
+	strategy = strings.ToLower(strategy)
+	return &Runner{
+		client:      c,
+		logger:      logger,
+		fault:       fault,
+		prober:      prober,
+		strategy:    strategy,
+		budget:      Budgets[strategy],
+		maxDuration: maxDuration,
+		interval:    10 * time.Millisecond,
+		now:         time.Now,
+	}
+}
+
+// Reports returns the latest reports, newest first
+func (r *Runner) Reports() []Report {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	reports := make([]Report, 0, len(r.reports))
+	for i := len(r.reports) - 1; i >= 0; i-- {
+		reports = append(reports, r.reports[i])
+	}
+	return reports
+}
+
+// target is a connection measured by a drill
+type target struct {
+	// Result being measured
+	result *ConnectionResult
+	// Probe target of the connection
+	address string
+	// Answered probes in a row since the last loss
+	streak int
+	// Time of the first answer of the streak
+	streakStart time.Time
+	// Whether the measurement is complete
+	done bool
+}
+
+// Run runs a drill. An empty selector is rejected, so a typo can't fail
+// the traffic of the whole node; so is a drill without any connection to
+// measure, which would take the device down for nothing.
+func (r *Runner) Run(ctx context.Context, req Request) (*Report, error) {
+	if req.Device == "" {
+		return nil, fmt.Errorf("a device is required for failover drills")
+	}
+	if strings.TrimSpace(req.Selector) == "" {
+		return nil, fmt.Errorf("a label selector is required for failover drills")
+	}
+	selector, err := labels.Parse(req.Selector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid label selector: %w", err)
+	}
+	duration := r.maxDuration
+	if req.DurationSec < 0 || time.Duration(req.DurationSec)*time.Second > r.maxDuration {
+		return nil, fmt.Errorf("drill duration must be between 1 and %d seconds", int(r.maxDuration.Seconds()))
+	}
+	if req.DurationSec > 0 {
+		duration = time.Duration(req.DurationSec) * time.Second
+	}
+
+	r.mu.Lock()
+	if r.running {
+		r.mu.Unlock()
+		return nil, ErrBusy
+	}
+	r.running = true
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		r.running = false
+		r.mu.Unlock()
+	}()
+
+	opts := []client.ListOption{client.MatchingLabelsSelector{Selector: selector}}
+	if req.Namespace != "" {
+		opts = append(opts, client.InNamespace(req.Namespace))
+	}
+	var conns nsmv1.NetworkConnectionList
+	if err := r.client.List(ctx, &conns, opts...); err != nil {
+		return nil, fmt.Errorf("failed to list connections: %w", err)
+	}
+
+	report := &Report{
+		Device:      req.Device,
+		Strategy:    r.strategy,
+		BudgetMs:    r.budget.Milliseconds(),
+		Connections: make([]ConnectionResult, len(conns.Items)),
+	}
+	var targets []*target
+	for i := range conns.Items {
+		conn := &conns.Items[i]
+		result := &report.Connections[i]
+		result.Connection = conn.Namespace + "/" + conn.Name
+		switch {
+		case !conn.Status.Established:
+			result.Skipped = "not established"
+		case conn.Spec.ProbeTarget == "":
+			result.Skipped = "no probe target"
+		default:
+			targets = append(targets, &target{result: result, address: conn.Spec.ProbeTarget})
+		}
+	}
+
+	// connections that don't answer before the failure can't fail over
+	baseline := r.probe(ctx, targets)
+	measured := targets[:0]
+	for i, t := range targets {
+		if !baseline[i] {
+			t.result.Skipped = "unreachable before the drill"
+			continue
+		}
+		measured = append(measured, t)
+	}
+	if len(measured) == 0 {
+		return nil, fmt.Errorf("none of the %d selected connections can be measured", len(conns.Items))
+	}
+
+	if err := r.fault.Fail(req.Device); err != nil {
+		return nil, err
+	}
+	report.Started = r.now()
+	r.logger.Warnf("Failover drill: device %s down, measuring %d connections", req.Device, len(measured))
+	r.measure(ctx, measured, report.Started, duration)
+	report.DurationMs = r.now().Sub(report.Started).Milliseconds()
+	if err := r.fault.Restore(req.Device); err != nil {
+		r.logger.Errorf("Failover drill: %v", err)
+		report.RestoreError = err.Error()
+	}
+
+	report.Passed = report.RestoreError == ""
+	for _, t := range measured {
+		t.result.Passed = !t.result.Affected || (t.result.Recovered && t.result.FailoverMs <= report.BudgetMs)
+		report.Passed = report.Passed && t.result.Passed
+	}
+	sort.Slice(report.Connections, func(i, j int) bool {
+		return report.Connections[i].Connection < report.Connections[j].Connection
+	})
+
+	result := "failed"
+	if report.Passed {
+		result = "passed"
+	}
+	drillsTotal.WithLabelValues(result).Inc()
+
+	r.mu.Lock()
+	r.drills++
+	report.ID = r.drills
+	r.reports = append(r.reports, *report)
+	if len(r.reports) > maxReports {
+		r.reports = r.reports[len(r.reports)-maxReports:]
+	}
+	r.mu.Unlock()
+
+	r.logger.Infof("Failover drill %d on %s %s: %d connections measured within a budget of %s",
+		report.ID, req.Device, result, len(measured), r.budget)
+	return report, nil
+}
+
+// measure probes the targets in rounds until all of them recovered or
+// stayed unaffected long enough, or the duration passed
+func (r *Runner) measure(ctx context.Context, targets []*target, start time.Time, duration time.Duration) {
+	// a connection answering for three budgets (at least a second) after
+	// the failure is taken as unaffected
+	settle := min(max(3*r.budget, time.Second), duration)
+	pending := targets
+	for len(pending) > 0 {
+		answers := r.probe(ctx, pending)
+		now := r.now()
+		elapsed := now.Sub(start)
+
+		next := pending[:0]
+		for i, t := range pending {
+			res := t.result
+			switch {
+			case !answers[i]:
+				res.Affected = true
+				res.LostProbes++
+				t.streak = 0
+			case t.streak == 0:
+				t.streak = 1
+				t.streakStart = now
+			default:
+				t.streak++
+			}
+			if res.Affected && t.streak >= recoveryProbes {
+				res.Recovered = true
+				res.FailoverMs = t.streakStart.Sub(start).Milliseconds()
+				t.done = true
+			}
+			if !res.Affected && elapsed >= settle {
+				t.done = true
+			}
+			if !t.done {
+				next = append(next, t)
+			}
+		}
+		pending = next
+
+		if elapsed >= duration || ctx.Err() != nil {
+			break
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(r.interval):
+		}
+	}
+	for _, t := range pending {
+		if t.result.Affected {
+			t.result.FailoverMs = r.now().Sub(start).Milliseconds()
+		}
+	}
+}
+
+// probe sends one probe to every target at once and reports which answered
+func (r *Runner) probe(ctx context.Context, targets []*target) []bool {
+	answers := make([]bool, len(targets))
+	var wg sync.WaitGroup
+	for i, t := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			samples, err := r.prober.Probe(ctx, t.address, 1, 0)
+			answers[i] = err == nil && len(samples) > 0 && samples[0].OK
+		}()
+	}
+	wg.Wait()
+	return answers
+}
@@ -0,0 +1,266 @@
+package drill
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/netutil"
+	"github.com/akos011221/nsm/pkg/probe"
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// scriptedProber answers the probes of every target in turn, repeating
+// the last answer once the script ran out
+type scriptedProber struct {
+	mu      sync.Mutex
+	answers map[string][]bool
+}
+
+func (p *scriptedProber) Probe(ctx context.Context, target string, count int, interval time.Duration) ([]probe.Sample, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	answers := p.answers[target]
+	if len(answers) == 0 {
+		return nil, errors.New("no route to host")
+	}
+	ok := answers[0]
+	if len(answers) > 1 {
+		p.answers[target] = answers[1:]
+	}
+	return []probe.Sample{{OK: ok, RTT: time.Millisecond}}, nil
+}
+
+// recordingFault records the devices failed and restored
+type recordingFault struct {
+	calls []string
+}
+
+func (f *recordingFault) Fail(device string) error {
+	f.calls = append(f.calls, "fail "+device)
+	return nil
+}
+
+func (f *recordingFault) Restore(device string) error {
+	f.calls = append(f.calls, "restore "+device)
+	return nil
+}
+
+func drillConnection(name, target string, established bool) *nsmv1.NetworkConnection {
+	return &nsmv1.NetworkConnection{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "edge", Labels: map[string]string{"site": "plant-1"}},
+		Spec: nsmv1.NetworkConnectionSpec{
+			ConnectionType: nsmv1.ConnectionTypeKernel,
+			ProbeTarget:    target,
+		},
+		Status: nsmv1.NetworkConnectionStatus{Established: established},
+	}
+}
+
+// answers returns n copies of an answer
+func answers(ok bool, n int) []bool {
+	a := make([]bool, n)
+	for i := range a {
+		a[i] = ok
+	}
+	return a
+}
+
+func newTestRunner(t *testing.T, strategy string, prober *scriptedProber, objs ...client.Object) (*Runner, *recordingFault) {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := nsmv1.AddToScheme(scheme); err != nil {
+		t.Fatalf("failed to build scheme: %v", err)
+	}
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	fault := &recordingFault{}
+	r := NewRunner(fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build(), logger, fault, prober, strategy, 10*time.Second)
+	r.interval = 0
+	// every reading of the clock advances it by 10ms
+	clock := time.Now()
+	r.now = func() time.Time {
+		clock = clock.Add(10 * time.Millisecond)
+		return clock
+	}
+	return r, fault
+}
+
+func TestRunMeasuresFailover(t *testing.T) {
+	// the plc connection loses five rounds of probes, then fails over
+	plc := append([]bool{true}, answers(false, 5)...)
+	prober := &scriptedProber{answers: map[string][]bool{
+		"10.0.0.1:502": append(plc, true),
+		"10.0.0.2:80":  {true},
+		"10.0.0.3:80":  {false},
+	}}
+	r, fault := newTestRunner(t, "balanced", prober,
+		drillConnection("plc", "10.0.0.1:502", true),
+		drillConnection("historian", "10.0.0.2:80", true),
+		drillConnection("offline", "10.0.0.3:80", true),
+		drillConnection("paused", "10.0.0.4:80", false),
+		drillConnection("unprobed", "", true),
+	)
+
+	report, err := r.Run(context.Background(), Request{Device: "eth1", Selector: "site=plant-1"})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if len(fault.calls) != 2 || fault.calls[0] != "fail eth1" || fault.calls[1] != "restore eth1" {
+		t.Errorf("unexpected fault calls %v", fault.calls)
+	}
+	if !report.Passed || report.Strategy != "balanced" || report.BudgetMs != 1000 || report.ID != 1 {
+		t.Errorf("unexpected report %+v", report)
+	}
+
+	results := make(map[string]ConnectionResult)
+	for _, res := range report.Connections {
+		results[res.Connection] = res
+	}
+	// the first answer came in the sixth round, 60ms after the failure
+	if res := results["edge/plc"]; !res.Affected || !res.Recovered || res.FailoverMs != 60 || res.LostProbes != 5 || !res.Passed {
+		t.Errorf("unexpected result of the plc connection %+v", res)
+	}
+	if res := results["edge/historian"]; res.Affected || !res.Passed || res.Skipped != "" {
+		t.Errorf("unexpected result of the unaffected connection %+v", res)
+	}
+	skipped := map[string]string{
+		"edge/offline":  "unreachable before the drill",
+		"edge/paused":   "not established",
+		"edge/unprobed": "no probe target",
+	}
+	for conn, reason := range skipped {
+		if res := results[conn]; res.Skipped != reason || res.Passed {
+			t.Errorf("unexpected result of %s %+v, want skipped as %s", conn, res, reason)
+		}
+	}
+
+	if reports := r.Reports(); len(reports) != 1 || reports[0].ID != 1 {
+		t.Errorf("Reports() = %+v", reports)
+	}
+}
+
+func TestRunFailsSlowFailover(t *testing.T) {
+	// twenty lost rounds take 200ms, twice the budget of the fast strategy
+	prober := &scriptedProber{answers: map[string][]bool{
+		"10.0.0.1:502": append(append([]bool{true}, answers(false, 20)...), true),
+		"10.0.0.2:80":  {true, false},
+	}}
+	r, fault := newTestRunner(t, "fast", prober,
+		drillConnection("plc", "10.0.0.1:502", true),
+		drillConnection("stranded", "10.0.0.2:80", true),
+	)
+
+	report, err := r.Run(context.Background(), Request{Device: "eth1", Selector: "site=plant-1", DurationSec: 1})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if report.Passed {
+		t.Errorf("drill passed despite the slow failover")
+	}
+	plc, stranded := report.Connections[0], report.Connections[1]
+	if plc.FailoverMs != 210 || !plc.Recovered || plc.Passed {
+		t.Errorf("unexpected result of the slow connection %+v", plc)
+	}
+	// the stranded connection never recovers, the drill ends after its duration
+	if stranded.Recovered || stranded.Passed || stranded.FailoverMs < 1000 {
+		t.Errorf("unexpected result of the stranded connection %+v", stranded)
+	}
+	if report.DurationMs < 1000 || report.DurationMs > 1100 {
+		t.Errorf("device down for %dms, want about a second", report.DurationMs)
+	}
+	if len(fault.calls) != 2 || fault.calls[1] != "restore eth1" {
+		t.Errorf("device not restored: %v", fault.calls)
+	}
+}
+
+func TestRunInvalid(t *testing.T) {
+	prober := &scriptedProber{answers: map[string][]bool{"10.0.0.1:502": {false}}}
+	r, fault := newTestRunner(t, "balanced", prober, drillConnection("plc", "10.0.0.1:502", true))
+
+	tests := map[string]Request{
+		"no device":      {Selector: "site=plant-1"},
+		"no selector":    {Device: "eth1", Selector: " "},
+		"bad selector":   {Device: "eth1", Selector: "site=="},
+		"too long":       {Device: "eth1", Selector: "site=plant-1", DurationSec: 60},
+		"nothing to see": {Device: "eth1", Selector: "site=plant-1"},
+		"no match":       {Device: "eth1", Selector: "site=plant-2"},
+	}
+	for name, req := range tests {
+		if _, err := r.Run(context.Background(), req); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+	if len(fault.calls) != 0 {
+		t.Errorf("device failed by invalid drills: %v", fault.calls)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	prober := &scriptedProber{answers: map[string][]bool{"10.0.0.2:80": {true}}}
+	r, _ := newTestRunner(t, "reliable", prober, drillConnection("historian", "10.0.0.2:80", true))
+	r.maxDuration = time.Second
+
+	post := func() *httptest.ResponseRecorder {
+		body, _ := json.Marshal(Request{Device: "eth1", Selector: "site=plant-1"})
+		w := httptest.NewRecorder()
+		Handler(r).ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/drills", bytes.NewReader(body)))
+		return w
+	}
+
+	// only one drill runs at a time
+	r.running = true
+	if w := post(); w.Code != http.StatusConflict {
+		t.Errorf("status = %d while another drill runs, want 409", w.Code)
+	}
+	r.running = false
+
+	w := post()
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body.String())
+	}
+	var report Report
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil || !report.Passed || len(report.Connections) != 1 {
+		t.Errorf("unexpected report %+v (%v)", report, err)
+	}
+
+	w = httptest.NewRecorder()
+	ReportsHandler(r).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/drills", nil))
+	var reports []Report
+	if err := json.Unmarshal(w.Body.Bytes(), &reports); err != nil || len(reports) != 1 {
+		t.Errorf("unexpected reports %s (%v)", w.Body.String(), err)
+	}
+}
+
+func TestLinkFault(t *testing.T) {
+	nl := netutil.NewFake()
+	nl.Links["eth1"] = netutil.Link{Name: "eth1", Type: "device", Up: true}
+	nl.Links["eth2"] = netutil.Link{Name: "eth2", Type: "device"}
+	f := NewLinkFault(nl)
+
+	if err := f.Fail("eth1"); err != nil || nl.Links["eth1"].Up {
+		t.Fatalf("Fail() error = %v, link %+v", err, nl.Links["eth1"])
+	}
+	if err := f.Restore("eth1"); err != nil || !nl.Links["eth1"].Up {
+		t.Errorf("Restore() error = %v, link %+v", err, nl.Links["eth1"])
+	}
+	// a device that is already down is left alone
+	if err := f.Fail("eth2"); err == nil {
+		t.Errorf("expected an error failing a device that is down")
+	}
+	if err := f.Fail("eth9"); err == nil {
+		t.Errorf("expected an error failing a missing device")
+	}
+}
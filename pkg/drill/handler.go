@@ -0,0 +1,40 @@
+package drill
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/akos011221/nsm/pkg/api"
+)
+
+// Handler runs failover drills over the management API
+func Handler(runner *Runner) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req Request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			api.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid drill request: %w", err))
+			return
+		}
+
+		report, err := runner.Run(r.Context(), req)
+		if errors.Is(err, ErrBusy) {
+			api.WriteError(w, http.StatusConflict, err)
+			return
+		}
+		if err != nil {
+			api.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		api.WriteJSON(w, http.StatusOK, report)
+	})
+}
+
+// ReportsHandler serves the latest drill reports over the management API
+func ReportsHandler(runner *Runner) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		api.WriteJSON(w, http.StatusOK, runner.Reports())
+	})
+}
@@ -0,0 +1,104 @@
+package endpoints
+
+import (
+	"net"
+	"strconv"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/external"
+	"github.com/akos011221/nsm/pkg/intent"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// ManagedBy is the controller the published EndpointSlices are managed by
+const ManagedBy = "nsm.akosrbn.io"
+
+// Publication is the Kubernetes Service and EndpointSlice a NetworkService
+// is published as
+type Publication struct {
+	// Selectorless Service, headless if the endpoint has no port
+	Service *corev1.Service
+	// EndpointSlice with the endpoint of the NetworkService
+	Slice *discoveryv1.EndpointSlice
+}
+
+// ServiceName returns the name of the Service a NetworkService is
+// published as, prefixed so it never takes the name of a regular Service
+func ServiceName(svc *nsmv1.NetworkService) string {
+	return intent.ObjectName("nsm", svc.Name)
+}
+
+// Build returns the Service and EndpointSlice publishing the endpoint of
+// a NetworkService, so kube-proxy and cluster DNS consumers reach it
+// without NSM specific discovery. Endpoints that aren't an IP can't be
+// published and return false.
+func Build(svc *nsmv1.NetworkService) (*Publication, bool) {
+	endpoint := external.Endpoint(svc)
+	host, port := endpoint, 0
+	if h, p, err := net.SplitHostPort(endpoint); err == nil {
+		n, err := strconv.Atoi(p)
+		if err != nil || n <= 0 || n > 65535 {
+			return nil, false
+		}
+		host, port = h, n
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, false
+	}
+	addressType := discoveryv1.AddressTypeIPv4
+	if ip.To4() == nil {
+		addressType = discoveryv1.AddressTypeIPv6
+	}
+
+	name := ServiceName(svc)
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: svc.Namespace},
+		Spec:       corev1.ServiceSpec{Type: corev1.ServiceTypeClusterIP},
+	}
+	slice := &discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: svc.Namespace,
+			Labels: map[string]string{
+				discoveryv1.LabelServiceName: name,
+				discoveryv1.LabelManagedBy:   ManagedBy,
+			},
+		},
+		AddressType: addressType,
+		Endpoints: []discoveryv1.Endpoint{{
+			Addresses:  []string{ip.String()},
+			Conditions: discoveryv1.EndpointConditions{Ready: ptr(ready(svc))},
+		}},
+	}
+
+	if port == 0 {
+		// a ClusterIP Service needs a port, DNS still resolves a headless one
+		service.Spec.ClusterIP = corev1.ClusterIPNone
+	} else {
+		service.Spec.Ports = []corev1.ServicePort{{
+			Protocol:   corev1.ProtocolTCP,
+			Port:       int32(port),
+			TargetPort: intstr.FromInt32(int32(port)),
+		}}
+		slice.Ports = []discoveryv1.EndpointPort{{
+			Name:     ptr(""),
+			Protocol: ptr(corev1.ProtocolTCP),
+			Port:     ptr(int32(port)),
+		}}
+	}
+	return &Publication{Service: service, Slice: slice}, true
+}
+
+// ready tells whether the endpoint takes traffic: unless the service is
+// pending or failed, as long as no phase is reported
+func ready(svc *nsmv1.NetworkService) bool {
+	return svc.Status.Phase != nsmv1.ServicePhasePending && svc.Status.Phase != nsmv1.ServicePhaseError
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
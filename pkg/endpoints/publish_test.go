@@ -0,0 +1,70 @@
+package endpoints
+
+import (
+	"testing"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func service(endpoint, phase string) *nsmv1.NetworkService {
+	return &nsmv1.NetworkService{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "edge", Name: "vision"},
+		Spec:       nsmv1.NetworkServiceSpec{Endpoint: endpoint},
+		Status:     nsmv1.NetworkServiceStatus{Phase: phase},
+	}
+}
+
+func TestBuildWithPort(t *testing.T) {
+	pub, ok := Build(service("10.60.0.7:8443", nsmv1.ServicePhaseReady))
+	if !ok {
+		t.Fatal("Build() of an IP endpoint not published")
+	}
+	if pub.Service.Name != ServiceName(service("", "")) || pub.Service.Namespace != "edge" {
+		t.Errorf("service %s/%s", pub.Service.Namespace, pub.Service.Name)
+	}
+	if pub.Service.Spec.ClusterIP != "" || pub.Service.Spec.Selector != nil || len(pub.Service.Spec.Ports) != 1 || pub.Service.Spec.Ports[0].Port != 8443 {
+		t.Errorf("unexpected service spec %+v", pub.Service.Spec)
+	}
+
+	slice := pub.Slice
+	if slice.Labels[discoveryv1.LabelServiceName] != pub.Service.Name || slice.Labels[discoveryv1.LabelManagedBy] != ManagedBy {
+		t.Errorf("slice labels %v", slice.Labels)
+	}
+	if slice.AddressType != discoveryv1.AddressTypeIPv4 || len(slice.Endpoints) != 1 || slice.Endpoints[0].Addresses[0] != "10.60.0.7" {
+		t.Errorf("unexpected slice %+v", slice)
+	}
+	if !*slice.Endpoints[0].Conditions.Ready {
+		t.Error("endpoint of a ready service not ready")
+	}
+	// kube-proxy matches the slice ports to the service ports by name
+	if len(slice.Ports) != 1 || *slice.Ports[0].Port != 8443 || *slice.Ports[0].Name != pub.Service.Spec.Ports[0].Name {
+		t.Errorf("slice ports %+v", slice.Ports)
+	}
+}
+
+func TestBuildHeadlessIPv6(t *testing.T) {
+	pub, ok := Build(service("fd00::7", nsmv1.ServicePhasePending))
+	if !ok {
+		t.Fatal("Build() of an IPv6 endpoint not published")
+	}
+	if pub.Service.Spec.ClusterIP != corev1.ClusterIPNone || len(pub.Service.Spec.Ports) != 0 {
+		t.Errorf("service without port not headless: %+v", pub.Service.Spec)
+	}
+	if pub.Slice.AddressType != discoveryv1.AddressTypeIPv6 || len(pub.Slice.Ports) != 0 {
+		t.Errorf("unexpected slice %+v", pub.Slice)
+	}
+	if *pub.Slice.Endpoints[0].Conditions.Ready {
+		t.Error("endpoint of a pending service ready")
+	}
+}
+
+func TestBuildUnpublishable(t *testing.T) {
+	for _, endpoint := range []string{"", "vision.example.com:443", "10.60.0.7:http", "10.60.0.7:70000"} {
+		if _, ok := Build(service(endpoint, "")); ok {
+			t.Errorf("Build(%q) published", endpoint)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package external
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/watchdog"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var endpointFailovers = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "nsm_external_endpoint_failovers_total",
+		Help: "Switches of the active external endpoint of a service",
+	},
+	[]string{"namespace", "service"},
+)
+
+func init() {
+	crmetrics.Registry.MustRegister(endpointFailovers)
+}
+
+// tick is the resolution of the check intervals
+const tick = time.Second
+
+// probeState is the run of results of the checks of an endpoint
+type probeState struct {
+	// Address the results are for
+	address string
+	// Time of the last check
+	last time.Time
+	// Outcome of the last check, empty if it passed
+	message string
+	// Consecutive checks with the same outcome
+	streak int
+}
+
+// Checker checks the external endpoints of the services, records their
+// health in the status of the services and fails the services over to the
+// preferred healthy endpoint
+type Checker struct {
+	// Context for cancellation
+	ctx context.Context
+	// Kubernetes client (controller-runtime)
+	client client.Client
+	// Logger
+	logger *logrus.Logger
+	// Checks the endpoints, replaceable for tests
+	prober Prober
+	// Results by namespace/service/endpoint
+	state map[string]*probeState
+	// Guards state while the checks run
+	mu sync.Mutex
+	// Progress signal for the watchdog
+	heartbeat *watchdog.Heartbeat
+}
+
+// NewChecker creates a new external endpoint checker
+func NewChecker(ctx context.Context, c client.Client, logger *logrus.Logger) *Checker {
+	return &Checker{
+		ctx:    ctx,
+		client: c,
+		logger: logger,
+		prober: NetProber{},
+		state:  make(map[string]*probeState),
+	}
+}
+
+// SetHeartbeat makes the checker report its progress to the watchdog
+func (c *Checker) SetHeartbeat(hb *watchdog.Heartbeat) {
+	c.heartbeat = hb
+	hb.Expect(tick)
+}
+
+// Start checks the endpoints at the interval of their services
+func (c *Checker) Start() error {
+	c.logger.Info("Starting external endpoint health checks")
+
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			c.heartbeat.Beat()
+			if err := c.Check(now); err != nil {
+				c.logger.WithError(err).Warn("External endpoint health checks failed")
+			}
+
+		case <-c.ctx.Done():
+			c.logger.Info("Stopping external endpoint health checks")
+			return nil
+		}
+	}
+}
+
+// Check checks the endpoints whose interval elapsed and updates the
+// services whose endpoint health or active endpoint changed
+func (c *Checker) Check(now time.Time) error {
+	var services nsmv1.NetworkServiceList
+	if err := c.client.List(c.ctx, &services); err != nil {
+		return fmt.Errorf("failed to list network services: %w", err)
+	}
+
+	seen := make(map[string]bool)
+	var wg sync.WaitGroup
+	for i := range services.Items {
+		svc := &services.Items[i]
+		hc := withDefaults(svc.Spec.HealthCheck)
+		for _, ep := range svc.Spec.ExternalEndpoints {
+			key := svc.Namespace + "/" + svc.Name + "/" + ep.Name
+			seen[key] = true
+			c.mu.Lock()
+			st, ok := c.state[key]
+			if !ok || st.address != ep.Address {
+				st = &probeState{address: ep.Address}
+				c.state[key] = st
+			}
+			due := now.Sub(st.last) >= time.Duration(hc.IntervalSeconds)*time.Second
+			c.mu.Unlock()
+			if !due {
+				continue
+			}
+
+			wg.Add(1)
+			go func(address string) {
+				defer wg.Done()
+				message := ""
+				if err := c.probe(address, hc); err != nil {
+					message = err.Error()
+				}
+				c.mu.Lock()
+				defer c.mu.Unlock()
+				if (message == "") == (st.message == "") && !st.last.IsZero() {
+					st.streak++
+				} else {
+					st.streak = 1
+				}
+				st.last, st.message = now, message
+			}(ep.Address)
+		}
+	}
+	wg.Wait()
+
+	c.mu.Lock()
+	for key := range c.state {
+		if !seen[key] {
+			delete(c.state, key)
+		}
+	}
+	c.mu.Unlock()
+
+	for i := range services.Items {
+		svc := &services.Items[i]
+		if len(svc.Spec.ExternalEndpoints) == 0 && len(svc.Status.Endpoints) == 0 {
+			continue
+		}
+		if err := c.update(svc, now); err != nil {
+			c.logger.WithError(err).Warnf("Failed to record the endpoint health of NetworkService %s/%s", svc.Namespace, svc.Name)
+		}
+	}
+	return nil
+}
+
+// probe checks an endpoint address within the timeout of the check
+func (c *Checker) probe(address string, hc nsmv1.HealthCheck) error {
+	hostPort, err := target(address, hc)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(c.ctx, time.Duration(hc.TimeoutSeconds)*time.Second)
+	defer cancel()
+	return c.prober.Probe(ctx, hostPort, hc)
+}
+
+// update applies the check results to the endpoint health of a service
+// and selects its active endpoint, writing the status if they changed
+func (c *Checker) update(svc *nsmv1.NetworkService, now time.Time) error {
+	hc := withDefaults(svc.Spec.HealthCheck)
+	previous := make(map[string]nsmv1.EndpointHealth, len(svc.Status.Endpoints))
+	for _, h := range svc.Status.Endpoints {
+		previous[h.Name] = h
+	}
+
+	c.mu.Lock()
+	endpoints := make([]nsmv1.EndpointHealth, 0, len(svc.Spec.ExternalEndpoints))
+	healthy := make(map[string]bool, len(svc.Spec.ExternalEndpoints))
+	for _, ep := range svc.Spec.ExternalEndpoints {
+		h, ok := previous[ep.Name]
+		if !ok || h.Address != ep.Address {
+			h = nsmv1.EndpointHealth{Name: ep.Name, Address: ep.Address}
+		}
+		if st := c.state[svc.Namespace+"/"+svc.Name+"/"+ep.Name]; st != nil && !st.last.IsZero() {
+			h.Message = st.message
+			passed := st.message == ""
+			transition := metav1.NewTime(now)
+			switch {
+			case h.LastTransitionTime == nil:
+				h.Healthy = passed && st.streak >= hc.SuccessThreshold
+				h.LastTransitionTime = &transition
+			case passed && !h.Healthy && st.streak >= hc.SuccessThreshold,
+				!passed && h.Healthy && st.streak >= hc.FailureThreshold:
+				h.Healthy = passed
+				h.LastTransitionTime = &transition
+			}
+		}
+		endpoints = append(endpoints, h)
+		healthy[ep.Name] = h.Healthy
+	}
+	c.mu.Unlock()
+	if len(endpoints) == 0 {
+		endpoints = nil
+	}
+	active := selectActive(svc.Spec.ExternalEndpoints, healthy)
+
+	if active == svc.Status.ActiveEndpoint && equality.Semantic.DeepEqual(endpoints, svc.Status.Endpoints) {
+		return nil
+	}
+	switch {
+	case active == svc.Status.ActiveEndpoint:
+	case active == "":
+		c.logger.Warnf("No healthy external endpoint of NetworkService %s/%s", svc.Namespace, svc.Name)
+	case svc.Status.ActiveEndpoint == "":
+		c.logger.Infof("NetworkService %s/%s is served by external endpoint %s", svc.Namespace, svc.Name, active)
+	default:
+		endpointFailovers.WithLabelValues(svc.Namespace, svc.Name).Inc()
+		c.logger.Warnf("NetworkService %s/%s failed over from external endpoint %s to %s", svc.Namespace, svc.Name, svc.Status.ActiveEndpoint, active)
+	}
+
+	svc.Status.ActiveEndpoint = active
+	svc.Status.Endpoints = endpoints
+	if err := c.client.Status().Update(c.ctx, svc); err != nil {
+		return fmt.Errorf("failed to update service status: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package external
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// fakeProber fails the checks of the addresses marked down and counts
+// the checks
+type fakeProber struct {
+	mu     sync.Mutex
+	down   map[string]bool
+	probes map[string]int
+}
+
+func (f *fakeProber) Probe(ctx context.Context, hostPort string, hc nsmv1.HealthCheck) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.probes[hostPort]++
+	if f.down[hostPort] {
+		return errors.New("connection refused")
+	}
+	return nil
+}
+
+func (f *fakeProber) set(hostPort string, down bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.down[hostPort] = down
+}
+
+func TestCheckerFailover(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := nsmv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	svc := &nsmv1.NetworkService{
+		ObjectMeta: metav1.ObjectMeta{Name: "historian", Namespace: "edge"},
+		Spec: nsmv1.NetworkServiceSpec{
+			ServiceType: "l3",
+			ExternalEndpoints: []nsmv1.ExternalEndpoint{
+				{Name: "onprem", Address: "10.20.0.5"},
+				{Name: "cloud", Address: "historian.example.com", Priority: 1},
+			},
+			HealthCheck: &nsmv1.HealthCheck{Port: 443, IntervalSeconds: 5, FailureThreshold: 2, SuccessThreshold: 2},
+		},
+	}
+	local := &nsmv1.NetworkService{
+		ObjectMeta: metav1.ObjectMeta{Name: "vision", Namespace: "edge"},
+		Spec:       nsmv1.NetworkServiceSpec{ServiceType: "l3", Endpoint: "10.0.0.5:8080"},
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).
+		WithObjects(svc, local).
+		WithStatusSubresource(&nsmv1.NetworkService{}).
+		Build()
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	ctx := context.Background()
+	checker := NewChecker(ctx, c, logger)
+	prober := &fakeProber{down: make(map[string]bool), probes: make(map[string]int)}
+	checker.prober = prober
+
+	now := time.Now()
+	check := func(after time.Duration) *nsmv1.NetworkService {
+		t.Helper()
+		now = now.Add(after)
+		if err := checker.Check(now); err != nil {
+			t.Fatalf("Check() error = %v", err)
+		}
+		var got nsmv1.NetworkService
+		if err := c.Get(ctx, client.ObjectKeyFromObject(svc), &got); err != nil {
+			t.Fatal(err)
+		}
+		return &got
+	}
+
+	// a single pass doesn't reach the success threshold
+	got := check(0)
+	if got.Status.ActiveEndpoint != "" || len(got.Status.Endpoints) != 2 {
+		t.Fatalf("status after first check = %+v, want no active endpoint", got.Status)
+	}
+	if got.Status.Endpoints[0].LastTransitionTime == nil {
+		t.Error("first check didn't set LastTransitionTime")
+	}
+
+	// checks are skipped until the interval elapsed
+	check(time.Second)
+	if prober.probes["10.20.0.5:443"] != 1 {
+		t.Errorf("probes = %d before the interval elapsed, want 1", prober.probes["10.20.0.5:443"])
+	}
+
+	got = check(5 * time.Second)
+	if got.Status.ActiveEndpoint != "onprem" {
+		t.Fatalf("ActiveEndpoint = %q, want onprem", got.Status.ActiveEndpoint)
+	}
+	if Endpoint(got) != "10.20.0.5" {
+		t.Errorf("Endpoint() = %q, want the onprem address", Endpoint(got))
+	}
+
+	// one failure stays below the failure threshold
+	prober.set("10.20.0.5:443", true)
+	got = check(5 * time.Second)
+	if got.Status.ActiveEndpoint != "onprem" || !got.Status.Endpoints[0].Healthy {
+		t.Fatalf("status after one failure = %+v, want onprem still healthy", got.Status)
+	}
+	if got.Status.Endpoints[0].Message != "connection refused" {
+		t.Errorf("Message = %q, want the failure", got.Status.Endpoints[0].Message)
+	}
+
+	got = check(5 * time.Second)
+	if got.Status.ActiveEndpoint != "cloud" || got.Status.Endpoints[0].Healthy {
+		t.Fatalf("status after two failures = %+v, want failover to cloud", got.Status)
+	}
+
+	// the preferred endpoint takes over again once it recovered
+	prober.set("10.20.0.5:443", false)
+	check(5 * time.Second)
+	got = check(5 * time.Second)
+	if got.Status.ActiveEndpoint != "onprem" {
+		t.Errorf("ActiveEndpoint after recovery = %q, want onprem", got.Status.ActiveEndpoint)
+	}
+
+	// an unchanged status isn't written again
+	version := got.ResourceVersion
+	if got = check(5 * time.Second); got.ResourceVersion != version {
+		t.Error("unchanged status was updated")
+	}
+
+	// services without external endpoints aren't checked
+	var vision nsmv1.NetworkService
+	if err := c.Get(ctx, client.ObjectKeyFromObject(local), &vision); err != nil {
+		t.Fatal(err)
+	}
+	if vision.Status.Endpoints != nil || prober.probes["10.0.0.5:8080"] != 0 {
+		t.Errorf("local service checked: %+v", vision.Status)
+	}
+}
@@ -0,0 +1,161 @@
+package external
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+)
+
+// Defaults of the health checks
+const (
+	defaultInterval         = 10 * time.Second
+	defaultTimeout          = 2 * time.Second
+	defaultFailureThreshold = 3
+	defaultSuccessThreshold = 1
+)
+
+// Endpoint returns the address a service is reached at: that of its
+// active external endpoint, or its endpoint without one
+func Endpoint(svc *nsmv1.NetworkService) string {
+	if svc.Status.ActiveEndpoint != "" {
+		for _, ep := range svc.Spec.ExternalEndpoints {
+			if ep.Name == svc.Status.ActiveEndpoint {
+				return ep.Address
+			}
+		}
+	}
+	return svc.Spec.Endpoint
+}
+
+// Validate checks the external endpoints and the health check of a service
+func Validate(spec *nsmv1.NetworkServiceSpec) error {
+	hc := withDefaults(spec.HealthCheck)
+	if hc.Type != nsmv1.HealthCheckTCP && hc.Type != nsmv1.HealthCheckHTTP {
+		return fmt.Errorf("invalid health check type %q, must be tcp or http", hc.Type)
+	}
+	if hc.Port < 0 || hc.Port > 65535 {
+		return fmt.Errorf("invalid health check port %d", hc.Port)
+	}
+	if hc.IntervalSeconds < 0 || hc.TimeoutSeconds < 0 || hc.FailureThreshold < 0 || hc.SuccessThreshold < 0 {
+		return fmt.Errorf("health check intervals and thresholds must not be negative")
+	}
+
+	names := make(map[string]bool, len(spec.ExternalEndpoints))
+	for _, ep := range spec.ExternalEndpoints {
+		if ep.Name == "" {
+			return fmt.Errorf("external endpoint %q has no name", ep.Address)
+		}
+		if names[ep.Name] {
+			return fmt.Errorf("duplicate external endpoint %s", ep.Name)
+		}
+		names[ep.Name] = true
+		if _, err := target(ep.Address, hc); err != nil {
+			return fmt.Errorf("external endpoint %s: %w", ep.Name, err)
+		}
+	}
+	return nil
+}
+
+// withDefaults returns the health check with the defaults filled in
+func withDefaults(hc *nsmv1.HealthCheck) nsmv1.HealthCheck {
+	var out nsmv1.HealthCheck
+	if hc != nil {
+		out = *hc
+	}
+	if out.Type == "" {
+		out.Type = nsmv1.HealthCheckTCP
+	}
+	if out.Path == "" {
+		out.Path = "/"
+	}
+	if out.IntervalSeconds == 0 {
+		out.IntervalSeconds = int(defaultInterval / time.Second)
+	}
+	if out.TimeoutSeconds == 0 {
+		out.TimeoutSeconds = int(defaultTimeout / time.Second)
+	}
+	if out.FailureThreshold == 0 {
+		out.FailureThreshold = defaultFailureThreshold
+	}
+	if out.SuccessThreshold == 0 {
+		out.SuccessThreshold = defaultSuccessThreshold
+	}
+	return out
+}
+
+// target returns the host:port checked for an endpoint address
+func target(address string, hc nsmv1.HealthCheck) (string, error) {
+	if _, port, err := net.SplitHostPort(address); err == nil {
+		if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+			return "", fmt.Errorf("invalid address %q: port must be between 1 and 65535", address)
+		}
+		return address, nil
+	}
+	if hc.Port == 0 {
+		return "", fmt.Errorf("address %q has no port and the health check sets none", address)
+	}
+	return net.JoinHostPort(address, strconv.Itoa(hc.Port)), nil
+}
+
+// selectActive returns the healthy endpoint with the lowest priority
+// value, the first listed among equals, empty if none is healthy
+func selectActive(endpoints []nsmv1.ExternalEndpoint, health map[string]bool) string {
+	candidates := make([]nsmv1.ExternalEndpoint, 0, len(endpoints))
+	for _, ep := range endpoints {
+		if health[ep.Name] {
+			candidates = append(candidates, ep)
+		}
+	}
+	if len(candidates) == 0 {
+		return ""
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].Priority < candidates[j].Priority })
+	return candidates[0].Name
+}
+
+// Prober checks the health of an endpoint
+type Prober interface {
+	// Probe returns why the endpoint at the host:port failed the check,
+	// nil if it passed
+	Probe(ctx context.Context, hostPort string, hc nsmv1.HealthCheck) error
+}
+
+// httpClient sends the http checks, a redirect counts as an answer
+var httpClient = &http.Client{
+	CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+}
+
+// NetProber checks endpoints over the network
+type NetProber struct{}
+
+// Probe implements Prober
+func (NetProber) Probe(ctx context.Context, hostPort string, hc nsmv1.HealthCheck) error {
+	if hc.Type == nsmv1.HealthCheckHTTP {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+hostPort+hc.Path, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 400 {
+			return fmt.Errorf("HTTP status %d", resp.StatusCode)
+		}
+		return nil
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", hostPort)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
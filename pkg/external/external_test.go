@@ -0,0 +1,115 @@
+package external
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+)
+
+func TestEndpoint(t *testing.T) {
+	svc := &nsmv1.NetworkService{Spec: nsmv1.NetworkServiceSpec{
+		Endpoint: "10.0.0.5:8080",
+		ExternalEndpoints: []nsmv1.ExternalEndpoint{
+			{Name: "onprem", Address: "10.20.0.5:443"},
+			{Name: "cloud", Address: "historian.example.com:443", Priority: 1},
+		},
+	}}
+	if got := Endpoint(svc); got != "10.0.0.5:8080" {
+		t.Errorf("Endpoint() without active endpoint = %q, want the spec endpoint", got)
+	}
+	svc.Status.ActiveEndpoint = "cloud"
+	if got := Endpoint(svc); got != "historian.example.com:443" {
+		t.Errorf("Endpoint() = %q, want the cloud endpoint", got)
+	}
+	// an active endpoint removed from the spec is no longer reached
+	svc.Status.ActiveEndpoint = "backup"
+	if got := Endpoint(svc); got != "10.0.0.5:8080" {
+		t.Errorf("Endpoint() with removed active endpoint = %q, want the spec endpoint", got)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := nsmv1.NetworkServiceSpec{
+		ExternalEndpoints: []nsmv1.ExternalEndpoint{
+			{Name: "onprem", Address: "10.20.0.5"},
+			{Name: "cloud", Address: "historian.example.com:8443"},
+		},
+		HealthCheck: &nsmv1.HealthCheck{Type: nsmv1.HealthCheckHTTP, Port: 443, Path: "/healthz"},
+	}
+	if err := Validate(&valid); err != nil {
+		t.Errorf("Validate() error = %v", err)
+	}
+
+	for name, spec := range map[string]nsmv1.NetworkServiceSpec{
+		"unknown type":     {HealthCheck: &nsmv1.HealthCheck{Type: "icmp"}},
+		"invalid port":     {HealthCheck: &nsmv1.HealthCheck{Port: 70000}},
+		"negative timeout": {HealthCheck: &nsmv1.HealthCheck{TimeoutSeconds: -1}},
+		"missing name":     {ExternalEndpoints: []nsmv1.ExternalEndpoint{{Address: "10.20.0.5:443"}}},
+		"duplicate name": {ExternalEndpoints: []nsmv1.ExternalEndpoint{
+			{Name: "onprem", Address: "10.20.0.5:443"}, {Name: "onprem", Address: "10.20.0.6:443"},
+		}},
+		"missing port": {ExternalEndpoints: []nsmv1.ExternalEndpoint{{Name: "onprem", Address: "10.20.0.5"}}},
+	} {
+		if err := Validate(&spec); err == nil {
+			t.Errorf("%s: Validate() accepted %+v", name, spec)
+		}
+	}
+}
+
+func TestSelectActive(t *testing.T) {
+	endpoints := []nsmv1.ExternalEndpoint{
+		{Name: "cloud", Priority: 1},
+		{Name: "onprem"},
+		{Name: "backup"},
+	}
+	for _, tt := range []struct {
+		health map[string]bool
+		want   string
+	}{
+		{map[string]bool{"cloud": true, "onprem": true, "backup": true}, "onprem"},
+		{map[string]bool{"cloud": true, "backup": true}, "backup"},
+		{map[string]bool{"cloud": true}, "cloud"},
+		{map[string]bool{}, ""},
+	} {
+		if got := selectActive(endpoints, tt.health); got != tt.want {
+			t.Errorf("selectActive(%v) = %q, want %q", tt.health, got, tt.want)
+		}
+	}
+}
+
+func TestNetProber(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" {
+			http.Error(w, "not found", http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	hostPort := strings.TrimPrefix(srv.URL, "http://")
+	ctx := context.Background()
+
+	if err := (NetProber{}).Probe(ctx, hostPort, nsmv1.HealthCheck{Type: nsmv1.HealthCheckTCP}); err != nil {
+		t.Errorf("tcp Probe() error = %v", err)
+	}
+	if err := (NetProber{}).Probe(ctx, hostPort, nsmv1.HealthCheck{Type: nsmv1.HealthCheckHTTP, Path: "/healthz"}); err != nil {
+		t.Errorf("http Probe() error = %v", err)
+	}
+	if err := (NetProber{}).Probe(ctx, hostPort, nsmv1.HealthCheck{Type: nsmv1.HealthCheckHTTP, Path: "/missing"}); err == nil {
+		t.Error("http Probe() passed on 404")
+	}
+
+	// nothing listens on a closed listener's port
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := l.Addr().String()
+	l.Close()
+	if err := (NetProber{}).Probe(ctx, closed, nsmv1.HealthCheck{Type: nsmv1.HealthCheckTCP}); err == nil {
+		t.Error("tcp Probe() passed on a closed port")
+	}
+}
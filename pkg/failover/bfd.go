@@ -0,0 +1,92 @@
+package failover
+
+import (
+	"fmt"
+	"net"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/bfd"
+)
+
+// SessionManager runs BFD sessions towards peers, implemented by
+// bfd.Manager
+type SessionManager interface {
+	// AddSession starts a session towards the peer with timers derived
+	// from the priority
+	AddSession(peer string, priority int32, onChange func(peer string, state bfd.State)) (*bfd.Session, error)
+	// RemoveSession stops the session towards the peer
+	RemoveSession(peer string)
+}
+
+// SetBFD makes the engine run a BFD session towards the gateway of every
+// path, with the timers of the highest priority connection using it. A
+// session going down fails its paths at once, instead of at their next
+// check.
+func (e *Engine) SetBFD(sessions SessionManager) {
+	e.bfd = sessions
+}
+
+// syncSessions starts the sessions towards the gateways of the paths and
+// stops the ones no path uses anymore. Sessions are restarted when the
+// priority of their connections asks for other timers. The mutex must be
+// held.
+func (e *Engine) syncSessions(gateways map[string]int32) {
+	if e.bfd == nil {
+		return
+	}
+	for gateway, priority := range e.sessions {
+		if want, ok := gateways[gateway]; ok && bfd.TimersForPriority(want) == bfd.TimersForPriority(priority) {
+			continue
+		}
+		e.bfd.RemoveSession(gateway)
+		delete(e.sessions, gateway)
+		e.bfdMu.Lock()
+		delete(e.peerDown, gateway)
+		e.bfdMu.Unlock()
+	}
+	for gateway, priority := range gateways {
+		if _, ok := e.sessions[gateway]; ok {
+			continue
+		}
+		// recorded even if it failed, so it is reported once
+		e.sessions[gateway] = priority
+		if _, err := e.bfd.AddSession(gateway, priority, e.sessionChanged); err != nil {
+			e.logger.WithError(err).Warnf("Failed to start the BFD session to gateway %s", gateway)
+		}
+	}
+}
+
+// sessionChanged records the state of the session towards a gateway and
+// checks the paths right away when it went down or came back up
+func (e *Engine) sessionChanged(peer string, state bfd.State) {
+	if state != bfd.StateDown && state != bfd.StateUp {
+		return
+	}
+	gateway, _, err := net.SplitHostPort(peer)
+	if err != nil {
+		gateway = peer
+	}
+
+	e.bfdMu.Lock()
+	e.peerDown[gateway] = state == bfd.StateDown
+	e.bfdMu.Unlock()
+
+	e.logger.Infof("BFD session to gateway %s is %s", gateway, state)
+	select {
+	case e.wake <- struct{}{}:
+	default:
+	}
+}
+
+// bfdHealth overrides the health of a path whose BFD session is down
+func (e *Engine) bfdHealth(path nsmv1.FailoverPath, h Health) Health {
+	if path.Gateway == "" || !h.Healthy {
+		return h
+	}
+	e.bfdMu.Lock()
+	defer e.bfdMu.Unlock()
+	if e.peerDown[path.Gateway] {
+		return Health{Reason: fmt.Sprintf("BFD session to gateway %s is down", path.Gateway)}
+	}
+	return h
+}
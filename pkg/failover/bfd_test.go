@@ -0,0 +1,79 @@
+package failover
+
+import (
+	"context"
+	"testing"
+
+	"github.com/akos011221/nsm/pkg/bfd"
+)
+
+// fakeSessions records the BFD sessions by peer
+type fakeSessions struct {
+	priorities map[string]int32
+	onChange   func(peer string, state bfd.State)
+}
+
+func (f *fakeSessions) AddSession(peer string, priority int32, onChange func(peer string, state bfd.State)) (*bfd.Session, error) {
+	f.priorities[peer] = priority
+	f.onChange = onChange
+	return nil, nil
+}
+
+func (f *fakeSessions) RemoveSession(peer string) {
+	delete(f.priorities, peer)
+}
+
+func TestEngineFailsOverOnBFDSessionDown(t *testing.T) {
+	conn := failoverConnection()
+	conn.Spec.Priority = 100
+	c := newTestClient(t, conn)
+	e, _ := testEngine(c, newFakeChecker(), "fast")
+	sessions := &fakeSessions{priorities: make(map[string]int32)}
+	e.SetBFD(sessions)
+
+	// one session towards the gateway of the fiber path, the lte path has none
+	tick(t, e, 1)
+	if len(sessions.priorities) != 1 || sessions.priorities["192.168.1.1"] != 100 {
+		t.Fatalf("sessions = %v, want one to 192.168.1.1 at priority 100", sessions.priorities)
+	}
+
+	// the session going down wakes the engine, which fails over at once
+	sessions.onChange("192.168.1.1:3784", bfd.StateUp)
+	sessions.onChange("192.168.1.1:3784", bfd.StateDown)
+	select {
+	case <-e.wake:
+	default:
+		t.Fatalf("engine not woken by the session going down")
+	}
+	tick(t, e, 1)
+	if got := getConnection(t, c); got.Status.ActivePath != "lte" {
+		t.Fatalf("active path = %s, want lte with the fiber session down", got.Status.ActivePath)
+	}
+
+	// and fails back once the session is up again for the recovery period
+	sessions.onChange("192.168.1.1:3784", bfd.StateUp)
+	tick(t, e, 3)
+	if got := getConnection(t, c); got.Status.ActivePath != "fiber" {
+		t.Errorf("active path = %s, want fiber with the session up", got.Status.ActivePath)
+	}
+
+	// a lower priority asks for slower timers, the session is restarted
+	conn = getConnection(t, c)
+	conn.Spec.Priority = 0
+	if err := c.Update(context.Background(), conn); err != nil {
+		t.Fatal(err)
+	}
+	tick(t, e, 1)
+	if priority, ok := sessions.priorities["192.168.1.1"]; !ok || priority != 0 {
+		t.Errorf("sessions = %v, want the session restarted at priority 0", sessions.priorities)
+	}
+
+	// sessions no path uses anymore are stopped
+	if err := c.Delete(context.Background(), conn); err != nil {
+		t.Fatal(err)
+	}
+	tick(t, e, 1)
+	if len(sessions.priorities) != 0 {
+		t.Errorf("sessions = %v, want none without connections", sessions.priorities)
+	}
+}
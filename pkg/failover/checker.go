@@ -0,0 +1,125 @@
+package failover
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/netutil"
+	"github.com/akos011221/nsm/pkg/probe"
+)
+
+// Health is the outcome of a check of a path
+type Health struct {
+	// Whether the path can carry the traffic
+	Healthy bool
+	// Why the path is unhealthy, empty if it is healthy
+	Reason string
+	// Average latency of the answered probes in milliseconds, 0 if not probed
+	LatencyMs int
+}
+
+// Checker checks the health of the paths of a connection
+type Checker interface {
+	// Check checks the link, loss and latency of a path against the
+	// latency requirement of its connection (0 for none)
+	Check(ctx context.Context, path nsmv1.FailoverPath, maxLatencyMs int) Health
+	// Confirm sends a number of probes over a path, reporting whether all
+	// of them were answered
+	Confirm(ctx context.Context, path nsmv1.FailoverPath, probes int) bool
+}
+
+// Share of lost probes above which a path is unhealthy
+const maxLoss = 0.5
+
+// ProbeChecker checks the link state of the device of a path and probes
+// its probe target over the device
+type ProbeChecker struct {
+	// Netlink operations
+	nl netutil.Interface
+	// Number of probes of a check
+	probes int
+	// Timeout of a single probe
+	timeout time.Duration
+	// Creates the prober of a device, replaceable for tests
+	newProber func(device string, timeout time.Duration) probe.Prober
+}
+
+// NewProbeChecker creates a checker sending a number of probes per check
+func NewProbeChecker(nl netutil.Interface, probes int, timeout time.Duration) *ProbeChecker {
+	return &ProbeChecker{
+		nl:      nl,
+		probes:  max(probes, 1),
+		timeout: timeout,
+		newProber: func(device string, timeout time.Duration) probe.Prober {
+			return probe.NewTCPProber(device, timeout)
+		},
+	}
+}
+
+// Check implements Checker
+func (c *ProbeChecker) Check(ctx context.Context, path nsmv1.FailoverPath, maxLatencyMs int) Health {
+	if reason := c.linkDown(path); reason != "" {
+		return Health{Reason: reason}
+	}
+	if path.ProbeTarget == "" {
+		return Health{Healthy: true}
+	}
+
+	samples, err := c.newProber(path.Device, c.timeout).Probe(ctx, path.ProbeTarget, c.probes, 0)
+	if err != nil {
+		return Health{Reason: fmt.Sprintf("failed to probe %s over %s: %v", path.ProbeTarget, path.Device, err)}
+	}
+	var answered int
+	var rtt time.Duration
+	for _, s := range samples {
+		if s.OK {
+			answered++
+			rtt += s.RTT
+		}
+	}
+	lost := len(samples) - answered
+	if answered == 0 || float64(lost) > maxLoss*float64(len(samples)) {
+		return Health{Reason: fmt.Sprintf("lost %d of %d probes to %s", lost, len(samples), path.ProbeTarget)}
+	}
+	latencyMs := int((rtt / time.Duration(answered)).Milliseconds())
+	if maxLatencyMs > 0 && latencyMs > maxLatencyMs {
+		return Health{Reason: fmt.Sprintf("latency %dms over the %dms requirement", latencyMs, maxLatencyMs), LatencyMs: latencyMs}
+	}
+	return Health{Healthy: true, LatencyMs: latencyMs}
+}
+
+// Confirm implements Checker. Paths without a probe target are confirmed
+// by their link alone.
+func (c *ProbeChecker) Confirm(ctx context.Context, path nsmv1.FailoverPath, probes int) bool {
+	if c.linkDown(path) != "" {
+		return false
+	}
+	if path.ProbeTarget == "" {
+		return true
+	}
+	samples, err := c.newProber(path.Device, c.timeout).Probe(ctx, path.ProbeTarget, probes, 0)
+	if err != nil || len(samples) < probes {
+		return false
+	}
+	for _, s := range samples {
+		if !s.OK {
+			return false
+		}
+	}
+	return true
+}
+
+// linkDown returns why the device of a path can't carry traffic, empty if
+// its link is up
+func (c *ProbeChecker) linkDown(path nsmv1.FailoverPath) string {
+	link, err := c.nl.LinkByName(path.Device)
+	if err != nil {
+		return fmt.Sprintf("device %s: %v", path.Device, err)
+	}
+	if !link.Up {
+		return fmt.Sprintf("link of %s is down", path.Device)
+	}
+	return ""
+}
@@ -0,0 +1,86 @@
+package failover
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/netutil"
+	"github.com/akos011221/nsm/pkg/probe"
+)
+
+// scriptedProber answers with fixed samples
+type scriptedProber struct {
+	samples []probe.Sample
+}
+
+func (p *scriptedProber) Probe(ctx context.Context, target string, count int, interval time.Duration) ([]probe.Sample, error) {
+	samples := make([]probe.Sample, 0, count)
+	for i := 0; i < count; i++ {
+		samples = append(samples, p.samples[i%len(p.samples)])
+	}
+	return samples, nil
+}
+
+func testChecker(up bool, samples ...probe.Sample) *ProbeChecker {
+	nl := netutil.NewFake()
+	nl.Links["eth0"] = netutil.Link{Name: "eth0", Up: up}
+	c := NewProbeChecker(nl, 4, 100*time.Millisecond)
+	c.newProber = func(device string, timeout time.Duration) probe.Prober {
+		return &scriptedProber{samples: samples}
+	}
+	return c
+}
+
+func TestProbeCheckerCheck(t *testing.T) {
+	ok := probe.Sample{RTT: 10 * time.Millisecond, OK: true}
+	slow := probe.Sample{RTT: 40 * time.Millisecond, OK: true}
+	lost := probe.Sample{}
+	path := nsmv1.FailoverPath{Name: "fiber", Device: "eth0", ProbeTarget: "10.20.0.1:502"}
+
+	tests := []struct {
+		name    string
+		checker *ProbeChecker
+		path    nsmv1.FailoverPath
+		maxMs   int
+		healthy bool
+		reason  string
+	}{
+		{name: "healthy", checker: testChecker(true, ok), path: path, maxMs: 20, healthy: true},
+		{name: "link down", checker: testChecker(false, ok), path: path, reason: "down"},
+		{name: "missing device", checker: testChecker(true, ok), path: nsmv1.FailoverPath{Name: "lte", Device: "wwan0"}, reason: "wwan0"},
+		{name: "link only", checker: testChecker(true, lost), path: nsmv1.FailoverPath{Name: "fiber", Device: "eth0"}, healthy: true},
+		{name: "half lost", checker: testChecker(true, ok, lost), path: path, healthy: true},
+		{name: "mostly lost", checker: testChecker(true, ok, lost, lost, lost), path: path, reason: "lost 3 of 4"},
+		{name: "over latency", checker: testChecker(true, ok, slow), path: path, maxMs: 20, reason: "latency 25ms"},
+		{name: "no requirement", checker: testChecker(true, slow), path: path, healthy: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := tt.checker.Check(context.Background(), tt.path, tt.maxMs)
+			if h.Healthy != tt.healthy {
+				t.Fatalf("Check() = %+v, want healthy %v", h, tt.healthy)
+			}
+			if !strings.Contains(h.Reason, tt.reason) {
+				t.Errorf("Check() reason = %q, want it to contain %q", h.Reason, tt.reason)
+			}
+		})
+	}
+}
+
+func TestProbeCheckerConfirm(t *testing.T) {
+	ok := probe.Sample{RTT: 10 * time.Millisecond, OK: true}
+	path := nsmv1.FailoverPath{Name: "fiber", Device: "eth0", ProbeTarget: "10.20.0.1:502"}
+
+	if !testChecker(true, ok).Confirm(context.Background(), path, 3) {
+		t.Errorf("Confirm() = false with every probe answered")
+	}
+	if testChecker(true, ok, ok, probe.Sample{}).Confirm(context.Background(), path, 3) {
+		t.Errorf("Confirm() = true with a lost probe")
+	}
+	if testChecker(false, ok).Confirm(context.Background(), path, 3) {
+		t.Errorf("Confirm() = true with the link down")
+	}
+}
@@ -0,0 +1,499 @@
+package failover
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/datapath"
+	"github.com/akos011221/nsm/pkg/watchdog"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// Reasons of path switches
+const (
+	// ReasonUnhealthy is a switch away from an unhealthy active path
+	ReasonUnhealthy = "unhealthy"
+	// ReasonRecovered is a switch back to a more preferred path that recovered
+	ReasonRecovered = "recovered"
+	// ReasonCost is a switch to a cheaper path, or away from a path over its
+	// monthly budget
+	ReasonCost = "cost"
+)
+
+// failoversTotal counts the path switches of connections
+var failoversTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "nsm_connection_failovers_total",
+	Help: "Number of path switches of connections by failover strategy and reason (unhealthy, recovered, cost)",
+}, []string{"strategy", "reason"})
+
+func init() {
+	crmetrics.Registry.MustRegister(failoversTotal)
+}
+
+// Policy is how a failover strategy trades switching speed for stability
+type Policy struct {
+	// Interval between health checks of the paths
+	Interval time.Duration
+	// Consecutive failed checks after which the active path is abandoned
+	FailAfter int
+	// Consecutive healthy checks after which a more preferred path is
+	// taken back
+	RecoverAfter int
+	// Probes a backup path has to answer in a row before traffic is
+	// switched to it, 0 to switch without confirmation
+	ConfirmProbes int
+	// Whether the route of a backup path is kept installed behind the
+	// active one, so the kernel falls back to it before the switch
+	WarmStandby bool
+}
+
+// Policies of the failover strategies: fast switches on the first failed
+// check over a warm standby route, reliable needs several failed checks
+// and a backup confirmed by probes, and waits longer before failing back
+var Policies = map[string]Policy{
+	"fast":     {Interval: 100 * time.Millisecond, FailAfter: 1, RecoverAfter: 3, WarmStandby: true},
+	"balanced": {Interval: 500 * time.Millisecond, FailAfter: 2, RecoverAfter: 5},
+	"reliable": {Interval: time.Second, FailAfter: 3, RecoverAfter: 15, ConfirmProbes: 3},
+}
+
+// PolicyFor returns the policy of a failover strategy, balanced for
+// unknown ones
+func PolicyFor(strategy string) Policy {
+	if p, ok := Policies[strings.ToLower(strategy)]; ok {
+		return p
+	}
+	return Policies["balanced"]
+}
+
+// RouteApplier converges the routes of an owner, implemented by
+// datapath.Applier
+type RouteApplier interface {
+	Apply(ctx context.Context, owner string, desired []datapath.Object) (*datapath.Plan, error)
+	Remove(ctx context.Context, owner string) error
+}
+
+// CostModel prices the uplinks of the paths, implemented by cost.Model
+type CostModel interface {
+	// Cost returns the price per gigabyte of an uplink and whether it
+	// spent its monthly budget
+	Cost(device string) (perGB float64, overBudget bool)
+}
+
+// pathState is the state of the paths of a connection
+type pathState struct {
+	// Generation of the connection the state was built for
+	generation int64
+	// Index of the active and standby paths, -1 for no standby
+	active, standby int
+	// Consecutive failed and healthy checks by path
+	bad, good []int
+	// Whether the routes of the current paths are installed
+	applied bool
+	// Whether no path is healthy, logged once
+	stranded bool
+	// Whether the spec of the generation is invalid, logged once
+	invalid bool
+}
+
+// Engine watches the health of the paths of the established connections
+// with failover on the node and re-routes their traffic to a backup path
+// when the active one fails, and back to the preferred path once it
+// recovered, with the policy of the configured failover strategy. With a
+// cost model the paths are preferred by cost: the paths within their
+// monthly budget first, the cheapest of them first, in the order of the
+// spec among equals. A path only qualifies while it meets the latency
+// requirement of the connection, checked with its health.
+type Engine struct {
+	// Context for cancellation
+	ctx context.Context
+	// Kubernetes client (controller-runtime)
+	client client.Client
+	// Logger
+	logger *logrus.Logger
+	// Programs the routes of the paths
+	applier RouteApplier
+	// Checks the health of the paths
+	checker Checker
+	// Prices the uplinks of the paths, nil to prefer them in spec order
+	costs CostModel
+	// Failover strategy and its policy
+	strategy string
+	policy   Policy
+	// Node whose connections are handled
+	node string
+	// State of the connections, by connection
+	states map[types.NamespacedName]*pathState
+	// Mutex for protecting the states
+	mu sync.Mutex
+	// BFD sessions towards the gateways of the paths, nil to rely on the
+	// checks alone
+	bfd SessionManager
+	// Priority the session towards each gateway was started with
+	sessions map[string]int32
+	// Gateways whose BFD session went down, set by the sessions
+	peerDown map[string]bool
+	// Mutex for protecting the down gateways
+	bfdMu sync.Mutex
+	// Triggers a check of the paths outside the interval
+	wake chan struct{}
+	// Progress signal for the watchdog
+	heartbeat *watchdog.Heartbeat
+}
+
+// NewEngine creates a new failover engine
+func NewEngine(ctx context.Context, c client.Client, logger *logrus.Logger, applier RouteApplier, checker Checker, strategy, node string) *Engine {
+	return &Engine{
+		ctx:      ctx,
+		client:   c,
+		logger:   logger,
+		applier:  applier,
+		checker:  checker,
+		strategy: strings.ToLower(strategy),
+		policy:   PolicyFor(strategy),
+		node:     node,
+		states:   make(map[types.NamespacedName]*pathState),
+		sessions: make(map[string]int32),
+		peerDown: make(map[string]bool),
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// SetHeartbeat makes the engine report its progress to the watchdog
+func (e *Engine) SetHeartbeat(hb *watchdog.Heartbeat) {
+	e.heartbeat = hb
+	hb.Expect(e.policy.Interval)
+}
+
+// SetCostModel makes the engine prefer the paths by the cost of their
+// uplinks
+func (e *Engine) SetCostModel(costs CostModel) {
+	e.costs = costs
+}
+
+// Start checks the paths periodically
+func (e *Engine) Start() error {
+	e.logger.Infof("Starting failover engine (%s strategy, every %s)", e.strategy, e.policy.Interval)
+
+	ticker := time.NewTicker(e.policy.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			e.heartbeat.Beat()
+			if err := e.Tick(); err != nil {
+				e.logger.WithError(err).Warn("Failed to check the paths of the connections")
+			}
+
+		case <-e.wake:
+			if err := e.Tick(); err != nil {
+				e.logger.WithError(err).Warn("Failed to check the paths of the connections")
+			}
+
+		case <-e.ctx.Done():
+			e.logger.Info("Stopping failover engine")
+			// a restarted engine starts the sessions again with its callbacks
+			e.mu.Lock()
+			e.syncSessions(nil)
+			e.mu.Unlock()
+			return nil
+		}
+	}
+}
+
+// Tick checks the paths of every connection with failover once, switching
+// paths as the policy demands, and removes the routes of the connections
+// that are gone or no longer established
+func (e *Engine) Tick() error {
+	var conns nsmv1.NetworkConnectionList
+	if err := e.client.List(e.ctx, &conns); err != nil {
+		return fmt.Errorf("failed to list connections: %w", err)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	seen := make(map[types.NamespacedName]bool)
+	gateways := make(map[string]int32)
+	for i := range conns.Items {
+		conn := &conns.Items[i]
+		if conn.Spec.Failover == nil || !conn.Status.Established ||
+			(conn.Status.Node != "" && conn.Status.Node != e.node) {
+			continue
+		}
+		key := types.NamespacedName{Namespace: conn.Namespace, Name: conn.Name}
+		seen[key] = true
+		if err := e.evaluate(conn); err != nil {
+			e.logger.WithError(err).Warnf("Failed to fail over connection %s", key)
+		}
+		for _, path := range conn.Spec.Failover.Paths {
+			if priority, ok := gateways[path.Gateway]; path.Gateway != "" && (!ok || conn.Spec.Priority > priority) {
+				gateways[path.Gateway] = conn.Spec.Priority
+			}
+		}
+	}
+	e.syncSessions(gateways)
+
+	for key := range e.states {
+		if seen[key] {
+			continue
+		}
+		if err := e.applier.Remove(e.ctx, owner(key)); err != nil {
+			e.logger.WithError(err).Warnf("Failed to remove the failover routes of connection %s", key)
+			continue
+		}
+		delete(e.states, key)
+	}
+	return nil
+}
+
+// evaluate checks the paths of a connection and switches its active path
+// when needed. The mutex must be held.
+func (e *Engine) evaluate(conn *nsmv1.NetworkConnection) error {
+	key := types.NamespacedName{Namespace: conn.Namespace, Name: conn.Name}
+	paths := conn.Spec.Failover.Paths
+	st := e.states[key]
+	if err := validate(conn.Spec.Failover); err != nil {
+		// reported once per generation, the routes of a valid one are removed
+		if st != nil && st.invalid && st.generation == conn.Generation {
+			return nil
+		}
+		e.states[key] = &pathState{generation: conn.Generation, invalid: true}
+		if rmErr := e.applier.Remove(e.ctx, owner(key)); rmErr != nil {
+			return fmt.Errorf("%v, and failed to remove its routes: %w", err, rmErr)
+		}
+		return err
+	}
+
+	if st == nil || st.invalid || st.generation != conn.Generation {
+		// resume on the path of the status, e.g., after a restart
+		st = &pathState{generation: conn.Generation, standby: -1, bad: make([]int, len(paths)), good: make([]int, len(paths))}
+		for i, p := range paths {
+			if p.Name == conn.Status.ActivePath {
+				st.active = i
+			}
+		}
+		e.states[key] = st
+	}
+
+	health := make([]Health, len(paths))
+	var wg sync.WaitGroup
+	for i := range paths {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			health[i] = e.bfdHealth(paths[i], e.checker.Check(e.ctx, paths[i], conn.Spec.LatencyRequirement))
+		}(i)
+	}
+	wg.Wait()
+	for i, h := range health {
+		if h.Healthy {
+			st.good[i]++
+			st.bad[i] = 0
+		} else {
+			st.bad[i]++
+			st.good[i] = 0
+		}
+	}
+
+	order := e.order(paths)
+	rank := make([]int, len(paths))
+	for r, i := range order {
+		rank[i] = r
+	}
+
+	msg := ""
+	switch {
+	case st.bad[st.active] >= e.policy.FailAfter:
+		next := e.pick(paths, order, st, func(i int) bool { return i != st.active && st.bad[i] == 0 })
+		if next < 0 {
+			if !st.stranded {
+				e.logger.Warnf("Connection %s: active path %s is unhealthy (%s) and no backup path is healthy",
+					key, paths[st.active].Name, health[st.active].Reason)
+				st.stranded = true
+			}
+			break
+		}
+		msg = fmt.Sprintf("failed over from path %s (%s) to %s", paths[st.active].Name, health[st.active].Reason, paths[next].Name)
+		failoversTotal.WithLabelValues(e.strategy, ReasonUnhealthy).Inc()
+		st.active, st.applied = next, false
+	case rank[st.active] > 0:
+		// fail back to the most preferred path that recovered
+		next := e.pick(paths, order, st, func(i int) bool { return rank[i] < rank[st.active] && st.good[i] >= e.policy.RecoverAfter })
+		if next < 0 {
+			break
+		}
+		activeCost, activeOver := e.cost(paths[st.active])
+		nextCost, nextOver := e.cost(paths[next])
+		switch {
+		case activeOver && !nextOver:
+			msg = fmt.Sprintf("switched from path %s, over its monthly budget, to %s", paths[st.active].Name, paths[next].Name)
+			failoversTotal.WithLabelValues(e.strategy, ReasonCost).Inc()
+		case nextCost < activeCost:
+			msg = fmt.Sprintf("switched from path %s to the cheaper %s", paths[st.active].Name, paths[next].Name)
+			failoversTotal.WithLabelValues(e.strategy, ReasonCost).Inc()
+		default:
+			msg = fmt.Sprintf("failed back from path %s to the recovered %s", paths[st.active].Name, paths[next].Name)
+			failoversTotal.WithLabelValues(e.strategy, ReasonRecovered).Inc()
+		}
+		st.active, st.applied = next, false
+	}
+	if st.bad[st.active] == 0 {
+		st.stranded = false
+	}
+
+	standby := -1
+	if e.policy.WarmStandby {
+		for _, i := range order {
+			if i != st.active && st.bad[i] == 0 {
+				standby = i
+				break
+			}
+		}
+	}
+	if standby != st.standby {
+		st.standby, st.applied = standby, false
+	}
+
+	if msg != "" {
+		e.logger.Warnf("Connection %s: %s", key, msg)
+	}
+	if !st.applied {
+		if _, err := e.applier.Apply(e.ctx, owner(key), e.routes(conn.Spec.Failover, st)); err != nil {
+			return fmt.Errorf("failed to route over path %s: %w", paths[st.active].Name, err)
+		}
+		st.applied = true
+	}
+	return e.updateStatus(conn, paths, st, msg)
+}
+
+// order returns the indexes of the paths in order of preference: the
+// order of the spec, or with a cost model the paths within their budget
+// before the ones over it, the cheapest first
+func (e *Engine) order(paths []nsmv1.FailoverPath) []int {
+	order := make([]int, len(paths))
+	for i := range order {
+		order[i] = i
+	}
+	if e.costs == nil {
+		return order
+	}
+	perGB := make([]float64, len(paths))
+	over := make([]bool, len(paths))
+	for i, p := range paths {
+		perGB[i], over[i] = e.cost(p)
+	}
+	// stable, so the order of the spec holds among equal costs
+	slices.SortStableFunc(order, func(a, b int) int {
+		switch {
+		case over[a] != over[b]:
+			if over[a] {
+				return 1
+			}
+			return -1
+		case perGB[a] < perGB[b]:
+			return -1
+		case perGB[a] > perGB[b]:
+			return 1
+		}
+		return 0
+	})
+	return order
+}
+
+// cost returns the price per gigabyte of the uplink of a path and whether
+// it spent its monthly budget, every path is free without a cost model
+func (e *Engine) cost(path nsmv1.FailoverPath) (perGB float64, overBudget bool) {
+	if e.costs == nil {
+		return 0, false
+	}
+	return e.costs.Cost(path.Device)
+}
+
+// pick returns the first path in order of preference the filter accepts,
+// confirmed by probes if the policy demands it, -1 if there is none
+func (e *Engine) pick(paths []nsmv1.FailoverPath, order []int, st *pathState, accept func(i int) bool) int {
+	for _, i := range order {
+		if !accept(i) {
+			continue
+		}
+		if e.policy.ConfirmProbes > 0 && !e.checker.Confirm(e.ctx, paths[i], e.policy.ConfirmProbes) {
+			// the check passed by chance, start counting again
+			st.bad[i], st.good[i] = 1, 0
+			continue
+		}
+		return i
+	}
+	return -1
+}
+
+// routes returns the routes of the active path and of the standby path,
+// if any, behind it
+func (e *Engine) routes(spec *nsmv1.FailoverSpec, st *pathState) []datapath.Object {
+	_, dst, _ := net.ParseCIDR(spec.Destination)
+	active := spec.Paths[st.active]
+	objs := []datapath.Object{
+		datapath.Route{Destination: dst.String(), Device: active.Device, Gateway: active.Gateway, Metric: datapath.ActiveMetric},
+	}
+	if st.standby >= 0 {
+		standby := spec.Paths[st.standby]
+		objs = append(objs, datapath.Route{Destination: dst.String(), Device: standby.Device, Gateway: standby.Gateway, Metric: datapath.StandbyMetric})
+	}
+	return objs
+}
+
+// updateStatus reports the active and standby paths of a connection
+func (e *Engine) updateStatus(conn *nsmv1.NetworkConnection, paths []nsmv1.FailoverPath, st *pathState, msg string) error {
+	active, standby := paths[st.active].Name, ""
+	if st.standby >= 0 {
+		standby = paths[st.standby].Name
+	}
+	if conn.Status.ActivePath == active && conn.Status.StandbyPath == standby && msg == "" {
+		return nil
+	}
+	conn.Status.ActivePath = active
+	conn.Status.StandbyPath = standby
+	if msg != "" {
+		conn.Status.Message = msg
+	}
+	if err := e.client.Status().Update(e.ctx, conn); err != nil {
+		return fmt.Errorf("failed to update status: %w", err)
+	}
+	return nil
+}
+
+// validate checks the failover spec of a connection
+func validate(spec *nsmv1.FailoverSpec) error {
+	if _, _, err := net.ParseCIDR(spec.Destination); err != nil {
+		return fmt.Errorf("invalid failover destination %q: %w", spec.Destination, err)
+	}
+	if len(spec.Paths) < 2 {
+		return fmt.Errorf("failover needs at least 2 paths, got %d", len(spec.Paths))
+	}
+	names := make(map[string]bool)
+	for _, path := range spec.Paths {
+		if path.Name == "" || path.Device == "" {
+			return fmt.Errorf("failover paths need a name and a device")
+		}
+		if names[path.Name] {
+			return fmt.Errorf("duplicate failover path %s", path.Name)
+		}
+		names[path.Name] = true
+	}
+	return nil
+}
+
+// owner returns the applier owner of the routes of a connection
+func owner(key types.NamespacedName) string {
+	return "failover/" + key.Namespace + "/" + key.Name
+}
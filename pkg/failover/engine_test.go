@@ -0,0 +1,349 @@
+package failover
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/datapath"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// fakeChecker reports the health of the paths by device
+type fakeChecker struct {
+	unhealthy   map[string]bool
+	unconfirmed map[string]bool
+	confirms    []string
+	mu          sync.Mutex
+}
+
+func newFakeChecker() *fakeChecker {
+	return &fakeChecker{unhealthy: make(map[string]bool), unconfirmed: make(map[string]bool)}
+}
+
+func (f *fakeChecker) set(device string, healthy bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.unhealthy[device] = !healthy
+}
+
+func (f *fakeChecker) Check(ctx context.Context, path nsmv1.FailoverPath, maxLatencyMs int) Health {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.unhealthy[path.Device] {
+		return Health{Reason: "link of " + path.Device + " is down"}
+	}
+	return Health{Healthy: true}
+}
+
+func (f *fakeChecker) Confirm(ctx context.Context, path nsmv1.FailoverPath, probes int) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.confirms = append(f.confirms, path.Name)
+	return !f.unhealthy[path.Device] && !f.unconfirmed[path.Device]
+}
+
+// price is the cost of an uplink
+type price struct {
+	perGB      float64
+	overBudget bool
+}
+
+// fakeCosts prices the uplinks by device
+type fakeCosts map[string]price
+
+func (f fakeCosts) Cost(device string) (float64, bool) {
+	return f[device].perGB, f[device].overBudget
+}
+
+// fakeApplier records the desired routes by owner
+type fakeApplier struct {
+	routes map[string][]datapath.Object
+}
+
+func (f *fakeApplier) Apply(ctx context.Context, owner string, desired []datapath.Object) (*datapath.Plan, error) {
+	f.routes[owner] = desired
+	return &datapath.Plan{}, nil
+}
+
+func (f *fakeApplier) Remove(ctx context.Context, owner string) error {
+	delete(f.routes, owner)
+	return nil
+}
+
+func newTestClient(t *testing.T, objs ...client.Object) client.Client {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := nsmv1.AddToScheme(scheme); err != nil {
+		t.Fatalf("failed to build scheme: %v", err)
+	}
+	return fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(objs...).
+		WithStatusSubresource(&nsmv1.NetworkConnection{}).
+		Build()
+}
+
+func testEngine(c client.Client, checker Checker, strategy string) (*Engine, *fakeApplier) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	applier := &fakeApplier{routes: make(map[string][]datapath.Object)}
+	return NewEngine(context.Background(), c, logger, applier, checker, strategy, "edge-1"), applier
+}
+
+// failoverConnection returns an established connection with a primary
+// and a backup path
+func failoverConnection() *nsmv1.NetworkConnection {
+	return &nsmv1.NetworkConnection{
+		ObjectMeta: metav1.ObjectMeta{Name: "plc", Namespace: "edge"},
+		Spec: nsmv1.NetworkConnectionSpec{
+			ConnectionType: nsmv1.ConnectionTypeKernel,
+			Failover: &nsmv1.FailoverSpec{
+				Destination: "10.20.0.0/16",
+				Paths: []nsmv1.FailoverPath{
+					{Name: "fiber", Device: "eth0", Gateway: "192.168.1.1"},
+					{Name: "lte", Device: "wwan0"},
+				},
+			},
+		},
+		Status: nsmv1.NetworkConnectionStatus{State: nsmv1.ConnectionStateEstablished, Established: true, Node: "edge-1"},
+	}
+}
+
+func route(device, gateway string, metric int) datapath.Route {
+	return datapath.Route{Destination: "10.20.0.0/16", Device: device, Gateway: gateway, Metric: metric}
+}
+
+func tick(t *testing.T, e *Engine, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if err := e.Tick(); err != nil {
+			t.Fatalf("Tick() error = %v", err)
+		}
+	}
+}
+
+func getConnection(t *testing.T, c client.Client) *nsmv1.NetworkConnection {
+	t.Helper()
+	var conn nsmv1.NetworkConnection
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "edge", Name: "plc"}, &conn); err != nil {
+		t.Fatal(err)
+	}
+	return &conn
+}
+
+func TestEngineFastFailsOverImmediatelyWithWarmStandby(t *testing.T) {
+	c := newTestClient(t, failoverConnection())
+	checker := newFakeChecker()
+	e, applier := testEngine(c, checker, "fast")
+
+	tick(t, e, 1)
+	want := []datapath.Object{route("eth0", "192.168.1.1", datapath.ActiveMetric), route("wwan0", "", datapath.StandbyMetric)}
+	if got := applier.routes["failover/edge/plc"]; !reflect.DeepEqual(got, want) {
+		t.Fatalf("routes = %v, want %v", got, want)
+	}
+	if conn := getConnection(t, c); conn.Status.ActivePath != "fiber" || conn.Status.StandbyPath != "lte" {
+		t.Fatalf("paths = %s/%s, want fiber/lte", conn.Status.ActivePath, conn.Status.StandbyPath)
+	}
+
+	// a single failed check switches
+	checker.set("eth0", false)
+	tick(t, e, 1)
+	want = []datapath.Object{route("wwan0", "", datapath.ActiveMetric)}
+	if got := applier.routes["failover/edge/plc"]; !reflect.DeepEqual(got, want) {
+		t.Fatalf("routes after failure = %v, want %v", got, want)
+	}
+	conn := getConnection(t, c)
+	if conn.Status.ActivePath != "lte" || conn.Status.StandbyPath != "" {
+		t.Errorf("paths after failure = %s/%s, want lte/none", conn.Status.ActivePath, conn.Status.StandbyPath)
+	}
+	if conn.Status.Message == "" {
+		t.Errorf("failover not reported in the status message")
+	}
+
+	// fails back after the recovery period
+	checker.set("eth0", true)
+	tick(t, e, 2)
+	if conn := getConnection(t, c); conn.Status.ActivePath != "lte" {
+		t.Fatalf("failed back before the recovery period")
+	}
+	tick(t, e, 1)
+	want = []datapath.Object{route("eth0", "192.168.1.1", datapath.ActiveMetric), route("wwan0", "", datapath.StandbyMetric)}
+	if got := applier.routes["failover/edge/plc"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("routes after recovery = %v, want %v", got, want)
+	}
+	if conn := getConnection(t, c); conn.Status.ActivePath != "fiber" || conn.Status.StandbyPath != "lte" {
+		t.Errorf("paths after recovery = %s/%s, want fiber/lte", conn.Status.ActivePath, conn.Status.StandbyPath)
+	}
+}
+
+func TestEngineReliableConfirmsBackupBeforeSwitching(t *testing.T) {
+	c := newTestClient(t, failoverConnection())
+	checker := newFakeChecker()
+	e, applier := testEngine(c, checker, "reliable")
+
+	tick(t, e, 1)
+	if got := applier.routes["failover/edge/plc"]; len(got) != 1 {
+		t.Fatalf("routes = %v, want the active route only", got)
+	}
+
+	// needs 3 failed checks
+	checker.set("eth0", false)
+	tick(t, e, 2)
+	if conn := getConnection(t, c); conn.Status.ActivePath != "fiber" {
+		t.Fatalf("switched before 3 failed checks")
+	}
+	if len(checker.confirms) != 0 {
+		t.Fatalf("confirmed a backup before the active path failed: %v", checker.confirms)
+	}
+
+	// the backup answers its checks, but not the confirmation probes
+	checker.unconfirmed["wwan0"] = true
+	tick(t, e, 1)
+	if conn := getConnection(t, c); conn.Status.ActivePath != "fiber" {
+		t.Fatalf("switched to an unconfirmed backup")
+	}
+
+	checker.unconfirmed["wwan0"] = false
+	tick(t, e, 1)
+	if conn := getConnection(t, c); conn.Status.ActivePath != "lte" {
+		t.Fatalf("active path = %s, want lte after the confirmation", conn.Status.ActivePath)
+	}
+	want := []datapath.Object{route("wwan0", "", datapath.ActiveMetric)}
+	if got := applier.routes["failover/edge/plc"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("routes = %v, want %v", got, want)
+	}
+
+	// a short recovery doesn't fail back
+	checker.set("eth0", true)
+	tick(t, e, 10)
+	checker.set("eth0", false)
+	tick(t, e, 1)
+	checker.set("eth0", true)
+	tick(t, e, 14)
+	if conn := getConnection(t, c); conn.Status.ActivePath != "lte" {
+		t.Errorf("failed back before 15 healthy checks in a row")
+	}
+	tick(t, e, 1)
+	if conn := getConnection(t, c); conn.Status.ActivePath != "fiber" {
+		t.Errorf("active path = %s, want fiber after the recovery", conn.Status.ActivePath)
+	}
+}
+
+func TestEngineStaysOnFailedPathWithoutHealthyBackup(t *testing.T) {
+	c := newTestClient(t, failoverConnection())
+	checker := newFakeChecker()
+	e, applier := testEngine(c, checker, "balanced")
+
+	checker.set("eth0", false)
+	checker.set("wwan0", false)
+	tick(t, e, 3)
+	want := []datapath.Object{route("eth0", "192.168.1.1", datapath.ActiveMetric)}
+	if got := applier.routes["failover/edge/plc"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("routes = %v, want %v", got, want)
+	}
+	if conn := getConnection(t, c); conn.Status.ActivePath != "fiber" {
+		t.Errorf("active path = %s, want fiber", conn.Status.ActivePath)
+	}
+}
+
+func TestEngineResumesOnStatusPathAndRemovesRoutes(t *testing.T) {
+	conn := failoverConnection()
+	conn.Status.ActivePath = "lte"
+	other := failoverConnection()
+	other.Name = "remote"
+	other.Status.Node = "edge-2"
+	c := newTestClient(t, conn, other)
+	e, applier := testEngine(c, newFakeChecker(), "balanced")
+
+	tick(t, e, 1)
+	want := map[string][]datapath.Object{"failover/edge/plc": {route("wwan0", "", datapath.ActiveMetric)}}
+	if !reflect.DeepEqual(applier.routes, want) {
+		t.Fatalf("routes = %v, want %v", applier.routes, want)
+	}
+
+	// a connection no longer established loses its routes
+	conn = getConnection(t, c)
+	conn.Status.Established = false
+	if err := c.Status().Update(context.Background(), conn); err != nil {
+		t.Fatal(err)
+	}
+	tick(t, e, 1)
+	if len(applier.routes) != 0 {
+		t.Errorf("routes = %v, want none", applier.routes)
+	}
+}
+
+func TestEngineRejectsInvalidSpec(t *testing.T) {
+	conn := failoverConnection()
+	conn.Spec.Failover.Destination = "10.20.0.0"
+	c := newTestClient(t, conn)
+	e, applier := testEngine(c, newFakeChecker(), "fast")
+
+	tick(t, e, 1)
+	if len(applier.routes) != 0 {
+		t.Errorf("routes = %v, want none for an invalid destination", applier.routes)
+	}
+	if err := e.evaluate(getConnection(t, c)); err != nil {
+		t.Errorf("invalid spec reported again for the same generation: %v", err)
+	}
+}
+
+func TestEnginePrefersCheapestPathWithinBudget(t *testing.T) {
+	conn := failoverConnection()
+	paths := conn.Spec.Failover.Paths
+	paths[0], paths[1] = paths[1], paths[0]
+	c := newTestClient(t, conn)
+	checker := newFakeChecker()
+	e, applier := testEngine(c, checker, "balanced")
+	costs := fakeCosts{"wwan0": {perGB: 0.5}, "eth0": {perGB: 0.1}}
+	e.SetCostModel(costs)
+	switches := testutil.ToFloat64(failoversTotal.WithLabelValues("balanced", ReasonCost))
+
+	// the metered lte path of the spec is left once fiber proved stable
+	tick(t, e, 4)
+	if conn := getConnection(t, c); conn.Status.ActivePath != "lte" {
+		t.Fatalf("switched to the cheaper path before the recovery period")
+	}
+	tick(t, e, 1)
+	want := []datapath.Object{route("eth0", "192.168.1.1", datapath.ActiveMetric)}
+	if got := applier.routes["failover/edge/plc"]; !reflect.DeepEqual(got, want) {
+		t.Fatalf("routes = %v, want %v", got, want)
+	}
+	if conn := getConnection(t, c); conn.Status.ActivePath != "fiber" || !strings.Contains(conn.Status.Message, "cheaper") {
+		t.Fatalf("active path = %s (%s), want the cheaper fiber", conn.Status.ActivePath, conn.Status.Message)
+	}
+
+	// a path over its monthly budget is only used when no other is healthy
+	costs["eth0"] = price{perGB: 0.1, overBudget: true}
+	tick(t, e, 5)
+	if conn := getConnection(t, c); conn.Status.ActivePath != "lte" || !strings.Contains(conn.Status.Message, "budget") {
+		t.Fatalf("active path = %s (%s), want lte within its budget", conn.Status.ActivePath, conn.Status.Message)
+	}
+	checker.set("wwan0", false)
+	tick(t, e, 2)
+	if conn := getConnection(t, c); conn.Status.ActivePath != "fiber" {
+		t.Errorf("active path = %s, want fiber with lte down", conn.Status.ActivePath)
+	}
+	if got := testutil.ToFloat64(failoversTotal.WithLabelValues("balanced", ReasonCost)) - switches; got != 2 {
+		t.Errorf("cost switches = %v, want 2", got)
+	}
+}
+
+func TestPolicyFor(t *testing.T) {
+	if got := PolicyFor("FAST"); !got.WarmStandby || got.FailAfter != 1 {
+		t.Errorf("PolicyFor(FAST) = %+v, want the fast policy", got)
+	}
+	if got := PolicyFor("unknown"); got != Policies["balanced"] {
+		t.Errorf("PolicyFor(unknown) = %+v, want the balanced policy", got)
+	}
+}
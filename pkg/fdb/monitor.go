@@ -0,0 +1,336 @@
+package fdb
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/akos011221/nsm/pkg/netutil"
+	"github.com/akos011221/nsm/pkg/watchdog"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// Anomaly kinds
+const (
+	// AnomalyFlapping is a MAC moving between ports, typical of a loop
+	AnomalyFlapping = "flapping"
+	// AnomalySpoofing is a MAC of the host learned on a port
+	AnomalySpoofing = "spoofing"
+)
+
+// Number of anomalies kept for Anomalies
+const maxAnomalies = 100
+
+var (
+	// fdbEntries exposes the size of the MAC learning table per port
+	fdbEntries = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "nsm_fdb_entries",
+		Help: "Number of MAC addresses learned on a bridge port",
+	}, []string{"bridge", "interface"})
+
+	// fdbChanges counts the churn of the MAC learning table
+	fdbChanges = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "nsm_fdb_changes_total",
+		Help: "Changes of the MAC learning table on a bridge port, by change (learned, aged, moved)",
+	}, []string{"interface", "change"})
+
+	// fdbAnomalies counts the anomalies per port, to alert on
+	fdbAnomalies = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "nsm_fdb_anomalies_total",
+		Help: "MAC learning anomalies on a bridge port, by anomaly (flapping, spoofing)",
+	}, []string{"interface", "anomaly"})
+)
+
+func init() {
+	crmetrics.Registry.MustRegister(fdbEntries, fdbChanges, fdbAnomalies)
+}
+
+// Entry is an entry of the MAC learning table of a bridge
+type Entry struct {
+	// MAC address
+	MAC string
+	// Bridge the entry belongs to
+	Bridge string
+	// Port the MAC was learned on
+	Port string
+	// VLAN of the entry, 0 without VLAN filtering
+	VLAN int
+	// Whether the entry is a MAC of the host (the bridge or one of its ports)
+	Permanent bool
+}
+
+// Anomaly is a suspicious change of the MAC learning table
+type Anomaly struct {
+	// Kind of the anomaly (flapping, spoofing)
+	Kind string `json:"kind"`
+	// Bridge and port the MAC was seen on
+	Bridge    string `json:"bridge"`
+	Interface string `json:"interface"`
+	// MAC address
+	MAC string `json:"mac"`
+	// VLAN of the entry
+	VLAN int `json:"vlan,omitempty"`
+	// Description of the anomaly
+	Message string `json:"message"`
+	// Time the anomaly was detected
+	Time time.Time `json:"time"`
+}
+
+// Monitor watches the MAC learning tables of the bridges of the node.
+// Churn is counted per port. A MAC moving between ports more often than
+// the threshold within the window is flapping, which on OT networks
+// usually means a loop; a MAC of the host learned on a port is spoofed.
+// Anomalies are logged and counted, so alerts can fire on the metrics.
+type Monitor struct {
+	// Context for cancellation
+	ctx context.Context
+	// Logger
+	logger *logrus.Logger
+	// Netlink operations reading the learning tables
+	nl netutil.Interface
+	// Interval between readings
+	interval time.Duration
+	// Moves of a MAC within the window that make it flapping
+	flapThreshold int
+	// Window the moves are counted in
+	flapWindow time.Duration
+	// Learned entries of the last reading by key, nil before the first
+	last map[string]Entry
+	// Recent moves per entry key
+	moves map[string][]time.Time
+	// Entries reported as flapping, by key, until their window passes
+	flapping map[string]time.Time
+	// Ports spoofed entries were reported on, by key
+	spoofed map[string]string
+	// Recent anomalies, oldest first
+	anomalies []Anomaly
+	// Mutex for protecting the state
+	mu sync.RWMutex
+	// Progress signal for the watchdog
+	heartbeat *watchdog.Heartbeat
+}
+
+// NewMonitor creates a new MAC learning table monitor
+func NewMonitor(ctx context.Context, logger *logrus.Logger, nl netutil.Interface, flapThreshold int) *Monitor {
+	return &Monitor{
+		ctx:           ctx,
+		logger:        logger,
+		nl:            nl,
+		interval:      5 * time.Second,
+		flapThreshold: flapThreshold,
+		flapWindow:    time.Minute,
+		moves:         make(map[string][]time.Time),
+		flapping:      make(map[string]time.Time),
+		spoofed:       make(map[string]string),
+	}
+}
+
+// SetHeartbeat makes the monitor report its progress to the watchdog
+func (m *Monitor) SetHeartbeat(hb *watchdog.Heartbeat) {
+	m.heartbeat = hb
+	hb.Expect(m.interval)
+}
+
+// Start reads the learning tables periodically
+func (m *Monitor) Start() error {
+	m.logger.Infof("Starting FDB monitor (flapping at %d moves per %s)", m.flapThreshold, m.flapWindow)
+
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			m.heartbeat.Beat()
+			if err := m.Check(now); err != nil {
+				m.logger.WithError(err).Warn("Failed to read the MAC learning tables")
+			}
+
+		case <-m.ctx.Done():
+			m.logger.Info("Stopping FDB monitor")
+			return nil
+		}
+	}
+}
+
+// Anomalies returns the recent anomalies, oldest first
+func (m *Monitor) Anomalies() []Anomaly {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]Anomaly{}, m.anomalies...)
+}
+
+// Check reads the learning tables and compares them to the last reading
+func (m *Monitor) Check(now time.Time) error {
+	entries, err := ReadFDB(m.nl)
+	if err != nil {
+		return err
+	}
+
+	// MACs of the host per bridge
+	local := make(map[string]string)
+	learned := make(map[string]Entry)
+	for _, e := range entries {
+		if e.Permanent {
+			local[e.Bridge+"/"+e.MAC] = e.Port
+			continue
+		}
+		learned[entryKey(e)] = e
+	}
+
+	fdbEntries.Reset()
+	for _, e := range learned {
+		fdbEntries.WithLabelValues(e.Bridge, e.Port).Inc()
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for key, e := range learned {
+		if owner, ok := local[e.Bridge+"/"+e.MAC]; ok && owner != e.Port {
+			m.spoof(key, e, owner, now)
+		}
+	}
+	for key, port := range m.spoofed {
+		if e, ok := learned[key]; !ok || e.Port != port {
+			delete(m.spoofed, key)
+		}
+	}
+
+	// the first reading is the baseline
+	if m.last == nil {
+		m.last = learned
+		return nil
+	}
+	for key, e := range learned {
+		prev, ok := m.last[key]
+		switch {
+		case !ok:
+			fdbChanges.WithLabelValues(e.Port, "learned").Inc()
+		case prev.Port != e.Port:
+			fdbChanges.WithLabelValues(e.Port, "moved").Inc()
+			m.move(key, prev, e, now)
+		}
+	}
+	for key, e := range m.last {
+		if _, ok := learned[key]; !ok {
+			fdbChanges.WithLabelValues(e.Port, "aged").Inc()
+		}
+	}
+	m.last = learned
+
+	for key, since := range m.flapping {
+		if now.Sub(since) >= m.flapWindow {
+			delete(m.flapping, key)
+		}
+	}
+	for key, times := range m.moves {
+		if now.Sub(times[len(times)-1]) >= m.flapWindow {
+			delete(m.moves, key)
+		}
+	}
+	return nil
+}
+
+// move records a move of a MAC and reports it once it flaps, the mutex
+// must be held
+func (m *Monitor) move(key string, from, to Entry, now time.Time) {
+	var recent []time.Time
+	for _, t := range m.moves[key] {
+		if now.Sub(t) < m.flapWindow {
+			recent = append(recent, t)
+		}
+	}
+	recent = append(recent, now)
+	m.moves[key] = recent
+
+	if len(recent) < m.flapThreshold {
+		return
+	}
+	if _, reported := m.flapping[key]; reported {
+		return
+	}
+	m.flapping[key] = now
+	m.report(Anomaly{
+		Kind:      AnomalyFlapping,
+		Bridge:    to.Bridge,
+		Interface: to.Port,
+		MAC:       to.MAC,
+		VLAN:      to.VLAN,
+		Message:   fmt.Sprintf("MAC moved %d times within %s, last from %s to %s, possible loop", len(recent), m.flapWindow, from.Port, to.Port),
+		Time:      now,
+	})
+}
+
+// spoof reports a MAC of the host learned on a port once, the mutex must
+// be held
+func (m *Monitor) spoof(key string, e Entry, owner string, now time.Time) {
+	if m.spoofed[key] == e.Port {
+		return
+	}
+	m.spoofed[key] = e.Port
+	m.report(Anomaly{
+		Kind:      AnomalySpoofing,
+		Bridge:    e.Bridge,
+		Interface: e.Port,
+		MAC:       e.MAC,
+		VLAN:      e.VLAN,
+		Message:   fmt.Sprintf("MAC of %s learned on %s, possible spoofing", owner, e.Port),
+		Time:      now,
+	})
+}
+
+// report logs, counts and keeps an anomaly, the mutex must be held
+func (m *Monitor) report(a Anomaly) {
+	m.logger.Warnf("FDB anomaly on %s/%s: %s %s", a.Bridge, a.Interface, a.MAC, a.Message)
+	fdbAnomalies.WithLabelValues(a.Interface, a.Kind).Inc()
+	m.anomalies = append(m.anomalies, a)
+	if len(m.anomalies) > maxAnomalies {
+		m.anomalies = m.anomalies[len(m.anomalies)-maxAnomalies:]
+	}
+}
+
+// entryKey identifies a learned MAC independently of its port
+func entryKey(e Entry) string {
+	return fmt.Sprintf("%s/%d/%s", e.Bridge, e.VLAN, e.MAC)
+}
+
+// ReadFDB reads the learning tables. Entries of bridges and their ports
+// are kept; those of other devices (e.g., the multicast addresses of NICs)
+// are left out.
+func ReadFDB(nl netutil.Interface) ([]Entry, error) {
+	fdb, err := nl.FDBList()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the MAC learning tables: %w", err)
+	}
+
+	bridges := make(map[string]bool)
+	for _, f := range fdb {
+		if f.Master != "" {
+			bridges[f.Master] = true
+		}
+	}
+	var entries []Entry
+	for _, f := range fdb {
+		bridge := f.Master
+		if bridge == "" {
+			// the own MACs of a bridge have no master
+			if !bridges[f.Device] {
+				continue
+			}
+			bridge = f.Device
+		}
+		entries = append(entries, Entry{
+			MAC:       strings.ToLower(f.MAC),
+			Bridge:    bridge,
+			Port:      f.Device,
+			VLAN:      f.VLAN,
+			Permanent: f.Permanent,
+		})
+	}
+	return entries, nil
+}
@@ -0,0 +1,120 @@
+package fdb
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/akos011221/nsm/pkg/netutil"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+)
+
+func quietLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return logger
+}
+
+func TestReadFDB(t *testing.T) {
+	nl := netutil.NewFake()
+	nl.FDB = []netutil.FDBEntry{
+		{MAC: "33:33:00:00:00:01", Device: "eth0", Permanent: true},
+		{MAC: "02:00:00:00:00:aa", Device: "br0", VLAN: 1, Permanent: true},
+		{MAC: "02:00:00:00:00:01", Device: "veth1", Master: "br0", Permanent: true},
+		{MAC: "0A:00:00:00:00:10", Device: "veth1", Master: "br0", VLAN: 1},
+	}
+	entries, err := ReadFDB(nl)
+	if err != nil {
+		t.Fatalf("ReadFDB() error = %v", err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("unexpected entries %+v, want the NIC multicast entry left out", entries)
+	}
+	if e := entries[0]; e.Bridge != "br0" || e.Port != "br0" || !e.Permanent {
+		t.Errorf("unexpected bridge entry %+v", e)
+	}
+	if e := entries[2]; e.MAC != "0a:00:00:00:00:10" || e.Bridge != "br0" || e.Port != "veth1" || e.VLAN != 1 || e.Permanent {
+		t.Errorf("unexpected learned entry %+v", e)
+	}
+
+	nl.Errors["FDBList"] = errors.New("netlink failure")
+	if _, err := ReadFDB(nl); err == nil {
+		t.Errorf("expected error when the FDB can't be listed")
+	}
+}
+
+func TestMonitorFlapping(t *testing.T) {
+	nl := netutil.NewFake()
+	m := NewMonitor(context.Background(), quietLogger(), nl, 3)
+	at := func(port string) []netutil.FDBEntry {
+		return []netutil.FDBEntry{{MAC: "0a:00:00:00:00:10", Device: port, Master: "brflap"}}
+	}
+
+	now := time.Now()
+	moved := testutil.ToFloat64(fdbChanges.WithLabelValues("portb", "moved"))
+	for i, port := range []string{"porta", "portb", "porta", "portb", "porta", "portb"} {
+		nl.FDB = at(port)
+		if err := m.Check(now.Add(time.Duration(i) * time.Second)); err != nil {
+			t.Fatalf("Check() error = %v", err)
+		}
+	}
+	anomalies := m.Anomalies()
+	if len(anomalies) != 1 || anomalies[0].Kind != AnomalyFlapping || anomalies[0].Interface != "portb" {
+		t.Fatalf("unexpected anomalies %+v, want one flapping report", anomalies)
+	}
+	if got := testutil.ToFloat64(fdbChanges.WithLabelValues("portb", "moved")) - moved; got != 3 {
+		t.Errorf("moves to portb = %v, want 3", got)
+	}
+	if got := testutil.ToFloat64(fdbAnomalies.WithLabelValues("portb", AnomalyFlapping)); got != 1 {
+		t.Errorf("flapping anomalies on portb = %v, want 1", got)
+	}
+
+	// moves spread beyond the window don't flap
+	m = NewMonitor(context.Background(), quietLogger(), nl, 3)
+	for i, port := range []string{"porta", "portb", "porta", "portb"} {
+		nl.FDB = at(port)
+		if err := m.Check(now.Add(time.Duration(i) * 40 * time.Second)); err != nil {
+			t.Fatalf("Check() error = %v", err)
+		}
+	}
+	if anomalies := m.Anomalies(); len(anomalies) != 0 {
+		t.Errorf("unexpected anomalies for slow moves %+v", anomalies)
+	}
+}
+
+func TestMonitorSpoofingAndChurn(t *testing.T) {
+	nl := netutil.NewFake()
+	m := NewMonitor(context.Background(), quietLogger(), nl, 3)
+	host := netutil.FDBEntry{MAC: "02:00:00:00:00:01", Device: "vethhost", Master: "brspoof", Permanent: true}
+	pod := netutil.FDBEntry{MAC: "0a:00:00:00:00:20", Device: "vethpod", Master: "brspoof"}
+	spoofed := netutil.FDBEntry{MAC: host.MAC, Device: "vethpod", Master: "brspoof"}
+	nl.FDB = []netutil.FDBEntry{host, pod}
+
+	now := time.Now()
+	if err := m.Check(now); err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	if got := testutil.ToFloat64(fdbEntries.WithLabelValues("brspoof", "vethpod")); got != 1 {
+		t.Errorf("entries on vethpod = %v, want 1", got)
+	}
+
+	nl.FDB = []netutil.FDBEntry{host, spoofed}
+	for i := 1; i <= 2; i++ {
+		if err := m.Check(now.Add(time.Duration(i) * time.Second)); err != nil {
+			t.Fatalf("Check() error = %v", err)
+		}
+	}
+	anomalies := m.Anomalies()
+	if len(anomalies) != 1 || anomalies[0].Kind != AnomalySpoofing || anomalies[0].Interface != "vethpod" || anomalies[0].MAC != host.MAC {
+		t.Fatalf("unexpected anomalies %+v, want one spoofing report", anomalies)
+	}
+	if got := testutil.ToFloat64(fdbChanges.WithLabelValues("vethpod", "aged")); got != 1 {
+		t.Errorf("aged entries on vethpod = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(fdbChanges.WithLabelValues("vethpod", "learned")); got != 1 {
+		t.Errorf("learned entries on vethpod = %v, want 1", got)
+	}
+}
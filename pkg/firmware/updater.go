@@ -0,0 +1,98 @@
+package firmware
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+)
+
+// DefaultCommand flashes the firmware through devlink, the image is looked
+// up by the kernel in its firmware search path (e.g., /lib/firmware)
+const DefaultCommand = "devlink dev flash pci/{pci} file {image}"
+
+// CommandRunner runs a command and returns its combined output
+type CommandRunner func(ctx context.Context, name string, args ...string) ([]byte, error)
+
+// Updater flashes NIC firmware with the command configured on the node.
+// The command is split into arguments and run without a shell, its {pf},
+// {pci} and {image} placeholders replaced, so the updates only choose the
+// image and never what runs on the node.
+type Updater struct {
+	// Command with its placeholders, split into arguments
+	command []string
+	// Longest time the command may run
+	timeout time.Duration
+	// Runs the commands, replaceable for tests
+	run CommandRunner
+}
+
+// NewUpdater creates a new firmware updater running command, DefaultCommand
+// if empty
+func NewUpdater(command string, timeout time.Duration) *Updater {
+	if command == "" {
+		command = DefaultCommand
+	}
+	return &Updater{command: strings.Fields(command), timeout: timeout, run: runCommand}
+}
+
+// runCommand runs a command on the host
+func runCommand(ctx context.Context, name string, args ...string) ([]byte, error) {
+	out, err := exec.CommandContext(ctx, name, args...).CombinedOutput()
+	if err != nil {
+		return out, fmt.Errorf("%s %s failed: %w: %s", name, strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return out, nil
+}
+
+// ValidateImage checks that an image names a file inside the firmware
+// search path
+func ValidateImage(image string) error {
+	if image == "" {
+		return fmt.Errorf("no firmware image set")
+	}
+	if path.IsAbs(image) || strings.HasPrefix(image, "-") {
+		return fmt.Errorf("firmware image %q must be relative to the firmware search path", image)
+	}
+	for _, elem := range strings.Split(image, "/") {
+		if elem == ".." {
+			return fmt.Errorf("firmware image %q escapes the firmware search path", image)
+		}
+	}
+	return nil
+}
+
+// Flash flashes image on the PF pf at PCI address pci
+func (u *Updater) Flash(ctx context.Context, pf, pci, image string) error {
+	if err := ValidateImage(image); err != nil {
+		return err
+	}
+	r := strings.NewReplacer("{pf}", pf, "{pci}", pci, "{image}", image)
+	args := make([]string, len(u.command))
+	for i, arg := range u.command {
+		args[i] = r.Replace(arg)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, u.timeout)
+	defer cancel()
+	if _, err := u.run(ctx, args[0], args[1:]...); err != nil {
+		return fmt.Errorf("failed to flash %s on %s: %w", image, pf, err)
+	}
+	return nil
+}
+
+// Version returns the firmware version a PF reports
+func (u *Updater) Version(ctx context.Context, pf string) (string, error) {
+	out, err := u.run(ctx, "ethtool", "-i", pf)
+	if err != nil {
+		return "", fmt.Errorf("failed to read the driver info of %s: %w", pf, err)
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		if version, ok := strings.CutPrefix(line, "firmware-version:"); ok {
+			return strings.TrimSpace(version), nil
+		}
+	}
+	return "", fmt.Errorf("%s doesn't report its firmware version", pf)
+}
@@ -0,0 +1,63 @@
+package firmware
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUpdaterFlash(t *testing.T) {
+	var ran []string
+	u := NewUpdater("", time.Minute)
+	u.run = func(_ context.Context, name string, args ...string) ([]byte, error) {
+		ran = append([]string{name}, args...)
+		return nil, nil
+	}
+
+	if err := u.Flash(context.Background(), "eth0", "0000:3b:00.0", "mlx/fw-22.39.bin"); err != nil {
+		t.Fatalf("Flash() error = %v", err)
+	}
+	want := []string{"devlink", "dev", "flash", "pci/0000:3b:00.0", "file", "mlx/fw-22.39.bin"}
+	if !reflect.DeepEqual(ran, want) {
+		t.Errorf("ran %q, want %q", ran, want)
+	}
+
+	u = NewUpdater("/opt/vendor/fwupdate --dev {pf} {image}", time.Minute)
+	u.run = func(_ context.Context, name string, args ...string) ([]byte, error) {
+		ran = append([]string{name}, args...)
+		return []byte("no such image"), errors.New("exit status 1")
+	}
+	err := u.Flash(context.Background(), "eth1", "0000:3b:00.1", "fw.bin")
+	if err == nil || !strings.Contains(err.Error(), "failed to flash fw.bin on eth1") {
+		t.Errorf("Flash() error = %v, want the failure of the command", err)
+	}
+	if want := []string{"/opt/vendor/fwupdate", "--dev", "eth1", "fw.bin"}; !reflect.DeepEqual(ran, want) {
+		t.Errorf("ran %q, want %q", ran, want)
+	}
+
+	for _, image := range []string{"", "/etc/shadow", "../../etc/shadow", "mlx/../../x", "--help"} {
+		if err := u.Flash(context.Background(), "eth0", "0000:3b:00.0", image); err == nil {
+			t.Errorf("Flash() accepted the image %q", image)
+		}
+	}
+}
+
+func TestUpdaterVersion(t *testing.T) {
+	u := NewUpdater("", time.Minute)
+	u.run = func(_ context.Context, name string, args ...string) ([]byte, error) {
+		return []byte("driver: mlx5_core\nversion: 6.8.0\nfirmware-version: 22.39.1002 (MT_0000000359)\nbus-info: 0000:3b:00.0\n"), nil
+	}
+	if v, err := u.Version(context.Background(), "eth0"); err != nil || v != "22.39.1002 (MT_0000000359)" {
+		t.Errorf("Version() = %q, %v", v, err)
+	}
+
+	u.run = func(_ context.Context, name string, args ...string) ([]byte, error) {
+		return []byte("driver: veth\n"), nil
+	}
+	if _, err := u.Version(context.Background(), "veth0"); err == nil {
+		t.Error("Version() succeeded without a firmware version")
+	}
+}
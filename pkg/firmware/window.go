@@ -0,0 +1,78 @@
+package firmware
+
+import (
+	"fmt"
+	"time"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+)
+
+// Longest maintenance window, a week
+const maxWindowMinutes = 7 * 24 * 60
+
+// weekdays by their abbreviation in maintenance windows
+var weekdays = map[string]time.Weekday{
+	"Sun": time.Sunday,
+	"Mon": time.Monday,
+	"Tue": time.Tuesday,
+	"Wed": time.Wednesday,
+	"Thu": time.Thursday,
+	"Fri": time.Friday,
+	"Sat": time.Saturday,
+}
+
+// ValidateWindow checks a maintenance window
+func ValidateWindow(w *nsmv1.MaintenanceWindow) error {
+	if w == nil {
+		return nil
+	}
+	if _, err := time.Parse("15:04", w.Start); err != nil {
+		return fmt.Errorf("invalid start %q of the maintenance window, want HH:MM", w.Start)
+	}
+	if w.DurationMinutes < 1 || w.DurationMinutes > maxWindowMinutes {
+		return fmt.Errorf("the maintenance window must last between a minute and a week")
+	}
+	for _, day := range w.Days {
+		if _, ok := weekdays[day]; !ok {
+			return fmt.Errorf("invalid day %q of the maintenance window", day)
+		}
+	}
+	return nil
+}
+
+// InWindow reports whether now is within a maintenance window and, if not,
+// when the window opens next. A nil window is always open.
+func InWindow(w *nsmv1.MaintenanceWindow, now time.Time) (bool, time.Time, error) {
+	if w == nil {
+		return true, now, nil
+	}
+	if err := ValidateWindow(w); err != nil {
+		return false, time.Time{}, err
+	}
+	start, _ := time.Parse("15:04", w.Start)
+	now = now.UTC()
+	length := time.Duration(w.DurationMinutes) * time.Minute
+
+	days := make(map[time.Weekday]bool, len(w.Days))
+	for _, day := range w.Days {
+		days[weekdays[day]] = true
+	}
+	// the window opened on the previous days may still be open, windows
+	// last at most a week
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	for offset := -7; offset <= 7; offset++ {
+		day := midnight.AddDate(0, 0, offset)
+		if len(days) > 0 && !days[day.Weekday()] {
+			continue
+		}
+		opens := day.Add(time.Duration(start.Hour())*time.Hour + time.Duration(start.Minute())*time.Minute)
+		if now.Before(opens) {
+			return false, opens, nil
+		}
+		if now.Before(opens.Add(length)) {
+			return true, now, nil
+		}
+	}
+	// unreachable, every day of the week comes up within a week
+	return false, time.Time{}, fmt.Errorf("maintenance window never opens")
+}
@@ -0,0 +1,44 @@
+package firmware
+
+import (
+	"testing"
+	"time"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+)
+
+func TestInWindow(t *testing.T) {
+	// Wednesday
+	now := time.Date(2026, 10, 14, 1, 30, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		window *nsmv1.MaintenanceWindow
+		open   bool
+		next   time.Time
+	}{
+		{"no window", nil, true, now},
+		{"open daily", &nsmv1.MaintenanceWindow{Start: "01:00", DurationMinutes: 60}, true, now},
+		{"later today", &nsmv1.MaintenanceWindow{Start: "02:00", DurationMinutes: 60}, false, time.Date(2026, 10, 14, 2, 0, 0, 0, time.UTC)},
+		{"closed today", &nsmv1.MaintenanceWindow{Start: "00:00", DurationMinutes: 60}, false, time.Date(2026, 10, 15, 0, 0, 0, 0, time.UTC)},
+		{"weekends", &nsmv1.MaintenanceWindow{Days: []string{"Sat", "Sun"}, Start: "22:00", DurationMinutes: 240}, false, time.Date(2026, 10, 17, 22, 0, 0, 0, time.UTC)},
+		{"opened yesterday", &nsmv1.MaintenanceWindow{Days: []string{"Tue"}, Start: "23:00", DurationMinutes: 180}, true, now},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			open, next, err := InWindow(tt.window, now)
+			if err != nil || open != tt.open || !next.Equal(tt.next) {
+				t.Errorf("InWindow() = %t, %s, %v, want %t, %s", open, next, err, tt.open, tt.next)
+			}
+		})
+	}
+
+	for _, w := range []*nsmv1.MaintenanceWindow{
+		{Start: "25:00", DurationMinutes: 60},
+		{Start: "01:00"},
+		{Days: []string{"Monday"}, Start: "01:00", DurationMinutes: 60},
+	} {
+		if _, _, err := InWindow(w, now); err == nil {
+			t.Errorf("InWindow() accepted %+v", w)
+		}
+	}
+}
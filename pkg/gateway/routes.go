@@ -0,0 +1,210 @@
+package gateway
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/intent"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Group of the Gateway API
+const Group = "gateway.networking.k8s.io"
+
+// Route kinds NSM accepts as intent
+var (
+	HTTPRoute = schema.GroupVersionKind{Group: Group, Version: "v1", Kind: "HTTPRoute"}
+	GRPCRoute = schema.GroupVersionKind{Group: Group, Version: "v1", Kind: "GRPCRoute"}
+)
+
+// LabelRoute is set on every connection generated from a route
+const LabelRoute = "nsm.akosrbn.io/route"
+
+// LabelGatewayName is the label Gateway API implementations put on the
+// pods of a Gateway
+const LabelGatewayName = "gateway.networking.k8s.io/gateway-name"
+
+// AnnotationConnectionType overrides the connection type of the
+// connections generated from a route
+const AnnotationConnectionType = "nsm.akosrbn.io/connection-type"
+
+// ParentRef references the Gateway a route attaches to
+type ParentRef struct {
+	// API group, defaults to the Gateway API
+	Group *string `json:"group,omitempty"`
+	// Kind, defaults to Gateway
+	Kind *string `json:"kind,omitempty"`
+	// Namespace, defaults to the route's
+	Namespace *string `json:"namespace,omitempty"`
+	// Name of the Gateway
+	Name string `json:"name"`
+}
+
+// BackendRef references the backend traffic is forwarded to
+type BackendRef struct {
+	// API group, the core group if empty
+	Group *string `json:"group,omitempty"`
+	// Kind, defaults to Service
+	Kind *string `json:"kind,omitempty"`
+	// Namespace, defaults to the route's
+	Namespace *string `json:"namespace,omitempty"`
+	// Name of the backend
+	Name string `json:"name"`
+}
+
+// Rule is a routing rule of a route
+type Rule struct {
+	// Backends of the rule
+	BackendRefs []BackendRef `json:"backendRefs,omitempty"`
+}
+
+// RouteSpec is the part of an HTTPRoute or GRPCRoute spec NSM reads
+type RouteSpec struct {
+	// Gateways the route attaches to
+	ParentRefs []ParentRef `json:"parentRefs,omitempty"`
+	// Routing rules
+	Rules []Rule `json:"rules,omitempty"`
+}
+
+// Result holds the connections a route expands into
+type Result struct {
+	// Connections from every Gateway pod to every NetworkService backend
+	Connections []nsmv1.NetworkConnection
+	// NetworkService backends that don't exist, as namespace/name
+	Unresolved []string
+}
+
+// ParseSpec reads the spec of a route
+func ParseSpec(route *unstructured.Unstructured) (*RouteSpec, error) {
+	raw, _, err := unstructured.NestedMap(route.Object, "spec")
+	if err != nil {
+		return nil, fmt.Errorf("invalid spec of %s %s/%s: %w", route.GetKind(), route.GetNamespace(), route.GetName(), err)
+	}
+	var spec RouteSpec
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(raw, &spec); err != nil {
+		return nil, fmt.Errorf("invalid spec of %s %s/%s: %w", route.GetKind(), route.GetNamespace(), route.GetName(), err)
+	}
+	return &spec, nil
+}
+
+// Gateways returns the Gateways a route attaches to
+func (s *RouteSpec) Gateways(routeNamespace string) []client.ObjectKey {
+	var gateways []client.ObjectKey
+	for _, ref := range s.ParentRefs {
+		if value(ref.Group, Group) != Group || value(ref.Kind, "Gateway") != "Gateway" {
+			continue
+		}
+		gateways = append(gateways, client.ObjectKey{Namespace: value(ref.Namespace, routeNamespace), Name: ref.Name})
+	}
+	return gateways
+}
+
+// Services returns the NetworkService backends of a route
+func (s *RouteSpec) Services(routeNamespace string) []client.ObjectKey {
+	seen := make(map[client.ObjectKey]bool)
+	var services []client.ObjectKey
+	for _, rule := range s.Rules {
+		for _, ref := range rule.BackendRefs {
+			if value(ref.Group, "") != nsmv1.GroupName || value(ref.Kind, "Service") != "NetworkService" {
+				continue
+			}
+			key := client.ObjectKey{Namespace: value(ref.Namespace, routeNamespace), Name: ref.Name}
+			if !seen[key] {
+				seen[key] = true
+				services = append(services, key)
+			}
+		}
+	}
+	return services
+}
+
+// Compile expands a route into a NetworkConnection from every pod of its
+// Gateways to every NetworkService backend, so the north-south traffic
+// the Gateway forwards runs over the NSM datapath. Backends of other
+// kinds are left to the Gateway. The connections live in the route's
+// namespace, so those from Gateway pods in another namespace need its
+// acceptance like any other cross-namespace connection. It is a pure
+// function, so the result only depends on its inputs.
+func Compile(route *unstructured.Unstructured, pods []corev1.Pod, services map[client.ObjectKey]*nsmv1.NetworkService) (*Result, error) {
+	spec, err := ParseSpec(route)
+	if err != nil {
+		return nil, err
+	}
+
+	gateways := make(map[client.ObjectKey]bool)
+	for _, gw := range spec.Gateways(route.GetNamespace()) {
+		gateways[gw] = true
+	}
+	// sort to keep the output stable between reconciles
+	var gatewayPods []corev1.Pod
+	for _, pod := range pods {
+		gw := client.ObjectKey{Namespace: pod.Namespace, Name: pod.Labels[LabelGatewayName]}
+		if gateways[gw] && pod.DeletionTimestamp == nil {
+			gatewayPods = append(gatewayPods, pod)
+		}
+	}
+	sort.Slice(gatewayPods, func(i, j int) bool {
+		return gatewayPods[i].Namespace+"/"+gatewayPods[i].Name < gatewayPods[j].Namespace+"/"+gatewayPods[j].Name
+	})
+
+	result := &Result{}
+	for _, key := range spec.Services(route.GetNamespace()) {
+		svc := services[key]
+		if svc == nil {
+			result.Unresolved = append(result.Unresolved, key.String())
+			continue
+		}
+		dst := key.Name
+		if key.Namespace != route.GetNamespace() {
+			dst = key.String()
+		}
+
+		for _, pod := range gatewayPods {
+			result.Connections = append(result.Connections, nsmv1.NetworkConnection{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      intent.ObjectName(strings.ToLower(route.GetKind()), route.GetName(), key.Name, pod.Name),
+					Namespace: route.GetNamespace(),
+					Labels:    map[string]string{LabelRoute: route.GetName()},
+				},
+				Spec: nsmv1.NetworkConnectionSpec{
+					Source:             pod.Namespace + "/" + pod.Name,
+					Destination:        dst,
+					ConnectionType:     connectionType(route, svc),
+					Priority:           intent.PriorityValue(svc.Spec.Priority),
+					LatencyRequirement: svc.Spec.LatencyRequirement,
+					Bandwidth:          svc.Spec.Bandwidth,
+				},
+			})
+		}
+	}
+	return result, nil
+}
+
+// connectionType picks the datapath for the generated connections
+func connectionType(route *unstructured.Unstructured, svc *nsmv1.NetworkService) string {
+	switch {
+	case route.GetAnnotations()[AnnotationConnectionType] != "":
+		return route.GetAnnotations()[AnnotationConnectionType]
+	case svc.Spec.RequireDPDK:
+		return nsmv1.ConnectionTypeDPDK
+	case svc.Spec.RequireSRIOV:
+		return nsmv1.ConnectionTypeSRIOV
+	default:
+		return nsmv1.ConnectionTypeKernel
+	}
+}
+
+// value returns the referenced string, or the default if unset
+func value(s *string, def string) string {
+	if s == nil {
+		return def
+	}
+	return *s
+}
@@ -0,0 +1,85 @@
+package gateway
+
+import (
+	"reflect"
+	"testing"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func route() *unstructured.Unstructured {
+	u := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{
+			"parentRefs": []interface{}{
+				map[string]interface{}{"name": "edge-gw", "namespace": "infra"},
+				// only Gateways are followed
+				map[string]interface{}{"name": "mesh", "kind": "Service", "group": ""},
+			},
+			"rules": []interface{}{
+				map[string]interface{}{"backendRefs": []interface{}{
+					map[string]interface{}{"group": "nsm.akosrbn.io", "kind": "NetworkService", "name": "vision"},
+					map[string]interface{}{"name": "plain-service", "port": int64(80)},
+				}},
+				map[string]interface{}{"backendRefs": []interface{}{
+					map[string]interface{}{"group": "nsm.akosrbn.io", "kind": "NetworkService", "name": "vision"},
+					map[string]interface{}{"group": "nsm.akosrbn.io", "kind": "NetworkService", "name": "archive", "namespace": "storage"},
+					map[string]interface{}{"group": "nsm.akosrbn.io", "kind": "NetworkService", "name": "missing"},
+				}},
+			},
+		},
+	}}
+	u.SetGroupVersionKind(HTTPRoute)
+	u.SetNamespace("edge")
+	u.SetName("cameras")
+	u.SetAnnotations(map[string]string{AnnotationConnectionType: nsmv1.ConnectionTypeVXLAN})
+	return u
+}
+
+func gatewayPod(namespace, name, gateway string) corev1.Pod {
+	return corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, Labels: map[string]string{LabelGatewayName: gateway}}}
+}
+
+func TestCompile(t *testing.T) {
+	pods := []corev1.Pod{
+		gatewayPod("infra", "edge-gw-b", "edge-gw"),
+		gatewayPod("infra", "edge-gw-a", "edge-gw"),
+		// same gateway name in another namespace
+		gatewayPod("edge", "edge-gw-x", "edge-gw"),
+	}
+	services := map[client.ObjectKey]*nsmv1.NetworkService{
+		{Namespace: "edge", Name: "vision"}:     {Spec: nsmv1.NetworkServiceSpec{Priority: "high", LatencyRequirement: 20}},
+		{Namespace: "storage", Name: "archive"}: {Spec: nsmv1.NetworkServiceSpec{}},
+	}
+
+	result, err := Compile(route(), pods, services)
+	if err != nil {
+		t.Fatalf("Compile() error = %v", err)
+	}
+	if !reflect.DeepEqual(result.Unresolved, []string{"edge/missing"}) {
+		t.Errorf("Unresolved = %v", result.Unresolved)
+	}
+
+	var got []string
+	for _, conn := range result.Connections {
+		got = append(got, conn.Name+" "+conn.Spec.Source+" -> "+conn.Spec.Destination)
+		if conn.Namespace != "edge" || conn.Labels[LabelRoute] != "cameras" || conn.Spec.ConnectionType != nsmv1.ConnectionTypeVXLAN {
+			t.Errorf("unexpected connection %+v", conn)
+		}
+	}
+	want := []string{
+		"httproute-cameras-vision-edge-gw-a infra/edge-gw-a -> vision",
+		"httproute-cameras-vision-edge-gw-b infra/edge-gw-b -> vision",
+		"httproute-cameras-archive-edge-gw-a infra/edge-gw-a -> storage/archive",
+		"httproute-cameras-archive-edge-gw-b infra/edge-gw-b -> storage/archive",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("connections = %v, want %v", got, want)
+	}
+	if spec := result.Connections[0].Spec; spec.Priority != 100 || spec.LatencyRequirement != 20 {
+		t.Errorf("QoS not taken from the service: %+v", spec)
+	}
+}
@@ -0,0 +1,223 @@
+package gc
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/connection"
+	"github.com/akos011221/nsm/pkg/watchdog"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	// collectedTotal counts the stale connections collected, by action
+	collectedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "nsm_connection_gc_total",
+		Help: "Number of stale NetworkConnections collected, by action (deleted, dry_run)",
+	}, []string{"action"})
+
+	// staleConnections is the number of connections whose endpoints are gone
+	staleConnections = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "nsm_connection_gc_stale",
+		Help: "Number of NetworkConnections whose endpoints are gone, within the grace period or not",
+	})
+)
+
+func init() {
+	crmetrics.Registry.MustRegister(collectedTotal, staleConnections)
+}
+
+// Result reports what a collection found
+type Result struct {
+	// Connections whose endpoints are gone for less than the grace period
+	Stale []string
+	// Connections deleted, or that would have been in dry-run mode
+	Collected []string
+}
+
+// Collector deletes the NetworkConnections whose endpoints have been gone
+// for the grace period, e.g. left behind by crashed clients. Connections
+// with an owner are left to the Kubernetes garbage collector, canaries to
+// their TTL. The time an endpoint went missing is kept in memory, so a
+// restart only ever extends the grace period.
+type Collector struct {
+	// Context for cancellation
+	ctx context.Context
+	// Kubernetes client (controller-runtime)
+	client client.Client
+	// Logger
+	logger *logrus.Logger
+	// Time the endpoints of a connection must be gone before it is collected
+	grace time.Duration
+	// Whether collections are only logged and counted
+	dryRun bool
+	// Interval between collections
+	interval time.Duration
+	// Time the endpoints of each connection were first seen gone, by namespace/name
+	missingSince map[string]time.Time
+	// Mutex for protecting the state
+	mu sync.Mutex
+	// Progress signal for the watchdog
+	heartbeat *watchdog.Heartbeat
+}
+
+// NewCollector creates a new stale connection collector
+func NewCollector(ctx context.Context, c client.Client, logger *logrus.Logger, grace time.Duration, dryRun bool) *Collector {
+	return &Collector{
+		ctx:          ctx,
+		client:       c,
+		logger:       logger,
+		grace:        grace,
+		dryRun:       dryRun,
+		interval:     min(grace/4, time.Minute),
+		missingSince: make(map[string]time.Time),
+	}
+}
+
+// SetHeartbeat makes the collector report its progress to the watchdog
+func (g *Collector) SetHeartbeat(hb *watchdog.Heartbeat) {
+	g.heartbeat = hb
+	hb.Expect(g.interval)
+}
+
+// Start collects the stale connections periodically
+func (g *Collector) Start() error {
+	mode := ""
+	if g.dryRun {
+		mode = " in dry-run mode"
+	}
+	g.logger.Infof("Starting stale connection collector%s (grace period %s)", mode, g.grace)
+
+	ticker := time.NewTicker(g.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			g.heartbeat.Beat()
+			if _, err := g.Collect(g.ctx, now); err != nil {
+				g.logger.WithError(err).Warn("Failed to collect stale connections")
+			}
+
+		case <-g.ctx.Done():
+			g.logger.Info("Stopping stale connection collector")
+			return nil
+		}
+	}
+}
+
+// Collect checks the endpoints of the connections and deletes those gone
+// for the grace period
+func (g *Collector) Collect(ctx context.Context, now time.Time) (*Result, error) {
+	var conns nsmv1.NetworkConnectionList
+	if err := g.client.List(ctx, &conns); err != nil {
+		return nil, fmt.Errorf("failed to list connections: %w", err)
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	result := &Result{}
+	seen := make(map[string]bool, len(conns.Items))
+	for i := range conns.Items {
+		conn := &conns.Items[i]
+		if conn.Spec.Canary != nil || len(conn.OwnerReferences) > 0 || conn.DeletionTimestamp != nil {
+			continue
+		}
+		key := client.ObjectKeyFromObject(conn).String()
+
+		gone, err := g.endpointGone(ctx, conn)
+		if err != nil {
+			return result, err
+		}
+		if gone == "" {
+			continue
+		}
+		seen[key] = true
+
+		since, ok := g.missingSince[key]
+		if !ok {
+			since = now
+			g.missingSince[key] = now
+			g.logger.Infof("Connection %s is stale, %s is gone", key, gone)
+		}
+		if now.Sub(since) < g.grace {
+			result.Stale = append(result.Stale, key)
+			continue
+		}
+
+		if err := g.collect(ctx, conn, gone); err != nil {
+			return result, err
+		}
+		delete(g.missingSince, key)
+		result.Collected = append(result.Collected, key)
+	}
+	// connections whose endpoints came back or that were deleted
+	for key := range g.missingSince {
+		if !seen[key] {
+			delete(g.missingSince, key)
+		}
+	}
+
+	staleConnections.Set(float64(len(g.missingSince)))
+	sort.Strings(result.Stale)
+	sort.Strings(result.Collected)
+	return result, nil
+}
+
+// collect deletes a stale connection, or only logs it in dry-run mode
+func (g *Collector) collect(ctx context.Context, conn *nsmv1.NetworkConnection, gone string) error {
+	key := client.ObjectKeyFromObject(conn)
+	if g.dryRun {
+		collectedTotal.WithLabelValues("dry_run").Inc()
+		g.logger.Infof("Dry run: would delete connection %s, %s gone for %s", key, gone, g.grace)
+		// in dry-run mode the connection is reported again after another grace period
+		return nil
+	}
+
+	// the UID precondition spares a connection recreated under the same name
+	if err := g.client.Delete(ctx, conn, client.Preconditions{UID: &conn.UID}); client.IgnoreNotFound(err) != nil {
+		return fmt.Errorf("failed to delete connection %s: %w", key, err)
+	}
+	collectedTotal.WithLabelValues("deleted").Inc()
+	g.logger.Warnf("Deleted stale connection %s, %s gone for %s", key, gone, g.grace)
+	return nil
+}
+
+// endpointGone returns which endpoint of a connection is gone, empty if
+// both exist. The source is a namespace/pod reference, the destination a
+// NetworkService when it names one rather than an address.
+func (g *Collector) endpointGone(ctx context.Context, conn *nsmv1.NetworkConnection) (string, error) {
+	if namespace, name, ok := strings.Cut(conn.Spec.Source, "/"); ok {
+		var pod corev1.Pod
+		err := g.client.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, &pod)
+		if client.IgnoreNotFound(err) != nil {
+			return "", fmt.Errorf("failed to get pod %s: %w", conn.Spec.Source, err)
+		}
+		if err != nil || pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			return "source pod " + conn.Spec.Source, nil
+		}
+	}
+
+	if namespace, name := connection.Destination(conn); len(validation.IsDNS1123Label(name)) == 0 {
+		var svc nsmv1.NetworkService
+		err := g.client.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, &svc)
+		if client.IgnoreNotFound(err) != nil {
+			return "", fmt.Errorf("failed to get service %s/%s: %w", namespace, name, err)
+		}
+		if err != nil {
+			return "destination service " + namespace + "/" + name, nil
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,115 @@
+package gc
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newClient(t *testing.T, objs ...client.Object) client.Client {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatalf("failed to build scheme: %v", err)
+	}
+	if err := nsmv1.AddToScheme(scheme); err != nil {
+		t.Fatalf("failed to build scheme: %v", err)
+	}
+	return fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build()
+}
+
+func quietLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return logger
+}
+
+func newConnection(name, source, destination string) *nsmv1.NetworkConnection {
+	return &nsmv1.NetworkConnection{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "edge", Name: name, UID: types.UID("uid-" + name)},
+		Spec:       nsmv1.NetworkConnectionSpec{Source: source, Destination: destination},
+	}
+}
+
+func TestCollectStaleConnections(t *testing.T) {
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "edge", Name: "camera"}}
+	svc := &nsmv1.NetworkService{ObjectMeta: metav1.ObjectMeta{Namespace: "edge", Name: "vision"}}
+	owned := newConnection("owned", "edge/crashed", "vision")
+	owned.OwnerReferences = []metav1.OwnerReference{{APIVersion: "nsm.akosrbn.io/v1", Kind: "NetworkIntent", Name: "feeds", UID: "intent-uid"}}
+	c := newClient(t, pod, svc, owned,
+		newConnection("healthy", "edge/camera", "vision"),
+		newConnection("orphaned", "edge/crashed", "vision"),
+		newConnection("no-service", "edge/camera", "gone"),
+		// addresses aren't services
+		newConnection("address", "edge/camera", "10.0.0.1:8554"),
+	)
+	g := NewCollector(context.Background(), c, quietLogger(), 10*time.Minute, false)
+	ctx := context.Background()
+	now := time.Now()
+
+	result, err := g.Collect(ctx, now)
+	if err != nil {
+		t.Fatalf("Collect() error = %v", err)
+	}
+	if !reflect.DeepEqual(result.Stale, []string{"edge/no-service", "edge/orphaned"}) || len(result.Collected) != 0 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+
+	// the service comes back within the grace period
+	if err := c.Create(ctx, &nsmv1.NetworkService{ObjectMeta: metav1.ObjectMeta{Namespace: "edge", Name: "gone"}}); err != nil {
+		t.Fatal(err)
+	}
+	before := testutil.ToFloat64(collectedTotal.WithLabelValues("deleted"))
+	result, err = g.Collect(ctx, now.Add(10*time.Minute))
+	if err != nil {
+		t.Fatalf("Collect() error = %v", err)
+	}
+	if !reflect.DeepEqual(result.Collected, []string{"edge/orphaned"}) || len(result.Stale) != 0 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	if got := testutil.ToFloat64(collectedTotal.WithLabelValues("deleted")) - before; got != 1 {
+		t.Errorf("deleted metric grew by %v, want 1", got)
+	}
+
+	var conns nsmv1.NetworkConnectionList
+	if err := c.List(ctx, &conns); err != nil {
+		t.Fatal(err)
+	}
+	if len(conns.Items) != 4 {
+		t.Errorf("%d connections left, want 4", len(conns.Items))
+	}
+}
+
+func TestCollectDryRun(t *testing.T) {
+	c := newClient(t, newConnection("orphaned", "edge/crashed", "10.0.0.1"))
+	g := NewCollector(context.Background(), c, quietLogger(), time.Minute, true)
+	ctx := context.Background()
+	now := time.Now()
+
+	before := testutil.ToFloat64(collectedTotal.WithLabelValues("dry_run"))
+	for _, at := range []time.Time{now, now.Add(time.Minute)} {
+		if _, err := g.Collect(ctx, at); err != nil {
+			t.Fatalf("Collect() error = %v", err)
+		}
+	}
+	if got := testutil.ToFloat64(collectedTotal.WithLabelValues("dry_run")) - before; got != 1 {
+		t.Errorf("dry-run metric grew by %v, want 1", got)
+	}
+	var conn nsmv1.NetworkConnection
+	if err := c.Get(ctx, client.ObjectKey{Namespace: "edge", Name: "orphaned"}, &conn); err != nil {
+		t.Errorf("dry run deleted the connection: %v", err)
+	}
+}
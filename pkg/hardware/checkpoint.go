@@ -0,0 +1,180 @@
+package hardware
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// checkpointVersion is the version of the allocation checkpoint format
+const checkpointVersion = 1
+
+// allocationCheckpoint is the VF allocations persisted across restarts
+type allocationCheckpoint struct {
+	// Version of the format
+	Version int `json:"version"`
+	// Allocated VFs, ordered by VF
+	Allocations []allocationRecord `json:"allocations"`
+}
+
+// allocationRecord is the allocation of a VF to a pod, or the
+// configuration of a VF that its pod released and that wasn't reset yet
+type allocationRecord struct {
+	// VF (e.g., eth0-vf1)
+	VF string `json:"vf"`
+	// PF name
+	PFName string `json:"pfName"`
+	// VF ID on the PF
+	VFID int `json:"vfId"`
+	// PCI address of the VF, the allocation is dropped if the VF now has
+	// another one
+	PCIAddress string `json:"pciAddress"`
+	// Namespace of the pod
+	Namespace string `json:"namespace"`
+	// Pod holding the VF, empty for a released VF that is still configured
+	Pod string `json:"pod"`
+	// TTL of a leased allocation, 0 for pods requesting a VF with the label
+	LeaseTTLSeconds int `json:"leaseTTLSeconds,omitempty"`
+	// Network namespace and interface the VF was handed to the pod with
+	Netns        string `json:"netns,omitempty"`
+	PodInterface string `json:"podInterface,omitempty"`
+	// Host interface of the VF, the host no longer lists it while the VF
+	// is in the pod
+	InterfaceName string `json:"interfaceName,omitempty"`
+	// Configuration programmed on the PF for the VF, programmed again on
+	// restore and reset once the VF is released
+	Config *VFConfig `json:"config,omitempty"`
+}
+
+// SetStateFile makes the manager persist the VF allocations in a file and
+// restore them on start, so a restart can't hand the VF of a running pod
+// to another one. Empty keeps the allocations in memory only.
+func (m *SRIOVManager) SetStateFile(path string) {
+	m.stateFile = path
+}
+
+// checkpoint persists the allocations when they changed since the last
+// checkpoint. The mutex must be held.
+func (m *SRIOVManager) checkpoint() {
+	if m.stateFile == "" {
+		return
+	}
+
+	cp := allocationCheckpoint{Version: checkpointVersion, Allocations: []allocationRecord{}}
+	for key, vf := range m.vfInventory {
+		// released VFs keep their record until their configuration is
+		// reset, so the next pod never inherits it across a restart
+		if !vf.Allocated && vf.Config == nil {
+			continue
+		}
+		rec := allocationRecord{
+			VF:         key,
+			PFName:     vf.PFName,
+			VFID:       vf.VFID,
+			PCIAddress: vf.PCIAddress,
+			Config:     vf.Config,
+		}
+		if vf.Allocated {
+			// lease expiries aren't persisted, they change with every renewal
+			rec.Namespace = vf.Namespace
+			rec.Pod = vf.AllocatedTo
+			rec.LeaseTTLSeconds = int(vf.LeaseTTL / time.Second)
+			rec.Netns = vf.Netns
+			rec.PodInterface = vf.PodInterface
+			rec.InterfaceName = vf.InterfaceName
+		}
+		cp.Allocations = append(cp.Allocations, rec)
+	}
+	sort.Slice(cp.Allocations, func(i, j int) bool { return cp.Allocations[i].VF < cp.Allocations[j].VF })
+	data, err := json.Marshal(cp)
+	if err != nil {
+		m.logger.WithError(err).Error("Failed to encode the VF allocations")
+		return
+	}
+	if bytes.Equal(data, m.checkpointed) {
+		return
+	}
+
+	if err := writeFileAtomic(m.stateFile, data); err != nil {
+		m.logger.WithError(err).Error("Failed to persist the VF allocations")
+		return
+	}
+	m.checkpointed = data
+}
+
+// writeFileAtomic replaces a file atomically so a crash never leaves a
+// truncated file behind
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", tmp, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", path, err)
+	}
+	return nil
+}
+
+// restoreAllocations loads the persisted allocations into the inventory,
+// the discovery keeps the ones of VFs that still exist and the pods gone
+// meanwhile are freed by the next reconcile. Leases restart with a full TTL.
+// The restored VF configurations are programmed again by the next
+// reconcile, in case the PF lost them meanwhile.
+func (m *SRIOVManager) restoreAllocations() error {
+	if m.stateFile == "" {
+		return nil
+	}
+	data, err := os.ReadFile(m.stateFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read the VF allocations: %w", err)
+	}
+
+	var cp allocationCheckpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return fmt.Errorf("failed to decode the VF allocations: %w", err)
+	}
+	if cp.Version != checkpointVersion {
+		return fmt.Errorf("unsupported VF allocation checkpoint version %d", cp.Version)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	for _, rec := range cp.Allocations {
+		vf := VirtualFunction{
+			PFName:        rec.PFName,
+			VFID:          rec.VFID,
+			PCIAddress:    rec.PCIAddress,
+			Allocated:     rec.Pod != "",
+			AllocatedTo:   rec.Pod,
+			Namespace:     rec.Namespace,
+			Netns:         rec.Netns,
+			PodInterface:  rec.PodInterface,
+			InterfaceName: rec.InterfaceName,
+			Config:        rec.Config,
+		}
+		if rec.Config != nil {
+			m.replayConfig[rec.VF] = true
+		}
+		if rec.LeaseTTLSeconds > 0 {
+			vf.LeaseTTL = time.Duration(rec.LeaseTTLSeconds) * time.Second
+			vf.LeaseExpires = now.Add(vf.LeaseTTL)
+		}
+		m.vfInventory[rec.VF] = vf
+	}
+	m.checkpointed = data
+	m.logger.Infof("Restored %d VF allocations from %s", len(cp.Allocations), m.stateFile)
+	return nil
+}
@@ -0,0 +1,162 @@
+package hardware
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestSRIOVManagerRestoresAllocations(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	fs := newFakeSysfs(t)
+	fs.write("sys/class/net/eth0/device/sriov_numvfs", "3\n")
+	fs.write("sys/class/net/eth0/device/virtfn0/uevent", "PCI_SLOT_NAME=0000:3b:02.0\n")
+	fs.write("sys/class/net/eth0/device/virtfn1/uevent", "PCI_SLOT_NAME=0000:3b:02.1\n")
+	fs.write("sys/class/net/eth0/device/virtfn2/uevent", "PCI_SLOT_NAME=0000:3b:02.2\n")
+	stateFile := filepath.Join(t.TempDir(), "nsm", "sriov-allocations.json")
+
+	newManager := func(pods ...string) *SRIOVManager {
+		clientset := fake.NewSimpleClientset()
+		for _, name := range pods {
+			if err := clientset.Tracker().Add(sriovPod(name)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		m := NewSRIOVManager(context.Background(), clientset, logger)
+		m.root = fs.root
+		m.links = &recordingLinks{}
+		m.SetStateFile(stateFile)
+		if err := m.restoreAllocations(); err != nil {
+			t.Fatalf("restoreAllocations() error = %v", err)
+		}
+		if err := m.discoverVirtualFunctions(); err != nil {
+			t.Fatalf("discoverVirtualFunctions() error = %v", err)
+		}
+		if err := m.reconcileAllocations(); err != nil {
+			t.Fatalf("reconcileAllocations() error = %v", err)
+		}
+		return m
+	}
+
+	m := newManager("lidar")
+	if _, err := m.LeaseVF("jobs", "batch", time.Hour); err != nil {
+		t.Fatalf("LeaseVF() error = %v", err)
+	}
+	m.SetAttachment("edge", "lidar", "/var/run/netns/lidar", "net1")
+	if _, err := os.Stat(stateFile); err != nil {
+		t.Fatalf("allocations not persisted: %v", err)
+	}
+
+	// the restarted manager keeps the VFs of the running pods, the new
+	// pod gets the free one instead of the lowest address
+	m = newManager("camera", "lidar")
+	if vf, ok := m.GetVFForPod("edge", "lidar"); !ok || vf.VFID != 0 || vf.Netns != "/var/run/netns/lidar" {
+		t.Errorf("GetVFForPod() = %+v, %t after the restart, want VF 0 with its attachment", vf, ok)
+	}
+	if vf, ok := m.GetVFForPod("edge", "camera"); !ok || vf.VFID != 2 {
+		t.Errorf("GetVFForPod() = %+v, %t, want the free VF 2", vf, ok)
+	}
+	leases := m.Leases()
+	if len(leases) != 1 || leases[0].Pod != "batch" || leases[0].TTLSeconds != 3600 || !leases[0].Expires.After(time.Now()) {
+		t.Errorf("Leases() = %+v after the restart, want the lease restored", leases)
+	}
+
+	// pods gone meanwhile free their VF, VFs now at another address
+	// lose their allocation
+	fs.write("sys/class/net/eth0/device/virtfn2/uevent", "PCI_SLOT_NAME=0000:3b:03.2\n")
+	m = newManager()
+	if _, ok := m.GetVFForPod("edge", "lidar"); ok {
+		t.Error("VF of the deleted pod restored")
+	}
+	if _, ok := m.GetVFForPod("edge", "camera"); ok {
+		t.Error("allocation restored on a VF with another PCI address")
+	}
+}
+
+func TestSRIOVManagerRestoresVFConfig(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	fs := newFakeSysfs(t)
+	fs.write("sys/class/net/eth0/device/sriov_numvfs", "1\n")
+	fs.write("sys/class/net/eth0/device/virtfn0/uevent", "PCI_SLOT_NAME=0000:3b:02.0\n")
+	stateFile := filepath.Join(t.TempDir(), "sriov-allocations.json")
+	camera := sriovPod("camera")
+	camera.Annotations = map[string]string{AnnotationVFConfig: "vlan=100"}
+
+	newManager := func(pods ...*corev1.Pod) (*SRIOVManager, *recordingConfigurer) {
+		clientset := fake.NewSimpleClientset()
+		for _, pod := range pods {
+			if err := clientset.Tracker().Add(pod); err != nil {
+				t.Fatal(err)
+			}
+		}
+		m := NewSRIOVManager(context.Background(), clientset, logger)
+		m.root = fs.root
+		m.links = &recordingLinks{}
+		configurer := &recordingConfigurer{}
+		m.SetVFConfigurer(configurer)
+		m.SetStateFile(stateFile)
+		if err := m.restoreAllocations(); err != nil {
+			t.Fatalf("restoreAllocations() error = %v", err)
+		}
+		if err := m.discoverVirtualFunctions(); err != nil {
+			t.Fatalf("discoverVirtualFunctions() error = %v", err)
+		}
+		if err := m.reconcileAllocations(); err != nil {
+			t.Fatalf("reconcileAllocations() error = %v", err)
+		}
+		return m, configurer
+	}
+
+	if _, configurer := newManager(camera); len(configurer.calls) != 1 {
+		t.Fatalf("calls = %v, want the VF of the pod configured", configurer.calls)
+	}
+
+	// the restarted manager programs the restored configuration once more
+	m, configurer := newManager(camera)
+	if vf, _ := m.GetVFForPod("edge", "camera"); vf.Config == nil || vf.Config.VLAN != 100 {
+		t.Errorf("Config = %+v after the restart, want VLAN 100", vf.Config)
+	}
+	if len(configurer.calls) != 1 || !strings.Contains(configurer.calls[0], "vlan=100") {
+		t.Errorf("calls = %v, want the configuration replayed", configurer.calls)
+	}
+	configurer.calls = nil
+	if err := m.reconcileAllocations(); err != nil {
+		t.Fatalf("reconcileAllocations() error = %v", err)
+	}
+	if len(configurer.calls) != 0 {
+		t.Errorf("replayed configuration programmed again: %v", configurer.calls)
+	}
+
+	// the VF of a pod deleted during the restart is reset
+	_, configurer = newManager()
+	if len(configurer.calls) != 1 || !strings.Contains(configurer.calls[0], "vlan=0") {
+		t.Errorf("calls = %v, want the VF of the deleted pod reset", configurer.calls)
+	}
+	if _, configurer = newManager(); len(configurer.calls) != 0 {
+		t.Errorf("calls = %v, want nothing left to reset", configurer.calls)
+	}
+}
+
+func TestSRIOVManagerRestoreRejectsUnknownVersion(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	stateFile := filepath.Join(t.TempDir(), "sriov-allocations.json")
+	if err := os.WriteFile(stateFile, []byte(`{"version":2,"allocations":[]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	m := NewSRIOVManager(context.Background(), nil, logger)
+	m.SetStateFile(stateFile)
+	if err := m.restoreAllocations(); err == nil {
+		t.Error("restoreAllocations() accepted an unknown checkpoint version")
+	}
+}
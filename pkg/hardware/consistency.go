@@ -0,0 +1,304 @@
+package hardware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/akos011221/nsm/pkg/netutil"
+	"github.com/akos011221/nsm/pkg/watchdog"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Kinds of inventory inconsistencies
+const (
+	// IssuePodMissing is a VF allocated to a pod that doesn't exist
+	IssuePodMissing = "PodMissing"
+	// IssueNetnsMissing is a VF handed to a pod whose network namespace is
+	// gone, the VF interface is back on the host
+	IssueNetnsMissing = "NetnsMissing"
+	// IssueInterfaceMissing is a VF on the host whose interface ip link
+	// doesn't know
+	IssueInterfaceMissing = "InterfaceMissing"
+	// IssueVFMissing is a VF in the inventory that sysfs no longer has
+	IssueVFMissing = "VFMissing"
+	// IssueNumVFsMismatch is a PF whose VF count in sysfs differs from the
+	// inventory
+	IssueNumVFsMismatch = "NumVFsMismatch"
+)
+
+// Inconsistency is a difference between the VF inventory and the node
+type Inconsistency struct {
+	// Kind (PodMissing, NetnsMissing, InterfaceMissing, VFMissing, NumVFsMismatch)
+	Kind string `json:"kind"`
+	// VF (e.g., eth0-vf1), empty for PF issues
+	VF string `json:"vf,omitempty"`
+	// PF name
+	PF string `json:"pf"`
+	// Pod holding the VF, if any
+	Namespace string `json:"namespace,omitempty"`
+	Pod       string `json:"pod,omitempty"`
+	// What was found
+	Message string `json:"message"`
+	// Repair suggested
+	Repair string `json:"repair"`
+	// Whether the repair can be applied automatically
+	AutoRepair bool `json:"autoRepair"`
+	// Whether the repair was applied
+	Repaired bool `json:"repaired"`
+}
+
+// ConsistencyReport is the outcome of a check of the VF inventory
+type ConsistencyReport struct {
+	// Time of the check
+	CheckedAt time.Time `json:"checkedAt"`
+	// VFs and PFs checked
+	VFs int `json:"vfs"`
+	PFs int `json:"pfs"`
+	// Inconsistencies found, ordered by PF and VF
+	Inconsistencies []Inconsistency `json:"inconsistencies"`
+}
+
+// CheckConsistency cross-validates the VF inventory against sysfs, the
+// links of the host and the pods, and applies the automatic repairs if
+// repair is set. The PFs being reset are skipped.
+func (m *SRIOVManager) CheckConsistency(repair bool) (*ConsistencyReport, error) {
+	m.mu.RLock()
+	pfs := make([]PhysicalFunction, 0, len(m.pfInventory))
+	for name, pf := range m.pfInventory {
+		if !m.resetting[name] {
+			pfs = append(pfs, pf)
+		}
+	}
+	vfs := make(map[string]VirtualFunction, len(m.vfInventory))
+	for key, vf := range m.vfInventory {
+		if !m.resetting[vf.PFName] {
+			vfs[key] = vf
+		}
+	}
+	m.mu.RUnlock()
+
+	report := &ConsistencyReport{CheckedAt: time.Now(), VFs: len(vfs), PFs: len(pfs)}
+	for _, pf := range pfs {
+		if issue, ok := m.checkPF(pf); ok {
+			report.Inconsistencies = append(report.Inconsistencies, issue)
+		}
+	}
+	for key, vf := range vfs {
+		issues, err := m.checkVF(key, vf)
+		if err != nil {
+			return nil, err
+		}
+		report.Inconsistencies = append(report.Inconsistencies, issues...)
+	}
+	sort.Slice(report.Inconsistencies, func(i, j int) bool {
+		a, b := report.Inconsistencies[i], report.Inconsistencies[j]
+		if a.PF != b.PF {
+			return a.PF < b.PF
+		}
+		if a.VF != b.VF {
+			return a.VF < b.VF
+		}
+		return a.Kind < b.Kind
+	})
+
+	if repair {
+		m.repair(report)
+	}
+	return report, nil
+}
+
+// checkPF compares the VF count of a PF in sysfs with the inventory
+func (m *SRIOVManager) checkPF(pf PhysicalFunction) (Inconsistency, bool) {
+	issue := Inconsistency{
+		Kind:       IssueNumVFsMismatch,
+		PF:         pf.Name,
+		Repair:     "rediscover the VFs",
+		AutoRepair: true,
+	}
+	data, err := os.ReadFile(m.path("sys/class/net", pf.Name, "device/sriov_numvfs"))
+	if err != nil {
+		issue.Message = fmt.Sprintf("%d VFs in the inventory, sriov_numvfs unreadable: %v", pf.NumVFs, err)
+		return issue, true
+	}
+	numVFs, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || numVFs != pf.NumVFs {
+		issue.Message = fmt.Sprintf("%d VFs in the inventory, sriov_numvfs is %q", pf.NumVFs, strings.TrimSpace(string(data)))
+		return issue, true
+	}
+	return Inconsistency{}, false
+}
+
+// checkVF checks a VF against sysfs, the links of the host and its pod
+func (m *SRIOVManager) checkVF(key string, vf VirtualFunction) ([]Inconsistency, error) {
+	var issues []Inconsistency
+	issue := func(kind, message, repair string, auto bool) {
+		issues = append(issues, Inconsistency{
+			Kind:       kind,
+			VF:         key,
+			PF:         vf.PFName,
+			Namespace:  vf.Namespace,
+			Pod:        vf.AllocatedTo,
+			Message:    message,
+			Repair:     repair,
+			AutoRepair: auto,
+		})
+	}
+
+	if _, err := os.Stat(m.path("sys/class/net", vf.PFName, fmt.Sprintf("device/virtfn%d", vf.VFID))); os.IsNotExist(err) {
+		issue(IssueVFMissing, fmt.Sprintf("VF %d of %s not in sysfs", vf.VFID, vf.PFName), "rediscover the VFs", true)
+		return issues, nil
+	}
+
+	// leased VFs are freed once their lease expires
+	if vf.Allocated && vf.LeaseTTL == 0 {
+		_, err := m.clientset.CoreV1().Pods(vf.Namespace).Get(m.ctx, vf.AllocatedTo, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			issue(IssuePodMissing, fmt.Sprintf("allocated to pod %s/%s, which doesn't exist", vf.Namespace, vf.AllocatedTo), "free the VF", true)
+			return issues, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get pod %s/%s: %w", vf.Namespace, vf.AllocatedTo, err)
+		}
+	}
+
+	if vf.Netns != "" {
+		if _, err := os.Stat(m.path(vf.Netns)); os.IsNotExist(err) {
+			issue(IssueNetnsMissing, fmt.Sprintf("handed to pod %s/%s in %s, which is gone", vf.Namespace, vf.AllocatedTo, vf.Netns),
+				"forget the attachment, the VF interface is back on the host", true)
+		}
+		return issues, nil
+	}
+
+	if vf.InterfaceName != "" {
+		if _, err := m.links.LinkByName(vf.InterfaceName); errors.Is(err, netutil.ErrNotFound) {
+			issue(IssueInterfaceMissing, fmt.Sprintf("interface %s not found", vf.InterfaceName),
+				"check the driver the VF is bound to and whether its interface was renamed", false)
+		}
+	}
+	return issues, nil
+}
+
+// repair applies the automatic repairs of the inconsistencies found
+func (m *SRIOVManager) repair(report *ConsistencyReport) {
+	rediscover := false
+
+	m.mu.Lock()
+	for i := range report.Inconsistencies {
+		issue := &report.Inconsistencies[i]
+		if !issue.AutoRepair {
+			continue
+		}
+		switch issue.Kind {
+		case IssueVFMissing, IssueNumVFsMismatch:
+			rediscover, issue.Repaired = true, true
+
+		case IssuePodMissing:
+			// the VF may have moved on since the check
+			vf, ok := m.vfInventory[issue.VF]
+			if !ok || !vf.Allocated || vf.AllocatedTo != issue.Pod || vf.Namespace != issue.Namespace {
+				continue
+			}
+			m.vfInventory[issue.VF] = freeVF(vf)
+			vfReleases.WithLabelValues(vf.PFName, releasePodGone).Inc()
+			issue.Repaired = true
+			m.logger.Warnf("Freed VF %s of pod %s/%s, which doesn't exist", issue.VF, issue.Namespace, issue.Pod)
+			m.decisions.record(issue.Namespace, issue.Pod, Decision{
+				Reason:     ReasonPodGone,
+				Message:    fmt.Sprintf("inventory check found the pod gone, freed VF %s", issue.VF),
+				VF:         issue.VF,
+				PCIAddress: vf.PCIAddress,
+			}, report.CheckedAt)
+
+		case IssueNetnsMissing:
+			vf, ok := m.vfInventory[issue.VF]
+			if !ok || vf.Netns == "" || vf.AllocatedTo != issue.Pod || vf.Namespace != issue.Namespace {
+				continue
+			}
+			vf.Netns, vf.PodInterface = "", ""
+			m.vfInventory[issue.VF] = vf
+			issue.Repaired = true
+			m.logger.Warnf("Forgot the attachment of VF %s to pod %s/%s, its network namespace is gone", issue.VF, issue.Namespace, issue.Pod)
+		}
+	}
+	m.checkpoint()
+	m.mu.Unlock()
+
+	if rediscover {
+		m.Resync()
+	}
+}
+
+// ConsistencyChecker checks the VF inventory periodically, logging the
+// inconsistencies found and repairing them if enabled
+type ConsistencyChecker struct {
+	// Context for cancellation
+	ctx context.Context
+	// SR-IOV manager whose inventory is checked
+	manager *SRIOVManager
+	// Interval between checks
+	interval time.Duration
+	// Whether the automatic repairs are applied
+	repair bool
+	// Progress signal for the watchdog
+	heartbeat *watchdog.Heartbeat
+}
+
+// NewConsistencyChecker creates a checker of the inventory of the manager
+func NewConsistencyChecker(ctx context.Context, m *SRIOVManager, interval time.Duration, repair bool) *ConsistencyChecker {
+	return &ConsistencyChecker{ctx: ctx, manager: m, interval: interval, repair: repair}
+}
+
+// SetHeartbeat makes the checker report its progress to the watchdog
+func (c *ConsistencyChecker) SetHeartbeat(hb *watchdog.Heartbeat) {
+	c.heartbeat = hb
+	hb.Expect(c.interval)
+}
+
+// Start checks the inventory periodically
+func (c *ConsistencyChecker) Start() error {
+	m := c.manager
+	m.logger.Infof("Starting VF inventory consistency checks every %s (repairs %t)", c.interval, c.repair)
+
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.heartbeat.Beat()
+			c.Check()
+
+		case <-c.ctx.Done():
+			m.logger.Info("Stopping VF inventory consistency checks")
+			return nil
+		}
+	}
+}
+
+// Check checks the inventory once and logs the inconsistencies found
+func (c *ConsistencyChecker) Check() {
+	m := c.manager
+	report, err := m.CheckConsistency(c.repair)
+	if err != nil {
+		m.logger.WithError(err).Warn("VF inventory consistency check failed")
+		return
+	}
+	for _, issue := range report.Inconsistencies {
+		entry := m.logger.WithField("kind", issue.Kind).WithField("pf", issue.PF)
+		if issue.VF != "" {
+			entry = entry.WithField("vf", issue.VF)
+		}
+		if issue.Repaired {
+			entry.Infof("Repaired VF inventory inconsistency: %s", issue.Message)
+			continue
+		}
+		entry.Warnf("VF inventory inconsistency: %s, suggested repair: %s", issue.Message, issue.Repair)
+	}
+}
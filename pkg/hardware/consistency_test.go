@@ -0,0 +1,93 @@
+package hardware
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestSRIOVManagerCheckConsistency(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	fs := newFakeSysfs(t)
+	// eth0 went from 4 to 3 VFs behind the back of the manager
+	fs.write("sys/class/net/eth0/device/sriov_numvfs", "3\n")
+	for i, addr := range []string{"0000:3b:02.0", "0000:3b:02.1", "0000:3b:02.2"} {
+		fs.write(fmt.Sprintf("sys/class/net/eth0/device/virtfn%d/uevent", i), "PCI_SLOT_NAME="+addr+"\n")
+	}
+	fs.mkdir("var/run/netns/camera")
+
+	m := NewSRIOVManager(context.Background(), fake.NewSimpleClientset(sriovPod("camera"), sriovPod("lidar")), logger)
+	m.root = fs.root
+	m.links = &recordingLinks{missing: map[string]bool{"eth0_vf2": true}}
+	m.pfInventory = map[string]PhysicalFunction{"eth0": {Name: "eth0", NumVFs: 4}}
+	m.vfInventory = map[string]VirtualFunction{
+		"eth0-vf0": {PFName: "eth0", VFID: 0, InterfaceName: "eth0_vf0", Allocated: true, AllocatedTo: "camera", Namespace: "edge", Netns: "/var/run/netns/camera", PodInterface: "net1"},
+		"eth0-vf1": {PFName: "eth0", VFID: 1, InterfaceName: "eth0_vf1", Allocated: true, AllocatedTo: "radar", Namespace: "edge"},
+		"eth0-vf2": {PFName: "eth0", VFID: 2, InterfaceName: "eth0_vf2", Allocated: true, AllocatedTo: "lidar", Namespace: "edge", Netns: "/var/run/netns/lidar", PodInterface: "net1"},
+		"eth0-vf3": {PFName: "eth0", VFID: 3, InterfaceName: "eth0_vf3"},
+		// leaseholders missing are left to the lease expiry
+		"eth1-vf0": {PFName: "eth1", VFID: 0},
+	}
+	m.resetting["eth1"] = true
+
+	report, err := m.CheckConsistency(false)
+	if err != nil {
+		t.Fatalf("CheckConsistency() error = %v", err)
+	}
+	var kinds []string
+	for _, issue := range report.Inconsistencies {
+		kinds = append(kinds, issue.VF+" "+issue.Kind)
+		if issue.Repaired {
+			t.Errorf("%s repaired without repairs enabled", issue.VF)
+		}
+	}
+	want := []string{" NumVFsMismatch", "eth0-vf1 PodMissing", "eth0-vf2 NetnsMissing", "eth0-vf3 VFMissing"}
+	if !reflect.DeepEqual(kinds, want) {
+		t.Fatalf("inconsistencies = %v, want %v", kinds, want)
+	}
+	if report.VFs != 4 || report.PFs != 1 {
+		t.Errorf("checked %d VFs and %d PFs, want 4 and 1 outside the reset PF", report.VFs, report.PFs)
+	}
+	if vf := m.vfInventory["eth0-vf1"]; !vf.Allocated {
+		t.Fatalf("VF of the missing pod freed without repairs enabled")
+	}
+
+	if _, err := m.CheckConsistency(true); err != nil {
+		t.Fatalf("CheckConsistency() error = %v", err)
+	}
+	if vf := m.vfInventory["eth0-vf1"]; vf.Allocated {
+		t.Errorf("VF of the missing pod still allocated: %+v", vf)
+	}
+	if exp, _ := m.Explain("edge", "radar"); len(exp.Decisions) != 1 || exp.Decisions[0].Reason != ReasonPodGone {
+		t.Errorf("unexpected explanation of the missing pod: %+v", exp)
+	}
+	if vf := m.vfInventory["eth0-vf2"]; !vf.Allocated || vf.Netns != "" || vf.PodInterface != "" {
+		t.Errorf("attachment to the gone namespace not forgotten: %+v", vf)
+	}
+	select {
+	case <-m.wake:
+	default:
+		t.Errorf("sysfs inconsistencies did not trigger a rediscovery")
+	}
+
+	// back on the host, the VF interface is checked
+	report, err = m.CheckConsistency(false)
+	if err != nil {
+		t.Fatalf("CheckConsistency() error = %v", err)
+	}
+	var found bool
+	for _, issue := range report.Inconsistencies {
+		if issue.VF == "eth0-vf2" {
+			found = issue.Kind == IssueInterfaceMissing && !issue.AutoRepair
+		}
+	}
+	if !found {
+		t.Errorf("missing interface not reported: %+v", report.Inconsistencies)
+	}
+}
@@ -0,0 +1,139 @@
+package hardware
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Reasons of the allocation decisions
+const (
+	// ReasonAllocated means a free VF was allocated to the pod
+	ReasonAllocated = "Allocated"
+	// ReasonAlreadyAllocated means the pod keeps the VF it holds
+	ReasonAlreadyAllocated = "AlreadyAllocated"
+	// ReasonNoFreeVF means every VF of the node is allocated or none was discovered
+	ReasonNoFreeVF = "NoFreeVF"
+	// ReasonNoMatchingVF means free VFs exist but too few match the PF,
+	// NUMA node or driver the pod requests
+	ReasonNoMatchingVF = "NoMatchingVF"
+	// ReasonInvalidSelector means the VF constraints of the pod can't be
+	// parsed, it gets no VF until they are fixed
+	ReasonInvalidSelector = "InvalidSelector"
+	// ReasonPodTerminating means the pod is terminating and gets no VF
+	ReasonPodTerminating = "PodTerminating"
+	// ReasonPodGone means the pod no longer requests a VF and its VF was freed
+	ReasonPodGone = "PodGone"
+	// ReasonReleased means the VF of the pod was released explicitly
+	ReasonReleased = "Released"
+	// ReasonLeased means a free VF was leased to the pod through the API
+	ReasonLeased = "Leased"
+	// ReasonLeaseExpired means the lease of the pod was neither renewed nor
+	// kept alive by the pod, and its VF was freed
+	ReasonLeaseExpired = "LeaseExpired"
+	// ReasonHardwareRemoved means the VF of the pod was hot-unplugged
+	ReasonHardwareRemoved = "HardwareRemoved"
+)
+
+// Number of decisions kept per pod
+const maxDecisionsPerPod = 10
+
+// Time the decisions of a pod are kept after the last one
+const decisionRetention = time.Hour
+
+// Decision records why the allocator did or didn't give a pod a VF
+type Decision struct {
+	// Reason code (e.g., NoFreeVF)
+	Reason string `json:"reason"`
+	// Human-readable explanation
+	Message string `json:"message"`
+	// Whether the pod holds a VF after the decision
+	Allocated bool `json:"allocated"`
+	// VF the decision is about (e.g., eth0-vf1), empty if none
+	VF string `json:"vf,omitempty"`
+	// PCI address of the VF
+	PCIAddress string `json:"pciAddress,omitempty"`
+	// First time the decision was made
+	Since time.Time `json:"since"`
+	// Last time the decision was made, repeated decisions are merged
+	LastSeen time.Time `json:"lastSeen"`
+}
+
+// Explanation is the allocation history of a pod
+type Explanation struct {
+	// Namespace of the pod
+	Namespace string `json:"namespace"`
+	// Name of the pod
+	Pod string `json:"pod"`
+	// Whether the pod holds a VF
+	Allocated bool `json:"allocated"`
+	// Decisions on the pod, newest first
+	Decisions []Decision `json:"decisions"`
+}
+
+// decisionLog keeps the latest allocation decisions per pod
+type decisionLog map[string][]Decision
+
+// record adds a decision of a pod, merging it into the latest one if the
+// allocator decided the same again
+func (l decisionLog) record(namespace, pod string, d Decision, now time.Time) {
+	key := namespace + "/" + pod
+	d.Since, d.LastSeen = now, now
+
+	decisions := l[key]
+	if n := len(decisions); n > 0 && decisions[n-1].Reason == d.Reason && decisions[n-1].VF == d.VF {
+		decisions[n-1].Message = d.Message
+		decisions[n-1].LastSeen = now
+		return
+	}
+	decisions = append(decisions, d)
+	if len(decisions) > maxDecisionsPerPod {
+		decisions = decisions[len(decisions)-maxDecisionsPerPod:]
+	}
+	l[key] = decisions
+}
+
+// prune forgets the pods without decisions for the retention period
+func (l decisionLog) prune(now time.Time) {
+	for key, decisions := range l {
+		if now.Sub(decisions[len(decisions)-1].LastSeen) > decisionRetention {
+			delete(l, key)
+		}
+	}
+}
+
+// explain returns the decisions of a pod, newest first
+func (l decisionLog) explain(namespace, pod string) (Explanation, bool) {
+	decisions, ok := l[namespace+"/"+pod]
+	if !ok {
+		return Explanation{}, false
+	}
+	exp := Explanation{
+		Namespace: namespace,
+		Pod:       pod,
+		Allocated: decisions[len(decisions)-1].Allocated,
+		Decisions: make([]Decision, 0, len(decisions)),
+	}
+	for i := len(decisions) - 1; i >= 0; i-- {
+		exp.Decisions = append(exp.Decisions, decisions[i])
+	}
+	return exp, true
+}
+
+// noFreeVFMessage describes the VF usage per PF when none is free
+func noFreeVFMessage(inventory map[string]VirtualFunction) string {
+	if len(inventory) == 0 {
+		return "no SR-IOV VFs discovered on the node"
+	}
+	total := make(map[string]int)
+	for _, vf := range inventory {
+		total[vf.PFName]++
+	}
+	pfs := make([]string, 0, len(total))
+	for pf := range total {
+		pfs = append(pfs, fmt.Sprintf("%s %d/%d allocated", pf, total[pf], total[pf]))
+	}
+	sort.Strings(pfs)
+	return "no free VFs (" + strings.Join(pfs, ", ") + ")"
+}
@@ -0,0 +1,496 @@
+package hardware
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/akos011221/nsm/pkg/watchdog"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Userspace drivers DPDK poll-mode devices are bound to
+const (
+	// DriverVFIOPCI is the VFIO driver, it needs a working IOMMU
+	DriverVFIOPCI = "vfio-pci"
+	// DriverIGBUIO is the out-of-tree UIO driver for nodes without an IOMMU
+	DriverIGBUIO = "igb_uio"
+)
+
+// LabelDPDK marks the pods requesting a dedicated poll-mode interface
+const LabelDPDK = "network.nsm.akosrbn.io/dpdk"
+
+// PCI class code prefix of network controllers
+const pciClassNetwork = "0x02"
+
+// Hugepages is the hugepage pool of one page size
+type Hugepages struct {
+	// Page size in kB (e.g., 2048)
+	SizeKB int `json:"sizeKB"`
+	// Pages reserved on the node
+	Total int `json:"total"`
+	// Pages not mapped by any process
+	Free int `json:"free"`
+	// Pages requested by the pods holding a DPDK device
+	Accounted int `json:"accounted"`
+	// Pool by NUMA node, empty without NUMA information
+	Nodes []NUMAHugepages `json:"nodes,omitempty"`
+}
+
+// DPDKDevice represents a NIC bound to a userspace driver
+type DPDKDevice struct {
+	// PCI address of the device
+	PCIAddress string `json:"pciAddress"`
+	// Userspace driver the device is bound to (vfio-pci, igb_uio)
+	Driver string `json:"driver"`
+	// PCI vendor ID (e.g., 0x8086)
+	Vendor string `json:"vendor,omitempty"`
+	// PCI device ID
+	Device string `json:"device,omitempty"`
+	// NUMA node of the device, -1 if unknown
+	NUMANode int `json:"numaNode"`
+	// IOMMU group of the device, empty without an IOMMU
+	IOMMUGroup string `json:"iommuGroup,omitempty"`
+	// Whether the device is allocated
+	Allocated bool `json:"allocated"`
+	// Pod using this device, if any
+	AllocatedTo string `json:"allocatedTo,omitempty"`
+	// Namespace of the pod using this device
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// DPDKInventory is the DPDK state of the node
+type DPDKInventory struct {
+	// Userspace driver the configured NICs are bound to
+	Driver string `json:"driver"`
+	// Hugepage pools of the node
+	Hugepages []Hugepages `json:"hugepages"`
+	// Hugepages accounted to the pods holding a device
+	Pods []PodHugepages `json:"pods,omitempty"`
+	// Devices bound to a userspace driver, ordered by PCI address
+	Devices []DPDKDevice `json:"devices"`
+}
+
+// DPDKManager manages the NICs handed to pods as dedicated poll-mode
+// interfaces. It binds the configured NICs to a userspace driver, keeps an
+// inventory of the devices bound to one and allocates them to the pods
+// labeled for DPDK, like the SRIOVManager does with the VFs. The hugepages
+// the pods request are accounted against the pools of the node, a pod whose
+// pages are no longer left gets no device.
+type DPDKManager struct {
+	// Context for cancellation
+	ctx context.Context
+	// Kubernetes client
+	clientset kubernetes.Interface
+	// Logger
+	logger *logrus.Logger
+	// Root of the filesystem sysfs is mounted under
+	root string
+	// Userspace driver the devices are bound to
+	driver string
+	// PCI addresses of the NICs to bind to the driver
+	bind []string
+	// DPDK device inventory, by PCI address
+	inventory map[string]DPDKDevice
+	// Hugepage pools of the node, by page size
+	hugepages []Hugepages
+	// Pages accounted to the pods holding a device, by pod and page size
+	podPages map[string]map[int]int
+	// Mutex for protecting the inventory and the hugepages
+	mu sync.RWMutex
+	// Kubernetes Node the hugepage condition is set on, none if empty
+	nodeName string
+	// Hugepage condition last set on the Node
+	condition *corev1.NodeCondition
+	// Poll interval for device discovery
+	pollInterval time.Duration
+	// Progress signal for the watchdog
+	heartbeat *watchdog.Heartbeat
+}
+
+// NewDPDKManager creates a new DPDK manager binding the NICs at the PCI
+// addresses to the driver
+func NewDPDKManager(ctx context.Context, clientset kubernetes.Interface, logger *logrus.Logger, driver string, bind []string) *DPDKManager {
+	return &DPDKManager{
+		ctx:          ctx,
+		clientset:    clientset,
+		logger:       logger,
+		root:         "/",
+		driver:       driver,
+		bind:         bind,
+		inventory:    make(map[string]DPDKDevice),
+		podPages:     make(map[string]map[int]int),
+		pollInterval: 30 * time.Second,
+	}
+}
+
+// SetHeartbeat makes the manager report its progress to the watchdog
+func (m *DPDKManager) SetHeartbeat(hb *watchdog.Heartbeat) {
+	m.heartbeat = hb
+	hb.Expect(m.pollInterval)
+}
+
+// Start binds the NICs and begins the DPDK manager's operation
+func (m *DPDKManager) Start() error {
+	m.logger.Info("Starting DPDK Manager")
+
+	for _, addr := range m.bind {
+		if err := m.bindDevice(addr); err != nil {
+			m.logger.WithError(err).Errorf("Failed to bind %s to %s", addr, m.driver)
+		}
+	}
+	m.sync()
+
+	ticker := time.NewTicker(m.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			m.heartbeat.Beat()
+			m.sync()
+
+		case <-m.ctx.Done():
+			m.logger.Info("Stopping DPDK Manager")
+			return nil
+		}
+	}
+}
+
+// sync rediscovers the hugepages and the devices and reconciles their allocations
+func (m *DPDKManager) sync() {
+	if err := m.discoverHugepages(); err != nil {
+		m.logger.WithError(err).Error("Hugepage discovery failed")
+	}
+
+	if err := m.discoverDevices(); err != nil {
+		m.logger.WithError(err).Error("DPDK device discovery failed")
+		return
+	}
+
+	if err := m.reconcileAllocations(); err != nil {
+		m.logger.WithError(err).Error("DPDK allocation reconciliation failed")
+	}
+
+	if err := m.publishCondition(); err != nil {
+		m.logger.WithError(err).Error("Failed to publish the hugepage condition")
+	}
+}
+
+// path joins a sysfs path to the root
+func (m *DPDKManager) path(elem ...string) string {
+	return filepath.Join(append([]string{m.root}, elem...)...)
+}
+
+// bindDevice binds the NIC at a PCI address to the userspace driver: the
+// driver override makes the kernel probe only that driver, the NIC is
+// unbound from its kernel driver and probed again
+func (m *DPDKManager) bindDevice(addr string) error {
+	dev := m.path("sys/bus/pci/devices", addr)
+	class, err := os.ReadFile(filepath.Join(dev, "class"))
+	if err != nil {
+		return fmt.Errorf("failed to read the PCI class: %w", err)
+	}
+	if !strings.HasPrefix(strings.TrimSpace(string(class)), pciClassNetwork) {
+		return fmt.Errorf("not a network controller (class %s)", strings.TrimSpace(string(class)))
+	}
+
+	current := ""
+	if target, err := os.Readlink(filepath.Join(dev, "driver")); err == nil {
+		current = filepath.Base(target)
+	}
+	if current == m.driver {
+		return nil
+	}
+
+	if err := os.WriteFile(filepath.Join(dev, "driver_override"), []byte(m.driver), 0o644); err != nil {
+		return fmt.Errorf("failed to set the driver override: %w", err)
+	}
+	if current != "" {
+		if err := os.WriteFile(filepath.Join(dev, "driver", "unbind"), []byte(addr), 0o644); err != nil {
+			return fmt.Errorf("failed to unbind from %s: %w", current, err)
+		}
+	}
+	if err := os.WriteFile(m.path("sys/bus/pci/drivers_probe"), []byte(addr), 0o644); err != nil {
+		return fmt.Errorf("failed to probe %s: %w", m.driver, err)
+	}
+
+	m.logger.Infof("Bound %s to %s (was %s)", addr, m.driver, current)
+	return nil
+}
+
+// discoverHugepages reads the hugepage pools of the node
+func (m *DPDKManager) discoverHugepages() error {
+	pools, err := filepath.Glob(m.path("sys/kernel/mm/hugepages/hugepages-*kB"))
+	if err != nil {
+		return fmt.Errorf("failed to glob hugepage pools: %w", err)
+	}
+
+	var hugepages []Hugepages
+	for _, pool := range pools {
+		var hp Hugepages
+		if _, err := fmt.Sscanf(filepath.Base(pool), "hugepages-%dkB", &hp.SizeKB); err != nil {
+			continue
+		}
+		hp.Total = readInt(filepath.Join(pool, "nr_hugepages"), 0)
+		hp.Free = readInt(filepath.Join(pool, "free_hugepages"), 0)
+		hp.Nodes = m.discoverNUMAHugepages(hp.SizeKB)
+		hugepages = append(hugepages, hp)
+	}
+	sort.Slice(hugepages, func(i, j int) bool { return hugepages[i].SizeKB < hugepages[j].SizeKB })
+
+	m.mu.Lock()
+	m.hugepages = hugepages
+	m.mu.Unlock()
+	return nil
+}
+
+// discoverDevices scans the PCI bus for NICs bound to a userspace driver
+func (m *DPDKManager) discoverDevices() error {
+	devices, err := filepath.Glob(m.path("sys/bus/pci/devices/*"))
+	if err != nil {
+		return fmt.Errorf("failed to glob PCI devices: %w", err)
+	}
+
+	newInventory := make(map[string]DPDKDevice)
+	for _, dev := range devices {
+		target, err := os.Readlink(filepath.Join(dev, "driver"))
+		if err != nil {
+			continue
+		}
+		driver := filepath.Base(target)
+		if driver != DriverVFIOPCI && driver != DriverIGBUIO {
+			continue
+		}
+		if !strings.HasPrefix(readString(filepath.Join(dev, "class")), pciClassNetwork) {
+			continue
+		}
+
+		d := DPDKDevice{
+			PCIAddress: filepath.Base(dev),
+			Driver:     driver,
+			Vendor:     readString(filepath.Join(dev, "vendor")),
+			Device:     readString(filepath.Join(dev, "device")),
+			NUMANode:   readInt(filepath.Join(dev, "numa_node"), -1),
+		}
+		if group, err := os.Readlink(filepath.Join(dev, "iommu_group")); err == nil {
+			d.IOMMUGroup = filepath.Base(group)
+		}
+		newInventory[d.PCIAddress] = d
+	}
+
+	// keep the allocations of the devices still bound
+	m.mu.Lock()
+	for addr, d := range newInventory {
+		if existing, ok := m.inventory[addr]; ok && existing.Allocated {
+			d.Allocated = true
+			d.AllocatedTo = existing.AllocatedTo
+			d.Namespace = existing.Namespace
+			newInventory[addr] = d
+		}
+	}
+	m.inventory = newInventory
+	m.mu.Unlock()
+
+	m.logger.WithField("deviceCount", len(newInventory)).Info("DPDK device discovery completed")
+	return nil
+}
+
+// reconcileAllocations reconciles device allocations with pods that request them
+func (m *DPDKManager) reconcileAllocations() error {
+	pods, err := m.clientset.CoreV1().Pods("").List(m.ctx, metav1.ListOptions{
+		LabelSelector: LabelDPDK + "=true",
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list pods requesting DPDK: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// free the devices of the pods that are gone
+	requesting := make(map[string]bool, len(pods.Items))
+	for _, pod := range pods.Items {
+		requesting[pod.Namespace+"/"+pod.Name] = true
+	}
+	for addr, d := range m.inventory {
+		if d.Allocated && !requesting[d.Namespace+"/"+d.AllocatedTo] {
+			m.logger.Infof("Freed DPDK device %s of gone pod %s/%s", addr, d.Namespace, d.AllocatedTo)
+			delete(m.podPages, d.Namespace+"/"+d.AllocatedTo)
+			d.Allocated = false
+			d.AllocatedTo = ""
+			d.Namespace = ""
+			m.inventory[addr] = d
+		}
+	}
+	// the pages of devices lost to rediscovery are no longer in use
+	for key := range m.podPages {
+		namespace, name, _ := strings.Cut(key, "/")
+		if !m.allocated(namespace, name) {
+			delete(m.podPages, key)
+		}
+	}
+
+	// poll-mode drivers map their rings into hugepages
+	hugepages := false
+	for _, hp := range m.hugepages {
+		hugepages = hugepages || hp.Total > 0
+	}
+
+	for _, pod := range pods.Items {
+		if pod.DeletionTimestamp != nil || m.allocated(pod.Namespace, pod.Name) {
+			continue
+		}
+		if !hugepages {
+			m.logger.Warnf("No hugepages reserved on the node, no DPDK device for pod %s/%s", pod.Namespace, pod.Name)
+			dpdkRefusals.WithLabelValues(refusalNoHugepages).Inc()
+			continue
+		}
+		// the pages of the pods holding a device are theirs even before
+		// mapped, so a pod starting late still gets them
+		need, err := podHugepages(&pod)
+		if err != nil {
+			m.logger.WithError(err).Warnf("No DPDK device for pod %s/%s", pod.Namespace, pod.Name)
+			continue
+		}
+		if reason := m.checkHugepages(need); reason != "" {
+			m.logger.Warnf("Hugepages exhausted, no DPDK device for pod %s/%s: pod %s", pod.Namespace, pod.Name, reason)
+			dpdkRefusals.WithLabelValues(refusalHugepagesExhausted).Inc()
+			continue
+		}
+
+		// lowest PCI address first, so the pick is predictable
+		allocated := false
+		for _, addr := range m.addresses() {
+			d := m.inventory[addr]
+			if d.Allocated {
+				continue
+			}
+			d.Allocated = true
+			d.AllocatedTo = pod.Name
+			d.Namespace = pod.Namespace
+			m.inventory[addr] = d
+			if len(need) > 0 {
+				m.podPages[pod.Namespace+"/"+pod.Name] = need
+			}
+			allocated = true
+			m.logger.Infof("Allocated DPDK device %s to pod %s/%s", addr, pod.Namespace, pod.Name)
+			break
+		}
+		if !allocated {
+			m.logger.Warnf("No free DPDK device for pod %s/%s", pod.Namespace, pod.Name)
+			dpdkRefusals.WithLabelValues(refusalNoDevice).Inc()
+		}
+	}
+	return nil
+}
+
+// allocated reports whether a pod holds a device, the mutex must be held
+func (m *DPDKManager) allocated(namespace, podName string) bool {
+	for _, d := range m.inventory {
+		if d.Allocated && d.AllocatedTo == podName && d.Namespace == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// addresses returns the PCI addresses of the inventory, sorted, the mutex must be held
+func (m *DPDKManager) addresses() []string {
+	addrs := make([]string, 0, len(m.inventory))
+	for addr := range m.inventory {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	return addrs
+}
+
+// GetDeviceForPod returns the allocated device for a pod, if any
+func (m *DPDKManager) GetDeviceForPod(namespace, podName string) (DPDKDevice, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, d := range m.inventory {
+		if d.Allocated && d.AllocatedTo == podName && d.Namespace == namespace {
+			return d, true
+		}
+	}
+	return DPDKDevice{}, false
+}
+
+// ReleaseDevice releases a device allocation
+func (m *DPDKManager) ReleaseDevice(namespace, podName string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for addr, d := range m.inventory {
+		if d.Allocated && d.AllocatedTo == podName && d.Namespace == namespace {
+			d.Allocated = false
+			d.AllocatedTo = ""
+			d.Namespace = ""
+			m.inventory[addr] = d
+			delete(m.podPages, namespace+"/"+podName)
+			m.logger.Infof("Released DPDK device %s from pod %s/%s", addr, namespace, podName)
+			return true
+		}
+	}
+	return false
+}
+
+// Devices returns a copy of the device inventory, ordered by PCI address
+func (m *DPDKManager) Devices() []DPDKDevice {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	devices := make([]DPDKDevice, 0, len(m.inventory))
+	for _, addr := range m.addresses() {
+		devices = append(devices, m.inventory[addr])
+	}
+	return devices
+}
+
+// Hugepages returns the hugepage pools of the node, ordered by page size,
+// with the pages accounted to the pods
+func (m *DPDKManager) Hugepages() []Hugepages {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	accounted := m.accountedPages()
+	hugepages := append([]Hugepages(nil), m.hugepages...)
+	for i := range hugepages {
+		hugepages[i].Accounted = accounted[hugepages[i].SizeKB]
+	}
+	return hugepages
+}
+
+// Inventory returns the hugepages, their accounting and the devices of the node
+func (m *DPDKManager) Inventory() DPDKInventory {
+	return DPDKInventory{Driver: m.driver, Hugepages: m.Hugepages(), Pods: m.PodHugepages(), Devices: m.Devices()}
+}
+
+// readString reads a sysfs attribute, empty if it can't be read
+func readString(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// readInt reads an integer sysfs attribute, def if it can't be read
+func readInt(path string, def int) int {
+	n, err := strconv.Atoi(readString(path))
+	if err != nil {
+		return def
+	}
+	return n
+}
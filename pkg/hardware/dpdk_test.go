@@ -0,0 +1,151 @@
+package hardware
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func dpdkPod(name string) *corev1.Pod {
+	return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name:      name,
+		Namespace: "edge",
+		Labels:    map[string]string{LabelDPDK: "true"},
+	}}
+}
+
+// pciDevice adds a PCI device of the class bound to the driver
+func (f *fakeSysfs) pciDevice(addr, class, driver string) {
+	device := "sys/bus/pci/devices/" + addr
+	f.write(device+"/class", class+"\n")
+	f.write(device+"/vendor", "0x8086\n")
+	f.write(device+"/numa_node", "1\n")
+	if driver != "" {
+		f.link(device+"/driver", "sys/bus/pci/drivers/"+driver)
+	}
+}
+
+func newTestDPDKManager(t *testing.T, fs *fakeSysfs, pods ...*corev1.Pod) *DPDKManager {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	clientset := fake.NewSimpleClientset()
+	for _, pod := range pods {
+		if err := clientset.Tracker().Add(pod); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m := NewDPDKManager(context.Background(), clientset, logger, DriverVFIOPCI, nil)
+	m.root = fs.root
+	return m
+}
+
+func TestDPDKManagerBindDevice(t *testing.T) {
+	fs := newFakeSysfs(t)
+	fs.pciDevice("0000:3b:00.0", "0x020000", "ixgbe")
+	fs.pciDevice("0000:00:17.0", "0x010601", "ahci")
+	m := newTestDPDKManager(t, fs)
+
+	if err := m.bindDevice("0000:3b:00.0"); err != nil {
+		t.Fatalf("bindDevice() error = %v", err)
+	}
+	for path, want := range map[string]string{
+		"sys/bus/pci/devices/0000:3b:00.0/driver_override": DriverVFIOPCI,
+		"sys/bus/pci/drivers/ixgbe/unbind":                 "0000:3b:00.0",
+		"sys/bus/pci/drivers_probe":                        "0000:3b:00.0",
+	} {
+		got, err := os.ReadFile(filepath.Join(fs.root, path))
+		if err != nil || string(got) != want {
+			t.Errorf("%s = %q (%v), want %q", path, got, err, want)
+		}
+	}
+
+	// storage controllers are never taken from the kernel
+	if err := m.bindDevice("0000:00:17.0"); err == nil {
+		t.Error("bindDevice() bound a device that is not a NIC")
+	}
+}
+
+func TestDPDKManagerDiscoversDevicesAndHugepages(t *testing.T) {
+	fs := newFakeSysfs(t)
+	fs.pciDevice("0000:5e:00.0", "0x020000", DriverIGBUIO)
+	fs.pciDevice("0000:3b:00.1", "0x020000", DriverVFIOPCI)
+	fs.link("sys/bus/pci/devices/0000:3b:00.1/iommu_group", "sys/kernel/iommu_groups/42")
+	fs.pciDevice("0000:3b:00.0", "0x020000", "ixgbe")
+	fs.pciDevice("0000:00:02.0", "0x030000", DriverVFIOPCI)
+	fs.write("sys/kernel/mm/hugepages/hugepages-2048kB/nr_hugepages", "512\n")
+	fs.write("sys/kernel/mm/hugepages/hugepages-2048kB/free_hugepages", "256\n")
+	fs.write("sys/kernel/mm/hugepages/hugepages-1048576kB/nr_hugepages", "0\n")
+	fs.write("sys/kernel/mm/hugepages/hugepages-1048576kB/free_hugepages", "0\n")
+	m := newTestDPDKManager(t, fs)
+
+	if err := m.discoverHugepages(); err != nil {
+		t.Fatalf("discoverHugepages() error = %v", err)
+	}
+	if err := m.discoverDevices(); err != nil {
+		t.Fatalf("discoverDevices() error = %v", err)
+	}
+
+	wantHugepages := []Hugepages{{SizeKB: 2048, Total: 512, Free: 256}, {SizeKB: 1048576}}
+	if got := m.Hugepages(); !reflect.DeepEqual(got, wantHugepages) {
+		t.Errorf("Hugepages() = %+v, want %+v", got, wantHugepages)
+	}
+	// the kernel-bound NIC and the GPU are not DPDK devices
+	wantDevices := []DPDKDevice{
+		{PCIAddress: "0000:3b:00.1", Driver: DriverVFIOPCI, Vendor: "0x8086", NUMANode: 1, IOMMUGroup: "42"},
+		{PCIAddress: "0000:5e:00.0", Driver: DriverIGBUIO, Vendor: "0x8086", NUMANode: 1},
+	}
+	if got := m.Devices(); !reflect.DeepEqual(got, wantDevices) {
+		t.Errorf("Devices() = %+v, want %+v", got, wantDevices)
+	}
+}
+
+func TestDPDKManagerAllocatesDevices(t *testing.T) {
+	fs := newFakeSysfs(t)
+	fs.pciDevice("0000:5e:00.0", "0x020000", DriverVFIOPCI)
+	fs.pciDevice("0000:3b:00.0", "0x020000", DriverVFIOPCI)
+	m := newTestDPDKManager(t, fs, dpdkPod("router"), dpdkPod("firewall"), dpdkPod("nat"))
+
+	// without hugepages the poll-mode drivers can't run, nothing is allocated
+	m.sync()
+	if _, ok := m.GetDeviceForPod("edge", "router"); ok {
+		t.Fatal("allocated a device on a node without hugepages")
+	}
+
+	fs.write("sys/kernel/mm/hugepages/hugepages-2048kB/nr_hugepages", "512\n")
+	m.sync()
+	allocated := map[string]string{}
+	for _, pod := range []string{"router", "firewall", "nat"} {
+		if d, ok := m.GetDeviceForPod("edge", pod); ok {
+			allocated[pod] = d.PCIAddress
+		}
+	}
+	if len(allocated) != 2 {
+		t.Fatalf("allocated %v, want both devices allocated", allocated)
+	}
+
+	// the allocation survives rediscovery, a released device goes to the waiting pod
+	var holder string
+	for pod, addr := range allocated {
+		if addr == "0000:3b:00.0" {
+			holder = pod
+		}
+	}
+	m.sync()
+	if d, ok := m.GetDeviceForPod("edge", holder); !ok || d.PCIAddress != "0000:3b:00.0" {
+		t.Fatalf("allocation of %s lost on rediscovery: %+v", holder, d)
+	}
+	if !m.ReleaseDevice("edge", holder) {
+		t.Fatal("ReleaseDevice() = false")
+	}
+	if m.ReleaseDevice("edge", holder) {
+		t.Error("ReleaseDevice() released a device twice")
+	}
+}
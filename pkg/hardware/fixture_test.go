@@ -0,0 +1,104 @@
+package hardware
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/akos011221/nsm/pkg/sysfsfixture"
+	"github.com/sirupsen/logrus"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// TestDiscoveryFromFixtures runs the discovery on the sysfs trees captured
+// with cmd/gensysfs under testdata/sysfs. Add the fixture of a bug report
+// with the discovery the node should get.
+func TestDiscoveryFromFixtures(t *testing.T) {
+	tests := []struct {
+		fixture   string
+		iommu     string
+		nics      []NIC
+		vfs       map[string]string
+		drivers   map[string]string
+		dpdk      []string
+		hugepages []Hugepages
+	}{
+		{
+			// Intel X710 with a VF on iavf and a VF on vfio-pci
+			fixture: "i40e-2vfs.json",
+			iommu:   "intel-vt-d",
+			nics: []NIC{
+				{Name: "enp59s0f0", Bus: BusPCI, Driver: "i40e", SRIOV: true, TotalVFs: 64, SpeedMbps: 10000},
+				{Name: "enp59s0f0v0", Bus: BusPCI, Driver: "iavf"},
+			},
+			vfs:       map[string]string{"0000:3b:02.0": "enp59s0f0v0", "0000:3b:02.1": ""},
+			drivers:   map[string]string{"0000:3b:02.0": "iavf", "0000:3b:02.1": "vfio-pci"},
+			dpdk:      []string{"0000:3b:02.1"},
+			hugepages: []Hugepages{{SizeKB: 2048, Total: 1024, Free: 512}, {SizeKB: 1048576}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			fixture, err := sysfsfixture.Load("testdata/sysfs/" + tt.fixture)
+			if err != nil {
+				t.Fatal(err)
+			}
+			root := t.TempDir()
+			if err := fixture.Extract(root); err != nil {
+				t.Fatalf("Extract() error = %v", err)
+			}
+			logger := logrus.New()
+			logger.SetOutput(io.Discard)
+
+			d := NewPlatformDetector()
+			d.root, d.ethtool = root, nil
+			p, err := d.Detect()
+			if err != nil {
+				t.Fatalf("Detect() error = %v", err)
+			}
+			if p.IOMMU != tt.iommu || !p.IOMMUGroups {
+				t.Errorf("IOMMU = %s (groups %t), want %s with groups", p.IOMMU, p.IOMMUGroups, tt.iommu)
+			}
+			if !reflect.DeepEqual(p.NICs, tt.nics) {
+				t.Errorf("NICs = %+v, want %+v", p.NICs, tt.nics)
+			}
+
+			m := NewSRIOVManager(context.Background(), fake.NewSimpleClientset(), logger)
+			m.root = root
+			if err := m.discoverVirtualFunctions(); err != nil {
+				t.Fatalf("discoverVirtualFunctions() error = %v", err)
+			}
+			vfs, drivers := make(map[string]string), make(map[string]string)
+			for _, vf := range m.VirtualFunctions() {
+				vfs[vf.PCIAddress] = vf.InterfaceName
+				drivers[vf.PCIAddress] = vf.Driver
+			}
+			if !reflect.DeepEqual(vfs, tt.vfs) {
+				t.Errorf("VF interfaces by PCI address = %v, want %v", vfs, tt.vfs)
+			}
+			if !reflect.DeepEqual(drivers, tt.drivers) {
+				t.Errorf("VF drivers by PCI address = %v, want %v", drivers, tt.drivers)
+			}
+
+			dpdk := NewDPDKManager(context.Background(), fake.NewSimpleClientset(), logger, DriverVFIOPCI, nil)
+			dpdk.root = root
+			if err := dpdk.discoverDevices(); err != nil {
+				t.Fatalf("discoverDevices() error = %v", err)
+			}
+			if err := dpdk.discoverHugepages(); err != nil {
+				t.Fatalf("discoverHugepages() error = %v", err)
+			}
+			var addrs []string
+			for _, dev := range dpdk.Devices() {
+				addrs = append(addrs, dev.PCIAddress)
+			}
+			if !reflect.DeepEqual(addrs, tt.dpdk) {
+				t.Errorf("DPDK devices = %v, want %v", addrs, tt.dpdk)
+			}
+			if !reflect.DeepEqual(dpdk.Hugepages(), tt.hugepages) {
+				t.Errorf("hugepages = %+v, want %+v", dpdk.Hugepages(), tt.hugepages)
+			}
+		})
+	}
+}
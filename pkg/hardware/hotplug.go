@@ -0,0 +1,180 @@
+package hardware
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/akos011221/nsm/pkg/uevent"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// HandlePCIEvent updates the inventory on a PCI hotplug event instead of
+// waiting for the next poll. The pods holding the VFs of removed devices
+// lose them at once and get a free VF with the rediscovery that follows,
+// moved into the pod in place of the removed one when the VF was handed
+// to the pod by the CNI plugin and reparenting is enabled.
+func (m *SRIOVManager) HandlePCIEvent(e uevent.Event) {
+	switch e.Action {
+	case uevent.ActionRemove:
+		m.removeDevice(e.Env["PCI_SLOT_NAME"])
+	case uevent.ActionAdd, uevent.ActionBind, uevent.ActionUnbind, uevent.ActionOverflow:
+	default:
+		return
+	}
+	m.hotplugged()
+}
+
+// HandleNetEvent rediscovers the VFs when a network interface of a device
+// appears, vanishes or is renamed, e.g., a VF whose driver was bound or
+// unbound. A VF interface renamed by udev/systemd takes its new name at
+// once. The interfaces of virtual devices (veth, bridges, tunnels) come
+// and go with the pods and are ignored.
+func (m *SRIOVManager) HandleNetEvent(e uevent.Event) {
+	switch e.Action {
+	case uevent.ActionAdd, uevent.ActionRemove, uevent.ActionMove, uevent.ActionChange, uevent.ActionOverflow:
+	default:
+		return
+	}
+	if strings.HasPrefix(e.DevPath, "/devices/virtual/") {
+		return
+	}
+	if e.Action == uevent.ActionMove {
+		m.renameInterface(e.DevPath)
+	}
+	m.hotplugged()
+}
+
+// renameInterface records the new name of a renamed VF interface, from the
+// device path of its netdev (/devices/.../<VF PCI address>/net/<name>)
+func (m *SRIOVManager) renameInterface(devPath string) {
+	dir, name := path.Split(devPath)
+	dir = strings.TrimSuffix(dir, "/")
+	if name == "" || path.Base(dir) != "net" {
+		return
+	}
+	pci := path.Base(path.Dir(dir))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for key, vf := range m.vfInventory {
+		if vf.PCIAddress != pci || vf.InterfaceName == name {
+			continue
+		}
+		m.logger.Infof("VF %s interface renamed from %s to %s", key, vf.InterfaceName, name)
+		vf.InterfaceName = name
+		m.vfInventory[key] = vf
+		if _, ok := m.downed[key]; ok {
+			m.downed[key] = name
+		}
+		m.checkpoint()
+	}
+}
+
+// SetPollInterval changes the interval of the periodic discovery, at once
+// if it is running. With the hotplug events driving the discovery, the
+// polls only check that no event was missed and can be far apart.
+func (m *SRIOVManager) SetPollInterval(interval time.Duration) {
+	m.mu.Lock()
+	m.pollInterval = interval
+	m.mu.Unlock()
+	m.heartbeat.Expect(interval)
+	m.Resync()
+}
+
+// interval returns the interval of the periodic discovery
+func (m *SRIOVManager) interval() time.Duration {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.pollInterval
+}
+
+// removeDevice drops the VFs of a removed PCI device, a PF or a VF, from
+// the inventory so they aren't allocated until the rediscovery
+func (m *SRIOVManager) removeDevice(pci string) {
+	if pci == "" {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	defer m.checkpoint()
+
+	removedPFs := make(map[string]bool)
+	for name, pf := range m.pfInventory {
+		if pf.PCIAddress == pci {
+			removedPFs[name] = true
+		}
+	}
+	now := time.Now()
+	for key, vf := range m.vfInventory {
+		// the VFs of a PF being reset are removed on purpose
+		if m.resetting[vf.PFName] || (vf.PCIAddress != pci && !removedPFs[vf.PFName]) {
+			continue
+		}
+		delete(m.vfInventory, key)
+		if !vf.Allocated {
+			continue
+		}
+		m.logger.Warnf("VF %s (%s) of pod %s/%s was removed from the node", key, vf.PCIAddress, vf.Namespace, vf.AllocatedTo)
+		m.decisions.record(vf.Namespace, vf.AllocatedTo, Decision{
+			Reason:     ReasonHardwareRemoved,
+			Message:    fmt.Sprintf("VF %s was removed from the node, failing over to a free VF", key),
+			VF:         key,
+			PCIAddress: vf.PCIAddress,
+		}, now)
+		// leases are renewed on another VF by their holders
+		if vf.LeaseTTL == 0 && vf.Netns != "" {
+			m.failovers[types.NamespacedName{Namespace: vf.Namespace, Name: vf.AllocatedTo}] = vf
+		}
+	}
+}
+
+// hotplugged rediscovers the VFs once the events of a hotplug settled, a
+// NIC adding its VFs raises an event per VF
+func (m *SRIOVManager) hotplugged() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.hotplugPending {
+		return
+	}
+	m.hotplugPending = true
+	time.AfterFunc(m.hotplugSettle, func() {
+		m.mu.Lock()
+		m.hotplugPending = false
+		m.mu.Unlock()
+		m.Resync()
+	})
+}
+
+// failOver moves the VFs allocated in place of removed ones into their
+// pods, as the removed VFs were
+func (m *SRIOVManager) failOver() {
+	m.mu.Lock()
+	failovers := m.failovers
+	m.failovers = make(map[types.NamespacedName]VirtualFunction)
+	m.mu.Unlock()
+
+	for pod, removed := range failovers {
+		// the VF allocated in place of the removed one isn't attached yet
+		vf, ok := PickVF(m.GetVFsForPod(pod.Namespace, pod.Name), removed.PodInterface)
+		if !ok {
+			m.logger.Warnf("No free VF to fail pod %s over to, its VF was removed", pod)
+			continue
+		}
+		if m.plumber == nil {
+			m.logger.Infof("Allocated VF %s:%d to pod %s in place of the removed %s, the pod must attach it", vf.PFName, vf.VFID, pod, removed.PCIAddress)
+			continue
+		}
+		vf.Netns = removed.Netns
+		vf.PodInterface = removed.PodInterface
+		// the configuration of the removed VF was lost with it
+		cfg := DefaultVFConfig
+		if err := m.plumber.Attach(vf, &cfg); err != nil {
+			m.logger.WithError(err).Errorf("Failed to move VF %s into pod %s in place of the removed %s", vf.PCIAddress, pod, removed.PCIAddress)
+			continue
+		}
+		m.SetAttachment(pod.Namespace, pod.Name, removed.Netns, removed.PodInterface)
+		m.logger.Infof("Failed pod %s over from the removed VF %s to %s", pod, removed.PCIAddress, vf.PCIAddress)
+	}
+}
@@ -0,0 +1,142 @@
+package hardware
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/akos011221/nsm/pkg/uevent"
+	"github.com/sirupsen/logrus"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// attachingPlumber records the VFs attached to pods
+type attachingPlumber struct {
+	attached []VirtualFunction
+}
+
+func (p *attachingPlumber) Detach(vf VirtualFunction) (*VFConfig, error) {
+	return &VFConfig{}, nil
+}
+
+func (p *attachingPlumber) Attach(vf VirtualFunction, cfg *VFConfig) error {
+	p.attached = append(p.attached, vf)
+	return nil
+}
+
+func TestSRIOVManagerFailsOverRemovedVF(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	fs := newFakeSysfs(t)
+	fs.write("sys/class/net/eth0/device/sriov_numvfs", "2\n")
+	fs.write("sys/class/net/eth0/device/virtfn0/uevent", "PCI_SLOT_NAME=0000:3b:02.0\n")
+	fs.write("sys/class/net/eth0/device/virtfn1/uevent", "PCI_SLOT_NAME=0000:3b:02.1\n")
+
+	m := NewSRIOVManager(context.Background(), fake.NewSimpleClientset(sriovPod("camera")), logger)
+	m.root = fs.root
+	m.links = &recordingLinks{}
+	m.hotplugSettle = time.Millisecond
+	plumber := &attachingPlumber{}
+	m.SetReparenting(plumber, nil)
+	m.sync()
+	if vf, ok := m.GetVFForPod("edge", "camera"); !ok || vf.VFID != 0 {
+		t.Fatalf("GetVFForPod() = %+v, %t, want VF 0", vf, ok)
+	}
+	m.SetAttachment("edge", "camera", "/var/run/netns/camera", "net1")
+
+	// the VF is unplugged, the pod loses it before the rediscovery
+	if err := os.RemoveAll(filepath.Join(fs.root, "sys/class/net/eth0/device/virtfn0")); err != nil {
+		t.Fatal(err)
+	}
+	m.HandlePCIEvent(uevent.Event{Action: uevent.ActionRemove, Subsystem: "pci", Env: map[string]string{"PCI_SLOT_NAME": "0000:3b:02.0"}})
+	if vf, ok := m.GetVFForPod("edge", "camera"); ok {
+		t.Fatalf("GetVFForPod() = %+v after the removal, want none", vf)
+	}
+	if exp, _ := m.Explain("edge", "camera"); exp.Decisions[0].Reason != ReasonHardwareRemoved {
+		t.Errorf("decision = %+v, want %s", exp.Decisions[0], ReasonHardwareRemoved)
+	}
+
+	select {
+	case <-m.wake:
+	case <-time.After(time.Second):
+		t.Fatal("no rediscovery after the hotplug")
+	}
+	m.sync()
+	vf, ok := m.GetVFForPod("edge", "camera")
+	if !ok || vf.VFID != 1 || vf.Netns != "/var/run/netns/camera" || vf.PodInterface != "net1" {
+		t.Fatalf("GetVFForPod() = %+v, %t after the failover, want VF 1 attached as net1", vf, ok)
+	}
+	if len(plumber.attached) != 1 || plumber.attached[0].PCIAddress != "0000:3b:02.1" || plumber.attached[0].PodInterface != "net1" {
+		t.Errorf("attached = %+v, want VF 1 moved into the pod as net1", plumber.attached)
+	}
+}
+
+func TestSRIOVManagerKeepsVFsOfResettingPF(t *testing.T) {
+	m, _ := newResetManager(t)
+	m.pfInventory["eth0"] = PhysicalFunction{Name: "eth0", PCIAddress: "0000:3b:00.0", NumVFs: 2}
+	m.resetting["eth0"] = true
+	m.hotplugSettle = time.Hour
+
+	m.HandlePCIEvent(uevent.Event{Action: uevent.ActionRemove, Subsystem: "pci", Env: map[string]string{"PCI_SLOT_NAME": "0000:3b:02.0"}})
+	if _, ok := m.GetVFForPod("edge", "camera"); !ok {
+		t.Error("the VF of a PF being reset was dropped on its removal")
+	}
+
+	delete(m.resetting, "eth0")
+	m.HandlePCIEvent(uevent.Event{Action: uevent.ActionRemove, Subsystem: "pci", Env: map[string]string{"PCI_SLOT_NAME": "0000:3b:00.0"}})
+	if vfs := m.VirtualFunctions(); len(vfs) != 0 {
+		t.Errorf("VirtualFunctions() = %+v after the PF was removed, want none", vfs)
+	}
+}
+
+func TestSRIOVManagerRediscoversOnNetEvents(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	m := NewSRIOVManager(context.Background(), fake.NewSimpleClientset(), logger)
+	m.hotplugSettle = time.Millisecond
+
+	// the interfaces of pods don't trigger a discovery
+	m.HandleNetEvent(uevent.Event{Action: uevent.ActionAdd, Subsystem: "net", DevPath: "/devices/virtual/net/veth1a2b"})
+	time.Sleep(20 * time.Millisecond)
+	select {
+	case <-m.wake:
+		t.Fatal("discovery triggered by a virtual interface")
+	default:
+	}
+
+	// a VF interface renamed by udev does, once settled
+	for i := 0; i < 3; i++ {
+		m.HandleNetEvent(uevent.Event{Action: uevent.ActionMove, Subsystem: "net", DevPath: "/devices/pci0000:3a/0000:3a:00.0/0000:3b:02.0/net/eth0v0"})
+	}
+	select {
+	case <-m.wake:
+	case <-time.After(time.Second):
+		t.Fatal("no discovery after a VF interface was renamed")
+	}
+	time.Sleep(20 * time.Millisecond)
+	select {
+	case <-m.wake:
+		t.Error("the events of a rename triggered more than one discovery")
+	default:
+	}
+}
+
+func TestSRIOVManagerRenamesVFInterface(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	m := NewSRIOVManager(context.Background(), fake.NewSimpleClientset(), logger)
+	m.hotplugSettle = time.Hour
+	m.vfInventory["eth0-vf0"] = VirtualFunction{PFName: "eth0", VFID: 0, PCIAddress: "0000:3b:02.0", InterfaceName: "eth2"}
+	m.downed["eth0-vf0"] = "eth2"
+
+	m.HandleNetEvent(uevent.Event{Action: uevent.ActionMove, Subsystem: "net", DevPath: "/devices/pci0000:3a/0000:3a:00.0/0000:3b:02.0/net/enp59s0f0v0"})
+	if vf := m.vfInventory["eth0-vf0"]; vf.InterfaceName != "enp59s0f0v0" {
+		t.Errorf("InterfaceName = %q after the rename, want enp59s0f0v0", vf.InterfaceName)
+	}
+	if m.downed["eth0-vf0"] != "enp59s0f0v0" {
+		t.Errorf("interface set down recorded as %q, want the new name", m.downed["eth0-vf0"])
+	}
+}
@@ -0,0 +1,225 @@
+package hardware
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// NodeConditionHugepagesExhausted is the condition of the Node reporting
+// whether the DPDK pods can still be given hugepages
+const NodeConditionHugepagesExhausted corev1.NodeConditionType = "NSMHugepagesExhausted"
+
+// NUMAHugepages is the part of a hugepage pool on one NUMA node
+type NUMAHugepages struct {
+	// NUMA node
+	NUMANode int `json:"numaNode"`
+	// Pages reserved on the NUMA node
+	Total int `json:"total"`
+	// Pages not mapped by any process
+	Free int `json:"free"`
+}
+
+// PodHugepages is the hugepages of one page size accounted to a pod
+// holding a DPDK device
+type PodHugepages struct {
+	// Namespace of the pod
+	Namespace string `json:"namespace"`
+	// Pod the pages are accounted to
+	Pod string `json:"pod"`
+	// Page size in kB
+	SizeKB int `json:"sizeKB"`
+	// Pages the pod requests
+	Pages int `json:"pages"`
+}
+
+// podHugepages returns the pages a pod requests, by page size in kB. The
+// kubelet requires the requests of hugepages to equal their limits, the
+// limits are read for containers setting only those.
+func podHugepages(pod *corev1.Pod) (map[int]int, error) {
+	pages := make(map[int]int)
+	for _, container := range pod.Spec.Containers {
+		quantities := container.Resources.Requests
+		if len(quantities) == 0 {
+			quantities = container.Resources.Limits
+		}
+		for name, quantity := range quantities {
+			if !strings.HasPrefix(string(name), corev1.ResourceHugePagesPrefix) {
+				continue
+			}
+			size, err := resource.ParseQuantity(strings.TrimPrefix(string(name), corev1.ResourceHugePagesPrefix))
+			if err != nil || size.Value() <= 0 {
+				return nil, fmt.Errorf("invalid hugepage resource %s", name)
+			}
+			// a partial page takes a whole one
+			pages[int(size.Value()/1024)] += int((quantity.Value() + size.Value() - 1) / size.Value())
+		}
+	}
+	return pages, nil
+}
+
+// discoverNUMAHugepages reads how a hugepage pool is spread across the NUMA
+// nodes, nil on nodes without NUMA information
+func (m *DPDKManager) discoverNUMAHugepages(sizeKB int) []NUMAHugepages {
+	pools, err := filepath.Glob(m.path("sys/devices/system/node/node*/hugepages", fmt.Sprintf("hugepages-%dkB", sizeKB)))
+	if err != nil {
+		return nil
+	}
+
+	var nodes []NUMAHugepages
+	for _, pool := range pools {
+		var n NUMAHugepages
+		if _, err := fmt.Sscanf(filepath.Base(filepath.Dir(filepath.Dir(pool))), "node%d", &n.NUMANode); err != nil {
+			continue
+		}
+		n.Total = readInt(filepath.Join(pool, "nr_hugepages"), 0)
+		n.Free = readInt(filepath.Join(pool, "free_hugepages"), 0)
+		nodes = append(nodes, n)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].NUMANode < nodes[j].NUMANode })
+	return nodes
+}
+
+// accountedPages returns the pages accounted to the pods, by page size,
+// the mutex must be held
+func (m *DPDKManager) accountedPages() map[int]int {
+	accounted := make(map[int]int)
+	for _, pages := range m.podPages {
+		for size, n := range pages {
+			accounted[size] += n
+		}
+	}
+	return accounted
+}
+
+// checkHugepages checks the pools have the pages a pod requests left
+// unaccounted, returning why they don't, the mutex must be held
+func (m *DPDKManager) checkHugepages(need map[int]int) string {
+	accounted := m.accountedPages()
+	sizes := make([]int, 0, len(need))
+	for size := range need {
+		sizes = append(sizes, size)
+	}
+	sort.Ints(sizes)
+
+	for _, size := range sizes {
+		total := 0
+		for _, hp := range m.hugepages {
+			if hp.SizeKB == size {
+				total = hp.Total
+			}
+		}
+		if left := total - accounted[size]; left < need[size] {
+			return fmt.Sprintf("needs %d pages of %dkB, %d of %d left", need[size], size, max(left, 0), total)
+		}
+	}
+	return ""
+}
+
+// hugepagesCondition returns the hugepage condition of the node, the mutex
+// must be held. The pools are exhausted once every page reserved is
+// accounted to a pod.
+func (m *DPDKManager) hugepagesCondition() (corev1.ConditionStatus, string, string) {
+	accounted := m.accountedPages()
+	var pools []string
+	reserved := false
+	exhausted := true
+	for _, hp := range m.hugepages {
+		if hp.Total == 0 {
+			continue
+		}
+		reserved = true
+		left := hp.Total - accounted[hp.SizeKB]
+		exhausted = exhausted && left <= 0
+		pools = append(pools, fmt.Sprintf("%dkB: %d of %d pages left", hp.SizeKB, max(left, 0), hp.Total))
+	}
+
+	switch {
+	case !reserved:
+		return corev1.ConditionTrue, "HugepagesNotReserved", "No hugepages reserved on the node"
+	case exhausted:
+		return corev1.ConditionTrue, "HugepagesExhausted", strings.Join(pools, ", ")
+	}
+	return corev1.ConditionFalse, "HugepagesAvailable", strings.Join(pools, ", ")
+}
+
+// SetNodeName makes the manager report the hugepage condition on the
+// status of a Kubernetes Node
+func (m *DPDKManager) SetNodeName(name string) {
+	m.nodeName = name
+}
+
+// publishCondition sets the hugepage condition on the Node when it changed
+func (m *DPDKManager) publishCondition() error {
+	if m.nodeName == "" {
+		return nil
+	}
+
+	m.mu.RLock()
+	status, reason, message := m.hugepagesCondition()
+	m.mu.RUnlock()
+	if m.condition != nil && m.condition.Status == status && m.condition.Reason == reason && m.condition.Message == message {
+		return nil
+	}
+
+	now := metav1.NewTime(time.Now())
+	condition := corev1.NodeCondition{
+		Type:               NodeConditionHugepagesExhausted,
+		Status:             status,
+		LastHeartbeatTime:  now,
+		LastTransitionTime: now,
+		Reason:             reason,
+		Message:            message,
+	}
+	if m.condition != nil && m.condition.Status == status {
+		condition.LastTransitionTime = m.condition.LastTransitionTime
+	}
+
+	// the conditions are merged by type, those of the kubelet are kept
+	patch, err := json.Marshal(map[string]any{
+		"status": map[string]any{"conditions": []corev1.NodeCondition{condition}},
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := m.clientset.CoreV1().Nodes().PatchStatus(m.ctx, m.nodeName, patch); err != nil {
+		return fmt.Errorf("failed to set the hugepage condition of node %s: %w", m.nodeName, err)
+	}
+	m.condition = &condition
+	if status == corev1.ConditionTrue {
+		m.logger.Warnf("Hugepages exhausted on node %s: %s", m.nodeName, message)
+	}
+	return nil
+}
+
+// PodHugepages returns the hugepages accounted to the pods, ordered by pod
+// and page size
+func (m *DPDKManager) PodHugepages() []PodHugepages {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var pods []PodHugepages
+	for key, pages := range m.podPages {
+		namespace, name, _ := strings.Cut(key, "/")
+		for size, n := range pages {
+			pods = append(pods, PodHugepages{Namespace: namespace, Pod: name, SizeKB: size, Pages: n})
+		}
+	}
+	sort.Slice(pods, func(i, j int) bool {
+		if pods[i].Namespace != pods[j].Namespace {
+			return pods[i].Namespace < pods[j].Namespace
+		}
+		if pods[i].Pod != pods[j].Pod {
+			return pods[i].Pod < pods[j].Pod
+		}
+		return pods[i].SizeKB < pods[j].SizeKB
+	})
+	return pods
+}
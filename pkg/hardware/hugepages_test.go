@@ -0,0 +1,164 @@
+package hardware
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// hugepagePod is a pod requesting DPDK and hugepages of a size (e.g., 1Gi)
+func hugepagePod(name, size, quantity string) *corev1.Pod {
+	pod := dpdkPod(name)
+	pod.Spec.Containers = []corev1.Container{{
+		Name: "dataplane",
+		Resources: corev1.ResourceRequirements{
+			Limits: corev1.ResourceList{
+				corev1.ResourceName(corev1.ResourceHugePagesPrefix + size): resource.MustParse(quantity),
+				corev1.ResourceMemory: resource.MustParse("1Gi"),
+			},
+		},
+	}}
+	return pod
+}
+
+func TestPodHugepages(t *testing.T) {
+	pod := hugepagePod("router", "2Mi", "512Mi")
+	pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{
+		Name: "sidecar",
+		Resources: corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{
+				corev1.ResourceName(corev1.ResourceHugePagesPrefix + "1Gi"): resource.MustParse("2Gi"),
+				corev1.ResourceName(corev1.ResourceHugePagesPrefix + "2Mi"): resource.MustParse("3Mi"),
+			},
+		},
+	})
+	got, err := podHugepages(pod)
+	if err != nil {
+		t.Fatalf("podHugepages() error = %v", err)
+	}
+	// a partial page takes a whole one
+	want := map[int]int{2048: 258, 1048576: 2}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("podHugepages() = %v, want %v", got, want)
+	}
+
+	if _, err := podHugepages(hugepagePod("nat", "huge", "1Gi")); err == nil {
+		t.Error("podHugepages() accepted an invalid page size")
+	}
+}
+
+func TestDPDKManagerDiscoversNUMAHugepages(t *testing.T) {
+	fs := newFakeSysfs(t)
+	fs.write("sys/kernel/mm/hugepages/hugepages-2048kB/nr_hugepages", "1024\n")
+	fs.write("sys/kernel/mm/hugepages/hugepages-2048kB/free_hugepages", "768\n")
+	fs.write("sys/devices/system/node/node1/hugepages/hugepages-2048kB/nr_hugepages", "512\n")
+	fs.write("sys/devices/system/node/node1/hugepages/hugepages-2048kB/free_hugepages", "512\n")
+	fs.write("sys/devices/system/node/node0/hugepages/hugepages-2048kB/nr_hugepages", "512\n")
+	fs.write("sys/devices/system/node/node0/hugepages/hugepages-2048kB/free_hugepages", "256\n")
+	m := newTestDPDKManager(t, fs)
+
+	if err := m.discoverHugepages(); err != nil {
+		t.Fatalf("discoverHugepages() error = %v", err)
+	}
+	want := []Hugepages{{SizeKB: 2048, Total: 1024, Free: 768, Nodes: []NUMAHugepages{
+		{NUMANode: 0, Total: 512, Free: 256},
+		{NUMANode: 1, Total: 512, Free: 512},
+	}}}
+	if got := m.Hugepages(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Hugepages() = %+v, want %+v", got, want)
+	}
+}
+
+func TestDPDKManagerAccountsHugepages(t *testing.T) {
+	fs := newFakeSysfs(t)
+	fs.pciDevice("0000:3b:00.0", "0x020000", DriverVFIOPCI)
+	fs.pciDevice("0000:3b:00.1", "0x020000", DriverVFIOPCI)
+	fs.pciDevice("0000:5e:00.0", "0x020000", DriverVFIOPCI)
+	fs.write("sys/kernel/mm/hugepages/hugepages-2048kB/nr_hugepages", "512\n")
+	fs.write("sys/kernel/mm/hugepages/hugepages-2048kB/free_hugepages", "512\n")
+	m := newTestDPDKManager(t, fs,
+		hugepagePod("firewall", "2Mi", "768Mi"),
+		hugepagePod("nat", "2Mi", "512Mi"),
+		hugepagePod("router", "1Gi", "1Gi"))
+
+	m.sync()
+	// 384 pages for the firewall, the rest too few for the NAT, no 1Gi pool for the router
+	if _, ok := m.GetDeviceForPod("edge", "firewall"); !ok {
+		t.Fatal("firewall got no device")
+	}
+	for _, pod := range []string{"nat", "router"} {
+		if d, ok := m.GetDeviceForPod("edge", pod); ok {
+			t.Errorf("%s got device %s without its hugepages", pod, d.PCIAddress)
+		}
+	}
+	wantPods := []PodHugepages{{Namespace: "edge", Pod: "firewall", SizeKB: 2048, Pages: 384}}
+	if got := m.Inventory().Pods; !reflect.DeepEqual(got, wantPods) {
+		t.Errorf("Pods = %+v, want %+v", got, wantPods)
+	}
+	if got := m.Hugepages()[0].Accounted; got != 384 {
+		t.Errorf("Accounted = %d, want 384", got)
+	}
+
+	// the pages of a released device go to the waiting pod
+	if !m.ReleaseDevice("edge", "firewall") {
+		t.Fatal("ReleaseDevice() = false")
+	}
+	if err := m.clientset.CoreV1().Pods("edge").Delete(context.Background(), "firewall", metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	m.sync()
+	if _, ok := m.GetDeviceForPod("edge", "nat"); !ok {
+		t.Error("nat got no device once the pages were released")
+	}
+	if got := m.Hugepages()[0].Accounted; got != 256 {
+		t.Errorf("Accounted = %d, want 256", got)
+	}
+}
+
+func TestDPDKManagerPublishesHugepageCondition(t *testing.T) {
+	fs := newFakeSysfs(t)
+	fs.pciDevice("0000:3b:00.0", "0x020000", DriverVFIOPCI)
+	fs.pciDevice("0000:3b:00.1", "0x020000", DriverVFIOPCI)
+	fs.write("sys/kernel/mm/hugepages/hugepages-2048kB/nr_hugepages", "512\n")
+	m := newTestDPDKManager(t, fs, hugepagePod("firewall", "2Mi", "512Mi"))
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "worker-1"}}
+	clientset := m.clientset.(*fake.Clientset)
+	if err := clientset.Tracker().Add(node); err != nil {
+		t.Fatal(err)
+	}
+	m.SetNodeName("worker-1")
+
+	condition := func() corev1.NodeCondition {
+		t.Helper()
+		node, err := clientset.CoreV1().Nodes().Get(context.Background(), "worker-1", metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, c := range node.Status.Conditions {
+			if c.Type == NodeConditionHugepagesExhausted {
+				return c
+			}
+		}
+		t.Fatal("no hugepage condition on the node")
+		return corev1.NodeCondition{}
+	}
+
+	m.sync()
+	if c := condition(); c.Status != corev1.ConditionFalse || c.Message != "2048kB: 256 of 512 pages left" {
+		t.Errorf("condition = %s %q, want False with 256 pages left", c.Status, c.Message)
+	}
+
+	// the NAT takes the pages left
+	if _, err := clientset.CoreV1().Pods("edge").Create(context.Background(), hugepagePod("nat", "2Mi", "512Mi"), metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	m.sync()
+	if c := condition(); c.Status != corev1.ConditionTrue || c.Reason != "HugepagesExhausted" {
+		t.Errorf("condition = %s %s, want True HugepagesExhausted", c.Status, c.Reason)
+	}
+}
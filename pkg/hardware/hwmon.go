@@ -0,0 +1,129 @@
+package hardware
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Sensor kinds
+const (
+	// SensorTemperature reads in degrees Celsius
+	SensorTemperature = "temperature"
+	// SensorPower reads in watts
+	SensorPower = "power"
+)
+
+// Sensor is a temperature or power sensor of a hwmon chip
+type Sensor struct {
+	// hwmon device (e.g., hwmon2)
+	Hwmon string `json:"hwmon"`
+	// Chip name (e.g., coretemp, mlx5, nvme)
+	Chip string `json:"chip"`
+	// Sensor label, or the channel (e.g., temp1) without a label
+	Name string `json:"name"`
+	// Kind of the reading (temperature, power)
+	Kind string `json:"kind"`
+	// Current reading in degrees Celsius or watts
+	Value float64 `json:"value"`
+	// Critical (or else maximum) value the reading must stay below, 0 if unknown
+	Limit float64 `json:"limit,omitempty"`
+}
+
+// hwmon attributes per sensor kind: channel prefix, input attributes in
+// order of preference, limit attributes in order of preference, and the
+// divisor converting the sysfs units (millidegrees, microwatts)
+var hwmonKinds = []struct {
+	kind    string
+	prefix  string
+	inputs  []string
+	limits  []string
+	divisor float64
+}{
+	{SensorTemperature, "temp", []string{"input"}, []string{"crit", "max"}, 1000},
+	{SensorPower, "power", []string{"input", "average"}, []string{"cap", "max"}, 1000000},
+}
+
+// ReadSensors reads the temperature and power sensors of the hwmon chips
+// (board, CPU and the NICs with a hwmon driver) under the root
+func ReadSensors(root string) ([]Sensor, error) {
+	chips, err := filepath.Glob(filepath.Join(root, "sys/class/hwmon/hwmon*"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to glob hwmon devices: %w", err)
+	}
+
+	var sensors []Sensor
+	for _, chip := range chips {
+		name := readAttr(chip, "name")
+		if name == "" {
+			name = filepath.Base(chip)
+		}
+
+		for _, k := range hwmonKinds {
+			channels, _ := filepath.Glob(filepath.Join(chip, k.prefix+"*_"+k.inputs[0]))
+			for _, extra := range k.inputs[1:] {
+				more, _ := filepath.Glob(filepath.Join(chip, k.prefix+"*_"+extra))
+				channels = append(channels, more...)
+			}
+
+			seen := make(map[string]bool)
+			for _, path := range channels {
+				channel, _, _ := strings.Cut(filepath.Base(path), "_")
+				if seen[channel] {
+					continue
+				}
+				seen[channel] = true
+
+				value, ok := readValue(chip, channel, k.inputs)
+				if !ok {
+					continue
+				}
+				sensor := Sensor{
+					Hwmon: filepath.Base(chip),
+					Chip:  name,
+					Name:  readAttr(chip, channel+"_label"),
+					Kind:  k.kind,
+					Value: value / k.divisor,
+				}
+				if sensor.Name == "" {
+					sensor.Name = channel
+				}
+				if limit, ok := readValue(chip, channel, k.limits); ok && limit > 0 {
+					sensor.Limit = limit / k.divisor
+				}
+				sensors = append(sensors, sensor)
+			}
+		}
+	}
+
+	sort.Slice(sensors, func(i, j int) bool {
+		if sensors[i].Hwmon != sensors[j].Hwmon {
+			return sensors[i].Hwmon < sensors[j].Hwmon
+		}
+		return sensors[i].Name < sensors[j].Name
+	})
+	return sensors, nil
+}
+
+// readAttr reads a hwmon attribute, empty if it doesn't exist
+func readAttr(chip, attr string) string {
+	data, err := os.ReadFile(filepath.Join(chip, attr))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// readValue reads the first readable numeric attribute of a channel
+func readValue(chip, channel string, attrs []string) (float64, bool) {
+	for _, attr := range attrs {
+		value, err := strconv.ParseFloat(readAttr(chip, channel+"_"+attr), 64)
+		if err == nil {
+			return value, true
+		}
+	}
+	return 0, false
+}
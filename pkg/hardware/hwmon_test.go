@@ -0,0 +1,45 @@
+package hardware
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestReadSensors(t *testing.T) {
+	fs := newFakeSysfs(t)
+	// board sensor with a critical limit
+	fs.write("sys/class/hwmon/hwmon0/name", "coretemp\n")
+	fs.write("sys/class/hwmon/hwmon0/temp1_input", "71500\n")
+	fs.write("sys/class/hwmon/hwmon0/temp1_label", "Package id 0\n")
+	fs.write("sys/class/hwmon/hwmon0/temp1_crit", "100000\n")
+	fs.write("sys/class/hwmon/hwmon0/temp1_max", "84000\n")
+	// NIC sensor with only a maximum, and an unreadable channel
+	fs.write("sys/class/hwmon/hwmon1/name", "mlx5\n")
+	fs.write("sys/class/hwmon/hwmon1/temp1_input", "65000\n")
+	fs.write("sys/class/hwmon/hwmon1/temp1_max", "105000\n")
+	fs.write("sys/class/hwmon/hwmon1/temp2_input", "\n")
+	// power sensor reporting an average
+	fs.write("sys/class/hwmon/hwmon2/name", "ina3221\n")
+	fs.write("sys/class/hwmon/hwmon2/power1_average", "4250000\n")
+	fs.write("sys/class/hwmon/hwmon2/power1_label", "VDD_IN\n")
+
+	sensors, err := ReadSensors(fs.root)
+	if err != nil {
+		t.Fatalf("ReadSensors() error = %v", err)
+	}
+	want := []Sensor{
+		{Hwmon: "hwmon0", Chip: "coretemp", Name: "Package id 0", Kind: SensorTemperature, Value: 71.5, Limit: 100},
+		{Hwmon: "hwmon1", Chip: "mlx5", Name: "temp1", Kind: SensorTemperature, Value: 65, Limit: 105},
+		{Hwmon: "hwmon2", Chip: "ina3221", Name: "VDD_IN", Kind: SensorPower, Value: 4.25},
+	}
+	if !reflect.DeepEqual(sensors, want) {
+		t.Errorf("ReadSensors() = %+v, want %+v", sensors, want)
+	}
+}
+
+func TestReadSensorsWithoutHwmon(t *testing.T) {
+	sensors, err := ReadSensors(newFakeSysfs(t).root)
+	if err != nil || len(sensors) != 0 {
+		t.Errorf("ReadSensors() = %v, %v, want no sensors", sensors, err)
+	}
+}
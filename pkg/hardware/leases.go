@@ -0,0 +1,209 @@
+package hardware
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ErrNoFreeVF is returned when a lease is requested but every VF is allocated
+var ErrNoFreeVF = errors.New("no free VF")
+
+// Lease is a VF allocated to a pod for a limited time, for batch jobs and
+// external allocators. It is renewed while the pod exists or explicitly,
+// and the VF is freed once it expires, so a crashed allocator can't leak it.
+type Lease struct {
+	// Namespace of the pod holding the lease
+	Namespace string `json:"namespace"`
+	// Pod holding the lease, it needn't exist yet
+	Pod string `json:"pod"`
+	// VF leased (e.g., eth0-vf1)
+	VF string `json:"vf"`
+	// PCI address of the VF
+	PCIAddress string `json:"pciAddress"`
+	// Seconds the lease lasts without being renewed
+	TTLSeconds int `json:"ttlSeconds"`
+	// Time the lease expires unless renewed
+	Expires time.Time `json:"expires"`
+}
+
+// LeaseRequest requests a VF lease for a pod
+type LeaseRequest struct {
+	// Namespace of the pod
+	Namespace string `json:"namespace"`
+	// Pod the VF is leased to
+	Pod string `json:"pod"`
+	// Seconds the lease lasts without being renewed
+	TTLSeconds int `json:"ttlSeconds"`
+}
+
+// LeaseVF allocates a free VF to a pod until the TTL passes without a
+// renewal. A pod already holding a lease gets it renewed with the TTL.
+func (m *SRIOVManager) LeaseVF(namespace, podName string, ttl time.Duration) (Lease, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	defer m.checkpoint()
+
+	now := time.Now()
+	for key, vf := range m.vfInventory {
+		if vf.Allocated && vf.AllocatedTo == podName && vf.Namespace == namespace {
+			if vf.LeaseTTL == 0 {
+				return Lease{}, fmt.Errorf("pod %s/%s holds VF %s as it requests one with the label, not through a lease", namespace, podName, key)
+			}
+			vf.LeaseTTL, vf.LeaseExpires = ttl, now.Add(ttl)
+			m.vfInventory[key] = vf
+			return lease(key, vf), nil
+		}
+	}
+
+	for _, key := range m.keysByPCIAddress() {
+		vf := m.vfInventory[key]
+		if vf.Allocated {
+			continue
+		}
+		vf.Allocated = true
+		vf.AllocatedTo = podName
+		vf.Namespace = namespace
+		vf.LeaseTTL, vf.LeaseExpires = ttl, now.Add(ttl)
+		m.vfInventory[key] = vf
+		if name, ok := m.downed[key]; ok {
+			m.linkUp(key, name)
+		}
+
+		vfAllocations.WithLabelValues(vf.PFName).Inc()
+		m.logger.Infof("Leased VF %s to pod %s/%s for %s", key, namespace, podName, ttl)
+		m.decisions.record(namespace, podName, Decision{
+			Reason:     ReasonLeased,
+			Message:    fmt.Sprintf("leased free VF %s of %s for %s", key, vf.PFName, ttl),
+			Allocated:  true,
+			VF:         key,
+			PCIAddress: vf.PCIAddress,
+		}, now)
+		return lease(key, vf), nil
+	}
+
+	m.decisions.record(namespace, podName, Decision{
+		Reason:  ReasonNoFreeVF,
+		Message: noFreeVFMessage(m.vfInventory),
+	}, now)
+	return Lease{}, ErrNoFreeVF
+}
+
+// RenewLease extends the lease of a pod by its TTL, false if it holds none
+func (m *SRIOVManager) RenewLease(namespace, podName string) (Lease, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for key, vf := range m.vfInventory {
+		if vf.Allocated && vf.LeaseTTL > 0 && vf.AllocatedTo == podName && vf.Namespace == namespace {
+			vf.LeaseExpires = time.Now().Add(vf.LeaseTTL)
+			m.vfInventory[key] = vf
+			return lease(key, vf), true
+		}
+	}
+	return Lease{}, false
+}
+
+// ReleaseLease frees the VF leased to a pod, false if it holds no lease
+func (m *SRIOVManager) ReleaseLease(namespace, podName string) bool {
+	m.mu.RLock()
+	leased := false
+	for _, vf := range m.vfInventory {
+		if vf.Allocated && vf.LeaseTTL > 0 && vf.AllocatedTo == podName && vf.Namespace == namespace {
+			leased = true
+		}
+	}
+	m.mu.RUnlock()
+	return leased && m.ReleaseVF(namespace, podName)
+}
+
+// Leases returns the VF leases, ordered by PCI address
+func (m *SRIOVManager) Leases() []Lease {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	leases := []Lease{}
+	for _, key := range m.keysByPCIAddress() {
+		if vf := m.vfInventory[key]; vf.Allocated && vf.LeaseTTL > 0 {
+			leases = append(leases, lease(key, vf))
+		}
+	}
+	return leases
+}
+
+// existingLeaseHolders returns the leaseholder pods that exist, by namespace/name
+func (m *SRIOVManager) existingLeaseHolders() (map[string]bool, error) {
+	m.mu.RLock()
+	var holders []VirtualFunction
+	for _, vf := range m.vfInventory {
+		if vf.Allocated && vf.LeaseTTL > 0 {
+			holders = append(holders, vf)
+		}
+	}
+	m.mu.RUnlock()
+
+	exists := make(map[string]bool, len(holders))
+	for _, vf := range holders {
+		_, err := m.clientset.CoreV1().Pods(vf.Namespace).Get(m.ctx, vf.AllocatedTo, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get leaseholder pod %s/%s: %w", vf.Namespace, vf.AllocatedTo, err)
+		}
+		exists[vf.Namespace+"/"+vf.AllocatedTo] = true
+	}
+	return exists, nil
+}
+
+// expireLease renews the lease of a VF whose pod exists and frees the VF
+// once the lease expired, returning whether it is still allocated. The
+// mutex must be held.
+func (m *SRIOVManager) expireLease(key string, vf VirtualFunction, holders map[string]bool, now time.Time) bool {
+	if holders[vf.Namespace+"/"+vf.AllocatedTo] {
+		vf.LeaseExpires = now.Add(vf.LeaseTTL)
+		m.vfInventory[key] = vf
+		return true
+	}
+	if now.Before(vf.LeaseExpires) {
+		return true
+	}
+
+	m.logger.Warnf("Lease of VF %s by pod %s/%s expired", key, vf.Namespace, vf.AllocatedTo)
+	m.decisions.record(vf.Namespace, vf.AllocatedTo, Decision{
+		Reason:     ReasonLeaseExpired,
+		Message:    fmt.Sprintf("lease not renewed within %s, freed VF %s", vf.LeaseTTL, key),
+		VF:         key,
+		PCIAddress: vf.PCIAddress,
+	}, now)
+	m.vfInventory[key] = freeVF(vf)
+	vfReleases.WithLabelValues(vf.PFName, releaseLeaseExpired).Inc()
+	return false
+}
+
+// freeVF returns the VF without its allocation
+func freeVF(vf VirtualFunction) VirtualFunction {
+	vf.Allocated = false
+	vf.AllocatedTo = ""
+	vf.Namespace = ""
+	vf.LeaseTTL = 0
+	vf.LeaseExpires = time.Time{}
+	vf.Netns = ""
+	vf.PodInterface = ""
+	return vf
+}
+
+// lease converts a leased VF
+func lease(key string, vf VirtualFunction) Lease {
+	return Lease{
+		Namespace:  vf.Namespace,
+		Pod:        vf.AllocatedTo,
+		VF:         key,
+		PCIAddress: vf.PCIAddress,
+		TTLSeconds: int(vf.LeaseTTL / time.Second),
+		Expires:    vf.LeaseExpires,
+	}
+}
@@ -0,0 +1,72 @@
+package hardware
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestSRIOVManagerLeases(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	// the batch pod exists without requesting a VF with the label
+	batch := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "batch", Namespace: "jobs"}}
+	m := NewSRIOVManager(context.Background(), fake.NewSimpleClientset(batch), logger)
+	m.links = &recordingLinks{}
+	m.vfInventory = map[string]VirtualFunction{
+		"eth0-vf0": {PFName: "eth0", VFID: 0, PCIAddress: "0000:3b:02.0"},
+		"eth0-vf1": {PFName: "eth0", VFID: 1, PCIAddress: "0000:3b:02.1"},
+	}
+
+	if _, err := m.LeaseVF("jobs", "batch", time.Minute); err != nil {
+		t.Fatalf("LeaseVF() error = %v", err)
+	}
+	crashed, err := m.LeaseVF("jobs", "crashed", time.Minute)
+	if err != nil {
+		t.Fatalf("LeaseVF() error = %v", err)
+	}
+	if _, err := m.LeaseVF("jobs", "third", time.Minute); !errors.Is(err, ErrNoFreeVF) {
+		t.Errorf("LeaseVF() error = %v, want ErrNoFreeVF", err)
+	}
+
+	// the leases outlive pods gone or not yet created until they expire
+	if err := m.reconcileAllocations(); err != nil {
+		t.Fatalf("reconcileAllocations() error = %v", err)
+	}
+	if leases := m.Leases(); len(leases) != 2 {
+		t.Fatalf("Leases() = %+v, want both leases kept", leases)
+	}
+
+	// the existing pod renews its lease, the other one expires
+	m.mu.Lock()
+	for key, vf := range m.vfInventory {
+		vf.LeaseExpires = time.Now().Add(-time.Second)
+		m.vfInventory[key] = vf
+	}
+	m.mu.Unlock()
+	if err := m.reconcileAllocations(); err != nil {
+		t.Fatalf("reconcileAllocations() error = %v", err)
+	}
+	leases := m.Leases()
+	if len(leases) != 1 || leases[0].Pod != "batch" || !leases[0].Expires.After(time.Now()) {
+		t.Fatalf("Leases() = %+v, want the renewed lease of the existing pod", leases)
+	}
+	if exp, _ := m.Explain("jobs", "crashed"); exp.Decisions[0].Reason != ReasonLeaseExpired || exp.Decisions[0].VF != crashed.VF {
+		t.Errorf("unexpected decisions %+v", exp.Decisions)
+	}
+
+	// renewals through the API
+	if _, ok := m.RenewLease("jobs", "crashed"); ok {
+		t.Error("RenewLease() renewed an expired lease")
+	}
+	if lease, ok := m.RenewLease("jobs", "batch"); !ok || lease.TTLSeconds != 60 {
+		t.Errorf("RenewLease() = %+v, %t", lease, ok)
+	}
+}
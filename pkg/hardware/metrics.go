@@ -0,0 +1,137 @@
+package hardware
+
+import (
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Reasons VFs are released
+const (
+	releaseRequested    = "requested"
+	releasePodGone      = "pod_gone"
+	releaseLeaseExpired = "lease_expired"
+)
+
+// Reasons pods are refused a DPDK device
+const (
+	refusalNoHugepages        = "no_hugepages"
+	refusalHugepagesExhausted = "hugepages_exhausted"
+	refusalNoDevice           = "no_device"
+)
+
+var (
+	vfAllocations = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "nsm_vf_allocations_total",
+			Help: "VFs allocated to pods, by PF",
+		},
+		[]string{"pf"},
+	)
+	vfReleases = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "nsm_vf_releases_total",
+			Help: "VFs released, by PF and reason (requested, pod_gone, lease_expired)",
+		},
+		[]string{"pf", "reason"},
+	)
+
+	vfsDesc = prometheus.NewDesc("nsm_vfs", "VFs in the inventory, by PF and state (free, allocated)",
+		[]string{"pf", "state"}, nil)
+	pfVFsDesc = prometheus.NewDesc("nsm_pf_num_vfs", "VFs enabled on a PF",
+		[]string{"pf"}, nil)
+
+	dpdkRefusals = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "nsm_dpdk_allocation_refusals_total",
+			Help: "DPDK devices refused to pods, by reason (no_hugepages, hugepages_exhausted, no_device)",
+		},
+		[]string{"reason"},
+	)
+
+	dpdkDevicesDesc = prometheus.NewDesc("nsm_dpdk_devices", "DPDK devices, by state (free, allocated)",
+		[]string{"state"}, nil)
+	hugepagesDesc = prometheus.NewDesc("nsm_hugepages", "Hugepages of the node, by page size in kB and state (total, free, accounted)",
+		[]string{"size_kb", "state"}, nil)
+	numaHugepagesDesc = prometheus.NewDesc("nsm_numa_hugepages", "Hugepages of a NUMA node, by page size in kB and state (total, free)",
+		[]string{"size_kb", "numa_node", "state"}, nil)
+)
+
+// Collectors returns the metrics of the SR-IOV manager: the VF inventory
+// counts and the allocation and release totals
+func (m *SRIOVManager) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{vfAllocations, vfReleases, inventoryCollector{m}}
+}
+
+// inventoryCollector counts the VFs of the inventory when scraped
+type inventoryCollector struct {
+	manager *SRIOVManager
+}
+
+// Describe implements prometheus.Collector
+func (c inventoryCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- vfsDesc
+	ch <- pfVFsDesc
+}
+
+// Collect implements prometheus.Collector
+func (c inventoryCollector) Collect(ch chan<- prometheus.Metric) {
+	allocated := make(map[string]int)
+	free := make(map[string]int)
+	for _, vf := range c.manager.VirtualFunctions() {
+		if vf.Allocated {
+			allocated[vf.PFName]++
+		} else {
+			free[vf.PFName]++
+		}
+	}
+	for _, pf := range c.manager.PhysicalFunctions() {
+		ch <- prometheus.MustNewConstMetric(pfVFsDesc, prometheus.GaugeValue, float64(pf.NumVFs), pf.Name)
+		ch <- prometheus.MustNewConstMetric(vfsDesc, prometheus.GaugeValue, float64(free[pf.Name]), pf.Name, "free")
+		ch <- prometheus.MustNewConstMetric(vfsDesc, prometheus.GaugeValue, float64(allocated[pf.Name]), pf.Name, "allocated")
+	}
+}
+
+// Collectors returns the metrics of the DPDK manager: the device and
+// hugepage counts and the refusal totals
+func (m *DPDKManager) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{dpdkRefusals, dpdkCollector{m}}
+}
+
+// dpdkCollector counts the DPDK devices and the hugepages when scraped
+type dpdkCollector struct {
+	manager *DPDKManager
+}
+
+// Describe implements prometheus.Collector
+func (c dpdkCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- dpdkDevicesDesc
+	ch <- hugepagesDesc
+	ch <- numaHugepagesDesc
+}
+
+// Collect implements prometheus.Collector
+func (c dpdkCollector) Collect(ch chan<- prometheus.Metric) {
+	allocated, free := 0, 0
+	for _, d := range c.manager.Devices() {
+		if d.Allocated {
+			allocated++
+		} else {
+			free++
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(dpdkDevicesDesc, prometheus.GaugeValue, float64(free), "free")
+	ch <- prometheus.MustNewConstMetric(dpdkDevicesDesc, prometheus.GaugeValue, float64(allocated), "allocated")
+
+	for _, hp := range c.manager.Hugepages() {
+		size := strconv.Itoa(hp.SizeKB)
+		ch <- prometheus.MustNewConstMetric(hugepagesDesc, prometheus.GaugeValue, float64(hp.Total), size, "total")
+		ch <- prometheus.MustNewConstMetric(hugepagesDesc, prometheus.GaugeValue, float64(hp.Free), size, "free")
+		ch <- prometheus.MustNewConstMetric(hugepagesDesc, prometheus.GaugeValue, float64(hp.Accounted), size, "accounted")
+		for _, n := range hp.Nodes {
+			node := strconv.Itoa(n.NUMANode)
+			ch <- prometheus.MustNewConstMetric(numaHugepagesDesc, prometheus.GaugeValue, float64(n.Total), size, node, "total")
+			ch <- prometheus.MustNewConstMetric(numaHugepagesDesc, prometheus.GaugeValue, float64(n.Free), size, node, "free")
+		}
+	}
+}
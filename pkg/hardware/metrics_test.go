@@ -0,0 +1,93 @@
+package hardware
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestSRIOVManagerCollectors(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	m := NewSRIOVManager(context.Background(), fake.NewSimpleClientset(sriovPod("camera")), logger)
+	m.links = &recordingLinks{}
+	m.pfInventory = map[string]PhysicalFunction{"eth0": {Name: "eth0", NumVFs: 2}}
+	m.vfInventory = map[string]VirtualFunction{
+		"eth0-vf0": {PFName: "eth0", VFID: 0, PCIAddress: "0000:3b:02.0"},
+		"eth0-vf1": {PFName: "eth0", VFID: 1, PCIAddress: "0000:3b:02.1"},
+	}
+
+	allocations := testutil.ToFloat64(vfAllocations.WithLabelValues("eth0"))
+	releases := testutil.ToFloat64(vfReleases.WithLabelValues("eth0", releaseRequested))
+	if err := m.reconcileAllocations(); err != nil {
+		t.Fatalf("reconcileAllocations() error = %v", err)
+	}
+	if !m.ReleaseVF("edge", "camera") {
+		t.Fatal("camera holds no VF")
+	}
+	if got := testutil.ToFloat64(vfAllocations.WithLabelValues("eth0")) - allocations; got != 1 {
+		t.Errorf("counted %v allocations, want 1", got)
+	}
+	if got := testutil.ToFloat64(vfReleases.WithLabelValues("eth0", releaseRequested)) - releases; got != 1 {
+		t.Errorf("counted %v releases, want 1", got)
+	}
+
+	// the inventory is counted when scraped
+	if err := m.reconcileAllocations(); err != nil {
+		t.Fatalf("reconcileAllocations() error = %v", err)
+	}
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(m.Collectors()...)
+	want := `
+# HELP nsm_vfs VFs in the inventory, by PF and state (free, allocated)
+# TYPE nsm_vfs gauge
+nsm_vfs{pf="eth0",state="allocated"} 1
+nsm_vfs{pf="eth0",state="free"} 1
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(want), "nsm_vfs"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDPDKManagerCollectors(t *testing.T) {
+	fs := newFakeSysfs(t)
+	fs.pciDevice("0000:3b:00.0", "0x020000", DriverVFIOPCI)
+	fs.write("sys/kernel/mm/hugepages/hugepages-2048kB/nr_hugepages", "512\n")
+	fs.write("sys/kernel/mm/hugepages/hugepages-2048kB/free_hugepages", "512\n")
+	fs.write("sys/devices/system/node/node0/hugepages/hugepages-2048kB/nr_hugepages", "512\n")
+	fs.write("sys/devices/system/node/node0/hugepages/hugepages-2048kB/free_hugepages", "512\n")
+	m := newTestDPDKManager(t, fs, hugepagePod("firewall", "2Mi", "256Mi"), hugepagePod("nat", "2Mi", "256Mi"))
+
+	refusals := testutil.ToFloat64(dpdkRefusals.WithLabelValues(refusalNoDevice))
+	m.sync()
+	if got := testutil.ToFloat64(dpdkRefusals.WithLabelValues(refusalNoDevice)) - refusals; got != 1 {
+		t.Errorf("counted %v refusals, want 1", got)
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(m.Collectors()...)
+	want := `
+# HELP nsm_dpdk_devices DPDK devices, by state (free, allocated)
+# TYPE nsm_dpdk_devices gauge
+nsm_dpdk_devices{state="allocated"} 1
+nsm_dpdk_devices{state="free"} 0
+# HELP nsm_hugepages Hugepages of the node, by page size in kB and state (total, free, accounted)
+# TYPE nsm_hugepages gauge
+nsm_hugepages{size_kb="2048",state="accounted"} 128
+nsm_hugepages{size_kb="2048",state="free"} 512
+nsm_hugepages{size_kb="2048",state="total"} 512
+# HELP nsm_numa_hugepages Hugepages of a NUMA node, by page size in kB and state (total, free)
+# TYPE nsm_numa_hugepages gauge
+nsm_numa_hugepages{numa_node="0",size_kb="2048",state="free"} 512
+nsm_numa_hugepages{numa_node="0",size_kb="2048",state="total"} 512
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(want), "nsm_dpdk_devices", "nsm_hugepages", "nsm_numa_hugepages"); err != nil {
+		t.Error(err)
+	}
+}
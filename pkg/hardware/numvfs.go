@@ -0,0 +1,90 @@
+package hardware
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/akos011221/nsm/pkg/disruption"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// Disrupter runs disruptive changes past the disruption budgets of the
+// pods they affect
+type Disrupter interface {
+	Disrupt(change disruption.Change, affected func() []types.NamespacedName, apply func() error) error
+}
+
+// NumVFsRequest requests a change of the number of VFs of a PF
+type NumVFsRequest struct {
+	// Number of VFs, 0 to remove them all
+	NumVFs int `json:"numVFs"`
+}
+
+// SetDisrupter makes the manager evict the pods using the VFs of a PF,
+// respecting their disruption budgets, before changing the PF
+func (m *SRIOVManager) SetDisrupter(d Disrupter) {
+	m.disrupter = d
+}
+
+// PodsOnPF returns the pods the VFs of a PF are allocated to
+func (m *SRIOVManager) PodsOnPF(pfName string) []types.NamespacedName {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var pods []types.NamespacedName
+	for _, vf := range m.vfInventory {
+		if vf.Allocated && vf.PFName == pfName {
+			pods = append(pods, types.NamespacedName{Namespace: vf.Namespace, Name: vf.AllocatedTo})
+		}
+	}
+	sort.Slice(pods, func(i, j int) bool { return pods[i].String() < pods[j].String() })
+	return pods
+}
+
+// SetNumVFs changes the number of VFs of a PF. The kernel only changes it
+// through 0, removing every VF of the PF, so the pods using them are
+// evicted first. Without a disrupter the change is refused while any VF
+// of the PF is allocated. A *disruption.BlockedError is returned when the
+// disruption budgets of the pods don't allow the change, and
+// ErrProvisionedExternally when another controller provisions the PF.
+func (m *SRIOVManager) SetNumVFs(pfName string, numVFs int) error {
+	devicePath := m.path("sys/class/net", pfName, "device")
+	total := readInt(devicePath+"/sriov_totalvfs", -1)
+	if total < 0 {
+		return fmt.Errorf("%s is not an SR-IOV capable PF", pfName)
+	}
+	if err := m.checkProvisioned(pfName); err != nil {
+		return err
+	}
+	if numVFs < 0 || numVFs > total {
+		return fmt.Errorf("invalid number of VFs %d for %s, must be between 0 and %d", numVFs, pfName, total)
+	}
+
+	affected := func() []types.NamespacedName { return m.PodsOnPF(pfName) }
+	apply := func() error {
+		numVFsPath := devicePath + "/sriov_numvfs"
+		if err := os.WriteFile(numVFsPath, []byte("0"), 0o200); err != nil {
+			return fmt.Errorf("failed to remove the VFs of %s: %w", pfName, err)
+		}
+		if numVFs > 0 {
+			if err := os.WriteFile(numVFsPath, []byte(strconv.Itoa(numVFs)), 0o200); err != nil {
+				return fmt.Errorf("failed to create %d VFs on %s: %w", numVFs, pfName, err)
+			}
+		}
+		m.logger.Infof("Changed the number of VFs of %s to %d", pfName, numVFs)
+
+		// rediscover the VFs right away
+		m.Resync()
+		return nil
+	}
+
+	if m.disrupter == nil {
+		if pods := affected(); len(pods) > 0 {
+			return fmt.Errorf("%d VFs of %s are allocated to pods, refusing to remove them without disruption coordination", len(pods), pfName)
+		}
+		return apply()
+	}
+	return m.disrupter.Disrupt(disruption.Change{Kind: disruption.KindVFCountChange, Device: pfName}, affected, apply)
+}
@@ -0,0 +1,79 @@
+package hardware
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/akos011221/nsm/pkg/disruption"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// fakeDisrupter records the changes and applies them if allowed
+type fakeDisrupter struct {
+	allow    bool
+	changes  []disruption.Change
+	affected []types.NamespacedName
+}
+
+func (d *fakeDisrupter) Disrupt(change disruption.Change, affected func() []types.NamespacedName, apply func() error) error {
+	d.changes = append(d.changes, change)
+	d.affected = affected()
+	if !d.allow {
+		return &disruption.BlockedError{Change: change, Deferred: true}
+	}
+	return apply()
+}
+
+func TestSRIOVManagerSetNumVFs(t *testing.T) {
+	fs := newFakeSysfs(t)
+	fs.write("sys/class/net/eth0/device/sriov_totalvfs", "8\n")
+	fs.write("sys/class/net/eth0/device/sriov_numvfs", "2\n")
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	m := NewSRIOVManager(context.Background(), nil, logger)
+	m.root = fs.root
+	m.vfInventory = map[string]VirtualFunction{
+		"eth0-vf0": {PFName: "eth0", VFID: 0, Allocated: true, AllocatedTo: "camera", Namespace: "edge"},
+		"eth0-vf1": {PFName: "eth0", VFID: 1},
+		"eth1-vf0": {PFName: "eth1", VFID: 0, Allocated: true, AllocatedTo: "router", Namespace: "edge"},
+	}
+	numVFs := func() string {
+		data, err := os.ReadFile(filepath.Join(fs.root, "sys/class/net/eth0/device/sriov_numvfs"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+
+	if err := m.SetNumVFs("eth0", 16); err == nil {
+		t.Error("SetNumVFs() accepted more VFs than the PF supports")
+	}
+	if err := m.SetNumVFs("eth9", 1); err == nil {
+		t.Error("SetNumVFs() accepted a PF without SR-IOV")
+	}
+
+	// without coordination the VFs aren't removed from under the pods
+	if err := m.SetNumVFs("eth0", 4); err == nil || numVFs() != "2\n" {
+		t.Fatalf("SetNumVFs() = %v with a VF allocated, sriov_numvfs = %q", err, numVFs())
+	}
+
+	d := &fakeDisrupter{}
+	m.SetDisrupter(d)
+	if err := m.SetNumVFs("eth0", 4); err == nil || numVFs() != "2\n" {
+		t.Fatalf("SetNumVFs() = %v with the change blocked, sriov_numvfs = %q", err, numVFs())
+	}
+	want := []types.NamespacedName{{Namespace: "edge", Name: "camera"}}
+	if !reflect.DeepEqual(d.affected, want) || d.changes[0].Kind != disruption.KindVFCountChange {
+		t.Errorf("disrupted %+v affecting %v, want a VF count change affecting %v", d.changes, d.affected, want)
+	}
+
+	d.allow = true
+	if err := m.SetNumVFs("eth0", 4); err != nil || numVFs() != "4" {
+		t.Fatalf("SetNumVFs() = %v, sriov_numvfs = %q, want 4", err, numVFs())
+	}
+}
@@ -0,0 +1,313 @@
+package hardware
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/akos011221/nsm/pkg/disruption"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// Quiescer takes the connections of pods off their VFs while the PF of the
+// VFs is reset, and puts them back afterwards
+type Quiescer interface {
+	// Quiesce tears down the connections of the pods, keeping their
+	// allocations, and waits until they are down
+	Quiesce(ctx context.Context, pods []types.NamespacedName) error
+	// Resume lets the connections of the pods be set up again
+	Resume(pods []types.NamespacedName)
+}
+
+// VFPlumber moves the VFs of pods out of their pods before a PF reset and
+// back in, configured as they were, once the VFs are recreated
+type VFPlumber interface {
+	// Detach saves the configuration of a VF, on its PF and in its pod,
+	// and returns the VF to the host
+	Detach(vf VirtualFunction) (*VFConfig, error)
+	// Attach restores the configuration of a recreated VF and moves it
+	// back into its pod
+	Attach(vf VirtualFunction, cfg *VFConfig) error
+}
+
+// VFConfig is the configuration of a VF restored after a PF reset
+type VFConfig struct {
+	// MAC address set on the PF for the VF, empty if none was set
+	MAC string
+	// VLAN and 802.1p priority set on the PF for the VF, 0 for none
+	VLAN int
+	QoS  int
+	// Whether the PF checks the source MAC of the VF's packets
+	SpoofCheck bool
+	// Whether the VF may change its MAC and receive promiscuous traffic
+	Trust bool
+	// Transmit rate limit in Mbps, 0 for none
+	MaxTxRateMbps int
+	// Guaranteed transmit rate in Mbps, 0 for none
+	MinTxRateMbps int
+	// MTU of the VF in the pod, 0 if it wasn't attached
+	MTU int
+	// Addresses (CIDR) of the VF in the pod
+	Addresses []string
+	// Routes over the VF in the pod
+	Routes []VFRoute
+}
+
+// VFRoute is a route over a VF in a pod
+type VFRoute struct {
+	// Destination prefix (CIDR)
+	Destination string
+	// Gateway address, empty for on-link routes
+	Gateway string
+}
+
+// ResetReport describes a PF reset
+type ResetReport struct {
+	// PF reset
+	PF string `json:"pf"`
+	// Number of VFs recreated on the PF
+	NumVFs int `json:"numVFs"`
+	// VFs re-attached to their pods
+	Reparented []string `json:"reparented,omitempty"`
+	// VFs that couldn't be re-attached, with the reason, by VF
+	Failed map[string]string `json:"failed,omitempty"`
+	// Time the connections of the pods were quiesced for, in milliseconds
+	DowntimeMs int64 `json:"downtimeMs"`
+}
+
+// SetReparenting makes the manager keep the pods through PF resets: the
+// connections of the pods are quiesced while their VFs are detached, the
+// PF reset and the VFs re-attached, instead of evicting the pods
+func (m *SRIOVManager) SetReparenting(plumber VFPlumber, quiescer Quiescer) {
+	m.plumber = plumber
+	m.quiescer = quiescer
+}
+
+// SetAttachment records the network namespace and interface name a VF was
+// handed to its pod with, false if the pod holds no VF. Of the VFs of a
+// pod holding several, it is recorded on the one PickVF picks.
+func (m *SRIOVManager) SetAttachment(namespace, podName, netns, ifName string) bool {
+	vf, ok := PickVF(m.GetVFsForPod(namespace, podName), ifName)
+	if !ok {
+		return false
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	defer m.checkpoint()
+
+	for key, current := range m.vfInventory {
+		if current.PCIAddress == vf.PCIAddress && current.Allocated && current.AllocatedTo == podName && current.Namespace == namespace {
+			current.Netns = netns
+			current.PodInterface = ifName
+			m.vfInventory[key] = current
+			return true
+		}
+	}
+	return false
+}
+
+// PickVF returns the VF of a pod handed to it as the interface ifName: the
+// VF handed out as ifName before, else the first not handed out yet, else
+// the first. A pod holding several VFs gets one per interface.
+func PickVF(vfs []VirtualFunction, ifName string) (VirtualFunction, bool) {
+	if len(vfs) == 0 {
+		return VirtualFunction{}, false
+	}
+	for _, vf := range vfs {
+		if ifName != "" && vf.PodInterface == ifName {
+			return vf, true
+		}
+	}
+	for _, vf := range vfs {
+		if vf.PodInterface == "" {
+			return vf, true
+		}
+	}
+	return vfs[0], true
+}
+
+// ResetPF resets a PF, e.g., to activate new firmware. The kernel removes
+// the VFs of a PF to reset it. With reparenting, the VFs of the pods are
+// detached and re-attached to them with their configuration, keeping the
+// allocations, so the pods only miss their traffic for the reset. Without
+// it the pods are evicted within their disruption budgets like for
+// SetNumVFs.
+func (m *SRIOVManager) ResetPF(pfName string) (*ResetReport, error) {
+	return m.MaintainPF(pfName, disruption.KindPFReset, nil)
+}
+
+// MaintainPF runs disruptive maintenance of a PF, e.g., a firmware flash:
+// the VFs are drained as for ResetPF, work runs while the PF has no VFs,
+// then the PF is reset and the VFs restored. When work fails the PF isn't
+// reset, the VFs are restored and the error returned with the report.
+// The VFs are recreated through sriov_numvfs, so PFs another controller
+// provisions are refused with ErrProvisionedExternally.
+func (m *SRIOVManager) MaintainPF(pfName, kind string, work func() error) (*ResetReport, error) {
+	devicePath := m.path("sys/class/net", pfName, "device")
+	numVFs := readInt(devicePath+"/sriov_numvfs", -1)
+	if numVFs < 0 {
+		return nil, fmt.Errorf("%s is not an SR-IOV capable PF", pfName)
+	}
+	if err := m.checkProvisioned(pfName); err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(devicePath + "/reset"); err != nil {
+		return nil, fmt.Errorf("%s can't be reset: %w", pfName, err)
+	}
+
+	if m.plumber == nil {
+		report := &ResetReport{PF: pfName, NumVFs: numVFs}
+		affected := func() []types.NamespacedName { return m.PodsOnPF(pfName) }
+		apply := func() error {
+			_, err := m.resetPF(pfName, numVFs, work)
+			return err
+		}
+		if m.disrupter == nil {
+			if pods := affected(); len(pods) > 0 {
+				return nil, fmt.Errorf("%d VFs of %s are allocated to pods, refusing to %s it without disruption coordination", len(pods), pfName, kindVerb(kind))
+			}
+			return report, apply()
+		}
+		return report, m.disrupter.Disrupt(disruption.Change{Kind: kind, Device: pfName}, affected, apply)
+	}
+	return m.reparent(pfName, numVFs, work)
+}
+
+// kindVerb describes a kind of disruptive change for errors
+func kindVerb(kind string) string {
+	if kind == disruption.KindFirmwareUpdate {
+		return "update the firmware of"
+	}
+	return "reset"
+}
+
+// reparent resets a PF, detaching the VFs of the pods before and
+// re-attaching them after
+func (m *SRIOVManager) reparent(pfName string, numVFs int, work func() error) (*ResetReport, error) {
+	m.mu.Lock()
+	if m.resetting[pfName] {
+		m.mu.Unlock()
+		return nil, fmt.Errorf("%s is already being reset", pfName)
+	}
+	m.resetting[pfName] = true
+	var vfs []VirtualFunction
+	for _, key := range m.keysByPCIAddress() {
+		if vf := m.vfInventory[key]; vf.Allocated && vf.PFName == pfName {
+			vfs = append(vfs, vf)
+		}
+	}
+	m.mu.Unlock()
+	defer func() {
+		m.mu.Lock()
+		delete(m.resetting, pfName)
+		m.mu.Unlock()
+		m.Resync()
+	}()
+
+	report := &ResetReport{PF: pfName, NumVFs: numVFs}
+	pods := m.PodsOnPF(pfName)
+	start := time.Now()
+	if m.quiescer != nil && len(pods) > 0 {
+		ctx, cancel := context.WithTimeout(m.ctx, m.resetTimeout)
+		err := m.quiescer.Quiesce(ctx, pods)
+		cancel()
+		if err != nil {
+			m.quiescer.Resume(pods)
+			return nil, fmt.Errorf("failed to quiesce the connections on %s: %w", pfName, err)
+		}
+		m.logger.Infof("Quiesced the connections of %d pods on %s", len(pods), pfName)
+	}
+	defer func() {
+		if m.quiescer != nil && len(pods) > 0 {
+			m.quiescer.Resume(pods)
+		}
+		report.DowntimeMs = time.Since(start).Milliseconds()
+	}()
+
+	configs := make(map[string]*VFConfig, len(vfs))
+	for _, vf := range vfs {
+		cfg, err := m.plumber.Detach(vf)
+		if err != nil {
+			// nothing was reset yet, the detached VFs go back as they were
+			for _, detached := range vfs {
+				if cfg, ok := configs[vfKey(detached)]; ok {
+					if err := m.plumber.Attach(detached, cfg); err != nil {
+						m.logger.WithError(err).Errorf("Failed to re-attach VF %s after an aborted reset", vfKey(detached))
+					}
+				}
+			}
+			return nil, fmt.Errorf("failed to detach VF %s from pod %s/%s: %w", vfKey(vf), vf.Namespace, vf.AllocatedTo, err)
+		}
+		configs[vfKey(vf)] = cfg
+	}
+
+	restored, err := m.resetPF(pfName, numVFs, work)
+	if !restored {
+		return nil, err
+	}
+
+	for _, vf := range vfs {
+		key := vfKey(vf)
+		if err := m.plumber.Attach(vf, configs[key]); err != nil {
+			m.logger.WithError(err).Errorf("Failed to re-attach VF %s to pod %s/%s", key, vf.Namespace, vf.AllocatedTo)
+			if report.Failed == nil {
+				report.Failed = make(map[string]string)
+			}
+			report.Failed[key] = err.Error()
+			continue
+		}
+		report.Reparented = append(report.Reparented, key)
+	}
+	m.logger.Infof("Reset %s, re-attached %d of %d VFs to their pods", pfName, len(report.Reparented), len(vfs))
+	return report, err
+}
+
+// resetPF removes the VFs of a PF, runs work, resets the PF, waits for it
+// to recover and recreates the VFs. It reports whether the VFs were
+// recreated, also when work failed, the PF isn't reset then.
+func (m *SRIOVManager) resetPF(pfName string, numVFs int, work func() error) (bool, error) {
+	devicePath := m.path("sys/class/net", pfName, "device")
+	if err := os.WriteFile(devicePath+"/sriov_numvfs", []byte("0"), 0o200); err != nil {
+		return true, fmt.Errorf("failed to remove the VFs of %s: %w", pfName, err)
+	}
+
+	var workErr error
+	if work != nil {
+		// drivers refuse to flash PFs with VFs enabled
+		workErr = work()
+	}
+	if workErr == nil {
+		if err := os.WriteFile(devicePath+"/reset", []byte("1"), 0o200); err != nil {
+			return false, fmt.Errorf("failed to reset %s: %w", pfName, err)
+		}
+		m.logger.Infof("Reset %s, waiting for it to recover", pfName)
+
+		// the PF is back once its netdev reports its SR-IOV capability again
+		deadline := time.Now().Add(m.resetTimeout)
+		for readInt(devicePath+"/sriov_totalvfs", -1) < numVFs {
+			if time.Now().After(deadline) {
+				return false, fmt.Errorf("%s didn't recover within %s of its reset", pfName, m.resetTimeout)
+			}
+			select {
+			case <-time.After(m.resetPollInterval):
+			case <-m.ctx.Done():
+				return false, m.ctx.Err()
+			}
+		}
+	}
+
+	if numVFs > 0 {
+		if err := os.WriteFile(devicePath+"/sriov_numvfs", []byte(strconv.Itoa(numVFs)), 0o200); err != nil {
+			return false, fmt.Errorf("failed to recreate %d VFs on %s: %w", numVFs, pfName, err)
+		}
+	}
+	return true, workErr
+}
+
+// vfKey returns the inventory key of a VF
+func vfKey(vf VirtualFunction) string {
+	return fmt.Sprintf("%s-vf%d", vf.PFName, vf.VFID)
+}
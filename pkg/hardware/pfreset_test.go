@@ -0,0 +1,209 @@
+package hardware
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/akos011221/nsm/pkg/disruption"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// fakePlumber records the VFs detached and attached, with the number of
+// VFs the PF had at the time
+type fakePlumber struct {
+	numVFs   func() string
+	attached []string
+	fail     string
+	events   []string
+}
+
+func (p *fakePlumber) Detach(vf VirtualFunction) (*VFConfig, error) {
+	p.events = append(p.events, "detach "+vfKey(vf)+" with numvfs "+p.numVFs())
+	if vfKey(vf) == p.fail {
+		return nil, errors.New("netns gone")
+	}
+	return &VFConfig{MTU: 9000}, nil
+}
+
+func (p *fakePlumber) Attach(vf VirtualFunction, cfg *VFConfig) error {
+	p.events = append(p.events, "attach "+vfKey(vf)+" with numvfs "+p.numVFs())
+	if cfg == nil || cfg.MTU != 9000 {
+		return errors.New("configuration lost")
+	}
+	p.attached = append(p.attached, vfKey(vf))
+	return nil
+}
+
+// fakeQuiescer records the quiesced pods
+type fakeQuiescer struct {
+	quiesced []types.NamespacedName
+	resumed  []types.NamespacedName
+}
+
+func (q *fakeQuiescer) Quiesce(_ context.Context, pods []types.NamespacedName) error {
+	q.quiesced = append(q.quiesced, pods...)
+	return nil
+}
+
+func (q *fakeQuiescer) Resume(pods []types.NamespacedName) {
+	q.resumed = append(q.resumed, pods...)
+}
+
+func newResetManager(t *testing.T) (*SRIOVManager, *fakeSysfs) {
+	t.Helper()
+	fs := newFakeSysfs(t)
+	fs.write("sys/class/net/eth0/device/sriov_totalvfs", "8\n")
+	fs.write("sys/class/net/eth0/device/sriov_numvfs", "2\n")
+	fs.write("sys/class/net/eth0/device/reset", "")
+	fs.write("sys/class/net/eth0/device/virtfn0/uevent", "PCI_SLOT_NAME=0000:3b:02.0\n")
+	fs.write("sys/class/net/eth0/device/virtfn1/uevent", "PCI_SLOT_NAME=0000:3b:02.1\n")
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	m := NewSRIOVManager(context.Background(), nil, logger)
+	m.root = fs.root
+	m.resetPollInterval = time.Millisecond
+	m.vfInventory = map[string]VirtualFunction{
+		"eth0-vf0": {PFName: "eth0", VFID: 0, PCIAddress: "0000:3b:02.0", Allocated: true, AllocatedTo: "camera", Namespace: "edge", Netns: "/var/run/netns/camera", PodInterface: "net1"},
+		"eth0-vf1": {PFName: "eth0", VFID: 1, PCIAddress: "0000:3b:02.1", Allocated: true, AllocatedTo: "router", Namespace: "edge", Netns: "/var/run/netns/router", PodInterface: "net2"},
+	}
+	m.pfInventory = map[string]PhysicalFunction{"eth0": {Name: "eth0", NumVFs: 2}}
+	return m, fs
+}
+
+func TestSRIOVManagerResetPFReparentsVFs(t *testing.T) {
+	m, fs := newResetManager(t)
+	read := func(name string) string {
+		data, err := os.ReadFile(filepath.Join(fs.root, "sys/class/net/eth0/device", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+	plumber := &fakePlumber{numVFs: func() string { return read("sriov_numvfs") }}
+	quiescer := &fakeQuiescer{}
+	m.SetReparenting(plumber, quiescer)
+
+	report, err := m.ResetPF("eth0")
+	if err != nil {
+		t.Fatalf("ResetPF() error = %v", err)
+	}
+	want := []string{
+		"detach eth0-vf0 with numvfs 2\n",
+		"detach eth0-vf1 with numvfs 2\n",
+		"attach eth0-vf0 with numvfs 2",
+		"attach eth0-vf1 with numvfs 2",
+	}
+	if !reflect.DeepEqual(plumber.events, want) {
+		t.Errorf("plumbing = %q, want %q", plumber.events, want)
+	}
+	if read("reset") != "1" {
+		t.Error("PF not reset")
+	}
+	if !reflect.DeepEqual(report.Reparented, []string{"eth0-vf0", "eth0-vf1"}) || len(report.Failed) != 0 || report.NumVFs != 2 {
+		t.Errorf("ResetPF() = %+v", report)
+	}
+
+	pods := []types.NamespacedName{{Namespace: "edge", Name: "camera"}, {Namespace: "edge", Name: "router"}}
+	if !reflect.DeepEqual(quiescer.quiesced, pods) || !reflect.DeepEqual(quiescer.resumed, pods) {
+		t.Errorf("quiesced %v and resumed %v, want %v", quiescer.quiesced, quiescer.resumed, pods)
+	}
+	// the pods keep their VFs
+	if vf, ok := m.GetVFForPod("edge", "router"); !ok || vf.VFID != 1 {
+		t.Errorf("GetVFForPod() = %+v, %t after the reset", vf, ok)
+	}
+	if len(m.resetting) != 0 {
+		t.Errorf("PFs still resetting: %v", m.resetting)
+	}
+}
+
+func TestSRIOVManagerResetPFAbortsWhenDetachFails(t *testing.T) {
+	m, fs := newResetManager(t)
+	plumber := &fakePlumber{numVFs: func() string { return "" }, fail: "eth0-vf1"}
+	quiescer := &fakeQuiescer{}
+	m.SetReparenting(plumber, quiescer)
+
+	if _, err := m.ResetPF("eth0"); err == nil {
+		t.Fatal("ResetPF() succeeded with a VF that couldn't be detached")
+	}
+	if data, _ := os.ReadFile(filepath.Join(fs.root, "sys/class/net/eth0/device/reset")); len(data) != 0 {
+		t.Error("PF reset after a failed detach")
+	}
+	// the VF detached already is put back, the connections resumed
+	if !reflect.DeepEqual(plumber.attached, []string{"eth0-vf0"}) || len(quiescer.resumed) != 2 {
+		t.Errorf("attached %v, resumed %v after the aborted reset", plumber.attached, quiescer.resumed)
+	}
+}
+
+func TestSRIOVManagerMaintainPFRunsWorkWithoutVFs(t *testing.T) {
+	m, fs := newResetManager(t)
+	read := func(name string) string {
+		data, _ := os.ReadFile(filepath.Join(fs.root, "sys/class/net/eth0/device", name))
+		return string(data)
+	}
+	plumber := &fakePlumber{numVFs: func() string { return read("sriov_numvfs") }}
+	m.SetReparenting(plumber, &fakeQuiescer{})
+
+	var numVFs string
+	report, err := m.MaintainPF("eth0", disruption.KindFirmwareUpdate, func() error {
+		numVFs = read("sriov_numvfs")
+		return nil
+	})
+	if err != nil || len(report.Reparented) != 2 {
+		t.Fatalf("MaintainPF() = %+v, %v", report, err)
+	}
+	if numVFs != "0" || read("reset") != "1" {
+		t.Errorf("work ran with numvfs %q, reset %q, want it run without VFs before the reset", numVFs, read("reset"))
+	}
+
+	// failed work skips the reset but the VFs go back to their pods
+	fs.write("sys/class/net/eth0/device/reset", "")
+	plumber.attached = nil
+	report, err = m.MaintainPF("eth0", disruption.KindFirmwareUpdate, func() error { return errors.New("flash failed") })
+	if err == nil || report == nil || len(plumber.attached) != 2 {
+		t.Errorf("MaintainPF() = %+v, %v, attached %v, want the error with the VFs restored", report, err, plumber.attached)
+	}
+	if read("reset") != "" || read("sriov_numvfs") != "2" {
+		t.Errorf("reset %q, numvfs %q after failed work", read("reset"), read("sriov_numvfs"))
+	}
+}
+
+func TestSRIOVManagerResetPFWithoutReparenting(t *testing.T) {
+	m, _ := newResetManager(t)
+	if _, err := m.ResetPF("eth0"); err == nil {
+		t.Error("ResetPF() reset a PF with allocated VFs without reparenting or disruption coordination")
+	}
+
+	d := &fakeDisrupter{allow: true}
+	m.SetDisrupter(d)
+	if _, err := m.ResetPF("eth0"); err != nil || len(d.changes) != 1 || d.changes[0].Kind != disruption.KindPFReset {
+		t.Errorf("ResetPF() = %v, disrupted %+v, want the reset run past the budgets", err, d.changes)
+	}
+
+	if _, err := m.ResetPF("eth9"); err == nil {
+		t.Error("ResetPF() accepted a PF without SR-IOV")
+	}
+}
+
+func TestDiscoveryKeepsVFsOfResettingPF(t *testing.T) {
+	m, fs := newResetManager(t)
+	m.resetting["eth0"] = true
+	// the VFs are gone while the PF resets
+	fs.write("sys/class/net/eth0/device/sriov_numvfs", "0\n")
+
+	if err := m.discoverVirtualFunctions(); err != nil {
+		t.Fatalf("discoverVirtualFunctions() error = %v", err)
+	}
+	if vf, ok := m.GetVFForPod("edge", "camera"); !ok || vf.Netns != "/var/run/netns/camera" {
+		t.Errorf("GetVFForPod() = %+v, %t during the reset", vf, ok)
+	}
+	if pfs := m.PhysicalFunctions(); len(pfs) != 1 {
+		t.Errorf("PhysicalFunctions() = %+v during the reset", pfs)
+	}
+}
@@ -0,0 +1,327 @@
+package hardware
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// NIC buses
+const (
+	BusPCI      = "pci"
+	BusPlatform = "platform"
+	BusUSB      = "usb"
+	BusVirtual  = "virtual"
+)
+
+// ethtool operations probed on every NIC, many ARM64 SoC drivers (stmmac,
+// fec, mvneta) implement only a subset of them
+var ethtoolOps = map[string]string{
+	"channels": "--show-channels",
+	"features": "--show-features",
+	"rings":    "--show-ring",
+	"stats":    "--statistics",
+}
+
+// Inline crypto offloads
+const (
+	// CryptoIPsec offloads ESP encryption (IPsec)
+	CryptoIPsec = "ipsec"
+	// CryptoTLS offloads kTLS record encryption
+	CryptoTLS = "tls"
+)
+
+// ethtool features announcing an inline crypto offload
+var cryptoFeatures = map[string]string{
+	"esp-hw-offload":    CryptoIPsec,
+	"tls-hw-tx-offload": CryptoTLS,
+}
+
+// NIC describes the capabilities of a physical network interface
+type NIC struct {
+	// Interface name
+	Name string `json:"name"`
+	// Bus the NIC is attached to (pci, platform, usb)
+	Bus string `json:"bus"`
+	// Kernel driver
+	Driver string `json:"driver,omitempty"`
+	// Whether the NIC supports SR-IOV
+	SRIOV bool `json:"sriov"`
+	// Maximum number of VFs
+	TotalVFs int `json:"totalVFs,omitempty"`
+	// Supported ethtool operations
+	Ethtool map[string]bool `json:"ethtool,omitempty"`
+	// Enabled inline crypto offloads (ipsec, tls)
+	CryptoOffload []string `json:"cryptoOffload,omitempty"`
+	// Negotiated link speed in Mbps, 0 when the link is down or the
+	// driver doesn't report it
+	SpeedMbps int `json:"speedMbps,omitempty"`
+}
+
+// Platform describes the hardware capabilities of the node
+type Platform struct {
+	// CPU architecture (e.g., amd64, arm64)
+	Arch string `json:"arch"`
+	// IOMMU implementation (intel-vt-d, amd-vi, arm-smmu, arm-smmu-v3, none)
+	IOMMU string `json:"iommu"`
+	// Whether devices are in IOMMU groups, required for VFIO (DPDK)
+	IOMMUGroups bool `json:"iommuGroups"`
+	// Physical NICs
+	NICs []NIC `json:"nics"`
+	// Differences to the x86 server NIC behavior NSM assumes by default
+	Quirks []string `json:"quirks,omitempty"`
+}
+
+// SRIOV reports whether any NIC supports SR-IOV
+func (p *Platform) SRIOV() bool {
+	for _, nic := range p.NICs {
+		if nic.SRIOV {
+			return true
+		}
+	}
+	return false
+}
+
+// MaxSpeedMbps returns the highest link speed of the NICs in Mbps, the
+// most bandwidth a single connection can get on the node. It returns 0
+// when no NIC reports its speed.
+func (p *Platform) MaxSpeedMbps() int {
+	speed := 0
+	for _, nic := range p.NICs {
+		speed = max(speed, nic.SpeedMbps)
+	}
+	return speed
+}
+
+// DefaultUplink returns the NIC fallback interfaces are created on,
+// preferring PCI over USB and onboard platform NICs
+func (p *Platform) DefaultUplink() string {
+	for _, bus := range []string{BusPCI, BusUSB, BusPlatform} {
+		for _, nic := range p.NICs {
+			if nic.Bus == bus {
+				return nic.Name
+			}
+		}
+	}
+	return ""
+}
+
+// CryptoOffload returns the inline crypto offloads of a NIC
+func (p *Platform) CryptoOffload(name string) []string {
+	for _, nic := range p.NICs {
+		if nic.Name == name {
+			return nic.CryptoOffload
+		}
+	}
+	return nil
+}
+
+// VFIO reports whether devices can be passed to userspace drivers
+func (p *Platform) VFIO() bool {
+	return p.IOMMU != "none" && p.IOMMUGroups
+}
+
+// PlatformDetector inspects the node hardware through sysfs
+type PlatformDetector struct {
+	// Root of the filesystem sysfs is mounted under
+	root string
+	// CPU architecture
+	arch string
+	// Runs ethtool and returns its output, nil to skip probing the ethtool operations
+	ethtool func(args ...string) ([]byte, error)
+}
+
+// NewPlatformDetector creates a detector for the running node
+func NewPlatformDetector() *PlatformDetector {
+	return &PlatformDetector{
+		root:    "/",
+		arch:    runtime.GOARCH,
+		ethtool: runEthtool,
+	}
+}
+
+// runEthtool runs ethtool, failing if the operation isn't supported
+func runEthtool(args ...string) ([]byte, error) {
+	return exec.CommandContext(context.Background(), "ethtool", args...).Output()
+}
+
+// Detect inspects the hardware of the node
+func (d *PlatformDetector) Detect() (*Platform, error) {
+	p := &Platform{Arch: d.arch}
+	p.IOMMU = d.detectIOMMU()
+	p.IOMMUGroups = d.hasIOMMUGroups()
+
+	nics, err := d.detectNICs()
+	if err != nil {
+		return nil, err
+	}
+	p.NICs = nics
+	p.Quirks = d.quirks(p)
+
+	return p, nil
+}
+
+// detectIOMMU finds the IOMMU implementation. Intel and AMD IOMMUs are
+// listed as dmar*/ivhd* in /sys/class/iommu, ARM SMMUs as platform devices
+// named after their MMIO address, so the bound driver is checked instead.
+func (d *PlatformDetector) detectIOMMU() string {
+	entries, _ := os.ReadDir(d.path("sys/class/iommu"))
+	for _, e := range entries {
+		switch {
+		case strings.HasPrefix(e.Name(), "dmar"):
+			return "intel-vt-d"
+		case strings.HasPrefix(e.Name(), "ivhd"):
+			return "amd-vi"
+		case strings.Contains(e.Name(), "smmu3"):
+			return "arm-smmu-v3"
+		case strings.Contains(e.Name(), "smmu"):
+			return "arm-smmu"
+		}
+	}
+
+	// SMMUs probed from the device tree or IORT may not show up in /sys/class/iommu
+	for _, driver := range []string{"arm-smmu-v3", "arm-smmu"} {
+		devices, _ := filepath.Glob(d.path("sys/bus/platform/drivers", driver, "*.*"))
+		if len(devices) > 0 {
+			return driver
+		}
+	}
+
+	return "none"
+}
+
+// hasIOMMUGroups reports whether the kernel created any IOMMU groups
+func (d *PlatformDetector) hasIOMMUGroups() bool {
+	groups, _ := os.ReadDir(d.path("sys/kernel/iommu_groups"))
+	return len(groups) > 0
+}
+
+// detectNICs inspects the physical network interfaces
+func (d *PlatformDetector) detectNICs() ([]NIC, error) {
+	devices, err := filepath.Glob(d.path("sys/class/net/*"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to glob network devices: %w", err)
+	}
+
+	var nics []NIC
+	for _, devicePath := range devices {
+		// virtual interfaces (bridges, veths, tunnels) have no device link
+		if _, err := os.Stat(filepath.Join(devicePath, "device")); err != nil {
+			continue
+		}
+
+		nic := NIC{Name: filepath.Base(devicePath), Bus: d.bus(devicePath)}
+		if driver, err := filepath.EvalSymlinks(filepath.Join(devicePath, "device/driver")); err == nil {
+			nic.Driver = filepath.Base(driver)
+		}
+
+		// SR-IOV is a PCIe feature, platform NICs of ARM SoCs never have it
+		if data, err := os.ReadFile(filepath.Join(devicePath, "device/sriov_totalvfs")); err == nil && nic.Bus == BusPCI {
+			nic.TotalVFs, _ = strconv.Atoi(strings.TrimSpace(string(data)))
+			nic.SRIOV = nic.TotalVFs > 0
+		}
+
+		// reading the speed of a down link fails, unknown speeds read -1
+		if data, err := os.ReadFile(filepath.Join(devicePath, "speed")); err == nil {
+			if speed, err := strconv.Atoi(strings.TrimSpace(string(data))); err == nil && speed > 0 {
+				nic.SpeedMbps = speed
+			}
+		}
+
+		if d.ethtool != nil {
+			nic.Ethtool = make(map[string]bool, len(ethtoolOps))
+			for op, flag := range ethtoolOps {
+				out, err := d.ethtool(flag, nic.Name)
+				nic.Ethtool[op] = err == nil
+				if op == "features" && err == nil {
+					nic.CryptoOffload = parseCryptoOffload(out)
+				}
+			}
+		}
+
+		nics = append(nics, nic)
+	}
+
+	sort.Slice(nics, func(i, j int) bool { return nics[i].Name < nics[j].Name })
+	return nics, nil
+}
+
+// parseCryptoOffload finds the enabled crypto offloads in the output of
+// ethtool --show-features, e.g. "esp-hw-offload: on"
+func parseCryptoOffload(out []byte) []string {
+	var offloads []string
+	for _, line := range strings.Split(string(out), "\n") {
+		feature, value, ok := strings.Cut(strings.TrimSpace(line), ":")
+		if !ok {
+			continue
+		}
+		if crypto, known := cryptoFeatures[feature]; known && strings.HasPrefix(strings.TrimSpace(value), "on") {
+			offloads = append(offloads, crypto)
+		}
+	}
+	sort.Strings(offloads)
+	return offloads
+}
+
+// bus returns the bus a network device is attached to
+func (d *PlatformDetector) bus(devicePath string) string {
+	subsystem, err := filepath.EvalSymlinks(filepath.Join(devicePath, "device/subsystem"))
+	if err != nil {
+		return BusVirtual
+	}
+	switch filepath.Base(subsystem) {
+	case "pci":
+		return BusPCI
+	case "usb":
+		return BusUSB
+	case "platform":
+		return BusPlatform
+	default:
+		return filepath.Base(subsystem)
+	}
+}
+
+// quirks lists where the node differs from x86 server NIC behavior
+func (d *PlatformDetector) quirks(p *Platform) []string {
+	var quirks []string
+
+	if p.IOMMU == "none" {
+		if p.Arch == "arm64" {
+			quirks = append(quirks, "no SMMU found, VFIO (DPDK) needs the SMMU enabled in firmware (device tree or IORT)")
+		} else {
+			quirks = append(quirks, "no IOMMU found, VFIO (DPDK) needs intel_iommu=on or amd_iommu=on")
+		}
+	} else if !p.IOMMUGroups {
+		quirks = append(quirks, fmt.Sprintf("%s present but no IOMMU groups, VFIO (DPDK) unavailable (iommu.passthrough or iommu=pt?)", p.IOMMU))
+	}
+
+	for _, nic := range p.NICs {
+		if nic.Bus != BusPCI {
+			quirks = append(quirks, fmt.Sprintf("%s is a %s device, SR-IOV unavailable", nic.Name, nic.Bus))
+		}
+
+		var missing []string
+		for op, ok := range nic.Ethtool {
+			if !ok {
+				missing = append(missing, op)
+			}
+		}
+		if len(missing) > 0 {
+			sort.Strings(missing)
+			quirks = append(quirks, fmt.Sprintf("%s (%s) lacks ethtool %s", nic.Name, nic.Driver, strings.Join(missing, ", ")))
+		}
+	}
+
+	return quirks
+}
+
+// path joins a sysfs path to the root
+func (d *PlatformDetector) path(elem ...string) string {
+	return filepath.Join(append([]string{d.root}, elem...)...)
+}
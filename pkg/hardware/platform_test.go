@@ -0,0 +1,195 @@
+package hardware
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeSysfs builds sysfs trees in a temporary directory
+type fakeSysfs struct {
+	t    *testing.T
+	root string
+}
+
+func newFakeSysfs(t *testing.T) *fakeSysfs {
+	return &fakeSysfs{t: t, root: t.TempDir()}
+}
+
+func (f *fakeSysfs) mkdir(path string) {
+	f.t.Helper()
+	if err := os.MkdirAll(filepath.Join(f.root, path), 0o755); err != nil {
+		f.t.Fatal(err)
+	}
+}
+
+func (f *fakeSysfs) write(path, content string) {
+	f.t.Helper()
+	f.mkdir(filepath.Dir(path))
+	if err := os.WriteFile(filepath.Join(f.root, path), []byte(content), 0o644); err != nil {
+		f.t.Fatal(err)
+	}
+}
+
+func (f *fakeSysfs) link(path, target string) {
+	f.t.Helper()
+	f.mkdir(filepath.Dir(path))
+	f.mkdir(target)
+	if err := os.Symlink(filepath.Join(f.root, target), filepath.Join(f.root, path)); err != nil {
+		f.t.Fatal(err)
+	}
+}
+
+// nic adds a network device attached to the bus with the driver
+func (f *fakeSysfs) nic(name, bus, driver string) {
+	device := "sys/devices/" + name
+	f.link("sys/class/net/"+name+"/device", device)
+	f.link(device+"/subsystem", "sys/bus/"+bus)
+	f.link(device+"/driver", "sys/bus/"+bus+"/drivers/"+driver)
+}
+
+func TestDetectARM64(t *testing.T) {
+	fs := newFakeSysfs(t)
+	fs.nic("end0", "platform", "st_gmac")
+	fs.nic("enP1p1s0", "pci", "mlx5_core")
+	fs.write("sys/devices/enP1p1s0/sriov_totalvfs", "8\n")
+	// the platform NIC exposes a bogus attribute, which must not count as SR-IOV
+	fs.write("sys/devices/end0/sriov_totalvfs", "4\n")
+	fs.write("sys/class/net/enP1p1s0/speed", "25000\n")
+	fs.write("sys/class/net/end0/speed", "-1\n")
+	fs.mkdir("sys/class/net/br0")
+	fs.mkdir("sys/bus/platform/drivers/arm-smmu-v3/9050000.smmuv3")
+
+	d := &PlatformDetector{
+		root: fs.root,
+		arch: "arm64",
+		ethtool: func(args ...string) ([]byte, error) {
+			// stmmac lacks channel and ring configuration
+			if args[1] == "end0" && (args[0] == "--show-channels" || args[0] == "--show-ring") {
+				return nil, errors.New("Operation not supported")
+			}
+			return nil, nil
+		},
+	}
+
+	p, err := d.Detect()
+	if err != nil {
+		t.Fatalf("Detect() error = %v", err)
+	}
+	if p.IOMMU != "arm-smmu-v3" || p.IOMMUGroups || p.VFIO() {
+		t.Errorf("unexpected IOMMU detection: %s groups=%t", p.IOMMU, p.IOMMUGroups)
+	}
+	if len(p.NICs) != 2 {
+		t.Fatalf("got %d NICs, want 2 (virtual interfaces skipped): %+v", len(p.NICs), p.NICs)
+	}
+
+	pci, end0 := p.NICs[0], p.NICs[1]
+	if end0.Bus != BusPlatform || end0.Driver != "st_gmac" || end0.SRIOV {
+		t.Errorf("unexpected platform NIC %+v", end0)
+	}
+	if pci.Bus != BusPCI || !pci.SRIOV || pci.TotalVFs != 8 || pci.SpeedMbps != 25000 {
+		t.Errorf("unexpected PCI NIC %+v", pci)
+	}
+	if end0.SpeedMbps != 0 || p.MaxSpeedMbps() != 25000 {
+		t.Errorf("unknown speed of end0 = %d, max speed = %d", end0.SpeedMbps, p.MaxSpeedMbps())
+	}
+	if err := ValidateSRIOVCapabilities(p); err != nil {
+		t.Errorf("SR-IOV should be available: %v", err)
+	}
+
+	quirks := strings.Join(p.Quirks, "\n")
+	for _, want := range []string{
+		"arm-smmu-v3 present but no IOMMU groups",
+		"end0 is a platform device, SR-IOV unavailable",
+		"end0 (st_gmac) lacks ethtool channels, rings",
+	} {
+		if !strings.Contains(quirks, want) {
+			t.Errorf("quirk %q not reported, got:\n%s", want, quirks)
+		}
+	}
+}
+
+func TestDetectX86(t *testing.T) {
+	fs := newFakeSysfs(t)
+	fs.nic("eno1", "pci", "ice")
+	fs.mkdir("sys/class/iommu/dmar0")
+	fs.mkdir("sys/kernel/iommu_groups/0")
+
+	p, err := (&PlatformDetector{root: fs.root, arch: "amd64"}).Detect()
+	if err != nil {
+		t.Fatalf("Detect() error = %v", err)
+	}
+	if p.IOMMU != "intel-vt-d" || !p.VFIO() {
+		t.Errorf("unexpected IOMMU detection: %s groups=%t", p.IOMMU, p.IOMMUGroups)
+	}
+	if len(p.Quirks) != 0 {
+		t.Errorf("unexpected quirks: %v", p.Quirks)
+	}
+	if err := ValidateSRIOVCapabilities(p); err == nil {
+		t.Errorf("expected error without SR-IOV capable NICs")
+	}
+}
+
+func TestDetectMissingSMMU(t *testing.T) {
+	fs := newFakeSysfs(t)
+	fs.mkdir("sys/class/net")
+
+	p, err := (&PlatformDetector{root: fs.root, arch: "arm64"}).Detect()
+	if err != nil {
+		t.Fatalf("Detect() error = %v", err)
+	}
+	if p.IOMMU != "none" || len(p.Quirks) != 1 || !strings.Contains(p.Quirks[0], "SMMU") {
+		t.Errorf("unexpected detection: %+v", p)
+	}
+}
+
+func TestDefaultUplink(t *testing.T) {
+	p := &Platform{NICs: []NIC{{Name: "end0", Bus: BusPlatform}, {Name: "enx0", Bus: BusUSB}}}
+	if got := p.DefaultUplink(); got != "enx0" {
+		t.Errorf("DefaultUplink() = %s, want the USB NIC over the platform one", got)
+	}
+	p.NICs = append(p.NICs, NIC{Name: "enp1s0", Bus: BusPCI})
+	if got := p.DefaultUplink(); got != "enp1s0" {
+		t.Errorf("DefaultUplink() = %s, want the PCI NIC", got)
+	}
+	if got := (&Platform{}).DefaultUplink(); got != "" {
+		t.Errorf("DefaultUplink() = %s without NICs", got)
+	}
+}
+
+func TestDetectCryptoOffload(t *testing.T) {
+	fs := newFakeSysfs(t)
+	fs.nic("enp1s0", "pci", "mlx5_core")
+	fs.nic("enp2s0", "pci", "ice")
+
+	features := map[string]string{
+		"enp1s0": "Features for enp1s0:\nrx-checksumming: on\nesp-hw-offload: on\ntls-hw-tx-offload: on\ntls-hw-rx-offload: off\n",
+		"enp2s0": "Features for enp2s0:\nesp-hw-offload: off [fixed]\ntls-hw-tx-offload: off [fixed]\n",
+	}
+	d := &PlatformDetector{
+		root: fs.root,
+		arch: "amd64",
+		ethtool: func(args ...string) ([]byte, error) {
+			if args[0] == "--show-features" {
+				return []byte(features[args[1]]), nil
+			}
+			return nil, nil
+		},
+	}
+
+	p, err := d.Detect()
+	if err != nil {
+		t.Fatalf("Detect() error = %v", err)
+	}
+	if got := strings.Join(p.CryptoOffload("enp1s0"), ","); got != "ipsec,tls" {
+		t.Errorf("enp1s0 crypto offload = %q, want ipsec,tls", got)
+	}
+	if got := p.CryptoOffload("enp2s0"); len(got) != 0 {
+		t.Errorf("enp2s0 crypto offload = %v, want none (fixed off)", got)
+	}
+	if got := p.CryptoOffload("missing"); got != nil {
+		t.Errorf("unknown NIC crypto offload = %v", got)
+	}
+}
@@ -0,0 +1,112 @@
+package hardware
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// SRIOVPodSelector selects the pods requesting a VF
+const SRIOVPodSelector = "network.nsm.akosrbn.io/sriov=true"
+
+// SetNodeName limits the pods the VFs are allocated to to those scheduled
+// on a node, so the managers of other nodes don't allocate VFs of this
+// node to their pods
+func (m *SRIOVManager) SetNodeName(name string) {
+	m.nodeName = name
+}
+
+// watchPods starts an informer on the pods of the node requesting a VF,
+// allocating and freeing the VFs on their events instead of listing the
+// pods on every poll, and waits for its cache to sync
+func (m *SRIOVManager) watchPods() error {
+	factory := informers.NewSharedInformerFactoryWithOptions(m.clientset, 0,
+		informers.WithTweakListOptions(m.podListOptions))
+	informer := factory.Core().V1().Pods()
+	if _, err := informer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(interface{}) { m.podsChanged() },
+		// pods start terminating and lose the label with updates
+		UpdateFunc: func(interface{}, interface{}) { m.podsChanged() },
+		DeleteFunc: func(interface{}) { m.podsChanged() },
+	}); err != nil {
+		return fmt.Errorf("failed to add the pod event handler: %w", err)
+	}
+
+	factory.Start(m.ctx.Done())
+	for typ, synced := range factory.WaitForCacheSync(m.ctx.Done()) {
+		if !synced {
+			return fmt.Errorf("failed to sync the %s informer", typ)
+		}
+	}
+
+	m.mu.Lock()
+	m.pods = informer.Lister()
+	m.mu.Unlock()
+	return nil
+}
+
+// podListOptions selects the pods of the node requesting a VF
+func (m *SRIOVManager) podListOptions(opts *metav1.ListOptions) {
+	opts.LabelSelector = SRIOVPodSelector
+	if m.nodeName != "" {
+		opts.FieldSelector = fields.OneTermEqualSelector("spec.nodeName", m.nodeName).String()
+	}
+}
+
+// podsChanged triggers the reconcile of the allocations, coalescing the
+// events of pods created or deleted together
+func (m *SRIOVManager) podsChanged() {
+	select {
+	case m.podEvents <- struct{}{}:
+	default:
+	}
+}
+
+// requestingPods returns the pods requesting a VF, from the informer cache
+// once it is synced, and the virtual machines requesting one
+func (m *SRIOVManager) requestingPods() ([]corev1.Pod, error) {
+	m.mu.RLock()
+	lister := m.pods
+	m.mu.RUnlock()
+
+	var pods []corev1.Pod
+	if lister == nil {
+		var opts metav1.ListOptions
+		m.podListOptions(&opts)
+		list, err := m.clientset.CoreV1().Pods("").List(m.ctx, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list pods requesting SR-IOV: %w", err)
+		}
+		pods = list.Items
+	} else {
+		cached, err := listPods(lister)
+		if err != nil {
+			return nil, err
+		}
+		pods = cached
+	}
+	return m.withVirtualMachines(pods)
+}
+
+// listPods returns copies of the cached pods requesting a VF
+func listPods(lister corelisters.PodLister) ([]corev1.Pod, error) {
+	selector, err := labels.Parse(SRIOVPodSelector)
+	if err != nil {
+		return nil, err
+	}
+	cached, err := lister.List(selector)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pods requesting SR-IOV: %w", err)
+	}
+	pods := make([]corev1.Pod, 0, len(cached))
+	for _, pod := range cached {
+		pods = append(pods, *pod)
+	}
+	return pods, nil
+}
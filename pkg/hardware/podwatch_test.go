@@ -0,0 +1,112 @@
+package hardware
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+func TestSRIOVManagerAllocatesOnPodEvents(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	clientset := fake.NewSimpleClientset(sriovPod("camera"))
+	// pods created before the watch is established would be missed
+	watching := make(chan struct{})
+	clientset.PrependWatchReactor("pods", func(action clienttesting.Action) (bool, watch.Interface, error) {
+		w, err := clientset.Tracker().Watch(action.GetResource(), action.GetNamespace())
+		if err != nil {
+			return false, nil, err
+		}
+		close(watching)
+		return true, w, nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m := NewSRIOVManager(ctx, clientset, logger)
+	m.links = &recordingLinks{}
+	m.vfInventory = map[string]VirtualFunction{
+		"eth0-vf0": {PFName: "eth0", VFID: 0, PCIAddress: "0000:3b:02.0"},
+		"eth0-vf1": {PFName: "eth0", VFID: 1, PCIAddress: "0000:3b:02.1"},
+	}
+	if err := m.watchPods(); err != nil {
+		t.Fatalf("watchPods() error = %v", err)
+	}
+	<-watching
+	lists := func() int {
+		n := 0
+		for _, action := range clientset.Actions() {
+			if action.GetVerb() == "list" {
+				n++
+			}
+		}
+		return n
+	}
+	listed := lists()
+
+	// reconciles on the events until the allocations match
+	waitFor := func(what string, done func() bool) {
+		t.Helper()
+		deadline := time.After(5 * time.Second)
+		for !done() {
+			select {
+			case <-m.podEvents:
+				if err := m.reconcileAllocations(); err != nil {
+					t.Fatalf("reconcileAllocations() error = %v", err)
+				}
+			case <-deadline:
+				t.Fatalf("timed out waiting for %s", what)
+			}
+		}
+	}
+
+	waitFor("the initial pod", func() bool { _, ok := m.GetVFForPod("edge", "camera"); return ok })
+	if _, err := clientset.CoreV1().Pods("edge").Create(ctx, sriovPod("lidar"), metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor("the created pod", func() bool { _, ok := m.GetVFForPod("edge", "lidar"); return ok })
+	if err := clientset.CoreV1().Pods("edge").Delete(ctx, "camera", metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor("the deleted pod", func() bool { _, ok := m.GetVFForPod("edge", "camera"); return !ok })
+
+	if n := lists() - listed; n != 0 {
+		t.Errorf("listed the pods %d times after the informer synced", n)
+	}
+}
+
+func TestSRIOVManagerWatchesPodsOfTheNode(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	clientset := fake.NewSimpleClientset()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m := NewSRIOVManager(ctx, clientset, logger)
+	m.SetNodeName("edge-1")
+	if err := m.watchPods(); err != nil {
+		t.Fatalf("watchPods() error = %v", err)
+	}
+
+	for _, action := range clientset.Actions() {
+		list, ok := action.(clienttesting.ListAction)
+		if !ok {
+			continue
+		}
+		restrictions := list.GetListRestrictions()
+		if got := restrictions.Fields.String(); got != "spec.nodeName=edge-1" {
+			t.Errorf("pods listed with field selector %q, want those of edge-1", got)
+		}
+		if got := restrictions.Labels.String(); got != SRIOVPodSelector {
+			t.Errorf("pods listed with label selector %q, want %q", got, SRIOVPodSelector)
+		}
+		return
+	}
+	t.Fatalf("pods never listed")
+}
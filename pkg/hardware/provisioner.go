@@ -0,0 +1,54 @@
+package hardware
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+)
+
+// ErrProvisionedExternally is returned for changes of the VFs of a PF
+// another controller provisions
+var ErrProvisionedExternally = errors.New("VFs provisioned by another controller")
+
+// Provisioner provisions the VFs of PFs in place of NSM, e.g. the SR-IOV
+// network operator. NSM leaves the VF count of its PFs to it, so the two
+// don't fight over sriov_numvfs, and still allocates and configures the
+// VFs.
+type Provisioner interface {
+	// Provisions returns whether the provisioner manages the VFs of a PF
+	Provisions(pfName, pciAddress string) bool
+	// Reconfiguring returns whether the provisioner is changing the VFs of
+	// the node, which come and go meanwhile
+	Reconfiguring() bool
+	// String names the provisioner
+	String() string
+}
+
+// SetProvisioner makes the manager leave the VF count of the PFs the
+// provisioner manages to it, and hold the inventory while it reconfigures
+// them
+func (m *SRIOVManager) SetProvisioner(p Provisioner) {
+	m.provisioner = p
+}
+
+// checkProvisioned refuses changing the VFs of a PF another controller
+// provisions
+func (m *SRIOVManager) checkProvisioned(pfName string) error {
+	if m.provisioner == nil {
+		return nil
+	}
+	var pciAddress string
+	if target, err := filepath.EvalSymlinks(m.path("sys/class/net", pfName, "device")); err == nil {
+		pciAddress = filepath.Base(target)
+	}
+	if m.provisioner.Provisions(pfName, pciAddress) {
+		return fmt.Errorf("%w: the VFs of %s are provisioned by %s, change them through it", ErrProvisionedExternally, pfName, m.provisioner)
+	}
+	return nil
+}
+
+// reconfiguring returns whether the provisioner is changing the VFs, the
+// inventory is kept as it was meanwhile
+func (m *SRIOVManager) reconfiguring() bool {
+	return m.provisioner != nil && m.provisioner.Reconfiguring()
+}
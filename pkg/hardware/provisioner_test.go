@@ -0,0 +1,77 @@
+package hardware
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// fakeProvisioner provisions the PFs by PCI address
+type fakeProvisioner struct {
+	pfs           map[string]bool
+	reconfiguring bool
+}
+
+func (p *fakeProvisioner) Provisions(pfName, pciAddress string) bool { return p.pfs[pciAddress] }
+func (p *fakeProvisioner) Reconfiguring() bool                       { return p.reconfiguring }
+func (p *fakeProvisioner) String() string                            { return "the fake operator" }
+
+func TestSRIOVManagerLeavesProvisionedPFs(t *testing.T) {
+	fs := newFakeSysfs(t)
+	fs.link("sys/class/net/eth0/device", "sys/devices/pci0000:00/0000:03:00.0")
+	fs.write("sys/devices/pci0000:00/0000:03:00.0/sriov_totalvfs", "8\n")
+	fs.write("sys/devices/pci0000:00/0000:03:00.0/sriov_numvfs", "2\n")
+	fs.write("sys/devices/pci0000:00/0000:03:00.0/reset", "")
+	fs.link("sys/class/net/eth1/device", "sys/devices/pci0000:00/0000:04:00.0")
+	fs.write("sys/devices/pci0000:00/0000:04:00.0/sriov_totalvfs", "8\n")
+	fs.write("sys/devices/pci0000:00/0000:04:00.0/sriov_numvfs", "0\n")
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	m := NewSRIOVManager(context.Background(), nil, logger)
+	m.root = fs.root
+	m.SetProvisioner(&fakeProvisioner{pfs: map[string]bool{"0000:03:00.0": true}})
+
+	if err := m.SetNumVFs("eth0", 4); !errors.Is(err, ErrProvisionedExternally) {
+		t.Errorf("SetNumVFs() error = %v, want ErrProvisionedExternally", err)
+	}
+	if _, err := m.ResetPF("eth0"); !errors.Is(err, ErrProvisionedExternally) {
+		t.Errorf("ResetPF() error = %v, want ErrProvisionedExternally", err)
+	}
+	if got := readInt(fs.root+"/sys/devices/pci0000:00/0000:03:00.0/sriov_numvfs", -1); got != 2 {
+		t.Errorf("sriov_numvfs of the provisioned PF = %d, want it untouched", got)
+	}
+
+	// the PFs the operator doesn't select are still NSM's
+	if err := m.SetNumVFs("eth1", 2); err != nil {
+		t.Errorf("SetNumVFs() of a PF NSM provisions error = %v", err)
+	}
+}
+
+func TestSRIOVManagerHoldsInventoryWhileReconfiguring(t *testing.T) {
+	fs := newFakeSysfs(t)
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	m := NewSRIOVManager(context.Background(), fake.NewSimpleClientset(), logger)
+	m.root = fs.root
+	m.vfInventory = map[string]VirtualFunction{
+		"eth0-vf0": {PFName: "eth0", VFID: 0, Allocated: true, AllocatedTo: "camera", Namespace: "edge"},
+	}
+	provisioner := &fakeProvisioner{reconfiguring: true}
+	m.SetProvisioner(provisioner)
+
+	// the VFs are gone from sysfs while the operator recreates them
+	m.sync()
+	if _, ok := m.GetVFForPod("edge", "camera"); !ok {
+		t.Fatal("VF dropped from the inventory while the operator reconfigures the node")
+	}
+
+	provisioner.reconfiguring = false
+	m.sync()
+	if _, ok := m.GetVFForPod("edge", "camera"); ok {
+		t.Error("VF kept in the inventory after the operator finished")
+	}
+}
@@ -5,14 +5,18 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/akos011221/nsm/pkg/netutil"
+	"github.com/akos011221/nsm/pkg/watchdog"
 	"github.com/sirupsen/logrus"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
 )
 
 // SRIOVManager manages SR-IOV Virtual Functions
@@ -20,15 +24,103 @@ type SRIOVManager struct {
 	// Context for cancellation
 	ctx context.Context
 	// Kubernetes client
-	clientset *kubernetes.Clientset
+	clientset kubernetes.Interface
 	// Logger
 	logger *logrus.Logger
+	// Root of the filesystem sysfs is mounted under
+	root string
 	// Available VF inventory
 	vfInventory map[string]VirtualFunction
+	// PFs with VFs configured, by name
+	pfInventory map[string]PhysicalFunction
 	// Mutex for protecting the inventory
 	mu sync.RWMutex
 	// Poll interval for VF discovery
 	pollInterval time.Duration
+	// Progress signal for the watchdog
+	heartbeat *watchdog.Heartbeat
+	// Sets the interfaces of the VFs up and down
+	links linkStater
+	// Whether the node is in the low-power idle mode
+	idle bool
+	// Interfaces of the free VFs set down while idle, by VF
+	downed map[string]string
+	// Triggers an immediate discovery on wake-up
+	wake chan struct{}
+	// Cache of the pods requesting a VF, nil until the informer synced
+	pods corelisters.PodLister
+	// Triggers the reconcile of the allocations on pod events
+	podEvents chan struct{}
+	// Latest allocation decisions per pod
+	decisions decisionLog
+	// Programs the storm control of the VFs, nil to leave them unlimited
+	storm StormController
+	// Storm control policy of pods without the annotation
+	stormDefaults StormPolicy
+	// Storm control policies programmed, by VF
+	stormPolicies map[string]StormPolicy
+	// Programs the MAC, VLAN, spoof check, trust and rate limits of the
+	// VFs, nil to leave them as they are
+	configurer VFConfigurer
+	// Evicts the pods using the VFs before disruptive PF changes, nil to
+	// refuse the changes while VFs are allocated
+	disrupter Disrupter
+	// Detaches and re-attaches the VFs of pods around PF resets, nil to
+	// evict the pods instead
+	plumber VFPlumber
+	// Takes the connections of pods off their VFs during PF resets, nil
+	// to leave them
+	quiescer Quiescer
+	// PFs being reset, their VFs are kept in the inventory meanwhile
+	resetting map[string]bool
+	// Longest time a reset PF is waited for to recover
+	resetTimeout time.Duration
+	// Interval the recovery of a reset PF is checked at
+	resetPollInterval time.Duration
+	// File the allocations are persisted in, empty to keep them in memory
+	stateFile string
+	// Allocations persisted last, to skip unchanged checkpoints
+	checkpointed []byte
+	// VFs with a configuration restored from the checkpoint, programmed
+	// again by the next reconcile
+	replayConfig map[string]bool
+	// Time the events of a hotplug are waited for to settle
+	hotplugSettle time.Duration
+	// Whether a rediscovery after hotplug events is scheduled
+	hotplugPending bool
+	// Removed VFs handed to pods by the CNI plugin, the VFs allocated in
+	// their place are moved into the pods
+	failovers map[types.NamespacedName]VirtualFunction
+	// Provisions the VFs of some PFs in place of NSM, nil if NSM owns them all
+	provisioner Provisioner
+	// Virtual machines requesting VFs, nil if only pods request them
+	vms VirtualMachines
+	// Node the VFs are allocated to the pods of, pods of all nodes if empty
+	nodeName string
+}
+
+// Only every idleSlowdown-th discovery runs in the idle mode
+const idleSlowdown = 10
+
+// DefaultPollInterval is the interval of the VF discovery without hotplug
+// events
+const DefaultPollInterval = 30 * time.Second
+
+// linkStater looks up network interfaces and sets them up and down
+type linkStater interface {
+	LinkByName(name string) (netutil.Link, error)
+	LinkSetUp(name string) error
+	LinkSetDown(name string) error
+}
+
+// PhysicalFunction represents an SR-IOV capable NIC with VFs configured
+type PhysicalFunction struct {
+	// Interface name (e.g., eth0)
+	Name string
+	// PF PCI address
+	PCIAddress string
+	// Number of VFs configured
+	NumVFs int
 }
 
 // VirtualFunction represents an SR-IOV Virtual Function
@@ -41,48 +133,111 @@ type VirtualFunction struct {
 	PCIAddress string
 	// VF interface name if bound to network driver
 	InterfaceName string
+	// NUMA node of the VF, -1 if unknown
+	NUMANode int
+	// Driver the VF is bound to (e.g., iavf, vfio-pci), empty if none
+	Driver string
 	// Whether the VF is allocated
 	Allocated bool
 	// Pod using this VF, if any
 	AllocatedTo string
 	// Namespace of the pod using this VF
 	Namespace string
+	// TTL of a leased allocation, renewed while the pod exists or through
+	// the API, zero for VFs allocated to pods requesting one with the label
+	LeaseTTL time.Duration
+	// Time the lease expires unless renewed
+	LeaseExpires time.Time
+	// Network namespace of the pod the VF was handed to by the CNI plugin
+	Netns string
+	// Interface name of the VF in the pod
+	PodInterface string
+	// Configuration programmed on the PF for the VF from the annotation of
+	// its pod, nil while the VF has the defaults
+	Config *VFConfig
 }
 
 // NewSRIOVManager creates a new SR-IOV manager
-func NewSRIOVManager(ctx context.Context, clientset *kubernetes.Clientset, logger *logrus.Logger) *SRIOVManager {
+func NewSRIOVManager(ctx context.Context, clientset kubernetes.Interface, logger *logrus.Logger) *SRIOVManager {
 	return &SRIOVManager{
-		ctx:          ctx,
-		clientset:    clientset,
-		logger:       logger,
-		vfInventory:  make(map[string]VirtualFunction),
-		pollInterval: 30 * time.Second,
+		ctx:               ctx,
+		clientset:         clientset,
+		logger:            logger,
+		root:              "/",
+		vfInventory:       make(map[string]VirtualFunction),
+		pfInventory:       make(map[string]PhysicalFunction),
+		pollInterval:      DefaultPollInterval,
+		links:             netutil.NewNetlink(),
+		downed:            make(map[string]string),
+		wake:              make(chan struct{}, 1),
+		podEvents:         make(chan struct{}, 1),
+		decisions:         make(decisionLog),
+		stormPolicies:     make(map[string]StormPolicy),
+		resetting:         make(map[string]bool),
+		resetTimeout:      2 * time.Minute,
+		resetPollInterval: 500 * time.Millisecond,
+		hotplugSettle:     500 * time.Millisecond,
+		failovers:         make(map[types.NamespacedName]VirtualFunction),
+		replayConfig:      make(map[string]bool),
 	}
 }
 
+// SetHeartbeat makes the manager report its progress to the watchdog
+func (m *SRIOVManager) SetHeartbeat(hb *watchdog.Heartbeat) {
+	m.heartbeat = hb
+	hb.Expect(m.pollInterval)
+}
+
 // Start begins the SR-IOV manager's operation
 func (m *SRIOVManager) Start() error {
 	m.logger.Info("Starting SR-IOV Manager")
 
+	// the allocations of the previous run, before any pod can get a VF
+	if err := m.restoreAllocations(); err != nil {
+		m.logger.WithError(err).Error("Failed to restore the VF allocations")
+	}
+
 	// initial discovery of VFs
 	if err := m.discoverVirtualFunctions(); err != nil {
 		m.logger.WithError(err).Error("Initial VF discovery failed")
 	}
 
+	// the allocations follow the pod events, the polls only rediscover
+	// the VFs and catch up on missed events. The pods are listed until the
+	// informer synced.
+	go func() {
+		if err := m.watchPods(); err != nil && m.ctx.Err() == nil {
+			m.logger.WithError(err).Error("Failed to watch the pods requesting SR-IOV, listing them on every poll")
+		}
+	}()
+
 	// start periodic discovery
-	ticker := time.NewTicker(m.pollInterval)
+	interval := m.interval()
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
-	for {
+	for ticks := 1; ; ticks++ {
 		select {
 		case <-ticker.C:
-			// rediscover VFs
-			if err := m.discoverVirtualFunctions(); err != nil {
-				m.logger.WithError(err).Error("VF discovery failed")
+			m.heartbeat.Beat()
+
+			// discovery slows down in the idle mode
+			if m.isIdle() && ticks%idleSlowdown != 0 {
 				continue
 			}
+			m.sync()
+
+		case <-m.wake:
+			if d := m.interval(); d != interval {
+				interval = d
+				ticker.Reset(interval)
+			}
+			m.sync()
 
-			// reconcile VF allocations
+		case <-m.podEvents:
+			if m.reconfiguring() {
+				continue
+			}
 			if err := m.reconcileAllocations(); err != nil {
 				m.logger.WithError(err).Error("VF allocation reconciliation failed")
 			}
@@ -94,19 +249,93 @@ func (m *SRIOVManager) Start() error {
 	}
 }
 
-// ValidateSRIOVCapabilities checks if the system supports SR-IOV
-func ValidateSRIOVCapabilities() error {
-	// does the sriov_numvfs file exists for any network device?
-	// TODO: use more robust validation
-	devices, err := filepath.Glob("sys/class/net/*/device/sriov_numvfs")
-	if err != nil {
-		return fmt.Errorf("failed to glob for sriov devices: %w", err)
+// sync rediscovers the VFs and reconciles their allocations
+func (m *SRIOVManager) sync() {
+	// the VFs vanish and reappear while the provisioner changes them
+	if m.reconfiguring() {
+		m.logger.Infof("%s is reconfiguring the VFs, holding the inventory", m.provisioner)
+		return
+	}
+
+	if err := m.discoverVirtualFunctions(); err != nil {
+		m.logger.WithError(err).Error("VF discovery failed")
+		return
+	}
+
+	if err := m.reconcileAllocations(); err != nil {
+		m.logger.WithError(err).Error("VF allocation reconciliation failed")
+		return
+	}
+	m.failOver()
+}
+
+// SetIdle switches the low-power idle mode: the interfaces of the free VFs
+// are set down and discovery slows down. Leaving it sets the interfaces up
+// again and discovers the VFs right away.
+func (m *SRIOVManager) SetIdle(idle bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.idle == idle {
+		return
+	}
+	m.idle = idle
+
+	if !idle {
+		for key, name := range m.downed {
+			m.linkUp(key, name)
+		}
+		m.Resync()
+		return
+	}
+
+	for key, vf := range m.vfInventory {
+		if vf.Allocated || vf.InterfaceName == "" {
+			continue
+		}
+		if err := m.links.LinkSetDown(vf.InterfaceName); err != nil {
+			m.logger.WithError(err).Warnf("Failed to set down the interface of free VF %s", key)
+			continue
+		}
+		m.downed[key] = vf.InterfaceName
 	}
+	m.logger.Infof("Set down the interfaces of %d free VFs", len(m.downed))
+}
 
-	if len(devices) == 0 {
-		return fmt.Errorf("no SR-IOV capable devices found")
+// Resync discovers the VFs and reconciles their allocations right away,
+// without waiting for the next poll
+func (m *SRIOVManager) Resync() {
+	select {
+	case m.wake <- struct{}{}:
+	default:
 	}
+}
 
+// isIdle reports whether the manager is in the idle mode
+func (m *SRIOVManager) isIdle() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.idle
+}
+
+// linkUp sets the interface of a VF set down in the idle mode up again,
+// the mutex must be held
+func (m *SRIOVManager) linkUp(key, name string) {
+	if err := m.links.LinkSetUp(name); err != nil {
+		m.logger.WithError(err).Warnf("Failed to set up the interface of VF %s", key)
+	}
+	delete(m.downed, key)
+}
+
+// path joins a sysfs path to the root
+func (m *SRIOVManager) path(elem ...string) string {
+	return filepath.Join(append([]string{m.root}, elem...)...)
+}
+
+// ValidateSRIOVCapabilities checks if the platform supports SR-IOV
+func ValidateSRIOVCapabilities(p *Platform) error {
+	if !p.SRIOV() {
+		return fmt.Errorf("no SR-IOV capable PCI devices found on %s", p.Arch)
+	}
 	return nil
 }
 
@@ -114,9 +343,25 @@ func ValidateSRIOVCapabilities() error {
 func (m *SRIOVManager) discoverVirtualFunctions() error {
 	// temp inventory for the newly discovered VFs (avoids race conditions)
 	newInventory := make(map[string]VirtualFunction)
+	newPFs := make(map[string]PhysicalFunction)
+
+	// the VFs of the PFs being reset come and go (the PF itself may
+	// vanish), they are kept as they were until the reset is over
+	m.mu.RLock()
+	for name, pf := range m.pfInventory {
+		if m.resetting[name] {
+			newPFs[name] = pf
+		}
+	}
+	for key, vf := range m.vfInventory {
+		if m.resetting[vf.PFName] {
+			newInventory[key] = vf
+		}
+	}
+	m.mu.RUnlock()
 
 	// find all network devices (in linux sysfs)
-	devices, err := filepath.Glob("/sys/class/net/*")
+	devices, err := filepath.Glob(m.path("sys/class/net/*"))
 	if err != nil {
 		return fmt.Errorf("failed to glob network devices: %w", err)
 	}
@@ -126,6 +371,11 @@ func (m *SRIOVManager) discoverVirtualFunctions() error {
 		// (e.g., "eth0" from "/sys/class/net/eth0")
 		pfName := filepath.Base(devicePath)
 
+		// the PFs being reset are kept as they were
+		if _, ok := newPFs[pfName]; ok {
+			continue
+		}
+
 		// skip virtual devices (e.g., Docker bridges, veth pairs)
 		if strings.HasPrefix(pfName, "docker") || strings.HasPrefix(pfName, "veth") {
 			continue
@@ -155,6 +405,11 @@ func (m *SRIOVManager) discoverVirtualFunctions() error {
 
 		// at this point, it's sure that this device has VFs
 		m.logger.Debugf("Found %d VFs for device %s", numVFs, pfName)
+		pf := PhysicalFunction{Name: pfName, NumVFs: numVFs}
+		if target, err := filepath.EvalSymlinks(filepath.Join(devicePath, "device")); err == nil {
+			pf.PCIAddress = filepath.Base(target)
+		}
+		newPFs[pfName] = pf
 
 		// get each VF's details
 		for vfID := range numVFs {
@@ -169,10 +424,31 @@ func (m *SRIOVManager) discoverVirtualFunctions() error {
 
 			// check if this VF was previously allocated (thread-safe read)
 			m.mu.RLock()
-			if existingVF, exists := m.vfInventory[vfKey]; exists && existingVF.Allocated {
+			existingVF, exists := m.vfInventory[vfKey]
+			// a VF key taken by another device (e.g., a replaced NIC) loses
+			// the allocation restored for it
+			if exists && existingVF.Allocated && existingVF.PCIAddress != "" && vf.PCIAddress != "" && existingVF.PCIAddress != vf.PCIAddress {
+				m.logger.Warnf("VF %s is now %s instead of %s, dropping its allocation to pod %s/%s",
+					vfKey, vf.PCIAddress, existingVF.PCIAddress, existingVF.Namespace, existingVF.AllocatedTo)
+				exists = false
+			}
+			if exists && existingVF.Allocated {
 				vf.Allocated = existingVF.Allocated
 				vf.AllocatedTo = existingVF.AllocatedTo
 				vf.Namespace = existingVF.Namespace
+				vf.LeaseTTL = existingVF.LeaseTTL
+				vf.LeaseExpires = existingVF.LeaseExpires
+				vf.Netns = existingVF.Netns
+				vf.PodInterface = existingVF.PodInterface
+				// the interface of a VF handed to a pod is in the network
+				// namespace of the pod, the host doesn't list it
+				if vf.InterfaceName == "" && vf.Netns != "" {
+					vf.InterfaceName = existingVF.InterfaceName
+				}
+			}
+			// the configuration stays with the VF until it is reset
+			if exists {
+				vf.Config = existingVF.Config
 			}
 			m.mu.RUnlock()
 
@@ -184,6 +460,8 @@ func (m *SRIOVManager) discoverVirtualFunctions() error {
 	// update inventory (thread-safe write)
 	m.mu.Lock()
 	m.vfInventory = newInventory
+	m.pfInventory = newPFs
+	m.checkpoint()
 	m.mu.Unlock()
 
 	m.logger.WithField("vfCount", len(newInventory)).Info("SR-IOV VF discovery completed")
@@ -198,7 +476,7 @@ func (m *SRIOVManager) getVFDetails(pfName string, vfID int) (VirtualFunction, e
 	}
 
 	// get PCI address
-	pciPath := fmt.Sprintf("/sys/class/net/%s/device/virtfn%d/uevent", pfName, vfID)
+	pciPath := m.path("sys/class/net", pfName, fmt.Sprintf("device/virtfn%d/uevent", vfID))
 	data, err := os.ReadFile(pciPath)
 	if err != nil {
 		return vf, fmt.Errorf("failed to read VF PCI into: %w", err)
@@ -212,26 +490,64 @@ func (m *SRIOVManager) getVFDetails(pfName string, vfID int) (VirtualFunction, e
 		}
 	}
 
-	// try to find the network interface name for this VF
-	// this is just a guess, as in real world, VF interface
-	// names are not guaranteed to follow: pfname_vfX
-	// if the guess is wrong, pods can't bind to it
-	vf.InterfaceName = fmt.Sprintf("%s_vf%d", pfName, vfID)
+	// some ARM64 PCIe host bridges don't report the slot in the uevent,
+	// the virtfn link points to the VF's PCI device directory instead
+	if vf.PCIAddress == "" {
+		if target, err := filepath.EvalSymlinks(m.path("sys/class/net", pfName, fmt.Sprintf("device/virtfn%d", vfID))); err == nil {
+			vf.PCIAddress = filepath.Base(target)
+		}
+	}
+
+	vf.InterfaceName = m.vfNetdev(pfName, vfID, vf.PCIAddress)
+
+	// pods may be constrained to a NUMA node or driver
+	device := m.path("sys/class/net", pfName, fmt.Sprintf("device/virtfn%d", vfID))
+	vf.NUMANode = readInt(filepath.Join(device, "numa_node"), -1)
+	if target, err := os.Readlink(filepath.Join(device, "driver")); err == nil {
+		vf.Driver = filepath.Base(target)
+	}
 
 	return vf, nil
 }
 
+// vfNetdev returns the network interface of a VF as the kernel lists it
+// under the PCI device, empty if the VF isn't bound to a network driver
+// or its interface is in another network namespace. The names depend on
+// the driver and the udev/systemd naming policy (e.g., enp59s0f0v1), they
+// can't be derived from the PF. The virtfn link of the PF reaches the same
+// device where /sys/bus/pci isn't mounted or the address is unknown.
+func (m *SRIOVManager) vfNetdev(pfName string, vfID int, pciAddress string) string {
+	var dirs []string
+	if pciAddress != "" {
+		dirs = append(dirs, m.path("sys/bus/pci/devices", pciAddress, "net"))
+	}
+	dirs = append(dirs, m.path("sys/class/net", pfName, fmt.Sprintf("device/virtfn%d/net", vfID)))
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil || len(entries) == 0 {
+			continue
+		}
+		// a VF has a single netdev, entries are sorted if a driver adds more
+		return entries[0].Name()
+	}
+	return ""
+}
+
 // reconcileAllocations reconciles VF allocations with pods that request them
 func (m *SRIOVManager) reconcileAllocations() error {
 	// get pods that request SR-IOV
-	pods, err := m.clientset.CoreV1().Pods("").List(m.ctx, metav1.ListOptions{
-		LabelSelector: "network.nsm.akosrbn.io/sriov=true",
-	})
+	pods, err := m.requestingPods()
 	if err != nil {
-		return fmt.Errorf("failed to list pods requesting SR-IOV: %w", err)
+		return err
 	}
 
-	m.logger.Debugf("Found %d pods requestion SR-IOV", len(pods.Items))
+	m.logger.Debugf("Found %d pods requesting SR-IOV", len(pods))
+
+	// leases are renewed by their pods, whether they request a VF or not
+	holders, err := m.existingLeaseHolders()
+	if err != nil {
+		return err
+	}
 
 	// track allocated VFs
 	allocatedVFs := make(map[string]bool)
@@ -239,12 +555,21 @@ func (m *SRIOVManager) reconcileAllocations() error {
 	// first pass: check existing allocations
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	defer m.checkpoint()
 
+	now := time.Now()
 	for key, vf := range m.vfInventory {
+		if vf.Allocated && vf.LeaseTTL > 0 {
+			if m.expireLease(key, vf, holders, now) {
+				allocatedVFs[key] = true
+			}
+			continue
+		}
+
 		// check if the pod that was using this VF still exists
 		podExists := false
 		if vf.Allocated && vf.AllocatedTo != "" {
-			for _, pod := range pods.Items {
+			for _, pod := range pods {
 				if pod.Name == vf.AllocatedTo && pod.Namespace == vf.Namespace {
 					// pod still exists, keep allocation
 					podExists = true
@@ -255,50 +580,135 @@ func (m *SRIOVManager) reconcileAllocations() error {
 		}
 
 		if !podExists {
+			if vf.Allocated {
+				vfReleases.WithLabelValues(vf.PFName, releasePodGone).Inc()
+				m.decisions.record(vf.Namespace, vf.AllocatedTo, Decision{
+					Reason:     ReasonPodGone,
+					Message:    fmt.Sprintf("pod no longer requests a VF, freed VF %s", key),
+					VF:         key,
+					PCIAddress: vf.PCIAddress,
+				}, now)
+			}
+
 			// pod no longer exists, free the VF
-			vf.Allocated = false
-			vf.AllocatedTo = ""
-			vf.Namespace = ""
-			m.vfInventory[key] = vf
+			m.vfInventory[key] = freeVF(vf)
 		}
 	}
 
 	// second pass: allocate VFs to pods that need them
-	for _, pod := range pods.Items {
+	for _, pod := range pods {
+		count := m.requestedVFs(&pod)
+
+		// skip if pod already has its VFs allocated
+		var held []string
+		for _, key := range m.keysByPCIAddress() {
+			if vf := m.vfInventory[key]; vf.Allocated && vf.AllocatedTo == pod.Name && vf.Namespace == pod.Namespace {
+				held = append(held, key)
+			}
+		}
+		if len(held) >= count {
+			message := fmt.Sprintf("pod holds VF %s", held[0])
+			if len(held) > 1 {
+				message = fmt.Sprintf("pod holds VFs %s", strings.Join(held, ", "))
+			}
+			m.decisions.record(pod.Namespace, pod.Name, Decision{
+				Reason:     ReasonAlreadyAllocated,
+				Message:    message,
+				Allocated:  true,
+				VF:         held[0],
+				PCIAddress: m.vfInventory[held[0]].PCIAddress,
+			}, now)
+			continue
+		}
+
 		// skip if pod is terminating
 		if pod.DeletionTimestamp != nil {
+			m.decisions.record(pod.Namespace, pod.Name, Decision{
+				Reason:  ReasonPodTerminating,
+				Message: "pod is terminating, no VF allocated",
+			}, now)
 			continue
 		}
 
-		// skip if pod already has a VF allocated
-		alreadyAllocated := false
-		for _, vf := range m.vfInventory {
-			if vf.Allocated && vf.AllocatedTo == pod.Name && vf.Namespace == pod.Namespace {
-				alreadyAllocated = true
-				break
-			}
+		sel, err := ParseVFSelector(pod.Annotations)
+		if err != nil {
+			m.logger.WithError(err).Warnf("Not allocating VFs to pod %s/%s", pod.Namespace, pod.Name)
+			m.decisions.record(pod.Namespace, pod.Name, Decision{
+				Reason:  ReasonInvalidSelector,
+				Message: err.Error(),
+			}, now)
+			continue
 		}
 
-		if alreadyAllocated {
+		// find available VFs, lowest PCI address first so the pick is
+		// predictable (what-if previews rely on it). A pod gets all the
+		// VFs it is missing or none, as a bond or VLAN set missing a
+		// member is of no use to it.
+		var free []string
+		var freeVFs []VirtualFunction
+		for _, key := range m.keysByPCIAddress() {
+			vf := m.vfInventory[key]
+			// the VFs of a PF being reset don't exist right now
+			if !vf.Allocated && !m.resetting[vf.PFName] {
+				free = append(free, key)
+				freeVFs = append(freeVFs, vf)
+			}
+		}
+		missing := count - len(held)
+		if len(free) < missing {
+			message := noFreeVFMessage(m.vfInventory)
+			if count > 1 {
+				message = fmt.Sprintf("pod requests %d VFs and holds %d, %d free", count, len(held), len(free))
+			}
+			m.logger.Warnf("Not enough free VFs for pod %s/%s: %s", pod.Namespace, pod.Name, message)
+			m.decisions.record(pod.Namespace, pod.Name, Decision{
+				Reason:  ReasonNoFreeVF,
+				Message: message,
+			}, now)
+			continue
+		}
+		heldVFs := make([]VirtualFunction, 0, len(held))
+		for _, key := range held {
+			heldVFs = append(heldVFs, m.vfInventory[key])
+		}
+		picked := SelectVFs(freeVFs, heldVFs, sel, missing)
+		if picked == nil {
+			message := noMatchingVFMessage(freeVFs, sel, missing)
+			m.logger.Warnf("Not enough matching VFs for pod %s/%s: %s", pod.Namespace, pod.Name, message)
+			m.decisions.record(pod.Namespace, pod.Name, Decision{
+				Reason:  ReasonNoMatchingVF,
+				Message: message,
+			}, now)
 			continue
 		}
 
-		// find an available VF
-		for key, vf := range m.vfInventory { // NOTE: vf is a copy, not a reference
-			if !vf.Allocated {
-				// allocate this VF to the pod
-				vf.Allocated = true
-				vf.AllocatedTo = pod.Name
-				vf.Namespace = pod.Namespace
-				m.vfInventory[key] = vf
-				allocatedVFs[key] = true
-
-				m.logger.Infof("Allocated VF %s to pod %s/%s", key, pod.Namespace, pod.Name)
-
-				break
+		for _, i := range picked {
+			key := free[i]
+			vf := m.vfInventory[key] // NOTE: vf is a copy, not a reference
+			// allocate this VF to the pod
+			vf.Allocated = true
+			vf.AllocatedTo = pod.Name
+			vf.Namespace = pod.Namespace
+			m.vfInventory[key] = vf
+			allocatedVFs[key] = true
+			if name, ok := m.downed[key]; ok {
+				m.linkUp(key, name)
 			}
+
+			vfAllocations.WithLabelValues(vf.PFName).Inc()
+			m.logger.Infof("Allocated VF %s to pod %s/%s", key, pod.Namespace, pod.Name)
+			m.decisions.record(pod.Namespace, pod.Name, Decision{
+				Reason:     ReasonAllocated,
+				Message:    fmt.Sprintf("allocated free VF %s of %s", key, vf.PFName),
+				Allocated:  true,
+				VF:         key,
+				PCIAddress: vf.PCIAddress,
+			}, now)
 		}
 	}
+	m.decisions.prune(now)
+	m.syncStormControl(pods)
+	m.syncVFConfig(pods)
 
 	m.logger.Infof("VF allocation reconciliation completed: %d/%d VFs allocated",
 		len(allocatedVFs), len(m.vfInventory))
@@ -306,36 +716,99 @@ func (m *SRIOVManager) reconcileAllocations() error {
 	return nil
 }
 
-// Get VFForPod returns the allocated VF for a pod, if any.
+// keysByPCIAddress returns the keys of the VF inventory ordered by PCI address
+func (m *SRIOVManager) keysByPCIAddress() []string {
+	keys := make([]string, 0, len(m.vfInventory))
+	for key := range m.vfInventory {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return m.vfInventory[keys[i]].PCIAddress < m.vfInventory[keys[j]].PCIAddress
+	})
+	return keys
+}
+
+// GetVFForPod returns the allocated VF for a pod, the one with the lowest
+// PCI address if it holds several, if any.
 func (m *SRIOVManager) GetVFForPod(namespace, podName string) (VirtualFunction, bool) {
+	vfs := m.GetVFsForPod(namespace, podName)
+	if len(vfs) == 0 {
+		return VirtualFunction{}, false
+	}
+	return vfs[0], true
+}
+
+// GetVFsForPod returns the VFs allocated to a pod, ordered by PCI address
+func (m *SRIOVManager) GetVFsForPod(namespace, podName string) []VirtualFunction {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
-	for _, vf := range m.vfInventory {
-		if vf.Allocated && vf.AllocatedTo == podName && vf.Namespace == namespace {
-			return vf, true
+	var vfs []VirtualFunction
+	for _, key := range m.keysByPCIAddress() {
+		if vf := m.vfInventory[key]; vf.Allocated && vf.AllocatedTo == podName && vf.Namespace == namespace {
+			vfs = append(vfs, vf)
 		}
 	}
-
-	return VirtualFunction{}, false
+	return vfs
 }
 
-// ReleaseVF releases a VF allocation
+// ReleaseVF releases the VFs allocated to a pod, false if it holds none
 func (m *SRIOVManager) ReleaseVF(namespace, podName string) bool {
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	defer m.checkpoint()
 
-	for key, vf := range m.vfInventory { // NOTE: vf is a copy, not a reference
+	released := false
+	for _, key := range m.keysByPCIAddress() {
+		vf := m.vfInventory[key] // NOTE: vf is a copy, not a reference
 		if vf.Allocated && vf.AllocatedTo == podName && vf.Namespace == namespace {
-			vf.Allocated = false
-			vf.AllocatedTo = ""
-			vf.Namespace = ""
-			m.vfInventory[key] = vf
+			m.vfInventory[key] = freeVF(vf)
+			vfReleases.WithLabelValues(vf.PFName, releaseRequested).Inc()
 
 			m.logger.Infof("Released VF %s from pod %s/%s", key, namespace, podName)
-			return true
+			m.decisions.record(namespace, podName, Decision{
+				Reason:     ReasonReleased,
+				Message:    fmt.Sprintf("VF %s released", key),
+				VF:         key,
+				PCIAddress: vf.PCIAddress,
+			}, time.Now())
+			released = true
 		}
 	}
 
-	return false
+	return released
+}
+
+// Explain returns the allocation decisions on a pod, false if the
+// allocator never decided on it (e.g., it doesn't request a VF)
+func (m *SRIOVManager) Explain(namespace, podName string) (Explanation, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.decisions.explain(namespace, podName)
+}
+
+// VirtualFunctions returns a copy of the VF inventory, ordered by PCI address
+func (m *SRIOVManager) VirtualFunctions() []VirtualFunction {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	vfs := make([]VirtualFunction, 0, len(m.vfInventory))
+	for _, vf := range m.vfInventory {
+		vfs = append(vfs, vf)
+	}
+	sort.Slice(vfs, func(i, j int) bool { return vfs[i].PCIAddress < vfs[j].PCIAddress })
+	return vfs
+}
+
+// PhysicalFunctions returns a copy of the PF inventory, ordered by name
+func (m *SRIOVManager) PhysicalFunctions() []PhysicalFunction {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	pfs := make([]PhysicalFunction, 0, len(m.pfInventory))
+	for _, pf := range m.pfInventory {
+		pfs = append(pfs, pf)
+	}
+	sort.Slice(pfs, func(i, j int) bool { return pfs[i].Name < pfs[j].Name })
+	return pfs
 }
@@ -0,0 +1,193 @@
+package hardware
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/akos011221/nsm/pkg/netutil"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// recordingLinks records the interfaces set up and down, every interface
+// exists except the missing ones
+type recordingLinks struct {
+	up      []string
+	down    []string
+	missing map[string]bool
+}
+
+func (l *recordingLinks) LinkByName(name string) (netutil.Link, error) {
+	if l.missing[name] {
+		return netutil.Link{}, fmt.Errorf("link %s: %w", name, netutil.ErrNotFound)
+	}
+	return netutil.Link{Name: name, Up: true}, nil
+}
+
+func (l *recordingLinks) LinkSetUp(name string) error {
+	l.up = append(l.up, name)
+	return nil
+}
+
+func (l *recordingLinks) LinkSetDown(name string) error {
+	l.down = append(l.down, name)
+	return nil
+}
+
+func TestSRIOVManagerIdle(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	m := NewSRIOVManager(context.Background(), nil, logger)
+	links := &recordingLinks{}
+	m.links = links
+	m.vfInventory = map[string]VirtualFunction{
+		"eth0-0": {PFName: "eth0", VFID: 0, PCIAddress: "0000:3b:02.0", InterfaceName: "eth0v0", Allocated: true, AllocatedTo: "camera", Namespace: "edge"},
+		"eth0-1": {PFName: "eth0", VFID: 1, PCIAddress: "0000:3b:02.1", InterfaceName: "eth0v1"},
+		"eth0-2": {PFName: "eth0", VFID: 2, PCIAddress: "0000:3b:02.2", InterfaceName: "eth0v2"},
+		// bound to a userspace driver, no interface
+		"eth0-3": {PFName: "eth0", VFID: 3, PCIAddress: "0000:3b:02.3"},
+	}
+
+	m.SetIdle(true)
+	sort.Strings(links.down)
+	if !reflect.DeepEqual(links.down, []string{"eth0v1", "eth0v2"}) {
+		t.Errorf("set down %v, want the interfaces of the free VFs", links.down)
+	}
+	if !m.isIdle() {
+		t.Errorf("manager not idle")
+	}
+
+	m.SetIdle(false)
+	sort.Strings(links.up)
+	if !reflect.DeepEqual(links.up, []string{"eth0v1", "eth0v2"}) {
+		t.Errorf("set up %v, want the interfaces set down while idle", links.up)
+	}
+	select {
+	case <-m.wake:
+	default:
+		t.Errorf("waking up did not trigger a discovery")
+	}
+	if len(m.downed) != 0 {
+		t.Errorf("interfaces still recorded as down: %v", m.downed)
+	}
+}
+
+func sriovPod(name string) *corev1.Pod {
+	return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name:      name,
+		Namespace: "edge",
+		Labels:    map[string]string{"network.nsm.akosrbn.io/sriov": "true"},
+	}}
+}
+
+func TestSRIOVManagerExplain(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	clientset := fake.NewSimpleClientset(sriovPod("camera"), sriovPod("lidar"))
+	m := NewSRIOVManager(context.Background(), clientset, logger)
+	m.links = &recordingLinks{}
+	m.vfInventory = map[string]VirtualFunction{
+		"eth0-vf0": {PFName: "eth0", VFID: 0, PCIAddress: "0000:3b:02.0"},
+	}
+
+	if err := m.reconcileAllocations(); err != nil {
+		t.Fatalf("reconcileAllocations() error = %v", err)
+	}
+	holder, waiting := "camera", "lidar"
+	if _, ok := m.GetVFForPod("edge", "lidar"); ok {
+		holder, waiting = waiting, holder
+	}
+
+	exp, ok := m.Explain("edge", waiting)
+	if !ok || exp.Allocated || len(exp.Decisions) != 1 || exp.Decisions[0].Reason != ReasonNoFreeVF {
+		t.Fatalf("unexpected explanation of the pod without a VF: %+v", exp)
+	}
+	if want := "no free VFs (eth0 1/1 allocated)"; exp.Decisions[0].Message != want {
+		t.Errorf("message = %q, want %q", exp.Decisions[0].Message, want)
+	}
+
+	// repeated decisions are merged
+	if err := m.reconcileAllocations(); err != nil {
+		t.Fatalf("reconcileAllocations() error = %v", err)
+	}
+	exp, _ = m.Explain("edge", holder)
+	if len(exp.Decisions) != 2 || exp.Decisions[0].Reason != ReasonAlreadyAllocated || exp.Decisions[1].Reason != ReasonAllocated {
+		t.Fatalf("unexpected explanation of the pod with a VF: %+v", exp)
+	}
+	if exp, _ := m.Explain("edge", waiting); len(exp.Decisions) != 1 {
+		t.Errorf("repeated decision not merged: %+v", exp)
+	}
+
+	// the VF moves on once its pod is gone
+	if err := clientset.CoreV1().Pods("edge").Delete(context.Background(), holder, metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.reconcileAllocations(); err != nil {
+		t.Fatalf("reconcileAllocations() error = %v", err)
+	}
+	if exp, _ := m.Explain("edge", holder); exp.Allocated || exp.Decisions[0].Reason != ReasonPodGone || exp.Decisions[0].VF != "eth0-vf0" {
+		t.Errorf("unexpected explanation of the deleted pod: %+v", exp)
+	}
+	if exp, _ := m.Explain("edge", waiting); !exp.Allocated || exp.Decisions[0].Reason != ReasonAllocated || exp.Decisions[0].PCIAddress != "0000:3b:02.0" {
+		t.Errorf("unexpected explanation of the waiting pod: %+v", exp)
+	}
+
+	if _, ok := m.Explain("edge", "unknown"); ok {
+		t.Errorf("explained a pod the allocator never decided on")
+	}
+}
+
+func TestSRIOVManagerAllocatesLowestPCIAddress(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	m := NewSRIOVManager(context.Background(), fake.NewSimpleClientset(sriovPod("camera")), logger)
+	m.links = &recordingLinks{}
+	m.vfInventory = map[string]VirtualFunction{
+		"eth1-0": {PFName: "eth1", VFID: 0, PCIAddress: "0000:5e:02.0"},
+		"eth0-1": {PFName: "eth0", VFID: 1, PCIAddress: "0000:3b:02.1"},
+		"eth0-0": {PFName: "eth0", VFID: 0, PCIAddress: "0000:3b:02.0", Allocated: true, AllocatedTo: "camera", Namespace: "other"},
+	}
+	if err := m.reconcileAllocations(); err != nil {
+		t.Fatalf("reconcileAllocations() error = %v", err)
+	}
+	// the VF of the gone pod is freed first, so it has the lowest address
+	if vf, ok := m.GetVFForPod("edge", "camera"); !ok || vf.PCIAddress != "0000:3b:02.0" {
+		t.Errorf("allocated %+v, want the free VF with the lowest PCI address", vf)
+	}
+}
+
+func TestDiscoverResolvesVFInterfaces(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	fs := newFakeSysfs(t)
+	fs.write("sys/class/net/eth0/device/sriov_numvfs", "4\n")
+	for i := range 4 {
+		fs.write(fmt.Sprintf("sys/class/net/eth0/device/virtfn%d/uevent", i), fmt.Sprintf("PCI_SLOT_NAME=0000:3b:02.%d\n", i))
+	}
+	// named by systemd after the slot, found under the PCI device
+	fs.mkdir("sys/bus/pci/devices/0000:3b:02.0/net/enp59s0f0v0")
+	// found through the virtfn link of the PF
+	fs.mkdir("sys/class/net/eth0/device/virtfn1/net/eth5")
+	// VF 2 is bound to vfio-pci, VF 3 is in a pod
+
+	m := NewSRIOVManager(context.Background(), fake.NewSimpleClientset(), logger)
+	m.root = fs.root
+	m.vfInventory["eth0-vf3"] = VirtualFunction{PFName: "eth0", VFID: 3, PCIAddress: "0000:3b:02.3", InterfaceName: "enp59s0f0v3",
+		Allocated: true, AllocatedTo: "camera", Namespace: "edge", Netns: "/var/run/netns/camera", PodInterface: "net1"}
+	if err := m.discoverVirtualFunctions(); err != nil {
+		t.Fatalf("discoverVirtualFunctions() error = %v", err)
+	}
+
+	want := map[string]string{"eth0-vf0": "enp59s0f0v0", "eth0-vf1": "eth5", "eth0-vf2": "", "eth0-vf3": "enp59s0f0v3"}
+	for key, name := range want {
+		if got := m.vfInventory[key].InterfaceName; got != name {
+			t.Errorf("InterfaceName of %s = %q, want %q", key, got, name)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package hardware
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// AnnotationStormControl overrides the storm control policy of the VF of a
+// pod, e.g., "broadcast=100,multicast=1000" (packets per second, 0 for no
+// limit). Limits left out keep the node defaults.
+const AnnotationStormControl = "network.nsm.akosrbn.io/storm-control"
+
+// StormPolicy limits the broadcast and multicast traffic a pod sends
+// through its VF, so a misbehaving pod can't flood the other VFs sharing
+// the physical function
+type StormPolicy struct {
+	// Broadcast packets per second, 0 for no limit
+	BroadcastPPS int
+	// Multicast packets per second, 0 for no limit
+	MulticastPPS int
+}
+
+// Enabled reports whether the policy limits any traffic
+func (p StormPolicy) Enabled() bool {
+	return p.BroadcastPPS > 0 || p.MulticastPPS > 0
+}
+
+// StormController programs the storm control of VFs
+type StormController interface {
+	// Apply programs the policy on a VF, replacing the previous one
+	Apply(ctx context.Context, vf VirtualFunction, policy StormPolicy) error
+	// Remove lifts the storm control of a VF
+	Remove(ctx context.Context, vf VirtualFunction) error
+}
+
+// ParseStormPolicy parses the storm control annotation of a pod over the
+// node defaults
+func ParseStormPolicy(value string, defaults StormPolicy) (StormPolicy, error) {
+	policy := defaults
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		kind, val, ok := strings.Cut(field, "=")
+		if !ok {
+			return defaults, fmt.Errorf("invalid storm control limit %q, want kind=pps", field)
+		}
+		pps, err := strconv.Atoi(strings.TrimSpace(val))
+		if err != nil || pps < 0 {
+			return defaults, fmt.Errorf("invalid storm control limit %q, want a non-negative rate", field)
+		}
+		switch strings.TrimSpace(kind) {
+		case "broadcast":
+			policy.BroadcastPPS = pps
+		case "multicast":
+			policy.MulticastPPS = pps
+		default:
+			return defaults, fmt.Errorf("unknown storm control traffic %q, must be broadcast or multicast", kind)
+		}
+	}
+	return policy, nil
+}
+
+// SetStormControl makes the manager police the broadcast and multicast
+// traffic of allocated VFs, with the defaults for pods not annotated
+func (m *SRIOVManager) SetStormControl(sc StormController, defaults StormPolicy) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.storm = sc
+	m.stormDefaults = defaults
+}
+
+// syncStormControl brings the storm control of the VFs in line with the
+// policies of the pods holding them, the mutex must be held
+func (m *SRIOVManager) syncStormControl(pods []corev1.Pod) {
+	if m.storm == nil {
+		return
+	}
+	annotations := make(map[string]string)
+	for _, pod := range pods {
+		annotations[pod.Namespace+"/"+pod.Name] = pod.Annotations[AnnotationStormControl]
+	}
+
+	for _, key := range m.keysByPCIAddress() {
+		vf := m.vfInventory[key]
+		applied, programmed := m.stormPolicies[key]
+
+		var policy StormPolicy
+		if vf.Allocated && vf.InterfaceName != "" {
+			var err error
+			policy, err = ParseStormPolicy(annotations[vf.Namespace+"/"+vf.AllocatedTo], m.stormDefaults)
+			if err != nil {
+				m.logger.WithError(err).Warnf("Ignoring the storm control annotation of pod %s/%s", vf.Namespace, vf.AllocatedTo)
+			}
+		}
+
+		if !policy.Enabled() {
+			if !programmed {
+				continue
+			}
+			if err := m.storm.Remove(m.ctx, vf); err != nil {
+				m.logger.WithError(err).Warnf("Failed to lift the storm control of VF %s", key)
+				continue
+			}
+			delete(m.stormPolicies, key)
+			m.logger.Infof("Lifted the storm control of VF %s", key)
+			continue
+		}
+		if programmed && applied == policy {
+			continue
+		}
+		if err := m.storm.Apply(m.ctx, vf, policy); err != nil {
+			m.logger.WithError(err).Warnf("Failed to program the storm control of VF %s", key)
+			continue
+		}
+		m.stormPolicies[key] = policy
+		m.logger.Infof("VF %s of pod %s/%s limited to %d broadcast and %d multicast packets/s (0 unlimited)",
+			key, vf.Namespace, vf.AllocatedTo, policy.BroadcastPPS, policy.MulticastPPS)
+	}
+}
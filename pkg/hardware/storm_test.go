@@ -0,0 +1,100 @@
+package hardware
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// recordingStorm records the storm control programmed on VFs
+type recordingStorm struct {
+	calls []string
+}
+
+func (s *recordingStorm) Apply(ctx context.Context, vf VirtualFunction, policy StormPolicy) error {
+	s.calls = append(s.calls, fmt.Sprintf("apply %s %d/%d", vf.InterfaceName, policy.BroadcastPPS, policy.MulticastPPS))
+	return nil
+}
+
+func (s *recordingStorm) Remove(ctx context.Context, vf VirtualFunction) error {
+	s.calls = append(s.calls, "remove "+vf.InterfaceName)
+	return nil
+}
+
+func TestParseStormPolicy(t *testing.T) {
+	defaults := StormPolicy{BroadcastPPS: 100, MulticastPPS: 1000}
+	tests := []struct {
+		value   string
+		want    StormPolicy
+		wantErr bool
+	}{
+		{value: "", want: defaults},
+		{value: "broadcast=10", want: StormPolicy{BroadcastPPS: 10, MulticastPPS: 1000}},
+		{value: "broadcast=0, multicast=50", want: StormPolicy{MulticastPPS: 50}},
+		{value: "unicast=10", want: defaults, wantErr: true},
+		{value: "broadcast=-1", want: defaults, wantErr: true},
+		{value: "broadcast", want: defaults, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseStormPolicy(tt.value, defaults)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseStormPolicy(%q) = %+v, %v, want %+v", tt.value, got, err, tt.want)
+		}
+	}
+}
+
+func TestSRIOVManagerStormControl(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	camera := sriovPod("camera")
+	camera.Annotations = map[string]string{AnnotationStormControl: "multicast=0"}
+	clientset := fake.NewSimpleClientset(camera, sriovPod("lidar"))
+	m := NewSRIOVManager(context.Background(), clientset, logger)
+	m.links = &recordingLinks{}
+	m.vfInventory = map[string]VirtualFunction{
+		"eth0-vf0": {PFName: "eth0", VFID: 0, PCIAddress: "0000:3b:02.0", InterfaceName: "eth0_vf0"},
+		"eth0-vf1": {PFName: "eth0", VFID: 1, PCIAddress: "0000:3b:02.1", InterfaceName: "eth0_vf1"},
+		"eth0-vf2": {PFName: "eth0", VFID: 2, PCIAddress: "0000:3b:02.2", InterfaceName: "eth0_vf2"},
+	}
+	storm := &recordingStorm{}
+	m.SetStormControl(storm, StormPolicy{BroadcastPPS: 100, MulticastPPS: 1000})
+
+	if err := m.reconcileAllocations(); err != nil {
+		t.Fatalf("reconcileAllocations() error = %v", err)
+	}
+	vf, _ := m.GetVFForPod("edge", "camera")
+	other, _ := m.GetVFForPod("edge", "lidar")
+	want := map[string]bool{
+		"apply " + vf.InterfaceName + " 100/0":       true,
+		"apply " + other.InterfaceName + " 100/1000": true,
+	}
+	if len(storm.calls) != 2 || !want[storm.calls[0]] || !want[storm.calls[1]] {
+		t.Fatalf("unexpected storm control %v, free VFs stay unlimited", storm.calls)
+	}
+
+	// unchanged policies aren't programmed again
+	storm.calls = nil
+	if err := m.reconcileAllocations(); err != nil {
+		t.Fatalf("reconcileAllocations() error = %v", err)
+	}
+	if len(storm.calls) != 0 {
+		t.Errorf("unchanged policies reprogrammed: %v", storm.calls)
+	}
+
+	// the VF of a gone pod is no longer limited
+	if err := clientset.CoreV1().Pods("edge").Delete(context.Background(), "camera", metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.reconcileAllocations(); err != nil {
+		t.Fatalf("reconcileAllocations() error = %v", err)
+	}
+	if want := []string{"remove " + vf.InterfaceName}; !reflect.DeepEqual(storm.calls, want) {
+		t.Errorf("calls = %v, want %v", storm.calls, want)
+	}
+}
@@ -0,0 +1,170 @@
+package hardware
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// AnnotationVFConfig sets how the PF programs the VF of a pod, e.g.,
+// "mac=02:00:00:00:01:01,vlan=100,qos=3,spoofchk=off,trust=on,max_tx_rate=1000"
+// (rates in Mbps, 0 for no limit). Settings left out keep the defaults of
+// a VF: no MAC or VLAN, spoof check on, untrusted and unlimited.
+const AnnotationVFConfig = "network.nsm.akosrbn.io/vf-config"
+
+// DefaultVFConfig is the configuration of a VF nobody configured, the VFs
+// of pods without the annotation are left as they are
+var DefaultVFConfig = VFConfig{SpoofCheck: true}
+
+// VFConfigurer programs the settings the PF applies to a VF: MAC, VLAN,
+// spoof check, trust and rate limits
+type VFConfigurer interface {
+	// Configure programs the configuration on a VF, replacing the previous
+	// one. Settings left empty are cleared.
+	Configure(ctx context.Context, vf VirtualFunction, cfg VFConfig) error
+}
+
+// ParseVFConfig parses the VF configuration annotation of a pod over the
+// defaults of a VF
+func ParseVFConfig(value string) (VFConfig, error) {
+	cfg := DefaultVFConfig
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		key, val, ok := strings.Cut(field, "=")
+		if !ok {
+			return DefaultVFConfig, fmt.Errorf("invalid VF setting %q, want key=value", field)
+		}
+		key, val = strings.TrimSpace(key), strings.TrimSpace(val)
+		switch key {
+		case "mac":
+			mac, err := net.ParseMAC(val)
+			if err != nil || len(mac) != 6 {
+				return DefaultVFConfig, fmt.Errorf("invalid VF MAC %q", val)
+			}
+			if mac[0]&1 != 0 {
+				return DefaultVFConfig, fmt.Errorf("invalid VF MAC %q, must be unicast", val)
+			}
+			cfg.MAC = mac.String()
+		case "vlan", "qos", "max_tx_rate", "min_tx_rate":
+			n, err := strconv.Atoi(val)
+			if err != nil || n < 0 {
+				return DefaultVFConfig, fmt.Errorf("invalid VF setting %q, want a non-negative number", field)
+			}
+			switch key {
+			case "vlan":
+				if n > 4094 {
+					return DefaultVFConfig, fmt.Errorf("invalid VF VLAN %d, must be between 0 and 4094", n)
+				}
+				cfg.VLAN = n
+			case "qos":
+				if n > 7 {
+					return DefaultVFConfig, fmt.Errorf("invalid VF QoS %d, must be between 0 and 7", n)
+				}
+				cfg.QoS = n
+			case "max_tx_rate":
+				cfg.MaxTxRateMbps = n
+			case "min_tx_rate":
+				cfg.MinTxRateMbps = n
+			}
+		case "spoofchk", "trust":
+			var on bool
+			switch val {
+			case "on":
+				on = true
+			case "off":
+			default:
+				return DefaultVFConfig, fmt.Errorf("invalid VF setting %q, want on or off", field)
+			}
+			if key == "spoofchk" {
+				cfg.SpoofCheck = on
+			} else {
+				cfg.Trust = on
+			}
+		default:
+			return DefaultVFConfig, fmt.Errorf("unknown VF setting %q, must be mac, vlan, qos, spoofchk, trust, max_tx_rate or min_tx_rate", key)
+		}
+	}
+	if cfg.QoS > 0 && cfg.VLAN == 0 {
+		return DefaultVFConfig, fmt.Errorf("VF QoS %d needs a VLAN", cfg.QoS)
+	}
+	if cfg.MaxTxRateMbps > 0 && cfg.MinTxRateMbps > cfg.MaxTxRateMbps {
+		return DefaultVFConfig, fmt.Errorf("VF min_tx_rate %d exceeds max_tx_rate %d", cfg.MinTxRateMbps, cfg.MaxTxRateMbps)
+	}
+	return cfg, nil
+}
+
+// SetVFConfigurer makes the manager program the VFs of pods with the
+// configuration annotation, and reset them once the pods release them
+func (m *SRIOVManager) SetVFConfigurer(configurer VFConfigurer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.configurer = configurer
+}
+
+// syncVFConfig brings the configuration of the VFs in line with the
+// annotations of the pods holding them, the mutex must be held. VFs that
+// were configured and are no longer asked to be get the defaults back, so
+// the next pod doesn't inherit the MAC or VLAN of the previous one.
+func (m *SRIOVManager) syncVFConfig(pods []corev1.Pod) {
+	if m.configurer == nil {
+		return
+	}
+	annotations := make(map[string]string)
+	for _, pod := range pods {
+		annotations[pod.Namespace+"/"+pod.Name] = pod.Annotations[AnnotationVFConfig]
+	}
+
+	for _, key := range m.keysByPCIAddress() {
+		vf := m.vfInventory[key]
+		// the VFs of a PF being reset don't exist right now
+		if m.resetting[vf.PFName] {
+			continue
+		}
+
+		replay := m.replayConfig[key]
+		delete(m.replayConfig, key)
+
+		var want *VFConfig
+		if value := annotations[vf.Namespace+"/"+vf.AllocatedTo]; vf.Allocated && value != "" {
+			cfg, err := ParseVFConfig(value)
+			if err != nil {
+				m.logger.WithError(err).Warnf("Ignoring the VF configuration annotation of pod %s/%s", vf.Namespace, vf.AllocatedTo)
+			} else {
+				want = &cfg
+			}
+		}
+
+		if want == nil {
+			if vf.Config == nil {
+				continue
+			}
+			if err := m.configurer.Configure(m.ctx, vf, DefaultVFConfig); err != nil {
+				m.logger.WithError(err).Warnf("Failed to reset the configuration of VF %s", key)
+				continue
+			}
+			vf.Config = nil
+			m.vfInventory[key] = vf
+			m.logger.Infof("Reset the configuration of VF %s", key)
+			continue
+		}
+		if !replay && vf.Config != nil && reflect.DeepEqual(*vf.Config, *want) {
+			continue
+		}
+		if err := m.configurer.Configure(m.ctx, vf, *want); err != nil {
+			m.logger.WithError(err).Warnf("Failed to configure VF %s for pod %s/%s", key, vf.Namespace, vf.AllocatedTo)
+			continue
+		}
+		vf.Config = want
+		m.vfInventory[key] = vf
+		m.logger.Infof("Configured VF %s for pod %s/%s: mac %q, vlan %d, qos %d, spoofchk %t, trust %t, tx rate %d-%d Mbps (0 unlimited)",
+			key, vf.Namespace, vf.AllocatedTo, want.MAC, want.VLAN, want.QoS, want.SpoofCheck, want.Trust, want.MinTxRateMbps, want.MaxTxRateMbps)
+	}
+}
@@ -0,0 +1,109 @@
+package hardware
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// recordingConfigurer records the configurations programmed on VFs
+type recordingConfigurer struct {
+	calls []string
+}
+
+func (c *recordingConfigurer) Configure(ctx context.Context, vf VirtualFunction, cfg VFConfig) error {
+	c.calls = append(c.calls, fmt.Sprintf("%s mac=%s vlan=%d spoofchk=%t trust=%t max=%d",
+		vf.InterfaceName, cfg.MAC, cfg.VLAN, cfg.SpoofCheck, cfg.Trust, cfg.MaxTxRateMbps))
+	return nil
+}
+
+func TestParseVFConfig(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    VFConfig
+		wantErr bool
+	}{
+		{value: "", want: DefaultVFConfig},
+		{
+			value: "mac=02:00:00:00:01:01, vlan=100, qos=3, spoofchk=off, trust=on, max_tx_rate=1000, min_tx_rate=100",
+			want:  VFConfig{MAC: "02:00:00:00:01:01", VLAN: 100, QoS: 3, Trust: true, MaxTxRateMbps: 1000, MinTxRateMbps: 100},
+		},
+		{value: "MAC=02:00:00:00:01:01", want: DefaultVFConfig, wantErr: true},
+		{value: "mac=01:00:5e:00:00:01", want: DefaultVFConfig, wantErr: true},
+		{value: "mac=zz", want: DefaultVFConfig, wantErr: true},
+		{value: "vlan=4095", want: DefaultVFConfig, wantErr: true},
+		{value: "qos=3", want: DefaultVFConfig, wantErr: true},
+		{value: "trust=yes", want: DefaultVFConfig, wantErr: true},
+		{value: "max_tx_rate=-1", want: DefaultVFConfig, wantErr: true},
+		{value: "max_tx_rate=100,min_tx_rate=200", want: DefaultVFConfig, wantErr: true},
+		{value: "vlan", want: DefaultVFConfig, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseVFConfig(tt.value)
+		if (err != nil) != tt.wantErr || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseVFConfig(%q) = %+v, %v, want %+v", tt.value, got, err, tt.want)
+		}
+	}
+}
+
+func TestSRIOVManagerVFConfig(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	camera := sriovPod("camera")
+	camera.Annotations = map[string]string{AnnotationVFConfig: "mac=02:00:00:00:01:01,vlan=100,trust=on"}
+	lidar := sriovPod("lidar")
+	lidar.Annotations = map[string]string{AnnotationVFConfig: "vlan=9999"}
+	clientset := fake.NewSimpleClientset(camera, lidar, sriovPod("radar"))
+	m := NewSRIOVManager(context.Background(), clientset, logger)
+	m.links = &recordingLinks{}
+	m.vfInventory = map[string]VirtualFunction{
+		"eth0-vf0": {PFName: "eth0", VFID: 0, PCIAddress: "0000:3b:02.0", InterfaceName: "eth0_vf0"},
+		"eth0-vf1": {PFName: "eth0", VFID: 1, PCIAddress: "0000:3b:02.1", InterfaceName: "eth0_vf1"},
+		"eth0-vf2": {PFName: "eth0", VFID: 2, PCIAddress: "0000:3b:02.2", InterfaceName: "eth0_vf2"},
+	}
+	configurer := &recordingConfigurer{}
+	m.SetVFConfigurer(configurer)
+
+	if err := m.reconcileAllocations(); err != nil {
+		t.Fatalf("reconcileAllocations() error = %v", err)
+	}
+	// only the VF of the pod with a valid annotation is programmed
+	vf, _ := m.GetVFForPod("edge", "camera")
+	want := []string{vf.InterfaceName + " mac=02:00:00:00:01:01 vlan=100 spoofchk=true trust=true max=0"}
+	if !reflect.DeepEqual(configurer.calls, want) {
+		t.Fatalf("calls = %v, want %v", configurer.calls, want)
+	}
+	if vf.Config == nil || vf.Config.VLAN != 100 {
+		t.Errorf("Config = %+v, want the programmed configuration", vf.Config)
+	}
+
+	// an unchanged configuration isn't programmed again
+	configurer.calls = nil
+	if err := m.reconcileAllocations(); err != nil {
+		t.Fatalf("reconcileAllocations() error = %v", err)
+	}
+	if len(configurer.calls) != 0 {
+		t.Errorf("unchanged configuration reprogrammed: %v", configurer.calls)
+	}
+
+	// the VF of a gone pod gets the defaults back
+	if err := clientset.CoreV1().Pods("edge").Delete(context.Background(), "camera", metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.reconcileAllocations(); err != nil {
+		t.Fatalf("reconcileAllocations() error = %v", err)
+	}
+	want = []string{vf.InterfaceName + " mac= vlan=0 spoofchk=true trust=false max=0"}
+	if !reflect.DeepEqual(configurer.calls, want) {
+		t.Errorf("calls = %v, want %v", configurer.calls, want)
+	}
+	if freed := m.vfInventory["eth0-vf"+fmt.Sprint(vf.VFID)]; freed.Config != nil {
+		t.Errorf("freed VF keeps the configuration %+v", freed.Config)
+	}
+}
@@ -0,0 +1,41 @@
+package hardware
+
+import (
+	"fmt"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// AnnotationSRIOVCount sets the number of VFs a pod requesting SR-IOV
+// gets, e.g., "2" for bonded uplinks or a VF per VLAN. Pods without it
+// get one.
+const AnnotationSRIOVCount = "network.nsm.akosrbn.io/sriov-count"
+
+// maxVFsPerPod bounds the VF count a pod can request, a typo in the
+// annotation shouldn't drain the node
+const maxVFsPerPod = 16
+
+// ParseVFCount parses the VF count annotation of a pod
+func ParseVFCount(value string) (int, error) {
+	count, err := strconv.Atoi(value)
+	if err != nil || count < 1 || count > maxVFsPerPod {
+		return 0, fmt.Errorf("invalid VF count %q, must be between 1 and %d", value, maxVFsPerPod)
+	}
+	return count, nil
+}
+
+// requestedVFs returns the number of VFs a pod requests, one if the
+// annotation is missing or invalid
+func (m *SRIOVManager) requestedVFs(pod *corev1.Pod) int {
+	value, ok := pod.Annotations[AnnotationSRIOVCount]
+	if !ok {
+		return 1
+	}
+	count, err := ParseVFCount(value)
+	if err != nil {
+		m.logger.WithError(err).Warnf("Ignoring the VF count annotation of pod %s/%s", pod.Namespace, pod.Name)
+		return 1
+	}
+	return count
+}
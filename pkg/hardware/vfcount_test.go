@@ -0,0 +1,85 @@
+package hardware
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestParseVFCount(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    int
+		wantErr bool
+	}{
+		{"1", 1, false},
+		{"4", 4, false},
+		{"0", 0, true},
+		{"-1", 0, true},
+		{"17", 0, true},
+		{"two", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseVFCount(tt.value)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseVFCount(%q) = %d, %v, want %d, error %t", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestSRIOVManagerAllocatesVFCount(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	bonded, router := sriovPod("bonded"), sriovPod("router")
+	bonded.Annotations = map[string]string{AnnotationSRIOVCount: "2"}
+	router.Annotations = map[string]string{AnnotationSRIOVCount: "3"}
+	m := NewSRIOVManager(context.Background(), fake.NewSimpleClientset(bonded, router), logger)
+	m.links = &recordingLinks{}
+	m.vfInventory = map[string]VirtualFunction{
+		"eth0-vf0": {PFName: "eth0", VFID: 0, PCIAddress: "0000:3b:02.0"},
+		"eth0-vf1": {PFName: "eth0", VFID: 1, PCIAddress: "0000:3b:02.1"},
+		"eth1-vf0": {PFName: "eth1", VFID: 0, PCIAddress: "0000:5e:02.0"},
+		"eth1-vf1": {PFName: "eth1", VFID: 1, PCIAddress: "0000:5e:02.1"},
+	}
+	if err := m.reconcileAllocations(); err != nil {
+		t.Fatalf("reconcileAllocations() error = %v", err)
+	}
+
+	// the pods are reconciled in any order, only one can get its VFs
+	held := len(m.GetVFsForPod("edge", "bonded")) + len(m.GetVFsForPod("edge", "router"))
+	if got := len(m.GetVFsForPod("edge", "bonded")); got != 0 && got != 2 {
+		t.Errorf("bonded pod holds %d VFs, want all or none of 2", got)
+	}
+	if got := len(m.GetVFsForPod("edge", "router")); got != 0 && got != 3 {
+		t.Errorf("router pod holds %d VFs, want all or none of 3", got)
+	}
+	if held == 0 {
+		t.Fatalf("no pod got its VFs")
+	}
+
+	// every interface of the pod gets its own VF, a repeated one the same
+	pod := "bonded"
+	if len(m.GetVFsForPod("edge", pod)) == 0 {
+		pod = "router"
+	}
+	if !m.SetAttachment("edge", pod, "/var/run/netns/a", "net1") || !m.SetAttachment("edge", pod, "/var/run/netns/a", "net2") {
+		t.Fatalf("SetAttachment() failed for pod %s", pod)
+	}
+	vfs := m.GetVFsForPod("edge", pod)
+	if vfs[0].PodInterface != "net1" || vfs[1].PodInterface != "net2" {
+		t.Errorf("attachments = %q, %q, want net1 and net2 in PCI order", vfs[0].PodInterface, vfs[1].PodInterface)
+	}
+	if vf, _ := PickVF(vfs, "net2"); vf.PCIAddress != vfs[1].PCIAddress {
+		t.Errorf("PickVF() = %s for net2, want the VF attached as net2", vf.PCIAddress)
+	}
+	if vf, ok := m.GetVFForPod("edge", pod); !ok || vf.PCIAddress != vfs[0].PCIAddress {
+		t.Errorf("GetVFForPod() = %+v, want the VF with the lowest PCI address", vf)
+	}
+
+	if !m.ReleaseVF("edge", pod) || len(m.GetVFsForPod("edge", pod)) != 0 {
+		t.Errorf("ReleaseVF() left VFs allocated to pod %s", pod)
+	}
+}
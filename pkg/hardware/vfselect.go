@@ -0,0 +1,180 @@
+package hardware
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Annotations constraining the VFs a pod requesting SR-IOV gets
+const (
+	// AnnotationSRIOVPF restricts the VFs to PFs, in order of preference
+	// (e.g., "eth2" or "eth2,eth3")
+	AnnotationSRIOVPF = "network.nsm.akosrbn.io/sriov-pf"
+	// AnnotationSRIOVNUMANode restricts the VFs to a NUMA node, the one
+	// the CPUs of the pod are pinned to (e.g., "1"). VFs whose NUMA node
+	// is unknown, as on single-socket nodes, are taken last.
+	AnnotationSRIOVNUMANode = "network.nsm.akosrbn.io/sriov-numa-node"
+	// AnnotationSRIOVDriver restricts the VFs to those bound to a driver
+	// (e.g., "iavf" or "vfio-pci")
+	AnnotationSRIOVDriver = "network.nsm.akosrbn.io/sriov-driver"
+)
+
+// VFSelector is the constraints of a pod on its VFs
+type VFSelector struct {
+	// PFs the VFs may be on, in order of preference, empty for any
+	PFs []string
+	// NUMA node the VFs must be on, -1 for any
+	NUMANode int
+	// Driver the VFs must be bound to, empty for any
+	Driver string
+}
+
+// ParseVFSelector parses the VF constraints from the annotations of a pod
+func ParseVFSelector(annotations map[string]string) (VFSelector, error) {
+	sel := VFSelector{NUMANode: -1}
+	if value, ok := annotations[AnnotationSRIOVPF]; ok {
+		for _, pf := range strings.Split(value, ",") {
+			if pf = strings.TrimSpace(pf); pf != "" {
+				sel.PFs = append(sel.PFs, pf)
+			}
+		}
+		if len(sel.PFs) == 0 {
+			return VFSelector{}, fmt.Errorf("invalid PF constraint %q, must list PF names", value)
+		}
+	}
+	if value, ok := annotations[AnnotationSRIOVNUMANode]; ok {
+		node, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || node < 0 {
+			return VFSelector{}, fmt.Errorf("invalid NUMA node constraint %q, must be a node number", value)
+		}
+		sel.NUMANode = node
+	}
+	if value, ok := annotations[AnnotationSRIOVDriver]; ok {
+		if sel.Driver = strings.TrimSpace(value); sel.Driver == "" {
+			return VFSelector{}, fmt.Errorf("invalid driver constraint %q", value)
+		}
+	}
+	return sel, nil
+}
+
+// String describes the constraints for the allocation decisions
+func (s VFSelector) String() string {
+	var parts []string
+	if len(s.PFs) > 0 {
+		parts = append(parts, "PF "+strings.Join(s.PFs, " or "))
+	}
+	if s.NUMANode >= 0 {
+		parts = append(parts, fmt.Sprintf("NUMA node %d", s.NUMANode))
+	}
+	if s.Driver != "" {
+		parts = append(parts, "driver "+s.Driver)
+	}
+	if len(parts) == 0 {
+		return "any VF"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// pfRank returns the preference of a PF, lower is preferred, -1 if the
+// selector excludes it
+func (s VFSelector) pfRank(pf string) int {
+	if len(s.PFs) == 0 {
+		return 0
+	}
+	for i, name := range s.PFs {
+		if name == pf {
+			return i
+		}
+	}
+	return -1
+}
+
+// mismatch returns why the selector excludes a VF, empty if it doesn't
+func (s VFSelector) mismatch(vf VirtualFunction) string {
+	switch {
+	case s.pfRank(vf.PFName) < 0:
+		return "on other PFs"
+	case s.NUMANode >= 0 && vf.NUMANode >= 0 && vf.NUMANode != s.NUMANode:
+		return "on other NUMA nodes"
+	case s.Driver != "" && vf.Driver != s.Driver:
+		return "bound to other drivers"
+	}
+	return ""
+}
+
+// SelectVFs picks count VFs for a pod from free candidates, ordered by PCI
+// address, and returns their indexes, nil if fewer match the selector.
+// The VFs are picked one by one, scoring the candidates: a VF known to be
+// on the requested NUMA node first, then one on a PF the pod has no VF on
+// yet (held or picked), so bonds span PFs, then the preferred PF, then the
+// lowest PCI address, which keeps the pick predictable.
+func SelectVFs(candidates, held []VirtualFunction, sel VFSelector, count int) []int {
+	var eligible []int
+	for i, vf := range candidates {
+		if sel.mismatch(vf) == "" {
+			eligible = append(eligible, i)
+		}
+	}
+	if len(eligible) < count {
+		return nil
+	}
+
+	used := make(map[string]bool)
+	for _, vf := range held {
+		used[vf.PFName] = true
+	}
+	// better reports whether candidate a scores above candidate b
+	better := func(a, b VirtualFunction) bool {
+		if aNUMA, bNUMA := a.NUMANode == sel.NUMANode, b.NUMANode == sel.NUMANode; sel.NUMANode >= 0 && aNUMA != bNUMA {
+			return aNUMA
+		}
+		if aNew, bNew := !used[a.PFName], !used[b.PFName]; aNew != bNew {
+			return aNew
+		}
+		if aRank, bRank := sel.pfRank(a.PFName), sel.pfRank(b.PFName); aRank != bRank {
+			return aRank < bRank
+		}
+		return a.PCIAddress < b.PCIAddress
+	}
+
+	picked := make([]int, 0, count)
+	for len(picked) < count {
+		best := 0
+		for j := 1; j < len(eligible); j++ {
+			if better(candidates[eligible[j]], candidates[eligible[best]]) {
+				best = j
+			}
+		}
+		i := eligible[best]
+		picked = append(picked, i)
+		used[candidates[i].PFName] = true
+		eligible = append(eligible[:best], eligible[best+1:]...)
+	}
+	return picked
+}
+
+// noMatchingVFMessage describes why the free VFs don't match the
+// constraints of a pod
+func noMatchingVFMessage(free []VirtualFunction, sel VFSelector, missing int) string {
+	excluded := make(map[string]int)
+	matching := 0
+	for _, vf := range free {
+		if reason := sel.mismatch(vf); reason != "" {
+			excluded[reason]++
+		} else {
+			matching++
+		}
+	}
+	reasons := make([]string, 0, len(excluded))
+	for reason, n := range excluded {
+		reasons = append(reasons, fmt.Sprintf("%d %s", n, reason))
+	}
+	sort.Strings(reasons)
+	message := fmt.Sprintf("pod needs %d VFs with %s, %d of %d free VFs match", missing, sel, matching, len(free))
+	if len(reasons) > 0 {
+		message += " (" + strings.Join(reasons, ", ") + ")"
+	}
+	return message
+}
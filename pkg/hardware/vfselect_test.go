@@ -0,0 +1,108 @@
+package hardware
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestParseVFSelector(t *testing.T) {
+	tests := []struct {
+		annotations map[string]string
+		want        VFSelector
+		wantErr     bool
+	}{
+		{annotations: nil, want: VFSelector{NUMANode: -1}},
+		{
+			annotations: map[string]string{AnnotationSRIOVPF: "eth2, eth3", AnnotationSRIOVNUMANode: "1", AnnotationSRIOVDriver: "vfio-pci"},
+			want:        VFSelector{PFs: []string{"eth2", "eth3"}, NUMANode: 1, Driver: "vfio-pci"},
+		},
+		{annotations: map[string]string{AnnotationSRIOVPF: " , "}, wantErr: true},
+		{annotations: map[string]string{AnnotationSRIOVNUMANode: "socket1"}, wantErr: true},
+		{annotations: map[string]string{AnnotationSRIOVNUMANode: "-1"}, wantErr: true},
+		{annotations: map[string]string{AnnotationSRIOVDriver: ""}, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseVFSelector(tt.annotations)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseVFSelector(%v) error = %v, wantErr %v", tt.annotations, err, tt.wantErr)
+			continue
+		}
+		if err == nil && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseVFSelector(%v) = %+v, want %+v", tt.annotations, got, tt.want)
+		}
+	}
+}
+
+func TestSelectVFs(t *testing.T) {
+	// ordered by PCI address, as the allocator passes them
+	free := []VirtualFunction{
+		{PFName: "eth0", VFID: 0, PCIAddress: "0000:3b:02.0", NUMANode: 0, Driver: "iavf"},
+		{PFName: "eth0", VFID: 1, PCIAddress: "0000:3b:02.1", NUMANode: 0, Driver: "vfio-pci"},
+		{PFName: "eth1", VFID: 0, PCIAddress: "0000:3b:0a.0", NUMANode: 0, Driver: "iavf"},
+		{PFName: "eth2", VFID: 0, PCIAddress: "0000:af:02.0", NUMANode: 1, Driver: "iavf"},
+		{PFName: "eth2", VFID: 1, PCIAddress: "0000:af:02.1", NUMANode: 1, Driver: "iavf"},
+		{PFName: "eth3", VFID: 0, PCIAddress: "0000:d8:02.0", NUMANode: -1, Driver: "iavf"},
+	}
+	unconstrained := VFSelector{NUMANode: -1}
+
+	tests := []struct {
+		name  string
+		sel   VFSelector
+		held  []VirtualFunction
+		count int
+		want  []int
+	}{
+		{name: "lowest PCI address without constraints", sel: unconstrained, count: 1, want: []int{0}},
+		{name: "bond spans PFs", sel: unconstrained, count: 2, want: []int{0, 2}},
+		{name: "held VF's PF avoided", sel: unconstrained, held: free[:1], count: 1, want: []int{2}},
+		{name: "PF", sel: VFSelector{PFs: []string{"eth2"}, NUMANode: -1}, count: 2, want: []int{3, 4}},
+		{name: "preferred PF first", sel: VFSelector{PFs: []string{"eth3", "eth0"}, NUMANode: -1}, count: 1, want: []int{5}},
+		{name: "NUMA node before unknown", sel: VFSelector{NUMANode: 1}, count: 3, want: []int{3, 4, 5}},
+		{name: "driver", sel: VFSelector{NUMANode: -1, Driver: "vfio-pci"}, count: 1, want: []int{1}},
+		{name: "too few matching", sel: VFSelector{PFs: []string{"eth1"}, NUMANode: -1}, count: 2, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SelectVFs(free, tt.held, tt.sel, tt.count); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SelectVFs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSRIOVManagerAllocatesMatchingVF(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	pinned, dpdk, typo := sriovPod("pinned"), sriovPod("dpdk"), sriovPod("typo")
+	pinned.Annotations = map[string]string{AnnotationSRIOVNUMANode: "1"}
+	dpdk.Annotations = map[string]string{AnnotationSRIOVPF: "eth0", AnnotationSRIOVDriver: "vfio-pci"}
+	typo.Annotations = map[string]string{AnnotationSRIOVNUMANode: "one"}
+	m := NewSRIOVManager(context.Background(), fake.NewSimpleClientset(pinned, dpdk, typo), logger)
+	m.links = &recordingLinks{}
+	m.vfInventory = map[string]VirtualFunction{
+		"eth0-vf0": {PFName: "eth0", VFID: 0, PCIAddress: "0000:3b:02.0", NUMANode: 0, Driver: "iavf"},
+		"eth1-vf0": {PFName: "eth1", VFID: 0, PCIAddress: "0000:af:02.0", NUMANode: 1, Driver: "iavf"},
+	}
+	if err := m.reconcileAllocations(); err != nil {
+		t.Fatalf("reconcileAllocations() error = %v", err)
+	}
+
+	if vf, ok := m.GetVFForPod("edge", "pinned"); !ok || vf.PFName != "eth1" {
+		t.Errorf("pinned pod got %+v, want the VF on NUMA node 1", vf)
+	}
+	exp, _ := m.Explain("edge", "dpdk")
+	if exp.Allocated || exp.Decisions[0].Reason != ReasonNoMatchingVF ||
+		!strings.Contains(exp.Decisions[0].Message, "1 bound to other drivers") {
+		t.Errorf("dpdk pod decisions = %+v, want no matching VF", exp.Decisions)
+	}
+	exp, _ = m.Explain("edge", "typo")
+	if exp.Allocated || exp.Decisions[0].Reason != ReasonInvalidSelector {
+		t.Errorf("typo pod decisions = %+v, want an invalid selector", exp.Decisions)
+	}
+}
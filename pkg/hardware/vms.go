@@ -0,0 +1,47 @@
+package hardware
+
+import (
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Label KubeVirt sets on the pods running its virtual machines
+const (
+	labelKubeVirt       = "kubevirt.io"
+	labelKubeVirtLaunch = "virt-launcher"
+)
+
+// VirtualMachines lists the virtual machines requesting VFs. Each is
+// returned as a pod carrying its name, labels, annotations and deletion,
+// so the VFs are allocated, constrained and freed the way they are for
+// pods.
+type VirtualMachines interface {
+	RequestingVMs() ([]corev1.Pod, error)
+}
+
+// SetVirtualMachines makes the manager allocate VFs to virtual machines.
+// The pods running them are then left out, they inherit the labels of
+// their machine and come and go with its migrations while the machine
+// keeps its VFs.
+func (m *SRIOVManager) SetVirtualMachines(vms VirtualMachines) {
+	m.vms = vms
+}
+
+// withVirtualMachines replaces the pods running virtual machines with the
+// machines requesting VFs
+func (m *SRIOVManager) withVirtualMachines(pods []corev1.Pod) ([]corev1.Pod, error) {
+	if m.vms == nil {
+		return pods, nil
+	}
+	vms, err := m.vms.RequestingVMs()
+	if err != nil {
+		return nil, err
+	}
+
+	requesting := make([]corev1.Pod, 0, len(pods)+len(vms))
+	for _, pod := range pods {
+		if pod.Labels[labelKubeVirt] != labelKubeVirtLaunch {
+			requesting = append(requesting, pod)
+		}
+	}
+	return append(requesting, vms...), nil
+}
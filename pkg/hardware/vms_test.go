@@ -0,0 +1,65 @@
+package hardware
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// fakeVMs lists fixed virtual machines
+type fakeVMs []corev1.Pod
+
+func (f *fakeVMs) RequestingVMs() ([]corev1.Pod, error) {
+	return *f, nil
+}
+
+func TestSRIOVManagerAllocatesVirtualMachines(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	// the pod running the VM inherits its labels
+	launcher := sriovPod("virt-launcher-router-x7k2p")
+	launcher.Labels[labelKubeVirt] = labelKubeVirtLaunch
+	camera := sriovPod("camera")
+	camera.Annotations = map[string]string{AnnotationSRIOVDriver: "iavf"}
+	m := NewSRIOVManager(context.Background(), fake.NewSimpleClientset(camera, launcher), logger)
+	m.links = &recordingLinks{}
+	m.vfInventory = map[string]VirtualFunction{
+		"eth0-vf0": {PFName: "eth0", VFID: 0, PCIAddress: "0000:3b:02.0", Driver: DriverVFIOPCI},
+		"eth0-vf1": {PFName: "eth0", VFID: 1, PCIAddress: "0000:3b:02.1", Driver: "iavf"},
+		"eth0-vf2": {PFName: "eth0", VFID: 2, PCIAddress: "0000:3b:02.2", Driver: DriverVFIOPCI},
+	}
+	vms := &fakeVMs{{ObjectMeta: metav1.ObjectMeta{
+		Name:        "router",
+		Namespace:   "edge",
+		Annotations: map[string]string{AnnotationSRIOVCount: "2", AnnotationSRIOVDriver: DriverVFIOPCI},
+	}}}
+	m.SetVirtualMachines(vms)
+
+	if err := m.reconcileAllocations(); err != nil {
+		t.Fatalf("reconcileAllocations() error = %v", err)
+	}
+	vfs := m.GetVFsForPod("edge", "router")
+	if len(vfs) != 2 || vfs[0].Driver != DriverVFIOPCI || vfs[1].Driver != DriverVFIOPCI {
+		t.Fatalf("VM holds %+v, want both vfio-pci VFs", vfs)
+	}
+	if vf, ok := m.GetVFForPod("edge", "camera"); !ok || vf.PCIAddress != "0000:3b:02.1" {
+		t.Errorf("camera holds %+v, want the iavf VF", vf)
+	}
+	if _, ok := m.GetVFForPod("edge", launcher.Name); ok {
+		t.Error("allocated a VF to the pod running the VM")
+	}
+
+	// the VFs of a VM that stopped are freed
+	*vms = nil
+	if err := m.reconcileAllocations(); err != nil {
+		t.Fatalf("reconcileAllocations() error = %v", err)
+	}
+	if vfs := m.GetVFsForPod("edge", "router"); len(vfs) != 0 {
+		t.Errorf("stopped VM holds %d VFs", len(vfs))
+	}
+}
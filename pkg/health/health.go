@@ -0,0 +1,197 @@
+// Package health serves the liveness and readiness of the controller on
+// /healthz and /readyz, for the kubelet probes and for operators finding
+// out which subsystem is failing.
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/akos011221/nsm/pkg/api"
+	"github.com/akos011221/nsm/pkg/watchdog"
+	"github.com/sirupsen/logrus"
+)
+
+// Paths of the probes
+const (
+	LivenessPath  = "/healthz"
+	ReadinessPath = "/readyz"
+)
+
+// checkTimeout bounds a readiness check, the kubelet gives up on the probe
+// after a second by default
+const checkTimeout = 800 * time.Millisecond
+
+// Check returns why a component isn't ready, nil if it is
+type Check func(ctx context.Context) error
+
+// Component is the health of a subsystem of the controller
+type Component struct {
+	// Name of the component (e.g., SR-IOV manager)
+	Name string `json:"name"`
+	// Whether the component is healthy
+	Healthy bool `json:"healthy"`
+	// Last time the component made progress or passed its check, nil if
+	// it never did
+	LastSuccess *time.Time `json:"lastSuccess,omitempty"`
+	// Why the component is unhealthy
+	Message string `json:"message,omitempty"`
+}
+
+// Report is the health of the controller
+type Report struct {
+	// Whether every component is healthy
+	Healthy bool `json:"healthy"`
+	// Components, ordered by name
+	Components []Component `json:"components"`
+}
+
+// readinessCheck is a registered readiness check
+type readinessCheck struct {
+	// Check of the component
+	check Check
+	// Last time the check passed
+	lastSuccess *time.Time
+}
+
+// Server serves the probes. The components are alive as long as their
+// heartbeats don't stall, and ready once they are alive and pass their
+// readiness checks.
+type Server struct {
+	// Context for cancellation
+	ctx context.Context
+	// Logger
+	logger *logrus.Logger
+	// Address to listen on
+	listenAddr string
+	// Heartbeats of the components, nil without a watchdog
+	watchdog *watchdog.Watchdog
+
+	// Mutex for protecting the checks
+	mu sync.Mutex
+	// Readiness checks by component name
+	checks map[string]*readinessCheck
+}
+
+// NewServer creates a new probe server
+func NewServer(ctx context.Context, logger *logrus.Logger, listenAddr string) *Server {
+	return &Server{
+		ctx:        ctx,
+		logger:     logger,
+		listenAddr: listenAddr,
+		checks:     make(map[string]*readinessCheck),
+	}
+}
+
+// SetWatchdog makes the liveness follow the heartbeats of the components
+// registered with the watchdog
+func (s *Server) SetWatchdog(w *watchdog.Watchdog) {
+	s.watchdog = w
+}
+
+// AddReadinessCheck adds a check the controller must pass to be ready
+func (s *Server) AddReadinessCheck(name string, check Check) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.checks[name] = &readinessCheck{check: check}
+}
+
+// Liveness reports the heartbeats of the components, a stalled one makes
+// the controller unhealthy
+func (s *Server) Liveness(now time.Time) Report {
+	report := Report{Healthy: true, Components: []Component{}}
+	if s.watchdog == nil {
+		return report
+	}
+	for _, hb := range s.watchdog.Heartbeats(now) {
+		last := hb.LastBeat
+		c := Component{Name: hb.Name, Healthy: !hb.Stalled, LastSuccess: &last}
+		if hb.Stalled {
+			c.Message = fmt.Sprintf("no progress for %s, expected every %s", now.Sub(last).Truncate(time.Second), hb.Interval)
+			report.Healthy = false
+		}
+		report.Components = append(report.Components, c)
+	}
+	return report
+}
+
+// Readiness reports the liveness of the components and runs the readiness
+// checks
+func (s *Server) Readiness(ctx context.Context, now time.Time) Report {
+	report := s.Liveness(now)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for name, rc := range s.checks {
+		checkCtx, cancel := context.WithTimeout(ctx, checkTimeout)
+		err := rc.check(checkCtx)
+		cancel()
+		if err == nil {
+			checked := now
+			rc.lastSuccess = &checked
+		}
+		c := Component{Name: name, Healthy: err == nil, LastSuccess: rc.lastSuccess}
+		if err != nil {
+			c.Message = err.Error()
+			report.Healthy = false
+		}
+		report.Components = append(report.Components, c)
+	}
+	sort.Slice(report.Components, func(i, j int) bool { return report.Components[i].Name < report.Components[j].Name })
+	return report
+}
+
+// Handler returns the HTTP handler of the probes, answering 503 Service
+// Unavailable with the report when the controller is unhealthy
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+LivenessPath, func(w http.ResponseWriter, r *http.Request) {
+		writeReport(w, s.Liveness(time.Now()))
+	})
+	mux.HandleFunc("GET "+ReadinessPath, func(w http.ResponseWriter, r *http.Request) {
+		writeReport(w, s.Readiness(r.Context(), time.Now()))
+	})
+	return mux
+}
+
+// writeReport writes a report with the status code of its health
+func writeReport(w http.ResponseWriter, report Report) {
+	status := http.StatusOK
+	if !report.Healthy {
+		status = http.StatusServiceUnavailable
+	}
+	api.WriteJSON(w, status, report)
+}
+
+// Start serves the probes until the context is done
+func (s *Server) Start() error {
+	s.logger.Infof("Serving health probes on %s", s.listenAddr)
+
+	srv := &http.Server{
+		Addr:              s.listenAddr,
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			errCh <- err
+		}
+	}()
+
+	select {
+	case err := <-errCh:
+		return fmt.Errorf("health probe server failed: %w", err)
+	case <-s.ctx.Done():
+		s.logger.Info("Stopping health probe server")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return srv.Shutdown(shutdownCtx)
+	}
+}
@@ -0,0 +1,99 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/akos011221/nsm/pkg/watchdog"
+	"github.com/sirupsen/logrus"
+)
+
+func quietLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return logger
+}
+
+func TestServer(t *testing.T) {
+	wd := watchdog.NewWatchdog(context.Background(), quietLogger(), 3, false)
+	sriov := wd.Register("SR-IOV manager", nil)
+	sriov.Expect(time.Hour)
+	wd.Register("connection reconciler", nil)
+
+	s := NewServer(context.Background(), quietLogger(), "")
+	s.SetWatchdog(wd)
+	synced := false
+	s.AddReadinessCheck("controller manager", func(ctx context.Context) error {
+		if !synced {
+			return errors.New("caches not synced")
+		}
+		return nil
+	})
+
+	srv := httptest.NewServer(s.Handler())
+	defer srv.Close()
+	get := func(path string) (int, Report) {
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var report Report
+		if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode, report
+	}
+
+	code, report := get(LivenessPath)
+	if code != http.StatusOK || !report.Healthy || len(report.Components) != 2 {
+		t.Errorf("/healthz = %d %+v, want 2 healthy components", code, report)
+	}
+	if report.Components[0].LastSuccess == nil {
+		t.Errorf("no last success for %s", report.Components[0].Name)
+	}
+
+	// alive but not ready until the caches are synced
+	code, report = get(ReadinessPath)
+	if code != http.StatusServiceUnavailable || report.Healthy || len(report.Components) != 3 {
+		t.Fatalf("/readyz = %d %+v, want unready", code, report)
+	}
+	manager := report.Components[2]
+	if manager.Name != "controller manager" || manager.Healthy || manager.LastSuccess != nil || manager.Message != "caches not synced" {
+		t.Errorf("unexpected manager component %+v", manager)
+	}
+
+	synced = true
+	if code, report = get(ReadinessPath); code != http.StatusOK || report.Components[2].LastSuccess == nil {
+		t.Errorf("/readyz = %d %+v, want ready", code, report)
+	}
+}
+
+func TestLivenessStalled(t *testing.T) {
+	wd := watchdog.NewWatchdog(context.Background(), quietLogger(), 3, false)
+	wd.Register("DPDK manager", nil).Expect(time.Second)
+	s := NewServer(context.Background(), quietLogger(), "")
+	s.SetWatchdog(wd)
+
+	report := s.Liveness(time.Now().Add(10 * time.Second))
+	if report.Healthy || report.Components[0].Healthy || report.Components[0].Message == "" {
+		t.Errorf("Liveness() = %+v, want the stalled DPDK manager reported", report)
+	}
+	// a stalled component isn't ready either
+	if report := s.Readiness(context.Background(), time.Now().Add(10*time.Second)); report.Healthy {
+		t.Errorf("Readiness() = %+v, want unready", report)
+	}
+}
+
+func TestWithoutWatchdog(t *testing.T) {
+	s := NewServer(context.Background(), quietLogger(), "")
+	if report := s.Liveness(time.Now()); !report.Healthy || len(report.Components) != 0 {
+		t.Errorf("Liveness() = %+v, want healthy without components", report)
+	}
+}
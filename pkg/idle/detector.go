@@ -0,0 +1,234 @@
+package idle
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/watchdog"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// LabelSRIOV marks the pods requesting a VF
+const LabelSRIOV = "network.nsm.akosrbn.io/sriov"
+
+// Maximum interval the demand is checked at
+const checkInterval = 30 * time.Second
+
+// idleMode is 1 while the node is in the low-power idle mode
+var idleMode = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "nsm_idle_mode",
+	Help: "Whether the node is in the low-power idle mode",
+})
+
+func init() {
+	crmetrics.Registry.MustRegister(idleMode)
+}
+
+// Signal tells whether the node is idle
+type Signal interface {
+	Idle() bool
+}
+
+// Detector puts the node into a low-power idle mode once no pod has
+// requested NSM resources for the idle period, and wakes it up on the
+// first pod asking for them again
+type Detector struct {
+	// Context for cancellation
+	ctx context.Context
+	// Kubernetes client (controller-runtime)
+	client client.Client
+	// Logger
+	logger *logrus.Logger
+	// Time without demand after which the node goes idle
+	idleAfter time.Duration
+	// Whether the node is idle
+	idle bool
+	// Last time pods requested NSM resources
+	lastDemand time.Time
+	// Functions called when the node goes idle or wakes up
+	listeners []func(idle bool)
+	// Triggers an immediate check
+	wake chan struct{}
+	// Mutex for protecting the state
+	mu sync.RWMutex
+	// Progress signal for the watchdog
+	heartbeat *watchdog.Heartbeat
+}
+
+// NewDetector creates a new idle detector
+func NewDetector(ctx context.Context, c client.Client, logger *logrus.Logger, idleAfter time.Duration) *Detector {
+	return &Detector{
+		ctx:        ctx,
+		client:     c,
+		logger:     logger,
+		idleAfter:  idleAfter,
+		lastDemand: time.Now(),
+		wake:       make(chan struct{}, 1),
+	}
+}
+
+// SetHeartbeat makes the detector report its progress to the watchdog
+func (d *Detector) SetHeartbeat(hb *watchdog.Heartbeat) {
+	d.heartbeat = hb
+	hb.Expect(min(d.idleAfter, checkInterval))
+}
+
+// WakeOn checks the demand on every pod and connection event, so the node
+// wakes up as soon as a pod asks for NSM resources
+func (d *Detector) WakeOn(ctx context.Context, informers cache.Informers) error {
+	handler := toolscache.ResourceEventHandlerFuncs{
+		AddFunc:    func(interface{}) { d.Wake() },
+		UpdateFunc: func(interface{}, interface{}) { d.Wake() },
+	}
+	for _, obj := range []client.Object{&corev1.Pod{}, &nsmv1.NetworkConnection{}} {
+		informer, err := informers.GetInformer(ctx, obj)
+		if err != nil {
+			return fmt.Errorf("failed to get informer: %w", err)
+		}
+		if _, err := informer.AddEventHandler(handler); err != nil {
+			return fmt.Errorf("failed to add event handler: %w", err)
+		}
+	}
+	return nil
+}
+
+// Start checks the demand periodically and on wake-ups
+func (d *Detector) Start() error {
+	d.logger.Infof("Starting idle detector (idle after %s)", d.idleAfter)
+
+	ticker := time.NewTicker(min(d.idleAfter, checkInterval))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			d.heartbeat.Beat()
+			if err := d.Check(now); err != nil {
+				d.logger.WithError(err).Warn("Failed to check the demand for NSM resources")
+			}
+
+		case <-d.wake:
+			if err := d.Check(time.Now()); err != nil {
+				d.logger.WithError(err).Warn("Failed to check the demand for NSM resources")
+			}
+
+		case <-d.ctx.Done():
+			d.logger.Info("Stopping idle detector")
+			return nil
+		}
+	}
+}
+
+// Wake triggers an immediate check if the node is idle
+func (d *Detector) Wake() {
+	if !d.Idle() {
+		return
+	}
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Idle reports whether the node is in the idle mode
+func (d *Detector) Idle() bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.idle
+}
+
+// OnChange registers a function called when the node goes idle or wakes up
+func (d *Detector) OnChange(fn func(idle bool)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.listeners = append(d.listeners, fn)
+}
+
+// Check updates the idle state from the current demand
+func (d *Detector) Check(now time.Time) error {
+	demand, err := d.demand()
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	changed := false
+	switch {
+	case demand > 0:
+		d.lastDemand = now
+		if d.idle {
+			d.logger.Infof("%d pods request NSM resources, leaving idle mode", demand)
+			d.idle, changed = false, true
+		}
+	case !d.idle && now.Sub(d.lastDemand) >= d.idleAfter:
+		d.logger.Infof("No pods requested NSM resources for %s, entering idle mode", d.idleAfter)
+		d.idle, changed = true, true
+	}
+	idle := d.idle
+	listeners := append([]func(bool){}, d.listeners...)
+	d.mu.Unlock()
+
+	if idle {
+		idleMode.Set(1)
+	} else {
+		idleMode.Set(0)
+	}
+	if changed {
+		for _, fn := range listeners {
+			fn(idle)
+		}
+	}
+	return nil
+}
+
+// demand counts the running pods requesting NSM resources: pods asking
+// for a VF and the source pods of the connections that are not down
+func (d *Detector) demand() (int, error) {
+	var pods corev1.PodList
+	if err := d.client.List(d.ctx, &pods, client.MatchingLabels{LabelSRIOV: "true"}); err != nil {
+		return 0, fmt.Errorf("failed to list pods requesting SR-IOV: %w", err)
+	}
+	demanding := make(map[client.ObjectKey]bool)
+	for _, pod := range pods.Items {
+		if pod.DeletionTimestamp == nil {
+			demanding[client.ObjectKeyFromObject(&pod)] = true
+		}
+	}
+
+	var conns nsmv1.NetworkConnectionList
+	if err := d.client.List(d.ctx, &conns); err != nil {
+		return 0, fmt.Errorf("failed to list connections: %w", err)
+	}
+	for _, conn := range conns.Items {
+		namespace, name, ok := strings.Cut(conn.Spec.Source, "/")
+		if !ok || conn.Spec.AdminState == nsmv1.AdminStateDown {
+			continue
+		}
+		key := client.ObjectKey{Namespace: namespace, Name: name}
+		if demanding[key] {
+			continue
+		}
+		var pod corev1.Pod
+		if err := d.client.Get(d.ctx, key, &pod); err != nil {
+			if client.IgnoreNotFound(err) != nil {
+				return 0, fmt.Errorf("failed to get pod %s: %w", key, err)
+			}
+			continue
+		}
+		if pod.DeletionTimestamp == nil {
+			demanding[key] = true
+		}
+	}
+
+	return len(demanding), nil
+}
@@ -0,0 +1,116 @@
+package idle
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newTestClient(t *testing.T, objs ...client.Object) client.Client {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatalf("failed to build scheme: %v", err)
+	}
+	if err := nsmv1.AddToScheme(scheme); err != nil {
+		t.Fatalf("failed to build scheme: %v", err)
+	}
+	return fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build()
+}
+
+func testDetector(c client.Client) *Detector {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return NewDetector(context.Background(), c, logger, 10*time.Minute)
+}
+
+func TestDetectorIdleAndWake(t *testing.T) {
+	ctx := context.Background()
+	c := newTestClient(t)
+	d := testDetector(c)
+	var changes []bool
+	d.OnChange(func(idle bool) { changes = append(changes, idle) })
+	start := d.lastDemand
+
+	// no demand, but not for the idle period yet
+	if err := d.Check(start.Add(5 * time.Minute)); err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	if d.Idle() {
+		t.Fatalf("idle before the idle period")
+	}
+	if err := d.Check(start.Add(10 * time.Minute)); err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	if !d.Idle() {
+		t.Fatalf("not idle after the idle period without demand")
+	}
+
+	// a pod requesting a VF wakes the node up
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "camera", Namespace: "edge", Labels: map[string]string{LabelSRIOV: "true"}}}
+	if err := c.Create(ctx, pod); err != nil {
+		t.Fatal(err)
+	}
+	d.Wake()
+	select {
+	case <-d.wake:
+	default:
+		t.Fatalf("pod event did not trigger a check while idle")
+	}
+	if err := d.Check(start.Add(11 * time.Minute)); err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	if d.Idle() {
+		t.Errorf("still idle with a pod requesting a VF")
+	}
+
+	// wake-ups are only checked while idle
+	d.Wake()
+	select {
+	case <-d.wake:
+		t.Errorf("wake-up triggered a check while awake")
+	default:
+	}
+
+	if len(changes) != 2 || !changes[0] || changes[1] {
+		t.Errorf("changes = %v, want idle then awake", changes)
+	}
+}
+
+func TestDetectorConnectionDemand(t *testing.T) {
+	conn := func(name, source, adminState string) *nsmv1.NetworkConnection {
+		return &nsmv1.NetworkConnection{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "edge"},
+			Spec:       nsmv1.NetworkConnectionSpec{Source: source, AdminState: adminState},
+		}
+	}
+	pod := func(name string) *corev1.Pod {
+		return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "edge"}}
+	}
+
+	c := newTestClient(t,
+		pod("gateway"), pod("parked"),
+		conn("uplink", "edge/gateway", ""),
+		conn("backup", "edge/gateway", ""),
+		conn("disabled", "edge/parked", nsmv1.AdminStateDown),
+		conn("orphan", "edge/gone", ""))
+	d := testDetector(c)
+
+	demand, err := d.demand()
+	if err != nil {
+		t.Fatalf("demand() error = %v", err)
+	}
+	if demand != 1 {
+		t.Errorf("demand = %d, want only the source pod of the connections that are up", demand)
+	}
+}
@@ -0,0 +1,219 @@
+package intent
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/external"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// LabelIntent is set on every object generated from an intent
+const LabelIntent = "nsm.akosrbn.io/intent"
+
+// maximum length of generated object names
+const maxNameLength = 63
+
+// Result holds the objects an intent expands into
+type Result struct {
+	// Connections to create, one per selected pod
+	Connections []nsmv1.NetworkConnection
+	// Policies that allow the traffic described by the intent
+	Policies []networkingv1.NetworkPolicy
+}
+
+// PriorityValue maps a priority class to a NetworkConnection priority
+func PriorityValue(priority string) int32 {
+	switch strings.ToLower(priority) {
+	case "high":
+		return 100
+	case "low":
+		return 10
+	default:
+		return 50
+	}
+}
+
+// Compile expands an intent into concrete NetworkConnections, policies and
+// QoS settings for the given service and the pods matching the intent.
+// It is a pure function, so the result only depends on its inputs.
+func Compile(in *nsmv1.NetworkIntent, svc *nsmv1.NetworkService, pods []corev1.Pod) (*Result, error) {
+	if in.Spec.Service == "" {
+		return nil, fmt.Errorf("intent %s/%s has no service", in.Namespace, in.Name)
+	}
+	if svc == nil || svc.Name != in.Spec.Service {
+		return nil, fmt.Errorf("service %s not found for intent %s/%s", in.Spec.Service, in.Namespace, in.Name)
+	}
+
+	direction := strings.ToLower(in.Spec.Direction)
+	if direction == "" {
+		direction = nsmv1.IntentDirectionEgress
+	}
+	if direction != nsmv1.IntentDirectionEgress && direction != nsmv1.IntentDirectionIngress {
+		return nil, fmt.Errorf("invalid intent direction: %s, must be one of: egress, ingress", in.Spec.Direction)
+	}
+
+	selector, err := metav1.LabelSelectorAsSelector(&in.Spec.PodSelector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pod selector: %w", err)
+	}
+
+	// QoS settings: the intent wins, the service provides the defaults
+	priority := in.Spec.Priority
+	if priority == "" {
+		priority = svc.Spec.Priority
+	}
+	latency := in.Spec.MaxLatencyMs
+	if latency == 0 {
+		latency = svc.Spec.LatencyRequirement
+	}
+	bandwidth := in.Spec.Bandwidth
+	if bandwidth == 0 {
+		bandwidth = svc.Spec.Bandwidth
+	}
+
+	result := &Result{}
+
+	// sort to keep the output stable between reconciles
+	sorted := make([]corev1.Pod, 0, len(pods))
+	for _, pod := range pods {
+		if pod.Namespace != in.Namespace || pod.DeletionTimestamp != nil {
+			continue
+		}
+		if !selector.Matches(labels.Set(pod.Labels)) {
+			continue
+		}
+		sorted = append(sorted, pod)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+
+	for _, pod := range sorted {
+		src := fmt.Sprintf("%s/%s", pod.Namespace, pod.Name)
+		dst := svc.Name
+		if direction == nsmv1.IntentDirectionIngress {
+			src, dst = dst, src
+		}
+
+		connLabels := map[string]string{LabelIntent: in.Name}
+		if pod.Spec.NodeName != "" {
+			connLabels[nsmv1.LabelConnectionNode] = pod.Spec.NodeName
+		}
+		result.Connections = append(result.Connections, nsmv1.NetworkConnection{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      ObjectName(in.Name, pod.Name),
+				Namespace: in.Namespace,
+				Labels:    connLabels,
+			},
+			Spec: nsmv1.NetworkConnectionSpec{
+				Source:               src,
+				Destination:          dst,
+				ConnectionType:       connectionType(in, svc),
+				Priority:             PriorityValue(priority),
+				LatencyRequirement:   latency,
+				Bandwidth:            bandwidth,
+				RekeyIntervalSeconds: in.Spec.RekeyIntervalSeconds,
+			},
+		})
+	}
+
+	if policy := buildPolicy(in, svc, direction); policy != nil {
+		result.Policies = append(result.Policies, *policy)
+	}
+
+	return result, nil
+}
+
+// connectionType picks the datapath for the generated connections
+func connectionType(in *nsmv1.NetworkIntent, svc *nsmv1.NetworkService) string {
+	switch {
+	case in.Spec.ConnectionType != "":
+		return in.Spec.ConnectionType
+	case svc.Spec.RequireDPDK:
+		return nsmv1.ConnectionTypeDPDK
+	case svc.Spec.RequireSRIOV:
+		return nsmv1.ConnectionTypeSRIOV
+	default:
+		return nsmv1.ConnectionTypeKernel
+	}
+}
+
+// buildPolicy creates a NetworkPolicy allowing the intent's traffic.
+// Only services with an IP endpoint can be expressed as a policy peer,
+// for the others nil is returned.
+func buildPolicy(in *nsmv1.NetworkIntent, svc *nsmv1.NetworkService, direction string) *networkingv1.NetworkPolicy {
+	host, port := splitEndpoint(external.Endpoint(svc))
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil
+	}
+
+	mask := "/32"
+	if ip.To4() == nil {
+		mask = "/128"
+	}
+	peer := networkingv1.NetworkPolicyPeer{
+		IPBlock: &networkingv1.IPBlock{CIDR: ip.String() + mask},
+	}
+
+	var ports []networkingv1.NetworkPolicyPort
+	if port > 0 {
+		p := intstr.FromInt32(int32(port))
+		ports = append(ports, networkingv1.NetworkPolicyPort{Port: &p})
+	}
+
+	policy := &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      ObjectName(in.Name, "policy"),
+			Namespace: in.Namespace,
+			Labels:    map[string]string{LabelIntent: in.Name},
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: *in.Spec.PodSelector.DeepCopy(),
+		},
+	}
+
+	if direction == nsmv1.IntentDirectionIngress {
+		policy.Spec.PolicyTypes = []networkingv1.PolicyType{networkingv1.PolicyTypeIngress}
+		policy.Spec.Ingress = []networkingv1.NetworkPolicyIngressRule{{From: []networkingv1.NetworkPolicyPeer{peer}}}
+	} else {
+		policy.Spec.PolicyTypes = []networkingv1.PolicyType{networkingv1.PolicyTypeEgress}
+		policy.Spec.Egress = []networkingv1.NetworkPolicyEgressRule{{To: []networkingv1.NetworkPolicyPeer{peer}, Ports: ports}}
+	}
+
+	return policy
+}
+
+// splitEndpoint splits an endpoint into host and port (0 if missing)
+func splitEndpoint(endpoint string) (string, int) {
+	host, portStr, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		return endpoint, 0
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return host, 0
+	}
+	return host, port
+}
+
+// ObjectName joins the parts into a valid object name, hashing it if too long
+func ObjectName(parts ...string) string {
+	name := strings.ToLower(strings.Join(parts, "-"))
+	if len(name) <= maxNameLength {
+		return name
+	}
+
+	sum := sha256.Sum256([]byte(name))
+	suffix := hex.EncodeToString(sum[:])[:8]
+	return strings.TrimRight(name[:maxNameLength-len(suffix)-1], "-.") + "-" + suffix
+}
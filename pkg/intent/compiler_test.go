@@ -0,0 +1,154 @@
+package intent
+
+import (
+	"strings"
+	"testing"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func testIntent() *nsmv1.NetworkIntent {
+	return &nsmv1.NetworkIntent{
+		ObjectMeta: metav1.ObjectMeta{Name: "vision", Namespace: "edge"},
+		Spec: nsmv1.NetworkIntentSpec{
+			PodSelector:  metav1.LabelSelector{MatchLabels: map[string]string{"app": "vision"}},
+			Service:      "camera-feed",
+			MaxLatencyMs: 10,
+		},
+	}
+}
+
+func testService() *nsmv1.NetworkService {
+	return &nsmv1.NetworkService{
+		ObjectMeta: metav1.ObjectMeta{Name: "camera-feed", Namespace: "edge"},
+		Spec: nsmv1.NetworkServiceSpec{
+			ServiceType:  "l3",
+			Endpoint:     "10.0.0.5:8554",
+			Priority:     "high",
+			Bandwidth:    200,
+			RequireSRIOV: true,
+		},
+	}
+}
+
+func testPod(namespace, name string, labels map[string]string) corev1.Pod {
+	return corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: labels}}
+}
+
+func TestCompileEgress(t *testing.T) {
+	pods := []corev1.Pod{
+		testPod("edge", "vision-b", map[string]string{"app": "vision"}),
+		testPod("edge", "vision-a", map[string]string{"app": "vision"}),
+		testPod("edge", "other", map[string]string{"app": "other"}),
+		testPod("default", "vision-c", map[string]string{"app": "vision"}),
+	}
+
+	result, err := Compile(testIntent(), testService(), pods)
+	if err != nil {
+		t.Fatalf("Compile() error = %v", err)
+	}
+
+	if len(result.Connections) != 2 {
+		t.Fatalf("got %d connections, want 2", len(result.Connections))
+	}
+
+	conn := result.Connections[0]
+	if conn.Name != "vision-vision-a" {
+		t.Errorf("connections not sorted, first is %s", conn.Name)
+	}
+	if conn.Spec.Source != "edge/vision-a" || conn.Spec.Destination != "camera-feed" {
+		t.Errorf("unexpected endpoints %s -> %s", conn.Spec.Source, conn.Spec.Destination)
+	}
+	if conn.Spec.ConnectionType != nsmv1.ConnectionTypeSRIOV {
+		t.Errorf("connection type = %s, want sriov", conn.Spec.ConnectionType)
+	}
+	if conn.Spec.Priority != PriorityValue("high") || conn.Spec.LatencyRequirement != 10 || conn.Spec.Bandwidth != 200 {
+		t.Errorf("unexpected QoS settings: %+v", conn.Spec)
+	}
+	if conn.Labels[LabelIntent] != "vision" {
+		t.Errorf("missing intent label")
+	}
+
+	if len(result.Policies) != 1 {
+		t.Fatalf("got %d policies, want 1", len(result.Policies))
+	}
+	policy := result.Policies[0]
+	if len(policy.Spec.Egress) != 1 || policy.Spec.Egress[0].To[0].IPBlock.CIDR != "10.0.0.5/32" {
+		t.Errorf("unexpected egress rule: %+v", policy.Spec.Egress)
+	}
+	if policy.Spec.Egress[0].Ports[0].Port.IntValue() != 8554 {
+		t.Errorf("unexpected egress port: %+v", policy.Spec.Egress[0].Ports)
+	}
+}
+
+func TestCompileIngress(t *testing.T) {
+	in := testIntent()
+	in.Spec.Direction = nsmv1.IntentDirectionIngress
+	in.Spec.Priority = "low"
+	in.Spec.RekeyIntervalSeconds = 600
+
+	pod := testPod("edge", "vision-a", map[string]string{"app": "vision"})
+	pod.Spec.NodeName = "edge-1"
+	result, err := Compile(in, testService(), []corev1.Pod{pod})
+	if err != nil {
+		t.Fatalf("Compile() error = %v", err)
+	}
+
+	// the connection is sharded by the node of the pod, whatever the direction
+	conn := result.Connections[0]
+	if conn.Labels[nsmv1.LabelConnectionNode] != "edge-1" {
+		t.Errorf("node label = %q, want the pod's edge-1", conn.Labels[nsmv1.LabelConnectionNode])
+	}
+	if conn.Spec.RekeyIntervalSeconds != 600 {
+		t.Errorf("rekey interval = %d, want the intent's 600", conn.Spec.RekeyIntervalSeconds)
+	}
+	if conn.Spec.Source != "camera-feed" || conn.Spec.Destination != "edge/vision-a" {
+		t.Errorf("unexpected endpoints %s -> %s", conn.Spec.Source, conn.Spec.Destination)
+	}
+	if conn.Spec.Priority != PriorityValue("low") {
+		t.Errorf("intent priority did not override service priority")
+	}
+	if got := result.Policies[0].Spec.PolicyTypes; len(got) != 1 || got[0] != networkingv1.PolicyTypeIngress {
+		t.Errorf("policy types = %v, want ingress", got)
+	}
+}
+
+func TestCompileNonIPEndpointHasNoPolicy(t *testing.T) {
+	svc := testService()
+	svc.Spec.Endpoint = "camera.example.com:8554"
+
+	result, err := Compile(testIntent(), svc, nil)
+	if err != nil {
+		t.Fatalf("Compile() error = %v", err)
+	}
+	if len(result.Policies) != 0 {
+		t.Errorf("expected no policy for a DNS endpoint")
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	in := testIntent()
+	in.Spec.Direction = "sideways"
+	if _, err := Compile(in, testService(), nil); err == nil {
+		t.Errorf("expected error for invalid direction")
+	}
+
+	other := testService()
+	other.Name = "other"
+	if _, err := Compile(testIntent(), other, nil); err == nil {
+		t.Errorf("expected error for mismatched service")
+	}
+}
+
+func TestObjectNameTruncation(t *testing.T) {
+	name := ObjectName(strings.Repeat("a", 50), strings.Repeat("b", 50))
+	if len(name) > maxNameLength {
+		t.Errorf("name too long: %d", len(name))
+	}
+	if name != ObjectName(strings.Repeat("a", 50), strings.Repeat("b", 50)) {
+		t.Errorf("name is not stable")
+	}
+}
@@ -0,0 +1,183 @@
+package inventorystream
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	inventoryv1 "github.com/akos011221/nsm/api/grpc/inventory/v1"
+	"github.com/akos011221/nsm/pkg/hardware"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Inventory lists the SR-IOV functions of the node
+type Inventory interface {
+	PhysicalFunctions() []hardware.PhysicalFunction
+	VirtualFunctions() []hardware.VirtualFunction
+}
+
+// Server streams the changes of the SR-IOV inventory over gRPC, so UIs and
+// external allocators can mirror it without polling the full list. Each
+// watch diffs the inventory against what it last sent.
+type Server struct {
+	inventoryv1.UnimplementedInventoryServer
+
+	// Context for cancellation
+	ctx context.Context
+	// Logger
+	logger *logrus.Logger
+	// SR-IOV inventory of the node
+	inventory Inventory
+	// Interval the inventory is checked for changes at
+	pollInterval time.Duration
+}
+
+// NewServer creates a new inventory streaming server
+func NewServer(ctx context.Context, logger *logrus.Logger, inventory Inventory) *Server {
+	return &Server{
+		ctx:          ctx,
+		logger:       logger,
+		inventory:    inventory,
+		pollInterval: 500 * time.Millisecond,
+	}
+}
+
+// WatchInventory sends the current functions as added and a synced event,
+// then an event whenever a function is added, updated or deleted
+func (s *Server) WatchInventory(req *inventoryv1.WatchInventoryRequest, stream inventoryv1.Inventory_WatchInventoryServer) error {
+	w := &watch{
+		stream: stream,
+		pfName: req.GetPfName(),
+		sent:   make(map[string]*inventoryv1.InventoryEvent),
+	}
+
+	current := s.snapshot(w.pfName)
+	if req.GetSkipInitial() {
+		w.sent = current
+	} else if err := w.send(current); err != nil {
+		return err
+	}
+	if err := stream.Send(&inventoryv1.InventoryEvent{Type: inventoryv1.EventType_EVENT_TYPE_SYNCED, Time: timestamppb.Now()}); err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(s.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := w.send(s.snapshot(w.pfName)); err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return nil
+		case <-s.ctx.Done():
+			return status.Error(codes.Unavailable, "server shutting down")
+		}
+	}
+}
+
+// snapshot converts the functions of the PF (all if empty), by key
+func (s *Server) snapshot(pfName string) map[string]*inventoryv1.InventoryEvent {
+	functions := make(map[string]*inventoryv1.InventoryEvent)
+	for _, pf := range s.inventory.PhysicalFunctions() {
+		if pfName != "" && pf.Name != pfName {
+			continue
+		}
+		functions["pf/"+pf.Name] = &inventoryv1.InventoryEvent{Function: &inventoryv1.InventoryEvent_Pf{Pf: &inventoryv1.PhysicalFunction{
+			Name:       pf.Name,
+			PciAddress: pf.PCIAddress,
+			NumVfs:     int32(pf.NumVFs),
+		}}}
+	}
+	for _, vf := range s.inventory.VirtualFunctions() {
+		if pfName != "" && vf.PFName != pfName {
+			continue
+		}
+		functions[fmt.Sprintf("vf/%s/%d", vf.PFName, vf.VFID)] = &inventoryv1.InventoryEvent{Function: &inventoryv1.InventoryEvent_Vf{Vf: VirtualFunction(vf)}}
+	}
+	return functions
+}
+
+// VirtualFunction converts a VF of the inventory to its API message
+func VirtualFunction(vf hardware.VirtualFunction) *inventoryv1.VirtualFunction {
+	return &inventoryv1.VirtualFunction{
+		PfName:        vf.PFName,
+		VfId:          int32(vf.VFID),
+		PciAddress:    vf.PCIAddress,
+		InterfaceName: vf.InterfaceName,
+		Allocated:     vf.Allocated,
+		Namespace:     vf.Namespace,
+		Pod:           vf.AllocatedTo,
+	}
+}
+
+// watch is the state of an inventory stream
+type watch struct {
+	// Stream the events are sent on
+	stream inventoryv1.Inventory_WatchInventoryServer
+	// Name of the watched PF, empty for all
+	pfName string
+	// Functions as last sent, by key
+	sent map[string]*inventoryv1.InventoryEvent
+}
+
+// send sends the changes between the functions last sent and the current
+// ones. PFs sort before their VFs, so mirrors see a PF added before its VFs
+// and, as deletions are sent in reverse, its VFs deleted before it.
+func (w *watch) send(current map[string]*inventoryv1.InventoryEvent) error {
+	keys := make([]string, 0, len(current))
+	for key := range current {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var deleted []string
+	for key := range w.sent {
+		if _, ok := current[key]; !ok {
+			deleted = append(deleted, key)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(deleted)))
+
+	now := timestamppb.Now()
+	for _, key := range deleted {
+		event := proto.Clone(w.sent[key]).(*inventoryv1.InventoryEvent)
+		event.Type, event.Time = inventoryv1.EventType_EVENT_TYPE_DELETED, now
+		if err := w.stream.Send(event); err != nil {
+			return err
+		}
+		delete(w.sent, key)
+	}
+	for _, key := range keys {
+		event := current[key]
+		last, ok := w.sent[key]
+		switch {
+		case !ok:
+			event.Type = inventoryv1.EventType_EVENT_TYPE_ADDED
+		case !proto.Equal(function(last), function(event)):
+			event.Type = inventoryv1.EventType_EVENT_TYPE_UPDATED
+		default:
+			continue
+		}
+		event.Time = now
+		if err := w.stream.Send(event); err != nil {
+			return err
+		}
+		w.sent[key] = event
+	}
+	return nil
+}
+
+// function returns the PF or VF of an event
+func function(event *inventoryv1.InventoryEvent) proto.Message {
+	if pf := event.GetPf(); pf != nil {
+		return pf
+	}
+	return event.GetVf()
+}
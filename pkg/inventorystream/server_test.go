@@ -0,0 +1,154 @@
+package inventorystream
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	inventoryv1 "github.com/akos011221/nsm/api/grpc/inventory/v1"
+	"github.com/akos011221/nsm/pkg/hardware"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// fakeInventory is an inventory the tests change
+type fakeInventory struct {
+	mu  sync.Mutex
+	pfs []hardware.PhysicalFunction
+	vfs []hardware.VirtualFunction
+}
+
+func (f *fakeInventory) PhysicalFunctions() []hardware.PhysicalFunction {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]hardware.PhysicalFunction(nil), f.pfs...)
+}
+
+func (f *fakeInventory) VirtualFunctions() []hardware.VirtualFunction {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]hardware.VirtualFunction(nil), f.vfs...)
+}
+
+func (f *fakeInventory) set(pfs []hardware.PhysicalFunction, vfs []hardware.VirtualFunction) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.pfs, f.vfs = pfs, vfs
+}
+
+// startServer serves the API over an in-memory listener and returns a client
+func startServer(t *testing.T, inventory Inventory) inventoryv1.InventoryClient {
+	t.Helper()
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	ctx, cancel := context.WithCancel(context.Background())
+	s := NewServer(ctx, logger, inventory)
+	s.pollInterval = 10 * time.Millisecond
+
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer()
+	inventoryv1.RegisterInventoryServer(srv, s)
+	go srv.Serve(lis)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		cancel()
+		srv.Stop()
+	})
+	return inventoryv1.NewInventoryClient(conn)
+}
+
+// describe summarizes an event as type and function
+func describe(event *inventoryv1.InventoryEvent) string {
+	switch {
+	case event.GetPf() != nil:
+		return event.GetType().String() + " pf " + event.GetPf().GetName()
+	case event.GetVf() != nil:
+		desc := event.GetType().String() + " vf " + event.GetVf().GetPciAddress()
+		if event.GetVf().GetAllocated() {
+			desc += " " + event.GetVf().GetNamespace() + "/" + event.GetVf().GetPod()
+		}
+		return desc
+	}
+	return event.GetType().String()
+}
+
+func receive(t *testing.T, stream inventoryv1.Inventory_WatchInventoryClient, want ...string) {
+	t.Helper()
+	for _, w := range want {
+		event, err := stream.Recv()
+		if err != nil {
+			t.Fatalf("Recv() error = %v", err)
+		}
+		if got := describe(event); got != w {
+			t.Fatalf("got event %q, want %q", got, w)
+		}
+	}
+}
+
+func TestWatchInventory(t *testing.T) {
+	inventory := &fakeInventory{}
+	pfs := []hardware.PhysicalFunction{{Name: "eth0", PCIAddress: "0000:3b:00.0", NumVFs: 2}}
+	vfs := []hardware.VirtualFunction{
+		{PFName: "eth0", VFID: 0, PCIAddress: "0000:3b:02.0"},
+		{PFName: "eth0", VFID: 1, PCIAddress: "0000:3b:02.1"},
+	}
+	inventory.set(pfs, vfs)
+	client := startServer(t, inventory)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := client.WatchInventory(ctx, &inventoryv1.WatchInventoryRequest{})
+	if err != nil {
+		t.Fatalf("WatchInventory() error = %v", err)
+	}
+	receive(t, stream,
+		"EVENT_TYPE_ADDED pf eth0",
+		"EVENT_TYPE_ADDED vf 0000:3b:02.0",
+		"EVENT_TYPE_ADDED vf 0000:3b:02.1",
+		"EVENT_TYPE_SYNCED",
+	)
+
+	// an allocation updates the VF
+	allocated := []hardware.VirtualFunction{vfs[0], vfs[1]}
+	allocated[1].Allocated, allocated[1].AllocatedTo, allocated[1].Namespace = true, "camera", "edge"
+	inventory.set(pfs, allocated)
+	receive(t, stream, "EVENT_TYPE_UPDATED vf 0000:3b:02.1 edge/camera")
+
+	// the VFs of a removed PF are deleted before it
+	inventory.set(nil, nil)
+	receive(t, stream,
+		"EVENT_TYPE_DELETED vf 0000:3b:02.1 edge/camera",
+		"EVENT_TYPE_DELETED vf 0000:3b:02.0",
+		"EVENT_TYPE_DELETED pf eth0",
+	)
+}
+
+func TestWatchInventorySkipInitial(t *testing.T) {
+	inventory := &fakeInventory{}
+	inventory.set([]hardware.PhysicalFunction{{Name: "eth0", NumVFs: 1}, {Name: "eth1", NumVFs: 1}}, nil)
+	client := startServer(t, inventory)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := client.WatchInventory(ctx, &inventoryv1.WatchInventoryRequest{PfName: "eth1", SkipInitial: true})
+	if err != nil {
+		t.Fatalf("WatchInventory() error = %v", err)
+	}
+	receive(t, stream, "EVENT_TYPE_SYNCED")
+
+	// changes of other PFs are filtered out
+	inventory.set([]hardware.PhysicalFunction{{Name: "eth0", NumVFs: 4}, {Name: "eth1", NumVFs: 2}}, nil)
+	receive(t, stream, "EVENT_TYPE_UPDATED pf eth1")
+}
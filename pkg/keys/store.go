@@ -0,0 +1,219 @@
+package keys
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strings"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// Data key holding the tunnel key in a key Secret
+const DataKey = "privateKey"
+
+// LabelConnection marks the key Secret of a connection
+const LabelConnection = "nsm.akosrbn.io/connection"
+
+// ErrNoKey is returned when a connection has no usable key Secret
+var ErrNoKey = errors.New("no tunnel key")
+
+// Size of the tunnel keys in bytes (Curve25519 for WireGuard, AES-256-GCM for IPsec)
+const keySize = 32
+
+// Store keeps the tunnel key of each encrypted connection in a Secret in
+// the namespace of the connection. Kubernetes RBAC is namespace-scoped, so
+// a tenant allowed to read Secrets in its own namespace can't read the keys
+// of another tenant's connections, which a single controller-global Secret
+// couldn't guarantee. The Secrets are owned by their connection and
+// garbage collected with it.
+type Store struct {
+	// Kubernetes client (controller-runtime)
+	client client.Client
+	// Logger
+	logger *logrus.Logger
+	// Controller-global Secret older versions kept all keys in, with data
+	// keys "<namespace>.<connection>", empty name if there is none
+	legacy types.NamespacedName
+}
+
+// NewStore creates a new key store migrating the keys out of the legacy
+// Secret, if given
+func NewStore(c client.Client, logger *logrus.Logger, legacy types.NamespacedName) *Store {
+	return &Store{
+		client: c,
+		logger: logger,
+		legacy: legacy,
+	}
+}
+
+// SecretName returns the name of the key Secret of a connection
+func SecretName(conn *nsmv1.NetworkConnection) string {
+	return "nsm-key-" + conn.Name
+}
+
+// Ensure returns the key of the connection, creating it (or taking it over
+// from the legacy Secret) if it doesn't exist yet
+func (s *Store) Ensure(ctx context.Context, conn *nsmv1.NetworkConnection) ([]byte, error) {
+	var secret corev1.Secret
+	err := s.client.Get(ctx, types.NamespacedName{Namespace: conn.Namespace, Name: SecretName(conn)}, &secret)
+	if err == nil && len(secret.Data[DataKey]) > 0 {
+		return secret.Data[DataKey], nil
+	}
+	if err != nil && !apierrors.IsNotFound(err) {
+		return nil, fmt.Errorf("failed to get key of connection %s/%s: %w", conn.Namespace, conn.Name, err)
+	}
+
+	key, err := s.legacyKey(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		if key, err = generateKey(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.store(ctx, conn, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// Key returns the key of the connection from the Secret named in its
+// status, without creating one. It fails with ErrNoKey when the Secret
+// doesn't exist or holds no key.
+func (s *Store) Key(ctx context.Context, conn *nsmv1.NetworkConnection) ([]byte, error) {
+	name := conn.Status.KeySecret
+	if name == "" {
+		name = SecretName(conn)
+	}
+	var secret corev1.Secret
+	if err := s.client.Get(ctx, types.NamespacedName{Namespace: conn.Namespace, Name: name}, &secret); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("%w: Secret %s/%s of connection %s not found", ErrNoKey, conn.Namespace, name, conn.Name)
+		}
+		return nil, fmt.Errorf("failed to get key of connection %s/%s: %w", conn.Namespace, conn.Name, err)
+	}
+	key := secret.Data[DataKey]
+	if len(key) == 0 {
+		return nil, fmt.Errorf("%w: Secret %s/%s has no %s", ErrNoKey, conn.Namespace, name, DataKey)
+	}
+	return key, nil
+}
+
+// Rekey replaces the key of the connection with a fresh one
+func (s *Store) Rekey(ctx context.Context, conn *nsmv1.NetworkConnection) error {
+	key, err := generateKey()
+	if err != nil {
+		return err
+	}
+	return s.store(ctx, conn, key)
+}
+
+// Migrate moves all keys of existing connections out of the legacy Secret
+// into per-namespace Secrets, and deletes the legacy Secret once it holds
+// no more keys. Keys of connections that no longer exist are dropped.
+func (s *Store) Migrate(ctx context.Context) (int, error) {
+	if s.legacy.Name == "" {
+		return 0, nil
+	}
+
+	var legacy corev1.Secret
+	if err := s.client.Get(ctx, s.legacy, &legacy); err != nil {
+		return 0, client.IgnoreNotFound(err)
+	}
+
+	migrated := 0
+	for entry, key := range legacy.Data {
+		namespace, name, ok := strings.Cut(entry, ".")
+		if !ok {
+			s.logger.Warnf("Ignoring malformed entry %q in legacy key Secret %s", entry, s.legacy)
+			continue
+		}
+
+		var conn nsmv1.NetworkConnection
+		err := s.client.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, &conn)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return migrated, fmt.Errorf("failed to get connection %s/%s: %w", namespace, name, err)
+		}
+		if err == nil {
+			if err := s.store(ctx, &conn, key); err != nil {
+				return migrated, err
+			}
+			migrated++
+		}
+		delete(legacy.Data, entry)
+	}
+
+	if len(legacy.Data) == 0 {
+		if err := s.client.Delete(ctx, &legacy); err != nil && !apierrors.IsNotFound(err) {
+			return migrated, fmt.Errorf("failed to delete legacy key Secret %s: %w", s.legacy, err)
+		}
+		s.logger.Infof("Migrated %d keys out of legacy key Secret %s", migrated, s.legacy)
+		return migrated, nil
+	}
+	if err := s.client.Update(ctx, &legacy); err != nil {
+		return migrated, fmt.Errorf("failed to update legacy key Secret %s: %w", s.legacy, err)
+	}
+	return migrated, nil
+}
+
+// legacyKey returns the key of a connection from the legacy Secret, nil if
+// it has none
+func (s *Store) legacyKey(ctx context.Context, conn *nsmv1.NetworkConnection) ([]byte, error) {
+	if s.legacy.Name == "" {
+		return nil, nil
+	}
+
+	var legacy corev1.Secret
+	if err := s.client.Get(ctx, s.legacy, &legacy); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get legacy key Secret %s: %w", s.legacy, err)
+	}
+
+	key := legacy.Data[conn.Namespace+"."+conn.Name]
+	if key != nil {
+		s.logger.Infof("Taking over key of connection %s/%s from legacy key Secret %s", conn.Namespace, conn.Name, s.legacy)
+	}
+	return key, nil
+}
+
+// store writes the key Secret of a connection
+func (s *Store) store(ctx context.Context, conn *nsmv1.NetworkConnection, key []byte) error {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: SecretName(conn), Namespace: conn.Namespace},
+	}
+	_, err := controllerutil.CreateOrUpdate(ctx, s.client, secret, func() error {
+		if secret.Labels == nil {
+			secret.Labels = make(map[string]string)
+		}
+		secret.Labels[LabelConnection] = conn.Name
+		secret.Type = corev1.SecretTypeOpaque
+		secret.Data = map[string][]byte{DataKey: key}
+		return controllerutil.SetControllerReference(conn, secret, s.client.Scheme())
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store key of connection %s/%s: %w", conn.Namespace, conn.Name, err)
+	}
+	return nil
+}
+
+// generateKey returns a fresh random key
+func generateKey() ([]byte, error) {
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	return key, nil
+}
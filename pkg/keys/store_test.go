@@ -0,0 +1,182 @@
+package keys
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func quietLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return logger
+}
+
+func newTestClient(t *testing.T, objs ...client.Object) client.Client {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatalf("failed to build scheme: %v", err)
+	}
+	if err := nsmv1.AddToScheme(scheme); err != nil {
+		t.Fatalf("failed to build scheme: %v", err)
+	}
+	return fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build()
+}
+
+func testConnection(namespace, name string) *nsmv1.NetworkConnection {
+	return &nsmv1.NetworkConnection{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, UID: types.UID(namespace + "-" + name)},
+		Spec:       nsmv1.NetworkConnectionSpec{ConnectionType: nsmv1.ConnectionTypeWireGuard},
+	}
+}
+
+func getSecret(t *testing.T, c client.Client, namespace, name string) *corev1.Secret {
+	t.Helper()
+	var secret corev1.Secret
+	if err := c.Get(context.Background(), types.NamespacedName{Namespace: namespace, Name: name}, &secret); err != nil {
+		t.Fatalf("failed to get secret %s/%s: %v", namespace, name, err)
+	}
+	return &secret
+}
+
+func TestEnsureStoresKeyInConnectionNamespace(t *testing.T) {
+	tenantA, tenantB := testConnection("tenant-a", "conn"), testConnection("tenant-b", "conn")
+	c := newTestClient(t, tenantA, tenantB)
+	s := NewStore(c, quietLogger(), types.NamespacedName{})
+	ctx := context.Background()
+
+	keyA, err := s.Ensure(ctx, tenantA)
+	if err != nil || len(keyA) != keySize {
+		t.Fatalf("Ensure() = %d bytes, error %v", len(keyA), err)
+	}
+	keyB, err := s.Ensure(ctx, tenantB)
+	if err != nil {
+		t.Fatalf("Ensure() error = %v", err)
+	}
+	if bytes.Equal(keyA, keyB) {
+		t.Errorf("connections of different tenants share a key")
+	}
+
+	secret := getSecret(t, c, "tenant-a", "nsm-key-conn")
+	if !bytes.Equal(secret.Data[DataKey], keyA) || secret.Labels[LabelConnection] != "conn" {
+		t.Errorf("unexpected key secret %+v", secret)
+	}
+	if owners := secret.OwnerReferences; len(owners) != 1 || owners[0].UID != tenantA.UID {
+		t.Errorf("key secret not owned by its connection: %+v", owners)
+	}
+
+	again, err := s.Ensure(ctx, tenantA)
+	if err != nil || !bytes.Equal(again, keyA) {
+		t.Errorf("Ensure() generated a new key for an existing connection")
+	}
+
+	if err := s.Rekey(ctx, tenantA); err != nil {
+		t.Fatalf("Rekey() error = %v", err)
+	}
+	if rekeyed := getSecret(t, c, "tenant-a", "nsm-key-conn").Data[DataKey]; bytes.Equal(rekeyed, keyA) || len(rekeyed) != keySize {
+		t.Errorf("Rekey() didn't replace the key")
+	}
+}
+
+func TestEnsureTakesOverLegacyKey(t *testing.T) {
+	legacyKey := bytes.Repeat([]byte{7}, keySize)
+	legacy := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "nsm-keys", Namespace: "nsm-system"},
+		Data:       map[string][]byte{"tenant-a.conn": legacyKey},
+	}
+	conn := testConnection("tenant-a", "conn")
+	c := newTestClient(t, legacy, conn)
+	s := NewStore(c, quietLogger(), types.NamespacedName{Namespace: "nsm-system", Name: "nsm-keys"})
+
+	key, err := s.Ensure(context.Background(), conn)
+	if err != nil {
+		t.Fatalf("Ensure() error = %v", err)
+	}
+	if !bytes.Equal(key, legacyKey) {
+		t.Errorf("connection got a new key instead of its legacy one, tunnel peers would mismatch")
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	legacy := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "nsm-keys", Namespace: "nsm-system"},
+		Data: map[string][]byte{
+			"tenant-a.conn.v2": bytes.Repeat([]byte{1}, keySize),
+			"tenant-b.conn":    bytes.Repeat([]byte{2}, keySize),
+			"tenant-b.deleted": bytes.Repeat([]byte{3}, keySize),
+		},
+	}
+	c := newTestClient(t, legacy, testConnection("tenant-a", "conn.v2"), testConnection("tenant-b", "conn"))
+	s := NewStore(c, quietLogger(), types.NamespacedName{Namespace: "nsm-system", Name: "nsm-keys"})
+
+	migrated, err := s.Migrate(context.Background())
+	if err != nil {
+		t.Fatalf("Migrate() error = %v", err)
+	}
+	if migrated != 2 {
+		t.Errorf("migrated %d keys, want 2", migrated)
+	}
+	if key := getSecret(t, c, "tenant-a", "nsm-key-conn.v2").Data[DataKey]; !bytes.Equal(key, legacy.Data["tenant-a.conn.v2"]) {
+		t.Errorf("tenant-a key not migrated")
+	}
+	if key := getSecret(t, c, "tenant-b", "nsm-key-conn").Data[DataKey]; !bytes.Equal(key, legacy.Data["tenant-b.conn"]) {
+		t.Errorf("tenant-b key not migrated")
+	}
+
+	err = c.Get(context.Background(), types.NamespacedName{Namespace: "nsm-system", Name: "nsm-keys"}, &corev1.Secret{})
+	if !apierrors.IsNotFound(err) {
+		t.Errorf("legacy secret not deleted after migration: %v", err)
+	}
+
+	// nothing left to do on the next start
+	if migrated, err := s.Migrate(context.Background()); err != nil || migrated != 0 {
+		t.Errorf("second Migrate() = %d, %v", migrated, err)
+	}
+}
+
+func TestKeyReadsConnectionSecret(t *testing.T) {
+	conn := testConnection("tenant-a", "conn")
+	c := newTestClient(t, conn)
+	s := NewStore(c, quietLogger(), types.NamespacedName{})
+	ctx := context.Background()
+
+	// nothing is created for a connection without a key
+	if _, err := s.Key(ctx, conn); !errors.Is(err, ErrNoKey) {
+		t.Fatalf("Key() error = %v, want ErrNoKey", err)
+	}
+	var secrets corev1.SecretList
+	if err := c.List(ctx, &secrets); err != nil || len(secrets.Items) != 0 {
+		t.Fatalf("Key() created secrets %v (%v)", secrets.Items, err)
+	}
+
+	ensured, err := s.Ensure(ctx, conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Status.KeySecret = SecretName(conn)
+	if key, err := s.Key(ctx, conn); err != nil || !bytes.Equal(key, ensured) {
+		t.Errorf("Key() = %x, %v, want the ensured key %x", key, err, ensured)
+	}
+
+	// after a rekey the new key is read
+	if err := s.Rekey(ctx, conn); err != nil {
+		t.Fatal(err)
+	}
+	if key, err := s.Key(ctx, conn); err != nil || bytes.Equal(key, ensured) {
+		t.Errorf("Key() = %x, %v, want the new key", key, err)
+	}
+}
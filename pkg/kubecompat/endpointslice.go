@@ -0,0 +1,64 @@
+package kubecompat
+
+import (
+	discoveryv1 "k8s.io/api/discovery/v1"
+	discoveryv1beta1 "k8s.io/api/discovery/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// NewEndpointSlice returns an empty EndpointSlice of the version the
+// cluster serves, to get, watch or write it with
+func (c *Capabilities) NewEndpointSlice() client.Object {
+	if c.EndpointSlice == V1Beta1 {
+		return &discoveryv1beta1.EndpointSlice{}
+	}
+	return &discoveryv1.EndpointSlice{}
+}
+
+// AddressType returns the address type of an EndpointSlice of either version
+func AddressType(obj client.Object) string {
+	switch slice := obj.(type) {
+	case *discoveryv1.EndpointSlice:
+		return string(slice.AddressType)
+	case *discoveryv1beta1.EndpointSlice:
+		return string(slice.AddressType)
+	}
+	return ""
+}
+
+// SetEndpointSlice sets the address type, endpoints and ports of an
+// EndpointSlice of either version to those of a v1 one
+func SetEndpointSlice(obj client.Object, from *discoveryv1.EndpointSlice) {
+	switch slice := obj.(type) {
+	case *discoveryv1.EndpointSlice:
+		slice.AddressType = from.AddressType
+		slice.Endpoints = from.Endpoints
+		slice.Ports = from.Ports
+
+	case *discoveryv1beta1.EndpointSlice:
+		slice.AddressType = discoveryv1beta1.AddressType(from.AddressType)
+		slice.Endpoints = make([]discoveryv1beta1.Endpoint, 0, len(from.Endpoints))
+		for _, ep := range from.Endpoints {
+			slice.Endpoints = append(slice.Endpoints, discoveryv1beta1.Endpoint{
+				Addresses: ep.Addresses,
+				Conditions: discoveryv1beta1.EndpointConditions{
+					Ready:       ep.Conditions.Ready,
+					Serving:     ep.Conditions.Serving,
+					Terminating: ep.Conditions.Terminating,
+				},
+				Hostname:  ep.Hostname,
+				TargetRef: ep.TargetRef,
+				NodeName:  ep.NodeName,
+			})
+		}
+		slice.Ports = make([]discoveryv1beta1.EndpointPort, 0, len(from.Ports))
+		for _, port := range from.Ports {
+			slice.Ports = append(slice.Ports, discoveryv1beta1.EndpointPort{
+				Name:        port.Name,
+				Protocol:    port.Protocol,
+				Port:        port.Port,
+				AppProtocol: port.AppProtocol,
+			})
+		}
+	}
+}
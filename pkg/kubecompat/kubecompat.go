@@ -0,0 +1,110 @@
+// Package kubecompat detects the API versions the Kubernetes cluster
+// serves and adapts the API usages of NSM to them, so a single binary runs
+// across the Kubernetes versions found in an edge fleet, 1.19 and newer.
+package kubecompat
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/discovery"
+)
+
+// API versions
+const (
+	// V1 is the GA version of an API
+	V1 = "v1"
+	// V1Beta1 is the beta version of an API, served by older clusters
+	V1Beta1 = "v1beta1"
+)
+
+// MinMinor is the oldest Kubernetes 1.x minor version supported, the first
+// serving EndpointSlices by default
+const MinMinor = 19
+
+// Capabilities are the versions of the APIs NSM uses that differ across
+// the supported Kubernetes versions
+type Capabilities struct {
+	// Version of the API server (e.g., v1.24.3)
+	ServerVersion string `json:"serverVersion"`
+	// Version of the EndpointSlice API (discovery.k8s.io), empty if not
+	// served
+	EndpointSlice string `json:"endpointSlice"`
+	// Version of the PodDisruptionBudget API (policy), v1 since Kubernetes
+	// 1.21
+	PodDisruptionBudget string `json:"podDisruptionBudget"`
+	// Version of the Eviction API (policy), v1 since Kubernetes 1.22
+	Eviction string `json:"eviction"`
+}
+
+// Latest returns the capabilities of the current Kubernetes versions, for
+// clusters that weren't probed
+func Latest() *Capabilities {
+	return &Capabilities{EndpointSlice: V1, PodDisruptionBudget: V1, Eviction: V1}
+}
+
+// Detect probes the API server for its version and the API versions it
+// serves. Clusters older than the oldest supported version are refused.
+func Detect(d discovery.DiscoveryInterface) (*Capabilities, error) {
+	info, err := d.ServerVersion()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the Kubernetes version: %w", err)
+	}
+	caps := &Capabilities{ServerVersion: info.GitVersion}
+	// distributions append to the minor version (e.g., 24+)
+	if minor, err := strconv.Atoi(strings.TrimRight(info.Minor, "+")); err == nil && info.Major == "1" && minor < MinMinor {
+		return nil, fmt.Errorf("Kubernetes %s is older than the oldest supported 1.%d", info.GitVersion, MinMinor)
+	}
+
+	for _, version := range []string{V1, V1Beta1} {
+		served, err := serves(d, "discovery.k8s.io/"+version, "endpointslices")
+		if err != nil {
+			return nil, err
+		}
+		if served {
+			caps.EndpointSlice = version
+			break
+		}
+	}
+
+	caps.PodDisruptionBudget = V1Beta1
+	served, err := serves(d, "policy/"+V1, "poddisruptionbudgets")
+	if err != nil {
+		return nil, err
+	}
+	if served {
+		caps.PodDisruptionBudget = V1
+	}
+
+	// the eviction is a subresource of the pods, listed with its version
+	core, err := d.ServerResourcesForGroupVersion("v1")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the core resources: %w", err)
+	}
+	caps.Eviction = V1Beta1
+	for _, resource := range core.APIResources {
+		if resource.Name == "pods/eviction" && resource.Version != "" {
+			caps.Eviction = resource.Version
+		}
+	}
+	return caps, nil
+}
+
+// serves checks whether the API server serves a resource in a group version
+func serves(d discovery.DiscoveryInterface, groupVersion, resource string) (bool, error) {
+	list, err := d.ServerResourcesForGroupVersion(groupVersion)
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to list the resources of %s: %w", groupVersion, err)
+	}
+	for _, r := range list.APIResources {
+		if r.Name == resource {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,94 @@
+package kubecompat
+
+import (
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/version"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// newDiscovery returns a discovery client of a cluster serving the
+// resources, keyed by group version, and the eviction of the pods in a
+// policy version
+func newDiscovery(minor, eviction string, resources map[string][]string) *fakediscovery.FakeDiscovery {
+	d := fake.NewSimpleClientset().Discovery().(*fakediscovery.FakeDiscovery)
+	d.FakedServerVersion = &version.Info{Major: "1", Minor: minor, GitVersion: "v1." + strings.TrimSuffix(minor, "+") + ".3"}
+	core := &metav1.APIResourceList{GroupVersion: "v1", APIResources: []metav1.APIResource{{Name: "pods"}}}
+	if eviction != "" {
+		core.APIResources = append(core.APIResources, metav1.APIResource{Name: "pods/eviction", Group: "policy", Version: eviction})
+	}
+	d.Resources = append(d.Resources, core)
+	for groupVersion, names := range resources {
+		list := &metav1.APIResourceList{GroupVersion: groupVersion}
+		for _, name := range names {
+			list.APIResources = append(list.APIResources, metav1.APIResource{Name: name})
+		}
+		d.Resources = append(d.Resources, list)
+	}
+	return d
+}
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		name      string
+		minor     string
+		eviction  string
+		resources map[string][]string
+		want      Capabilities
+		wantErr   bool
+	}{
+		{
+			name:     "current",
+			minor:    "31",
+			eviction: V1,
+			resources: map[string][]string{
+				"discovery.k8s.io/v1": {"endpointslices"},
+				"policy/v1":           {"poddisruptionbudgets"},
+			},
+			want: Capabilities{EndpointSlice: V1, PodDisruptionBudget: V1, Eviction: V1},
+		},
+		{
+			name:     "1.20",
+			minor:    "20",
+			eviction: V1Beta1,
+			resources: map[string][]string{
+				"discovery.k8s.io/v1beta1": {"endpointslices"},
+				"policy/v1beta1":           {"poddisruptionbudgets"},
+			},
+			want: Capabilities{EndpointSlice: V1Beta1, PodDisruptionBudget: V1Beta1, Eviction: V1Beta1},
+		},
+		{
+			name:     "distribution minor with EndpointSlices disabled",
+			minor:    "21+",
+			eviction: V1Beta1,
+			resources: map[string][]string{
+				"policy/v1": {"poddisruptionbudgets"},
+			},
+			want: Capabilities{PodDisruptionBudget: V1, Eviction: V1Beta1},
+		},
+		{
+			name:    "too old",
+			minor:   "18",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			caps, err := Detect(newDiscovery(tt.minor, tt.eviction, tt.resources))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Detect() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			tt.want.ServerVersion = "v1." + strings.TrimSuffix(tt.minor, "+") + ".3"
+			if *caps != tt.want {
+				t.Errorf("Detect() = %+v, want %+v", *caps, tt.want)
+			}
+		})
+	}
+}
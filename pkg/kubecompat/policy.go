@@ -0,0 +1,63 @@
+package kubecompat
+
+import (
+	"context"
+
+	policyv1 "k8s.io/api/policy/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Evict evicts a pod through the eviction API version the cluster serves,
+// honoring its disruption budgets
+func (c *Capabilities) Evict(ctx context.Context, clientset kubernetes.Interface, namespace, name string) error {
+	meta := metav1.ObjectMeta{Name: name, Namespace: namespace}
+	if c.Eviction == V1Beta1 {
+		return clientset.PolicyV1beta1().Evictions(namespace).Evict(ctx, &policyv1beta1.Eviction{ObjectMeta: meta})
+	}
+	return clientset.PolicyV1().Evictions(namespace).Evict(ctx, &policyv1.Eviction{ObjectMeta: meta})
+}
+
+// ListPodDisruptionBudgets lists the disruption budgets of a namespace
+// through the API version the cluster serves, as v1 budgets
+func (c *Capabilities) ListPodDisruptionBudgets(ctx context.Context, clientset kubernetes.Interface, namespace string) ([]policyv1.PodDisruptionBudget, error) {
+	if c.PodDisruptionBudget != V1Beta1 {
+		list, err := clientset.PolicyV1().PodDisruptionBudgets(namespace).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return list.Items, nil
+	}
+
+	list, err := clientset.PolicyV1beta1().PodDisruptionBudgets(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	pdbs := make([]policyv1.PodDisruptionBudget, 0, len(list.Items))
+	for _, pdb := range list.Items {
+		selector := pdb.Spec.Selector
+		// a v1beta1 empty selector selects nothing, unlike a v1 one
+		if selector != nil && len(selector.MatchLabels) == 0 && len(selector.MatchExpressions) == 0 {
+			selector = nil
+		}
+		pdbs = append(pdbs, policyv1.PodDisruptionBudget{
+			ObjectMeta: pdb.ObjectMeta,
+			Spec: policyv1.PodDisruptionBudgetSpec{
+				MinAvailable:   pdb.Spec.MinAvailable,
+				Selector:       selector,
+				MaxUnavailable: pdb.Spec.MaxUnavailable,
+			},
+			Status: policyv1.PodDisruptionBudgetStatus{
+				ObservedGeneration: pdb.Status.ObservedGeneration,
+				DisruptedPods:      pdb.Status.DisruptedPods,
+				DisruptionsAllowed: pdb.Status.DisruptionsAllowed,
+				CurrentHealthy:     pdb.Status.CurrentHealthy,
+				DesiredHealthy:     pdb.Status.DesiredHealthy,
+				ExpectedPods:       pdb.Status.ExpectedPods,
+				Conditions:         pdb.Status.Conditions,
+			},
+		})
+	}
+	return pdbs, nil
+}
@@ -0,0 +1,75 @@
+package kubecompat
+
+import (
+	"context"
+	"testing"
+
+	policyv1 "k8s.io/api/policy/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestEvict(t *testing.T) {
+	for _, version := range []string{V1, V1Beta1} {
+		t.Run(version, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset()
+			var got runtime.Object
+			clientset.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				got = action.(k8stesting.CreateAction).GetObject()
+				return true, nil, nil
+			})
+
+			caps := &Capabilities{Eviction: version}
+			if err := caps.Evict(context.Background(), clientset, "edge", "router-0"); err != nil {
+				t.Fatalf("Evict() error = %v", err)
+			}
+			switch eviction := got.(type) {
+			case *policyv1.Eviction:
+				if version != V1 || eviction.Name != "router-0" {
+					t.Errorf("Evict() sent v1 eviction %+v", eviction)
+				}
+			case *policyv1beta1.Eviction:
+				if version != V1Beta1 || eviction.Name != "router-0" {
+					t.Errorf("Evict() sent v1beta1 eviction %+v", eviction)
+				}
+			default:
+				t.Errorf("Evict() sent %T", got)
+			}
+		})
+	}
+}
+
+func TestListPodDisruptionBudgetsV1Beta1(t *testing.T) {
+	selector := &metav1.LabelSelector{MatchLabels: map[string]string{"app": "router"}}
+	clientset := fake.NewSimpleClientset(
+		&policyv1beta1.PodDisruptionBudget{
+			ObjectMeta: metav1.ObjectMeta{Name: "router", Namespace: "edge"},
+			Spec:       policyv1beta1.PodDisruptionBudgetSpec{Selector: selector},
+			Status:     policyv1beta1.PodDisruptionBudgetStatus{DisruptionsAllowed: 1},
+		},
+		&policyv1beta1.PodDisruptionBudget{
+			ObjectMeta: metav1.ObjectMeta{Name: "empty", Namespace: "edge"},
+			Spec:       policyv1beta1.PodDisruptionBudgetSpec{Selector: &metav1.LabelSelector{}},
+		},
+	)
+
+	caps := &Capabilities{PodDisruptionBudget: V1Beta1}
+	pdbs, err := caps.ListPodDisruptionBudgets(context.Background(), clientset, "edge")
+	if err != nil {
+		t.Fatalf("ListPodDisruptionBudgets() error = %v", err)
+	}
+	got := make(map[string]policyv1.PodDisruptionBudget)
+	for _, pdb := range pdbs {
+		got[pdb.Name] = pdb
+	}
+	if len(got) != 2 || got["router"].Status.DisruptionsAllowed != 1 || got["router"].Spec.Selector.MatchLabels["app"] != "router" {
+		t.Errorf("ListPodDisruptionBudgets() = %+v", pdbs)
+	}
+	// an empty v1beta1 selector selects no pod, as a nil v1 one
+	if got["empty"].Spec.Selector != nil {
+		t.Errorf("empty v1beta1 selector converted to %+v, want nil", got["empty"].Spec.Selector)
+	}
+}
@@ -0,0 +1,285 @@
+// Package kubevirt follows the KubeVirt VirtualMachineInstances requesting
+// VFs, so edge sites mixing VMs and containers get the VFs of their VMs
+// allocated, constrained and freed the way those of their pods are.
+package kubevirt
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/akos011221/nsm/pkg/hardware"
+	"github.com/akos011221/nsm/pkg/watchdog"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// VirtualMachineInstanceList is the kind of the lists of running VMs
+var VirtualMachineInstanceList = schema.GroupVersionKind{Group: "kubevirt.io", Version: "v1", Kind: "VirtualMachineInstanceList"}
+
+// Phases of a VMI whose VM no longer runs, their VFs are freed
+const (
+	PhaseSucceeded = "Succeeded"
+	PhaseFailed    = "Failed"
+)
+
+// Bindings of the VM interfaces to the network of their pod
+const (
+	// BindingSRIOV passes a VF through to the VM as a PCI host device
+	BindingSRIOV = "sriov"
+	// BindingVhostUser connects the VM to a userspace switch over a
+	// vhost-user socket, a network binding plugin
+	BindingVhostUser = "vhostuser"
+)
+
+// coreBindings are the bindings built into KubeVirt
+var coreBindings = []string{"bridge", "masquerade", "slirp", "macvtap", "passt", BindingSRIOV}
+
+// Interface is a network interface of a VM
+type Interface struct {
+	// Name of the interface in the VMI spec
+	Name string `json:"name"`
+	// Binding of the interface (sriov, vhostuser, bridge, masquerade...)
+	Binding string `json:"binding"`
+}
+
+// VirtualMachine is a VMI requesting VFs
+type VirtualMachine struct {
+	// Namespace of the VMI
+	Namespace string `json:"namespace"`
+	// Name of the VMI
+	Name string `json:"name"`
+	// Phase of the VMI (Pending, Scheduling, Scheduled, Running...)
+	Phase string `json:"phase,omitempty"`
+	// Node the VMI runs on, empty before it is scheduled
+	NodeName string `json:"nodeName,omitempty"`
+	// Network interfaces of the VM
+	Interfaces []Interface `json:"interfaces,omitempty"`
+	// PCI addresses of the VFs allocated to the VM
+	VFs []string `json:"vfs,omitempty"`
+
+	// Metadata handed to the SR-IOV manager
+	pod corev1.Pod
+}
+
+// Watcher follows the VMIs requesting VFs. The VMIs are polled, KubeVirt
+// may be installed or removed at any time.
+type Watcher struct {
+	// Context for cancellation
+	ctx context.Context
+	// Kubernetes client, uncached so the KubeVirt kinds aren't watched
+	client client.Reader
+	// Logger
+	logger *logrus.Logger
+	// Kubernetes node the watcher runs on, VMIs on other nodes are skipped
+	node string
+	// Interval between lists of the VMIs
+	interval time.Duration
+	// Progress signal for the watchdog
+	heartbeat *watchdog.Heartbeat
+	// Called when the VMIs requesting VFs changed
+	onChange func()
+
+	// Mutex for protecting the VMs
+	mu sync.RWMutex
+	// VMIs requesting VFs, ordered by namespace and name
+	vms []VirtualMachine
+	// Whether KubeVirt is installed
+	installed bool
+}
+
+// NewWatcher creates a watcher of the VMIs requesting VFs on a node
+func NewWatcher(ctx context.Context, c client.Reader, logger *logrus.Logger, node string, interval time.Duration) *Watcher {
+	return &Watcher{ctx: ctx, client: c, logger: logger, node: node, interval: interval}
+}
+
+// SetHeartbeat makes the watcher report its progress to the watchdog
+func (w *Watcher) SetHeartbeat(hb *watchdog.Heartbeat) {
+	w.heartbeat = hb
+	hb.Expect(w.interval)
+}
+
+// SetOnChange sets a function called when the VMIs requesting VFs change,
+// to reconcile the allocations without waiting for the next poll
+func (w *Watcher) SetOnChange(fn func()) {
+	w.onChange = fn
+}
+
+// Start lists the VMIs periodically
+func (w *Watcher) Start() error {
+	w.logger.Infof("Watching the KubeVirt VMIs requesting VFs on node %s every %s", w.node, w.interval)
+	if err := w.Sync(); err != nil {
+		w.logger.WithError(err).Warn("Failed to list the KubeVirt VMIs")
+	}
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			w.heartbeat.Beat()
+			if err := w.Sync(); err != nil {
+				w.logger.WithError(err).Warn("Failed to list the KubeVirt VMIs")
+			}
+
+		case <-w.ctx.Done():
+			w.logger.Info("Stopping KubeVirt VMI watcher")
+			return nil
+		}
+	}
+}
+
+// Sync lists the VMIs requesting VFs. The VMIs are kept as they were when
+// they can't be listed, so an API server outage doesn't free their VFs.
+func (w *Watcher) Sync() error {
+	selector, err := labels.Parse(hardware.SRIOVPodSelector)
+	if err != nil {
+		return err
+	}
+	list := &unstructured.UnstructuredList{}
+	list.SetGroupVersionKind(VirtualMachineInstanceList)
+	err = w.client.List(w.ctx, list, client.MatchingLabelsSelector{Selector: selector})
+	if meta.IsNoMatchError(err) {
+		w.update(nil, false)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to list KubeVirt VMIs: %w", err)
+	}
+
+	var vms []VirtualMachine
+	for i := range list.Items {
+		vm := virtualMachine(&list.Items[i])
+		// the VFs of a VM that stopped or moved to another node are freed
+		if vm.Phase == PhaseSucceeded || vm.Phase == PhaseFailed {
+			continue
+		}
+		if vm.NodeName != "" && w.node != "" && vm.NodeName != w.node {
+			continue
+		}
+		vms = append(vms, vm)
+	}
+	sort.Slice(vms, func(i, j int) bool {
+		if vms[i].Namespace != vms[j].Namespace {
+			return vms[i].Namespace < vms[j].Namespace
+		}
+		return vms[i].Name < vms[j].Name
+	})
+	w.update(vms, true)
+	return nil
+}
+
+// virtualMachine reads a VMI and the metadata of its VFs: a VF per SR-IOV
+// interface unless the VMI sets the count, bound to vfio-pci for the
+// passthrough unless the VMI sets the driver
+func virtualMachine(obj *unstructured.Unstructured) VirtualMachine {
+	vm := VirtualMachine{Namespace: obj.GetNamespace(), Name: obj.GetName()}
+	vm.Phase, _, _ = unstructured.NestedString(obj.Object, "status", "phase")
+	vm.NodeName, _, _ = unstructured.NestedString(obj.Object, "status", "nodeName")
+
+	sriov := 0
+	ifaces, _, _ := unstructured.NestedSlice(obj.Object, "spec", "domain", "devices", "interfaces")
+	for _, item := range ifaces {
+		iface, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		i := Interface{}
+		i.Name, _, _ = unstructured.NestedString(iface, "name")
+		// the core bindings are keys of the interface, the others plugins
+		// named in its binding (e.g., vhostuser)
+		for _, binding := range coreBindings {
+			if _, ok := iface[binding]; ok {
+				i.Binding = binding
+			}
+		}
+		if plugin, ok, _ := unstructured.NestedString(iface, "binding", "name"); ok {
+			i.Binding = plugin
+		}
+		if i.Binding == BindingSRIOV {
+			sriov++
+		}
+		vm.Interfaces = append(vm.Interfaces, i)
+	}
+
+	annotations := make(map[string]string, len(obj.GetAnnotations())+2)
+	for k, v := range obj.GetAnnotations() {
+		annotations[k] = v
+	}
+	if _, ok := annotations[hardware.AnnotationSRIOVCount]; !ok && sriov > 1 {
+		annotations[hardware.AnnotationSRIOVCount] = strconv.Itoa(sriov)
+	}
+	if _, ok := annotations[hardware.AnnotationSRIOVDriver]; !ok && sriov > 0 {
+		annotations[hardware.AnnotationSRIOVDriver] = hardware.DriverVFIOPCI
+	}
+	vm.pod.Namespace = vm.Namespace
+	vm.pod.Name = vm.Name
+	vm.pod.UID = obj.GetUID()
+	vm.pod.Labels = obj.GetLabels()
+	vm.pod.Annotations = annotations
+	vm.pod.DeletionTimestamp = obj.GetDeletionTimestamp()
+	return vm
+}
+
+// update replaces the VMs, logging the changes and reporting them
+func (w *Watcher) update(vms []VirtualMachine, installed bool) {
+	w.mu.Lock()
+	switch {
+	case installed && !w.installed:
+		w.logger.Info("KubeVirt detected, allocating VFs to its VMIs")
+	case !installed && w.installed:
+		w.logger.Info("KubeVirt no longer installed")
+	}
+	changed := !reflect.DeepEqual(vms, w.vms)
+	w.vms = vms
+	w.installed = installed
+	w.mu.Unlock()
+
+	if changed && w.onChange != nil {
+		w.onChange()
+	}
+}
+
+// RequestingVMs returns the VMIs requesting VFs as pods, for the SR-IOV
+// manager
+func (w *Watcher) RequestingVMs() ([]corev1.Pod, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	pods := make([]corev1.Pod, 0, len(w.vms))
+	for _, vm := range w.vms {
+		pods = append(pods, *vm.pod.DeepCopy())
+	}
+	return pods, nil
+}
+
+// VirtualMachines returns the VMIs requesting VFs, ordered by namespace and
+// name
+func (w *Watcher) VirtualMachines() []VirtualMachine {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	vms := make([]VirtualMachine, 0, len(w.vms))
+	for _, vm := range w.vms {
+		vm.Interfaces = append([]Interface(nil), vm.Interfaces...)
+		vms = append(vms, vm)
+	}
+	return vms
+}
+
+// Installed returns whether KubeVirt was found installed on the last list
+func (w *Watcher) Installed() bool {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.installed
+}
@@ -0,0 +1,129 @@
+package kubevirt
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/akos011221/nsm/pkg/hardware"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+var vmiKind = schema.GroupVersionKind{Group: "kubevirt.io", Version: "v1", Kind: "VirtualMachineInstance"}
+
+// vmi returns a VMI requesting VFs with the interfaces, running on a node
+func vmi(name, node, phase string, ifaces ...interface{}) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"name":      name,
+			"namespace": "edge",
+			"labels":    map[string]interface{}{"network.nsm.akosrbn.io/sriov": "true"},
+		},
+		"spec": map[string]interface{}{
+			"domain": map[string]interface{}{"devices": map[string]interface{}{"interfaces": ifaces}},
+		},
+		"status": map[string]interface{}{"phase": phase, "nodeName": node},
+	}}
+	obj.SetGroupVersionKind(vmiKind)
+	return obj
+}
+
+func newTestWatcher(t *testing.T, installed bool, objs ...*unstructured.Unstructured) *Watcher {
+	t.Helper()
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(vmiKind, meta.RESTScopeNamespace)
+	builder := fake.NewClientBuilder().WithScheme(runtime.NewScheme()).WithRESTMapper(mapper)
+	if !installed {
+		// the fake client lists kinds it doesn't know, the API server doesn't
+		builder = builder.WithInterceptorFuncs(interceptor.Funcs{
+			List: func(context.Context, client.WithWatch, client.ObjectList, ...client.ListOption) error {
+				return &meta.NoKindMatchError{GroupKind: vmiKind.GroupKind(), SearchedVersions: []string{vmiKind.Version}}
+			},
+		})
+	}
+	for _, obj := range objs {
+		builder = builder.WithObjects(obj)
+	}
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return NewWatcher(context.Background(), builder.Build(), logger, "worker-1", time.Second)
+}
+
+func TestWatcherWithoutKubeVirt(t *testing.T) {
+	w := newTestWatcher(t, false)
+	if err := w.Sync(); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if w.Installed() {
+		t.Error("KubeVirt detected while not installed")
+	}
+	if vms, err := w.RequestingVMs(); err != nil || len(vms) != 0 {
+		t.Errorf("RequestingVMs() = %v, %v, want none", vms, err)
+	}
+}
+
+func TestWatcherListsVMIs(t *testing.T) {
+	sriov := func(name string) interface{} {
+		return map[string]interface{}{"name": name, "sriov": map[string]interface{}{}}
+	}
+	unlabeled := vmi("desktop", "worker-1", "Running", sriov("net1"))
+	unlabeled.SetLabels(nil)
+	dpdk := vmi("dpdk", "", "Pending",
+		map[string]interface{}{"name": "default", "masquerade": map[string]interface{}{}},
+		map[string]interface{}{"name": "net1", "binding": map[string]interface{}{"name": "vhostuser"}},
+		sriov("net2"))
+	dpdk.SetAnnotations(map[string]string{hardware.AnnotationSRIOVDriver: "iavf"})
+	w := newTestWatcher(t, true,
+		vmi("router", "worker-1", "Running", sriov("uplink1"), sriov("uplink2")),
+		dpdk,
+		vmi("stopped", "worker-1", PhaseSucceeded, sriov("net1")),
+		vmi("elsewhere", "worker-2", "Running", sriov("net1")),
+		unlabeled,
+	)
+	changes := 0
+	w.SetOnChange(func() { changes++ })
+	if err := w.Sync(); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+
+	// the VMIs not requesting VFs, stopped or on other nodes are skipped,
+	// those not scheduled yet get their VFs ahead
+	vms := w.VirtualMachines()
+	if len(vms) != 2 || vms[0].Name != "dpdk" || vms[1].Name != "router" {
+		t.Fatalf("VirtualMachines() = %+v, want dpdk and router", vms)
+	}
+	wantIfaces := []Interface{{Name: "default", Binding: "masquerade"}, {Name: "net1", Binding: BindingVhostUser}, {Name: "net2", Binding: BindingSRIOV}}
+	if !reflect.DeepEqual(vms[0].Interfaces, wantIfaces) {
+		t.Errorf("interfaces = %+v, want %+v", vms[0].Interfaces, wantIfaces)
+	}
+
+	// a VF per SR-IOV interface, bound to vfio-pci unless the VMI says otherwise
+	pods, err := w.RequestingVMs()
+	if err != nil {
+		t.Fatalf("RequestingVMs() error = %v", err)
+	}
+	if got := pods[0].Annotations; !reflect.DeepEqual(got, map[string]string{hardware.AnnotationSRIOVDriver: "iavf"}) {
+		t.Errorf("dpdk annotations = %v", got)
+	}
+	want := map[string]string{hardware.AnnotationSRIOVCount: "2", hardware.AnnotationSRIOVDriver: hardware.DriverVFIOPCI}
+	if got := pods[1].Annotations; !reflect.DeepEqual(got, want) {
+		t.Errorf("router annotations = %v, want %v", got, want)
+	}
+
+	// only changes are reported
+	if err := w.Sync(); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if changes != 1 {
+		t.Errorf("reported %d changes, want 1", changes)
+	}
+}
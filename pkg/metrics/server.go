@@ -0,0 +1,142 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/akos011221/nsm/pkg/api"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sirupsen/logrus"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// ControllerComponent is the component of the metrics the packages
+// register with controller-runtime, its reconcile durations among them
+const ControllerComponent = "controller"
+
+// Server serves the Prometheus metrics of the controller on /metrics,
+// and those of a single component on /metrics/{component}. Every
+// component has its own registry, so the metrics of a manager can be
+// scraped (and alerted on) on their own.
+type Server struct {
+	// Context for cancellation
+	ctx context.Context
+	// Logger
+	logger *logrus.Logger
+	// Address to listen on
+	listenAddr string
+	// Registries by component
+	registries map[string]prometheus.Gatherer
+	// Guards registries
+	mu sync.RWMutex
+}
+
+// NewServer creates a new metrics server with the controller component
+func NewServer(ctx context.Context, logger *logrus.Logger, listenAddr string) *Server {
+	return &Server{
+		ctx:        ctx,
+		logger:     logger,
+		listenAddr: listenAddr,
+		registries: map[string]prometheus.Gatherer{ControllerComponent: crmetrics.Registry},
+	}
+}
+
+// Register registers the collectors of a component in its registry
+func (s *Server) Register(component string, collectors ...prometheus.Collector) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if component == ControllerComponent {
+		return fmt.Errorf("metrics of the %s component are registered with controller-runtime", component)
+	}
+	gatherer, ok := s.registries[component]
+	if !ok {
+		gatherer = prometheus.NewRegistry()
+		s.registries[component] = gatherer
+	}
+	registry := gatherer.(*prometheus.Registry)
+	for _, c := range collectors {
+		if err := registry.Register(c); err != nil {
+			return fmt.Errorf("failed to register %s metrics: %w", component, err)
+		}
+	}
+	return nil
+}
+
+// Components returns the names of the components, sorted
+func (s *Server) Components() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.componentsLocked()
+}
+
+// Handler returns the HTTP handler of the server
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /metrics", func(w http.ResponseWriter, r *http.Request) {
+		s.mu.RLock()
+		gatherers := make(prometheus.Gatherers, 0, len(s.registries))
+		for _, name := range s.componentsLocked() {
+			gatherers = append(gatherers, s.registries[name])
+		}
+		s.mu.RUnlock()
+		promhttp.HandlerFor(gatherers, promhttp.HandlerOpts{ErrorLog: s.logger}).ServeHTTP(w, r)
+	})
+	mux.HandleFunc("GET /metrics/{component}", func(w http.ResponseWriter, r *http.Request) {
+		component := r.PathValue("component")
+		s.mu.RLock()
+		gatherer, ok := s.registries[component]
+		s.mu.RUnlock()
+		if !ok {
+			api.WriteError(w, http.StatusNotFound, fmt.Errorf("no metrics component %s", component))
+			return
+		}
+		promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{ErrorLog: s.logger}).ServeHTTP(w, r)
+	})
+	return mux
+}
+
+// componentsLocked returns the names of the components, sorted, with the
+// lock held
+func (s *Server) componentsLocked() []string {
+	names := make([]string, 0, len(s.registries))
+	for name := range s.registries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Start serves the metrics until the context is done
+func (s *Server) Start() error {
+	s.logger.Infof("Serving metrics on %s", s.listenAddr)
+
+	srv := &http.Server{
+		Addr:              s.listenAddr,
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			errCh <- err
+		}
+	}()
+
+	select {
+	case err := <-errCh:
+		return fmt.Errorf("metrics server failed: %w", err)
+	case <-s.ctx.Done():
+		s.logger.Info("Stopping metrics server")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return srv.Shutdown(shutdownCtx)
+	}
+}
@@ -0,0 +1,61 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+func TestServer(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	s := NewServer(context.Background(), logger, "")
+
+	vfs := prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_vfs", Help: "VFs"})
+	vfs.Set(4)
+	conns := prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_connections", Help: "Connections"})
+	if err := s.Register("sriov", vfs); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	if err := s.Register("connections", conns); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	if err := s.Register("sriov", vfs); err == nil {
+		t.Error("Register() accepted a collector twice")
+	}
+	if err := s.Register(ControllerComponent, conns); err == nil {
+		t.Error("Register() accepted collectors for the controller-runtime registry")
+	}
+	if got := s.Components(); !reflect.DeepEqual(got, []string{"connections", "controller", "sriov"}) {
+		t.Errorf("Components() = %v", got)
+	}
+
+	srv := httptest.NewServer(s.Handler())
+	defer srv.Close()
+	get := func(path string) (int, string) {
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	if code, body := get("/metrics"); code != http.StatusOK || !strings.Contains(body, "test_vfs 4") || !strings.Contains(body, "test_connections 0") {
+		t.Errorf("/metrics = %d %s, want the metrics of every component", code, body)
+	}
+	if code, body := get("/metrics/sriov"); code != http.StatusOK || !strings.Contains(body, "test_vfs 4") || strings.Contains(body, "test_connections") {
+		t.Errorf("/metrics/sriov = %d %s, want the SR-IOV metrics only", code, body)
+	}
+	if code, _ := get("/metrics/unknown"); code != http.StatusNotFound {
+		t.Errorf("/metrics/unknown = %d, want 404", code)
+	}
+}
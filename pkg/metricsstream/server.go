@@ -0,0 +1,215 @@
+package metricsstream
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	metricsv1 "github.com/akos011221/nsm/api/grpc/metrics/v1"
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/budget"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Default minimum interval between two samples of a connection
+const defaultMinInterval = time.Second
+
+// Server streams live connection metrics over gRPC, so edge dashboards can
+// graph them in real time without scraping
+type Server struct {
+	metricsv1.UnimplementedConnectionMetricsServer
+
+	// Context for cancellation
+	ctx context.Context
+	// Kubernetes client (controller-runtime)
+	client client.Client
+	// Logger
+	logger *logrus.Logger
+	// Address to listen on
+	listenAddr string
+	// Interval the connections are checked for new metrics at
+	pollInterval time.Duration
+	// Resource budget of the streams, polling slows down once it is exceeded
+	budget *budget.Tracker
+	// Other streaming services served on the same listener
+	services []service
+}
+
+// service is a gRPC service registered with its implementation
+type service struct {
+	desc *grpc.ServiceDesc
+	impl any
+}
+
+// NewServer creates a new metrics streaming server
+func NewServer(ctx context.Context, c client.Client, logger *logrus.Logger, listenAddr string) *Server {
+	return &Server{
+		ctx:          ctx,
+		client:       c,
+		logger:       logger,
+		listenAddr:   listenAddr,
+		pollInterval: 250 * time.Millisecond,
+	}
+}
+
+// SetBudget makes the server account the resources of the streams and
+// poll less often while over budget
+func (s *Server) SetBudget(tracker *budget.Tracker) {
+	s.budget = tracker
+}
+
+// RegisterService serves another streaming service alongside the metrics,
+// it must be called before the server is started
+func (s *Server) RegisterService(desc *grpc.ServiceDesc, impl any) {
+	s.services = append(s.services, service{desc: desc, impl: impl})
+}
+
+// Start serves the gRPC API until the context is done
+func (s *Server) Start() error {
+	lis, err := net.Listen("tcp", s.listenAddr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", s.listenAddr, err)
+	}
+	s.logger.Infof("Starting metrics streaming API on %s", s.listenAddr)
+	return s.Serve(lis)
+}
+
+// Serve serves the gRPC API on the listener until the context is done
+func (s *Server) Serve(lis net.Listener) error {
+	srv := grpc.NewServer()
+	metricsv1.RegisterConnectionMetricsServer(srv, s)
+	for _, svc := range s.services {
+		srv.RegisterService(svc.desc, svc.impl)
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.Serve(lis)
+	}()
+
+	select {
+	case err := <-errCh:
+		return fmt.Errorf("metrics streaming API failed: %w", err)
+	case <-s.ctx.Done():
+		// the watches end with the context, so this doesn't block
+		s.logger.Info("Stopping metrics streaming API")
+		srv.GracefulStop()
+		return nil
+	}
+}
+
+// WatchConnectionMetrics sends a sample whenever the metrics of a selected
+// connection are updated, at most one per connection and minimum interval
+func (s *Server) WatchConnectionMetrics(req *metricsv1.WatchConnectionMetricsRequest, stream metricsv1.ConnectionMetrics_WatchConnectionMetricsServer) error {
+	selector, err := labels.Parse(req.GetLabelSelector())
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "invalid label selector: %v", err)
+	}
+	names := make(map[string]bool, len(req.GetNames()))
+	for _, name := range req.GetNames() {
+		names[name] = true
+	}
+	w := &watch{
+		stream:       stream,
+		selector:     selector,
+		namespace:    req.GetNamespace(),
+		names:        names,
+		minInterval:  defaultMinInterval,
+		lastObserved: make(map[types.NamespacedName]time.Time),
+		lastSent:     make(map[types.NamespacedName]time.Time),
+	}
+	if req.GetMinIntervalMs() > 0 {
+		w.minInterval = time.Duration(req.GetMinIntervalMs()) * time.Millisecond
+	}
+
+	ticker := time.NewTicker(s.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		s.budget.Run(func() { err = s.poll(w) })
+		if err != nil {
+			return err
+		}
+
+		// polling slows down while the streams are over budget
+		ticker.Reset(s.budget.Stretch(s.pollInterval))
+		select {
+		case <-ticker.C:
+		case <-stream.Context().Done():
+			return nil
+		case <-s.ctx.Done():
+			return status.Error(codes.Unavailable, "server shutting down")
+		}
+	}
+}
+
+// watch is the state of a metrics stream
+type watch struct {
+	// Stream the samples are sent on
+	stream metricsv1.ConnectionMetrics_WatchConnectionMetricsServer
+	// Labels of the selected connections
+	selector labels.Selector
+	// Namespace of the selected connections, empty for all
+	namespace string
+	// Names of the selected connections, empty for all
+	names map[string]bool
+	// Minimum interval between two samples of a connection
+	minInterval time.Duration
+	// Observation time of the last sample sent per connection
+	lastObserved map[types.NamespacedName]time.Time
+	// Time the last sample was sent per connection
+	lastSent map[types.NamespacedName]time.Time
+}
+
+// poll sends the metrics of the selected connections updated since they
+// were last sent, at most one per connection and minimum interval
+func (s *Server) poll(w *watch) error {
+	var conns nsmv1.NetworkConnectionList
+	opts := []client.ListOption{client.MatchingLabelsSelector{Selector: w.selector}}
+	if w.namespace != "" {
+		opts = append(opts, client.InNamespace(w.namespace))
+	}
+	if err := s.client.List(w.stream.Context(), &conns, opts...); err != nil {
+		return status.Errorf(codes.Unavailable, "failed to list connections: %v", err)
+	}
+
+	now := time.Now()
+	for _, conn := range conns.Items {
+		updated := conn.Status.Metrics.LastUpdated
+		if updated == nil || (len(w.names) > 0 && !w.names[conn.Name]) {
+			continue
+		}
+		key := types.NamespacedName{Namespace: conn.Namespace, Name: conn.Name}
+		if !updated.Time.After(w.lastObserved[key]) || now.Sub(w.lastSent[key]) < w.minInterval {
+			continue
+		}
+
+		if err := w.stream.Send(sample(&conn)); err != nil {
+			return err
+		}
+		w.lastObserved[key] = updated.Time
+		w.lastSent[key] = now
+	}
+	return nil
+}
+
+// sample converts the metrics of a connection
+func sample(conn *nsmv1.NetworkConnection) *metricsv1.ConnectionMetricsSample {
+	return &metricsv1.ConnectionMetricsSample{
+		Namespace:      conn.Namespace,
+		Name:           conn.Name,
+		Time:           timestamppb.New(conn.Status.Metrics.LastUpdated.Time),
+		LatencyMs:      int64(conn.Status.Metrics.LatencyMs),
+		ThroughputMbps: int64(conn.Status.Metrics.ThroughputMbps),
+		PacketLossPpm:  int64(conn.Status.Metrics.PacketLossPPM),
+		State:          conn.Status.State,
+	}
+}
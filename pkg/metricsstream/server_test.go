@@ -0,0 +1,197 @@
+package metricsstream
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	metricsv1 "github.com/akos011221/nsm/api/grpc/metrics/v1"
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/budget"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func quietLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return logger
+}
+
+func testConnection(name, tier string, latency int) *nsmv1.NetworkConnection {
+	updated := metav1.NewTime(time.Now().Truncate(time.Second))
+	return &nsmv1.NetworkConnection{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "edge", Labels: map[string]string{"tier": tier}},
+		Status: nsmv1.NetworkConnectionStatus{
+			State:   nsmv1.ConnectionStateEstablished,
+			Metrics: nsmv1.ConnectionMetrics{LatencyMs: latency, ThroughputMbps: 100, LastUpdated: &updated},
+		},
+	}
+}
+
+// startServer serves the API over an in-memory listener and returns a
+// client, setup (if not nil) adjusts the server before it starts
+func startServer(t *testing.T, setup func(*Server), objs ...client.Object) (metricsv1.ConnectionMetricsClient, client.Client) {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := nsmv1.AddToScheme(scheme); err != nil {
+		t.Fatalf("failed to build scheme: %v", err)
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).WithStatusSubresource(&nsmv1.NetworkConnection{}).Build()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := NewServer(ctx, c, quietLogger(), "")
+	s.pollInterval = 10 * time.Millisecond
+	if setup != nil {
+		setup(s)
+	}
+
+	lis := bufconn.Listen(1 << 20)
+	done := make(chan error, 1)
+	go func() { done <- s.Serve(lis) }()
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Serve() error = %v", err)
+		}
+	})
+
+	return metricsv1.NewConnectionMetricsClient(conn), c
+}
+
+func TestWatchConnectionMetrics(t *testing.T) {
+	api, c := startServer(t, nil, testConnection("video", "video", 5), testConnection("bulk", "bulk", 50))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	stream, err := api.WatchConnectionMetrics(ctx, &metricsv1.WatchConnectionMetricsRequest{
+		Namespace:     "edge",
+		LabelSelector: "tier=video",
+		MinIntervalMs: 1,
+	})
+	if err != nil {
+		t.Fatalf("WatchConnectionMetrics() error = %v", err)
+	}
+
+	first, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("Recv() error = %v", err)
+	}
+	if first.GetName() != "video" || first.GetLatencyMs() != 5 || first.GetThroughputMbps() != 100 || first.GetState() != nsmv1.ConnectionStateEstablished {
+		t.Errorf("unexpected first sample %v", first)
+	}
+
+	// a new observation is pushed
+	var conn nsmv1.NetworkConnection
+	if err := c.Get(ctx, client.ObjectKey{Namespace: "edge", Name: "video"}, &conn); err != nil {
+		t.Fatal(err)
+	}
+	later := metav1.NewTime(conn.Status.Metrics.LastUpdated.Add(time.Second))
+	conn.Status.Metrics.LatencyMs = 7
+	conn.Status.Metrics.LastUpdated = &later
+	if err := c.Status().Update(ctx, &conn); err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("Recv() error = %v", err)
+	}
+	if second.GetName() != "video" || second.GetLatencyMs() != 7 || !second.GetTime().AsTime().Equal(later.Time) {
+		t.Errorf("unexpected second sample %v, want the updated latency", second)
+	}
+}
+
+func TestWatchConnectionMetricsByName(t *testing.T) {
+	api, _ := startServer(t, nil, testConnection("video", "video", 5), testConnection("bulk", "bulk", 50))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	stream, err := api.WatchConnectionMetrics(ctx, &metricsv1.WatchConnectionMetricsRequest{Names: []string{"bulk"}})
+	if err != nil {
+		t.Fatalf("WatchConnectionMetrics() error = %v", err)
+	}
+
+	sample, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("Recv() error = %v", err)
+	}
+	if sample.GetName() != "bulk" || sample.GetLatencyMs() != 50 {
+		t.Errorf("unexpected sample %v", sample)
+	}
+}
+
+func TestWatchConnectionMetricsInvalidSelector(t *testing.T) {
+	api, _ := startServer(t, nil)
+
+	stream, err := api.WatchConnectionMetrics(context.Background(), &metricsv1.WatchConnectionMetricsRequest{LabelSelector: "tier in (("})
+	if err != nil {
+		t.Fatalf("WatchConnectionMetrics() error = %v", err)
+	}
+	if _, err := stream.Recv(); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Recv() error = %v, want InvalidArgument", err)
+	}
+}
+
+func TestWatchConnectionMetricsOverBudget(t *testing.T) {
+	// a stream over budget polls at the maximum degradation level
+	budgets := budget.NewManager(context.Background(), quietLogger(), time.Second)
+	tracker := budgets.Track("metrics-stream", budget.Budget{MemoryBytes: 1})
+	tracker.SetMemory(2)
+	for i := 1; i <= budget.MaxLevel; i++ {
+		if err := budgets.Check(time.Now().Add(time.Duration(i) * time.Second)); err != nil {
+			t.Fatalf("Check() error = %v", err)
+		}
+	}
+	api, c := startServer(t, func(s *Server) {
+		s.pollInterval = 100 * time.Millisecond
+		s.SetBudget(tracker)
+	}, testConnection("video", "video", 5))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	stream, err := api.WatchConnectionMetrics(ctx, &metricsv1.WatchConnectionMetricsRequest{MinIntervalMs: 1})
+	if err != nil {
+		t.Fatalf("WatchConnectionMetrics() error = %v", err)
+	}
+	if _, err := stream.Recv(); err != nil {
+		t.Fatalf("Recv() error = %v", err)
+	}
+
+	var conn nsmv1.NetworkConnection
+	if err := c.Get(ctx, client.ObjectKey{Namespace: "edge", Name: "video"}, &conn); err != nil {
+		t.Fatal(err)
+	}
+	later := metav1.NewTime(conn.Status.Metrics.LastUpdated.Add(time.Second))
+	conn.Status.Metrics.LastUpdated = &later
+	if err := c.Status().Update(ctx, &conn); err != nil {
+		t.Fatal(err)
+	}
+
+	updated := time.Now()
+	if _, err := stream.Recv(); err != nil {
+		t.Fatalf("Recv() error = %v", err)
+	}
+	// the update is picked up by the next poll, 800ms after the first
+	if waited := time.Since(updated); waited < 500*time.Millisecond {
+		t.Errorf("update streamed after %s, polling not slowed down over budget", waited)
+	}
+}
@@ -0,0 +1,130 @@
+// Package netendpoint publishes the interfaces of a node as
+// NetworkEndpoints, and fills in the address of the endpoints the
+// NetworkConnections reference as their source or destination.
+package netendpoint
+
+import (
+	"net"
+	"sort"
+	"strings"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/hardware"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Interface is an interface of the node, published as a NetworkEndpoint
+type Interface struct {
+	// Interface name
+	Name string
+	// MAC address
+	MAC string
+	// IP addresses, without their prefix length
+	IPs []string
+	// Capabilities (kernel, sriov, dpdk)
+	Capabilities []string
+	// Negotiated link speed in Mbps, 0 when unknown
+	SpeedMbps int
+	// Whether the interface is up
+	Up bool
+}
+
+// Lister returns the interfaces of the node
+type Lister func() ([]Interface, error)
+
+// Name returns the name of the NetworkEndpoint of an interface of a node,
+// <node>.<interface> lowercased, with the characters an object name can't
+// hold replaced by dashes
+func Name(node, iface string) string {
+	sanitize := func(s string) string {
+		return strings.Trim(strings.Map(func(r rune) rune {
+			switch {
+			case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-', r == '.':
+				return r
+			case r >= 'A' && r <= 'Z':
+				return r - 'A' + 'a'
+			}
+			return '-'
+		}, s), "-.")
+	}
+	return sanitize(node) + "." + sanitize(iface)
+}
+
+// Build returns the NetworkEndpoints of the interfaces of a node, ordered
+// by name
+func Build(node string, ifaces []Interface) []nsmv1.NetworkEndpoint {
+	endpoints := make([]nsmv1.NetworkEndpoint, 0, len(ifaces))
+	for _, iface := range ifaces {
+		endpoints = append(endpoints, nsmv1.NetworkEndpoint{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   Name(node, iface.Name),
+				Labels: map[string]string{nsmv1.LabelEndpointNode: node},
+			},
+			Spec: nsmv1.NetworkEndpointSpec{
+				NodeName:     node,
+				Interface:    iface.Name,
+				MAC:          iface.MAC,
+				IPs:          iface.IPs,
+				Capabilities: iface.Capabilities,
+				SpeedMbps:    iface.SpeedMbps,
+				Up:           iface.Up,
+			},
+		})
+	}
+	sort.Slice(endpoints, func(i, j int) bool { return endpoints[i].Name < endpoints[j].Name })
+	return endpoints
+}
+
+// Address returns the address connections reach an endpoint at, its first
+// IP, or empty when it has none
+func Address(ep *nsmv1.NetworkEndpoint) string {
+	if len(ep.Spec.IPs) == 0 {
+		return ""
+	}
+	return ep.Spec.IPs[0]
+}
+
+// HostInterfaces lists the physical NICs of the node with their current
+// addresses. Every NIC is served by the kernel datapath; SR-IOV capable
+// NICs hand out VFs when sriov is enabled, PCI NICs can be bound to DPDK
+// when dpdk is enabled.
+func HostInterfaces(nics []hardware.NIC, sriov, dpdk bool) Lister {
+	return func() ([]Interface, error) {
+		var ifaces []Interface
+		for _, nic := range nics {
+			if nic.Bus == hardware.BusVirtual {
+				continue
+			}
+			link, err := net.InterfaceByName(nic.Name)
+			if err != nil {
+				// renamed or unplugged since the detection
+				continue
+			}
+			iface := Interface{
+				Name:         nic.Name,
+				MAC:          link.HardwareAddr.String(),
+				Capabilities: []string{nsmv1.EndpointCapabilityKernel},
+				SpeedMbps:    nic.SpeedMbps,
+				Up:           link.Flags&net.FlagUp != 0 && link.Flags&net.FlagRunning != 0,
+			}
+			if sriov && nic.SRIOV {
+				iface.Capabilities = append(iface.Capabilities, nsmv1.EndpointCapabilitySRIOV)
+			}
+			if dpdk && nic.Bus == hardware.BusPCI {
+				iface.Capabilities = append(iface.Capabilities, nsmv1.EndpointCapabilityDPDK)
+			}
+			addrs, err := link.Addrs()
+			if err != nil {
+				return nil, err
+			}
+			for _, addr := range addrs {
+				// link-local addresses aren't reachable from other links
+				if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.IsGlobalUnicast() {
+					iface.IPs = append(iface.IPs, ipnet.IP.String())
+				}
+			}
+			ifaces = append(ifaces, iface)
+		}
+		return ifaces, nil
+	}
+}
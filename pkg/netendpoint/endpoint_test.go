@@ -0,0 +1,38 @@
+package netendpoint
+
+import (
+	"testing"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+)
+
+func TestName(t *testing.T) {
+	for _, tt := range []struct{ node, iface, want string }{
+		{"edge-1", "eth0", "edge-1.eth0"},
+		{"Edge-1", "enp59s0f0", "edge-1.enp59s0f0"},
+		{"edge-1", "wan_uplink@", "edge-1.wan-uplink"},
+	} {
+		if got := Name(tt.node, tt.iface); got != tt.want {
+			t.Errorf("Name(%s, %s) = %s, want %s", tt.node, tt.iface, got, tt.want)
+		}
+	}
+}
+
+func TestBuild(t *testing.T) {
+	endpoints := Build("edge-1", []Interface{
+		{Name: "eth1", IPs: []string{"192.0.2.10", "2001:db8::10"}, Capabilities: []string{nsmv1.EndpointCapabilityKernel}, Up: true},
+		{Name: "eth0", Capabilities: []string{nsmv1.EndpointCapabilityKernel, nsmv1.EndpointCapabilitySRIOV}},
+	})
+	if len(endpoints) != 2 || endpoints[0].Name != "edge-1.eth0" || endpoints[1].Name != "edge-1.eth1" {
+		t.Fatalf("Build() = %+v, want the endpoints of eth0 and eth1 in order", endpoints)
+	}
+	if endpoints[1].Labels[nsmv1.LabelEndpointNode] != "edge-1" || endpoints[1].Spec.NodeName != "edge-1" || !endpoints[1].Spec.Up {
+		t.Errorf("endpoint of eth1 = %+v", endpoints[1])
+	}
+	if got := Address(&endpoints[1]); got != "192.0.2.10" {
+		t.Errorf("Address() = %q, want the first IP", got)
+	}
+	if got := Address(&endpoints[0]); got != "" {
+		t.Errorf("Address() without IPs = %q", got)
+	}
+}
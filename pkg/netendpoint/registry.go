@@ -0,0 +1,164 @@
+package netendpoint
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/watchdog"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Registry publishes the interfaces of a node as NetworkEndpoints, keeping
+// them current as addresses and links change and deleting the endpoints of
+// interfaces gone. The connections referencing one of the endpoints get its
+// address as their source or destination, so the rest of NSM keeps
+// working on addresses while the users reference discoverable objects.
+type Registry struct {
+	// Context for cancellation
+	ctx context.Context
+	// Kubernetes client (controller-runtime)
+	client client.Client
+	// Logger
+	logger *logrus.Logger
+	// Node the interfaces are on
+	node string
+	// Interfaces of the node
+	interfaces Lister
+	// Interval between publications
+	interval time.Duration
+	// Progress signal for the watchdog
+	heartbeat *watchdog.Heartbeat
+}
+
+// NewRegistry creates a registry of the interfaces of a node
+func NewRegistry(ctx context.Context, c client.Client, logger *logrus.Logger, node string, interfaces Lister, interval time.Duration) *Registry {
+	return &Registry{ctx: ctx, client: c, logger: logger, node: node, interfaces: interfaces, interval: interval}
+}
+
+// SetHeartbeat makes the registry report its progress to the watchdog
+func (r *Registry) SetHeartbeat(hb *watchdog.Heartbeat) {
+	r.heartbeat = hb
+	hb.Expect(r.interval)
+}
+
+// Start publishes the endpoints periodically
+func (r *Registry) Start() error {
+	r.logger.Infof("Publishing the network endpoints of node %s every %s", r.node, r.interval)
+	if err := r.Sync(); err != nil {
+		r.logger.WithError(err).Warn("Failed to publish network endpoints")
+	}
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			r.heartbeat.Beat()
+			if err := r.Sync(); err != nil {
+				r.logger.WithError(err).Warn("Failed to publish network endpoints")
+			}
+
+		case <-r.ctx.Done():
+			r.logger.Info("Stopping network endpoint registry")
+			return nil
+		}
+	}
+}
+
+// Sync publishes the current interfaces of the node, then resolves the
+// connections referencing them
+func (r *Registry) Sync() error {
+	ifaces, err := r.interfaces()
+	if err != nil {
+		return fmt.Errorf("failed to list the interfaces: %w", err)
+	}
+	endpoints := Build(r.node, ifaces)
+	if err := r.publish(endpoints); err != nil {
+		return err
+	}
+	return r.resolve(endpoints)
+}
+
+// publish creates and updates the endpoints of the node, deleting those of
+// interfaces gone
+func (r *Registry) publish(endpoints []nsmv1.NetworkEndpoint) error {
+	var existing nsmv1.NetworkEndpointList
+	if err := r.client.List(r.ctx, &existing, client.MatchingLabels{nsmv1.LabelEndpointNode: r.node}); err != nil {
+		return fmt.Errorf("failed to list network endpoints: %w", err)
+	}
+	published := make(map[string]*nsmv1.NetworkEndpoint, len(existing.Items))
+	for i := range existing.Items {
+		published[existing.Items[i].Name] = &existing.Items[i]
+	}
+
+	for i := range endpoints {
+		ep := &endpoints[i]
+		current, ok := published[ep.Name]
+		delete(published, ep.Name)
+		if !ok {
+			if err := r.client.Create(r.ctx, ep); err != nil {
+				return fmt.Errorf("failed to create network endpoint %s: %w", ep.Name, err)
+			}
+			r.logger.Infof("Published network endpoint %s", ep.Name)
+			continue
+		}
+		if equality.Semantic.DeepEqual(current.Spec, ep.Spec) {
+			continue
+		}
+		current.Spec = ep.Spec
+		if err := r.client.Update(r.ctx, current); err != nil {
+			return fmt.Errorf("failed to update network endpoint %s: %w", ep.Name, err)
+		}
+		r.logger.Debugf("Updated network endpoint %s", ep.Name)
+	}
+
+	for name, ep := range published {
+		if err := r.client.Delete(r.ctx, ep); client.IgnoreNotFound(err) != nil {
+			return fmt.Errorf("failed to delete network endpoint %s: %w", name, err)
+		}
+		r.logger.Infof("Deleted network endpoint %s, its interface is gone", name)
+	}
+	return nil
+}
+
+// resolve fills in the address of the endpoints of the node as the source
+// or destination of the connections referencing them. An endpoint without
+// an address leaves them empty, holding the connections pending.
+func (r *Registry) resolve(endpoints []nsmv1.NetworkEndpoint) error {
+	addresses := make(map[string]string, len(endpoints))
+	for i := range endpoints {
+		addresses[endpoints[i].Name] = Address(&endpoints[i])
+	}
+
+	var conns nsmv1.NetworkConnectionList
+	if err := r.client.List(r.ctx, &conns); err != nil {
+		return fmt.Errorf("failed to list network connections: %w", err)
+	}
+	for i := range conns.Items {
+		conn := &conns.Items[i]
+		patch := client.MergeFrom(conn.DeepCopy())
+		changed := false
+		if address, ok := addresses[conn.Spec.SourceEndpoint]; ok && conn.Spec.Source != address {
+			conn.Spec.Source = address
+			changed = true
+		}
+		if address, ok := addresses[conn.Spec.DestinationEndpoint]; ok && conn.Spec.Destination != address {
+			conn.Spec.Destination = address
+			changed = true
+		}
+		if !changed {
+			continue
+		}
+		if err := r.client.Patch(r.ctx, conn, patch); err != nil {
+			return fmt.Errorf("failed to resolve the endpoints of connection %s/%s: %w", conn.Namespace, conn.Name, err)
+		}
+		r.logger.Infof("Resolved the endpoints of connection %s/%s to %s -> %s",
+			conn.Namespace, conn.Name, conn.Spec.Source, conn.Spec.Destination)
+	}
+	return nil
+}
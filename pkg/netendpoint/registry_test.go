@@ -0,0 +1,93 @@
+package netendpoint
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestRegistrySync(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := nsmv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	published := func(node, iface string) *nsmv1.NetworkEndpoint {
+		return &Build(node, []Interface{{Name: iface}})[0]
+	}
+	conn := &nsmv1.NetworkConnection{
+		ObjectMeta: metav1.ObjectMeta{Name: "plc", Namespace: "edge"},
+		Spec: nsmv1.NetworkConnectionSpec{
+			SourceEndpoint:      "edge-1.eth1",
+			DestinationEndpoint: "edge-2.eth0",
+			ConnectionType:      nsmv1.ConnectionTypeKernel,
+		},
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		published("edge-1", "eth1"),
+		published("edge-1", "eth9"),
+		published("edge-2", "eth0"),
+		conn,
+	).Build()
+
+	ifaces := []Interface{
+		{Name: "eth0", Capabilities: []string{nsmv1.EndpointCapabilityKernel}},
+		{Name: "eth1", IPs: []string{"192.0.2.10"}, Capabilities: []string{nsmv1.EndpointCapabilityKernel}, Up: true},
+	}
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	ctx := context.Background()
+	r := NewRegistry(ctx, c, logger, "edge-1", func() ([]Interface, error) { return ifaces, nil }, 0)
+	if err := r.Sync(); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+
+	var endpoints nsmv1.NetworkEndpointList
+	if err := c.List(ctx, &endpoints); err != nil {
+		t.Fatal(err)
+	}
+	names := map[string]nsmv1.NetworkEndpointSpec{}
+	for _, ep := range endpoints.Items {
+		names[ep.Name] = ep.Spec
+	}
+	// eth0 published, eth1 updated, eth9 gone, the other node's kept
+	if len(names) != 3 {
+		t.Errorf("endpoints = %v, want edge-1.eth0, edge-1.eth1 and edge-2.eth0", names)
+	}
+	if spec := names["edge-1.eth1"]; len(spec.IPs) != 1 || !spec.Up {
+		t.Errorf("edge-1.eth1 = %+v, want its address and up", spec)
+	}
+	for _, name := range []string{"edge-1.eth0", "edge-2.eth0"} {
+		if _, ok := names[name]; !ok {
+			t.Errorf("endpoint %s missing", name)
+		}
+	}
+
+	// only the endpoint of the node is resolved, the other node's registry
+	// resolves the destination
+	var got nsmv1.NetworkConnection
+	if err := c.Get(ctx, client.ObjectKeyFromObject(conn), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Spec.Source != "192.0.2.10" || got.Spec.Destination != "" {
+		t.Errorf("connection endpoints = %q -> %q, want 192.0.2.10 -> unresolved", got.Spec.Source, got.Spec.Destination)
+	}
+
+	// an address lost holds the connection until it's back
+	ifaces[1].IPs = nil
+	if err := r.Sync(); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if err := c.Get(ctx, client.ObjectKeyFromObject(conn), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Spec.Source != "" {
+		t.Errorf("source = %q after the address was lost", got.Spec.Source)
+	}
+}
@@ -0,0 +1,489 @@
+package netutil
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Fake is an in-memory Interface for unit tests
+type Fake struct {
+	// Links by name
+	Links map[string]Link
+	// Addresses by link name
+	Addrs map[string][]string
+	// Installed routes
+	Routes []Route
+	// VFs by PF name
+	VFs map[string][]VF
+	// MAC addresses by link name
+	MACs map[string]string
+	// Associations by destination and SPI (e.g., "10.0.0.2/0x1234")
+	XfrmStates map[string]XfrmState
+	// Entries of the forwarding databases
+	FDB []FDBEntry
+	// Network namespaces by path, added with AddNetns
+	Namespaces map[string]*Fake
+	// Errors returned by the named operations (e.g., "LinkAdd")
+	Errors map[string]error
+	// Host namespace of the fake of a network namespace, nil on the host
+	host *Fake
+	// Mutex for protecting the state
+	mu sync.Mutex
+}
+
+// NewFake creates an empty fake
+func NewFake() *Fake {
+	return &Fake{
+		Links:      make(map[string]Link),
+		Addrs:      make(map[string][]string),
+		VFs:        make(map[string][]VF),
+		MACs:       make(map[string]string),
+		XfrmStates: make(map[string]XfrmState),
+		Namespaces: make(map[string]*Fake),
+		Errors:     make(map[string]error),
+	}
+}
+
+// AddNetns creates the fake of the network namespace at a path
+func (f *Fake) AddNetns(path string) *Fake {
+	ns := NewFake()
+	ns.host = f
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Namespaces[path] = ns
+	return ns
+}
+
+// LinkByName implements Interface
+func (f *Fake) LinkByName(name string) (Link, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.Errors["LinkByName"]; err != nil {
+		return Link{}, err
+	}
+	link, ok := f.Links[name]
+	if !ok {
+		return Link{}, fmt.Errorf("link %s: %w", name, ErrNotFound)
+	}
+	return link, nil
+}
+
+// LinkAdd implements Interface
+func (f *Fake) LinkAdd(link Link) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.Errors["LinkAdd"]; err != nil {
+		return err
+	}
+	if _, ok := f.Links[link.Name]; ok {
+		return fmt.Errorf("link %s already exists", link.Name)
+	}
+	if link.Parent != "" {
+		if _, ok := f.Links[link.Parent]; !ok {
+			return fmt.Errorf("parent of %s: %w", link.Name, ErrNotFound)
+		}
+	}
+	f.Links[link.Name] = link
+	if link.Type == "veth" && link.PeerName != "" {
+		f.Links[link.PeerName] = Link{Name: link.PeerName, Type: "veth", PeerName: link.Name, MTU: link.MTU}
+	}
+	return nil
+}
+
+// LinkDel implements Interface
+func (f *Fake) LinkDel(name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.Errors["LinkDel"]; err != nil {
+		return err
+	}
+	link, ok := f.Links[name]
+	if !ok {
+		return fmt.Errorf("link %s: %w", name, ErrNotFound)
+	}
+	delete(f.Links, name)
+	delete(f.Addrs, name)
+	if link.Type == "veth" {
+		delete(f.Links, link.PeerName)
+	}
+
+	// the kernel removes the routes through a deleted link
+	routes := f.Routes[:0]
+	for _, r := range f.Routes {
+		if r.Device != name {
+			routes = append(routes, r)
+		}
+	}
+	f.Routes = routes
+	return nil
+}
+
+// LinkSetMTU implements Interface
+func (f *Fake) LinkSetMTU(name string, mtu int) error {
+	return f.updateLink("LinkSetMTU", name, func(l *Link) { l.MTU = mtu })
+}
+
+// LinkSetUp implements Interface
+func (f *Fake) LinkSetUp(name string) error {
+	return f.updateLink("LinkSetUp", name, func(l *Link) { l.Up = true })
+}
+
+// LinkSetDown implements Interface
+func (f *Fake) LinkSetDown(name string) error {
+	return f.updateLink("LinkSetDown", name, func(l *Link) { l.Up = false })
+}
+
+// AddrList implements Interface
+func (f *Fake) AddrList(device string) ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.Links[device]; !ok {
+		return nil, fmt.Errorf("link %s: %w", device, ErrNotFound)
+	}
+	return append([]string(nil), f.Addrs[device]...), nil
+}
+
+// AddrAdd implements Interface
+func (f *Fake) AddrAdd(device, cidr string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.Errors["AddrAdd"]; err != nil {
+		return err
+	}
+	if _, ok := f.Links[device]; !ok {
+		return fmt.Errorf("link %s: %w", device, ErrNotFound)
+	}
+	for _, addr := range f.Addrs[device] {
+		if addr == cidr {
+			return fmt.Errorf("address %s already assigned to %s", cidr, device)
+		}
+	}
+	f.Addrs[device] = append(f.Addrs[device], cidr)
+	return nil
+}
+
+// AddrDel implements Interface
+func (f *Fake) AddrDel(device, cidr string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	addrs := f.Addrs[device]
+	for i, addr := range addrs {
+		if addr == cidr {
+			f.Addrs[device] = append(addrs[:i:i], addrs[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("address %s on %s: %w", cidr, device, ErrNotFound)
+}
+
+// RouteList implements Interface
+func (f *Fake) RouteList(destination string) ([]Route, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var routes []Route
+	for _, r := range f.Routes {
+		if r.Destination == destination {
+			routes = append(routes, r)
+		}
+	}
+	return routes, nil
+}
+
+// RouteReplace implements Interface
+func (f *Fake) RouteReplace(route Route) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.Errors["RouteReplace"]; err != nil {
+		return err
+	}
+	if _, ok := f.Links[route.Device]; route.Device != "" && !ok {
+		return fmt.Errorf("link %s: %w", route.Device, ErrNotFound)
+	}
+	for _, hop := range route.Nexthops {
+		if _, ok := f.Links[hop.Device]; !ok {
+			return fmt.Errorf("link %s: %w", hop.Device, ErrNotFound)
+		}
+	}
+	for i, r := range f.Routes {
+		if r.Destination == route.Destination && r.Metric == route.Metric && r.Table == route.Table {
+			f.Routes[i] = route
+			return nil
+		}
+	}
+	f.Routes = append(f.Routes, route)
+	return nil
+}
+
+// RouteDel implements Interface
+func (f *Fake) RouteDel(route Route) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for i, r := range f.Routes {
+		if r.Destination == route.Destination && r.Metric == route.Metric && r.Table == route.Table {
+			f.Routes = append(f.Routes[:i:i], f.Routes[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("route to %s: %w", route.Destination, ErrNotFound)
+}
+
+// LinkSetName implements Interface
+func (f *Fake) LinkSetName(name, newName string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.Errors["LinkSetName"]; err != nil {
+		return err
+	}
+	link, ok := f.Links[name]
+	if !ok {
+		return fmt.Errorf("link %s: %w", name, ErrNotFound)
+	}
+	if _, ok := f.Links[newName]; ok && newName != name {
+		return fmt.Errorf("link %s already exists", newName)
+	}
+	delete(f.Links, name)
+	link.Name = newName
+	f.Links[newName] = link
+	if addrs, ok := f.Addrs[name]; ok {
+		delete(f.Addrs, name)
+		f.Addrs[newName] = addrs
+	}
+	if mac, ok := f.MACs[name]; ok {
+		delete(f.MACs, name)
+		f.MACs[newName] = mac
+	}
+	for i := range f.Routes {
+		if f.Routes[i].Device == name {
+			f.Routes[i].Device = newName
+		}
+	}
+	return nil
+}
+
+// LinkSetHardwareAddr implements Interface
+func (f *Fake) LinkSetHardwareAddr(name, mac string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.Errors["LinkSetHardwareAddr"]; err != nil {
+		return err
+	}
+	if _, ok := f.Links[name]; !ok {
+		return fmt.Errorf("link %s: %w", name, ErrNotFound)
+	}
+	f.MACs[name] = mac
+	return nil
+}
+
+// LinkSetNetns implements Interface. Like the kernel, it takes the link
+// down and drops its routes, the addresses move with it.
+func (f *Fake) LinkSetNetns(name, path string) error {
+	target := f.host
+	if path != "" {
+		root := f
+		if f.host != nil {
+			root = f.host
+		}
+		root.mu.Lock()
+		target = root.Namespaces[path]
+		root.mu.Unlock()
+		if target == nil {
+			return fmt.Errorf("network namespace %s: %w", path, ErrNotFound)
+		}
+	}
+	if target == nil || target == f {
+		return nil
+	}
+
+	f.mu.Lock()
+	if err := f.Errors["LinkSetNetns"]; err != nil {
+		f.mu.Unlock()
+		return err
+	}
+	link, ok := f.Links[name]
+	if !ok {
+		f.mu.Unlock()
+		return fmt.Errorf("link %s: %w", name, ErrNotFound)
+	}
+	addrs, mac := f.Addrs[name], f.MACs[name]
+	delete(f.Links, name)
+	delete(f.Addrs, name)
+	delete(f.MACs, name)
+	routes := f.Routes[:0]
+	for _, r := range f.Routes {
+		if r.Device != name {
+			routes = append(routes, r)
+		}
+	}
+	f.Routes = routes
+	f.mu.Unlock()
+
+	target.mu.Lock()
+	defer target.mu.Unlock()
+	link.Up = false
+	target.Links[name] = link
+	if addrs != nil {
+		target.Addrs[name] = addrs
+	}
+	if mac != "" {
+		target.MACs[name] = mac
+	}
+	return nil
+}
+
+// LinkRouteList implements Interface
+func (f *Fake) LinkRouteList(device string) ([]Route, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.Links[device]; !ok {
+		return nil, fmt.Errorf("link %s: %w", device, ErrNotFound)
+	}
+	var routes []Route
+	for _, r := range f.Routes {
+		if r.Device == device {
+			routes = append(routes, r)
+		}
+	}
+	return routes, nil
+}
+
+// VFList implements Interface
+func (f *Fake) VFList(pf string) ([]VF, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.Errors["VFList"]; err != nil {
+		return nil, err
+	}
+	if _, ok := f.Links[pf]; !ok {
+		return nil, fmt.Errorf("link %s: %w", pf, ErrNotFound)
+	}
+	return append([]VF(nil), f.VFs[pf]...), nil
+}
+
+// VFSetMAC implements Interface
+func (f *Fake) VFSetMAC(pf string, vf int, mac string) error {
+	if mac == "00:00:00:00:00:00" {
+		mac = ""
+	}
+	return f.updateVF("VFSetMAC", pf, vf, func(v *VF) { v.MAC = mac })
+}
+
+// VFSetVLAN implements Interface
+func (f *Fake) VFSetVLAN(pf string, vf, vlan, qos int) error {
+	return f.updateVF("VFSetVLAN", pf, vf, func(v *VF) { v.VLAN, v.QoS = vlan, qos })
+}
+
+// VFSetSpoofCheck implements Interface
+func (f *Fake) VFSetSpoofCheck(pf string, vf int, on bool) error {
+	return f.updateVF("VFSetSpoofCheck", pf, vf, func(v *VF) { v.SpoofCheck = on })
+}
+
+// VFSetTrust implements Interface
+func (f *Fake) VFSetTrust(pf string, vf int, on bool) error {
+	return f.updateVF("VFSetTrust", pf, vf, func(v *VF) { v.Trust = on })
+}
+
+// VFSetRate implements Interface
+func (f *Fake) VFSetRate(pf string, vf, minMbps, maxMbps int) error {
+	return f.updateVF("VFSetRate", pf, vf, func(v *VF) { v.MinTxRateMbps, v.MaxTxRateMbps = minMbps, maxMbps })
+}
+
+// XfrmStateAdd implements Interface
+func (f *Fake) XfrmStateAdd(state XfrmState) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.Errors["XfrmStateAdd"]; err != nil {
+		return err
+	}
+	key := fmt.Sprintf("%s/%#x", state.Dst, state.SPI)
+	if _, ok := f.XfrmStates[key]; ok {
+		return fmt.Errorf("xfrm state %s already exists", key)
+	}
+	f.XfrmStates[key] = state
+	return nil
+}
+
+// FDBList implements Interface
+func (f *Fake) FDBList() ([]FDBEntry, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.Errors["FDBList"]; err != nil {
+		return nil, err
+	}
+	return append([]FDBEntry(nil), f.FDB...), nil
+}
+
+// Netns implements Interface, returning the fake added with AddNetns
+func (f *Fake) Netns(path string) (Interface, error) {
+	root := f
+	if f.host != nil {
+		root = f.host
+	}
+	root.mu.Lock()
+	defer root.mu.Unlock()
+
+	if err := root.Errors["Netns"]; err != nil {
+		return nil, err
+	}
+	ns, ok := root.Namespaces[path]
+	if !ok {
+		return nil, fmt.Errorf("network namespace %s: %w", path, ErrNotFound)
+	}
+	return ns, nil
+}
+
+// Close implements Interface
+func (f *Fake) Close() error {
+	return nil
+}
+
+// updateVF changes a VF of a PF in place
+func (f *Fake) updateVF(op, pf string, id int, update func(*VF)) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.Errors[op]; err != nil {
+		return err
+	}
+	if _, ok := f.Links[pf]; !ok {
+		return fmt.Errorf("link %s: %w", pf, ErrNotFound)
+	}
+	for i := range f.VFs[pf] {
+		if f.VFs[pf][i].ID == id {
+			update(&f.VFs[pf][i])
+			return nil
+		}
+	}
+	return fmt.Errorf("VF %d of %s: %w", id, pf, ErrNotFound)
+}
+
+// updateLink changes a link in place
+func (f *Fake) updateLink(op, name string, update func(*Link)) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.Errors[op]; err != nil {
+		return err
+	}
+	link, ok := f.Links[name]
+	if !ok {
+		return fmt.Errorf("link %s: %w", name, ErrNotFound)
+	}
+	update(&link)
+	f.Links[name] = link
+	return nil
+}
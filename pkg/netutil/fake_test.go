@@ -0,0 +1,190 @@
+package netutil
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestFakeLinks(t *testing.T) {
+	f := NewFake()
+
+	if _, err := f.LinkByName("veth0"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("LinkByName() error = %v, want ErrNotFound", err)
+	}
+	if err := f.LinkAdd(Link{Name: "mv0", Type: "macvlan", Parent: "eth0"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("macvlan without parent error = %v, want ErrNotFound", err)
+	}
+
+	if err := f.LinkAdd(Link{Name: "veth0", Type: "veth", PeerName: "veth1"}); err != nil {
+		t.Fatalf("LinkAdd() error = %v", err)
+	}
+	if _, err := f.LinkByName("veth1"); err != nil {
+		t.Errorf("veth peer not created: %v", err)
+	}
+	if err := f.LinkAdd(Link{Name: "veth0", Type: "veth"}); err == nil {
+		t.Errorf("duplicate link accepted")
+	}
+
+	if err := f.LinkSetMTU("veth0", 9000); err != nil {
+		t.Fatalf("LinkSetMTU() error = %v", err)
+	}
+	if err := f.LinkSetUp("veth0"); err != nil {
+		t.Fatalf("LinkSetUp() error = %v", err)
+	}
+	if link, _ := f.LinkByName("veth0"); link.MTU != 9000 || !link.Up {
+		t.Errorf("unexpected link %+v", link)
+	}
+
+	f.Errors["LinkSetDown"] = errors.New("EPERM")
+	if err := f.LinkSetDown("veth0"); err == nil {
+		t.Errorf("injected error not returned")
+	}
+}
+
+func TestFakeAddrsAndRoutes(t *testing.T) {
+	f := NewFake()
+	if err := f.LinkAdd(Link{Name: "nsm0", Type: "dummy"}); err != nil {
+		t.Fatalf("LinkAdd() error = %v", err)
+	}
+
+	if err := f.AddrAdd("nsm0", "10.0.0.1/24"); err != nil {
+		t.Fatalf("AddrAdd() error = %v", err)
+	}
+	if err := f.AddrAdd("nsm0", "10.0.0.1/24"); err == nil {
+		t.Errorf("duplicate address accepted")
+	}
+	if err := f.RouteReplace(Route{Destination: "10.1.0.0/24", Device: "missing"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("route via missing link error = %v, want ErrNotFound", err)
+	}
+
+	route := Route{Destination: "10.1.0.0/24", Device: "nsm0", Gateway: "10.0.0.2", Metric: 100}
+	if err := f.RouteReplace(route); err != nil {
+		t.Fatalf("RouteReplace() error = %v", err)
+	}
+	route.Gateway = "10.0.0.3"
+	if err := f.RouteReplace(route); err != nil {
+		t.Fatalf("RouteReplace() error = %v", err)
+	}
+	if routes, _ := f.RouteList("10.1.0.0/24"); len(routes) != 1 || routes[0].Gateway != "10.0.0.3" {
+		t.Errorf("route not replaced: %+v", routes)
+	}
+
+	// routes of other tables and multipath routes are routes of their own
+	if err := f.RouteReplace(Route{Destination: "10.1.0.0/24", Metric: 100, Table: 4096, Nexthops: []Nexthop{{Device: "missing"}}}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("nexthop via missing link error = %v, want ErrNotFound", err)
+	}
+	if err := f.RouteReplace(Route{Destination: "10.1.0.0/24", Metric: 100, Table: 4096, Nexthops: []Nexthop{{Device: "nsm0", Weight: 2}}}); err != nil {
+		t.Fatalf("RouteReplace() error = %v", err)
+	}
+	if routes, _ := f.RouteList("10.1.0.0/24"); len(routes) != 2 || routes[0].Gateway != "10.0.0.3" {
+		t.Errorf("route of another table replaced the main one: %+v", routes)
+	}
+	if err := f.RouteDel(Route{Destination: "10.1.0.0/24", Metric: 100, Table: 4096}); err != nil {
+		t.Fatalf("RouteDel() error = %v", err)
+	}
+
+	// deleting the link removes its addresses and routes
+	if err := f.LinkDel("nsm0"); err != nil {
+		t.Fatalf("LinkDel() error = %v", err)
+	}
+	if routes, _ := f.RouteList("10.1.0.0/24"); len(routes) != 0 {
+		t.Errorf("routes left after link removal: %+v", routes)
+	}
+	if err := f.AddrDel("nsm0", "10.0.0.1/24"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("AddrDel() error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestFakeVFs(t *testing.T) {
+	f := NewFake()
+	f.Links["eth0"] = Link{Name: "eth0", Type: "device"}
+	f.VFs["eth0"] = []VF{{ID: 0}, {ID: 1, SpoofCheck: true}}
+
+	if err := f.VFSetMAC("eth0", 1, "02:00:00:00:00:01"); err != nil {
+		t.Fatalf("VFSetMAC() error = %v", err)
+	}
+	if err := f.VFSetVLAN("eth0", 1, 100, 3); err != nil {
+		t.Fatalf("VFSetVLAN() error = %v", err)
+	}
+	if err := f.VFSetRate("eth0", 1, 0, 1000); err != nil {
+		t.Fatalf("VFSetRate() error = %v", err)
+	}
+	if err := f.VFSetTrust("eth0", 2, true); !errors.Is(err, ErrNotFound) {
+		t.Errorf("VFSetTrust() of a missing VF error = %v, want ErrNotFound", err)
+	}
+	vfs, err := f.VFList("eth0")
+	if err != nil {
+		t.Fatalf("VFList() error = %v", err)
+	}
+	want := VF{ID: 1, MAC: "02:00:00:00:00:01", VLAN: 100, QoS: 3, SpoofCheck: true, MaxTxRateMbps: 1000}
+	if len(vfs) != 2 || vfs[1] != want {
+		t.Errorf("VFList() = %+v, want VF 1 %+v", vfs, want)
+	}
+
+	// the zero address clears the MAC
+	if err := f.VFSetMAC("eth0", 1, "00:00:00:00:00:00"); err != nil {
+		t.Fatalf("VFSetMAC() error = %v", err)
+	}
+	if vfs, _ := f.VFList("eth0"); vfs[1].MAC != "" {
+		t.Errorf("MAC %s not cleared", vfs[1].MAC)
+	}
+}
+
+func TestFakeNetns(t *testing.T) {
+	f := NewFake()
+	pod := f.AddNetns("/var/run/netns/pod")
+	f.Links["eth5"] = Link{Name: "eth5", Type: "device", Up: true}
+	f.Addrs["eth5"] = []string{"10.0.0.5/24"}
+	f.Routes = []Route{{Destination: "default", Device: "eth5", Gateway: "10.0.0.1"}}
+
+	if err := f.LinkSetNetns("eth5", "/var/run/netns/missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("LinkSetNetns() into a missing namespace error = %v, want ErrNotFound", err)
+	}
+	if err := f.LinkSetNetns("eth5", "/var/run/netns/pod"); err != nil {
+		t.Fatalf("LinkSetNetns() error = %v", err)
+	}
+	if _, err := f.LinkByName("eth5"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("link left on the host: %v", err)
+	}
+	if len(f.Routes) != 0 {
+		t.Errorf("routes of the moved link left on the host: %+v", f.Routes)
+	}
+
+	ns, err := f.Netns("/var/run/netns/pod")
+	if err != nil {
+		t.Fatalf("Netns() error = %v", err)
+	}
+	defer ns.Close()
+	if ns != pod {
+		t.Fatalf("Netns() returned another namespace")
+	}
+	if err := ns.LinkSetName("eth5", "net1"); err != nil {
+		t.Fatalf("LinkSetName() error = %v", err)
+	}
+	if addrs, _ := ns.AddrList("net1"); len(addrs) != 1 {
+		t.Errorf("addresses didn't move with the link: %v", addrs)
+	}
+	if link, _ := ns.LinkByName("net1"); link.Up {
+		t.Errorf("moved link still up")
+	}
+
+	// an empty path moves the link back to the host
+	if err := ns.LinkSetNetns("net1", ""); err != nil {
+		t.Fatalf("LinkSetNetns() to the host error = %v", err)
+	}
+	if _, err := f.LinkByName("net1"); err != nil {
+		t.Errorf("link not back on the host: %v", err)
+	}
+}
+
+func TestLinkModes(t *testing.T) {
+	if _, err := macvlanMode("passthru"); err != nil {
+		t.Errorf("macvlanMode(passthru) error = %v", err)
+	}
+	if _, err := macvlanMode("bogus"); err == nil {
+		t.Errorf("expected error for unknown macvlan mode")
+	}
+	if _, err := ipvlanMode("l3s"); err != nil {
+		t.Errorf("ipvlanMode(l3s) error = %v", err)
+	}
+}
@@ -0,0 +1,569 @@
+package netutil
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+	"golang.org/x/sys/unix"
+)
+
+// ID of the main routing table
+const mainTable = 254
+
+// Netlink is the Interface backed by the kernel through vishvananda/netlink
+type Netlink struct {
+	// Netlink socket of the network namespace, the zero handle works in
+	// the namespace of the process
+	h *netlink.Handle
+	// Network namespace of the handle, closed with it
+	ns netns.NsHandle
+}
+
+// NewNetlink creates a new netlink backed Interface
+func NewNetlink() *Netlink {
+	return &Netlink{h: &netlink.Handle{}, ns: -1}
+}
+
+// LinkByName implements Interface
+func (n *Netlink) LinkByName(name string) (Link, error) {
+	l, err := n.link(name)
+	if err != nil {
+		return Link{}, err
+	}
+
+	attrs := l.Attrs()
+	link := Link{
+		Name: attrs.Name,
+		Type: l.Type(),
+		MTU:  attrs.MTU,
+		Up:   attrs.Flags&net.FlagUp != 0,
+	}
+	if attrs.ParentIndex > 0 {
+		if parent, err := n.h.LinkByIndex(attrs.ParentIndex); err == nil {
+			link.Parent = parent.Attrs().Name
+		}
+	}
+	if vlan, ok := l.(*netlink.Vlan); ok {
+		link.VLAN = vlan.VlanId
+		if vlan.VlanProtocol != netlink.VLAN_PROTOCOL_UNKNOWN {
+			link.VLANProtocol = vlan.VlanProtocol.String()
+		}
+	}
+	if vxlan, ok := l.(*netlink.Vxlan); ok {
+		link.VNI = vxlan.VxlanId
+		link.Port = vxlan.Port
+		if vxlan.Group != nil {
+			link.Remote = vxlan.Group.String()
+		}
+	}
+	return link, nil
+}
+
+// LinkAdd implements Interface
+func (n *Netlink) LinkAdd(link Link) error {
+	attrs := netlink.NewLinkAttrs()
+	attrs.Name = link.Name
+	attrs.MTU = link.MTU
+	if link.Parent != "" {
+		parent, err := n.link(link.Parent)
+		if err != nil {
+			return fmt.Errorf("parent of %s: %w", link.Name, err)
+		}
+		attrs.ParentIndex = parent.Attrs().Index
+	}
+
+	var l netlink.Link
+	switch link.Type {
+	case "dummy":
+		l = &netlink.Dummy{LinkAttrs: attrs}
+	case "veth":
+		l = &netlink.Veth{LinkAttrs: attrs, PeerName: link.PeerName}
+	case "vxlan":
+		vxlan := &netlink.Vxlan{LinkAttrs: attrs, VxlanId: link.VNI, Port: link.Port, VtepDevIndex: attrs.ParentIndex}
+		vxlan.ParentIndex = 0
+		if link.Remote != "" {
+			vxlan.Group = net.ParseIP(link.Remote)
+		}
+		l = vxlan
+	case "vlan":
+		l = &netlink.Vlan{LinkAttrs: attrs, VlanId: link.VLAN, VlanProtocol: netlink.StringToVlanProtocol(link.VLANProtocol)}
+	case "macvlan":
+		mode, err := macvlanMode(link.Mode)
+		if err != nil {
+			return err
+		}
+		l = &netlink.Macvlan{LinkAttrs: attrs, Mode: mode}
+	case "ipvlan":
+		mode, err := ipvlanMode(link.Mode)
+		if err != nil {
+			return err
+		}
+		l = &netlink.IPVlan{LinkAttrs: attrs, Mode: mode}
+	case "wireguard":
+		l = &netlink.Wireguard{LinkAttrs: attrs}
+	default:
+		l = &netlink.GenericLink{LinkAttrs: attrs, LinkType: link.Type}
+	}
+
+	if err := n.h.LinkAdd(l); err != nil {
+		return fmt.Errorf("failed to add link %s: %w", link.Name, err)
+	}
+	return nil
+}
+
+// LinkDel implements Interface
+func (n *Netlink) LinkDel(name string) error {
+	l, err := n.link(name)
+	if err != nil {
+		return err
+	}
+	return n.h.LinkDel(l)
+}
+
+// LinkSetMTU implements Interface
+func (n *Netlink) LinkSetMTU(name string, mtu int) error {
+	l, err := n.link(name)
+	if err != nil {
+		return err
+	}
+	return n.h.LinkSetMTU(l, mtu)
+}
+
+// LinkSetUp implements Interface
+func (n *Netlink) LinkSetUp(name string) error {
+	l, err := n.link(name)
+	if err != nil {
+		return err
+	}
+	return n.h.LinkSetUp(l)
+}
+
+// LinkSetDown implements Interface
+func (n *Netlink) LinkSetDown(name string) error {
+	l, err := n.link(name)
+	if err != nil {
+		return err
+	}
+	return n.h.LinkSetDown(l)
+}
+
+// AddrList implements Interface
+func (n *Netlink) AddrList(device string) ([]string, error) {
+	l, err := n.link(device)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := n.h.AddrList(l, netlink.FAMILY_ALL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list addresses of %s: %w", device, err)
+	}
+
+	cidrs := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		cidrs = append(cidrs, addr.IPNet.String())
+	}
+	return cidrs, nil
+}
+
+// AddrAdd implements Interface
+func (n *Netlink) AddrAdd(device, cidr string) error {
+	l, addr, err := n.addr(device, cidr)
+	if err != nil {
+		return err
+	}
+	return n.h.AddrAdd(l, addr)
+}
+
+// AddrDel implements Interface
+func (n *Netlink) AddrDel(device, cidr string) error {
+	l, addr, err := n.addr(device, cidr)
+	if err != nil {
+		return err
+	}
+	return n.h.AddrDel(l, addr)
+}
+
+// RouteList implements Interface
+func (n *Netlink) RouteList(destination string) ([]Route, error) {
+	_, dst, err := net.ParseCIDR(destination)
+	if err != nil {
+		return nil, fmt.Errorf("invalid destination %s: %w", destination, err)
+	}
+
+	// table 0 with the table filter lists the routes of all tables
+	routes, err := n.h.RouteListFiltered(netlink.FAMILY_ALL, &netlink.Route{Dst: dst}, netlink.RT_FILTER_DST|netlink.RT_FILTER_TABLE)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list routes to %s: %w", destination, err)
+	}
+
+	result := make([]Route, 0, len(routes))
+	for _, r := range routes {
+		route := Route{Destination: destination, Metric: r.Priority}
+		if r.Table != mainTable {
+			route.Table = r.Table
+		}
+		if r.Gw != nil {
+			route.Gateway = r.Gw.String()
+		}
+		if l, err := n.h.LinkByIndex(r.LinkIndex); err == nil {
+			route.Device = l.Attrs().Name
+		}
+		for _, nh := range r.MultiPath {
+			hop := Nexthop{Weight: nh.Hops + 1}
+			if nh.Gw != nil {
+				hop.Gateway = nh.Gw.String()
+			}
+			if l, err := n.h.LinkByIndex(nh.LinkIndex); err == nil {
+				hop.Device = l.Attrs().Name
+			}
+			route.Nexthops = append(route.Nexthops, hop)
+		}
+		result = append(result, route)
+	}
+	return result, nil
+}
+
+// RouteReplace implements Interface
+func (n *Netlink) RouteReplace(route Route) error {
+	r, err := n.route(route)
+	if err != nil {
+		return err
+	}
+	return n.h.RouteReplace(r)
+}
+
+// RouteDel implements Interface
+func (n *Netlink) RouteDel(route Route) error {
+	r, err := n.route(route)
+	if err != nil {
+		return err
+	}
+	return n.h.RouteDel(r)
+}
+
+// LinkSetName implements Interface
+func (n *Netlink) LinkSetName(name, newName string) error {
+	l, err := n.link(name)
+	if err != nil {
+		return err
+	}
+	return n.h.LinkSetName(l, newName)
+}
+
+// LinkSetHardwareAddr implements Interface
+func (n *Netlink) LinkSetHardwareAddr(name, mac string) error {
+	hw, err := net.ParseMAC(mac)
+	if err != nil {
+		return fmt.Errorf("invalid MAC %s: %w", mac, err)
+	}
+	l, err := n.link(name)
+	if err != nil {
+		return err
+	}
+	return n.h.LinkSetHardwareAddr(l, hw)
+}
+
+// LinkSetNetns implements Interface
+func (n *Netlink) LinkSetNetns(name, path string) error {
+	l, err := n.link(name)
+	if err != nil {
+		return err
+	}
+	var ns netns.NsHandle
+	if path == "" {
+		ns, err = netns.Get()
+	} else {
+		ns, err = netns.GetFromPath(path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open the network namespace %s: %w", path, err)
+	}
+	defer ns.Close()
+	return n.h.LinkSetNsFd(l, int(ns))
+}
+
+// LinkRouteList implements Interface
+func (n *Netlink) LinkRouteList(device string) ([]Route, error) {
+	l, err := n.link(device)
+	if err != nil {
+		return nil, err
+	}
+	routes, err := n.h.RouteList(l, netlink.FAMILY_ALL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list routes over %s: %w", device, err)
+	}
+
+	result := make([]Route, 0, len(routes))
+	for _, r := range routes {
+		if r.Protocol == unix.RTPROT_KERNEL {
+			continue
+		}
+		route := Route{Destination: "default", Device: device, Metric: r.Priority}
+		if r.Dst != nil {
+			route.Destination = r.Dst.String()
+		}
+		if r.Table != mainTable {
+			route.Table = r.Table
+		}
+		if r.Gw != nil {
+			route.Gateway = r.Gw.String()
+		}
+		result = append(result, route)
+	}
+	return result, nil
+}
+
+// VFList implements Interface
+func (n *Netlink) VFList(pf string) ([]VF, error) {
+	l, err := n.link(pf)
+	if err != nil {
+		return nil, err
+	}
+	vfs := make([]VF, 0, len(l.Attrs().Vfs))
+	for _, info := range l.Attrs().Vfs {
+		vf := VF{
+			ID:            info.ID,
+			VLAN:          info.Vlan,
+			QoS:           info.Qos,
+			SpoofCheck:    info.Spoofchk,
+			Trust:         info.Trust != 0,
+			MinTxRateMbps: int(info.MinTxRate),
+			MaxTxRateMbps: int(info.MaxTxRate),
+		}
+		if !isZeroMAC(info.Mac) {
+			vf.MAC = info.Mac.String()
+		}
+		vfs = append(vfs, vf)
+	}
+	return vfs, nil
+}
+
+// VFSetMAC implements Interface
+func (n *Netlink) VFSetMAC(pf string, vf int, mac string) error {
+	hw, err := net.ParseMAC(mac)
+	if err != nil {
+		return fmt.Errorf("invalid MAC %s of VF %d: %w", mac, vf, err)
+	}
+	l, err := n.link(pf)
+	if err != nil {
+		return err
+	}
+	return n.h.LinkSetVfHardwareAddr(l, vf, hw)
+}
+
+// VFSetVLAN implements Interface
+func (n *Netlink) VFSetVLAN(pf string, vf, vlan, qos int) error {
+	l, err := n.link(pf)
+	if err != nil {
+		return err
+	}
+	return n.h.LinkSetVfVlanQos(l, vf, vlan, qos)
+}
+
+// VFSetSpoofCheck implements Interface
+func (n *Netlink) VFSetSpoofCheck(pf string, vf int, on bool) error {
+	l, err := n.link(pf)
+	if err != nil {
+		return err
+	}
+	return n.h.LinkSetVfSpoofchk(l, vf, on)
+}
+
+// VFSetTrust implements Interface
+func (n *Netlink) VFSetTrust(pf string, vf int, on bool) error {
+	l, err := n.link(pf)
+	if err != nil {
+		return err
+	}
+	return n.h.LinkSetVfTrust(l, vf, on)
+}
+
+// VFSetRate implements Interface
+func (n *Netlink) VFSetRate(pf string, vf, minMbps, maxMbps int) error {
+	l, err := n.link(pf)
+	if err != nil {
+		return err
+	}
+	return n.h.LinkSetVfRate(l, vf, minMbps, maxMbps)
+}
+
+// XfrmStateAdd implements Interface
+func (n *Netlink) XfrmStateAdd(state XfrmState) error {
+	src, dst := net.ParseIP(state.Src), net.ParseIP(state.Dst)
+	if src == nil || dst == nil {
+		return fmt.Errorf("invalid xfrm state addresses %s and %s", state.Src, state.Dst)
+	}
+	err := n.h.XfrmStateAdd(&netlink.XfrmState{
+		Src:   src,
+		Dst:   dst,
+		Proto: netlink.XFRM_PROTO_ESP,
+		Mode:  netlink.XFRM_MODE_TRANSPORT,
+		Spi:   int(state.SPI),
+		Reqid: state.Reqid,
+		Aead:  &netlink.XfrmStateAlgo{Name: "rfc4106(gcm(aes))", Key: state.AEADKey, ICVLen: 128},
+	})
+	if err != nil {
+		// the error of the kernel doesn't carry the key
+		return fmt.Errorf("failed to add the xfrm state %s>%s spi %#x: %w", state.Src, state.Dst, state.SPI, err)
+	}
+	return nil
+}
+
+// Netns implements Interface
+func (n *Netlink) Netns(path string) (Interface, error) {
+	ns, err := netns.GetFromPath(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the network namespace %s: %w", path, err)
+	}
+	h, err := netlink.NewHandleAt(ns)
+	if err != nil {
+		ns.Close()
+		return nil, fmt.Errorf("failed to open netlink in %s: %w", path, err)
+	}
+	return &Netlink{h: h, ns: ns}, nil
+}
+
+// Close implements Interface
+func (n *Netlink) Close() error {
+	if n.ns < 0 {
+		return nil
+	}
+	n.h.Close()
+	return n.ns.Close()
+}
+
+// FDBList implements Interface
+func (n *Netlink) FDBList() ([]FDBEntry, error) {
+	links, err := n.h.LinkList()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list links: %w", err)
+	}
+	names := make(map[int]string, len(links))
+	for _, l := range links {
+		names[l.Attrs().Index] = l.Attrs().Name
+	}
+	neighs, err := n.h.NeighList(0, unix.AF_BRIDGE)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the forwarding databases: %w", err)
+	}
+	entries := make([]FDBEntry, 0, len(neighs))
+	for _, neigh := range neighs {
+		device, ok := names[neigh.LinkIndex]
+		if !ok {
+			continue
+		}
+		entries = append(entries, FDBEntry{
+			MAC:       neigh.HardwareAddr.String(),
+			Device:    device,
+			Master:    names[neigh.MasterIndex],
+			VLAN:      neigh.Vlan,
+			Permanent: neigh.State&(netlink.NUD_PERMANENT|netlink.NUD_NOARP) != 0,
+		})
+	}
+	return entries, nil
+}
+
+// link looks up a netlink link, mapping missing links to ErrNotFound
+func (n *Netlink) link(name string) (netlink.Link, error) {
+	l, err := n.h.LinkByName(name)
+	if err != nil {
+		var notFound netlink.LinkNotFoundError
+		if errors.As(err, &notFound) {
+			return nil, fmt.Errorf("link %s: %w", name, ErrNotFound)
+		}
+		return nil, fmt.Errorf("failed to get link %s: %w", name, err)
+	}
+	return l, nil
+}
+
+// addr resolves the link and parses the address
+func (n *Netlink) addr(device, cidr string) (netlink.Link, *netlink.Addr, error) {
+	l, err := n.link(device)
+	if err != nil {
+		return nil, nil, err
+	}
+	addr, err := netlink.ParseAddr(cidr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid address %s: %w", cidr, err)
+	}
+	return l, addr, nil
+}
+
+// route converts a Route to its netlink representation
+func (n *Netlink) route(route Route) (*netlink.Route, error) {
+	r := &netlink.Route{Priority: route.Metric, Table: route.Table}
+	// the default route has no destination, its family is the gateway's
+	if route.Destination != "default" {
+		_, dst, err := net.ParseCIDR(route.Destination)
+		if err != nil {
+			return nil, fmt.Errorf("invalid destination %s: %w", route.Destination, err)
+		}
+		r.Dst = dst
+	}
+	if route.Device != "" {
+		l, err := n.link(route.Device)
+		if err != nil {
+			return nil, err
+		}
+		r.LinkIndex = l.Attrs().Index
+	}
+	if route.Gateway != "" {
+		r.Gw = net.ParseIP(route.Gateway)
+	}
+	for _, hop := range route.Nexthops {
+		l, err := n.link(hop.Device)
+		if err != nil {
+			return nil, err
+		}
+		nh := &netlink.NexthopInfo{LinkIndex: l.Attrs().Index, Hops: max(hop.Weight, 1) - 1}
+		if hop.Gateway != "" {
+			nh.Gw = net.ParseIP(hop.Gateway)
+		}
+		r.MultiPath = append(r.MultiPath, nh)
+	}
+	return r, nil
+}
+
+// macvlanMode parses a macvlan mode, defaulting to bridge
+func macvlanMode(mode string) (netlink.MacvlanMode, error) {
+	switch strings.ToLower(mode) {
+	case "", "bridge":
+		return netlink.MACVLAN_MODE_BRIDGE, nil
+	case "private":
+		return netlink.MACVLAN_MODE_PRIVATE, nil
+	case "vepa":
+		return netlink.MACVLAN_MODE_VEPA, nil
+	case "passthru":
+		return netlink.MACVLAN_MODE_PASSTHRU, nil
+	default:
+		return 0, fmt.Errorf("unknown macvlan mode %s", mode)
+	}
+}
+
+// ipvlanMode parses an ipvlan mode, defaulting to l2
+func ipvlanMode(mode string) (netlink.IPVlanMode, error) {
+	switch strings.ToLower(mode) {
+	case "", "l2":
+		return netlink.IPVLAN_MODE_L2, nil
+	case "l3":
+		return netlink.IPVLAN_MODE_L3, nil
+	case "l3s":
+		return netlink.IPVLAN_MODE_L3S, nil
+	default:
+		return 0, fmt.Errorf("unknown ipvlan mode %s", mode)
+	}
+}
+
+// isZeroMAC reports whether a MAC address is all zeroes, i.e., unset
+func isZeroMAC(mac net.HardwareAddr) bool {
+	for _, b := range mac {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
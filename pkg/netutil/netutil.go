@@ -0,0 +1,172 @@
+package netutil
+
+import "errors"
+
+// ErrNotFound is returned for links, addresses or routes that don't exist
+var ErrNotFound = errors.New("not found")
+
+// Link is a network interface
+type Link struct {
+	// Name of the interface
+	Name string
+	// Link type (e.g., dummy, veth, vxlan, vlan, macvlan, ipvlan, wireguard)
+	Type string
+	// Parent interface of stacked links (vlan, macvlan, ipvlan, vxlan underlay)
+	Parent string
+	// Peer interface name (veth only)
+	PeerName string
+	// VXLAN network identifier (vxlan only)
+	VNI int
+	// Remote tunnel endpoint (vxlan only)
+	Remote string
+	// UDP destination port (vxlan only)
+	Port int
+	// Mode of macvlan (bridge, private, vepa, passthru) or ipvlan (l2, l3)
+	Mode string
+	// VLAN ID (vlan only)
+	VLAN int
+	// VLAN protocol, 802.1q (default) or 802.1ad for the outer tag of QinQ (vlan only)
+	VLANProtocol string
+	// MTU, 0 keeps the default
+	MTU int
+	// Whether the interface is up
+	Up bool
+}
+
+// Route is a unicast route
+type Route struct {
+	// Destination prefix in CIDR notation, or "default" for the default
+	// route of the gateway's address family
+	Destination string
+	// Outgoing device
+	Device string
+	// Next hop, empty for directly connected destinations
+	Gateway string
+	// Route metric (priority)
+	Metric int
+	// Routing table, 0 for the main table
+	Table int
+	// Paths of a multipath route, used instead of Device and Gateway
+	Nexthops []Nexthop
+}
+
+// Nexthop is a path of a multipath route
+type Nexthop struct {
+	// Outgoing device
+	Device string
+	// Next hop, empty for directly connected destinations
+	Gateway string
+	// Relative share of the traffic, 0 for 1
+	Weight int
+}
+
+// VF is the configuration of a virtual function on its PF, as "ip link
+// show <pf>" lists it
+type VF struct {
+	// VF ID on the PF
+	ID int
+	// Administrative MAC address, empty when unset
+	MAC string
+	// VLAN ID and 802.1p priority, 0 for untagged
+	VLAN int
+	QoS  int
+	// Whether the NIC drops frames with a spoofed source MAC
+	SpoofCheck bool
+	// Whether the VF may change its MAC and enter promiscuous mode
+	Trust bool
+	// Guaranteed and maximum transmit rates in Mbps, 0 for unlimited
+	MinTxRateMbps int
+	MaxTxRateMbps int
+}
+
+// XfrmState is an ESP association in transport mode, encrypting with
+// AES-GCM (rfc4106)
+type XfrmState struct {
+	// Addresses the association protects the traffic between
+	Src string
+	Dst string
+	// Security parameter index identifying the association at the receiver
+	SPI uint32
+	// Request ID binding the association to the templates of its policies
+	Reqid int
+	// AES-GCM key with the 4 byte salt appended
+	AEADKey []byte
+}
+
+// FDBEntry is an entry of the forwarding database of a bridge, as "bridge
+// fdb show" lists it
+type FDBEntry struct {
+	// MAC address
+	MAC string
+	// Device the entry is on, a bridge port or the bridge itself
+	Device string
+	// Bridge of the device, empty for the bridge itself and for devices
+	// without a bridge (e.g., the multicast addresses of NICs)
+	Master string
+	// VLAN of the entry, 0 without VLAN filtering
+	VLAN int
+	// Whether the entry is permanent or static rather than learned
+	Permanent bool
+}
+
+// Interface wraps the netlink operations used by the datapath, so every
+// datapath feature can be tested with the Fake instead of root privileges
+type Interface interface {
+	// LinkByName returns the link, or ErrNotFound
+	LinkByName(name string) (Link, error)
+	// LinkAdd creates a link
+	LinkAdd(link Link) error
+	// LinkDel removes a link
+	LinkDel(name string) error
+	// LinkSetMTU changes the MTU of a link
+	LinkSetMTU(name string, mtu int) error
+	// LinkSetUp brings a link up
+	LinkSetUp(name string) error
+	// LinkSetDown brings a link down
+	LinkSetDown(name string) error
+	// LinkSetName renames a link
+	LinkSetName(name, newName string) error
+	// LinkSetHardwareAddr changes the MAC address of a link
+	LinkSetHardwareAddr(name, mac string) error
+	// LinkSetNetns moves a link into the network namespace at a path, or
+	// into the namespace of the process for an empty path
+	LinkSetNetns(name, netns string) error
+	// AddrList returns the addresses (CIDR notation) of a link
+	AddrList(device string) ([]string, error)
+	// AddrAdd assigns an address to a link
+	AddrAdd(device, cidr string) error
+	// AddrDel removes an address from a link
+	AddrDel(device, cidr string) error
+	// RouteList returns the routes towards a destination prefix
+	RouteList(destination string) ([]Route, error)
+	// RouteReplace installs or updates a route
+	RouteReplace(route Route) error
+	// RouteDel removes a route
+	RouteDel(route Route) error
+	// LinkRouteList returns the routes over a link, without the prefix
+	// routes the kernel adds for its addresses
+	LinkRouteList(device string) ([]Route, error)
+	// VFList returns the VFs of a PF
+	VFList(pf string) ([]VF, error)
+	// VFSetMAC sets the MAC address of a VF, the zero address clears it
+	VFSetMAC(pf string, vf int, mac string) error
+	// VFSetVLAN sets the VLAN and priority of a VF, VLAN 0 clears it
+	VFSetVLAN(pf string, vf, vlan, qos int) error
+	// VFSetSpoofCheck turns the spoof check of a VF on or off
+	VFSetSpoofCheck(pf string, vf int, on bool) error
+	// VFSetTrust turns the trust of a VF on or off
+	VFSetTrust(pf string, vf int, on bool) error
+	// VFSetRate sets the transmit rate limits of a VF, 0 for unlimited
+	VFSetRate(pf string, vf, minMbps, maxMbps int) error
+	// XfrmStateAdd installs an association. The key is only handed to the
+	// kernel, never to another process or into an error.
+	XfrmStateAdd(state XfrmState) error
+	// FDBList returns the entries of the forwarding databases of all
+	// devices
+	FDBList() ([]FDBEntry, error)
+	// Netns returns the Interface of the network namespace at a path,
+	// released with Close
+	Netns(path string) (Interface, error)
+	// Close releases the namespace of an Interface returned by Netns
+	Close() error
+}
@@ -0,0 +1,227 @@
+package placement
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/connection"
+	"github.com/akos011221/nsm/pkg/watchdog"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Labels and annotations of the latency hints on the nodes
+const (
+	// LabelPrefixLatency prefixes the labels carrying the latency in
+	// milliseconds the node achieves to a service, named
+	// <namespace>.<service>. Latency-sensitive pods select the nodes
+	// meeting their requirement with a node affinity using the Lt operator.
+	LabelPrefixLatency = "latency.nsm.akosrbn.io/"
+	// AnnotationLatencyHints is the JSON list of the latency hints of the
+	// node, with the requirements of the services
+	AnnotationLatencyHints = "nsm.akosrbn.io/latency-hints"
+)
+
+// maxMetricAge is the age beyond which the latency measured on a
+// connection no longer tells what the node achieves
+const maxMetricAge = 10 * time.Minute
+
+// maxLabelName is the longest name part of a label key
+const maxLabelName = 63
+
+// Hint is the latency a node achieves to a service, measured on the
+// connections the node established to it
+type Hint struct {
+	// Service reached (namespace/name)
+	Service string `json:"service"`
+	// Lowest latency measured in milliseconds
+	LatencyMs int `json:"latencyMs"`
+	// Latency requirement of the service in milliseconds, 0 for none
+	RequirementMs int `json:"requirementMs,omitempty"`
+	// Whether the latency meets the requirement
+	Meets bool `json:"meets"`
+	// Connections the latency was measured on
+	Connections int `json:"connections"`
+}
+
+// Hints returns the latency hints of a node from the recent metrics of the
+// connections it established, ordered by service
+func Hints(node string, conns []nsmv1.NetworkConnection, services []nsmv1.NetworkService, now time.Time) []Hint {
+	requirements := make(map[string]int, len(services))
+	for _, svc := range services {
+		requirements[svc.Namespace+"/"+svc.Name] = svc.Spec.LatencyRequirement
+	}
+
+	byService := make(map[string]*Hint)
+	for i := range conns {
+		conn := &conns[i]
+		updated := conn.Status.Metrics.LastUpdated
+		if !conn.Status.Established || conn.Status.Node != node || updated == nil || now.Sub(updated.Time) > maxMetricAge {
+			continue
+		}
+		namespace, name := connection.Destination(conn)
+		key := namespace + "/" + name
+		requirement, ok := requirements[key]
+		if !ok {
+			continue
+		}
+		hint := byService[key]
+		if hint == nil {
+			hint = &Hint{Service: key, LatencyMs: conn.Status.Metrics.LatencyMs, RequirementMs: requirement}
+			byService[key] = hint
+		}
+		hint.LatencyMs = min(hint.LatencyMs, conn.Status.Metrics.LatencyMs)
+		hint.Connections++
+	}
+
+	hints := make([]Hint, 0, len(byService))
+	for _, hint := range byService {
+		hint.Meets = hint.RequirementMs == 0 || hint.LatencyMs <= hint.RequirementMs
+		hints = append(hints, *hint)
+	}
+	sort.Slice(hints, func(i, j int) bool { return hints[i].Service < hints[j].Service })
+	return hints
+}
+
+// LatencyLabel returns the key of the label carrying the latency to a
+// service. Names too long for a label are shortened with a hash.
+func LatencyLabel(namespace, name string) string {
+	label := namespace + "." + name
+	if len(label) > maxLabelName {
+		h := fnv.New32a()
+		h.Write([]byte(label))
+		label = fmt.Sprintf("%s-%08x", strings.TrimRight(label[:maxLabelName-9], ".-"), h.Sum32())
+	}
+	return LabelPrefixLatency + label
+}
+
+// Publisher publishes the latency hints of a node as labels and an
+// annotation of its Node, so the scheduler can place latency-sensitive
+// pods on the nodes meeting the latency requirements of their services
+type Publisher struct {
+	// Context for cancellation
+	ctx context.Context
+	// Kubernetes client (controller-runtime)
+	client client.Client
+	// Logger
+	logger *logrus.Logger
+	// Node the connections are established on
+	node string
+	// Kubernetes Node the hints are published on
+	nodeName string
+	// Interval between publications
+	interval time.Duration
+	// Progress signal for the watchdog
+	heartbeat *watchdog.Heartbeat
+}
+
+// NewPublisher creates a publisher of the hints of the connections
+// established on node, on the Kubernetes Node nodeName
+func NewPublisher(ctx context.Context, c client.Client, logger *logrus.Logger, node, nodeName string, interval time.Duration) *Publisher {
+	return &Publisher{ctx: ctx, client: c, logger: logger, node: node, nodeName: nodeName, interval: interval}
+}
+
+// SetHeartbeat makes the publisher report its progress to the watchdog
+func (p *Publisher) SetHeartbeat(hb *watchdog.Heartbeat) {
+	p.heartbeat = hb
+	hb.Expect(p.interval)
+}
+
+// Start publishes the hints periodically
+func (p *Publisher) Start() error {
+	p.logger.Infof("Publishing latency hints on node %s every %s", p.nodeName, p.interval)
+	if err := p.Publish(time.Now()); err != nil {
+		p.logger.WithError(err).Warn("Failed to publish latency hints")
+	}
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			p.heartbeat.Beat()
+			if err := p.Publish(now); err != nil {
+				p.logger.WithError(err).Warn("Failed to publish latency hints")
+			}
+
+		case <-p.ctx.Done():
+			p.logger.Info("Stopping latency hint publisher")
+			return nil
+		}
+	}
+}
+
+// Publish updates the labels and the annotation of the Node with the
+// current hints. Labels of services no longer measured are removed, so a
+// stale latency never attracts pods.
+func (p *Publisher) Publish(now time.Time) error {
+	var conns nsmv1.NetworkConnectionList
+	if err := p.client.List(p.ctx, &conns); err != nil {
+		return fmt.Errorf("failed to list network connections: %w", err)
+	}
+	var services nsmv1.NetworkServiceList
+	if err := p.client.List(p.ctx, &services); err != nil {
+		return fmt.Errorf("failed to list network services: %w", err)
+	}
+	hints := Hints(p.node, conns.Items, services.Items, now)
+
+	var node corev1.Node
+	if err := p.client.Get(p.ctx, client.ObjectKey{Name: p.nodeName}, &node); err != nil {
+		return fmt.Errorf("failed to get node %s: %w", p.nodeName, err)
+	}
+	patch := client.MergeFrom(node.DeepCopy())
+	if !apply(&node, hints) {
+		return nil
+	}
+	if err := p.client.Patch(p.ctx, &node, patch); err != nil {
+		return fmt.Errorf("failed to update the latency hints of node %s: %w", p.nodeName, err)
+	}
+	p.logger.Debugf("Published %d latency hints on node %s", len(hints), p.nodeName)
+	return nil
+}
+
+// apply sets the labels and the annotation of the hints on a node,
+// reporting whether they changed
+func apply(node *corev1.Node, hints []Hint) bool {
+	labels := make(map[string]string, len(hints))
+	for _, hint := range hints {
+		namespace, name, _ := strings.Cut(hint.Service, "/")
+		labels[LatencyLabel(namespace, name)] = strconv.Itoa(hint.LatencyMs)
+	}
+	data, _ := json.Marshal(hints)
+
+	changed := false
+	for key := range node.Labels {
+		if _, ok := labels[key]; strings.HasPrefix(key, LabelPrefixLatency) && !ok {
+			delete(node.Labels, key)
+			changed = true
+		}
+	}
+	for key, value := range labels {
+		if node.Labels == nil {
+			node.Labels = make(map[string]string)
+		}
+		if node.Labels[key] != value {
+			node.Labels[key] = value
+			changed = true
+		}
+	}
+
+	if node.Annotations[AnnotationLatencyHints] != string(data) {
+		if node.Annotations == nil {
+			node.Annotations = make(map[string]string)
+		}
+		node.Annotations[AnnotationLatencyHints] = string(data)
+		changed = true
+	}
+	return changed
+}
@@ -0,0 +1,114 @@
+package placement
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func measured(name, node, destination string, latencyMs int, at time.Time) *nsmv1.NetworkConnection {
+	updated := metav1.NewTime(at)
+	return &nsmv1.NetworkConnection{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "edge"},
+		Spec:       nsmv1.NetworkConnectionSpec{Destination: destination},
+		Status: nsmv1.NetworkConnectionStatus{
+			Established: true,
+			Node:        node,
+			Metrics:     nsmv1.ConnectionMetrics{LatencyMs: latencyMs, LastUpdated: &updated},
+		},
+	}
+}
+
+func service(name string, requirementMs int) *nsmv1.NetworkService {
+	return &nsmv1.NetworkService{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "edge"},
+		Spec:       nsmv1.NetworkServiceSpec{LatencyRequirement: requirementMs},
+	}
+}
+
+func TestHints(t *testing.T) {
+	now := time.Now()
+	conns := []nsmv1.NetworkConnection{
+		*measured("scada-1", "edge-1", "scada", 8, now),
+		*measured("scada-2", "edge-1", "scada", 4, now),
+		*measured("video", "edge-1", "video", 30, now.Add(-time.Minute)),
+		*measured("stale", "edge-1", "storage", 1, now.Add(-time.Hour)),
+		*measured("elsewhere", "edge-2", "storage", 1, now),
+		*measured("unknown", "edge-1", "missing", 1, now),
+	}
+	services := []nsmv1.NetworkService{*service("scada", 5), *service("video", 20), *service("storage", 0)}
+
+	hints := Hints("edge-1", conns, services, now)
+	want := []Hint{
+		{Service: "edge/scada", LatencyMs: 4, RequirementMs: 5, Meets: true, Connections: 2},
+		{Service: "edge/video", LatencyMs: 30, RequirementMs: 20, Meets: false, Connections: 1},
+	}
+	if len(hints) != len(want) {
+		t.Fatalf("Hints() = %+v, want %+v", hints, want)
+	}
+	for i := range want {
+		if hints[i] != want[i] {
+			t.Errorf("hint %d = %+v, want %+v", i, hints[i], want[i])
+		}
+	}
+}
+
+func TestLatencyLabel(t *testing.T) {
+	if got := LatencyLabel("edge", "scada"); got != "latency.nsm.akosrbn.io/edge.scada" {
+		t.Errorf("LatencyLabel() = %s", got)
+	}
+	long := LatencyLabel("manufacturing-line-7", strings.Repeat("a", 60))
+	name := strings.TrimPrefix(long, LabelPrefixLatency)
+	if len(name) > maxLabelName || long == LatencyLabel("manufacturing-line-7", strings.Repeat("a", 61)) {
+		t.Errorf("long names not shortened uniquely: %s", long)
+	}
+}
+
+func TestPublish(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := nsmv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{
+		Name:   "worker-1",
+		Labels: map[string]string{"kubernetes.io/hostname": "worker-1", LatencyLabel("edge", "gone"): "3"},
+	}}
+	scada := measured("scada", "edge-1", "scada", 4, now)
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node, scada, service("scada", 5)).Build()
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	p := NewPublisher(context.Background(), c, logger, "edge-1", "worker-1", time.Minute)
+	if err := p.Publish(now); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+
+	var got corev1.Node
+	if err := c.Get(context.Background(), client.ObjectKey{Name: "worker-1"}, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Labels[LatencyLabel("edge", "scada")] != "4" || got.Labels["kubernetes.io/hostname"] != "worker-1" {
+		t.Errorf("labels = %v, want the latency to edge/scada beside the others", got.Labels)
+	}
+	if _, ok := got.Labels[LatencyLabel("edge", "gone")]; ok {
+		t.Errorf("label of a service no longer measured kept")
+	}
+	if !strings.Contains(got.Annotations[AnnotationLatencyHints], `"meets":true`) {
+		t.Errorf("annotation = %s", got.Annotations[AnnotationLatencyHints])
+	}
+}
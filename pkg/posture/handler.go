@@ -0,0 +1,50 @@
+package posture
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/api"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Handler serves the security posture report of the connections, as JSON
+// or with format=csv as CSV, for the sites given with site (repeatable).
+// The encryption of the connections established by the node is checked
+// with the verifier, if not nil.
+func Handler(c client.Reader, policy Policy, node string, verifier Verifier) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		format := query.Get("format")
+		if format != "" && format != "json" && format != "csv" {
+			api.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid format %q, must be json or csv", format))
+			return
+		}
+
+		var conns nsmv1.NetworkConnectionList
+		if err := c.List(r.Context(), &conns); err != nil {
+			api.WriteError(w, http.StatusInternalServerError, fmt.Errorf("failed to list connections: %w", err))
+			return
+		}
+		var verify Verify
+		if verifier != nil {
+			verify = func(conn *nsmv1.NetworkConnection) error {
+				if conn.Status.Node != node {
+					return ErrNotVerifiable
+				}
+				return verifier.Verify(r.Context(), conn)
+			}
+		}
+		report := Build(conns.Items, policy, verify, time.Now()).Filter(query["site"]...)
+
+		if format == "csv" {
+			w.Header().Set("Content-Type", "text/csv")
+			w.Header().Set("Content-Disposition", `attachment; filename="security-posture.csv"`)
+			WriteCSV(w, report)
+			return
+		}
+		api.WriteJSON(w, http.StatusOK, report)
+	})
+}
@@ -0,0 +1,312 @@
+package posture
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Ciphers of the encryption protocols
+const (
+	// CipherAES256GCM encrypts IPsec (ESP) and kTLS records
+	CipherAES256GCM = "aes-256-gcm"
+	// CipherChaCha20Poly1305 is the construction of WireGuard
+	CipherChaCha20Poly1305 = "chacha20-poly1305"
+)
+
+// ErrNotVerifiable is returned by a Verify function for connections whose
+// datapath it can't check, those established by other nodes
+var ErrNotVerifiable = errors.New("datapath of the connection can't be checked")
+
+// Verify checks that the datapath encrypts an established connection with
+// its key, returning ErrNotVerifiable when it can't be checked
+type Verify func(conn *nsmv1.NetworkConnection) error
+
+// Verifier checks the encryption of connections on the datapath of the
+// node, implemented by datapath.IPsecDatapath
+type Verifier interface {
+	// Verify returns nil when the datapath encrypts the connection with
+	// the key in its Secret
+	Verify(ctx context.Context, conn *nsmv1.NetworkConnection) error
+}
+
+// Policy is what the connections of a site must comply with
+type Policy struct {
+	// Whether every connection must be encrypted
+	RequireEncryption bool `json:"requireEncryption"`
+	// Longest a key may stay in use before it is rotated, 0 for no limit
+	MaxKeyAge time.Duration `json:"maxKeyAge,omitempty"`
+}
+
+// Connection is the security posture of a connection
+type Connection struct {
+	// Namespace and name of the connection
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	// Source and destination endpoints
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	// Connection type (kernel, sriov, dpdk, vxlan, wireguard)
+	ConnectionType string `json:"connectionType"`
+	// State of the connection
+	State string `json:"state,omitempty"`
+	// Encryption protocol (ipsec, tls, wireguard), empty for none
+	Protocol string `json:"protocol,omitempty"`
+	// Cipher of the protocol
+	Cipher string `json:"cipher,omitempty"`
+	// Whether the traffic is encrypted inline on the NIC (offload) or on
+	// the CPU (software), empty while it isn't encrypted
+	Mode string `json:"mode,omitempty"`
+	// Whether the established datapath encrypts the traffic
+	Encrypted bool `json:"encrypted"`
+	// Whether the encryption was checked on the datapath rather than taken
+	// from the status, only the node of a connection can check it
+	Verified bool `json:"verified"`
+	// Whether the peers authenticate each other with the key of the
+	// connection, kept in a Secret of its namespace
+	IdentityVerified bool `json:"identityVerified"`
+	// Last time the keys were rotated
+	LastRekeyTime *metav1.Time `json:"lastRekeyTime,omitempty"`
+	// Whether the connection complies with the policy
+	Compliant bool `json:"compliant"`
+	// Why the connection doesn't comply
+	Violations []string `json:"violations,omitempty"`
+}
+
+// Summary counts the connections of a site by posture
+type Summary struct {
+	// Connections of the site
+	Connections int `json:"connections"`
+	// Connections encrypting their traffic
+	Encrypted int `json:"encrypted"`
+	// Connections encrypting their traffic on the NIC
+	Offloaded int `json:"offloaded"`
+	// Connections whose encryption was checked on the datapath
+	Verified int `json:"verified"`
+	// Connections whose peers verify each other's identity
+	IdentityVerified int `json:"identityVerified"`
+	// Connections complying with the policy
+	Compliant int `json:"compliant"`
+	// Encrypted connections by cipher
+	Ciphers map[string]int `json:"ciphers,omitempty"`
+}
+
+// Site is the security posture of the connections established by a node
+type Site struct {
+	// Node of the site, empty for connections not established by any
+	Site string `json:"site"`
+	// Counts of the connections
+	Summary Summary `json:"summary"`
+	// Connections, ordered by namespace and name
+	Connections []Connection `json:"connections"`
+}
+
+// Report is the security posture of the connections, by site
+type Report struct {
+	// Time the report was generated
+	GeneratedAt time.Time `json:"generatedAt"`
+	// Policy the compliance was checked against
+	Policy Policy `json:"policy"`
+	// Sites, ordered by name
+	Sites []Site `json:"sites"`
+}
+
+// Build returns the security posture report of the connections, checking
+// the encryption of the established ones with verify, if not nil. Canary
+// connections are ephemeral and left out.
+func Build(conns []nsmv1.NetworkConnection, policy Policy, verify Verify, now time.Time) *Report {
+	bySite := make(map[string]*Site)
+	for i := range conns {
+		conn := &conns[i]
+		if conn.Spec.Canary != nil {
+			continue
+		}
+		site := bySite[conn.Status.Node]
+		if site == nil {
+			site = &Site{Site: conn.Status.Node, Connections: []Connection{}}
+			bySite[conn.Status.Node] = site
+		}
+		site.add(assess(conn, policy, verify, now))
+	}
+
+	report := &Report{GeneratedAt: now, Policy: policy, Sites: make([]Site, 0, len(bySite))}
+	for _, site := range bySite {
+		sort.Slice(site.Connections, func(i, j int) bool {
+			a, b := site.Connections[i], site.Connections[j]
+			if a.Namespace != b.Namespace {
+				return a.Namespace < b.Namespace
+			}
+			return a.Name < b.Name
+		})
+		report.Sites = append(report.Sites, *site)
+	}
+	sort.Slice(report.Sites, func(i, j int) bool { return report.Sites[i].Site < report.Sites[j].Site })
+	return report
+}
+
+// add adds a connection to the site and its summary
+func (s *Site) add(c Connection) {
+	s.Connections = append(s.Connections, c)
+	s.Summary.Connections++
+	if c.Encrypted {
+		s.Summary.Encrypted++
+		if s.Summary.Ciphers == nil {
+			s.Summary.Ciphers = make(map[string]int)
+		}
+		s.Summary.Ciphers[c.Cipher]++
+	}
+	if c.Mode == nsmv1.EncryptionModeOffload {
+		s.Summary.Offloaded++
+	}
+	if c.Verified {
+		s.Summary.Verified++
+	}
+	if c.IdentityVerified {
+		s.Summary.IdentityVerified++
+	}
+	if c.Compliant {
+		s.Summary.Compliant++
+	}
+}
+
+// assess returns the posture of a connection and checks it against the
+// policy. Only established connections carry traffic, the others comply
+// as long as they are configured to. The encryption of connections the
+// datapath can be checked for is what the check finds, the status of the
+// others is trusted.
+func assess(conn *nsmv1.NetworkConnection, policy Policy, verify Verify, now time.Time) Connection {
+	c := Connection{
+		Namespace:      conn.Namespace,
+		Name:           conn.Name,
+		Source:         conn.Spec.Source,
+		Destination:    conn.Spec.Destination,
+		ConnectionType: conn.Spec.ConnectionType,
+		State:          conn.Status.State,
+		Protocol:       protocol(&conn.Spec),
+		LastRekeyTime:  conn.Status.LastRekeyTime,
+	}
+	c.Cipher = cipher(c.Protocol)
+	var verifyErr error = ErrNotVerifiable
+	if conn.Status.Established && c.Protocol != "" && verify != nil {
+		verifyErr = verify(conn)
+	}
+	switch {
+	case verifyErr == nil:
+		// the associations are derived from the key in the Secret
+		c.Encrypted, c.Verified, c.Mode = true, true, conn.Status.Encryption
+		c.IdentityVerified = true
+	case !errors.Is(verifyErr, ErrNotVerifiable):
+		c.Verified = true
+	case conn.Status.Established && conn.Status.Encryption != "":
+		c.Encrypted, c.Mode = true, conn.Status.Encryption
+		c.IdentityVerified = conn.Status.KeySecret != ""
+	}
+
+	switch {
+	case c.Protocol == "" && policy.RequireEncryption:
+		c.Violations = append(c.Violations, "not configured for encryption")
+	case c.Protocol != "" && conn.Status.Established && c.Verified && !c.Encrypted:
+		c.Violations = append(c.Violations, fmt.Sprintf("encryption configured but not on the datapath: %v", verifyErr))
+	case c.Protocol != "" && conn.Status.Established && !c.Encrypted:
+		c.Violations = append(c.Violations, "encryption configured but not active")
+	}
+	if c.Encrypted && !c.IdentityVerified {
+		c.Violations = append(c.Violations, "peer identity not verified, the connection has no key")
+	}
+	if c.Encrypted && policy.MaxKeyAge > 0 {
+		rotated := conn.CreationTimestamp.Time
+		if conn.Status.LastRekeyTime != nil {
+			rotated = conn.Status.LastRekeyTime.Time
+		}
+		if age := now.Sub(rotated); age > policy.MaxKeyAge {
+			c.Violations = append(c.Violations, fmt.Sprintf("key not rotated for %s, the policy allows %s",
+				age.Truncate(time.Minute), policy.MaxKeyAge))
+		}
+	}
+	c.Compliant = len(c.Violations) == 0
+	return c
+}
+
+// protocol returns the encryption protocol of a connection, empty for none
+func protocol(spec *nsmv1.NetworkConnectionSpec) string {
+	if spec.Encryption != "" {
+		return spec.Encryption
+	}
+	if spec.ConnectionType == nsmv1.ConnectionTypeWireGuard {
+		return nsmv1.ConnectionTypeWireGuard
+	}
+	return ""
+}
+
+// cipher returns the cipher of an encryption protocol
+func cipher(protocol string) string {
+	switch protocol {
+	case nsmv1.EncryptionIPsec, nsmv1.EncryptionTLS:
+		return CipherAES256GCM
+	case nsmv1.ConnectionTypeWireGuard:
+		return CipherChaCha20Poly1305
+	default:
+		return ""
+	}
+}
+
+// Filter returns the report with the sites named, all sites without names
+func (r *Report) Filter(sites ...string) *Report {
+	if len(sites) == 0 {
+		return r
+	}
+	filtered := &Report{GeneratedAt: r.GeneratedAt, Policy: r.Policy, Sites: []Site{}}
+	for _, site := range r.Sites {
+		for _, name := range sites {
+			if site.Site == name {
+				filtered.Sites = append(filtered.Sites, site)
+				break
+			}
+		}
+	}
+	return filtered
+}
+
+// WriteCSV writes the report as CSV, a row per connection
+func WriteCSV(w io.Writer, report *Report) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"site", "namespace", "name", "source", "destination", "connection_type", "state",
+		"protocol", "cipher", "mode", "encrypted", "identity_verified", "last_rekey", "compliant", "violations", "verified"})
+	for _, site := range report.Sites {
+		for _, c := range site.Connections {
+			lastRekey := ""
+			if c.LastRekeyTime != nil {
+				lastRekey = c.LastRekeyTime.UTC().Format(time.RFC3339)
+			}
+			cw.Write([]string{
+				site.Site,
+				c.Namespace,
+				c.Name,
+				c.Source,
+				c.Destination,
+				c.ConnectionType,
+				c.State,
+				c.Protocol,
+				c.Cipher,
+				c.Mode,
+				strconv.FormatBool(c.Encrypted),
+				strconv.FormatBool(c.IdentityVerified),
+				lastRekey,
+				strconv.FormatBool(c.Compliant),
+				strings.Join(c.Violations, "; "),
+				strconv.FormatBool(c.Verified),
+			})
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
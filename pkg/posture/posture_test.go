@@ -0,0 +1,172 @@
+package posture
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// conn returns a connection established on node, encrypted in mode if
+// the spec encrypts it
+func conn(name, node string, spec nsmv1.NetworkConnectionSpec, mode string, created time.Time) nsmv1.NetworkConnection {
+	c := nsmv1.NetworkConnection{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "edge", CreationTimestamp: metav1.NewTime(created)},
+		Spec:       spec,
+		Status:     nsmv1.NetworkConnectionStatus{State: nsmv1.ConnectionStateEstablished, Established: true, Node: node},
+	}
+	if mode != "" {
+		c.Status.Encryption = mode
+		c.Status.KeySecret = "nsm-key-" + name
+	}
+	return c
+}
+
+func TestBuild(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	policy := Policy{RequireEncryption: true, MaxKeyAge: 48 * time.Hour}
+
+	ipsec := conn("plc", "edge-1", nsmv1.NetworkConnectionSpec{ConnectionType: nsmv1.ConnectionTypeSRIOV, Encryption: nsmv1.EncryptionIPsec},
+		nsmv1.EncryptionModeOffload, now.Add(-time.Hour))
+	wg := conn("camera", "edge-1", nsmv1.NetworkConnectionSpec{ConnectionType: nsmv1.ConnectionTypeWireGuard},
+		nsmv1.EncryptionModeSoftware, now.Add(-72*time.Hour))
+	rekeyed := metav1.NewTime(now.Add(-time.Hour))
+	wg.Status.LastRekeyTime = &rekeyed
+	plain := conn("telemetry", "edge-2", nsmv1.NetworkConnectionSpec{ConnectionType: nsmv1.ConnectionTypeKernel}, "", now)
+	stale := conn("historian", "edge-2", nsmv1.NetworkConnectionSpec{ConnectionType: nsmv1.ConnectionTypeKernel, Encryption: nsmv1.EncryptionTLS},
+		nsmv1.EncryptionModeSoftware, now.Add(-72*time.Hour))
+	keyless := conn("scada", "edge-2", nsmv1.NetworkConnectionSpec{ConnectionType: nsmv1.ConnectionTypeKernel, Encryption: nsmv1.EncryptionIPsec},
+		nsmv1.EncryptionModeSoftware, now)
+	keyless.Status.KeySecret = ""
+	pending := conn("lidar", "", nsmv1.NetworkConnectionSpec{ConnectionType: nsmv1.ConnectionTypeWireGuard}, "", now)
+	pending.Status = nsmv1.NetworkConnectionStatus{State: nsmv1.ConnectionStatePending}
+	canary := conn("canary", "edge-1", nsmv1.NetworkConnectionSpec{ConnectionType: nsmv1.ConnectionTypeKernel, Canary: &nsmv1.CanarySpec{}}, "", now)
+
+	report := Build([]nsmv1.NetworkConnection{ipsec, wg, plain, stale, keyless, pending, canary}, policy, nil, now)
+	if len(report.Sites) != 3 || report.Sites[0].Site != "" || report.Sites[1].Site != "edge-1" || report.Sites[2].Site != "edge-2" {
+		t.Fatalf("unexpected sites %+v", report.Sites)
+	}
+
+	// a pending connection configured for encryption complies
+	if c := report.Sites[0].Connections[0]; !c.Compliant || c.Encrypted || c.Cipher != CipherChaCha20Poly1305 {
+		t.Errorf("pending connection posture = %+v", c)
+	}
+
+	edge1 := report.Sites[1]
+	if len(edge1.Connections) != 2 {
+		t.Fatalf("canary connection reported: %+v", edge1.Connections)
+	}
+	want := Summary{Connections: 2, Encrypted: 2, Offloaded: 1, IdentityVerified: 2, Compliant: 2,
+		Ciphers: map[string]int{CipherAES256GCM: 1, CipherChaCha20Poly1305: 1}}
+	if got := edge1.Summary; !reflect.DeepEqual(got, want) {
+		t.Errorf("edge-1 summary = %+v, want %+v", got, want)
+	}
+
+	violations := make(map[string][]string)
+	for _, c := range report.Sites[2].Connections {
+		violations[c.Name] = c.Violations
+	}
+	for name, want := range map[string]string{
+		"telemetry": "not configured for encryption",
+		"historian": "key not rotated for 72h0m0s, the policy allows 48h0m0s",
+		"scada":     "peer identity not verified, the connection has no key",
+	} {
+		if len(violations[name]) != 1 || violations[name][0] != want {
+			t.Errorf("violations of %s = %q, want %q", name, violations[name], want)
+		}
+	}
+	if report.Sites[2].Summary.Compliant != 0 {
+		t.Errorf("edge-2 compliant = %d, want 0", report.Sites[2].Summary.Compliant)
+	}
+
+	// unencrypted connections comply when the policy allows them
+	if c := Build([]nsmv1.NetworkConnection{plain}, Policy{}, nil, now).Sites[0].Connections[0]; !c.Compliant {
+		t.Errorf("unencrypted connection not compliant without policy: %+v", c)
+	}
+
+	if filtered := report.Filter("edge-2"); len(filtered.Sites) != 1 || filtered.Sites[0].Site != "edge-2" {
+		t.Errorf("Filter(edge-2) = %+v", filtered.Sites)
+	}
+}
+
+func TestBuildVerifiesDatapath(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	spec := nsmv1.NetworkConnectionSpec{ConnectionType: nsmv1.ConnectionTypeSRIOV, Encryption: nsmv1.EncryptionIPsec}
+	installed := conn("plc", "edge-1", spec, nsmv1.EncryptionModeSoftware, now)
+	missing := conn("scada", "edge-1", spec, nsmv1.EncryptionModeSoftware, now)
+	remote := conn("historian", "edge-2", spec, nsmv1.EncryptionModeSoftware, now)
+	verify := func(conn *nsmv1.NetworkConnection) error {
+		switch {
+		case conn.Status.Node != "edge-1":
+			return ErrNotVerifiable
+		case conn.Name == "scada":
+			return errors.New("xfrm-state/10.0.0.2/esp/0x100 not installed as applied")
+		}
+		return nil
+	}
+
+	report := Build([]nsmv1.NetworkConnection{installed, missing, remote}, Policy{}, verify, now)
+	edge1, edge2 := report.Sites[0], report.Sites[1]
+	if s := edge1.Summary; s.Encrypted != 1 || s.Verified != 2 || s.Compliant != 1 {
+		t.Errorf("edge-1 summary = %+v, want 1 of 2 verified connections encrypted", s)
+	}
+	for _, c := range edge1.Connections {
+		if c.Name == "scada" && (c.Encrypted || len(c.Violations) != 1 ||
+			c.Violations[0] != "encryption configured but not on the datapath: xfrm-state/10.0.0.2/esp/0x100 not installed as applied") {
+			t.Errorf("posture of a connection without associations = %+v", c)
+		}
+	}
+
+	// the status of connections of other nodes is trusted, but not verified
+	if c := edge2.Connections[0]; !c.Encrypted || c.Verified || !c.Compliant {
+		t.Errorf("posture of a connection of another node = %+v", c)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := nsmv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	plc := conn("plc", "edge-1", nsmv1.NetworkConnectionSpec{ConnectionType: nsmv1.ConnectionTypeWireGuard}, nsmv1.EncryptionModeSoftware, now)
+	plain := conn("telemetry", "edge-2", nsmv1.NetworkConnectionSpec{ConnectionType: nsmv1.ConnectionTypeKernel}, "", now)
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(&plc, &plain).Build()
+	h := Handler(c, Policy{RequireEncryption: true}, "edge-1", nil)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/security/posture?site=edge-1", nil))
+	var report Report
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+		t.Fatalf("decoding %s: %v", rec.Body, err)
+	}
+	if len(report.Sites) != 1 || report.Sites[0].Summary.Compliant != 1 {
+		t.Errorf("report for edge-1 = %+v", report)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/security/posture?format=csv", nil))
+	rows, err := csv.NewReader(bytes.NewReader(rec.Body.Bytes())).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 || rows[2][0] != "edge-2" || rows[2][13] != "false" || rows[2][14] != "not configured for encryption" {
+		t.Errorf("unexpected CSV %q", rows)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/security/posture?format=xml", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("format=xml status = %d, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,261 @@
+package pressure
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/akos011221/nsm/pkg/watchdog"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// Resources with pressure stall information
+var resources = []string{"cpu", "memory", "io"}
+
+var (
+	// pressurePercent exposes the PSI "some" avg10 per resource
+	pressurePercent = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "nsm_node_pressure_percent",
+		Help: "Share of the last 10 seconds tasks were stalled on a resource, by resource",
+	}, []string{"resource"})
+
+	// underPressure is 1 while non-critical work is throttled
+	underPressure = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "nsm_node_under_pressure",
+		Help: "Whether non-critical allocations and telemetry are throttled due to node pressure",
+	})
+)
+
+func init() {
+	crmetrics.Registry.MustRegister(pressurePercent, underPressure)
+}
+
+// Signal tells whether the node is under pressure
+type Signal interface {
+	UnderPressure() bool
+}
+
+// Sample is a reading of the node pressure
+type Sample struct {
+	// PSI "some" avg10 per resource in percent, missing on kernels without PSI
+	Stall map[string]float64
+	// Available memory in percent of the total memory
+	MemAvailable float64
+}
+
+// Monitor watches the CPU, memory and IO pressure of the node. The node is
+// under pressure when tasks stall on a resource for more than the threshold
+// or available memory runs low, and stays so until the readings are calm
+// for the release delay, so throttled work doesn't flap.
+type Monitor struct {
+	// Context for cancellation
+	ctx context.Context
+	// Logger
+	logger *logrus.Logger
+	// Root of the proc filesystem
+	procRoot string
+	// Stall share in percent above which the node is under pressure
+	stallThreshold float64
+	// Available memory in percent below which the node is under pressure
+	minMemAvailable float64
+	// Interval between readings
+	interval time.Duration
+	// Time the readings must be calm before the pressure is released
+	releaseDelay time.Duration
+	// Whether the node is under pressure
+	pressured bool
+	// Time the readings were last over the thresholds
+	lastPressure time.Time
+	// Mutex for protecting the state
+	mu sync.RWMutex
+	// Progress signal for the watchdog
+	heartbeat *watchdog.Heartbeat
+}
+
+// NewMonitor creates a new node pressure monitor
+func NewMonitor(ctx context.Context, logger *logrus.Logger, procRoot string, stallThreshold, minMemAvailable float64) *Monitor {
+	return &Monitor{
+		ctx:             ctx,
+		logger:          logger,
+		procRoot:        procRoot,
+		stallThreshold:  stallThreshold,
+		minMemAvailable: minMemAvailable,
+		interval:        5 * time.Second,
+		releaseDelay:    time.Minute,
+	}
+}
+
+// SetHeartbeat makes the monitor report its progress to the watchdog
+func (m *Monitor) SetHeartbeat(hb *watchdog.Heartbeat) {
+	m.heartbeat = hb
+	hb.Expect(m.interval)
+}
+
+// SetThresholds changes the thresholds of the pressure, from the next
+// reading
+func (m *Monitor) SetThresholds(stallThreshold, minMemAvailable float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stallThreshold = stallThreshold
+	m.minMemAvailable = minMemAvailable
+}
+
+// Start reads the node pressure periodically
+func (m *Monitor) Start() error {
+	m.logger.Infof("Starting node pressure monitor (stall threshold %.0f%%, min available memory %.0f%%)", m.stallThreshold, m.minMemAvailable)
+	if _, err := os.Stat(filepath.Join(m.procRoot, "pressure")); err != nil {
+		m.logger.Warn("Kernel without pressure stall information, only watching available memory")
+	}
+
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			m.heartbeat.Beat()
+			if err := m.Check(now); err != nil {
+				m.logger.WithError(err).Warn("Failed to read node pressure")
+			}
+
+		case <-m.ctx.Done():
+			m.logger.Info("Stopping node pressure monitor")
+			return nil
+		}
+	}
+}
+
+// UnderPressure reports whether non-critical work should be paused
+func (m *Monitor) UnderPressure() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.pressured
+}
+
+// Check reads the node pressure and updates the state
+func (m *Monitor) Check(now time.Time) error {
+	sample, err := m.Read()
+	if err != nil {
+		return err
+	}
+	for resource, stall := range sample.Stall {
+		pressurePercent.WithLabelValues(resource).Set(stall)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var reasons []string
+	for _, resource := range resources {
+		if stall, ok := sample.Stall[resource]; ok && stall > m.stallThreshold {
+			reasons = append(reasons, fmt.Sprintf("%s stalled %.1f%%", resource, stall))
+		}
+	}
+	if sample.MemAvailable < m.minMemAvailable {
+		reasons = append(reasons, fmt.Sprintf("%.1f%% memory available", sample.MemAvailable))
+	}
+
+	switch {
+	case len(reasons) > 0:
+		m.lastPressure = now
+		if !m.pressured {
+			m.logger.Warnf("Node under pressure (%s), throttling non-critical allocations and telemetry", strings.Join(reasons, ", "))
+		}
+		m.pressured = true
+	case m.pressured && now.Sub(m.lastPressure) >= m.releaseDelay:
+		m.logger.Info("Node pressure released, resuming non-critical allocations and telemetry")
+		m.pressured = false
+	}
+	if m.pressured {
+		underPressure.Set(1)
+	} else {
+		underPressure.Set(0)
+	}
+	return nil
+}
+
+// Read reads the pressure stall information and available memory
+func (m *Monitor) Read() (*Sample, error) {
+	sample := &Sample{Stall: make(map[string]float64)}
+	for _, resource := range resources {
+		stall, err := readStall(filepath.Join(m.procRoot, "pressure", resource))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		sample.Stall[resource] = stall
+	}
+
+	available, err := readMemAvailable(filepath.Join(m.procRoot, "meminfo"))
+	if err != nil {
+		return nil, err
+	}
+	sample.MemAvailable = available
+	return sample, nil
+}
+
+// readStall returns the "some" avg10 of a PSI file
+func readStall(path string) (float64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "some" {
+			continue
+		}
+		value, ok := strings.CutPrefix(fields[1], "avg10=")
+		if !ok {
+			break
+		}
+		stall, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid pressure in %s: %w", path, err)
+		}
+		return stall, nil
+	}
+	return 0, fmt.Errorf("no pressure average in %s", path)
+}
+
+// readMemAvailable returns the available memory in percent of the total
+func readMemAvailable(path string) (float64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read memory info: %w", err)
+	}
+	defer f.Close()
+
+	values := make(map[string]float64)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		if value, err := strconv.ParseFloat(fields[1], 64); err == nil {
+			values[strings.TrimSuffix(fields[0], ":")] = value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, fmt.Errorf("failed to read memory info: %w", err)
+	}
+
+	total, available := values["MemTotal"], values["MemAvailable"]
+	if total == 0 {
+		return 0, fmt.Errorf("no total memory in %s", path)
+	}
+	return available / total * 100, nil
+}
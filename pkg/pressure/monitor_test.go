@@ -0,0 +1,125 @@
+package pressure
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+)
+
+func quietLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return logger
+}
+
+// writeProc writes the PSI files (unless psi is nil) and meminfo
+func writeProc(t *testing.T, root string, psi map[string]float64, availableKB int) {
+	t.Helper()
+	if psi != nil {
+		if err := os.MkdirAll(filepath.Join(root, "pressure"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		for resource, stall := range psi {
+			content := fmt.Sprintf("some avg10=%.2f avg60=0.00 avg300=0.00 total=1234\nfull avg10=0.00 avg60=0.00 avg300=0.00 total=0\n", stall)
+			if err := os.WriteFile(filepath.Join(root, "pressure", resource), []byte(content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	meminfo := fmt.Sprintf("MemTotal:       1000000 kB\nMemFree:         100000 kB\nMemAvailable:   %7d kB\n", availableKB)
+	if err := os.WriteFile(filepath.Join(root, "meminfo"), []byte(meminfo), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMonitorRead(t *testing.T) {
+	root := t.TempDir()
+	writeProc(t, root, map[string]float64{"cpu": 12.5, "memory": 0, "io": 3.25}, 250000)
+
+	sample, err := NewMonitor(context.Background(), quietLogger(), root, 40, 10).Read()
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if sample.Stall["cpu"] != 12.5 || sample.Stall["io"] != 3.25 || sample.MemAvailable != 25 {
+		t.Errorf("unexpected sample %+v", sample)
+	}
+}
+
+func TestMonitorHysteresis(t *testing.T) {
+	root := t.TempDir()
+	m := NewMonitor(context.Background(), quietLogger(), root, 40, 10)
+	now := time.Now()
+
+	writeProc(t, root, map[string]float64{"cpu": 5, "memory": 0, "io": 0}, 500000)
+	if err := m.Check(now); err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	if m.UnderPressure() {
+		t.Fatalf("calm node reported under pressure")
+	}
+
+	// CPU stalls
+	writeProc(t, root, map[string]float64{"cpu": 55, "memory": 0, "io": 0}, 500000)
+	if err := m.Check(now.Add(5 * time.Second)); err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	if !m.UnderPressure() || testutil.ToFloat64(underPressure) != 1 {
+		t.Fatalf("stalled node not reported under pressure")
+	}
+
+	// calm again, but not for long enough
+	writeProc(t, root, map[string]float64{"cpu": 5, "memory": 0, "io": 0}, 500000)
+	if err := m.Check(now.Add(30 * time.Second)); err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	if !m.UnderPressure() {
+		t.Errorf("pressure released before the release delay")
+	}
+	if err := m.Check(now.Add(65 * time.Second)); err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	if m.UnderPressure() || testutil.ToFloat64(underPressure) != 0 {
+		t.Errorf("pressure not released after the release delay")
+	}
+}
+
+func TestMonitorWithoutPSI(t *testing.T) {
+	root := t.TempDir()
+	m := NewMonitor(context.Background(), quietLogger(), root, 40, 10)
+
+	// older kernels only have the available memory
+	writeProc(t, root, nil, 50000)
+	if err := m.Check(time.Now()); err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	if !m.UnderPressure() {
+		t.Errorf("node with 5%% available memory not reported under pressure")
+	}
+}
+
+func TestMonitorSetThresholds(t *testing.T) {
+	root := t.TempDir()
+	m := NewMonitor(context.Background(), quietLogger(), root, 40, 10)
+	writeProc(t, root, map[string]float64{"cpu": 30, "memory": 0, "io": 0}, 500000)
+	if err := m.Check(time.Now()); err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	if m.UnderPressure() {
+		t.Fatalf("node under the threshold reported under pressure")
+	}
+
+	m.SetThresholds(25, 10)
+	if err := m.Check(time.Now()); err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	if !m.UnderPressure() {
+		t.Errorf("node over the lowered threshold not reported under pressure")
+	}
+}
@@ -0,0 +1,19 @@
+package probe
+
+import (
+	"syscall"
+)
+
+// bindToDevice returns a dialer control function setting SO_BINDTODEVICE
+func bindToDevice(device string) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		var sockErr error
+		err := c.Control(func(fd uintptr) {
+			sockErr = syscall.BindToDevice(int(fd), device)
+		})
+		if err != nil {
+			return err
+		}
+		return sockErr
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package probe
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// bindToDevice is only supported on Linux
+func bindToDevice(device string) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		return fmt.Errorf("binding to device %s is not supported on this platform", device)
+	}
+}
@@ -0,0 +1,265 @@
+package probe
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/watchdog"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// Probes of a round and the time between them
+const (
+	roundProbes  = 3
+	roundSpacing = 100 * time.Millisecond
+	probeTimeout = 500 * time.Millisecond
+)
+
+// Time for the instability of a connection to decay to half
+const instabilityHalfLife = 10 * time.Minute
+
+// probeInterval exposes the adapted probe interval of every connection
+var probeInterval = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "nsm_connection_probe_interval_seconds",
+	Help: "Current interval between the probe rounds of a connection",
+}, []string{"namespace", "name"})
+
+// probeLatency is the distribution of the round trip times of the probes
+var probeLatency = prometheus.NewHistogram(prometheus.HistogramOpts{
+	Name:    "nsm_connection_latency_seconds",
+	Help:    "Round trip time of the probes of the connections",
+	Buckets: []float64{0.0005, 0.001, 0.002, 0.005, 0.01, 0.02, 0.05, 0.1, 0.2, 0.5},
+})
+
+func init() {
+	crmetrics.Registry.MustRegister(probeInterval, probeLatency)
+}
+
+// instability is the decaying count of the unstable probe rounds of a
+// connection
+type instability struct {
+	// Count at the time it was last updated
+	score float64
+	// Time the count was last updated
+	updated time.Time
+}
+
+// Monitor probes the established connections with a probe target and
+// records the observed latency and loss in their status. A round that
+// loses probes or misses the latency requirement makes the connection
+// unstable for a while, so it is probed more often, as are connections
+// of high priority; the scheduler keeps the node within its probe budget.
+type Monitor struct {
+	// Context for cancellation
+	ctx context.Context
+	// Kubernetes client (controller-runtime)
+	client client.Client
+	// Logger
+	logger *logrus.Logger
+	// Sends the probes, replaceable for tests
+	prober Prober
+	// Decides when the connections are probed
+	scheduler *Scheduler
+	// Interval between checks for due connections
+	interval time.Duration
+	// Instability by namespace/name
+	instability map[string]*instability
+	// Intervals taken by the scheduler on the next tick, nil if unchanged
+	intervals *intervals
+	// Mutex for protecting the intervals
+	mu sync.Mutex
+	// Progress signal for the watchdog
+	heartbeat *watchdog.Heartbeat
+}
+
+// intervals are the settings of the probe scheduler
+type intervals struct {
+	base, minimum time.Duration
+	budget        float64
+}
+
+// NewMonitor creates a new connection probe monitor. Stable connections
+// of priority 0 are probed every base interval, none more often than
+// every minimum interval, and the node sends at most budget probes per second.
+func NewMonitor(ctx context.Context, c client.Client, logger *logrus.Logger, base, minimum time.Duration, budget float64) *Monitor {
+	return &Monitor{
+		ctx:         ctx,
+		client:      c,
+		logger:      logger,
+		prober:      NewTCPProber("", probeTimeout),
+		scheduler:   NewScheduler(base, minimum, budget/roundProbes),
+		interval:    time.Second,
+		instability: make(map[string]*instability),
+	}
+}
+
+// SetHeartbeat makes the monitor report its progress to the watchdog
+func (m *Monitor) SetHeartbeat(hb *watchdog.Heartbeat) {
+	m.heartbeat = hb
+	hb.Expect(m.interval)
+}
+
+// SetIntervals changes the base and minimum intervals and the budget of
+// the probes, from the next check for due connections on
+func (m *Monitor) SetIntervals(base, minimum time.Duration, budget float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.intervals = &intervals{base: base, minimum: minimum, budget: budget}
+}
+
+// Start probes the due connections periodically
+func (m *Monitor) Start() error {
+	m.logger.Infof("Starting connection probe monitor (base interval %s, minimum %s, budget %.1f rounds/s)",
+		m.scheduler.base, m.scheduler.min, m.scheduler.budget)
+
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			m.heartbeat.Beat()
+			if err := m.Tick(now); err != nil {
+				m.logger.WithError(err).Warn("Connection probing failed")
+			}
+
+		case <-m.ctx.Done():
+			m.logger.Info("Stopping connection probe monitor")
+			return nil
+		}
+	}
+}
+
+// Tick updates the priorities and instability of the probed connections
+// and probes those that are due
+func (m *Monitor) Tick(now time.Time) error {
+	m.mu.Lock()
+	if next := m.intervals; next != nil {
+		m.scheduler.SetIntervals(next.base, next.minimum, next.budget/roundProbes)
+		m.intervals = nil
+	}
+	m.mu.Unlock()
+
+	var conns nsmv1.NetworkConnectionList
+	if err := m.client.List(m.ctx, &conns); err != nil {
+		return fmt.Errorf("failed to list connections: %w", err)
+	}
+
+	probed := make(map[string]*nsmv1.NetworkConnection)
+	for i := range conns.Items {
+		conn := &conns.Items[i]
+		if !conn.Status.Established || conn.Spec.ProbeTarget == "" || conn.Spec.Canary != nil {
+			continue
+		}
+		key := conn.Namespace + "/" + conn.Name
+		probed[key] = conn
+		m.scheduler.Set(key, conn.Spec.Priority, m.unstable(key, now))
+	}
+	for _, key := range m.scheduler.Keys() {
+		if _, ok := probed[key]; !ok {
+			m.scheduler.Forget(key)
+			delete(m.instability, key)
+		}
+	}
+	probeInterval.Reset()
+	for key, interval := range m.scheduler.Intervals() {
+		probeInterval.WithLabelValues(probed[key].Namespace, probed[key].Name).Set(interval.Seconds())
+	}
+
+	due := m.scheduler.Due(now)
+	samples := make([][]Sample, len(due))
+	var wg sync.WaitGroup
+	for i, key := range due {
+		m.scheduler.Probed(key, now)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn := probed[key]
+			var err error
+			samples[i], err = m.prober.Probe(m.ctx, conn.Spec.ProbeTarget, roundProbes, roundSpacing)
+			if err != nil {
+				m.logger.WithError(err).Warnf("Failed to probe connection %s", key)
+			}
+		}()
+	}
+	wg.Wait()
+
+	var errs []error
+	for i, key := range due {
+		if len(samples[i]) == 0 {
+			continue
+		}
+		if err := m.record(probed[key], samples[i], now); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to record the probes of %d connections: %w", len(errs), errs[0])
+	}
+	return nil
+}
+
+// record updates the metrics of a connection from a probe round and
+// counts unstable rounds
+func (m *Monitor) record(conn *nsmv1.NetworkConnection, samples []Sample, now time.Time) error {
+	var received int
+	var total time.Duration
+	for _, s := range samples {
+		if s.OK {
+			received++
+			total += s.RTT
+			probeLatency.Observe(s.RTT.Seconds())
+		}
+	}
+
+	metrics := &conn.Status.Metrics
+	metrics.PacketLossPPM = (len(samples) - received) * 1000000 / len(samples)
+	if received > 0 {
+		metrics.LatencyMs = int((total / time.Duration(received)).Milliseconds())
+	}
+	updated := metav1.NewTime(now)
+	metrics.LastUpdated = &updated
+
+	key := conn.Namespace + "/" + conn.Name
+	requirement := conn.Spec.LatencyRequirement
+	if received < len(samples) || (requirement > 0 && metrics.LatencyMs > requirement) {
+		e, ok := m.instability[key]
+		if !ok {
+			e = &instability{}
+			m.instability[key] = e
+		}
+		e.score = decay(e, now) + 1
+		e.updated = now
+	}
+
+	if err := m.client.Status().Update(m.ctx, conn); err != nil {
+		return fmt.Errorf("failed to update connection %s status: %w", key, err)
+	}
+	return nil
+}
+
+// unstable returns the current instability of a connection
+func (m *Monitor) unstable(key string, now time.Time) float64 {
+	e, ok := m.instability[key]
+	if !ok {
+		return 0
+	}
+	return decay(e, now)
+}
+
+// decay returns the instability decayed to now
+func decay(e *instability, now time.Time) float64 {
+	elapsed := now.Sub(e.updated)
+	if elapsed <= 0 {
+		return e.score
+	}
+	return e.score * math.Exp2(-float64(elapsed)/float64(instabilityHalfLife))
+}
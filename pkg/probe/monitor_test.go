@@ -0,0 +1,138 @@
+package probe
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// scriptedProber answers probes per target
+type scriptedProber struct {
+	samples map[string][]Sample
+}
+
+func (p *scriptedProber) Probe(ctx context.Context, target string, count int, interval time.Duration) ([]Sample, error) {
+	return p.samples[target], nil
+}
+
+func probedConnection(name, target string, priority int32) *nsmv1.NetworkConnection {
+	return &nsmv1.NetworkConnection{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "edge"},
+		Spec: nsmv1.NetworkConnectionSpec{
+			ConnectionType: nsmv1.ConnectionTypeKernel,
+			Priority:       priority,
+			ProbeTarget:    target,
+		},
+		Status: nsmv1.NetworkConnectionStatus{
+			State:       nsmv1.ConnectionStateEstablished,
+			Established: true,
+		},
+	}
+}
+
+func TestMonitorProbesConnections(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := nsmv1.AddToScheme(scheme); err != nil {
+		t.Fatalf("failed to build scheme: %v", err)
+	}
+	unprobed := probedConnection("unprobed", "", 0)
+	c := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(probedConnection("stable", "10.0.0.1:80", 0), probedConnection("lossy", "10.0.0.2:80", 0), unprobed).
+		WithStatusSubresource(&nsmv1.NetworkConnection{}).
+		Build()
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	m := NewMonitor(context.Background(), c, logger, 30*time.Second, 2*time.Second, 30)
+	ok := Sample{RTT: 4 * time.Millisecond, OK: true}
+	m.prober = &scriptedProber{samples: map[string][]Sample{
+		"10.0.0.1:80": {ok, ok, ok},
+		"10.0.0.2:80": {ok, {}, ok},
+	}}
+
+	now := time.Now()
+	if err := m.Tick(now); err != nil {
+		t.Fatalf("Tick() error = %v", err)
+	}
+	get := func(name string) *nsmv1.NetworkConnection {
+		t.Helper()
+		var conn nsmv1.NetworkConnection
+		if err := c.Get(context.Background(), types.NamespacedName{Namespace: "edge", Name: name}, &conn); err != nil {
+			t.Fatal(err)
+		}
+		return &conn
+	}
+	stable, lossy := get("stable"), get("lossy")
+	if stable.Status.Metrics.LatencyMs != 4 || stable.Status.Metrics.PacketLossPPM != 0 || stable.Status.Metrics.LastUpdated == nil {
+		t.Errorf("unexpected metrics of the stable connection %+v", stable.Status.Metrics)
+	}
+	if lossy.Status.Metrics.PacketLossPPM != 333333 {
+		t.Errorf("PacketLossPPM = %d, want 333333", lossy.Status.Metrics.PacketLossPPM)
+	}
+	if get("unprobed").Status.Metrics.LastUpdated != nil {
+		t.Errorf("connection without a probe target was probed")
+	}
+
+	// the loss makes the connection unstable, so it is probed more often
+	if err := m.Tick(now.Add(time.Second)); err != nil {
+		t.Fatalf("Tick() error = %v", err)
+	}
+	intervals := m.scheduler.Intervals()
+	if lossy := intervals["edge/lossy"]; intervals["edge/stable"] != 30*time.Second || lossy < 15*time.Second || lossy > 16*time.Second {
+		t.Errorf("unexpected intervals %v", intervals)
+	}
+
+	// the instability decays once the connection stays healthy
+	m.prober.(*scriptedProber).samples["10.0.0.2:80"] = []Sample{ok, ok, ok}
+	if err := m.Tick(now.Add(time.Hour)); err != nil {
+		t.Fatalf("Tick() error = %v", err)
+	}
+	if interval := m.scheduler.Intervals()["edge/lossy"]; interval < 29*time.Second {
+		t.Errorf("interval of the recovered connection = %s, want about 30s", interval)
+	}
+
+	// connections that are torn down are no longer probed
+	lossy = get("lossy")
+	lossy.Status.Established = false
+	if err := c.Status().Update(context.Background(), lossy); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Tick(now.Add(2 * time.Hour)); err != nil {
+		t.Fatalf("Tick() error = %v", err)
+	}
+	if keys := m.scheduler.Keys(); len(keys) != 1 || keys[0] != "edge/stable" {
+		t.Errorf("probed connections = %v, want only the stable one", keys)
+	}
+	if _, ok := m.instability["edge/lossy"]; ok {
+		t.Errorf("instability of the torn down connection kept")
+	}
+}
+
+func TestMonitorSetIntervals(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := nsmv1.AddToScheme(scheme); err != nil {
+		t.Fatalf("failed to build scheme: %v", err)
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(probedConnection("stable", "10.0.0.1:80", 0)).Build()
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	m := NewMonitor(context.Background(), c, logger, 30*time.Second, 2*time.Second, 30)
+	m.prober = &scriptedProber{}
+	m.SetIntervals(10*time.Second, time.Second, 30)
+	if err := m.Tick(time.Now()); err != nil {
+		t.Fatalf("Tick() error = %v", err)
+	}
+	if interval := m.scheduler.Intervals()["edge/stable"]; interval != 10*time.Second {
+		t.Errorf("interval = %s after the change, want 10s", interval)
+	}
+}
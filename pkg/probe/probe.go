@@ -0,0 +1,76 @@
+package probe
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Sample is the outcome of a single probe
+type Sample struct {
+	// Round trip time of the probe, zero if it was lost
+	RTT time.Duration
+	// Whether the probe got an answer
+	OK bool
+}
+
+// Prober sends synthetic traffic to a target
+type Prober interface {
+	// Probe sends count probes to target (host:port) with the given interval
+	Probe(ctx context.Context, target string, count int, interval time.Duration) ([]Sample, error)
+}
+
+// TCPProber measures the TCP handshake time to a target, optionally
+// forcing the traffic out of a specific device. It needs no raw sockets.
+type TCPProber struct {
+	// Device to send the probes through (empty for the routing table's choice)
+	Device string
+	// Timeout of a single probe
+	Timeout time.Duration
+}
+
+// NewTCPProber creates a TCP prober bound to the device
+func NewTCPProber(device string, timeout time.Duration) *TCPProber {
+	if timeout <= 0 {
+		timeout = time.Second
+	}
+	return &TCPProber{
+		Device:  device,
+		Timeout: timeout,
+	}
+}
+
+// Probe connects count times to the target and records the handshake times
+func (p *TCPProber) Probe(ctx context.Context, target string, count int, interval time.Duration) ([]Sample, error) {
+	if _, _, err := net.SplitHostPort(target); err != nil {
+		return nil, fmt.Errorf("invalid probe target %s: %w", target, err)
+	}
+
+	dialer := &net.Dialer{Timeout: p.Timeout}
+	if p.Device != "" {
+		dialer.Control = bindToDevice(p.Device)
+	}
+
+	samples := make([]Sample, 0, count)
+	for i := 0; i < count; i++ {
+		if i > 0 {
+			select {
+			case <-time.After(interval):
+			case <-ctx.Done():
+				return samples, ctx.Err()
+			}
+		}
+
+		start := time.Now()
+		conn, err := dialer.DialContext(ctx, "tcp", target)
+		if err != nil {
+			samples = append(samples, Sample{})
+			continue
+		}
+		samples = append(samples, Sample{RTT: time.Since(start), OK: true})
+		conn.Close()
+	}
+
+	return samples, nil
+}
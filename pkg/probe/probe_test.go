@@ -0,0 +1,57 @@
+package probe
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTCPProber(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	p := NewTCPProber("", time.Second)
+	samples, err := p.Probe(context.Background(), ln.Addr().String(), 3, time.Millisecond)
+	if err != nil {
+		t.Fatalf("Probe() error = %v", err)
+	}
+	if len(samples) != 3 {
+		t.Fatalf("got %d samples, want 3", len(samples))
+	}
+	for _, s := range samples {
+		if !s.OK || s.RTT <= 0 {
+			t.Errorf("unexpected sample: %+v", s)
+		}
+	}
+
+	// once the listener is gone the probes are lost
+	addr := ln.Addr().String()
+	ln.Close()
+	samples, err = p.Probe(context.Background(), addr, 2, time.Millisecond)
+	if err != nil {
+		t.Fatalf("Probe() error = %v", err)
+	}
+	for _, s := range samples {
+		if s.OK {
+			t.Errorf("probe to a closed port succeeded")
+		}
+	}
+}
+
+func TestTCPProberInvalidTarget(t *testing.T) {
+	if _, err := NewTCPProber("", 0).Probe(context.Background(), "no-port", 1, 0); err == nil {
+		t.Errorf("expected error for a target without port")
+	}
+}
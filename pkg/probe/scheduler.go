@@ -0,0 +1,167 @@
+package probe
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// Priority at which a connection is probed twice as often as one of
+// priority 0, the default critical priority of the node
+const priorityScale = 100
+
+// target is the probing state of a connection
+type target struct {
+	// Priority of the connection
+	priority int32
+	// Recent instability, 0 for a stable connection
+	instability float64
+	// Time of the last probe round, zero before the first
+	last time.Time
+}
+
+// Scheduler decides when the connections of the node are probed. Instead
+// of a single global interval, the interval of a connection shrinks with
+// its priority and its recent instability, down to a minimum. Should the
+// connections together want more probe rounds than the per-node budget,
+// all intervals are stretched by the same factor, so critical and
+// unstable connections keep being probed more often than the rest.
+type Scheduler struct {
+	// Interval of a stable connection of priority 0
+	base time.Duration
+	// Shortest interval of any connection
+	min time.Duration
+	// Probe rounds per second the node may run
+	budget float64
+	// Probing state by connection
+	targets map[string]*target
+	// Probe rounds that may start right away, refilled at the budget rate
+	tokens float64
+	// Time the tokens were last refilled, zero before the first
+	refilled time.Time
+}
+
+// NewScheduler creates a new probe scheduler
+func NewScheduler(base, minimum time.Duration, budget float64) *Scheduler {
+	return &Scheduler{
+		base:    base,
+		min:     minimum,
+		budget:  budget,
+		targets: make(map[string]*target),
+	}
+}
+
+// SetIntervals changes the base and minimum intervals and the budget, the
+// connections are probed at the new intervals from their last round on
+func (s *Scheduler) SetIntervals(base, minimum time.Duration, budget float64) {
+	s.base = base
+	s.min = minimum
+	s.budget = budget
+}
+
+// Set adds a connection or updates its priority and instability
+func (s *Scheduler) Set(key string, priority int32, instability float64) {
+	t, ok := s.targets[key]
+	if !ok {
+		t = &target{}
+		s.targets[key] = t
+	}
+	t.priority = priority
+	t.instability = instability
+}
+
+// Forget stops probing a connection
+func (s *Scheduler) Forget(key string) {
+	delete(s.targets, key)
+}
+
+// Keys returns the connections being probed
+func (s *Scheduler) Keys() []string {
+	keys := make([]string, 0, len(s.targets))
+	for key := range s.targets {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Intervals returns the current probe interval of every connection
+func (s *Scheduler) Intervals() map[string]time.Duration {
+	intervals := make(map[string]time.Duration, len(s.targets))
+	var rate float64
+	for key, t := range s.targets {
+		interval := s.desired(t)
+		intervals[key] = interval
+		rate += 1 / interval.Seconds()
+	}
+	if rate > s.budget {
+		stretch := rate / s.budget
+		for key, interval := range intervals {
+			intervals[key] = time.Duration(float64(interval) * stretch)
+		}
+	}
+	return intervals
+}
+
+// Due returns the connections to probe now, the most overdue first and
+// among equally overdue the most frequently probed. Never probed
+// connections are due right away, but no more rounds start than
+// the budget allows, so a burst of new connections is spread out.
+func (s *Scheduler) Due(now time.Time) []string {
+	if s.refilled.IsZero() {
+		s.tokens = math.Max(s.budget, 1)
+	} else if elapsed := now.Sub(s.refilled); elapsed > 0 {
+		s.tokens += elapsed.Seconds() * s.budget
+	}
+	s.tokens = math.Min(s.tokens, math.Max(s.budget, 1))
+	s.refilled = now
+
+	type due struct {
+		key      string
+		overdue  float64
+		interval time.Duration
+	}
+	var candidates []due
+	for key, interval := range s.Intervals() {
+		t := s.targets[key]
+		if t.last.IsZero() {
+			candidates = append(candidates, due{key: key, overdue: math.Inf(1), interval: interval})
+			continue
+		}
+		if elapsed := now.Sub(t.last); elapsed >= interval {
+			candidates = append(candidates, due{key: key, overdue: float64(elapsed) / float64(interval), interval: interval})
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].overdue != candidates[j].overdue {
+			return candidates[i].overdue > candidates[j].overdue
+		}
+		if candidates[i].interval != candidates[j].interval {
+			return candidates[i].interval < candidates[j].interval
+		}
+		return candidates[i].key < candidates[j].key
+	})
+
+	var keys []string
+	for _, c := range candidates {
+		if s.tokens < 1 {
+			break
+		}
+		s.tokens--
+		keys = append(keys, c.key)
+	}
+	return keys
+}
+
+// Probed records that a connection was probed
+func (s *Scheduler) Probed(key string, now time.Time) {
+	if t, ok := s.targets[key]; ok {
+		t.last = now
+	}
+}
+
+// desired returns the interval a connection wants, regardless of the budget
+func (s *Scheduler) desired(t *target) time.Duration {
+	factor := (1 + float64(max(t.priority, 0))/priorityScale) * (1 + math.Max(t.instability, 0))
+	return max(time.Duration(float64(s.base)/factor), s.min)
+}
@@ -0,0 +1,80 @@
+package probe
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSchedulerIntervals(t *testing.T) {
+	s := NewScheduler(30*time.Second, 2*time.Second, 10)
+	s.Set("edge/plc", 0, 0)
+	s.Set("edge/safety", 100, 0)
+	s.Set("edge/flaky", 0, 2)
+	s.Set("edge/critical-flaky", 300, 100)
+
+	want := map[string]time.Duration{
+		"edge/plc":            30 * time.Second,
+		"edge/safety":         15 * time.Second,
+		"edge/flaky":          10 * time.Second,
+		"edge/critical-flaky": 2 * time.Second,
+	}
+	if got := s.Intervals(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Intervals() = %v, want %v", got, want)
+	}
+
+	// over the budget all intervals stretch alike: the connections want
+	// 1/30+1/15+1/10+1/2 = 0.7 rounds per second
+	s.budget = 0.35
+	got := s.Intervals()
+	if got["edge/plc"] != time.Minute || got["edge/critical-flaky"] != 4*time.Second {
+		t.Errorf("Intervals() over budget = %v, want them doubled", got)
+	}
+	var rate float64
+	for _, interval := range got {
+		rate += 1 / interval.Seconds()
+	}
+	if rate > 0.35+1e-9 {
+		t.Errorf("%.3f rounds per second exceed the budget", rate)
+	}
+}
+
+func TestSchedulerDue(t *testing.T) {
+	now := time.Now()
+	s := NewScheduler(30*time.Second, 2*time.Second, 2)
+	s.Set("edge/a", 0, 0)
+	s.Set("edge/b", 0, 0)
+	s.Set("edge/critical", 100, 0)
+
+	// new connections are due at once, but only as many as the budget
+	// allows, the most frequently probed first
+	due := s.Due(now)
+	if want := []string{"edge/critical", "edge/a"}; !reflect.DeepEqual(due, want) {
+		t.Fatalf("Due() = %v, want %v", due, want)
+	}
+	for _, key := range due {
+		s.Probed(key, now)
+	}
+	now = now.Add(500 * time.Millisecond)
+	if due := s.Due(now); !reflect.DeepEqual(due, []string{"edge/b"}) {
+		t.Fatalf("Due() = %v, want the remaining connection once the budget refilled", due)
+	}
+	s.Probed("edge/b", now)
+
+	// nothing is due before its interval passed
+	now = now.Add(10 * time.Second)
+	if due := s.Due(now); len(due) != 0 {
+		t.Errorf("Due() = %v, want none", due)
+	}
+	now = now.Add(5 * time.Second)
+	if due := s.Due(now); !reflect.DeepEqual(due, []string{"edge/critical"}) {
+		t.Errorf("Due() = %v, want the critical connection after 15s", due)
+	}
+
+	// forgotten connections are no longer probed
+	s.Forget("edge/a")
+	now = now.Add(time.Minute)
+	if due := s.Due(now); !reflect.DeepEqual(due, []string{"edge/critical", "edge/b"}) {
+		t.Errorf("Due() = %v, want the remaining connections", due)
+	}
+}
@@ -0,0 +1,30 @@
+package profiling
+
+import (
+	"net/http"
+	"net/http/pprof"
+
+	"github.com/akos011221/nsm/pkg/api"
+)
+
+// PathPrefix is where the pprof endpoints are served
+const PathPrefix = "/debug/pprof/"
+
+// Handler serves the pprof endpoints to requests carrying the bearer token
+func Handler(token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(PathPrefix, pprof.Index)
+	mux.HandleFunc(PathPrefix+"cmdline", pprof.Cmdline)
+	mux.HandleFunc(PathPrefix+"profile", pprof.Profile)
+	mux.HandleFunc(PathPrefix+"symbol", pprof.Symbol)
+	mux.HandleFunc(PathPrefix+"trace", pprof.Trace)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !api.Authorized(r, token) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="nsm"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,88 @@
+package profiling
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestHandlerRequiresToken(t *testing.T) {
+	h := Handler("s3cret")
+
+	tests := []struct {
+		name   string
+		header string
+		want   int
+	}{
+		{"no token", "", http.StatusUnauthorized},
+		{"wrong token", "Bearer nope", http.StatusUnauthorized},
+		{"basic auth", "Basic s3cret", http.StatusUnauthorized},
+		{"valid token", "Bearer s3cret", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, PathPrefix+"goroutine?debug=1", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if tt.want == http.StatusOK && !strings.Contains(rec.Body.String(), "goroutine") {
+				t.Errorf("unexpected profile body")
+			}
+		})
+	}
+}
+
+func TestHandlerWithoutTokenIsClosed(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, PathPrefix, nil)
+	req.Header.Set("Authorization", "Bearer ")
+	rec := httptest.NewRecorder()
+	Handler("").ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, want 401 without a configured token", rec.Code)
+	}
+}
+
+func TestSnapshotterKeepsLatest(t *testing.T) {
+	dir := t.TempDir()
+	s := NewSnapshotter(context.Background(), logrus.New(), dir, time.Hour, 2)
+
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 4; i++ {
+		if err := s.Snapshot(start.Add(time.Duration(i) * time.Minute)); err != nil {
+			t.Fatalf("Snapshot() error = %v", err)
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir() error = %v", err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	want := []string{
+		"goroutine-20260101T000200Z.pprof", "goroutine-20260101T000300Z.pprof",
+		"heap-20260101T000200Z.pprof", "heap-20260101T000300Z.pprof",
+	}
+	if strings.Join(names, ",") != strings.Join(want, ",") {
+		t.Errorf("snapshots = %v, want %v", names, want)
+	}
+
+	info, err := os.Stat(dir + "/" + want[0])
+	if err != nil || info.Size() == 0 || info.Mode().Perm() != 0o600 {
+		t.Errorf("unexpected snapshot file: %v (%v)", info, err)
+	}
+}
@@ -0,0 +1,124 @@
+package profiling
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Snapshot kinds written by the snapshotter
+var snapshotKinds = []string{"heap", "goroutine"}
+
+// Snapshotter periodically writes heap and goroutine profiles to a local
+// directory, keeping only the most recent ones so the disk never fills up
+type Snapshotter struct {
+	// Context for cancellation
+	ctx context.Context
+	// Logger
+	logger *logrus.Logger
+	// Directory the snapshots are written to
+	dir string
+	// Interval between snapshots
+	interval time.Duration
+	// Number of snapshots of each kind to keep
+	maxSnapshots int
+}
+
+// NewSnapshotter creates a new snapshotter
+func NewSnapshotter(ctx context.Context, logger *logrus.Logger, dir string, interval time.Duration, maxSnapshots int) *Snapshotter {
+	return &Snapshotter{
+		ctx:          ctx,
+		logger:       logger,
+		dir:          dir,
+		interval:     interval,
+		maxSnapshots: maxSnapshots,
+	}
+}
+
+// Start takes snapshots until the context is done
+func (s *Snapshotter) Start() error {
+	s.logger.Infof("Writing profile snapshots to %s every %s", s.dir, s.interval)
+
+	if err := os.MkdirAll(s.dir, 0o700); err != nil {
+		return fmt.Errorf("failed to create profile directory: %w", err)
+	}
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.Snapshot(time.Now()); err != nil {
+				s.logger.Errorf("Failed to take profile snapshot: %v", err)
+			}
+		case <-s.ctx.Done():
+			s.logger.Info("Stopping profile snapshots")
+			return nil
+		}
+	}
+}
+
+// Snapshot writes one snapshot of each kind and prunes the oldest ones
+func (s *Snapshotter) Snapshot(now time.Time) error {
+	// an up to date heap profile needs a completed GC cycle
+	runtime.GC()
+
+	stamp := now.UTC().Format("20060102T150405Z")
+	for _, kind := range snapshotKinds {
+		if err := s.write(kind, filepath.Join(s.dir, fmt.Sprintf("%s-%s.pprof", kind, stamp))); err != nil {
+			return err
+		}
+		if err := s.prune(kind); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// write writes a profile to the file
+func (s *Snapshotter) write(kind, path string) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to create %s snapshot: %w", kind, err)
+	}
+	defer f.Close()
+
+	if err := pprof.Lookup(kind).WriteTo(f, 0); err != nil {
+		return fmt.Errorf("failed to write %s snapshot: %w", kind, err)
+	}
+	return nil
+}
+
+// prune removes the oldest snapshots of a kind beyond the limit
+func (s *Snapshotter) prune(kind string) error {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return fmt.Errorf("failed to list profile directory: %w", err)
+	}
+
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasPrefix(e.Name(), kind+"-") && strings.HasSuffix(e.Name(), ".pprof") {
+			names = append(names, e.Name())
+		}
+	}
+	// timestamps sort lexically
+	sort.Strings(names)
+
+	for len(names) > s.maxSnapshots {
+		if err := os.Remove(filepath.Join(s.dir, names[0])); err != nil {
+			return fmt.Errorf("failed to remove old snapshot: %w", err)
+		}
+		names = names[1:]
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+package qos
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/datapath"
+	"github.com/akos011221/nsm/pkg/watchdog"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var shapedConnections = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "nsm_qos_shaped_connections",
+		Help: "Connections whose traffic is shaped by their own class, by interface",
+	},
+	[]string{"interface"},
+)
+
+func init() {
+	crmetrics.Registry.MustRegister(shapedConnections)
+}
+
+// Applier programs the shaping of the interfaces
+type Applier interface {
+	Apply(ctx context.Context, owner string, desired []datapath.Object) (*datapath.Plan, error)
+	Remove(ctx context.Context, owner string) error
+}
+
+// Enforcer shapes the egress traffic of the QoS interfaces according to
+// the priorities and bandwidths of the established connections
+type Enforcer struct {
+	// Context for cancellation
+	ctx context.Context
+	// Kubernetes client (controller-runtime)
+	client client.Client
+	// Logger
+	logger *logrus.Logger
+	// Applier programming the qdiscs, classes and filters
+	applier Applier
+	// Interfaces shaped
+	interfaces []string
+	// Link rate in Mbps of the interfaces whose speed is unknown
+	linkRateMbps int
+	// Priority of the unclassified traffic (high, medium, low)
+	defaultPriority string
+	// Mutex for protecting the link rate and the default priority
+	mu sync.Mutex
+	// Interval between updates
+	interval time.Duration
+	// Root of the sysfs tree, overridden in tests
+	sysRoot string
+	// Shaping last applied by interface, to only apply changes
+	applied map[string]string
+	// Progress signal for the watchdog
+	heartbeat *watchdog.Heartbeat
+}
+
+// NewEnforcer creates an enforcer shaping the interfaces
+func NewEnforcer(ctx context.Context, c client.Client, logger *logrus.Logger, applier Applier, interfaces []string,
+	linkRateMbps int, defaultPriority string, interval time.Duration) *Enforcer {
+	return &Enforcer{
+		ctx:             ctx,
+		client:          c,
+		logger:          logger,
+		applier:         applier,
+		interfaces:      interfaces,
+		linkRateMbps:    linkRateMbps,
+		defaultPriority: defaultPriority,
+		interval:        interval,
+		sysRoot:         "/sys",
+		applied:         make(map[string]string),
+	}
+}
+
+// SetHeartbeat makes the enforcer report its progress to the watchdog
+func (e *Enforcer) SetHeartbeat(hb *watchdog.Heartbeat) {
+	e.heartbeat = hb
+	hb.Expect(e.interval)
+}
+
+// SetPolicy changes the link rate of the interfaces whose speed is unknown
+// and the priority of the unclassified traffic, the interfaces are shaped
+// again on the next update
+func (e *Enforcer) SetPolicy(linkRateMbps int, defaultPriority string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.linkRateMbps = linkRateMbps
+	e.defaultPriority = defaultPriority
+}
+
+// Start shapes the interfaces and keeps their shaping up to date with the
+// connections
+func (e *Enforcer) Start() error {
+	e.logger.Infof("Starting QoS enforcement on %s (unclassified traffic %s priority)", strings.Join(e.interfaces, ", "), e.defaultPriority)
+	if err := e.Enforce(e.ctx); err != nil {
+		e.logger.WithError(err).Warn("QoS enforcement failed")
+	}
+
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			e.heartbeat.Beat()
+			if err := e.Enforce(e.ctx); err != nil {
+				e.logger.WithError(err).Warn("QoS enforcement failed")
+			}
+
+		case <-e.ctx.Done():
+			e.logger.Info("Stopping QoS enforcement")
+			return nil
+		}
+	}
+}
+
+// Enforce applies the shaping of the current connections to the
+// interfaces whose shaping changed
+func (e *Enforcer) Enforce(ctx context.Context) error {
+	var conns nsmv1.NetworkConnectionList
+	if err := e.client.List(ctx, &conns); err != nil {
+		return fmt.Errorf("failed to list network connections: %w", err)
+	}
+	var services nsmv1.NetworkServiceList
+	if err := e.client.List(ctx, &services); err != nil {
+		return fmt.Errorf("failed to list network services: %w", err)
+	}
+
+	e.mu.Lock()
+	linkRateMbps, defaultPriority := e.linkRateMbps, e.defaultPriority
+	e.mu.Unlock()
+
+	flows, skipped := Flows(conns.Items, services.Items, defaultPriority)
+	if len(skipped) > 0 {
+		e.logger.Debugf("Not shaping connections without a destination address: %s", strings.Join(skipped, ", "))
+	}
+
+	var errs []error
+	for _, device := range e.interfaces {
+		linkMbps := e.linkRate(device, linkRateMbps)
+		objs := Objects(device, linkMbps, defaultPriority, flows)
+		fingerprint := fmt.Sprint(objs)
+		if e.applied[device] == fingerprint {
+			continue
+		}
+
+		if _, err := e.applier.Apply(ctx, owner(device), objs); err != nil {
+			errs = append(errs, fmt.Errorf("failed to shape %s: %w", device, err))
+			continue
+		}
+		e.applied[device] = fingerprint
+		shapedConnections.WithLabelValues(device).Set(float64(len(flows)))
+
+		reserved := 0
+		for _, flow := range flows {
+			reserved += flow.RateKbit
+		}
+		if reserved > linkMbps*1000 {
+			e.logger.Warnf("Connections on %s are guaranteed %d kbit/s, more than its %d Mbps link rate", device, reserved, linkMbps)
+		}
+		e.logger.Infof("Shaped %s at %d Mbps with %d connection classes", device, linkMbps, len(flows))
+	}
+	return errors.Join(errs...)
+}
+
+// linkRate returns the speed of an interface reported by the kernel, or
+// the configured link rate when it is unknown (e.g., VFs, links down)
+func (e *Enforcer) linkRate(device string, configured int) int {
+	data, err := os.ReadFile(filepath.Join(e.sysRoot, "class/net", device, "speed"))
+	if err != nil {
+		return configured
+	}
+	speed, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || speed <= 0 {
+		return configured
+	}
+	return speed
+}
+
+// owner returns the applier owner of the shaping of an interface
+func owner(device string) string {
+	return "qos/" + device
+}
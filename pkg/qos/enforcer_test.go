@@ -0,0 +1,107 @@
+package qos
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/datapath"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// fakeApplier records the desired objects and the applies by owner
+type fakeApplier struct {
+	objects map[string][]datapath.Object
+	applies map[string]int
+}
+
+func newFakeApplier() *fakeApplier {
+	return &fakeApplier{objects: make(map[string][]datapath.Object), applies: make(map[string]int)}
+}
+
+func (f *fakeApplier) Apply(ctx context.Context, owner string, desired []datapath.Object) (*datapath.Plan, error) {
+	f.objects[owner] = desired
+	f.applies[owner]++
+	return &datapath.Plan{}, nil
+}
+
+func (f *fakeApplier) Remove(ctx context.Context, owner string) error {
+	delete(f.objects, owner)
+	return nil
+}
+
+func TestEnforcer(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := nsmv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	plc := conn("plc", "10.1.0.7", 100, 0)
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(&plc).Build()
+
+	// eth0 reports its speed, the VF doesn't
+	sys := t.TempDir()
+	for device, speed := range map[string]string{"eth0": "10000\n", "eth0v0": "-1\n"} {
+		if err := os.MkdirAll(filepath.Join(sys, "class/net", device), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(sys, "class/net", device, "speed"), []byte(speed), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	applier := newFakeApplier()
+	e := NewEnforcer(context.Background(), c, logger, applier, []string{"eth0", "eth0v0"}, 100, "medium", time.Minute)
+	e.sysRoot = sys
+
+	if err := e.Enforce(context.Background()); err != nil {
+		t.Fatalf("Enforce() error = %v", err)
+	}
+	if len(applier.objects["qos/eth0"]) != 7 || len(applier.objects["qos/eth0v0"]) != 7 {
+		t.Fatalf("shaping = %v, want a connection class on both interfaces", applier.objects)
+	}
+	if link := applier.objects["qos/eth0"][1].(datapath.Class); link.Params[1] != "10000mbit" {
+		t.Errorf("eth0 shaped at %s, want its reported speed", link.Params[1])
+	}
+	if link := applier.objects["qos/eth0v0"][1].(datapath.Class); link.Params[1] != "100mbit" {
+		t.Errorf("VF shaped at %s, want the configured link rate", link.Params[1])
+	}
+
+	// unchanged shaping isn't applied again
+	if err := e.Enforce(context.Background()); err != nil {
+		t.Fatalf("Enforce() error = %v", err)
+	}
+	if applier.applies["qos/eth0"] != 1 {
+		t.Errorf("eth0 applied %d times, want once", applier.applies["qos/eth0"])
+	}
+
+	// the class of a torn down connection goes away
+	if err := c.Delete(context.Background(), &plc); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Enforce(context.Background()); err != nil {
+		t.Fatalf("Enforce() error = %v", err)
+	}
+	if len(applier.objects["qos/eth0"]) != 5 || applier.applies["qos/eth0"] != 2 {
+		t.Errorf("shaping after teardown = %v", applier.objects["qos/eth0"])
+	}
+
+	// a new link rate reshapes the VF only
+	e.SetPolicy(1000, "medium")
+	if err := e.Enforce(context.Background()); err != nil {
+		t.Fatalf("Enforce() error = %v", err)
+	}
+	if link := applier.objects["qos/eth0v0"][1].(datapath.Class); link.Params[1] != "1000mbit" {
+		t.Errorf("VF shaped at %s after the policy change, want 1000mbit", link.Params[1])
+	}
+	if applier.applies["qos/eth0"] != 2 {
+		t.Errorf("eth0 reshaped though its speed is reported")
+	}
+}
@@ -0,0 +1,269 @@
+package qos
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/connection"
+	"github.com/akos011221/nsm/pkg/datapath"
+	"github.com/akos011221/nsm/pkg/external"
+	"github.com/akos011221/nsm/pkg/usage"
+)
+
+// Layout of the shaping of an interface. Under the HTB root qdisc a single
+// class carries the link rate, with a leaf class per priority for the
+// unclassified traffic and one per connection beside them. The class ids
+// are hexadecimal.
+const (
+	// rootHandle is the handle of the HTB root qdisc
+	rootHandle = "1:"
+	// linkClass is the class carrying the link rate
+	linkClass = "1:1"
+	// minConnMinor and maxConnMinor bound the minors of the connection
+	// classes, which also are the preferences of their filters
+	minConnMinor = 0x100
+	maxConnMinor = 0xfff
+	// minRateKbit is the rate guaranteed to connections without a
+	// reservation, so they never starve
+	minRateKbit = 1000
+)
+
+// priorityClass is the class of the unclassified traffic of a priority
+type priorityClass struct {
+	// Minor of the class id
+	minor string
+	// HTB priority, lower ones borrow the spare bandwidth first
+	prio int
+	// Percent of the link rate guaranteed
+	percent int
+}
+
+// priorityClasses are the classes of the priorities (high, medium, low)
+var priorityClasses = map[string]priorityClass{
+	usage.PriorityHigh:   {minor: "10", prio: 0, percent: 50},
+	usage.PriorityMedium: {minor: "20", prio: 1, percent: 30},
+	usage.PriorityLow:    {minor: "30", prio: 2, percent: 20},
+}
+
+// Flow is the traffic of an established connection shaped by its own class
+type Flow struct {
+	// Connection the traffic belongs to
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	// Destination of the traffic in CIDR notation
+	Destination string `json:"destination"`
+	// Priority of the traffic (high, medium, low)
+	Priority string `json:"priority"`
+	// Rate guaranteed in kbit/s, the connection's share of the bandwidth
+	// reserved for its service
+	RateKbit int `json:"rateKbit"`
+	// Rate the traffic is limited to in Mbps, 0 for the link rate
+	CeilMbps int `json:"ceilMbps,omitempty"`
+}
+
+// Flows returns the flows of the established connections, ordered by
+// namespace and name. The priority of a connection is its own, or the one
+// of its service when it has none, or defaultPriority. The bandwidth
+// reserved for a service is shared evenly by its connections. Connections
+// whose destination doesn't resolve to an address are returned as skipped.
+func Flows(conns []nsmv1.NetworkConnection, services []nsmv1.NetworkService, defaultPriority string) ([]Flow, []string) {
+	byKey := make(map[string]*nsmv1.NetworkService, len(services))
+	for i := range services {
+		byKey[services[i].Namespace+"/"+services[i].Name] = &services[i]
+	}
+
+	var flows []Flow
+	var skipped []string
+	// service of each flow, and the number of flows sharing a reservation
+	serviceOf := make([]string, 0, len(conns))
+	shares := make(map[string]int)
+	for i := range conns {
+		conn := &conns[i]
+		if !conn.Status.Established || conn.Spec.AdminState == nsmv1.AdminStateDown || conn.Spec.Canary != nil {
+			continue
+		}
+		namespace, name := connection.Destination(conn)
+		key := namespace + "/" + name
+		svc := byKey[key]
+
+		dst, ok := destination(conn, svc)
+		if !ok {
+			skipped = append(skipped, conn.Namespace+"/"+conn.Name)
+			continue
+		}
+		flow := Flow{
+			Namespace:   conn.Namespace,
+			Name:        conn.Name,
+			Destination: dst,
+			Priority:    priority(conn, svc, defaultPriority),
+			CeilMbps:    conn.Spec.Bandwidth,
+		}
+		if svc != nil && svc.Spec.Bandwidth > 0 {
+			flow.RateKbit = svc.Spec.Bandwidth * 1000
+			shares[key]++
+		}
+		flows = append(flows, flow)
+		serviceOf = append(serviceOf, key)
+	}
+
+	for i := range flows {
+		if n := shares[serviceOf[i]]; n > 0 {
+			flows[i].RateKbit /= n
+		}
+		if flows[i].RateKbit < minRateKbit {
+			flows[i].RateKbit = minRateKbit
+		}
+	}
+
+	sort.Slice(flows, func(i, j int) bool {
+		if flows[i].Namespace != flows[j].Namespace {
+			return flows[i].Namespace < flows[j].Namespace
+		}
+		return flows[i].Name < flows[j].Name
+	})
+	sort.Strings(skipped)
+	return flows, skipped
+}
+
+// destination returns the destination of a connection in CIDR notation:
+// its own when it is an address or a prefix, otherwise the address of the
+// endpoint of its service
+func destination(conn *nsmv1.NetworkConnection, svc *nsmv1.NetworkService) (string, bool) {
+	if prefix, err := netip.ParsePrefix(conn.Spec.Destination); err == nil {
+		return prefix.Masked().String(), true
+	}
+	if addr, err := netip.ParseAddr(conn.Spec.Destination); err == nil {
+		return netip.PrefixFrom(addr, addr.BitLen()).String(), true
+	}
+	if svc == nil {
+		return "", false
+	}
+	host := external.Endpoint(svc)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return "", false
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()).String(), true
+}
+
+// priority returns the priority class of a connection
+func priority(conn *nsmv1.NetworkConnection, svc *nsmv1.NetworkService, defaultPriority string) string {
+	if conn.Spec.Priority > 0 {
+		return usage.PriorityClass(conn.Spec.Priority)
+	}
+	if svc != nil {
+		p := strings.ToLower(svc.Spec.Priority)
+		if _, ok := priorityClasses[p]; ok {
+			return p
+		}
+	}
+	return strings.ToLower(defaultPriority)
+}
+
+// Objects returns the qdiscs, classes and filters shaping the egress
+// traffic of an interface with the link rate: the flows are classified by
+// destination into their classes, the rest of the traffic goes to the
+// class of defaultPriority. Every leaf class queues with fq_codel.
+func Objects(device string, linkMbps int, defaultPriority string, flows []Flow) []datapath.Object {
+	link := mbit(linkMbps)
+	def, ok := priorityClasses[strings.ToLower(defaultPriority)]
+	if !ok {
+		def = priorityClasses[usage.PriorityMedium]
+	}
+
+	objs := []datapath.Object{
+		datapath.Qdisc{Device: device, Handle: rootHandle, Type: "htb", Params: []string{"default", def.minor}},
+		datapath.Class{Device: device, Parent: rootHandle, ID: linkClass, Type: "htb", Params: []string{"rate", link, "ceil", link}},
+	}
+	for _, p := range []string{usage.PriorityHigh, usage.PriorityMedium, usage.PriorityLow} {
+		class := priorityClasses[p]
+		objs = append(objs, datapath.Class{
+			Device: device,
+			Parent: linkClass,
+			ID:     rootHandle + class.minor,
+			Type:   "htb",
+			Params: []string{"rate", kbit(linkMbps * 10 * class.percent), "ceil", link, "prio", strconv.Itoa(class.prio)},
+			Leaf:   "fq_codel",
+		})
+	}
+
+	for i, minor := range connMinors(flows) {
+		flow := flows[i]
+		ceil := linkMbps
+		if flow.CeilMbps > 0 && flow.CeilMbps < ceil {
+			ceil = flow.CeilMbps
+		}
+		rate := flow.RateKbit
+		if rate > ceil*1000 {
+			rate = ceil * 1000
+		}
+		id := fmt.Sprintf("%s%x", rootHandle, minor)
+		objs = append(objs,
+			datapath.Class{
+				Device: device,
+				Parent: linkClass,
+				ID:     id,
+				Type:   "htb",
+				Params: []string{"rate", kbit(rate), "ceil", mbit(ceil), "prio", strconv.Itoa(priorityClasses[flow.Priority].prio)},
+				Leaf:   "fq_codel",
+			},
+			datapath.Filter{
+				Device:   device,
+				Parent:   rootHandle,
+				Pref:     minor,
+				Protocol: protocol(flow.Destination),
+				Match:    []string{"flower", "dst_ip", flow.Destination, "classid", id},
+			})
+	}
+	return objs
+}
+
+// connMinors returns the class minors of the flows. A minor is derived
+// from the connection's name, so it doesn't change when other connections
+// come and go, with collisions resolved by probing.
+func connMinors(flows []Flow) []int {
+	const slots = maxConnMinor - minConnMinor + 1
+	used := make(map[int]bool, len(flows))
+	minors := make([]int, 0, len(flows))
+	for _, flow := range flows {
+		if len(used) == slots {
+			break
+		}
+		h := fnv.New32a()
+		h.Write([]byte(flow.Namespace + "/" + flow.Name))
+		slot := int(h.Sum32() % slots)
+		for used[minConnMinor+slot] {
+			slot = (slot + 1) % slots
+		}
+		used[minConnMinor+slot] = true
+		minors = append(minors, minConnMinor+slot)
+	}
+	return minors
+}
+
+// protocol returns the protocol of the filters matching a destination
+func protocol(cidr string) string {
+	if strings.Contains(cidr, ":") {
+		return "ipv6"
+	}
+	return "ip"
+}
+
+// mbit formats a rate in Mbps for tc
+func mbit(mbps int) string {
+	return strconv.Itoa(mbps) + "mbit"
+}
+
+// kbit formats a rate in kbit/s for tc
+func kbit(kbps int) string {
+	return strconv.Itoa(kbps) + "kbit"
+}
@@ -0,0 +1,117 @@
+package qos
+
+import (
+	"reflect"
+	"testing"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/datapath"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func conn(name, destination string, priority int32, bandwidth int) nsmv1.NetworkConnection {
+	return nsmv1.NetworkConnection{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "edge"},
+		Spec:       nsmv1.NetworkConnectionSpec{Destination: destination, Priority: priority, Bandwidth: bandwidth},
+		Status:     nsmv1.NetworkConnectionStatus{Established: true},
+	}
+}
+
+func service(name, endpoint, priority string, bandwidth int) nsmv1.NetworkService {
+	return nsmv1.NetworkService{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "edge"},
+		Spec:       nsmv1.NetworkServiceSpec{Endpoint: endpoint, Priority: priority, Bandwidth: bandwidth},
+	}
+}
+
+func TestFlows(t *testing.T) {
+	pending := conn("pending", "10.0.0.9", 100, 0)
+	pending.Status.Established = false
+	down := conn("down", "10.0.0.8", 100, 0)
+	down.Spec.AdminState = nsmv1.AdminStateDown
+	conns := []nsmv1.NetworkConnection{
+		conn("scada-b", "scada", 0, 0),
+		conn("scada-a", "scada", 0, 50),
+		conn("video", "2001:db8::/32", 10, 0),
+		conn("plc", "10.1.0.7", 100, 0),
+		conn("cloud", "storage", 0, 0),
+		conn("orphan", "missing", 0, 0),
+		pending,
+		down,
+	}
+	services := []nsmv1.NetworkService{
+		service("scada", "10.2.0.1:502", "medium", 10),
+		service("storage", "storage.example.com:443", "low", 0),
+	}
+
+	flows, skipped := Flows(conns, services, "high")
+	want := []Flow{
+		{Namespace: "edge", Name: "plc", Destination: "10.1.0.7/32", Priority: "high", RateKbit: 1000},
+		{Namespace: "edge", Name: "scada-a", Destination: "10.2.0.1/32", Priority: "medium", RateKbit: 5000, CeilMbps: 50},
+		{Namespace: "edge", Name: "scada-b", Destination: "10.2.0.1/32", Priority: "medium", RateKbit: 5000},
+		{Namespace: "edge", Name: "video", Destination: "2001:db8::/32", Priority: "low", RateKbit: 1000},
+	}
+	if !reflect.DeepEqual(flows, want) {
+		t.Errorf("Flows() = %+v, want %+v", flows, want)
+	}
+	if !reflect.DeepEqual(skipped, []string{"edge/cloud", "edge/orphan"}) {
+		t.Errorf("skipped = %v, want the connections to a DNS name and a missing service", skipped)
+	}
+}
+
+func TestObjects(t *testing.T) {
+	flows := []Flow{
+		{Namespace: "edge", Name: "plc", Destination: "10.1.0.7/32", Priority: "high", RateKbit: 20000},
+		{Namespace: "edge", Name: "video", Destination: "2001:db8::/32", Priority: "low", RateKbit: 5000, CeilMbps: 2},
+	}
+	objs := Objects("eth0", 1000, "low", flows)
+	if len(objs) != 9 {
+		t.Fatalf("got %d objects, want the root qdisc, 4 classes and a class and filter per flow", len(objs))
+	}
+
+	root := objs[0].(datapath.Qdisc)
+	if root.Handle != "1:" || root.Type != "htb" || !reflect.DeepEqual(root.Params, []string{"default", "30"}) {
+		t.Errorf("root qdisc = %+v, want htb defaulting to the low priority class", root)
+	}
+	high := objs[2].(datapath.Class)
+	if high.ID != "1:10" || high.Parent != "1:1" || high.Leaf != "fq_codel" ||
+		!reflect.DeepEqual(high.Params, []string{"rate", "500000kbit", "ceil", "1000mbit", "prio", "0"}) {
+		t.Errorf("high priority class = %+v", high)
+	}
+
+	minors := connMinors(flows)
+	plc := objs[5].(datapath.Class)
+	filter := objs[6].(datapath.Filter)
+	if plc.Parent != "1:1" || !reflect.DeepEqual(plc.Params, []string{"rate", "20000kbit", "ceil", "1000mbit", "prio", "0"}) {
+		t.Errorf("plc class = %+v", plc)
+	}
+	if filter.Pref != minors[0] || filter.Parent != "1:" || filter.Protocol != "ip" ||
+		!reflect.DeepEqual(filter.Match, []string{"flower", "dst_ip", "10.1.0.7/32", "classid", plc.ID}) {
+		t.Errorf("plc filter = %+v, want it classifying into %s", filter, plc.ID)
+	}
+
+	// the guarantee never exceeds the limit
+	video := objs[7].(datapath.Class)
+	if !reflect.DeepEqual(video.Params, []string{"rate", "2000kbit", "ceil", "2mbit", "prio", "2"}) {
+		t.Errorf("video class = %+v", video)
+	}
+	if objs[8].(datapath.Filter).Protocol != "ipv6" {
+		t.Errorf("IPv6 destination not matched as ipv6")
+	}
+}
+
+func TestConnMinorsStable(t *testing.T) {
+	flows := []Flow{{Namespace: "edge", Name: "a"}, {Namespace: "edge", Name: "b"}, {Namespace: "edge", Name: "c"}}
+	minors := connMinors(flows)
+	seen := make(map[int]bool)
+	for _, minor := range minors {
+		if minor < minConnMinor || minor > maxConnMinor || seen[minor] {
+			t.Fatalf("minors = %v, want distinct ones in the connection range", minors)
+		}
+		seen[minor] = true
+	}
+	// removing a connection doesn't move the others
+	if rest := connMinors(flows[1:]); rest[0] != minors[1] || rest[1] != minors[2] {
+		t.Errorf("minors moved from %v to %v", minors[1:], rest)
+	}
+}
@@ -0,0 +1,406 @@
+package reachability
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/connection"
+	"github.com/akos011221/nsm/pkg/external"
+	"github.com/akos011221/nsm/pkg/watchdog"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// Time flaps count against the score of an endpoint
+const flapWindow = time.Hour
+
+// endpointScore exposes the score of every endpoint
+var endpointScore = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "nsm_endpoint_reachability_score",
+	Help: "Reachability score of a NetworkService endpoint from 0 (unreachable) to 100",
+}, []string{"namespace", "service"})
+
+func init() {
+	crmetrics.Registry.MustRegister(endpointScore)
+}
+
+// Endpoint is the reachability of a NetworkService endpoint
+type Endpoint struct {
+	// Namespace of the service
+	Namespace string `json:"namespace"`
+	// Name of the service
+	Name string `json:"name"`
+	// Address of the endpoint
+	Endpoint string `json:"endpoint"`
+	// Type of the service (e.g., l2, l3, vpn)
+	ServiceType string `json:"serviceType,omitempty"`
+	// Score from 0 (unreachable) to 100, higher is better
+	Score int `json:"score"`
+	// Whether the endpoint is reachable
+	Reachable bool `json:"reachable"`
+	// Average latency of the established connections in milliseconds
+	LatencyMs int `json:"latencyMs,omitempty"`
+	// Average packet loss of the established connections in parts per million
+	PacketLossPPM int `json:"packetLossPPM,omitempty"`
+	// Number of reachability changes within the last hour
+	Flaps int `json:"flaps"`
+	// Time of the last reachability change
+	LastFlap *time.Time `json:"lastFlap,omitempty"`
+}
+
+// history is the persisted flap history of an endpoint
+type history struct {
+	// Whether the endpoint was reachable when last observed
+	Reachable bool `json:"reachable"`
+	// Times the reachability changed within the flap window
+	Flaps []time.Time `json:"flaps,omitempty"`
+}
+
+// Scorer scores the reachability of the NetworkService endpoints from
+// their phase, the latency and loss of the connections to them and how
+// often they flapped, and ranks them so consumers and the load balancer
+// pick the best one. The flap history is persisted, so a restart doesn't
+// make an unstable endpoint look stable.
+type Scorer struct {
+	// Context for cancellation
+	ctx context.Context
+	// Kubernetes client (controller-runtime)
+	client client.Client
+	// Logger
+	logger *logrus.Logger
+	// File the flap history is persisted in, empty to keep it in memory
+	stateFile string
+	// Interval between scorings
+	interval time.Duration
+	// Flap history by namespace/name
+	history map[string]*history
+	// Endpoints of the last scoring, ranked
+	ranked []Endpoint
+	// Mutex for protecting the state
+	mu sync.RWMutex
+	// Progress signal for the watchdog
+	heartbeat *watchdog.Heartbeat
+}
+
+// NewScorer creates a new reachability scorer
+func NewScorer(ctx context.Context, c client.Client, logger *logrus.Logger, stateFile string) *Scorer {
+	return &Scorer{
+		ctx:       ctx,
+		client:    c,
+		logger:    logger,
+		stateFile: stateFile,
+		interval:  5 * time.Second,
+		history:   make(map[string]*history),
+	}
+}
+
+// SetHeartbeat makes the scorer report its progress to the watchdog
+func (s *Scorer) SetHeartbeat(hb *watchdog.Heartbeat) {
+	s.heartbeat = hb
+	hb.Expect(s.interval)
+}
+
+// Start loads the flap history and scores the endpoints periodically
+func (s *Scorer) Start() error {
+	s.logger.Info("Starting endpoint reachability scorer")
+	if err := s.Load(); err != nil {
+		s.logger.WithError(err).Warn("Failed to load the flap history, starting without it")
+	}
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			s.heartbeat.Beat()
+
+			var services nsmv1.NetworkServiceList
+			if err := s.client.List(s.ctx, &services); err != nil {
+				s.logger.WithError(err).Warn("Failed to list network services")
+				continue
+			}
+			var conns nsmv1.NetworkConnectionList
+			if err := s.client.List(s.ctx, &conns); err != nil {
+				s.logger.WithError(err).Warn("Failed to list network connections")
+				continue
+			}
+			if err := s.Observe(services.Items, conns.Items, now); err != nil {
+				s.logger.WithError(err).Warn("Failed to persist the flap history")
+			}
+
+		case <-s.ctx.Done():
+			s.logger.Info("Stopping endpoint reachability scorer")
+			return nil
+		}
+	}
+}
+
+// Observe scores the endpoints of the services and records their flaps,
+// persisting the history when it changed
+func (s *Scorer) Observe(services []nsmv1.NetworkService, conns []nsmv1.NetworkConnection, now time.Time) error {
+	stats := connectionStats(conns)
+
+	s.mu.Lock()
+	changed := false
+	seen := make(map[string]bool, len(services))
+	ranked := make([]Endpoint, 0, len(services))
+	for i := range services {
+		svc := &services[i]
+		key := svc.Namespace + "/" + svc.Name
+		seen[key] = true
+		st := stats[key]
+
+		ep := Endpoint{
+			Namespace:     svc.Namespace,
+			Name:          svc.Name,
+			Endpoint:      external.Endpoint(svc),
+			ServiceType:   svc.Spec.ServiceType,
+			Reachable:     reachable(svc, st),
+			LatencyMs:     st.latency(),
+			PacketLossPPM: st.loss(),
+		}
+
+		h, ok := s.history[key]
+		switch {
+		case !ok:
+			h = &history{Reachable: ep.Reachable}
+			s.history[key] = h
+			changed = true
+		case h.Reachable != ep.Reachable:
+			h.Reachable = ep.Reachable
+			h.Flaps = append(h.Flaps, now)
+			changed = true
+		}
+		if pruned := pruneFlaps(h.Flaps, now); len(pruned) != len(h.Flaps) {
+			h.Flaps = pruned
+			changed = true
+		}
+		ep.Flaps = len(h.Flaps)
+		if ep.Flaps > 0 {
+			last := h.Flaps[ep.Flaps-1]
+			ep.LastFlap = &last
+		}
+
+		ep.Score = score(svc, ep)
+		endpointScore.WithLabelValues(svc.Namespace, svc.Name).Set(float64(ep.Score))
+		ranked = append(ranked, ep)
+	}
+	for key := range s.history {
+		if !seen[key] {
+			delete(s.history, key)
+			changed = true
+		}
+	}
+
+	Rank(ranked)
+	s.ranked = ranked
+	s.mu.Unlock()
+
+	if changed {
+		return s.save()
+	}
+	return nil
+}
+
+// Endpoints returns the endpoints of the last scoring, best first,
+// filtered by namespace and service type when not empty
+func (s *Scorer) Endpoints(namespace, serviceType string) []Endpoint {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	endpoints := []Endpoint{}
+	for _, ep := range s.ranked {
+		if (namespace == "" || ep.Namespace == namespace) && (serviceType == "" || ep.ServiceType == serviceType) {
+			endpoints = append(endpoints, ep)
+		}
+	}
+	return endpoints
+}
+
+// Score returns the score of the endpoint of a service
+func (s *Scorer) Score(namespace, name string) (int, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, ep := range s.ranked {
+		if ep.Namespace == namespace && ep.Name == name {
+			return ep.Score, true
+		}
+	}
+	return 0, false
+}
+
+// Rank orders endpoints best first: by score, then latency, then name
+func Rank(endpoints []Endpoint) {
+	sort.SliceStable(endpoints, func(i, j int) bool {
+		a, b := endpoints[i], endpoints[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		if a.LatencyMs != b.LatencyMs {
+			return a.LatencyMs < b.LatencyMs
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Name < b.Name
+	})
+}
+
+// Load reads the persisted flap history
+func (s *Scorer) Load() error {
+	if s.stateFile == "" {
+		return nil
+	}
+	data, err := os.ReadFile(s.stateFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read flap history: %w", err)
+	}
+
+	loaded := make(map[string]*history)
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		return fmt.Errorf("failed to decode flap history: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.history = loaded
+	return nil
+}
+
+// save persists the flap history, replacing the file atomically so a
+// crash never leaves a truncated history behind
+func (s *Scorer) save() error {
+	if s.stateFile == "" {
+		return nil
+	}
+
+	s.mu.RLock()
+	data, err := json.Marshal(s.history)
+	s.mu.RUnlock()
+	if err != nil {
+		return fmt.Errorf("failed to encode flap history: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.stateFile), 0o755); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+	tmp := s.stateFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write flap history: %w", err)
+	}
+	if err := os.Rename(tmp, s.stateFile); err != nil {
+		return fmt.Errorf("failed to replace flap history: %w", err)
+	}
+	return nil
+}
+
+// pruneFlaps drops the flaps older than the flap window
+func pruneFlaps(flaps []time.Time, now time.Time) []time.Time {
+	for i, t := range flaps {
+		if now.Sub(t) < flapWindow {
+			return flaps[i:]
+		}
+	}
+	return nil
+}
+
+// stats aggregates the connections to a service
+type stats struct {
+	// Number of connections
+	total int
+	// Number of established connections
+	established int
+	// Sum of the latency and loss of the established connections with metrics
+	latencySum, lossSum, measured int
+}
+
+func (s stats) latency() int {
+	if s.measured == 0 {
+		return 0
+	}
+	return s.latencySum / s.measured
+}
+
+func (s stats) loss() int {
+	if s.measured == 0 {
+		return 0
+	}
+	return s.lossSum / s.measured
+}
+
+// connectionStats aggregates the connections per destination service
+func connectionStats(conns []nsmv1.NetworkConnection) map[string]stats {
+	result := make(map[string]stats)
+	for _, conn := range conns {
+		namespace, name := connection.Destination(&conn)
+		key := namespace + "/" + name
+		st := result[key]
+		st.total++
+		if conn.Status.Established {
+			st.established++
+			if conn.Status.Metrics.LastUpdated != nil {
+				st.latencySum += conn.Status.Metrics.LatencyMs
+				st.lossSum += conn.Status.Metrics.PacketLossPPM
+				st.measured++
+			}
+		}
+		result[key] = st
+	}
+	return result
+}
+
+// reachable tells whether a service is reachable: from its phase when it
+// has one, otherwise from the connections established to it
+func reachable(svc *nsmv1.NetworkService, st stats) bool {
+	switch svc.Status.Phase {
+	case nsmv1.ServicePhaseReady, nsmv1.ServicePhaseDegraded:
+		return true
+	case nsmv1.ServicePhasePending, nsmv1.ServicePhaseError:
+		return false
+	default:
+		return st.established > 0
+	}
+}
+
+// score rates an endpoint from 0 to 100. Unreachable endpoints score 0,
+// reachable ones at least 1 and lose points for latency (relative to the
+// requirement of the service), loss, flaps and a degraded phase.
+func score(svc *nsmv1.NetworkService, ep Endpoint) int {
+	if !ep.Reachable {
+		return 0
+	}
+
+	s := 100
+	if req := svc.Spec.LatencyRequirement; req > 0 {
+		if ep.LatencyMs > req {
+			s -= 40
+		} else {
+			s -= 20 * ep.LatencyMs / req
+		}
+	} else {
+		s -= min(ep.LatencyMs/5, 30)
+	}
+	// 5 points per 0.1% loss
+	s -= min(ep.PacketLossPPM/200, 40)
+	s -= min(10*ep.Flaps, 50)
+	if svc.Status.Phase == nsmv1.ServicePhaseDegraded {
+		s -= 20
+	}
+	return max(s, 1)
+}
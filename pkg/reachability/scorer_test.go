@@ -0,0 +1,119 @@
+package reachability
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func quietLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return logger
+}
+
+func service(name, phase string, latencyRequirement int) nsmv1.NetworkService {
+	return nsmv1.NetworkService{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "edge", Name: name},
+		Spec:       nsmv1.NetworkServiceSpec{ServiceType: "l3", Endpoint: name + ":8554", LatencyRequirement: latencyRequirement},
+		Status:     nsmv1.NetworkServiceStatus{Phase: phase},
+	}
+}
+
+func newConnection(name, destination string, established bool, latencyMs, lossPPM int) nsmv1.NetworkConnection {
+	now := metav1.Now()
+	return nsmv1.NetworkConnection{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "edge", Name: name},
+		Spec:       nsmv1.NetworkConnectionSpec{Destination: destination},
+		Status: nsmv1.NetworkConnectionStatus{
+			Established: established,
+			Metrics:     nsmv1.ConnectionMetrics{LatencyMs: latencyMs, PacketLossPPM: lossPPM, LastUpdated: &now},
+		},
+	}
+}
+
+func TestScorerRanksEndpoints(t *testing.T) {
+	s := NewScorer(context.Background(), nil, quietLogger(), "")
+	services := []nsmv1.NetworkService{
+		service("slow", nsmv1.ServicePhaseReady, 10),
+		service("fast", nsmv1.ServicePhaseReady, 10),
+		service("lossy", "", 0),
+		service("down", nsmv1.ServicePhaseError, 0),
+	}
+	conns := []nsmv1.NetworkConnection{
+		newConnection("a", "slow", true, 25, 0),
+		newConnection("b", "fast", true, 2, 0),
+		// 1% loss
+		newConnection("c", "lossy", true, 0, 10000),
+	}
+	if err := s.Observe(services, conns, time.Now()); err != nil {
+		t.Fatalf("Observe() error = %v", err)
+	}
+
+	ranked := s.Endpoints("", "")
+	var order []string
+	for _, ep := range ranked {
+		order = append(order, ep.Name)
+	}
+	want := []string{"fast", "lossy", "slow", "down"}
+	for i := range want {
+		if i >= len(order) || order[i] != want[i] {
+			t.Fatalf("ranking = %v, want %v", order, want)
+		}
+	}
+	if ranked[0].Score != 96 || ranked[1].Score != 60 || ranked[2].Score != 60 || ranked[3].Score != 0 {
+		t.Errorf("unexpected scores %+v", ranked)
+	}
+	if got := testutil.ToFloat64(endpointScore.WithLabelValues("edge", "fast")); got != 96 {
+		t.Errorf("score metric = %v, want 96", got)
+	}
+	if score, ok := s.Score("edge", "down"); !ok || score != 0 {
+		t.Errorf("Score() = %d, %v", score, ok)
+	}
+	if eps := s.Endpoints("edge", "vpn"); len(eps) != 0 {
+		t.Errorf("filter by service type returned %+v", eps)
+	}
+}
+
+func TestScorerPersistsFlapHistory(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "state", "reachability.json")
+	s := NewScorer(context.Background(), nil, quietLogger(), stateFile)
+	now := time.Now()
+
+	// the endpoint flaps down and up again
+	for i, phase := range []string{nsmv1.ServicePhaseReady, nsmv1.ServicePhaseError, nsmv1.ServicePhaseReady} {
+		if err := s.Observe([]nsmv1.NetworkService{service("camera", phase, 0)}, nil, now.Add(time.Duration(i)*time.Minute)); err != nil {
+			t.Fatalf("Observe() error = %v", err)
+		}
+	}
+	if ep := s.Endpoints("", "")[0]; ep.Flaps != 2 || ep.Score != 80 {
+		t.Fatalf("unexpected endpoint %+v", ep)
+	}
+
+	// a restarted scorer remembers the flaps
+	restarted := NewScorer(context.Background(), nil, quietLogger(), stateFile)
+	if err := restarted.Load(); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if err := restarted.Observe([]nsmv1.NetworkService{service("camera", nsmv1.ServicePhaseReady, 0)}, nil, now.Add(3*time.Minute)); err != nil {
+		t.Fatalf("Observe() error = %v", err)
+	}
+	if ep := restarted.Endpoints("", "")[0]; ep.Flaps != 2 {
+		t.Errorf("flaps after restart = %d, want 2", ep.Flaps)
+	}
+
+	// flaps age out of the window
+	if err := restarted.Observe([]nsmv1.NetworkService{service("camera", nsmv1.ServicePhaseReady, 0)}, nil, now.Add(2*time.Hour)); err != nil {
+		t.Fatalf("Observe() error = %v", err)
+	}
+	if ep := restarted.Endpoints("", "")[0]; ep.Flaps != 0 || ep.Score != 100 {
+		t.Errorf("unexpected endpoint after the flap window %+v", ep)
+	}
+}
@@ -0,0 +1,232 @@
+package registry
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// Device is a device or endpoint of an external inventory
+type Device struct {
+	// Name of the device in the inventory
+	Name string
+	// Address the device is reachable at (host:port, IP or DNS name)
+	Endpoint string
+	// Type of the service (e.g., l2, l3, vpn), the sync default if empty
+	ServiceType string
+	// Namespace of the service, the sync default if empty
+	Namespace string
+}
+
+// Source is an external system of record for devices
+type Source interface {
+	// Name identifies the source in logs and metrics
+	Name() string
+	// Devices fetches the current inventory
+	Devices(ctx context.Context) ([]Device, error)
+}
+
+// CSVSource reads the inventory from a CSV file or HTTP endpoint. The
+// first row is a header with the columns name and endpoint, and
+// optionally serviceType and namespace.
+type CSVSource struct {
+	// File path or http(s) URL of the CSV
+	location string
+	// HTTP client the CSV is fetched with
+	httpClient *http.Client
+}
+
+// NewCSVSource creates a source reading a CSV file or HTTP endpoint
+func NewCSVSource(location string, httpClient *http.Client) *CSVSource {
+	return &CSVSource{location: location, httpClient: httpClient}
+}
+
+// Name identifies the source
+func (s *CSVSource) Name() string {
+	return "csv"
+}
+
+// Devices reads the rows of the CSV
+func (s *CSVSource) Devices(ctx context.Context) ([]Device, error) {
+	var body io.ReadCloser
+	if strings.HasPrefix(s.location, "http://") || strings.HasPrefix(s.location, "https://") {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.location, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to build inventory request: %w", err)
+		}
+		resp, err := s.httpClient.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch inventory: %w", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("inventory request failed with status %d", resp.StatusCode)
+		}
+		body = resp.Body
+	} else {
+		f, err := os.Open(s.location)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open inventory: %w", err)
+		}
+		body = f
+	}
+	defer body.Close()
+
+	return parseCSV(body)
+}
+
+// parseCSV parses the rows of an inventory CSV
+func parseCSV(r io.Reader) ([]Device, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read inventory header: %w", err)
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.TrimSpace(name)] = i
+	}
+	for _, required := range []string{"name", "endpoint"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("inventory has no %s column", required)
+		}
+	}
+	field := func(record []string, column string) string {
+		i, ok := columns[column]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	var devices []Device
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read inventory: %w", err)
+		}
+		devices = append(devices, Device{
+			Name:        field(record, "name"),
+			Endpoint:    field(record, "endpoint"),
+			ServiceType: field(record, "serviceType"),
+			Namespace:   field(record, "namespace"),
+		})
+	}
+	return devices, nil
+}
+
+// NetBoxSource reads the devices with a primary IP from NetBox. The
+// service type is read from the nsm_service_type custom field.
+type NetBoxSource struct {
+	// Base URL of NetBox (e.g., https://netbox.example.com)
+	baseURL string
+	// API token
+	token string
+	// Query parameters filtering the devices (e.g., site=edge-01)
+	filter url.Values
+	// HTTP client the API is called with
+	httpClient *http.Client
+}
+
+// NewNetBoxSource creates a source reading devices from NetBox
+func NewNetBoxSource(baseURL, token string, filter url.Values, httpClient *http.Client) *NetBoxSource {
+	return &NetBoxSource{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		token:      token,
+		filter:     filter,
+		httpClient: httpClient,
+	}
+}
+
+// Name identifies the source
+func (s *NetBoxSource) Name() string {
+	return "netbox"
+}
+
+// netboxPage is a page of the NetBox device list
+type netboxPage struct {
+	// URL of the next page, empty on the last one
+	Next string `json:"next"`
+	// Devices of the page
+	Results []struct {
+		Name      string `json:"name"`
+		PrimaryIP *struct {
+			// Address with prefix length (e.g., 10.0.0.5/24)
+			Address string `json:"address"`
+		} `json:"primary_ip"`
+		CustomFields map[string]interface{} `json:"custom_fields"`
+	} `json:"results"`
+}
+
+// Devices pages through the device list
+func (s *NetBoxSource) Devices(ctx context.Context) ([]Device, error) {
+	query := url.Values{}
+	for k, v := range s.filter {
+		query[k] = v
+	}
+	query.Set("has_primary_ip", "true")
+	query.Set("limit", "1000")
+	next := s.baseURL + "/api/dcim/devices/?" + query.Encode()
+
+	var devices []Device
+	for next != "" {
+		page, err := s.fetch(ctx, next)
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range page.Results {
+			if d.PrimaryIP == nil {
+				continue
+			}
+			ip, _, err := net.ParseCIDR(d.PrimaryIP.Address)
+			if err != nil {
+				return nil, fmt.Errorf("invalid primary IP %q of device %s: %w", d.PrimaryIP.Address, d.Name, err)
+			}
+			serviceType, _ := d.CustomFields["nsm_service_type"].(string)
+			devices = append(devices, Device{Name: d.Name, Endpoint: ip.String(), ServiceType: serviceType})
+		}
+		next = page.Next
+	}
+	return devices, nil
+}
+
+// fetch gets a page of the device list
+func (s *NetBoxSource) fetch(ctx context.Context, pageURL string) (*netboxPage, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pageURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build NetBox request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	if s.token != "" {
+		req.Header.Set("Authorization", "Token "+s.token)
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list NetBox devices: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("NetBox device list failed with status %d", resp.StatusCode)
+	}
+
+	var page netboxPage
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		return nil, fmt.Errorf("failed to decode NetBox devices: %w", err)
+	}
+	return &page, nil
+}
@@ -0,0 +1,260 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/cloud"
+	"github.com/akos011221/nsm/pkg/watchdog"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// LabelRegistry marks the services materialized from an external
+// inventory with the name of the source, only those are ever deleted
+const LabelRegistry = "nsm.akosrbn.io/registry"
+
+// invalidNameChars matches the runs of characters not allowed in object names
+var invalidNameChars = regexp.MustCompile(`[^a-z0-9.-]+`)
+
+var (
+	// syncsTotal counts the inventory syncs by result
+	syncsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "nsm_registry_syncs_total",
+		Help: "Number of syncs of the external device inventory, by result",
+	}, []string{"result"})
+
+	// syncedDevices is the number of services materialized from the inventory
+	syncedDevices = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "nsm_registry_devices",
+		Help: "Number of NetworkServices materialized from the external device inventory",
+	})
+)
+
+func init() {
+	crmetrics.Registry.MustRegister(syncsTotal, syncedDevices)
+}
+
+// Result reports what a sync changed
+type Result struct {
+	// Services created, as namespace/name
+	Created []string
+	// Services whose endpoint or type was updated
+	Updated []string
+	// Services of devices removed from the inventory
+	Deleted []string
+	// Devices that were not materialized, with the reason
+	Skipped []string
+}
+
+// Syncer materializes the devices of an external inventory as
+// NetworkServices and keeps them in line with it. Services not created by
+// the syncer are never touched, and an empty inventory never deletes
+// anything, so an outage of the source doesn't cut off a site.
+type Syncer struct {
+	// Context for cancellation
+	ctx context.Context
+	// Kubernetes client (controller-runtime)
+	client client.Client
+	// Logger
+	logger *logrus.Logger
+	// System of record of the devices
+	source Source
+	// Namespace of the services of devices without one
+	namespace string
+	// Service type of devices without one
+	serviceType string
+	// Interval between syncs
+	interval time.Duration
+	// Connectivity to the cloud, the syncs pause while the node runs
+	// autonomously, nil to always sync
+	cloud cloud.Signal
+	// Progress signal for the watchdog
+	heartbeat *watchdog.Heartbeat
+}
+
+// NewSyncer creates a new inventory syncer
+func NewSyncer(ctx context.Context, c client.Client, logger *logrus.Logger, source Source, namespace, serviceType string, interval time.Duration) *Syncer {
+	return &Syncer{
+		ctx:         ctx,
+		client:      c,
+		logger:      logger,
+		source:      source,
+		namespace:   namespace,
+		serviceType: serviceType,
+		interval:    interval,
+	}
+}
+
+// SetHeartbeat makes the syncer report its progress to the watchdog
+func (s *Syncer) SetHeartbeat(hb *watchdog.Heartbeat) {
+	s.heartbeat = hb
+	hb.Expect(s.interval)
+}
+
+// SetCloudSignal pauses the syncs while the node is cut off from the
+// cloud, the services of the last sync keep serving the site until the
+// inventory is reachable again
+func (s *Syncer) SetCloudSignal(signal cloud.Signal) {
+	s.cloud = signal
+}
+
+// Start syncs the inventory on start and periodically
+func (s *Syncer) Start() error {
+	s.logger.Infof("Starting %s inventory sync (every %s)", s.source.Name(), s.interval)
+	s.syncAndLog()
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.heartbeat.Beat()
+			s.syncAndLog()
+
+		case <-s.ctx.Done():
+			s.logger.Info("Stopping inventory sync")
+			return nil
+		}
+	}
+}
+
+// syncAndLog syncs the inventory and logs the outcome
+func (s *Syncer) syncAndLog() {
+	if s.cloud != nil && s.cloud.Autonomous() {
+		s.logger.Debugf("Skipping the %s inventory sync, the node runs autonomously", s.source.Name())
+		return
+	}
+	result, err := s.Sync(s.ctx)
+	if err != nil {
+		syncsTotal.WithLabelValues("failure").Inc()
+		s.logger.WithError(err).Warnf("Failed to sync the %s inventory", s.source.Name())
+		return
+	}
+	syncsTotal.WithLabelValues("success").Inc()
+	for _, skipped := range result.Skipped {
+		s.logger.Warnf("Skipped inventory device %s", skipped)
+	}
+	if len(result.Created)+len(result.Updated)+len(result.Deleted) > 0 {
+		s.logger.Infof("Synced the %s inventory: %d created, %d updated, %d deleted",
+			s.source.Name(), len(result.Created), len(result.Updated), len(result.Deleted))
+	}
+}
+
+// Sync fetches the inventory and creates, updates and deletes the
+// services of its devices
+func (s *Syncer) Sync(ctx context.Context) (*Result, error) {
+	devices, err := s.source.Devices(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var existing nsmv1.NetworkServiceList
+	if err := s.client.List(ctx, &existing, client.MatchingLabels{LabelRegistry: s.source.Name()}); err != nil {
+		return nil, fmt.Errorf("failed to list synced services: %w", err)
+	}
+	if len(devices) == 0 && len(existing.Items) > 0 {
+		return nil, fmt.Errorf("%s inventory is empty, keeping the %d synced services", s.source.Name(), len(existing.Items))
+	}
+
+	result := &Result{}
+	wanted := make(map[client.ObjectKey]bool)
+	for _, device := range devices {
+		key, err := s.serviceKey(device)
+		if err != nil {
+			result.Skipped = append(result.Skipped, fmt.Sprintf("%q: %v", device.Name, err))
+			continue
+		}
+		if wanted[key] {
+			result.Skipped = append(result.Skipped, fmt.Sprintf("%q: duplicate of service %s", device.Name, key))
+			continue
+		}
+		wanted[key] = true
+
+		if err := s.apply(ctx, key, device, result); err != nil {
+			return result, err
+		}
+	}
+
+	for i := range existing.Items {
+		svc := &existing.Items[i]
+		key := client.ObjectKeyFromObject(svc)
+		if wanted[key] {
+			continue
+		}
+		if err := s.client.Delete(ctx, svc); client.IgnoreNotFound(err) != nil {
+			return result, fmt.Errorf("failed to delete service %s: %w", key, err)
+		}
+		result.Deleted = append(result.Deleted, key.String())
+	}
+
+	sort.Strings(result.Deleted)
+	syncedDevices.Set(float64(len(wanted)))
+	return result, nil
+}
+
+// apply creates the service of a device or updates its endpoint and type
+func (s *Syncer) apply(ctx context.Context, key client.ObjectKey, device Device, result *Result) error {
+	serviceType := device.ServiceType
+	if serviceType == "" {
+		serviceType = s.serviceType
+	}
+
+	svc := &nsmv1.NetworkService{ObjectMeta: metav1.ObjectMeta{Namespace: key.Namespace, Name: key.Name}}
+	err := s.client.Get(ctx, key, svc)
+	if client.IgnoreNotFound(err) != nil {
+		return fmt.Errorf("failed to get service %s: %w", key, err)
+	}
+	if err == nil && svc.Labels[LabelRegistry] != s.source.Name() {
+		result.Skipped = append(result.Skipped, fmt.Sprintf("%q: service %s is not managed by the %s sync", device.Name, key, s.source.Name()))
+		return nil
+	}
+
+	op, err := controllerutil.CreateOrUpdate(ctx, s.client, svc, func() error {
+		if svc.Labels == nil {
+			svc.Labels = make(map[string]string)
+		}
+		svc.Labels[LabelRegistry] = s.source.Name()
+		svc.Spec.Endpoint = device.Endpoint
+		svc.Spec.ServiceType = serviceType
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to apply service %s: %w", key, err)
+	}
+	switch op {
+	case controllerutil.OperationResultCreated:
+		result.Created = append(result.Created, key.String())
+	case controllerutil.OperationResultUpdated:
+		result.Updated = append(result.Updated, key.String())
+	}
+	return nil
+}
+
+// serviceKey derives the name of the service of a device, e.g. "Cam 17"
+// becomes cam-17
+func (s *Syncer) serviceKey(device Device) (client.ObjectKey, error) {
+	if device.Endpoint == "" {
+		return client.ObjectKey{}, fmt.Errorf("no endpoint")
+	}
+	name := strings.Trim(invalidNameChars.ReplaceAllString(strings.ToLower(device.Name), "-"), "-.")
+	if errs := validation.IsDNS1123Subdomain(name); len(errs) > 0 {
+		return client.ObjectKey{}, fmt.Errorf("invalid service name %q: %s", name, strings.Join(errs, ", "))
+	}
+	namespace := device.Namespace
+	if namespace == "" {
+		namespace = s.namespace
+	}
+	return client.ObjectKey{Namespace: namespace, Name: name}, nil
+}
@@ -0,0 +1,187 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newClient(t *testing.T, objs ...client.Object) client.Client {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := nsmv1.AddToScheme(scheme); err != nil {
+		t.Fatalf("failed to build scheme: %v", err)
+	}
+	return fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build()
+}
+
+func quietLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return logger
+}
+
+// staticSource is an inventory with fixed devices
+type staticSource []Device
+
+func (s *staticSource) Name() string { return "static" }
+
+func (s *staticSource) Devices(context.Context) ([]Device, error) { return *s, nil }
+
+func TestSync(t *testing.T) {
+	manual := &nsmv1.NetworkService{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "edge", Name: "gateway"},
+		Spec:       nsmv1.NetworkServiceSpec{ServiceType: "vpn", Endpoint: "192.0.2.1"},
+	}
+	c := newClient(t, manual)
+	source := &staticSource{
+		{Name: "Cam 17", Endpoint: "10.0.0.17"},
+		{Name: "lidar-1", Endpoint: "10.0.0.21:7000", ServiceType: "l2", Namespace: "sensors"},
+		{Name: "gateway", Endpoint: "10.0.0.1"},
+		{Name: "no-address"},
+	}
+	s := NewSyncer(context.Background(), c, quietLogger(), source, "edge", "l3", time.Minute)
+	ctx := context.Background()
+
+	result, err := s.Sync(ctx)
+	if err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if !reflect.DeepEqual(result.Created, []string{"edge/cam-17", "sensors/lidar-1"}) || len(result.Skipped) != 2 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	var svc nsmv1.NetworkService
+	if err := c.Get(ctx, client.ObjectKey{Namespace: "edge", Name: "cam-17"}, &svc); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if svc.Spec.Endpoint != "10.0.0.17" || svc.Spec.ServiceType != "l3" || svc.Labels[LabelRegistry] != "static" {
+		t.Errorf("unexpected service %+v", svc)
+	}
+	if err := c.Get(ctx, client.ObjectKeyFromObject(manual), &svc); err != nil || svc.Spec.Endpoint != "192.0.2.1" {
+		t.Errorf("sync changed a service it doesn't manage: %+v", svc.Spec)
+	}
+
+	// a moved device is updated, a removed one deleted
+	*source = staticSource{{Name: "Cam 17", Endpoint: "10.0.0.170"}}
+	result, err = s.Sync(ctx)
+	if err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if !reflect.DeepEqual(result.Updated, []string{"edge/cam-17"}) || !reflect.DeepEqual(result.Deleted, []string{"sensors/lidar-1"}) {
+		t.Errorf("unexpected result %+v", result)
+	}
+
+	// an empty inventory never deletes the synced services
+	*source = nil
+	if _, err := s.Sync(ctx); err == nil {
+		t.Errorf("expected an error for an empty inventory")
+	}
+	if err := c.Get(ctx, client.ObjectKey{Namespace: "edge", Name: "cam-17"}, &svc); err != nil {
+		t.Errorf("empty inventory deleted a service: %v", err)
+	}
+}
+
+// autonomousSignal reports a fixed connectivity to the cloud
+type autonomousSignal bool
+
+func (a autonomousSignal) Autonomous() bool { return bool(a) }
+
+func TestSyncPausesWhileAutonomous(t *testing.T) {
+	c := newClient(t)
+	source := &staticSource{{Name: "cam-17", Endpoint: "10.0.0.17"}}
+	s := NewSyncer(context.Background(), c, quietLogger(), source, "edge", "l3", time.Minute)
+	s.SetCloudSignal(autonomousSignal(true))
+
+	s.syncAndLog()
+	var services nsmv1.NetworkServiceList
+	if err := c.List(context.Background(), &services); err != nil {
+		t.Fatal(err)
+	}
+	if len(services.Items) != 0 {
+		t.Fatalf("synced %d services while autonomous", len(services.Items))
+	}
+
+	s.SetCloudSignal(autonomousSignal(false))
+	s.syncAndLog()
+	if err := c.List(context.Background(), &services); err != nil {
+		t.Fatal(err)
+	}
+	if len(services.Items) != 1 {
+		t.Errorf("synced %d services once connected, want 1", len(services.Items))
+	}
+}
+
+func TestCSVSource(t *testing.T) {
+	csv := "name,endpoint,serviceType\ncam-1, 10.0.0.1,l2\ncam-2,10.0.0.2\n"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, csv)
+	}))
+	defer server.Close()
+
+	devices, err := NewCSVSource(server.URL, server.Client()).Devices(context.Background())
+	if err != nil {
+		t.Fatalf("Devices() error = %v", err)
+	}
+	want := []Device{
+		{Name: "cam-1", Endpoint: "10.0.0.1", ServiceType: "l2"},
+		{Name: "cam-2", Endpoint: "10.0.0.2"},
+	}
+	if !reflect.DeepEqual(devices, want) {
+		t.Errorf("Devices() = %+v, want %+v", devices, want)
+	}
+
+	if _, err := parseCSV(strings.NewReader("name,address\ncam-1,10.0.0.1\n")); err == nil {
+		t.Errorf("expected an error without an endpoint column")
+	}
+}
+
+func TestNetBoxSource(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Token s3cret" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if r.URL.Query().Get("site") != "edge-01" || r.URL.Query().Get("has_primary_ip") != "true" {
+			t.Errorf("unexpected query %s", r.URL.RawQuery)
+		}
+		if r.URL.Query().Get("offset") == "" {
+			fmt.Fprintf(w, `{"next": %q, "results": [{"name": "cam-1", "primary_ip": {"address": "10.0.0.1/24"}, "custom_fields": {"nsm_service_type": "l2"}}]}`,
+				server.URL+"/api/dcim/devices/?site=edge-01&has_primary_ip=true&offset=1")
+			return
+		}
+		fmt.Fprint(w, `{"next": null, "results": [{"name": "cam-2", "primary_ip": {"address": "2001:db8::2/64"}, "custom_fields": {}}]}`)
+	}))
+	defer server.Close()
+
+	source := NewNetBoxSource(server.URL+"/", "s3cret", url.Values{"site": {"edge-01"}}, server.Client())
+	devices, err := source.Devices(context.Background())
+	if err != nil {
+		t.Fatalf("Devices() error = %v", err)
+	}
+	want := []Device{
+		{Name: "cam-1", Endpoint: "10.0.0.1", ServiceType: "l2"},
+		{Name: "cam-2", Endpoint: "2001:db8::2"},
+	}
+	if !reflect.DeepEqual(devices, want) {
+		t.Errorf("Devices() = %+v, want %+v", devices, want)
+	}
+
+	if _, err := NewNetBoxSource(server.URL, "wrong", nil, server.Client()).Devices(context.Background()); err == nil {
+		t.Errorf("expected an error for a rejected token")
+	}
+}
@@ -0,0 +1,171 @@
+package rekey
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/connection"
+	"github.com/akos011221/nsm/pkg/watchdog"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// Delay before a failed rekey is attempted again
+const retryInterval = time.Minute
+
+// rekeysTotal counts the rekeys per result (success, failure)
+var rekeysTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "nsm_rekeys_total",
+	Help: "Number of key rotations of encrypted connections, by result",
+}, []string{"result"})
+
+func init() {
+	crmetrics.Registry.MustRegister(rekeysTotal)
+}
+
+// Scheduler periodically rotates the keys of the encrypted connections of
+// the node. At most one connection is rekeyed per gap, so the connections
+// of a site established together don't all rekey (and drop packets during
+// the key switch) at the same moment.
+type Scheduler struct {
+	// Context for cancellation
+	ctx context.Context
+	// Kubernetes client (controller-runtime)
+	client client.Client
+	// Logger
+	logger *logrus.Logger
+	// Rotates the keys of a connection
+	rekeyer connection.Rekeyer
+	// Interval between rekeys of connections that don't set their own
+	interval time.Duration
+	// Minimum time between two rekeys on the node
+	gap time.Duration
+	// Node whose connections are rekeyed, all if empty
+	node string
+	// Earliest retry of connections whose rekey failed
+	retryAt map[types.NamespacedName]time.Time
+	// Progress signal for the watchdog
+	heartbeat *watchdog.Heartbeat
+}
+
+// NewScheduler creates a new key rotation scheduler
+func NewScheduler(ctx context.Context, c client.Client, logger *logrus.Logger, rekeyer connection.Rekeyer, interval, gap time.Duration) *Scheduler {
+	return &Scheduler{
+		ctx:      ctx,
+		client:   c,
+		logger:   logger,
+		rekeyer:  rekeyer,
+		interval: interval,
+		gap:      gap,
+		retryAt:  make(map[types.NamespacedName]time.Time),
+	}
+}
+
+// SetHeartbeat makes the scheduler report its progress to the watchdog
+func (s *Scheduler) SetHeartbeat(hb *watchdog.Heartbeat) {
+	s.heartbeat = hb
+	hb.Expect(s.gap)
+}
+
+// SetNode limits the rekeys to the connections established by the node,
+// the other nodes rotate the keys of theirs
+func (s *Scheduler) SetNode(node string) {
+	s.node = node
+}
+
+// Start rekeys the due connections, one per gap
+func (s *Scheduler) Start() error {
+	s.logger.Infof("Starting key rotation scheduler (interval %s, gap %s)", s.interval, s.gap)
+
+	ticker := time.NewTicker(s.gap)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			s.heartbeat.Beat()
+			if err := s.Tick(now); err != nil {
+				s.logger.WithError(err).Error("Key rotation failed")
+			}
+
+		case <-s.ctx.Done():
+			s.logger.Info("Stopping key rotation scheduler")
+			return nil
+		}
+	}
+}
+
+// Tick rekeys the connection that has been due the longest, if any
+func (s *Scheduler) Tick(now time.Time) error {
+	var conns nsmv1.NetworkConnectionList
+	if err := s.client.List(s.ctx, &conns); err != nil {
+		return fmt.Errorf("failed to list connections: %w", err)
+	}
+
+	var due []nsmv1.NetworkConnection
+	for _, conn := range conns.Items {
+		key := types.NamespacedName{Namespace: conn.Namespace, Name: conn.Name}
+		if now.Before(s.retryAt[key]) {
+			continue
+		}
+		if s.encrypted(&conn) && !now.Before(s.due(&conn)) {
+			due = append(due, conn)
+		}
+	}
+	if len(due) == 0 {
+		return nil
+	}
+	sort.Slice(due, func(i, j int) bool {
+		di, dj := s.due(&due[i]), s.due(&due[j])
+		if !di.Equal(dj) {
+			return di.Before(dj)
+		}
+		return due[i].Namespace+"/"+due[i].Name < due[j].Namespace+"/"+due[j].Name
+	})
+
+	conn := &due[0]
+	key := types.NamespacedName{Namespace: conn.Namespace, Name: conn.Name}
+	if err := s.rekeyer.Rekey(s.ctx, conn); err != nil {
+		rekeysTotal.WithLabelValues("failure").Inc()
+		s.retryAt[key] = now.Add(retryInterval)
+		return fmt.Errorf("failed to rekey connection %s/%s: %w", conn.Namespace, conn.Name, err)
+	}
+	delete(s.retryAt, key)
+	rekeysTotal.WithLabelValues("success").Inc()
+	s.logger.Infof("Rotated the keys of connection %s/%s", conn.Namespace, conn.Name)
+
+	rekeyed := metav1.NewTime(now)
+	conn.Status.LastRekeyTime = &rekeyed
+	if err := s.client.Status().Update(s.ctx, conn); err != nil {
+		return fmt.Errorf("failed to update connection status: %w", err)
+	}
+	return nil
+}
+
+// encrypted reports whether the connection carries encrypted traffic
+// through the node
+func (s *Scheduler) encrypted(conn *nsmv1.NetworkConnection) bool {
+	return conn.Status.Established && conn.Status.Encryption != "" && conn.Spec.Canary == nil &&
+		(s.node == "" || conn.Status.Node == "" || conn.Status.Node == s.node)
+}
+
+// due returns when the keys of the connection are to be rotated next
+func (s *Scheduler) due(conn *nsmv1.NetworkConnection) time.Time {
+	interval := s.interval
+	if conn.Spec.RekeyIntervalSeconds > 0 {
+		interval = time.Duration(conn.Spec.RekeyIntervalSeconds) * time.Second
+	}
+
+	last := conn.CreationTimestamp.Time
+	if conn.Status.LastRekeyTime != nil {
+		last = conn.Status.LastRekeyTime.Time
+	}
+	return last.Add(interval)
+}
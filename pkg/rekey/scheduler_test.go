@@ -0,0 +1,156 @@
+package rekey
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// recordingRekeyer records the rekeyed connections
+type recordingRekeyer struct {
+	rekeyed []string
+	err     error
+}
+
+func (r *recordingRekeyer) Rekey(ctx context.Context, conn *nsmv1.NetworkConnection) error {
+	r.rekeyed = append(r.rekeyed, conn.Name)
+	return r.err
+}
+
+func quietLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return logger
+}
+
+func newTestClient(t *testing.T, objs ...client.Object) client.Client {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := nsmv1.AddToScheme(scheme); err != nil {
+		t.Fatalf("failed to build scheme: %v", err)
+	}
+	return fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(objs...).
+		WithStatusSubresource(&nsmv1.NetworkConnection{}).
+		Build()
+}
+
+// encryptedConnection returns an established encrypted connection created at created
+func encryptedConnection(name string, created time.Time) *nsmv1.NetworkConnection {
+	return &nsmv1.NetworkConnection{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "edge", CreationTimestamp: metav1.NewTime(created)},
+		Spec:       nsmv1.NetworkConnectionSpec{ConnectionType: nsmv1.ConnectionTypeWireGuard},
+		Status: nsmv1.NetworkConnectionStatus{
+			State:       nsmv1.ConnectionStateEstablished,
+			Established: true,
+			Encryption:  nsmv1.EncryptionModeSoftware,
+		},
+	}
+}
+
+func TestTickRekeysOneDueConnectionAtATime(t *testing.T) {
+	start := time.Now().Truncate(time.Second)
+	plain := encryptedConnection("plain", start)
+	plain.Status.Encryption = ""
+	custom := encryptedConnection("custom", start)
+	custom.Spec.RekeyIntervalSeconds = 60
+
+	c := newTestClient(t,
+		encryptedConnection("a", start), encryptedConnection("b", start.Add(time.Second)), custom, plain)
+	rekeyer := &recordingRekeyer{}
+	s := NewScheduler(context.Background(), c, quietLogger(), rekeyer, time.Hour, 10*time.Second)
+	before := testutil.ToFloat64(rekeysTotal.WithLabelValues("success"))
+
+	if err := s.Tick(start.Add(30 * time.Second)); err != nil || len(rekeyer.rekeyed) != 0 {
+		t.Fatalf("nothing is due yet, rekeyed %v (err %v)", rekeyer.rekeyed, err)
+	}
+
+	// the per-connection interval comes first, the rest follow one per tick
+	// in the order they became due
+	now := start.Add(2 * time.Hour)
+	for i := 0; i < 5; i++ {
+		if err := s.Tick(now); err != nil {
+			t.Fatalf("Tick() error = %v", err)
+		}
+		now = now.Add(10 * time.Second)
+	}
+	if got := rekeyer.rekeyed; len(got) != 3 || got[0] != "custom" || got[1] != "a" || got[2] != "b" {
+		t.Errorf("rekeyed %v, want [custom a b]", got)
+	}
+	if diff := testutil.ToFloat64(rekeysTotal.WithLabelValues("success")) - before; diff != 3 {
+		t.Errorf("successful rekeys increased by %v, want 3", diff)
+	}
+
+	var conn nsmv1.NetworkConnection
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "edge", Name: "a"}, &conn); err != nil {
+		t.Fatal(err)
+	}
+	if conn.Status.LastRekeyTime == nil || !conn.Status.LastRekeyTime.Time.Equal(start.Add(2*time.Hour+10*time.Second)) {
+		t.Errorf("last rekey time = %v", conn.Status.LastRekeyTime)
+	}
+}
+
+func TestTickRetriesFailedRekeyLater(t *testing.T) {
+	start := time.Now().Truncate(time.Second)
+	c := newTestClient(t, encryptedConnection("a", start), encryptedConnection("b", start.Add(time.Second)))
+	rekeyer := &recordingRekeyer{err: errors.New("xfrm state busy")}
+	s := NewScheduler(context.Background(), c, quietLogger(), rekeyer, time.Hour, 10*time.Second)
+	before := testutil.ToFloat64(rekeysTotal.WithLabelValues("failure"))
+
+	now := start.Add(time.Hour + time.Minute)
+	if err := s.Tick(now); err == nil {
+		t.Fatalf("expected the rekey error")
+	}
+	// the failed connection doesn't block the others
+	rekeyer.err = nil
+	if err := s.Tick(now.Add(10 * time.Second)); err != nil {
+		t.Fatalf("Tick() error = %v", err)
+	}
+	if err := s.Tick(now.Add(20 * time.Second)); err != nil {
+		t.Fatalf("Tick() error = %v", err)
+	}
+	if err := s.Tick(now.Add(retryInterval)); err != nil {
+		t.Fatalf("Tick() error = %v", err)
+	}
+
+	if got := rekeyer.rekeyed; len(got) != 3 || got[0] != "a" || got[1] != "b" || got[2] != "a" {
+		t.Errorf("rekeyed %v, want [a b a]", got)
+	}
+	if diff := testutil.ToFloat64(rekeysTotal.WithLabelValues("failure")) - before; diff != 1 {
+		t.Errorf("failed rekeys increased by %v, want 1", diff)
+	}
+}
+
+func TestTickRekeysConnectionsOfTheNode(t *testing.T) {
+	start := time.Now().Truncate(time.Second)
+	local := encryptedConnection("local", start)
+	local.Status.Node = "edge-1"
+	remote := encryptedConnection("remote", start)
+	remote.Status.Node = "edge-2"
+	c := newTestClient(t, local, remote)
+	rekeyer := &recordingRekeyer{}
+	s := NewScheduler(context.Background(), c, quietLogger(), rekeyer, time.Hour, 10*time.Second)
+	s.SetNode("edge-1")
+
+	now := start.Add(2 * time.Hour)
+	for i := 0; i < 3; i++ {
+		if err := s.Tick(now); err != nil {
+			t.Fatalf("Tick() error = %v", err)
+		}
+		now = now.Add(10 * time.Second)
+	}
+	if got := rekeyer.rekeyed; len(got) != 1 || got[0] != "local" {
+		t.Errorf("rekeyed %v, want only [local]", got)
+	}
+}
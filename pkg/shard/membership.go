@@ -0,0 +1,252 @@
+package shard
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/akos011221/nsm/pkg/watchdog"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// LabelGroup marks the Leases of the replicas sharing the reconciliation
+const LabelGroup = "nsm.akosrbn.io/shard-group"
+
+var (
+	// shardMembers is the number of replicas sharing the reconciliation
+	shardMembers = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "nsm_shard_members",
+		Help: "Number of controller replicas the reconciliation is sharded across",
+	})
+
+	// rebalancesTotal counts the membership changes
+	rebalancesTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "nsm_shard_rebalances_total",
+		Help: "Number of times the shards were rebalanced on a membership change",
+	})
+)
+
+func init() {
+	crmetrics.Registry.MustRegister(shardMembers, rebalancesTotal)
+}
+
+// Membership shards the reconciliation across the controller replicas.
+// Every replica holds a Lease it renews, the replicas with a live Lease
+// are the members of a consistent hash ring keyed on the node names, and
+// each replica reconciles only the objects of the nodes the ring assigns
+// to it. A replica joining or leaving rebalances the ring, moving only its
+// share of the nodes.
+type Membership struct {
+	// Context for cancellation
+	ctx context.Context
+	// Kubernetes client (controller-runtime)
+	client client.Client
+	// Logger
+	logger *logrus.Logger
+	// Namespace of the Leases
+	namespace string
+	// Group of replicas sharing the reconciliation
+	group string
+	// Identity of this replica
+	identity string
+	// Time a Lease is valid for without being renewed
+	leaseDuration time.Duration
+	// Interval between renewals
+	interval time.Duration
+	// Ring of the live members, nil until the membership was read
+	ring *Ring
+	// Time the Lease was last renewed
+	renewed time.Time
+	// Functions called once the ring was rebalanced
+	listeners []func()
+	// Mutex for protecting the ring and listeners
+	mu sync.RWMutex
+	// Progress signal for the watchdog
+	heartbeat *watchdog.Heartbeat
+}
+
+// NewMembership creates the membership of a replica
+func NewMembership(ctx context.Context, c client.Client, logger *logrus.Logger, namespace, group, identity string, leaseDuration time.Duration) *Membership {
+	return &Membership{
+		ctx:           ctx,
+		client:        c,
+		logger:        logger,
+		namespace:     namespace,
+		group:         group,
+		identity:      identity,
+		leaseDuration: leaseDuration,
+		interval:      leaseDuration / 3,
+	}
+}
+
+// SetHeartbeat makes the membership report its progress to the watchdog
+func (m *Membership) SetHeartbeat(hb *watchdog.Heartbeat) {
+	m.heartbeat = hb
+	hb.Expect(m.interval)
+}
+
+// OnChange registers a function called after every rebalance, e.g. to
+// reconcile the objects the replica took over
+func (m *Membership) OnChange(fn func()) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.listeners = append(m.listeners, fn)
+}
+
+// Owns tells whether this replica reconciles the objects of the node.
+// Objects not tied to a node are owned by their key instead. Nothing is
+// owned before the replica joined, so two replicas never reconcile the
+// same node on start.
+func (m *Membership) Owns(node string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.ring != nil && m.ring.Owner(node) == m.identity
+}
+
+// Members returns the live members, sorted
+func (m *Membership) Members() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.ring == nil {
+		return nil
+	}
+	return m.ring.Members()
+}
+
+// Start joins the ring and renews the Lease until the context is
+// cancelled, then leaves so the others take over right away
+func (m *Membership) Start() error {
+	m.logger.Infof("Joining shard group %s as %s", m.group, m.identity)
+	m.Sync(time.Now())
+
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			m.heartbeat.Beat()
+			m.Sync(now)
+
+		case <-m.ctx.Done():
+			m.logger.Infof("Leaving shard group %s", m.group)
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := m.leave(ctx); err != nil {
+				m.logger.WithError(err).Warn("Failed to release the shard lease")
+			}
+			return nil
+		}
+	}
+}
+
+// Sync renews the Lease of this replica and rebalances the ring when the
+// live members changed
+func (m *Membership) Sync(now time.Time) {
+	if err := m.renew(now); err != nil {
+		m.logger.WithError(err).Warn("Failed to renew the shard lease")
+		m.expire(now)
+		return
+	}
+	m.renewed = now
+	members, err := m.liveMembers(now)
+	if err != nil {
+		m.logger.WithError(err).Warn("Failed to read the shard members")
+		return
+	}
+
+	m.mu.Lock()
+	if m.ring != nil && slices.Equal(m.ring.Members(), members) {
+		m.mu.Unlock()
+		return
+	}
+	m.ring = NewRing(members)
+	listeners := slices.Clone(m.listeners)
+	m.mu.Unlock()
+
+	shardMembers.Set(float64(len(members)))
+	rebalancesTotal.Inc()
+	m.logger.Infof("Rebalanced shard group %s across %d members: %v", m.group, len(members), members)
+	for _, fn := range listeners {
+		fn()
+	}
+}
+
+// expire drops out of the ring once the Lease lapsed, since the other
+// members took over the objects of this replica by then
+func (m *Membership) expire(now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.ring == nil || now.Sub(m.renewed) < m.leaseDuration {
+		return
+	}
+	m.logger.Warnf("Shard lease of %s lapsed, reconciling nothing until it is renewed", m.identity)
+	m.ring = nil
+}
+
+// leaseName is the name of the Lease of this replica
+func (m *Membership) leaseName() string {
+	return m.group + "-" + strings.ToLower(m.identity)
+}
+
+// renew creates or renews the Lease of this replica
+func (m *Membership) renew(now time.Time) error {
+	lease := &coordinationv1.Lease{ObjectMeta: metav1.ObjectMeta{Namespace: m.namespace, Name: m.leaseName()}}
+	_, err := controllerutil.CreateOrUpdate(m.ctx, m.client, lease, func() error {
+		if lease.Labels == nil {
+			lease.Labels = make(map[string]string)
+		}
+		lease.Labels[LabelGroup] = m.group
+		seconds := int32(m.leaseDuration / time.Second)
+		renewTime := metav1.NewMicroTime(now)
+		lease.Spec.HolderIdentity = &m.identity
+		lease.Spec.LeaseDurationSeconds = &seconds
+		lease.Spec.RenewTime = &renewTime
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to renew lease %s/%s: %w", m.namespace, m.leaseName(), err)
+	}
+	return nil
+}
+
+// liveMembers returns the holders of the Leases renewed in time, sorted
+func (m *Membership) liveMembers(now time.Time) ([]string, error) {
+	var leases coordinationv1.LeaseList
+	if err := m.client.List(m.ctx, &leases, client.InNamespace(m.namespace), client.MatchingLabels{LabelGroup: m.group}); err != nil {
+		return nil, fmt.Errorf("failed to list shard leases: %w", err)
+	}
+
+	members := []string{m.identity}
+	for _, lease := range leases.Items {
+		spec := lease.Spec
+		if spec.HolderIdentity == nil || *spec.HolderIdentity == m.identity || spec.RenewTime == nil || spec.LeaseDurationSeconds == nil {
+			continue
+		}
+		expiry := spec.RenewTime.Add(time.Duration(*spec.LeaseDurationSeconds) * time.Second)
+		if now.Before(expiry) {
+			members = append(members, *spec.HolderIdentity)
+		}
+	}
+	sort.Strings(members)
+	return slices.Compact(members), nil
+}
+
+// leave deletes the Lease of this replica
+func (m *Membership) leave(ctx context.Context) error {
+	lease := &coordinationv1.Lease{ObjectMeta: metav1.ObjectMeta{Namespace: m.namespace, Name: m.leaseName()}}
+	if err := m.client.Delete(ctx, lease); client.IgnoreNotFound(err) != nil {
+		return fmt.Errorf("failed to delete lease %s/%s: %w", m.namespace, m.leaseName(), err)
+	}
+	return nil
+}
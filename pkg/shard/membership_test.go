@@ -0,0 +1,90 @@
+package shard
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func quietLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return logger
+}
+
+func TestMembershipRebalances(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatalf("failed to build scheme: %v", err)
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).Build()
+	ctx := context.Background()
+	now := time.Now()
+
+	a := NewMembership(ctx, c, quietLogger(), "nsm-system", "nsm-controller", "edge-a", 15*time.Second)
+	b := NewMembership(ctx, c, quietLogger(), "nsm-system", "nsm-controller", "edge-b", 15*time.Second)
+	rebalances := 0
+	a.OnChange(func() { rebalances++ })
+
+	// nothing is owned before joining
+	if a.Owns("edge-1") {
+		t.Fatalf("replica owns nodes before joining")
+	}
+	a.Sync(now)
+	if !a.Owns("edge-1") || rebalances != 1 {
+		t.Fatalf("single replica doesn't own every node")
+	}
+
+	// a second replica takes over a share of the nodes
+	b.Sync(now)
+	a.Sync(now.Add(5 * time.Second))
+	if rebalances != 2 || len(a.Members()) != 2 {
+		t.Fatalf("members = %v after %d rebalances", a.Members(), rebalances)
+	}
+	ownedA, ownedB := 0, 0
+	for _, node := range []string{"edge-a", "edge-b", "edge-c", "edge-d", "edge-e", "edge-f", "edge-g", "edge-h"} {
+		if a.Owns(node) == b.Owns(node) {
+			t.Errorf("%s owned by both or neither replica", node)
+		}
+		if a.Owns(node) {
+			ownedA++
+		} else {
+			ownedB++
+		}
+	}
+	if ownedA == 0 || ownedB == 0 {
+		t.Errorf("nodes not spread: %d and %d", ownedA, ownedB)
+	}
+
+	// an unchanged membership doesn't rebalance
+	a.Sync(now.Add(10 * time.Second))
+	if rebalances != 2 {
+		t.Errorf("rebalanced without a membership change")
+	}
+
+	// the lease of the second replica lapses, the first takes over again
+	a.Sync(now.Add(time.Minute))
+	if rebalances != 3 || len(a.Members()) != 1 || !a.Owns("edge/a") {
+		t.Errorf("members = %v after the lease lapsed", a.Members())
+	}
+
+	// leaving releases the lease
+	if err := b.leave(ctx); err != nil {
+		t.Fatalf("leave() error = %v", err)
+	}
+	var leases coordinationv1.LeaseList
+	if err := c.List(ctx, &leases, client.MatchingLabels{LabelGroup: "nsm-controller"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(leases.Items) != 1 || *leases.Items[0].Spec.HolderIdentity != "edge-a" {
+		t.Errorf("unexpected leases %+v", leases.Items)
+	}
+}
@@ -0,0 +1,75 @@
+package shard
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+)
+
+// Virtual nodes per member, evening out the share of each member
+const virtualNodes = 128
+
+// Ring assigns keys to members by consistent hashing, so a membership
+// change only moves the keys of the members that joined or left
+type Ring struct {
+	// Hashes of the virtual nodes, sorted
+	hashes []uint64
+	// Member owning each virtual node
+	owners map[uint64]string
+	// Members of the ring, sorted
+	members []string
+}
+
+// NewRing creates a ring of the members
+func NewRing(members []string) *Ring {
+	r := &Ring{owners: make(map[uint64]string)}
+	for _, member := range members {
+		r.members = append(r.members, member)
+		for i := 0; i < virtualNodes; i++ {
+			h := hash(member + "#" + strconv.Itoa(i))
+			// on the unlikely collision the lowest member wins, on every replica
+			if owner, ok := r.owners[h]; ok && owner < member {
+				continue
+			}
+			if _, ok := r.owners[h]; !ok {
+				r.hashes = append(r.hashes, h)
+			}
+			r.owners[h] = member
+		}
+	}
+	sort.Strings(r.members)
+	sort.Slice(r.hashes, func(i, j int) bool { return r.hashes[i] < r.hashes[j] })
+	return r
+}
+
+// Owner returns the member owning a key, empty for an empty ring
+func (r *Ring) Owner(key string) string {
+	if len(r.hashes) == 0 {
+		return ""
+	}
+	h := hash(key)
+	i := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= h })
+	if i == len(r.hashes) {
+		i = 0
+	}
+	return r.owners[r.hashes[i]]
+}
+
+// Members returns the members of the ring, sorted
+func (r *Ring) Members() []string {
+	return r.members
+}
+
+// hash spreads a string over the ring. FNV alone clusters similar
+// strings like the virtual node names, the murmur3 finalizer mixes it.
+func hash(s string) uint64 {
+	f := fnv.New64a()
+	f.Write([]byte(s))
+	h := f.Sum64()
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
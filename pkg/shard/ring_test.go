@@ -0,0 +1,44 @@
+package shard
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRingSpreadsKeys(t *testing.T) {
+	ring := NewRing([]string{"edge-a", "edge-b", "edge-c"})
+	counts := make(map[string]int)
+	for i := 0; i < 3000; i++ {
+		counts[ring.Owner(fmt.Sprintf("edge/intent-%d", i))]++
+	}
+	for _, member := range ring.Members() {
+		if counts[member] < 700 || counts[member] > 1300 {
+			t.Errorf("%s owns %d of 3000 keys: %v", member, counts[member], counts)
+		}
+	}
+
+	if owner := NewRing(nil).Owner("edge/intent-1"); owner != "" {
+		t.Errorf("empty ring assigned a key to %q", owner)
+	}
+}
+
+func TestRingRebalanceMovesOnlyNewShare(t *testing.T) {
+	before := NewRing([]string{"edge-a", "edge-b", "edge-c"})
+	// the order members are listed in doesn't matter
+	after := NewRing([]string{"edge-d", "edge-c", "edge-b", "edge-a"})
+
+	moved := 0
+	for i := 0; i < 3000; i++ {
+		key := fmt.Sprintf("edge/intent-%d", i)
+		if from, to := before.Owner(key), after.Owner(key); from != to {
+			moved++
+			if to != "edge-d" {
+				t.Fatalf("%s moved from %s to %s instead of the new member", key, from, to)
+			}
+		}
+	}
+	// about a quarter of the keys move to the new member
+	if moved < 450 || moved > 1050 {
+		t.Errorf("%d of 3000 keys moved", moved)
+	}
+}
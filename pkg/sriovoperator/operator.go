@@ -0,0 +1,231 @@
+// Package sriovoperator detects the SR-IOV network operator (OpenShift,
+// k8snetworkplumbingwg) on the node, so NSM can leave the provisioning of
+// the VFs to it while still allocating and configuring them.
+package sriovoperator
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/akos011221/nsm/pkg/watchdog"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// NodeStateList is the kind of the lists of the per-node states the
+// operator keeps, holding the PFs its policies select
+var NodeStateList = schema.GroupVersionKind{Group: "sriovnetwork.openshift.io", Version: "v1", Kind: "SriovNetworkNodeStateList"}
+
+// Sync statuses of the operator on a node
+const (
+	// SyncSucceeded means the VFs are provisioned as the policies want
+	SyncSucceeded = "Succeeded"
+	// SyncInProgress means the operator is changing the VFs
+	SyncInProgress = "InProgress"
+	// SyncFailed means the operator failed to provision the VFs
+	SyncFailed = "Failed"
+)
+
+// Interface is a PF the operator provisions the VFs of
+type Interface struct {
+	// Interface name (e.g., ens1f0), empty if the policy selects it by PCI
+	// address only
+	Name string `json:"name,omitempty"`
+	// PCI address of the PF
+	PCIAddress string `json:"pciAddress"`
+	// Number of VFs the operator creates
+	NumVFs int64 `json:"numVfs"`
+}
+
+// NodeState is the state of the operator on the node
+type NodeState struct {
+	// Whether the operator manages the node
+	Detected bool `json:"detected"`
+	// PFs the operator provisions
+	Interfaces []Interface `json:"interfaces,omitempty"`
+	// Sync status of the operator on the node (Succeeded, InProgress, Failed)
+	SyncStatus string `json:"syncStatus,omitempty"`
+	// Time the state was read, zero before the first read
+	CheckedAt time.Time `json:"checkedAt,omitempty"`
+}
+
+// Watcher follows the state of the SR-IOV network operator on a node. The
+// operator's node states are polled, the operator may be installed or
+// removed at any time.
+type Watcher struct {
+	// Context for cancellation
+	ctx context.Context
+	// Kubernetes client, uncached so the operator's kinds aren't watched
+	client client.Reader
+	// Logger
+	logger *logrus.Logger
+	// Kubernetes node the watcher runs on
+	node string
+	// Interval between reads of the node state
+	interval time.Duration
+	// Progress signal for the watchdog
+	heartbeat *watchdog.Heartbeat
+
+	// Mutex for protecting the state
+	mu sync.RWMutex
+	// Latest state of the operator on the node
+	state NodeState
+}
+
+// NewWatcher creates a watcher of the operator's state on a node
+func NewWatcher(ctx context.Context, c client.Reader, logger *logrus.Logger, node string, interval time.Duration) *Watcher {
+	return &Watcher{ctx: ctx, client: c, logger: logger, node: node, interval: interval}
+}
+
+// SetHeartbeat makes the watcher report its progress to the watchdog
+func (w *Watcher) SetHeartbeat(hb *watchdog.Heartbeat) {
+	w.heartbeat = hb
+	hb.Expect(w.interval)
+}
+
+// Start reads the state of the operator periodically
+func (w *Watcher) Start() error {
+	w.logger.Infof("Watching the SR-IOV network operator on node %s every %s", w.node, w.interval)
+	if err := w.Sync(); err != nil {
+		w.logger.WithError(err).Warn("Failed to read the SR-IOV network operator state")
+	}
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			w.heartbeat.Beat()
+			if err := w.Sync(); err != nil {
+				w.logger.WithError(err).Warn("Failed to read the SR-IOV network operator state")
+			}
+
+		case <-w.ctx.Done():
+			w.logger.Info("Stopping SR-IOV network operator watcher")
+			return nil
+		}
+	}
+}
+
+// Sync reads the state of the operator on the node. The state is kept as
+// it was when it can't be read.
+func (w *Watcher) Sync() error {
+	list := &unstructured.UnstructuredList{}
+	list.SetGroupVersionKind(NodeStateList)
+	// the states live in the operator's namespace, named after their node
+	err := w.client.List(w.ctx, list)
+	if meta.IsNoMatchError(err) {
+		w.update(NodeState{CheckedAt: time.Now()})
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to list SR-IOV network node states: %w", err)
+	}
+
+	state := NodeState{CheckedAt: time.Now()}
+	for _, item := range list.Items {
+		if item.GetName() != w.node {
+			continue
+		}
+		state.Detected = true
+		state.Interfaces = interfaces(&item)
+		state.SyncStatus, _, _ = unstructured.NestedString(item.Object, "status", "syncStatus")
+	}
+	w.update(state)
+	return nil
+}
+
+// interfaces returns the PFs the policies select in a node state, with
+// their names from the status when the policies select them by PCI address
+func interfaces(obj *unstructured.Unstructured) []Interface {
+	names := make(map[string]string)
+	status, _, _ := unstructured.NestedSlice(obj.Object, "status", "interfaces")
+	for _, item := range status {
+		iface, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		pci, _, _ := unstructured.NestedString(iface, "pciAddress")
+		name, _, _ := unstructured.NestedString(iface, "name")
+		names[pci] = name
+	}
+
+	var ifaces []Interface
+	spec, _, _ := unstructured.NestedSlice(obj.Object, "spec", "interfaces")
+	for _, item := range spec {
+		iface, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		var pf Interface
+		pf.PCIAddress, _, _ = unstructured.NestedString(iface, "pciAddress")
+		pf.Name, _, _ = unstructured.NestedString(iface, "name")
+		pf.NumVFs, _, _ = unstructured.NestedInt64(iface, "numVfs")
+		if pf.Name == "" {
+			pf.Name = names[pf.PCIAddress]
+		}
+		ifaces = append(ifaces, pf)
+	}
+	sort.Slice(ifaces, func(i, j int) bool { return ifaces[i].PCIAddress < ifaces[j].PCIAddress })
+	return ifaces
+}
+
+// update replaces the state, logging the changes that matter to NSM
+func (w *Watcher) update(state NodeState) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	switch {
+	case state.Detected && !w.state.Detected:
+		w.logger.Infof("SR-IOV network operator manages node %s, leaving the VFs of %d PFs to it", w.node, len(state.Interfaces))
+	case !state.Detected && w.state.Detected:
+		w.logger.Infof("SR-IOV network operator no longer manages node %s, NSM provisions the VFs", w.node)
+	}
+	if state.SyncStatus != w.state.SyncStatus && state.Detected {
+		w.logger.Infof("SR-IOV network operator sync status on node %s: %s", w.node, state.SyncStatus)
+	}
+	w.state = state
+}
+
+// State returns the latest state of the operator on the node
+func (w *Watcher) State() NodeState {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	state := w.state
+	state.Interfaces = append([]Interface(nil), w.state.Interfaces...)
+	return state
+}
+
+// Provisions returns whether the operator provisions the VFs of a PF
+func (w *Watcher) Provisions(pfName, pciAddress string) bool {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	for _, pf := range w.state.Interfaces {
+		if (pciAddress != "" && pf.PCIAddress == pciAddress) || (pf.Name != "" && pf.Name == pfName) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reconfiguring returns whether the operator is changing the VFs of the
+// node
+func (w *Watcher) Reconfiguring() bool {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.state.Detected && w.state.SyncStatus == SyncInProgress
+}
+
+// String names the operator
+func (w *Watcher) String() string {
+	return "the SR-IOV network operator"
+}
@@ -0,0 +1,94 @@
+package sriovoperator
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var nodeState = schema.GroupVersionKind{Group: "sriovnetwork.openshift.io", Version: "v1", Kind: "SriovNetworkNodeState"}
+
+// state returns the operator's state of a node
+func state(node, syncStatus string, spec, status []interface{}) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": node, "namespace": "openshift-sriov-network-operator"},
+		"spec":     map[string]interface{}{"interfaces": spec},
+		"status":   map[string]interface{}{"interfaces": status, "syncStatus": syncStatus},
+	}}
+	obj.SetGroupVersionKind(nodeState)
+	return obj
+}
+
+func newTestWatcher(t *testing.T, installed bool, objs ...*unstructured.Unstructured) *Watcher {
+	t.Helper()
+	mapper := meta.NewDefaultRESTMapper(nil)
+	if installed {
+		mapper.Add(nodeState, meta.RESTScopeNamespace)
+	}
+	builder := fake.NewClientBuilder().WithScheme(runtime.NewScheme()).WithRESTMapper(mapper)
+	for _, obj := range objs {
+		builder = builder.WithObjects(obj)
+	}
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return NewWatcher(context.Background(), builder.Build(), logger, "worker-1", time.Second)
+}
+
+func TestWatcherWithoutOperator(t *testing.T) {
+	w := newTestWatcher(t, false)
+	if err := w.Sync(); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if s := w.State(); s.Detected || s.CheckedAt.IsZero() {
+		t.Errorf("State() = %+v, want the operator not detected", s)
+	}
+	if w.Provisions("ens1f0", "0000:3b:00.0") || w.Reconfiguring() {
+		t.Error("PFs left to an operator that isn't installed")
+	}
+}
+
+func TestWatcherFollowsNodeState(t *testing.T) {
+	w := newTestWatcher(t, true,
+		state("worker-1", SyncInProgress,
+			[]interface{}{
+				map[string]interface{}{"pciAddress": "0000:3b:00.1", "numVfs": int64(8)},
+				map[string]interface{}{"name": "ens1f0", "pciAddress": "0000:3b:00.0", "numVfs": int64(4)},
+			},
+			[]interface{}{
+				map[string]interface{}{"name": "ens1f1", "pciAddress": "0000:3b:00.1"},
+			}),
+		state("worker-2", SyncSucceeded, []interface{}{
+			map[string]interface{}{"name": "ens2f0", "pciAddress": "0000:5e:00.0", "numVfs": int64(4)},
+		}, nil),
+	)
+	if err := w.Sync(); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+
+	s := w.State()
+	if !s.Detected || s.SyncStatus != SyncInProgress || len(s.Interfaces) != 2 {
+		t.Fatalf("State() = %+v", s)
+	}
+	// the PF selected by PCI address is named from the status
+	if s.Interfaces[1] != (Interface{Name: "ens1f1", PCIAddress: "0000:3b:00.1", NumVFs: 8}) {
+		t.Errorf("interface selected by PCI address = %+v", s.Interfaces[1])
+	}
+	if !w.Provisions("ens1f0", "") || !w.Provisions("renamed", "0000:3b:00.1") {
+		t.Error("PFs of the node state not left to the operator")
+	}
+	// the PFs of other nodes aren't this node's
+	if w.Provisions("ens2f0", "0000:5e:00.0") {
+		t.Error("PF of another node left to the operator")
+	}
+	if !w.Reconfiguring() {
+		t.Error("sync in progress not reported as reconfiguring")
+	}
+}
@@ -0,0 +1,406 @@
+// Package sysfsfixture captures the sysfs subtree the hardware discovery
+// reads into a fixture, and extracts fixtures into directories the
+// hardware managers can use as their root. Fixtures are single JSON files
+// rather than directory trees: sysfs names (PCI addresses) and symlinks
+// can't be part of a Go module.
+package sysfsfixture
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Version is the version of the fixture format
+const Version = 1
+
+// Types of the entries
+const (
+	TypeDir     = "dir"
+	TypeFile    = "file"
+	TypeSymlink = "symlink"
+)
+
+// maxAttrSize bounds the attributes captured, sysfs attributes are at most
+// a page
+const maxAttrSize = 4096
+
+// Fixture is a captured sysfs subtree
+type Fixture struct {
+	// Version of the format
+	Version int `json:"version"`
+	// Kernel release of the node captured, for reference
+	Kernel string `json:"kernel,omitempty"`
+	// Entries, ordered by path
+	Entries []Entry `json:"entries"`
+}
+
+// Entry is a directory, attribute or symlink of the subtree
+type Entry struct {
+	// Path relative to the root (e.g., sys/class/net/eth0)
+	Path string `json:"path"`
+	// Type of the entry (dir, file, symlink)
+	Type string `json:"type"`
+	// Content of a file
+	Content string `json:"content,omitempty"`
+	// Target of a symlink, relative as in sysfs
+	Target string `json:"target,omitempty"`
+}
+
+// deviceAttrs are the attributes of the devices the discovery reads, or
+// that tell devices apart in a bug report
+var deviceAttrs = []string{
+	"uevent", "vendor", "device", "subsystem_vendor", "subsystem_device", "class",
+	"numa_node", "sriov_numvfs", "sriov_totalvfs", "sriov_drivers_autoprobe",
+	"driver_override",
+}
+
+// deviceLinks are the symlinks of the devices to their driver, bus, IOMMU
+// group and physical function
+var deviceLinks = []string{"driver", "subsystem", "iommu_group", "physfn"}
+
+// netdevAttrs are the attributes of the network interfaces
+var netdevAttrs = []string{
+	"uevent", "address", "perm_addr", "speed", "duplex", "mtu", "operstate",
+	"carrier", "type", "dev_port", "phys_port_name", "phys_port_id", "phys_switch_id",
+}
+
+// Capture captures the sysfs subtree under root the hardware discovery
+// reads: the network interfaces and their PCI devices with the VFs, the
+// network PCI devices, the IOMMUs, the hugepage pools and the hwmon
+// sensors. MAC addresses and switch/port IDs are replaced by stable
+// pseudonyms, so the same address maps to the same pseudonym throughout
+// the fixture.
+func Capture(root string) (*Fixture, error) {
+	c := &capturer{root: root, entries: make(map[string]Entry), devices: make(map[string]bool)}
+
+	if _, err := os.Stat(c.abs("sys/class/net")); err != nil {
+		return nil, fmt.Errorf("no sysfs under %s: %w", root, err)
+	}
+	netdevs, _ := os.ReadDir(c.abs("sys/class/net"))
+	for _, e := range netdevs {
+		c.netdev(path.Join("sys/class/net", e.Name()))
+	}
+
+	// the DPDK manager scans the network controllers on the PCI bus
+	pciDevices, _ := os.ReadDir(c.abs("sys/bus/pci/devices"))
+	for _, e := range pciDevices {
+		p := path.Join("sys/bus/pci/devices", e.Name())
+		if !strings.HasPrefix(c.read(path.Join(p, "class")), "0x02") {
+			continue
+		}
+		c.link(p)
+		if dev, ok := c.resolve(p); ok {
+			c.device(dev)
+		}
+	}
+
+	for _, dir := range []string{"sys/class/iommu", "sys/kernel/iommu_groups", "sys/bus/platform/drivers/arm-smmu", "sys/bus/platform/drivers/arm-smmu-v3"} {
+		entries, _ := os.ReadDir(c.abs(dir))
+		for _, e := range entries {
+			c.dir(path.Join(dir, e.Name()))
+		}
+	}
+
+	pools, _ := filepath.Glob(c.abs("sys/kernel/mm/hugepages/hugepages-*kB"))
+	for _, pool := range pools {
+		p := c.rel(pool)
+		c.file(path.Join(p, "nr_hugepages"))
+		c.file(path.Join(p, "free_hugepages"))
+	}
+
+	chips, _ := filepath.Glob(c.abs("sys/class/hwmon/hwmon*"))
+	for _, chip := range chips {
+		p := c.rel(chip)
+		c.link(p)
+		dir, ok := c.resolve(p)
+		if !ok {
+			continue
+		}
+		attrs, _ := os.ReadDir(c.abs(dir))
+		for _, a := range attrs {
+			name := a.Name()
+			if name == "name" || strings.HasPrefix(name, "temp") || strings.HasPrefix(name, "power") {
+				c.file(path.Join(dir, name))
+			}
+		}
+	}
+
+	f := &Fixture{Version: Version, Entries: make([]Entry, 0, len(c.entries))}
+	if data, err := os.ReadFile(c.abs("proc/sys/kernel/osrelease")); err == nil {
+		f.Kernel = strings.TrimSpace(string(data))
+	}
+	for _, e := range c.entries {
+		f.Entries = append(f.Entries, e)
+	}
+	sort.Slice(f.Entries, func(i, j int) bool { return f.Entries[i].Path < f.Entries[j].Path })
+	return f, nil
+}
+
+// capturer collects the entries of a capture
+type capturer struct {
+	// Root of the filesystem sysfs is mounted under
+	root string
+	// Entries captured, by path
+	entries map[string]Entry
+	// Devices captured, by path
+	devices map[string]bool
+}
+
+// abs returns the path of an entry on the node
+func (c *capturer) abs(p string) string {
+	return filepath.Join(c.root, filepath.FromSlash(p))
+}
+
+// rel returns the path of an entry relative to the root
+func (c *capturer) rel(p string) string {
+	r, err := filepath.Rel(c.root, p)
+	if err != nil {
+		return p
+	}
+	return filepath.ToSlash(r)
+}
+
+// read returns the trimmed content of an attribute, empty if unreadable
+func (c *capturer) read(p string) string {
+	data, err := os.ReadFile(c.abs(p))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// resolve follows the symlinks of a path, returning it relative to the
+// root. Paths leading outside the root aren't followed.
+func (c *capturer) resolve(p string) (string, bool) {
+	real, err := filepath.EvalSymlinks(c.abs(p))
+	if err != nil {
+		return "", false
+	}
+	root, err := filepath.EvalSymlinks(c.root)
+	if err != nil {
+		return "", false
+	}
+	r, err := filepath.Rel(root, real)
+	if err != nil || r == ".." || strings.HasPrefix(r, "../") {
+		return "", false
+	}
+	return filepath.ToSlash(r), true
+}
+
+// dir captures a directory, without its content
+func (c *capturer) dir(p string) {
+	if info, err := os.Lstat(c.abs(p)); err != nil || !info.IsDir() {
+		return
+	}
+	c.entries[p] = Entry{Path: p, Type: TypeDir}
+}
+
+// file captures an attribute, sanitized. Unreadable attributes (write-only,
+// or failing like the speed of a down link) are left out.
+func (c *capturer) file(p string) {
+	info, err := os.Lstat(c.abs(p))
+	if err != nil || !info.Mode().IsRegular() {
+		return
+	}
+	f, err := os.Open(c.abs(p))
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, maxAttrSize))
+	if err != nil {
+		return
+	}
+	c.entries[p] = Entry{Path: p, Type: TypeFile, Content: sanitize(path.Base(p), string(data))}
+}
+
+// link captures a symlink, and the directory it points to so the link
+// resolves in the fixture
+func (c *capturer) link(p string) {
+	target, err := os.Readlink(c.abs(p))
+	if err != nil {
+		return
+	}
+	dir, ok := c.resolve(p)
+	// sysfs links are relative, absolute ones wouldn't resolve under
+	// another root
+	if filepath.IsAbs(target) {
+		if !ok {
+			return
+		}
+		if target, err = filepath.Rel(path.Dir(p), dir); err != nil {
+			return
+		}
+	}
+	c.entries[p] = Entry{Path: p, Type: TypeSymlink, Target: filepath.ToSlash(target)}
+	if _, captured := c.entries[dir]; ok && !captured {
+		c.dir(dir)
+	}
+}
+
+// netdev captures a network interface and, unless it is virtual, its
+// device
+func (c *capturer) netdev(p string) {
+	if info, err := os.Lstat(c.abs(p)); err == nil && info.Mode()&fs.ModeSymlink != 0 {
+		c.link(p)
+	}
+	dir, ok := c.resolve(p)
+	if !ok {
+		return
+	}
+	c.dir(dir)
+	for _, attr := range netdevAttrs {
+		c.file(path.Join(dir, attr))
+	}
+	device := path.Join(dir, "device")
+	if _, err := os.Lstat(c.abs(device)); err != nil {
+		return
+	}
+	c.link(device)
+	if dev, ok := c.resolve(device); ok {
+		c.device(dev)
+	}
+}
+
+// device captures a device with its VFs and their interfaces
+func (c *capturer) device(p string) {
+	if c.devices[p] {
+		return
+	}
+	c.devices[p] = true
+	c.dir(p)
+	for _, attr := range deviceAttrs {
+		c.file(path.Join(p, attr))
+	}
+	for _, l := range deviceLinks {
+		c.link(path.Join(p, l))
+	}
+	if pf, ok := c.resolve(path.Join(p, "physfn")); ok {
+		c.device(pf)
+	}
+
+	entries, _ := os.ReadDir(c.abs(p))
+	for _, e := range entries {
+		if !strings.HasPrefix(e.Name(), "virtfn") {
+			continue
+		}
+		vf := path.Join(p, e.Name())
+		c.link(vf)
+		if dev, ok := c.resolve(vf); ok {
+			c.device(dev)
+		}
+	}
+
+	// the interfaces of the device, found through the device when the
+	// interfaces of VFs aren't in the network namespace of the capture
+	netdevs, _ := os.ReadDir(c.abs(path.Join(p, "net")))
+	for _, e := range netdevs {
+		dir := path.Join(p, "net", e.Name())
+		c.dir(dir)
+		for _, attr := range netdevAttrs {
+			c.file(path.Join(dir, attr))
+		}
+	}
+}
+
+// macAttrs are the attributes holding MAC addresses
+var macAttrs = map[string]bool{"address": true, "perm_addr": true}
+
+// idAttrs are the attributes holding identifiers of the hardware
+var idAttrs = map[string]bool{"phys_port_id": true, "phys_switch_id": true}
+
+// sanitize replaces the MAC addresses and hardware identifiers in an
+// attribute by pseudonyms
+func sanitize(name, content string) string {
+	value := strings.TrimSpace(content)
+	switch {
+	case macAttrs[name]:
+		mac, err := net.ParseMAC(value)
+		if err != nil || len(mac) != 6 || isZero(mac) {
+			return content
+		}
+		sum := sha256.Sum256(mac)
+		// locally administered unicast, like the addresses of VFs
+		pseudonym := net.HardwareAddr{0x02, sum[0], sum[1], sum[2], sum[3], sum[4]}
+		return pseudonym.String() + "\n"
+	case idAttrs[name] && value != "":
+		sum := sha256.Sum256([]byte(value))
+		return hex.EncodeToString(sum[:min(max(len(value)/2, 1), len(sum))]) + "\n"
+	}
+	return content
+}
+
+// isZero reports whether a MAC address is all zeroes
+func isZero(mac net.HardwareAddr) bool {
+	for _, b := range mac {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Write writes a fixture as indented JSON
+func (f *Fixture) Write(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(f)
+}
+
+// Load reads a fixture
+func Load(file string) (*Fixture, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the fixture: %w", err)
+	}
+	var f Fixture
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("failed to decode the fixture %s: %w", file, err)
+	}
+	if f.Version != Version {
+		return nil, fmt.Errorf("unsupported fixture version %d", f.Version)
+	}
+	return &f, nil
+}
+
+// Extract recreates the subtree of a fixture under dir, to be used as the
+// root of the hardware managers
+func (f *Fixture) Extract(dir string) error {
+	for _, e := range f.Entries {
+		if e.Path == "" || path.IsAbs(e.Path) || strings.HasPrefix(path.Clean(e.Path), "..") {
+			return fmt.Errorf("invalid fixture path %q", e.Path)
+		}
+		p := filepath.Join(dir, filepath.FromSlash(e.Path))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			return fmt.Errorf("failed to create %s: %w", filepath.Dir(p), err)
+		}
+		switch e.Type {
+		case TypeDir:
+			if err := os.MkdirAll(p, 0o755); err != nil {
+				return fmt.Errorf("failed to create %s: %w", p, err)
+			}
+		case TypeFile:
+			if err := os.WriteFile(p, []byte(e.Content), 0o644); err != nil {
+				return fmt.Errorf("failed to write %s: %w", p, err)
+			}
+		case TypeSymlink:
+			if err := os.Symlink(filepath.FromSlash(e.Target), p); err != nil && !errors.Is(err, fs.ErrExist) {
+				return fmt.Errorf("failed to link %s: %w", p, err)
+			}
+		default:
+			return fmt.Errorf("unknown type %q of fixture entry %s", e.Type, e.Path)
+		}
+	}
+	return nil
+}
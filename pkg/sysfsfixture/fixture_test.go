@@ -0,0 +1,143 @@
+package sysfsfixture
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// tree builds a sysfs tree in a temporary directory
+type tree struct {
+	t    *testing.T
+	root string
+}
+
+func (tr *tree) write(path, content string) {
+	tr.t.Helper()
+	p := filepath.Join(tr.root, path)
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		tr.t.Fatal(err)
+	}
+	if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+		tr.t.Fatal(err)
+	}
+}
+
+func (tr *tree) link(path, target string) {
+	tr.t.Helper()
+	p := filepath.Join(tr.root, path)
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		tr.t.Fatal(err)
+	}
+	if err := os.Symlink(target, p); err != nil {
+		tr.t.Fatal(err)
+	}
+}
+
+func (tr *tree) mkdir(path string) {
+	tr.t.Helper()
+	if err := os.MkdirAll(filepath.Join(tr.root, path), 0o755); err != nil {
+		tr.t.Fatal(err)
+	}
+}
+
+func entries(f *Fixture) map[string]Entry {
+	byPath := make(map[string]Entry, len(f.Entries))
+	for _, e := range f.Entries {
+		byPath[e.Path] = e
+	}
+	return byPath
+}
+
+func TestCaptureAndExtract(t *testing.T) {
+	tr := &tree{t: t, root: t.TempDir()}
+	pf := "sys/devices/pci0000:00/0000:00:01.0/0000:01:00.0"
+	vf := "sys/devices/pci0000:00/0000:00:01.0/0000:01:00.2"
+	tr.mkdir("sys/bus/pci/drivers/mlx5_core")
+	tr.write(pf+"/sriov_numvfs", "1\n")
+	tr.write(pf+"/class", "0x020000\n")
+	tr.write(pf+"/net/eth0/address", "b8:ce:f6:01:02:03\n")
+	tr.write(pf+"/net/eth0/phys_switch_id", "f6cebb03000304b8\n")
+	tr.write(pf+"/config", "binary PCI config space")
+	tr.link(pf+"/driver", "../../../../bus/pci/drivers/mlx5_core")
+	tr.link(pf+"/net/eth0/device", "../../../0000:01:00.0")
+	tr.link(pf+"/virtfn0", "../0000:01:00.2")
+	tr.write(vf+"/uevent", "PCI_SLOT_NAME=0000:01:00.2\n")
+	tr.link(vf+"/physfn", "../0000:01:00.0")
+	// the VF interface shows the PF address, the pseudonym must match
+	tr.write(vf+"/net/eth0v0/address", "b8:ce:f6:01:02:03\n")
+	tr.link("sys/class/net/eth0", "../../devices/pci0000:00/0000:00:01.0/0000:01:00.0/net/eth0")
+	// absolute links become relative
+	tr.link("sys/bus/pci/devices/0000:01:00.0", filepath.Join(tr.root, pf))
+	tr.write("sys/kernel/mm/hugepages/hugepages-2048kB/nr_hugepages", "64\n")
+	// outside the capture
+	tr.write("etc/hostname", "edge-plant-7\n")
+
+	f, err := Capture(tr.root)
+	if err != nil {
+		t.Fatalf("Capture() error = %v", err)
+	}
+	got := entries(f)
+
+	pfAddr := got[pf+"/net/eth0/address"].Content
+	if pfAddr == "b8:ce:f6:01:02:03\n" || !strings.HasPrefix(pfAddr, "02:") {
+		t.Errorf("PF address = %q, want a locally administered pseudonym", pfAddr)
+	}
+	if vfAddr := got[vf+"/net/eth0v0/address"].Content; vfAddr != pfAddr {
+		t.Errorf("VF address = %q, want the pseudonym of the PF address %q", vfAddr, pfAddr)
+	}
+	if id := got[pf+"/net/eth0/phys_switch_id"].Content; id == "f6cebb03000304b8\n" || len(id) != len("f6cebb03000304b8\n") {
+		t.Errorf("phys_switch_id = %q, want a pseudonym of the same length", id)
+	}
+	if e := got["sys/bus/pci/devices/0000:01:00.0"]; e.Type != TypeSymlink || e.Target != "../../../devices/pci0000:00/0000:00:01.0/0000:01:00.0" {
+		t.Errorf("absolute link captured as %+v, want a relative link", e)
+	}
+	if e := got["sys/bus/pci/drivers/mlx5_core"]; e.Type != TypeDir {
+		t.Errorf("driver %+v not captured, its link wouldn't resolve", e)
+	}
+	for _, path := range []string{pf + "/config", "etc/hostname"} {
+		if _, ok := got[path]; ok {
+			t.Errorf("%s captured", path)
+		}
+	}
+	if got[vf+"/uevent"].Content != "PCI_SLOT_NAME=0000:01:00.2\n" || got["sys/kernel/mm/hugepages/hugepages-2048kB/nr_hugepages"].Content != "64\n" {
+		t.Error("attributes of the VF or the hugepages not captured")
+	}
+
+	// the fixture survives a round trip through JSON and extracts to a
+	// tree the discovery can walk
+	var buf bytes.Buffer
+	if err := f.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "fixture.json")
+	if err := os.WriteFile(file, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := Load(file)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	dir := t.TempDir()
+	if err := loaded.Extract(dir); err != nil {
+		t.Fatalf("Extract() error = %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "sys/class/net/eth0/device/virtfn0/uevent"))
+	if err != nil || string(data) != "PCI_SLOT_NAME=0000:01:00.2\n" {
+		t.Errorf("VF uevent through the links = %q, %v", data, err)
+	}
+	if target, err := filepath.EvalSymlinks(filepath.Join(dir, "sys/class/net/eth0/device/driver")); err != nil || filepath.Base(target) != "mlx5_core" {
+		t.Errorf("driver link resolves to %q, %v", target, err)
+	}
+}
+
+func TestExtractRejectsEscapingPaths(t *testing.T) {
+	for _, path := range []string{"../etc/passwd", "/etc/passwd", ""} {
+		f := &Fixture{Version: Version, Entries: []Entry{{Path: path, Type: TypeFile, Content: "x"}}}
+		if err := f.Extract(t.TempDir()); err == nil {
+			t.Errorf("Extract() accepted the path %q", path)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package telemetry
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/usage"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// Downsampling bounds the telemetry of a heartbeat, for sites behind
+// metered or constrained (e.g., LTE) uplinks
+type Downsampling struct {
+	// Length of the windows the samples are rolled up into
+	Rollup time.Duration
+	// Number of busiest connections reported, 0 for all
+	TopN int
+	// Maximum size of the encoded heartbeat in bytes, 0 for no limit
+	MaxBytes int
+}
+
+// Heartbeat is the payload sent to the cloud
+type Heartbeat struct {
+	// Edge node identifier
+	NodeID string `json:"nodeId"`
+	// Sequence number of the heartbeat
+	Seq uint64 `json:"seq"`
+	// Sequence number of the acknowledged heartbeat the changes are
+	// relative to, 0 if the heartbeat carries the full state
+	BaseSeq uint64 `json:"baseSeq"`
+	// Time the heartbeat was built
+	Time time.Time `json:"time"`
+	// Number of connections on the node
+	Connections int `json:"connections"`
+	// Connections with samples that were left out by top-N or the byte budget
+	Omitted int `json:"omitted,omitempty"`
+	// Whether rollups were dropped to stay within the byte budget
+	Truncated bool `json:"truncated,omitempty"`
+	// Connections added or changed since the base
+	ConnectionChanges []ConnectionState `json:"conns,omitempty"`
+	// Connections removed since the base, as namespace/name
+	ConnectionRemovals []string `json:"connsRemoved,omitempty"`
+	// VFs added or changed since the base
+	VFChanges []VFState `json:"vfs,omitempty"`
+	// VFs removed since the base, by PCI address
+	VFRemovals []string `json:"vfsRemoved,omitempty"`
+	// NetworkServices added or changed since the base
+	ServiceChanges []ServiceState `json:"services,omitempty"`
+	// NetworkServices removed since the base, as namespace/name
+	ServiceRemovals []string `json:"servicesRemoved,omitempty"`
+	// Seconds the node ran autonomously, cut off from the cloud, before
+	// this heartbeat got through, 0 while connected
+	AutonomousSec int `json:"autonomousSec,omitempty"`
+	// Metrics rollups of the reported connections
+	Rollups []Rollup `json:"rollups,omitempty"`
+	// Usage reports closed since the last one acknowledged
+	Usage []usage.Report `json:"usage,omitempty"`
+}
+
+// Rollup aggregates the samples of a connection in a window
+type Rollup struct {
+	// Connection as namespace/name
+	Connection string `json:"conn"`
+	// Start of the window
+	Start time.Time `json:"start"`
+	// Number of samples in the window
+	Samples int `json:"n"`
+	// Average and maximum latency in milliseconds
+	LatencyAvgMs int `json:"latAvg"`
+	LatencyMaxMs int `json:"latMax"`
+	// Average throughput in Mbps
+	ThroughputAvgMbps int `json:"tputAvg"`
+	// Maximum packet loss in parts per million
+	PacketLossMaxPPM int `json:"lossMax"`
+}
+
+// window accumulates the samples of a connection in a window
+type window struct {
+	samples    int
+	latencySum int
+	latencyMax int
+	tputSum    int
+	lossMax    int
+}
+
+// Aggregator rolls the metrics samples of the connections up into windows
+type Aggregator struct {
+	// Length of the windows
+	rollup time.Duration
+	// Open windows per connection and window start
+	windows map[types.NamespacedName]map[time.Time]*window
+	// Observation time of the last sample added per connection
+	lastObserved map[types.NamespacedName]time.Time
+}
+
+// NewAggregator creates a new aggregator
+func NewAggregator(rollup time.Duration) *Aggregator {
+	return &Aggregator{
+		rollup:       rollup,
+		windows:      make(map[types.NamespacedName]map[time.Time]*window),
+		lastObserved: make(map[types.NamespacedName]time.Time),
+	}
+}
+
+// Add records the metrics of the connection, unless they were already
+// recorded. The sample goes into the window of its observation time.
+func (a *Aggregator) Add(conn *nsmv1.NetworkConnection) {
+	metrics := conn.Status.Metrics
+	if metrics.LastUpdated == nil {
+		return
+	}
+	key := types.NamespacedName{Namespace: conn.Namespace, Name: conn.Name}
+	observed := metrics.LastUpdated.Time
+	if !observed.After(a.lastObserved[key]) {
+		return
+	}
+	a.lastObserved[key] = observed
+
+	if a.windows[key] == nil {
+		a.windows[key] = make(map[time.Time]*window)
+	}
+	start := observed.Truncate(a.rollup)
+	w := a.windows[key][start]
+	if w == nil {
+		w = &window{}
+		a.windows[key][start] = w
+	}
+	w.samples++
+	w.latencySum += metrics.LatencyMs
+	w.latencyMax = max(w.latencyMax, metrics.LatencyMs)
+	w.tputSum += metrics.ThroughputMbps
+	w.lossMax = max(w.lossMax, metrics.PacketLossPPM)
+}
+
+// Forget drops the state of connections that no longer exist
+func (a *Aggregator) Forget(exists func(types.NamespacedName) bool) {
+	for key := range a.lastObserved {
+		if !exists(key) {
+			delete(a.lastObserved, key)
+		}
+	}
+}
+
+// Flush removes and returns the rollups of the windows that ended by now,
+// the open windows are kept for the next heartbeat
+func (a *Aggregator) Flush(now time.Time) []Rollup {
+	var rollups []Rollup
+	for key, windows := range a.windows {
+		for start, w := range windows {
+			if start.Add(a.rollup).After(now) {
+				continue
+			}
+			rollups = append(rollups, Rollup{
+				Connection:        key.String(),
+				Start:             start,
+				Samples:           w.samples,
+				LatencyAvgMs:      w.latencySum / w.samples,
+				LatencyMaxMs:      w.latencyMax,
+				ThroughputAvgMbps: w.tputSum / w.samples,
+				PacketLossMaxPPM:  w.lossMax,
+			})
+			delete(windows, start)
+		}
+		if len(windows) == 0 {
+			delete(a.windows, key)
+		}
+	}
+	return rollups
+}
+
+// Approximate memory held per open window and per tracked connection,
+// including the map entries and keys
+const (
+	windowBytes     = 96
+	connectionBytes = 128
+)
+
+// Size estimates the memory held by the open windows in bytes
+func (a *Aggregator) Size() int64 {
+	size := int64(len(a.lastObserved)) * connectionBytes
+	for _, windows := range a.windows {
+		size += connectionBytes + int64(len(windows))*windowBytes
+	}
+	return size
+}
+
+// Encode builds the heartbeat of the rollups within the limits of the
+// downsampling. The state changes are always sent in full, only the
+// rollups of the top-N connections by throughput are kept, and the least
+// busy of those are dropped until the encoded heartbeat fits the budget.
+func Encode(hb *Heartbeat, rollups []Rollup, ds Downsampling) ([]byte, error) {
+	// rank the connections by the traffic they carried
+	traffic := make(map[string]int)
+	for _, r := range rollups {
+		traffic[r.Connection] += r.ThroughputAvgMbps * r.Samples
+	}
+	ranked := make([]string, 0, len(traffic))
+	for conn := range traffic {
+		ranked = append(ranked, conn)
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if traffic[ranked[i]] != traffic[ranked[j]] {
+			return traffic[ranked[i]] > traffic[ranked[j]]
+		}
+		return ranked[i] < ranked[j]
+	})
+
+	keep := len(ranked)
+	if ds.TopN > 0 && keep > ds.TopN {
+		keep = ds.TopN
+	}
+
+	for {
+		kept := make(map[string]bool, keep)
+		for _, conn := range ranked[:keep] {
+			kept[conn] = true
+		}
+		hb.Rollups = nil
+		for _, r := range rollups {
+			if kept[r.Connection] {
+				hb.Rollups = append(hb.Rollups, r)
+			}
+		}
+		sort.Slice(hb.Rollups, func(i, j int) bool {
+			if hb.Rollups[i].Connection != hb.Rollups[j].Connection {
+				return hb.Rollups[i].Connection < hb.Rollups[j].Connection
+			}
+			return hb.Rollups[i].Start.Before(hb.Rollups[j].Start)
+		})
+		hb.Omitted = len(ranked) - keep
+
+		data, err := json.Marshal(hb)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode heartbeat: %w", err)
+		}
+		// the heartbeat itself is always sent, even over budget
+		if ds.MaxBytes <= 0 || len(data) <= ds.MaxBytes || keep == 0 {
+			return data, nil
+		}
+		keep--
+		hb.Truncated = true
+	}
+}
@@ -0,0 +1,140 @@
+package telemetry
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var epoch = time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+func observed(name string, at time.Time, latency, tput, loss int) *nsmv1.NetworkConnection {
+	updated := metav1.NewTime(at)
+	return &nsmv1.NetworkConnection{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "edge"},
+		Status: nsmv1.NetworkConnectionStatus{
+			Metrics: nsmv1.ConnectionMetrics{LatencyMs: latency, ThroughputMbps: tput, PacketLossPPM: loss, LastUpdated: &updated},
+		},
+	}
+}
+
+func TestAggregatorRollsUpWindows(t *testing.T) {
+	a := NewAggregator(time.Minute)
+	a.Add(observed("video", epoch.Add(10*time.Second), 10, 100, 0))
+	// the same observation sampled twice counts once
+	a.Add(observed("video", epoch.Add(10*time.Second), 10, 100, 0))
+	a.Add(observed("video", epoch.Add(40*time.Second), 20, 300, 50))
+	a.Add(observed("video", epoch.Add(70*time.Second), 5, 50, 0))
+	a.Add(observed("idle", epoch, 0, 0, 0))
+	a.Add(&nsmv1.NetworkConnection{})
+
+	rollups := a.Flush(epoch.Add(90 * time.Second))
+	if len(rollups) != 2 {
+		t.Fatalf("flushed %d rollups, want the 2 ended windows: %+v", len(rollups), rollups)
+	}
+	for _, r := range rollups {
+		if r.Connection != "edge/video" {
+			continue
+		}
+		want := Rollup{Connection: "edge/video", Start: epoch, Samples: 2, LatencyAvgMs: 15, LatencyMaxMs: 20, ThroughputAvgMbps: 200, PacketLossMaxPPM: 50}
+		if r != want {
+			t.Errorf("rollup = %+v, want %+v", r, want)
+		}
+	}
+
+	// the open window is kept for the next heartbeat
+	rollups = a.Flush(epoch.Add(2 * time.Minute))
+	if len(rollups) != 1 || rollups[0].Start != epoch.Add(time.Minute) || rollups[0].Samples != 1 {
+		t.Errorf("unexpected rollups of the second window: %+v", rollups)
+	}
+	if rollups := a.Flush(epoch.Add(time.Hour)); len(rollups) != 0 {
+		t.Errorf("rollups flushed twice: %+v", rollups)
+	}
+}
+
+func testRollups() []Rollup {
+	var rollups []Rollup
+	for i, conn := range []string{"edge/bulk", "edge/video", "edge/voice", "edge/sensor"} {
+		for w := 0; w < 5; w++ {
+			rollups = append(rollups, Rollup{
+				Connection:        conn,
+				Start:             epoch.Add(time.Duration(w) * time.Minute),
+				Samples:           6,
+				LatencyAvgMs:      10,
+				ThroughputAvgMbps: 1000 - 100*i,
+			})
+		}
+	}
+	return rollups
+}
+
+func decode(t *testing.T, data []byte) Heartbeat {
+	t.Helper()
+	var hb Heartbeat
+	if err := json.Unmarshal(data, &hb); err != nil {
+		t.Fatalf("invalid heartbeat: %v", err)
+	}
+	return hb
+}
+
+func connections(hb Heartbeat) map[string]int {
+	conns := make(map[string]int)
+	for _, r := range hb.Rollups {
+		conns[r.Connection]++
+	}
+	return conns
+}
+
+func TestEncodeTopN(t *testing.T) {
+	data, err := Encode(&Heartbeat{NodeID: "edge-1", Time: epoch, Connections: 4}, testRollups(), Downsampling{TopN: 2})
+	if err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+
+	hb := decode(t, data)
+	conns := connections(hb)
+	if len(conns) != 2 || conns["edge/bulk"] != 5 || conns["edge/video"] != 5 {
+		t.Errorf("reported %v, want the 2 busiest connections", conns)
+	}
+	if hb.Omitted != 2 || hb.Truncated {
+		t.Errorf("omitted = %d, truncated = %t, want 2 omitted by top-N", hb.Omitted, hb.Truncated)
+	}
+}
+
+func TestEncodeByteBudget(t *testing.T) {
+	unlimited, err := Encode(&Heartbeat{NodeID: "edge-1", Time: epoch}, testRollups(), Downsampling{})
+	if err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	if hb := decode(t, unlimited); len(hb.Rollups) != 20 || hb.Omitted != 0 {
+		t.Fatalf("unlimited heartbeat dropped rollups: %d", len(hb.Rollups))
+	}
+
+	budget := len(unlimited) / 2
+	data, err := Encode(&Heartbeat{NodeID: "edge-1", Time: epoch}, testRollups(), Downsampling{MaxBytes: budget})
+	if err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	if len(data) > budget {
+		t.Errorf("heartbeat of %d bytes over the budget of %d", len(data), budget)
+	}
+	hb := decode(t, data)
+	if !hb.Truncated || hb.Omitted == 0 {
+		t.Errorf("truncated = %t, omitted = %d", hb.Truncated, hb.Omitted)
+	}
+	if conns := connections(hb); conns["edge/bulk"] != 5 || conns["edge/sensor"] != 0 {
+		t.Errorf("reported %v, want the least busy connections dropped first", conns)
+	}
+
+	// the heartbeat itself is sent even if it doesn't fit
+	data, err = Encode(&Heartbeat{NodeID: "edge-1", Time: epoch}, testRollups(), Downsampling{MaxBytes: 10})
+	if err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	if hb := decode(t, data); hb.NodeID != "edge-1" || len(hb.Rollups) != 0 || hb.Omitted != 4 {
+		t.Errorf("unexpected heartbeat over a tiny budget: %+v", hb)
+	}
+}
@@ -0,0 +1,318 @@
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/budget"
+	"github.com/akos011221/nsm/pkg/cloud"
+	"github.com/akos011221/nsm/pkg/hardware"
+	"github.com/akos011221/nsm/pkg/idle"
+	"github.com/akos011221/nsm/pkg/pressure"
+	"github.com/akos011221/nsm/pkg/thermal"
+	"github.com/akos011221/nsm/pkg/watchdog"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// Maximum interval the connection metrics are sampled at
+const sampleInterval = 10 * time.Second
+
+// Only every hotSampleDivisor-th sample is taken near the thermal limits
+const hotSampleDivisor = 4
+
+var (
+	// heartbeatsTotal counts the heartbeats per result (success, failure)
+	heartbeatsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "nsm_cloud_heartbeats_total",
+		Help: "Number of heartbeats sent to the cloud, by result",
+	}, []string{"result"})
+
+	// heartbeatBytesTotal counts the bytes of the heartbeats sent
+	heartbeatBytesTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "nsm_cloud_heartbeat_bytes_total",
+		Help: "Number of bytes of telemetry sent to the cloud",
+	})
+)
+
+func init() {
+	crmetrics.Registry.MustRegister(heartbeatsTotal, heartbeatBytesTotal)
+}
+
+// Reporter periodically sends a heartbeat with the downsampled metrics of
+// the connections of the node to the cloud.
+//
+// The state of the connections and VFs is synced as deltas: every heartbeat
+// carries a sequence number and the changes since the last heartbeat the
+// cloud acknowledged (BaseSeq). A lost heartbeat or a disconnect needs no
+// special handling, the next heartbeat carries its changes again. A
+// heartbeat with BaseSeq 0 carries the full state, which is sent after a
+// restart of the reporter and when the cloud asks for a resync.
+type Reporter struct {
+	// Context for cancellation
+	ctx context.Context
+	// Kubernetes client (controller-runtime)
+	client client.Client
+	// Logger
+	logger *logrus.Logger
+	// URL the heartbeats are posted to
+	endpoint string
+	// Edge node identifier
+	nodeID string
+	// Interval between heartbeats
+	interval time.Duration
+	// Limits of the telemetry in a heartbeat
+	downsampling Downsampling
+	// Rollups of the samples since the last heartbeat
+	aggregator *Aggregator
+	// Lists the VFs of the node, nil without SR-IOV
+	vfs VFLister
+	// Usage reports pushed with the heartbeats, nil to not push them
+	usage UsageSource
+	// Node pressure, sampling pauses while the node is under pressure
+	pressure pressure.Signal
+	// Idle mode, sampling is suspended while the node is idle
+	idle idle.Signal
+	// Thermal state, sampling slows down near the thermal limits
+	thermal thermal.Signal
+	// Resource budget of the sampling, samples are skipped once it is exceeded
+	budget *budget.Tracker
+	// Connectivity to the cloud, tracked from the heartbeats, nil to not track it
+	link *cloud.Link
+	// Number of sample ticks
+	ticks int
+	// Sequence number of the last heartbeat sent
+	seq uint64
+	// Sequence number of the last heartbeat acknowledged, 0 for none
+	ackedSeq uint64
+	// State of the node as of the last acknowledged heartbeat
+	acked *snapshot
+	// HTTP client the heartbeats are sent with
+	httpClient *http.Client
+	// Progress signal for the watchdog
+	heartbeat *watchdog.Heartbeat
+}
+
+// NewReporter creates a new cloud reporter
+func NewReporter(ctx context.Context, c client.Client, logger *logrus.Logger, endpoint, nodeID string, interval time.Duration, ds Downsampling) *Reporter {
+	return &Reporter{
+		ctx:          ctx,
+		client:       c,
+		logger:       logger,
+		endpoint:     endpoint,
+		nodeID:       nodeID,
+		interval:     interval,
+		downsampling: ds,
+		aggregator:   NewAggregator(ds.Rollup),
+		httpClient:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// SetVFLister makes the reporter sync the VFs of the node
+func (r *Reporter) SetVFLister(vfs VFLister) {
+	r.vfs = vfs
+}
+
+// SetUsageSource makes the reporter push the usage reports with the
+// heartbeats, until a heartbeat carrying them is acknowledged
+func (r *Reporter) SetUsageSource(source UsageSource) {
+	r.usage = source
+}
+
+// SetPressureSignal makes the reporter pause sampling the connection
+// metrics while the node is under pressure. Heartbeats are still sent,
+// the cloud keeps seeing the state of the node.
+func (r *Reporter) SetPressureSignal(signal pressure.Signal) {
+	r.pressure = signal
+}
+
+// SetIdleSignal makes the reporter suspend sampling while the node is in
+// the low-power idle mode
+func (r *Reporter) SetIdleSignal(signal idle.Signal) {
+	r.idle = signal
+}
+
+// SetThermalSignal makes the reporter sample less often while the node
+// is near its thermal limits
+func (r *Reporter) SetThermalSignal(signal thermal.Signal) {
+	r.thermal = signal
+}
+
+// SetBudget makes the reporter account the resources of the sampling and
+// sample less often while over budget
+func (r *Reporter) SetBudget(tracker *budget.Tracker) {
+	r.budget = tracker
+}
+
+// SetLink makes the reporter track the connectivity to the cloud with
+// the outcome of its heartbeats
+func (r *Reporter) SetLink(link *cloud.Link) {
+	r.link = link
+}
+
+// SetHeartbeat makes the reporter report its progress to the watchdog
+func (r *Reporter) SetHeartbeat(hb *watchdog.Heartbeat) {
+	r.heartbeat = hb
+	hb.Expect(min(r.interval, sampleInterval))
+}
+
+// Start samples the connection metrics and sends the heartbeats
+func (r *Reporter) Start() error {
+	r.logger.Infof("Starting cloud reporter (heartbeat %s, rollup %s)", r.interval, r.downsampling.Rollup)
+
+	sampleTicker := time.NewTicker(min(r.interval, sampleInterval))
+	defer sampleTicker.Stop()
+	heartbeatTicker := time.NewTicker(r.interval)
+	defer heartbeatTicker.Stop()
+
+	for {
+		select {
+		case <-sampleTicker.C:
+			r.heartbeat.Beat()
+			if err := r.Sample(); err != nil {
+				r.logger.WithError(err).Warn("Failed to sample connection metrics")
+			}
+
+		case now := <-heartbeatTicker.C:
+			if err := r.Report(now); err != nil {
+				r.logger.WithError(err).Warn("Failed to send heartbeat")
+			}
+
+		case <-r.ctx.Done():
+			r.logger.Info("Stopping cloud reporter")
+			return nil
+		}
+	}
+}
+
+// Sample adds the current metrics of the connections to the rollups
+func (r *Reporter) Sample() error {
+	if r.pressure != nil && r.pressure.UnderPressure() {
+		return nil
+	}
+	if r.idle != nil && r.idle.Idle() {
+		return nil
+	}
+	r.ticks++
+	if r.thermal != nil && r.thermal.Hot() && r.ticks%hotSampleDivisor != 0 {
+		return nil
+	}
+	if r.ticks%r.budget.Divisor() != 0 {
+		return nil
+	}
+
+	var err error
+	r.budget.Run(func() { err = r.sample() })
+	r.budget.SetMemory(r.aggregator.Size())
+	return err
+}
+
+// sample lists the connections and adds their metrics to the rollups
+func (r *Reporter) sample() error {
+	var conns nsmv1.NetworkConnectionList
+	if err := r.client.List(r.ctx, &conns); err != nil {
+		return fmt.Errorf("failed to list connections: %w", err)
+	}
+
+	exists := make(map[types.NamespacedName]bool, len(conns.Items))
+	for i := range conns.Items {
+		conn := &conns.Items[i]
+		exists[types.NamespacedName{Namespace: conn.Namespace, Name: conn.Name}] = true
+		r.aggregator.Add(conn)
+	}
+	r.aggregator.Forget(func(key types.NamespacedName) bool { return exists[key] })
+	return nil
+}
+
+// Report sends a heartbeat with the state changes since the last
+// acknowledged heartbeat and the rollups of the windows ended by now.
+// Rollups that failed to send are dropped, resending them would only
+// push the next heartbeats over the budget.
+func (r *Reporter) Report(now time.Time) error {
+	var conns nsmv1.NetworkConnectionList
+	if err := r.client.List(r.ctx, &conns); err != nil {
+		return fmt.Errorf("failed to list connections: %w", err)
+	}
+	var services nsmv1.NetworkServiceList
+	if err := r.client.List(r.ctx, &services); err != nil {
+		return fmt.Errorf("failed to list services: %w", err)
+	}
+	var vfs []hardware.VirtualFunction
+	if r.vfs != nil {
+		vfs = r.vfs.VirtualFunctions()
+	}
+	current := newSnapshot(conns.Items, vfs, services.Items)
+
+	r.seq++
+	hb := &Heartbeat{NodeID: r.nodeID, Seq: r.seq, BaseSeq: r.ackedSeq, Time: now, Connections: len(conns.Items)}
+	if r.link != nil {
+		hb.AutonomousSec = int(r.link.AutonomousFor(now).Seconds())
+	}
+	current.delta(r.acked, hb)
+	if r.usage != nil {
+		hb.Usage = r.usage.Unacknowledged()
+	}
+
+	data, err := Encode(hb, r.aggregator.Flush(now), r.downsampling)
+	r.budget.SetMemory(r.aggregator.Size())
+	if err != nil {
+		return err
+	}
+	if hb.Truncated {
+		r.logger.Debugf("Heartbeat over the budget of %d bytes, omitted %d connections", r.downsampling.MaxBytes, hb.Omitted)
+	}
+
+	err = r.send(hb, current, data)
+	if r.link != nil {
+		r.link.Report(err, now)
+	}
+	return err
+}
+
+// send posts an encoded heartbeat and applies the acknowledgement
+func (r *Reporter) send(hb *Heartbeat, current *snapshot, data []byte) error {
+	req, err := http.NewRequestWithContext(r.ctx, http.MethodPost, r.endpoint, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to build heartbeat request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		heartbeatsTotal.WithLabelValues("failure").Inc()
+		return fmt.Errorf("failed to send heartbeat: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		heartbeatsTotal.WithLabelValues("failure").Inc()
+		return fmt.Errorf("heartbeat rejected with status %d", resp.StatusCode)
+	}
+	heartbeatsTotal.WithLabelValues("success").Inc()
+	heartbeatBytesTotal.Add(float64(len(data)))
+
+	var ack Ack
+	if err := json.NewDecoder(resp.Body).Decode(&ack); err != nil {
+		return fmt.Errorf("failed to decode heartbeat ack: %w", err)
+	}
+	switch {
+	case ack.Resync:
+		r.logger.Info("Cloud requested a resync, sending the full state with the next heartbeat")
+		r.acked, r.ackedSeq = nil, 0
+	case ack.Seq == hb.Seq:
+		r.acked, r.ackedSeq = current, hb.Seq
+		if len(hb.Usage) > 0 {
+			r.usage.Acknowledge(hb.Usage[len(hb.Usage)-1].End)
+		}
+	default:
+		return fmt.Errorf("heartbeat %d not acknowledged (ack %d)", hb.Seq, ack.Seq)
+	}
+	return nil
+}
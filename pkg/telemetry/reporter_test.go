@@ -0,0 +1,401 @@
+package telemetry
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/budget"
+	"github.com/akos011221/nsm/pkg/cloud"
+	"github.com/akos011221/nsm/pkg/hardware"
+	"github.com/akos011221/nsm/pkg/usage"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func quietLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return logger
+}
+
+func newTestClient(t *testing.T, objs ...client.Object) client.Client {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := nsmv1.AddToScheme(scheme); err != nil {
+		t.Fatalf("failed to build scheme: %v", err)
+	}
+	return fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build()
+}
+
+func TestReporterSendsDownsampledHeartbeat(t *testing.T) {
+	var received []Heartbeat
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var hb Heartbeat
+		if err := json.NewDecoder(r.Body).Decode(&hb); err != nil {
+			t.Errorf("invalid heartbeat: %v", err)
+		}
+		received = append(received, hb)
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(Ack{Seq: hb.Seq})
+	}))
+	defer srv.Close()
+
+	c := newTestClient(t,
+		observed("video", epoch.Add(5*time.Second), 10, 500, 0),
+		observed("bulk", epoch.Add(5*time.Second), 40, 900, 0),
+		observed("sensor", epoch.Add(5*time.Second), 5, 1, 0))
+	r := NewReporter(context.Background(), c, quietLogger(), srv.URL, "edge-1", 30*time.Second, Downsampling{Rollup: time.Minute, TopN: 1})
+
+	if err := r.Sample(); err != nil {
+		t.Fatalf("Sample() error = %v", err)
+	}
+
+	// the window is still open
+	before := testutil.ToFloat64(heartbeatsTotal.WithLabelValues("success"))
+	if err := r.Report(epoch.Add(30 * time.Second)); err != nil {
+		t.Fatalf("Report() error = %v", err)
+	}
+	if len(received) != 1 || received[0].NodeID != "edge-1" || received[0].Connections != 3 || len(received[0].Rollups) != 0 {
+		t.Fatalf("unexpected heartbeat with an open window: %+v", received)
+	}
+
+	if err := r.Report(epoch.Add(time.Minute)); err != nil {
+		t.Fatalf("Report() error = %v", err)
+	}
+	hb := received[1]
+	if len(hb.Rollups) != 1 || hb.Rollups[0].Connection != "edge/bulk" || hb.Omitted != 2 {
+		t.Errorf("unexpected heartbeat %+v, want the busiest connection only", hb)
+	}
+	if got := testutil.ToFloat64(heartbeatsTotal.WithLabelValues("success")) - before; got != 2 {
+		t.Errorf("successful heartbeats = %v, want 2", got)
+	}
+
+	status = http.StatusServiceUnavailable
+	if err := r.Report(epoch.Add(2 * time.Minute)); err == nil {
+		t.Errorf("expected error for a rejected heartbeat")
+	}
+}
+
+type fakeVFs []hardware.VirtualFunction
+
+func (f fakeVFs) VirtualFunctions() []hardware.VirtualFunction {
+	return f
+}
+
+func TestReporterSyncsDeltas(t *testing.T) {
+	var received []Heartbeat
+	reply := func(hb Heartbeat) interface{} { return Ack{Seq: hb.Seq} }
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var hb Heartbeat
+		if err := json.NewDecoder(r.Body).Decode(&hb); err != nil {
+			t.Errorf("invalid heartbeat: %v", err)
+		}
+		received = append(received, hb)
+		json.NewEncoder(w).Encode(reply(hb))
+	}))
+	defer srv.Close()
+
+	video := observed("video", epoch, 10, 100, 0)
+	video.Status.State = nsmv1.ConnectionStateEstablished
+	c := newTestClient(t, video, observed("bulk", epoch, 10, 100, 0))
+	vfs := fakeVFs{
+		{PFName: "eth0", VFID: 0, PCIAddress: "0000:3b:02.0"},
+		{PFName: "eth0", VFID: 1, PCIAddress: "0000:3b:02.1"},
+	}
+	r := NewReporter(context.Background(), c, quietLogger(), srv.URL, "edge-1", 30*time.Second, Downsampling{Rollup: time.Minute})
+	r.SetVFLister(vfs)
+	ctx := context.Background()
+
+	// the first heartbeat carries the full state
+	if err := r.Report(epoch); err != nil {
+		t.Fatalf("Report() error = %v", err)
+	}
+	if hb := received[0]; hb.Seq != 1 || hb.BaseSeq != 0 || len(hb.ConnectionChanges) != 2 || len(hb.VFChanges) != 2 {
+		t.Fatalf("unexpected full heartbeat %+v", hb)
+	}
+
+	// nothing changed
+	if err := r.Report(epoch.Add(30 * time.Second)); err != nil {
+		t.Fatalf("Report() error = %v", err)
+	}
+	if hb := received[1]; hb.Seq != 2 || hb.BaseSeq != 1 || len(hb.ConnectionChanges) != 0 || len(hb.VFChanges) != 0 {
+		t.Fatalf("unexpected heartbeat without changes %+v", hb)
+	}
+
+	// a connection fails, another is deleted and a VF is allocated
+	video.Status.State = nsmv1.ConnectionStateFailed
+	if err := c.Update(ctx, video); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Delete(ctx, observed("bulk", epoch, 0, 0, 0)); err != nil {
+		t.Fatal(err)
+	}
+	vfs[1].Allocated, vfs[1].AllocatedTo, vfs[1].Namespace = true, "camera", "edge"
+
+	// the heartbeat is lost
+	reply = func(Heartbeat) interface{} { return struct{}{} }
+	if err := r.Report(epoch.Add(time.Minute)); err == nil {
+		t.Errorf("expected error for an unacknowledged heartbeat")
+	}
+
+	// the changes are sent again relative to the last ack
+	reply = func(hb Heartbeat) interface{} { return Ack{Seq: hb.Seq} }
+	if err := r.Report(epoch.Add(90 * time.Second)); err != nil {
+		t.Fatalf("Report() error = %v", err)
+	}
+	hb := received[3]
+	wantConn := []ConnectionState{{Name: "edge/video", State: nsmv1.ConnectionStateFailed}}
+	wantVF := []VFState{{PCIAddress: "0000:3b:02.1", PFName: "eth0", VFID: 1, AllocatedTo: "edge/camera"}}
+	if hb.Seq != 4 || hb.BaseSeq != 2 {
+		t.Errorf("seq = %d, base = %d, want 4 relative to 2", hb.Seq, hb.BaseSeq)
+	}
+	if !reflect.DeepEqual(hb.ConnectionChanges, wantConn) || !reflect.DeepEqual(hb.ConnectionRemovals, []string{"edge/bulk"}) || !reflect.DeepEqual(hb.VFChanges, wantVF) {
+		t.Errorf("unexpected delta %+v", hb)
+	}
+
+	// the cloud lost its state
+	reply = func(Heartbeat) interface{} { return Ack{Resync: true} }
+	if err := r.Report(epoch.Add(2 * time.Minute)); err != nil {
+		t.Fatalf("Report() error = %v", err)
+	}
+	reply = func(hb Heartbeat) interface{} { return Ack{Seq: hb.Seq} }
+	if err := r.Report(epoch.Add(150 * time.Second)); err != nil {
+		t.Fatalf("Report() error = %v", err)
+	}
+	if hb := received[5]; hb.BaseSeq != 0 || len(hb.ConnectionChanges) != 1 || len(hb.VFChanges) != 2 || len(hb.ConnectionRemovals) != 0 {
+		t.Errorf("unexpected heartbeat after resync %+v, want the full state", hb)
+	}
+}
+
+func TestReporterTracksCloudLink(t *testing.T) {
+	var received []Heartbeat
+	up := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !up {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		var hb Heartbeat
+		if err := json.NewDecoder(r.Body).Decode(&hb); err != nil {
+			t.Errorf("invalid heartbeat: %v", err)
+		}
+		received = append(received, hb)
+		json.NewEncoder(w).Encode(Ack{Seq: hb.Seq})
+	}))
+	defer srv.Close()
+
+	svc := &nsmv1.NetworkService{
+		ObjectMeta: metav1.ObjectMeta{Name: "plc", Namespace: "edge"},
+		Spec:       nsmv1.NetworkServiceSpec{ServiceType: "l3", Endpoint: "10.0.0.5"},
+		Status:     nsmv1.NetworkServiceStatus{Phase: nsmv1.ServicePhaseReady, ConnectionCount: 2},
+	}
+	c := newTestClient(t, svc)
+	link := cloud.NewLink(quietLogger(), time.Minute)
+	r := NewReporter(context.Background(), c, quietLogger(), srv.URL, "edge-1", 30*time.Second, Downsampling{Rollup: time.Minute})
+	r.SetLink(link)
+	start := time.Now()
+
+	if err := r.Report(start); err != nil {
+		t.Fatalf("Report() error = %v", err)
+	}
+	want := []ServiceState{{Name: "edge/plc", ServiceType: "l3", Phase: nsmv1.ServicePhaseReady, Connections: 2}}
+	if !reflect.DeepEqual(received[0].ServiceChanges, want) {
+		t.Fatalf("services = %+v, want %+v", received[0].ServiceChanges, want)
+	}
+
+	// the cloud is unreachable for the disconnect period
+	up = false
+	for i := 1; i <= 2; i++ {
+		if err := r.Report(start.Add(time.Duration(i) * 30 * time.Second)); err == nil {
+			t.Fatalf("Report() succeeded with the cloud unreachable")
+		}
+	}
+	if !link.Autonomous() {
+		t.Fatal("not autonomous after the disconnect period")
+	}
+
+	// the first heartbeat getting through reports the autonomous period
+	up = true
+	if err := r.Report(start.Add(3 * time.Minute)); err != nil {
+		t.Fatalf("Report() error = %v", err)
+	}
+	if hb := received[1]; hb.AutonomousSec != 120 || len(hb.ServiceChanges) != 0 {
+		t.Errorf("heartbeat after reconnecting = %+v, want 120s autonomous and no service changes", hb)
+	}
+	if link.Autonomous() {
+		t.Error("still autonomous after a heartbeat got through")
+	}
+}
+
+type pressureFlag bool
+
+func (p pressureFlag) UnderPressure() bool {
+	return bool(p)
+}
+
+func TestReporterPausesSamplingUnderPressure(t *testing.T) {
+	c := newTestClient(t, observed("video", epoch.Add(5*time.Second), 10, 500, 0))
+	r := NewReporter(context.Background(), c, quietLogger(), "http://127.0.0.1:0", "edge-1", 30*time.Second, Downsampling{Rollup: time.Minute})
+	r.SetPressureSignal(pressureFlag(true))
+
+	if err := r.Sample(); err != nil {
+		t.Fatalf("Sample() error = %v", err)
+	}
+	if rollups := r.aggregator.Flush(epoch.Add(time.Hour)); len(rollups) != 0 {
+		t.Errorf("metrics sampled under pressure: %+v", rollups)
+	}
+}
+
+type hotFlag bool
+
+func (h hotFlag) Hot() bool {
+	return bool(h)
+}
+
+func (h hotFlag) OnChange(func(bool)) {}
+
+func TestReporterSamplesLessWhenHot(t *testing.T) {
+	conn := observed("video", epoch, 10, 500, 0)
+	c := newTestClient(t, conn)
+	r := NewReporter(context.Background(), c, quietLogger(), "http://127.0.0.1:0", "edge-1", 30*time.Second, Downsampling{Rollup: time.Hour})
+	r.SetThermalSignal(hotFlag(true))
+
+	for i := 1; i <= 2*hotSampleDivisor; i++ {
+		updated := metav1.NewTime(epoch.Add(time.Duration(i) * time.Second))
+		conn.Status.Metrics.LastUpdated = &updated
+		if err := c.Update(context.Background(), conn); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Sample(); err != nil {
+			t.Fatalf("Sample() error = %v", err)
+		}
+	}
+
+	rollups := r.aggregator.Flush(epoch.Add(2 * time.Hour))
+	if len(rollups) != 1 || rollups[0].Samples != 2 {
+		t.Errorf("rollups = %+v, want 2 samples out of %d ticks", rollups, 2*hotSampleDivisor)
+	}
+}
+
+type idleFlag bool
+
+func (f idleFlag) Idle() bool {
+	return bool(f)
+}
+
+func TestReporterSuspendsSamplingWhenIdle(t *testing.T) {
+	c := newTestClient(t, observed("video", epoch.Add(5*time.Second), 10, 500, 0))
+	r := NewReporter(context.Background(), c, quietLogger(), "http://127.0.0.1:0", "edge-1", 30*time.Second, Downsampling{Rollup: time.Minute})
+	r.SetIdleSignal(idleFlag(true))
+
+	if err := r.Sample(); err != nil {
+		t.Fatalf("Sample() error = %v", err)
+	}
+	if rollups := r.aggregator.Flush(epoch.Add(time.Hour)); len(rollups) != 0 {
+		t.Errorf("metrics sampled while idle: %+v", rollups)
+	}
+}
+
+func TestReporterDegradesOverBudget(t *testing.T) {
+	conn := observed("video", epoch, 10, 500, 0)
+	c := newTestClient(t, conn)
+	r := NewReporter(context.Background(), c, quietLogger(), "http://127.0.0.1:0", "edge-1", 30*time.Second, Downsampling{Rollup: time.Hour})
+	budgets := budget.NewManager(context.Background(), quietLogger(), 10*time.Second)
+	r.SetBudget(budgets.Track("telemetry", budget.Budget{MemoryBytes: 1}))
+
+	sampleAt := func(i int) {
+		t.Helper()
+		updated := metav1.NewTime(epoch.Add(time.Duration(i) * time.Second))
+		conn.Status.Metrics.LastUpdated = &updated
+		if err := c.Update(context.Background(), conn); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Sample(); err != nil {
+			t.Fatalf("Sample() error = %v", err)
+		}
+	}
+
+	// the first sample opens a window, putting the rollups over the budget
+	sampleAt(1)
+	if err := budgets.Check(time.Now().Add(10 * time.Second)); err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	for i := 2; i <= 5; i++ {
+		sampleAt(i)
+	}
+
+	rollups := r.aggregator.Flush(epoch.Add(2 * time.Hour))
+	if len(rollups) != 1 || rollups[0].Samples != 3 {
+		t.Errorf("rollups = %+v, want 1 sample before and 2 out of 4 ticks over budget", rollups)
+	}
+}
+
+// fakeUsage holds usage reports until acknowledged
+type fakeUsage struct {
+	reports []usage.Report
+	acked   time.Time
+}
+
+func (f *fakeUsage) Unacknowledged() []usage.Report {
+	var reports []usage.Report
+	for _, report := range f.reports {
+		if report.End.After(f.acked) {
+			reports = append(reports, report)
+		}
+	}
+	return reports
+}
+
+func (f *fakeUsage) Acknowledge(end time.Time) {
+	f.acked = end
+}
+
+func TestReporterPushesUsageReports(t *testing.T) {
+	var received []Heartbeat
+	acknowledge := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var hb Heartbeat
+		if err := json.NewDecoder(r.Body).Decode(&hb); err != nil {
+			t.Errorf("invalid heartbeat: %v", err)
+		}
+		received = append(received, hb)
+		if acknowledge {
+			json.NewEncoder(w).Encode(Ack{Seq: hb.Seq})
+		} else {
+			json.NewEncoder(w).Encode(struct{}{})
+		}
+	}))
+	defer srv.Close()
+
+	source := &fakeUsage{reports: []usage.Report{{NodeID: "edge-1", Start: epoch, End: epoch.Add(time.Hour)}}}
+	r := NewReporter(context.Background(), newTestClient(t), quietLogger(), srv.URL, "edge-1", 30*time.Second, Downsampling{Rollup: time.Minute})
+	r.SetUsageSource(source)
+
+	// a report is sent again until a heartbeat carrying it is acknowledged
+	acknowledge = false
+	if err := r.Report(epoch.Add(time.Hour)); err == nil {
+		t.Error("expected error for an unacknowledged heartbeat")
+	}
+	acknowledge = true
+	if err := r.Report(epoch.Add(time.Hour + 30*time.Second)); err != nil {
+		t.Fatalf("Report() error = %v", err)
+	}
+	if err := r.Report(epoch.Add(time.Hour + time.Minute)); err != nil {
+		t.Fatalf("Report() error = %v", err)
+	}
+	if len(received[0].Usage) != 1 || len(received[1].Usage) != 1 || len(received[2].Usage) != 0 {
+		t.Errorf("usage reports sent = %d, %d, %d, want 1, 1, 0", len(received[0].Usage), len(received[1].Usage), len(received[2].Usage))
+	}
+}
@@ -0,0 +1,161 @@
+package telemetry
+
+import (
+	"sort"
+	"time"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/hardware"
+	"github.com/akos011221/nsm/pkg/usage"
+)
+
+// VFLister lists the SR-IOV virtual functions of the node
+type VFLister interface {
+	VirtualFunctions() []hardware.VirtualFunction
+}
+
+// UsageSource provides the usage reports pushed to the cloud
+type UsageSource interface {
+	// Unacknowledged returns the reports not yet acknowledged, oldest first
+	Unacknowledged() []usage.Report
+	// Acknowledge marks the reports ending until end as received
+	Acknowledge(end time.Time)
+}
+
+// ConnectionState is the state of a connection synced to the cloud
+type ConnectionState struct {
+	// Connection as namespace/name
+	Name string `json:"name"`
+	// Current state of the connection
+	State string `json:"state,omitempty"`
+	// Whether the datapath is established
+	Established bool `json:"established,omitempty"`
+	// Datapath serving the connection
+	Datapath string `json:"datapath,omitempty"`
+	// Path currently carrying the traffic
+	ActivePath string `json:"activePath,omitempty"`
+	// Encryption mode (offload, software), empty if unencrypted
+	Encryption string `json:"encryption,omitempty"`
+}
+
+// VFState is the state of a virtual function synced to the cloud
+type VFState struct {
+	// VF PCI address
+	PCIAddress string `json:"pci"`
+	// PF name
+	PFName string `json:"pf"`
+	// VF ID
+	VFID int `json:"vf"`
+	// Pod using the VF as namespace/name, empty if free
+	AllocatedTo string `json:"allocatedTo,omitempty"`
+}
+
+// ServiceState is the state of a NetworkService synced to the cloud
+type ServiceState struct {
+	// Service as namespace/name
+	Name string `json:"name"`
+	// Type of the service
+	ServiceType string `json:"type,omitempty"`
+	// Current phase of the service
+	Phase string `json:"phase,omitempty"`
+	// Number of connections using the service
+	Connections int `json:"connections,omitempty"`
+}
+
+// Ack is the response of the cloud to a heartbeat
+type Ack struct {
+	// Sequence number of the heartbeat the cloud applied
+	Seq uint64 `json:"ack"`
+	// Whether the cloud lost the synced state and needs a full snapshot
+	Resync bool `json:"resync,omitempty"`
+}
+
+// snapshot is the synced state of the node
+type snapshot struct {
+	connections map[string]ConnectionState
+	vfs         map[string]VFState
+	services    map[string]ServiceState
+}
+
+// newSnapshot captures the state of the connections, VFs and services
+func newSnapshot(conns []nsmv1.NetworkConnection, vfs []hardware.VirtualFunction, services []nsmv1.NetworkService) *snapshot {
+	s := &snapshot{
+		connections: make(map[string]ConnectionState, len(conns)),
+		vfs:         make(map[string]VFState, len(vfs)),
+		services:    make(map[string]ServiceState, len(services)),
+	}
+	for _, conn := range conns {
+		name := conn.Namespace + "/" + conn.Name
+		s.connections[name] = ConnectionState{
+			Name:        name,
+			State:       conn.Status.State,
+			Established: conn.Status.Established,
+			Datapath:    conn.Status.Datapath,
+			ActivePath:  conn.Status.ActivePath,
+			Encryption:  conn.Status.Encryption,
+		}
+	}
+	for _, vf := range vfs {
+		state := VFState{PCIAddress: vf.PCIAddress, PFName: vf.PFName, VFID: vf.VFID}
+		if vf.Allocated {
+			state.AllocatedTo = vf.Namespace + "/" + vf.AllocatedTo
+		}
+		s.vfs[vf.PCIAddress] = state
+	}
+	for _, svc := range services {
+		name := svc.Namespace + "/" + svc.Name
+		s.services[name] = ServiceState{
+			Name:        name,
+			ServiceType: svc.Spec.ServiceType,
+			Phase:       svc.Status.Phase,
+			Connections: svc.Status.ConnectionCount,
+		}
+	}
+	return s
+}
+
+// delta fills the heartbeat with the changes from the base to the snapshot,
+// a nil base gives the full snapshot
+func (s *snapshot) delta(base *snapshot, hb *Heartbeat) {
+	if base == nil {
+		base = &snapshot{}
+	}
+
+	for name, state := range s.connections {
+		if old, ok := base.connections[name]; !ok || old != state {
+			hb.ConnectionChanges = append(hb.ConnectionChanges, state)
+		}
+	}
+	for name := range base.connections {
+		if _, ok := s.connections[name]; !ok {
+			hb.ConnectionRemovals = append(hb.ConnectionRemovals, name)
+		}
+	}
+	for addr, state := range s.vfs {
+		if old, ok := base.vfs[addr]; !ok || old != state {
+			hb.VFChanges = append(hb.VFChanges, state)
+		}
+	}
+	for addr := range base.vfs {
+		if _, ok := s.vfs[addr]; !ok {
+			hb.VFRemovals = append(hb.VFRemovals, addr)
+		}
+	}
+	for name, state := range s.services {
+		if old, ok := base.services[name]; !ok || old != state {
+			hb.ServiceChanges = append(hb.ServiceChanges, state)
+		}
+	}
+	for name := range base.services {
+		if _, ok := s.services[name]; !ok {
+			hb.ServiceRemovals = append(hb.ServiceRemovals, name)
+		}
+	}
+
+	sort.Slice(hb.ConnectionChanges, func(i, j int) bool { return hb.ConnectionChanges[i].Name < hb.ConnectionChanges[j].Name })
+	sort.Strings(hb.ConnectionRemovals)
+	sort.Slice(hb.VFChanges, func(i, j int) bool { return hb.VFChanges[i].PCIAddress < hb.VFChanges[j].PCIAddress })
+	sort.Strings(hb.VFRemovals)
+	sort.Slice(hb.ServiceChanges, func(i, j int) bool { return hb.ServiceChanges[i].Name < hb.ServiceChanges[j].Name })
+	sort.Strings(hb.ServiceRemovals)
+}
@@ -0,0 +1,200 @@
+package thermal
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/akos011221/nsm/pkg/hardware"
+	"github.com/akos011221/nsm/pkg/watchdog"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	// temperatureCelsius exposes the temperature sensors
+	temperatureCelsius = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "nsm_hwmon_temperature_celsius",
+		Help: "Temperature of a hwmon sensor (board, CPU, NIC)",
+	}, []string{"hwmon", "chip", "sensor"})
+
+	// powerWatts exposes the power sensors
+	powerWatts = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "nsm_hwmon_power_watts",
+		Help: "Power draw of a hwmon sensor",
+	}, []string{"hwmon", "chip", "sensor"})
+
+	// nearThermalLimit is 1 while a sensor is within the margin of its limit
+	nearThermalLimit = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "nsm_near_thermal_limit",
+		Help: "Whether a temperature sensor of the node is within the margin of its limit",
+	})
+)
+
+func init() {
+	crmetrics.Registry.MustRegister(temperatureCelsius, powerWatts, nearThermalLimit)
+}
+
+// Signal tells whether the node is close to its thermal limits
+type Signal interface {
+	// Hot reports whether a sensor is within the margin of its limit
+	Hot() bool
+	// OnChange registers a function called when the node gets hot or cools down
+	OnChange(fn func(hot bool))
+}
+
+// Status is the thermal state of the node
+type Status struct {
+	// Whether a sensor is within the margin of its limit
+	Hot bool `json:"hot"`
+	// Last readings of the sensors
+	Sensors []hardware.Sensor `json:"sensors"`
+}
+
+// Monitor reads the temperature and power sensors of the node. The node
+// is hot once a temperature gets within the margin of its limit, and
+// cools down when all temperatures are twice the margin below their
+// limits, so shedding doesn't flap around the threshold.
+type Monitor struct {
+	// Context for cancellation
+	ctx context.Context
+	// Logger
+	logger *logrus.Logger
+	// Root of the filesystem sysfs is mounted under
+	root string
+	// Degrees Celsius below the limit of a sensor the node is hot at
+	margin float64
+	// Interval between readings
+	interval time.Duration
+	// Whether the node is hot
+	hot bool
+	// Last readings
+	sensors []hardware.Sensor
+	// Functions called on changes of the thermal state
+	listeners []func(hot bool)
+	// Mutex for protecting the state
+	mu sync.RWMutex
+	// Progress signal for the watchdog
+	heartbeat *watchdog.Heartbeat
+}
+
+// NewMonitor creates a new thermal monitor
+func NewMonitor(ctx context.Context, logger *logrus.Logger, root string, margin float64) *Monitor {
+	return &Monitor{
+		ctx:      ctx,
+		logger:   logger,
+		root:     root,
+		margin:   margin,
+		interval: 10 * time.Second,
+	}
+}
+
+// SetHeartbeat makes the monitor report its progress to the watchdog
+func (m *Monitor) SetHeartbeat(hb *watchdog.Heartbeat) {
+	m.heartbeat = hb
+	hb.Expect(m.interval)
+}
+
+// Start reads the sensors periodically
+func (m *Monitor) Start() error {
+	m.logger.Infof("Starting thermal monitor (margin %.0f°C)", m.margin)
+	if err := m.Check(); err != nil {
+		m.logger.WithError(err).Warn("Failed to read hwmon sensors")
+	}
+
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			m.heartbeat.Beat()
+			if err := m.Check(); err != nil {
+				m.logger.WithError(err).Warn("Failed to read hwmon sensors")
+			}
+
+		case <-m.ctx.Done():
+			m.logger.Info("Stopping thermal monitor")
+			return nil
+		}
+	}
+}
+
+// Hot reports whether a sensor is within the margin of its limit
+func (m *Monitor) Hot() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.hot
+}
+
+// OnChange registers a function called when the node gets hot or cools down
+func (m *Monitor) OnChange(fn func(hot bool)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.listeners = append(m.listeners, fn)
+}
+
+// Status returns the thermal state and the last sensor readings
+func (m *Monitor) Status() Status {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return Status{Hot: m.hot, Sensors: append([]hardware.Sensor{}, m.sensors...)}
+}
+
+// Check reads the sensors and updates the thermal state
+func (m *Monitor) Check() error {
+	sensors, err := hardware.ReadSensors(m.root)
+	if err != nil {
+		return err
+	}
+
+	var near []string
+	cool := true
+	for _, s := range sensors {
+		labels := prometheus.Labels{"hwmon": s.Hwmon, "chip": s.Chip, "sensor": s.Name}
+		if s.Kind == hardware.SensorPower {
+			powerWatts.With(labels).Set(s.Value)
+			continue
+		}
+		temperatureCelsius.With(labels).Set(s.Value)
+		if s.Limit == 0 {
+			continue
+		}
+		if s.Value >= s.Limit-m.margin {
+			near = append(near, fmt.Sprintf("%s %s at %.0f°C (limit %.0f°C)", s.Chip, s.Name, s.Value, s.Limit))
+		}
+		if s.Value >= s.Limit-2*m.margin {
+			cool = false
+		}
+	}
+
+	m.mu.Lock()
+	m.sensors = sensors
+	changed := false
+	switch {
+	case len(near) > 0 && !m.hot:
+		m.logger.Warnf("Node near thermal limit: %s", strings.Join(near, ", "))
+		m.hot, changed = true, true
+	case cool && m.hot:
+		m.logger.Info("Node cooled down below its thermal limits")
+		m.hot, changed = false, true
+	}
+	hot := m.hot
+	listeners := append([]func(bool){}, m.listeners...)
+	m.mu.Unlock()
+
+	if hot {
+		nearThermalLimit.Set(1)
+	} else {
+		nearThermalLimit.Set(0)
+	}
+	if changed {
+		for _, fn := range listeners {
+			fn(hot)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package thermal
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+)
+
+func quietLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return logger
+}
+
+// setTemperature writes a NIC temperature sensor with a limit of 90°C
+func setTemperature(t *testing.T, root string, celsius int) {
+	t.Helper()
+	dir := filepath.Join(root, "sys/class/hwmon/hwmon0")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for attr, value := range map[string]string{
+		"name":        "mlx5",
+		"temp1_input": fmt.Sprint(celsius * 1000),
+		"temp1_crit":  "90000",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, attr), []byte(value+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestMonitorHysteresis(t *testing.T) {
+	root := t.TempDir()
+	m := NewMonitor(context.Background(), quietLogger(), root, 5)
+	var changes []bool
+	m.OnChange(func(hot bool) { changes = append(changes, hot) })
+
+	for _, step := range []struct {
+		celsius int
+		hot     bool
+	}{
+		{70, false},
+		// within the margin of the limit
+		{86, true},
+		// below the margin, but not twice the margin
+		{83, true},
+		{79, false},
+	} {
+		setTemperature(t, root, step.celsius)
+		if err := m.Check(); err != nil {
+			t.Fatalf("Check() error = %v", err)
+		}
+		if m.Hot() != step.hot {
+			t.Errorf("at %d°C hot = %t, want %t", step.celsius, m.Hot(), step.hot)
+		}
+	}
+
+	if len(changes) != 2 || !changes[0] || changes[1] {
+		t.Errorf("changes = %v, want hot then cool", changes)
+	}
+	if got := testutil.ToFloat64(temperatureCelsius.WithLabelValues("hwmon0", "mlx5", "temp1")); got != 79 {
+		t.Errorf("temperature gauge = %v, want 79", got)
+	}
+	if status := m.Status(); status.Hot || len(status.Sensors) != 1 || status.Sensors[0].Limit != 90 {
+		t.Errorf("unexpected status %+v", status)
+	}
+}
@@ -0,0 +1,346 @@
+package traffic
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// Test packets start with the magic, followed by the sequence number and
+// the time they were sent at in nanoseconds since the start of the test
+var magic = []byte("NSMT")
+
+const (
+	// headerSize is the size of the test header
+	headerSize = 4 + 8 + 8
+	// minPacketSize and maxPacketSize bound the UDP payload of the packets
+	minPacketSize = 64
+	maxPacketSize = 9000
+)
+
+// Defaults of the test requests
+const (
+	defaultPacketSize  = 1000
+	defaultDurationSec = 10
+)
+
+// MaxDiagnostics is the number of results kept in the diagnostics history
+// of a connection
+const MaxDiagnostics = 10
+
+// ErrBusy is returned while another traffic test is running
+var ErrBusy = errors.New("a traffic test is already running")
+
+// testsTotal counts the traffic tests by outcome
+var testsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "nsm_traffic_tests_total",
+	Help: "Number of connection traffic tests by result (passed, failed)",
+}, []string{"result"})
+
+func init() {
+	crmetrics.Registry.MustRegister(testsTotal)
+}
+
+// Request describes a traffic test against a connection
+type Request struct {
+	// Offered rate in Mbps
+	RateMbps int `json:"rateMbps"`
+	// UDP payload size in bytes, defaults to 1000
+	PacketSize int `json:"packetSize,omitempty"`
+	// Duration in seconds, defaults to 10
+	DurationSec int `json:"durationSec,omitempty"`
+	// Reflector (host:port) echoing the traffic, defaults to the host of
+	// the probe target of the connection on the default reflector port
+	Target string `json:"target,omitempty"`
+}
+
+// Report is the outcome of a traffic test
+type Report struct {
+	// Connection (namespace/name)
+	Connection string `json:"connection"`
+	// Reflector the traffic was sent to
+	Target string `json:"target"`
+	// Result, as saved in the diagnostics history of the connection
+	Result nsmv1.DiagnosticResult `json:"result"`
+	// Error saving the result, empty if it is in the history
+	SaveError string `json:"saveError,omitempty"`
+}
+
+// stats are the measurements of a test
+type stats struct {
+	// Packets sent and echoed back
+	sent, received int
+	// Sum and maximum of the round trip times
+	rttSum, rttMax time.Duration
+	// Sum of the differences between consecutive round trip times
+	jitterSum time.Duration
+	// Round trip time of the previous echo
+	lastRTT time.Duration
+}
+
+// Generator sends test traffic at a given rate over established
+// connections to a reflector echoing it, measuring the throughput, loss
+// and latency the connection sustains, e.g. to validate its bandwidth
+// shaping and path capacity after setup. The results are saved in the
+// diagnostics history of the connection. One test runs at a time, so
+// tests don't skew each other.
+type Generator struct {
+	// Kubernetes client (controller-runtime)
+	client client.Client
+	// Logger
+	logger *logrus.Logger
+	// Longest test
+	maxDuration time.Duration
+	// Highest rate offered
+	maxRateMbps int
+	// Time the echoes of the last packets are waited for
+	grace time.Duration
+	// Guards running
+	mu sync.Mutex
+	// Whether a test is running
+	running bool
+}
+
+// NewGenerator creates a new traffic generator. No test runs for longer
+// than maxDuration or offers more than maxRateMbps.
+func NewGenerator(c client.Client, logger *logrus.Logger, maxDuration time.Duration, maxRateMbps int) *Generator {
+	return &Generator{
+		client:      c,
+		logger:      logger,
+		maxDuration: maxDuration,
+		maxRateMbps: maxRateMbps,
+		grace:       time.Second,
+	}
+}
+
+// Run runs a traffic test against an established connection and saves the
+// result in its diagnostics history
+func (g *Generator) Run(ctx context.Context, namespace, name string, req Request) (*Report, error) {
+	if req.PacketSize == 0 {
+		req.PacketSize = defaultPacketSize
+	}
+	if req.DurationSec == 0 {
+		req.DurationSec = defaultDurationSec
+	}
+	if req.RateMbps <= 0 || req.RateMbps > g.maxRateMbps {
+		return nil, fmt.Errorf("rate must be between 1 and %d Mbps", g.maxRateMbps)
+	}
+	if req.PacketSize < minPacketSize || req.PacketSize > maxPacketSize {
+		return nil, fmt.Errorf("packet size must be between %d and %d bytes", minPacketSize, maxPacketSize)
+	}
+	duration := time.Duration(req.DurationSec) * time.Second
+	if req.DurationSec < 0 || duration > g.maxDuration {
+		return nil, fmt.Errorf("test duration must be between 1 and %d seconds", int(g.maxDuration.Seconds()))
+	}
+
+	var conn nsmv1.NetworkConnection
+	if err := g.client.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, &conn); err != nil {
+		return nil, fmt.Errorf("failed to get connection %s/%s: %w", namespace, name, err)
+	}
+	if !conn.Status.Established {
+		return nil, fmt.Errorf("connection %s/%s is not established", namespace, name)
+	}
+	target := req.Target
+	if target == "" {
+		host, _, err := net.SplitHostPort(conn.Spec.ProbeTarget)
+		if err != nil {
+			return nil, fmt.Errorf("connection %s/%s has no probe target, a target is required", namespace, name)
+		}
+		target = net.JoinHostPort(host, strconv.Itoa(DefaultReflectorPort))
+	}
+
+	g.mu.Lock()
+	if g.running {
+		g.mu.Unlock()
+		return nil, ErrBusy
+	}
+	g.running = true
+	g.mu.Unlock()
+	defer func() {
+		g.mu.Lock()
+		g.running = false
+		g.mu.Unlock()
+	}()
+
+	g.logger.Infof("Traffic test on %s/%s: %d Mbps of %d byte packets to %s for %s",
+		namespace, name, req.RateMbps, req.PacketSize, target, duration)
+	st, err := g.send(ctx, target, req.RateMbps, req.PacketSize, duration)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{
+		Connection: namespace + "/" + name,
+		Target:     target,
+		Result:     evaluate(&conn, req, st, duration),
+	}
+	verdict := "failed"
+	if report.Result.Passed {
+		verdict = "passed"
+	}
+	testsTotal.WithLabelValues(verdict).Inc()
+	g.logger.Infof("Traffic test on %s/%s %s: %s", namespace, name, verdict, report.Result.Message)
+
+	if err := g.save(ctx, namespace, name, report.Result); err != nil {
+		g.logger.WithError(err).Warnf("Failed to save the traffic test result of %s/%s", namespace, name)
+		report.SaveError = err.Error()
+	}
+	return report, nil
+}
+
+// send paces the test packets to the target for the duration and collects
+// their echoes
+func (g *Generator) send(ctx context.Context, target string, rateMbps, size int, duration time.Duration) (*stats, error) {
+	conn, err := net.Dial("udp", target)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach %s: %w", target, err)
+	}
+	defer conn.Close()
+
+	st := &stats{}
+	start := time.Now()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		receive(conn, start, st)
+	}()
+
+	// the packets due are sent every millisecond, timers are too coarse
+	// to pace them one by one
+	pps := float64(rateMbps) * 1e6 / float64(size*8)
+	buf := make([]byte, size)
+	copy(buf, magic)
+	ticker := time.NewTicker(time.Millisecond)
+	defer ticker.Stop()
+	for {
+		elapsed := time.Since(start)
+		if elapsed >= duration {
+			break
+		}
+		for due := int(elapsed.Seconds()*pps) + 1; st.sent < due; st.sent++ {
+			binary.BigEndian.PutUint64(buf[4:], uint64(st.sent))
+			binary.BigEndian.PutUint64(buf[12:], uint64(time.Since(start)))
+			// losses show in the echoes, e.g. a refused port
+			_, _ = conn.Write(buf)
+		}
+		select {
+		case <-ctx.Done():
+			conn.Close()
+			<-done
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+
+	if err := conn.SetReadDeadline(time.Now().Add(g.grace)); err != nil {
+		conn.Close()
+	}
+	<-done
+	return st, nil
+}
+
+// receive collects the echoes until the connection is closed or its read
+// deadline passes
+func receive(conn net.Conn, start time.Time, st *stats) {
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && !netErr.Timeout() && !errors.Is(err, net.ErrClosed) {
+				// e.g. ICMP port unreachable, the next echoes may still arrive
+				continue
+			}
+			return
+		}
+		if n < headerSize || string(buf[:len(magic)]) != string(magic) {
+			continue
+		}
+		rtt := time.Since(start) - time.Duration(binary.BigEndian.Uint64(buf[12:]))
+		if st.received > 0 {
+			st.jitterSum += (rtt - st.lastRTT).Abs()
+		}
+		st.received++
+		st.rttSum += rtt
+		st.rttMax = max(st.rttMax, rtt)
+		st.lastRTT = rtt
+	}
+}
+
+// evaluate turns the measurements into a result. A connection with a
+// bandwidth limit passes if the echoed traffic reaches the rate offered up
+// to the limit without exceeding it, one without if the echoed traffic
+// reaches the rate offered. Both must meet the latency requirement.
+func evaluate(conn *nsmv1.NetworkConnection, req Request, st *stats, duration time.Duration) nsmv1.DiagnosticResult {
+	received := min(st.received, st.sent)
+	result := nsmv1.DiagnosticResult{
+		Type:           nsmv1.DiagnosticTrafficTest,
+		CompletionTime: metav1.Now(),
+		RateMbps:       req.RateMbps,
+		PacketSize:     req.PacketSize,
+		DurationSec:    req.DurationSec,
+		Sent:           st.sent,
+		Received:       received,
+		ThroughputKbps: int(float64(received*req.PacketSize*8) / duration.Seconds() / 1000),
+	}
+	if st.sent > 0 {
+		result.PacketLossPPM = int(int64(st.sent-received) * 1_000_000 / int64(st.sent))
+	}
+	if received > 0 {
+		result.AvgLatencyUs = int((st.rttSum / time.Duration(received)).Microseconds())
+		result.MaxLatencyUs = int(st.rttMax.Microseconds())
+	}
+	if received > 1 {
+		result.JitterUs = int((st.jitterSum / time.Duration(received-1)).Microseconds())
+	}
+
+	expectedKbps := req.RateMbps * 1000
+	shaped := conn.Spec.Bandwidth > 0 && conn.Spec.Bandwidth < req.RateMbps
+	if shaped {
+		expectedKbps = conn.Spec.Bandwidth * 1000
+	}
+	switch {
+	case received == 0:
+		result.Message = "no traffic echoed back, is a reflector running at the target?"
+	case result.ThroughputKbps < expectedKbps*9/10:
+		result.Message = fmt.Sprintf("throughput %d kbps below the expected %d kbps", result.ThroughputKbps, expectedKbps)
+	case shaped && result.ThroughputKbps > expectedKbps*11/10:
+		result.Message = fmt.Sprintf("throughput %d kbps exceeds the bandwidth limit of %d Mbps", result.ThroughputKbps, conn.Spec.Bandwidth)
+	case conn.Spec.LatencyRequirement > 0 && result.AvgLatencyUs > conn.Spec.LatencyRequirement*1000:
+		result.Message = fmt.Sprintf("average latency %dus exceeds the requirement of %dms", result.AvgLatencyUs, conn.Spec.LatencyRequirement)
+	default:
+		result.Passed = true
+		result.Message = fmt.Sprintf("throughput %d kbps, loss %d ppm, average latency %dus",
+			result.ThroughputKbps, result.PacketLossPPM, result.AvgLatencyUs)
+	}
+	return result
+}
+
+// save appends a result to the diagnostics history of a connection,
+// keeping the latest MaxDiagnostics
+func (g *Generator) save(ctx context.Context, namespace, name string, result nsmv1.DiagnosticResult) error {
+	var conn nsmv1.NetworkConnection
+	if err := g.client.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, &conn); err != nil {
+		return fmt.Errorf("failed to get connection: %w", err)
+	}
+	conn.Status.Diagnostics = append(conn.Status.Diagnostics, result)
+	if n := len(conn.Status.Diagnostics); n > MaxDiagnostics {
+		conn.Status.Diagnostics = conn.Status.Diagnostics[n-MaxDiagnostics:]
+	}
+	if err := g.client.Status().Update(ctx, &conn); err != nil {
+		return fmt.Errorf("failed to update status: %w", err)
+	}
+	return nil
+}
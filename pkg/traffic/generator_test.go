@@ -0,0 +1,164 @@
+package traffic
+
+import (
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func testLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return logger
+}
+
+func newTestClient(t *testing.T, objs ...client.Object) client.Client {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := nsmv1.AddToScheme(scheme); err != nil {
+		t.Fatalf("failed to build scheme: %v", err)
+	}
+	return fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(objs...).
+		WithStatusSubresource(&nsmv1.NetworkConnection{}).
+		Build()
+}
+
+// startReflector runs a reflector on a loopback port and returns its address
+func startReflector(t *testing.T) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go NewReflector(ctx, testLogger(), "").serve(conn)
+	return conn.LocalAddr().String()
+}
+
+func connection(bandwidth int) *nsmv1.NetworkConnection {
+	return &nsmv1.NetworkConnection{
+		ObjectMeta: metav1.ObjectMeta{Name: "plc", Namespace: "edge"},
+		Spec:       nsmv1.NetworkConnectionSpec{ConnectionType: nsmv1.ConnectionTypeKernel, Bandwidth: bandwidth},
+		Status:     nsmv1.NetworkConnectionStatus{Established: true},
+	}
+}
+
+func TestGeneratorRunSavesResult(t *testing.T) {
+	target := startReflector(t)
+	conn := connection(0)
+	for i := 0; i < MaxDiagnostics; i++ {
+		conn.Status.Diagnostics = append(conn.Status.Diagnostics, nsmv1.DiagnosticResult{Type: nsmv1.DiagnosticTrafficTest, Message: "old"})
+	}
+	c := newTestClient(t, conn)
+	g := NewGenerator(c, testLogger(), time.Minute, 100)
+	g.grace = 200 * time.Millisecond
+
+	report, err := g.Run(context.Background(), "edge", "plc", Request{RateMbps: 2, PacketSize: 500, DurationSec: 1, Target: target})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	res := report.Result
+	// 2 Mbps of 500 byte packets are 500 packets per second
+	if res.Sent < 450 || res.Sent > 510 {
+		t.Errorf("sent %d packets, want about 500", res.Sent)
+	}
+	if !res.Passed || res.Received != res.Sent || res.PacketLossPPM != 0 {
+		t.Errorf("unexpected result over loopback: %+v", res)
+	}
+	if res.ThroughputKbps < 1800 || res.ThroughputKbps > 2100 {
+		t.Errorf("throughput = %d kbps, want about 2000", res.ThroughputKbps)
+	}
+	if report.SaveError != "" {
+		t.Fatalf("result not saved: %s", report.SaveError)
+	}
+
+	var saved nsmv1.NetworkConnection
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "edge", Name: "plc"}, &saved); err != nil {
+		t.Fatal(err)
+	}
+	history := saved.Status.Diagnostics
+	if len(history) != MaxDiagnostics || history[len(history)-1].Sent != res.Sent || history[0].Message != "old" {
+		t.Errorf("history of %d results, want the %d latest ending with the test", len(history), MaxDiagnostics)
+	}
+}
+
+func TestGeneratorRunRejects(t *testing.T) {
+	pending := connection(0)
+	pending.Name = "pending"
+	pending.Status.Established = false
+	c := newTestClient(t, connection(0), pending)
+	g := NewGenerator(c, testLogger(), 30*time.Second, 100)
+
+	tests := []struct {
+		name string
+		conn string
+		req  Request
+		want string
+	}{
+		{name: "no rate", conn: "plc", req: Request{Target: "127.0.0.1:9"}, want: "rate"},
+		{name: "over max rate", conn: "plc", req: Request{RateMbps: 1000, Target: "127.0.0.1:9"}, want: "rate"},
+		{name: "tiny packets", conn: "plc", req: Request{RateMbps: 1, PacketSize: 10, Target: "127.0.0.1:9"}, want: "packet size"},
+		{name: "too long", conn: "plc", req: Request{RateMbps: 1, DurationSec: 60, Target: "127.0.0.1:9"}, want: "duration"},
+		{name: "not established", conn: "pending", req: Request{RateMbps: 1, Target: "127.0.0.1:9"}, want: "not established"},
+		{name: "no target", conn: "plc", req: Request{RateMbps: 1}, want: "no probe target"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := g.Run(context.Background(), "edge", tt.conn, tt.req)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Run() error = %v, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	echoed := func(n int) *stats {
+		return &stats{sent: 1000, received: n, rttSum: time.Duration(n) * time.Millisecond, rttMax: 2 * time.Millisecond}
+	}
+	// 1000 packets of 1250 bytes in a second are 10 Mbps
+	req := Request{RateMbps: 10, PacketSize: 1250, DurationSec: 1}
+	strict := connection(0)
+	strict.Spec.LatencyRequirement = 1
+	strict.Spec.Bandwidth = 100
+
+	tests := []struct {
+		name   string
+		conn   *nsmv1.NetworkConnection
+		stats  *stats
+		passed bool
+		want   string
+	}{
+		{name: "full rate", conn: connection(0), stats: echoed(1000), passed: true},
+		{name: "lossy", conn: connection(0), stats: echoed(800), want: "below the expected 10000 kbps"},
+		{name: "shaped", conn: connection(5), stats: echoed(500), passed: true},
+		{name: "shaping not enforced", conn: connection(5), stats: echoed(1000), want: "exceeds the bandwidth limit"},
+		{name: "no reflector", conn: connection(0), stats: &stats{sent: 1000}, want: "no traffic echoed"},
+		{name: "latency", conn: &nsmv1.NetworkConnection{Spec: nsmv1.NetworkConnectionSpec{LatencyRequirement: 1}}, stats: &stats{sent: 1000, received: 1000, rttSum: 2 * time.Second}, want: "exceeds the requirement"},
+		{name: "within latency", conn: strict, stats: echoed(1000), passed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := evaluate(tt.conn, req, tt.stats, time.Second)
+			if res.Passed != tt.passed || !strings.Contains(res.Message, tt.want) {
+				t.Errorf("evaluate() = %v %q, want %v with %q", res.Passed, res.Message, tt.passed, tt.want)
+			}
+		})
+	}
+	if res := evaluate(connection(0), req, echoed(800), time.Second); res.PacketLossPPM != 200_000 || res.ThroughputKbps != 8000 || res.AvgLatencyUs != 1000 {
+		t.Errorf("unexpected measurements: %+v", res)
+	}
+}
@@ -0,0 +1,34 @@
+package traffic
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/akos011221/nsm/pkg/api"
+)
+
+// Handler runs traffic tests against the connection in the path over the
+// management API
+func Handler(g *Generator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req Request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			api.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid traffic test request: %w", err))
+			return
+		}
+
+		report, err := g.Run(r.Context(), r.PathValue("namespace"), r.PathValue("name"), req)
+		if errors.Is(err, ErrBusy) {
+			api.WriteError(w, http.StatusConflict, err)
+			return
+		}
+		if err != nil {
+			api.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		api.WriteJSON(w, http.StatusOK, report)
+	})
+}
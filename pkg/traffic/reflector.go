@@ -0,0 +1,66 @@
+package traffic
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultReflectorPort is the UDP port the reflectors listen on by default
+const DefaultReflectorPort = 9107
+
+// Reflector echoes the test traffic back to the generators, so the far end
+// of a connection can be measured without a tool running there. Packets
+// without the test header are dropped, the reflector answers nothing else.
+type Reflector struct {
+	// Context for cancellation
+	ctx context.Context
+	// Logger
+	logger *logrus.Logger
+	// UDP address to listen on (e.g., :9107)
+	addr string
+}
+
+// NewReflector creates a reflector listening on the UDP address
+func NewReflector(ctx context.Context, logger *logrus.Logger, addr string) *Reflector {
+	return &Reflector{ctx: ctx, logger: logger, addr: addr}
+}
+
+// Start echoes the test packets until the context is done
+func (r *Reflector) Start() error {
+	conn, err := net.ListenPacket("udp", r.addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", r.addr, err)
+	}
+	return r.serve(conn)
+}
+
+// serve echoes the test packets received on conn
+func (r *Reflector) serve(conn net.PacketConn) error {
+	r.logger.Infof("Reflecting test traffic on %s", conn.LocalAddr())
+	go func() {
+		<-r.ctx.Done()
+		conn.Close()
+	}()
+
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			if r.ctx.Err() != nil {
+				r.logger.Info("Stopping test traffic reflector")
+				return nil
+			}
+			return fmt.Errorf("failed to read test traffic: %w", err)
+		}
+		if n < headerSize || !bytes.Equal(buf[:len(magic)], magic) {
+			continue
+		}
+		if _, err := conn.WriteTo(buf[:n], from); err != nil {
+			r.logger.WithError(err).Debugf("Failed to echo test traffic to %s", from)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+//go:build linux
+
+package uevent
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// Receive buffer of the socket, large enough for the events of a NIC
+// creating its VFs
+const socketBuffer = 4 << 20
+
+// socket is a netlink socket bound to the kernel uevent group
+type socket struct {
+	*os.File
+}
+
+// openSocket opens a netlink socket receiving the kernel uevents
+func openSocket() (io.ReadCloser, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC|unix.SOCK_NONBLOCK, unix.NETLINK_KOBJECT_UEVENT)
+	if err != nil {
+		return nil, err
+	}
+	// the group of the kernel, udev rebroadcasts on the second
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK, Groups: 1}); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("failed to bind: %w", err)
+	}
+	// without CAP_NET_ADMIN the default buffer is kept
+	if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_RCVBUFFORCE, socketBuffer); err != nil {
+		_ = unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_RCVBUF, socketBuffer)
+	}
+	// non-blocking, so closing the file interrupts a read
+	return &socket{os.NewFile(uintptr(fd), "uevent")}, nil
+}
+
+// Read receives a uevent message
+func (s *socket) Read(p []byte) (int, error) {
+	n, err := s.File.Read(p)
+	if errors.Is(err, unix.ENOBUFS) {
+		return 0, errOverflow
+	}
+	return n, err
+}
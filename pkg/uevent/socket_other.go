@@ -0,0 +1,13 @@
+//go:build !linux
+
+package uevent
+
+import (
+	"errors"
+	"io"
+)
+
+// openSocket fails where the kernel uevents aren't available
+func openSocket() (io.ReadCloser, error) {
+	return nil, errors.New("uevents are only available on Linux")
+}
@@ -0,0 +1,161 @@
+package uevent
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Actions of kernel uevents
+const (
+	ActionAdd    = "add"
+	ActionRemove = "remove"
+	ActionChange = "change"
+	ActionBind   = "bind"
+	ActionUnbind = "unbind"
+	// ActionMove is a renamed device, e.g., a network interface
+	ActionMove = "move"
+	// ActionOverflow is delivered when the socket buffer overflowed and
+	// events were lost, the consumers rescan what they track
+	ActionOverflow = "overflow"
+)
+
+// Event is a kernel uevent (e.g., add@/devices/pci0000:3a/0000:3a:00.0/0000:3b:00.0)
+type Event struct {
+	// Action (add, remove, change, move, bind, unbind)
+	Action string
+	// Path of the device in sysfs, without /sys
+	DevPath string
+	// Subsystem of the device (e.g., pci, net)
+	Subsystem string
+	// Environment of the event (e.g., PCI_SLOT_NAME, INTERFACE)
+	Env map[string]string
+}
+
+// Parse parses a uevent message sent by the kernel: a header followed by
+// NUL-separated KEY=VALUE pairs. The messages udev sends on its own
+// netlink group are rejected.
+func Parse(msg []byte) (Event, error) {
+	fields := bytes.Split(bytes.TrimRight(msg, "\x00"), []byte{0})
+	action, devpath, ok := strings.Cut(string(fields[0]), "@")
+	if !ok || action == "" || !strings.HasPrefix(devpath, "/") {
+		return Event{}, fmt.Errorf("invalid uevent header %q", fields[0])
+	}
+
+	e := Event{Env: make(map[string]string, len(fields)-1)}
+	for _, field := range fields[1:] {
+		if key, value, ok := strings.Cut(string(field), "="); ok {
+			e.Env[key] = value
+		}
+	}
+	e.Action = e.Env["ACTION"]
+	if e.Action == "" {
+		e.Action = action
+	}
+	e.DevPath = e.Env["DEVPATH"]
+	if e.DevPath == "" {
+		e.DevPath = devpath
+	}
+	e.Subsystem = e.Env["SUBSYSTEM"]
+	return e, nil
+}
+
+// errOverflow is returned by a source whose buffer overflowed
+var errOverflow = errors.New("uevent buffer overflow")
+
+// Listener receives the kernel uevents of the node and hands them to its
+// subscribers, filtered by subsystem
+type Listener struct {
+	// Context for cancellation
+	ctx context.Context
+	// Logger
+	logger *logrus.Logger
+	// Opens the uevent socket, receiving a message per read, replaceable
+	// for tests
+	open func() (io.ReadCloser, error)
+	// Subscribers by subsystem, "" for every subsystem
+	subscribers map[string][]func(Event)
+	// Mutex for protecting the subscribers
+	mu sync.RWMutex
+}
+
+// NewListener creates a new uevent listener
+func NewListener(ctx context.Context, logger *logrus.Logger) *Listener {
+	return &Listener{
+		ctx:         ctx,
+		logger:      logger,
+		open:        openSocket,
+		subscribers: make(map[string][]func(Event)),
+	}
+}
+
+// Subscribe registers a function called with the events of a subsystem,
+// every subsystem if empty, and with the overflows
+func (l *Listener) Subscribe(subsystem string, fn func(Event)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.subscribers[subsystem] = append(l.subscribers[subsystem], fn)
+}
+
+// Start receives the uevents until the context is done
+func (l *Listener) Start() error {
+	src, err := l.open()
+	if err != nil {
+		return fmt.Errorf("failed to open the uevent socket: %w", err)
+	}
+	l.logger.Info("Starting uevent listener")
+	go func() {
+		<-l.ctx.Done()
+		src.Close()
+	}()
+
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := src.Read(buf)
+		switch {
+		case l.ctx.Err() != nil:
+			l.logger.Info("Stopping uevent listener")
+			return nil
+		case errors.Is(err, errOverflow):
+			l.logger.Warn("Lost uevents, the socket buffer overflowed")
+			l.dispatch(Event{Action: ActionOverflow})
+			continue
+		case err != nil:
+			return fmt.Errorf("failed to receive uevents: %w", err)
+		}
+
+		e, err := Parse(buf[:n])
+		if err != nil {
+			l.logger.WithError(err).Debug("Ignoring uevent")
+			continue
+		}
+		l.dispatch(e)
+	}
+}
+
+// dispatch hands an event to its subscribers, overflows to all of them
+func (l *Listener) dispatch(e Event) {
+	l.mu.RLock()
+	var fns []func(Event)
+	if e.Action == ActionOverflow {
+		for _, subs := range l.subscribers {
+			fns = append(fns, subs...)
+		}
+	} else {
+		fns = append(fns, l.subscribers[""]...)
+		if e.Subsystem != "" {
+			fns = append(fns, l.subscribers[e.Subsystem]...)
+		}
+	}
+	l.mu.RUnlock()
+
+	for _, fn := range fns {
+		fn(e)
+	}
+}
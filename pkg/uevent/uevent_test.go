@@ -0,0 +1,107 @@
+package uevent
+
+import (
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestParse(t *testing.T) {
+	msg := []byte("remove@/devices/pci0000:3a/0000:3a:00.0/0000:3b:02.0\x00ACTION=remove\x00" +
+		"DEVPATH=/devices/pci0000:3a/0000:3a:00.0/0000:3b:02.0\x00SUBSYSTEM=pci\x00PCI_SLOT_NAME=0000:3b:02.0\x00SEQNUM=4242\x00")
+	e, err := Parse(msg)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if e.Action != ActionRemove || e.Subsystem != "pci" || e.DevPath != "/devices/pci0000:3a/0000:3a:00.0/0000:3b:02.0" || e.Env["PCI_SLOT_NAME"] != "0000:3b:02.0" {
+		t.Errorf("Parse() = %+v", e)
+	}
+
+	// udev rebroadcasts the events with a binary header
+	if _, err := Parse([]byte("libudev\x00\xfe\xed\xca\xfe")); err == nil {
+		t.Error("expected an error for a udev message")
+	}
+}
+
+// fakeSource returns the queued messages, then blocks until closed
+type fakeSource struct {
+	msgs   [][]byte
+	errs   []error
+	closed chan struct{}
+}
+
+func (s *fakeSource) Read(p []byte) (int, error) {
+	if len(s.msgs) == 0 {
+		<-s.closed
+		return 0, io.EOF
+	}
+	msg, err := s.msgs[0], s.errs[0]
+	s.msgs, s.errs = s.msgs[1:], s.errs[1:]
+	return copy(p, msg), err
+}
+
+func (s *fakeSource) Close() error {
+	close(s.closed)
+	return nil
+}
+
+func TestListenerDispatchesBySubsystem(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	src := &fakeSource{closed: make(chan struct{})}
+	for _, msg := range []string{
+		"add@/devices/pci0000:3a/0000:3a:00.0/0000:3b:02.0\x00ACTION=add\x00SUBSYSTEM=pci\x00PCI_SLOT_NAME=0000:3b:02.0",
+		"add@/devices/virtual/net/veth1\x00ACTION=add\x00SUBSYSTEM=net\x00INTERFACE=veth1",
+		"garbage",
+	} {
+		src.msgs = append(src.msgs, []byte(msg))
+		src.errs = append(src.errs, nil)
+	}
+	src.msgs = append(src.msgs, nil)
+	src.errs = append(src.errs, errOverflow)
+
+	l := NewListener(ctx, logger)
+	l.open = func() (io.ReadCloser, error) { return src, nil }
+	var pci, all []string
+	done := make(chan struct{})
+	l.Subscribe("pci", func(e Event) {
+		pci = append(pci, e.Action)
+		if e.Action == ActionOverflow {
+			close(done)
+		}
+	})
+	l.Subscribe("", func(e Event) { all = append(all, e.Subsystem) })
+
+	errc := make(chan error, 1)
+	go func() { errc <- l.Start() }()
+	<-done
+	cancel()
+	if err := <-errc; err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	if !reflect.DeepEqual(pci, []string{ActionAdd, ActionOverflow}) {
+		t.Errorf("pci events = %v, want the add and the overflow", pci)
+	}
+	if !reflect.DeepEqual(all, []string{"pci", "net", ""}) {
+		t.Errorf("all events = %v, want pci, net and the overflow", all)
+	}
+}
+
+func TestListenerFailsOnSocketError(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	src := &fakeSource{msgs: [][]byte{nil}, errs: []error{errors.New("socket closed")}, closed: make(chan struct{})}
+	l := NewListener(context.Background(), logger)
+	l.open = func() (io.ReadCloser, error) { return src, nil }
+	if err := l.Start(); err == nil {
+		t.Error("expected an error when the socket fails")
+	}
+}
@@ -0,0 +1,39 @@
+package usage
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/akos011221/nsm/pkg/api"
+)
+
+// Handler serves the closed usage reports over the management API, as JSON
+// or, with format=csv, as CSV for spreadsheets and billing imports. The
+// since parameter (RFC 3339) limits them to the reports ending after it.
+func Handler(meter *Meter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		var since time.Time
+		if val := query.Get("since"); val != "" {
+			t, err := time.Parse(time.RFC3339, val)
+			if err != nil {
+				api.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid since %q, must be RFC 3339: %w", val, err))
+				return
+			}
+			since = t
+		}
+		reports := meter.Reports(since)
+
+		switch query.Get("format") {
+		case "", "json":
+			api.WriteJSON(w, http.StatusOK, reports)
+		case "csv":
+			w.Header().Set("Content-Type", "text/csv")
+			w.Header().Set("Content-Disposition", `attachment; filename="usage.csv"`)
+			WriteCSV(w, reports)
+		default:
+			api.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid format %q, must be json or csv", query.Get("format")))
+		}
+	})
+}
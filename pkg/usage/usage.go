@@ -0,0 +1,303 @@
+package usage
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/hardware"
+	"github.com/akos011221/nsm/pkg/intent"
+	"github.com/akos011221/nsm/pkg/watchdog"
+	"github.com/sirupsen/logrus"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Interval the usage is sampled at
+const sampleInterval = time.Minute
+
+// Number of closed reports kept for the API, a week of hourly reports
+const maxReports = 168
+
+// Gaps between samples longer than this (e.g., a controller restart) are
+// not charged, the state during the gap is unknown
+const maxSampleGap = 5 * sampleInterval
+
+// Priority classes of the connections, by their priority
+const (
+	PriorityHigh   = "high"
+	PriorityMedium = "medium"
+	PriorityLow    = "low"
+)
+
+// VFLister lists the VFs of the node
+type VFLister interface {
+	VirtualFunctions() []hardware.VirtualFunction
+}
+
+// Usage is the edge resources a namespace consumed in a report period
+type Usage struct {
+	// Namespace (tenant) charged
+	Namespace string `json:"namespace"`
+	// Hours of VFs allocated to the pods of the namespace
+	VFHours float64 `json:"vfHours"`
+	// Gigabytes carried by the connections of the namespace, estimated
+	// from their observed throughput
+	GBTransferred float64 `json:"gbTransferred"`
+	// Minutes of established high priority connections
+	HighPriorityMinutes float64 `json:"highPriorityMinutes"`
+	// Minutes of established medium priority connections
+	MediumPriorityMinutes float64 `json:"mediumPriorityMinutes"`
+	// Minutes of established low priority connections
+	LowPriorityMinutes float64 `json:"lowPriorityMinutes"`
+}
+
+// Report is the usage of the namespaces in a period
+type Report struct {
+	// Edge node identifier
+	NodeID string `json:"nodeId"`
+	// Start of the period
+	Start time.Time `json:"start"`
+	// End of the period
+	End time.Time `json:"end"`
+	// Usage per namespace, ordered by namespace
+	Namespaces []Usage `json:"namespaces"`
+}
+
+// Meter samples the VFs and connections of the node and closes a usage
+// report per period, for the internal chargeback of the edge resources.
+// The closed reports are kept for the API and, when pushed to the cloud,
+// handed to the reporter until a heartbeat carrying them is acknowledged.
+type Meter struct {
+	// Context for cancellation
+	ctx context.Context
+	// Kubernetes client (controller-runtime)
+	client client.Client
+	// Logger
+	logger *logrus.Logger
+	// Edge node identifier
+	nodeID string
+	// Length of a report period
+	period time.Duration
+	// Lists the VFs of the node, nil without SR-IOV
+	vfs VFLister
+	// Progress signal for the watchdog
+	heartbeat *watchdog.Heartbeat
+
+	// Mutex for protecting the usage and reports
+	mu sync.Mutex
+	// Start of the current period
+	start time.Time
+	// Time of the last sample, zero before the first
+	lastSample time.Time
+	// Usage of the current period, by namespace
+	current map[string]*Usage
+	// Closed reports, oldest first
+	reports []Report
+	// End of the last report acknowledged by the cloud
+	acked time.Time
+}
+
+// NewMeter creates a new usage meter closing a report every period
+func NewMeter(ctx context.Context, c client.Client, logger *logrus.Logger, nodeID string, period time.Duration) *Meter {
+	return &Meter{
+		ctx:     ctx,
+		client:  c,
+		logger:  logger,
+		nodeID:  nodeID,
+		period:  period,
+		current: make(map[string]*Usage),
+	}
+}
+
+// SetVFLister makes the meter charge the VFs allocated to the namespaces
+func (m *Meter) SetVFLister(vfs VFLister) {
+	m.vfs = vfs
+}
+
+// SetHeartbeat makes the meter report its progress to the watchdog
+func (m *Meter) SetHeartbeat(hb *watchdog.Heartbeat) {
+	m.heartbeat = hb
+	hb.Expect(sampleInterval)
+}
+
+// Start samples the usage and closes the reports
+func (m *Meter) Start() error {
+	m.logger.Infof("Starting usage meter (report every %s)", m.period)
+
+	ticker := time.NewTicker(sampleInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			m.heartbeat.Beat()
+			if err := m.Sample(now); err != nil {
+				m.logger.WithError(err).Warn("Failed to sample usage")
+			}
+
+		case <-m.ctx.Done():
+			m.logger.Info("Stopping usage meter")
+			return nil
+		}
+	}
+}
+
+// Sample charges the namespaces for the VFs and connections they hold
+// since the last sample, and closes the period once it ended
+func (m *Meter) Sample(now time.Time) error {
+	var conns nsmv1.NetworkConnectionList
+	if err := m.client.List(m.ctx, &conns); err != nil {
+		return fmt.Errorf("failed to list connections: %w", err)
+	}
+	var vfs []hardware.VirtualFunction
+	if m.vfs != nil {
+		vfs = m.vfs.VirtualFunctions()
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.start.IsZero() {
+		m.start = now
+	}
+	elapsed := now.Sub(m.lastSample)
+	if !m.lastSample.IsZero() && elapsed > 0 && elapsed <= maxSampleGap {
+		for _, vf := range vfs {
+			if vf.Allocated {
+				m.usage(vf.Namespace).VFHours += elapsed.Hours()
+			}
+		}
+		for i := range conns.Items {
+			conn := &conns.Items[i]
+			if !conn.Status.Established {
+				continue
+			}
+			u := m.usage(conn.Namespace)
+			// Mbps over the elapsed seconds, in decimal gigabytes
+			u.GBTransferred += float64(conn.Status.Metrics.ThroughputMbps) * elapsed.Seconds() / 8 / 1000
+			switch PriorityClass(conn.Spec.Priority) {
+			case PriorityHigh:
+				u.HighPriorityMinutes += elapsed.Minutes()
+			case PriorityMedium:
+				u.MediumPriorityMinutes += elapsed.Minutes()
+			default:
+				u.LowPriorityMinutes += elapsed.Minutes()
+			}
+		}
+	}
+	m.lastSample = now
+
+	if now.Sub(m.start) >= m.period {
+		m.close(now)
+	}
+	return nil
+}
+
+// usage returns the usage of a namespace in the current period. The mutex
+// must be held.
+func (m *Meter) usage(namespace string) *Usage {
+	u, ok := m.current[namespace]
+	if !ok {
+		u = &Usage{Namespace: namespace}
+		m.current[namespace] = u
+	}
+	return u
+}
+
+// close closes the current period into a report. The mutex must be held.
+func (m *Meter) close(now time.Time) {
+	report := Report{NodeID: m.nodeID, Start: m.start, End: now, Namespaces: []Usage{}}
+	for _, u := range m.current {
+		report.Namespaces = append(report.Namespaces, *u)
+	}
+	sort.Slice(report.Namespaces, func(i, j int) bool {
+		return report.Namespaces[i].Namespace < report.Namespaces[j].Namespace
+	})
+
+	m.reports = append(m.reports, report)
+	if len(m.reports) > maxReports {
+		m.reports = m.reports[len(m.reports)-maxReports:]
+	}
+	m.logger.Debugf("Closed usage report of %d namespaces for %s to %s", len(report.Namespaces), report.Start.Format(time.RFC3339), now.Format(time.RFC3339))
+	m.start = now
+	m.current = make(map[string]*Usage)
+}
+
+// Reports returns the closed reports ending after since, oldest first
+func (m *Meter) Reports(since time.Time) []Report {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	reports := []Report{}
+	for _, report := range m.reports {
+		if report.End.After(since) {
+			reports = append(reports, report)
+		}
+	}
+	return reports
+}
+
+// Unacknowledged returns the closed reports not yet acknowledged by the cloud
+func (m *Meter) Unacknowledged() []Report {
+	m.mu.Lock()
+	acked := m.acked
+	m.mu.Unlock()
+	return m.Reports(acked)
+}
+
+// Acknowledge marks the reports ending until end as received by the cloud
+func (m *Meter) Acknowledge(end time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if end.After(m.acked) {
+		m.acked = end
+	}
+}
+
+// PriorityClass returns the priority class of a connection priority, the
+// inverse of the priorities the intents compile the classes to
+func PriorityClass(priority int32) string {
+	switch {
+	case priority >= intent.PriorityValue(PriorityHigh):
+		return PriorityHigh
+	case priority > intent.PriorityValue(PriorityLow):
+		return PriorityMedium
+	default:
+		return PriorityLow
+	}
+}
+
+// WriteCSV writes the reports as CSV, a row per namespace and report
+func WriteCSV(w io.Writer, reports []Report) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"node", "start", "end", "namespace", "vf_hours", "gb_transferred",
+		"high_priority_minutes", "medium_priority_minutes", "low_priority_minutes"})
+	for _, report := range reports {
+		for _, u := range report.Namespaces {
+			cw.Write([]string{
+				report.NodeID,
+				report.Start.UTC().Format(time.RFC3339),
+				report.End.UTC().Format(time.RFC3339),
+				u.Namespace,
+				formatFloat(u.VFHours),
+				formatFloat(u.GBTransferred),
+				formatFloat(u.HighPriorityMinutes),
+				formatFloat(u.MediumPriorityMinutes),
+				formatFloat(u.LowPriorityMinutes),
+			})
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// formatFloat formats a usage quantity with a fixed precision
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', 3, 64)
+}
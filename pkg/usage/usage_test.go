@@ -0,0 +1,172 @@
+package usage
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/hardware"
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var epoch = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+type fakeVFs []hardware.VirtualFunction
+
+func (f fakeVFs) VirtualFunctions() []hardware.VirtualFunction {
+	return f
+}
+
+func newTestMeter(t *testing.T, period time.Duration, objs ...client.Object) *Meter {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := nsmv1.AddToScheme(scheme); err != nil {
+		t.Fatalf("failed to build scheme: %v", err)
+	}
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build()
+	return NewMeter(context.Background(), c, logger, "edge-1", period)
+}
+
+func established(namespace, name string, priority int32, mbps int) *nsmv1.NetworkConnection {
+	return &nsmv1.NetworkConnection{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Spec:       nsmv1.NetworkConnectionSpec{Priority: priority},
+		Status: nsmv1.NetworkConnectionStatus{
+			State:       nsmv1.ConnectionStateEstablished,
+			Established: true,
+			Metrics:     nsmv1.ConnectionMetrics{ThroughputMbps: mbps},
+		},
+	}
+}
+
+func near(got, want float64) bool {
+	return math.Abs(got-want) < 1e-9
+}
+
+func TestMeterChargesNamespaces(t *testing.T) {
+	pending := &nsmv1.NetworkConnection{ObjectMeta: metav1.ObjectMeta{Name: "backup", Namespace: "ops"}}
+	m := newTestMeter(t, time.Hour,
+		established("edge", "video", 100, 800),
+		established("edge", "telemetry", 10, 8),
+		established("ops", "monitoring", 50, 0),
+		pending)
+	m.SetVFLister(fakeVFs{
+		{PFName: "eth0", VFID: 0, Allocated: true, AllocatedTo: "camera", Namespace: "edge"},
+		{PFName: "eth0", VFID: 1},
+	})
+
+	// an hour of samples closes the first report
+	for i := 0; i <= 60; i++ {
+		if err := m.Sample(epoch.Add(time.Duration(i) * time.Minute)); err != nil {
+			t.Fatalf("Sample() error = %v", err)
+		}
+	}
+	reports := m.Reports(time.Time{})
+	if len(reports) != 1 {
+		t.Fatalf("%d reports closed, want 1", len(reports))
+	}
+	report := reports[0]
+	if report.NodeID != "edge-1" || !report.Start.Equal(epoch) || !report.End.Equal(epoch.Add(time.Hour)) || len(report.Namespaces) != 2 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+
+	edge, ops := report.Namespaces[0], report.Namespaces[1]
+	// 808 Mbps for an hour is 363.6 GB
+	if edge.Namespace != "edge" || !near(edge.VFHours, 1) || !near(edge.GBTransferred, 363.6) ||
+		!near(edge.HighPriorityMinutes, 60) || !near(edge.LowPriorityMinutes, 60) || edge.MediumPriorityMinutes != 0 {
+		t.Errorf("unexpected usage of edge %+v", edge)
+	}
+	// the pending connection isn't charged
+	if ops.Namespace != "ops" || !near(ops.MediumPriorityMinutes, 60) || ops.LowPriorityMinutes != 0 || ops.VFHours != 0 {
+		t.Errorf("unexpected usage of ops %+v", ops)
+	}
+}
+
+func TestMeterSkipsGaps(t *testing.T) {
+	m := newTestMeter(t, time.Hour, established("edge", "video", 100, 0))
+
+	for _, at := range []time.Duration{0, time.Minute, time.Hour, time.Hour + time.Minute} {
+		if err := m.Sample(epoch.Add(at)); err != nil {
+			t.Fatalf("Sample() error = %v", err)
+		}
+	}
+	// the controller was down between the second and third samples
+	report := m.Reports(time.Time{})[0]
+	if len(report.Namespaces) != 1 || !near(report.Namespaces[0].HighPriorityMinutes, 1) {
+		t.Errorf("unexpected report %+v, want only the sampled minute charged", report)
+	}
+}
+
+func TestMeterAcknowledge(t *testing.T) {
+	m := newTestMeter(t, time.Minute)
+	for i := 0; i <= 3; i++ {
+		if err := m.Sample(epoch.Add(time.Duration(i) * time.Minute)); err != nil {
+			t.Fatalf("Sample() error = %v", err)
+		}
+	}
+	if got := len(m.Unacknowledged()); got != 3 {
+		t.Fatalf("%d unacknowledged reports, want 3", got)
+	}
+	m.Acknowledge(epoch.Add(2 * time.Minute))
+	if got := m.Unacknowledged(); len(got) != 1 || !got[0].End.Equal(epoch.Add(3*time.Minute)) {
+		t.Errorf("Unacknowledged() = %+v, want the last report", got)
+	}
+}
+
+func TestPriorityClass(t *testing.T) {
+	for priority, want := range map[int32]string{0: PriorityLow, 10: PriorityLow, 11: PriorityMedium, 50: PriorityMedium, 100: PriorityHigh, 1000: PriorityHigh} {
+		if got := PriorityClass(priority); got != want {
+			t.Errorf("PriorityClass(%d) = %s, want %s", priority, got, want)
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	m := newTestMeter(t, time.Minute, established("edge", "video", 100, 80))
+	for i := 0; i <= 2; i++ {
+		if err := m.Sample(epoch.Add(time.Duration(i) * time.Minute)); err != nil {
+			t.Fatalf("Sample() error = %v", err)
+		}
+	}
+	h := Handler(m)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/usage?since="+epoch.Add(time.Minute).Format(time.RFC3339), nil))
+	var reports []Report
+	if err := json.NewDecoder(rec.Body).Decode(&reports); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("status %d, decode error %v", rec.Code, err)
+	}
+	if len(reports) != 1 || !reports[0].End.Equal(epoch.Add(2*time.Minute)) {
+		t.Errorf("reports = %+v, want the report after since", reports)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/usage?format=csv", nil))
+	want := "node,start,end,namespace,vf_hours,gb_transferred,high_priority_minutes,medium_priority_minutes,low_priority_minutes\n" +
+		"edge-1,2024-05-01T12:00:00Z,2024-05-01T12:01:00Z,edge,0.000,0.600,1.000,0.000,0.000\n" +
+		"edge-1,2024-05-01T12:01:00Z,2024-05-01T12:02:00Z,edge,0.000,0.600,1.000,0.000,0.000\n"
+	if got := rec.Body.String(); got != want || rec.Header().Get("Content-Type") != "text/csv" {
+		t.Errorf("CSV = %q, want %q", got, want)
+	}
+
+	for _, query := range []string{"format=xml", "since=yesterday"} {
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/usage?"+query, nil))
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "invalid") {
+			t.Errorf("%s: status %d, want 400", query, rec.Code)
+		}
+	}
+}
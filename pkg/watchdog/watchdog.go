@@ -0,0 +1,200 @@
+package watchdog
+
+import (
+	"bytes"
+	"context"
+	"runtime/pprof"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// stallsTotal counts the stalls detected per component
+var stallsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "nsm_watchdog_stalls_total",
+	Help: "Number of times a component made no progress for too long",
+}, []string{"component"})
+
+func init() {
+	crmetrics.Registry.MustRegister(stallsTotal)
+}
+
+// Heartbeat is the progress signal of a component loop. A nil Heartbeat
+// is valid and ignores beats, so components run without a watchdog too.
+type Heartbeat struct {
+	// Name of the component
+	name string
+	// Expected interval between beats
+	interval time.Duration
+	// Time of the last beat
+	last time.Time
+	// Whether a stall was reported and no beat arrived since
+	stalled bool
+	// Restarts the component, nil if it can't be restarted
+	restart func()
+	// Mutex for protecting the heartbeat
+	mu sync.Mutex
+}
+
+// Beat records progress of the component
+func (h *Heartbeat) Beat() {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.last = time.Now()
+	h.stalled = false
+}
+
+// Expect sets the interval the component beats at
+func (h *Heartbeat) Expect(interval time.Duration) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.interval = interval
+}
+
+// Watchdog detects component loops that stopped making progress, which on
+// unattended edge nodes would otherwise go unnoticed until something breaks
+type Watchdog struct {
+	// Context for cancellation
+	ctx context.Context
+	// Logger
+	logger *logrus.Logger
+	// Number of missed intervals after which a component is stalled
+	misses int
+	// Whether stalled components are restarted
+	restart bool
+	// Interval between checks
+	checkInterval time.Duration
+	// Registered heartbeats by component name
+	heartbeats map[string]*Heartbeat
+	// Mutex for protecting the heartbeats
+	mu sync.Mutex
+}
+
+// NewWatchdog creates a new watchdog
+func NewWatchdog(ctx context.Context, logger *logrus.Logger, misses int, restart bool) *Watchdog {
+	return &Watchdog{
+		ctx:           ctx,
+		logger:        logger,
+		misses:        misses,
+		restart:       restart,
+		checkInterval: 5 * time.Second,
+		heartbeats:    make(map[string]*Heartbeat),
+	}
+}
+
+// Register adds a component to watch. The restart function is called when
+// the component stalls and restarts are enabled, it may be nil.
+func (w *Watchdog) Register(name string, restart func()) *Heartbeat {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	hb := &Heartbeat{name: name, last: time.Now(), restart: restart}
+	w.heartbeats[name] = hb
+	return hb
+}
+
+// Start checks the heartbeats until the context is done
+func (w *Watchdog) Start() error {
+	w.logger.Infof("Starting watchdog (stall after %d missed intervals)", w.misses)
+
+	ticker := time.NewTicker(w.checkInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			w.Check(time.Now())
+
+		case <-w.ctx.Done():
+			w.logger.Info("Stopping watchdog")
+			return nil
+		}
+	}
+}
+
+// Check reports the components that stalled since the last check and
+// returns their names. A stall is reported once until the component beats again.
+func (w *Watchdog) Check(now time.Time) []string {
+	w.mu.Lock()
+	var stalled []*Heartbeat
+	for _, hb := range w.heartbeats {
+		hb.mu.Lock()
+		if hb.interval > 0 && !hb.stalled && now.Sub(hb.last) > time.Duration(w.misses)*hb.interval {
+			hb.stalled = true
+			stalled = append(stalled, hb)
+		}
+		hb.mu.Unlock()
+	}
+	w.mu.Unlock()
+
+	if len(stalled) == 0 {
+		return nil
+	}
+
+	// one dump covers all stalled components
+	dump := goroutineDump()
+	names := make([]string, 0, len(stalled))
+	for _, hb := range stalled {
+		names = append(names, hb.name)
+		stallsTotal.WithLabelValues(hb.name).Inc()
+		w.logger.Errorf("Watchdog: %s made no progress for %d intervals, goroutines:\n%s", hb.name, w.misses, dump)
+
+		if w.restart && hb.restart != nil {
+			w.logger.Warnf("Watchdog: restarting %s", hb.name)
+			hb.restart()
+		}
+	}
+	return names
+}
+
+// HeartbeatStatus is the progress of a component
+type HeartbeatStatus struct {
+	// Name of the component
+	Name string
+	// Time of the last beat, the start of the component before any
+	LastBeat time.Time
+	// Expected interval between beats, 0 for components beating on events
+	Interval time.Duration
+	// Whether the component made no progress for the missed intervals
+	Stalled bool
+}
+
+// Heartbeats returns the progress of the components, ordered by name.
+// Components are stalled as Check finds them, whether it ran yet or not.
+func (w *Watchdog) Heartbeats(now time.Time) []HeartbeatStatus {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	statuses := make([]HeartbeatStatus, 0, len(w.heartbeats))
+	for _, hb := range w.heartbeats {
+		hb.mu.Lock()
+		statuses = append(statuses, HeartbeatStatus{
+			Name:     hb.name,
+			LastBeat: hb.last,
+			Interval: hb.interval,
+			Stalled:  hb.interval > 0 && now.Sub(hb.last) > time.Duration(w.misses)*hb.interval,
+		})
+		hb.mu.Unlock()
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
+
+// goroutineDump returns the stacks of all goroutines
+func goroutineDump() string {
+	var buf bytes.Buffer
+	pprof.Lookup("goroutine").WriteTo(&buf, 2)
+	return buf.String()
+}
@@ -0,0 +1,96 @@
+package watchdog
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+)
+
+func TestCheckDetectsStall(t *testing.T) {
+	w := NewWatchdog(context.Background(), quietLogger(), 3, true)
+
+	restarts := 0
+	stuck := w.Register("stuck-loop", func() { restarts++ })
+	stuck.Expect(time.Second)
+	healthy := w.Register("healthy-loop", nil)
+	healthy.Expect(2 * time.Second)
+	// components without an expected interval are never reported
+	w.Register("idle", nil)
+
+	before := testutil.ToFloat64(stallsTotal.WithLabelValues("stuck-loop"))
+
+	now := time.Now()
+	if got := w.Check(now.Add(2 * time.Second)); len(got) != 0 {
+		t.Fatalf("stall reported too early: %v", got)
+	}
+
+	healthy.Beat()
+	got := w.Check(now.Add(3500 * time.Millisecond))
+	if len(got) != 1 || got[0] != "stuck-loop" {
+		t.Fatalf("stalled = %v, want [stuck-loop]", got)
+	}
+	if restarts != 1 {
+		t.Errorf("restarts = %d, want 1", restarts)
+	}
+	if diff := testutil.ToFloat64(stallsTotal.WithLabelValues("stuck-loop")) - before; diff != 1 {
+		t.Errorf("stall metric increased by %v, want 1", diff)
+	}
+
+	// reported once until the component makes progress again
+	if got := w.Check(time.Now().Add(10 * time.Second)); len(got) != 1 || got[0] != "healthy-loop" {
+		t.Errorf("stalled = %v, want only the newly stalled healthy-loop", got)
+	}
+	stuck.Beat()
+	if got := w.Check(time.Now().Add(10 * time.Second)); len(got) != 1 || got[0] != "stuck-loop" {
+		t.Errorf("stall not reported again after recovery: %v", got)
+	}
+}
+
+func TestNoRestartWhenDisabled(t *testing.T) {
+	w := NewWatchdog(context.Background(), quietLogger(), 1, false)
+	restarts := 0
+	w.Register("loop", func() { restarts++ }).Expect(time.Second)
+
+	if got := w.Check(time.Now().Add(5 * time.Second)); len(got) != 1 {
+		t.Fatalf("stall not detected")
+	}
+	if restarts != 0 {
+		t.Errorf("component restarted with restarts disabled")
+	}
+}
+
+func TestHeartbeats(t *testing.T) {
+	w := NewWatchdog(context.Background(), quietLogger(), 3, false)
+	w.Register("sriov", nil).Expect(time.Second)
+	w.Register("reconciler", nil)
+
+	now := time.Now()
+	got := w.Heartbeats(now.Add(5 * time.Second))
+	if len(got) != 2 || got[0].Name != "reconciler" || got[1].Name != "sriov" {
+		t.Fatalf("Heartbeats() = %+v, want reconciler and sriov", got)
+	}
+	// components beating on events never stall
+	if got[0].Stalled || !got[1].Stalled || got[1].Interval != time.Second {
+		t.Errorf("Heartbeats() = %+v, want only sriov stalled", got)
+	}
+	// reading the heartbeats doesn't keep Check from reporting the stall
+	if stalled := w.Check(now.Add(5 * time.Second)); len(stalled) != 1 {
+		t.Errorf("Check() = %v, want the stall reported", stalled)
+	}
+}
+
+func TestNilHeartbeat(t *testing.T) {
+	var hb *Heartbeat
+	hb.Expect(time.Second)
+	hb.Beat()
+}
+
+func quietLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return logger
+}
@@ -0,0 +1,31 @@
+package whatif
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/akos011221/nsm/pkg/api"
+	"github.com/sirupsen/logrus"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Handler serves what-if previews of proposed specs over the management API
+func Handler(c client.Client, logger *logrus.Logger, planner Planner, vfs VFInventory) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req Request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			api.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid what-if request: %w", err))
+			return
+		}
+
+		result, err := Preview(r.Context(), c, planner, vfs, req)
+		if err != nil {
+			api.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		logger.Debugf("What-if for %s/%s: %d connections planned", req.Namespace, req.Name, len(result.Connections))
+		api.WriteJSON(w, http.StatusOK, result)
+	})
+}
@@ -0,0 +1,251 @@
+package whatif
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/datapath"
+	"github.com/akos011221/nsm/pkg/hardware"
+	"github.com/akos011221/nsm/pkg/intent"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Request proposes a NetworkConnection or NetworkService spec to preview
+type Request struct {
+	// Namespace of the proposed object
+	Namespace string `json:"namespace"`
+	// Name of the proposed object
+	Name string `json:"name"`
+	// Proposed NetworkConnection spec
+	Connection *nsmv1.NetworkConnectionSpec `json:"connection,omitempty"`
+	// Proposed NetworkService spec, previews the connections of the intents using the service
+	Service *nsmv1.NetworkServiceSpec `json:"service,omitempty"`
+}
+
+// Action is a planned change of a host networking object
+type Action struct {
+	// Operation (create, update, delete)
+	Op string `json:"op"`
+	// Kind of the object (link, address, route, qdisc, nft-rule)
+	Kind string `json:"kind"`
+	// Object on the host (e.g., link/nsmfb1a2b3c4d)
+	Object string `json:"object"`
+}
+
+// VF is the Virtual Function a connection would use
+type VF struct {
+	// PF name (e.g., eth0)
+	PFName string `json:"pf"`
+	// VF ID on the PF
+	VFID int `json:"vf"`
+	// PCI address of the VF
+	PCIAddress string `json:"pci"`
+	// Whether the source pod already holds the VF, otherwise it is the next free one
+	Held bool `json:"held"`
+}
+
+// QoS is the service level of a connection
+type QoS struct {
+	// Priority of the connection, higher values are served first
+	Priority int32 `json:"priority"`
+	// Bandwidth limit in Mbps, 0 for unlimited
+	BandwidthMbps int `json:"bandwidthMbps,omitempty"`
+	// Maximum allowed latency in milliseconds, 0 for no requirement
+	LatencyMs int `json:"latencyMs,omitempty"`
+}
+
+// ConnectionPlan previews how a connection would be reconciled
+type ConnectionPlan struct {
+	// Namespace of the connection
+	Namespace string `json:"namespace"`
+	// Name of the connection
+	Name string `json:"name"`
+	// State the connection would reach (e.g., Established, Pending, Degraded)
+	State string `json:"state"`
+	// Machine-readable reason of the state (e.g., SRIOVDisabled, NodePressure)
+	Reason string `json:"reason"`
+	// Human-readable explanation
+	Message string `json:"message,omitempty"`
+	// Datapath serving the connection
+	Datapath string `json:"datapath,omitempty"`
+	// Whether the datapath is a non-accelerated fallback
+	Fallback bool `json:"fallback,omitempty"`
+	// Dataplane backend forwarding the connection (kernel, ovs, vpp)
+	Dataplane string `json:"dataplane,omitempty"`
+	// Encryption mode (offload, software), empty if unencrypted
+	Encryption string `json:"encryption,omitempty"`
+	// Secret the tunnel key would be provisioned in
+	KeySecret string `json:"keySecret,omitempty"`
+	// VF of SR-IOV connections
+	VF *VF `json:"vf,omitempty"`
+	// Service level of the connection
+	QoS QoS `json:"qos"`
+	// Changes of the host networking
+	Actions []Action `json:"actions"`
+	// Problems the setup would run into
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// Result lists the plans of the previewed connections
+type Result struct {
+	// Plans of the connections, in the order they would be reconciled
+	Connections []ConnectionPlan `json:"connections"`
+}
+
+// Planner plans the reconcile of a connection without applying it
+type Planner interface {
+	Plan(ctx context.Context, conn *nsmv1.NetworkConnection) (*ConnectionPlan, error)
+}
+
+// VFInventory tells which VFs the pods hold and which are free
+type VFInventory interface {
+	GetVFForPod(namespace, podName string) (hardware.VirtualFunction, bool)
+	VirtualFunctions() []hardware.VirtualFunction
+}
+
+// Actions converts the plan of a datapath into actions
+func Actions(plan *datapath.Plan) []Action {
+	actions := []Action{}
+	for _, change := range []struct {
+		op      string
+		objects []datapath.Object
+	}{{"create", plan.Create}, {"update", plan.Update}, {"delete", plan.Delete}} {
+		for _, obj := range change.objects {
+			actions = append(actions, Action{Op: change.op, Kind: obj.Kind().String(), Object: obj.Key()})
+		}
+	}
+	return actions
+}
+
+// Preview plans the proposed connection, or the connections the intents
+// using the proposed service would compile into, without applying
+// anything. vfs may be nil without SR-IOV.
+func Preview(ctx context.Context, c client.Client, planner Planner, vfs VFInventory, req Request) (*Result, error) {
+	if req.Namespace == "" || req.Name == "" {
+		return nil, fmt.Errorf("namespace and name of the proposed object are required")
+	}
+	if (req.Connection == nil) == (req.Service == nil) {
+		return nil, fmt.Errorf("exactly one of a connection or a service spec is required")
+	}
+
+	var conns []nsmv1.NetworkConnection
+	if req.Connection != nil {
+		conns = []nsmv1.NetworkConnection{{
+			ObjectMeta: metav1.ObjectMeta{Namespace: req.Namespace, Name: req.Name},
+			Spec:       *req.Connection,
+		}}
+	} else {
+		var err error
+		if conns, err = compileService(ctx, c, req.Namespace, req.Name, req.Service); err != nil {
+			return nil, err
+		}
+	}
+
+	result := &Result{Connections: []ConnectionPlan{}}
+	claimed := make(map[string]bool)
+	for i := range conns {
+		conn := &conns[i]
+
+		// existing connections keep their status, the reconciler acts on it
+		var existing nsmv1.NetworkConnection
+		err := c.Get(ctx, client.ObjectKeyFromObject(conn), &existing)
+		if err == nil {
+			existing.Spec = conn.Spec
+			conn = &existing
+		} else if client.IgnoreNotFound(err) != nil {
+			return nil, fmt.Errorf("failed to get connection %s/%s: %w", conn.Namespace, conn.Name, err)
+		}
+
+		plan, err := planner.Plan(ctx, conn)
+		if err != nil {
+			return nil, fmt.Errorf("failed to plan connection %s/%s: %w", conn.Namespace, conn.Name, err)
+		}
+		if plan.Datapath == nsmv1.ConnectionTypeSRIOV && vfs != nil {
+			planVF(ctx, c, plan, conn.Spec.Source, vfs, claimed)
+		}
+		result.Connections = append(result.Connections, *plan)
+	}
+	return result, nil
+}
+
+// compileService compiles the intents using the service with the proposed spec
+func compileService(ctx context.Context, c client.Client, namespace, name string, spec *nsmv1.NetworkServiceSpec) ([]nsmv1.NetworkConnection, error) {
+	svc := &nsmv1.NetworkService{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Spec:       *spec,
+	}
+
+	var intents nsmv1.NetworkIntentList
+	if err := c.List(ctx, &intents, client.InNamespace(namespace)); err != nil {
+		return nil, fmt.Errorf("failed to list intents: %w", err)
+	}
+
+	var conns []nsmv1.NetworkConnection
+	for i := range intents.Items {
+		in := &intents.Items[i]
+		if in.Spec.Service != name {
+			continue
+		}
+		selector, err := metav1.LabelSelectorAsSelector(&in.Spec.PodSelector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pod selector of intent %s: %w", in.Name, err)
+		}
+		var pods corev1.PodList
+		if err := c.List(ctx, &pods, client.InNamespace(namespace), client.MatchingLabelsSelector{Selector: selector}); err != nil {
+			return nil, fmt.Errorf("failed to list pods: %w", err)
+		}
+		compiled, err := intent.Compile(in, svc, pods.Items)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compile intent %s: %w", in.Name, err)
+		}
+		conns = append(conns, compiled.Connections...)
+	}
+	return conns, nil
+}
+
+// planVF adds the VF of the source pod, or the free VF the allocator
+// would pick next for it, to the plan of an SR-IOV connection
+func planVF(ctx context.Context, c client.Client, plan *ConnectionPlan, source string, vfs VFInventory, claimed map[string]bool) {
+	namespace, pod, ok := strings.Cut(source, "/")
+	if !ok {
+		plan.Warnings = append(plan.Warnings, fmt.Sprintf("source %q is not a namespace/pod, no VF can be allocated", source))
+		return
+	}
+	if vf, ok := vfs.GetVFForPod(namespace, pod); ok {
+		plan.VF = &VF{PFName: vf.PFName, VFID: vf.VFID, PCIAddress: vf.PCIAddress, Held: true}
+		claimed[vf.PCIAddress] = true
+		return
+	}
+
+	// the pod may constrain its VF, it needn't exist yet
+	sel := hardware.VFSelector{NUMANode: -1}
+	var p corev1.Pod
+	if err := c.Get(ctx, client.ObjectKey{Namespace: namespace, Name: pod}, &p); err == nil {
+		if sel, err = hardware.ParseVFSelector(p.Annotations); err != nil {
+			plan.Warnings = append(plan.Warnings, fmt.Sprintf("pod %s gets no VF: %v", source, err))
+			return
+		}
+	}
+	var free []hardware.VirtualFunction
+	for _, vf := range vfs.VirtualFunctions() {
+		if !vf.Allocated && !claimed[vf.PCIAddress] {
+			free = append(free, vf)
+		}
+	}
+	if len(free) == 0 {
+		plan.Warnings = append(plan.Warnings, fmt.Sprintf("no free VF for pod %s", source))
+		return
+	}
+	picked := hardware.SelectVFs(free, nil, sel, 1)
+	if picked == nil {
+		plan.Warnings = append(plan.Warnings, fmt.Sprintf("no free VF with %s for pod %s", sel, source))
+		return
+	}
+	vf := free[picked[0]]
+	plan.VF = &VF{PFName: vf.PFName, VFID: vf.VFID, PCIAddress: vf.PCIAddress}
+	claimed[vf.PCIAddress] = true
+}
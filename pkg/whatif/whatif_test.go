@@ -0,0 +1,216 @@
+package whatif
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/datapath"
+	"github.com/akos011221/nsm/pkg/hardware"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newClient(t *testing.T, objs ...client.Object) client.Client {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatalf("failed to build scheme: %v", err)
+	}
+	if err := nsmv1.AddToScheme(scheme); err != nil {
+		t.Fatalf("failed to build scheme: %v", err)
+	}
+	return fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build()
+}
+
+// echoPlanner plans every connection as established on its requested
+// datapath and records the connections it saw
+type echoPlanner struct {
+	seen []*nsmv1.NetworkConnection
+}
+
+func (p *echoPlanner) Plan(_ context.Context, conn *nsmv1.NetworkConnection) (*ConnectionPlan, error) {
+	p.seen = append(p.seen, conn)
+	return &ConnectionPlan{
+		Namespace: conn.Namespace,
+		Name:      conn.Name,
+		State:     nsmv1.ConnectionStateEstablished,
+		Datapath:  conn.Spec.ConnectionType,
+		QoS:       QoS{Priority: conn.Spec.Priority, BandwidthMbps: conn.Spec.Bandwidth},
+		Actions:   []Action{},
+	}, nil
+}
+
+// staticVFs is a fixed VF inventory
+type staticVFs []hardware.VirtualFunction
+
+func (v staticVFs) GetVFForPod(namespace, podName string) (hardware.VirtualFunction, bool) {
+	for _, vf := range v {
+		if vf.Allocated && vf.Namespace == namespace && vf.AllocatedTo == podName {
+			return vf, true
+		}
+	}
+	return hardware.VirtualFunction{}, false
+}
+
+func (v staticVFs) VirtualFunctions() []hardware.VirtualFunction {
+	return v
+}
+
+func sriovSpec(source string) *nsmv1.NetworkConnectionSpec {
+	return &nsmv1.NetworkConnectionSpec{Source: source, Destination: "svc", ConnectionType: nsmv1.ConnectionTypeSRIOV}
+}
+
+func TestPreviewConnectionVF(t *testing.T) {
+	vfs := staticVFs{
+		{PFName: "eth0", VFID: 0, PCIAddress: "0000:3b:02.0", Allocated: true, AllocatedTo: "camera", Namespace: "edge"},
+		{PFName: "eth0", VFID: 1, PCIAddress: "0000:3b:02.1"},
+	}
+	c := newClient(t)
+	planner := &echoPlanner{}
+
+	// the pod holding a VF keeps it
+	result, err := Preview(context.Background(), c, planner, vfs, Request{Namespace: "edge", Name: "conn", Connection: sriovSpec("edge/camera")})
+	if err != nil {
+		t.Fatalf("Preview() error = %v", err)
+	}
+	if vf := result.Connections[0].VF; vf == nil || !vf.Held || vf.PCIAddress != "0000:3b:02.0" {
+		t.Errorf("vf = %+v, want the held VF", vf)
+	}
+
+	// other pods get the next free VF
+	result, _ = Preview(context.Background(), c, planner, vfs, Request{Namespace: "edge", Name: "conn", Connection: sriovSpec("edge/lidar")})
+	if vf := result.Connections[0].VF; vf == nil || vf.Held || vf.PCIAddress != "0000:3b:02.1" {
+		t.Errorf("vf = %+v, want the free VF", vf)
+	}
+
+	// without a free VF the plan warns
+	result, _ = Preview(context.Background(), c, planner, vfs[:1], Request{Namespace: "edge", Name: "conn", Connection: sriovSpec("edge/lidar")})
+	if plan := result.Connections[0]; plan.VF != nil || !reflect.DeepEqual(plan.Warnings, []string{"no free VF for pod edge/lidar"}) {
+		t.Errorf("unexpected plan without a free VF %+v", plan)
+	}
+}
+
+func TestPreviewKeepsExistingStatus(t *testing.T) {
+	existing := &nsmv1.NetworkConnection{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "edge", Name: "conn"},
+		Spec:       nsmv1.NetworkConnectionSpec{Source: "edge/camera", Destination: "svc", ConnectionType: nsmv1.ConnectionTypeKernel, Priority: 10},
+		Status:     nsmv1.NetworkConnectionStatus{Established: true},
+	}
+	c := newClient(t, existing)
+	planner := &echoPlanner{}
+
+	spec := existing.Spec
+	spec.Priority = 90
+	if _, err := Preview(context.Background(), c, planner, nil, Request{Namespace: "edge", Name: "conn", Connection: &spec}); err != nil {
+		t.Fatalf("Preview() error = %v", err)
+	}
+	if seen := planner.seen[0]; seen.Spec.Priority != 90 || !seen.Status.Established {
+		t.Errorf("planned %+v, want the proposed spec with the existing status", seen)
+	}
+
+	var stored nsmv1.NetworkConnection
+	if err := c.Get(context.Background(), client.ObjectKeyFromObject(existing), &stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored.Spec.Priority != 10 {
+		t.Errorf("preview changed the stored connection")
+	}
+}
+
+func TestPreviewService(t *testing.T) {
+	pod := func(name string) *corev1.Pod {
+		return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "edge", Name: name, Labels: map[string]string{"app": "camera"}}}
+	}
+	in := &nsmv1.NetworkIntent{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "edge", Name: "cameras"},
+		Spec: nsmv1.NetworkIntentSpec{
+			PodSelector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "camera"}},
+			Service:     "uplink",
+		},
+	}
+	other := &nsmv1.NetworkIntent{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "edge", Name: "other"},
+		Spec:       nsmv1.NetworkIntentSpec{Service: "elsewhere"},
+	}
+	c := newClient(t, in, other, pod("cam-a"), pod("cam-b"))
+	vfs := staticVFs{{PFName: "eth0", VFID: 0, PCIAddress: "0000:3b:02.0"}}
+
+	// requiring SR-IOV moves both connections, only one VF is free
+	result, err := Preview(context.Background(), c, &echoPlanner{}, vfs, Request{
+		Namespace: "edge",
+		Name:      "uplink",
+		Service:   &nsmv1.NetworkServiceSpec{ServiceType: "l3", Endpoint: "10.0.0.1", Bandwidth: 50, RequireSRIOV: true},
+	})
+	if err != nil {
+		t.Fatalf("Preview() error = %v", err)
+	}
+	if len(result.Connections) != 2 {
+		t.Fatalf("planned %d connections, want 2", len(result.Connections))
+	}
+	first, second := result.Connections[0], result.Connections[1]
+	if first.Datapath != nsmv1.ConnectionTypeSRIOV || first.QoS.BandwidthMbps != 50 || first.VF == nil {
+		t.Errorf("unexpected plan %+v", first)
+	}
+	if second.VF != nil || len(second.Warnings) != 1 {
+		t.Errorf("second connection claimed the same VF: %+v", second)
+	}
+}
+
+func TestPreviewValidation(t *testing.T) {
+	c := newClient(t)
+	for name, req := range map[string]Request{
+		"no name":    {Namespace: "edge", Connection: sriovSpec("edge/pod")},
+		"no spec":    {Namespace: "edge", Name: "conn"},
+		"both specs": {Namespace: "edge", Name: "conn", Connection: sriovSpec("edge/pod"), Service: &nsmv1.NetworkServiceSpec{}},
+	} {
+		if _, err := Preview(context.Background(), c, &echoPlanner{}, nil, req); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestActions(t *testing.T) {
+	plan := &datapath.Plan{
+		Create: []datapath.Object{datapath.Link{Name: "nsmfb1"}},
+		Delete: []datapath.Object{datapath.Link{Name: "nsmfb2"}},
+	}
+	want := []Action{
+		{Op: "create", Kind: "link", Object: "link/nsmfb1"},
+		{Op: "delete", Kind: "link", Object: "link/nsmfb2"},
+	}
+	if got := Actions(plan); !reflect.DeepEqual(got, want) {
+		t.Errorf("Actions() = %+v, want %+v", got, want)
+	}
+}
+
+func TestPreviewConnectionVFConstraints(t *testing.T) {
+	vfs := staticVFs{
+		{PFName: "eth0", VFID: 0, PCIAddress: "0000:3b:02.0", NUMANode: 0},
+		{PFName: "eth2", VFID: 0, PCIAddress: "0000:af:02.0", NUMANode: 1},
+	}
+	lidar := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "edge", Name: "lidar", Annotations: map[string]string{
+		hardware.AnnotationSRIOVPF: "eth2",
+	}}}
+	radar := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "edge", Name: "radar", Annotations: map[string]string{
+		hardware.AnnotationSRIOVDriver: "vfio-pci",
+	}}}
+	c := newClient(t, lidar, radar)
+	planner := &echoPlanner{}
+
+	// the pod gets the VF the allocator would pick for its constraints
+	result, _ := Preview(context.Background(), c, planner, vfs, Request{Namespace: "edge", Name: "conn", Connection: sriovSpec("edge/lidar")})
+	if vf := result.Connections[0].VF; vf == nil || vf.PFName != "eth2" {
+		t.Errorf("vf = %+v, want the VF of eth2", vf)
+	}
+
+	result, _ = Preview(context.Background(), c, planner, vfs, Request{Namespace: "edge", Name: "conn", Connection: sriovSpec("edge/radar")})
+	if plan := result.Connections[0]; plan.VF != nil || !reflect.DeepEqual(plan.Warnings, []string{"no free VF with driver vfio-pci for pod edge/radar"}) {
+		t.Errorf("unexpected plan without a matching VF %+v", plan)
+	}
+}
@@ -0,0 +1,293 @@
+package xds
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// The Envoy API isn't vendored, so the messages NSM exchanges with Envoy
+// are described here with the subset of their fields NSM sets or reads.
+// Field numbers and names match the Envoy v3 protos, so the encoding is
+// wire compatible and the fields Envoy sets beyond the subset are kept as
+// unknown fields.
+
+// Full names of the Envoy messages
+const (
+	clusterMessage           = "envoy.config.cluster.v3.Cluster"
+	loadAssignmentMessage    = "envoy.config.endpoint.v3.ClusterLoadAssignment"
+	discoveryRequestMessage  = "envoy.service.discovery.v3.DiscoveryRequest"
+	discoveryResponseMessage = "envoy.service.discovery.v3.DiscoveryResponse"
+)
+
+// envoyFiles holds the descriptors of the Envoy messages
+var envoyFiles = mustBuildFiles()
+
+// messageType returns the type of an Envoy message by its full name
+func messageType(name protoreflect.FullName) protoreflect.MessageType {
+	desc, err := envoyFiles.FindDescriptorByName(name)
+	if err != nil {
+		panic(fmt.Sprintf("xds: unknown message %s: %v", name, err))
+	}
+	return dynamicpb.NewMessageType(desc.(protoreflect.MessageDescriptor))
+}
+
+var (
+	clusterType           = messageType(clusterMessage)
+	loadAssignmentType    = messageType(loadAssignmentMessage)
+	discoveryRequestType  = messageType(discoveryRequestMessage)
+	discoveryResponseType = messageType(discoveryResponseMessage)
+)
+
+// resourceTypes maps the served type URLs to their message type
+var resourceTypes = map[string]protoreflect.MessageType{
+	TypeCluster:               clusterType,
+	TypeClusterLoadAssignment: loadAssignmentType,
+}
+
+// encodeResource encodes a resource as the Envoy message of its type
+func encodeResource(typeURL string, r Resource) (*anypb.Any, error) {
+	mt, ok := resourceTypes[typeURL]
+	if !ok {
+		return nil, fmt.Errorf("unknown resource type %s", typeURL)
+	}
+	data, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	msg := mt.New().Interface()
+	if err := protojson.Unmarshal(data, msg); err != nil {
+		return nil, fmt.Errorf("resource doesn't match %s: %w", mt.Descriptor().FullName(), err)
+	}
+	return anypb.New(msg)
+}
+
+func mustBuildFiles() *protoregistry.Files {
+	files := new(protoregistry.Files)
+	for _, fd := range []protoreflect.FileDescriptor{
+		anypb.File_google_protobuf_any_proto,
+		durationpb.File_google_protobuf_duration_proto,
+		structpb.File_google_protobuf_struct_proto,
+		wrapperspb.File_google_protobuf_wrappers_proto,
+		status.File_google_rpc_status_proto,
+	} {
+		if err := files.RegisterFile(fd); err != nil {
+			panic(err)
+		}
+	}
+	for _, fdp := range []*descriptorpb.FileDescriptorProto{coreFile(), clusterFile(), endpointFile(), discoveryFile()} {
+		fd, err := protodesc.NewFile(fdp, files)
+		if err != nil {
+			panic(fmt.Sprintf("xds: invalid descriptor %s: %v", fdp.GetName(), err))
+		}
+		if err := files.RegisterFile(fd); err != nil {
+			panic(err)
+		}
+	}
+	return files
+}
+
+func coreFile() *descriptorpb.FileDescriptorProto {
+	return &descriptorpb.FileDescriptorProto{
+		Name:       proto.String("envoy/config/core/v3/nsm.proto"),
+		Package:    proto.String("envoy.config.core.v3"),
+		Syntax:     proto.String("proto3"),
+		Dependency: []string{"google/protobuf/duration.proto", "google/protobuf/struct.proto"},
+		EnumType: []*descriptorpb.EnumDescriptorProto{
+			enum("ApiVersion", "AUTO", "V2", "V3"),
+			enum("HealthStatus", "UNKNOWN", "HEALTHY", "UNHEALTHY", "DRAINING", "TIMEOUT", "DEGRADED"),
+		},
+		MessageType: []*descriptorpb.DescriptorProto{
+			message("Node",
+				scalar("id", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+				scalar("cluster", 2, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+			),
+			message("SocketAddress",
+				scalar("address", 2, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+				scalar("port_value", 3, descriptorpb.FieldDescriptorProto_TYPE_UINT32),
+			),
+			message("Address",
+				ref("socket_address", 1, ".envoy.config.core.v3.SocketAddress"),
+			),
+			withNested(message("Metadata",
+				repeated(ref("filter_metadata", 1, ".envoy.config.core.v3.Metadata.FilterMetadataEntry")),
+			), mapEntry("FilterMetadataEntry", ".google.protobuf.Struct")),
+			withNested(message("GrpcService",
+				ref("envoy_grpc", 1, ".envoy.config.core.v3.GrpcService.EnvoyGrpc"),
+				ref("timeout", 3, ".google.protobuf.Duration"),
+			), message("EnvoyGrpc",
+				scalar("cluster_name", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+			)),
+			withEnums(message("ApiConfigSource",
+				enumRef("api_type", 1, ".envoy.config.core.v3.ApiConfigSource.ApiType"),
+				repeated(ref("grpc_services", 4, ".envoy.config.core.v3.GrpcService")),
+				enumRef("transport_api_version", 8, ".envoy.config.core.v3.ApiVersion"),
+			), enumValues("ApiType", map[string]int32{
+				"DEPRECATED_AND_UNAVAILABLE_DO_NOT_USE": 0, "REST": 1, "GRPC": 2, "DELTA_GRPC": 3, "AGGREGATED_GRPC": 5, "AGGREGATED_DELTA_GRPC": 6,
+			})),
+			message("AggregatedConfigSource"),
+			message("ConfigSource",
+				ref("api_config_source", 2, ".envoy.config.core.v3.ApiConfigSource"),
+				ref("ads", 3, ".envoy.config.core.v3.AggregatedConfigSource"),
+				enumRef("resource_api_version", 6, ".envoy.config.core.v3.ApiVersion"),
+			),
+		},
+	}
+}
+
+func clusterFile() *descriptorpb.FileDescriptorProto {
+	cluster := message("Cluster",
+		scalar("name", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+		enumRef("type", 2, ".envoy.config.cluster.v3.Cluster.DiscoveryType"),
+		ref("eds_cluster_config", 3, ".envoy.config.cluster.v3.Cluster.EdsClusterConfig"),
+		ref("connect_timeout", 4, ".google.protobuf.Duration"),
+	)
+	cluster = withEnums(cluster, enum("DiscoveryType", "STATIC", "STRICT_DNS", "LOGICAL_DNS", "EDS", "ORIGINAL_DST"))
+	cluster = withNested(cluster, message("EdsClusterConfig",
+		ref("eds_config", 1, ".envoy.config.core.v3.ConfigSource"),
+		scalar("service_name", 2, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+	))
+	return &descriptorpb.FileDescriptorProto{
+		Name:        proto.String("envoy/config/cluster/v3/nsm.proto"),
+		Package:     proto.String("envoy.config.cluster.v3"),
+		Syntax:      proto.String("proto3"),
+		Dependency:  []string{"google/protobuf/duration.proto", "envoy/config/core/v3/nsm.proto"},
+		MessageType: []*descriptorpb.DescriptorProto{cluster},
+	}
+}
+
+func endpointFile() *descriptorpb.FileDescriptorProto {
+	return &descriptorpb.FileDescriptorProto{
+		Name:       proto.String("envoy/config/endpoint/v3/nsm.proto"),
+		Package:    proto.String("envoy.config.endpoint.v3"),
+		Syntax:     proto.String("proto3"),
+		Dependency: []string{"google/protobuf/wrappers.proto", "envoy/config/core/v3/nsm.proto"},
+		MessageType: []*descriptorpb.DescriptorProto{
+			message("Endpoint",
+				ref("address", 1, ".envoy.config.core.v3.Address"),
+			),
+			message("LbEndpoint",
+				ref("endpoint", 1, ".envoy.config.endpoint.v3.Endpoint"),
+				enumRef("health_status", 2, ".envoy.config.core.v3.HealthStatus"),
+				ref("metadata", 3, ".envoy.config.core.v3.Metadata"),
+				ref("load_balancing_weight", 4, ".google.protobuf.UInt32Value"),
+			),
+			message("LocalityLbEndpoints",
+				repeated(ref("lb_endpoints", 2, ".envoy.config.endpoint.v3.LbEndpoint")),
+			),
+			message("ClusterLoadAssignment",
+				scalar("cluster_name", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+				repeated(ref("endpoints", 2, ".envoy.config.endpoint.v3.LocalityLbEndpoints")),
+			),
+		},
+	}
+}
+
+func discoveryFile() *descriptorpb.FileDescriptorProto {
+	return &descriptorpb.FileDescriptorProto{
+		Name:       proto.String("envoy/service/discovery/v3/nsm.proto"),
+		Package:    proto.String("envoy.service.discovery.v3"),
+		Syntax:     proto.String("proto3"),
+		Dependency: []string{"google/protobuf/any.proto", "google/rpc/status.proto", "envoy/config/core/v3/nsm.proto"},
+		MessageType: []*descriptorpb.DescriptorProto{
+			message("DiscoveryRequest",
+				scalar("version_info", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+				ref("node", 2, ".envoy.config.core.v3.Node"),
+				repeated(scalar("resource_names", 3, descriptorpb.FieldDescriptorProto_TYPE_STRING)),
+				scalar("type_url", 4, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+				scalar("response_nonce", 5, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+				ref("error_detail", 6, ".google.rpc.Status"),
+			),
+			message("DiscoveryResponse",
+				scalar("version_info", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+				repeated(ref("resources", 2, ".google.protobuf.Any")),
+				scalar("type_url", 4, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+				scalar("nonce", 5, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+			),
+		},
+	}
+}
+
+func message(name string, fields ...*descriptorpb.FieldDescriptorProto) *descriptorpb.DescriptorProto {
+	return &descriptorpb.DescriptorProto{Name: proto.String(name), Field: fields}
+}
+
+func withNested(msg *descriptorpb.DescriptorProto, nested ...*descriptorpb.DescriptorProto) *descriptorpb.DescriptorProto {
+	msg.NestedType = append(msg.NestedType, nested...)
+	return msg
+}
+
+func withEnums(msg *descriptorpb.DescriptorProto, enums ...*descriptorpb.EnumDescriptorProto) *descriptorpb.DescriptorProto {
+	msg.EnumType = append(msg.EnumType, enums...)
+	return msg
+}
+
+// mapEntry describes the entry of a map from strings to messages
+func mapEntry(name, valueType string) *descriptorpb.DescriptorProto {
+	entry := message(name,
+		scalar("key", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+		ref("value", 2, valueType),
+	)
+	entry.Options = &descriptorpb.MessageOptions{MapEntry: proto.Bool(true)}
+	return entry
+}
+
+// enum describes an enum whose values are numbered in order
+func enum(name string, values ...string) *descriptorpb.EnumDescriptorProto {
+	numbers := make(map[string]int32, len(values))
+	for i, v := range values {
+		numbers[v] = int32(i)
+	}
+	return enumValues(name, numbers)
+}
+
+func enumValues(name string, numbers map[string]int32) *descriptorpb.EnumDescriptorProto {
+	e := &descriptorpb.EnumDescriptorProto{Name: proto.String(name)}
+	// the first value must be the zero value
+	for n := int32(0); len(e.Value) < len(numbers); n++ {
+		for v, number := range numbers {
+			if number == n {
+				e.Value = append(e.Value, &descriptorpb.EnumValueDescriptorProto{Name: proto.String(v), Number: proto.Int32(n)})
+			}
+		}
+	}
+	return e
+}
+
+func scalar(name string, number int32, typ descriptorpb.FieldDescriptorProto_Type) *descriptorpb.FieldDescriptorProto {
+	return &descriptorpb.FieldDescriptorProto{
+		Name:   proto.String(name),
+		Number: proto.Int32(number),
+		Label:  descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+		Type:   typ.Enum(),
+	}
+}
+
+func ref(name string, number int32, typeName string) *descriptorpb.FieldDescriptorProto {
+	f := scalar(name, number, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE)
+	f.TypeName = proto.String(typeName)
+	return f
+}
+
+func enumRef(name string, number int32, typeName string) *descriptorpb.FieldDescriptorProto {
+	f := scalar(name, number, descriptorpb.FieldDescriptorProto_TYPE_ENUM)
+	f.TypeName = proto.String(typeName)
+	return f
+}
+
+func repeated(f *descriptorpb.FieldDescriptorProto) *descriptorpb.FieldDescriptorProto {
+	f.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+	return f
+}
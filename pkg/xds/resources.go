@@ -0,0 +1,266 @@
+package xds
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/connection"
+	"github.com/akos011221/nsm/pkg/external"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// Envoy v3 resource type URLs
+const (
+	TypeCluster               = "type.googleapis.com/envoy.config.cluster.v3.Cluster"
+	TypeClusterLoadAssignment = "type.googleapis.com/envoy.config.endpoint.v3.ClusterLoadAssignment"
+)
+
+// Envoy health statuses
+const (
+	healthHealthy   = "HEALTHY"
+	healthUnhealthy = "UNHEALTHY"
+	healthDegraded  = "DEGRADED"
+)
+
+// Resource is an xDS resource in the proto JSON mapping of its type
+type Resource map[string]interface{}
+
+// Snapshot is a consistent set of clusters and endpoints
+type Snapshot struct {
+	// Version of the snapshot, changes whenever the content changes
+	Version string
+	// Clusters by name (CDS)
+	Clusters map[string]Resource
+	// Endpoints by cluster name (EDS)
+	Endpoints map[string]Resource
+	// Resources encoded as Envoy messages, by type URL and name
+	encoded map[string]map[string]*anypb.Any
+}
+
+// Resources returns the resources of a type, filtered by name if names is not empty
+func (s *Snapshot) Resources(typeURL string, names []string) []Resource {
+	var set map[string]Resource
+	switch typeURL {
+	case TypeCluster:
+		set = s.Clusters
+	case TypeClusterLoadAssignment:
+		set = s.Endpoints
+	default:
+		return nil
+	}
+	return selectResources(set, names)
+}
+
+// Encoded returns the encoded resources of a type, filtered by name if
+// names is not empty
+func (s *Snapshot) Encoded(typeURL string, names []string) []*anypb.Any {
+	return selectResources(s.encoded[typeURL], names)
+}
+
+// encode encodes the resources as the Envoy messages of their type
+func (s *Snapshot) encode() error {
+	s.encoded = make(map[string]map[string]*anypb.Any, len(resourceTypes))
+	for typeURL, set := range map[string]map[string]Resource{TypeCluster: s.Clusters, TypeClusterLoadAssignment: s.Endpoints} {
+		s.encoded[typeURL] = make(map[string]*anypb.Any, len(set))
+		for name, r := range set {
+			a, err := encodeResource(typeURL, r)
+			if err != nil {
+				return fmt.Errorf("failed to encode %s: %w", name, err)
+			}
+			s.encoded[typeURL][name] = a
+		}
+	}
+	return nil
+}
+
+// selectResources returns the named resources of a set sorted by name,
+// all of them if names is empty
+func selectResources[T any](set map[string]T, names []string) []T {
+	keys := make([]string, 0, len(set))
+	if len(names) > 0 {
+		keys = append(keys, names...)
+	} else {
+		for k := range set {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	resources := make([]T, 0, len(keys))
+	for _, k := range keys {
+		if r, ok := set[k]; ok {
+			resources = append(resources, r)
+		}
+	}
+	return resources
+}
+
+// Scores rates the reachability of the service endpoints from 0 to 100
+type Scores interface {
+	Score(namespace, name string) (int, bool)
+}
+
+// BuildSnapshot describes the NSM services and their observed health and
+// latency as Envoy clusters and endpoints. xdsCluster is the name of the
+// cluster Envoy uses to reach NSM, EDS for the clusters is fetched from it.
+// The endpoints are weighted by their reachability score if scores is set.
+func BuildSnapshot(services []nsmv1.NetworkService, conns []nsmv1.NetworkConnection, xdsCluster string, scores Scores) (*Snapshot, error) {
+	snap := &Snapshot{
+		Clusters:  make(map[string]Resource),
+		Endpoints: make(map[string]Resource),
+	}
+
+	latency := averageLatency(conns)
+
+	for _, svc := range services {
+		host, port, err := splitEndpoint(external.Endpoint(&svc))
+		if err != nil {
+			// services without an addressable endpoint can't be load balanced
+			continue
+		}
+
+		name := ClusterName(svc.Namespace, svc.Name)
+		snap.Clusters[name] = Resource{
+			"name":            name,
+			"type":            "EDS",
+			"connect_timeout": "1s",
+			"eds_cluster_config": map[string]interface{}{
+				"eds_config": map[string]interface{}{
+					"resource_api_version": "V3",
+					"api_config_source": map[string]interface{}{
+						"api_type":              "GRPC",
+						"transport_api_version": "V3",
+						"grpc_services": []interface{}{
+							map[string]interface{}{
+								"envoy_grpc": map[string]interface{}{"cluster_name": xdsCluster},
+							},
+						},
+					},
+				},
+			},
+		}
+
+		observed := latency[svcKey(svc.Namespace, svc.Name)]
+		metadata := map[string]interface{}{
+			"latencyMs":          observed,
+			"latencyRequirement": svc.Spec.LatencyRequirement,
+			"priority":           svc.Spec.Priority,
+		}
+		lbEndpoint := map[string]interface{}{
+			"endpoint": map[string]interface{}{
+				"address": map[string]interface{}{
+					"socket_address": map[string]interface{}{
+						"address":    host,
+						"port_value": port,
+					},
+				},
+			},
+			"health_status": healthStatus(&svc, observed),
+			"metadata": map[string]interface{}{
+				"filter_metadata": map[string]interface{}{"nsm": metadata},
+			},
+		}
+		if scores != nil {
+			if score, ok := scores.Score(svc.Namespace, svc.Name); ok {
+				metadata["score"] = score
+				// Envoy requires weights of at least 1
+				lbEndpoint["load_balancing_weight"] = max(score, 1)
+			}
+		}
+		snap.Endpoints[name] = Resource{
+			"cluster_name": name,
+			"endpoints": []interface{}{
+				map[string]interface{}{
+					"lb_endpoints": []interface{}{lbEndpoint},
+				},
+			},
+		}
+	}
+
+	version, err := hashSnapshot(snap)
+	if err != nil {
+		return nil, err
+	}
+	snap.Version = version
+
+	// encoded once per snapshot, and a resource Envoy would reject fails the build
+	if err := snap.encode(); err != nil {
+		return nil, err
+	}
+
+	return snap, nil
+}
+
+// ClusterName returns the Envoy cluster name of a NetworkService
+func ClusterName(namespace, name string) string {
+	return fmt.Sprintf("nsm/%s/%s", namespace, name)
+}
+
+// healthStatus maps the service phase and observed latency to an Envoy health status
+func healthStatus(svc *nsmv1.NetworkService, observedLatency int) string {
+	switch svc.Status.Phase {
+	case nsmv1.ServicePhaseReady:
+		if svc.Spec.LatencyRequirement > 0 && observedLatency > svc.Spec.LatencyRequirement {
+			return healthDegraded
+		}
+		return healthHealthy
+	case nsmv1.ServicePhaseDegraded:
+		return healthDegraded
+	default:
+		return healthUnhealthy
+	}
+}
+
+// averageLatency returns the average observed latency per destination service
+func averageLatency(conns []nsmv1.NetworkConnection) map[string]int {
+	sum := make(map[string]int)
+	count := make(map[string]int)
+	for _, conn := range conns {
+		if !conn.Status.Established || conn.Status.Metrics.LatencyMs == 0 {
+			continue
+		}
+		key := svcKey(connection.Destination(&conn))
+		sum[key] += conn.Status.Metrics.LatencyMs
+		count[key]++
+	}
+
+	avg := make(map[string]int, len(sum))
+	for k, v := range sum {
+		avg[k] = v / count[k]
+	}
+	return avg
+}
+
+func svcKey(namespace, name string) string {
+	return namespace + "/" + name
+}
+
+// splitEndpoint returns the IP and port of an endpoint
+func splitEndpoint(endpoint string) (string, int, error) {
+	host, portStr, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		return "", 0, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port <= 0 || port > 65535 {
+		return "", 0, fmt.Errorf("invalid port in endpoint %s", endpoint)
+	}
+	return host, port, nil
+}
+
+// hashSnapshot computes a content based version for the snapshot
+func hashSnapshot(snap *Snapshot) (string, error) {
+	// encoding/json sorts map keys, so the encoding is stable
+	data, err := json.Marshal([]interface{}{snap.Clusters, snap.Endpoints})
+	if err != nil {
+		return "", fmt.Errorf("failed to encode snapshot: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8]), nil
+}
@@ -0,0 +1,369 @@
+package xds
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/akos011221/nsm/pkg/watchdog"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Server serves NSM services and endpoints over the state of the world
+// variant of the gRPC xDS protocol, so Envoy based gateways can consume
+// them through ADS, or CDS and EDS, without custom integration code
+type Server struct {
+	// Context for cancellation
+	ctx context.Context
+	// Kubernetes client (controller-runtime)
+	client client.Client
+	// Logger
+	logger *logrus.Logger
+	// Address to listen on
+	listenAddr string
+	// Name of the cluster Envoy uses to reach this server
+	xdsCluster string
+	// Latest snapshot, nil until the first one is built
+	snapshot *Snapshot
+	// Closed and replaced when the snapshot version changes
+	updated chan struct{}
+	// Mutex for protecting the snapshot
+	mu sync.RWMutex
+	// Interval between snapshot rebuilds
+	refreshInterval time.Duration
+	// Reachability scores the endpoints are weighted by, nil for none
+	scores Scores
+	// Progress signal for the watchdog
+	heartbeat *watchdog.Heartbeat
+}
+
+// NewServer creates a new xDS server
+func NewServer(ctx context.Context, c client.Client, logger *logrus.Logger, listenAddr, xdsCluster string) *Server {
+	return &Server{
+		ctx:             ctx,
+		client:          c,
+		logger:          logger,
+		listenAddr:      listenAddr,
+		xdsCluster:      xdsCluster,
+		updated:         make(chan struct{}),
+		refreshInterval: 5 * time.Second,
+	}
+}
+
+// SetHeartbeat makes the server report its progress to the watchdog
+func (s *Server) SetHeartbeat(hb *watchdog.Heartbeat) {
+	s.heartbeat = hb
+	hb.Expect(s.refreshInterval)
+}
+
+// SetScores makes the server weight the endpoints by their reachability score
+func (s *Server) SetScores(scores Scores) {
+	s.scores = scores
+}
+
+// Start builds the first snapshot, then serves xDS streams and refreshes
+// the snapshot until the context is done. Envoy takes the first response
+// for the whole state, so nothing is served before the snapshot is built.
+func (s *Server) Start() error {
+	for {
+		err := s.refresh()
+		if err == nil {
+			break
+		}
+		s.logger.WithError(err).Warn("Failed to build the first xDS snapshot, retrying")
+		s.heartbeat.Beat()
+		select {
+		case <-time.After(s.refreshInterval):
+		case <-s.ctx.Done():
+			return nil
+		}
+	}
+
+	lis, err := net.Listen("tcp", s.listenAddr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", s.listenAddr, err)
+	}
+	s.logger.Infof("Starting xDS server on %s", s.listenAddr)
+	return s.Serve(lis)
+}
+
+// Serve serves xDS streams on the listener and refreshes the snapshot
+// until the context is done, the snapshot must have been set
+func (s *Server) Serve(lis net.Listener) error {
+	srv := grpc.NewServer()
+	s.register(srv)
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.Serve(lis)
+	}()
+
+	ticker := time.NewTicker(s.refreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.heartbeat.Beat()
+
+			if err := s.refresh(); err != nil {
+				s.logger.WithError(err).Error("Failed to refresh xDS snapshot")
+			}
+
+		case err := <-errCh:
+			return fmt.Errorf("xDS server failed: %w", err)
+
+		case <-s.ctx.Done():
+			// the streams end with the context, so this doesn't block
+			s.logger.Info("Stopping xDS server")
+			srv.GracefulStop()
+			return nil
+		}
+	}
+}
+
+// register registers the aggregated discovery service and the cluster and
+// endpoint discovery services, whose streams default to their resource type
+func (s *Server) register(srv *grpc.Server) {
+	for _, svc := range []struct {
+		name, method, typeURL string
+	}{
+		{"envoy.service.discovery.v3.AggregatedDiscoveryService", "StreamAggregatedResources", ""},
+		{"envoy.service.cluster.v3.ClusterDiscoveryService", "StreamClusters", TypeCluster},
+		{"envoy.service.endpoint.v3.EndpointDiscoveryService", "StreamEndpoints", TypeClusterLoadAssignment},
+	} {
+		typeURL := svc.typeURL
+		srv.RegisterService(&grpc.ServiceDesc{
+			ServiceName: svc.name,
+			HandlerType: (*any)(nil),
+			Streams: []grpc.StreamDesc{{
+				StreamName: svc.method,
+				Handler: func(_ any, stream grpc.ServerStream) error {
+					return s.serveStream(stream, typeURL)
+				},
+				ServerStreams: true,
+				ClientStreams: true,
+			}},
+		}, s)
+	}
+}
+
+// SetSnapshot replaces the served snapshot, the streams are sent the new
+// version when it changed
+func (s *Server) SetSnapshot(snap *Snapshot) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.snapshot != nil && s.snapshot.Version == snap.Version {
+		s.snapshot = snap
+		return
+	}
+	s.logger.Debugf("xDS snapshot updated to version %s", snap.Version)
+	s.snapshot = snap
+	close(s.updated)
+	s.updated = make(chan struct{})
+}
+
+// current returns the served snapshot and a channel closed when it changes
+func (s *Server) current() (*Snapshot, <-chan struct{}) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.snapshot, s.updated
+}
+
+// refresh rebuilds the snapshot from the NSM resources
+func (s *Server) refresh() error {
+	var services nsmv1.NetworkServiceList
+	if err := s.client.List(s.ctx, &services); err != nil {
+		return fmt.Errorf("failed to list network services: %w", err)
+	}
+
+	var conns nsmv1.NetworkConnectionList
+	if err := s.client.List(s.ctx, &conns); err != nil {
+		return fmt.Errorf("failed to list network connections: %w", err)
+	}
+
+	snap, err := BuildSnapshot(services.Items, conns.Items, s.xdsCluster, s.scores)
+	if err != nil {
+		return err
+	}
+	s.SetSnapshot(snap)
+	return nil
+}
+
+// discoveryStream is the state of an xDS stream
+type discoveryStream struct {
+	// Stream the responses are sent on
+	stream grpc.ServerStream
+	// Resource type of requests without one
+	typeURL string
+	// Node of the Envoy on the other end
+	node string
+	// Subscriptions by resource type
+	subscriptions map[string]*subscription
+	// Last nonce sent
+	nonce int
+}
+
+// subscription is the state of a resource type on a stream
+type subscription struct {
+	// Names of the subscribed resources, empty for all
+	names []string
+	// Snapshot version last sent
+	version string
+	// Nonce of the last response
+	nonce string
+}
+
+// serveStream answers the discovery requests of a stream and sends the
+// subscribed resources again whenever the snapshot changes
+func (s *Server) serveStream(stream grpc.ServerStream, typeURL string) error {
+	ds := &discoveryStream{
+		stream:        stream,
+		typeURL:       typeURL,
+		subscriptions: make(map[string]*subscription),
+	}
+
+	reqs := make(chan protoreflect.Message)
+	errCh := make(chan error, 1)
+	go func() {
+		for {
+			req := discoveryRequestType.New()
+			if err := stream.RecvMsg(req.Interface()); err != nil {
+				errCh <- err
+				return
+			}
+			select {
+			case reqs <- req:
+			case <-stream.Context().Done():
+				return
+			}
+		}
+	}()
+
+	for {
+		_, updated := s.current()
+		select {
+		case req := <-reqs:
+			if err := s.handleRequest(ds, req); err != nil {
+				return err
+			}
+
+		case <-updated:
+			snap, _ := s.current()
+			for typeURL, sub := range ds.subscriptions {
+				if sub.version == snap.Version {
+					continue
+				}
+				if err := ds.send(typeURL, snap); err != nil {
+					return err
+				}
+			}
+
+		case err := <-errCh:
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+
+		case <-stream.Context().Done():
+			return nil
+
+		case <-s.ctx.Done():
+			return status.Error(codes.Unavailable, "server shutting down")
+		}
+	}
+}
+
+// handleRequest answers a discovery request. Requests acknowledging or
+// rejecting the current version of the resources, and responses to a
+// superseded response, are not answered.
+func (s *Server) handleRequest(ds *discoveryStream, req protoreflect.Message) error {
+	if node := field(req, "node"); node.IsValid() {
+		ds.node = stringField(node.Message(), "id")
+	}
+	typeURL := stringField(req, "type_url")
+	if typeURL == "" {
+		typeURL = ds.typeURL
+	}
+	if _, ok := resourceTypes[typeURL]; !ok {
+		// listeners and routes are left to the gateway's own configuration
+		s.logger.Debugf("Ignoring xDS request of node %s for %s", ds.node, typeURL)
+		return nil
+	}
+
+	sub := ds.subscriptions[typeURL]
+	nonce := stringField(req, "response_nonce")
+	if sub != nil && nonce != "" && nonce != sub.nonce {
+		return nil
+	}
+	if detail := field(req, "error_detail"); detail.IsValid() {
+		s.logger.Warnf("xDS node %s rejected version %s of %s: %s",
+			ds.node, stringField(req, "version_info"), typeURL, stringField(detail.Message(), "message"))
+	}
+
+	var names []string
+	list := field(req, "resource_names").List()
+	for i := 0; i < list.Len(); i++ {
+		names = append(names, list.Get(i).String())
+	}
+	snap, _ := s.current()
+	if sub == nil {
+		sub = &subscription{}
+		ds.subscriptions[typeURL] = sub
+	} else if sub.version == snap.Version && slices.Equal(sub.names, names) {
+		return nil
+	}
+	sub.names = names
+	return ds.send(typeURL, snap)
+}
+
+// send sends the subscribed resources of a type in a snapshot
+func (ds *discoveryStream) send(typeURL string, snap *Snapshot) error {
+	sub := ds.subscriptions[typeURL]
+	ds.nonce++
+	nonce := strconv.Itoa(ds.nonce)
+
+	resp := discoveryResponseType.New()
+	fields := resp.Descriptor().Fields()
+	resp.Set(fields.ByName("version_info"), protoreflect.ValueOfString(snap.Version))
+	resp.Set(fields.ByName("type_url"), protoreflect.ValueOfString(typeURL))
+	resp.Set(fields.ByName("nonce"), protoreflect.ValueOfString(nonce))
+	resources := resp.Mutable(fields.ByName("resources")).List()
+	for _, r := range snap.Encoded(typeURL, sub.names) {
+		resources.Append(protoreflect.ValueOfMessage(r.ProtoReflect()))
+	}
+
+	if err := ds.stream.SendMsg(resp.Interface()); err != nil {
+		return err
+	}
+	sub.version = snap.Version
+	sub.nonce = nonce
+	return nil
+}
+
+// field returns a field of a message, invalid if it is not set
+func field(msg protoreflect.Message, name protoreflect.Name) protoreflect.Value {
+	fd := msg.Descriptor().Fields().ByName(name)
+	if fd.Message() != nil && !fd.IsList() && !msg.Has(fd) {
+		return protoreflect.Value{}
+	}
+	return msg.Get(fd)
+}
+
+// stringField returns a string field of a message
+func stringField(msg protoreflect.Message, name protoreflect.Name) string {
+	return msg.Get(msg.Descriptor().Fields().ByName(name)).String()
+}
@@ -0,0 +1,240 @@
+package xds
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	nsmv1 "github.com/akos011221/nsm/api/v1"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func testServices() []nsmv1.NetworkService {
+	return []nsmv1.NetworkService{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "camera-feed", Namespace: "edge"},
+			Spec:       nsmv1.NetworkServiceSpec{Endpoint: "10.0.0.5:8554", LatencyRequirement: 10},
+			Status:     nsmv1.NetworkServiceStatus{Phase: nsmv1.ServicePhaseReady},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "no-port", Namespace: "edge"},
+			Spec:       nsmv1.NetworkServiceSpec{Endpoint: "10.0.0.6"},
+		},
+	}
+}
+
+func lbEndpoint(t *testing.T, r Resource) map[string]interface{} {
+	t.Helper()
+	endpoints := r["endpoints"].([]interface{})
+	lb := endpoints[0].(map[string]interface{})["lb_endpoints"].([]interface{})
+	return lb[0].(map[string]interface{})
+}
+
+func TestBuildSnapshot(t *testing.T) {
+	snap, err := BuildSnapshot(testServices(), nil, "nsm-xds", nil)
+	if err != nil {
+		t.Fatalf("BuildSnapshot() error = %v", err)
+	}
+
+	name := ClusterName("edge", "camera-feed")
+	if len(snap.Clusters) != 1 || snap.Clusters[name] == nil {
+		t.Fatalf("unexpected clusters: %v", snap.Clusters)
+	}
+	if got := lbEndpoint(t, snap.Endpoints[name])["health_status"]; got != healthHealthy {
+		t.Errorf("health_status = %v, want HEALTHY", got)
+	}
+
+	// a latency above the requirement degrades the endpoint and changes the version
+	conns := []nsmv1.NetworkConnection{{
+		ObjectMeta: metav1.ObjectMeta{Name: "c", Namespace: "edge"},
+		Spec:       nsmv1.NetworkConnectionSpec{Destination: "camera-feed"},
+		Status:     nsmv1.NetworkConnectionStatus{Established: true, Metrics: nsmv1.ConnectionMetrics{LatencyMs: 25}},
+	}}
+	slow, err := BuildSnapshot(testServices(), conns, "nsm-xds", nil)
+	if err != nil {
+		t.Fatalf("BuildSnapshot() error = %v", err)
+	}
+	if got := lbEndpoint(t, slow.Endpoints[name])["health_status"]; got != healthDegraded {
+		t.Errorf("health_status = %v, want DEGRADED", got)
+	}
+	if slow.Version == snap.Version {
+		t.Errorf("version did not change with the content")
+	}
+}
+
+// discoveryClient is the Envoy end of an aggregated discovery stream
+type discoveryClient struct {
+	t      *testing.T
+	stream grpc.ClientStream
+}
+
+// startServer serves the snapshot of the objects over an in-memory
+// listener and opens an aggregated discovery stream
+func startServer(t *testing.T, objs ...client.Object) (*discoveryClient, client.Client) {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := nsmv1.AddToScheme(scheme); err != nil {
+		t.Fatalf("failed to build scheme: %v", err)
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build()
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	ctx, cancel := context.WithCancel(context.Background())
+	s := NewServer(ctx, c, logger, "", "nsm-xds")
+	s.refreshInterval = 10 * time.Millisecond
+	if err := s.refresh(); err != nil {
+		t.Fatalf("refresh() error = %v", err)
+	}
+
+	lis := bufconn.Listen(1 << 20)
+	done := make(chan error, 1)
+	go func() { done <- s.Serve(lis) }()
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	streamCtx, cancelStream := context.WithCancel(context.Background())
+	t.Cleanup(func() {
+		cancelStream()
+		conn.Close()
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Serve() error = %v", err)
+		}
+	})
+
+	stream, err := conn.NewStream(streamCtx, &grpc.StreamDesc{ServerStreams: true, ClientStreams: true},
+		"/envoy.service.discovery.v3.AggregatedDiscoveryService/StreamAggregatedResources")
+	if err != nil {
+		t.Fatalf("failed to open the stream: %v", err)
+	}
+	return &discoveryClient{t: t, stream: stream}, c
+}
+
+// request sends a discovery request
+func (d *discoveryClient) request(typeURL, version, nonce string, names ...string) {
+	d.t.Helper()
+	req := discoveryRequestType.New()
+	fields := req.Descriptor().Fields()
+	req.Set(fields.ByName("type_url"), protoreflect.ValueOfString(typeURL))
+	req.Set(fields.ByName("version_info"), protoreflect.ValueOfString(version))
+	req.Set(fields.ByName("response_nonce"), protoreflect.ValueOfString(nonce))
+	list := req.Mutable(fields.ByName("resource_names")).List()
+	for _, name := range names {
+		list.Append(protoreflect.ValueOfString(name))
+	}
+	if err := d.stream.SendMsg(req.Interface()); err != nil {
+		d.t.Fatalf("failed to send the request: %v", err)
+	}
+}
+
+// response receives a discovery response and decodes its resources
+func (d *discoveryClient) response() (typeURL, version, nonce string, resources []protoreflect.Message) {
+	d.t.Helper()
+	resp := discoveryResponseType.New()
+	if err := d.stream.RecvMsg(resp.Interface()); err != nil {
+		d.t.Fatalf("failed to receive the response: %v", err)
+	}
+	typeURL = stringField(resp, "type_url")
+	list := field(resp, "resources").List()
+	for i := 0; i < list.Len(); i++ {
+		a := list.Get(i).Message()
+		if got := stringField(a, "type_url"); got != typeURL {
+			d.t.Errorf("resource of type %s in a %s response", got, typeURL)
+		}
+		msg := resourceTypes[typeURL].New()
+		if err := proto.Unmarshal(field(a, "value").Bytes(), msg.Interface()); err != nil {
+			d.t.Fatalf("failed to decode the resource: %v", err)
+		}
+		resources = append(resources, msg)
+	}
+	return typeURL, stringField(resp, "version_info"), stringField(resp, "nonce"), resources
+}
+
+func TestAggregatedDiscovery(t *testing.T) {
+	svcs := testServices()
+	d, c := startServer(t, &svcs[0], &svcs[1])
+	name := ClusterName("edge", "camera-feed")
+
+	d.request(TypeCluster, "", "")
+	typeURL, version, nonce, clusters := d.response()
+	if typeURL != TypeCluster || version == "" || len(clusters) != 1 || stringField(clusters[0], "name") != name {
+		t.Fatalf("unexpected CDS response %s %s: %v", typeURL, version, clusters)
+	}
+	eds := field(field(clusters[0], "eds_cluster_config").Message(), "eds_config").Message()
+	grpcService := field(field(eds, "api_config_source").Message(), "grpc_services").List().Get(0).Message()
+	if got := stringField(field(grpcService, "envoy_grpc").Message(), "cluster_name"); got != "nsm-xds" {
+		t.Errorf("EDS fetched from cluster %q, want nsm-xds", got)
+	}
+
+	// the acknowledgement isn't answered, so the next response is the EDS one
+	d.request(TypeCluster, version, nonce)
+	d.request(TypeClusterLoadAssignment, "", "", name)
+	typeURL, _, edsNonce, endpoints := d.response()
+	if typeURL != TypeClusterLoadAssignment || len(endpoints) != 1 {
+		t.Fatalf("unexpected EDS response %s: %v", typeURL, endpoints)
+	}
+	lb := field(endpoints[0], "endpoints").List().Get(0).Message()
+	lbEndpoint := field(lb, "lb_endpoints").List().Get(0).Message()
+	if got := field(lbEndpoint, "health_status").Enum(); got != 1 {
+		t.Errorf("health_status = %d, want HEALTHY", got)
+	}
+	d.request(TypeClusterLoadAssignment, version, edsNonce, name)
+
+	// a changed service is pushed to both subscriptions
+	svc := &nsmv1.NetworkService{}
+	if err := c.Get(context.Background(), client.ObjectKeyFromObject(&svcs[0]), svc); err != nil {
+		t.Fatal(err)
+	}
+	svc.Status.Phase = nsmv1.ServicePhaseDegraded
+	if err := c.Update(context.Background(), svc); err != nil {
+		t.Fatal(err)
+	}
+	pushed := map[string]string{}
+	for i := 0; i < 2; i++ {
+		typeURL, newVersion, _, _ := d.response()
+		pushed[typeURL] = newVersion
+	}
+	for _, typeURL := range []string{TypeCluster, TypeClusterLoadAssignment} {
+		if v, ok := pushed[typeURL]; !ok || v == version {
+			t.Errorf("%s not pushed with a new version: %v", typeURL, pushed)
+		}
+	}
+}
+
+// fixedScores scores every endpoint the same
+type fixedScores int
+
+func (f fixedScores) Score(namespace, name string) (int, bool) {
+	return int(f), true
+}
+
+func TestBuildSnapshotScores(t *testing.T) {
+	snap, err := BuildSnapshot(testServices(), nil, "nsm-xds", fixedScores(0))
+	if err != nil {
+		t.Fatalf("BuildSnapshot() error = %v", err)
+	}
+	lb := lbEndpoint(t, snap.Endpoints[ClusterName("edge", "camera-feed")])
+	if lb["load_balancing_weight"] != 1 {
+		t.Errorf("load_balancing_weight = %v, want the minimum of 1", lb["load_balancing_weight"])
+	}
+	nsm := lb["metadata"].(map[string]interface{})["filter_metadata"].(map[string]interface{})["nsm"].(map[string]interface{})
+	if nsm["score"] != 0 {
+		t.Errorf("score = %v, want 0", nsm["score"])
+	}
+}